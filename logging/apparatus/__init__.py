@@ -1,66 +1,289 @@
+import base64
 import json
+import math
+import os
+import shutil
+import socket
+import ssl
+import subprocess
+import threading
 import urllib.request
 import urllib.parse
 import time
-from datetime import datetime
+import warnings
+from datetime import datetime, timezone
+
+# The version of the server API this client is written against
+API_VERSION = 1
+
+
+def _warn_if_deprecated(response, action):
+    """Surface a server's deprecation of the API version or endpoint as a warning."""
+    if response.headers.get("Deprecation") is None:
+        return
+    message = f"The server has deprecated the API used to {action}"
+    sunset = response.headers.get("Sunset")
+    if sunset:
+        message += f"; it will be removed after {sunset}"
+    warnings.warn(message + ". Upgrade the apparatus client.", DeprecationWarning, stacklevel=4)
+
+
+# How many times a write is tried while the server refuses it as overloaded
+_OVERLOADED_ATTEMPTS = 8
+
+
+def _backoff_seconds(headers):
+    """The delay an overloaded server asks for before the next write, from its X-Apparatus-Backoff header."""
+    try:
+        return max(0, int((headers or {}).get("X-Apparatus-Backoff"))) / 1000
+    except (TypeError, ValueError):
+        return 0
 
 
 def http_request_response_json(req, action):
+    req.add_header("Accept", f"application/vnd.apparatus.v{API_VERSION}+json")
+    # Servers started with -require-auth need an API token on every request
+    api_token = os.environ.get("APPARATUS_API_TOKEN")
+    if api_token and not req.has_header("Authorization"):
+        req.add_header("Authorization", f"Bearer {api_token}")
+    for attempt in range(1, _OVERLOADED_ATTEMPTS + 1):
+        try:
+            with urllib.request.urlopen(req) as response:
+                _warn_if_deprecated(response, action)
+                data = json.loads(response.read().decode('utf-8'))
+            # The server is falling behind, and asks clients to slow down
+            time.sleep(_backoff_seconds(response.headers))
+            return data
+        except urllib.error.HTTPError as e:
+            # Quotas also respond 429, but without a backoff to retry after
+            backoff = _backoff_seconds(e.headers)
+            if e.code == 429 and backoff and attempt < _OVERLOADED_ATTEMPTS:
+                time.sleep(backoff)
+                continue
+            raise RuntimeError(f"Failed to {action}: HTTP {e.code} - {e.reason}\n{e.read()}")
+        except urllib.error.URLError as e:
+            raise RuntimeError(f"Failed to {action}: {e.reason}")
+
+
+def _request_arrow_table(req, action):
+    """Fetch an Arrow stream response as a pyarrow Table."""
+    try:
+        import pyarrow.ipc
+    except ImportError:
+        raise RuntimeError(f"Failed to {action}: pyarrow is required, install it with pip install pyarrow")
+    req.add_header("Accept", "application/vnd.apache.arrow.stream")
+    api_token = os.environ.get("APPARATUS_API_TOKEN")
+    if api_token and not req.has_header("Authorization"):
+        req.add_header("Authorization", f"Bearer {api_token}")
     try:
         with urllib.request.urlopen(req) as response:
-            data = json.loads(response.read().decode('utf-8'))
-            return data
+            _warn_if_deprecated(response, action)
+            return pyarrow.ipc.open_stream(response.read()).read_all()
     except urllib.error.HTTPError as e:
         raise RuntimeError(f"Failed to {action}: HTTP {e.code} - {e.reason}\n{e.read()}")
     except urllib.error.URLError as e:
         raise RuntimeError(f"Failed to {action}: {e.reason}")
 
 
-def create_run(name, experiment_uuid=None, parent_run_uuid=None, tracking_uri="http://localhost:8080"):
+def create_run(name, experiment_uuid=None, parent_run_uuid=None, user=None, git_commit=None,
+               git_branch=None, git_dirty=None, entrypoint=None, seed=None, seeds=None,
+               tracking_uri="http://localhost:8080"):
     """Create a new run and return its UUID.
 
     Args:
         name: The name of the run
         experiment_uuid: Optional UUID of the experiment to associate this run with
         parent_run_uuid: Optional UUID of the parent run (for nested runs, max 2 levels)
+        user: Optional name of the user running it
+        git_commit: Optional hash of the git commit it runs from
+        git_branch: Optional git branch it runs from
+        git_dirty: Optional bool, whether the working tree has uncommitted changes
+        entrypoint: Optional command or script it was started with, e.g. "train.py --lr 0.1"
+        seed: Optional global random seed it was started with
+        seeds: Optional dict of libraries seeded separately to their seeds, e.g. {"torch": 0}
         tracking_uri: The tracking server URI
     """
-    params = {"name": name}
+    payload = {"name": name}
     if experiment_uuid:
-        params["experiment_uuid"] = experiment_uuid
+        payload["experiment_uuid"] = experiment_uuid
     if parent_run_uuid:
-        params["parent_run_uuid"] = parent_run_uuid
+        payload["parent_run_uuid"] = parent_run_uuid
+    if user:
+        payload["user"] = user
+    if git_commit:
+        payload["git_commit"] = git_commit
+    if git_branch:
+        payload["git_branch"] = git_branch
+    if git_dirty is not None:
+        payload["git_dirty"] = bool(git_dirty)
+    if entrypoint:
+        payload["entrypoint"] = entrypoint
+    if seed is not None:
+        payload["seed"] = int(seed)
+    if seeds:
+        payload["seeds"] = {library: int(s) for library, s in seeds.items()}
 
-    url = f"{tracking_uri}/api/runs?{urllib.parse.urlencode(params)}"
+    url = f"{tracking_uri}/api/runs"
+    data = json.dumps(payload).encode('utf-8')
+
+    req = urllib.request.Request(url, data=data, method="POST")
+    req.add_header('Content-Type', 'application/json')
 
-    req = urllib.request.Request(url, method="POST")
     return http_request_response_json(req, "create run")["id"]
 
 
-def log_param(run_uuid, key, value, tracking_uri="http://localhost:8080"):
-    """Log a parameter for a run. Value can be str, bool, float, or int."""
-    # Detect type
+def finish_run(run_uuid, status="FINISHED", message=None, tracking_uri="http://localhost:8080"):
+    """Mark a run as ended.
+
+    Runs are RUNNING from creation. A run that logs no params or metrics for
+    longer than the server's heartbeat timeout is marked FAILED automatically.
+
+    Args:
+        run_uuid: The UUID of the run
+        status: One of "FINISHED", "FAILED", or "KILLED"
+        message: What the run ended with, e.g. traceback.format_exc() for a
+            failure. The experiment page groups failed runs by the error it names.
+        tracking_uri: The tracking server URI
+    """
+    payload = {
+        "run_uuid": run_uuid,
+        "status": status,
+    }
+    if message is not None:
+        payload["message"] = message
+
+    url = f"{tracking_uri}/api/runs/finish"
+    data = json.dumps(payload).encode('utf-8')
+
+    req = urllib.request.Request(url, data=data, method="POST")
+    req.add_header('Content-Type', 'application/json')
+
+    http_request_response_json(req, "finish run")
+
+
+def delete_run(run_uuid, notify_email=None, tracking_uri="http://localhost:8080"):
+    """Delete a run, its child runs, and everything logged to them.
+
+    Runs on hold cannot be deleted. If the server keeps deleted runs in the
+    trash for a while before purging them, they can be restored with
+    restore_run until then, and notify_email is sent a notice before they are
+    purged. Otherwise this cannot be undone.
+    """
+    url = f"{tracking_uri}/api/runs/{urllib.parse.quote(run_uuid)}"
+    if notify_email:
+        url += "?" + urllib.parse.urlencode({"notify": notify_email})
+
+    req = urllib.request.Request(url, method="DELETE")
+
+    http_request_response_json(req, "delete run")
+
+
+def archive_run(run_uuid, tracking_uri="http://localhost:8080"):
+    """Archive a run and its child runs.
+
+    Archived runs are hidden from the home page and run search unless asked
+    for, and may be deleted by the server's retention settings.
+    """
+    url = f"{tracking_uri}/api/runs/{urllib.parse.quote(run_uuid)}/archive"
+
+    req = urllib.request.Request(url, method="POST")
+
+    http_request_response_json(req, "archive run")
+
+
+def unarchive_run(run_uuid, tracking_uri="http://localhost:8080"):
+    """Restore an archived run and its child runs."""
+    url = f"{tracking_uri}/api/runs/{urllib.parse.quote(run_uuid)}/archive"
+
+    req = urllib.request.Request(url, method="DELETE")
+
+    http_request_response_json(req, "unarchive run")
+
+
+def list_trash(project=None, tracking_uri="http://localhost:8080"):
+    """List the deleted runs in the trash, the most recently deleted first.
+
+    Returns:
+        A list of dicts with each run's "uuid", "name", "experiment_uuid",
+        "deleted_at", "purge_at", the "child_run_uuids" deleted with it, and
+        whether it is "restorable"
+    """
+    url = f"{tracking_uri}/api/trash"
+    if project:
+        url += "?" + urllib.parse.urlencode({"project": project})
+
+    req = urllib.request.Request(url)
+
+    return http_request_response_json(req, "list trash")["runs"]
+
+
+def restore_run(run_uuid, tracking_uri="http://localhost:8080"):
+    """Restore a deleted run, with the child runs deleted with it, from the trash.
+
+    Returns:
+        How many runs were restored
+    """
+    payload = {"run_uuid": run_uuid}
+
+    url = f"{tracking_uri}/api/trash/restore"
+    data = json.dumps(payload).encode('utf-8')
+
+    req = urllib.request.Request(url, data=data, method="POST")
+    req.add_header('Content-Type', 'application/json')
+
+    return http_request_response_json(req, "restore run")["restored"]
+
+
+def _parameter_type(value):
+    """The type a parameter value is logged as."""
+    # bool is checked first since it is a subclass of int
     if isinstance(value, bool):
-        value_type = "bool"
-        value_str = "true" if value else "false"
+        return "bool"
     elif isinstance(value, int):
-        value_type = "int"
-        value_str = str(value)
+        return "int"
     elif isinstance(value, float):
-        value_type = "float"
-        value_str = str(value)
+        return "float"
     elif isinstance(value, str):
-        value_type = "string"
-        value_str = value
-    else:
-        raise TypeError(f"Unsupported parameter type: {type(value)}")
+        return "string"
+    raise TypeError(f"Unsupported parameter type: {type(value)}")
 
-    url = f"{tracking_uri}/api/params?run_uuid={urllib.parse.quote(run_uuid)}&key={urllib.parse.quote(key)}&value={urllib.parse.quote(value_str)}&type={value_type}"
 
-    req = urllib.request.Request(url, method="POST")
+def log_param(run_uuid, key, value, tracking_uri="http://localhost:8080"):
+    """Log a parameter for a run. Value can be str, bool, float, or int."""
+    payload = {"run_uuid": run_uuid, "key": key, "value": value, "type": _parameter_type(value)}
+
+    url = f"{tracking_uri}/api/params"
+    data = json.dumps(payload).encode('utf-8')
+
+    req = urllib.request.Request(url, data=data, method="POST")
+    req.add_header('Content-Type', 'application/json')
+
     http_request_response_json(req, "log parameter")
 
 
+def log_params(run_uuid, params, tracking_uri="http://localhost:8080"):
+    """Log several parameters for a run in one request, e.g. at the start of
+    training.
+
+    Args:
+        params: Dict of keys to values, each a str, bool, float, or int
+
+    Either all of the parameters are logged or, if any is invalid, none are.
+    """
+    payload = {
+        "run_uuid": run_uuid,
+        "params": [{"key": key, "value": value, "type": _parameter_type(value)} for key, value in params.items()],
+    }
+
+    url = f"{tracking_uri}/api/params/batch"
+    data = json.dumps(payload).encode('utf-8')
+
+    req = urllib.request.Request(url, data=data, method="POST")
+    req.add_header('Content-Type', 'application/json')
+
+    http_request_response_json(req, "log parameters")
+
+
 def log_metrics(run_uuid, key, x_values, y_values, logged_at_epoch_millis=None, tracking_uri="http://localhost:8080"):
     """Log a metric for a run.
 
@@ -71,6 +294,9 @@ def log_metrics(run_uuid, key, x_values, y_values, logged_at_epoch_millis=None,
         y_values: The y value of the metric (must be numeric)
         logged_at_epoch_millis: Timestamp in milliseconds since epoch (defaults to current time)
         tracking_uri: The tracking server URI
+
+    Warns if the metric is outside its experiment's metric schema, for example a
+    misspelled key or a step out of its declared range.
     """
     if logged_at_epoch_millis is None:
         logged_at_epoch_millis = int(time.time() * 1000)
@@ -97,59 +323,1631 @@ def log_metrics(run_uuid, key, x_values, y_values, logged_at_epoch_millis=None,
     req = urllib.request.Request(url, data=data, method="POST")
     req.add_header('Content-Type', 'application/json')
 
-    http_request_response_json(req, "log metric")
+    response = http_request_response_json(req, "log metric")
+    # Metrics outside the experiment's metric schema, or in the reserved
+    # system/ namespace, are recorded, but warned about
+    for message in response.get("warnings", []):
+        warnings.warn(message, stacklevel=2)
 
 
-def log_artifact(run_uuid, path, file_path, tracking_uri="http://localhost:8080"):
-    """Log an artifact (file) for a run.
+def log_system_metrics(run_uuid, samples, tracking_uri="http://localhost:8080"):
+    """Log a batch of system metric samples for a run, e.g. GPU utilization.
+
+    Args:
+        samples: List of ``(epoch_millis, values)`` pairs, where values is a
+            dict of keys to numbers. Keys are logged under the reserved
+            ``system/`` namespace, which is added if left out.
+
+    System metrics are shown on the run page's System tab rather than among
+    the run's own metrics. See start_system_metrics to collect them.
+    """
+    payload = {
+        "run_uuid": run_uuid,
+        "samples": [{"t": int(t), "values": values} for t, values in samples],
+    }
+
+    url = f"{tracking_uri}/api/system-metrics"
+    data = json.dumps(payload).encode('utf-8')
+
+    req = urllib.request.Request(url, data=data, method="POST")
+    req.add_header('Content-Type', 'application/json')
+
+    http_request_response_json(req, "log system metrics")
+
+
+def _sample_cpu_and_memory(previous_cpu_times):
+    """Sample CPU utilization and memory in use, with psutil if it is
+    installed and otherwise from /proc. Returns the values and the CPU times
+    to compute the next sample's utilization from."""
+    try:
+        import psutil
+    except ImportError:
+        psutil = None
+    if psutil is not None:
+        # psutil measures CPU utilization since it was last asked, so the
+        # first sample has none
+        cpu_percent = psutil.cpu_percent()
+        memory = psutil.virtual_memory()
+        values = {"memory_bytes": memory.total - memory.available}
+        if previous_cpu_times is not None:
+            values["cpu_percent"] = cpu_percent
+        return values, True
+
+    values = {}
+    try:
+        with open("/proc/stat") as f:
+            times = [int(v) for v in f.readline().split()[1:]]
+        idle, total = times[3] + times[4], sum(times)
+        if previous_cpu_times is not None and total > previous_cpu_times[1]:
+            busy = (total - previous_cpu_times[1]) - (idle - previous_cpu_times[0])
+            values["cpu_percent"] = 100 * busy / (total - previous_cpu_times[1])
+        previous_cpu_times = (idle, total)
+    except (OSError, ValueError, IndexError):
+        pass
+    try:
+        meminfo = {}
+        with open("/proc/meminfo") as f:
+            for line in f:
+                name, amount = line.split(":", 1)
+                meminfo[name] = int(amount.split()[0]) * 1024
+        values["memory_bytes"] = meminfo["MemTotal"] - meminfo["MemAvailable"]
+    except (OSError, ValueError, KeyError):
+        pass
+    return values, previous_cpu_times
+
+
+def _sample_gpus():
+    """Sample GPU utilization, averaged over the GPUs, and the total memory in
+    use, with nvidia-smi. Returns no values if there are no NVIDIA GPUs."""
+    if shutil.which("nvidia-smi") is None:
+        return {}
+    try:
+        output = subprocess.run(
+            ["nvidia-smi", "--query-gpu=utilization.gpu,memory.used", "--format=csv,noheader,nounits"],
+            capture_output=True, text=True, timeout=10, check=True,
+        ).stdout
+        gpus = [[float(v) for v in line.split(",")] for line in output.strip().splitlines() if line.strip()]
+    except (OSError, subprocess.SubprocessError, ValueError):
+        return {}
+    if not gpus:
+        return {}
+    return {
+        "gpu_utilization": sum(g[0] for g in gpus) / len(gpus),
+        # nvidia-smi reports memory in MiB
+        "gpu_memory_bytes": sum(g[1] for g in gpus) * 1024 * 1024,
+        "gpu_count": len(gpus),
+    }
+
+
+class SystemMetricsCollector:
+    """Samples CPU, memory and GPU metrics of the machine in a background
+    thread, and logs them for a run in batches. Use start_system_metrics to
+    create one."""
+
+    def __init__(self, run_uuid, interval=5, flush_interval=60, tracking_uri="http://localhost:8080"):
+        self.run_uuid = run_uuid
+        self.interval = interval
+        self.flush_interval = flush_interval
+        self.tracking_uri = tracking_uri
+        self._samples = []
+        self._cpu_times = None
+        self._stop = threading.Event()
+        self._thread = threading.Thread(target=self._run, name="apparatus-system-metrics", daemon=True)
+
+    def start(self):
+        self._thread.start()
+        return self
+
+    def sample(self):
+        """Take one sample now, to be logged with the next batch."""
+        values, self._cpu_times = _sample_cpu_and_memory(self._cpu_times)
+        values.update(_sample_gpus())
+        if values:
+            self._samples.append((int(time.time() * 1000), values))
+
+    def flush(self):
+        """Log the samples taken since the last batch. Samples that fail to
+        log are dropped with a warning rather than interrupting training."""
+        samples, self._samples = self._samples, []
+        if not samples:
+            return
+        try:
+            log_system_metrics(self.run_uuid, samples, tracking_uri=self.tracking_uri)
+        except Exception as e:
+            warnings.warn(f"Failed to log {len(samples)} system metric samples: {e}")
+
+    def _run(self):
+        last_flush = time.monotonic()
+        while not self._stop.wait(self.interval):
+            self.sample()
+            if time.monotonic() - last_flush >= self.flush_interval:
+                self.flush()
+                last_flush = time.monotonic()
+
+    def stop(self):
+        """Stop sampling and log the remaining samples."""
+        self._stop.set()
+        self._thread.join()
+        self.flush()
+
+    def __enter__(self):
+        return self
+
+    def __exit__(self, *exc):
+        self.stop()
+
+
+def start_system_metrics(run_uuid, interval=5, flush_interval=60, tracking_uri="http://localhost:8080"):
+    """Start collecting system metrics for a run in the background.
+
+    Samples CPU utilization and memory in use (with psutil if it is installed,
+    otherwise from /proc) and, if nvidia-smi is available, GPU utilization,
+    memory and count every ``interval`` seconds, and logs them in a batch
+    every ``flush_interval`` seconds. Call ``stop()`` on the returned
+    collector, or use it as a context manager, when the run finishes:
+
+        with start_system_metrics(run_uuid):
+            train()
+    """
+    return SystemMetricsCollector(run_uuid, interval, flush_interval, tracking_uri).start()
+
+
+class MetricStream:
+    """Streams metric points to the server over a WebSocket.
+
+    Points are sent in messages of ``batch_size`` points, and committed by
+    the server in batches, which it acknowledges as it goes. ``close()``
+    waits for every point to be acknowledged, and raises if any were
+    rejected.
+    """
+
+    def __init__(self, tracking_uri, batch_size=1000):
+        parsed = urllib.parse.urlparse(tracking_uri)
+        port = parsed.port or (443 if parsed.scheme == "https" else 80)
+        sock = socket.create_connection((parsed.hostname, port))
+        if parsed.scheme == "https":
+            sock = ssl.create_default_context().wrap_socket(sock, server_hostname=parsed.hostname)
+        lines = [
+            f"GET {parsed.path.rstrip('/')}/api/stream HTTP/1.1",
+            f"Host: {parsed.netloc}",
+            "Upgrade: websocket",
+            "Connection: Upgrade",
+            f"Sec-WebSocket-Key: {base64.b64encode(os.urandom(16)).decode()}",
+            "Sec-WebSocket-Version: 13",
+            f"Accept: application/vnd.apparatus.v{API_VERSION}+json",
+        ]
+        api_token = os.environ.get("APPARATUS_API_TOKEN")
+        if api_token:
+            lines.append(f"Authorization: Bearer {api_token}")
+        sock.sendall(("\r\n".join(lines) + "\r\n\r\n").encode())
+        self._file = sock.makefile("rb")
+        status = self._file.readline().decode().strip()
+        while self._file.readline() not in (b"\r\n", b""):
+            pass
+        if " 101 " not in f"{status} ":
+            sock.close()
+            raise RuntimeError(f"Failed to open metric stream: {status}")
+
+        self._sock = sock
+        self._send_lock = threading.Lock()
+        self._batch_size = batch_size
+        self._pending = []
+        self._seq = 0
+        # When the server last asked to wait until before sending more points
+        self._backoff_until = 0
+        self.acked = 0
+        self.rejected = []
+        self._reader = threading.Thread(target=self._read, daemon=True)
+        self._reader.start()
+
+    def log(self, run_uuid, key, x_value, y_value, logged_at_epoch_millis=None):
+        """Log a point of a metric, returning its sequence number."""
+        if logged_at_epoch_millis is None:
+            logged_at_epoch_millis = int(time.time() * 1000)
+        self._seq += 1
+        self._pending.append(json.dumps({
+            "seq": self._seq,
+            "run_uuid": run_uuid,
+            "key": key,
+            "x_value": x_value,
+            "y_value": y_value,
+            "logged_at_epoch_millis": logged_at_epoch_millis,
+        }))
+        if len(self._pending) >= self._batch_size:
+            self.flush()
+        return self._seq
+
+    def flush(self):
+        """Send the points logged since the last message."""
+        if self._pending:
+            wait = self._backoff_until - time.monotonic()
+            if wait > 0:
+                time.sleep(wait)
+            self._send(0x1, "\n".join(self._pending).encode('utf-8'))
+            self._pending = []
+
+    def close(self, timeout=30):
+        """Send the remaining points, and wait for the server to acknowledge them."""
+        self.flush()
+        self._send(0x8, (1000).to_bytes(2, "big"))
+        self._reader.join(timeout)
+        self._sock.close()
+        if self.rejected:
+            raise RuntimeError(f"The server rejected metric points: {self.rejected}")
+        if self.acked < self._seq:
+            raise RuntimeError(f"The server acknowledged metric points up to {self.acked} of {self._seq}")
+
+    def _send(self, opcode, payload):
+        # Client frames are masked, as the WebSocket protocol requires
+        n = len(payload)
+        header = bytes([0x80 | opcode])
+        if n < 126:
+            header += bytes([0x80 | n])
+        elif n < 1 << 16:
+            header += bytes([0x80 | 126]) + n.to_bytes(2, "big")
+        else:
+            header += bytes([0x80 | 127]) + n.to_bytes(8, "big")
+        mask = os.urandom(4)
+        masked = (int.from_bytes(payload, "big") ^ int.from_bytes((mask * (n // 4 + 1))[:n], "big")).to_bytes(n, "big")
+        with self._send_lock:
+            self._sock.sendall(header + mask + masked)
+
+    def _read(self):
+        while True:
+            header = self._file.read(2)
+            if len(header) < 2:
+                return
+            opcode, n = header[0] & 0x0F, header[1] & 0x7F
+            if n == 126:
+                n = int.from_bytes(self._file.read(2), "big")
+            elif n == 127:
+                n = int.from_bytes(self._file.read(8), "big")
+            payload = self._file.read(n)
+            if opcode == 0x8:
+                return
+            if opcode == 0x9:
+                self._send(0xA, payload)
+            if opcode != 0x1:
+                continue
+            reply = json.loads(payload)
+            if reply["type"] == "ack":
+                self.acked = reply["seq"]
+                if reply.get("backoff_ms"):
+                    self._backoff_until = time.monotonic() + reply["backoff_ms"] / 1000
+            elif reply["type"] == "error":
+                self.rejected.append(reply)
+            elif reply["type"] == "warning":
+                for message in reply.get("warnings", []):
+                    warnings.warn(message)
+
+    def __enter__(self):
+        return self
+
+    def __exit__(self, *exc):
+        self.close()
+
+
+def open_metric_stream(tracking_uri="http://localhost:8080", batch_size=1000):
+    """Open a stream for logging metric points faster than log_metrics can.
+
+    Each point is committed within a fraction of a second of its batch
+    arriving. Use the stream as a context manager, or call ``close()`` when
+    done logging:
+
+        with open_metric_stream() as stream:
+            for step in range(steps):
+                stream.log(run_uuid, "loss", step, loss)
+    """
+    return MetricStream(tracking_uri, batch_size)
+
+
+def log_confusion_matrix(run_uuid, key, labels, matrix, step=None, tracking_uri="http://localhost:8080"):
+    """Log a confusion matrix for a run, rendered as a heatmap on the run page.
 
     Args:
         run_uuid: The UUID of the run
-        path: Logical path for the artifact (e.g., "model.pkl", "plots/accuracy.png")
-        file_path: Local filesystem path to the file to upload
+        key: The name of the confusion matrix, e.g. "val/confusion"
+        labels: The class names, in the order of the matrix's rows and columns
+        matrix: Counts indexed [true label][predicted label], e.g. the output of
+            sklearn.metrics.confusion_matrix
+        step: Optional step the matrix was computed at; logging again at the
+            same step replaces it
         tracking_uri: The tracking server URI
     """
-    import os
-    from urllib.request import Request, urlopen
-    from urllib.error import HTTPError
+    if hasattr(matrix, "tolist"):
+        matrix = matrix.tolist()
 
-    if not os.path.exists(file_path):
-        raise FileNotFoundError(f"File not found: {file_path}")
+    payload = {
+        "run_uuid": run_uuid,
+        "key": key,
+        "labels": [str(label) for label in labels],
+        "matrix": [list(row) for row in matrix],
+    }
+    if step is not None:
+        payload["step"] = step
 
-    # Prepare multipart form data with a simple boundary string
-    boundary = "----ApparatusBoundary7MA4YWxkTrZu0gW"
+    url = f"{tracking_uri}/api/confusion_matrices"
+    data = json.dumps(payload).encode('utf-8')
 
-    # Read file content
-    with open(file_path, "rb") as f:
-        file_content = f.read()
+    req = urllib.request.Request(url, data=data, method="POST")
+    req.add_header('Content-Type', 'application/json')
 
-    # Build multipart body
-    body_parts = []
+    http_request_response_json(req, "log confusion matrix")
 
-    # Add run_uuid field
-    body_parts.append(f"--{boundary}\r\n".encode())
-    body_parts.append(b'Content-Disposition: form-data; name="run_uuid"\r\n\r\n')
-    body_parts.append(run_uuid.encode())
 
-    # Add path field
-    body_parts.append(f"\r\n--{boundary}\r\n".encode())
-    body_parts.append(b'Content-Disposition: form-data; name="path"\r\n\r\n')
-    body_parts.append(path.encode())
+def log_text_samples(run_uuid, key, samples, step=None, tracking_uri="http://localhost:8080"):
+    """Log generated text samples for a run, viewable by step on the run page.
 
-    # Add file field
-    filename = os.path.basename(file_path)
-    body_parts.append(f"\r\n--{boundary}\r\n".encode())
-    body_parts.append(f'Content-Disposition: form-data; name="file"; filename="{filename}"\r\n'.encode())
-    body_parts.append(b'Content-Type: application/octet-stream\r\n\r\n')
-    body_parts.append(file_content)
+    Args:
+        run_uuid: The UUID of the run
+        key: The name of the samples, e.g. "generations/prompt_1"
+        samples: A list of generated strings, or a single string
+        step: Optional step the samples were generated at; logging again at
+            the same step replaces them
+        tracking_uri: The tracking server URI
+    """
+    if isinstance(samples, str):
+        samples = [samples]
 
-    # Final boundary
-    body_parts.append(f"\r\n--{boundary}--\r\n".encode())
+    payload = {
+        "run_uuid": run_uuid,
+        "key": key,
+        "samples": [str(sample) for sample in samples],
+    }
+    if step is not None:
+        payload["step"] = step
 
-    body = b"".join(body_parts)
+    url = f"{tracking_uri}/api/text_samples"
+    data = json.dumps(payload).encode('utf-8')
 
-    url = f"{tracking_uri}/api/artifacts"
-    req = Request(url, data=body, method="POST")
-    req.add_header("Content-Type", f"multipart/form-data; boundary={boundary}")
+    req = urllib.request.Request(url, data=data, method="POST")
+    req.add_header('Content-Type', 'application/json')
+
+    http_request_response_json(req, "log text samples")
+
+
+def log_embeddings(run_uuid, key, vectors, labels=None, tracking_uri="http://localhost:8080"):
+    """Log a matrix of embeddings for a run, explorable in the run's projector.
+
+    The projector plots the embeddings in two dimensions with PCA or UMAP,
+    colored by label. At most 2000 vectors of up to 2048 dimensions are
+    supported.
+
+    Args:
+        run_uuid: The UUID of the run
+        key: The name of the embeddings, e.g. "val/penultimate"
+        vectors: The embeddings, one row per point, e.g. a numpy array
+        labels: Optional labels, one per row, used to color the points
+        tracking_uri: The tracking server URI
+    """
+    if hasattr(vectors, "tolist"):
+        vectors = vectors.tolist()
+
+    payload = {
+        "run_uuid": run_uuid,
+        "key": key,
+        "vectors": [list(row) for row in vectors],
+    }
+    if labels is not None:
+        payload["labels"] = [str(label) for label in labels]
+
+    url = f"{tracking_uri}/api/embeddings"
+    data = json.dumps(payload).encode('utf-8')
+
+    req = urllib.request.Request(url, data=data, method="POST")
+    req.add_header('Content-Type', 'application/json')
+
+    http_request_response_json(req, "log embeddings")
+
+
+def _log_curve(run_uuid, key, kind, coordinates, thresholds, step, tracking_uri):
+    payload = {
+        "run_uuid": run_uuid,
+        "key": key,
+        "kind": kind,
+    }
+    for name, values in coordinates.items():
+        payload[name] = [float(v) for v in values]
+    if thresholds is not None:
+        # Thresholds that JSON can't represent, like sklearn's leading inf, are sent as null
+        payload["thresholds"] = [float(t) if math.isfinite(t) else None for t in thresholds]
+    if step is not None:
+        payload["step"] = step
+
+    url = f"{tracking_uri}/api/curves"
+    data = json.dumps(payload).encode('utf-8')
+
+    req = urllib.request.Request(url, data=data, method="POST")
+    req.add_header('Content-Type', 'application/json')
+
+    return http_request_response_json(req, f"log {kind} curve")
+
+
+def log_roc_curve(run_uuid, key, fpr, tpr, thresholds=None, step=None, tracking_uri="http://localhost:8080"):
+    """Log a ROC curve for a run. The server computes its AUC.
+
+    The arguments match the output of sklearn.metrics.roc_curve.
+
+    Args:
+        run_uuid: The UUID of the run
+        key: The name of the curve, e.g. "val/roc"
+        fpr: False positive rates, in [0, 1]
+        tpr: True positive rates, in [0, 1]
+        thresholds: Optional decision thresholds, one per point
+        step: Optional step the curve was computed at; logging again at the
+            same step replaces it
+        tracking_uri: The tracking server URI
+
+    Returns:
+        The area under the curve
+    """
+    response = _log_curve(run_uuid, key, "roc", {"fpr": fpr, "tpr": tpr}, thresholds, step, tracking_uri)
+    return response["auc"]
+
+
+def log_pr_curve(run_uuid, key, precision, recall, thresholds=None, step=None, tracking_uri="http://localhost:8080"):
+    """Log a precision-recall curve for a run. The server computes its AUC.
+
+    The arguments match the output of sklearn.metrics.precision_recall_curve,
+    except that thresholds may have one entry per point; sklearn's, which are
+    one shorter, are padded with a missing threshold at the end.
+
+    Args:
+        run_uuid: The UUID of the run
+        key: The name of the curve, e.g. "val/pr"
+        precision: Precision values, in [0, 1]
+        recall: Recall values, in [0, 1]
+        thresholds: Optional decision thresholds
+        step: Optional step the curve was computed at; logging again at the
+            same step replaces it
+        tracking_uri: The tracking server URI
+
+    Returns:
+        The area under the curve
+    """
+    if thresholds is not None and len(thresholds) == len(precision) - 1:
+        thresholds = list(thresholds) + [math.inf]
+    response = _log_curve(run_uuid, key, "pr", {"precision": precision, "recall": recall}, thresholds, step, tracking_uri)
+    return response["auc"]
+
+
+def log_annotation(run_uuid, step, text, tracking_uri="http://localhost:8080"):
+    """Attach a text annotation to a run at a given step.
+
+    Annotations are rendered as vertical markers across all of the run's metric charts.
+
+    Args:
+        run_uuid: The UUID of the run
+        step: The x value (step) at which to place the annotation
+        text: The annotation text, e.g. "lr dropped to 1e-4"
+        tracking_uri: The tracking server URI
+    """
+    payload = {
+        "run_uuid": run_uuid,
+        "step": step,
+        "text": text,
+    }
+
+    url = f"{tracking_uri}/api/annotations"
+    data = json.dumps(payload).encode('utf-8')
+
+    req = urllib.request.Request(url, data=data, method="POST")
+    req.add_header('Content-Type', 'application/json')
+
+    http_request_response_json(req, "log annotation")
+
+
+def set_run_hold(run_uuid, reason, admin_token, tracking_uri="http://localhost:8080"):
+    """Place a run on hold, exempting it from retention, GC, and deletion.
+
+    Requires the server's admin token.
+
+    Args:
+        run_uuid: The UUID of the run
+        reason: Why the run is held, e.g. "cited in NeurIPS 2026 submission"
+        admin_token: The admin token the server was started with
+        tracking_uri: The tracking server URI
+    """
+    payload = {
+        "run_uuid": run_uuid,
+        "reason": reason,
+    }
+
+    url = f"{tracking_uri}/api/runs/hold"
+    data = json.dumps(payload).encode('utf-8')
+
+    req = urllib.request.Request(url, data=data, method="POST")
+    req.add_header('Content-Type', 'application/json')
+    req.add_header('Authorization', f"Bearer {admin_token}")
+
+    http_request_response_json(req, "set run hold")
+
+
+def release_run_hold(run_uuid, admin_token, tracking_uri="http://localhost:8080"):
+    """Release the hold on a run. Requires the server's admin token."""
+    url = f"{tracking_uri}/api/runs/hold?run_uuid={urllib.parse.quote(run_uuid)}"
+
+    req = urllib.request.Request(url, method="DELETE")
+    req.add_header('Authorization', f"Bearer {admin_token}")
+
+    http_request_response_json(req, "release run hold")
+
+
+def dry_run_housekeeping(job, admin_token, tracking_uri="http://localhost:8080"):
+    """Report what a housekeeping job, e.g. "artifact-gc", would delete.
+
+    Nothing is deleted. Requires the server's admin token.
+
+    Returns:
+        A tuple (report_id, report). Pass report_id to execute_housekeeping
+        once the report has been reviewed.
+    """
+    return _post_housekeeping({"job": job, "dry_run": True}, admin_token, tracking_uri)
+
+
+def execute_housekeeping(job, plan_id, admin_token, tracking_uri="http://localhost:8080"):
+    """Delete what a reviewed dry run of a housekeeping job reported.
+
+    Only items the dry run listed, and that are still eligible, are deleted.
+    Requires the server's admin token.
+
+    Returns:
+        A tuple (report_id, report) of what was deleted and skipped
+    """
+    return _post_housekeeping({"job": job, "plan_id": plan_id}, admin_token, tracking_uri)
+
+
+def _post_housekeeping(payload, admin_token, tracking_uri):
+    url = f"{tracking_uri}/api/admin/housekeeping"
+    data = json.dumps(payload).encode('utf-8')
+
+    req = urllib.request.Request(url, data=data, method="POST")
+    req.add_header('Content-Type', 'application/json')
+    req.add_header('Authorization', f"Bearer {admin_token}")
+
+    response = http_request_response_json(req, "run housekeeping job")
+    return response["report_id"], response["report"]
+
+
+def merge_runs(source_run_uuid, target_run_uuid, admin_token, prefer=None, tracking_uri="http://localhost:8080"):
+    """Merge a run into another, e.g. a restarted job's run into the original.
+
+    The source run's parameters, metric points and artifacts move to the
+    target run, and the source run is then deleted. Where both runs logged a
+    metric at the same step, the later point is kept. The merge is recorded in
+    the server's audit log. Requires the server's admin token.
+
+    Args:
+        source_run_uuid: The UUID of the run to merge, which is deleted
+        target_run_uuid: The UUID of the run to merge it into
+        admin_token: The admin token the server was started with
+        prefer: How to resolve parameters and artifacts both runs have with
+            different values: "target" keeps the target's, "source" takes the
+            source's. By default the merge is refused if there are any.
+        tracking_uri: The tracking server URI
+
+    Returns:
+        What the merge did, including the conflicts it resolved
+    """
+    payload = {"source_run_uuid": source_run_uuid, "target_run_uuid": target_run_uuid}
+    if prefer is not None:
+        payload["prefer"] = prefer
+
+    url = f"{tracking_uri}/api/admin/runs/merge"
+    data = json.dumps(payload).encode('utf-8')
+
+    req = urllib.request.Request(url, data=data, method="POST")
+    req.add_header('Content-Type', 'application/json')
+    req.add_header('Authorization', f"Bearer {admin_token}")
+
+    return http_request_response_json(req, "merge runs")
+
+
+def get_audit_log(admin_token, tracking_uri="http://localhost:8080"):
+    """List the most recent audit log entries, newest first.
+
+    Requires the server's admin token.
+    """
+    url = f"{tracking_uri}/api/admin/audit-log"
+
+    req = urllib.request.Request(url)
+    req.add_header('Authorization', f"Bearer {admin_token}")
+
+    return http_request_response_json(req, "get audit log")["entries"]
+
+
+def set_tag(run_uuid, key, value="", tracking_uri="http://localhost:8080"):
+    """Tag a run, replacing the tag's value if it is already set.
+
+    Tags show on the run page and filter the run list on the home page, e.g.
+    ``team=vision``.
+
+    Args:
+        run_uuid: The UUID of the run
+        key: The tag key: letters, digits, and any of ``_ . - / :``
+        value: Optional tag value
+        tracking_uri: The tracking server URI
+    """
+    payload = {"run_uuid": run_uuid, "key": key, "value": str(value)}
+
+    url = f"{tracking_uri}/api/tags"
+    data = json.dumps(payload).encode('utf-8')
+
+    req = urllib.request.Request(url, data=data, method="POST")
+    req.add_header('Content-Type', 'application/json')
+
+    http_request_response_json(req, "set tag")
+
+
+def get_tags(run_uuid, tracking_uri="http://localhost:8080"):
+    """Return a run's tags as a dict of key to value."""
+    url = f"{tracking_uri}/api/tags?run_uuid={urllib.parse.quote(run_uuid)}"
+
+    req = urllib.request.Request(url, method="GET")
+
+    return http_request_response_json(req, "get tags")["tags"]
+
+
+def delete_tag(run_uuid, key, tracking_uri="http://localhost:8080"):
+    """Remove a tag from a run."""
+    query = urllib.parse.urlencode({"run_uuid": run_uuid, "key": key})
+    url = f"{tracking_uri}/api/tags?{query}"
+
+    req = urllib.request.Request(url, method="DELETE")
+
+    http_request_response_json(req, "delete tag")
+
+
+def log_environment(run_uuid, include=None, exclude=None, tracking_uri="http://localhost:8080"):
+    """Log a snapshot of this process's environment variables for a run.
+
+    The server redacts the values of variables that look like secrets, such
+    as ``*_TOKEN`` or AWS access keys, before storing them, and they are shown
+    redacted on the run page. Logging the environment again replaces the
+    snapshot.
+
+    Args:
+        run_uuid: The UUID of the run
+        include: Optional glob patterns of variable names to log, e.g. ["CUDA_*", "SLURM_*"];
+            all variables are logged by default
+        exclude: Optional glob patterns of variable names not to log
+        tracking_uri: The tracking server URI
+
+    Returns:
+        The names of the variables the server redacted.
+    """
+    from fnmatch import fnmatchcase
+
+    variables = {
+        key: value for key, value in os.environ.items()
+        if (include is None or any(fnmatchcase(key, p) for p in include))
+        and not any(fnmatchcase(key, p) for p in exclude or [])
+    }
+    payload = {"run_uuid": run_uuid, "variables": variables}
+
+    url = f"{tracking_uri}/api/runs/environment"
+    data = json.dumps(payload).encode('utf-8')
+
+    req = urllib.request.Request(url, data=data, method="POST")
+    req.add_header('Content-Type', 'application/json')
+
+    return http_request_response_json(req, "log environment")["redacted"]
+
+
+def log_seeds(run_uuid, seed=None, seeds=None, tracking_uri="http://localhost:8080"):
+    """Record the random seeds a run was started with, replacing any recorded before.
+
+    A run that records a seed, is created from a clean git commit and logs
+    its environment is marked reproducible.
+
+    Args:
+        run_uuid: The UUID of the run
+        seed: The global random seed
+        seeds: A dict of libraries seeded separately to their seeds, e.g.
+            {"numpy": 0, "torch": 0}
+        tracking_uri: The tracking server URI
+    """
+    payload = {"run_uuid": run_uuid, "seeds": {library: int(s) for library, s in (seeds or {}).items()}}
+    if seed is not None:
+        payload["seed"] = int(seed)
+
+    url = f"{tracking_uri}/api/runs/seeds"
+    data = json.dumps(payload).encode('utf-8')
+
+    req = urllib.request.Request(url, data=data, method="POST")
+    req.add_header('Content-Type', 'application/json')
+
+    http_request_response_json(req, "log seeds")
+
+
+def log_run_dependency(run_uuid, upstream_run_uuid, kind="artifact", artifact_path=None, tracking_uri="http://localhost:8080"):
+    """Record that a run consumed the output of another run.
+
+    Dependencies are separate from parent/child nesting and are shown in the
+    run's lineage graph.
+
+    Args:
+        run_uuid: The UUID of the consuming run
+        upstream_run_uuid: The UUID of the run whose output was consumed
+        kind: One of "artifact", "checkpoint", "dataset", or "evaluation"
+        artifact_path: Optional path of the upstream run's artifact that was used
+        tracking_uri: The tracking server URI
+    """
+    payload = {
+        "run_uuid": run_uuid,
+        "upstream_run_uuid": upstream_run_uuid,
+        "kind": kind,
+    }
+    if artifact_path is not None:
+        payload["artifact_path"] = artifact_path
+
+    url = f"{tracking_uri}/api/runs/dependencies"
+    data = json.dumps(payload).encode('utf-8')
+
+    req = urllib.request.Request(url, data=data, method="POST")
+    req.add_header('Content-Type', 'application/json')
+
+    http_request_response_json(req, "log run dependency")
+
+
+def save_run_template(run_uuid, name, prompt_keys=None, tracking_uri="http://localhost:8080"):
+    """Save a run's parameters as a named template.
+
+    Args:
+        run_uuid: The UUID of the run whose parameters to save
+        name: The template name
+        prompt_keys: Optional list of parameter keys that may be overridden when
+            creating runs from the template
+        tracking_uri: The tracking server URI
+    """
+    payload = {
+        "name": name,
+        "run_uuid": run_uuid,
+        "prompt_keys": list(prompt_keys or []),
+    }
+
+    url = f"{tracking_uri}/api/templates"
+    data = json.dumps(payload).encode('utf-8')
+
+    req = urllib.request.Request(url, data=data, method="POST")
+    req.add_header('Content-Type', 'application/json')
+
+    http_request_response_json(req, "save run template")
+
+
+def create_run_from_template(template, name=None, overrides=None, tracking_uri="http://localhost:8080"):
+    """Create a new run from a template and return its UUID.
+
+    The run is created in the template's experiment with the template's parameters.
+
+    Args:
+        template: The template name
+        name: Optional run name (defaults to the template name)
+        overrides: Optional dict of values for the template's prompted parameters
+        tracking_uri: The tracking server URI
+    """
+    payload = {"template": template}
+    if name:
+        payload["name"] = name
+    if overrides:
+        payload["overrides"] = overrides
+
+    url = f"{tracking_uri}/api/templates/runs"
+    data = json.dumps(payload).encode('utf-8')
+
+    req = urllib.request.Request(url, data=data, method="POST")
+    req.add_header('Content-Type', 'application/json')
+
+    return http_request_response_json(req, "create run from template")["id"]
+
+
+def fork_run(run_uuid, name=None, parameters=None, tags=False, notes=False, tracking_uri="http://localhost:8080"):
+    """Create a new run with another run's parameters and return its UUID.
+
+    The fork is created in the run's experiment, under the run's parent, and
+    records the run it was forked from.
+
+    Args:
+        run_uuid: The UUID of the run to fork
+        name: Optional name for the fork (defaults to the run's name with " (fork)")
+        parameters: Optional dict of parameters to override or add. Values
+            are converted to the types of the parameters they replace.
+        tags: Whether to copy the run's tags
+        notes: Whether to copy the run's notes
+        tracking_uri: The tracking server URI
+    """
+    payload = {"tags": tags, "notes": notes}
+    if name:
+        payload["name"] = name
+    if parameters:
+        payload["parameters"] = parameters
+
+    url = f"{tracking_uri}/api/runs/{run_uuid}/fork"
+    data = json.dumps(payload).encode('utf-8')
+
+    req = urllib.request.Request(url, data=data, method="POST")
+    req.add_header('Content-Type', 'application/json')
+
+    return http_request_response_json(req, "fork run")["id"]
+
+
+def log_artifact(run_uuid, path, file_path, tracking_uri="http://localhost:8080"):
+    """Log an artifact (file) for a run.
+
+    Args:
+        run_uuid: The UUID of the run
+        path: Logical path for the artifact (e.g., "model.pkl", "plots/accuracy.png")
+        file_path: Local filesystem path to the file to upload
+        tracking_uri: The tracking server URI
+
+    Returns the server's response, including the artifact's version and, if
+    the server scans uploads for malware, its scan_status. Quarantined
+    artifacts cannot be downloaded until an admin releases them.
+
+    Files larger than 64MB are uploaded in chunks with log_artifact_chunked.
+    """
+    import os
+    from urllib.request import Request, urlopen
+    from urllib.error import HTTPError
+
+    if not os.path.exists(file_path):
+        raise FileNotFoundError(f"File not found: {file_path}")
+    if os.path.getsize(file_path) > _CHUNKED_UPLOAD_THRESHOLD:
+        return log_artifact_chunked(run_uuid, path, file_path, tracking_uri=tracking_uri)
+
+    # Prepare multipart form data with a simple boundary string
+    boundary = "----ApparatusBoundary7MA4YWxkTrZu0gW"
+
+    # Read file content
+    with open(file_path, "rb") as f:
+        file_content = f.read()
+
+    # Build multipart body
+    body_parts = []
+
+    # Add run_uuid field
+    body_parts.append(f"--{boundary}\r\n".encode())
+    body_parts.append(b'Content-Disposition: form-data; name="run_uuid"\r\n\r\n')
+    body_parts.append(run_uuid.encode())
+
+    # Add path field
+    body_parts.append(f"\r\n--{boundary}\r\n".encode())
+    body_parts.append(b'Content-Disposition: form-data; name="path"\r\n\r\n')
+    body_parts.append(path.encode())
+
+    # Add file field
+    filename = os.path.basename(file_path)
+    body_parts.append(f"\r\n--{boundary}\r\n".encode())
+    body_parts.append(f'Content-Disposition: form-data; name="file"; filename="{filename}"\r\n'.encode())
+    body_parts.append(b'Content-Type: application/octet-stream\r\n\r\n')
+    body_parts.append(file_content)
+
+    # Final boundary
+    body_parts.append(f"\r\n--{boundary}--\r\n".encode())
+
+    body = b"".join(body_parts)
+
+    url = f"{tracking_uri}/api/artifacts"
+    req = Request(url, data=body, method="POST")
+    req.add_header("Content-Type", f"multipart/form-data; boundary={boundary}")
+
+    response = http_request_response_json(req, "log artifact")
+    if response.get("scan_status") == "quarantined":
+        warnings.warn(f"Artifact {path} was quarantined by the server's malware scanner", stacklevel=2)
+    return response
+
+
+# Files larger than this are uploaded in chunks
+_CHUNKED_UPLOAD_THRESHOLD = 64 << 20
+# Attempts at sending a chunk before giving up
+_UPLOAD_ATTEMPTS = 5
+
+
+def log_artifact_chunked(run_uuid, path, file_path, chunk_size=None, tracking_uri="http://localhost:8080"):
+    """Log an artifact by uploading it in chunks, for large files such as model checkpoints.
+
+    Chunks that fail to send are retried, and an interrupted upload resumes
+    from the chunks the server already received when called again with the
+    same file, for up to a day. The server verifies the assembled file
+    against its SHA-256.
+
+    Args:
+        run_uuid: The UUID of the run
+        path: Logical path for the artifact (e.g., "checkpoints/model.pt")
+        file_path: Local filesystem path to the file to upload
+        chunk_size: Size of each chunk in bytes, between 1MB and 256MB;
+            defaults to the server's choice
+        tracking_uri: The tracking server URI
+
+    Returns the server's response, as for log_artifact.
+    """
+    import hashlib
+
+    if not os.path.exists(file_path):
+        raise FileNotFoundError(f"File not found: {file_path}")
+
+    size = os.path.getsize(file_path)
+    digest = hashlib.sha256()
+    with open(file_path, "rb") as f:
+        while block := f.read(_DOWNLOAD_CHUNK_BYTES):
+            digest.update(block)
+
+    initiate = {"run_uuid": run_uuid, "path": path, "size_bytes": size, "sha256": digest.hexdigest()}
+    if chunk_size is not None:
+        initiate["chunk_size"] = chunk_size
+    req = urllib.request.Request(f"{tracking_uri}/api/artifacts/initiate", data=json.dumps(initiate).encode(), method="POST")
+    req.add_header("Content-Type", "application/json")
+    upload = http_request_response_json(req, "initiate artifact upload")
+
+    upload_id = upload["upload_id"]
+    received = set(upload["received_chunks"])
+    with open(file_path, "rb") as f:
+        for index in range(upload["chunk_count"]):
+            if index in received:
+                continue
+            f.seek(index * upload["chunk_size"])
+            chunk = f.read(upload["chunk_size"])
+            query = urllib.parse.urlencode({"upload_id": upload_id, "index": index})
+            for attempt in range(1, _UPLOAD_ATTEMPTS + 1):
+                req = urllib.request.Request(f"{tracking_uri}/api/artifacts/chunk?{query}", data=chunk, method="PUT")
+                req.add_header("Content-Type", "application/octet-stream")
+                req.add_header("X-Apparatus-Chunk-SHA256", hashlib.sha256(chunk).hexdigest())
+                try:
+                    http_request_response_json(req, f"upload chunk {index} of artifact {path}")
+                    break
+                except RuntimeError:
+                    if attempt == _UPLOAD_ATTEMPTS:
+                        raise RuntimeError(
+                            f"Failed to upload chunk {index} of artifact {path} after {_UPLOAD_ATTEMPTS} attempts; "
+                            f"rerun to resume the upload")
+                    time.sleep(2 ** attempt)
+
+    req = urllib.request.Request(f"{tracking_uri}/api/artifacts/complete",
+                                 data=json.dumps({"upload_id": upload_id}).encode(), method="POST")
+    req.add_header("Content-Type", "application/json")
+    response = http_request_response_json(req, "complete artifact upload")
+    if response.get("scan_status") == "quarantined":
+        warnings.warn(f"Artifact {path} was quarantined by the server's malware scanner", stacklevel=2)
+    return response
+
+
+# Download attempts before giving up, resuming where the last one stopped
+_DOWNLOAD_ATTEMPTS = 5
+_DOWNLOAD_CHUNK_BYTES = 1 << 20
+
+
+def get_artifact(run_uuid, path, tracking_uri="http://localhost:8080"):
+    """Return an artifact's metadata: uri, type, size_bytes, sha256, download_url, and scan_status."""
+    query = urllib.parse.urlencode({"run_uuid": run_uuid, "path": path})
+    url = f"{tracking_uri}/api/artifacts?{query}"
+
+    req = urllib.request.Request(url, method="GET")
+
+    return http_request_response_json(req, "get artifact")
+
+
+def get_artifact_versions(run_uuid, path, tracking_uri="http://localhost:8080"):
+    """Return every version of an artifact, newest first.
+
+    Logging an artifact at a path it was already logged at makes a new version
+    of it, and the prior versions are kept. Each version is a dict with its
+    version number, whether it is current, uri, size_bytes, sha256,
+    uploaded_at, and download_url.
+    """
+    query = urllib.parse.urlencode({"path": path, "versions": "true"})
+    url = f"{tracking_uri}/api/runs/{urllib.parse.quote(run_uuid)}/artifacts?{query}"
+
+    req = urllib.request.Request(url, method="GET")
+
+    return http_request_response_json(req, "get artifact versions")["artifacts"]
+
+
+def download_artifact(run_uuid, path, dest_path=None, tracking_uri="http://localhost:8080"):
+    """Download an artifact, verifying it against the SHA-256 the server recorded on upload.
+
+    The download is written to ``dest_path + ".part"`` and renamed into place
+    once verified. An interrupted download resumes from the partial file,
+    including across calls. A download that does not match the recorded
+    digest is deleted and raises an error.
+
+    Args:
+        run_uuid: The UUID of the run
+        path: Logical path of the artifact (e.g., "model.pkl")
+        dest_path: Local path to save to; defaults to the artifact's file name
+        tracking_uri: The tracking server URI
+
+    Returns:
+        The path the artifact was saved to.
+    """
+    import hashlib
+
+    artifact = get_artifact(run_uuid, path, tracking_uri=tracking_uri)
+    if dest_path is None:
+        dest_path = os.path.basename(path)
+    part_path = dest_path + ".part"
+    size = artifact["size_bytes"]
+    url = tracking_uri + artifact["download_url"]
+
+    for attempt in range(1, _DOWNLOAD_ATTEMPTS + 1):
+        offset = os.path.getsize(part_path) if os.path.exists(part_path) else 0
+        if offset > size:
+            os.remove(part_path)
+            offset = 0
+        if offset == size and os.path.exists(part_path):
+            break
+
+        req = urllib.request.Request(url, method="GET")
+        if offset > 0:
+            req.add_header("Range", f"bytes={offset}-")
+        try:
+            with urllib.request.urlopen(req) as response:
+                # A server that ignores the range sends the whole artifact
+                mode = "ab" if response.status == 206 else "wb"
+                with open(part_path, mode) as f:
+                    while chunk := response.read(_DOWNLOAD_CHUNK_BYTES):
+                        f.write(chunk)
+        except urllib.error.HTTPError as e:
+            if e.code == 416:
+                os.remove(part_path)
+            elif e.code == 503 and attempt < _DOWNLOAD_ATTEMPTS:
+                # The server is saturated with downloads
+                retry_after = e.headers.get("Retry-After", "1")
+                time.sleep(float(retry_after) if retry_after.isdigit() else 1)
+            else:
+                raise RuntimeError(f"Failed to download artifact {path}: HTTP {e.code} - {e.reason}")
+        except (urllib.error.URLError, ConnectionError, TimeoutError) as e:
+            if attempt == _DOWNLOAD_ATTEMPTS:
+                raise RuntimeError(f"Failed to download artifact {path}: {e}; rerun to resume from {part_path}")
+    else:
+        if not os.path.exists(part_path) or os.path.getsize(part_path) != size:
+            raise RuntimeError(f"Failed to download artifact {path} after {_DOWNLOAD_ATTEMPTS} attempts; rerun to resume from {part_path}")
+
+    expected = artifact.get("sha256")
+    if expected:
+        digest = hashlib.sha256()
+        with open(part_path, "rb") as f:
+            while chunk := f.read(_DOWNLOAD_CHUNK_BYTES):
+                digest.update(chunk)
+        if digest.hexdigest() != expected:
+            os.remove(part_path)
+            raise RuntimeError(
+                f"Downloaded artifact {path} is corrupt: SHA-256 {digest.hexdigest()} does not match "
+                f"the recorded {expected}; the partial download was deleted")
+    else:
+        warnings.warn(f"Artifact {path} has no recorded SHA-256, so the download could not be verified", stacklevel=2)
+
+    os.replace(part_path, dest_path)
+    return dest_path
+
+
+def download_artifacts_archive(run_uuid, dest_path=None, paths=None, tracking_uri="http://localhost:8080"):
+    """Download a zip of a run's artifacts, or of only those selected by paths.
+
+    The archive is built as it is streamed, so it cannot be resumed; it is
+    written to ``dest_path + ".part"`` and renamed into place once complete.
+
+    Args:
+        run_uuid: The UUID of the run
+        dest_path: Local path to save to; defaults to ``{run_uuid}-artifacts.zip``
+        paths: Optional artifact paths and directories to download, e.g.
+            ``["config.yaml", "checkpoints/"]``; defaults to everything
+        tracking_uri: The tracking server URI
+
+    Returns:
+        The path the archive was saved to.
+    """
+    import zipfile
+
+    if dest_path is None:
+        dest_path = f"{run_uuid}-artifacts.zip"
+    part_path = dest_path + ".part"
+    url = f"{tracking_uri}/api/runs/{urllib.parse.quote(run_uuid)}/artifacts/archive"
+
+    if paths:
+        # Selections are posted, since they may be too long for a URL
+        data = urllib.parse.urlencode([("paths[]", p) for p in paths]).encode('utf-8')
+        req = urllib.request.Request(url, data=data, method="POST")
+        req.add_header('Content-Type', 'application/x-www-form-urlencoded')
+    else:
+        req = urllib.request.Request(url, method="GET")
+    try:
+        with urllib.request.urlopen(req) as response, open(part_path, "wb") as f:
+            while chunk := response.read(_DOWNLOAD_CHUNK_BYTES):
+                f.write(chunk)
+    except urllib.error.HTTPError as e:
+        raise RuntimeError(f"Failed to download artifacts archive: HTTP {e.code} - {e.reason}")
+
+    # An archive cut short by a server error has no central directory
+    if not zipfile.is_zipfile(part_path):
+        os.remove(part_path)
+        raise RuntimeError("Downloaded artifacts archive is incomplete; the partial download was deleted")
+    os.replace(part_path, dest_path)
+    return dest_path
+
+
+def get_best_checkpoint(run_uuid, tracking_uri="http://localhost:8080"):
+    """Return a run's best checkpoint, or None if it has none.
+
+    The checkpoint is a dict with the artifact's path, uri, size_bytes, sha256,
+    and download_url, how it was designated (``source`` is "rule" or
+    "manual"), and for rule designations the metric_key and metric_value it
+    was chosen by. Fetch it with ``download_artifact(run_uuid, checkpoint["path"])``.
+    """
+    url = f"{tracking_uri}/api/v1/runs/{urllib.parse.quote(run_uuid)}"
+
+    req = urllib.request.Request(url, method="GET")
+
+    return http_request_response_json(req, "get best checkpoint")["best_checkpoint"]
+
+
+def set_best_checkpoint(run_uuid, path, tracking_uri="http://localhost:8080"):
+    """Designate an artifact as a run's best checkpoint by hand.
+
+    A checkpoint chosen by hand is never replaced by the experiment's best
+    checkpoint rule, until it is cleared with ``clear_best_checkpoint``.
+    """
+    payload = {"run_uuid": run_uuid, "path": path}
+
+    url = f"{tracking_uri}/api/runs/best-checkpoint"
+    data = json.dumps(payload).encode('utf-8')
+
+    req = urllib.request.Request(url, data=data, method="POST")
+    req.add_header('Content-Type', 'application/json')
+
+    http_request_response_json(req, "set best checkpoint")
+
+
+def clear_best_checkpoint(run_uuid, tracking_uri="http://localhost:8080"):
+    """Remove a run's best checkpoint designation."""
+    url = f"{tracking_uri}/api/runs/best-checkpoint?run_uuid={urllib.parse.quote(run_uuid)}"
+
+    req = urllib.request.Request(url, method="DELETE")
+
+    http_request_response_json(req, "clear best checkpoint")
+
+
+def set_best_checkpoint_rule(experiment_uuid, metric, mode, artifacts, tracking_uri="http://localhost:8080"):
+    """Choose the best checkpoint of each run in an experiment automatically.
+
+    Whenever an artifact matching ``artifacts`` is logged, it becomes the run's
+    best checkpoint if the run's latest ``metric`` is the best so far.
+
+    Args:
+        experiment_uuid: The UUID of the experiment
+        metric: The metric key checkpoints are judged by, e.g. "val_loss"
+        mode: "min" or "max"
+        artifacts: Glob of the artifact paths that are checkpoints, e.g. "checkpoints/*.pt"
+        tracking_uri: The tracking server URI
+    """
+    payload = {"experiment_uuid": experiment_uuid, "metric": metric, "mode": mode, "artifacts": artifacts}
+
+    url = f"{tracking_uri}/api/experiments/best-checkpoint-rule"
+    data = json.dumps(payload).encode('utf-8')
+
+    req = urllib.request.Request(url, data=data, method="POST")
+    req.add_header('Content-Type', 'application/json')
+
+    http_request_response_json(req, "set best checkpoint rule")
+
+
+def archive_experiment(experiment_uuid, purge=False, tracking_uri="http://localhost:8080"):
+    """Archive an experiment into a single bundle in the artifact store.
+
+    The bundle is a zip of the experiment's config, its runs with their
+    parameters and tags, a metrics.parquet of every metric point, and the runs'
+    artifacts. If ``purge`` is set, the runs are then deleted; nothing is
+    archived if any of them is on hold or still running.
+
+    Returns the archive, with the ``download_url`` of the bundle.
+    """
+    payload = {"experiment_uuid": experiment_uuid, "purge": purge}
+
+    url = f"{tracking_uri}/api/experiments/archive"
+    data = json.dumps(payload).encode('utf-8')
+
+    req = urllib.request.Request(url, data=data, method="POST")
+    req.add_header('Content-Type', 'application/json')
+
+    return http_request_response_json(req, "archive experiment")["archive"]
+
+
+def list_experiment_archives(experiment_uuid, tracking_uri="http://localhost:8080"):
+    """List an experiment's archives, newest first."""
+    url = f"{tracking_uri}/api/experiments/archive?experiment_uuid={urllib.parse.quote(experiment_uuid)}"
+
+    req = urllib.request.Request(url)
+
+    return http_request_response_json(req, "list experiment archives")["archives"]
+
+
+def get_meta(experiment_uuid=None, tracking_uri="http://localhost:8080"):
+    """Describe the experiments and the keys logged to their runs.
+
+    Returns a dict with ``experiments``, ``parameters`` (each key with the
+    types it was logged as), ``metrics`` and ``tags``, each with the number of
+    runs that logged it. With ``experiment_uuid``, only keys logged to that
+    experiment's runs are described.
+    """
+    url = f"{tracking_uri}/api/v1/meta"
+    if experiment_uuid is not None:
+        url += f"?experiment_uuid={urllib.parse.quote(experiment_uuid)}"
+
+    req = urllib.request.Request(url)
+
+    return http_request_response_json(req, "get meta")
+
+
+def get_metric_series(run_uuid, key, max_points=None, tracking_uri="http://localhost:8080"):
+    """Load a run's metric series as a pyarrow Table with x, y and logged_at columns.
+
+    The whole series is loaded unless ``max_points`` is given, in which case
+    it is downsampled keeping each stretch's extremes. Convert the table with
+    ``.to_pandas()`` or ``polars.from_arrow()``. Requires pyarrow.
+    """
+    url = f"{tracking_uri}/api/v1/runs/{urllib.parse.quote(run_uuid)}/metrics/{urllib.parse.quote(key)}"
+    if max_points is not None:
+        url += f"?max_points={int(max_points)}"
+
+    req = urllib.request.Request(url)
+
+    return _request_arrow_table(req, "get metric series")
+
+
+def search_runs(query, tracking_uri="http://localhost:8080"):
+    """Search run names, notes and annotations, returning a pyarrow Table of the matching runs.
+
+    The table has uuid, name, created_at, experiment_uuid, experiment_name and
+    snippet columns, newest run first. Requires pyarrow.
+    """
+    url = f"{tracking_uri}/api/v1/search?q={urllib.parse.quote(query)}"
+
+    req = urllib.request.Request(url)
+
+    return _request_arrow_table(req, "search runs")
+
+
+def query_runs(query="", limit=50, offset=0, created_within=None, created_after=None, created_before=None,
+               reproducible=False, tracking_uri="http://localhost:8080"):
+    """List the runs matching a run query, newest first.
+
+    Queries compare run fields, parameters, latest metric values and tags,
+    e.g. ``params.lr > 0.001 AND metrics.loss < 0.2 AND name ~ "resnet"``.
+    ``created_within`` (e.g. ``"24h"`` or ``"7d"``), ``created_after`` and
+    ``created_before`` (datetimes, naive ones in UTC, dates or RFC 3339
+    strings; the end is exclusive) limit the runs to those created in a range,
+    and ``reproducible`` to reproducible runs. Returns a list of dicts with
+    uuid, name, status, created_at, experiment_uuid and experiment_name.
+    """
+    params = {"q": query, "limit": limit, "offset": offset}
+    if reproducible:
+        params["reproducible"] = "true"
+    if created_within is not None:
+        params["created_within"] = created_within
+    for name, bound in (("created_after", created_after), ("created_before", created_before)):
+        if bound is not None:
+            if isinstance(bound, datetime) and bound.tzinfo is None:
+                bound = bound.replace(tzinfo=timezone.utc)
+            params[name] = bound if isinstance(bound, str) else bound.isoformat()
+    params = urllib.parse.urlencode(params)
+    url = f"{tracking_uri}/api/v1/runs/search?{params}"
+
+    req = urllib.request.Request(url)
+
+    return http_request_response_json(req, "query runs")["runs"]
+
+
+def set_run_notes(run_uuid, notes, tracking_uri="http://localhost:8080"):
+    """Replace the notes of a run. Notes are Markdown, rendered on the run page."""
+    url = f"{tracking_uri}/api/v1/runs/{urllib.parse.quote(run_uuid)}/notes"
+    data = json.dumps({"notes": notes}).encode('utf-8')
+
+    req = urllib.request.Request(url, data=data, method="PUT")
+    req.add_header('Content-Type', 'application/json')
+
+    http_request_response_json(req, "set run notes")
+
+
+def follow_metric(run_uuid, key, after_step=None, wait=30, tracking_uri="http://localhost:8080"):
+    """Yield ``(x, y)`` points of a metric series as they are logged.
+
+    Starts after ``after_step``, or at the beginning of the series, and asks
+    the server to hold each request up to ``wait`` seconds for new points, so
+    that a caught-up series is not polled in a busy loop. Runs until the
+    caller stops iterating.
+    """
+    url = f"{tracking_uri}/api/v1/runs/{urllib.parse.quote(run_uuid)}/metrics/{urllib.parse.quote(key)}/tail"
+    caught_up = False
+    while True:
+        params = {"wait": wait if caught_up else 0}
+        if after_step is not None:
+            params["after_step"] = after_step
+        req = urllib.request.Request(f"{url}?{urllib.parse.urlencode(params)}")
+        tail = http_request_response_json(req, "follow metric")
+        yield from zip(tail["x"], tail["y"])
+        if tail["next_after_step"] is not None:
+            after_step = tail["next_after_step"]
+        caught_up = not tail["has_more"]
+
+
+def get_best_metric(run_uuid, key, mode, tracking_uri="http://localhost:8080"):
+    """Get the best point of a metric series, e.g. to pick the epoch whose checkpoint to deploy.
+
+    Args:
+        mode: "min" for the lowest value, or "max" for the highest. Ties go to the earliest step.
+
+    Returns:
+        A dict with the "step", "value" and "logged_at" of the best point
+    """
+    params = urllib.parse.urlencode({"mode": mode})
+    url = f"{tracking_uri}/api/v1/runs/{urllib.parse.quote(run_uuid)}/metrics/{urllib.parse.quote(key)}/best?{params}"
+
+    req = urllib.request.Request(url)
+
+    return http_request_response_json(req, "get best metric")
+
+
+def list_metric_keys(run_uuid, prefix="", tracking_uri="http://localhost:8080"):
+    """List a run's metric keys grouped by namespace, the part of a key up to a "/".
+
+    Args:
+        prefix: Only list keys starting with it, grouped by the next "/" after it,
+            e.g. "train/" to group "train/layer1/grad" under "train/layer1/"
+
+    Returns:
+        A list of dicts with the "prefix", "count" and "keys" of each group
+    """
+    params = urllib.parse.urlencode({"prefix": prefix})
+    url = f"{tracking_uri}/api/v1/runs/{urllib.parse.quote(run_uuid)}/metrics?{params}"
+
+    req = urllib.request.Request(url)
+
+    return http_request_response_json(req, "list metric keys")["groups"]
+
+
+def list_metric_namespaces(run_uuid, tracking_uri="http://localhost:8080"):
+    """List the namespaces of a run's metrics, the part of a key before its first "/".
+
+    Returns:
+        A list of dicts with the "namespace", how many "metrics" and "points" it
+        has, and the "setting" its experiment has for it, if any. Metrics
+        without a "/" are listed under the namespace "".
+    """
+    url = f"{tracking_uri}/api/v1/runs/{urllib.parse.quote(run_uuid)}/metrics/namespaces"
+
+    req = urllib.request.Request(url)
+
+    return http_request_response_json(req, "list metric namespaces")["namespaces"]
+
+
+def get_metric_summaries(run_uuid, namespace=None, tracking_uri="http://localhost:8080"):
+    """Get the last, lowest and highest values of a run's metrics.
+
+    Args:
+        namespace: Only get the metrics of this namespace, e.g. "train", or
+            "" for the metrics without one; if None, every metric
+
+    Returns:
+        A list of dicts with each metric's "key", "namespace", "name",
+        "last_step", "last_value", "min_value", "max_value" and "points"
+    """
+    url = f"{tracking_uri}/api/v1/runs/{urllib.parse.quote(run_uuid)}/metrics/summaries"
+    if namespace is not None:
+        url += "?" + urllib.parse.urlencode({"namespace": namespace})
+
+    req = urllib.request.Request(url)
+
+    return http_request_response_json(req, "get metric summaries")["metrics"]
+
+
+def set_metric_namespace(experiment_uuid, namespace, retain_points_days=None, alert_above=None,
+                         alert_below=None, tracking_uri="http://localhost:8080"):
+    """Set how an experiment treats the metrics of a namespace, replacing what was set before.
+
+    Args:
+        namespace: The part of the metric keys before their first "/", e.g. "debug"
+        retain_points_days: Days after a run ends that the points of its metrics
+            in the namespace are kept, by the metric-namespaces housekeeping job;
+            their last, lowest and highest values are kept regardless
+        alert_above, alert_below: Values that notify subscribers of the
+            metric_alert event the first time one of a run's metrics in the
+            namespace crosses them
+    """
+    payload = {"experiment_uuid": experiment_uuid, "namespace": namespace}
+    if retain_points_days is not None:
+        payload["retain_points_days"] = retain_points_days
+    if alert_above is not None:
+        payload["alert_above"] = alert_above
+    if alert_below is not None:
+        payload["alert_below"] = alert_below
+
+    url = f"{tracking_uri}/api/experiments/metric-namespaces"
+    data = json.dumps(payload).encode('utf-8')
+
+    req = urllib.request.Request(url, data=data, method="POST")
+    req.add_header('Content-Type', 'application/json')
+
+    http_request_response_json(req, "set metric namespace")
+
+
+def delete_metric_namespace(experiment_uuid, namespace, tracking_uri="http://localhost:8080"):
+    """Remove what an experiment set for a metric namespace with set_metric_namespace."""
+    params = urllib.parse.urlencode({"experiment_uuid": experiment_uuid, "namespace": namespace})
+    url = f"{tracking_uri}/api/experiments/metric-namespaces?{params}"
+
+    req = urllib.request.Request(url, method="DELETE")
+
+    http_request_response_json(req, "delete metric namespace")
+
+
+def get_metric_chart(run_uuid, key, dest_path=None, format="png", width=None, height=None,
+                     tracking_uri="http://localhost:8080"):
+    """Render a chart of a metric series on the server, e.g. for a report.
+
+    Args:
+        format: "png" or "svg"
+        width, height: Size of the chart in pixels; defaults to 1200x630
+        dest_path: Local path to save the chart to; if None, it is returned
+
+    Returns:
+        The path the chart was saved to, or its bytes if dest_path is None.
+    """
+    params = {"format": format}
+    if width is not None:
+        params["width"] = width
+    if height is not None:
+        params["height"] = height
+    url = (f"{tracking_uri}/api/v1/runs/{urllib.parse.quote(run_uuid)}/metrics/{urllib.parse.quote(key)}/chart?"
+           + urllib.parse.urlencode(params))
+
+    req = urllib.request.Request(url)
+    req.add_header("Accept", f"application/vnd.apparatus.v{API_VERSION}+json")
+    api_token = os.environ.get("APPARATUS_API_TOKEN")
+    if api_token:
+        req.add_header("Authorization", f"Bearer {api_token}")
+    try:
+        with urllib.request.urlopen(req) as response:
+            _warn_if_deprecated(response, "get metric chart")
+            data = response.read()
+    except urllib.error.HTTPError as e:
+        raise RuntimeError(f"Failed to get metric chart: HTTP {e.code} - {e.reason}\n{e.read()}")
+    except urllib.error.URLError as e:
+        raise RuntimeError(f"Failed to get metric chart: {e.reason}")
+
+    if dest_path is None:
+        return data
+    with open(dest_path, "wb") as f:
+        f.write(data)
+    return dest_path
+
+
+def get_experiment_quota(experiment_uuid, admin_token, tracking_uri="http://localhost:8080"):
+    """Get an experiment's quota and its usage. Requires the server's admin token.
+
+    Returns:
+        A dict with the limits in effect under "quota", whether they override
+        the server's defaults under "overridden", and the experiment's usage,
+        with metric points counted for today (UTC), under "usage". A limit of
+        0 is unlimited.
+    """
+    url = f"{tracking_uri}/api/admin/quotas?experiment_uuid={urllib.parse.quote(experiment_uuid)}"
+
+    req = urllib.request.Request(url)
+    req.add_header('Authorization', f"Bearer {admin_token}")
+
+    return http_request_response_json(req, "get experiment quota")
+
+
+def set_experiment_quota(experiment_uuid, admin_token, runs=None, metric_points_per_day=None,
+                         artifact_bytes=None, tracking_uri="http://localhost:8080"):
+    """Override the server's default quotas for an experiment.
+
+    Limits left as None keep the server's defaults, and 0 is unlimited. The
+    change is recorded in the server's audit log. Requires the server's admin
+    token.
+
+    Returns:
+        The experiment's quota and usage, as from get_experiment_quota
+    """
+    payload = {
+        "experiment_uuid": experiment_uuid,
+        "runs": runs,
+        "metric_points_per_day": metric_points_per_day,
+        "artifact_bytes": artifact_bytes,
+    }
+
+    url = f"{tracking_uri}/api/admin/quotas"
+    data = json.dumps(payload).encode('utf-8')
+
+    req = urllib.request.Request(url, data=data, method="PUT")
+    req.add_header('Content-Type', 'application/json')
+    req.add_header('Authorization', f"Bearer {admin_token}")
+
+    return http_request_response_json(req, "set experiment quota")
+
+
+def clear_experiment_quota(experiment_uuid, admin_token, tracking_uri="http://localhost:8080"):
+    """Restore the server's default quotas for an experiment. Requires the server's admin token."""
+    url = f"{tracking_uri}/api/admin/quotas?experiment_uuid={urllib.parse.quote(experiment_uuid)}"
+
+    req = urllib.request.Request(url, method="DELETE")
+    req.add_header('Authorization', f"Bearer {admin_token}")
+
+    return http_request_response_json(req, "clear experiment quota")
+
+
+def import_runs_csv(file_path, experiment_uuid=None, columns=None, dry_run=False, tracking_uri="http://localhost:8080"):
+    """Import a CSV of past results as finished runs, one per row.
+
+    Args:
+        file_path: Local path to the CSV, which must have a header row
+        experiment_uuid: The experiment to import into (the default experiment if None)
+        columns: A list of {"column", "as", "key"} dicts mapping columns to
+            "name", "param", "metric" (a final value), "date" or "ignore".
+            Columns left out are ignored. If None, the server guesses.
+        dry_run: Return the runs that would be created without creating them
+        tracking_uri: The tracking server URI
+
+    Returns:
+        The column mapping used, the planned runs, and unless dry_run the
+        UUIDs of the created runs under "run_uuids"
+    """
+    boundary = "----ApparatusBoundary7MA4YWxkTrZu0gW"
+    with open(file_path, "rb") as f:
+        file_content = f.read()
+
+    fields = {"dry_run": "true" if dry_run else "false"}
+    if experiment_uuid is not None:
+        fields["experiment_uuid"] = experiment_uuid
+    if columns is not None:
+        fields["columns"] = json.dumps(columns)
+
+    body_parts = []
+    for name, value in fields.items():
+        body_parts.append(f"--{boundary}\r\n".encode())
+        body_parts.append(f'Content-Disposition: form-data; name="{name}"\r\n\r\n'.encode())
+        body_parts.append(value.encode())
+        body_parts.append(b"\r\n")
+    filename = os.path.basename(file_path)
+    body_parts.append(f"--{boundary}\r\n".encode())
+    body_parts.append(f'Content-Disposition: form-data; name="file"; filename="{filename}"\r\n'.encode())
+    body_parts.append(b'Content-Type: text/csv\r\n\r\n')
+    body_parts.append(file_content)
+    body_parts.append(f"\r\n--{boundary}--\r\n".encode())
+
+    req = urllib.request.Request(f"{tracking_uri}/api/experiments/import", data=b"".join(body_parts), method="POST")
+    req.add_header("Content-Type", f"multipart/form-data; boundary={boundary}")
+
+    return http_request_response_json(req, "import runs")
+
+
+def import_runs_jsonl(runs, experiment_uuid=None, source=None, dry_run=False, tracking_uri="http://localhost:8080"):
+    """Import finished runs in bulk from run documents, e.g. those a sweep planner generates.
+
+    Args:
+        runs: An iterable of run documents, each a dict or a line of JSON, with
+            "name", "parameters" (a dict of keys to values), "metrics" (a dict
+            of final values) and "date", all optional
+        experiment_uuid: The experiment to import into (the default experiment if None)
+        source: What the runs' imported_from tag records (default "jsonl")
+        dry_run: Return the runs that would be created without creating them
+        tracking_uri: The tracking server URI
+
+    Returns:
+        The planned runs, and unless dry_run the UUIDs of the created runs
+        under "run_uuids"
+    """
+    lines = []
+    for run in runs:
+        lines.append(run.rstrip("\r\n") if isinstance(run, str) else json.dumps(run))
+
+    query = {"dry_run": "true" if dry_run else "false"}
+    if experiment_uuid is not None:
+        query["experiment_uuid"] = experiment_uuid
+    if source is not None:
+        query["source"] = source
+
+    url = f"{tracking_uri}/api/experiments/import/jsonl?{urllib.parse.urlencode(query)}"
+    req = urllib.request.Request(url, data="\n".join(lines).encode(), method="POST")
+    req.add_header("Content-Type", "application/x-ndjson")
 
-    http_request_response_json(req, "log artifact")
+    return http_request_response_json(req, "import runs")
@@ -0,0 +1,72 @@
+"""Command line interface to an apparatus tracking server.
+
+Usage:
+    python -m apparatus download RUN_UUID PATH [-o DEST] [--tracking-uri URI]
+    python -m apparatus runs import --format jsonl FILE|- [--experiment UUID] [--dry-run]
+"""
+import argparse
+import json
+import os
+import sys
+
+import apparatus
+
+
+def main(argv=None):
+    parser = argparse.ArgumentParser(prog="python -m apparatus")
+    parser.add_argument("--tracking-uri", default="http://localhost:8080", help="The tracking server URI")
+    commands = parser.add_subparsers(dest="command", required=True)
+
+    download = commands.add_parser(
+        "download",
+        help="Download an artifact, verifying its SHA-256 and resuming a partial download")
+    download.add_argument("run_uuid", help="The UUID of the run")
+    download.add_argument("path", help="Logical path of the artifact, e.g. model.pkl")
+    download.add_argument("-o", "--output", help="Local path to save to (default: the artifact's file name)")
+
+    runs = commands.add_parser("runs", help="Manage runs")
+    runs_commands = runs.add_subparsers(dest="runs_command", required=True)
+    run_import = runs_commands.add_parser(
+        "import",
+        help="Import finished runs in bulk from run documents, one JSON object per line")
+    run_import.add_argument("file", help="The file to read, or - for stdin")
+    run_import.add_argument("--format", choices=["jsonl"], default="jsonl", help="The format of the file")
+    run_import.add_argument("--experiment", help="UUID of the experiment to import into (default: the default experiment)")
+    run_import.add_argument("--dry-run", action="store_true", help="Print the runs that would be created without creating them")
+
+    args = parser.parse_args(argv)
+    if args.command == "download":
+        try:
+            dest = apparatus.download_artifact(args.run_uuid, args.path, args.output, tracking_uri=args.tracking_uri)
+        except RuntimeError as e:
+            print(f"error: {e}", file=sys.stderr)
+            return 1
+        print(dest)
+    elif args.command == "runs" and args.runs_command == "import":
+        return import_runs(args)
+    return 0
+
+
+def import_runs(args):
+    if args.file == "-":
+        lines, source = sys.stdin.readlines(), "stdin"
+    else:
+        with open(args.file) as f:
+            lines, source = f.readlines(), os.path.basename(args.file)
+    try:
+        result = apparatus.import_runs_jsonl(lines, experiment_uuid=args.experiment, source=source,
+                                             dry_run=args.dry_run, tracking_uri=args.tracking_uri)
+    except RuntimeError as e:
+        print(f"error: {e}", file=sys.stderr)
+        return 1
+    if args.dry_run:
+        for run in result["runs"]:
+            print(json.dumps(run))
+    else:
+        for run_uuid in result["run_uuids"]:
+            print(run_uuid)
+    return 0
+
+
+if __name__ == "__main__":
+    sys.exit(main())
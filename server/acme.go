@@ -0,0 +1,54 @@
+package main
+
+import (
+	"crypto/tls"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// With -acme-domains, the server's TLS certificate is obtained and renewed
+// by autocert from an ACME certificate authority such as Let's Encrypt, and
+// cached in -acme-cache-dir across restarts. Control of the server's domains
+// is proven with the tls-alpn-01 challenge, answered on the server's own TLS
+// listener, so no other port needs to be reachable; the certificate
+// authority connects to port 443 of each domain.
+
+// letsEncryptDirectory is the default certificate authority
+const letsEncryptDirectory = acme.LetsEncryptURL
+
+// parseACMEDomains splits a comma-separated list of domains
+func parseACMEDomains(list string) []string {
+	var domains []string
+	for _, d := range strings.Split(list, ",") {
+		if d = strings.ToLower(strings.TrimSpace(d)); d != "" {
+			domains = append(domains, d)
+		}
+	}
+	return domains
+}
+
+// newACMEManager creates a manager that obtains certificates for domains,
+// and only for them, from the certificate authority at directoryURL
+func newACMEManager(directoryURL, email string, domains []string, cacheDir string) (*autocert.Manager, error) {
+	if err := os.MkdirAll(cacheDir, 0o700); err != nil {
+		return nil, err
+	}
+	return &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(domains...),
+		Cache:      autocert.DirCache(cacheDir),
+		Email:      email,
+		Client:     &acme.Client{DirectoryURL: directoryURL},
+	}, nil
+}
+
+// acmeTLSConfig is the server's TLS configuration with certificates from m,
+// served over HTTP/2 or HTTP/1.1
+func acmeTLSConfig(m *autocert.Manager) *tls.Config {
+	config := m.TLSConfig()
+	config.MinVersion = tls.VersionTLS12
+	return config
+}
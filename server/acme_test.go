@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"golang.org/x/crypto/acme"
+)
+
+func TestParseACMEDomains(t *testing.T) {
+	if got := parseACMEDomains(" Apparatus.example.com, ,www.apparatus.example.com "); !slices.Equal(got, []string{"apparatus.example.com", "www.apparatus.example.com"}) {
+		t.Errorf("Expected the domains trimmed and lowercased, got %v", got)
+	}
+	if got := parseACMEDomains(""); len(got) != 0 {
+		t.Errorf("Expected no domains, got %v", got)
+	}
+}
+
+func TestACMEManager(t *testing.T) {
+	cacheDir := filepath.Join(t.TempDir(), "acme")
+	m, err := newACMEManager("https://ca.example.com/directory", "ops@example.com", []string{"apparatus.example.com"}, cacheDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Client.DirectoryURL != "https://ca.example.com/directory" || m.Email != "ops@example.com" {
+		t.Errorf("Expected the manager to use the configured certificate authority, got %s %s", m.Client.DirectoryURL, m.Email)
+	}
+	if err := m.HostPolicy(context.Background(), "apparatus.example.com"); err != nil {
+		t.Errorf("Expected a certificate allowed for the configured domain, got %v", err)
+	}
+	if err := m.HostPolicy(context.Background(), "other.example.com"); err == nil {
+		t.Error("Expected no certificate for other domains")
+	}
+
+	config := acmeTLSConfig(m)
+	if !slices.Contains(config.NextProtos, "h2") || !slices.Contains(config.NextProtos, acme.ALPNProto) {
+		t.Errorf("Expected HTTP/2 and tls-alpn-01 challenges negotiated, got %v", config.NextProtos)
+	}
+	if config.MinVersion != tls.VersionTLS12 {
+		t.Errorf("Expected TLS 1.2 or later, got %x", config.MinVersion)
+	}
+}
+
+func TestServerURL(t *testing.T) {
+	none, domains := "", "apparatus.example.com"
+	tests := []struct {
+		addr    string
+		domains *string
+		https   bool
+		want    string
+	}{
+		{":8080", &none, false, "http://localhost:8080"},
+		{"127.0.0.1:8443", &none, true, "https://127.0.0.1:8443"},
+		{"[::1]:80", &none, false, "http://[::1]"},
+		{":443", &domains, true, "https://apparatus.example.com"},
+	}
+	for _, tt := range tests {
+		if got := serverURL(tt.addr, serverFlags{acmeDomains: tt.domains}, tt.https); got != tt.want {
+			t.Errorf("serverURL(%q) = %q, want %q", tt.addr, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// adminToken authorizes administrative operations such as placing runs on
+// hold, as do tokens of admin users. Admin operations are disabled when it is
+// empty.
+var adminToken string
+
+// requireAdmin checks that the request carries the admin token, or an admin
+// user's token, as a bearer token, writing an error response and returning
+// false if it does not
+func requireAdmin(w http.ResponseWriter, r *http.Request) bool {
+	// Journaled requests were authorized when first served; the journal does
+	// not keep their tokens
+	if isJournalReplay(r.Context()) {
+		return true
+	}
+	if hasAdminRole(r) {
+		return true
+	}
+	if adminToken == "" && !requireAuth {
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Admin operations are disabled; start the server with -admin-token"})
+		return false
+	}
+
+	w.WriteHeader(http.StatusUnauthorized)
+	json.NewEncoder(w).Encode(map[string]string{"error": "Admin token required"})
+	return false
+}
+
+// hasAdminRole reports whether the request carries the admin token as a
+// bearer token, or was authorized as an admin user
+func hasAdminRole(r *http.Request) bool {
+	if p, ok := requestPrincipal(r); ok && p.Role == roleAdmin {
+		return true
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && adminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) == 1
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequireAdmin(t *testing.T) {
+	defer func(token string) { adminToken = token }(adminToken)
+
+	tests := []struct {
+		name          string
+		configured    string
+		authorization string
+		wantOK        bool
+		wantStatus    int
+	}{
+		{"disabled without a configured token", "", "Bearer secret", false, http.StatusForbidden},
+		{"missing header", "secret", "", false, http.StatusUnauthorized},
+		{"wrong token", "secret", "Bearer nope", false, http.StatusUnauthorized},
+		{"not a bearer token", "secret", "secret", false, http.StatusUnauthorized},
+		{"correct token", "secret", "Bearer secret", true, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			adminToken = tt.configured
+			r := httptest.NewRequest(http.MethodPost, "/api/runs/hold", nil)
+			if tt.authorization != "" {
+				r.Header.Set("Authorization", tt.authorization)
+			}
+			w := httptest.NewRecorder()
+
+			if ok := requireAdmin(w, r); ok != tt.wantOK {
+				t.Errorf("requireAdmin() = %v, want %v", ok, tt.wantOK)
+			}
+			if w.Code != tt.wantStatus {
+				t.Errorf("requireAdmin() wrote status %d, want %d", w.Code, tt.wantStatus)
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// Annotation is a text note attached to a run at a given step, e.g.
+// "lr dropped to 1e-4", rendered as a vertical marker on the run's charts.
+type Annotation struct {
+	Step string
+	Text string
+}
+
+// CreateAnnotationRequest is the body of POST /api/annotations
+type CreateAnnotationRequest struct {
+	RunUUID string   `json:"run_uuid"`
+	Step    *float64 `json:"step,omitempty"`
+	Text    string   `json:"text"`
+}
+
+func handleAPICreateAnnotation(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req CreateAnnotationRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid JSON"})
+		return
+	}
+
+	var missing []string
+	if req.RunUUID == "" {
+		missing = append(missing, "run_uuid")
+	}
+	if req.Step == nil {
+		missing = append(missing, "step")
+	}
+	if req.Text == "" {
+		missing = append(missing, "text")
+	}
+
+	if len(missing) > 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":          "Missing required fields",
+			"missing_fields": missing,
+		})
+		return
+	}
+
+	runID, err := dao.GetRunIDByUUID(ctx, req.RunUUID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Run not found"})
+		return
+	}
+
+	err = dao.InsertRunAnnotation(ctx, runID, *req.Step, req.Text)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to insert annotation"})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+
+// getRunAnnotations loads the annotations for a run in display form
+func getRunAnnotations(ctx context.Context, runID int) ([]Annotation, error) {
+	rows, err := dao.GetRunAnnotationsByRunID(ctx, runID)
+	if err != nil {
+		return nil, err
+	}
+
+	var annotations []Annotation
+	for _, a := range rows {
+		annotations = append(annotations, Annotation{
+			Step: fmt.Sprintf("%g", a.Step),
+			Text: a.Text,
+		})
+	}
+	return annotations, nil
+}
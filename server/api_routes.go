@@ -0,0 +1,266 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+// Query parameters several API operations take
+var (
+	runUUIDQuery        = APIParam{Name: "run_uuid", Required: true}
+	experimentUUIDQuery = APIParam{Name: "experiment_uuid", Required: true}
+	projectQuery        = APIParam{Name: "project", Description: "Limits the results to a project, by name or UUID"}
+)
+
+// registerAPIRoutes registers the JSON API's endpoints, describing each for
+// the OpenAPI document at /api/openapi.json
+func registerAPIRoutes() {
+	handleAPI("/api/openapi.json", handleAPIOpenAPI,
+		APIOperation{Method: http.MethodGet, ID: "getOpenAPIDocument", Summary: "This document", Response: map[string]any{}})
+
+	handleAPI("/api/runs", handleAPICreateRun,
+		APIOperation{Method: http.MethodPost, ID: "createRun", Summary: "Create a run", Request: CreateRunRequest{},
+			Response: apiFields{"id": "", "name": ""}})
+	handleAPI("/api/params", handleAPILogParam,
+		APIOperation{Method: http.MethodPost, ID: "logParam", Summary: "Log a parameter of a run", Request: LogParamRequest{}, Response: APIStatus{}})
+	handleAPI("/api/params/batch", handleAPILogParamsBatch,
+		APIOperation{Method: http.MethodPost, ID: "logParams", Summary: "Log parameters of a run, as an object of keys to values or a list of {key, value, type}",
+			Request: LogParamsBatchRequest{}, Response: apiFields{"status": "", "count": 0}})
+	handleAPI("/api/metrics", handleAPILogMetrics,
+		APIOperation{Method: http.MethodPost, ID: "logMetrics", Summary: "Log points of a metric of a run", Request: LogMetricsRequest{},
+			Response: apiFields{"status": "", "warnings": []string{}}})
+	handleAPI("/api/stream", handleAPIMetricStream)
+	handleAPI("/api/system-metrics", handleAPILogSystemMetrics,
+		APIOperation{Method: http.MethodPost, ID: "logSystemMetrics", Summary: "Log system metrics of a run", Request: LogSystemMetricsRequest{},
+			Response: apiFields{"status": "", "count": 0}})
+	handleAPI("/api/confusion_matrices", handleAPILogConfusionMatrix,
+		APIOperation{Method: http.MethodPost, ID: "logConfusionMatrix", Summary: "Log a confusion matrix of a run", Request: LogConfusionMatrixRequest{}, Response: APIStatus{}})
+	handleAPI("/api/curves", handleAPILogCurve,
+		APIOperation{Method: http.MethodPost, ID: "logCurve", Summary: "Log a ROC or precision-recall curve of a run", Request: LogCurveRequest{},
+			Response: apiFields{"status": "", "auc": 0.0}})
+	handleAPI("/api/embeddings", handleAPILogEmbeddings,
+		APIOperation{Method: http.MethodPost, ID: "logEmbeddings", Summary: "Log embeddings of a run", Request: LogEmbeddingsRequest{},
+			Response: apiFields{"status": "", "path": ""}})
+	handleAPI("/api/text_samples", handleAPILogTextSamples,
+		APIOperation{Method: http.MethodPost, ID: "logTextSamples", Summary: "Log text samples of a run", Request: LogTextSamplesRequest{}, Response: APIStatus{}})
+
+	artifactUpload := apiFields{"status": "", "path": "", "uri": "", "sha256": "", "content_type": "", "version": 0, "scan_status": ""}
+	handleAPI("/api/artifacts", handleAPIArtifacts,
+		APIOperation{Method: http.MethodGet, ID: "getArtifact", Summary: "Get an artifact's metadata",
+			Query: []APIParam{runUUIDQuery, {Name: "path", Required: true}},
+			Response: apiFields{"path": "", "uri": "", "type": "", "content_type": "", "size_bytes": int64(0), "sha256": "",
+				"download_url": "", "scan_status": "", "scan_result": ""}},
+		APIOperation{Method: http.MethodPost, ID: "logArtifact", Summary: "Upload an artifact of a run", RequestType: "multipart/form-data",
+			Request: apiFields{"run_uuid": "", "path": "", "file": apiFile{}}, Response: artifactUpload})
+	handleAPI("/api/artifacts/initiate", handleAPIInitiateArtifactUpload,
+		APIOperation{Method: http.MethodPost, ID: "initiateArtifactUpload", Summary: "Start or resume a chunked artifact upload",
+			Request:  InitiateArtifactUploadRequest{},
+			Response: apiFields{"upload_id": "", "chunk_size": int64(0), "chunk_count": 0, "received_chunks": []int{}, "expires_at": time.Time{}}})
+	handleAPI("/api/artifacts/chunk", handleAPIArtifactUploadChunk,
+		APIOperation{Method: http.MethodPut, ID: "uploadArtifactChunk", Summary: "Upload a chunk of an artifact upload",
+			Query:       []APIParam{{Name: "upload_id", Required: true}, {Name: "index", Required: true}},
+			RequestType: "application/octet-stream",
+			Response:    apiFields{"status": "", "index": 0, "size_bytes": int64(0), "sha256": ""}})
+	handleAPI("/api/artifacts/complete", handleAPICompleteArtifactUpload,
+		APIOperation{Method: http.MethodPost, ID: "completeArtifactUpload", Summary: "Assemble an artifact upload's chunks into the artifact",
+			Request: CompleteArtifactUploadRequest{}, Response: artifactUpload})
+
+	handleAPI("/api/runs/notes", handleAPIUpdateRunNotes,
+		APIOperation{Method: http.MethodPost, ID: "updateRunNotes", Summary: "Replace a run's notes", Request: UpdateRunNotesRequest{}, Response: APIStatus{}})
+	handleAPI("/api/runs/hold", handleAPIRunHold,
+		APIOperation{Method: http.MethodPost, ID: "holdRun", Summary: "Put a run on hold, keeping it from deletion", Request: RunHoldRequest{}, Response: APIStatus{}},
+		APIOperation{Method: http.MethodDelete, ID: "releaseRun", Summary: "Take a run off hold", Query: []APIParam{runUUIDQuery}, Response: APIStatus{}})
+	handleAPI("/api/runs/finish", handleAPIFinishRun,
+		APIOperation{Method: http.MethodPost, ID: "finishRun", Summary: "Set the status a run ended with", Request: FinishRunRequest{},
+			Response: apiFields{"status": "", "run_status": ""}})
+	handleAPI("/api/runs/dependencies", handleAPIRunDependencies,
+		APIOperation{Method: http.MethodGet, ID: "getRunDependencies", Summary: "List a run's upstream and downstream runs", Query: []APIParam{runUUIDQuery},
+			Response: apiFields{"upstream": []RunDependencyDocument{}, "downstream": []RunDependencyDocument{}}},
+		APIOperation{Method: http.MethodPost, ID: "addRunDependency", Summary: "Record that a run consumed something of an upstream run",
+			Request: AddRunDependencyRequest{}, Response: APIStatus{}},
+		APIOperation{Method: http.MethodDelete, ID: "deleteRunDependency", Summary: "Delete a run dependency",
+			Query: []APIParam{runUUIDQuery, {Name: "id", Required: true}}, Response: APIStatus{}})
+	handleAPI("/api/runs/environment", handleAPILogEnvironment,
+		APIOperation{Method: http.MethodPost, ID: "logEnvironment", Summary: "Log the environment a run runs in", Request: LogEnvironmentRequest{},
+			Response: apiFields{"status": "", "redacted": []string{}}})
+	handleAPI("/api/runs/seeds", handleAPILogSeeds,
+		APIOperation{Method: http.MethodPost, ID: "logSeeds", Summary: "Log the random seeds a run started with", Request: LogSeedsRequest{}, Response: APIStatus{}})
+	handleAPI("/api/runs/best-checkpoint", handleAPIRunBestCheckpoint,
+		APIOperation{Method: http.MethodGet, ID: "getBestCheckpoint", Summary: "Get a run's best checkpoint", Query: []APIParam{runUUIDQuery},
+			Response: apiFields{"best_checkpoint": &BestCheckpoint{}}},
+		APIOperation{Method: http.MethodPost, ID: "setBestCheckpoint", Summary: "Designate an artifact as a run's best checkpoint",
+			Request: RunBestCheckpointRequest{}, Response: APIStatus{}},
+		APIOperation{Method: http.MethodDelete, ID: "clearBestCheckpoint", Summary: "Clear a run's best checkpoint", Query: []APIParam{runUUIDQuery}, Response: APIStatus{}})
+	handleAPI("/api/runs/import", handleAPIImportRunBundle,
+		APIOperation{Method: http.MethodPost, ID: "importRunBundle", Summary: "Import a run bundle",
+			Query: []APIParam{{Name: "experiment_uuid"}}, RequestType: "application/gzip", Response: RunBundleImport{}})
+
+	handleAPI("/api/trash", handleAPITrash,
+		APIOperation{Method: http.MethodGet, ID: "listTrash", Summary: "List the runs in the trash", Query: []APIParam{projectQuery},
+			Response: apiFields{"purge_after_days": 0, "runs": []TrashedRun{}}})
+	handleAPI("/api/trash/restore", handleAPIRestoreRun,
+		APIOperation{Method: http.MethodPost, ID: "restoreRun", Summary: "Restore a run from the trash, with its child runs deleted with it",
+			Request: RestoreRunRequest{}, Response: apiFields{"status": "", "restored": 0}})
+	handleAPI("/api/annotations", handleAPICreateAnnotation,
+		APIOperation{Method: http.MethodPost, ID: "createAnnotation", Summary: "Annotate a point of a run's metric", Request: CreateAnnotationRequest{}, Response: APIStatus{}})
+	handleAPI("/api/tags", handleAPITags,
+		APIOperation{Method: http.MethodGet, ID: "listTags", Summary: "List a run's tags", Query: []APIParam{runUUIDQuery},
+			Response: apiFields{"tags": map[string]string{}}},
+		APIOperation{Method: http.MethodPost, ID: "setTag", Summary: "Set a tag of a run", Request: SetTagRequest{}, Response: APIStatus{}},
+		APIOperation{Method: http.MethodDelete, ID: "deleteTag", Summary: "Delete a tag of a run",
+			Query: []APIParam{runUUIDQuery, {Name: "key", Required: true}}, Response: APIStatus{}})
+	handleAPI("/api/templates", handleAPIRunTemplates,
+		APIOperation{Method: http.MethodGet, ID: "listRunTemplates", Summary: "List run templates",
+			Response: apiFields{"templates": []RunTemplateDocument{}}},
+		APIOperation{Method: http.MethodPost, ID: "saveRunTemplate", Summary: "Save a run's parameters as a template", Request: SaveRunTemplateRequest{}, Response: APIStatus{}})
+	handleAPI("/api/templates/runs", handleAPICreateRunFromTemplate,
+		APIOperation{Method: http.MethodPost, ID: "createRunFromTemplate", Summary: "Create a run from a template", Request: CreateRunFromTemplateRequest{},
+			Response: apiFields{"id": "", "name": ""}})
+
+	// Endpoints of a run, served under /api/runs/ by handleAPIV1Runs
+	http.Handle("/api/runs/", LoggerMiddleware(apiErrorMiddleware(authMiddleware(apiVersionMiddleware(0, http.HandlerFunc(handleAPIV1Runs))))))
+	http.Handle("/api/v1/runs/", LoggerMiddleware(apiErrorMiddleware(authMiddleware(apiVersionMiddleware(1, http.HandlerFunc(handleAPIV1Runs))))))
+	describeAPI("/api/runs/{uuid}",
+		APIOperation{Method: http.MethodGet, ID: "getRun", Summary: "Get a run", Response: RunDocument{}},
+		APIOperation{Method: http.MethodDelete, ID: "deleteRun", Summary: "Delete a run and its child runs",
+			Query:    []APIParam{{Name: "notify", Description: "Who deleted the run, for the run_deleted notification"}},
+			Response: apiFields{"status": "", "purge_after": time.Time{}}})
+	describeAPI("/api/runs/{uuid}/metrics",
+		APIOperation{Method: http.MethodGet, ID: "listRunMetricKeys", Summary: "List a run's metric keys grouped by prefix",
+			Query:    []APIParam{{Name: "prefix", Description: "Lists the keys under a prefix, e.g. train/"}},
+			Response: apiFields{"prefix": "", "total": 0, "groups": []MetricKeyGroup{}}})
+	describeAPI("/api/runs/{uuid}/metrics/namespaces",
+		APIOperation{Method: http.MethodGet, ID: "listRunMetricNamespaces", Summary: "List the namespaces of a run's metrics",
+			Response: apiFields{"namespaces": []RunMetricNamespace{}}})
+	describeAPI("/api/runs/{uuid}/metrics/summaries",
+		APIOperation{Method: http.MethodGet, ID: "getRunMetricSummaries", Summary: "Summarize a run's metrics",
+			Query:    []APIParam{{Name: "namespace", Description: "Summarizes the metrics of a namespace"}},
+			Response: apiFields{"metrics": []RunMetricSummary{}}})
+	describeAPI("/api/runs/{uuid}/metrics/prometheus",
+		APIOperation{Method: http.MethodGet, ID: "getRunMetricsPrometheus", Summary: "A run's latest metric values in the Prometheus text format",
+			ResponseType: "text/plain"})
+	// Metric keys may contain slashes, which are not escaped
+	describeAPI("/api/runs/{uuid}/metrics/{key}",
+		APIOperation{Method: http.MethodGet, ID: "getRunMetricSeries", Summary: "Get a metric series of a run, downsampled to max_points",
+			Query: []APIParam{{Name: "max_points"}}, Response: MetricSeries{}})
+	describeAPI("/api/runs/{uuid}/metrics/{key}/tail",
+		APIOperation{Method: http.MethodGet, ID: "tailRunMetric", Summary: "Get the points of a metric series after a step, waiting for new ones",
+			Query:    []APIParam{{Name: "after_step"}, {Name: "limit"}, {Name: "wait", Description: "Seconds to wait for new points, up to 60"}},
+			Response: MetricTail{}})
+	describeAPI("/api/runs/{uuid}/metrics/{key}/best",
+		APIOperation{Method: http.MethodGet, ID: "getRunMetricBest", Summary: "Get the best point of a metric series",
+			Query: []APIParam{{Name: "mode", Description: "min or max", Required: true}}, Response: MetricBest{}})
+	describeAPI("/api/runs/{uuid}/metrics/{key}/chart",
+		APIOperation{Method: http.MethodGet, ID: "getRunMetricChart", Summary: "Render a chart of a metric series",
+			Query: []APIParam{{Name: "format", Description: "png or svg"}}, ResponseType: "image/png"})
+	describeAPI("/api/runs/{uuid}/artifacts",
+		APIOperation{Method: http.MethodGet, ID: "listRunArtifacts", Summary: "List a run's artifacts, or the versions of one",
+			Query:    []APIParam{{Name: "path"}, {Name: "versions", Description: "true to list prior versions"}},
+			Response: apiFields{"artifacts": []ArtifactVersion{}}})
+	describeAPI("/api/runs/{uuid}/artifacts/archive",
+		APIOperation{Method: http.MethodGet, ID: "downloadRunArtifacts", Summary: "Download a run's artifacts as a zip archive", ResponseType: "application/zip"})
+	describeAPI("/api/runs/{uuid}/notes",
+		APIOperation{Method: http.MethodPut, ID: "putRunNotes", Summary: "Replace a run's notes", Request: PutRunNotesRequest{}, Response: APIStatus{}})
+	describeAPI("/api/runs/{uuid}/archive",
+		APIOperation{Method: http.MethodPost, ID: "archiveRun", Summary: "Archive a run", Response: apiFields{"status": "", "archived": false}},
+		APIOperation{Method: http.MethodDelete, ID: "unarchiveRun", Summary: "Unarchive a run", Response: apiFields{"status": "", "archived": false}})
+	describeAPI("/api/runs/{uuid}/fork",
+		APIOperation{Method: http.MethodPost, ID: "forkRun", Summary: "Create a run with a run's parameters, some overridden, and optionally its tags and notes",
+			Request: ForkRunRequest{}, Response: apiFields{"id": "", "name": "", "forked_from": ""}})
+
+	http.Handle("/api/grafana/", LoggerMiddleware(apiErrorMiddleware(authMiddleware(apiVersionMiddleware(0, http.HandlerFunc(handleGrafana))))))
+	http.Handle("/api/v1/grafana/", LoggerMiddleware(apiErrorMiddleware(authMiddleware(apiVersionMiddleware(1, http.HandlerFunc(handleGrafana))))))
+
+	handleAPI("/api/admin/housekeeping", handleAPIHousekeeping,
+		APIOperation{Method: http.MethodGet, ID: "listHousekeeping", Summary: "List the housekeeping jobs and their recent reports, or get a report",
+			Query: []APIParam{{Name: "report_id"}}},
+		APIOperation{Method: http.MethodPost, ID: "runHousekeeping", Summary: "Dry run a housekeeping job, or execute a reviewed dry run",
+			Request: HousekeepingRequest{}, Response: apiFields{"status": "", "report_id": 0, "report": HousekeepingReport{}}})
+	handleAPI("/api/admin/runs/merge", handleAPIMergeRuns,
+		APIOperation{Method: http.MethodPost, ID: "mergeRuns", Summary: "Merge one run into another", Request: MergeRunsRequest{}, Response: RunMerge{}})
+	handleAPI("/api/admin/audit-log", handleAPIAuditLog,
+		APIOperation{Method: http.MethodGet, ID: "getAuditLog", Summary: "List recent audit log entries", Response: apiFields{"entries": []AuditLogEntry{}}})
+	quota := apiFields{"experiment_uuid": "", "quota": ExperimentQuota{}, "overridden": false, "usage": ExperimentQuota{}}
+	handleAPI("/api/admin/quotas", handleAPIQuotas,
+		APIOperation{Method: http.MethodGet, ID: "getQuota", Summary: "Get an experiment's quota and usage", Query: []APIParam{experimentUUIDQuery}, Response: quota},
+		APIOperation{Method: http.MethodPut, ID: "setQuota", Summary: "Override an experiment's quota", Request: QuotaRequest{}, Response: quota},
+		APIOperation{Method: http.MethodDelete, ID: "resetQuota", Summary: "Restore the defaults of an experiment's quota",
+			Query: []APIParam{experimentUUIDQuery}, Response: quota})
+	handleAPI("/api/admin/artifacts/scan", handleAPIArtifactScan,
+		APIOperation{Method: http.MethodPost, ID: "scanArtifact", Summary: "Scan an artifact again", Request: ArtifactScanRequest{},
+			Response: apiFields{"status": "", "path": "", "version": 0, "scan_status": ""}})
+	handleAPI("/api/admin/artifacts/relocate", handleAPIRelocateArtifacts,
+		APIOperation{Method: http.MethodPost, ID: "relocateArtifacts", Summary: "Rewrite the URIs of artifacts moved in the artifact store",
+			Request: RelocateArtifactsRequest{}, Response: ArtifactRelocation{}})
+	handleAPI("/api/admin/projects/experiments", handleAPIMoveExperiment,
+		APIOperation{Method: http.MethodPost, ID: "moveExperiment", Summary: "Move an experiment to another project", Request: MoveExperimentRequest{}, Response: APIStatus{}})
+	handleAPI("/api/projects", handleAPIProjects,
+		APIOperation{Method: http.MethodGet, ID: "listProjects", Summary: "List projects", Response: apiFields{"projects": []ProjectDocument{}}},
+		APIOperation{Method: http.MethodPost, ID: "createProject", Summary: "Create a project", Request: CreateProjectRequest{}, Response: ProjectDocument{}})
+
+	handleAPI("/api/experiments", handleAPICreateExperiment,
+		APIOperation{Method: http.MethodPost, ID: "createExperiment", Summary: "Create an experiment",
+			Query: []APIParam{{Name: "name", Required: true}, projectQuery}, Response: apiFields{"id": "", "name": ""}})
+	handleAPI("/api/experiments/config", handleAPIExperimentConfig,
+		APIOperation{Method: http.MethodGet, ID: "exportExperimentConfig", Summary: "Export an experiment's config as YAML",
+			Query: []APIParam{experimentUUIDQuery}, ResponseType: "application/yaml"},
+		APIOperation{Method: http.MethodPost, ID: "applyExperimentConfig", Summary: "Create or update an experiment from a YAML config",
+			RequestType: "application/yaml", Response: apiFields{"status": "", "experiment_uuid": "", "created": false}})
+	handleAPI("/api/experiments/best-checkpoint-rule", handleAPIBestCheckpointRule,
+		APIOperation{Method: http.MethodGet, ID: "getBestCheckpointRule", Summary: "Get an experiment's best checkpoint rule",
+			Query: []APIParam{experimentUUIDQuery}, Response: apiFields{"metric": "", "mode": "", "artifacts": ""}},
+		APIOperation{Method: http.MethodPost, ID: "setBestCheckpointRule", Summary: "Set an experiment's best checkpoint rule",
+			Request: BestCheckpointRuleRequest{}, Response: APIStatus{}},
+		APIOperation{Method: http.MethodDelete, ID: "deleteBestCheckpointRule", Summary: "Delete an experiment's best checkpoint rule",
+			Query: []APIParam{experimentUUIDQuery}, Response: APIStatus{}})
+	handleAPI("/api/experiments/metric-namespaces", handleAPIMetricNamespaceSettings,
+		APIOperation{Method: http.MethodGet, ID: "listMetricNamespaceSettings", Summary: "List what an experiment sets for its metric namespaces",
+			Query: []APIParam{experimentUUIDQuery}, Response: apiFields{"namespaces": []MetricNamespaceSetting{}}},
+		APIOperation{Method: http.MethodPost, ID: "setMetricNamespace", Summary: "Set point retention or alerts for a metric namespace",
+			Request: MetricNamespaceSettingRequest{}, Response: apiFields{"status": "", "setting": MetricNamespaceSetting{}}},
+		APIOperation{Method: http.MethodDelete, ID: "deleteMetricNamespace", Summary: "Delete what an experiment sets for a metric namespace",
+			Query: []APIParam{experimentUUIDQuery, {Name: "namespace", Required: true}}, Response: APIStatus{}})
+	handleAPI("/api/experiments/readme", handleAPIUpdateExperimentReadme,
+		APIOperation{Method: http.MethodPost, ID: "updateExperimentReadme", Summary: "Replace an experiment's README",
+			Request: UpdateExperimentReadmeRequest{}, Response: APIStatus{}})
+	handleAPI("/api/experiments/archive", handleAPIExperimentArchive,
+		APIOperation{Method: http.MethodGet, ID: "listExperimentArchives", Summary: "List an experiment's archives",
+			Query: []APIParam{experimentUUIDQuery}, Response: apiFields{"archives": []ExperimentArchive{}}},
+		APIOperation{Method: http.MethodPost, ID: "archiveExperiment", Summary: "Archive an experiment's runs into a bundle in the artifact store",
+			Request: ExperimentArchiveRequest{}, Response: apiFields{"archive": ExperimentArchive{}, "runs": 0, "purged": false}})
+	handleAPI("/api/experiments/import", handleAPIImportRunsCSV,
+		APIOperation{Method: http.MethodPost, ID: "importRunsCSV", Summary: "Import runs from a CSV file", RequestType: "multipart/form-data",
+			Request:  apiFields{"file": apiFile{}, "experiment_uuid": "", "columns": "", "dry_run": ""},
+			Response: apiFields{"columns": []ImportColumn{}, "runs": []ImportedRun{}, "dry_run": false, "run_uuids": []string{}}})
+	handleAPI("/api/experiments/import/jsonl", handleAPIImportRunsJSONL,
+		APIOperation{Method: http.MethodPost, ID: "importRunsJSONL", Summary: "Import runs from JSON lines, a run per line",
+			Query:       []APIParam{{Name: "experiment_uuid"}, {Name: "source"}, {Name: "dry_run"}},
+			RequestType: "application/x-ndjson",
+			Response:    apiFields{"runs": []ImportedRun{}, "dry_run": false, "run_uuids": []string{}}})
+	handleAPI("/api/notifications/subscriptions", handleAPINotificationSubscriptions,
+		APIOperation{Method: http.MethodGet, ID: "listNotificationSubscriptions", Summary: "List notification subscriptions",
+			Response: apiFields{"subscriptions": []NotificationSubscriptionDocument{}}},
+		APIOperation{Method: http.MethodPost, ID: "createNotificationSubscription", Summary: "Subscribe to notifications of run events",
+			Request: CreateNotificationSubscriptionRequest{}, Response: APIStatus{}},
+		APIOperation{Method: http.MethodDelete, ID: "deleteNotificationSubscription", Summary: "Delete a notification subscription",
+			Query: []APIParam{{Name: "id", Required: true}}, Response: APIStatus{}})
+	handleAPI("/api/experiments/parameter-warnings", handleAPIGetParameterWarnings,
+		APIOperation{Method: http.MethodGet, ID: "getParameterWarnings", Summary: "List parameters an experiment's runs logged with more than one type",
+			Query: []APIParam{experimentUUIDQuery}, Response: apiFields{"warnings": []ParameterWarningDocument{}}})
+
+	handleAPI("/api/search", handleAPISearch,
+		APIOperation{Method: http.MethodGet, ID: "search", Summary: "Search runs' names, notes and parameters",
+			Query: []APIParam{{Name: "q", Required: true}, projectQuery}, Response: apiFields{"results": []SearchResultDocument{}}})
+	handleAPI("/api/runs/search", handleAPIRunQuerySearch,
+		APIOperation{Method: http.MethodGet, ID: "searchRuns", Summary: "List the runs matching a run query, newest first",
+			Query: []APIParam{{Name: "q"}, {Name: "limit"}, {Name: "offset"}, {Name: "name"}, {Name: "created_within", Description: "e.g. 24h or 7d"},
+				{Name: "include_archived"}, {Name: "user"}, {Name: "commit"}, {Name: "reproducible"}, projectQuery},
+			Response: apiFields{"runs": []RunSearchResultDocument{}, "has_more": false}})
+	handleAPI("/api/meta", handleAPIMeta,
+		APIOperation{Method: http.MethodGet, ID: "getMeta", Summary: "Describe the experiments and the keys logged to their runs",
+			Query: []APIParam{{Name: "experiment_uuid"}, projectQuery}, Response: Meta{}})
+	handleAPI("/api/export", handleAPIExport,
+		APIOperation{Method: http.MethodGet, ID: "exportRuns", Summary: "Export runs' parameters and final metrics as CSV, or JSON with format=json",
+			Query:        []APIParam{{Name: "runs", Description: "Comma-separated run UUIDs", Required: true}, {Name: "format", Description: "csv or json"}},
+			ResponseType: "text/csv"})
+}
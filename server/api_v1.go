@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// handleAPIV1Runs routes the per-run endpoints: the run document at
+// /api/v1/runs/{uuid}, where DELETE deletes the run, and the endpoints under
+// it. They are also served at their unversioned paths, e.g. /api/runs/{uuid}.
+func handleAPIV1Runs(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/api/v1"), "/api")
+	path = strings.TrimPrefix(path, "/runs/")
+	parts := strings.SplitN(path, "/", 2)
+	runUUID := parts[0]
+
+	if len(parts) == 1 && runUUID != "" {
+		if r.Method == http.MethodDelete {
+			handleAPIDeleteRun(w, r, runUUID)
+			return
+		}
+		handleAPIRunDocument(w, r, runUUID)
+		return
+	}
+	if len(parts) == 2 {
+		switch parts[1] {
+		case "metrics":
+			handleAPIRunMetricKeys(w, r, runUUID)
+			return
+		case "metrics/namespaces":
+			handleAPIRunMetricNamespaces(w, r, runUUID)
+			return
+		case "metrics/summaries":
+			handleAPIRunMetricSummaries(w, r, runUUID)
+			return
+		case "metrics/prometheus":
+			handleAPIRunMetricsPrometheus(w, r, runUUID)
+			return
+		case "artifacts":
+			handleAPIRunArtifacts(w, r, runUUID)
+			return
+		case "artifacts/archive":
+			handleAPIRunArtifactsArchive(w, r, runUUID)
+			return
+		case "notes":
+			handleAPIPutRunNotes(w, r, runUUID)
+			return
+		case "archive":
+			handleAPIArchiveRun(w, r, runUUID)
+			return
+		case "fork":
+			handleAPIForkRun(w, r, runUUID)
+			return
+		}
+		if key, ok := strings.CutPrefix(parts[1], "metrics/"); ok && key != "" {
+			if key, ok := strings.CutSuffix(key, "/tail"); ok && key != "" {
+				handleAPIRunMetricTail(w, r, runUUID, key)
+				return
+			}
+			if key, ok := strings.CutSuffix(key, "/best"); ok && key != "" {
+				handleAPIRunMetricBest(w, r, runUUID, key)
+				return
+			}
+			if key, ok := strings.CutSuffix(key, "/chart"); ok && key != "" {
+				handleAPIRunMetricChart(w, r, runUUID, key)
+				return
+			}
+			handleAPIRunMetricSeries(w, r, runUUID, key)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusNotFound)
+	json.NewEncoder(w).Encode(map[string]string{"error": "Not found"})
+}
+
+// RunDocument is a run as returned by the API, for tooling that needs to
+// look a run up programmatically
+type RunDocument struct {
+	UUID           string            `json:"uuid"`
+	Name           string            `json:"name"`
+	Status         string            `json:"status"`
+	StatusMessage  string            `json:"status_message,omitempty"`
+	Notes          string            `json:"notes"`
+	ExperimentUUID string            `json:"experiment_uuid,omitempty"`
+	Parameters     map[string]string `json:"parameters"`
+	Tags           map[string]string `json:"tags"`
+	BestCheckpoint *BestCheckpoint   `json:"best_checkpoint"`
+	Source         *RunSource        `json:"source"`
+	Seeds          *RunSeeds         `json:"seeds"`
+	Reproducible   bool              `json:"reproducible"`
+	// ForkedFrom is the UUID of the run the run was forked from
+	ForkedFrom string `json:"forked_from,omitempty"`
+}
+
+// handleAPIRunDocument returns a run's document
+func handleAPIRunDocument(w http.ResponseWriter, r *http.Request, runUUID string) {
+	ctx := r.Context()
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+
+	run, err := dao.GetRunByUUID(ctx, runUUID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Run not found"})
+		return
+	}
+	runID, err := dao.GetRunIDByUUID(ctx, runUUID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Run not found"})
+		return
+	}
+
+	doc, err := buildRunDocument(ctx, run, runID)
+	if err != nil {
+		log.Printf("Failed to load run %s: %v", runUUID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to load run"})
+		return
+	}
+	json.NewEncoder(w).Encode(doc)
+}
+
+func buildRunDocument(ctx context.Context, run *Run, runID int) (*RunDocument, error) {
+	doc := &RunDocument{
+		UUID:          run.UUID,
+		Name:          run.Name,
+		Status:        run.Status,
+		StatusMessage: run.StatusMessage,
+		Notes:         run.Notes,
+		ForkedFrom:    run.ForkedFrom,
+		Parameters:    map[string]string{},
+		Tags:          map[string]string{},
+	}
+
+	experiment, err := dao.GetExperimentForRunUUID(ctx, run.UUID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	if experiment != nil {
+		doc.ExperimentUUID = experiment.UUID
+	}
+
+	params, err := dao.GetParametersByRunID(ctx, runID)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range params {
+		doc.Parameters[p.Key] = formatParameterValue(p)
+	}
+
+	tags, err := dao.GetRunTags(ctx, runID)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range tags {
+		doc.Tags[t.Key] = t.Value
+	}
+
+	doc.BestCheckpoint, err = getRunBestCheckpoint(ctx, runID)
+	if err != nil {
+		return nil, err
+	}
+	doc.Source, err = getRunSource(ctx, runID)
+	if err != nil {
+		return nil, err
+	}
+	doc.Seeds, err = getRunSeeds(ctx, runID)
+	if err != nil {
+		return nil, err
+	}
+	environment, err := dao.GetRunEnvironment(ctx, runID)
+	if err != nil {
+		return nil, err
+	}
+	doc.Reproducible = runReproducible(doc.Seeds, doc.Source, environment)
+	return doc, nil
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// APIVersion is a version of the JSON API. Clients select a version with a
+// path prefix (/api/v1/runs) or an Accept header
+// (application/vnd.apparatus.v1+json). Requests that do neither are served the
+// oldest supported version, so clients written before a version existed keep
+// working across upgrades.
+type APIVersion struct {
+	Version int
+	// Deprecated is when the version was deprecated, or zero if it is not
+	Deprecated time.Time
+	// Sunset is when the version will stop being served, or zero if that is
+	// not scheduled
+	Sunset time.Time
+	// Upgrade wraps the handlers of the next version so they serve requests
+	// made against this one, e.g. by rewriting renamed fields. Requests are
+	// upgraded one version at a time up to the current version.
+	Upgrade func(next http.Handler) http.Handler
+}
+
+// APIDeprecation marks an endpoint as deprecated in favor of a successor
+type APIDeprecation struct {
+	Since     time.Time
+	Sunset    time.Time
+	Successor string
+}
+
+// apiVersions are the supported API versions, oldest first. The last is the
+// current version, which the handlers implement.
+var apiVersions = []APIVersion{
+	{Version: 1},
+}
+
+// apiDeprecatedEndpoints maps the unversioned paths of deprecated endpoints,
+// e.g. "/api/runs", to their deprecations
+var apiDeprecatedEndpoints = map[string]APIDeprecation{}
+
+// legacyQueryParamWrites accepts the fields of POST /api/runs and POST
+// /api/params as URL query parameters, the form they took before they took
+// JSON bodies. The query form cannot carry values containing '&', '=', or
+// non-ASCII text reliably, and will be removed.
+var legacyQueryParamWrites = true
+
+// legacyQueryParamWritesDeprecated is when the query parameter form of writes
+// was deprecated
+var legacyQueryParamWritesDeprecated = time.Date(2026, time.October, 15, 0, 0, 0, 0, time.UTC)
+
+// apiVersionHeader reports the version a response was served at
+const apiVersionHeader = "Apparatus-API-Version"
+
+// apiMediaTypePattern matches the media type clients request a version with
+var apiMediaTypePattern = regexp.MustCompile(`^application/vnd\.apparatus\.v(\d+)\+json$`)
+
+// apiVersionPathPattern matches the version prefix of a versioned API path
+var apiVersionPathPattern = regexp.MustCompile(`^/api/v\d+(/|$)`)
+
+type apiVersionContextKey struct{}
+
+// apiUnsupportedVersionError is returned when a client asks only for API
+// versions the server does not support
+type apiUnsupportedVersionError struct {
+	requested []int
+}
+
+func (e *apiUnsupportedVersionError) Error() string {
+	return fmt.Sprintf("API version %v is not supported", e.requested)
+}
+
+// currentAPIVersion is the newest API version
+func currentAPIVersion() APIVersion {
+	return apiVersions[len(apiVersions)-1]
+}
+
+// lookupAPIVersion finds a supported API version
+func lookupAPIVersion(version int) (APIVersion, bool) {
+	for _, v := range apiVersions {
+		if v.Version == version {
+			return v, true
+		}
+	}
+	return APIVersion{}, false
+}
+
+// supportedAPIVersions lists the supported version numbers
+func supportedAPIVersions() []int {
+	versions := make([]int, len(apiVersions))
+	for i, v := range apiVersions {
+		versions[i] = v.Version
+	}
+	return versions
+}
+
+// negotiateAPIVersion picks the API version to serve a request at. A version
+// in the path wins; otherwise the first supported version in the Accept header
+// is used, and requests that ask for none get the oldest supported version.
+func negotiateAPIVersion(r *http.Request, pathVersion int) (int, error) {
+	if pathVersion != 0 {
+		return pathVersion, nil
+	}
+
+	var requested []int
+	for _, accept := range r.Header.Values("Accept") {
+		for _, mediaRange := range strings.Split(accept, ",") {
+			mediaType, _, _ := strings.Cut(mediaRange, ";")
+			m := apiMediaTypePattern.FindStringSubmatch(strings.TrimSpace(mediaType))
+			if m == nil {
+				continue
+			}
+			version, _ := strconv.Atoi(m[1])
+			if _, ok := lookupAPIVersion(version); ok {
+				return version, nil
+			}
+			requested = append(requested, version)
+		}
+	}
+	if len(requested) > 0 {
+		return 0, &apiUnsupportedVersionError{requested: requested}
+	}
+	return apiVersions[0].Version, nil
+}
+
+// apiVersionFromContext returns the API version a request is being served at
+func apiVersionFromContext(ctx context.Context) int {
+	if version, ok := ctx.Value(apiVersionContextKey{}).(int); ok {
+		return version
+	}
+	return currentAPIVersion().Version
+}
+
+// unversionedAPIPath strips the version prefix from an API path
+func unversionedAPIPath(path string) string {
+	if loc := apiVersionPathPattern.FindStringIndex(path); loc != nil {
+		return "/api/" + path[loc[1]:]
+	}
+	return path
+}
+
+// setDeprecationHeaders announces the deprecation of an API version or
+// endpoint with the Deprecation (RFC 9745), Sunset (RFC 8594), and Link
+// headers
+func setDeprecationHeaders(w http.ResponseWriter, since, sunset time.Time, successor string) {
+	w.Header().Set("Deprecation", fmt.Sprintf("@%d", since.Unix()))
+	if !sunset.IsZero() {
+		w.Header().Set("Sunset", sunset.UTC().Format(http.TimeFormat))
+	}
+	if successor != "" {
+		w.Header().Add("Link", fmt.Sprintf(`<%s>; rel="successor-version"`, successor))
+	}
+}
+
+// apiVersionMiddleware negotiates the API version of a request, reports it and
+// any deprecations in the response headers, and upgrades the request to the
+// current version. pathVersion is the version in the route's path, or zero for
+// unversioned routes.
+func apiVersionMiddleware(pathVersion int, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept")
+		version, err := negotiateAPIVersion(r, pathVersion)
+		if err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusNotAcceptable)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"error":              err.Error(),
+				"supported_versions": supportedAPIVersions(),
+			})
+			return
+		}
+		w.Header().Set(apiVersionHeader, strconv.Itoa(version))
+
+		path := unversionedAPIPath(r.URL.Path)
+		current := currentAPIVersion()
+		if v, _ := lookupAPIVersion(version); !v.Deprecated.IsZero() {
+			setDeprecationHeaders(w, v.Deprecated, v.Sunset, fmt.Sprintf("/api/v%d%s", current.Version, strings.TrimPrefix(path, "/api")))
+		}
+		if d, ok := apiDeprecatedEndpoints[path]; ok {
+			setDeprecationHeaders(w, d.Since, d.Sunset, d.Successor)
+		}
+
+		// Wrap the handler in the upgrades from the requested version to the
+		// current one, so the requested version's upgrade runs first
+		handler := next
+		for i := len(apiVersions) - 1; i >= 0; i-- {
+			v := apiVersions[i]
+			if v.Version >= version && v.Version < current.Version && v.Upgrade != nil {
+				handler = v.Upgrade(handler)
+			}
+		}
+		handler.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiVersionContextKey{}, version)))
+	})
+}
+
+// handleAPI registers an API endpoint at its unversioned path, e.g.
+// "/api/runs", and under each supported version, e.g. "/api/v1/runs". Writes
+// to the endpoint are journaled for replay, it requires an API token if the
+// server requires authentication, and its server errors are answered with the
+// JSON error envelope. The endpoint's operations describe it
+// in the API's OpenAPI document.
+func handleAPI(pattern string, handler http.HandlerFunc, ops ...APIOperation) {
+	journaledHandlers[pattern] = handler
+	if len(ops) > 0 {
+		describeAPI(pattern, ops...)
+	}
+	http.Handle(pattern, LoggerMiddleware(apiErrorMiddleware(authMiddleware(apiVersionMiddleware(0, backpressureMiddleware(journalMiddleware(handler)))))))
+	for _, v := range apiVersions {
+		versioned := fmt.Sprintf("/api/v%d%s", v.Version, strings.TrimPrefix(pattern, "/api"))
+		http.Handle(versioned, LoggerMiddleware(apiErrorMiddleware(authMiddleware(apiVersionMiddleware(v.Version, backpressureMiddleware(journalMiddleware(handler)))))))
+	}
+}
+
+// isLegacyQueryParamWrite reports whether a write sends its fields as URL
+// query parameters rather than a JSON body. It responds to the request,
+// returning ok false, if the query form is no longer accepted; otherwise the
+// response announces the form's deprecation.
+func isLegacyQueryParamWrite(w http.ResponseWriter, r *http.Request) (legacy, ok bool) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if r.URL.RawQuery == "" || mediaType == "application/json" {
+		return false, true
+	}
+	if !legacyQueryParamWrites {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Query parameters are no longer accepted, send the fields as a JSON body"})
+		return true, false
+	}
+	setDeprecationHeaders(w, legacyQueryParamWritesDeprecated, time.Time{}, "")
+	return true, true
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNegotiateAPIVersion(t *testing.T) {
+	tests := []struct {
+		name        string
+		accept      string
+		pathVersion int
+		want        int
+		wantErr     bool
+	}{
+		{"unversioned", "", 0, 1, false},
+		{"generic accept", "application/json, */*", 0, 1, false},
+		{"path version", "", 1, 1, false},
+		{"media type", "application/vnd.apparatus.v1+json", 0, 1, false},
+		{"media type with parameters", "text/html;q=0.5, application/vnd.apparatus.v1+json; q=0.9", 0, 1, false},
+		{"first supported media type", "application/vnd.apparatus.v99+json, application/vnd.apparatus.v1+json", 0, 1, false},
+		{"path wins over accept", "application/vnd.apparatus.v99+json", 1, 1, false},
+		{"unsupported media type", "application/vnd.apparatus.v99+json", 0, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/api/runs", nil)
+			if tt.accept != "" {
+				r.Header.Set("Accept", tt.accept)
+			}
+			got, err := negotiateAPIVersion(r, tt.pathVersion)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("negotiateAPIVersion error = %v, wantErr %v", err, tt.wantErr)
+			}
+			var unsupported *apiUnsupportedVersionError
+			if tt.wantErr && !errors.As(err, &unsupported) {
+				t.Errorf("Expected an unsupported version error, got %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Expected version %d, got %d", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestUnversionedAPIPath(t *testing.T) {
+	tests := map[string]string{
+		"/api/runs":                   "/api/runs",
+		"/api/v1/runs":                "/api/runs",
+		"/api/v12/runs/notes":         "/api/runs/notes",
+		"/api/v1":                     "/api/",
+		"/api/v1beta/runs":            "/api/v1beta/runs",
+		"/api/versions/notes-history": "/api/versions/notes-history",
+	}
+	for path, want := range tests {
+		if got := unversionedAPIPath(path); got != want {
+			t.Errorf("unversionedAPIPath(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
+
+func TestAPIVersionMiddleware(t *testing.T) {
+	// Simulate a deprecated v1 whose requests are upgraded to a current v2
+	// that renamed the "name" query parameter to "run_name"
+	oldVersions, oldDeprecations := apiVersions, apiDeprecatedEndpoints
+	defer func() { apiVersions, apiDeprecatedEndpoints = oldVersions, oldDeprecations }()
+	deprecated := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	sunset := time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)
+	apiVersions = []APIVersion{
+		{Version: 1, Deprecated: deprecated, Sunset: sunset, Upgrade: func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				q := r.URL.Query()
+				q.Set("run_name", q.Get("name"))
+				r.URL.RawQuery = q.Encode()
+				next.ServeHTTP(w, r)
+			})
+		}},
+		{Version: 2},
+	}
+	apiDeprecatedEndpoints = map[string]APIDeprecation{
+		"/api/old": {Since: deprecated, Successor: "/api/new"},
+	}
+
+	var served struct {
+		version int
+		runName string
+	}
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		served.version = apiVersionFromContext(r.Context())
+		served.runName = r.URL.Query().Get("run_name")
+	})
+
+	// Unversioned clients get the oldest version, upgraded to the current handler
+	w := httptest.NewRecorder()
+	apiVersionMiddleware(0, handler).ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/runs?name=baseline", nil))
+	if served.version != 1 || served.runName != "baseline" {
+		t.Errorf("Expected an upgraded v1 request, got version %d with run_name %q", served.version, served.runName)
+	}
+	if got := w.Header().Get(apiVersionHeader); got != "1" {
+		t.Errorf("Expected version header 1, got %q", got)
+	}
+	if got := w.Header().Get("Deprecation"); got != "@1767225600" {
+		t.Errorf("Unexpected Deprecation header %q", got)
+	}
+	if got := w.Header().Get("Sunset"); got != "Fri, 01 Jan 2027 00:00:00 GMT" {
+		t.Errorf("Unexpected Sunset header %q", got)
+	}
+	if got := w.Header().Get("Link"); got != `</api/v2/runs>; rel="successor-version"` {
+		t.Errorf("Unexpected Link header %q", got)
+	}
+
+	// Current clients are served directly, without deprecation headers
+	served.runName = ""
+	w = httptest.NewRecorder()
+	apiVersionMiddleware(2, handler).ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/v2/runs?name=baseline", nil))
+	if served.version != 2 || served.runName != "" || w.Header().Get("Deprecation") != "" {
+		t.Errorf("Expected an unmodified v2 request, got version %d with run_name %q", served.version, served.runName)
+	}
+
+	// Deprecated endpoints point to their successor
+	w = httptest.NewRecorder()
+	apiVersionMiddleware(2, handler).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v2/old", nil))
+	if got := w.Header().Get("Link"); got != `</api/new>; rel="successor-version"` {
+		t.Errorf("Unexpected Link header %q", got)
+	}
+
+	// Clients asking only for unsupported versions are refused
+	r := httptest.NewRequest(http.MethodGet, "/api/runs", nil)
+	r.Header.Set("Accept", "application/vnd.apparatus.v3+json")
+	w = httptest.NewRecorder()
+	apiVersionMiddleware(0, handler).ServeHTTP(w, r)
+	if w.Code != http.StatusNotAcceptable || !strings.Contains(w.Body.String(), `"supported_versions":[1,2]`) {
+		t.Errorf("Expected 406 listing the supported versions, got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestIsLegacyQueryParamWrite(t *testing.T) {
+	defer func(old bool) { legacyQueryParamWrites = old }(legacyQueryParamWrites)
+
+	// JSON bodies are the current form, even with a query string
+	r := httptest.NewRequest(http.MethodPost, "/api/params?trace=1", strings.NewReader(`{}`))
+	r.Header.Set("Content-Type", "application/json; charset=utf-8")
+	w := httptest.NewRecorder()
+	if legacy, ok := isLegacyQueryParamWrite(w, r); legacy || !ok || w.Header().Get("Deprecation") != "" {
+		t.Errorf("Expected a JSON body to be the current form, got legacy=%v ok=%v", legacy, ok)
+	}
+
+	// Query parameters are accepted with a deprecation notice
+	legacyQueryParamWrites = true
+	w = httptest.NewRecorder()
+	if legacy, ok := isLegacyQueryParamWrite(w, httptest.NewRequest(http.MethodPost, "/api/runs?name=baseline", nil)); !legacy || !ok {
+		t.Errorf("Expected the legacy form to be accepted, got legacy=%v ok=%v", legacy, ok)
+	}
+	if w.Header().Get("Deprecation") == "" {
+		t.Error("Expected the legacy form to be announced as deprecated")
+	}
+
+	// ...until they are turned off
+	legacyQueryParamWrites = false
+	w = httptest.NewRecorder()
+	if _, ok := isLegacyQueryParamWrite(w, httptest.NewRequest(http.MethodPost, "/api/runs?name=baseline", nil)); ok || w.Code != http.StatusBadRequest {
+		t.Errorf("Expected the legacy form to be refused, got ok=%v status %d", ok, w.Code)
+	}
+}
@@ -0,0 +1,361 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// A minimal Arrow IPC stream writer, enough to send tables of non-null
+// columns to pandas and polars without a dependency. A stream is a schema
+// message, a record batch per arrowBatchRows rows, and an end-of-stream
+// marker; each message is a flatbuffer followed by its body. See
+// https://arrow.apache.org/docs/format/Columnar.html for the layout and
+// Schema.fbs and Message.fbs for the messages' fields.
+
+// arrowStreamMediaType is the media type clients ask for Arrow responses with
+const arrowStreamMediaType = "application/vnd.apache.arrow.stream"
+
+// Arrow column types
+const (
+	arrowInt64 = iota
+	arrowFloat64
+	arrowUTF8
+	arrowTimestampMillis
+)
+
+// arrowBatchRows is how many rows are buffered before they are written out
+// as a record batch
+const arrowBatchRows = 64 * 1024
+
+// Values of the Type and MessageHeader unions and other enums of the format
+const (
+	arrowTypeInt           = 2
+	arrowTypeFloatingPoint = 3
+	arrowTypeUtf8          = 5
+	arrowTypeTimestamp     = 10
+
+	arrowHeaderSchema      = 1
+	arrowHeaderRecordBatch = 3
+
+	arrowMetadataV5      = 4
+	arrowPrecisionDouble = 2
+	arrowUnitMillisecond = 1
+)
+
+// arrowContinuation starts every message of a stream
+const arrowContinuation = 0xFFFFFFFF
+
+// arrowColumn describes a non-null column of an Arrow stream
+type arrowColumn struct {
+	Name string
+	Type int
+}
+
+// arrowStreamWriter writes rows to an Arrow IPC stream. Close must be called
+// to write the last record batch and the end-of-stream marker.
+type arrowStreamWriter struct {
+	w       io.Writer
+	columns []arrowColumn
+	// values holds each column's fixed-width values, or its string data
+	values []bytes.Buffer
+	// offsets holds the end offsets of the strings of UTF-8 columns
+	offsets [][]int32
+	rows    int
+	err     error
+}
+
+// wantsArrowStream reports whether a request asks for an Arrow stream rather
+// than JSON
+func wantsArrowStream(r *http.Request) bool {
+	for _, accept := range r.Header.Values("Accept") {
+		for _, mediaRange := range strings.Split(accept, ",") {
+			mediaType, _, err := mime.ParseMediaType(mediaRange)
+			if err == nil && mediaType == arrowStreamMediaType {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// newArrowStreamWriter starts an Arrow stream with the given columns
+func newArrowStreamWriter(w io.Writer, columns []arrowColumn) *arrowStreamWriter {
+	aw := &arrowStreamWriter{
+		w:       w,
+		columns: columns,
+		values:  make([]bytes.Buffer, len(columns)),
+		offsets: make([][]int32, len(columns)),
+	}
+	fields := make([]fbTable, len(columns))
+	for i, c := range columns {
+		var typeType uint8
+		var typ fbTable
+		switch c.Type {
+		case arrowInt64:
+			typeType, typ = arrowTypeInt, fbTable{int32(64), true}
+		case arrowFloat64:
+			typeType, typ = arrowTypeFloatingPoint, fbTable{int16(arrowPrecisionDouble)}
+		case arrowUTF8:
+			typeType, typ = arrowTypeUtf8, fbTable{}
+		case arrowTimestampMillis:
+			typeType, typ = arrowTypeTimestamp, fbTable{int16(arrowUnitMillisecond), "UTC"}
+		}
+		// name, nullable, type_type, type, dictionary, children
+		fields[i] = fbTable{c.Name, false, typeType, typ, nil, []fbTable{}}
+	}
+	// endianness, fields
+	aw.writeMessage(arrowHeaderSchema, fbTable{int16(0), fields}, nil)
+	return aw
+}
+
+// WriteRow appends a row. Values must be int64 (epoch milliseconds for
+// timestamps), float64 or string, matching the types of the columns.
+func (aw *arrowStreamWriter) WriteRow(values ...any) error {
+	if len(values) != len(aw.columns) {
+		return fmt.Errorf("arrow: got %d values for %d columns", len(values), len(aw.columns))
+	}
+	for i, v := range values {
+		buf := &aw.values[i]
+		switch aw.columns[i].Type {
+		case arrowInt64, arrowTimestampMillis:
+			n, ok := v.(int64)
+			if !ok {
+				return fmt.Errorf("arrow: column %s needs an int64, got %T", aw.columns[i].Name, v)
+			}
+			buf.Write(binary.LittleEndian.AppendUint64(nil, uint64(n)))
+		case arrowFloat64:
+			f, ok := v.(float64)
+			if !ok {
+				return fmt.Errorf("arrow: column %s needs a float64, got %T", aw.columns[i].Name, v)
+			}
+			buf.Write(binary.LittleEndian.AppendUint64(nil, math.Float64bits(f)))
+		case arrowUTF8:
+			s, ok := v.(string)
+			if !ok {
+				return fmt.Errorf("arrow: column %s needs a string, got %T", aw.columns[i].Name, v)
+			}
+			buf.WriteString(s)
+			aw.offsets[i] = append(aw.offsets[i], int32(buf.Len()))
+		default:
+			return fmt.Errorf("arrow: unsupported type %d of column %s", aw.columns[i].Type, aw.columns[i].Name)
+		}
+	}
+	aw.rows++
+	if aw.rows >= arrowBatchRows {
+		return aw.flush()
+	}
+	return aw.err
+}
+
+// flush writes the buffered rows out as a record batch
+func (aw *arrowStreamWriter) flush() error {
+	if aw.rows == 0 {
+		return aw.err
+	}
+	var body []byte
+	var nodes, buffers []byte
+	// addBuffer appends a buffer to the body, 8-byte aligned as the format
+	// requires, and describes it in the batch's buffers
+	addBuffer := func(b []byte) {
+		buffers = binary.LittleEndian.AppendUint64(buffers, uint64(len(body)))
+		buffers = binary.LittleEndian.AppendUint64(buffers, uint64(len(b)))
+		body = append(body, b...)
+		for len(body)%8 != 0 {
+			body = append(body, 0)
+		}
+	}
+	for i, c := range aw.columns {
+		// length, null_count
+		nodes = binary.LittleEndian.AppendUint64(nodes, uint64(aw.rows))
+		nodes = binary.LittleEndian.AppendUint64(nodes, 0)
+		// No value is null, so the validity bitmap is left out
+		addBuffer(nil)
+		if c.Type == arrowUTF8 {
+			offsets := binary.LittleEndian.AppendUint32(nil, 0)
+			for _, o := range aw.offsets[i] {
+				offsets = binary.LittleEndian.AppendUint32(offsets, uint32(o))
+			}
+			addBuffer(offsets)
+			aw.offsets[i] = aw.offsets[i][:0]
+		}
+		addBuffer(aw.values[i].Bytes())
+		aw.values[i].Reset()
+	}
+	// length, nodes, buffers
+	aw.writeMessage(arrowHeaderRecordBatch, fbTable{int64(aw.rows), fbStructs(nodes), fbStructs(buffers)}, body)
+	aw.rows = 0
+	return aw.err
+}
+
+// writeMessage writes a message with the given header and body, framed as
+// the stream format requires: a continuation marker, the length of the
+// flatbuffer padded to 8 bytes, the flatbuffer and the body
+func (aw *arrowStreamWriter) writeMessage(headerType uint8, header fbTable, body []byte) {
+	// version, header_type, header, bodyLength
+	metadata := fbFinish(fbTable{int16(arrowMetadataV5), headerType, header, int64(len(body))})
+	for len(metadata)%8 != 0 {
+		metadata = append(metadata, 0)
+	}
+	prefix := binary.LittleEndian.AppendUint32(nil, arrowContinuation)
+	prefix = binary.LittleEndian.AppendUint32(prefix, uint32(len(metadata)))
+	aw.write(prefix)
+	aw.write(metadata)
+	aw.write(body)
+}
+
+func (aw *arrowStreamWriter) write(p []byte) {
+	if aw.err != nil || len(p) == 0 {
+		return
+	}
+	_, aw.err = aw.w.Write(p)
+}
+
+// Close writes the buffered rows and the end-of-stream marker
+func (aw *arrowStreamWriter) Close() error {
+	if err := aw.flush(); err != nil {
+		return err
+	}
+	eos := binary.LittleEndian.AppendUint32(nil, arrowContinuation)
+	aw.write(binary.LittleEndian.AppendUint32(eos, 0))
+	return aw.err
+}
+
+// Flatbuffers, the encoding of Arrow's messages. A table is a list of its
+// fields in schema order: nil for absent fields, bool, uint8, int16, int32 or
+// int64 scalars, or a string, table, vector of tables, or vector of structs
+// it refers to. Tables are written front to back, each preceded by its vtable
+// and followed by the objects it refers to, since offsets must point forward.
+
+type fbTable []any
+
+// fbStructs is a vector of 16-byte structs, such as Arrow's Buffer and
+// FieldNode, already laid out
+type fbStructs []byte
+
+// fbFinish encodes a flatbuffer with rootTable as its root
+func fbFinish(rootTable fbTable) []byte {
+	b := &fbBuilder{buf: make([]byte, 4)}
+	root := b.write(rootTable)
+	binary.LittleEndian.PutUint32(b.buf, uint32(root))
+	return b.buf
+}
+
+type fbBuilder struct {
+	buf []byte
+}
+
+// pad aligns the end of the buffer to n bytes, or to rem bytes past a
+// multiple of n
+func (b *fbBuilder) pad(n, rem int) {
+	for len(b.buf)%n != rem {
+		b.buf = append(b.buf, 0)
+	}
+}
+
+// write writes an object and returns where offsets to it should point
+func (b *fbBuilder) write(obj any) int {
+	switch o := obj.(type) {
+	case fbTable:
+		return b.writeTable(o)
+	case string:
+		b.pad(4, 0)
+		pos := len(b.buf)
+		b.buf = binary.LittleEndian.AppendUint32(b.buf, uint32(len(o)))
+		b.buf = append(append(b.buf, o...), 0)
+		return pos
+	case []fbTable:
+		b.pad(4, 0)
+		pos := len(b.buf)
+		b.buf = binary.LittleEndian.AppendUint32(b.buf, uint32(len(o)))
+		slots := len(b.buf)
+		b.buf = append(b.buf, make([]byte, 4*len(o))...)
+		for i, t := range o {
+			at := slots + 4*i
+			// Writing the table may grow the buffer, so it goes first
+			child := b.write(t)
+			binary.LittleEndian.PutUint32(b.buf[at:], uint32(child-at))
+		}
+		return pos
+	case fbStructs:
+		// The structs hold 8-byte fields, so they start 8-byte aligned
+		// after the vector's length
+		b.pad(8, 4)
+		pos := len(b.buf)
+		b.buf = binary.LittleEndian.AppendUint32(b.buf, uint32(len(o)/16))
+		b.buf = append(b.buf, o...)
+		return pos
+	}
+	panic(fmt.Sprintf("flatbuffers: cannot write %T", obj))
+}
+
+// fbFieldSize is how many bytes a field takes in its table, which it is also
+// aligned to. Objects are referred to by 4-byte offsets.
+func fbFieldSize(field any) int {
+	switch field.(type) {
+	case bool, uint8:
+		return 1
+	case int16:
+		return 2
+	case int64:
+		return 8
+	}
+	return 4
+}
+
+func (b *fbBuilder) writeTable(t fbTable) int {
+	// The table starts with the offset to its vtable, then its fields, each
+	// aligned to its size
+	offsets := make([]int, len(t))
+	size, align := 4, 4
+	for i, field := range t {
+		if field == nil {
+			continue
+		}
+		n := fbFieldSize(field)
+		size = (size + n - 1) / n * n
+		offsets[i] = size
+		size += n
+		align = max(align, n)
+	}
+
+	b.pad(2, 0)
+	vtable := len(b.buf)
+	b.buf = binary.LittleEndian.AppendUint16(b.buf, uint16(4+2*len(t)))
+	b.buf = binary.LittleEndian.AppendUint16(b.buf, uint16(size))
+	for _, offset := range offsets {
+		b.buf = binary.LittleEndian.AppendUint16(b.buf, uint16(offset))
+	}
+	b.pad(align, 0)
+	table := len(b.buf)
+	b.buf = append(b.buf, make([]byte, size)...)
+	binary.LittleEndian.PutUint32(b.buf[table:], uint32(int32(table-vtable)))
+
+	for i, field := range t {
+		at := table + offsets[i]
+		switch v := field.(type) {
+		case nil:
+		case bool:
+			if v {
+				b.buf[at] = 1
+			}
+		case uint8:
+			b.buf[at] = v
+		case int16:
+			binary.LittleEndian.PutUint16(b.buf[at:], uint16(v))
+		case int32:
+			binary.LittleEndian.PutUint32(b.buf[at:], uint32(v))
+		case int64:
+			binary.LittleEndian.PutUint64(b.buf[at:], uint64(v))
+		default:
+			child := b.write(v)
+			binary.LittleEndian.PutUint32(b.buf[at:], uint32(child-at))
+		}
+	}
+	return table
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// fbTestTable reads the fields of a flatbuffer table
+type fbTestTable struct {
+	buf []byte
+	pos int
+}
+
+func fbTestRoot(buf []byte) fbTestTable {
+	return fbTestTable{buf, int(binary.LittleEndian.Uint32(buf))}
+}
+
+// field returns where a field is stored, or 0 if it is absent
+func (t fbTestTable) field(i int) int {
+	vtable := t.pos - int(int32(binary.LittleEndian.Uint32(t.buf[t.pos:])))
+	if 4+2*i >= int(binary.LittleEndian.Uint16(t.buf[vtable:])) {
+		return 0
+	}
+	if offset := int(binary.LittleEndian.Uint16(t.buf[vtable+4+2*i:])); offset != 0 {
+		return t.pos + offset
+	}
+	return 0
+}
+
+func (t fbTestTable) ref(i int) int {
+	at := t.field(i)
+	return at + int(binary.LittleEndian.Uint32(t.buf[at:]))
+}
+
+func (t fbTestTable) table(i int) fbTestTable {
+	return fbTestTable{t.buf, t.ref(i)}
+}
+
+// arrowTestMessage is a message of an Arrow stream
+type arrowTestMessage struct {
+	headerType uint8
+	header     fbTestTable
+	body       []byte
+}
+
+// readArrowTestStream splits an Arrow stream into its messages, checking
+// their framing
+func readArrowTestStream(t *testing.T, data []byte) []arrowTestMessage {
+	t.Helper()
+	var messages []arrowTestMessage
+	for {
+		if len(data) < 8 || binary.LittleEndian.Uint32(data) != arrowContinuation {
+			t.Fatalf("Expected a continuation marker, got %x", data[:min(len(data), 8)])
+		}
+		size := int(binary.LittleEndian.Uint32(data[4:]))
+		data = data[8:]
+		if size == 0 {
+			break
+		}
+		if size%8 != 0 {
+			t.Errorf("Expected metadata padded to 8 bytes, got %d", size)
+		}
+		message := fbTestRoot(data[:size])
+		bodyLength := int(binary.LittleEndian.Uint64(data[message.field(3):]))
+		if version := binary.LittleEndian.Uint16(data[message.field(0):]); version != arrowMetadataV5 {
+			t.Errorf("Expected metadata version V5, got %d", version)
+		}
+		messages = append(messages, arrowTestMessage{
+			headerType: data[message.field(1)],
+			header:     message.table(2),
+			body:       data[size : size+bodyLength],
+		})
+		data = data[size+bodyLength:]
+	}
+	if len(data) != 0 {
+		t.Errorf("Expected the stream to end after the end-of-stream marker, %d bytes remain", len(data))
+	}
+	return messages
+}
+
+func TestArrowStreamWriter(t *testing.T) {
+	var buf bytes.Buffer
+	aw := newArrowStreamWriter(&buf, []arrowColumn{
+		{Name: "x", Type: arrowFloat64},
+		{Name: "run", Type: arrowUTF8},
+	})
+	rows := arrowBatchRows + 2
+	for i := range rows {
+		run := "a"
+		if i%2 == 1 {
+			run = "bcd"
+		}
+		if err := aw.WriteRow(float64(i), run); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := aw.WriteRow(1.0); err == nil {
+		t.Error("Expected a row with too few values to be refused")
+	}
+	if err := aw.WriteRow("1", "a"); err == nil {
+		t.Error("Expected a value of the wrong type to be refused")
+	}
+	if err := aw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	messages := readArrowTestStream(t, buf.Bytes())
+	if len(messages) != 3 || messages[0].headerType != arrowHeaderSchema || messages[1].headerType != arrowHeaderRecordBatch {
+		t.Fatalf("Expected a schema and two record batches, got %d messages", len(messages))
+	}
+	fields := messages[0].header.ref(1)
+	if n := binary.LittleEndian.Uint32(messages[0].header.buf[fields:]); n != 2 {
+		t.Errorf("Expected 2 fields in the schema, got %d", n)
+	}
+
+	// The last batch holds the two rows that did not fit in the first
+	last := messages[2]
+	if length := int64(binary.LittleEndian.Uint64(last.header.buf[last.header.field(0):])); length != 2 {
+		t.Errorf("Expected 2 rows in the last batch, got %d", length)
+	}
+	// Buffers are laid out x's validity and values, then run's validity,
+	// offsets and data, each 8-byte aligned
+	buffers := last.header.ref(2)
+	var layout [][2]uint64
+	for i := range int(binary.LittleEndian.Uint32(last.header.buf[buffers:])) {
+		at := buffers + 4 + 16*i
+		layout = append(layout, [2]uint64{binary.LittleEndian.Uint64(last.header.buf[at:]), binary.LittleEndian.Uint64(last.header.buf[at+8:])})
+	}
+	if len(layout) != 5 {
+		t.Fatalf("Expected 5 buffers, got %v", layout)
+	}
+	for _, b := range layout {
+		if b[0]%8 != 0 {
+			t.Errorf("Expected buffers to be 8-byte aligned, got %v", layout)
+		}
+	}
+	x := last.body[layout[1][0]:]
+	if math.Float64frombits(binary.LittleEndian.Uint64(x)) != float64(arrowBatchRows) {
+		t.Errorf("Unexpected first x of the last batch %v", math.Float64frombits(binary.LittleEndian.Uint64(x)))
+	}
+	offsets := last.body[layout[3][0]:]
+	if binary.LittleEndian.Uint32(offsets[4:]) != 1 || binary.LittleEndian.Uint32(offsets[8:]) != 4 {
+		t.Errorf("Unexpected string offsets %x", offsets[:12])
+	}
+	if data := last.body[layout[4][0] : layout[4][0]+layout[4][1]]; string(data) != "abcd" {
+		t.Errorf("Unexpected string data %q", data)
+	}
+}
+
+func TestWantsArrowStream(t *testing.T) {
+	for accept, want := range map[string]bool{
+		"":                                    false,
+		"application/json":                    false,
+		"application/vnd.apache.arrow.stream": true,
+		"application/vnd.apparatus.v1+json, application/vnd.apache.arrow.stream; q=0.9": true,
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/api/v1/search", nil)
+		if accept != "" {
+			r.Header.Set("Accept", accept)
+		}
+		if got := wantsArrowStream(r); got != want {
+			t.Errorf("wantsArrowStream(%q) = %v, want %v", accept, got, want)
+		}
+	}
+}
+
+// metricSeriesDAO knows a whole and a downsampled series
+type metricSeriesDAO struct {
+	DAO
+}
+
+func (d *metricSeriesDAO) GetRunIDByUUID(ctx context.Context, uuid string) (int, error) {
+	if uuid != "run-1" {
+		return 0, sql.ErrNoRows
+	}
+	return 1, nil
+}
+
+func (d *metricSeriesDAO) GetMetricSeries(ctx context.Context, runID int, key string) ([]MetricRow, error) {
+	loggedAt := time.UnixMilli(1700000000000)
+	return []MetricRow{{Key: key, XValue: 0, YValue: 1, LoggedAt: loggedAt}, {Key: key, XValue: 1, YValue: 0.5, LoggedAt: loggedAt}, {Key: key, XValue: 2, YValue: 0.25, LoggedAt: loggedAt}}, nil
+}
+
+func (d *metricSeriesDAO) GetMetricsDownsampled(ctx context.Context, runID int, key string, maxPoints int) ([]MetricRow, error) {
+	return []MetricRow{{Key: key, XValue: 0, YValue: 1, LoggedAt: time.UnixMilli(1700000000000)}, {Key: key, XValue: 2, YValue: 0.25, LoggedAt: time.UnixMilli(1700000060000)}}, nil
+}
+
+func (d *metricSeriesDAO) GetMetricGaps(ctx context.Context, runID int, key string, threshold time.Duration) ([]MetricGapRow, error) {
+	return nil, nil
+}
+
+func TestHandleAPIRunMetricSeriesArrow(t *testing.T) {
+	defer func(d DAO) { dao = d }(dao)
+	dao = &metricSeriesDAO{}
+
+	series := func(url string) (*httptest.ResponseRecorder, []arrowTestMessage) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, url, nil)
+		r.Header.Set("Accept", arrowStreamMediaType)
+		handleAPIRunMetricSeries(w, r, "run-1", "loss")
+		if w.Code != http.StatusOK || w.Header().Get("Content-Type") != arrowStreamMediaType {
+			t.Fatalf("Expected an Arrow stream, got %d %s: %s", w.Code, w.Header().Get("Content-Type"), w.Body)
+		}
+		return w, readArrowTestStream(t, w.Body.Bytes())
+	}
+	batchLength := func(m arrowTestMessage) int64 {
+		return int64(binary.LittleEndian.Uint64(m.header.buf[m.header.field(0):]))
+	}
+
+	// The whole series is sent unless max_points is given
+	_, messages := series("/api/v1/runs/run-1/metrics/loss")
+	if len(messages) != 2 || batchLength(messages[1]) != 3 {
+		t.Fatalf("Expected the whole series in one batch, got %d messages", len(messages))
+	}
+	// x, y and logged_at, each with an empty validity buffer
+	body := messages[1].body
+	if y := math.Float64frombits(binary.LittleEndian.Uint64(body[24+8:])); y != 0.5 {
+		t.Errorf("Unexpected second y %v", y)
+	}
+	if loggedAt := int64(binary.LittleEndian.Uint64(body[48:])); loggedAt != 1700000000000 {
+		t.Errorf("Unexpected logged_at %d", loggedAt)
+	}
+
+	_, messages = series("/api/v1/runs/run-1/metrics/loss?max_points=4")
+	if batchLength(messages[1]) != 2 {
+		t.Errorf("Expected the downsampled series, got %d rows", batchLength(messages[1]))
+	}
+
+	// JSON is still the default, with when each point was logged
+	w := httptest.NewRecorder()
+	handleAPIRunMetricSeries(w, httptest.NewRequest(http.MethodGet, "/api/v1/runs/run-1/metrics/loss", nil), "run-1", "loss")
+	if w.Header().Get("Content-Type") != "application/json" || !bytes.Contains(w.Body.Bytes(), []byte(`"x":[0,2]`)) {
+		t.Errorf("Expected the downsampled series as JSON, got %s", w.Body)
+	}
+	if !bytes.Contains(w.Body.Bytes(), []byte(`"time":[1700000000000,1700000060000]`)) {
+		t.Errorf("Expected the times the points were logged, got %s", w.Body)
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// writeArtifactsArchive writes a zip of the current contents of artifacts,
+// named by their paths
+func writeArtifactsArchive(ctx context.Context, w io.Writer, artifacts []ArtifactRow) error {
+	zw := zip.NewWriter(w)
+	if err := addArtifactsToArchive(ctx, zw, artifacts, ""); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// addArtifactsToArchive adds the current contents of artifacts to a zip,
+// named by their paths beneath dir. Prior versions are left out, as are
+// artifacts the malware scanner holds back.
+func addArtifactsToArchive(ctx context.Context, zw *zip.Writer, artifacts []ArtifactRow, dir string) error {
+	for _, a := range artifacts {
+		if !artifactDownloadable(a) {
+			continue
+		}
+		if err := addArtifactToArchive(ctx, zw, a, dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addArtifactToArchive adds the current contents of an artifact to a zip
+func addArtifactToArchive(ctx context.Context, zw *zip.Writer, a ArtifactRow, dir string) error {
+	entry, err := zw.CreateHeader(&zip.FileHeader{
+		Name:     dir + a.Path,
+		Method:   zip.Deflate,
+		Modified: a.UpdatedAt.Time,
+	})
+	if err != nil {
+		return err
+	}
+	file, err := openArtifact(ctx, a.URI)
+	if err != nil {
+		return fmt.Errorf("opening %s: %w", a.Path, err)
+	}
+	defer file.Close()
+	if _, err := io.Copy(entry, file); err != nil {
+		return fmt.Errorf("archiving %s: %w", a.Path, err)
+	}
+	return nil
+}
+
+// selectArtifacts picks the artifacts at paths, each naming an artifact or a
+// directory of them, in the order artifacts are given. Paths that name
+// nothing are an error.
+func selectArtifacts(artifacts []ArtifactRow, paths []string) ([]ArtifactRow, error) {
+	selected := make(map[string]bool, len(artifacts))
+	for _, p := range paths {
+		p = strings.TrimSuffix(p, "/")
+		if p == "" {
+			return nil, fmt.Errorf("empty path")
+		}
+		found := false
+		for _, a := range artifacts {
+			if a.Path == p || strings.HasPrefix(a.Path, p+"/") {
+				selected[a.Path] = true
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("no artifact or directory at %q", p)
+		}
+	}
+	var picked []ArtifactRow
+	for _, a := range artifacts {
+		if selected[a.Path] {
+			picked = append(picked, a)
+		}
+	}
+	return picked, nil
+}
+
+// handleAPIRunArtifactsArchive streams a zip of a run's artifact tree, at
+// /api/runs/{uuid}/artifacts/archive, or of only the artifacts and
+// directories given as paths[]. Long selections may be POSTed as a form. It
+// counts against the artifact serving limits like any other download.
+func handleAPIRunArtifactsArchive(w http.ResponseWriter, r *http.Request, runUUID string) {
+	ctx := r.Context()
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to parse form"})
+		return
+	}
+
+	run, err := dao.GetRunByUUID(ctx, runUUID)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Run not found"})
+		return
+	}
+	runID, err := dao.GetRunIDByUUID(ctx, runUUID)
+	if err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Run not found"})
+		return
+	}
+	artifacts, err := dao.GetArtifactsByRunID(ctx, runID)
+	if err != nil {
+		log.Printf("Failed to list artifacts of run %s: %v", runUUID, err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to list artifacts"})
+		return
+	}
+	if paths := r.Form["paths[]"]; len(paths) > 0 {
+		artifacts, err = selectArtifacts(artifacts, paths)
+		if err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": fmt.Sprintf("Invalid selection: %v", err)})
+			return
+		}
+	}
+
+	release, ok := artifactServeLimiter.acquire(ctx)
+	if !ok {
+		log.Printf("Turned away artifact archive of run %s: artifact serving is saturated", runUUID)
+		artifactServeLimiter.rejectSaturated(w)
+		return
+	}
+	defer release()
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": run.Name + "-artifacts.zip"}))
+	// The archive is streamed as it is built, so a failure part way through
+	// can only cut it short
+	if err := writeArtifactsArchive(ctx, artifactServeLimiter.throttle(ctx, w), artifacts); err != nil {
+		log.Printf("Failed to archive artifacts of run %s: %v", runUUID, err)
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"database/sql"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// archiveDAO knows a single run
+type archiveDAO struct {
+	DAO
+}
+
+func (d *archiveDAO) GetRunByUUID(ctx context.Context, uuid string) (*Run, error) {
+	if uuid != "run-1" {
+		return nil, sql.ErrNoRows
+	}
+	return &Run{UUID: uuid, Name: "baseline"}, nil
+}
+
+func (d *archiveDAO) GetRunIDByUUID(ctx context.Context, uuid string) (int, error) {
+	if uuid != "run-1" {
+		return 0, sql.ErrNoRows
+	}
+	return 1, nil
+}
+
+// GetArtifactsByRunID lists model.pt at its second version
+func (d *archiveDAO) GetArtifactsByRunID(ctx context.Context, runID int) ([]ArtifactRow, error) {
+	return []ArtifactRow{
+		{Path: "model.pt", URI: "run-1/~versions/2/model.pt", Version: 2},
+		{Path: "plots/loss.png", URI: "run-1/plots/loss.png", Version: 1},
+	}, nil
+}
+
+func TestHandleAPIRunArtifactsArchive(t *testing.T) {
+	defer func(d DAO) { dao = d }(dao)
+	defer func(s ArtifactStore) { artifactStore = s }(artifactStore)
+	dao = &archiveDAO{}
+	store, err := newFileArtifactStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	artifactStore = store
+	files := map[string]string{
+		"run-1/model.pt":             "first weights",
+		"run-1/~versions/2/model.pt": "weights",
+		"run-1/plots/loss.png":       "png",
+		"run-10/other-run.txt":       "not this run",
+		"run-2/unrelated/run.md":     "nor this one",
+	}
+	for key, content := range files {
+		if _, err := store.Put(key, strings.NewReader(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	handleAPIV1Runs(w, httptest.NewRequest(http.MethodGet, "/api/runs/run-1/artifacts/archive", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body)
+	}
+	if cd := w.Header().Get("Content-Disposition"); cd != `attachment; filename=baseline-artifacts.zip` {
+		t.Errorf("Content-Disposition = %q", cd)
+	}
+
+	archive, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	if err != nil {
+		t.Fatalf("Invalid zip: %v", err)
+	}
+	var names []string
+	for _, f := range archive.File {
+		names = append(names, f.Name)
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, _ := io.ReadAll(r)
+		r.Close()
+		if want := map[string]string{"model.pt": "weights", "plots/loss.png": "png"}[f.Name]; string(content) != want {
+			t.Errorf("Expected the current version of %s, got %q", f.Name, content)
+		}
+	}
+	slices.Sort(names)
+	if !slices.Equal(names, []string{"model.pt", "plots/loss.png"}) {
+		t.Errorf("Unexpected archive entries %v", names)
+	}
+
+	w = httptest.NewRecorder()
+	handleAPIV1Runs(w, httptest.NewRequest(http.MethodGet, "/api/runs/missing/artifacts/archive", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a missing run, got %d", w.Code)
+	}
+}
+
+func TestSelectArtifacts(t *testing.T) {
+	artifacts := []ArtifactRow{{Path: "model.pt"}, {Path: "plots/acc.png"}, {Path: "plots/loss.png"}, {Path: "plotsmore.txt"}}
+	paths := func(rows []ArtifactRow) []string {
+		var out []string
+		for _, a := range rows {
+			out = append(out, a.Path)
+		}
+		return out
+	}
+
+	for _, tt := range []struct {
+		selection []string
+		want      []string
+	}{
+		{[]string{"plots"}, []string{"plots/acc.png", "plots/loss.png"}},
+		{[]string{"plots/"}, []string{"plots/acc.png", "plots/loss.png"}},
+		{[]string{"plots/loss.png", "model.pt", "plots"}, []string{"model.pt", "plots/acc.png", "plots/loss.png"}},
+		{[]string{"plotsmore.txt"}, []string{"plotsmore.txt"}},
+	} {
+		selected, err := selectArtifacts(artifacts, tt.selection)
+		if err != nil || !slices.Equal(paths(selected), tt.want) {
+			t.Errorf("selectArtifacts(%v) = %v, %v, want %v", tt.selection, paths(selected), err, tt.want)
+		}
+	}
+	for _, invalid := range [][]string{{"plo"}, {"missing.txt"}, {""}, {"/"}} {
+		if _, err := selectArtifacts(artifacts, invalid); err == nil {
+			t.Errorf("Expected an error selecting %v", invalid)
+		}
+	}
+}
+
+func TestHandleAPIRunArtifactsArchiveSelection(t *testing.T) {
+	defer func(d DAO) { dao = d }(dao)
+	defer func(s ArtifactStore) { artifactStore = s }(artifactStore)
+	dao = &archiveDAO{}
+	store, err := newFileArtifactStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	artifactStore = store
+	for _, key := range []string{"run-1/~versions/2/model.pt", "run-1/plots/loss.png"} {
+		if _, err := store.Put(key, strings.NewReader(key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	archive := func(r *http.Request) (int, []string) {
+		w := httptest.NewRecorder()
+		handleAPIV1Runs(w, r)
+		if w.Code != http.StatusOK {
+			return w.Code, nil
+		}
+		zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+		if err != nil {
+			t.Fatalf("Invalid zip: %v", err)
+		}
+		var names []string
+		for _, f := range zr.File {
+			names = append(names, f.Name)
+		}
+		return w.Code, names
+	}
+
+	if code, names := archive(httptest.NewRequest(http.MethodGet, "/api/runs/run-1/artifacts/archive?paths[]=plots/", nil)); code != http.StatusOK || !slices.Equal(names, []string{"plots/loss.png"}) {
+		t.Errorf("Expected only the plots directory, got %d %v", code, names)
+	}
+
+	// Selections may be posted from the artifacts tab's form
+	form := url.Values{"paths[]": {"model.pt", "plots/loss.png"}}
+	r := httptest.NewRequest(http.MethodPost, "/api/runs/run-1/artifacts/archive", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if code, names := archive(r); code != http.StatusOK || !slices.Equal(names, []string{"model.pt", "plots/loss.png"}) {
+		t.Errorf("Expected both selected artifacts, got %d %v", code, names)
+	}
+
+	if code, _ := archive(httptest.NewRequest(http.MethodGet, "/api/runs/run-1/artifacts/archive?paths[]=missing", nil)); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a selection of nothing, got %d", code)
+	}
+}
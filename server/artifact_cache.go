@@ -0,0 +1,390 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// With -artifact-cache-dir, artifacts in object storage are cached on local
+// disk as the web UI views them, so that showing the same images and plots
+// again reads them from disk instead of fetching them from S3 (or GCS, through
+// its S3-compatible API) every time. Downloads of cached artifacts are served
+// from the cache rather than redirected to presigned URLs; artifacts larger
+// than -artifact-cache-max-object-size are not cached, and still are. Once the
+// cache holds more than -artifact-cache-size bytes, the least recently viewed
+// artifacts are evicted.
+//
+// Writes through the server invalidate the artifacts they replace. With
+// -multi-instance, each write is also recorded in the database, and the other
+// replicas drop the artifact written from their caches before they next serve
+// one. Replicas re-read the records of the last few minutes each time, since
+// a write may commit after later ones; a replica that has not caught up for
+// longer than the records are kept drops its whole cache instead. The cache starts empty each time the
+// server does, since artifacts may have been overwritten while it was down.
+
+var (
+	// artifactCacheDir is the directory artifacts are cached in, or empty if
+	// the cache is disabled
+	artifactCacheDir string
+	// artifactCacheSize is the most bytes the cache holds
+	artifactCacheSize int64 = 1 << 30
+	// artifactCacheMaxObjectSize is the size of the largest artifact cached
+	artifactCacheMaxObjectSize int64 = 64 << 20
+)
+
+// artifactCacheInvalidationExpiry is how long the artifacts written on one
+// replica are recorded for the others to drop from their caches
+const artifactCacheInvalidationExpiry = time.Hour
+
+// artifactCacheInvalidationSlack is how far back replicas re-read the
+// artifacts written on other replicas. It must be longer than recording a
+// write can take to commit, plus how far apart replicas' clocks may be.
+const artifactCacheInvalidationSlack = 5 * time.Minute
+
+// errArtifactTooLargeToCache is returned for artifacts larger than the
+// cache's maximum object size, which are read from their store instead
+var errArtifactTooLargeToCache = errors.New("artifact is too large to cache")
+
+// artifactCacheFilePattern matches the names of the files the cache keeps
+// artifacts and artifacts being fetched in
+var artifactCacheFilePattern = regexp.MustCompile(`^([0-9a-f]{64}|fetch-[0-9]+)$`)
+
+// artifactCache is the artifact cache, or nil if it is disabled
+var artifactCache *artifactDiskCache
+
+// artifactCacheEntry is a cached artifact, stored in the file of its name
+type artifactCacheEntry struct {
+	name string
+	size int64
+}
+
+// artifactDiskCache keeps recently viewed artifacts in files named after the
+// hash of their URI
+type artifactDiskCache struct {
+	dir           string
+	maxSize       int64
+	maxObjectSize int64
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	// lru orders the entries from the most to the least recently used
+	lru  *list.List
+	size int64
+	// generation counts invalidations, so that an artifact fetched while it
+	// was being written is not cached
+	generation int64
+	// applied holds when the invalidations recorded by replicas that the
+	// cache has applied were recorded, by ID, and syncedAt is when it last
+	// caught up
+	applied  map[int64]time.Time
+	syncedAt time.Time
+}
+
+// newArtifactCache creates a cache in dir, emptying it of the files of a
+// previous cache
+func newArtifactCache(dir string, maxSize, maxObjectSize int64) (*artifactDiskCache, error) {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		if !f.IsDir() && artifactCacheFilePattern.MatchString(f.Name()) {
+			if err := os.Remove(filepath.Join(dir, f.Name())); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return &artifactDiskCache{
+		dir:           dir,
+		maxSize:       maxSize,
+		maxObjectSize: min(maxObjectSize, maxSize),
+		entries:       make(map[string]*list.Element),
+		lru:           list.New(),
+		applied:       make(map[int64]time.Time),
+	}, nil
+}
+
+// artifactCacheFileName names the file an artifact recorded under uri is
+// cached in
+func artifactCacheFileName(uri string) string {
+	sum := sha256.Sum256([]byte(uri))
+	return hex.EncodeToString(sum[:])
+}
+
+// syncShared drops the entries that replicas have written or deleted since
+// the cache last caught up, or every entry if it has been too long to tell
+func (c *artifactDiskCache) syncShared(ctx context.Context) error {
+	if !multiInstance {
+		return nil
+	}
+	// Invalidations are not cursored by ID, since IDs are handed out before
+	// the writes recording them commit, possibly out of order
+	now := time.Now().UTC()
+	c.mu.Lock()
+	var since time.Time
+	if !c.syncedAt.IsZero() {
+		since = c.syncedAt.Add(-artifactCacheInvalidationSlack)
+	}
+	c.mu.Unlock()
+	invalidations, err := dao.GetArtifactCacheInvalidations(ctx, since)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.syncedAt.IsZero() && now.Sub(c.syncedAt)+artifactCacheInvalidationSlack > artifactCacheInvalidationExpiry {
+		c.generation++
+		for c.lru.Len() > 0 {
+			c.remove(c.lru.Back())
+		}
+	}
+	for _, inv := range invalidations {
+		if _, ok := c.applied[inv.ID]; ok {
+			continue
+		}
+		c.generation++
+		if elem, ok := c.entries[artifactCacheFileName(inv.URI)]; ok {
+			c.remove(elem)
+		}
+		c.applied[inv.ID] = inv.InvalidatedAt
+	}
+	for id, at := range c.applied {
+		if at.Before(since) {
+			delete(c.applied, id)
+		}
+	}
+	c.syncedAt = now
+	return nil
+}
+
+// lookup opens the cached artifact of a file name, marking it most recently
+// used
+func (c *artifactDiskCache) lookup(name string) (*os.File, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[name]
+	if !ok {
+		return nil, false
+	}
+	file, err := os.Open(filepath.Join(c.dir, name))
+	if err != nil {
+		// Removed from beneath the cache
+		c.remove(elem)
+		return nil, false
+	}
+	c.lru.MoveToFront(elem)
+	return file, true
+}
+
+// add caches the fetched artifact in tmp as name, unless the cache has been
+// invalidated since generation, evicting the least recently used artifacts
+// to make room
+func (c *artifactDiskCache) add(name, tmp string, size, generation int64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.generation != generation {
+		return os.Remove(tmp)
+	}
+	if elem, ok := c.entries[name]; ok {
+		// Fetched by another request at the same time
+		c.size -= elem.Value.(artifactCacheEntry).size
+		c.lru.Remove(elem)
+		delete(c.entries, name)
+	}
+	if err := os.Rename(tmp, filepath.Join(c.dir, name)); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	c.entries[name] = c.lru.PushFront(artifactCacheEntry{name: name, size: size})
+	c.size += size
+	for c.size > c.maxSize && c.lru.Len() > 1 {
+		c.remove(c.lru.Back())
+		serverStats.artifactCacheEvictions.Add(1)
+	}
+	return nil
+}
+
+// remove drops an entry and its file. c.mu must be held.
+func (c *artifactDiskCache) remove(elem *list.Element) {
+	entry := c.lru.Remove(elem).(artifactCacheEntry)
+	delete(c.entries, entry.name)
+	c.size -= entry.size
+	if err := os.Remove(filepath.Join(c.dir, entry.name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("Failed to remove cached artifact %s: %v", entry.name, err)
+	}
+}
+
+// invalidate drops the cached artifact recorded under uri, and keeps
+// artifacts being fetched from being cached
+func (c *artifactDiskCache) invalidate(uri string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.generation++
+	if elem, ok := c.entries[artifactCacheFileName(uri)]; ok {
+		c.remove(elem)
+	}
+}
+
+// usage returns the number of cached artifacts and their total size
+func (c *artifactDiskCache) usage() (int, int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len(), c.size
+}
+
+// cachingArtifactStore wraps an object store so that artifacts viewed through
+// openCached are cached, and writes through it invalidate them
+type cachingArtifactStore struct {
+	ArtifactStore
+	cache *artifactDiskCache
+}
+
+// invalidate drops the cached artifact stored under key on this server, and
+// records it for the other replicas to drop if there are any
+func (s *cachingArtifactStore) invalidate(key string) {
+	uri := s.URI(key)
+	s.cache.invalidate(uri)
+	if !multiInstance {
+		return
+	}
+	ctx := context.Background()
+	now := time.Now().UTC()
+	if err := dao.InsertArtifactCacheInvalidation(ctx, uri, now); err != nil {
+		log.Printf("Failed to invalidate %s in the artifact caches of other replicas: %v", uri, err)
+	}
+	if err := dao.DeleteArtifactCacheInvalidations(ctx, now.Add(-artifactCacheInvalidationExpiry)); err != nil {
+		log.Printf("Failed to delete expired artifact cache invalidations: %v", err)
+	}
+}
+
+func (s *cachingArtifactStore) Put(key string, r io.Reader) (int64, error) {
+	// Invalidated again once written, in case it was fetched meanwhile
+	s.cache.invalidate(s.URI(key))
+	defer s.invalidate(key)
+	return s.ArtifactStore.Put(key, r)
+}
+
+func (s *cachingArtifactStore) GetContext(ctx context.Context, key string) (io.ReadCloser, error) {
+	return getArtifact(ctx, s.ArtifactStore, key)
+}
+
+func (s *cachingArtifactStore) Delete(key string) error {
+	s.cache.invalidate(s.URI(key))
+	defer s.invalidate(key)
+	return s.ArtifactStore.Delete(key)
+}
+
+// openCached opens the contents stored under key from the cache, fetching
+// them into it if they are not cached yet. It returns
+// errArtifactTooLargeToCache for contents too large to cache.
+func (s *cachingArtifactStore) openCached(ctx context.Context, key string) (*os.File, error) {
+	c := s.cache
+	if err := c.syncShared(ctx); err != nil {
+		return nil, err
+	}
+	name := artifactCacheFileName(s.URI(key))
+	if file, ok := c.lookup(name); ok {
+		serverStats.artifactCacheHits.Add(1)
+		return file, nil
+	}
+	serverStats.artifactCacheMisses.Add(1)
+
+	size, err := s.Size(key)
+	if err != nil {
+		return nil, err
+	}
+	if size > c.maxObjectSize {
+		return nil, errArtifactTooLargeToCache
+	}
+	c.mu.Lock()
+	generation := c.generation
+	c.mu.Unlock()
+
+	src, err := getArtifact(ctx, s.ArtifactStore, key)
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+	tmp, err := os.CreateTemp(c.dir, "fetch-*")
+	if err != nil {
+		return nil, err
+	}
+	n, err := io.Copy(tmp, io.LimitReader(src, c.maxObjectSize+1))
+	if err == nil && n > c.maxObjectSize {
+		// Overwritten with larger contents since its size was read
+		err = errArtifactTooLargeToCache
+	}
+	if err == nil {
+		_, err = tmp.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+	serverStats.artifactCacheFetchedBytes.Add(n)
+
+	// The open file is still read from once renamed into the cache, or
+	// removed if it cannot be cached
+	if err := c.add(name, tmp.Name(), n, generation); err != nil {
+		log.Printf("Failed to cache artifact %s: %v", key, err)
+	}
+	return tmp, nil
+}
+
+// openViewedArtifact opens the contents stored under key in a store for the
+// web UI to show, through the artifact cache if the store is cached
+func openViewedArtifact(ctx context.Context, store ArtifactStore, key string) (io.ReadCloser, error) {
+	if cached, ok := store.(*cachingArtifactStore); ok {
+		file, err := cached.openCached(ctx, key)
+		if !errors.Is(err, errArtifactTooLargeToCache) {
+			if err != nil {
+				return nil, err
+			}
+			return file, nil
+		}
+	}
+	return getArtifact(ctx, store, key)
+}
+
+// initArtifactCache creates the artifact cache if -artifact-cache-dir is set,
+// and caches the artifact store and mirror if they are object stores
+func initArtifactCache() {
+	if artifactCacheDir == "" {
+		return
+	}
+	var err error
+	artifactCache, err = newArtifactCache(artifactCacheDir, artifactCacheSize, artifactCacheMaxObjectSize)
+	if err != nil {
+		log.Fatalf("Could not create artifact cache: %v", err)
+	}
+
+	cached := false
+	if artifactStoreDistance(artifactStore) > 0 {
+		artifactStore = &cachingArtifactStore{ArtifactStore: artifactStore, cache: artifactCache}
+		cached = true
+	}
+	if artifactMirror != nil && artifactStoreDistance(artifactMirror) > 0 {
+		artifactMirror = &cachingArtifactStore{ArtifactStore: artifactMirror, cache: artifactCache}
+		cached = true
+	}
+	if !cached {
+		log.Printf("Warning: -artifact-cache-dir is set, but artifacts are stored on local disk already and will not be cached")
+		return
+	}
+	log.Printf("Artifact cache initialized at %s, holding up to %s", artifactCacheDir, formatBytes(artifactCacheSize))
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// objectArtifactStore stands in for an object store, counting the artifacts
+// fetched from it and presigning URLs
+type objectArtifactStore struct {
+	*fileArtifactStore
+	gets int
+}
+
+func (s *objectArtifactStore) Get(key string) (io.ReadCloser, error) {
+	s.gets++
+	return s.fileArtifactStore.Get(key)
+}
+
+func (s *objectArtifactStore) PresignGet(key string, expires time.Duration) (string, error) {
+	return "https://bucket.example.com/" + key + "?signature=x", nil
+}
+
+func newCachedObjectStore(t *testing.T, maxSize, maxObjectSize int64) (*cachingArtifactStore, *objectArtifactStore) {
+	t.Helper()
+	files, err := newFileArtifactStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache, err := newArtifactCache(t.TempDir(), maxSize, maxObjectSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	object := &objectArtifactStore{fileArtifactStore: files}
+	return &cachingArtifactStore{ArtifactStore: object, cache: cache}, object
+}
+
+func readCached(t *testing.T, store *cachingArtifactStore, key string) string {
+	t.Helper()
+	file, err := store.openCached(context.Background(), key)
+	if err != nil {
+		t.Fatalf("openCached(%s) failed: %v", key, err)
+	}
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestArtifactCache(t *testing.T) {
+	store, object := newCachedObjectStore(t, 1<<20, 1<<10)
+	if _, err := store.Put("run/plot.png", strings.NewReader("v1")); err != nil {
+		t.Fatal(err)
+	}
+
+	hits, misses := serverStats.artifactCacheHits.Load(), serverStats.artifactCacheMisses.Load()
+	if got := readCached(t, store, "run/plot.png"); got != "v1" || object.gets != 1 {
+		t.Fatalf("Expected the artifact fetched, got %q after %d fetches", got, object.gets)
+	}
+	if got := readCached(t, store, "run/plot.png"); got != "v1" || object.gets != 1 {
+		t.Errorf("Expected the artifact viewed again from the cache, got %q after %d fetches", got, object.gets)
+	}
+	if serverStats.artifactCacheHits.Load()-hits != 1 || serverStats.artifactCacheMisses.Load()-misses != 1 {
+		t.Errorf("Expected a miss then a hit counted")
+	}
+	if entries, size := store.cache.usage(); entries != 1 || size != 2 {
+		t.Errorf("Expected 1 artifact of 2 bytes cached, got %d of %d", entries, size)
+	}
+
+	// Writes through the store invalidate what they replace
+	if _, err := store.Put("run/plot.png", strings.NewReader("v2")); err != nil {
+		t.Fatal(err)
+	}
+	if got := readCached(t, store, "run/plot.png"); got != "v2" || object.gets != 2 {
+		t.Errorf("Expected the overwritten artifact fetched again, got %q after %d fetches", got, object.gets)
+	}
+	if err := store.Delete("run/plot.png"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.openCached(context.Background(), "run/plot.png"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Expected a deleted artifact not found, got %v", err)
+	}
+
+	// Artifacts too large to cache are left to their store
+	if _, err := store.Put("run/model.pkl", strings.NewReader(strings.Repeat("w", 2<<10))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.openCached(context.Background(), "run/model.pkl"); !errors.Is(err, errArtifactTooLargeToCache) {
+		t.Errorf("Expected errArtifactTooLargeToCache, got %v", err)
+	}
+	r, err := openViewedArtifact(context.Background(), store, "run/model.pkl")
+	if err != nil {
+		t.Fatalf("Expected a large artifact viewed from its store, got %v", err)
+	}
+	r.Close()
+	if entries, _ := store.cache.usage(); entries != 0 {
+		t.Errorf("Expected nothing cached, got %d artifacts", entries)
+	}
+}
+
+func TestArtifactCacheEviction(t *testing.T) {
+	store, object := newCachedObjectStore(t, 10, 10)
+	for _, key := range []string{"run/a", "run/b", "run/c"} {
+		if _, err := store.Put(key, strings.NewReader("1234")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	evictions := serverStats.artifactCacheEvictions.Load()
+	readCached(t, store, "run/a")
+	readCached(t, store, "run/b")
+	readCached(t, store, "run/a")
+	// Making room for c evicts b, the least recently viewed
+	readCached(t, store, "run/c")
+	if entries, size := store.cache.usage(); entries != 2 || size != 8 {
+		t.Errorf("Expected 2 artifacts of 8 bytes cached, got %d of %d", entries, size)
+	}
+	if serverStats.artifactCacheEvictions.Load()-evictions != 1 {
+		t.Errorf("Expected an eviction counted")
+	}
+	gets := object.gets
+	readCached(t, store, "run/a")
+	readCached(t, store, "run/c")
+	if object.gets != gets {
+		t.Errorf("Expected a and c still cached")
+	}
+	readCached(t, store, "run/b")
+	if object.gets != gets+1 {
+		t.Errorf("Expected b fetched again")
+	}
+	if files, _ := os.ReadDir(store.cache.dir); len(files) != 2 {
+		t.Errorf("Expected the evicted artifacts' files removed, got %d files", len(files))
+	}
+}
+
+func TestArtifactCacheFetchDuringWrite(t *testing.T) {
+	store, _ := newCachedObjectStore(t, 1<<20, 1<<20)
+	c := store.cache
+	tmp := filepath.Join(c.dir, "fetch-1")
+	if err := os.WriteFile(tmp, []byte("old"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// An artifact written while it was being fetched is not cached
+	generation := c.generation
+	c.invalidate(store.URI("run/plot.png"))
+	if err := c.add(artifactCacheFileName(store.URI("run/plot.png")), tmp, 3, generation); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := c.usage(); entries != 0 {
+		t.Errorf("Expected the stale fetch not cached, got %d artifacts", entries)
+	}
+	if _, err := os.Stat(tmp); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Expected the stale fetch removed, got %v", err)
+	}
+}
+
+// artifactCacheDAO records the artifacts replicas invalidate
+type artifactCacheDAO struct {
+	DAO
+	invalidations []ArtifactCacheInvalidationRow
+}
+
+func (d *artifactCacheDAO) InsertArtifactCacheInvalidation(ctx context.Context, uri string, at time.Time) error {
+	d.invalidations = append(d.invalidations, ArtifactCacheInvalidationRow{ID: int64(len(d.invalidations) + 1), URI: uri, InvalidatedAt: at})
+	return nil
+}
+
+func (d *artifactCacheDAO) GetArtifactCacheInvalidations(ctx context.Context, since time.Time) ([]ArtifactCacheInvalidationRow, error) {
+	var invalidations []ArtifactCacheInvalidationRow
+	for _, inv := range d.invalidations {
+		if !inv.InvalidatedAt.Before(since) {
+			invalidations = append(invalidations, inv)
+		}
+	}
+	return invalidations, nil
+}
+
+func (d *artifactCacheDAO) DeleteArtifactCacheInvalidations(ctx context.Context, before time.Time) error {
+	return nil
+}
+
+func TestArtifactCacheSharedInvalidation(t *testing.T) {
+	defer func(d DAO, m bool) { dao, multiInstance = d, m }(dao, multiInstance)
+	d := &artifactCacheDAO{}
+	dao, multiInstance = d, true
+
+	// Two replicas caching the same object store
+	writer, object := newCachedObjectStore(t, 1<<20, 1<<10)
+	cache, err := newArtifactCache(t.TempDir(), 1<<20, 1<<10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader := &cachingArtifactStore{ArtifactStore: object, cache: cache}
+	for _, key := range []string{"run/plot.png", "run/loss.svg"} {
+		if _, err := writer.Put(key, strings.NewReader("v1")); err != nil {
+			t.Fatal(err)
+		}
+		readCached(t, reader, key)
+	}
+	if len(d.invalidations) != 2 || d.invalidations[0].URI != object.URI("run/plot.png") {
+		t.Fatalf("Expected each write recorded for the other replicas, got %+v", d.invalidations)
+	}
+
+	// Only the artifact written is dropped from the other replica's cache
+	if _, err := writer.Put("run/plot.png", strings.NewReader("v2")); err != nil {
+		t.Fatal(err)
+	}
+	gets := object.gets
+	if got := readCached(t, reader, "run/plot.png"); got != "v2" || object.gets != gets+1 {
+		t.Errorf("Expected the overwritten artifact fetched again, got %q", got)
+	}
+	if got := readCached(t, reader, "run/loss.svg"); got != "v1" || object.gets != gets+1 {
+		t.Errorf("Expected other artifacts still cached, got %q after %d fetches", got, object.gets-gets)
+	}
+	if readCached(t, reader, "run/plot.png"); object.gets != gets+1 {
+		t.Errorf("Expected an invalidation applied only once, got %d fetches", object.gets-gets)
+	}
+
+	// A write that commits after later ones, with a lower ID and an earlier
+	// time than what the replica has already seen, is still applied
+	if _, err := object.Put("run/loss.svg", strings.NewReader("v2")); err != nil {
+		t.Fatal(err)
+	}
+	late := ArtifactCacheInvalidationRow{ID: 0, URI: object.URI("run/loss.svg"), InvalidatedAt: cache.syncedAt.Add(-time.Minute)}
+	d.invalidations = append([]ArtifactCacheInvalidationRow{late}, d.invalidations...)
+	if got := readCached(t, reader, "run/loss.svg"); got != "v2" || object.gets != gets+2 {
+		t.Errorf("Expected the artifact of a late invalidation fetched again, got %q", got)
+	}
+
+	// A replica that has not caught up for too long cannot tell what was
+	// written, and drops everything
+	cache.syncedAt = time.Now().Add(-2 * artifactCacheInvalidationExpiry)
+	readCached(t, reader, "run/loss.svg")
+	if object.gets != gets+3 {
+		t.Errorf("Expected the cache dropped after falling behind")
+	}
+}
+
+func TestNewArtifactCacheEmptiesDir(t *testing.T) {
+	dir := t.TempDir()
+	stale := filepath.Join(dir, artifactCacheFileName("s3://bucket/run/plot.png"))
+	other := filepath.Join(dir, "notes.txt")
+	for _, path := range []string{stale, other, filepath.Join(dir, "fetch-123")} {
+		if err := os.WriteFile(path, []byte("x"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := newArtifactCache(dir, 1<<20, 1<<10); err != nil {
+		t.Fatal(err)
+	}
+	files, _ := os.ReadDir(dir)
+	if len(files) != 1 || files[0].Name() != "notes.txt" {
+		t.Errorf("Expected only the previous cache's files removed, got %v", files)
+	}
+}
+
+// blobDAO reports every artifact unscanned
+type blobDAO struct {
+	DAO
+}
+
+func (d *blobDAO) GetArtifactScanStatusByURI(ctx context.Context, uri string) (string, string, error) {
+	return "", "", nil
+}
+
+func TestHandleServeArtifactBlobCached(t *testing.T) {
+	defer func(d DAO, s, m ArtifactStore, scanner ArtifactScanner) {
+		dao, artifactStore, artifactMirror, artifactScanner = d, s, m, scanner
+	}(dao, artifactStore, artifactMirror, artifactScanner)
+	store, object := newCachedObjectStore(t, 1<<20, 1<<10)
+	dao, artifactStore, artifactMirror, artifactScanner = &blobDAO{}, store, nil, nil
+	if _, err := store.Put("run/plot.png", strings.NewReader("png")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Put("run/model.pkl", strings.NewReader(strings.Repeat("w", 2<<10))); err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(key string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handleServeArtifactBlob(w, httptest.NewRequest(http.MethodGet, "/artifacts/blob?uri="+store.URI(key), nil))
+		return w
+	}
+	for i := 0; i < 2; i++ {
+		if w := get("run/plot.png"); w.Code != http.StatusOK || w.Body.String() != "png" {
+			t.Fatalf("Expected the plot served, got %d %q", w.Code, w.Body)
+		}
+	}
+	if object.gets != 1 {
+		t.Errorf("Expected the plot fetched from object storage once, got %d", object.gets)
+	}
+	// Artifacts too large to cache are still served from presigned URLs
+	if w := get("run/model.pkl"); w.Code != http.StatusFound || !strings.HasPrefix(w.Header().Get("Location"), "https://bucket.example.com/run/model.pkl") {
+		t.Errorf("Expected a redirect to a presigned URL, got %d %s", w.Code, w.Header().Get("Location"))
+	}
+	if w := get("run/missing.png"); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a missing artifact, got %d", w.Code)
+	}
+}
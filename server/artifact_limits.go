@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Limits on streaming artifact blobs, so that a few large downloads cannot
+// starve ingestion of connections, disk, and network bandwidth
+var (
+	// artifactServeConcurrency is how many artifact blobs are streamed at
+	// once (0 is unlimited)
+	artifactServeConcurrency = 4
+	// artifactServeQueueLength is how many downloads can wait for a slot
+	// before the server turns them away
+	artifactServeQueueLength = 16
+	// artifactServeQueueTimeout is how long a download waits for a slot
+	artifactServeQueueTimeout = 30 * time.Second
+	// artifactServeBandwidth is the total rate artifact blobs are streamed at,
+	// in bytes per second (0 is unlimited)
+	artifactServeBandwidth int64
+)
+
+// artifactServeLimiter limits the /artifacts/blob endpoint, or is nil if
+// artifact serving is unlimited
+var artifactServeLimiter *serveLimiter
+
+func initArtifactServeLimits() {
+	artifactServeLimiter = newServeLimiter(artifactServeConcurrency, artifactServeQueueLength, artifactServeQueueTimeout, artifactServeBandwidth)
+	if artifactServeConcurrency > 0 {
+		log.Printf("Artifact serving limited to %d concurrent downloads", artifactServeConcurrency)
+	}
+	if artifactServeBandwidth > 0 {
+		log.Printf("Artifact serving limited to %d bytes/s", artifactServeBandwidth)
+	}
+}
+
+// serveLimiter bounds how many requests an endpoint serves at once, queuing
+// a bounded number of the rest, and the bandwidth of their responses
+type serveLimiter struct {
+	// slots holds a token for each request being served, or is nil if
+	// concurrency is unlimited
+	slots        chan struct{}
+	queueTimeout time.Duration
+
+	mu       sync.Mutex
+	queued   int
+	maxQueue int
+
+	bandwidth *bandwidthLimiter
+}
+
+// newServeLimiter returns a limiter serving up to concurrency requests at
+// once and bytesPerSecond in total, or nil if both are unlimited (0)
+func newServeLimiter(concurrency, maxQueue int, queueTimeout time.Duration, bytesPerSecond int64) *serveLimiter {
+	if concurrency <= 0 && bytesPerSecond <= 0 {
+		return nil
+	}
+	l := &serveLimiter{maxQueue: maxQueue, queueTimeout: queueTimeout}
+	if concurrency > 0 {
+		l.slots = make(chan struct{}, concurrency)
+	}
+	if bytesPerSecond > 0 {
+		l.bandwidth = &bandwidthLimiter{bytesPerSecond: bytesPerSecond}
+	}
+	return l
+}
+
+// acquire waits for a slot to serve a request in, returning a function that
+// releases it, or false if the queue is full or the wait timed out
+func (l *serveLimiter) acquire(ctx context.Context) (func(), bool) {
+	if l == nil || l.slots == nil {
+		return func() {}, true
+	}
+	release := func() { <-l.slots }
+	select {
+	case l.slots <- struct{}{}:
+		return release, true
+	default:
+	}
+
+	l.mu.Lock()
+	if l.queued >= l.maxQueue {
+		l.mu.Unlock()
+		return nil, false
+	}
+	l.queued++
+	l.mu.Unlock()
+	defer func() {
+		l.mu.Lock()
+		l.queued--
+		l.mu.Unlock()
+	}()
+
+	timer := time.NewTimer(l.queueTimeout)
+	defer timer.Stop()
+	select {
+	case l.slots <- struct{}{}:
+		return release, true
+	case <-timer.C:
+		return nil, false
+	case <-ctx.Done():
+		return nil, false
+	}
+}
+
+// retryAfter is how long a client turned away should wait before retrying
+func (l *serveLimiter) retryAfter() time.Duration {
+	return max(l.queueTimeout, time.Second)
+}
+
+// rejectSaturated responds that the server is too busy to serve the request
+func (l *serveLimiter) rejectSaturated(w http.ResponseWriter) {
+	seconds := int(math.Ceil(l.retryAfter().Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	http.Error(w, "Too many concurrent downloads, try again later", http.StatusServiceUnavailable)
+}
+
+// throttle wraps w so that its writes share the limiter's bandwidth
+func (l *serveLimiter) throttle(ctx context.Context, w http.ResponseWriter) http.ResponseWriter {
+	if l == nil || l.bandwidth == nil {
+		return w
+	}
+	return &throttledResponseWriter{ResponseWriter: w, ctx: ctx, bandwidth: l.bandwidth}
+}
+
+// bandwidthLimiter paces writes so that together they average at most
+// bytesPerSecond
+type bandwidthLimiter struct {
+	bytesPerSecond int64
+
+	mu sync.Mutex
+	// next is when the bandwidth already reserved has been used up
+	next time.Time
+}
+
+// bandwidthChunkDuration is roughly how long each throttled write takes, so
+// that concurrent downloads interleave smoothly
+const bandwidthChunkDuration = 100 * time.Millisecond
+
+// chunkSize is the largest write that is paced as a unit
+func (b *bandwidthLimiter) chunkSize() int {
+	return int(max(b.bytesPerSecond*int64(bandwidthChunkDuration)/int64(time.Second), 1))
+}
+
+// wait reserves bandwidth for n bytes and waits until they can be sent
+func (b *bandwidthLimiter) wait(ctx context.Context, n int) error {
+	b.mu.Lock()
+	now := time.Now()
+	if b.next.Before(now) {
+		b.next = now
+	}
+	delay := b.next.Sub(now)
+	b.next = b.next.Add(time.Duration(int64(n) * int64(time.Second) / b.bytesPerSecond))
+	b.mu.Unlock()
+
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// throttledResponseWriter writes a response at the pace of a shared
+// bandwidthLimiter
+type throttledResponseWriter struct {
+	http.ResponseWriter
+	ctx       context.Context
+	bandwidth *bandwidthLimiter
+}
+
+func (w *throttledResponseWriter) Write(p []byte) (int, error) {
+	written := 0
+	chunkSize := w.bandwidth.chunkSize()
+	for len(p) > 0 {
+		chunk := p[:min(len(p), chunkSize)]
+		if err := w.bandwidth.wait(w.ctx, len(chunk)); err != nil {
+			return written, err
+		}
+		n, err := w.ResponseWriter.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[len(chunk):]
+	}
+	return written, nil
+}
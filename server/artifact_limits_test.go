@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServeLimiterAcquire(t *testing.T) {
+	l := newServeLimiter(1, 1, 50*time.Millisecond, 0)
+
+	release, ok := l.acquire(context.Background())
+	if !ok {
+		t.Fatal("Expected the first request to be served")
+	}
+
+	// A second request queues and is served once the first finishes
+	acquired := make(chan bool)
+	go func() {
+		release2, ok := l.acquire(context.Background())
+		if ok {
+			release2()
+		}
+		acquired <- ok
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	// The queue is full, so a third is turned away immediately
+	if _, ok := l.acquire(context.Background()); ok {
+		t.Error("Expected a request beyond the queue to be turned away")
+	}
+
+	release()
+	if !<-acquired {
+		t.Error("Expected the queued request to be served")
+	}
+
+	// A request that waits longer than the queue timeout is turned away
+	release, _ = l.acquire(context.Background())
+	defer release()
+	start := time.Now()
+	if _, ok := l.acquire(context.Background()); ok {
+		t.Error("Expected the queued request to time out")
+	}
+	if waited := time.Since(start); waited < 50*time.Millisecond {
+		t.Errorf("Expected the request to wait out the queue timeout, waited %v", waited)
+	}
+}
+
+func TestServeLimiterUnlimited(t *testing.T) {
+	l := newServeLimiter(0, 0, time.Second, 0)
+	if l != nil {
+		t.Fatalf("Expected no limiter when unlimited, got %+v", l)
+	}
+	for i := 0; i < 10; i++ {
+		if _, ok := l.acquire(context.Background()); !ok {
+			t.Fatal("Expected an unlimited limiter to serve every request")
+		}
+	}
+	w := httptest.NewRecorder()
+	if l.throttle(context.Background(), w) != http.ResponseWriter(w) {
+		t.Error("Expected an unlimited limiter not to throttle responses")
+	}
+}
+
+func TestServeLimiterRejectSaturated(t *testing.T) {
+	l := newServeLimiter(1, 0, 1500*time.Millisecond, 0)
+	w := httptest.NewRecorder()
+	l.rejectSaturated(w)
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != "2" {
+		t.Errorf("Expected 503 with Retry-After 2, got %d %q", w.Code, w.Header().Get("Retry-After"))
+	}
+}
+
+func TestThrottledResponseWriter(t *testing.T) {
+	l := newServeLimiter(0, 0, 0, 1000)
+	w := httptest.NewRecorder()
+	body := strings.Repeat("x", 300)
+
+	// 300 bytes at 1000 bytes/s in 100 byte chunks: the first is sent
+	// immediately and the rest are paced
+	start := time.Now()
+	n, err := l.throttle(context.Background(), w).Write([]byte(body))
+	if err != nil || n != len(body) || w.Body.String() != body {
+		t.Fatalf("Write = %d, %v; wrote %q", n, err, w.Body.String())
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("Expected the write to be paced to at least 200ms, took %v", elapsed)
+	}
+
+	// A canceled request stops waiting for bandwidth
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := l.throttle(ctx, httptest.NewRecorder()).Write([]byte(body)); err == nil {
+		t.Error("Expected a write for a canceled request to fail")
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+)
+
+// Mirror statuses of an artifact
+const (
+	artifactMirrorPending  = "pending"
+	artifactMirrorMirrored = "mirrored"
+	artifactMirrorFailed   = "failed"
+)
+
+const (
+	// artifactMirrorBatchSize is how many artifacts the mirroring job copies
+	// between checks for new ones
+	artifactMirrorBatchSize = 100
+	// artifactMirrorMaxAttempts is how many times the mirroring job tries to
+	// copy an artifact before leaving it failed
+	artifactMirrorMaxAttempts = 5
+)
+
+// artifactMirror is the secondary store artifacts are replicated to for
+// durability, or nil if mirroring is disabled. It holds each artifact under
+// the same key as the primary store.
+var artifactMirror ArtifactStore
+
+// artifactMirrorInterval is how often the mirroring job looks for artifacts
+// to copy
+var artifactMirrorInterval = time.Minute
+
+func initArtifactMirror(uri string) {
+	if uri == "" {
+		return
+	}
+	var err error
+	artifactMirror, err = newArtifactStore(uri)
+	if err != nil {
+		log.Fatalf("Could not create artifact mirror: %v", err)
+	}
+
+	log.Printf("Artifact mirror initialized at: %s", uri)
+}
+
+// artifactStoreDistance ranks stores by how costly they are to read from:
+// local files are nearer than object stores
+func artifactStoreDistance(store ArtifactStore) int {
+	if _, ok := store.(*fileArtifactStore); ok {
+		return 0
+	}
+	return 1
+}
+
+// nearestFirst orders stores by distance, keeping the given order among
+// stores at the same distance
+func nearestFirst(stores []ArtifactStore) []ArtifactStore {
+	sort.SliceStable(stores, func(i, j int) bool {
+		return artifactStoreDistance(stores[i]) < artifactStoreDistance(stores[j])
+	})
+	return stores
+}
+
+// artifactCopies returns the stores holding the contents of the artifact
+// recorded under uri, nearest first. The mirror is included once the artifact
+// has been copied to it.
+func artifactCopies(ctx context.Context, uri string) []ArtifactStore {
+	stores := []ArtifactStore{artifactStore}
+	if artifactMirror == nil {
+		return stores
+	}
+	status, err := dao.GetArtifactMirrorStatusByURI(ctx, uri)
+	if err != nil {
+		log.Printf("Failed to query mirror status of artifact %s: %v", uri, err)
+		return stores
+	}
+	if status == artifactMirrorMirrored {
+		stores = append(stores, artifactMirror)
+	}
+	return nearestFirst(stores)
+}
+
+// copyArtifact copies the contents stored under key from one store to another
+func copyArtifact(from, to ArtifactStore, key string) error {
+	src, err := from.Get(key)
+	if err != nil {
+		return fmt.Errorf("reading artifact: %w", err)
+	}
+	defer src.Close()
+	if _, err := to.Put(key, src); err != nil {
+		return fmt.Errorf("writing artifact to mirror: %w", err)
+	}
+	return nil
+}
+
+// mirrorPendingArtifacts copies a batch of artifacts that are not yet in the
+// mirror to it, recording the outcome for each. It reports whether another
+// batch should follow immediately: failures wait for the next interval, so a
+// mirror outage does not use up every artifact's attempts at once.
+func mirrorPendingArtifacts(ctx context.Context) (bool, error) {
+	pending, err := dao.GetArtifactsToMirror(ctx, artifactMirrorMaxAttempts, artifactMirrorBatchSize)
+	if err != nil {
+		return false, err
+	}
+	failed := false
+	for _, m := range pending {
+		status, mirrorError := artifactMirrorMirrored, ""
+		key, err := artifactKey(m.URI)
+		if err == nil {
+			err = copyArtifact(artifactStore, artifactMirror, key)
+		}
+		if err != nil {
+			log.Printf("Failed to mirror artifact %s (attempt %d of %d): %v", m.URI, m.Attempts+1, artifactMirrorMaxAttempts, err)
+			status, mirrorError = artifactMirrorFailed, err.Error()
+			failed = true
+		}
+		if err := dao.SetArtifactMirrorStatus(ctx, m, status, mirrorError); err != nil {
+			return false, err
+		}
+	}
+	return len(pending) == artifactMirrorBatchSize && !failed, nil
+}
+
+// startArtifactMirror periodically replicates new and overwritten artifacts
+// to the mirror store
+func startArtifactMirror(ctx context.Context) {
+	if artifactMirror == nil {
+		return
+	}
+	go func() {
+		for {
+			for holdJobLease(ctx, "artifact-mirror", 2*artifactMirrorInterval) {
+				more, err := mirrorPendingArtifacts(ctx)
+				if err != nil {
+					log.Printf("Failed to mirror artifacts: %v", err)
+				}
+				if !more {
+					break
+				}
+			}
+			time.Sleep(artifactMirrorInterval)
+		}
+	}()
+}
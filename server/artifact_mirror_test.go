@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"strings"
+	"testing"
+)
+
+func TestNearestFirst(t *testing.T) {
+	s3 := &s3ArtifactStore{bucket: "bucket"}
+	local := &fileArtifactStore{root: "artifacts"}
+	mirror := &fileArtifactStore{root: "mirror"}
+
+	tests := []struct {
+		name   string
+		stores []ArtifactStore
+		want   []ArtifactStore
+	}{
+		{"local primary, S3 mirror", []ArtifactStore{local, s3}, []ArtifactStore{local, s3}},
+		{"S3 primary, local mirror", []ArtifactStore{s3, local}, []ArtifactStore{local, s3}},
+		{"both local keeps primary first", []ArtifactStore{local, mirror}, []ArtifactStore{local, mirror}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := nearestFirst(tt.stores)
+			for i := range tt.want {
+				if got[i] != tt.want[i] {
+					t.Fatalf("nearestFirst() = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestCopyArtifact(t *testing.T) {
+	from, err := newFileArtifactStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	to, err := newFileArtifactStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := from.Put("run/model.pkl", strings.NewReader("weights")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := copyArtifact(from, to, "run/model.pkl"); err != nil {
+		t.Fatalf("copyArtifact failed: %v", err)
+	}
+	r, err := to.Get("run/model.pkl")
+	if err != nil {
+		t.Fatalf("mirrored artifact not found: %v", err)
+	}
+	defer r.Close()
+	if data, _ := io.ReadAll(r); string(data) != "weights" {
+		t.Errorf("mirrored artifact = %q, want %q", data, "weights")
+	}
+
+	if err := copyArtifact(from, to, "run/missing.pkl"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("copyArtifact of a missing artifact = %v, want fs.ErrNotExist", err)
+	}
+}
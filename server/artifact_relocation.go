@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// Artifact relocation rewrites the URIs artifacts are recorded under after
+// their contents move, e.g. to a new artifact root directory or bucket. Each
+// rewritten URI is resolved against the artifact store and recorded in the
+// store's own form, which for file stores is relative to the root, so that
+// moving the root again needs no relocation.
+
+// ArtifactURIMapping rewrites artifact URIs starting with From to start with
+// To instead
+type ArtifactURIMapping struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// RelocatedArtifactURI is an artifact URI a relocation rewrites
+type RelocatedArtifactURI struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	// Missing is set if the artifact store has nothing under the new URI
+	Missing bool `json:"missing,omitempty"`
+	// Error is why a URI the mappings rewrite is left as it is
+	Error string `json:"error,omitempty"`
+}
+
+// ArtifactRelocation is what a relocation rewrote, or would rewrite in a dry run
+type ArtifactRelocation struct {
+	DryRun   bool                 `json:"dry_run"`
+	Mappings []ArtifactURIMapping `json:"mappings"`
+	// Relocated are the URIs rewritten, and Unresolved those left as they are
+	// because their new URIs are outside the artifact store
+	Relocated  []RelocatedArtifactURI `json:"relocated"`
+	Unresolved []RelocatedArtifactURI `json:"unresolved"`
+	// Missing counts the relocated URIs the artifact store has nothing under
+	Missing int `json:"missing"`
+	// Artifacts counts the artifacts and prior versions rewritten
+	Artifacts int `json:"artifacts"`
+}
+
+// parseArtifactURIMapping parses a mapping written OLD=NEW
+func parseArtifactURIMapping(s string) (ArtifactURIMapping, error) {
+	from, to, ok := strings.Cut(s, "=")
+	if !ok || from == "" {
+		return ArtifactURIMapping{}, fmt.Errorf("expected OLD=NEW, got %q", s)
+	}
+	return ArtifactURIMapping{From: from, To: to}, nil
+}
+
+// relocateArtifactURI rewrites a URI with the mapping of the longest prefix
+// it starts with, reporting whether any did
+func relocateArtifactURI(uri string, mappings []ArtifactURIMapping) (string, bool) {
+	var best *ArtifactURIMapping
+	for i, m := range mappings {
+		if strings.HasPrefix(uri, m.From) && (best == nil || len(m.From) > len(best.From)) {
+			best = &mappings[i]
+		}
+	}
+	if best == nil {
+		return "", false
+	}
+	return best.To + strings.TrimPrefix(uri, best.From), true
+}
+
+// relocateArtifacts rewrites the URIs of every artifact and prior version
+// according to mappings. Mappings may rewrite URIs to bare keys, which are
+// recorded under the artifact store's URI for them. A dry run only plans the
+// rewrites.
+func relocateArtifacts(ctx context.Context, mappings []ArtifactURIMapping, dryRun bool) (*ArtifactRelocation, error) {
+	if len(mappings) == 0 {
+		return nil, errors.New("at least one mapping is required")
+	}
+	for _, m := range mappings {
+		if m.From == "" {
+			return nil, errors.New("mappings must rewrite a non-empty prefix")
+		}
+	}
+
+	uris, err := dao.GetAllArtifactURIs(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("listing artifact URIs: %w", err)
+	}
+	sort.Strings(uris)
+	relocation := &ArtifactRelocation{DryRun: dryRun, Mappings: mappings, Relocated: []RelocatedArtifactURI{}, Unresolved: []RelocatedArtifactURI{}}
+	for _, uri := range uris {
+		rewritten, ok := relocateArtifactURI(uri, mappings)
+		if !ok {
+			continue
+		}
+		key, err := artifactKey(rewritten)
+		if err != nil {
+			relocation.Unresolved = append(relocation.Unresolved, RelocatedArtifactURI{From: uri, To: rewritten, Error: err.Error()})
+			continue
+		}
+		item := RelocatedArtifactURI{From: uri, To: artifactStore.URI(key)}
+		if item.To == uri {
+			continue
+		}
+		if _, err := artifactStore.Size(key); errors.Is(err, fs.ErrNotExist) {
+			item.Missing = true
+			relocation.Missing++
+		} else if err != nil {
+			return nil, fmt.Errorf("checking %s: %w", item.To, err)
+		}
+		relocation.Relocated = append(relocation.Relocated, item)
+		if dryRun {
+			continue
+		}
+		n, err := dao.RelocateArtifactURI(ctx, item.From, item.To)
+		if err != nil {
+			return nil, fmt.Errorf("relocating %s: %w", uri, err)
+		}
+		relocation.Artifacts += n
+	}
+
+	if !dryRun && len(relocation.Relocated) > 0 {
+		if err := recordAudit(ctx, "relocate_artifacts", "", map[string]interface{}{
+			"mappings":  mappings,
+			"relocated": len(relocation.Relocated),
+			"artifacts": relocation.Artifacts,
+			"missing":   relocation.Missing,
+		}); err != nil {
+			log.Printf("Failed to record artifact relocation in the audit log: %v", err)
+		}
+	}
+	return relocation, nil
+}
+
+// writeArtifactRelocation prints a relocation as a table of rewritten URIs
+func writeArtifactRelocation(w io.Writer, relocation *ArtifactRelocation) {
+	verb := "Relocated"
+	if relocation.DryRun {
+		verb = "Would relocate"
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, item := range relocation.Relocated {
+		note := ""
+		if item.Missing {
+			note = "missing from the artifact store"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", item.From, item.To, note)
+	}
+	for _, item := range relocation.Unresolved {
+		fmt.Fprintf(tw, "%s\t%s\tleft as is: %s\n", item.From, item.To, item.Error)
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "\n%s %d URIs", verb, len(relocation.Relocated))
+	if relocation.Missing > 0 {
+		fmt.Fprintf(w, ", %d of them missing from the artifact store", relocation.Missing)
+	}
+	fmt.Fprintln(w)
+	if len(relocation.Unresolved) > 0 {
+		fmt.Fprintf(w, "Left %d URIs outside the artifact store as they are\n", len(relocation.Unresolved))
+	}
+}
+
+// runRelocateArtifactsCommand implements "apparatus-server relocate-artifacts",
+// which rewrites artifact URIs after the artifact store moves
+func runRelocateArtifactsCommand(ctx context.Context, args []string) {
+	flags := flag.NewFlagSet("relocate-artifacts", flag.ExitOnError)
+	configPath := defineConfigFlag(flags)
+	dbConnString := flags.String("db", "sqlite:///apparatus.db", "Database connection string (e.g., sqlite:///path/to/db.db)")
+	artifactStoreURI := flags.String("artifact-store-uri", "file://artifacts", "URI for location to store artifacts (e.g. file:///path/to/artifacts or s3://bucket/prefix)")
+	dryRun := flags.Bool("dry-run", false, "List the URIs that would be rewritten without changing them")
+	jsonOutput := flags.Bool("json", false, "Print the relocation as JSON")
+	var mappings []ArtifactURIMapping
+	flags.Func("map", "Rewrite artifact URIs starting with OLD to start with NEW, as OLD=NEW (repeatable)", func(s string) error {
+		m, err := parseArtifactURIMapping(s)
+		if err == nil {
+			mappings = append(mappings, m)
+		}
+		return err
+	})
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s relocate-artifacts -map OLD=NEW [-map OLD=NEW ...] [flags]\n\nRewrites the URIs artifacts are recorded under after their contents move, e.g.\n\n  %s relocate-artifacts -artifact-store-uri file:///data/artifacts -map file:///old/artifacts/=file:///data/artifacts/\n\nRewritten URIs must resolve to the artifact store, and are recorded relative to file store roots.\n\n", os.Args[0], os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if len(mappings) == 0 || flags.NArg() != 0 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	if _, err := loadServerConfig(flags, *configPath); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+
+	initDB(*dbConnString)
+	initArtifactStore(*artifactStoreURI)
+
+	relocation, err := relocateArtifacts(ctx, mappings, *dryRun)
+	if err != nil {
+		log.Fatalf("Relocation failed: %v", err)
+	}
+	if *jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(relocation)
+	} else {
+		writeArtifactRelocation(os.Stdout, relocation)
+	}
+}
+
+// RelocateArtifactsRequest is the body of POST /api/admin/artifacts/relocate
+type RelocateArtifactsRequest struct {
+	Mappings []ArtifactURIMapping `json:"mappings"`
+	DryRun   bool                 `json:"dry_run"`
+}
+
+// handleAPIRelocateArtifacts rewrites artifact URIs at POST
+// /api/admin/artifacts/relocate, taking JSON {mappings: [{from, to}], dry_run}
+func handleAPIRelocateArtifacts(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	var req RelocateArtifactsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid JSON"})
+		return
+	}
+	if len(req.Mappings) == 0 || slices.ContainsFunc(req.Mappings, func(m ArtifactURIMapping) bool { return m.From == "" }) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "mappings must list at least one {from, to} with a non-empty from"})
+		return
+	}
+
+	relocation, err := relocateArtifacts(r.Context(), req.Mappings, req.DryRun)
+	if err != nil {
+		log.Printf("Failed to relocate artifacts: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to relocate artifacts"})
+		return
+	}
+	json.NewEncoder(w).Encode(relocation)
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// relocationDAO records artifacts by their URIs
+type relocationDAO struct {
+	DAO
+	uris    []string
+	audited []string
+}
+
+func (d *relocationDAO) GetAllArtifactURIs(ctx context.Context) ([]string, error) {
+	return slices.Clone(d.uris), nil
+}
+
+func (d *relocationDAO) RelocateArtifactURI(ctx context.Context, from, to string) (int, error) {
+	n := 0
+	for i, uri := range d.uris {
+		if uri == from {
+			d.uris[i] = to
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (d *relocationDAO) InsertAuditLogEntry(ctx context.Context, e AuditLogRow) error {
+	d.audited = append(d.audited, e.Action)
+	return nil
+}
+
+func TestParseArtifactURIMapping(t *testing.T) {
+	if m, err := parseArtifactURIMapping("file:///old/=file:///new/"); err != nil || m.From != "file:///old/" || m.To != "file:///new/" {
+		t.Errorf("Unexpected mapping %+v (%v)", m, err)
+	}
+	if m, err := parseArtifactURIMapping("file:///old/="); err != nil || m.To != "" {
+		t.Errorf("Expected a mapping to bare keys, got %+v (%v)", m, err)
+	}
+	for _, s := range []string{"file:///old/", "=file:///new/"} {
+		if _, err := parseArtifactURIMapping(s); err == nil {
+			t.Errorf("Expected %q to be rejected", s)
+		}
+	}
+}
+
+func TestRelocateArtifacts(t *testing.T) {
+	ctx := t.Context()
+	defer func(d DAO, s ArtifactStore) { dao, artifactStore = d, s }(dao, artifactStore)
+	store, err := newFileArtifactStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	artifactStore = store
+	store.Put("run-1/model.pt", strings.NewReader("weights"))
+	store.Put("run-2/plot.png", strings.NewReader("png"))
+	root := "file://" + filepath.ToSlash(store.root) + "/"
+
+	d := &relocationDAO{uris: []string{
+		"file:///old/root/run-1/model.pt",
+		"file:///old/root/run-1/missing.pt",
+		"file:///old/root/scratch/run-2/plot.png",
+		"file:///elsewhere/run-3/a.txt",
+		"file://run-4/kept.txt",
+	}}
+	dao = d
+	mappings := []ArtifactURIMapping{
+		{From: "file:///old/root/", To: root},
+		// The longest prefix a URI starts with wins
+		{From: "file:///old/root/scratch/", To: ""},
+		{From: "file:///elsewhere/", To: "file:///nowhere/"},
+	}
+
+	plan, err := relocateArtifacts(ctx, mappings, true)
+	if err != nil {
+		t.Fatalf("relocateArtifacts failed: %v", err)
+	}
+	if len(plan.Relocated) != 3 || plan.Missing != 1 || len(plan.Unresolved) != 1 || plan.Artifacts != 0 {
+		t.Errorf("Unexpected plan %+v", plan)
+	}
+	if d.uris[0] != "file:///old/root/run-1/model.pt" || len(d.audited) != 0 {
+		t.Errorf("Expected a dry run to change nothing, got %v %v", d.uris, d.audited)
+	}
+
+	relocation, err := relocateArtifacts(ctx, mappings, false)
+	if err != nil {
+		t.Fatalf("relocateArtifacts failed: %v", err)
+	}
+	if relocation.Artifacts != 3 || len(d.audited) != 1 || d.audited[0] != "relocate_artifacts" {
+		t.Errorf("Unexpected relocation %+v, audited %v", relocation, d.audited)
+	}
+	want := []string{"file://run-1/model.pt", "file://run-1/missing.pt", "file://run-2/plot.png", "file:///elsewhere/run-3/a.txt", "file://run-4/kept.txt"}
+	for i, uri := range want {
+		if d.uris[i] != uri {
+			t.Errorf("Expected URI %d to be %s, got %s", i, uri, d.uris[i])
+		}
+	}
+	for _, item := range relocation.Relocated {
+		if item.Missing != (item.To == "file://run-1/missing.pt") {
+			t.Errorf("Unexpected missing flag on %+v", item)
+		}
+	}
+
+	// Relocated URIs are relative, so relocating again changes nothing
+	if again, err := relocateArtifacts(ctx, mappings, false); err != nil || len(again.Relocated) != 0 {
+		t.Errorf("Expected nothing left to relocate, got %+v (%v)", again, err)
+	}
+}
+
+func TestHandleAPIRelocateArtifacts(t *testing.T) {
+	defer func(d DAO, s ArtifactStore, token string) { dao, artifactStore, adminToken = d, s, token }(dao, artifactStore, adminToken)
+	store, err := newFileArtifactStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	artifactStore = store
+	adminToken = "admin"
+	d := &relocationDAO{uris: []string{"file:///old/run-1/model.pt"}}
+	dao = d
+
+	post := func(body string, authorized bool) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/api/admin/artifacts/relocate", bytes.NewBufferString(body))
+		if authorized {
+			r.Header.Set("Authorization", "Bearer admin")
+		}
+		w := httptest.NewRecorder()
+		handleAPIRelocateArtifacts(w, r)
+		return w
+	}
+
+	if w := post(`{"mappings": [{"from": "file:///old/", "to": ""}]}`, false); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without the admin token, got %d", w.Code)
+	}
+	for _, body := range []string{`{"mappings": []}`, `{"mappings": [{"from": "", "to": "file://"}]}`, `not json`} {
+		if w := post(body, true); w.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for %s, got %d", body, w.Code)
+		}
+	}
+
+	w := post(`{"mappings": [{"from": "file:///old/", "to": ""}], "dry_run": true}`, true)
+	var relocation ArtifactRelocation
+	if err := json.NewDecoder(w.Body).Decode(&relocation); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("Expected a relocation plan, got %d (%v)", w.Code, err)
+	}
+	if !relocation.DryRun || len(relocation.Relocated) != 1 || relocation.Relocated[0].To != "file://run-1/model.pt" || !relocation.Relocated[0].Missing {
+		t.Errorf("Unexpected plan %+v", relocation)
+	}
+	if d.uris[0] != "file:///old/run-1/model.pt" {
+		t.Errorf("Expected a dry run to change nothing, got %v", d.uris)
+	}
+
+	if w := post(`{"mappings": [{"from": "file:///old/", "to": ""}]}`, true); w.Code != http.StatusOK || d.uris[0] != "file://run-1/model.pt" {
+		t.Errorf("Expected the URI relocated, got %d %v", w.Code, d.uris)
+	}
+}
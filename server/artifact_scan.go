@@ -0,0 +1,404 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// With a scanner configured, each upload is scanned for malware before it can
+// be downloaded. Contents the scanner flags are quarantined: they are kept in
+// the store for admins to review, but are neither served nor shown until an
+// admin releases them. Contents that have not been scanned, including those
+// uploaded before scanning was enabled, are held back until the scanning job
+// gets to them.
+
+// Scan statuses of an artifact's contents
+const (
+	artifactScanPending     = "pending"
+	artifactScanClean       = "clean"
+	artifactScanQuarantined = "quarantined"
+	artifactScanFailed      = "failed"
+)
+
+const (
+	// artifactScanBatchSize is how many artifacts the scanning job scans
+	// between checks for new ones
+	artifactScanBatchSize = 20
+	// artifactScanMaxAttempts is how many times artifacts are scanned before
+	// they are left failed, for an admin to release or scan again
+	artifactScanMaxAttempts = 5
+)
+
+// ArtifactScanner scans the contents of artifacts for malware
+type ArtifactScanner interface {
+	// Scan returns what the scanner found in the contents, or "" if they
+	// are clean
+	Scan(ctx context.Context, r io.Reader) (string, error)
+}
+
+// artifactScanner scans uploads before they can be downloaded, or is nil if
+// scanning is disabled
+var artifactScanner ArtifactScanner
+
+var (
+	// artifactScanInterval is how often the scanning job looks for artifacts
+	// to scan
+	artifactScanInterval = time.Minute
+	// artifactScanTimeout is how long a scan can take
+	artifactScanTimeout = 5 * time.Minute
+)
+
+// newArtifactScanner creates a scanner from its URI: clamd://host:port or
+// clamd:///path/to/clamd.sock for a ClamAV daemon, or an http(s) URL of a
+// webhook
+func newArtifactScanner(uri string) (ArtifactScanner, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, fmt.Errorf("invalid scanner URI: %w", err)
+	}
+	switch u.Scheme {
+	case "clamd":
+		if u.Host != "" {
+			return &clamdScanner{network: "tcp", addr: u.Host}, nil
+		}
+		if u.Path == "" {
+			return nil, fmt.Errorf("clamd scanner URI needs a host:port or socket path")
+		}
+		return &clamdScanner{network: "unix", addr: u.Path}, nil
+	case "http", "https":
+		return &webhookScanner{url: uri}, nil
+	default:
+		return nil, fmt.Errorf("unsupported scanner scheme: %s (use clamd://, http:// or https://)", u.Scheme)
+	}
+}
+
+func initArtifactScanner(uri string) {
+	if uri == "" {
+		return
+	}
+	var err error
+	artifactScanner, err = newArtifactScanner(uri)
+	if err != nil {
+		log.Fatalf("Could not create artifact scanner: %v", err)
+	}
+
+	log.Printf("Artifact scanner initialized at: %s", redactSetting("artifact-scanner", uri))
+}
+
+// clamdScanner scans with a ClamAV daemon over its INSTREAM protocol
+type clamdScanner struct {
+	network string
+	addr    string
+}
+
+func (s *clamdScanner) Scan(ctx context.Context, r io.Reader) (string, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, s.network, s.addr)
+	if err != nil {
+		return "", fmt.Errorf("connecting to clamd: %w", err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	// The contents are sent in chunks, each prefixed with its length, and
+	// ended by an empty chunk
+	if _, err := io.WriteString(conn, "zINSTREAM\x00"); err != nil {
+		return "", fmt.Errorf("sending to clamd: %w", err)
+	}
+	buf := make([]byte, 4+64*1024)
+	for {
+		n, err := r.Read(buf[4:])
+		if n > 0 {
+			binary.BigEndian.PutUint32(buf, uint32(n))
+			if _, err := conn.Write(buf[:4+n]); err != nil {
+				return "", fmt.Errorf("sending to clamd: %w", err)
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", fmt.Errorf("reading artifact: %w", err)
+		}
+	}
+	if _, err := conn.Write([]byte{0, 0, 0, 0}); err != nil {
+		return "", fmt.Errorf("sending to clamd: %w", err)
+	}
+
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && reply == "" {
+		return "", fmt.Errorf("reading clamd reply: %w", err)
+	}
+	reply = strings.TrimPrefix(strings.TrimRight(reply, "\x00\n"), "stream: ")
+	switch {
+	case reply == "OK":
+		return "", nil
+	case strings.HasSuffix(reply, " FOUND"):
+		return strings.TrimSuffix(reply, " FOUND"), nil
+	default:
+		return "", fmt.Errorf("clamd: %s", reply)
+	}
+}
+
+// webhookScanner POSTs the contents to a webhook, which responds with JSON
+// {"clean": bool, "finding": "..."}. Scanners such as ICAP servers can be
+// put behind one.
+type webhookScanner struct {
+	url string
+}
+
+func (s *webhookScanner) Scan(ctx context.Context, r io.Reader) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, r)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("calling scanner webhook: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("scanner webhook responded %s", resp.Status)
+	}
+
+	var result struct {
+		Clean   *bool  `json:"clean"`
+		Finding string `json:"finding"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil || result.Clean == nil {
+		return "", fmt.Errorf("scanner webhook responded with invalid JSON")
+	}
+	if *result.Clean {
+		return "", nil
+	}
+	if result.Finding == "" {
+		return "rejected by scanner", nil
+	}
+	return result.Finding, nil
+}
+
+// scanArtifact scans the contents stored under uri, recording the outcome,
+// and returns the artifact's scan status. Failed scans are left pending to
+// be tried again, until they have been tried artifactScanMaxAttempts times.
+func scanArtifact(ctx context.Context, a ArtifactScanRow) (string, error) {
+	finding, err := func() (string, error) {
+		file, err := openArtifact(ctx, a.URI)
+		if err != nil {
+			return "", fmt.Errorf("reading artifact: %w", err)
+		}
+		defer file.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), artifactScanTimeout)
+		defer cancel()
+		return artifactScanner.Scan(ctx, file)
+	}()
+
+	status, result := artifactScanClean, ""
+	switch {
+	case err != nil:
+		log.Printf("Failed to scan artifact %s (attempt %d of %d): %v", a.URI, a.Attempts+1, artifactScanMaxAttempts, err)
+		status, result = artifactScanPending, err.Error()
+		if a.Attempts+1 >= artifactScanMaxAttempts {
+			status = artifactScanFailed
+		}
+	case finding != "":
+		log.Printf("Quarantined artifact %s: %s", a.URI, finding)
+		status, result = artifactScanQuarantined, finding
+	}
+	if err := dao.RecordArtifactScan(ctx, a.URI, status, result); err != nil {
+		return "", err
+	}
+	return status, nil
+}
+
+// scanUploadedArtifact scans a new upload, so that clean uploads can be
+// downloaded straight away, and returns its scan status. Uploads that cannot
+// be scanned now are left to the scanning job.
+func scanUploadedArtifact(ctx context.Context, uri string) string {
+	if artifactScanner == nil {
+		return artifactScanPending
+	}
+	status, err := scanArtifact(ctx, ArtifactScanRow{URI: uri})
+	if err != nil {
+		log.Printf("Failed to record scan of artifact %s: %v", uri, err)
+		return artifactScanPending
+	}
+	return status
+}
+
+// scanPendingArtifacts scans a batch of artifacts awaiting a scan. It reports
+// whether another batch should follow immediately: failures wait for the next
+// interval, so a scanner outage does not use up every artifact's attempts at
+// once.
+func scanPendingArtifacts(ctx context.Context) (bool, error) {
+	pending, err := dao.GetArtifactsToScan(ctx, artifactScanBatchSize)
+	if err != nil {
+		return false, err
+	}
+	for _, a := range pending {
+		status, err := scanArtifact(ctx, a)
+		if err != nil {
+			return false, err
+		}
+		if status == artifactScanPending || status == artifactScanFailed {
+			return false, nil
+		}
+	}
+	return len(pending) == artifactScanBatchSize, nil
+}
+
+// startArtifactScanner periodically scans artifacts uploaded while the
+// scanner was unavailable, or before scanning was enabled
+func startArtifactScanner(ctx context.Context) {
+	if artifactScanner == nil {
+		return
+	}
+	go func() {
+		for {
+			for holdJobLease(ctx, "artifact-scan", 2*artifactScanInterval) {
+				more, err := scanPendingArtifacts(ctx)
+				if err != nil {
+					log.Printf("Failed to scan artifacts: %v", err)
+				}
+				if !more {
+					break
+				}
+			}
+			time.Sleep(artifactScanInterval)
+		}
+	}()
+}
+
+// artifactScanBlock explains why contents with the given scan status cannot
+// be downloaded, or returns "" if they can. Quarantined contents never can;
+// with a scanner configured, only clean ones can. Contents that are not an
+// artifact's, with no status, such as experiment archives, are not scanned.
+func artifactScanBlock(status string) string {
+	switch {
+	case status == artifactScanQuarantined:
+		return "quarantined"
+	case artifactScanner == nil || status == artifactScanClean || status == "":
+		return ""
+	case status == artifactScanFailed:
+		return "could not be scanned for malware"
+	default:
+		return "awaiting a malware scan"
+	}
+}
+
+// artifactDownloadable reports whether an artifact's contents can be
+// downloaded or shown
+func artifactDownloadable(a ArtifactRow) bool {
+	return artifactScanBlock(a.ScanStatus) == ""
+}
+
+// ArtifactScanRequest is the body of POST /api/admin/artifacts/scan
+type ArtifactScanRequest struct {
+	RunUUID string `json:"run_uuid"`
+	Path    string `json:"path"`
+	Version int    `json:"version"`
+	Action  string `json:"action"`
+	Reason  string `json:"reason"`
+}
+
+// handleAPIArtifactScan lets admins decide the scan status of an artifact or
+// one of its prior versions, at /api/admin/artifacts/scan. It takes JSON
+// {run_uuid, path, version, action, reason}, where version defaults to the
+// current one and action is release, quarantine or rescan.
+func handleAPIArtifactScan(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	var req ArtifactScanRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid JSON"})
+		return
+	}
+	status, ok := map[string]string{
+		"release":    artifactScanClean,
+		"quarantine": artifactScanQuarantined,
+		"rescan":     artifactScanPending,
+	}[req.Action]
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Action must be release, quarantine or rescan"})
+		return
+	}
+
+	runID, err := dao.GetRunIDByUUID(ctx, req.RunUUID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Run not found"})
+		return
+	}
+	artifact, err := dao.GetArtifactByRunIDAndPath(ctx, runID, req.Path)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Artifact not found"})
+		return
+	}
+	if req.Version != 0 && req.Version != artifact.Version {
+		versions, err := dao.GetArtifactVersions(ctx, runID, req.Path)
+		if err != nil {
+			log.Printf("Failed to list versions of artifact %s of run %s: %v", req.Path, req.RunUUID, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to list artifact versions"})
+			return
+		}
+		artifact = nil
+		for i := range versions {
+			if versions[i].Version == req.Version {
+				artifact = &versions[i]
+			}
+		}
+		if artifact == nil {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Artifact version not found"})
+			return
+		}
+	}
+
+	err = dao.SetArtifactScanStatus(ctx, artifact.URI, status, req.Reason)
+	if err == nil {
+		err = recordAudit(ctx, req.Action+"_artifact", req.RunUUID, map[string]interface{}{
+			"path":        artifact.Path,
+			"version":     artifact.Version,
+			"scan_status": artifact.ScanStatus,
+			"scan_result": artifact.ScanResult,
+			"reason":      req.Reason,
+		})
+	}
+	if err != nil {
+		log.Printf("Failed to update scan status of artifact %s of run %s: %v", req.Path, req.RunUUID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to update artifact"})
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":      "ok",
+		"path":        artifact.Path,
+		"version":     artifact.Version,
+		"scan_status": status,
+	})
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// scanRow is an artifact or prior version kept by scanDAO
+type scanRow struct {
+	ArtifactRow
+	current  bool
+	attempts int
+}
+
+// scanDAO keeps the artifacts of run "run-1" in memory
+type scanDAO struct {
+	DAO
+	rows    []*scanRow
+	audited []string
+}
+
+func (d *scanDAO) GetRunIDByUUID(ctx context.Context, uuid string) (int, error) {
+	if uuid != "run-1" {
+		return 0, sql.ErrNoRows
+	}
+	return 1, nil
+}
+
+func (d *scanDAO) GetArtifactByRunIDAndPath(ctx context.Context, runID int, path string) (*ArtifactRow, error) {
+	for _, row := range d.rows {
+		if row.current && row.Path == path {
+			a := row.ArtifactRow
+			return &a, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (d *scanDAO) GetArtifactVersions(ctx context.Context, runID int, path string) ([]ArtifactRow, error) {
+	var versions []ArtifactRow
+	for _, row := range d.rows {
+		if !row.current && row.Path == path {
+			versions = append(versions, row.ArtifactRow)
+		}
+	}
+	return versions, nil
+}
+
+func (d *scanDAO) GetArtifactsToScan(ctx context.Context, limit int) ([]ArtifactScanRow, error) {
+	var pending []ArtifactScanRow
+	for _, row := range d.rows {
+		if row.ScanStatus == artifactScanPending && len(pending) < limit {
+			pending = append(pending, ArtifactScanRow{URI: row.URI, Attempts: row.attempts})
+		}
+	}
+	return pending, nil
+}
+
+func (d *scanDAO) RecordArtifactScan(ctx context.Context, uri, status, result string) error {
+	for _, row := range d.rows {
+		if row.URI == uri && row.ScanStatus == artifactScanPending {
+			row.ScanStatus, row.ScanResult = status, result
+			row.attempts++
+		}
+	}
+	return nil
+}
+
+func (d *scanDAO) SetArtifactScanStatus(ctx context.Context, uri, status, result string) error {
+	for _, row := range d.rows {
+		if row.URI == uri {
+			row.ScanStatus, row.ScanResult, row.attempts = status, result, 0
+		}
+	}
+	return nil
+}
+
+func (d *scanDAO) GetArtifactScanStatusByURI(ctx context.Context, uri string) (string, string, error) {
+	for _, row := range d.rows {
+		if row.URI == uri {
+			return row.ScanStatus, row.ScanResult, nil
+		}
+	}
+	return "", "", nil
+}
+
+func (d *scanDAO) InsertAuditLogEntry(ctx context.Context, e AuditLogRow) error {
+	d.audited = append(d.audited, e.Action)
+	return nil
+}
+
+// newScanDAO stores artifacts with the given contents, all pending a scan
+func newScanDAO(t *testing.T, contents map[string]string) *scanDAO {
+	store, err := newFileArtifactStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	artifactStore = store
+	d := &scanDAO{}
+	for path, content := range contents {
+		uri, _, _, err := storeArtifact("run-1", path, 1, strings.NewReader(content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		d.rows = append(d.rows, &scanRow{ArtifactRow: ArtifactRow{Path: path, URI: uri, Type: "text", Version: 1, ScanStatus: artifactScanPending}, current: true})
+	}
+	return d
+}
+
+func (d *scanDAO) row(path string) *scanRow {
+	for _, row := range d.rows {
+		if row.current && row.Path == path {
+			return row
+		}
+	}
+	return nil
+}
+
+// fakeScanner flags contents containing "EICAR", or fails with err
+type fakeScanner struct {
+	err error
+}
+
+func (s *fakeScanner) Scan(ctx context.Context, r io.Reader) (string, error) {
+	contents, _ := io.ReadAll(r)
+	if s.err != nil {
+		return "", s.err
+	}
+	if bytes.Contains(contents, []byte("EICAR")) {
+		return "Eicar-Test-Signature", nil
+	}
+	return "", nil
+}
+
+func TestClamdScanner(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	// Answer like clamd, reassembling the streamed chunks
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			reader := bufio.NewReader(conn)
+			command, _ := reader.ReadString(0)
+			var contents []byte
+			for command == "zINSTREAM\x00" {
+				var size uint32
+				if binary.Read(reader, binary.BigEndian, &size) != nil || size == 0 {
+					break
+				}
+				chunk := make([]byte, size)
+				io.ReadFull(reader, chunk)
+				contents = append(contents, chunk...)
+			}
+			switch {
+			case command != "zINSTREAM\x00":
+				io.WriteString(conn, "UNKNOWN COMMAND\x00")
+			case bytes.Contains(contents, []byte("EICAR")):
+				io.WriteString(conn, "stream: Eicar-Test-Signature FOUND\x00")
+			case len(contents) > 100000:
+				io.WriteString(conn, "INSTREAM size limit exceeded. ERROR\x00")
+			default:
+				io.WriteString(conn, "stream: OK\x00")
+			}
+			conn.Close()
+		}
+	}()
+
+	scanner, err := newArtifactScanner("clamd://" + listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if finding, err := scanner.Scan(context.Background(), strings.NewReader("model weights")); finding != "" || err != nil {
+		t.Errorf("Expected clean contents, got %q %v", finding, err)
+	}
+	infected := strings.Repeat("x", 70*1024) + "EICAR"
+	if finding, err := scanner.Scan(context.Background(), strings.NewReader(infected)); finding != "Eicar-Test-Signature" || err != nil {
+		t.Errorf("Expected the signature found across chunks, got %q %v", finding, err)
+	}
+	if _, err := scanner.Scan(context.Background(), strings.NewReader(strings.Repeat("x", 200000))); err == nil {
+		t.Error("Expected an error for a clamd error reply")
+	}
+}
+
+func TestWebhookScanner(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contents, _ := io.ReadAll(r.Body)
+		switch {
+		case bytes.Contains(contents, []byte("EICAR")):
+			w.Write([]byte(`{"clean": false, "finding": "Eicar-Test-Signature"}`))
+		case bytes.Contains(contents, []byte("blocked")):
+			w.Write([]byte(`{"clean": false}`))
+		case bytes.Contains(contents, []byte("broken")):
+			w.WriteHeader(http.StatusBadGateway)
+		default:
+			w.Write([]byte(`{"clean": true}`))
+		}
+	}))
+	defer server.Close()
+
+	scanner, err := newArtifactScanner(server.URL + "/scan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for content, want := range map[string]string{"fine": "", "EICAR": "Eicar-Test-Signature", "blocked": "rejected by scanner"} {
+		if finding, err := scanner.Scan(context.Background(), strings.NewReader(content)); finding != want || err != nil {
+			t.Errorf("Expected %q for %q, got %q %v", want, content, finding, err)
+		}
+	}
+	if _, err := scanner.Scan(context.Background(), strings.NewReader("broken")); err == nil {
+		t.Error("Expected an error when the webhook fails")
+	}
+
+	if _, err := newArtifactScanner("icap://scanner:1344"); err == nil {
+		t.Error("Expected an error for an unsupported scheme")
+	}
+}
+
+func TestScanArtifacts(t *testing.T) {
+	ctx := t.Context()
+	defer func(d DAO, s ArtifactStore, scanner ArtifactScanner) {
+		dao, artifactStore, artifactScanner = d, s, scanner
+	}(dao, artifactStore, artifactScanner)
+	fake := newScanDAO(t, map[string]string{"clean.txt": "fine", "virus.txt": "EICAR"})
+	dao = fake
+	artifactScanner = &fakeScanner{}
+
+	if status := scanUploadedArtifact(ctx, fake.row("clean.txt").URI); status != artifactScanClean {
+		t.Errorf("Expected a clean upload, got %s", status)
+	}
+	if more, err := scanPendingArtifacts(ctx); more || err != nil {
+		t.Fatalf("Expected one batch, got %v %v", more, err)
+	}
+	if row := fake.row("virus.txt"); row.ScanStatus != artifactScanQuarantined || row.ScanResult != "Eicar-Test-Signature" {
+		t.Errorf("Expected the infected artifact quarantined, got %+v", row)
+	}
+
+	// Failed scans are tried again, until they run out of attempts
+	fake.SetArtifactScanStatus(ctx, fake.row("clean.txt").URI, artifactScanPending, "")
+	artifactScanner = &fakeScanner{err: errors.New("scanner down")}
+	for i := 1; i <= artifactScanMaxAttempts; i++ {
+		scanPendingArtifacts(ctx)
+		row := fake.row("clean.txt")
+		want := artifactScanPending
+		if i == artifactScanMaxAttempts {
+			want = artifactScanFailed
+		}
+		if row.ScanStatus != want || row.attempts != i || row.ScanResult != "scanner down" {
+			t.Fatalf("Expected %s after %d attempts, got %+v", want, i, row)
+		}
+	}
+}
+
+func TestHandleServeArtifactBlobScanStatus(t *testing.T) {
+	defer func(d DAO, s ArtifactStore, scanner ArtifactScanner) {
+		dao, artifactStore, artifactScanner = d, s, scanner
+	}(dao, artifactStore, artifactScanner)
+	fake := newScanDAO(t, map[string]string{"clean.txt": "fine", "virus.txt": "EICAR", "new.txt": "new"})
+	dao = fake
+	fake.row("clean.txt").ScanStatus = artifactScanClean
+	fake.row("virus.txt").ScanStatus = artifactScanQuarantined
+	if _, err := artifactStore.Put("_archives/experiment.zip", strings.NewReader("zip")); err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(uri string) int {
+		w := httptest.NewRecorder()
+		handleServeArtifactBlob(w, httptest.NewRequest(http.MethodGet, "/artifacts/blob?uri="+uri, nil))
+		return w.Code
+	}
+
+	// Without a scanner, only quarantined artifacts are held back
+	artifactScanner = nil
+	for path, want := range map[string]int{"clean.txt": http.StatusOK, "new.txt": http.StatusOK, "virus.txt": http.StatusForbidden} {
+		if code := get(fake.row(path).URI); code != want {
+			t.Errorf("Expected %d for %s without a scanner, got %d", want, path, code)
+		}
+	}
+
+	artifactScanner = &fakeScanner{}
+	for path, want := range map[string]int{"clean.txt": http.StatusOK, "new.txt": http.StatusConflict, "virus.txt": http.StatusForbidden} {
+		if code := get(fake.row(path).URI); code != want {
+			t.Errorf("Expected %d for %s with a scanner, got %d", want, path, code)
+		}
+	}
+	if code := get(artifactStore.URI("_archives/experiment.zip")); code != http.StatusOK {
+		t.Errorf("Expected contents that are not an artifact's served, got %d", code)
+	}
+}
+
+func TestHandleAPIArtifactScan(t *testing.T) {
+	defer func(d DAO, s ArtifactStore, token string) { dao, artifactStore, adminToken = d, s, token }(dao, artifactStore, adminToken)
+	fake := newScanDAO(t, map[string]string{"model.pt": "EICAR"})
+	fake.row("model.pt").ScanStatus = artifactScanQuarantined
+	fake.row("model.pt").Version = 2
+	fake.rows = append(fake.rows, &scanRow{ArtifactRow: ArtifactRow{Path: "model.pt", URI: "run-1/~versions/1/model.pt", Version: 1, ScanStatus: artifactScanClean}})
+	dao = fake
+	adminToken = "secret"
+
+	request := func(body string) (int, map[string]interface{}) {
+		r := httptest.NewRequest(http.MethodPost, "/api/admin/artifacts/scan", strings.NewReader(body))
+		r.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		handleAPIArtifactScan(w, r)
+		var resp map[string]interface{}
+		json.NewDecoder(w.Body).Decode(&resp)
+		return w.Code, resp
+	}
+
+	code, resp := request(`{"run_uuid": "run-1", "path": "model.pt", "action": "release", "reason": "false positive"}`)
+	if code != http.StatusOK || resp["scan_status"] != artifactScanClean {
+		t.Fatalf("Expected the artifact released, got %d %v", code, resp)
+	}
+	if row := fake.row("model.pt"); row.ScanStatus != artifactScanClean || row.ScanResult != "false positive" {
+		t.Errorf("Expected the release and its reason recorded, got %+v", row)
+	}
+	if code, _ := request(`{"run_uuid": "run-1", "path": "model.pt", "version": 1, "action": "quarantine"}`); code != http.StatusOK || fake.rows[1].ScanStatus != artifactScanQuarantined {
+		t.Errorf("Expected the prior version quarantined, got %d %+v", code, fake.rows[1])
+	}
+	if strings.Join(fake.audited, ",") != "release_artifact,quarantine_artifact" {
+		t.Errorf("Expected the changes audited, got %v", fake.audited)
+	}
+
+	if code, _ := request(`{"run_uuid": "run-1", "path": "model.pt", "action": "delete"}`); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an unknown action, got %d", code)
+	}
+	if code, _ := request(`{"run_uuid": "run-1", "path": "model.pt", "version": 7, "action": "rescan"}`); code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a missing version, got %d", code)
+	}
+	if code, _ := request(`{"run_uuid": "missing", "path": "model.pt", "action": "rescan"}`); code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a missing run, got %d", code)
+	}
+}
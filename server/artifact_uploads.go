@@ -0,0 +1,614 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Large artifacts, such as model checkpoints, are uploaded in chunks so that
+// an upload interrupted by a flaky connection resumes where it left off
+// rather than starting over. A client initiates an upload with the size and
+// SHA-256 digest of the file, PUTs its chunks in any order, retrying those
+// that fail, and completes the upload, which assembles the chunks into the
+// artifact and verifies the digest. Initiating the same upload again returns
+// the chunks already received.
+//
+// Chunks are staged in the artifact store, so that any replica can receive
+// them, and are deleted once the upload is completed. Uploads left idle for
+// longer than -artifact-upload-expiry are listed for deletion by the
+// expired-uploads housekeeping job.
+
+// artifactUploadPrefix is where the chunks of uploads in progress are kept in
+// the artifact store, beneath the upload's UUID. It cannot collide with a run
+// UUID, and files beneath it are never served.
+const artifactUploadPrefix = "_uploads/"
+
+// Bounds on the size of chunks, which clients may choose within
+const (
+	artifactUploadDefaultChunkSize int64 = 16 << 20
+	artifactUploadMinChunkSize     int64 = 1 << 20
+	artifactUploadMaxChunkSize     int64 = 256 << 20
+	// artifactUploadMaxChunks bounds how many chunks an upload has
+	artifactUploadMaxChunks = 10000
+)
+
+// artifactUploadChunkSHA256Header optionally carries the hex SHA-256 digest
+// of a chunk, which the chunk is refused without matching
+const artifactUploadChunkSHA256Header = "X-Apparatus-Chunk-SHA256"
+
+// artifactUploadExpiry is how long an upload can go without receiving a
+// chunk before it is abandoned
+var artifactUploadExpiry = 24 * time.Hour
+
+// artifactUploadPaths are the endpoints of the chunked upload protocol. Like
+// multipart uploads, they are not journaled: their contents are kept in the
+// artifact store.
+var artifactUploadPaths = []string{"/api/artifacts/initiate", "/api/artifacts/chunk", "/api/artifacts/complete"}
+
+// isArtifactUploadRequest reports whether a request is part of a chunked
+// upload
+func isArtifactUploadRequest(r *http.Request) bool {
+	return slices.Contains(artifactUploadPaths, unversionedAPIPath(r.URL.Path))
+}
+
+// artifactUploadChunkKey is the key chunk index of an upload is staged under
+func artifactUploadChunkKey(uploadUUID string, index int) string {
+	return fmt.Sprintf("%s%s/%08d", artifactUploadPrefix, uploadUUID, index)
+}
+
+// chunkCount is how many chunks an upload's file is split into
+func (u *ArtifactUploadRow) chunkCount() int {
+	return int((u.SizeBytes + u.ChunkSize - 1) / u.ChunkSize)
+}
+
+// chunkLength is the size of chunk index, which is the chunk size for all but
+// the last chunk
+func (u *ArtifactUploadRow) chunkLength(index int) int64 {
+	return min(u.ChunkSize, u.SizeBytes-int64(index)*u.ChunkSize)
+}
+
+// receivedArtifactUploadChunks returns the indexes of the chunks of an upload
+// staged in full, in order. Chunks of the wrong size were cut off and must
+// be sent again.
+func receivedArtifactUploadChunks(u *ArtifactUploadRow) ([]int, error) {
+	received := []int{}
+	err := artifactStore.Walk(artifactUploadPrefix+u.UUID+"/", func(info ArtifactInfo) error {
+		index, err := strconv.Atoi(path.Base(info.Key))
+		if err != nil || index < 0 || index >= u.chunkCount() || info.Size != u.chunkLength(index) {
+			return nil
+		}
+		received = append(received, index)
+		return nil
+	})
+	slices.Sort(received)
+	return received, err
+}
+
+// missingArtifactUploadChunks returns the indexes of the chunks of an upload
+// not yet received, given those that were
+func missingArtifactUploadChunks(u *ArtifactUploadRow, received []int) []int {
+	missing := []int{}
+	for index := range u.chunkCount() {
+		if _, found := slices.BinarySearch(received, index); !found {
+			missing = append(missing, index)
+		}
+	}
+	return missing
+}
+
+// deleteArtifactUpload deletes an upload with its staged chunks
+func deleteArtifactUpload(ctx context.Context, uploadUUID string) error {
+	if err := deleteArtifactPrefix(artifactStore, artifactUploadPrefix+uploadUUID+"/"); err != nil {
+		return err
+	}
+	return dao.DeleteArtifactUpload(ctx, uploadUUID)
+}
+
+// artifactUploadChunksReader reads the chunks of an upload in order, opening
+// each only once the one before it is read
+type artifactUploadChunksReader struct {
+	keys    []string
+	current io.ReadCloser
+}
+
+func (c *artifactUploadChunksReader) Read(p []byte) (int, error) {
+	for {
+		if c.current == nil {
+			if len(c.keys) == 0 {
+				return 0, io.EOF
+			}
+			chunk, err := artifactStore.Get(c.keys[0])
+			if err != nil {
+				return 0, err
+			}
+			c.current, c.keys = chunk, c.keys[1:]
+		}
+		n, err := c.current.Read(p)
+		if err == io.EOF {
+			c.current.Close()
+			c.current = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+func (c *artifactUploadChunksReader) Close() error {
+	if c.current == nil {
+		return nil
+	}
+	return c.current.Close()
+}
+
+// lookupArtifactUpload loads the upload a request names with upload_id,
+// responding with an error if it doesn't exist or the request cannot access
+// its run
+func lookupArtifactUpload(w http.ResponseWriter, r *http.Request, uploadUUID string) (*ArtifactUploadRow, bool) {
+	if uploadUUID == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":          "Missing required fields",
+			"missing_fields": []string{"upload_id"},
+		})
+		return nil, false
+	}
+	upload, err := dao.GetArtifactUpload(r.Context(), uploadUUID)
+	if errors.Is(err, sql.ErrNoRows) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Upload not found; it may have been completed or expired"})
+		return nil, false
+	}
+	if err != nil {
+		log.Printf("Failed to load artifact upload %s: %v", uploadUUID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to load upload"})
+		return nil, false
+	}
+	if ok, err := requestCanAccessRun(r, upload.RunID); err != nil || !ok {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Upload not found; it may have been completed or expired"})
+		return nil, false
+	}
+	return upload, true
+}
+
+// checkArtifactUploadVersion returns the version an upload to an artifact's
+// path will be, responding with an error if it cannot be uploaded: artifacts
+// of a run on hold may be added but not overwritten
+func checkArtifactUploadVersion(ctx context.Context, w http.ResponseWriter, runID int, artifactPath string) (int, bool) {
+	version, err := nextArtifactVersion(ctx, runID, artifactPath)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to load artifact"})
+		return 0, false
+	}
+	if version > 1 {
+		if err := ensureRunNotOnHold(ctx, runID); err != nil {
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(map[string]string{"error": fmt.Sprintf("Cannot overwrite artifact: %v", err)})
+			return 0, false
+		}
+	}
+	return version, true
+}
+
+// InitiateArtifactUploadRequest is the body of POST /api/artifacts/initiate
+type InitiateArtifactUploadRequest struct {
+	RunUUID   string `json:"run_uuid"`
+	Path      string `json:"path"`
+	SizeBytes *int64 `json:"size_bytes"`
+	SHA256    string `json:"sha256"`
+	ChunkSize int64  `json:"chunk_size"`
+}
+
+// handleAPIInitiateArtifactUpload starts a chunked upload of an artifact, or
+// resumes the unexpired upload of the same file to the same path, at
+// POST /api/artifacts/initiate
+func handleAPIInitiateArtifactUpload(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req InitiateArtifactUploadRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid JSON"})
+		return
+	}
+
+	var missing []string
+	if req.RunUUID == "" {
+		missing = append(missing, "run_uuid")
+	}
+	if req.Path == "" {
+		missing = append(missing, "path")
+	}
+	if req.SizeBytes == nil {
+		missing = append(missing, "size_bytes")
+	}
+	if req.SHA256 == "" {
+		missing = append(missing, "sha256")
+	}
+	if len(missing) > 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":          "Missing required fields",
+			"missing_fields": missing,
+		})
+		return
+	}
+
+	if err := isValidArtifactPath(req.Path); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": fmt.Sprintf("Invalid artifact path: %v", err)})
+		return
+	}
+	digest := strings.ToLower(req.SHA256)
+	if decoded, err := hex.DecodeString(digest); err != nil || len(decoded) != sha256.Size {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "sha256 must be a hex SHA-256 digest"})
+		return
+	}
+	size := *req.SizeBytes
+	if size < 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "size_bytes cannot be negative"})
+		return
+	}
+	chunkSize := req.ChunkSize
+	if chunkSize == 0 {
+		// Small enough a chunk for a retry to be cheap, and large enough for
+		// the largest checkpoints to fit in the chunk limit
+		chunkSize = max(artifactUploadDefaultChunkSize, (size+artifactUploadMaxChunks-1)/artifactUploadMaxChunks)
+	}
+	if chunkSize < artifactUploadMinChunkSize || chunkSize > artifactUploadMaxChunkSize {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": fmt.Sprintf("chunk_size must be between %d and %d bytes", artifactUploadMinChunkSize, artifactUploadMaxChunkSize)})
+		return
+	}
+	if (size+chunkSize-1)/chunkSize > artifactUploadMaxChunks {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": fmt.Sprintf("Uploads can have at most %d chunks; use a larger chunk_size", artifactUploadMaxChunks)})
+		return
+	}
+
+	runID, err := dao.GetRunIDByUUID(ctx, req.RunUUID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Run not found"})
+		return
+	}
+	if ok, err := requestCanAccessRun(r, runID); err != nil || !ok {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Run not found"})
+		return
+	}
+	if _, ok := checkArtifactUploadVersion(ctx, w, runID, req.Path); !ok {
+		return
+	}
+	// Refuse uploads the quota has no room for before any chunks are sent.
+	// The quota is checked again on completion.
+	if !quotaExempt(r) {
+		if _, err := checkRunQuota(ctx, runID, 0, max(size, 1)); writeQuotaError(w, err) {
+			return
+		}
+	}
+
+	now := time.Now().UTC()
+	upload, err := dao.FindArtifactUpload(ctx, runID, req.Path, digest, size)
+	if err != nil {
+		log.Printf("Failed to look up artifact uploads of %s to run %s: %v", req.Path, req.RunUUID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to initiate upload"})
+		return
+	}
+	// A resumed upload keeps its chunk size, which its chunks were cut to
+	resumed := upload != nil && now.Sub(upload.UpdatedAt) < artifactUploadExpiry && (req.ChunkSize == 0 || req.ChunkSize == upload.ChunkSize)
+	if resumed {
+		if err := dao.TouchArtifactUpload(ctx, upload.UUID, now); err != nil {
+			log.Printf("Failed to resume artifact upload %s: %v", upload.UUID, err)
+		}
+	} else {
+		upload = &ArtifactUploadRow{
+			UUID:      newUUID(ctx),
+			RunID:     runID,
+			Path:      req.Path,
+			SizeBytes: size,
+			SHA256:    digest,
+			ChunkSize: chunkSize,
+			CreatedAt: now,
+			UpdatedAt: now,
+		}
+		if err := dao.InsertArtifactUpload(ctx, *upload); err != nil {
+			log.Printf("Failed to record artifact upload of %s to run %s: %v", req.Path, req.RunUUID, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to initiate upload"})
+			return
+		}
+	}
+
+	received, err := receivedArtifactUploadChunks(upload)
+	if err != nil {
+		log.Printf("Failed to list chunks of artifact upload %s: %v", upload.UUID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to list received chunks"})
+		return
+	}
+	if !resumed {
+		w.WriteHeader(http.StatusCreated)
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"upload_id":       upload.UUID,
+		"chunk_size":      upload.ChunkSize,
+		"chunk_count":     upload.chunkCount(),
+		"received_chunks": received,
+		"expires_at":      now.Add(artifactUploadExpiry),
+	})
+}
+
+// handleAPIArtifactUploadChunk receives one chunk of an upload as the raw
+// request body, at PUT /api/artifacts/chunk?upload_id=...&index=... A chunk
+// sent again replaces the one received before.
+func handleAPIArtifactUploadChunk(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPut {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	upload, ok := lookupArtifactUpload(w, r, r.URL.Query().Get("upload_id"))
+	if !ok {
+		return
+	}
+	index, err := strconv.Atoi(r.URL.Query().Get("index"))
+	if err != nil || index < 0 || index >= upload.chunkCount() {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": fmt.Sprintf("index must be a chunk index between 0 and %d", upload.chunkCount()-1)})
+		return
+	}
+
+	length := upload.chunkLength(index)
+	key := artifactUploadChunkKey(upload.UUID, index)
+	digest := sha256.New()
+	// Reading a byte past the chunk's length tells a chunk that is too long
+	// from one that fits
+	size, err := artifactStore.Put(key, io.TeeReader(io.LimitReader(r.Body, length+1), digest))
+	switch {
+	case err != nil:
+		// The client went away, or the store failed; either way the chunk
+		// must be sent again
+		log.Printf("Failed to store chunk %d of artifact upload %s: %v", index, upload.UUID, err)
+		artifactStore.Delete(key)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to store chunk"})
+		return
+	case size > length:
+		artifactStore.Delete(key)
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		json.NewEncoder(w).Encode(map[string]string{"error": fmt.Sprintf("Chunk %d must be %d bytes", index, length)})
+		return
+	case size < length:
+		artifactStore.Delete(key)
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": fmt.Sprintf("Chunk %d must be %d bytes, got %d", index, length, size)})
+		return
+	}
+	chunkDigest := hex.EncodeToString(digest.Sum(nil))
+	if want := r.Header.Get(artifactUploadChunkSHA256Header); want != "" && !strings.EqualFold(want, chunkDigest) {
+		artifactStore.Delete(key)
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": fmt.Sprintf("Chunk %d does not match its %s header: its SHA-256 is %s", index, artifactUploadChunkSHA256Header, chunkDigest)})
+		return
+	}
+	if err := dao.TouchArtifactUpload(r.Context(), upload.UUID, time.Now().UTC()); err != nil {
+		log.Printf("Failed to record chunk %d of artifact upload %s: %v", index, upload.UUID, err)
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":     "ok",
+		"index":      index,
+		"size_bytes": size,
+		"sha256":     chunkDigest,
+	})
+}
+
+// CompleteArtifactUploadRequest is the body of POST /api/artifacts/complete
+type CompleteArtifactUploadRequest struct {
+	UploadID string `json:"upload_id"`
+}
+
+// handleAPICompleteArtifactUpload assembles the chunks of an upload into its
+// artifact, verifying the file's digest, at POST /api/artifacts/complete.
+// It responds like a multipart upload to /api/artifacts.
+func handleAPICompleteArtifactUpload(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req CompleteArtifactUploadRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid JSON"})
+		return
+	}
+	upload, ok := lookupArtifactUpload(w, r, req.UploadID)
+	if !ok {
+		return
+	}
+
+	received, err := receivedArtifactUploadChunks(upload)
+	if err != nil {
+		log.Printf("Failed to list chunks of artifact upload %s: %v", upload.UUID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to list received chunks"})
+		return
+	}
+	if missing := missingArtifactUploadChunks(upload, received); len(missing) > 0 {
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":          fmt.Sprintf("%d of %d chunks have not been received", len(missing), upload.chunkCount()),
+			"missing_chunks": missing,
+		})
+		return
+	}
+
+	run, err := dao.GetRunByID(ctx, upload.RunID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Run not found"})
+		return
+	}
+	version, ok := checkArtifactUploadVersion(ctx, w, upload.RunID, upload.Path)
+	if !ok {
+		return
+	}
+	if !quotaExempt(r) {
+		if _, err := checkRunQuota(ctx, upload.RunID, 0, max(upload.SizeBytes, 1)); writeQuotaError(w, err) {
+			return
+		}
+	}
+
+	// Assemble the chunks into the artifact, sniffing its content type on the
+	// way
+	keys := make([]string, len(received))
+	for i, index := range received {
+		keys[i] = artifactUploadChunkKey(upload.UUID, index)
+	}
+	chunks := &artifactUploadChunksReader{keys: keys}
+	defer chunks.Close()
+	contentType, contents := sniffArtifact(upload.Path, chunks)
+	key, err := runArtifactKey(ctx, upload.RunID, run.UUID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": fmt.Sprintf("Failed to store artifact: %v", err)})
+		return
+	}
+	uri, size, digest, err := storeArtifact(key, upload.Path, version, contents)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": fmt.Sprintf("Failed to store artifact: %v", err)})
+		return
+	}
+
+	// A corrupted chunk cannot be told from the others, so the upload starts
+	// over
+	if digest != upload.SHA256 || size != upload.SizeBytes {
+		if err := artifactStore.Delete(artifactVersionKey(key, upload.Path, version)); err != nil {
+			log.Printf("Failed to delete corrupted upload of %s to run %s: %v", upload.Path, run.UUID, err)
+		}
+		if err := deleteArtifactUpload(ctx, upload.UUID); err != nil {
+			log.Printf("Failed to delete artifact upload %s: %v", upload.UUID, err)
+		}
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(map[string]string{"error": fmt.Sprintf("The assembled file's SHA-256 is %s, not %s; initiate the upload again", digest, upload.SHA256)})
+		return
+	}
+
+	scanStatus, err := recordArtifactUpload(ctx, upload.RunID, run.UUID, upload.Path, uri, contentType, size, digest)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to insert artifact metadata"})
+		return
+	}
+	if err := deleteArtifactUpload(ctx, upload.UUID); err != nil {
+		log.Printf("Failed to delete chunks of completed artifact upload %s: %v", upload.UUID, err)
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":       "ok",
+		"path":         upload.Path,
+		"uri":          uri,
+		"sha256":       digest,
+		"content_type": contentType,
+		"version":      version,
+		"scan_status":  scanStatus,
+	})
+}
+
+// planExpiredArtifactUploads lists uploads that have received no chunks for
+// longer than -artifact-upload-expiry, and chunks staged for uploads that no
+// longer exist, such as those of purged runs
+func planExpiredArtifactUploads(ctx context.Context) (items, skipped []HousekeepingItem, err error) {
+	uploads, err := dao.GetArtifactUploads(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	// Chunks staged for no upload are left alone until they too go idle, in
+	// case their upload was initiated since it was listed
+	type stagedChunks struct {
+		bytes      int64
+		modifiedAt time.Time
+	}
+	staged := make(map[string]*stagedChunks)
+	err = artifactStore.Walk(artifactUploadPrefix, func(info ArtifactInfo) error {
+		uploadUUID, _, _ := strings.Cut(strings.TrimPrefix(info.Key, artifactUploadPrefix), "/")
+		if staged[uploadUUID] == nil {
+			staged[uploadUUID] = &stagedChunks{}
+		}
+		staged[uploadUUID].bytes += info.Size
+		if info.ModifiedAt.After(staged[uploadUUID].modifiedAt) {
+			staged[uploadUUID].modifiedAt = info.ModifiedAt
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, nil, err
+	}
+
+	cutoff := time.Now().Add(-artifactUploadExpiry)
+	for _, upload := range uploads {
+		chunks := staged[upload.UUID]
+		delete(staged, upload.UUID)
+		if upload.UpdatedAt.After(cutoff) {
+			continue
+		}
+		item := HousekeepingItem{Kind: "artifact_upload", Key: upload.UUID}
+		if chunks != nil {
+			item.Bytes = chunks.bytes
+		}
+		if run, err := dao.GetRunByID(ctx, upload.RunID); err == nil {
+			item.RunUUID = run.UUID
+		}
+		items = append(items, item)
+	}
+	orphaned := make([]string, 0, len(staged))
+	for uploadUUID, chunks := range staged {
+		if chunks.modifiedAt.Before(cutoff) {
+			orphaned = append(orphaned, uploadUUID)
+		}
+	}
+	slices.Sort(orphaned)
+	for _, uploadUUID := range orphaned {
+		items = append(items, HousekeepingItem{Kind: "artifact_upload", Key: uploadUUID, Bytes: staged[uploadUUID].bytes})
+	}
+	return items, nil, nil
+}
+
+// deleteExpiredArtifactUpload deletes an upload listed by
+// planExpiredArtifactUploads
+func deleteExpiredArtifactUpload(ctx context.Context, item HousekeepingItem) error {
+	return deleteArtifactUpload(ctx, item.Key)
+}
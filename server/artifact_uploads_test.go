@@ -0,0 +1,345 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// artifactUploadsDAO keeps run-1's artifacts and chunked uploads in memory
+type artifactUploadsDAO struct {
+	artifactTypeDAO
+	uploads map[string]ArtifactUploadRow
+}
+
+func (d *artifactUploadsDAO) GetRunByID(ctx context.Context, id int) (*Run, error) {
+	return &Run{UUID: "run-1"}, nil
+}
+
+func (d *artifactUploadsDAO) GetRunProjectID(ctx context.Context, runID int) (int, error) {
+	return 1, nil
+}
+
+func (d *artifactUploadsDAO) GetProjectByID(ctx context.Context, id int) (*ProjectRow, error) {
+	return &ProjectRow{ID: id}, nil
+}
+
+func (d *artifactUploadsDAO) GetRunExperimentID(ctx context.Context, runID int) (int, error) {
+	return 1, nil
+}
+
+func (d *artifactUploadsDAO) GetExperimentQuota(ctx context.Context, experimentID int) (*ExperimentQuotaRow, error) {
+	return nil, nil
+}
+
+func (d *artifactUploadsDAO) GetExperimentForRunUUID(ctx context.Context, runUUID string) (*Experiment, error) {
+	return nil, sql.ErrNoRows
+}
+
+func (d *artifactUploadsDAO) GetRunHold(ctx context.Context, runID int) (*RunHoldRow, error) {
+	return nil, nil
+}
+
+func (d *artifactUploadsDAO) UpsertArtifact(ctx context.Context, runID int, path, uri, artifactType, contentType string, sizeBytes int64, sha256 string) error {
+	version := 1
+	for _, a := range d.artifacts {
+		if a.Path == path {
+			version = a.Version + 1
+		}
+	}
+	d.artifacts = append(slices.DeleteFunc(d.artifacts, func(a ArtifactRow) bool { return a.Path == path }),
+		ArtifactRow{Path: path, URI: uri, Type: artifactType, ContentType: contentType, SizeBytes: sizeBytes, SHA256: sha256, Version: version})
+	return nil
+}
+
+func (d *artifactUploadsDAO) InsertArtifactUpload(ctx context.Context, u ArtifactUploadRow) error {
+	d.uploads[u.UUID] = u
+	return nil
+}
+
+func (d *artifactUploadsDAO) GetArtifactUpload(ctx context.Context, uuid string) (*ArtifactUploadRow, error) {
+	u, ok := d.uploads[uuid]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return &u, nil
+}
+
+func (d *artifactUploadsDAO) FindArtifactUpload(ctx context.Context, runID int, path, sha256 string, sizeBytes int64) (*ArtifactUploadRow, error) {
+	for _, u := range d.uploads {
+		if u.RunID == runID && u.Path == path && u.SHA256 == sha256 && u.SizeBytes == sizeBytes {
+			return &u, nil
+		}
+	}
+	return nil, nil
+}
+
+func (d *artifactUploadsDAO) TouchArtifactUpload(ctx context.Context, uuid string, at time.Time) error {
+	u := d.uploads[uuid]
+	u.UpdatedAt = at
+	d.uploads[uuid] = u
+	return nil
+}
+
+func (d *artifactUploadsDAO) DeleteArtifactUpload(ctx context.Context, uuid string) error {
+	delete(d.uploads, uuid)
+	return nil
+}
+
+func (d *artifactUploadsDAO) GetArtifactUploads(ctx context.Context) ([]ArtifactUploadRow, error) {
+	var uploads []ArtifactUploadRow
+	for _, u := range d.uploads {
+		uploads = append(uploads, u)
+	}
+	return uploads, nil
+}
+
+func newArtifactUploadsDAO(t *testing.T) *artifactUploadsDAO {
+	store, err := newFileArtifactStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	artifactStore = store
+	return &artifactUploadsDAO{uploads: make(map[string]ArtifactUploadRow)}
+}
+
+// initiateArtifactUpload initiates an upload to run-1, returning the status
+// and response
+func initiateArtifactUpload(t *testing.T, body string) (int, map[string]interface{}) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	handleAPIInitiateArtifactUpload(w, httptest.NewRequest(http.MethodPost, "/api/artifacts/initiate", strings.NewReader(body)))
+	var resp map[string]interface{}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	return w.Code, resp
+}
+
+// putArtifactUploadChunk sends a chunk of an upload, returning the status
+func putArtifactUploadChunk(uploadID string, index int, chunk []byte, digest string) int {
+	r := httptest.NewRequest(http.MethodPut, fmt.Sprintf("/api/artifacts/chunk?upload_id=%s&index=%d", uploadID, index), bytes.NewReader(chunk))
+	r.Header.Set("Content-Type", "application/octet-stream")
+	if digest != "" {
+		r.Header.Set(artifactUploadChunkSHA256Header, digest)
+	}
+	w := httptest.NewRecorder()
+	handleAPIArtifactUploadChunk(w, r)
+	return w.Code
+}
+
+// completeArtifactUpload completes an upload, returning the status and
+// response
+func completeArtifactUpload(t *testing.T, uploadID string) (int, map[string]interface{}) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	handleAPICompleteArtifactUpload(w, httptest.NewRequest(http.MethodPost, "/api/artifacts/complete", strings.NewReader(`{"upload_id":"`+uploadID+`"}`)))
+	var resp map[string]interface{}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	return w.Code, resp
+}
+
+func TestChunkedArtifactUpload(t *testing.T) {
+	ctx := t.Context()
+	defer func(d DAO, s ArtifactStore) { dao, artifactStore = d, s }(dao, artifactStore)
+	d := newArtifactUploadsDAO(t)
+	dao = d
+
+	// A checkpoint of two and a half chunks
+	file := bytes.Repeat([]byte("checkpoint"), (5<<20)/20)
+	sum := sha256.Sum256(file)
+	digest := hex.EncodeToString(sum[:])
+	chunk := func(index int) []byte {
+		return file[index<<20 : min((index+1)<<20, len(file))]
+	}
+	initiate := fmt.Sprintf(`{"run_uuid":"run-1","path":"checkpoints/model.pt","size_bytes":%d,"sha256":"%s","chunk_size":%d}`, len(file), digest, 1<<20)
+
+	code, resp := initiateArtifactUpload(t, initiate)
+	if code != http.StatusCreated || resp["chunk_count"] != float64(3) || len(resp["received_chunks"].([]interface{})) != 0 {
+		t.Fatalf("Expected a new upload of 3 chunks, got %d %v", code, resp)
+	}
+	uploadID := resp["upload_id"].(string)
+
+	if code := putArtifactUploadChunk(uploadID, 0, chunk(0), ""); code != http.StatusOK {
+		t.Fatalf("Expected chunk 0 received, got %d", code)
+	}
+	last := chunk(2)
+	lastSum := sha256.Sum256(last)
+	if code := putArtifactUploadChunk(uploadID, 2, last, hex.EncodeToString(lastSum[:])); code != http.StatusOK {
+		t.Fatalf("Expected the shorter last chunk received, got %d", code)
+	}
+	for _, tc := range []struct {
+		index  int
+		chunk  []byte
+		digest string
+		want   int
+	}{
+		{1, chunk(1)[:1000], "", http.StatusBadRequest},
+		{1, slices.Concat(chunk(1), []byte("x")), "", http.StatusRequestEntityTooLarge},
+		{1, chunk(1), strings.Repeat("0", 64), http.StatusBadRequest},
+		{3, chunk(1), "", http.StatusBadRequest},
+	} {
+		if code := putArtifactUploadChunk(uploadID, tc.index, tc.chunk, tc.digest); code != tc.want {
+			t.Errorf("Expected %d for a bad chunk %d of %d bytes, got %d", tc.want, tc.index, len(tc.chunk), code)
+		}
+	}
+	if code := putArtifactUploadChunk("missing", 0, chunk(0), ""); code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a chunk of an unknown upload, got %d", code)
+	}
+
+	code, resp = completeArtifactUpload(t, uploadID)
+	if code != http.StatusConflict || fmt.Sprint(resp["missing_chunks"]) != "[1]" {
+		t.Fatalf("Expected completing to wait for chunk 1, got %d %v", code, resp)
+	}
+
+	// The client reconnects and initiates the upload again
+	code, resp = initiateArtifactUpload(t, initiate)
+	if code != http.StatusOK || resp["upload_id"] != uploadID || fmt.Sprint(resp["received_chunks"]) != "[0 2]" {
+		t.Fatalf("Expected the upload resumed with chunks 0 and 2, got %d %v", code, resp)
+	}
+	if code := putArtifactUploadChunk(uploadID, 1, chunk(1), ""); code != http.StatusOK {
+		t.Fatalf("Expected chunk 1 received, got %d", code)
+	}
+	code, resp = completeArtifactUpload(t, uploadID)
+	if code != http.StatusOK || resp["sha256"] != digest || resp["version"] != float64(1) {
+		t.Fatalf("Expected the upload completed, got %d %v", code, resp)
+	}
+
+	artifact, _ := d.GetArtifactByRunIDAndPath(ctx, 1, "checkpoints/model.pt")
+	if artifact == nil || artifact.SizeBytes != int64(len(file)) || artifact.SHA256 != digest {
+		t.Fatalf("Expected the artifact recorded, got %+v", artifact)
+	}
+	stored, err := openArtifact(ctx, artifact.URI)
+	if err != nil {
+		t.Fatal(err)
+	}
+	contents, _ := io.ReadAll(stored)
+	stored.Close()
+	if !bytes.Equal(contents, file) {
+		t.Errorf("Expected the chunks assembled in order")
+	}
+	if keys, _ := artifactStore.List(artifactUploadPrefix); len(keys) != 0 || len(d.uploads) != 0 {
+		t.Errorf("Expected the upload deleted once completed, got %v and %v", keys, d.uploads)
+	}
+	if code, _ := completeArtifactUpload(t, uploadID); code != http.StatusNotFound {
+		t.Errorf("Expected a completed upload not found, got %d", code)
+	}
+}
+
+func TestChunkedArtifactUploadChecksumMismatch(t *testing.T) {
+	defer func(d DAO, s ArtifactStore) { dao, artifactStore = d, s }(dao, artifactStore)
+	d := newArtifactUploadsDAO(t)
+	dao = d
+
+	code, resp := initiateArtifactUpload(t, fmt.Sprintf(`{"run_uuid":"run-1","path":"model.pt","size_bytes":5,"sha256":"%s"}`, strings.Repeat("ab", 32)))
+	if code != http.StatusCreated || resp["chunk_size"] != float64(artifactUploadDefaultChunkSize) {
+		t.Fatalf("Expected an upload in chunks of the default size, got %d %v", code, resp)
+	}
+	uploadID := resp["upload_id"].(string)
+	if code := putArtifactUploadChunk(uploadID, 0, []byte("wrong"), ""); code != http.StatusOK {
+		t.Fatalf("Expected the chunk received, got %d", code)
+	}
+	if code, _ := completeArtifactUpload(t, uploadID); code != http.StatusUnprocessableEntity {
+		t.Errorf("Expected the corrupted upload refused, got %d", code)
+	}
+	if keys, _ := artifactStore.List(""); len(keys) != 0 || len(d.artifacts) != 0 || len(d.uploads) != 0 {
+		t.Errorf("Expected nothing kept of a corrupted upload, got %v, %v and %v", keys, d.artifacts, d.uploads)
+	}
+}
+
+func TestInitiateArtifactUploadValidation(t *testing.T) {
+	defer func(d DAO, s ArtifactStore) { dao, artifactStore = d, s }(dao, artifactStore)
+	dao = newArtifactUploadsDAO(t)
+
+	digest := strings.Repeat("ab", 32)
+	for _, body := range []string{
+		`{"run_uuid":"run-1","path":"model.pt","sha256":"` + digest + `"}`,
+		`{"run_uuid":"run-1","path":"../model.pt","size_bytes":5,"sha256":"` + digest + `"}`,
+		`{"run_uuid":"run-1","path":"model.pt","size_bytes":5,"sha256":"abcd"}`,
+		`{"run_uuid":"run-1","path":"model.pt","size_bytes":-1,"sha256":"` + digest + `"}`,
+		`{"run_uuid":"run-1","path":"model.pt","size_bytes":5,"sha256":"` + digest + `","chunk_size":1024}`,
+		`{"run_uuid":"run-1","path":"model.pt","size_bytes":1000000000000,"sha256":"` + digest + `","chunk_size":1048576}`,
+	} {
+		if code, resp := initiateArtifactUpload(t, body); code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for %s, got %d %v", body, code, resp)
+		}
+	}
+
+	// The default chunk size grows for files too large for the chunk limit
+	code, resp := initiateArtifactUpload(t, `{"run_uuid":"run-1","path":"model.pt","size_bytes":400000000000,"sha256":"`+digest+`"}`)
+	if code != http.StatusCreated || resp["chunk_count"] != float64(artifactUploadMaxChunks) {
+		t.Errorf("Expected the largest number of chunks, got %d %v", code, resp)
+	}
+}
+
+func TestArtifactUploadRequestsNotJournaled(t *testing.T) {
+	for target, want := range map[string]bool{
+		"/api/artifacts/chunk?upload_id=x&index=0": false,
+		"/api/v1/artifacts/complete":               false,
+		"/api/v1/params":                           true,
+	} {
+		r := httptest.NewRequest(http.MethodPut, target, nil)
+		r.Header.Set("Content-Type", "application/json")
+		if got := isJournaled(r); got != want {
+			t.Errorf("isJournaled(%s) = %v, want %v", target, got, want)
+		}
+	}
+}
+
+func TestHousekeepingExpiredUploads(t *testing.T) {
+	ctx := t.Context()
+	defer func(d DAO, s ArtifactStore) { dao, artifactStore = d, s }(dao, artifactStore)
+	d := newArtifactUploadsDAO(t)
+	dao = d
+
+	now := time.Now().UTC()
+	d.uploads["idle"] = ArtifactUploadRow{UUID: "idle", RunID: 1, ChunkSize: 4, SizeBytes: 8, UpdatedAt: now.Add(-artifactUploadExpiry - time.Minute)}
+	d.uploads["active"] = ArtifactUploadRow{UUID: "active", RunID: 1, ChunkSize: 4, SizeBytes: 8, UpdatedAt: now}
+	for _, key := range []string{artifactUploadChunkKey("idle", 0), artifactUploadChunkKey("active", 0), artifactUploadChunkKey("orphan", 1)} {
+		if _, err := artifactStore.Put(key, strings.NewReader("data")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	items, _, err := planExpiredArtifactUploads(ctx)
+	if err != nil {
+		t.Fatalf("planExpiredArtifactUploads failed: %v", err)
+	}
+	if len(items) != 1 || items[0] != (HousekeepingItem{Kind: "artifact_upload", Key: "idle", RunUUID: "run-1", Bytes: 4}) {
+		t.Errorf("Expected only the idle upload, with recently staged orphans left alone, got %+v", items)
+	}
+
+	// Orphaned chunks are listed once they too go idle
+	past := now.Add(-artifactUploadExpiry - time.Minute)
+	if err := os.Chtimes(filepath.Join(artifactStore.(*fileArtifactStore).root, filepath.FromSlash(artifactUploadChunkKey("orphan", 1))), past, past); err != nil {
+		t.Fatal(err)
+	}
+	items, _, _ = planExpiredArtifactUploads(ctx)
+	if len(items) != 2 || items[1].Key != "orphan" {
+		t.Fatalf("Expected the orphaned chunks listed, got %+v", items)
+	}
+	for _, item := range items {
+		if err := deleteExpiredArtifactUpload(ctx, item); err != nil {
+			t.Fatal(err)
+		}
+	}
+	keys, _ := artifactStore.List(artifactUploadPrefix)
+	if len(keys) != 1 || keys[0] != artifactUploadChunkKey("active", 0) || len(d.uploads) != 1 {
+		t.Errorf("Expected only the active upload kept, got %v and %v", keys, d.uploads)
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Uploading an artifact over another makes a new version of it. The prior
+// versions are kept, stored under their own keys (see artifactVersionKey),
+// and can be listed and viewed, e.g. to look back through the history of
+// checkpoints/best.pt.
+
+// ArtifactVersion is a version of an artifact as returned by the API
+type ArtifactVersion struct {
+	Path        string     `json:"path"`
+	Version     int        `json:"version"`
+	Current     bool       `json:"current"`
+	URI         string     `json:"uri"`
+	Type        string     `json:"type"`
+	ContentType string     `json:"content_type"`
+	SizeBytes   int64      `json:"size_bytes"`
+	SHA256      string     `json:"sha256"`
+	UploadedAt  *time.Time `json:"uploaded_at"`
+	DownloadURL string     `json:"download_url"`
+	ScanStatus  string     `json:"scan_status"`
+	ScanResult  string     `json:"scan_result"`
+}
+
+func newArtifactVersion(a ArtifactRow, current bool) ArtifactVersion {
+	v := ArtifactVersion{
+		Path:        a.Path,
+		Version:     a.Version,
+		Current:     current,
+		URI:         a.URI,
+		Type:        a.Type,
+		ContentType: a.ContentType,
+		SizeBytes:   a.SizeBytes,
+		SHA256:      a.SHA256,
+		DownloadURL: "/artifacts/blob?uri=" + url.QueryEscape(a.URI),
+		ScanStatus:  a.ScanStatus,
+		ScanResult:  a.ScanResult,
+	}
+	if a.UpdatedAt.Valid {
+		v.UploadedAt = &a.UpdatedAt.Time
+	}
+	return v
+}
+
+// handleAPIRunArtifacts lists a run's artifacts at /api/runs/{uuid}/artifacts,
+// or only the one at ?path=..., with its prior versions, newest first, if
+// versions=true
+func handleAPIRunArtifacts(w http.ResponseWriter, r *http.Request, runUUID string) {
+	ctx := r.Context()
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+
+	artifactPath := r.URL.Query().Get("path")
+	withVersions := r.URL.Query().Get("versions") == "true"
+	if withVersions && artifactPath == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Listing versions requires a path"})
+		return
+	}
+
+	runID, err := dao.GetRunIDByUUID(ctx, runUUID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Run not found"})
+		return
+	}
+
+	artifacts := []ArtifactVersion{}
+	if artifactPath == "" {
+		rows, err := dao.GetArtifactsByRunID(ctx, runID)
+		if err != nil {
+			log.Printf("Failed to list artifacts of run %s: %v", runUUID, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to list artifacts"})
+			return
+		}
+		for _, a := range rows {
+			artifacts = append(artifacts, newArtifactVersion(a, true))
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"artifacts": artifacts})
+		return
+	}
+
+	current, err := dao.GetArtifactByRunIDAndPath(ctx, runID, artifactPath)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Artifact not found"})
+		return
+	}
+	artifacts = append(artifacts, newArtifactVersion(*current, true))
+	if withVersions {
+		prior, err := dao.GetArtifactVersions(ctx, runID, artifactPath)
+		if err != nil {
+			log.Printf("Failed to list versions of artifact %s of run %s: %v", artifactPath, runUUID, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to list artifact versions"})
+			return
+		}
+		for _, a := range prior {
+			artifacts = append(artifacts, newArtifactVersion(a, false))
+		}
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"artifacts": artifacts})
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// versionsDAO keeps one run's checkpoint, uploaded three times
+type versionsDAO struct {
+	artifactTypeDAO
+	versions []ArtifactRow
+}
+
+func (d *versionsDAO) GetRunIDByUUID(ctx context.Context, uuid string) (int, error) {
+	if uuid != "run-1" {
+		return 0, sql.ErrNoRows
+	}
+	return 1, nil
+}
+
+func (d *versionsDAO) GetArtifactVersions(ctx context.Context, runID int, path string) ([]ArtifactRow, error) {
+	var versions []ArtifactRow
+	for _, v := range d.versions {
+		if v.Path == path {
+			versions = append(versions, v)
+		}
+	}
+	return versions, nil
+}
+
+func newVersionsDAO(t *testing.T) *versionsDAO {
+	store, err := newFileArtifactStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	artifactStore = store
+	d := &versionsDAO{}
+	uploadedAt := sql.NullTime{Time: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), Valid: true}
+	for version, content := range []string{"first", "second", "best"} {
+		version++
+		uri, size, digest, err := storeArtifact("run-1", "checkpoints/best.txt", version, strings.NewReader(content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		a := ArtifactRow{Path: "checkpoints/best.txt", URI: uri, Type: "text", ContentType: "text/plain; charset=utf-8", SizeBytes: size, SHA256: digest, UpdatedAt: uploadedAt, Version: version}
+		if version == 3 {
+			d.artifacts = append(d.artifacts, a)
+		} else {
+			d.versions = append([]ArtifactRow{a}, d.versions...)
+		}
+	}
+	d.artifacts = append(d.artifacts, ArtifactRow{Path: "notes.txt", URI: "run-1/notes.txt", Type: "text", Version: 1})
+	return d
+}
+
+func TestHandleAPIRunArtifacts(t *testing.T) {
+	defer func(d DAO, s ArtifactStore) { dao, artifactStore = d, s }(dao, artifactStore)
+	dao = newVersionsDAO(t)
+
+	list := func(target string) (int, []ArtifactVersion) {
+		w := httptest.NewRecorder()
+		handleAPIV1Runs(w, httptest.NewRequest(http.MethodGet, target, nil))
+		var resp struct {
+			Artifacts []ArtifactVersion `json:"artifacts"`
+		}
+		json.NewDecoder(w.Body).Decode(&resp)
+		return w.Code, resp.Artifacts
+	}
+
+	if code, artifacts := list("/api/runs/run-1/artifacts"); code != http.StatusOK || len(artifacts) != 2 {
+		t.Errorf("Expected the run's two artifacts, got %d %+v", code, artifacts)
+	}
+	code, artifacts := list("/api/runs/run-1/artifacts?path=checkpoints/best.txt")
+	if code != http.StatusOK || len(artifacts) != 1 || artifacts[0].Version != 3 || !artifacts[0].Current {
+		t.Errorf("Expected only the current version without versions=true, got %d %+v", code, artifacts)
+	}
+
+	code, artifacts = list("/api/v1/runs/run-1/artifacts?path=checkpoints/best.txt&versions=true")
+	if code != http.StatusOK || len(artifacts) != 3 {
+		t.Fatalf("Expected three versions, got %d %+v", code, artifacts)
+	}
+	for i, want := range []int{3, 2, 1} {
+		if artifacts[i].Version != want || artifacts[i].Current != (want == 3) {
+			t.Errorf("Expected version %d at %d, newest first, got %+v", want, i, artifacts[i])
+		}
+	}
+	if artifacts[2].URI != artifactStore.URI("run-1/checkpoints/best.txt") || artifacts[1].URI != artifactStore.URI("run-1/~versions/2/checkpoints/best.txt") {
+		t.Errorf("Expected each version under its own key, got %s and %s", artifacts[2].URI, artifacts[1].URI)
+	}
+	if artifacts[1].UploadedAt == nil || !strings.HasPrefix(artifacts[1].DownloadURL, "/artifacts/blob?uri=") {
+		t.Errorf("Expected the upload time and a download URL, got %+v", artifacts[1])
+	}
+
+	if code, _ := list("/api/runs/run-1/artifacts?versions=true"); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for versions without a path, got %d", code)
+	}
+	if code, _ := list("/api/runs/run-1/artifacts?path=missing.txt&versions=true"); code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a missing artifact, got %d", code)
+	}
+	if code, _ := list("/api/runs/missing/artifacts"); code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a missing run, got %d", code)
+	}
+}
+
+func TestRunArtifactsVersionPicker(t *testing.T) {
+	defer func(d DAO, s ArtifactStore) { dao, artifactStore = d, s }(dao, artifactStore)
+	dao = newVersionsDAO(t)
+
+	view := func(query string) string {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/runs/run-1/artifacts?"+query, nil)
+		if err := handleRunArtifacts(w, r, "run-1"); err != nil {
+			t.Fatal(err)
+		}
+		return w.Body.String()
+	}
+
+	body := view("current_artifact_path=checkpoints/best.txt")
+	if !strings.Contains(body, `<option value="3" selected>`) || !strings.Contains(body, `<option value="1">`) || !strings.Contains(body, ">best</pre>") {
+		t.Errorf("Expected the current version shown with a picker of all three, got %s", body)
+	}
+	body = view("current_artifact_path=checkpoints/best.txt&current_artifact_version=2")
+	if !strings.Contains(body, `<option value="2" selected>`) || !strings.Contains(body, ">second</pre>") || strings.Contains(body, "artifact-type-form") {
+		t.Errorf("Expected the second version shown without the type form, got %s", body)
+	}
+	if body := view("current_artifact_path=notes.txt"); strings.Contains(body, "current_artifact_version") {
+		t.Errorf("Expected no picker for an artifact without prior versions, got %s", body)
+	}
+}
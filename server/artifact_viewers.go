@@ -0,0 +1,392 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"html/template"
+	"io"
+	"io/fs"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"unicode/utf8"
+)
+
+// artifactSniffLength is how much of an artifact is read to sniff its
+// content type, which is all net/http's sniffer considers
+const artifactSniffLength = 512
+
+// artifactPreviewMaxBytes bounds how much of an artifact the inline viewers
+// read; larger artifacts are previewed truncated
+const artifactPreviewMaxBytes = 1 << 20
+
+// artifactPreviewMaxRows bounds the rows of a CSV artifact shown as a table
+const artifactPreviewMaxRows = 500
+
+// Text formats the sniffer cannot tell apart from plain text, by extension
+var artifactExtensionContentTypes = map[string]string{
+	".json":     "application/json",
+	".csv":      "text/csv; charset=utf-8",
+	".md":       "text/markdown; charset=utf-8",
+	".markdown": "text/markdown; charset=utf-8",
+	".txt":      "text/plain; charset=utf-8",
+	".log":      "text/plain; charset=utf-8",
+	".html":     "text/html; charset=utf-8",
+	".htm":      "text/html; charset=utf-8",
+}
+
+// Content types of the model and data formats recognized by their magic
+// bytes, which have no inline viewer
+const (
+	contentTypePyTorch     = "application/x-pytorch"
+	contentTypeNumPy       = "application/x-npy"
+	contentTypeHDF5        = "application/x-hdf5"
+	contentTypeSafetensors = "application/x-safetensors"
+	contentTypeParquet     = "application/vnd.apache.parquet"
+	contentTypePickle      = "application/x-python-pickle"
+	contentTypeTFRecord    = "application/x-tfrecord"
+)
+
+// ArtifactContentType is a content type an artifact may be given by hand
+type ArtifactContentType struct {
+	Value string
+	Label string
+}
+
+// artifactContentTypes are the content types offered when setting an
+// artifact's type by hand, with the viewer ones first
+var artifactContentTypes = []ArtifactContentType{
+	{"text/plain; charset=utf-8", "Text"},
+	{"application/json", "JSON"},
+	{"text/csv; charset=utf-8", "CSV"},
+	{"text/markdown; charset=utf-8", "Markdown"},
+	{"text/html; charset=utf-8", "HTML"},
+	{"image/png", "PNG image"},
+	{"image/jpeg", "JPEG image"},
+	{"image/gif", "GIF image"},
+	{"image/webp", "WebP image"},
+	{"image/svg+xml", "SVG image"},
+	{contentTypePyTorch, "PyTorch checkpoint"},
+	{contentTypeNumPy, "NumPy array"},
+	{contentTypeHDF5, "HDF5"},
+	{contentTypeSafetensors, "safetensors"},
+	{contentTypeParquet, "Parquet"},
+	{contentTypePickle, "Python pickle"},
+	{contentTypeTFRecord, "TFRecord (e.g. TensorBoard events)"},
+	{"application/zip", "ZIP archive"},
+	{"application/x-gzip", "gzip archive"},
+	{"application/octet-stream", "Binary"},
+}
+
+// lookupArtifactContentType finds a content type offered by hand
+func lookupArtifactContentType(value string) (ArtifactContentType, bool) {
+	for _, t := range artifactContentTypes {
+		if t.Value == value {
+			return t, true
+		}
+	}
+	return ArtifactContentType{}, false
+}
+
+// tfrecordCRCTable checksums TFRecord lengths, as TensorBoard event files do
+var tfrecordCRCTable = crc32.MakeTable(crc32.Castagnoli)
+
+// detectArtifactMagic recognizes model and data formats common among
+// artifacts, which are often saved without an extension, by their magic
+// bytes. It returns "" for anything else.
+func detectArtifactMagic(head []byte) string {
+	switch {
+	case bytes.HasPrefix(head, []byte("\x93NUMPY")):
+		return contentTypeNumPy
+	case bytes.HasPrefix(head, []byte("\x89HDF\r\n\x1a\n")):
+		return contentTypeHDF5
+	case bytes.HasPrefix(head, []byte("PAR1")):
+		return contentTypeParquet
+	case bytes.HasPrefix(head, []byte("PK\x03\x04")) && len(head) >= 30:
+		// torch.save writes a ZIP archive whose first entry is
+		// <archive>/data.pkl
+		nameLength := int(binary.LittleEndian.Uint16(head[26:28]))
+		if len(head) >= 30+nameLength && strings.HasSuffix(string(head[30:30+nameLength]), "/data.pkl") {
+			return contentTypePyTorch
+		}
+	case len(head) >= 2 && head[0] == 0x80 && head[1] >= 2 && head[1] <= 5:
+		// The PROTO opcode starting pickles of protocol 2 and later,
+		// including checkpoints from torch.save before PyTorch 1.6
+		return contentTypePickle
+	}
+	if len(head) >= 9 {
+		// safetensors starts with the length of its JSON header
+		headerLength := binary.LittleEndian.Uint64(head[:8])
+		if head[8] == '{' && headerLength >= 2 && headerLength < 100<<20 {
+			return contentTypeSafetensors
+		}
+	}
+	if len(head) >= 12 {
+		// A TFRecord starts with a record length and its masked CRC-32C
+		crc := crc32.Checksum(head[:8], tfrecordCRCTable)
+		if (crc>>15|crc<<17)+0xa282ead8 == binary.LittleEndian.Uint32(head[8:12]) {
+			return contentTypeTFRecord
+		}
+	}
+	return ""
+}
+
+// detectArtifactContentType picks an artifact's content type from the start
+// of its contents, falling back to its extension for text formats and for
+// contents the sniffer does not recognize. An empty head types the artifact
+// by its extension alone.
+func detectArtifactContentType(artifactPath string, head []byte) string {
+	if magic := detectArtifactMagic(head); magic != "" {
+		return magic
+	}
+	ext := strings.ToLower(path.Ext(artifactPath))
+	byExtension := artifactExtensionContentTypes[ext]
+	if byExtension == "" {
+		byExtension = mime.TypeByExtension(ext)
+	}
+	if len(head) == 0 {
+		if byExtension == "" {
+			return "application/octet-stream"
+		}
+		return byExtension
+	}
+	sniffed := http.DetectContentType(head)
+	if byExtension != "" && (strings.HasPrefix(sniffed, "text/plain") || sniffed == "application/octet-stream") {
+		return byExtension
+	}
+	return sniffed
+}
+
+// sniffArtifact detects the content type of an artifact being uploaded,
+// returning a reader of its whole contents
+func sniffArtifact(artifactPath string, r io.Reader) (string, io.Reader) {
+	buffered := bufio.NewReaderSize(r, artifactSniffLength)
+	head, _ := buffered.Peek(artifactSniffLength)
+	return detectArtifactContentType(artifactPath, head), buffered
+}
+
+// artifactTypeForContentType is the artifact type recorded alongside a
+// content type
+func artifactTypeForContentType(contentType string) string {
+	if strings.HasPrefix(contentType, "image/") {
+		return "image"
+	}
+	return "unknown"
+}
+
+// artifactViewer names the inline viewer for a content type, or "" if the
+// artifact is not shown inline
+func artifactViewer(contentType string) string {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case strings.HasPrefix(mediaType, "image/"):
+		return "image"
+	case mediaType == "application/json":
+		return "json"
+	case mediaType == "text/csv":
+		return "csv"
+	case mediaType == "text/markdown":
+		return "markdown"
+	case mediaType == "text/html":
+		return "html"
+	case strings.HasPrefix(mediaType, "text/"):
+		return "text"
+	}
+	return ""
+}
+
+// ArtifactView is an artifact as shown by its inline viewer. Text viewers
+// fill Text, or Rows for CSV with the header first, or HTML for Markdown;
+// images and HTML artifacts are loaded from the blob endpoint.
+type ArtifactView struct {
+	URI         string
+	ContentType string
+	Viewer      string
+	Text        string
+	Rows        [][]string
+	HTML        template.HTML
+	// Truncated is whether only the start of the artifact is shown
+	Truncated bool
+	// Error explains why the contents could not be shown
+	Error string
+	// ScanBlock explains why the contents may not be shown or downloaded,
+	// with the scan's status and result
+	ScanBlock  string
+	ScanStatus string
+	ScanResult string
+}
+
+// TypeLabel describes the artifact's content type
+func (v ArtifactView) TypeLabel() string {
+	if t, ok := lookupArtifactContentType(v.ContentType); ok {
+		return t.Label
+	}
+	return v.ContentType
+}
+
+// TypeOptions are the content types the artifact may be given by hand,
+// including its current one
+func (v ArtifactView) TypeOptions() []ArtifactContentType {
+	if _, ok := lookupArtifactContentType(v.ContentType); ok {
+		return artifactContentTypes
+	}
+	return append([]ArtifactContentType{{v.ContentType, v.ContentType}}, artifactContentTypes...)
+}
+
+// newArtifactView prepares an artifact for its inline viewer, reading the
+// start of its contents for the text viewers. Artifacts uploaded before
+// content types were recorded are typed by their extension. Artifacts the
+// malware scanner holds back are not read.
+func newArtifactView(ctx context.Context, a Artifact) ArtifactView {
+	contentType := a.ContentType
+	if contentType == "" {
+		contentType = detectArtifactContentType(a.Path, nil)
+	}
+	view := ArtifactView{URI: a.URI, ContentType: contentType, Viewer: artifactViewer(contentType)}
+	if view.ScanBlock = artifactScanBlock(a.ScanStatus); view.ScanBlock != "" {
+		view.Viewer, view.ScanStatus, view.ScanResult = "", a.ScanStatus, a.ScanResult
+		return view
+	}
+	switch view.Viewer {
+	case "", "image", "html":
+		return view
+	}
+
+	contents, truncated, err := readArtifactStart(ctx, a.URI, artifactPreviewMaxBytes)
+	if err != nil {
+		log.Printf("Failed to read artifact %s for preview: %v", a.URI, err)
+		view.Error = "The artifact's contents could not be read."
+		return view
+	}
+	view.Truncated = truncated
+	if !utf8.Valid(contents) {
+		view.Viewer = ""
+		view.Error = "The artifact is not valid UTF-8 text."
+		return view
+	}
+
+	switch view.Viewer {
+	case "json":
+		var indented bytes.Buffer
+		if truncated || json.Indent(&indented, contents, "", "  ") != nil {
+			view.Text = string(contents)
+		} else {
+			view.Text = indented.String()
+		}
+	case "csv":
+		reader := csv.NewReader(bytes.NewReader(contents))
+		reader.FieldsPerRecord = -1
+		reader.LazyQuotes = true
+		for len(view.Rows) < artifactPreviewMaxRows {
+			row, err := reader.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				// A truncated preview may end partway through a row
+				if !truncated {
+					view.Viewer = "text"
+					view.Text = string(contents)
+					view.Rows = nil
+				}
+				break
+			}
+			view.Rows = append(view.Rows, row)
+		}
+		if len(view.Rows) == artifactPreviewMaxRows {
+			view.Truncated = true
+		}
+	case "markdown":
+		view.HTML = renderMarkdown(string(contents))
+	default:
+		view.Text = string(contents)
+	}
+	return view
+}
+
+// readArtifactStart reads up to n bytes of an artifact, reporting whether
+// there was more
+func readArtifactStart(ctx context.Context, uri string, n int) ([]byte, bool, error) {
+	key, err := artifactStore.Key(uri)
+	if err != nil {
+		return nil, false, err
+	}
+	var file io.ReadCloser
+	for _, store := range artifactCopies(ctx, uri) {
+		if file, err = openViewedArtifact(ctx, store, key); err == nil || !errors.Is(err, fs.ErrNotExist) {
+			break
+		}
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	defer file.Close()
+	contents, err := io.ReadAll(io.LimitReader(file, int64(n)+1))
+	if err != nil {
+		return nil, false, err
+	}
+	if len(contents) > n {
+		return contents[:n], true, nil
+	}
+	return contents, false, nil
+}
+
+// handleSetArtifactType gives one of a run's artifacts a content type by
+// hand, for when sniffing picked the wrong viewer, and shows the artifacts tab
+// again. An empty content type detects it from the contents again.
+func handleSetArtifactType(w http.ResponseWriter, r *http.Request, runUUID string) error {
+	ctx := r.Context()
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return nil
+	}
+
+	runID, err := dao.GetRunIDByUUID(ctx, runUUID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "Run not found")
+		return nil
+	}
+	artifact, err := dao.GetArtifactByRunIDAndPath(ctx, runID, r.FormValue("path"))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "Artifact not found")
+		return nil
+	}
+
+	contentType := r.FormValue("content_type")
+	if contentType == "" {
+		head, _, err := readArtifactStart(ctx, artifact.URI, artifactSniffLength)
+		if err != nil {
+			return fmt.Errorf("failed to read artifact %s: %w", artifact.URI, err)
+		}
+		contentType = detectArtifactContentType(artifact.Path, head)
+	} else if _, ok := lookupArtifactContentType(contentType); !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "Unknown content type")
+		return nil
+	}
+
+	// Artifacts of other kinds, such as embeddings, keep their type
+	artifactType := artifact.Type
+	if artifactType == "image" || artifactType == "unknown" {
+		artifactType = artifactTypeForContentType(contentType)
+	}
+	if err := dao.SetArtifactContentType(ctx, runID, artifact.Path, artifactType, contentType); err != nil {
+		return fmt.Errorf("failed to set type of artifact %s: %w", artifact.Path, err)
+	}
+
+	r.URL.RawQuery = url.Values{"current_artifact_path": {artifact.Path}}.Encode()
+	return executeRunPageTab(w, r, runUUID, "artifacts", handleRunArtifacts)
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// torchZipHead starts a ZIP archive as torch.save writes it
+var torchZipHead = []byte("PK\x03\x04" + strings.Repeat("\x00", 22) + "\x10\x00\x00\x00" + "archive/data.pkl")
+
+func TestDetectArtifactContentType(t *testing.T) {
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+	for _, tt := range []struct {
+		path string
+		head []byte
+		want string
+	}{
+		{"plot.png", png, "image/png"},
+		// Contents win over a misleading extension, and type extensionless files
+		{"plot.txt", png, "image/png"},
+		{"samples/grid", png, "image/png"},
+		{"metrics.json", []byte(`{"loss": 0.1}`), "application/json"},
+		{"results.csv", []byte("step,loss\n1,0.5\n"), "text/csv; charset=utf-8"},
+		{"README.md", []byte("# Results\n"), "text/markdown; charset=utf-8"},
+		{"report.html", []byte("<!DOCTYPE html><html></html>"), "text/html; charset=utf-8"},
+		{"train.log", []byte("epoch 1\n"), "text/plain; charset=utf-8"},
+		{"notes", []byte("epoch 1\n"), "text/plain; charset=utf-8"},
+		{"model.pkl", []byte("\x80\x04\x95\x00"), contentTypePickle},
+		{"blob", []byte{0x00, 0x01, 0x02, 0x03}, "application/octet-stream"},
+		// Model and data formats are recognized without an extension
+		{"checkpoint", torchZipHead, contentTypePyTorch},
+		{"archive.zip", []byte("PK\x03\x04" + strings.Repeat("\x00", 26)), "application/zip"},
+		{"events.out.tfevents.1700000000.host", []byte("\x18\x00\x00\x00\x00\x00\x00\x00\xa3\x7f\x4b\x22\x00"), contentTypeTFRecord},
+		{"embeddings", []byte("\x93NUMPY\x01\x00v\x00{'descr'"), contentTypeNumPy},
+		{"weights", []byte("\x89HDF\r\n\x1a\n\x00"), contentTypeHDF5},
+		{"model", []byte("\x40\x00\x00\x00\x00\x00\x00\x00{\"__metadata__\""), contentTypeSafetensors},
+		{"table", []byte("PAR1\x15\x04"), contentTypeParquet},
+		// Artifacts uploaded before sniffing are typed by extension alone
+		{"metrics.json", nil, "application/json"},
+		{"checkpoint", nil, "application/octet-stream"},
+	} {
+		if got := detectArtifactContentType(tt.path, tt.head); got != tt.want {
+			t.Errorf("detectArtifactContentType(%q, %q) = %q, want %q", tt.path, tt.head, got, tt.want)
+		}
+	}
+}
+
+func TestSniffArtifact(t *testing.T) {
+	contents := "step,loss\n" + strings.Repeat("1,0.5\n", 200)
+	contentType, r := sniffArtifact("loss.csv", strings.NewReader(contents))
+	if contentType != "text/csv; charset=utf-8" {
+		t.Errorf("Unexpected content type %q", contentType)
+	}
+	// Sniffing consumes none of the contents
+	if read, _ := io.ReadAll(r); string(read) != contents {
+		t.Errorf("Expected the whole contents after sniffing, got %d bytes", len(read))
+	}
+}
+
+func TestNewArtifactView(t *testing.T) {
+	ctx := t.Context()
+	defer func(s ArtifactStore) { artifactStore = s }(artifactStore)
+	store, err := newFileArtifactStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	artifactStore = store
+	put := func(path, contents string) Artifact {
+		contentType, r := sniffArtifact(path, strings.NewReader(contents))
+		uri, _, _, err := storeArtifact("run1", path, 1, r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return Artifact{Path: path, URI: uri, ContentType: contentType}
+	}
+
+	if view := newArtifactView(ctx, put("metrics.json", `{"loss":0.1}`)); view.Viewer != "json" || view.Text != "{\n  \"loss\": 0.1\n}" {
+		t.Errorf("Expected pretty-printed JSON, got %+v", view)
+	}
+	if view := newArtifactView(ctx, put("loss.csv", "step,loss\n1,\"0.5\"\n")); view.Viewer != "csv" || len(view.Rows) != 2 || view.Rows[1][1] != "0.5" {
+		t.Errorf("Expected a table of 2 rows, got %+v", view)
+	}
+	if view := newArtifactView(ctx, put("README.md", "# Results\n<script>")); view.Viewer != "markdown" || !strings.Contains(string(view.HTML), "<h1>Results</h1>") || strings.Contains(string(view.HTML), "<script>") {
+		t.Errorf("Expected escaped rendered Markdown, got %+v", view)
+	}
+	if view := newArtifactView(ctx, put("report.html", "<html><script>alert(1)</script></html>")); view.Viewer != "html" || view.Text != "" {
+		t.Errorf("Expected HTML to be framed rather than read, got %+v", view)
+	}
+	if view := newArtifactView(ctx, put("model.pkl", "\x80\x04\x95\x00")); view.Viewer != "" || view.Error != "" || view.TypeLabel() != "Python pickle" {
+		t.Errorf("Expected no inline viewer for a pickle, got %+v", view)
+	}
+
+	// Long artifacts are previewed truncated, and CSV with a bounded number
+	// of rows
+	long := put("train.log", strings.Repeat("x", artifactPreviewMaxBytes+10))
+	if view := newArtifactView(ctx, long); view.Viewer != "text" || !view.Truncated || len(view.Text) != artifactPreviewMaxBytes {
+		t.Errorf("Expected a truncated preview, got %d bytes, truncated %v", len(view.Text), view.Truncated)
+	}
+	var rows bytes.Buffer
+	for i := 0; i < 2*artifactPreviewMaxRows; i++ {
+		rows.WriteString("1,2\n")
+	}
+	if view := newArtifactView(ctx, put("many.csv", rows.String())); len(view.Rows) != artifactPreviewMaxRows || !view.Truncated {
+		t.Errorf("Expected %d rows and truncated, got %d rows, truncated %v", artifactPreviewMaxRows, len(view.Rows), view.Truncated)
+	}
+
+	// Artifacts uploaded before content types were recorded use their
+	// extension, and missing contents are reported
+	legacy := put("old.json", `[1,2]`)
+	legacy.ContentType = ""
+	if view := newArtifactView(ctx, legacy); view.Viewer != "json" || view.Text != "[\n  1,\n  2\n]" {
+		t.Errorf("Expected an untyped .json artifact to be viewed as JSON, got %+v", view)
+	}
+	if view := newArtifactView(ctx, Artifact{Path: "gone.txt", URI: store.URI("run1/gone.txt")}); view.Error == "" {
+		t.Errorf("Expected an error for a missing artifact, got %+v", view)
+	}
+}
+
+// artifactTypeDAO keeps one run's artifacts
+type artifactTypeDAO struct {
+	DAO
+	artifacts []ArtifactRow
+}
+
+func (d *artifactTypeDAO) GetRunIDByUUID(ctx context.Context, uuid string) (int, error) {
+	return 1, nil
+}
+
+func (d *artifactTypeDAO) GetArtifactsByRunID(ctx context.Context, runID int) ([]ArtifactRow, error) {
+	return d.artifacts, nil
+}
+
+func (d *artifactTypeDAO) GetArtifactByRunIDAndPath(ctx context.Context, runID int, path string) (*ArtifactRow, error) {
+	for _, a := range d.artifacts {
+		if a.Path == path {
+			return &a, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (d *artifactTypeDAO) GetArtifactVersions(ctx context.Context, runID int, path string) ([]ArtifactRow, error) {
+	return nil, nil
+}
+
+func (d *artifactTypeDAO) SetArtifactContentType(ctx context.Context, runID int, path, artifactType, contentType string) error {
+	for i := range d.artifacts {
+		if d.artifacts[i].Path == path {
+			d.artifacts[i].Type, d.artifacts[i].ContentType = artifactType, contentType
+		}
+	}
+	return nil
+}
+
+func TestHandleSetArtifactType(t *testing.T) {
+	defer func(d DAO, s ArtifactStore) { dao, artifactStore = d, s }(dao, artifactStore)
+	store, err := newFileArtifactStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	artifactStore = store
+	uri, _, _, err := storeArtifact("run-1", "checkpoint", 1, bytes.NewReader(torchZipHead))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake := &artifactTypeDAO{artifacts: []ArtifactRow{{Path: "checkpoint", URI: uri, Type: "unknown", ContentType: "text/plain; charset=utf-8"}}}
+	dao = fake
+
+	set := func(contentType string) *httptest.ResponseRecorder {
+		form := url.Values{"path": {"checkpoint"}, "content_type": {contentType}}
+		r := httptest.NewRequest(http.MethodPost, "/runs/run-1/artifact-type", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		if err := handleSetArtifactType(w, r, "run-1"); err != nil {
+			t.Fatalf("handleSetArtifactType failed: %v", err)
+		}
+		return w
+	}
+
+	// The artifacts tab is shown again with the artifact in its new viewer
+	w := set("image/png")
+	if w.Code != http.StatusOK || fake.artifacts[0].ContentType != "image/png" || fake.artifacts[0].Type != "image" {
+		t.Fatalf("Expected the artifact to become an image, got %d %+v", w.Code, fake.artifacts[0])
+	}
+	if body := w.Body.String(); !strings.Contains(body, `<img src="/artifacts/blob?uri=`) || !strings.Contains(body, `<option value="image/png" selected>`) {
+		t.Errorf("Expected the artifact shown as an image, got %s", body)
+	}
+
+	// Types are detected from the contents again on request
+	set("")
+	if fake.artifacts[0].ContentType != contentTypePyTorch || fake.artifacts[0].Type != "unknown" {
+		t.Errorf("Expected the artifact detected as a PyTorch checkpoint, got %+v", fake.artifacts[0])
+	}
+
+	if w := set("application/x-made-up"); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an unknown content type, got %d", w.Code)
+	}
+}
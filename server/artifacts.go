@@ -1,17 +1,22 @@
 package main
 
 import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"log"
 	"os"
 	"path/filepath"
 	"regexp"
 	"strings"
+	"time"
 )
 
-var artifactStorePath string
-
 // validArtifactPathPattern matches paths containing only safe characters:
 // alphanumerics, hyphens, underscores, dots, and forward slashes.
 var validArtifactPathPattern = regexp.MustCompile(`^[a-zA-Z0-9_.\-/]+$`)
@@ -34,51 +39,338 @@ func isValidArtifactPath(path string) error {
 	return nil
 }
 
-func initArtifactStore(uri string) {
-	if strings.HasPrefix(uri, "file://") {
-		artifactStorePath = strings.TrimPrefix(uri, "file://")
-	} else {
-		log.Fatalf("Invalid artifacts store URI format. Expected file:///path/to/store, got: %s", uri)
+// ArtifactStore stores artifact contents under keys of the form
+// "{runUUID}/{artifactPath}", or artifactVersionKey's for later versions.
+// Each store records artifacts under URIs with its own scheme, which URI and
+// Key convert to and from keys.
+type ArtifactStore interface {
+	// Put stores the contents of r under key, returning the number of bytes
+	// stored
+	Put(key string, r io.Reader) (int64, error)
+	// Get opens the contents stored under key. It returns an error wrapping
+	// fs.ErrNotExist if there are none.
+	Get(key string) (io.ReadCloser, error)
+	// List returns the keys that start with prefix
+	List(prefix string) ([]string, error)
+	// Walk calls fn for the contents stored under each key that starts with
+	// prefix, stopping at the first error fn returns
+	Walk(prefix string, fn func(info ArtifactInfo) error) error
+	// Size returns the number of bytes stored under key. It returns an error
+	// wrapping fs.ErrNotExist if there are none.
+	Size(key string) (int64, error)
+	// Delete removes the contents stored under key, if any
+	Delete(key string) error
+	// URI returns the URI an artifact stored under key is recorded with
+	URI(key string) string
+	// Key resolves an artifact URI to its key, returning errArtifactURIOutsideStore
+	// if it refers to a location outside the store
+	Key(uri string) (string, error)
+}
+
+// ArtifactInfo describes the contents stored under a key
+type ArtifactInfo struct {
+	Key        string
+	Size       int64
+	ModifiedAt time.Time
+}
+
+// artifactURLSigner is implemented by artifact stores that can grant
+// temporary read access to an artifact at a URL of their own
+type artifactURLSigner interface {
+	PresignGet(key string, expires time.Duration) (string, error)
+}
+
+// artifactContextGetter is implemented by artifact stores that can stop
+// reading an artifact when what it is read for, e.g. a request, is done
+type artifactContextGetter interface {
+	GetContext(ctx context.Context, key string) (io.ReadCloser, error)
+}
+
+// getArtifact opens the contents stored under key in a store, for as long as
+// ctx lasts if the store supports it
+func getArtifact(ctx context.Context, store ArtifactStore, key string) (io.ReadCloser, error) {
+	if getter, ok := store.(artifactContextGetter); ok {
+		return getter.GetContext(ctx, key)
 	}
+	return store.Get(key)
+}
 
-	err := os.MkdirAll(artifactStorePath, os.ModePerm)
+// artifactDirectoryRemover is implemented by artifact stores that have
+// directories, which deleting every key beneath a prefix would leave behind
+type artifactDirectoryRemover interface {
+	RemoveDirectory(prefix string) error
+}
+
+// artifactPresignExpiry is how long presigned artifact URLs remain valid
+const artifactPresignExpiry = 15 * time.Minute
+
+// errArtifactURIOutsideStore is returned for artifact URIs that refer to
+// locations outside the artifact store
+var errArtifactURIOutsideStore = errors.New("artifact URI is outside the artifact store")
+
+var artifactStore ArtifactStore
+
+// newArtifactStore creates the artifact store at a file:// or s3:// URI
+func newArtifactStore(uri string) (ArtifactStore, error) {
+	switch {
+	case strings.HasPrefix(uri, "file://"):
+		return newFileArtifactStore(strings.TrimPrefix(uri, "file://"))
+	case strings.HasPrefix(uri, "s3://"):
+		return newS3ArtifactStoreFromEnv(uri)
+	default:
+		return nil, fmt.Errorf("invalid artifact store URI format. Expected file:///path/to/store or s3://bucket/prefix, got: %s", uri)
+	}
+}
+
+func initArtifactStore(uri string) {
+	var err error
+	artifactStore, err = newArtifactStore(uri)
 	if err != nil {
 		log.Fatalf("Could not create artifact store: %v", err)
 	}
 
-	log.Printf("Artifact store initialized at: %s", artifactStorePath)
+	log.Printf("Artifact store initialized at: %s", uri)
+}
+
+// fileArtifactStore stores artifacts as files beneath a root directory. Its
+// URIs are file:// URIs relative to the root, so that the root can be moved
+// without rewriting them.
+type fileArtifactStore struct {
+	root string
+}
+
+func newFileArtifactStore(root string) (*fileArtifactStore, error) {
+	if err := os.MkdirAll(root, os.ModePerm); err != nil {
+		return nil, err
+	}
+	return &fileArtifactStore{root: root}, nil
+}
+
+// path returns the file a key is stored at
+func (s *fileArtifactStore) path(key string) string {
+	return filepath.Join(s.root, filepath.FromSlash(key))
+}
+
+func (s *fileArtifactStore) Put(key string, r io.Reader) (int64, error) {
+	fullPath := s.path(key)
+	if err := os.MkdirAll(filepath.Dir(fullPath), os.ModePerm); err != nil {
+		return 0, fmt.Errorf("failed to create artifact directory: %v", err)
+	}
+
+	file, err := os.Create(fullPath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create artifact file: %v", err)
+	}
+	defer file.Close()
+
+	size, err := io.Copy(file, r)
+	if err != nil {
+		return 0, fmt.Errorf("failed to write artifact data: %v", err)
+	}
+	return size, nil
+}
+
+func (s *fileArtifactStore) Get(key string) (io.ReadCloser, error) {
+	return os.Open(s.path(key))
+}
+
+func (s *fileArtifactStore) List(prefix string) ([]string, error) {
+	var keys []string
+	err := s.Walk(prefix, func(info ArtifactInfo) error {
+		keys = append(keys, info.Key)
+		return nil
+	})
+	return keys, err
+}
+
+func (s *fileArtifactStore) Walk(prefix string, fn func(info ArtifactInfo) error) error {
+	// Only the directory the prefix names, e.g. "{run uuid}/" of
+	// "{run uuid}/plots/a", holds keys starting with it
+	dir := s.root
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		dir = s.path(prefix[:i])
+		if _, err := os.Stat(dir); errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+	}
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(s.root, path)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		return fn(ArtifactInfo{Key: key, Size: info.Size(), ModifiedAt: info.ModTime()})
+	})
+}
+
+func (s *fileArtifactStore) Size(key string) (int64, error) {
+	info, err := os.Stat(s.path(key))
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+func (s *fileArtifactStore) Delete(key string) error {
+	err := os.Remove(s.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// RemoveDirectory removes the directory a prefix such as "{run uuid}/"
+// names, with everything beneath it
+func (s *fileArtifactStore) RemoveDirectory(prefix string) error {
+	rel, err := filepath.Rel(s.root, s.path(prefix))
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("refusing to remove %q: not a directory inside the artifact store", prefix)
+	}
+	return os.RemoveAll(s.path(prefix))
+}
+
+func (s *fileArtifactStore) URI(key string) string {
+	return "file://" + key
+}
+
+func (s *fileArtifactStore) Key(uri string) (string, error) {
+	if !strings.HasPrefix(uri, "file://") {
+		return "", fmt.Errorf("expected a file:// artifact URI, got %q", uri)
+	}
+	key := strings.TrimPrefix(uri, "file://")
+	root, path := s.root, filepath.Join(s.root, filepath.FromSlash(key))
+	// Absolute URIs name a file by its full path, which must still be beneath
+	// the root
+	if filepath.IsAbs(key) {
+		var err error
+		if root, err = filepath.Abs(s.root); err != nil {
+			return "", err
+		}
+		path = filepath.Clean(filepath.FromSlash(key))
+	}
+	// Ensure the path is within the artifact store to prevent path traversal
+	rel, err := filepath.Rel(root, path)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", errArtifactURIOutsideStore
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+// artifactVersionsDir holds the contents of artifacts uploaded over others.
+// Artifact paths cannot contain '~', so it never holds an artifact itself.
+const artifactVersionsDir = "~versions"
+
+// artifactVersionKey is the key a version of an artifact is stored under.
+// First versions are stored under "{runUUID}/{artifactPath}", and later ones
+// under "{runUUID}/~versions/{version}/{artifactPath}" so that a failed
+// upload never clobbers the contents it was replacing.
+func artifactVersionKey(runUUID, artifactPath string, version int) string {
+	if version <= 1 {
+		return runUUID + "/" + artifactPath
+	}
+	return fmt.Sprintf("%s/%s/%d/%s", runUUID, artifactVersionsDir, version, artifactPath)
 }
 
-// storeArtifact saves a file to the artifact store and returns its URI
-func storeArtifact(runUUID string, artifactPath string, fileData io.Reader) (string, error) {
+// storeArtifact saves a version of a file to the artifact store beneath a
+// run's key, from runArtifactKey, and returns its URI, size in bytes, and hex
+// SHA-256 digest
+func storeArtifact(runUUID string, artifactPath string, version int, fileData io.Reader) (string, int64, string, error) {
 	if err := isValidArtifactPath(artifactPath); err != nil {
-		return "", fmt.Errorf("invalid artifact path: %w", err)
+		return "", 0, "", fmt.Errorf("invalid artifact path: %w", err)
 	}
 
-	// Create directory structure: {artifactStorePath}/{runUUID}/{dir-of-artifactPath}
-	fullDir := filepath.Join(artifactStorePath, runUUID, filepath.Dir(artifactPath))
-	err := os.MkdirAll(fullDir, os.ModePerm)
+	key := artifactVersionKey(runUUID, artifactPath, version)
+	digest := sha256.New()
+	size, err := artifactStore.Put(key, io.TeeReader(fileData, digest))
 	if err != nil {
-		return "", fmt.Errorf("failed to create artifact directory: %v", err)
+		return "", 0, "", err
 	}
+	return artifactStore.URI(key), size, hex.EncodeToString(digest.Sum(nil)), nil
+}
 
-	// Full file path
-	fullPath := filepath.Join(artifactStorePath, runUUID, artifactPath)
+// nextArtifactVersion is the version the next upload to an artifact's path
+// will be
+func nextArtifactVersion(ctx context.Context, runID int, artifactPath string) (int, error) {
+	existing, err := dao.GetArtifactByRunIDAndPath(ctx, runID, artifactPath)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 1, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return existing.Version + 1, nil
+}
 
-	// Create file
-	file, err := os.Create(fullPath)
+// deleteArtifactPrefix deletes everything a store holds beneath a prefix
+func deleteArtifactPrefix(store ArtifactStore, prefix string) error {
+	if remover, ok := store.(artifactDirectoryRemover); ok {
+		return remover.RemoveDirectory(prefix)
+	}
+	keys, err := store.List(prefix)
 	if err != nil {
-		return "", fmt.Errorf("failed to create artifact file: %v", err)
+		return err
 	}
-	defer file.Close()
+	for _, key := range keys {
+		if err := store.Delete(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// artifactKey resolves the URI an artifact is recorded under to its key in the
+// artifact store. Artifacts stored before the artifact store was pluggable
+// were recorded under their bare keys.
+func artifactKey(uri string) (string, error) {
+	if !strings.Contains(uri, "://") {
+		return uri, nil
+	}
+	return artifactStore.Key(uri)
+}
 
-	// Copy data to file
-	_, err = io.Copy(file, fileData)
+// openArtifact opens the contents of the artifact recorded under uri from the
+// nearest store holding a copy, falling back to the others if it cannot be read
+func openArtifact(ctx context.Context, uri string) (io.ReadCloser, error) {
+	key, err := artifactKey(uri)
 	if err != nil {
-		return "", fmt.Errorf("failed to write artifact data: %v", err)
+		return nil, err
+	}
+	var firstErr error
+	for _, store := range artifactCopies(ctx, uri) {
+		file, err := getArtifact(ctx, store, key)
+		if err == nil {
+			return file, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
 	}
+	return nil, firstErr
+}
 
-	// Return relative path within the artifact store
-	relativePath := filepath.Join(runUUID, artifactPath)
-	return relativePath, nil
+// formatBytes renders a byte count with a binary unit suffix, e.g. "1.5 MiB"
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
 }
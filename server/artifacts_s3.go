@@ -0,0 +1,512 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// s3PartSize is the size of the parts artifacts are uploaded to S3 in.
+// Artifacts no larger than one part are uploaded with a single request.
+const s3PartSize = 8 << 20
+
+// s3ResponseHeaderTimeout is how long S3 has to start responding to a
+// request once it is sent
+const s3ResponseHeaderTimeout = time.Minute
+
+// s3ReadIdleTimeout is how long reading a response from S3 can go without
+// receiving anything before it is abandoned. Responses are not limited in
+// how long they take overall, so that large artifacts can be streamed.
+var s3ReadIdleTimeout = time.Minute
+
+// emptyPayloadHash is the SHA-256 hash of an empty request body
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// s3Credentials sign requests to S3 with AWS Signature Version 4
+type s3Credentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	Region          string
+}
+
+// s3ArtifactStore stores artifacts as objects beneath a prefix of an S3
+// bucket. Its URIs are s3://bucket/prefix/key.
+type s3ArtifactStore struct {
+	bucket string
+	prefix string
+	// endpoint is set for S3-compatible services, which are addressed with
+	// path-style URLs; AWS is addressed with virtual-hosted-style URLs
+	endpoint    *url.URL
+	credentials s3Credentials
+	client      *http.Client
+	now         func() time.Time
+}
+
+// newS3ArtifactStoreFromEnv creates an S3 artifact store for an
+// s3://bucket/prefix URI, configured by the standard AWS environment
+// variables. AWS_ENDPOINT_URL selects an S3-compatible service such as MinIO.
+func newS3ArtifactStoreFromEnv(uri string) (*s3ArtifactStore, error) {
+	bucket, prefix, _ := strings.Cut(strings.TrimPrefix(uri, "s3://"), "/")
+	if bucket == "" {
+		return nil, fmt.Errorf("S3 artifact store URI %q has no bucket", uri)
+	}
+
+	creds := s3Credentials{
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		Region:          os.Getenv("AWS_REGION"),
+	}
+	if creds.Region == "" {
+		creds.Region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if creds.Region == "" {
+		creds.Region = "us-east-1"
+	}
+	if creds.AccessKeyID == "" || creds.SecretAccessKey == "" {
+		return nil, fmt.Errorf("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set for an S3 artifact store")
+	}
+
+	var endpoint *url.URL
+	if e := os.Getenv("AWS_ENDPOINT_URL"); e != "" {
+		var err error
+		if endpoint, err = url.Parse(e); err != nil {
+			return nil, fmt.Errorf("invalid AWS_ENDPOINT_URL %q: %w", e, err)
+		}
+	}
+	return newS3ArtifactStore(bucket, prefix, endpoint, creds), nil
+}
+
+func newS3ArtifactStore(bucket, prefix string, endpoint *url.URL, creds s3Credentials) *s3ArtifactStore {
+	return &s3ArtifactStore{
+		bucket:      bucket,
+		prefix:      strings.Trim(prefix, "/"),
+		endpoint:    endpoint,
+		credentials: creds,
+		client:      &http.Client{Transport: newS3Transport()},
+		now:         time.Now,
+	}
+}
+
+// newS3Transport is the transport of requests to S3, with the default
+// transport's dial and TLS handshake timeouts, and a limit on how long S3 has
+// to respond
+func newS3Transport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = s3ResponseHeaderTimeout
+	return transport
+}
+
+// objectKey is the S3 object key an artifact key is stored under
+func (s *s3ArtifactStore) objectKey(key string) string {
+	if s.prefix == "" {
+		return key
+	}
+	return s.prefix + "/" + key
+}
+
+// objectURL is the URL of an object, or of the bucket if objectKey is empty
+func (s *s3ArtifactStore) objectURL(objectKey string, query url.Values) *url.URL {
+	var u url.URL
+	if s.endpoint != nil {
+		u = *s.endpoint
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/" + s.bucket + "/" + objectKey
+	} else {
+		u = url.URL{
+			Scheme: "https",
+			Host:   fmt.Sprintf("%s.s3.%s.amazonaws.com", s.bucket, s.credentials.Region),
+			Path:   "/" + objectKey,
+		}
+	}
+	u.RawPath = s3EscapePath(u.Path)
+	u.RawQuery = s3CanonicalQuery(query)
+	return &u
+}
+
+// do signs and sends a request, returning an error for non-2xx responses. The
+// caller must close the body of a successful response.
+func (s *s3ArtifactStore) do(method string, u *url.URL, body []byte) (*http.Response, error) {
+	return s.doContext(context.Background(), method, u, body)
+}
+
+// doContext is do for as long as ctx lasts. Reading the response body is
+// abandoned if it stalls for s3ReadIdleTimeout.
+func (s *s3ArtifactStore) doContext(ctx context.Context, method string, u *url.URL, body []byte) (*http.Response, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	payloadHash := emptyPayloadHash
+	if len(body) > 0 {
+		sum := sha256.Sum256(body)
+		payloadHash = hex.EncodeToString(sum[:])
+	}
+	signS3Request(req, payloadHash, s.credentials, s.now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		defer cancel()
+		defer resp.Body.Close()
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		if resp.StatusCode == http.StatusNotFound {
+			return nil, fmt.Errorf("S3 %s %s: %w", method, u.Path, fs.ErrNotExist)
+		}
+		return nil, fmt.Errorf("S3 %s %s failed with status %d: %s", method, u.Path, resp.StatusCode, bytes.TrimSpace(message))
+	}
+	resp.Body = &s3ResponseBody{ReadCloser: resp.Body, cancel: cancel, idle: time.AfterFunc(s3ReadIdleTimeout, cancel)}
+	return resp, nil
+}
+
+// s3ResponseBody cancels its request once it is closed, or once reading it
+// stalls for s3ReadIdleTimeout
+type s3ResponseBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+	idle   *time.Timer
+}
+
+func (b *s3ResponseBody) Read(p []byte) (int, error) {
+	b.idle.Reset(s3ReadIdleTimeout)
+	return b.ReadCloser.Read(p)
+}
+
+func (b *s3ResponseBody) Close() error {
+	b.idle.Stop()
+	defer b.cancel()
+	return b.ReadCloser.Close()
+}
+
+// Put uploads r in a single request if it fits in one part, and as a
+// multipart upload otherwise, so artifacts are streamed to S3 without being
+// held in memory in full
+func (s *s3ArtifactStore) Put(key string, r io.Reader) (int64, error) {
+	objectKey := s.objectKey(key)
+	buf := make([]byte, s3PartSize)
+	n, err := io.ReadFull(r, buf)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		resp, err := s.do(http.MethodPut, s.objectURL(objectKey, nil), buf[:n])
+		if err != nil {
+			return 0, err
+		}
+		resp.Body.Close()
+		return int64(n), nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read artifact data: %v", err)
+	}
+
+	var created struct {
+		UploadID string `xml:"UploadId"`
+	}
+	if err := s.doXML(http.MethodPost, s.objectURL(objectKey, url.Values{"uploads": {""}}), nil, &created); err != nil {
+		return 0, err
+	}
+	abort := func() {
+		if resp, err := s.do(http.MethodDelete, s.objectURL(objectKey, url.Values{"uploadId": {created.UploadID}}), nil); err == nil {
+			resp.Body.Close()
+		}
+	}
+
+	type completedPart struct {
+		PartNumber int
+		ETag       string
+	}
+	var parts []completedPart
+	var size int64
+	for partNumber := 1; n > 0; partNumber++ {
+		query := url.Values{"partNumber": {strconv.Itoa(partNumber)}, "uploadId": {created.UploadID}}
+		resp, err := s.do(http.MethodPut, s.objectURL(objectKey, query), buf[:n])
+		if err != nil {
+			abort()
+			return 0, err
+		}
+		resp.Body.Close()
+		parts = append(parts, completedPart{partNumber, resp.Header.Get("ETag")})
+		size += int64(n)
+
+		n, err = io.ReadFull(r, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			abort()
+			return 0, fmt.Errorf("failed to read artifact data: %v", err)
+		}
+	}
+
+	complete, err := xml.Marshal(struct {
+		XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+		Parts   []completedPart `xml:"Part"`
+	}{Parts: parts})
+	if err != nil {
+		abort()
+		return 0, err
+	}
+	resp, err := s.do(http.MethodPost, s.objectURL(objectKey, url.Values{"uploadId": {created.UploadID}}), complete)
+	if err != nil {
+		abort()
+		return 0, err
+	}
+	resp.Body.Close()
+	return size, nil
+}
+
+// doXML sends a request and decodes its XML response into v
+func (s *s3ArtifactStore) doXML(method string, u *url.URL, body []byte, v interface{}) error {
+	resp, err := s.do(method, u, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return xml.NewDecoder(resp.Body).Decode(v)
+}
+
+func (s *s3ArtifactStore) Get(key string) (io.ReadCloser, error) {
+	return s.GetContext(context.Background(), key)
+}
+
+// GetContext opens the contents stored under key, which stop being read once
+// ctx is done
+func (s *s3ArtifactStore) GetContext(ctx context.Context, key string) (io.ReadCloser, error) {
+	resp, err := s.doContext(ctx, http.MethodGet, s.objectURL(s.objectKey(key), nil), nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (s *s3ArtifactStore) List(prefix string) ([]string, error) {
+	var keys []string
+	err := s.Walk(prefix, func(info ArtifactInfo) error {
+		keys = append(keys, info.Key)
+		return nil
+	})
+	return keys, err
+}
+
+// Walk lists objects a page at a time
+func (s *s3ArtifactStore) Walk(prefix string, fn func(info ArtifactInfo) error) error {
+	query := url.Values{"list-type": {"2"}, "prefix": {s.objectKey(prefix)}}
+	for {
+		var page struct {
+			Contents []struct {
+				Key          string
+				Size         int64
+				LastModified time.Time
+			}
+			IsTruncated           bool
+			NextContinuationToken string
+		}
+		if err := s.doXML(http.MethodGet, s.objectURL("", query), nil, &page); err != nil {
+			return err
+		}
+		for _, c := range page.Contents {
+			info := ArtifactInfo{Key: strings.TrimPrefix(c.Key, s.objectKey("")), Size: c.Size, ModifiedAt: c.LastModified}
+			if err := fn(info); err != nil {
+				return err
+			}
+		}
+		if !page.IsTruncated {
+			return nil
+		}
+		query.Set("continuation-token", page.NextContinuationToken)
+	}
+}
+
+func (s *s3ArtifactStore) Size(key string) (int64, error) {
+	resp, err := s.do(http.MethodHead, s.objectURL(s.objectKey(key), nil), nil)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.ContentLength, nil
+}
+
+func (s *s3ArtifactStore) Delete(key string) error {
+	resp, err := s.do(http.MethodDelete, s.objectURL(s.objectKey(key), nil), nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *s3ArtifactStore) URI(key string) string {
+	return "s3://" + s.bucket + "/" + s.objectKey(key)
+}
+
+func (s *s3ArtifactStore) Key(uri string) (string, error) {
+	if !strings.HasPrefix(uri, "s3://") {
+		return "", fmt.Errorf("expected an s3:// artifact URI, got %q", uri)
+	}
+	root := s.URI("")
+	if !strings.HasPrefix(uri, root) {
+		return "", errArtifactURIOutsideStore
+	}
+	key := strings.TrimPrefix(uri, root)
+	for _, segment := range strings.Split(key, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return "", errArtifactURIOutsideStore
+		}
+	}
+	return key, nil
+}
+
+// PresignGet returns a URL that grants read access to an artifact until it
+// expires
+func (s *s3ArtifactStore) PresignGet(key string, expires time.Duration) (string, error) {
+	return presignS3URL(s.objectURL(s.objectKey(key), nil), s.credentials, s.now(), expires), nil
+}
+
+// s3EscapePath URI-encodes each segment of a path as Signature Version 4
+// requires
+func s3EscapePath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		segments[i] = s3Escape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// s3Escape URI-encodes everything but unreserved characters
+func s3Escape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// s3CanonicalQuery encodes query parameters sorted by name
+func s3CanonicalQuery(query url.Values) string {
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var pairs []string
+	for _, name := range names {
+		values := append([]string(nil), query[name]...)
+		sort.Strings(values)
+		for _, v := range values {
+			pairs = append(pairs, s3Escape(name)+"="+s3Escape(v))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// s3SigningKey derives the Signature Version 4 key for a day and region
+func s3SigningKey(creds s3Credentials, date string) []byte {
+	mac := func(key []byte, data string) []byte {
+		h := hmac.New(sha256.New, key)
+		h.Write([]byte(data))
+		return h.Sum(nil)
+	}
+	key := mac([]byte("AWS4"+creds.SecretAccessKey), date)
+	key = mac(key, creds.Region)
+	key = mac(key, "s3")
+	return mac(key, "aws4_request")
+}
+
+// s3Signature signs a canonical request made at t, returning the credential
+// scope and the signature
+func s3Signature(canonicalRequest string, creds s3Credentials, t time.Time) (string, string) {
+	date := t.UTC().Format("20060102")
+	scope := date + "/" + creds.Region + "/s3/aws4_request"
+	hashed := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + t.UTC().Format("20060102T150405Z") + "\n" + scope + "\n" + hex.EncodeToString(hashed[:])
+	h := hmac.New(sha256.New, s3SigningKey(creds, date))
+	h.Write([]byte(stringToSign))
+	return scope, hex.EncodeToString(h.Sum(nil))
+}
+
+// signS3Request adds Signature Version 4 authorization to a request, signing
+// its host, range, and x-amz-* headers
+func signS3Request(req *http.Request, payloadHash string, creds s3Credentials, t time.Time) {
+	req.Header.Set("X-Amz-Date", t.UTC().Format("20060102T150405Z"))
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		name = strings.ToLower(name)
+		if name == "range" || strings.HasPrefix(name, "x-amz-") {
+			headers[name] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope, signature := s3Signature(canonicalRequest, creds, t)
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		creds.AccessKeyID, scope, signedHeaders, signature))
+}
+
+// presignS3URL returns a copy of a GET URL signed with Signature Version 4
+// query parameters, valid for expires from t
+func presignS3URL(u *url.URL, creds s3Credentials, t time.Time, expires time.Duration) string {
+	query := u.Query()
+	query.Set("X-Amz-Algorithm", "AWS4-HMAC-SHA256")
+	query.Set("X-Amz-Credential", creds.AccessKeyID+"/"+t.UTC().Format("20060102")+"/"+creds.Region+"/s3/aws4_request")
+	query.Set("X-Amz-Date", t.UTC().Format("20060102T150405Z"))
+	query.Set("X-Amz-Expires", strconv.Itoa(int(expires.Seconds())))
+	query.Set("X-Amz-SignedHeaders", "host")
+	if creds.SessionToken != "" {
+		query.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+
+	canonicalRequest := strings.Join([]string{
+		http.MethodGet,
+		u.EscapedPath(),
+		s3CanonicalQuery(query),
+		"host:" + u.Host + "\n",
+		"host",
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+	_, signature := s3Signature(canonicalRequest, creds, t)
+	query.Set("X-Amz-Signature", signature)
+
+	signed := *u
+	signed.RawQuery = s3CanonicalQuery(query)
+	return signed.String()
+}
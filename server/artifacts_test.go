@@ -1,16 +1,23 @@
 package main
 
 import (
+	"errors"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestAssembleArtifactsTree(t *testing.T) {
 	artifacts := []Artifact{
-		{"file1.txt", "abc1", "text"},
-		{"plots/1.png", "abc2", "image"},
-		{"plots/2.png", "abc3", "image"},
-		{"plots/barcharts/G.png", "abc4", "image"},
-		{"plots/barcharts/H.png", "abc5", "image"},
+		{Path: "file1.txt", URI: "abc1", Type: "text", Size: 100},
+		{Path: "plots/1.png", URI: "abc2", Type: "image", Size: 10},
+		{Path: "plots/2.png", URI: "abc3", Type: "image", Size: 20},
+		{Path: "plots/barcharts/G.png", URI: "abc4", Type: "image", Size: 30},
+		{Path: "plots/barcharts/H.png", URI: "abc5", Type: "image", Size: 40},
 	}
 	result := assembleArtifactsTree("foo-uuid", artifacts)
 	if *(*result.Children["file1.txt"]).ArtifactURI != "abc1" {
@@ -32,6 +39,53 @@ func TestAssembleArtifactsTree(t *testing.T) {
 	if *(*result.Children["plots"].Children["1.png"]).RunUUID != "foo-uuid" {
 		t.Error("Failure  ")
 	}
+
+	if result.Size != 200 {
+		t.Errorf("expected root size 200, got %d", result.Size)
+	}
+	if result.Children["plots"].Size != 100 || result.Children["plots"].Children["barcharts"].Size != 70 {
+		t.Errorf("directory sizes are not cumulative: plots=%d barcharts=%d",
+			result.Children["plots"].Size, result.Children["plots"].Children["barcharts"].Size)
+	}
+	if result.Entries[0].Name != "file1.txt" || result.Entries[1].Name != "plots" {
+		t.Errorf("expected entries sorted by name by default, got %+v", result.Entries)
+	}
+	if barcharts := result.Children["plots"].Children["barcharts"]; barcharts.Path != "plots/barcharts" || barcharts.Children["G.png"].Path != "plots/barcharts/G.png" {
+		t.Errorf("expected nodes to know their paths, got %q and %q", barcharts.Path, barcharts.Children["G.png"].Path)
+	}
+}
+
+func TestSortArtifactsTree(t *testing.T) {
+	now := time.Now()
+	artifacts := []Artifact{
+		{Path: "a.txt", URI: "abc1", Type: "text", Size: 5, ModifiedAt: now.Add(-time.Hour)},
+		{Path: "b.txt", URI: "abc2", Type: "text", Size: 50, ModifiedAt: now.Add(-2 * time.Hour)},
+		{Path: "dir/c.txt", URI: "abc3", Type: "text", Size: 20, ModifiedAt: now},
+	}
+	tree := assembleArtifactsTree("foo-uuid", artifacts)
+
+	names := func(node ArtifactsTreeNode) []string {
+		var out []string
+		for _, e := range node.Entries {
+			out = append(out, e.Name)
+		}
+		return out
+	}
+
+	sortArtifactsTree(&tree, "size")
+	if got := names(tree); !slices.Equal(got, []string{"b.txt", "dir", "a.txt"}) {
+		t.Errorf("sort by size: got %v", got)
+	}
+
+	sortArtifactsTree(&tree, "modified")
+	if got := names(tree); !slices.Equal(got, []string{"dir", "a.txt", "b.txt"}) {
+		t.Errorf("sort by modified: got %v", got)
+	}
+
+	sortArtifactsTree(&tree, "")
+	if got := names(tree); !slices.Equal(got, []string{"a.txt", "b.txt", "dir"}) {
+		t.Errorf("sort by name: got %v", got)
+	}
 }
 
 func TestIsValidArtifactPath(t *testing.T) {
@@ -79,3 +133,127 @@ func TestIsValidArtifactPath(t *testing.T) {
 		})
 	}
 }
+
+func TestFormatBytes(t *testing.T) {
+	tests := []struct {
+		n    int64
+		want string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1536, "1.5 KiB"},
+		{5 * 1024 * 1024, "5.0 MiB"},
+		{3 << 30, "3.0 GiB"},
+	}
+	for _, tt := range tests {
+		if got := formatBytes(tt.n); got != tt.want {
+			t.Errorf("formatBytes(%d) = %q, want %q", tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestFileArtifactStore(t *testing.T) {
+	store, err := newFileArtifactStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("newFileArtifactStore failed: %v", err)
+	}
+
+	for _, key := range []string{"run1/a.txt", "run1/plots/b.png", "run2/c.txt"} {
+		size, err := store.Put(key, strings.NewReader(key))
+		if err != nil || size != int64(len(key)) {
+			t.Fatalf("Put %s returned %d, %v", key, size, err)
+		}
+	}
+
+	r, err := store.Get("run1/plots/b.png")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	content, _ := io.ReadAll(r)
+	r.Close()
+	if string(content) != "run1/plots/b.png" {
+		t.Errorf("Unexpected content %q", content)
+	}
+
+	keys, err := store.List("run1/")
+	slices.Sort(keys)
+	if err != nil || !slices.Equal(keys, []string{"run1/a.txt", "run1/plots/b.png"}) {
+		t.Errorf("Unexpected keys %v (%v)", keys, err)
+	}
+
+	var walked []ArtifactInfo
+	err = store.Walk("run1/plots/", func(info ArtifactInfo) error {
+		walked = append(walked, info)
+		return nil
+	})
+	if err != nil || len(walked) != 1 || walked[0].Key != "run1/plots/b.png" || walked[0].Size != int64(len("run1/plots/b.png")) || walked[0].ModifiedAt.IsZero() {
+		t.Errorf("Unexpected walk %+v (%v)", walked, err)
+	}
+	stop := errors.New("stop")
+	visited := 0
+	err = store.Walk("", func(info ArtifactInfo) error {
+		visited++
+		return stop
+	})
+	if !errors.Is(err, stop) || visited != 1 {
+		t.Errorf("Expected Walk to stop at the first error, got %v after %d keys", err, visited)
+	}
+
+	if size, err := store.Size("run2/c.txt"); err != nil || size != int64(len("run2/c.txt")) {
+		t.Errorf("Unexpected size %d (%v)", size, err)
+	}
+
+	if key, err := store.Key(store.URI("run1/a.txt")); err != nil || key != "run1/a.txt" {
+		t.Errorf("Expected the URI to resolve to run1/a.txt, got %q (%v)", key, err)
+	}
+	if key, err := store.Key("file://" + filepath.ToSlash(store.root) + "/run1/a.txt"); err != nil || key != "run1/a.txt" {
+		t.Errorf("Expected an absolute URI beneath the root to resolve to run1/a.txt, got %q (%v)", key, err)
+	}
+	if _, err := store.Key("file://" + filepath.ToSlash(filepath.Dir(store.root)) + "/other/a.txt"); !errors.Is(err, errArtifactURIOutsideStore) {
+		t.Errorf("Expected an absolute URI outside the root to be rejected, got %v", err)
+	}
+
+	if err := store.Delete("run1/a.txt"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if err := store.Delete("run1/a.txt"); err != nil {
+		t.Errorf("Expected deleting a missing artifact to succeed, got %v", err)
+	}
+	if _, err := store.Get("run1/a.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Expected a deleted artifact not to exist, got %v", err)
+	}
+}
+
+func TestStoreArtifactDigest(t *testing.T) {
+	ctx := t.Context()
+	defer func(s ArtifactStore) { artifactStore = s }(artifactStore)
+	store, err := newFileArtifactStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	artifactStore = store
+
+	uri, size, digest, err := storeArtifact("run1", "hello.txt", 1, strings.NewReader("hello world"))
+	if err != nil {
+		t.Fatalf("storeArtifact failed: %v", err)
+	}
+	if uri != store.URI("run1/hello.txt") || size != 11 {
+		t.Errorf("storeArtifact = %q, %d", uri, size)
+	}
+	// sha256sum of "hello world"
+	if want := "b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9"; digest != want {
+		t.Errorf("storeArtifact digest = %s, want %s", digest, want)
+	}
+
+	// Later versions are stored apart from the first
+	uri, _, _, err = storeArtifact("run1", "hello.txt", 2, strings.NewReader("hello again"))
+	if err != nil {
+		t.Fatalf("storeArtifact failed: %v", err)
+	}
+	if uri != store.URI("run1/~versions/2/hello.txt") {
+		t.Errorf("Expected the second version under ~versions, got %q", uri)
+	}
+	if content, _, _ := readArtifactStart(ctx, store.URI("run1/hello.txt"), 100); string(content) != "hello world" {
+		t.Errorf("Expected the first version kept, got %q", content)
+	}
+}
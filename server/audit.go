@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+)
+
+// auditLogListLimit is how many recent entries the admin API lists
+const auditLogListLimit = 100
+
+// AuditLogEntry is an audit log entry in API form
+type AuditLogEntry struct {
+	ID        int             `json:"id"`
+	Action    string          `json:"action"`
+	Subject   string          `json:"subject"`
+	Details   json.RawMessage `json:"details"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// recordAudit records an administrative operation on subject in the audit
+// log. details is encoded as JSON.
+func recordAudit(ctx context.Context, action, subject string, details interface{}) error {
+	encoded, err := json.Marshal(details)
+	if err != nil {
+		return err
+	}
+	return dao.InsertAuditLogEntry(ctx, AuditLogRow{
+		Action:    action,
+		Subject:   subject,
+		Details:   string(encoded),
+		CreatedAt: time.Now().UTC(),
+	})
+}
+
+// handleAPIAuditLog lists the most recent audit log entries, at
+// GET /api/admin/audit-log
+func handleAPIAuditLog(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	rows, err := dao.GetAuditLog(r.Context(), auditLogListLimit)
+	if err != nil {
+		log.Printf("Failed to load audit log: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to load audit log"})
+		return
+	}
+	entries := make([]AuditLogEntry, 0, len(rows))
+	for _, row := range rows {
+		entries = append(entries, AuditLogEntry{
+			ID:        row.ID,
+			Action:    row.Action,
+			Subject:   row.Subject,
+			Details:   json.RawMessage(row.Details),
+			CreatedAt: row.CreatedAt,
+		})
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"entries": entries})
+}
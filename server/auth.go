@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// requireAuth makes every API endpoint require a bearer token created with
+// the token command. The admin token is accepted as well.
+var requireAuth bool
+
+// apiTokenPrefix marks apparatus API tokens, so that leaked ones are easy to
+// recognize
+const apiTokenPrefix = "apparatus_"
+
+// newAPIToken generates the secret of a new API token
+func newAPIToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return apiTokenPrefix + hex.EncodeToString(b), nil
+}
+
+// hashAPIToken is how API tokens are stored. Tokens are random rather than
+// chosen by people, so a fast unsalted hash is enough to keep a leaked
+// database from yielding usable tokens.
+func hashAPIToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// authorizeAPIRequest checks the request's bearer token, or the token the
+// browser signed in with for the web UI's own API calls, returning an error
+// message for the client if it is not authorized, or else who it acts as
+func authorizeAPIRequest(r *http.Request) (principal, string, error) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		cookie, err := r.Cookie(sessionCookie)
+		if err != nil || cookie.Value == "" {
+			return principal{}, "API token required", nil
+		}
+		token = cookie.Value
+	}
+	return authorizeToken(r.Context(), token)
+}
+
+// authorizeToken checks an API token, returning an error message if it is
+// not valid, or else who it acts as: an admin for the admin token, the user
+// it was issued to, or an editor
+func authorizeToken(ctx context.Context, token string) (principal, string, error) {
+	if adminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) == 1 {
+		return principal{Role: roleAdmin}, "", nil
+	}
+	row, err := dao.GetAPITokenByHash(ctx, hashAPIToken(token))
+	if err != nil {
+		return principal{}, "", err
+	}
+	if row == nil {
+		return principal{}, "Invalid API token", nil
+	}
+	if row.RevokedAt.Valid {
+		return principal{}, "API token has been revoked", nil
+	}
+	if row.KioskExperimentID.Valid {
+		return principal{}, "Kiosk tokens only open their experiment's wallboard at /kiosk", nil
+	}
+	p := principal{Role: roleEditor, ProjectID: int(row.ProjectID.Int64)}
+	if row.UserID.Valid {
+		user, err := dao.GetUserByID(ctx, int(row.UserID.Int64))
+		if err != nil {
+			return principal{}, "", err
+		}
+		if user == nil {
+			return principal{}, "API token's user has been deleted", nil
+		}
+		p.User = user.Name
+		var ok bool
+		if p.Role, ok = parseRole(user.Role); !ok {
+			return principal{}, "", fmt.Errorf("user %s has unknown role %q", user.Name, user.Role)
+		}
+	}
+	return p, "", nil
+}
+
+// authMiddleware rejects API requests without a valid token when the server
+// requires authentication, or whose token's role does not allow them, and
+// keeps requests with tokens scoped to a project within it
+func authMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !requireAuth {
+			next.ServeHTTP(w, r)
+			return
+		}
+		p, message, err := authorizeAPIRequest(r)
+		if err != nil {
+			log.Printf("Failed to check API token: %v", err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to check API token"})
+			return
+		}
+		if message != "" {
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("WWW-Authenticate", `Bearer realm="apparatus"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": message})
+			return
+		}
+		if need := requiredRole(r); p.Role < need {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusForbidden)
+			json.NewEncoder(w).Encode(map[string]string{"error": fmt.Sprintf("This requires the %s role", need)})
+			return
+		}
+		r = withPrincipal(r, p)
+		if p.ProjectID != 0 {
+			projectScopeMiddleware(p.ProjectID, next).ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// runTokenCommand manages API tokens: token create -name NAME [-project
+// PROJECT] [-user USER], token revoke -name NAME, and token list
+func runTokenCommand(ctx context.Context, args []string) {
+	usage := func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s token create|revoke|list [flags]\n\nManages the API tokens accepted when the server is started with -require-auth.\n", os.Args[0])
+		os.Exit(2)
+	}
+	if len(args) == 0 {
+		usage()
+	}
+	action := args[0]
+	if action != "create" && action != "revoke" && action != "list" {
+		usage()
+	}
+
+	flags := flag.NewFlagSet("token "+action, flag.ExitOnError)
+	configPath := defineConfigFlag(flags)
+	dbConnString := flags.String("db", "sqlite:///apparatus.db", "Database connection string (e.g., sqlite:///path/to/db.db)")
+	name := new(string)
+	if action != "list" {
+		name = flags.String("name", "", "Name of the token, e.g. the user or machine it is issued to")
+	}
+	project, user, kiosk := new(string), new(string), new(string)
+	if action == "create" {
+		project = flags.String("project", "", "Name or UUID of the project the token is limited to (default: every project)")
+		user = flags.String("user", "", "Name of the user the token is issued to, acting with their role (default: none, acting as an editor)")
+		kiosk = flags.String("kiosk", "", "UUID of an experiment to make a kiosk token for, which only opens the experiment's read-only wallboard at /kiosk")
+	}
+	flags.Parse(args[1:])
+	if action != "list" && *name == "" {
+		flags.Usage()
+		os.Exit(2)
+	}
+	if *kiosk != "" && (*project != "" || *user != "") {
+		log.Fatalf("A kiosk token cannot have a project or user")
+	}
+	if _, err := loadServerConfig(flags, *configPath); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	initDB(*dbConnString)
+
+	switch action {
+	case "create":
+		token, err := newAPIToken()
+		if err != nil {
+			log.Fatalf("Failed to generate token: %v", err)
+		}
+		if *kiosk != "" {
+			experimentID, err := dao.GetExperimentIDByUUID(ctx, *kiosk)
+			if err != nil {
+				log.Fatalf("Failed to find experiment %q: %v", *kiosk, err)
+			}
+			if err := dao.InsertKioskToken(ctx, *name, hashAPIToken(token), experimentID); err != nil {
+				log.Fatalf("Failed to save token %q (names cannot be reused): %v", *name, err)
+			}
+			// The wallboard link signs the kiosk in, so it is as secret as the token
+			fmt.Printf("%s\nOpen /kiosk?token=%s on the kiosk\n", token, token)
+			return
+		}
+		var projectID sql.NullInt64
+		if *project != "" {
+			p, err := findProject(ctx, *project)
+			if err != nil {
+				log.Fatalf("Failed to find project %q: %v", *project, err)
+			}
+			projectID = sql.NullInt64{Int64: int64(p.ID), Valid: true}
+		}
+		var userID sql.NullInt64
+		if *user != "" {
+			u, err := dao.GetUserByName(ctx, *user)
+			if err != nil {
+				log.Fatalf("Failed to find user %q: %v", *user, err)
+			}
+			if u == nil {
+				log.Fatalf("No user named %q; add users on the /admin/users page", *user)
+			}
+			userID = sql.NullInt64{Int64: int64(u.ID), Valid: true}
+		}
+		if err := dao.InsertAPIToken(ctx, *name, hashAPIToken(token), projectID, userID); err != nil {
+			log.Fatalf("Failed to save token %q (names cannot be reused): %v", *name, err)
+		}
+		// Only the hash is stored, so this is the one chance to see the token
+		fmt.Println(token)
+	case "revoke":
+		revoked, err := dao.RevokeAPIToken(ctx, *name)
+		if err != nil {
+			log.Fatalf("Failed to revoke token: %v", err)
+		}
+		if !revoked {
+			log.Fatalf("No unrevoked token named %q", *name)
+		}
+		fmt.Printf("Revoked token %q\n", *name)
+	case "list":
+		tokens, err := dao.GetAllAPITokens(ctx)
+		if err != nil {
+			log.Fatalf("Failed to list tokens: %v", err)
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		projectNames := make(map[int64]string)
+		projects, err := dao.GetAllProjects(ctx)
+		if err != nil {
+			log.Fatalf("Failed to list projects: %v", err)
+		}
+		for _, p := range projects {
+			projectNames[int64(p.ID)] = p.Name
+		}
+		userNames := make(map[int64]string)
+		users, err := dao.GetAllUsers(ctx)
+		if err != nil {
+			log.Fatalf("Failed to list users: %v", err)
+		}
+		for _, u := range users {
+			userNames[int64(u.ID)] = u.Name + " (" + u.Role + ")"
+		}
+		fmt.Fprintln(tw, "NAME\tPROJECT\tUSER\tCREATED\tREVOKED")
+		for _, t := range tokens {
+			project := "*"
+			if t.ProjectID.Valid {
+				project = projectNames[t.ProjectID.Int64]
+			}
+			user := "-"
+			if t.UserID.Valid {
+				user = userNames[t.UserID.Int64]
+			}
+			if t.KioskExperimentID.Valid {
+				user = "kiosk"
+			}
+			revoked := "-"
+			if t.RevokedAt.Valid {
+				revoked = t.RevokedAt.Time.Format(time.RFC3339)
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", t.Name, project, user, t.CreatedAt.Format(time.RFC3339), revoked)
+		}
+		tw.Flush()
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// apiTokenDAO serves API tokens from a map keyed by hash
+type apiTokenDAO struct {
+	DAO
+	tokens map[string]APITokenRow
+}
+
+func (d *apiTokenDAO) GetAPITokenByHash(ctx context.Context, tokenHash string) (*APITokenRow, error) {
+	if t, ok := d.tokens[tokenHash]; ok {
+		return &t, nil
+	}
+	return nil, nil
+}
+
+func TestNewAPIToken(t *testing.T) {
+	a, err := newAPIToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := newAPIToken()
+	if !strings.HasPrefix(a, apiTokenPrefix) || a == b {
+		t.Errorf("Expected distinct prefixed tokens, got %q and %q", a, b)
+	}
+	if hashAPIToken(a) == a || hashAPIToken(a) != hashAPIToken(a) {
+		t.Errorf("Expected a stable hash different from the token")
+	}
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	defer func(d DAO, required bool, admin string) { dao, requireAuth, adminToken = d, required, admin }(dao, requireAuth, adminToken)
+	dao = &apiTokenDAO{tokens: map[string]APITokenRow{
+		hashAPIToken("good"):    {Name: "ci"},
+		hashAPIToken("revoked"): {Name: "old", RevokedAt: sql.NullTime{Time: time.Now(), Valid: true}},
+		hashAPIToken("kiosk"):   {Name: "lab-tv", KioskExperimentID: sql.NullInt64{Int64: 1, Valid: true}},
+	}}
+	adminToken = "admin"
+	handler := authMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		name          string
+		required      bool
+		authorization string
+		wantStatus    int
+	}{
+		{"open server", false, "", http.StatusOK},
+		{"missing token", true, "", http.StatusUnauthorized},
+		{"not a bearer token", true, "good", http.StatusUnauthorized},
+		{"unknown token", true, "Bearer nope", http.StatusUnauthorized},
+		{"revoked token", true, "Bearer revoked", http.StatusUnauthorized},
+		{"kiosk token", true, "Bearer kiosk", http.StatusUnauthorized},
+		{"valid token", true, "Bearer good", http.StatusOK},
+		{"admin token", true, "Bearer admin", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requireAuth = tt.required
+			r := httptest.NewRequest(http.MethodPost, "/api/runs", nil)
+			if tt.authorization != "" {
+				r.Header.Set("Authorization", tt.authorization)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tt.wantStatus {
+				t.Errorf("Expected status %d, got %d: %s", tt.wantStatus, w.Code, w.Body.String())
+			}
+			if w.Code == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+				t.Error("Expected a WWW-Authenticate header on 401")
+			}
+		})
+	}
+}
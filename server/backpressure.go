@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Backpressure keeps an overloaded server stable by telling clients to slow
+// down rather than letting writes queue up until they time out. Ingestion
+// writes are refused with 429 Too Many Requests once too many are in flight,
+// and while writes take longer than the latency target every response
+// carries an X-Apparatus-Backoff header: how many milliseconds the client
+// should wait before its next write. Refusals carry the header too, along
+// with Retry-After, and metric streams carry the hint in their acks as
+// backoff_ms.
+
+// backoffHeader is the response header giving a suggested delay in
+// milliseconds before a client's next write
+const backoffHeader = "X-Apparatus-Backoff"
+
+// Bounds of the delay clients are asked to wait
+const (
+	minIngestionBackoff = 100 * time.Millisecond
+	maxIngestionBackoff = 30 * time.Second
+)
+
+// ingestionLatencyWeight is the weight of each write in the moving average of
+// write latency
+const ingestionLatencyWeight = 0.2
+
+var (
+	// ingestionMaxInFlight is how many ingestion writes are served at once
+	// before the server refuses more (0 is unlimited)
+	ingestionMaxInFlight = 64
+	// ingestionLatencyTarget is how long ingestion writes should take.
+	// Writes slower on average make the server ask clients to back off (0
+	// never asks).
+	ingestionLatencyTarget = time.Second
+)
+
+// ingestionLoad tracks how loaded the server is with ingestion writes: how
+// many are in flight and how long they have been taking
+type ingestionLoad struct {
+	maxInFlight   int
+	latencyTarget time.Duration
+
+	mu       sync.Mutex
+	inFlight int
+	// latency is a moving average of how long writes take
+	latency time.Duration
+}
+
+// ingestion is the server's ingestion load; see initIngestionBackpressure
+var ingestion = newIngestionLoad(ingestionMaxInFlight, ingestionLatencyTarget)
+
+func newIngestionLoad(maxInFlight int, latencyTarget time.Duration) *ingestionLoad {
+	return &ingestionLoad{maxInFlight: maxInFlight, latencyTarget: latencyTarget}
+}
+
+func initIngestionBackpressure() {
+	ingestion = newIngestionLoad(ingestionMaxInFlight, ingestionLatencyTarget)
+	if ingestionMaxInFlight > 0 {
+		log.Printf("Ingestion limited to %d concurrent writes", ingestionMaxInFlight)
+	}
+}
+
+// begin admits a write, returning a function to call when it is done, or
+// false if too many writes are in flight
+func (l *ingestionLoad) begin() (done func(), ok bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.maxInFlight > 0 && l.inFlight >= l.maxInFlight {
+		return nil, false
+	}
+	l.inFlight++
+	return l.finisher(), true
+}
+
+// track counts a write that cannot be refused, such as a metric stream's
+// flush, returning a function to call when it is done
+func (l *ingestionLoad) track() (done func()) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.inFlight++
+	return l.finisher()
+}
+
+// finisher returns the function that ends a write begun now
+func (l *ingestionLoad) finisher() func() {
+	start := time.Now()
+	return func() {
+		l.observe(time.Since(start))
+		l.mu.Lock()
+		l.inFlight--
+		l.mu.Unlock()
+	}
+}
+
+// observe records how long a write took
+func (l *ingestionLoad) observe(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.latency == 0 {
+		l.latency = d
+		return
+	}
+	l.latency += time.Duration(ingestionLatencyWeight * float64(d-l.latency))
+}
+
+// backoff is how long clients should wait before their next write, or 0 if
+// the server keeps up. The delay grows with how far past its limits the
+// server is: the average write latency times the overload.
+func (l *ingestionLoad) backoff() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var overload float64
+	if l.maxInFlight > 0 {
+		overload = float64(l.inFlight) / float64(l.maxInFlight)
+	}
+	if l.latencyTarget > 0 {
+		overload = math.Max(overload, float64(l.latency)/float64(l.latencyTarget))
+	}
+	if overload < 1 {
+		return 0
+	}
+	d := time.Duration(float64(l.latency) * overload)
+	return min(max(d, minIngestionBackoff), maxIngestionBackoff)
+}
+
+// backpressureMiddleware refuses ingestion writes while the server is
+// saturated, and asks the clients of the writes it serves to back off while
+// it is falling behind. Ingestion writes are those the journal records; see
+// isJournaled. Multipart artifact uploads are bounded by the artifact limits
+// instead.
+func backpressureMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isJournaled(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		backoff := ingestion.backoff()
+		done, ok := ingestion.begin()
+		if !ok {
+			log.Printf("Refused %s %s: too many writes in flight", r.Method, r.URL.Path)
+			writeOverloaded(w, backoff)
+			return
+		}
+		defer done()
+		if backoff > 0 {
+			w.Header().Set(backoffHeader, strconv.FormatInt(backoff.Milliseconds(), 10))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// writeOverloaded responds 429 to a write the server is too loaded to take,
+// suggesting a delay before it is retried
+func writeOverloaded(w http.ResponseWriter, backoff time.Duration) {
+	backoff = max(backoff, minIngestionBackoff)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(backoff.Seconds()))))
+	w.Header().Set(backoffHeader, strconv.FormatInt(backoff.Milliseconds(), 10))
+	w.WriteHeader(http.StatusTooManyRequests)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":          "The server is overloaded, retry later",
+		"retry_after_ms": backoff.Milliseconds(),
+	})
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIngestionLoadBackoff(t *testing.T) {
+	l := newIngestionLoad(2, 100*time.Millisecond)
+	if d := l.backoff(); d != 0 {
+		t.Errorf("Expected no backoff from an idle server, got %v", d)
+	}
+
+	first, ok := l.begin()
+	if !ok {
+		t.Fatal("Expected the first write admitted")
+	}
+	second, ok := l.begin()
+	if !ok {
+		t.Fatal("Expected the second write admitted")
+	}
+	if _, ok := l.begin(); ok {
+		t.Errorf("Expected a third write refused")
+	}
+	if d := l.backoff(); d != minIngestionBackoff {
+		t.Errorf("Expected the minimum backoff while full of fast writes, got %v", d)
+	}
+	first()
+	second()
+	if _, ok := l.begin(); !ok {
+		t.Errorf("Expected writes admitted again once the others finished")
+	}
+
+	// Slow writes ask for backoff in proportion to how slow they are, up to
+	// a bound
+	l = newIngestionLoad(0, 100*time.Millisecond)
+	l.observe(50 * time.Millisecond)
+	if d := l.backoff(); d != 0 {
+		t.Errorf("Expected no backoff while writes meet the target, got %v", d)
+	}
+	l.observe(550 * time.Millisecond)
+	if d := l.backoff(); d != 225*time.Millisecond {
+		t.Errorf("Expected the average latency of 150ms times the overload of 1.5, got %v", d)
+	}
+	l.observe(time.Hour)
+	if d := l.backoff(); d != maxIngestionBackoff {
+		t.Errorf("Expected the backoff capped at %v, got %v", maxIngestionBackoff, d)
+	}
+	if d := newIngestionLoad(0, 0).backoff(); d != 0 {
+		t.Errorf("Expected no backoff without limits, got %v", d)
+	}
+}
+
+func TestBackpressureMiddleware(t *testing.T) {
+	defer func(l *ingestionLoad) { ingestion = l }(ingestion)
+	ingestion = newIngestionLoad(1, 0)
+
+	started, release := make(chan struct{}), make(chan struct{})
+	handler := backpressureMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			close(started)
+			<-release
+		}
+	}))
+	first := httptest.NewRecorder()
+	finished := make(chan struct{})
+	go func() {
+		handler.ServeHTTP(first, httptest.NewRequest(http.MethodPost, "/api/metrics", strings.NewReader(`{}`)))
+		close(finished)
+	}()
+	<-started
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/metrics", strings.NewReader(`{}`)))
+	var resp struct {
+		RetryAfterMillis int64 `json:"retry_after_ms"`
+	}
+	json.NewDecoder(w.Body).Decode(&resp)
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "1" || w.Header().Get(backoffHeader) != "100" || resp.RetryAfterMillis != 100 {
+		t.Errorf("Expected a write over the limit refused with a backoff of 100ms, got %d with headers %v", w.Code, w.Header())
+	}
+
+	// Reads are never refused
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/metrics", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected a read served, got %d", w.Code)
+	}
+
+	close(release)
+	<-finished
+	if first.Code != http.StatusOK || first.Header().Get(backoffHeader) != "" {
+		t.Errorf("Expected the first write served without a backoff, got %d with headers %v", first.Code, first.Header())
+	}
+
+	// Writes served while the server is slow carry the hint
+	ingestion = newIngestionLoad(0, time.Millisecond)
+	ingestion.observe(2 * time.Second)
+	w = httptest.NewRecorder()
+	handler = backpressureMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/params", strings.NewReader(`{}`)))
+	if w.Code != http.StatusOK || w.Header().Get(backoffHeader) != "30000" {
+		t.Errorf("Expected a write served with the longest backoff, got %d with headers %v", w.Code, w.Header())
+	}
+}
@@ -0,0 +1,392 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"time"
+)
+
+// How a run's best checkpoint was designated
+const (
+	bestCheckpointManual = "manual"
+	bestCheckpointRule   = "rule"
+)
+
+// Directions a best checkpoint rule optimizes its metric in
+const (
+	bestCheckpointModeMin = "min"
+	bestCheckpointModeMax = "max"
+)
+
+// BestCheckpoint is a run's best checkpoint as returned by the API, with
+// what downstream tooling needs to fetch and verify it
+type BestCheckpoint struct {
+	Path         string   `json:"path"`
+	URI          string   `json:"uri"`
+	SizeBytes    int64    `json:"size_bytes"`
+	SHA256       string   `json:"sha256"`
+	DownloadURL  string   `json:"download_url"`
+	Source       string   `json:"source"`
+	MetricKey    string   `json:"metric_key,omitempty"`
+	MetricValue  *float64 `json:"metric_value,omitempty"`
+	DesignatedAt string   `json:"designated_at"`
+}
+
+// validateBestCheckpointRule checks a best checkpoint rule
+func validateBestCheckpointRule(rule BestCheckpointRuleRow) error {
+	if rule.MetricKey == "" {
+		return fmt.Errorf("metric is required")
+	}
+	if rule.Mode != bestCheckpointModeMin && rule.Mode != bestCheckpointModeMax {
+		return fmt.Errorf("mode must be %q or %q, got %q", bestCheckpointModeMin, bestCheckpointModeMax, rule.Mode)
+	}
+	if rule.ArtifactPattern == "" {
+		return fmt.Errorf("artifacts pattern is required")
+	}
+	if _, err := path.Match(rule.ArtifactPattern, ""); err != nil {
+		return fmt.Errorf("invalid artifacts pattern %q: %w", rule.ArtifactPattern, err)
+	}
+	return nil
+}
+
+// metricImproves reports whether value is better than best in a rule's mode
+func metricImproves(mode string, value, best float64) bool {
+	if mode == bestCheckpointModeMax {
+		return value > best
+	}
+	return value < best
+}
+
+// nextBestCheckpoint decides whether an artifact uploaded while the rule's
+// metric was at value becomes the run's best checkpoint, returning the new
+// designation or nil to keep current. Checkpoints designated by hand are
+// never replaced by a rule. Overwriting the best checkpoint re-designates
+// it, since its contents now reflect value.
+func nextBestCheckpoint(rule BestCheckpointRuleRow, current *RunBestCheckpointRow, runID int, artifactPath string, value float64, now time.Time) *RunBestCheckpointRow {
+	if current != nil && current.Source == bestCheckpointManual {
+		return nil
+	}
+	matched, err := path.Match(rule.ArtifactPattern, artifactPath)
+	if err != nil || !matched {
+		return nil
+	}
+	if current != nil && current.MetricKey.String == rule.MetricKey && current.MetricValue.Valid &&
+		current.Path != artifactPath && !metricImproves(rule.Mode, value, current.MetricValue.Float64) {
+		return nil
+	}
+	c := RunBestCheckpointRow{RunID: runID, Path: artifactPath, Source: bestCheckpointRule, DesignatedAt: now}
+	c.MetricKey.String, c.MetricKey.Valid = rule.MetricKey, true
+	c.MetricValue.Float64, c.MetricValue.Valid = value, true
+	return &c
+}
+
+// applyBestCheckpointRule designates an uploaded artifact as its run's best
+// checkpoint if it matches the experiment's rule and the rule's metric is at
+// its best so far
+func applyBestCheckpointRule(ctx context.Context, runID int, runUUID, artifactPath string) error {
+	experiment, err := dao.GetExperimentForRunUUID(ctx, runUUID)
+	if errors.Is(err, sql.ErrNoRows) {
+		// Runs outside an experiment have no rule
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	experimentID, err := dao.GetExperimentIDByUUID(ctx, experiment.UUID)
+	if err != nil {
+		return err
+	}
+	rule, err := dao.GetBestCheckpointRule(ctx, experimentID)
+	if err != nil || rule == nil {
+		return err
+	}
+
+	latest, err := dao.GetLatestMetricsByRunID(ctx, runID)
+	if err != nil {
+		return err
+	}
+	var value *float64
+	for _, m := range latest {
+		if m.Key == rule.MetricKey {
+			value = &m.YValue
+		}
+	}
+	if value == nil {
+		// Nothing to judge the checkpoint by yet
+		return nil
+	}
+
+	current, err := dao.GetRunBestCheckpoint(ctx, runID)
+	if err != nil {
+		return err
+	}
+	next := nextBestCheckpoint(*rule, current, runID, artifactPath, *value, time.Now().UTC())
+	if next == nil {
+		return nil
+	}
+	if current != nil {
+		if err := ensureRunNotOnHold(ctx, runID); err != nil {
+			return err
+		}
+	}
+	return dao.SetRunBestCheckpoint(ctx, *next)
+}
+
+// getRunBestCheckpoint loads a run's best checkpoint, or nil if it has none
+func getRunBestCheckpoint(ctx context.Context, runID int) (*BestCheckpoint, error) {
+	c, err := dao.GetRunBestCheckpoint(ctx, runID)
+	if err != nil || c == nil {
+		return nil, err
+	}
+	return loadBestCheckpoint(ctx, *c)
+}
+
+// getExperimentBestCheckpoints loads the best checkpoints of an experiment's
+// runs by run ID
+func getExperimentBestCheckpoints(ctx context.Context, experimentID int) (map[int]*BestCheckpoint, error) {
+	rows, err := dao.GetRunBestCheckpointsByExperimentID(ctx, experimentID)
+	if err != nil {
+		return nil, err
+	}
+	checkpoints := make(map[int]*BestCheckpoint, len(rows))
+	for _, c := range rows {
+		checkpoint, err := loadBestCheckpoint(ctx, c)
+		if err != nil {
+			return nil, err
+		}
+		checkpoints[c.RunID] = checkpoint
+	}
+	return checkpoints, nil
+}
+
+// loadBestCheckpoint joins a best checkpoint designation with the metadata
+// of its artifact
+func loadBestCheckpoint(ctx context.Context, c RunBestCheckpointRow) (*BestCheckpoint, error) {
+	artifact, err := dao.GetArtifactByRunIDAndPath(ctx, c.RunID, c.Path)
+	if err != nil {
+		return nil, fmt.Errorf("loading best checkpoint artifact %s: %w", c.Path, err)
+	}
+	checkpoint := &BestCheckpoint{
+		Path:         c.Path,
+		URI:          artifact.URI,
+		SizeBytes:    artifact.SizeBytes,
+		SHA256:       artifact.SHA256,
+		DownloadURL:  "/artifacts/blob?uri=" + url.QueryEscape(artifact.URI),
+		Source:       c.Source,
+		MetricKey:    c.MetricKey.String,
+		DesignatedAt: c.DesignatedAt.UTC().Format(time.RFC3339),
+	}
+	if c.MetricValue.Valid {
+		checkpoint.MetricValue = &c.MetricValue.Float64
+	}
+	return checkpoint, nil
+}
+
+// formatBestCheckpointRule describes a rule for the experiment page, e.g.
+// "lowest val_loss among checkpoints/*"
+func formatBestCheckpointRule(rule *BestCheckpointRuleRow) string {
+	direction := "lowest"
+	if rule.Mode == bestCheckpointModeMax {
+		direction = "highest"
+	}
+	return fmt.Sprintf("%s %s among %s", direction, rule.MetricKey, rule.ArtifactPattern)
+}
+
+// RunBestCheckpointRequest is the body of POST /api/runs/best-checkpoint
+type RunBestCheckpointRequest struct {
+	RunUUID string `json:"run_uuid"`
+	Path    string `json:"path"`
+}
+
+// handleAPIRunBestCheckpoint returns (GET), designates by hand (POST), or
+// clears (DELETE) a run's best checkpoint
+func handleAPIRunBestCheckpoint(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	w.Header().Set("Content-Type", "application/json")
+
+	var req RunBestCheckpointRequest
+	switch r.Method {
+	case http.MethodGet, http.MethodDelete:
+		req.RunUUID = r.URL.Query().Get("run_uuid")
+	case http.MethodPost:
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Invalid JSON"})
+			return
+		}
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var missing []string
+	if req.RunUUID == "" {
+		missing = append(missing, "run_uuid")
+	}
+	if r.Method == http.MethodPost && req.Path == "" {
+		missing = append(missing, "path")
+	}
+	if len(missing) > 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":          "Missing required fields",
+			"missing_fields": missing,
+		})
+		return
+	}
+
+	runID, err := dao.GetRunIDByUUID(ctx, req.RunUUID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Run not found"})
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		checkpoint, err := getRunBestCheckpoint(ctx, runID)
+		if err != nil {
+			log.Printf("Failed to load best checkpoint for run %s: %v", req.RunUUID, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to load best checkpoint"})
+			return
+		}
+		if checkpoint == nil {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Run has no best checkpoint"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"best_checkpoint": checkpoint})
+		return
+	}
+
+	if err := ensureRunNotOnHold(ctx, runID); err != nil {
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]string{"error": fmt.Sprintf("Cannot change best checkpoint: %v", err)})
+		return
+	}
+
+	if r.Method == http.MethodDelete {
+		cleared, err := dao.ClearRunBestCheckpoint(ctx, runID)
+		if err != nil {
+			log.Printf("Error clearing best checkpoint: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to clear best checkpoint"})
+			return
+		}
+		if !cleared {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Run has no best checkpoint"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+		return
+	}
+
+	if _, err := dao.GetArtifactByRunIDAndPath(ctx, runID, req.Path); err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Artifact not found"})
+		return
+	}
+	err = dao.SetRunBestCheckpoint(ctx, RunBestCheckpointRow{
+		RunID:        runID,
+		Path:         req.Path,
+		Source:       bestCheckpointManual,
+		DesignatedAt: time.Now().UTC(),
+	})
+	if err != nil {
+		log.Printf("Error saving best checkpoint: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to save best checkpoint"})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+
+// BestCheckpointRuleRequest is the body of POST /api/experiments/best-checkpoint-rule
+type BestCheckpointRuleRequest struct {
+	ExperimentUUID string `json:"experiment_uuid"`
+	Metric         string `json:"metric"`
+	Mode           string `json:"mode"`
+	Artifacts      string `json:"artifacts"`
+}
+
+// handleAPIBestCheckpointRule returns (GET), sets (POST), or removes (DELETE)
+// the rule an experiment's runs choose their best checkpoint by
+func handleAPIBestCheckpointRule(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	w.Header().Set("Content-Type", "application/json")
+
+	var req BestCheckpointRuleRequest
+	switch r.Method {
+	case http.MethodGet, http.MethodDelete:
+		req.ExperimentUUID = r.URL.Query().Get("experiment_uuid")
+	case http.MethodPost:
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Invalid JSON"})
+			return
+		}
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if req.ExperimentUUID == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Missing required field: experiment_uuid"})
+		return
+	}
+
+	experimentID, err := dao.GetExperimentIDByUUID(ctx, req.ExperimentUUID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Experiment not found"})
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		rule, err := dao.GetBestCheckpointRule(ctx, experimentID)
+		if err != nil {
+			log.Printf("Failed to load best checkpoint rule for experiment %s: %v", req.ExperimentUUID, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to load best checkpoint rule"})
+			return
+		}
+		if rule == nil {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Experiment has no best checkpoint rule"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"metric": rule.MetricKey, "mode": rule.Mode, "artifacts": rule.ArtifactPattern})
+	case http.MethodDelete:
+		if err := dao.DeleteBestCheckpointRule(ctx, experimentID); err != nil {
+			log.Printf("Error deleting best checkpoint rule: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to delete best checkpoint rule"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+	case http.MethodPost:
+		rule := BestCheckpointRuleRow{MetricKey: req.Metric, Mode: req.Mode, ArtifactPattern: req.Artifacts}
+		if err := validateBestCheckpointRule(rule); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": fmt.Sprintf("Invalid best checkpoint rule: %v", err)})
+			return
+		}
+		if err := dao.UpsertBestCheckpointRule(ctx, experimentID, rule); err != nil {
+			log.Printf("Error saving best checkpoint rule: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to save best checkpoint rule"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateBestCheckpointRule(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    BestCheckpointRuleRow
+		wantErr string
+	}{
+		{name: "valid", rule: BestCheckpointRuleRow{MetricKey: "val_loss", Mode: "min", ArtifactPattern: "checkpoints/*.pt"}},
+		{name: "missing metric", rule: BestCheckpointRuleRow{Mode: "min", ArtifactPattern: "*"}, wantErr: "metric"},
+		{name: "bad mode", rule: BestCheckpointRuleRow{MetricKey: "acc", Mode: "highest", ArtifactPattern: "*"}, wantErr: "mode"},
+		{name: "missing pattern", rule: BestCheckpointRuleRow{MetricKey: "acc", Mode: "max"}, wantErr: "pattern is required"},
+		{name: "malformed pattern", rule: BestCheckpointRuleRow{MetricKey: "acc", Mode: "max", ArtifactPattern: "ckpt/[a-"}, wantErr: "invalid artifacts pattern"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateBestCheckpointRule(tt.rule)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("validateBestCheckpointRule() failed: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("validateBestCheckpointRule() error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNextBestCheckpoint(t *testing.T) {
+	rule := BestCheckpointRuleRow{MetricKey: "val_loss", Mode: "min", ArtifactPattern: "ckpt/*.pt"}
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	designated := func(path, source string, value float64) *RunBestCheckpointRow {
+		c := &RunBestCheckpointRow{RunID: 1, Path: path, Source: source, DesignatedAt: now}
+		if source == bestCheckpointRule {
+			c.MetricKey = sql.NullString{String: "val_loss", Valid: true}
+			c.MetricValue = sql.NullFloat64{Float64: value, Valid: true}
+		}
+		return c
+	}
+
+	tests := []struct {
+		name     string
+		rule     BestCheckpointRuleRow
+		current  *RunBestCheckpointRow
+		path     string
+		value    float64
+		wantPath string
+	}{
+		{name: "first checkpoint", rule: rule, path: "ckpt/1.pt", value: 0.9, wantPath: "ckpt/1.pt"},
+		{name: "not a checkpoint", rule: rule, path: "plots/loss.png", value: 0.1},
+		{name: "improvement", rule: rule, current: designated("ckpt/1.pt", bestCheckpointRule, 0.9), path: "ckpt/2.pt", value: 0.5, wantPath: "ckpt/2.pt"},
+		{name: "regression", rule: rule, current: designated("ckpt/1.pt", bestCheckpointRule, 0.5), path: "ckpt/2.pt", value: 0.9},
+		{name: "tie keeps earlier", rule: rule, current: designated("ckpt/1.pt", bestCheckpointRule, 0.5), path: "ckpt/2.pt", value: 0.5},
+		{name: "overwritten best", rule: rule, current: designated("ckpt/1.pt", bestCheckpointRule, 0.5), path: "ckpt/1.pt", value: 0.7, wantPath: "ckpt/1.pt"},
+		{name: "max mode", rule: BestCheckpointRuleRow{MetricKey: "val_loss", Mode: "max", ArtifactPattern: "ckpt/*.pt"}, current: designated("ckpt/1.pt", bestCheckpointRule, 0.5), path: "ckpt/2.pt", value: 0.9, wantPath: "ckpt/2.pt"},
+		{name: "manual is kept", rule: rule, current: designated("ckpt/1.pt", bestCheckpointManual, 0), path: "ckpt/2.pt", value: 0.1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next := nextBestCheckpoint(tt.rule, tt.current, 1, tt.path, tt.value, now)
+			if tt.wantPath == "" {
+				if next != nil {
+					t.Errorf("nextBestCheckpoint() = %+v, want no change", next)
+				}
+				return
+			}
+			if next == nil || next.Path != tt.wantPath || next.Source != bestCheckpointRule || next.MetricValue.Float64 != tt.value {
+				t.Errorf("nextBestCheckpoint() = %+v, want %s at %g", next, tt.wantPath, tt.value)
+			}
+		})
+	}
+}
+
+func TestFormatBestCheckpointRule(t *testing.T) {
+	got := formatBestCheckpointRule(&BestCheckpointRuleRow{MetricKey: "acc", Mode: "max", ArtifactPattern: "ckpt/*"})
+	if got != "highest acc among ckpt/*" {
+		t.Errorf("formatBestCheckpointRule() = %q", got)
+	}
+}
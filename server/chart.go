@@ -0,0 +1,623 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"image"
+	"image/color"
+	"image/png"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Metric charts rendered on the server, as SVG or PNG, for the places a
+// browser can't draw them: email notifications, exported reports, and the
+// preview images chat apps show for links to runs.
+
+// Formats charts can be rendered in
+const (
+	chartFormatPNG = "png"
+	chartFormatSVG = "svg"
+)
+
+// Chart sizes, in pixels. The default is the size link previews are shown at.
+const (
+	chartDefaultWidth  = 1200
+	chartDefaultHeight = 630
+	chartMinSize       = 200
+	chartMaxSize       = 4000
+)
+
+// chartColors are the colors of a chart's series, in the order the run pages
+// use them
+var chartColors = []color.RGBA{
+	{0x00, 0x66, 0xcc, 0xff},
+	{0xcc, 0x33, 0x00, 0xff},
+	{0x2e, 0x8b, 0x57, 0xff},
+	{0x99, 0x33, 0xcc, 0xff},
+	{0xe6, 0x95, 0x00, 0xff},
+	{0x00, 0x8b, 0x8b, 0xff},
+	{0xb8, 0x86, 0x0b, 0xff},
+	{0x66, 0x66, 0x66, 0xff},
+}
+
+var (
+	chartBackground = color.RGBA{0xff, 0xff, 0xff, 0xff}
+	chartTextColor  = color.RGBA{0x33, 0x33, 0x33, 0xff}
+	chartMutedColor = color.RGBA{0x66, 0x66, 0x66, 0xff}
+	chartGridColor  = color.RGBA{0xe5, 0xe5, 0xe5, 0xff}
+)
+
+// MetricChart is a line chart of metric series against their steps
+type MetricChart struct {
+	Title  string
+	Width  int
+	Height int
+	Series []ChartSeries
+}
+
+// ChartSeries is one line of a chart
+type ChartSeries struct {
+	Label  string
+	Points []MetricRow
+}
+
+// chartPoint is a position on a chart, in pixels from its top left corner
+type chartPoint struct {
+	X, Y float64
+}
+
+// chartCanvas is what a chart is drawn on. Text is vertically centered on y
+// and anchored at x by its start, middle or end, as in SVG.
+type chartCanvas interface {
+	fillRect(x, y, w, h float64, c color.RGBA)
+	polyline(points []chartPoint, width float64, c color.RGBA)
+	text(x, y float64, s string, size float64, anchor string, c color.RGBA)
+	textWidth(s string, size float64) float64
+}
+
+// niceChartTicks picks about target evenly spaced ticks at round numbers
+// covering lo to hi
+func niceChartTicks(lo, hi float64, target int) (ticks []float64, step float64) {
+	if hi <= lo {
+		pad := math.Max(math.Abs(lo)*0.1, 1)
+		lo, hi = lo-pad, hi+pad
+	}
+	rough := (hi - lo) / float64(max(target, 1))
+	magnitude := math.Pow(10, math.Floor(math.Log10(rough)))
+	step = 10 * magnitude
+	for _, m := range []float64{1, 2, 5} {
+		if rough <= m*magnitude {
+			step = m * magnitude
+			break
+		}
+	}
+	start := math.Floor(lo/step) * step
+	end := math.Ceil(hi/step) * step
+	for i := 0; start+float64(i)*step <= end+step/2; i++ {
+		ticks = append(ticks, start+float64(i)*step)
+	}
+	return ticks, step
+}
+
+// formatChartTick labels a tick with as many decimals as its step needs
+func formatChartTick(v, step float64) string {
+	if math.Abs(v) >= 1e6 || step < 1e-4 {
+		return strconv.FormatFloat(v, 'g', 4, 64)
+	}
+	decimals := max(0, int(math.Ceil(-math.Log10(step)-1e-9)))
+	s := strconv.FormatFloat(v, 'f', decimals, 64)
+	if strings.Trim(s, "-0.") == "" {
+		return strings.TrimPrefix(s, "-")
+	}
+	return s
+}
+
+// fitChartText shortens text with an ellipsis to fit within a width
+func fitChartText(c chartCanvas, text string, size, width float64) string {
+	if c.textWidth(text, size) <= width {
+		return text
+	}
+	runes := []rune(text)
+	for n := len(runes) - 1; n > 0; n-- {
+		if shortened := string(runes[:n]) + "..."; c.textWidth(shortened, size) <= width {
+			return shortened
+		}
+	}
+	return ""
+}
+
+// isFiniteChartValue reports whether a value can be plotted
+func isFiniteChartValue(v float64) bool {
+	return !math.IsNaN(v) && !math.IsInf(v, 0)
+}
+
+// drawMetricChart lays a chart out and draws it: the title, a legend, the
+// axes' grid and labels, and a line for each series, broken where values are
+// not finite
+func drawMetricChart(c chartCanvas, chart MetricChart) {
+	width, height := float64(chart.Width), float64(chart.Height)
+	scale := math.Max(1, math.Min(width, height)/400)
+	pad := 16 * scale
+	titleSize := 18 * scale
+	labelSize := 11 * scale
+	lineWidth := 2 * scale
+
+	c.fillRect(0, 0, width, height, chartBackground)
+	top := pad
+	if chart.Title != "" {
+		c.text(pad, top+titleSize/2, fitChartText(c, chart.Title, titleSize, width-2*pad), titleSize, "start", chartTextColor)
+		top += titleSize + pad/2
+	}
+
+	// The legend is one row of swatches and labels, of as many series as fit
+	x := pad
+	for i, s := range chart.Series {
+		if x+3*labelSize+c.textWidth(s.Label, labelSize) > width-pad {
+			break
+		}
+		color := chartColors[i%len(chartColors)]
+		c.polyline([]chartPoint{{x, top + labelSize/2}, {x + 2*labelSize, top + labelSize/2}}, lineWidth, color)
+		x += 2*labelSize + labelSize/2
+		c.text(x, top+labelSize/2, s.Label, labelSize, "start", chartMutedColor)
+		x += c.textWidth(s.Label, labelSize) + 2*labelSize
+	}
+	if len(chart.Series) > 0 {
+		top += labelSize + pad
+	}
+
+	xMin, xMax, yMin, yMax := math.Inf(1), math.Inf(-1), math.Inf(1), math.Inf(-1)
+	for _, s := range chart.Series {
+		for _, p := range s.Points {
+			if !isFiniteChartValue(p.XValue) || !isFiniteChartValue(p.YValue) {
+				continue
+			}
+			xMin, xMax = math.Min(xMin, p.XValue), math.Max(xMax, p.XValue)
+			yMin, yMax = math.Min(yMin, p.YValue), math.Max(yMax, p.YValue)
+		}
+	}
+	if math.IsInf(xMin, 1) {
+		c.text(width/2, (top+height)/2, "No data", titleSize, "middle", chartMutedColor)
+		return
+	}
+
+	bottom := height - pad - labelSize - labelSize/2
+	yTicks, yStep := niceChartTicks(yMin, yMax, max(2, int((bottom-top)/(4*labelSize))))
+	yLabels := make([]string, len(yTicks))
+	labelWidth := 0.0
+	for i, v := range yTicks {
+		yLabels[i] = formatChartTick(v, yStep)
+		labelWidth = math.Max(labelWidth, c.textWidth(yLabels[i], labelSize))
+	}
+	left := pad + labelWidth + labelSize/2
+	right := width - pad
+	// The steps span the width of the chart, with ticks at the round numbers
+	// within them
+	xTicks, xStep := niceChartTicks(xMin, xMax, max(2, int((right-left)/(10*labelSize))))
+	xLo, xHi := xMin, xMax
+	if xHi == xLo {
+		xLo, xHi = xTicks[0], xTicks[len(xTicks)-1]
+	}
+	xTicks = slices.DeleteFunc(xTicks, func(v float64) bool { return v < xLo-xStep/1e6 || v > xHi+xStep/1e6 })
+	yLo, yHi := yTicks[0], yTicks[len(yTicks)-1]
+	toPixel := func(x, y float64) chartPoint {
+		return chartPoint{
+			X: left + (x-xLo)/(xHi-xLo)*(right-left),
+			Y: bottom - (y-yLo)/(yHi-yLo)*(bottom-top),
+		}
+	}
+
+	for i, v := range yTicks {
+		y := toPixel(xLo, v).Y
+		c.fillRect(left, y-scale/2, right-left, scale, chartGridColor)
+		c.text(left-labelSize/2, y, yLabels[i], labelSize, "end", chartMutedColor)
+	}
+	for _, v := range xTicks {
+		x := toPixel(v, yLo).X
+		c.fillRect(x-scale/2, top, scale, bottom-top, chartGridColor)
+		label := formatChartTick(v, xStep)
+		anchor := "middle"
+		if x+c.textWidth(label, labelSize)/2 > width {
+			anchor = "end"
+		}
+		c.text(x, bottom+labelSize, label, labelSize, anchor, chartMutedColor)
+	}
+
+	for i, s := range chart.Series {
+		color := chartColors[i%len(chartColors)]
+		var line []chartPoint
+		for _, p := range s.Points {
+			if !isFiniteChartValue(p.XValue) || !isFiniteChartValue(p.YValue) {
+				c.polyline(line, lineWidth, color)
+				line = line[:0]
+				continue
+			}
+			line = append(line, toPixel(p.XValue, p.YValue))
+		}
+		c.polyline(line, lineWidth, color)
+	}
+}
+
+// svgChartCanvas draws a chart as SVG elements
+type svgChartCanvas struct {
+	buf bytes.Buffer
+}
+
+func svgColor(c color.RGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
+
+func (s *svgChartCanvas) fillRect(x, y, w, h float64, c color.RGBA) {
+	fmt.Fprintf(&s.buf, `<rect x="%.1f" y="%.1f" width="%.1f" height="%.1f" fill="%s"/>`+"\n", x, y, w, h, svgColor(c))
+}
+
+func (s *svgChartCanvas) polyline(points []chartPoint, width float64, c color.RGBA) {
+	if len(points) == 0 {
+		return
+	}
+	fmt.Fprintf(&s.buf, `<polyline fill="none" stroke="%s" stroke-width="%.1f" stroke-linejoin="round" stroke-linecap="round" points="`, svgColor(c), width)
+	for i, p := range points {
+		if i > 0 {
+			s.buf.WriteByte(' ')
+		}
+		fmt.Fprintf(&s.buf, "%.1f,%.1f", p.X, p.Y)
+	}
+	s.buf.WriteString("\"/>\n")
+}
+
+func (s *svgChartCanvas) text(x, y float64, text string, size float64, anchor string, c color.RGBA) {
+	fmt.Fprintf(&s.buf, `<text x="%.1f" y="%.1f" font-size="%.1f" text-anchor="%s" dominant-baseline="middle" fill="%s">%s</text>`+"\n",
+		x, y, size, anchor, svgColor(c), html.EscapeString(text))
+}
+
+// textWidth estimates the width of sans-serif text, generously so that labels
+// are not cut off
+func (s *svgChartCanvas) textWidth(text string, size float64) float64 {
+	return 0.6 * size * float64(len([]rune(text)))
+}
+
+// renderMetricChartSVG renders a chart as an SVG document
+func renderMetricChartSVG(chart MetricChart) []byte {
+	s := &svgChartCanvas{}
+	fmt.Fprintf(&s.buf, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" font-family="-apple-system, BlinkMacSystemFont, 'Segoe UI', Roboto, sans-serif">`+"\n",
+		chart.Width, chart.Height, chart.Width, chart.Height)
+	drawMetricChart(s, chart)
+	s.buf.WriteString("</svg>\n")
+	return s.buf.Bytes()
+}
+
+// pngChartSupersampling is how many pixels a PNG chart is drawn with along
+// each side of each pixel, which are averaged to smooth its edges
+const pngChartSupersampling = 2
+
+// pngChartCanvas draws a chart on an image at pngChartSupersampling times
+// its size, writing text in chartFont
+type pngChartCanvas struct {
+	img *image.RGBA
+}
+
+func (p *pngChartCanvas) fillRect(x, y, w, h float64, c color.RGBA) {
+	const s = pngChartSupersampling
+	r := image.Rect(int(math.Round(x*s)), int(math.Round(y*s)), int(math.Round((x+w)*s)), int(math.Round((y+h)*s))).Intersect(p.img.Rect)
+	for py := r.Min.Y; py < r.Max.Y; py++ {
+		for px := r.Min.X; px < r.Max.X; px++ {
+			p.img.SetRGBA(px, py, c)
+		}
+	}
+}
+
+// polyline fills the pixels within half the line's width of each segment
+func (p *pngChartCanvas) polyline(points []chartPoint, width float64, c color.RGBA) {
+	const s = pngChartSupersampling
+	radius := width * s / 2
+	for i := range points {
+		a, b := points[i], points[max(i-1, 0)]
+		ax, ay, bx, by := a.X*s, a.Y*s, b.X*s, b.Y*s
+		r := image.Rect(int(math.Floor(math.Min(ax, bx)-radius)), int(math.Floor(math.Min(ay, by)-radius)),
+			int(math.Ceil(math.Max(ax, bx)+radius))+1, int(math.Ceil(math.Max(ay, by)+radius))+1).Intersect(p.img.Rect)
+		dx, dy := bx-ax, by-ay
+		length := dx*dx + dy*dy
+		for py := r.Min.Y; py < r.Max.Y; py++ {
+			for px := r.Min.X; px < r.Max.X; px++ {
+				cx, cy := float64(px)+0.5, float64(py)+0.5
+				t := 0.0
+				if length > 0 {
+					t = math.Max(0, math.Min(1, ((cx-ax)*dx+(cy-ay)*dy)/length))
+				}
+				if math.Hypot(cx-ax-t*dx, cy-ay-t*dy) <= radius {
+					p.img.SetRGBA(px, py, c)
+				}
+			}
+		}
+	}
+}
+
+// fontScale is how many pixels wide and tall each dot of chartFont is
+// drawn for text of a size
+func (p *pngChartCanvas) fontScale(size float64) int {
+	return max(1, int(math.Round(size*pngChartSupersampling/(chartFontHeight+1))))
+}
+
+func (p *pngChartCanvas) text(x, y float64, text string, size float64, anchor string, c color.RGBA) {
+	const s = pngChartSupersampling
+	k := p.fontScale(size)
+	switch anchor {
+	case "middle":
+		x -= p.textWidth(text, size) / 2
+	case "end":
+		x -= p.textWidth(text, size)
+	}
+	left := int(math.Round(x * s))
+	top := int(math.Round(y*s)) - chartFontHeight*k/2
+	for i, r := range []rune(text) {
+		glyph := chartGlyph(r)
+		for col, bits := range glyph {
+			for row := 0; row < chartFontHeight; row++ {
+				if bits&(1<<row) == 0 {
+					continue
+				}
+				px := left + (i*(chartFontWidth+1)+col)*k
+				py := top + row*k
+				for dy := 0; dy < k; dy++ {
+					for dx := 0; dx < k; dx++ {
+						if (image.Point{px + dx, py + dy}).In(p.img.Rect) {
+							p.img.SetRGBA(px+dx, py+dy, c)
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+func (p *pngChartCanvas) textWidth(text string, size float64) float64 {
+	n := len([]rune(text))
+	if n == 0 {
+		return 0
+	}
+	return float64((n*(chartFontWidth+1)-1)*p.fontScale(size)) / pngChartSupersampling
+}
+
+// renderMetricChartPNG renders a chart as a PNG image
+func renderMetricChartPNG(chart MetricChart) ([]byte, error) {
+	const s = pngChartSupersampling
+	p := &pngChartCanvas{img: image.NewRGBA(image.Rect(0, 0, chart.Width*s, chart.Height*s))}
+	drawMetricChart(p, chart)
+
+	img := image.NewRGBA(image.Rect(0, 0, chart.Width, chart.Height))
+	for y := 0; y < chart.Height; y++ {
+		for x := 0; x < chart.Width; x++ {
+			var sum [4]int
+			for dy := 0; dy < s; dy++ {
+				for dx := 0; dx < s; dx++ {
+					c := p.img.RGBAAt(x*s+dx, y*s+dy)
+					sum[0] += int(c.R)
+					sum[1] += int(c.G)
+					sum[2] += int(c.B)
+					sum[3] += int(c.A)
+				}
+			}
+			img.SetRGBA(x, y, color.RGBA{uint8(sum[0] / (s * s)), uint8(sum[1] / (s * s)), uint8(sum[2] / (s * s)), uint8(sum[3] / (s * s))})
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// renderMetricChart renders a chart in a format, returning it with its
+// content type
+func renderMetricChart(chart MetricChart, format string) ([]byte, string, error) {
+	if format == chartFormatSVG {
+		return renderMetricChartSVG(chart), "image/svg+xml", nil
+	}
+	data, err := renderMetricChartPNG(chart)
+	return data, "image/png", err
+}
+
+// parseChartSize reads a chart's width and height from a request's query,
+// defaulting to the size of a link preview
+func parseChartSize(query url.Values) (width, height int, err error) {
+	width, height = chartDefaultWidth, chartDefaultHeight
+	for name, size := range map[string]*int{"width": &width, "height": &height} {
+		v := query.Get(name)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < chartMinSize || n > chartMaxSize {
+			return 0, 0, fmt.Errorf("%s must be a number of pixels from %d to %d", name, chartMinSize, chartMaxSize)
+		}
+		*size = n
+	}
+	return width, height, nil
+}
+
+// loadRunMetricChart charts metric series of a run, each downsampled to
+// about a point per pixel of the chart's width
+func loadRunMetricChart(ctx context.Context, runID int, title string, keys []string, width, height int) (MetricChart, error) {
+	chart := MetricChart{Title: title, Width: width, Height: height}
+	for _, key := range keys {
+		points, err := dao.GetMetricsDownsampled(ctx, runID, key, width)
+		if err != nil {
+			return chart, err
+		}
+		chart.Series = append(chart.Series, ChartSeries{Label: key, Points: points})
+	}
+	return chart, nil
+}
+
+// defaultChartKey picks the metric to chart for a run when none is asked
+// for, returning "" if the run has no metrics
+func defaultChartKey(ctx context.Context, runID int) (string, error) {
+	checkpoint, err := dao.GetRunBestCheckpoint(ctx, runID)
+	if err != nil {
+		return "", err
+	}
+	latest, err := dao.GetLatestMetricsByRunID(ctx, runID)
+	if err != nil {
+		return "", err
+	}
+	return pickChartKey(checkpoint, latest), nil
+}
+
+// pickChartKey picks a run's headline metric, given its best checkpoint, if
+// any, and the latest point of each of its metrics: the one its best
+// checkpoint was chosen by, else its first metric named like a loss, else
+// its first metric
+func pickChartKey(checkpoint *RunBestCheckpointRow, latest []MetricRow) string {
+	if checkpoint != nil && checkpoint.MetricKey.Valid {
+		return checkpoint.MetricKey.String
+	}
+	var keys []string
+	for _, m := range latest {
+		keys = append(keys, m.Key)
+	}
+	slices.Sort(keys)
+	for _, key := range keys {
+		if strings.Contains(strings.ToLower(key), "loss") {
+			return key
+		}
+	}
+	if len(keys) == 0 {
+		return ""
+	}
+	return keys[0]
+}
+
+// handleAPIRunMetricChart renders a run's metric series as a chart, at
+// GET /api/v1/runs/{uuid}/metrics/{key}/chart?format=png|svg&width=&height=
+func handleAPIRunMetricChart(w http.ResponseWriter, r *http.Request, runUUID, key string) {
+	ctx := r.Context()
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	writeError := func(status int, message string) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]string{"error": message})
+	}
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = chartFormatPNG
+	}
+	if format != chartFormatPNG && format != chartFormatSVG {
+		writeError(http.StatusBadRequest, fmt.Sprintf("format must be %s or %s", chartFormatPNG, chartFormatSVG))
+		return
+	}
+	width, height, err := parseChartSize(r.URL.Query())
+	if err != nil {
+		writeError(http.StatusBadRequest, err.Error())
+		return
+	}
+
+	runID, err := dao.GetRunIDByUUID(ctx, runUUID)
+	if err != nil {
+		writeError(http.StatusNotFound, "Run not found")
+		return
+	}
+	run, err := dao.GetRunByUUID(ctx, runUUID)
+	if err != nil {
+		writeError(http.StatusNotFound, "Run not found")
+		return
+	}
+	chart, err := loadRunMetricChart(ctx, runID, run.Name, []string{key}, width, height)
+	if err != nil {
+		log.Printf("Failed to query %s of run %s for a chart: %v", key, runUUID, err)
+		writeError(http.StatusInternalServerError, "Failed to query metric")
+		return
+	}
+	if len(chart.Series[0].Points) == 0 {
+		writeError(http.StatusNotFound, "Metric not found")
+		return
+	}
+	writeMetricChart(w, chart, format)
+}
+
+// handleRunChart renders a chart of a run's metrics at
+// /runs/{uuid}/chart.png or chart.svg, for link previews and embedding in
+// pages outside apparatus. Each key query parameter adds a series, defaulting
+// to the metric picked by defaultChartKey.
+func handleRunChart(w http.ResponseWriter, r *http.Request, runUUID, format string) error {
+	ctx := r.Context()
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return nil
+	}
+	width, height, err := parseChartSize(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil
+	}
+
+	runID, err := dao.GetRunIDByUUID(ctx, runUUID)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Run not found", http.StatusNotFound)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get run %s: %w", runUUID, err)
+	}
+	run, err := dao.GetRunByUUID(ctx, runUUID)
+	if err != nil {
+		return fmt.Errorf("failed to get run %s: %w", runUUID, err)
+	}
+	keys := r.URL.Query()["key"]
+	if len(keys) == 0 {
+		key, err := defaultChartKey(ctx, runID)
+		if err != nil {
+			return fmt.Errorf("failed to pick a metric to chart for run %s: %w", runUUID, err)
+		}
+		if key != "" {
+			keys = []string{key}
+		}
+	}
+	chart, err := loadRunMetricChart(ctx, runID, run.Name, keys, width, height)
+	if err != nil {
+		return fmt.Errorf("failed to query metrics of run %s for a chart: %w", runUUID, err)
+	}
+	writeMetricChart(w, chart, format)
+	return nil
+}
+
+// writeMetricChart renders a chart into a response
+func writeMetricChart(w http.ResponseWriter, chart MetricChart, format string) {
+	data, contentType, err := renderMetricChart(chart, format)
+	if err != nil {
+		log.Printf("Failed to render chart of run %s: %v", chart.Title, err)
+		http.Error(w, "Failed to render chart", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	// Charts of runs that are still logging change from minute to minute
+	w.Header().Set("Cache-Control", "max-age=60")
+	w.Write(data)
+}
+
+// renderRunChartPNG renders the default chart of a run as a PNG for email,
+// returning nil if the run has no metrics
+func renderRunChartPNG(ctx context.Context, runID int, title string) ([]byte, error) {
+	key, err := defaultChartKey(ctx, runID)
+	if err != nil || key == "" {
+		return nil, err
+	}
+	chart, err := loadRunMetricChart(ctx, runID, title, []string{key}, 800, 420)
+	if err != nil {
+		return nil, err
+	}
+	return renderMetricChartPNG(chart)
+}
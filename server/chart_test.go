@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"image/png"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// chartDAO has run-1 with a loss and an accuracy series and no best
+// checkpoint
+type chartDAO struct {
+	DAO
+	maxPoints int
+}
+
+func (d *chartDAO) GetRunIDByUUID(ctx context.Context, uuid string) (int, error) {
+	if uuid != "run-1" {
+		return 0, sql.ErrNoRows
+	}
+	return 1, nil
+}
+
+func (d *chartDAO) GetRunByUUID(ctx context.Context, uuid string) (*Run, error) {
+	if uuid != "run-1" {
+		return nil, sql.ErrNoRows
+	}
+	return &Run{UUID: uuid, Name: "baseline <lr=0.1>"}, nil
+}
+
+func (d *chartDAO) GetMetricsDownsampled(ctx context.Context, runID int, key string, maxPoints int) ([]MetricRow, error) {
+	d.maxPoints = maxPoints
+	switch key {
+	case "train/loss":
+		return []MetricRow{{Key: key, XValue: 0, YValue: 2}, {Key: key, XValue: 1, YValue: math.NaN()}, {Key: key, XValue: 2, YValue: 0.5}, {Key: key, XValue: 3, YValue: 0.25}}, nil
+	case "accuracy":
+		return []MetricRow{{Key: key, XValue: 0, YValue: 0.1}, {Key: key, XValue: 3, YValue: 0.9}}, nil
+	}
+	return nil, nil
+}
+
+func (d *chartDAO) GetRunBestCheckpoint(ctx context.Context, runID int) (*RunBestCheckpointRow, error) {
+	return nil, nil
+}
+
+func (d *chartDAO) GetLatestMetricsByRunID(ctx context.Context, runID int) ([]MetricRow, error) {
+	return []MetricRow{{Key: "accuracy"}, {Key: "train/loss"}}, nil
+}
+
+func TestNiceChartTicks(t *testing.T) {
+	tests := []struct {
+		lo, hi float64
+		target int
+		want   []float64
+	}{
+		{0, 1000, 5, []float64{0, 200, 400, 600, 800, 1000}},
+		{0.13, 0.87, 4, []float64{0, 0.2, 0.4, 0.6, 0.8, 1}},
+		{-3, 7, 2, []float64{-5, 0, 5, 10}},
+		// A flat series gets a range around its value
+		{5, 5, 2, []float64{4, 5, 6}},
+	}
+	for _, tt := range tests {
+		ticks, _ := niceChartTicks(tt.lo, tt.hi, tt.target)
+		for i := range ticks {
+			ticks[i] = math.Round(ticks[i]*1e9) / 1e9
+		}
+		if !slices.Equal(ticks, tt.want) {
+			t.Errorf("niceChartTicks(%v, %v, %d) = %v, want %v", tt.lo, tt.hi, tt.target, ticks, tt.want)
+		}
+	}
+}
+
+func TestFormatChartTick(t *testing.T) {
+	tests := []struct {
+		v, step float64
+		want    string
+	}{
+		{200, 100, "200"},
+		{0.30000000000000004, 0.1, "0.3"},
+		{-0.0000001, 0.5, "0.0"},
+		{0.25, 0.05, "0.25"},
+		{2e7, 1e7, "2e+07"},
+	}
+	for _, tt := range tests {
+		if got := formatChartTick(tt.v, tt.step); got != tt.want {
+			t.Errorf("formatChartTick(%v, %v) = %q, want %q", tt.v, tt.step, got, tt.want)
+		}
+	}
+}
+
+func TestRenderMetricChart(t *testing.T) {
+	chart := MetricChart{
+		Title:  "baseline <lr=0.1>",
+		Width:  600,
+		Height: 300,
+		Series: []ChartSeries{{Label: "loss", Points: []MetricRow{{XValue: 0, YValue: 2}, {XValue: 1, YValue: math.Inf(1)}, {XValue: 2, YValue: 1}, {XValue: 3, YValue: 0.5}}}},
+	}
+
+	svg := string(renderMetricChartSVG(chart))
+	if !strings.HasPrefix(svg, "<svg") || !strings.Contains(svg, "baseline &lt;lr=0.1&gt;") || !strings.Contains(svg, ">loss</text>") {
+		t.Errorf("Expected an SVG with the title escaped and the legend, got:\n%s", svg)
+	}
+	// The infinite value breaks the line in two
+	if n := strings.Count(svg, `stroke="#0066cc"`); n != 3 {
+		t.Errorf("Expected the legend swatch and two segments of line, got %d polylines:\n%s", n, svg)
+	}
+
+	data, err := renderMetricChartPNG(chart)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Invalid PNG: %v", err)
+	}
+	if b := img.Bounds(); b.Dx() != 600 || b.Dy() != 300 {
+		t.Errorf("Expected a 600x300 image, got %v", b)
+	}
+	if r, g, b, _ := img.At(0, 0).RGBA(); r != 0xffff || g != 0xffff || b != 0xffff {
+		t.Errorf("Expected a white background, got %v", img.At(0, 0))
+	}
+	blue := 0
+	for y := 0; y < 300; y++ {
+		for x := 0; x < 600; x++ {
+			if c := img.At(x, y); c == chartColors[0] {
+				blue++
+			}
+		}
+	}
+	if blue == 0 {
+		t.Error("Expected the series drawn in the first chart color")
+	}
+}
+
+func TestHandleAPIRunMetricChart(t *testing.T) {
+	defer func(d DAO) { dao = d }(dao)
+	fake := &chartDAO{}
+	dao = fake
+
+	tests := []struct {
+		name        string
+		url         string
+		wantStatus  int
+		contentType string
+	}{
+		{"png by default", "/api/v1/runs/run-1/metrics/train/loss/chart?width=400&height=200", http.StatusOK, "image/png"},
+		{"svg", "/api/v1/runs/run-1/metrics/train/loss/chart?format=svg", http.StatusOK, "image/svg+xml"},
+		{"unknown format", "/api/v1/runs/run-1/metrics/train/loss/chart?format=gif", http.StatusBadRequest, "application/json"},
+		{"too wide", "/api/v1/runs/run-1/metrics/train/loss/chart?width=100000", http.StatusBadRequest, "application/json"},
+		{"unknown metric", "/api/v1/runs/run-1/metrics/missing/chart", http.StatusNotFound, "application/json"},
+		{"unknown run", "/api/v1/runs/run-2/metrics/train/loss/chart", http.StatusNotFound, "application/json"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handleAPIV1Runs(w, httptest.NewRequest(http.MethodGet, tt.url, nil))
+			if w.Code != tt.wantStatus || w.Header().Get("Content-Type") != tt.contentType {
+				t.Errorf("Expected %d %s, got %d %s: %s", tt.wantStatus, tt.contentType, w.Code, w.Header().Get("Content-Type"), w.Body)
+			}
+		})
+	}
+	if fake.maxPoints != chartDefaultWidth {
+		t.Errorf("Expected the series downsampled to the chart's width, got %d points", fake.maxPoints)
+	}
+}
+
+func TestHandleRunChart(t *testing.T) {
+	defer func(d DAO) { dao = d }(dao)
+	dao = &chartDAO{}
+
+	// Without a key, the run's loss is charted
+	w := httptest.NewRecorder()
+	if err := handleViewRun(w, httptest.NewRequest(http.MethodGet, "/runs/run-1/chart.svg", nil)); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "image/svg+xml" || !strings.Contains(w.Body.String(), ">train/loss</text>") {
+		t.Errorf("Expected a chart of train/loss, got %d: %s", w.Code, w.Body)
+	}
+
+	w = httptest.NewRecorder()
+	if err := handleViewRun(w, httptest.NewRequest(http.MethodGet, "/runs/run-1/chart.svg?key=accuracy&key=train/loss", nil)); err != nil {
+		t.Fatal(err)
+	}
+	if body := w.Body.String(); !strings.Contains(body, ">accuracy</text>") || !strings.Contains(body, ">train/loss</text>") {
+		t.Errorf("Expected both series charted, got: %s", body)
+	}
+
+	w = httptest.NewRecorder()
+	if err := handleViewRun(w, httptest.NewRequest(http.MethodGet, "/runs/run-1/chart.png", nil)); err != nil {
+		t.Fatal(err)
+	}
+	if img, err := png.Decode(w.Body); err != nil || img.Bounds().Dx() != chartDefaultWidth || img.Bounds().Dy() != chartDefaultHeight {
+		t.Errorf("Expected a link preview sized PNG, got %v", err)
+	}
+
+	w = httptest.NewRecorder()
+	if err := handleViewRun(w, httptest.NewRequest(http.MethodGet, "/runs/run-2/chart.png", nil)); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown run, got %d", w.Code)
+	}
+}
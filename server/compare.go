@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+)
+
+// compareRunsLimit caps the number of runs on the comparison page
+const compareRunsLimit = 20
+
+// ComparedRun is a run selected on the comparison page
+type ComparedRun struct {
+	UUID string
+	Name string
+	// AUCs holds the AUC of the run's curve in each of the page's curve
+	// comparisons, or nil where the run logged no such curve
+	AUCs []*float64
+}
+
+// CurveSeries is one run's curve in a curve comparison
+type CurveSeries struct {
+	RunName string       `json:"run_name"`
+	Step    float64      `json:"step"`
+	AUC     float64      `json:"auc"`
+	Points  []CurvePoint `json:"points"`
+}
+
+// CurveComparison overlays the curves that runs logged under the same key
+type CurveComparison struct {
+	Key    string        `json:"key"`
+	Kind   string        `json:"kind"`
+	Label  string        `json:"label"`
+	XLabel string        `json:"x_label"`
+	YLabel string        `json:"y_label"`
+	Series []CurveSeries `json:"series"`
+}
+
+// compareRunCurves groups the latest curves of each run by key and kind, in
+// key order. curvesByRun is parallel to runs.
+func compareRunCurves(runs []ComparedRun, curvesByRun [][]RunCurve) []CurveComparison {
+	type groupKey struct{ key, kind string }
+	index := make(map[groupKey]int)
+	var comparisons []CurveComparison
+	for _, curves := range curvesByRun {
+		for _, c := range curves {
+			k := groupKey{c.Key, c.Kind}
+			if _, ok := index[k]; !ok {
+				index[k] = len(comparisons)
+				comparisons = append(comparisons, CurveComparison{
+					Key:    c.Key,
+					Kind:   c.Kind,
+					Label:  c.Label,
+					XLabel: c.XLabel,
+					YLabel: c.YLabel,
+				})
+			}
+		}
+	}
+	sort.SliceStable(comparisons, func(i, j int) bool {
+		if comparisons[i].Key != comparisons[j].Key {
+			return comparisons[i].Key < comparisons[j].Key
+		}
+		return comparisons[i].Kind < comparisons[j].Kind
+	})
+	for i, c := range comparisons {
+		index[groupKey{c.Key, c.Kind}] = i
+	}
+
+	for i := range runs {
+		runs[i].AUCs = make([]*float64, len(comparisons))
+		for _, c := range curvesByRun[i] {
+			j := index[groupKey{c.Key, c.Kind}]
+			auc := c.AUC
+			runs[i].AUCs[j] = &auc
+			comparisons[j].Series = append(comparisons[j].Series, CurveSeries{
+				RunName: runs[i].Name,
+				Step:    c.Step,
+				AUC:     c.AUC,
+				Points:  c.Points,
+			})
+		}
+	}
+	return comparisons
+}
+
+// compareTemplates are the templates of the run comparison page
+var compareTemplates = registerPage("templates/compare.html", "templates/curve_chart.html")
+
+func handleCompareRuns(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	uuids := r.URL.Query()["run"]
+	if len(uuids) > compareRunsLimit {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "At most %d runs can be compared", compareRunsLimit)
+		return nil
+	}
+
+	var runs []ComparedRun
+	var curvesByRun [][]RunCurve
+	seen := make(map[string]bool)
+	for _, uuid := range uuids {
+		if seen[uuid] {
+			continue
+		}
+		seen[uuid] = true
+
+		run, err := dao.GetRunByUUID(ctx, uuid)
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprintf(w, "Run %s not found", uuid)
+			return nil
+		}
+		runID, err := dao.GetRunIDByUUID(ctx, uuid)
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprintf(w, "Run %s not found", uuid)
+			return nil
+		}
+		curves, err := getRunCurves(ctx, runID)
+		if err != nil {
+			return fmt.Errorf("failed to query curves for run %s: %w", uuid, err)
+		}
+		runs = append(runs, ComparedRun{UUID: uuid, Name: run.Name})
+		curvesByRun = append(curvesByRun, curves)
+	}
+
+	var runUUIDs []string
+	for _, run := range runs {
+		runUUIDs = append(runUUIDs, run.UUID)
+	}
+	data := struct {
+		Title         string
+		Runs          []ComparedRun
+		Curves        []CurveComparison
+		ExportCSVURL  string
+		ExportJSONURL string
+	}{
+		Title:         "Compare runs",
+		Runs:          runs,
+		Curves:        compareRunCurves(runs, curvesByRun),
+		ExportCSVURL:  exportURL(runUUIDs, "csv"),
+		ExportJSONURL: exportURL(runUUIDs, "json"),
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	tmpl, err := compareTemplates.Get()
+	if err != nil {
+		return fmt.Errorf("failed to parse template: %w", err)
+	}
+	return executeTemplate(w, tmpl, "compare.html", data)
+}
@@ -0,0 +1,35 @@
+package main
+
+import "testing"
+
+func TestCompareRunCurves(t *testing.T) {
+	runs := []ComparedRun{{UUID: "a", Name: "run-a"}, {UUID: "b", Name: "run-b"}}
+	curvesByRun := [][]RunCurve{
+		{
+			{Key: "val/roc", Kind: "roc", AUC: 0.9},
+			{Key: "val/pr", Kind: "pr", AUC: 0.7},
+		},
+		{
+			{Key: "val/roc", Kind: "roc", AUC: 0.8},
+		},
+	}
+
+	comparisons := compareRunCurves(runs, curvesByRun)
+
+	if len(comparisons) != 2 || comparisons[0].Key != "val/pr" || comparisons[1].Key != "val/roc" {
+		t.Fatalf("Expected comparisons ordered by key, got %+v", comparisons)
+	}
+	if len(comparisons[0].Series) != 1 || len(comparisons[1].Series) != 2 {
+		t.Errorf("Unexpected series %+v", comparisons)
+	}
+	if s := comparisons[1].Series[1]; s.RunName != "run-b" || s.AUC != 0.8 {
+		t.Errorf("Unexpected series %+v", s)
+	}
+
+	if runs[0].AUCs[0] == nil || *runs[0].AUCs[0] != 0.7 || *runs[0].AUCs[1] != 0.9 {
+		t.Errorf("Unexpected AUCs for run-a: %v", runs[0].AUCs)
+	}
+	if runs[1].AUCs[0] != nil || *runs[1].AUCs[1] != 0.8 {
+		t.Errorf("Unexpected AUCs for run-b: %v", runs[1].AUCs)
+	}
+}
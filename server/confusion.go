@@ -0,0 +1,398 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// confusionMatrixMaxLabels bounds the number of classes in a confusion matrix
+// so its heatmap stays readable
+const confusionMatrixMaxLabels = 100
+
+// confusionMatrixArtifactMaxBytes is the largest JSON artifact inspected for a
+// confusion matrix when it is uploaded
+const confusionMatrixArtifactMaxBytes = 1 << 20
+
+// ConfusionMatrix is a confusion matrix logged by a run. Counts is indexed
+// [true label][predicted label].
+type ConfusionMatrix struct {
+	Key    string
+	Step   float64
+	Labels []string
+	Counts [][]float64
+}
+
+// ConfusionMatrixCell is a cell of a rendered confusion matrix with its count
+// under each normalization. Shade is the count relative to the largest cell.
+type ConfusionMatrixCell struct {
+	Count          float64
+	RowFraction    float64
+	ColumnFraction float64
+	TotalFraction  float64
+	Shade          float64
+}
+
+// ConfusionMatrixViewRow is the row of a rendered confusion matrix for one
+// true label
+type ConfusionMatrixViewRow struct {
+	Label string
+	Cells []ConfusionMatrixCell
+	Total float64
+}
+
+// ConfusionMatrixView is a confusion matrix laid out for the heatmap on the
+// run page, along with the other steps logged under its key
+type ConfusionMatrixView struct {
+	RunUUID      string
+	Key          string
+	Step         float64
+	Steps        []float64
+	Labels       []string
+	Rows         []ConfusionMatrixViewRow
+	ColumnTotals []float64
+	Total        float64
+	Accuracy     *float64
+}
+
+// validateConfusionMatrix checks that counts is a square matrix of
+// non-negative numbers with one row and column per label
+func validateConfusionMatrix(labels []string, counts [][]float64) error {
+	if len(labels) == 0 {
+		return fmt.Errorf("at least one label is required")
+	}
+	if len(labels) > confusionMatrixMaxLabels {
+		return fmt.Errorf("at most %d labels are supported, got %d", confusionMatrixMaxLabels, len(labels))
+	}
+	seen := make(map[string]bool, len(labels))
+	for _, label := range labels {
+		if seen[label] {
+			return fmt.Errorf("duplicate label %q", label)
+		}
+		seen[label] = true
+	}
+	if len(counts) != len(labels) {
+		return fmt.Errorf("matrix has %d rows, expected one per label (%d)", len(counts), len(labels))
+	}
+	for i, row := range counts {
+		if len(row) != len(labels) {
+			return fmt.Errorf("row %d of matrix has %d columns, expected one per label (%d)", i, len(row), len(labels))
+		}
+		for _, count := range row {
+			if math.IsNaN(count) || math.IsInf(count, 0) || count < 0 {
+				return fmt.Errorf("row %d of matrix has invalid count %v", i, count)
+			}
+		}
+	}
+	return nil
+}
+
+// parseConfusionMatrixArtifact detects a confusion matrix in the content of a
+// JSON artifact shaped like {"labels": [...], "matrix": [[...], ...]}
+func parseConfusionMatrixArtifact(content []byte) ([]string, [][]float64, bool) {
+	var doc struct {
+		Labels []string    `json:"labels"`
+		Matrix [][]float64 `json:"matrix"`
+	}
+	if err := json.Unmarshal(content, &doc); err != nil {
+		return nil, nil, false
+	}
+	if validateConfusionMatrix(doc.Labels, doc.Matrix) != nil {
+		return nil, nil, false
+	}
+	return doc.Labels, doc.Matrix, true
+}
+
+// saveConfusionMatrix stores a validated confusion matrix for a run
+func saveConfusionMatrix(ctx context.Context, runID int, m ConfusionMatrix) error {
+	labels, err := json.Marshal(m.Labels)
+	if err != nil {
+		return err
+	}
+	counts, err := json.Marshal(m.Counts)
+	if err != nil {
+		return err
+	}
+	return dao.UpsertConfusionMatrix(ctx, ConfusionMatrixRow{
+		RunID:  runID,
+		Key:    m.Key,
+		Step:   m.Step,
+		Labels: string(labels),
+		Matrix: string(counts),
+	})
+}
+
+// detectConfusionMatrixArtifact records an uploaded JSON artifact that holds a
+// confusion matrix under the artifact's path, without its extension
+func detectConfusionMatrixArtifact(ctx context.Context, runID int, artifactPath, uri string, size int64) {
+	if !strings.HasSuffix(artifactPath, ".json") || size > confusionMatrixArtifactMaxBytes {
+		return
+	}
+	file, err := openArtifact(ctx, uri)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	content, err := io.ReadAll(file)
+	if err != nil {
+		return
+	}
+	labels, counts, ok := parseConfusionMatrixArtifact(content)
+	if !ok {
+		return
+	}
+	m := ConfusionMatrix{Key: strings.TrimSuffix(artifactPath, ".json"), Labels: labels, Counts: counts}
+	if err := saveConfusionMatrix(ctx, runID, m); err != nil {
+		log.Printf("Failed to save confusion matrix from artifact %s: %v", artifactPath, err)
+	}
+}
+
+// confusionMatrixFromRow decodes a stored confusion matrix
+func confusionMatrixFromRow(row ConfusionMatrixRow) (ConfusionMatrix, error) {
+	m := ConfusionMatrix{Key: row.Key, Step: row.Step}
+	if err := json.Unmarshal([]byte(row.Labels), &m.Labels); err != nil {
+		return m, fmt.Errorf("decoding labels of confusion matrix %q: %w", row.Key, err)
+	}
+	if err := json.Unmarshal([]byte(row.Matrix), &m.Counts); err != nil {
+		return m, fmt.Errorf("decoding confusion matrix %q: %w", row.Key, err)
+	}
+	return m, nil
+}
+
+// buildConfusionMatrixView normalizes a confusion matrix by row, column, and
+// total for rendering
+func buildConfusionMatrixView(runUUID string, m ConfusionMatrix, steps []float64) ConfusionMatrixView {
+	view := ConfusionMatrixView{
+		RunUUID:      runUUID,
+		Key:          m.Key,
+		Step:         m.Step,
+		Steps:        steps,
+		Labels:       m.Labels,
+		ColumnTotals: make([]float64, len(m.Labels)),
+	}
+
+	var largest, correct float64
+	rowTotals := make([]float64, len(m.Counts))
+	for i, row := range m.Counts {
+		for j, count := range row {
+			rowTotals[i] += count
+			view.ColumnTotals[j] += count
+			view.Total += count
+			largest = max(largest, count)
+			if i == j {
+				correct += count
+			}
+		}
+	}
+
+	fraction := func(count, total float64) float64 {
+		if total == 0 {
+			return 0
+		}
+		return count / total
+	}
+	for i, row := range m.Counts {
+		viewRow := ConfusionMatrixViewRow{Label: m.Labels[i], Total: rowTotals[i]}
+		for j, count := range row {
+			viewRow.Cells = append(viewRow.Cells, ConfusionMatrixCell{
+				Count:          count,
+				RowFraction:    fraction(count, rowTotals[i]),
+				ColumnFraction: fraction(count, view.ColumnTotals[j]),
+				TotalFraction:  fraction(count, view.Total),
+				Shade:          fraction(count, largest),
+			})
+		}
+		view.Rows = append(view.Rows, viewRow)
+	}
+
+	if view.Total > 0 {
+		accuracy := correct / view.Total
+		view.Accuracy = &accuracy
+	}
+	return view
+}
+
+// getRunConfusionMatrices loads the latest confusion matrix logged by a run
+// under each key
+func getRunConfusionMatrices(ctx context.Context, runID int, runUUID string) ([]ConfusionMatrixView, error) {
+	rows, err := dao.GetConfusionMatricesByRunID(ctx, runID)
+	if err != nil {
+		return nil, err
+	}
+
+	var views []ConfusionMatrixView
+	for i := 0; i < len(rows); {
+		// Rows are ordered by key and step, so the last row of each key is its latest
+		j := i
+		var steps []float64
+		for ; j < len(rows) && rows[j].Key == rows[i].Key; j++ {
+			steps = append(steps, rows[j].Step)
+		}
+		m, err := confusionMatrixFromRow(rows[j-1])
+		if err != nil {
+			return nil, err
+		}
+		views = append(views, buildConfusionMatrixView(runUUID, m, steps))
+		i = j
+	}
+	return views, nil
+}
+
+// getRunConfusionMatrix loads the confusion matrix a run logged under key at
+// step, or nil if there is none
+func getRunConfusionMatrix(ctx context.Context, runID int, runUUID, key string, step float64) (*ConfusionMatrixView, error) {
+	rows, err := dao.GetConfusionMatricesByRunID(ctx, runID)
+	if err != nil {
+		return nil, err
+	}
+
+	var steps []float64
+	var found *ConfusionMatrixRow
+	for i, row := range rows {
+		if row.Key != key {
+			continue
+		}
+		steps = append(steps, row.Step)
+		if row.Step == step {
+			found = &rows[i]
+		}
+	}
+	if found == nil {
+		return nil, nil
+	}
+	m, err := confusionMatrixFromRow(*found)
+	if err != nil {
+		return nil, err
+	}
+	view := buildConfusionMatrixView(runUUID, m, steps)
+	return &view, nil
+}
+
+// confusionMatrixTemplates are the templates of the confusion matrix
+// fragment, which the run overview also includes
+var confusionMatrixTemplates = registerTemplates("templates/run_confusion_matrix.html")
+
+// handleRunConfusionMatrix renders the confusion matrix fragment for one key
+// and step, for switching steps on the run page
+func handleRunConfusionMatrix(w http.ResponseWriter, r *http.Request, runUUID string) {
+	ctx := r.Context()
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	runID, err := dao.GetRunIDByUUID(ctx, runUUID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "Run not found")
+		return
+	}
+	step, err := strconv.ParseFloat(r.URL.Query().Get("step"), 64)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "Invalid step")
+		return
+	}
+
+	view, err := getRunConfusionMatrix(ctx, runID, runUUID, r.URL.Query().Get("key"), step)
+	if err != nil {
+		log.Printf("Failed to load confusion matrix for run %s: %v", runUUID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if view == nil {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "Confusion matrix not found")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	tmpl, err := confusionMatrixTemplates.Get()
+	if err != nil {
+		log.Printf("Failed to parse template: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if err := tmpl.ExecuteTemplate(w, "confusion_matrix", view); err != nil {
+		log.Printf("Failed to execute template: %v", err)
+	}
+}
+
+// LogConfusionMatrixRequest is the body of POST /api/confusion_matrices
+type LogConfusionMatrixRequest struct {
+	RunUUID string      `json:"run_uuid"`
+	Key     string      `json:"key"`
+	Labels  []string    `json:"labels"`
+	Matrix  [][]float64 `json:"matrix"`
+	Step    *float64    `json:"step,omitempty"`
+}
+
+func handleAPILogConfusionMatrix(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req LogConfusionMatrixRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid JSON"})
+		return
+	}
+
+	var missing []string
+	if req.RunUUID == "" {
+		missing = append(missing, "run_uuid")
+	}
+	if req.Key == "" {
+		missing = append(missing, "key")
+	}
+	if req.Labels == nil {
+		missing = append(missing, "labels")
+	}
+	if req.Matrix == nil {
+		missing = append(missing, "matrix")
+	}
+	if len(missing) > 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":          "Missing required fields",
+			"missing_fields": missing,
+		})
+		return
+	}
+
+	if err := validateConfusionMatrix(req.Labels, req.Matrix); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": fmt.Sprintf("Invalid confusion matrix: %v", err)})
+		return
+	}
+
+	runID, err := dao.GetRunIDByUUID(ctx, req.RunUUID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Run not found"})
+		return
+	}
+
+	m := ConfusionMatrix{Key: req.Key, Labels: req.Labels, Counts: req.Matrix}
+	if req.Step != nil {
+		m.Step = *req.Step
+	}
+	if err := saveConfusionMatrix(ctx, runID, m); err != nil {
+		log.Printf("Error saving confusion matrix: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to save confusion matrix"})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
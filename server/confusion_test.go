@@ -0,0 +1,87 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestValidateConfusionMatrix(t *testing.T) {
+	tests := []struct {
+		name    string
+		labels  []string
+		counts  [][]float64
+		wantErr bool
+	}{
+		{"valid", []string{"cat", "dog"}, [][]float64{{5, 1}, {2, 7}}, false},
+		{"single class", []string{"cat"}, [][]float64{{3}}, false},
+		{"no labels", nil, nil, true},
+		{"duplicate label", []string{"cat", "cat"}, [][]float64{{5, 1}, {2, 7}}, true},
+		{"missing row", []string{"cat", "dog"}, [][]float64{{5, 1}}, true},
+		{"ragged row", []string{"cat", "dog"}, [][]float64{{5, 1}, {2}}, true},
+		{"negative count", []string{"cat", "dog"}, [][]float64{{5, -1}, {2, 7}}, true},
+		{"NaN count", []string{"cat", "dog"}, [][]float64{{5, math.NaN()}, {2, 7}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateConfusionMatrix(tt.labels, tt.counts)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateConfusionMatrix() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseConfusionMatrixArtifact(t *testing.T) {
+	labels, counts, ok := parseConfusionMatrixArtifact([]byte(`{"labels": ["pos", "neg"], "matrix": [[9, 1], [2, 8]]}`))
+	if !ok {
+		t.Fatalf("Expected confusion matrix to be detected")
+	}
+	if !reflect.DeepEqual(labels, []string{"pos", "neg"}) || !reflect.DeepEqual(counts, [][]float64{{9, 1}, {2, 8}}) {
+		t.Errorf("Unexpected confusion matrix %v %v", labels, counts)
+	}
+
+	for _, content := range []string{
+		`{"accuracy": 0.9}`,
+		`{"labels": ["pos", "neg"], "matrix": [[9, 1]]}`,
+		`[1, 2, 3]`,
+		`not json`,
+	} {
+		if _, _, ok := parseConfusionMatrixArtifact([]byte(content)); ok {
+			t.Errorf("Expected no confusion matrix in %s", content)
+		}
+	}
+}
+
+func TestBuildConfusionMatrixView(t *testing.T) {
+	m := ConfusionMatrix{
+		Key:    "val/confusion",
+		Step:   2,
+		Labels: []string{"cat", "dog"},
+		Counts: [][]float64{{6, 2}, {0, 0}},
+	}
+	view := buildConfusionMatrixView("run-uuid", m, []float64{1, 2})
+
+	if view.Total != 8 || !reflect.DeepEqual(view.ColumnTotals, []float64{6, 2}) {
+		t.Errorf("Unexpected totals %v %v", view.Total, view.ColumnTotals)
+	}
+	if view.Accuracy == nil || *view.Accuracy != 0.75 {
+		t.Errorf("Expected accuracy 0.75, got %v", view.Accuracy)
+	}
+
+	want := ConfusionMatrixCell{Count: 2, RowFraction: 0.25, ColumnFraction: 1, TotalFraction: 0.25, Shade: 2.0 / 6}
+	if got := view.Rows[0].Cells[1]; got != want {
+		t.Errorf("Cell [cat][dog] = %+v, want %+v", got, want)
+	}
+	// A label that never occurs normalizes to zero rather than NaN
+	if got := view.Rows[1].Cells[0]; got.RowFraction != 0 || got.ColumnFraction != 0 {
+		t.Errorf("Cell [dog][cat] = %+v, want zero fractions", got)
+	}
+}
+
+func TestBuildConfusionMatrixViewEmpty(t *testing.T) {
+	view := buildConfusionMatrixView("run-uuid", ConfusionMatrix{Labels: []string{"a"}, Counts: [][]float64{{0}}}, nil)
+	if view.Accuracy != nil {
+		t.Errorf("Expected no accuracy for an empty matrix, got %v", *view.Accuracy)
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// costMachineTypeParamKey is the run parameter a cost model matches its
+// rates against
+const costMachineTypeParamKey = "machine_type"
+
+// costDefaultMachineType is the machine type of the rate that prices runs
+// whose machine type has no rate of its own, or that logged none
+const costDefaultMachineType = "*"
+
+// CostRate is the price of a GPU-hour on a machine type
+type CostRate struct {
+	MachineType   string
+	USDPerGPUHour float64
+}
+
+// validateCostRate checks a rate before it is saved
+func validateCostRate(machineType string, usdPerGPUHour float64) error {
+	if strings.TrimSpace(machineType) == "" {
+		return fmt.Errorf("machine type is required")
+	}
+	if math.IsNaN(usdPerGPUHour) || math.IsInf(usdPerGPUHour, 0) || usdPerGPUHour < 0 {
+		return fmt.Errorf("price per GPU-hour must be a non-negative number")
+	}
+	return nil
+}
+
+// costRateFor returns the rate that prices a run on machineType, falling back
+// to the default rate
+func costRateFor(rates []CostRate, machineType string) (float64, bool) {
+	var fallback *float64
+	for i, rate := range rates {
+		if machineType != "" && rate.MachineType == machineType {
+			return rate.USDPerGPUHour, true
+		}
+		if rate.MachineType == costDefaultMachineType {
+			fallback = &rates[i].USDPerGPUHour
+		}
+	}
+	if fallback == nil {
+		return 0, false
+	}
+	return *fallback, true
+}
+
+// estimateRunCost prices a run's GPU-hours. It reports false if the run has
+// no GPU summary or no rate applies to it.
+func estimateRunCost(gpu *RunGPUSummary, machineType string, rates []CostRate) (float64, bool) {
+	if gpu == nil {
+		return 0, false
+	}
+	rate, ok := costRateFor(rates, machineType)
+	if !ok {
+		return 0, false
+	}
+	return gpu.GPUHours * rate, true
+}
+
+// getExperimentCostRates loads the cost model of an experiment
+func getExperimentCostRates(ctx context.Context, experimentID int) ([]CostRate, error) {
+	rows, err := dao.GetExperimentCostRates(ctx, experimentID)
+	if err != nil {
+		return nil, err
+	}
+	var rates []CostRate
+	for _, row := range rows {
+		rates = append(rates, CostRate{MachineType: row.MachineType, USDPerGPUHour: row.USDPerGPUHour})
+	}
+	return rates, nil
+}
+
+// getExperimentRunCosts estimates the cost of every run of an experiment that
+// has a GPU summary and a matching rate, keyed by run ID
+func getExperimentRunCosts(ctx context.Context, experimentID int, gpuSummaries map[int]*RunGPUSummary) (map[int]float64, error) {
+	costs := make(map[int]float64)
+	if len(gpuSummaries) == 0 {
+		return costs, nil
+	}
+	rates, err := getExperimentCostRates(ctx, experimentID)
+	if err != nil || len(rates) == 0 {
+		return costs, err
+	}
+	params, err := dao.GetParametersByExperimentID(ctx, experimentID)
+	if err != nil {
+		return nil, err
+	}
+
+	machineTypes := make(map[int]string)
+	for _, p := range params {
+		if p.Key == costMachineTypeParamKey {
+			machineTypes[p.RunID] = formatParameterValue(p.ParameterRow)
+		}
+	}
+	for runID, gpu := range gpuSummaries {
+		if cost, ok := estimateRunCost(gpu, machineTypes[runID], rates); ok {
+			costs[runID] = cost
+		}
+	}
+	return costs, nil
+}
+
+// getRunCost estimates the cost of a single run of an experiment
+func getRunCost(ctx context.Context, runID, experimentID int, gpu *RunGPUSummary) (float64, bool, error) {
+	if gpu == nil {
+		return 0, false, nil
+	}
+	rates, err := getExperimentCostRates(ctx, experimentID)
+	if err != nil || len(rates) == 0 {
+		return 0, false, err
+	}
+	params, err := dao.GetParametersByRunID(ctx, runID)
+	if err != nil {
+		return 0, false, err
+	}
+
+	var machineType string
+	for _, p := range params {
+		if p.Key == costMachineTypeParamKey {
+			machineType = formatParameterValue(p)
+		}
+	}
+	cost, ok := estimateRunCost(gpu, machineType, rates)
+	return cost, ok, nil
+}
+
+// runCostPointer returns the estimated cost of a run, or nil if it has none
+func runCostPointer(costs map[int]float64, runID int) *float64 {
+	cost, ok := costs[runID]
+	if !ok {
+		return nil
+	}
+	return &cost
+}
+
+// formatUSD renders a dollar amount for display
+func formatUSD(amount float64) string {
+	return fmt.Sprintf("$%.2f", amount)
+}
+
+// costModelTemplates are the templates of the cost model panel of the experiment page
+var costModelTemplates = registerTemplates("templates/experiment_cost_model.html")
+
+func handleExperimentCostRates(w http.ResponseWriter, r *http.Request, experimentUUID, action string) {
+	ctx := r.Context()
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	experimentID, err := dao.GetExperimentIDByUUID(ctx, experimentUUID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "Experiment not found")
+		return
+	}
+
+	var formError string
+	if machineType, ok := strings.CutPrefix(action, "delete/"); ok {
+		if err := dao.DeleteExperimentCostRate(ctx, experimentID, machineType); err != nil {
+			log.Printf("Failed to delete cost rate %q: %v", machineType, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	} else {
+		machineType := strings.TrimSpace(r.FormValue("machine_type"))
+		usdPerGPUHour, err := strconv.ParseFloat(strings.TrimSpace(r.FormValue("usd_per_gpu_hour")), 64)
+		if err != nil {
+			formError = "price per GPU-hour must be a number"
+		} else if err := validateCostRate(machineType, usdPerGPUHour); err != nil {
+			formError = err.Error()
+		} else if err := dao.UpsertExperimentCostRate(ctx, experimentID, machineType, usdPerGPUHour); err != nil {
+			log.Printf("Failed to save cost rate %q: %v", machineType, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// Costs in the runs table depend on the cost model, so reload the page
+	// rather than swapping the fragment
+	if formError == "" {
+		w.Header().Set("HX-Refresh", "true")
+		return
+	}
+
+	rates, err := getExperimentCostRates(ctx, experimentID)
+	if err != nil {
+		log.Printf("Failed to load cost rates for experiment %s: %v", experimentUUID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// Return the cost model fragment with the error for htmx to swap in
+	data := struct {
+		ExperimentUUID string
+		CostRates      []CostRate
+		CostRateError  string
+	}{
+		ExperimentUUID: experimentUUID,
+		CostRates:      rates,
+		CostRateError:  formError,
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	tmpl, err := costModelTemplates.Get()
+	if err != nil {
+		log.Printf("Failed to parse template: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if err := executeTemplate(w, tmpl, "experiment_cost_model", data); err != nil {
+		log.Printf("Failed to render template: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestEstimateRunCost(t *testing.T) {
+	rates := []CostRate{
+		{MachineType: "*", USDPerGPUHour: 1},
+		{MachineType: "a100", USDPerGPUHour: 3},
+	}
+	gpu := &RunGPUSummary{GPUHours: 2}
+
+	tests := []struct {
+		name        string
+		gpu         *RunGPUSummary
+		machineType string
+		rates       []CostRate
+		want        float64
+		wantOK      bool
+	}{
+		{name: "matching machine type", gpu: gpu, machineType: "a100", rates: rates, want: 6, wantOK: true},
+		{name: "unknown machine type uses default", gpu: gpu, machineType: "v100", rates: rates, want: 2, wantOK: true},
+		{name: "no machine type uses default", gpu: gpu, rates: rates, want: 2, wantOK: true},
+		{name: "no default rate", gpu: gpu, machineType: "v100", rates: rates[1:]},
+		{name: "no GPU summary", machineType: "a100", rates: rates},
+		{name: "no rates", gpu: gpu, machineType: "a100"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := estimateRunCost(tt.gpu, tt.machineType, tt.rates)
+			if ok != tt.wantOK || math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("estimateRunCost() = %v, %v, want %v, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestValidateCostRate(t *testing.T) {
+	tests := []struct {
+		machineType string
+		rate        float64
+		wantErr     bool
+	}{
+		{"a100", 3.2, false},
+		{"*", 0, false},
+		{"", 1, true},
+		{"  ", 1, true},
+		{"a100", -0.5, true},
+		{"a100", math.NaN(), true},
+		{"a100", math.Inf(1), true},
+	}
+
+	for _, tt := range tests {
+		if err := validateCostRate(tt.machineType, tt.rate); (err != nil) != tt.wantErr {
+			t.Errorf("validateCostRate(%q, %v) error = %v, wantErr %v", tt.machineType, tt.rate, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+)
+
+// curveMaxPoints bounds the number of points in a logged curve
+const curveMaxPoints = 10000
+
+// CurveKind is a kind of curve a run can log, with the axes its points are
+// plotted on
+type CurveKind struct {
+	Kind   string
+	Label  string
+	XLabel string
+	YLabel string
+}
+
+// curveKinds are the kinds of curves a run can log. A ROC curve plots the
+// true positive rate against the false positive rate, and a precision-recall
+// curve plots precision against recall.
+var curveKinds = []CurveKind{
+	{"roc", "ROC", "False positive rate", "True positive rate"},
+	{"pr", "Precision-recall", "Recall", "Precision"},
+}
+
+// CurvePoint is a point of a curve at a classification threshold. The
+// threshold is nil if it was not logged or is not finite.
+type CurvePoint struct {
+	Threshold *float64 `json:"threshold,omitempty"`
+	X         float64  `json:"x"`
+	Y         float64  `json:"y"`
+}
+
+// RunCurve is a curve logged by a run, with its area under the curve
+type RunCurve struct {
+	Key    string       `json:"key"`
+	Kind   string       `json:"kind"`
+	Label  string       `json:"label"`
+	XLabel string       `json:"x_label"`
+	YLabel string       `json:"y_label"`
+	Step   float64      `json:"step"`
+	AUC    float64      `json:"auc"`
+	Points []CurvePoint `json:"points"`
+}
+
+// curveKind looks up a kind of curve
+func curveKind(kind string) (CurveKind, bool) {
+	for _, k := range curveKinds {
+		if k.Kind == kind {
+			return k, true
+		}
+	}
+	return CurveKind{}, false
+}
+
+// buildCurvePoints validates the coordinates of a curve and zips them into
+// points. thresholds may be empty; if not, it must have one entry per point.
+func buildCurvePoints(x, y []float64, thresholds []*float64) ([]CurvePoint, error) {
+	if len(x) != len(y) {
+		return nil, fmt.Errorf("curve has %d x values but %d y values", len(x), len(y))
+	}
+	if len(x) < 2 {
+		return nil, fmt.Errorf("curve must have at least 2 points")
+	}
+	if len(x) > curveMaxPoints {
+		return nil, fmt.Errorf("curve has %d points, at most %d are supported", len(x), curveMaxPoints)
+	}
+	if len(thresholds) > 0 && len(thresholds) != len(x) {
+		return nil, fmt.Errorf("curve has %d points but %d thresholds", len(x), len(thresholds))
+	}
+
+	inUnitInterval := func(v float64) bool {
+		return !math.IsNaN(v) && v >= 0 && v <= 1
+	}
+	points := make([]CurvePoint, len(x))
+	for i := range x {
+		if !inUnitInterval(x[i]) || !inUnitInterval(y[i]) {
+			return nil, fmt.Errorf("point %d (%v, %v) is outside [0, 1]", i, x[i], y[i])
+		}
+		points[i] = CurvePoint{X: x[i], Y: y[i]}
+		if len(thresholds) > 0 && thresholds[i] != nil && !math.IsInf(*thresholds[i], 0) && !math.IsNaN(*thresholds[i]) {
+			points[i].Threshold = thresholds[i]
+		}
+	}
+	return points, nil
+}
+
+// curveAUC computes the area under a curve with the trapezoidal rule, over its
+// points ordered by x
+func curveAUC(points []CurvePoint) float64 {
+	sorted := make([]CurvePoint, len(points))
+	copy(sorted, points)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].X != sorted[j].X {
+			return sorted[i].X < sorted[j].X
+		}
+		return sorted[i].Y < sorted[j].Y
+	})
+
+	var area float64
+	for i := 1; i < len(sorted); i++ {
+		area += (sorted[i].X - sorted[i-1].X) * (sorted[i].Y + sorted[i-1].Y) / 2
+	}
+	return area
+}
+
+// runCurveFromRow decodes a stored curve
+func runCurveFromRow(row RunCurveRow) (RunCurve, error) {
+	kind, _ := curveKind(row.Kind)
+	curve := RunCurve{
+		Key:    row.Key,
+		Kind:   row.Kind,
+		Label:  kind.Label,
+		XLabel: kind.XLabel,
+		YLabel: kind.YLabel,
+		Step:   row.Step,
+		AUC:    row.AUC,
+	}
+	if err := json.Unmarshal([]byte(row.Points), &curve.Points); err != nil {
+		return curve, fmt.Errorf("decoding curve %q: %w", row.Key, err)
+	}
+	return curve, nil
+}
+
+// getRunCurves loads the latest curve logged by a run under each key
+func getRunCurves(ctx context.Context, runID int) ([]RunCurve, error) {
+	rows, err := dao.GetRunCurvesByRunID(ctx, runID)
+	if err != nil {
+		return nil, err
+	}
+
+	var curves []RunCurve
+	for i, row := range rows {
+		// Rows are ordered by key and step, so the last row of each key is its latest
+		if i+1 < len(rows) && rows[i+1].Key == row.Key {
+			continue
+		}
+		curve, err := runCurveFromRow(row)
+		if err != nil {
+			return nil, err
+		}
+		curves = append(curves, curve)
+	}
+	return curves, nil
+}
+
+// LogCurveRequest is the body of POST /api/curves
+type LogCurveRequest struct {
+	RunUUID    string     `json:"run_uuid"`
+	Key        string     `json:"key"`
+	Kind       string     `json:"kind"`
+	FPR        []float64  `json:"fpr"`
+	TPR        []float64  `json:"tpr"`
+	Precision  []float64  `json:"precision"`
+	Recall     []float64  `json:"recall"`
+	Thresholds []*float64 `json:"thresholds"`
+	Step       *float64   `json:"step,omitempty"`
+}
+
+func handleAPILogCurve(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req LogCurveRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid JSON"})
+		return
+	}
+
+	// The coordinates a curve is logged with depend on its kind
+	var x, y []float64
+	var missing []string
+	if req.RunUUID == "" {
+		missing = append(missing, "run_uuid")
+	}
+	if req.Key == "" {
+		missing = append(missing, "key")
+	}
+	switch req.Kind {
+	case "":
+		missing = append(missing, "kind")
+	case "roc":
+		x, y = req.FPR, req.TPR
+		if x == nil {
+			missing = append(missing, "fpr")
+		}
+		if y == nil {
+			missing = append(missing, "tpr")
+		}
+	case "pr":
+		x, y = req.Recall, req.Precision
+		if y == nil {
+			missing = append(missing, "precision")
+		}
+		if x == nil {
+			missing = append(missing, "recall")
+		}
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": fmt.Sprintf("Unknown curve kind %q (expected roc or pr)", req.Kind)})
+		return
+	}
+	if len(missing) > 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":          "Missing required fields",
+			"missing_fields": missing,
+		})
+		return
+	}
+
+	points, err := buildCurvePoints(x, y, req.Thresholds)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": fmt.Sprintf("Invalid curve: %v", err)})
+		return
+	}
+
+	runID, err := dao.GetRunIDByUUID(ctx, req.RunUUID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Run not found"})
+		return
+	}
+
+	encoded, err := json.Marshal(points)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to encode curve"})
+		return
+	}
+	auc := curveAUC(points)
+	row := RunCurveRow{RunID: runID, Key: req.Key, Kind: req.Kind, Points: string(encoded), AUC: auc}
+	if req.Step != nil {
+		row.Step = *req.Step
+	}
+	if err := dao.UpsertRunCurve(ctx, row); err != nil {
+		log.Printf("Error saving curve: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to save curve"})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": "ok",
+		"auc":    auc,
+	})
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestBuildCurvePoints(t *testing.T) {
+	threshold := 0.5
+	inf := math.Inf(1)
+	points, err := buildCurvePoints([]float64{0, 0.5, 1}, []float64{0, 0.8, 1}, []*float64{&inf, &threshold, nil})
+	if err != nil {
+		t.Fatalf("buildCurvePoints failed: %v", err)
+	}
+	if len(points) != 3 || points[1].X != 0.5 || points[1].Y != 0.8 {
+		t.Errorf("Unexpected points %+v", points)
+	}
+	// Infinite and missing thresholds are dropped
+	if points[0].Threshold != nil || points[2].Threshold != nil || points[1].Threshold == nil || *points[1].Threshold != 0.5 {
+		t.Errorf("Unexpected thresholds %+v", points)
+	}
+
+	tests := []struct {
+		name       string
+		x, y       []float64
+		thresholds []*float64
+	}{
+		{"mismatched lengths", []float64{0, 1}, []float64{0}, nil},
+		{"single point", []float64{0}, []float64{0}, nil},
+		{"out of range", []float64{0, 1.5}, []float64{0, 1}, nil},
+		{"negative", []float64{0, 1}, []float64{-0.1, 1}, nil},
+		{"NaN", []float64{0, math.NaN()}, []float64{0, 1}, nil},
+		{"thresholds length", []float64{0, 1}, []float64{0, 1}, []*float64{&threshold}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := buildCurvePoints(tt.x, tt.y, tt.thresholds); err == nil {
+				t.Errorf("Expected an error")
+			}
+		})
+	}
+}
+
+func TestCurveAUC(t *testing.T) {
+	tests := []struct {
+		name   string
+		points []CurvePoint
+		want   float64
+	}{
+		{"chance", []CurvePoint{{X: 0, Y: 0}, {X: 1, Y: 1}}, 0.5},
+		{"perfect", []CurvePoint{{X: 0, Y: 0}, {X: 0, Y: 1}, {X: 1, Y: 1}}, 1},
+		{"unordered", []CurvePoint{{X: 1, Y: 1}, {X: 0.5, Y: 0.5}, {X: 0, Y: 0}}, 0.5},
+		{"precision-recall", []CurvePoint{{X: 0, Y: 1}, {X: 0.5, Y: 0.8}, {X: 1, Y: 0.5}}, 0.775},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := curveAUC(tt.points); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("curveAUC() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,44 +1,256 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"time"
 )
 
 // DAO defines the interface for database operations
 type DAO interface {
+	// Project operations
+	InsertProject(ctx context.Context, uuid, name, artifactPrefix string) error
+	GetProjectByID(ctx context.Context, id int) (*ProjectRow, error)
+	GetProjectByUUID(ctx context.Context, uuid string) (*ProjectRow, error)
+	GetProjectByName(ctx context.Context, name string) (*ProjectRow, error)
+	GetAllProjects(ctx context.Context) ([]ProjectRow, error)
+	SetExperimentProject(ctx context.Context, experimentID, projectID int) error
+	GetRunProjectID(ctx context.Context, runID int) (int, error)
+
 	// Experiment operations
-	InsertExperiment(uuid, name string) error
-	GetExperimentByUUID(uuid string) (*Experiment, error)
-	GetExperimentIDByUUID(uuid string) (int, error)
-	GetAllExperiments() ([]Experiment, error)
-	GetDefaultExperimentID() (int, error)
+	InsertExperiment(ctx context.Context, uuid, name string) error
+	GetExperimentByUUID(ctx context.Context, uuid string) (*Experiment, error)
+	GetExperimentIDByUUID(ctx context.Context, uuid string) (int, error)
+	GetExperimentByID(ctx context.Context, id int) (*Experiment, error)
+	GetAllExperiments(ctx context.Context) ([]Experiment, error)
+	GetDefaultExperimentID(ctx context.Context) (int, error)
+	UpdateExperimentReadme(ctx context.Context, experimentID int, readme string) error
+	GetExperimentReadmeRevisions(ctx context.Context, experimentID int) ([]ExperimentReadmeRevisionRow, error)
 
 	// Run operations
-	InsertRun(uuid, name string, experimentID int, parentRunID *int) error
-	GetRunByUUID(uuid string) (*Run, error)
-	GetRunByID(id int) (*Run, error)
-	GetRunIDByUUID(uuid string) (int, error)
-	GetAllRuns() ([]Run, error)
-	GetRunsByExperimentID(experimentID int) ([]Run, error)
-	GetRunsByExperimentIDAndLevel(experimentID int, nestingLevel int) ([]Run, error)
-	GetChildRuns(parentRunID int) ([]Run, error)
-	GetChildRunCount(parentRunID int) (int, error)
-	UpdateRunNotes(runID int, notes string) error
-	GetExperimentForRunUUID(runUUID string) (*Experiment, error)
+	InsertRun(ctx context.Context, uuid, name string, experimentID int, parentRunID *int) error
+	InsertRunWithSourceAndSeeds(ctx context.Context, uuid, name string, experimentID int, parentRunID *int, source RunSourceRow, seeds RunSeedsRow) error
+	GetRunByUUID(ctx context.Context, uuid string) (*Run, error)
+	GetRunByID(ctx context.Context, id int) (*Run, error)
+	GetRunIDByUUID(ctx context.Context, uuid string) (int, error)
+	GetAllRuns(ctx context.Context) ([]Run, error)
+	GetRunsByExperimentID(ctx context.Context, experimentID int) ([]Run, error)
+	GetRunsByExperimentIDAndLevel(ctx context.Context, experimentID int, nestingLevel int) ([]Run, error)
+	GetChildRuns(ctx context.Context, parentRunID int) ([]Run, error)
+	GetChildRunCount(ctx context.Context, parentRunID int) (int, error)
+	UpdateRunNotes(ctx context.Context, runID int, notes string) error
+	BackdateRun(ctx context.Context, runID int, at time.Time) error
+	SetRunHold(ctx context.Context, runID int, reason string) error
+	ClearRunHold(ctx context.Context, runID int) error
+	GetRunHold(ctx context.Context, runID int) (*RunHoldRow, error)
+	SetRunSource(ctx context.Context, runID int, source RunSourceRow) error
+	GetRunSource(ctx context.Context, runID int) (*RunSourceRow, error)
+	SetRunSeeds(ctx context.Context, runID int, seeds RunSeedsRow) error
+	GetRunSeeds(ctx context.Context, runID int) (*RunSeedsRow, error)
+	SetRunForkedFrom(ctx context.Context, runID int, sourceUUID string) error
+	UpdateRunStatus(ctx context.Context, runID int, status, message string) error
+	RecordRunActivity(ctx context.Context, runID int, at time.Time) error
+	FailStaleRuns(ctx context.Context, cutoff time.Time) ([]string, error)
+	GetRunHealth(ctx context.Context, failedSince, quietBefore time.Time) (*RunHealthRow, error)
+	GetFailedRuns(ctx context.Context, experimentID int) ([]FailedRunRow, error)
+	GetExperimentForRunUUID(ctx context.Context, runUUID string) (*Experiment, error)
+	GetRuns(ctx context.Context, offset, limit int, sortBy, sortDir string, filter RunFilter) ([]RunSummary, error)
+	SearchRuns(ctx context.Context, terms []string, limit, projectID int) ([]RunSearchRow, error)
+	FindRunsByNameOrUUIDPrefix(ctx context.Context, query string) ([]Run, error)
+	MarkRunDeleted(ctx context.Context, runID int, at time.Time, deletedBy string) error
+	MarkRunPurgeNoticeSent(ctx context.Context, runID int, at time.Time) error
+	GetDeletedRuns(ctx context.Context) ([]DeletedRunRow, error)
+	RestoreRun(ctx context.Context, runID int) error
+	PurgeRun(ctx context.Context, runID int) error
+	ArchiveRun(ctx context.Context, runID int, at time.Time) error
+	UnarchiveRun(ctx context.Context, runID int) error
+	GetRunsToArchive(ctx context.Context, createdBefore time.Time) ([]RetentionRunRow, error)
+	GetRunsArchivedBefore(ctx context.Context, cutoff time.Time) ([]RetentionRunRow, error)
 
 	// Parameter operations
-	UpsertParameter(runID int, key, valueType string, valueString *string, valueBool *bool, valueFloat *float64, valueInt *int64) error
-	GetParametersByRunID(runID int) ([]ParameterRow, error)
+	UpsertParameter(ctx context.Context, runID int, key, valueType string, valueString *string, valueBool *bool, valueFloat *float64, valueInt *int64) error
+	UpsertParameters(ctx context.Context, runID int, params []ParameterRow) error
+	UpsertExperimentParameters(ctx context.Context, params []ExperimentParameterRow) error
+	GetParametersByRunID(ctx context.Context, runID int) ([]ParameterRow, error)
+	GetParametersByExperimentID(ctx context.Context, experimentID int) ([]ExperimentParameterRow, error)
 
 	// Metric operations
-	InsertMetrics(runID int, key string, xValues []float64, yValues []float64, loggedAt int64) error
-	GetMetricsByRunID(runID int) ([]MetricRow, error)
+	InsertMetrics(ctx context.Context, runID int, key string, xValues []float64, yValues []float64, loggedAt int64) error
+	GetMetricsByRunID(ctx context.Context, runID int) ([]MetricRow, error)
+	GetLatestMetricsByRunID(ctx context.Context, runID int) ([]MetricRow, error)
+	InsertSystemMetrics(ctx context.Context, runID int, points []MetricRow) error
+	InsertMetricBatch(ctx context.Context, points map[int][]MetricRow) error
+	GetLatestSystemMetricsByRunID(ctx context.Context, runID int) ([]MetricRow, error)
+	GetMetricSeries(ctx context.Context, runID int, key string) ([]MetricRow, error)
+	GetMetricsDownsampled(ctx context.Context, runID int, key string, maxPoints int) ([]MetricRow, error)
+	GetMetricGaps(ctx context.Context, runID int, key string, threshold time.Duration) ([]MetricGapRow, error)
+	MergeRunMetrics(ctx context.Context, sourceRunID, targetRunID int) (int, error)
+	GetMetricsLoggedBetween(ctx context.Context, runID int, key string, from, to time.Time) ([]MetricRow, error)
+	GetMetricsAfter(ctx context.Context, runID int, key string, afterX float64, limit int) ([]MetricRow, error)
+	GetBestMetric(ctx context.Context, runID int, key string, maximize bool) (*MetricRow, error)
+	FindMetricSeries(ctx context.Context, query string, limit, projectID int) ([]MetricSeriesRow, error)
+	GetMetricSummariesByRunIDs(ctx context.Context, runIDs []int) ([]MetricSummaryRow, error)
+	UpsertRunGPUSummary(ctx context.Context, summary RunGPUSummaryRow) error
+	GetRunGPUSummary(ctx context.Context, runID int) (*RunGPUSummaryRow, error)
+	GetRunGPUSummariesByExperimentID(ctx context.Context, experimentID int) ([]RunGPUSummaryRow, error)
+
+	// Cost model operations
+	UpsertExperimentCostRate(ctx context.Context, experimentID int, machineType string, usdPerGPUHour float64) error
+	DeleteExperimentCostRate(ctx context.Context, experimentID int, machineType string) error
+	GetExperimentCostRates(ctx context.Context, experimentID int) ([]CostRateRow, error)
+
+	// Metric schema operations
+	UpsertMetricSchemaEntry(ctx context.Context, experimentID int, entry MetricSchemaEntryRow) error
+	DeleteMetricSchemaEntry(ctx context.Context, experimentID int, key string) error
+	GetMetricSchema(ctx context.Context, experimentID int) ([]MetricSchemaEntryRow, error)
+	GetMetricSchemaForRun(ctx context.Context, runID int) ([]MetricSchemaEntryRow, error)
+	RecordMetricSchemaWarning(ctx context.Context, runID int, key, kind, detail string) error
+	GetMetricSchemaWarnings(ctx context.Context, experimentID int) ([]MetricSchemaWarningRow, error)
+	DeleteMetricSchemaWarnings(ctx context.Context, experimentID int) error
+
+	// Metric namespace operations
+	GetRunMetricNamespaces(ctx context.Context, runID int) ([]MetricNamespaceRow, error)
+	UpsertMetricNamespaceSetting(ctx context.Context, setting MetricNamespaceSettingRow) error
+	DeleteMetricNamespaceSetting(ctx context.Context, experimentID int, namespace string) (bool, error)
+	GetMetricNamespaceSettings(ctx context.Context, experimentID int) ([]MetricNamespaceSettingRow, error)
+	GetMetricNamespaceSettingsForRun(ctx context.Context, runID int) ([]MetricNamespaceSettingRow, error)
+	GetNamespaceMetricPoints(ctx context.Context, experimentID int, namespace string, endedBefore time.Time) ([]RunMetricPointsRow, error)
+	DeleteNamespaceMetrics(ctx context.Context, runID int, namespace string) (int64, error)
+	RecordMetricAlert(ctx context.Context, runID int, key string, value float64, at time.Time) (bool, error)
+
+	// Quota operations
+	SetExperimentQuota(ctx context.Context, experimentID int, quota ExperimentQuotaRow) error
+	DeleteExperimentQuota(ctx context.Context, experimentID int) (bool, error)
+	GetExperimentQuota(ctx context.Context, experimentID int) (*ExperimentQuotaRow, error)
+	GetExperimentUsage(ctx context.Context, experimentID int, day string) (*ExperimentUsageRow, error)
+	AddExperimentMetricPoints(ctx context.Context, experimentID int, day string, points int) error
+	GetRunExperimentID(ctx context.Context, runID int) (int, error)
+
+	// Run dependency operations
+	InsertRunDependency(ctx context.Context, runID, upstreamRunID int, kind, artifactPath string) error
+	DeleteRunDependency(ctx context.Context, id int) error
+	GetRunDependencyEdges(ctx context.Context, runID int) ([]RunDependencyRow, error)
+
+	// Confusion matrix operations
+	UpsertConfusionMatrix(ctx context.Context, m ConfusionMatrixRow) error
+	GetConfusionMatricesByRunID(ctx context.Context, runID int) ([]ConfusionMatrixRow, error)
+
+	// Curve operations
+	UpsertRunCurve(ctx context.Context, c RunCurveRow) error
+	GetRunCurvesByRunID(ctx context.Context, runID int) ([]RunCurveRow, error)
+
+	// Text sample operations
+	UpsertTextSamples(ctx context.Context, s TextSamplesRow) error
+	GetTextSampleStepsByRunID(ctx context.Context, runID int) ([]TextSamplesRow, error)
+	GetTextSamples(ctx context.Context, runID int, key string, step float64) (*TextSamplesRow, error)
+
+	// Tag operations
+	SetRunTag(ctx context.Context, runID int, key, value string) error
+	GetRunTags(ctx context.Context, runID int) ([]RunTagRow, error)
+	DeleteRunTag(ctx context.Context, runID int, key string) (bool, error)
+
+	// Best checkpoint operations
+	UpsertBestCheckpointRule(ctx context.Context, experimentID int, rule BestCheckpointRuleRow) error
+	DeleteBestCheckpointRule(ctx context.Context, experimentID int) error
+	GetBestCheckpointRule(ctx context.Context, experimentID int) (*BestCheckpointRuleRow, error)
+	SetRunBestCheckpoint(ctx context.Context, c RunBestCheckpointRow) error
+	ClearRunBestCheckpoint(ctx context.Context, runID int) (bool, error)
+	GetRunBestCheckpoint(ctx context.Context, runID int) (*RunBestCheckpointRow, error)
+	GetRunBestCheckpointsByExperimentID(ctx context.Context, experimentID int) ([]RunBestCheckpointRow, error)
+
+	// Housekeeping operations
+	InsertHousekeepingReport(ctx context.Context, r HousekeepingReportRow) (int, error)
+	GetHousekeepingReport(ctx context.Context, id int) (*HousekeepingReportRow, error)
+	GetHousekeepingReports(ctx context.Context, limit int) ([]HousekeepingReportRow, error)
+	MarkHousekeepingReportExecuted(ctx context.Context, id int, executedAt time.Time) (bool, error)
+
+	// Schema introspection operations. experimentID 0 covers every experiment,
+	// and projectID 0 every project.
+	GetParameterKeys(ctx context.Context, experimentID, projectID int) ([]SchemaKeyRow, error)
+	GetMetricKeys(ctx context.Context, experimentID, projectID int) ([]SchemaKeyRow, error)
+	GetTagKeys(ctx context.Context, experimentID, projectID int) ([]SchemaKeyRow, error)
+
+	// Audit log operations
+	InsertAuditLogEntry(ctx context.Context, e AuditLogRow) error
+	GetAuditLog(ctx context.Context, limit int) ([]AuditLogRow, error)
+
+	// Replica coordination operations
+	AcquireJobLease(ctx context.Context, job, holder string, now time.Time, ttl time.Duration) (bool, error)
+	GetCacheGeneration(ctx context.Context, name string) (int64, error)
+	BumpCacheGeneration(ctx context.Context, name string) error
+	InsertArtifactCacheInvalidation(ctx context.Context, uri string, at time.Time) error
+	GetArtifactCacheInvalidations(ctx context.Context, since time.Time) ([]ArtifactCacheInvalidationRow, error)
+	DeleteArtifactCacheInvalidations(ctx context.Context, before time.Time) error
+	NotifyRunEvent(ctx context.Context, payload string) error
+
+	// Environment operations
+	ReplaceRunEnvironment(ctx context.Context, runID int, variables []EnvironmentVariableRow) error
+	GetRunEnvironment(ctx context.Context, runID int) ([]EnvironmentVariableRow, error)
+
+	// API token operations
+	InsertAPIToken(ctx context.Context, name, tokenHash string, projectID, userID sql.NullInt64) error
+	InsertKioskToken(ctx context.Context, name, tokenHash string, experimentID int) error
+	GetAPITokenByHash(ctx context.Context, tokenHash string) (*APITokenRow, error)
+	GetAllAPITokens(ctx context.Context) ([]APITokenRow, error)
+	RevokeAPIToken(ctx context.Context, name string) (bool, error)
+
+	// User operations
+	InsertUser(ctx context.Context, name, role string) error
+	GetUserByID(ctx context.Context, id int) (*UserRow, error)
+	GetUserByName(ctx context.Context, name string) (*UserRow, error)
+	GetAllUsers(ctx context.Context) ([]UserRow, error)
+	SetUserRole(ctx context.Context, id int, role string) error
+	DeleteUser(ctx context.Context, id int) error
+
+	// Artifact mirror operations
+	GetArtifactsToMirror(ctx context.Context, maxAttempts, limit int) ([]ArtifactMirrorRow, error)
+	SetArtifactMirrorStatus(ctx context.Context, m ArtifactMirrorRow, status, mirrorError string) error
+	GetArtifactMirrorStatusByURI(ctx context.Context, uri string) (string, error)
+
+	// Artifact scanning operations
+	GetArtifactsToScan(ctx context.Context, limit int) ([]ArtifactScanRow, error)
+	RecordArtifactScan(ctx context.Context, uri, status, result string) error
+	SetArtifactScanStatus(ctx context.Context, uri, status, result string) error
+	GetArtifactScanStatusByURI(ctx context.Context, uri string) (string, string, error)
 
 	// Artifact operations
-	UpsertArtifact(runID int, path, uri, artifactType string) error
-	GetArtifactsByRunID(runID int) ([]ArtifactRow, error)
-	GetArtifactByRunIDAndPath(runID int, path string) (*ArtifactRow, error)
+	UpsertArtifact(ctx context.Context, runID int, path, uri, artifactType, contentType string, sizeBytes int64, sha256 string) error
+	GetArtifactsByRunID(ctx context.Context, runID int) ([]ArtifactRow, error)
+	GetArtifactByRunIDAndPath(ctx context.Context, runID int, path string) (*ArtifactRow, error)
+	GetArtifactVersions(ctx context.Context, runID int, path string) ([]ArtifactRow, error)
+	SetArtifactContentType(ctx context.Context, runID int, path, artifactType, contentType string) error
+	GetAllArtifactURIs(ctx context.Context) ([]string, error)
+	RelocateArtifactURI(ctx context.Context, from, to string) (int, error)
+
+	// Chunked artifact upload operations
+	InsertArtifactUpload(ctx context.Context, upload ArtifactUploadRow) error
+	GetArtifactUpload(ctx context.Context, uuid string) (*ArtifactUploadRow, error)
+	FindArtifactUpload(ctx context.Context, runID int, path, sha256 string, sizeBytes int64) (*ArtifactUploadRow, error)
+	TouchArtifactUpload(ctx context.Context, uuid string, at time.Time) error
+	DeleteArtifactUpload(ctx context.Context, uuid string) error
+	GetArtifactUploads(ctx context.Context) ([]ArtifactUploadRow, error)
+
+	// Annotation operations
+	InsertRunAnnotation(ctx context.Context, runID int, step float64, text string) error
+	GetRunAnnotationsByRunID(ctx context.Context, runID int) ([]RunAnnotationRow, error)
+
+	// Run template operations
+	InsertRunTemplate(ctx context.Context, name string, experimentID int, params []RunTemplateParameterRow) error
+	GetRunTemplateByName(ctx context.Context, name string) (*RunTemplateRow, error)
+	GetAllRunTemplates(ctx context.Context) ([]RunTemplateRow, error)
+	GetRunTemplateParameters(ctx context.Context, templateID int) ([]RunTemplateParameterRow, error)
+
+	// Notification subscription operations
+	InsertNotificationSubscription(ctx context.Context, sub NotificationSubscriptionRow) error
+	GetNotificationSubscriptions(ctx context.Context) ([]NotificationSubscriptionRow, error)
+	DeleteNotificationSubscription(ctx context.Context, id int) error
+
+	// Notification preference operations
+	InsertNotificationPreference(ctx context.Context, pref NotificationPreferenceRow) error
+	GetNotificationPreferences(ctx context.Context) ([]NotificationPreferenceRow, error)
+	DeleteNotificationPreference(ctx context.Context, id int, user string) error
+	InsertWebNotification(ctx context.Context, notification WebNotificationRow) error
+	GetWebNotifications(ctx context.Context, user string, limit int) ([]WebNotificationRow, error)
 }
 
 // RunRow represents a row in the runs table
@@ -62,6 +274,13 @@ type ParameterRow struct {
 	ValueInt    sql.NullInt64
 }
 
+// ExperimentParameterRow is a parameter row together with the run it belongs to
+type ExperimentParameterRow struct {
+	RunID   int
+	RunUUID string
+	ParameterRow
+}
+
 // MetricRow represents a row in the metrics table
 type MetricRow struct {
 	Key      string
@@ -70,11 +289,48 @@ type MetricRow struct {
 	LoggedAt time.Time
 }
 
+// ArtifactUploadRow represents a row in the artifact_uploads table: a
+// chunked upload of an artifact in progress. UpdatedAt is when a chunk was
+// last received.
+type ArtifactUploadRow struct {
+	UUID      string
+	RunID     int
+	Path      string
+	SizeBytes int64
+	SHA256    string
+	ChunkSize int64
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// ArtifactCacheInvalidationRow is an artifact written or deleted by a
+// replica, which the other replicas drop from their artifact caches
+type ArtifactCacheInvalidationRow struct {
+	ID            int64
+	URI           string
+	InvalidatedAt time.Time
+}
+
 // ArtifactRow represents a row in the artifacts table
 type ArtifactRow struct {
 	Path string
 	URI  string
 	Type string
+	// ContentType is the MIME type sniffed from the contents on upload, or
+	// empty for artifacts uploaded before content types were recorded
+	ContentType  string
+	SizeBytes    int64
+	UpdatedAt    sql.NullTime
+	MirrorStatus string
+	// SHA256 is the hex digest of the contents, or empty for artifacts
+	// uploaded before digests were recorded
+	SHA256 string
+	// Version counts the uploads to the artifact's path, starting from 1
+	Version int
+	// ScanStatus is the outcome of scanning the contents for malware, and
+	// ScanResult what the scanner found, if anything
+	ScanStatus string
+	ScanResult string
 }
 
 // ExperimentRow represents a row in the experiments table
@@ -84,3 +340,528 @@ type ExperimentRow struct {
 	Name      string
 	CreatedAt time.Time
 }
+
+// RunAnnotationRow represents a row in the run_annotations table
+type RunAnnotationRow struct {
+	Step      float64
+	Text      string
+	CreatedAt time.Time
+}
+
+// ExperimentReadmeRevisionRow represents a row in the experiment_readme_revisions table
+type ExperimentReadmeRevisionRow struct {
+	Readme    string
+	CreatedAt time.Time
+}
+
+// RunTemplateRow represents a row in the run_templates table
+type RunTemplateRow struct {
+	ID           int
+	Name         string
+	ExperimentID int
+	CreatedAt    time.Time
+}
+
+// RunTemplateParameterRow represents a row in the run_template_parameters table
+type RunTemplateParameterRow struct {
+	ParameterRow
+	Prompt bool
+}
+
+// RunHoldRow is the hold recorded on a run
+type RunHoldRow struct {
+	Reason string
+	HeldAt time.Time
+}
+
+// RunSourceRow is where a run came from, as reported by the client that
+// created it. Fields the client did not report are empty.
+type RunSourceRow struct {
+	User       string
+	GitCommit  string
+	GitBranch  string
+	GitDirty   sql.NullBool
+	Entrypoint string
+}
+
+// RunSeedsRow is the random seeds a run was started with. Seed is its global
+// seed, or empty if it reported none, and Libraries the seeds of libraries
+// seeded separately, ordered by library.
+type RunSeedsRow struct {
+	Seed      string
+	Libraries []LibrarySeedRow
+}
+
+// LibrarySeedRow represents a row in the run_seeds table
+type LibrarySeedRow struct {
+	Library string
+	Seed    string
+}
+
+// NotificationSubscriptionRow represents a row in the notification_subscriptions table
+type NotificationSubscriptionRow struct {
+	ID           int
+	ExperimentID sql.NullInt64
+	Channel      string
+	Target       string
+	Events       string
+	Tag          string
+	CreatedAt    time.Time
+}
+
+// NotificationPreferenceRow represents a row in the notification_preferences
+// table: an event a user wants delivered to them on a channel
+type NotificationPreferenceRow struct {
+	ID   int
+	User string
+	// Event is one of notificationPreferenceEvents, and ExperimentID the
+	// experiment it is limited to, if any
+	Event        string
+	ExperimentID sql.NullInt64
+	Channel      string
+	// Target is the email address or Slack webhook URL delivered to, and
+	// empty for the web channel
+	Target    string
+	CreatedAt time.Time
+}
+
+// WebNotificationRow represents a row in the web_notifications table
+type WebNotificationRow struct {
+	ID        int
+	User      string
+	RunUUID   string
+	Message   string
+	CreatedAt time.Time
+}
+
+// RunSearchRow is a run matching a full-text search. Snippet is an excerpt of
+// the matched text with matches delimited by searchMatchStart and searchMatchEnd.
+type RunSearchRow struct {
+	RunSummary
+	Snippet string
+}
+
+// MetricSummaryRow represents a row in the metric_summaries table: the last
+// point of a run's metric series, its lowest and highest values with the
+// steps they were first reached at, and how many points it has
+type MetricSummaryRow struct {
+	RunID int
+	Key   string
+	// Namespace and Name are Key split at its first slash
+	Namespace  string
+	Name       string
+	LastStep   float64
+	LastValue  float64
+	MinStep    float64
+	MinValue   float64
+	MaxStep    float64
+	MaxValue   float64
+	PointCount int
+}
+
+// metricSummariesInsert recomputes the metric summaries of the metrics
+// matching the WHERE clause it is formatted with, for when metrics change
+// other than by insert and the trigger that maintains metric_summaries does
+// not fire
+const metricSummariesInsert = `
+	INSERT INTO metric_summaries (run_id, key, last_step, last_value, min_step, min_value, max_step, max_value, point_count)
+	SELECT DISTINCT run_id, key,
+		FIRST_VALUE(x_value) OVER (PARTITION BY run_id, key ORDER BY x_value DESC NULLS LAST, id DESC),
+		FIRST_VALUE(y_value) OVER (PARTITION BY run_id, key ORDER BY x_value DESC NULLS LAST, id DESC),
+		FIRST_VALUE(x_value) OVER (PARTITION BY run_id, key ORDER BY y_value, x_value),
+		FIRST_VALUE(y_value) OVER (PARTITION BY run_id, key ORDER BY y_value, x_value),
+		FIRST_VALUE(x_value) OVER (PARTITION BY run_id, key ORDER BY y_value DESC, x_value),
+		FIRST_VALUE(y_value) OVER (PARTITION BY run_id, key ORDER BY y_value DESC, x_value),
+		COUNT(*) OVER (PARTITION BY run_id, key)
+	FROM metrics
+	WHERE %s
+`
+
+// MetricNamespaceRow is a namespace of a run's metrics, with how many metrics
+// it has and how many points they were logged with
+type MetricNamespaceRow struct {
+	Namespace string
+	Metrics   int
+	Points    int64
+}
+
+// MetricNamespaceSettingRow represents a row in the metric_namespace_settings
+// table: how many days after a run of the experiment ends the points of its
+// metrics in the namespace are kept, and the values they alert above or below
+type MetricNamespaceSettingRow struct {
+	ExperimentID     int
+	Namespace        string
+	RetainPointsDays sql.NullInt64
+	AlertAbove       sql.NullFloat64
+	AlertBelow       sql.NullFloat64
+}
+
+// RunMetricPointsRow is how many metric points a run has
+type RunMetricPointsRow struct {
+	RunID   int
+	RunUUID string
+	Points  int
+}
+
+// RunGPUSummaryRow represents a row in the run_gpu_summaries table
+type RunGPUSummaryRow struct {
+	RunID           int
+	UtilizationMean sql.NullFloat64
+	MemoryPeakBytes sql.NullFloat64
+	GPUHours        float64
+}
+
+// CostRateRow represents a row in the experiment_cost_rates table
+type CostRateRow struct {
+	MachineType   string
+	USDPerGPUHour float64
+}
+
+// MetricSchemaEntryRow represents a row in the experiment_metric_schema table.
+// Key is a metric key or a path.Match pattern of keys, and the steps bound
+// the range it may be logged at, where set.
+type MetricSchemaEntryRow struct {
+	Key     string
+	MinStep sql.NullFloat64
+	MaxStep sql.NullFloat64
+}
+
+// MetricSchemaWarningRow represents a row in the metric_schema_warnings table,
+// with the UUID and name of the run that logged the metric
+type MetricSchemaWarningRow struct {
+	RunUUID    string
+	RunName    string
+	Key        string
+	Kind       string
+	Detail     string
+	Count      int
+	LastSeenAt time.Time
+}
+
+// ExperimentQuotaRow represents a row in the experiment_quotas table. A NULL
+// limit keeps the server's default, and 0 is unlimited.
+type ExperimentQuotaRow struct {
+	MaxRuns               sql.NullInt64
+	MaxMetricPointsPerDay sql.NullInt64
+	MaxArtifactBytes      sql.NullInt64
+}
+
+// ExperimentUsageRow is how much of its quota an experiment has used: its
+// runs that are not deleted, the metric points logged to it on a day, and
+// the size of its runs' artifacts
+type ExperimentUsageRow struct {
+	Runs          int64
+	MetricPoints  int64
+	ArtifactBytes int64
+}
+
+// RunDependencyRow represents a row in the run_dependencies table, with the
+// UUIDs and names of the runs on both ends
+type RunDependencyRow struct {
+	ID            int
+	RunID         int
+	RunUUID       string
+	RunName       string
+	UpstreamRunID int
+	UpstreamUUID  string
+	UpstreamName  string
+	Kind          string
+	ArtifactPath  string
+	CreatedAt     time.Time
+}
+
+// ConfusionMatrixRow represents a row in the confusion_matrices table. Labels
+// and Matrix are the JSON encodings of the class names and the counts.
+type ConfusionMatrixRow struct {
+	RunID    int
+	Key      string
+	Step     float64
+	Labels   string
+	Matrix   string
+	LoggedAt time.Time
+}
+
+// RunCurveRow represents a row in the run_curves table. Points is the JSON
+// encoding of the curve's points.
+type RunCurveRow struct {
+	RunID    int
+	Key      string
+	Kind     string
+	Step     float64
+	Points   string
+	AUC      float64
+	LoggedAt time.Time
+}
+
+// TextSamplesRow represents a row in the text_samples table. Samples is the
+// JSON encoding of the sample strings.
+type TextSamplesRow struct {
+	RunID    int
+	Key      string
+	Step     float64
+	Samples  string
+	LoggedAt time.Time
+}
+
+// ArtifactMirrorRow is an artifact awaiting replication to the mirror artifact
+// store. UpdatedAt identifies the upload being mirrored, so that a status is
+// not recorded against an artifact overwritten in the meantime.
+type ArtifactMirrorRow struct {
+	RunID     int
+	Path      string
+	URI       string
+	UpdatedAt sql.NullTime
+	Attempts  int
+}
+
+// ArtifactScanRow is the contents of an artifact or prior version awaiting a
+// malware scan
+type ArtifactScanRow struct {
+	URI      string
+	Attempts int
+}
+
+// RunTagRow represents a row in the tags table
+type RunTagRow struct {
+	Key   string
+	Value string
+}
+
+// RunFilter narrows a run list. The zero value matches every run.
+type RunFilter struct {
+	// Tags are tags a run must all have
+	Tags []TagFilter
+	// Query is a run query runs must match, if not nil
+	Query *RunQuery
+	// CreatedAfter and CreatedBefore bound when runs were created, unless
+	// zero. CreatedBefore is exclusive.
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	// HideArchived leaves out archived runs
+	HideArchived bool
+	// ProjectID limits runs to a project's experiments, unless zero
+	ProjectID int
+	// User limits runs to those created by a user, unless empty
+	User string
+	// ExperimentID limits runs to an experiment's, unless zero
+	ExperimentID int
+	// GitCommit limits runs to those created from commits starting with a
+	// hex prefix, unless empty
+	GitCommit string
+	// Reproducible limits runs to reproducible ones; see
+	// runReproducibleCondition
+	Reproducible bool
+}
+
+// TagFilter matches runs with a tag, with a particular value if HasValue
+type TagFilter struct {
+	Key      string
+	Value    string
+	HasValue bool
+}
+
+// APITokenRow represents a row in the api_tokens table
+type APITokenRow struct {
+	ID        int
+	Name      string
+	TokenHash string
+	CreatedAt time.Time
+	RevokedAt sql.NullTime
+	// ProjectID is the project the token is scoped to, if any
+	ProjectID sql.NullInt64
+	// UserID is the user the token was issued to, if any
+	UserID sql.NullInt64
+	// KioskExperimentID is the experiment a kiosk token shows, if it is one
+	KioskExperimentID sql.NullInt64
+}
+
+// UserRow represents a row in the users table
+type UserRow struct {
+	ID   int
+	Name string
+	// Role is one of viewer, editor, or admin
+	Role      string
+	CreatedAt time.Time
+}
+
+// ProjectRow represents a row in the projects table
+type ProjectRow struct {
+	ID   int
+	UUID string
+	Name string
+	// ArtifactPrefix is prepended to the keys of the project's artifacts
+	ArtifactPrefix string
+	CreatedAt      time.Time
+}
+
+// EnvironmentVariableRow represents a row in the run_environment table
+type EnvironmentVariableRow struct {
+	Key      string
+	Value    string
+	Redacted bool
+}
+
+// BestCheckpointRuleRow represents a row in the best_checkpoint_rules table
+type BestCheckpointRuleRow struct {
+	MetricKey       string
+	Mode            string
+	ArtifactPattern string
+}
+
+// RunBestCheckpointRow represents a row in the run_best_checkpoints table.
+// MetricKey and MetricValue are the rule's metric when the checkpoint was
+// designated, and are unset for checkpoints designated by hand.
+type RunBestCheckpointRow struct {
+	RunID        int
+	Path         string
+	Source       string
+	MetricKey    sql.NullString
+	MetricValue  sql.NullFloat64
+	DesignatedAt time.Time
+}
+
+// HousekeepingReportRow represents a row in the housekeeping_reports table.
+// PlanID is the dry run an execution carried out, and ExecutedAt is when a
+// dry run was carried out.
+type HousekeepingReportRow struct {
+	ID         int
+	Job        string
+	DryRun     bool
+	URI        string
+	ItemCount  int
+	RunCount   int
+	SizeBytes  int64
+	PlanID     sql.NullInt64
+	CreatedAt  time.Time
+	ExecutedAt sql.NullTime
+}
+
+// MetricGapRow is a pair of consecutive points of a metric series logged
+// further apart than a threshold
+type MetricGapRow struct {
+	Start MetricRow
+	End   MetricRow
+}
+
+// metricDownsampleBuckets is the number of buckets a metric series is split
+// into to downsample it to at most maxPoints points: the lowest and highest
+// point of each bucket are kept, as well as the first and last points
+func metricDownsampleBuckets(maxPoints int) int {
+	return max((maxPoints-2)/2, 1)
+}
+
+// scanMetricSchemaEntries reads key, min_step, max_step rows into metric schema entries
+func scanMetricSchemaEntries(rows *sql.Rows) ([]MetricSchemaEntryRow, error) {
+	var entries []MetricSchemaEntryRow
+	for rows.Next() {
+		var e MetricSchemaEntryRow
+		if err := rows.Scan(&e.Key, &e.MinStep, &e.MaxStep); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// scanMetricNamespaceSettings reads experiment_id, namespace,
+// retain_points_days, alert_above, alert_below rows into settings
+func scanMetricNamespaceSettings(rows *sql.Rows) ([]MetricNamespaceSettingRow, error) {
+	var settings []MetricNamespaceSettingRow
+	for rows.Next() {
+		var s MetricNamespaceSettingRow
+		if err := rows.Scan(&s.ExperimentID, &s.Namespace, &s.RetainPointsDays, &s.AlertAbove, &s.AlertBelow); err != nil {
+			return nil, err
+		}
+		settings = append(settings, s)
+	}
+	return settings, rows.Err()
+}
+
+// RetentionRunRow is a run that retention archives or deletes
+type RetentionRunRow struct {
+	ID   int
+	UUID string
+	Name string
+}
+
+// DeletedRunRow is a run that was deleted but whose data has not been purged
+type DeletedRunRow struct {
+	ID          int
+	UUID        string
+	Name        string
+	ParentRunID sql.NullInt64
+	// The experiment and project the run belongs to
+	ExperimentUUID string
+	ExperimentName string
+	ProjectID      int
+	DeletedAt      time.Time
+	// DeletedBy is the email address of whoever deleted the run, to tell
+	// before it is purged, or empty
+	DeletedBy         string
+	PurgeNoticeSentAt sql.NullTime
+}
+
+// runDataTables are the tables whose rows belong to a run by run_id, and are
+// purged with it. Run dependencies are purged from either end.
+var runDataTables = []string{
+	"parameters",
+	"metrics",
+	"artifacts",
+	"artifact_versions",
+	"run_annotations",
+	"run_gpu_summaries",
+	"confusion_matrices",
+	"run_curves",
+	"text_samples",
+	"tags",
+	"run_environment",
+	"run_seeds",
+	"run_best_checkpoints",
+	"metric_schema_warnings",
+	"metric_summaries",
+	"metric_alerts",
+	"artifact_uploads",
+}
+
+// AuditLogRow represents a row in the audit_log table. Details is the JSON
+// encoding of what the operation did.
+type AuditLogRow struct {
+	ID        int
+	Action    string
+	Subject   string
+	Details   string
+	CreatedAt time.Time
+}
+
+// SchemaKeyRow is a parameter, metric or tag key and how many runs logged it.
+// ValueType is the type parameter values were logged as, and is empty for
+// metrics and tags.
+type SchemaKeyRow struct {
+	Key       string
+	ValueType string
+	RunCount  int
+}
+
+// MetricSeriesRow is a metric series of a run
+type MetricSeriesRow struct {
+	RunUUID string
+	RunName string
+	Key     string
+}
+
+// RunHealthRow counts the runs that are running, that failed since a time,
+// and that are running but have logged nothing since a time
+type RunHealthRow struct {
+	Running int
+	Failed  int
+	Quiet   int
+}
+
+// FailedRunRow is a failed run with the message it ended with, empty if it
+// reported none
+type FailedRunRow struct {
+	UUID       string
+	Name       string
+	Message    string
+	FinishedAt sql.NullTime
+}
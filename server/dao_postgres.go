@@ -1,11 +1,13 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
 	"github.com/lib/pq"
 	"log"
+	"strings"
 	"time"
 )
 
@@ -20,8 +22,8 @@ func NewPostgresDAO(db *sql.DB) *PostgresDAO {
 }
 
 // InsertExperiment inserts a new experiment
-func (d *PostgresDAO) InsertExperiment(uuid, name string) error {
-	_, err := d.db.Exec(
+func (d *PostgresDAO) InsertExperiment(ctx context.Context, uuid, name string) error {
+	_, err := d.db.ExecContext(ctx,
 		"INSERT INTO experiments (uuid, name) VALUES ($1, $2)",
 		uuid, name,
 	)
@@ -29,29 +31,43 @@ func (d *PostgresDAO) InsertExperiment(uuid, name string) error {
 }
 
 // GetExperimentByUUID retrieves an experiment by its UUID
-func (d *PostgresDAO) GetExperimentByUUID(uuid string) (*Experiment, error) {
-	var name, createdAt string
+func (d *PostgresDAO) GetExperimentByUUID(ctx context.Context, uuid string) (*Experiment, error) {
+	var name, createdAt, readme string
+	var projectID int
 	var mostRecentRunAt sql.NullString
-	err := d.db.QueryRow(`
-		SELECT e.name, e.created_at,
-			(SELECT MAX(created_at) FROM runs WHERE experiment_id = e.id) as most_recent_run_at
+	err := d.db.QueryRowContext(ctx, `
+		SELECT e.name, e.created_at, e.readme, e.project_id,
+			(SELECT MAX(created_at) FROM runs WHERE experiment_id = e.id AND deleted_at IS NULL) as most_recent_run_at
 		FROM experiments e WHERE e.uuid = $1`,
 		uuid,
-	).Scan(&name, &createdAt, &mostRecentRunAt)
+	).Scan(&name, &createdAt, &readme, &projectID, &mostRecentRunAt)
 	if err != nil {
 		return nil, err
 	}
-	exp := &Experiment{UUID: uuid, Name: name, CreatedAt: createdAt}
+	exp := &Experiment{UUID: uuid, Name: name, CreatedAt: createdAt, Readme: readme, ProjectID: projectID}
 	if mostRecentRunAt.Valid {
 		exp.MostRecentRunAt = mostRecentRunAt.String
 	}
 	return exp, nil
 }
 
+// GetExperimentByID retrieves an experiment by its database ID
+func (d *PostgresDAO) GetExperimentByID(ctx context.Context, id int) (*Experiment, error) {
+	var exp Experiment
+	err := d.db.QueryRowContext(ctx,
+		"SELECT uuid, name, created_at, readme, project_id FROM experiments WHERE id = $1",
+		id,
+	).Scan(&exp.UUID, &exp.Name, &exp.CreatedAt, &exp.Readme, &exp.ProjectID)
+	if err != nil {
+		return nil, err
+	}
+	return &exp, nil
+}
+
 // GetExperimentIDByUUID retrieves the database ID of an experiment by its UUID
-func (d *PostgresDAO) GetExperimentIDByUUID(uuid string) (int, error) {
+func (d *PostgresDAO) GetExperimentIDByUUID(ctx context.Context, uuid string) (int, error) {
 	var id int
-	err := d.db.QueryRow(
+	err := d.db.QueryRowContext(ctx,
 		"SELECT id FROM experiments WHERE uuid = $1",
 		uuid,
 	).Scan(&id)
@@ -59,13 +75,13 @@ func (d *PostgresDAO) GetExperimentIDByUUID(uuid string) (int, error) {
 }
 
 // GetAllExperiments retrieves all experiments ordered by most_recent_run_at descending
-func (d *PostgresDAO) GetAllExperiments() ([]Experiment, error) {
-	rows, err := d.db.Query(`
-		SELECT e.uuid, e.name, e.created_at,
-			(SELECT MAX(created_at) FROM runs WHERE experiment_id = e.id) as most_recent_run_at,
-			(SELECT COUNT(*) FROM runs WHERE experiment_id = e.id) as run_count
+func (d *PostgresDAO) GetAllExperiments(ctx context.Context) ([]Experiment, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT e.uuid, e.name, e.created_at, e.project_id,
+			(SELECT MAX(created_at) FROM runs WHERE experiment_id = e.id AND deleted_at IS NULL) as most_recent_run_at,
+			(SELECT COUNT(*) FROM runs WHERE experiment_id = e.id AND deleted_at IS NULL) as run_count
 		FROM experiments e
-		ORDER BY COALESCE((SELECT MAX(created_at) FROM runs WHERE experiment_id = e.id), e.created_at) DESC
+		ORDER BY COALESCE((SELECT MAX(created_at) FROM runs WHERE experiment_id = e.id AND deleted_at IS NULL), e.created_at) DESC
 	`)
 	if err != nil {
 		return nil, err
@@ -76,11 +92,11 @@ func (d *PostgresDAO) GetAllExperiments() ([]Experiment, error) {
 	for rows.Next() {
 		var uuid, name, createdAt string
 		var mostRecentRunAt sql.NullString
-		var runCount int
-		if err := rows.Scan(&uuid, &name, &createdAt, &mostRecentRunAt, &runCount); err != nil {
+		var projectID, runCount int
+		if err := rows.Scan(&uuid, &name, &createdAt, &projectID, &mostRecentRunAt, &runCount); err != nil {
 			return nil, err
 		}
-		exp := Experiment{UUID: uuid, Name: name, CreatedAt: createdAt, RunCount: runCount}
+		exp := Experiment{UUID: uuid, Name: name, CreatedAt: createdAt, RunCount: runCount, ProjectID: projectID}
 		if mostRecentRunAt.Valid {
 			exp.MostRecentRunAt = mostRecentRunAt.String
 		}
@@ -90,20 +106,106 @@ func (d *PostgresDAO) GetAllExperiments() ([]Experiment, error) {
 	return experiments, rows.Err()
 }
 
+// InsertProject inserts a new project
+func (d *PostgresDAO) InsertProject(ctx context.Context, uuid, name, artifactPrefix string) error {
+	_, err := d.db.ExecContext(ctx,
+		"INSERT INTO projects (uuid, name, artifact_prefix) VALUES ($1, $2, $3)",
+		uuid, name, artifactPrefix,
+	)
+	return err
+}
+
+// GetProjectByID retrieves a project by its database ID
+func (d *PostgresDAO) GetProjectByID(ctx context.Context, id int) (*ProjectRow, error) {
+	return d.getProject(ctx, "id = $1", id)
+}
+
+// GetProjectByUUID retrieves a project by its UUID
+func (d *PostgresDAO) GetProjectByUUID(ctx context.Context, uuid string) (*ProjectRow, error) {
+	return d.getProject(ctx, "uuid = $1", uuid)
+}
+
+// GetProjectByName retrieves a project by its name
+func (d *PostgresDAO) GetProjectByName(ctx context.Context, name string) (*ProjectRow, error) {
+	return d.getProject(ctx, "name = $1", name)
+}
+
+func (d *PostgresDAO) getProject(ctx context.Context, where string, arg interface{}) (*ProjectRow, error) {
+	var p ProjectRow
+	err := d.db.QueryRowContext(ctx,
+		"SELECT id, uuid, name, artifact_prefix, created_at FROM projects WHERE "+where,
+		arg,
+	).Scan(&p.ID, &p.UUID, &p.Name, &p.ArtifactPrefix, &p.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// GetAllProjects retrieves all projects ordered by name
+func (d *PostgresDAO) GetAllProjects(ctx context.Context) ([]ProjectRow, error) {
+	rows, err := d.db.QueryContext(ctx, "SELECT id, uuid, name, artifact_prefix, created_at FROM projects ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var projects []ProjectRow
+	for rows.Next() {
+		var p ProjectRow
+		if err := rows.Scan(&p.ID, &p.UUID, &p.Name, &p.ArtifactPrefix, &p.CreatedAt); err != nil {
+			return nil, err
+		}
+		projects = append(projects, p)
+	}
+	return projects, rows.Err()
+}
+
+// SetExperimentProject moves an experiment, and so its runs, to a project
+func (d *PostgresDAO) SetExperimentProject(ctx context.Context, experimentID, projectID int) error {
+	_, err := d.db.ExecContext(ctx, "UPDATE experiments SET project_id = $1 WHERE id = $2", projectID, experimentID)
+	return err
+}
+
+// GetRunProjectID retrieves the ID of the project of a run's experiment
+func (d *PostgresDAO) GetRunProjectID(ctx context.Context, runID int) (int, error) {
+	var projectID int
+	err := d.db.QueryRowContext(ctx, `
+		SELECT e.project_id
+		FROM runs r
+		JOIN experiments e ON e.id = r.experiment_id
+		WHERE r.id = $1
+	`, runID).Scan(&projectID)
+	return projectID, err
+}
+
 // GetDefaultExperimentID returns the ID of the default experiment
-func (d *PostgresDAO) GetDefaultExperimentID() (int, error) {
+func (d *PostgresDAO) GetDefaultExperimentID(ctx context.Context) (int, error) {
 	var id int
-	err := d.db.QueryRow("SELECT id FROM experiments WHERE uuid = '00000000-0000-0000-0000-000000000000'").Scan(&id)
+	err := d.db.QueryRowContext(ctx, "SELECT id FROM experiments WHERE uuid = '00000000-0000-0000-0000-000000000000'").Scan(&id)
 	return id, err
 }
 
 // InsertRun inserts a new run
-func (d *PostgresDAO) InsertRun(uuid, name string, experimentID int, parentRunID *int) error {
+func (d *PostgresDAO) InsertRun(ctx context.Context, uuid, name string, experimentID int, parentRunID *int) error {
+	return d.InsertRunWithSourceAndSeeds(ctx, uuid, name, experimentID, parentRunID, RunSourceRow{}, RunSeedsRow{})
+}
+
+// InsertRunWithSourceAndSeeds inserts a new run along with where it came from
+// and the random seeds it was started with, in one transaction, so that a run
+// is never left behind without them
+func (d *PostgresDAO) InsertRunWithSourceAndSeeds(ctx context.Context, uuid, name string, experimentID int, parentRunID *int, source RunSourceRow, seeds RunSeedsRow) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
 	var nestingLevel int
 	if parentRunID != nil {
 		// Get parent's nesting level and add 1
 		var parentLevel int
-		err := d.db.QueryRow("SELECT nesting_level FROM runs WHERE id = $1", *parentRunID).Scan(&parentLevel)
+		err := tx.QueryRowContext(ctx, "SELECT nesting_level FROM runs WHERE id = $1", *parentRunID).Scan(&parentLevel)
 		if err != nil {
 			return fmt.Errorf("failed to get parent run nesting level: %w", err)
 		}
@@ -113,26 +215,41 @@ func (d *PostgresDAO) InsertRun(uuid, name string, experimentID int, parentRunID
 		}
 	}
 
-	_, err := d.db.Exec(
-		"INSERT INTO runs (uuid, name, experiment_id, parent_run_id, nesting_level) VALUES ($1, $2, $3, $4, $5)",
+	var runID int
+	err = tx.QueryRowContext(ctx,
+		"INSERT INTO runs (uuid, name, experiment_id, parent_run_id, nesting_level) VALUES ($1, $2, $3, $4, $5) RETURNING id",
 		uuid, name, experimentID, parentRunID, nestingLevel,
-	)
-	return err
+	).Scan(&runID)
+	if err != nil {
+		return err
+	}
+
+	if source != (RunSourceRow{}) {
+		if err := setPostgresRunSource(ctx, tx.ExecContext, runID, source); err != nil {
+			return fmt.Errorf("failed to record the run's source: %w", err)
+		}
+	}
+	if !seeds.isEmpty() {
+		if err := setPostgresRunSeeds(ctx, tx.ExecContext, runID, seeds); err != nil {
+			return fmt.Errorf("failed to record the run's seeds: %w", err)
+		}
+	}
+	return tx.Commit()
 }
 
 // GetRunByUUID retrieves a run by its UUID
-func (d *PostgresDAO) GetRunByUUID(uuid string) (*Run, error) {
-	var name, notes string
+func (d *PostgresDAO) GetRunByUUID(ctx context.Context, uuid string) (*Run, error) {
+	var name, notes, status, statusMessage, forkedFrom string
 	var parentRunID sql.NullInt64
 	var nestingLevel int
-	err := d.db.QueryRow(
-		"SELECT name, notes, parent_run_id, nesting_level FROM runs WHERE uuid = $1",
+	err := d.db.QueryRowContext(ctx,
+		"SELECT name, notes, parent_run_id, nesting_level, status, COALESCE(status_message, ''), COALESCE(forked_from, '') FROM runs WHERE uuid = $1 AND deleted_at IS NULL",
 		uuid,
-	).Scan(&name, &notes, &parentRunID, &nestingLevel)
+	).Scan(&name, &notes, &parentRunID, &nestingLevel, &status, &statusMessage, &forkedFrom)
 	if err != nil {
 		return nil, err
 	}
-	run := &Run{UUID: uuid, Name: name, Notes: notes, NestingLevel: nestingLevel}
+	run := &Run{UUID: uuid, Name: name, Notes: notes, NestingLevel: nestingLevel, Status: status, StatusMessage: statusMessage, ForkedFrom: forkedFrom}
 	if parentRunID.Valid {
 		id := int(parentRunID.Int64)
 		run.ParentRunID = &id
@@ -141,18 +258,18 @@ func (d *PostgresDAO) GetRunByUUID(uuid string) (*Run, error) {
 }
 
 // GetRunByID retrieves a run by its database ID
-func (d *PostgresDAO) GetRunByID(id int) (*Run, error) {
-	var uuid, name, notes string
+func (d *PostgresDAO) GetRunByID(ctx context.Context, id int) (*Run, error) {
+	var uuid, name, notes, status, statusMessage, forkedFrom string
 	var parentRunID sql.NullInt64
 	var nestingLevel int
-	err := d.db.QueryRow(
-		"SELECT uuid, name, notes, parent_run_id, nesting_level FROM runs WHERE id = $1",
+	err := d.db.QueryRowContext(ctx,
+		"SELECT uuid, name, notes, parent_run_id, nesting_level, status, COALESCE(status_message, ''), COALESCE(forked_from, '') FROM runs WHERE id = $1",
 		id,
-	).Scan(&uuid, &name, &notes, &parentRunID, &nestingLevel)
+	).Scan(&uuid, &name, &notes, &parentRunID, &nestingLevel, &status, &statusMessage, &forkedFrom)
 	if err != nil {
 		return nil, err
 	}
-	run := &Run{UUID: uuid, Name: name, Notes: notes, NestingLevel: nestingLevel}
+	run := &Run{UUID: uuid, Name: name, Notes: notes, NestingLevel: nestingLevel, Status: status, StatusMessage: statusMessage, ForkedFrom: forkedFrom}
 	if parentRunID.Valid {
 		pID := int(parentRunID.Int64)
 		run.ParentRunID = &pID
@@ -161,20 +278,21 @@ func (d *PostgresDAO) GetRunByID(id int) (*Run, error) {
 }
 
 // GetRunIDByUUID retrieves the database ID of a run by its UUID
-func (d *PostgresDAO) GetRunIDByUUID(uuid string) (int, error) {
+func (d *PostgresDAO) GetRunIDByUUID(ctx context.Context, uuid string) (int, error) {
 	var id int
-	err := d.db.QueryRow(
-		"SELECT id FROM runs WHERE uuid = $1",
+	err := d.db.QueryRowContext(ctx,
+		"SELECT id FROM runs WHERE uuid = $1 AND deleted_at IS NULL",
 		uuid,
 	).Scan(&id)
 	return id, err
 }
 
 // GetAllRuns retrieves all runs ordered by created_at descending
-func (d *PostgresDAO) GetAllRuns() ([]Run, error) {
-	rows, err := d.db.Query(`
-		SELECT uuid, name, created_at
+func (d *PostgresDAO) GetAllRuns(ctx context.Context) ([]Run, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT uuid, name, created_at, status
 		FROM runs
+		WHERE deleted_at IS NULL
 		ORDER BY created_at DESC
 	`)
 	if err != nil {
@@ -184,22 +302,22 @@ func (d *PostgresDAO) GetAllRuns() ([]Run, error) {
 
 	var runs []Run
 	for rows.Next() {
-		var uuid, name, createdAt string
-		if err := rows.Scan(&uuid, &name, &createdAt); err != nil {
+		var uuid, name, createdAt, status string
+		if err := rows.Scan(&uuid, &name, &createdAt, &status); err != nil {
 			return nil, err
 		}
-		runs = append(runs, Run{UUID: uuid, Name: name, CreatedAt: createdAt})
+		runs = append(runs, Run{UUID: uuid, Name: name, CreatedAt: createdAt, Status: status})
 	}
 
 	return runs, rows.Err()
 }
 
 // GetRunsByExperimentID retrieves all runs for an experiment
-func (d *PostgresDAO) GetRunsByExperimentID(experimentID int) ([]Run, error) {
-	rows, err := d.db.Query(`
-		SELECT uuid, name, created_at, parent_run_id, nesting_level
+func (d *PostgresDAO) GetRunsByExperimentID(ctx context.Context, experimentID int) ([]Run, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT uuid, name, created_at, parent_run_id, nesting_level, status
 		FROM runs
-		WHERE experiment_id = $1
+		WHERE experiment_id = $1 AND deleted_at IS NULL
 		ORDER BY created_at DESC
 	`, experimentID)
 	if err != nil {
@@ -209,13 +327,13 @@ func (d *PostgresDAO) GetRunsByExperimentID(experimentID int) ([]Run, error) {
 
 	var runs []Run
 	for rows.Next() {
-		var uuid, name, createdAt string
+		var uuid, name, createdAt, status string
 		var parentRunID sql.NullInt64
 		var nestingLevel int
-		if err := rows.Scan(&uuid, &name, &createdAt, &parentRunID, &nestingLevel); err != nil {
+		if err := rows.Scan(&uuid, &name, &createdAt, &parentRunID, &nestingLevel, &status); err != nil {
 			return nil, err
 		}
-		run := Run{UUID: uuid, Name: name, CreatedAt: createdAt, NestingLevel: nestingLevel}
+		run := Run{UUID: uuid, Name: name, CreatedAt: createdAt, NestingLevel: nestingLevel, Status: status}
 		if parentRunID.Valid {
 			id := int(parentRunID.Int64)
 			run.ParentRunID = &id
@@ -227,11 +345,11 @@ func (d *PostgresDAO) GetRunsByExperimentID(experimentID int) ([]Run, error) {
 }
 
 // GetRunsByExperimentIDAndLevel retrieves runs for an experiment at a specific nesting level
-func (d *PostgresDAO) GetRunsByExperimentIDAndLevel(experimentID int, nestingLevel int) ([]Run, error) {
-	rows, err := d.db.Query(`
-		SELECT uuid, name, created_at, parent_run_id, nesting_level
+func (d *PostgresDAO) GetRunsByExperimentIDAndLevel(ctx context.Context, experimentID int, nestingLevel int) ([]Run, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT uuid, name, created_at, parent_run_id, nesting_level, status
 		FROM runs
-		WHERE experiment_id = $1 AND nesting_level = $2
+		WHERE experiment_id = $1 AND nesting_level = $2 AND deleted_at IS NULL
 		ORDER BY created_at DESC
 	`, experimentID, nestingLevel)
 	if err != nil {
@@ -241,13 +359,13 @@ func (d *PostgresDAO) GetRunsByExperimentIDAndLevel(experimentID int, nestingLev
 
 	var runs []Run
 	for rows.Next() {
-		var uuid, name, createdAt string
+		var uuid, name, createdAt, status string
 		var parentRunID sql.NullInt64
 		var level int
-		if err := rows.Scan(&uuid, &name, &createdAt, &parentRunID, &level); err != nil {
+		if err := rows.Scan(&uuid, &name, &createdAt, &parentRunID, &level, &status); err != nil {
 			return nil, err
 		}
-		run := Run{UUID: uuid, Name: name, CreatedAt: createdAt, NestingLevel: level}
+		run := Run{UUID: uuid, Name: name, CreatedAt: createdAt, NestingLevel: level, Status: status}
 		if parentRunID.Valid {
 			id := int(parentRunID.Int64)
 			run.ParentRunID = &id
@@ -259,11 +377,11 @@ func (d *PostgresDAO) GetRunsByExperimentIDAndLevel(experimentID int, nestingLev
 }
 
 // GetChildRuns retrieves all direct child runs of a parent run
-func (d *PostgresDAO) GetChildRuns(parentRunID int) ([]Run, error) {
-	rows, err := d.db.Query(`
-		SELECT uuid, name, created_at, parent_run_id, nesting_level
+func (d *PostgresDAO) GetChildRuns(ctx context.Context, parentRunID int) ([]Run, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT uuid, name, created_at, parent_run_id, nesting_level, status
 		FROM runs
-		WHERE parent_run_id = $1
+		WHERE parent_run_id = $1 AND deleted_at IS NULL
 		ORDER BY created_at DESC
 	`, parentRunID)
 	if err != nil {
@@ -273,13 +391,13 @@ func (d *PostgresDAO) GetChildRuns(parentRunID int) ([]Run, error) {
 
 	var runs []Run
 	for rows.Next() {
-		var uuid, name, createdAt string
+		var uuid, name, createdAt, status string
 		var pRunID sql.NullInt64
 		var nestingLevel int
-		if err := rows.Scan(&uuid, &name, &createdAt, &pRunID, &nestingLevel); err != nil {
+		if err := rows.Scan(&uuid, &name, &createdAt, &pRunID, &nestingLevel, &status); err != nil {
 			return nil, err
 		}
-		run := Run{UUID: uuid, Name: name, CreatedAt: createdAt, NestingLevel: nestingLevel}
+		run := Run{UUID: uuid, Name: name, CreatedAt: createdAt, NestingLevel: nestingLevel, Status: status}
 		if pRunID.Valid {
 			id := int(pRunID.Int64)
 			run.ParentRunID = &id
@@ -291,38 +409,80 @@ func (d *PostgresDAO) GetChildRuns(parentRunID int) ([]Run, error) {
 }
 
 // GetChildRunCount returns the count of direct child runs
-func (d *PostgresDAO) GetChildRunCount(parentRunID int) (int, error) {
+func (d *PostgresDAO) GetChildRunCount(ctx context.Context, parentRunID int) (int, error) {
 	var count int
-	err := d.db.QueryRow("SELECT COUNT(*) FROM runs WHERE parent_run_id = $1", parentRunID).Scan(&count)
+	err := d.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM runs WHERE parent_run_id = $1 AND deleted_at IS NULL", parentRunID).Scan(&count)
 	return count, err
 }
 
 // UpsertParameter inserts or updates a parameter
-func (d *PostgresDAO) UpsertParameter(runID int, key, valueType string, valueString *string, valueBool *bool, valueFloat *float64, valueInt *int64) error {
-	var sql string
+func (d *PostgresDAO) UpsertParameter(ctx context.Context, runID int, key, valueType string, valueString *string, valueBool *bool, valueFloat *float64, valueInt *int64) error {
+	return upsertPostgresParameter(ctx, d.db.ExecContext, runID, key, valueType, valueString, valueBool, valueFloat, valueInt)
+}
+
+// UpsertParameters inserts or replaces several parameters of a run in one
+// transaction, so that either all of them are logged or none are
+func (d *PostgresDAO) UpsertParameters(ctx context.Context, runID int, params []ParameterRow) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, p := range params {
+		valueString, valueBool, valueFloat, valueInt := parameterValuePointers(p)
+		if err := upsertPostgresParameter(ctx, tx.ExecContext, runID, p.Key, p.ValueType, valueString, valueBool, valueFloat, valueInt); err != nil {
+			return fmt.Errorf("parameter %s: %w", p.Key, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// UpsertExperimentParameters inserts or replaces parameters of several runs
+// in one transaction, so that either all of them are written or none are
+func (d *PostgresDAO) UpsertExperimentParameters(ctx context.Context, params []ExperimentParameterRow) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, p := range params {
+		valueString, valueBool, valueFloat, valueInt := parameterValuePointers(p.ParameterRow)
+		if err := upsertPostgresParameter(ctx, tx.ExecContext, p.RunID, p.Key, p.ValueType, valueString, valueBool, valueFloat, valueInt); err != nil {
+			return fmt.Errorf("run %d parameter %s: %w", p.RunID, p.Key, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// upsertPostgresParameter inserts or replaces a parameter with exec, which is the
+// database's or a transaction's Exec
+func upsertPostgresParameter(ctx context.Context, exec func(ctx context.Context, query string, args ...interface{}) (sql.Result, error), runID int, key, valueType string, valueString *string, valueBool *bool, valueFloat *float64, valueInt *int64) error {
+	var query string
 	var args []interface{}
 
 	switch valueType {
 	case "string":
-		sql = `INSERT INTO parameters (run_id, key, value_type, value_string)
+		query = `INSERT INTO parameters (run_id, key, value_type, value_string)
 		       VALUES ($1, $2, $3, $4)
 		       ON CONFLICT (run_id, key) DO UPDATE
 		       SET value_type = EXCLUDED.value_type, value_string = EXCLUDED.value_string`
 		args = []interface{}{runID, key, valueType, valueString}
 	case "bool":
-		sql = `INSERT INTO parameters (run_id, key, value_type, value_bool)
+		query = `INSERT INTO parameters (run_id, key, value_type, value_bool)
 		       VALUES ($1, $2, $3, $4)
 		       ON CONFLICT (run_id, key) DO UPDATE
 		       SET value_type = EXCLUDED.value_type, value_bool = EXCLUDED.value_bool`
 		args = []interface{}{runID, key, valueType, valueBool}
 	case "float":
-		sql = `INSERT INTO parameters (run_id, key, value_type, value_float)
+		query = `INSERT INTO parameters (run_id, key, value_type, value_float)
 		       VALUES ($1, $2, $3, $4)
 		       ON CONFLICT (run_id, key) DO UPDATE
 		       SET value_type = EXCLUDED.value_type, value_float = EXCLUDED.value_float`
 		args = []interface{}{runID, key, valueType, valueFloat}
 	case "int":
-		sql = `INSERT INTO parameters (run_id, key, value_type, value_int)
+		query = `INSERT INTO parameters (run_id, key, value_type, value_int)
 		       VALUES ($1, $2, $3, $4)
 		       ON CONFLICT (run_id, key) DO UPDATE
 		       SET value_type = EXCLUDED.value_type, value_int = EXCLUDED.value_int`
@@ -331,13 +491,13 @@ func (d *PostgresDAO) UpsertParameter(runID int, key, valueType string, valueStr
 		return fmt.Errorf("unsupported value type: %s", valueType)
 	}
 
-	_, err := d.db.Exec(sql, args...)
+	_, err := exec(ctx, query, args...)
 	return err
 }
 
 // GetParametersByRunID retrieves all parameters for a run
-func (d *PostgresDAO) GetParametersByRunID(runID int) ([]ParameterRow, error) {
-	rows, err := d.db.Query(`
+func (d *PostgresDAO) GetParametersByRunID(ctx context.Context, runID int) ([]ParameterRow, error) {
+	rows, err := d.db.QueryContext(ctx, `
 		SELECT key, value_type, value_string, value_bool, value_float, value_int
 		FROM parameters
 		WHERE run_id = $1
@@ -361,23 +521,23 @@ func (d *PostgresDAO) GetParametersByRunID(runID int) ([]ParameterRow, error) {
 }
 
 // InsertMetric inserts a new metric
-func (d *PostgresDAO) InsertMetrics(runID int, key string, xValues []float64, yValues []float64, loggedAtEpochMillis int64) error {
+func (d *PostgresDAO) InsertMetrics(ctx context.Context, runID int, key string, xValues []float64, yValues []float64, loggedAtEpochMillis int64) error {
 	if len(xValues) != len(yValues) {
 		return errors.New("xValues and yValues must have the same length")
 	}
 
-	txn, err := d.db.Begin()
+	txn, err := d.db.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
 
-	stmt, err := txn.Prepare(pq.CopyIn("metrics", "run_id", "key", "logged_at", "x_value", "y_value"))
+	stmt, err := txn.PrepareContext(ctx, pq.CopyIn("metrics", "run_id", "key", "logged_at", "x_value", "y_value"))
 	if err != nil {
 		return err
 	}
 
 	for i := range len(xValues) {
-		stmt.Exec(runID, key, time.UnixMilli(loggedAtEpochMillis).UTC(),
+		stmt.ExecContext(ctx, runID, key, time.UnixMilli(loggedAtEpochMillis).UTC(),
 			xValues[i], yValues[i])
 		if err != nil {
 			log.Printf("Error inserting metric: %v", err)
@@ -394,9 +554,67 @@ func (d *PostgresDAO) InsertMetrics(runID int, key string, xValues []float64, yV
 	return err
 }
 
+// InsertSystemMetrics copies points of system metrics, each with its own key
+// and logging time, in one transaction
+func (d *PostgresDAO) InsertSystemMetrics(ctx context.Context, runID int, points []MetricRow) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, pq.CopyIn("metrics", "run_id", "key", "logged_at", "x_value", "y_value"))
+	if err != nil {
+		return err
+	}
+	for _, p := range points {
+		if _, err := stmt.ExecContext(ctx, runID, p.Key, p.LoggedAt.UTC(), p.XValue, p.YValue); err != nil {
+			return err
+		}
+	}
+	// Executing the statement without arguments flushes the copied rows
+	if _, err := stmt.ExecContext(ctx); err != nil {
+		return err
+	}
+	if err := stmt.Close(); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// InsertMetricBatch copies points of the metrics of several runs, keyed by
+// run ID, in one transaction
+func (d *PostgresDAO) InsertMetricBatch(ctx context.Context, points map[int][]MetricRow) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, pq.CopyIn("metrics", "run_id", "key", "logged_at", "x_value", "y_value"))
+	if err != nil {
+		return err
+	}
+	for runID, runPoints := range points {
+		for _, p := range runPoints {
+			if _, err := stmt.ExecContext(ctx, runID, p.Key, p.LoggedAt.UTC(), p.XValue, p.YValue); err != nil {
+				return err
+			}
+		}
+	}
+	// Executing the statement without arguments flushes the copied rows
+	if _, err := stmt.ExecContext(ctx); err != nil {
+		return err
+	}
+	if err := stmt.Close(); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
 // GetMetricsByRunID retrieves all metrics for a run
-func (d *PostgresDAO) GetMetricsByRunID(runID int) ([]MetricRow, error) {
-	rows, err := d.db.Query(`
+func (d *PostgresDAO) GetMetricsByRunID(ctx context.Context, runID int) ([]MetricRow, error) {
+	rows, err := d.db.QueryContext(ctx, `
 		SELECT key, x_value, y_value, logged_at
 		FROM metrics
 		WHERE run_id = $1
@@ -419,22 +637,137 @@ func (d *PostgresDAO) GetMetricsByRunID(runID int) ([]MetricRow, error) {
 	return metrics, rows.Err()
 }
 
-// UpsertArtifact inserts or updates an artifact
-func (d *PostgresDAO) UpsertArtifact(runID int, path, uri, artifactType string) error {
-	_, err := d.db.Exec(
-		`INSERT INTO artifacts (run_id, path, uri, type)
-		 VALUES ($1, $2, $3, $4)
-		 ON CONFLICT (run_id, path) DO UPDATE
-		 SET uri = EXCLUDED.uri, type = EXCLUDED.type`,
-		runID, path, uri, artifactType,
-	)
-	return err
+// GetMetricsDownsampled retrieves a metric series of a run, ordered by x
+// value, downsampled to at most maxPoints points, or 4 if maxPoints is less.
+// The series is split into buckets of consecutive points, of which the lowest
+// and highest are kept, so that spikes survive downsampling.
+func (d *PostgresDAO) GetMetricsDownsampled(ctx context.Context, runID int, key string, maxPoints int) ([]MetricRow, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		WITH numbered AS (
+			SELECT id, x_value, y_value,
+				ROW_NUMBER() OVER (ORDER BY x_value) - 1 AS point_index,
+				COUNT(*) OVER () AS point_count
+			FROM metrics
+			WHERE run_id = $1 AND key = $2
+		), ranked AS (
+			SELECT id, point_index, point_count,
+				ROW_NUMBER() OVER (PARTITION BY point_index * $3 / point_count ORDER BY y_value, x_value) AS lowest,
+				ROW_NUMBER() OVER (PARTITION BY point_index * $3 / point_count ORDER BY y_value DESC, x_value) AS highest
+			FROM numbered
+		)
+		SELECT m.key, m.x_value, m.y_value, m.logged_at
+		FROM ranked r
+		JOIN metrics m ON m.id = r.id
+		WHERE r.point_count <= $4 OR r.lowest = 1 OR r.highest = 1 OR r.point_index = 0 OR r.point_index = r.point_count - 1
+		ORDER BY m.x_value
+	`, runID, key, metricDownsampleBuckets(maxPoints), maxPoints)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var metrics []MetricRow
+	for rows.Next() {
+		var m MetricRow
+		if err := rows.Scan(&m.Key, &m.XValue, &m.YValue, &m.LoggedAt); err != nil {
+			return nil, err
+		}
+		metrics = append(metrics, m)
+	}
+
+	return metrics, rows.Err()
+}
+
+// GetMetricGaps retrieves the consecutive points of a metric series of a run
+// that were logged more than threshold apart, ordered by x value
+func (d *PostgresDAO) GetMetricGaps(ctx context.Context, runID int, key string, threshold time.Duration) ([]MetricGapRow, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		WITH ordered AS (
+			SELECT id, logged_at, LAG(id) OVER (ORDER BY x_value) AS previous_id
+			FROM metrics
+			WHERE run_id = $1 AND key = $2
+		)
+		SELECT s.key, s.x_value, s.y_value, s.logged_at, e.key, e.x_value, e.y_value, e.logged_at
+		FROM ordered o
+		JOIN metrics s ON s.id = o.previous_id
+		JOIN metrics e ON e.id = o.id
+		WHERE EXTRACT(EPOCH FROM e.logged_at - s.logged_at) > $3
+		ORDER BY e.x_value
+	`, runID, key, threshold.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var gaps []MetricGapRow
+	for rows.Next() {
+		var g MetricGapRow
+		if err := rows.Scan(&g.Start.Key, &g.Start.XValue, &g.Start.YValue, &g.Start.LoggedAt, &g.End.Key, &g.End.XValue, &g.End.YValue, &g.End.LoggedAt); err != nil {
+			return nil, err
+		}
+		gaps = append(gaps, g)
+	}
+
+	return gaps, rows.Err()
+}
+
+// UpsertArtifact inserts or updates an artifact. Updating it to contents
+// stored under another URI keeps the prior version.
+func (d *PostgresDAO) UpsertArtifact(ctx context.Context, runID int, path, uri, artifactType, contentType string, sizeBytes int64, sha256 string) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var currentURI string
+	var version int
+	err = tx.QueryRowContext(ctx, "SELECT uri, version FROM artifacts WHERE run_id = $1 AND path = $2 FOR UPDATE", runID, path).Scan(&currentURI, &version)
+	if errors.Is(err, sql.ErrNoRows) {
+		_, err = tx.ExecContext(ctx,
+			`INSERT INTO artifacts (run_id, path, uri, type, content_type, size_bytes, sha256, updated_at)
+			 VALUES ($1, $2, $3, $4, NULLIF($5, ''), $6, NULLIF($7, ''), $8)`,
+			runID, path, uri, artifactType, contentType, sizeBytes, sha256, time.Now().UTC(),
+		)
+		if err != nil {
+			return err
+		}
+		return tx.Commit()
+	}
+	if err != nil {
+		return err
+	}
+
+	// Contents stored under a new URI make a new version, keeping the prior
+	// one. Contents stored over the current ones replace them.
+	if uri != currentURI {
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO artifact_versions (run_id, path, version, uri, type, content_type, size_bytes, sha256, updated_at, scan_status, scan_result, scan_attempts, scanned_at)
+			SELECT run_id, path, version, uri, type, content_type, size_bytes, sha256, updated_at, scan_status, scan_result, scan_attempts, scanned_at
+			FROM artifacts WHERE run_id = $1 AND path = $2
+		`, runID, path)
+		if err != nil {
+			return err
+		}
+		version++
+	}
+	_, err = tx.ExecContext(ctx, `
+		UPDATE artifacts
+		SET uri = $1, type = $2, content_type = NULLIF($3, ''), size_bytes = $4, sha256 = NULLIF($5, ''), updated_at = $6, version = $7,
+		    mirror_status = 'pending', mirror_attempts = 0, mirror_error = NULL, mirrored_at = NULL,
+		    scan_status = 'pending', scan_result = NULL, scan_attempts = 0, scanned_at = NULL
+		WHERE run_id = $8 AND path = $9
+	`, uri, artifactType, contentType, sizeBytes, sha256, time.Now().UTC(), version, runID, path)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
 // GetArtifactsByRunID retrieves all artifacts for a run
-func (d *PostgresDAO) GetArtifactsByRunID(runID int) ([]ArtifactRow, error) {
-	rows, err := d.db.Query(`
-		SELECT path, uri, type
+func (d *PostgresDAO) GetArtifactsByRunID(ctx context.Context, runID int) ([]ArtifactRow, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT path, uri, type, COALESCE(content_type, ''), size_bytes, updated_at, mirror_status, COALESCE(sha256, ''), version, scan_status, COALESCE(scan_result, '')
 		FROM artifacts
 		WHERE run_id = $1
 		ORDER BY path
@@ -447,7 +780,7 @@ func (d *PostgresDAO) GetArtifactsByRunID(runID int) ([]ArtifactRow, error) {
 	var artifacts []ArtifactRow
 	for rows.Next() {
 		var a ArtifactRow
-		if err := rows.Scan(&a.Path, &a.URI, &a.Type); err != nil {
+		if err := rows.Scan(&a.Path, &a.URI, &a.Type, &a.ContentType, &a.SizeBytes, &a.UpdatedAt, &a.MirrorStatus, &a.SHA256, &a.Version, &a.ScanStatus, &a.ScanResult); err != nil {
 			return nil, err
 		}
 		artifacts = append(artifacts, a)
@@ -457,38 +790,2524 @@ func (d *PostgresDAO) GetArtifactsByRunID(runID int) ([]ArtifactRow, error) {
 }
 
 // GetArtifactByRunIDAndPath retrieves a specific artifact by run ID and path
-func (d *PostgresDAO) GetArtifactByRunIDAndPath(runID int, path string) (*ArtifactRow, error) {
+func (d *PostgresDAO) GetArtifactByRunIDAndPath(ctx context.Context, runID int, path string) (*ArtifactRow, error) {
 	var a ArtifactRow
-	err := d.db.QueryRow(
-		"SELECT path, uri, type FROM artifacts WHERE run_id = $1 AND path = $2",
+	err := d.db.QueryRowContext(ctx,
+		"SELECT path, uri, type, COALESCE(content_type, ''), size_bytes, updated_at, mirror_status, COALESCE(sha256, ''), version, scan_status, COALESCE(scan_result, '') FROM artifacts WHERE run_id = $1 AND path = $2",
 		runID, path,
-	).Scan(&a.Path, &a.URI, &a.Type)
+	).Scan(&a.Path, &a.URI, &a.Type, &a.ContentType, &a.SizeBytes, &a.UpdatedAt, &a.MirrorStatus, &a.SHA256, &a.Version, &a.ScanStatus, &a.ScanResult)
 	if err != nil {
 		return nil, err
 	}
 	return &a, nil
 }
 
+// GetArtifactVersions retrieves the prior versions of an artifact, newest
+// first
+func (d *PostgresDAO) GetArtifactVersions(ctx context.Context, runID int, path string) ([]ArtifactRow, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT path, uri, type, COALESCE(content_type, ''), size_bytes, updated_at, COALESCE(sha256, ''), version, scan_status, COALESCE(scan_result, '')
+		FROM artifact_versions
+		WHERE run_id = $1 AND path = $2
+		ORDER BY version DESC
+	`, runID, path)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var versions []ArtifactRow
+	for rows.Next() {
+		var a ArtifactRow
+		if err := rows.Scan(&a.Path, &a.URI, &a.Type, &a.ContentType, &a.SizeBytes, &a.UpdatedAt, &a.SHA256, &a.Version, &a.ScanStatus, &a.ScanResult); err != nil {
+			return nil, err
+		}
+		versions = append(versions, a)
+	}
+	return versions, rows.Err()
+}
+
+// SetArtifactContentType changes the type and content type of an artifact
+func (d *PostgresDAO) SetArtifactContentType(ctx context.Context, runID int, path, artifactType, contentType string) error {
+	_, err := d.db.ExecContext(ctx,
+		"UPDATE artifacts SET type = $1, content_type = $2 WHERE run_id = $3 AND path = $4",
+		artifactType, contentType, runID, path,
+	)
+	return err
+}
+
 // UpdateRunNotes updates the notes for a run
-func (d *PostgresDAO) UpdateRunNotes(runID int, notes string) error {
-	_, err := d.db.Exec(
+func (d *PostgresDAO) UpdateRunNotes(ctx context.Context, runID int, notes string) error {
+	_, err := d.db.ExecContext(ctx,
 		"UPDATE runs SET notes = $1 WHERE id = $2",
 		notes, runID,
 	)
 	return err
 }
 
+// BackdateRun records that a run was created, and finished, at a time in the
+// past, for runs imported from elsewhere
+func (d *PostgresDAO) BackdateRun(ctx context.Context, runID int, at time.Time) error {
+	at = at.UTC()
+	_, err := d.db.ExecContext(ctx,
+		"UPDATE runs SET created_at = $1, last_activity_at = $1, finished_at = $1 WHERE id = $2",
+		at, runID,
+	)
+	return err
+}
+
 // GetExperimentForRunUUID retrieves the experiment associated with a run
-func (d *PostgresDAO) GetExperimentForRunUUID(runUUID string) (*Experiment, error) {
+func (d *PostgresDAO) GetExperimentForRunUUID(ctx context.Context, runUUID string) (*Experiment, error) {
 	var uuid, name, createdAt string
-	err := d.db.QueryRow(`
-		SELECT e.uuid, e.name, e.created_at
+	var projectID int
+	err := d.db.QueryRowContext(ctx, `
+		SELECT e.uuid, e.name, e.created_at, e.project_id
 		FROM experiments e
 		JOIN runs r ON r.experiment_id = e.id
 		WHERE r.uuid = $1
-	`, runUUID).Scan(&uuid, &name, &createdAt)
+	`, runUUID).Scan(&uuid, &name, &createdAt, &projectID)
+	if err != nil {
+		return nil, err
+	}
+	return &Experiment{UUID: uuid, Name: name, CreatedAt: createdAt, ProjectID: projectID}, nil
+}
+
+// InsertRunAnnotation attaches a text annotation to a run at the given step
+func (d *PostgresDAO) InsertRunAnnotation(ctx context.Context, runID int, step float64, text string) error {
+	_, err := d.db.ExecContext(ctx,
+		"INSERT INTO run_annotations (run_id, step, text) VALUES ($1, $2, $3)",
+		runID, step, text,
+	)
+	return err
+}
+
+// GetRunAnnotationsByRunID retrieves all annotations for a run ordered by step
+func (d *PostgresDAO) GetRunAnnotationsByRunID(ctx context.Context, runID int) ([]RunAnnotationRow, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT step, text, created_at
+		FROM run_annotations
+		WHERE run_id = $1
+		ORDER BY step, id
+	`, runID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var annotations []RunAnnotationRow
+	for rows.Next() {
+		var a RunAnnotationRow
+		if err := rows.Scan(&a.Step, &a.Text, &a.CreatedAt); err != nil {
+			return nil, err
+		}
+		annotations = append(annotations, a)
+	}
+
+	return annotations, rows.Err()
+}
+
+// UpdateExperimentReadme replaces an experiment's README and records the new
+// text as a revision in its edit history
+func (d *PostgresDAO) UpdateExperimentReadme(ctx context.Context, experimentID int, readme string) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, "UPDATE experiments SET readme = $1 WHERE id = $2", readme, experimentID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO experiment_readme_revisions (experiment_id, readme) VALUES ($1, $2)",
+		experimentID, readme,
+	)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetExperimentReadmeRevisions retrieves the README edit history of an experiment, newest first
+func (d *PostgresDAO) GetExperimentReadmeRevisions(ctx context.Context, experimentID int) ([]ExperimentReadmeRevisionRow, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT readme, created_at
+		FROM experiment_readme_revisions
+		WHERE experiment_id = $1
+		ORDER BY id DESC
+	`, experimentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var revisions []ExperimentReadmeRevisionRow
+	for rows.Next() {
+		var rev ExperimentReadmeRevisionRow
+		if err := rows.Scan(&rev.Readme, &rev.CreatedAt); err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, rev)
+	}
+
+	return revisions, rows.Err()
+}
+
+// FindRunsByNameOrUUIDPrefix retrieves runs whose name matches the query
+// exactly or whose UUID starts with it, newest first
+func (d *PostgresDAO) FindRunsByNameOrUUIDPrefix(ctx context.Context, query string) ([]Run, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT uuid, name, created_at, parent_run_id, nesting_level, status
+		FROM runs
+		WHERE (name = $1 OR substr(uuid, 1, $2) = $3) AND deleted_at IS NULL
+		ORDER BY created_at DESC
+	`, query, len(query), strings.ToLower(query))
 	if err != nil {
 		return nil, err
 	}
-	return &Experiment{UUID: uuid, Name: name, CreatedAt: createdAt}, nil
+	defer rows.Close()
+
+	var runs []Run
+	for rows.Next() {
+		var uuid, name, createdAt, status string
+		var parentRunID sql.NullInt64
+		var nestingLevel int
+		if err := rows.Scan(&uuid, &name, &createdAt, &parentRunID, &nestingLevel, &status); err != nil {
+			return nil, err
+		}
+		run := Run{UUID: uuid, Name: name, CreatedAt: createdAt, NestingLevel: nestingLevel, Status: status}
+		if parentRunID.Valid {
+			id := int(parentRunID.Int64)
+			run.ParentRunID = &id
+		}
+		runs = append(runs, run)
+	}
+
+	return runs, rows.Err()
+}
+
+// GetLatestMetricsByRunID retrieves the point with the largest x value for each metric key of a run, leaving out system metrics
+func (d *PostgresDAO) GetLatestMetricsByRunID(ctx context.Context, runID int) ([]MetricRow, error) {
+	return d.getLatestMetrics(ctx, runID, "key NOT LIKE 'system/%'")
+}
+
+// GetLatestSystemMetricsByRunID retrieves the point with the largest x value for each system metric key of a run
+func (d *PostgresDAO) GetLatestSystemMetricsByRunID(ctx context.Context, runID int) ([]MetricRow, error) {
+	return d.getLatestMetrics(ctx, runID, "key LIKE 'system/%'")
+}
+
+// getLatestMetrics retrieves the latest point of each metric key of a run that matches keyFilter
+func (d *PostgresDAO) getLatestMetrics(ctx context.Context, runID int, keyFilter string) ([]MetricRow, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT DISTINCT ON (key) key, x_value, y_value, logged_at
+		FROM metrics
+		WHERE run_id = $1 AND `+keyFilter+`
+		ORDER BY key, x_value DESC, logged_at DESC
+	`, runID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var metrics []MetricRow
+	for rows.Next() {
+		var m MetricRow
+		if err := rows.Scan(&m.Key, &m.XValue, &m.YValue, &m.LoggedAt); err != nil {
+			return nil, err
+		}
+		metrics = append(metrics, m)
+	}
+
+	return metrics, rows.Err()
+}
+
+// GetParametersByExperimentID retrieves the parameters of every run in an experiment
+func (d *PostgresDAO) GetParametersByExperimentID(ctx context.Context, experimentID int) ([]ExperimentParameterRow, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT r.id, r.uuid, p.key, p.value_type, p.value_string, p.value_bool, p.value_float, p.value_int
+		FROM parameters p
+		JOIN runs r ON p.run_id = r.id
+		WHERE r.experiment_id = $1
+		ORDER BY p.key, r.id
+	`, experimentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var params []ExperimentParameterRow
+	for rows.Next() {
+		var p ExperimentParameterRow
+		if err := rows.Scan(&p.RunID, &p.RunUUID, &p.Key, &p.ValueType, &p.ValueString, &p.ValueBool, &p.ValueFloat, &p.ValueInt); err != nil {
+			return nil, err
+		}
+		params = append(params, p)
+	}
+
+	return params, rows.Err()
+}
+
+// InsertRunTemplate saves a named template and its parameters
+func (d *PostgresDAO) InsertRunTemplate(ctx context.Context, name string, experimentID int, params []RunTemplateParameterRow) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var templateID int
+	err = tx.QueryRowContext(ctx,
+		"INSERT INTO run_templates (name, experiment_id) VALUES ($1, $2) RETURNING id",
+		name, experimentID,
+	).Scan(&templateID)
+	if err != nil {
+		return err
+	}
+
+	for _, param := range params {
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO run_template_parameters (template_id, key, value_type, value_string, value_bool, value_float, value_int, prompt)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		`, templateID, param.Key, param.ValueType, param.ValueString, param.ValueBool, param.ValueFloat, param.ValueInt, param.Prompt)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// GetRunTemplateByName retrieves a run template by its name
+func (d *PostgresDAO) GetRunTemplateByName(ctx context.Context, name string) (*RunTemplateRow, error) {
+	var t RunTemplateRow
+	err := d.db.QueryRowContext(ctx,
+		"SELECT id, name, experiment_id, created_at FROM run_templates WHERE name = $1",
+		name,
+	).Scan(&t.ID, &t.Name, &t.ExperimentID, &t.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// GetAllRunTemplates retrieves all run templates ordered by name
+func (d *PostgresDAO) GetAllRunTemplates(ctx context.Context) ([]RunTemplateRow, error) {
+	rows, err := d.db.QueryContext(ctx, "SELECT id, name, experiment_id, created_at FROM run_templates ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var templates []RunTemplateRow
+	for rows.Next() {
+		var t RunTemplateRow
+		if err := rows.Scan(&t.ID, &t.Name, &t.ExperimentID, &t.CreatedAt); err != nil {
+			return nil, err
+		}
+		templates = append(templates, t)
+	}
+
+	return templates, rows.Err()
+}
+
+// GetRunTemplateParameters retrieves the parameters of a run template ordered by key
+func (d *PostgresDAO) GetRunTemplateParameters(ctx context.Context, templateID int) ([]RunTemplateParameterRow, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT key, value_type, value_string, value_bool, value_float, value_int, prompt
+		FROM run_template_parameters
+		WHERE template_id = $1
+		ORDER BY key
+	`, templateID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var params []RunTemplateParameterRow
+	for rows.Next() {
+		var param RunTemplateParameterRow
+		if err := rows.Scan(&param.Key, &param.ValueType, &param.ValueString, &param.ValueBool, &param.ValueFloat, &param.ValueInt, &param.Prompt); err != nil {
+			return nil, err
+		}
+		params = append(params, param)
+	}
+
+	return params, rows.Err()
+}
+
+// GetRuns retrieves a page of runs across all experiments matching a filter, sorted by one of the runSortColumns or a metric
+func (d *PostgresDAO) GetRuns(ctx context.Context, offset, limit int, sortBy, sortDir string, filter RunFilter) ([]RunSummary, error) {
+	// The sort value's arguments come first, then the filter's
+	sortValue, orderBy, args, err := runsOrderBy(sortBy, sortDir, func(n int) string { return fmt.Sprintf("$%d", n) })
+	if err != nil {
+		return nil, err
+	}
+	where, filterArgs := runFilterWhere(filter, func(n int) string { return fmt.Sprintf("$%d", len(args)+n) })
+	args = append(append(args, filterArgs...), limit, offset)
+	rows, err := d.db.QueryContext(ctx, fmt.Sprintf(`
+		SELECT r.id, r.uuid, r.name, r.created_at, e.uuid, e.name, r.status, r.archived_at IS NOT NULL, %s, %s AS sort_value
+		FROM runs r
+		JOIN experiments e ON r.experiment_id = e.id AND r.deleted_at IS NULL
+		%s
+		ORDER BY %s
+		LIMIT $%d OFFSET $%d
+	`, runReproducibleCondition, sortValue, where, orderBy, len(args)-1, len(args)), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var runs []RunSummary
+	for rows.Next() {
+		var run RunSummary
+		if err := rows.Scan(&run.ID, &run.UUID, &run.Name, &run.CreatedAt, &run.ExperimentUUID, &run.ExperimentName, &run.Status, &run.Archived, &run.Reproducible, &run.SortValue); err != nil {
+			return nil, err
+		}
+		runs = append(runs, run)
+	}
+
+	return runs, rows.Err()
+}
+
+// SetRunHold places a run on hold, recording the reason
+func (d *PostgresDAO) SetRunHold(ctx context.Context, runID int, reason string) error {
+	_, err := d.db.ExecContext(ctx,
+		"UPDATE runs SET hold_reason = $1, held_at = $2 WHERE id = $3",
+		reason, time.Now().UTC(), runID,
+	)
+	return err
+}
+
+// ClearRunHold releases the hold on a run
+func (d *PostgresDAO) ClearRunHold(ctx context.Context, runID int) error {
+	_, err := d.db.ExecContext(ctx,
+		"UPDATE runs SET hold_reason = NULL, held_at = NULL WHERE id = $1",
+		runID,
+	)
+	return err
+}
+
+// GetRunHold retrieves the hold on a run, or nil if the run is not on hold
+func (d *PostgresDAO) GetRunHold(ctx context.Context, runID int) (*RunHoldRow, error) {
+	var reason sql.NullString
+	var heldAt sql.NullTime
+	err := d.db.QueryRowContext(ctx,
+		"SELECT hold_reason, held_at FROM runs WHERE id = $1",
+		runID,
+	).Scan(&reason, &heldAt)
+	if err != nil {
+		return nil, err
+	}
+	if !heldAt.Valid {
+		return nil, nil
+	}
+	return &RunHoldRow{Reason: reason.String, HeldAt: heldAt.Time}, nil
+}
+
+// SetRunSource records where a run came from, storing empty fields as NULL
+func (d *PostgresDAO) SetRunSource(ctx context.Context, runID int, source RunSourceRow) error {
+	return setPostgresRunSource(ctx, d.db.ExecContext, runID, source)
+}
+
+// setPostgresRunSource records where a run came from with exec, which is the
+// database's or a transaction's Exec
+func setPostgresRunSource(ctx context.Context, exec func(ctx context.Context, query string, args ...interface{}) (sql.Result, error), runID int, source RunSourceRow) error {
+	nullable := func(s string) sql.NullString { return sql.NullString{String: s, Valid: s != ""} }
+	_, err := exec(ctx,
+		"UPDATE runs SET user_name = $1, git_commit = $2, git_branch = $3, git_dirty = $4, entrypoint = $5 WHERE id = $6",
+		nullable(source.User), nullable(source.GitCommit), nullable(source.GitBranch), source.GitDirty, nullable(source.Entrypoint), runID,
+	)
+	return err
+}
+
+// GetRunSource retrieves where a run came from, or nil if nothing was recorded
+func (d *PostgresDAO) GetRunSource(ctx context.Context, runID int) (*RunSourceRow, error) {
+	var user, commit, branch, entrypoint sql.NullString
+	var source RunSourceRow
+	err := d.db.QueryRowContext(ctx,
+		"SELECT user_name, git_commit, git_branch, git_dirty, entrypoint FROM runs WHERE id = $1",
+		runID,
+	).Scan(&user, &commit, &branch, &source.GitDirty, &entrypoint)
+	if err != nil {
+		return nil, err
+	}
+	source.User, source.GitCommit, source.GitBranch, source.Entrypoint = user.String, commit.String, branch.String, entrypoint.String
+	if source == (RunSourceRow{}) {
+		return nil, nil
+	}
+	return &source, nil
+}
+
+// SetRunForkedFrom records the UUID of the run a run was forked from
+func (d *PostgresDAO) SetRunForkedFrom(ctx context.Context, runID int, sourceUUID string) error {
+	_, err := d.db.ExecContext(ctx, "UPDATE runs SET forked_from = $1 WHERE id = $2", sourceUUID, runID)
+	return err
+}
+
+// SetRunSeeds replaces the random seeds a run was started with
+func (d *PostgresDAO) SetRunSeeds(ctx context.Context, runID int, seeds RunSeedsRow) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := setPostgresRunSeeds(ctx, tx.ExecContext, runID, seeds); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// setPostgresRunSeeds replaces the random seeds a run was started with, with
+// exec, which is a transaction's Exec
+func setPostgresRunSeeds(ctx context.Context, exec func(ctx context.Context, query string, args ...interface{}) (sql.Result, error), runID int, seeds RunSeedsRow) error {
+	seed := sql.NullString{String: seeds.Seed, Valid: seeds.Seed != ""}
+	if _, err := exec(ctx, "UPDATE runs SET seed = $1 WHERE id = $2", seed, runID); err != nil {
+		return err
+	}
+	if _, err := exec(ctx, "DELETE FROM run_seeds WHERE run_id = $1", runID); err != nil {
+		return err
+	}
+	for _, l := range seeds.Libraries {
+		if _, err := exec(ctx, "INSERT INTO run_seeds (run_id, library, seed) VALUES ($1, $2, $3)", runID, l.Library, l.Seed); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetRunSeeds retrieves the random seeds a run was started with, or nil if it
+// reported none
+func (d *PostgresDAO) GetRunSeeds(ctx context.Context, runID int) (*RunSeedsRow, error) {
+	var seed sql.NullString
+	if err := d.db.QueryRowContext(ctx, "SELECT seed FROM runs WHERE id = $1", runID).Scan(&seed); err != nil {
+		return nil, err
+	}
+	seeds := RunSeedsRow{Seed: seed.String}
+
+	rows, err := d.db.QueryContext(ctx, "SELECT library, seed FROM run_seeds WHERE run_id = $1 ORDER BY library", runID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var l LibrarySeedRow
+		if err := rows.Scan(&l.Library, &l.Seed); err != nil {
+			return nil, err
+		}
+		seeds.Libraries = append(seeds.Libraries, l)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if seeds.Seed == "" && len(seeds.Libraries) == 0 {
+		return nil, nil
+	}
+	return &seeds, nil
+}
+
+// InsertNotificationSubscription saves a notification subscription
+func (d *PostgresDAO) InsertNotificationSubscription(ctx context.Context, sub NotificationSubscriptionRow) error {
+	_, err := d.db.ExecContext(ctx,
+		"INSERT INTO notification_subscriptions (experiment_id, channel, target, events, tag) VALUES ($1, $2, $3, $4, $5)",
+		sub.ExperimentID, sub.Channel, sub.Target, sub.Events, sub.Tag,
+	)
+	return err
+}
+
+// GetNotificationSubscriptions retrieves all notification subscriptions, oldest first
+func (d *PostgresDAO) GetNotificationSubscriptions(ctx context.Context) ([]NotificationSubscriptionRow, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT id, experiment_id, channel, target, events, tag, created_at
+		FROM notification_subscriptions
+		ORDER BY id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var subs []NotificationSubscriptionRow
+	for rows.Next() {
+		var sub NotificationSubscriptionRow
+		if err := rows.Scan(&sub.ID, &sub.ExperimentID, &sub.Channel, &sub.Target, &sub.Events, &sub.Tag, &sub.CreatedAt); err != nil {
+			return nil, err
+		}
+		subs = append(subs, sub)
+	}
+
+	return subs, rows.Err()
+}
+
+// DeleteNotificationSubscription removes a notification subscription
+func (d *PostgresDAO) DeleteNotificationSubscription(ctx context.Context, id int) error {
+	_, err := d.db.ExecContext(ctx, "DELETE FROM notification_subscriptions WHERE id = $1", id)
+	return err
+}
+
+// InsertNotificationPreference saves a user's notification preference
+func (d *PostgresDAO) InsertNotificationPreference(ctx context.Context, pref NotificationPreferenceRow) error {
+	_, err := d.db.ExecContext(ctx,
+		"INSERT INTO notification_preferences (user_name, event, experiment_id, channel, target) VALUES ($1, $2, $3, $4, $5)",
+		pref.User, pref.Event, pref.ExperimentID, pref.Channel, pref.Target,
+	)
+	return err
+}
+
+// GetNotificationPreferences retrieves every user's notification preferences,
+// oldest first
+func (d *PostgresDAO) GetNotificationPreferences(ctx context.Context) ([]NotificationPreferenceRow, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT id, user_name, event, experiment_id, channel, target, created_at
+		FROM notification_preferences
+		ORDER BY id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var prefs []NotificationPreferenceRow
+	for rows.Next() {
+		var pref NotificationPreferenceRow
+		if err := rows.Scan(&pref.ID, &pref.User, &pref.Event, &pref.ExperimentID, &pref.Channel, &pref.Target, &pref.CreatedAt); err != nil {
+			return nil, err
+		}
+		prefs = append(prefs, pref)
+	}
+
+	return prefs, rows.Err()
+}
+
+// DeleteNotificationPreference removes one of a user's notification
+// preferences, doing nothing if it is someone else's
+func (d *PostgresDAO) DeleteNotificationPreference(ctx context.Context, id int, user string) error {
+	_, err := d.db.ExecContext(ctx, "DELETE FROM notification_preferences WHERE id = $1 AND user_name = $2", id, user)
+	return err
+}
+
+// InsertWebNotification saves a notification delivered to a user on the web
+func (d *PostgresDAO) InsertWebNotification(ctx context.Context, notification WebNotificationRow) error {
+	_, err := d.db.ExecContext(ctx,
+		"INSERT INTO web_notifications (user_name, run_uuid, message) VALUES ($1, $2, $3)",
+		notification.User, notification.RunUUID, notification.Message,
+	)
+	return err
+}
+
+// GetWebNotifications retrieves the latest notifications delivered to a user
+// on the web, newest first
+func (d *PostgresDAO) GetWebNotifications(ctx context.Context, user string, limit int) ([]WebNotificationRow, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT id, user_name, run_uuid, message, created_at
+		FROM web_notifications
+		WHERE user_name = $1
+		ORDER BY id DESC
+		LIMIT $2
+	`, user, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var notifications []WebNotificationRow
+	for rows.Next() {
+		var n WebNotificationRow
+		if err := rows.Scan(&n.ID, &n.User, &n.RunUUID, &n.Message, &n.CreatedAt); err != nil {
+			return nil, err
+		}
+		notifications = append(notifications, n)
+	}
+
+	return notifications, rows.Err()
+}
+
+// SearchRuns finds runs whose name, notes, annotations, or tags contain every
+// term as a word prefix, best matches first
+func (d *PostgresDAO) SearchRuns(ctx context.Context, terms []string, limit, projectID int) ([]RunSearchRow, error) {
+	var query []string
+	for _, term := range terms {
+		query = append(query, "'"+strings.ReplaceAll(term, "'", "''")+"':*")
+	}
+	headlineOptions := fmt.Sprintf(`StartSel="%s", StopSel="%s", MaxWords=24, MinWords=8`, searchMatchStart, searchMatchEnd)
+
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT r.uuid, r.name, r.created_at, e.uuid, e.name,
+			ts_headline('english',
+				r.name || ' ' || COALESCE(r.notes, '') || ' ' ||
+				COALESCE((SELECT string_agg(a.text, ' ') FROM run_annotations a WHERE a.run_id = r.id), '') || ' ' ||
+				COALESCE((SELECT string_agg(t.key || ' ' || t.value, ' ') FROM tags t WHERE t.run_id = r.id), ''),
+				q, $2)
+		FROM runs r
+		JOIN experiments e ON e.id = r.experiment_id,
+			to_tsquery('english', $1) q
+		WHERE r.search_vector @@ q AND r.deleted_at IS NULL AND ($4 = 0 OR e.project_id = $4)
+		ORDER BY ts_rank(r.search_vector, q) DESC, r.created_at DESC
+		LIMIT $3
+	`, strings.Join(query, " & "), headlineOptions, limit, projectID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []RunSearchRow
+	for rows.Next() {
+		var r RunSearchRow
+		if err := rows.Scan(&r.UUID, &r.Name, &r.CreatedAt, &r.ExperimentUUID, &r.ExperimentName, &r.Snippet); err != nil {
+			return nil, err
+		}
+		results = append(results, r)
+	}
+
+	return results, rows.Err()
+}
+
+// UpsertRunGPUSummary saves the GPU aggregates of a run, replacing any previous ones
+func (d *PostgresDAO) UpsertRunGPUSummary(ctx context.Context, summary RunGPUSummaryRow) error {
+	_, err := d.db.ExecContext(ctx,
+		`INSERT INTO run_gpu_summaries (run_id, utilization_mean, memory_peak_bytes, gpu_hours, updated_at)
+		 VALUES ($1, $2, $3, $4, $5)
+		 ON CONFLICT (run_id) DO UPDATE
+		 SET utilization_mean = EXCLUDED.utilization_mean, memory_peak_bytes = EXCLUDED.memory_peak_bytes,
+		     gpu_hours = EXCLUDED.gpu_hours, updated_at = EXCLUDED.updated_at`,
+		summary.RunID, summary.UtilizationMean, summary.MemoryPeakBytes, summary.GPUHours, time.Now().UTC(),
+	)
+	return err
+}
+
+// GetRunGPUSummary retrieves the GPU aggregates of a run, or nil if it has none
+func (d *PostgresDAO) GetRunGPUSummary(ctx context.Context, runID int) (*RunGPUSummaryRow, error) {
+	var s RunGPUSummaryRow
+	err := d.db.QueryRowContext(ctx, `
+		SELECT run_id, utilization_mean, memory_peak_bytes, gpu_hours
+		FROM run_gpu_summaries
+		WHERE run_id = $1
+	`, runID).Scan(&s.RunID, &s.UtilizationMean, &s.MemoryPeakBytes, &s.GPUHours)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// GetRunGPUSummariesByExperimentID retrieves the GPU aggregates of every run in an experiment
+func (d *PostgresDAO) GetRunGPUSummariesByExperimentID(ctx context.Context, experimentID int) ([]RunGPUSummaryRow, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT s.run_id, s.utilization_mean, s.memory_peak_bytes, s.gpu_hours
+		FROM run_gpu_summaries s
+		JOIN runs r ON r.id = s.run_id
+		WHERE r.experiment_id = $1
+	`, experimentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var summaries []RunGPUSummaryRow
+	for rows.Next() {
+		var s RunGPUSummaryRow
+		if err := rows.Scan(&s.RunID, &s.UtilizationMean, &s.MemoryPeakBytes, &s.GPUHours); err != nil {
+			return nil, err
+		}
+		summaries = append(summaries, s)
+	}
+
+	return summaries, rows.Err()
+}
+
+// UpsertExperimentCostRate sets the price of a GPU-hour on a machine type for an experiment
+func (d *PostgresDAO) UpsertExperimentCostRate(ctx context.Context, experimentID int, machineType string, usdPerGPUHour float64) error {
+	_, err := d.db.ExecContext(ctx,
+		`INSERT INTO experiment_cost_rates (experiment_id, machine_type, usd_per_gpu_hour) VALUES ($1, $2, $3)
+		 ON CONFLICT (experiment_id, machine_type) DO UPDATE SET usd_per_gpu_hour = EXCLUDED.usd_per_gpu_hour`,
+		experimentID, machineType, usdPerGPUHour,
+	)
+	return err
+}
+
+// DeleteExperimentCostRate removes the rate of a machine type from an experiment's cost model
+func (d *PostgresDAO) DeleteExperimentCostRate(ctx context.Context, experimentID int, machineType string) error {
+	_, err := d.db.ExecContext(ctx,
+		"DELETE FROM experiment_cost_rates WHERE experiment_id = $1 AND machine_type = $2",
+		experimentID, machineType,
+	)
+	return err
+}
+
+// GetExperimentCostRates retrieves the cost model of an experiment, ordered by machine type
+func (d *PostgresDAO) GetExperimentCostRates(ctx context.Context, experimentID int) ([]CostRateRow, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT machine_type, usd_per_gpu_hour
+		FROM experiment_cost_rates
+		WHERE experiment_id = $1
+		ORDER BY machine_type
+	`, experimentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rates []CostRateRow
+	for rows.Next() {
+		var rate CostRateRow
+		if err := rows.Scan(&rate.MachineType, &rate.USDPerGPUHour); err != nil {
+			return nil, err
+		}
+		rates = append(rates, rate)
+	}
+
+	return rates, rows.Err()
+}
+
+// UpsertMetricSchemaEntry declares a metric key, or pattern of keys, in an experiment's metric schema
+func (d *PostgresDAO) UpsertMetricSchemaEntry(ctx context.Context, experimentID int, entry MetricSchemaEntryRow) error {
+	_, err := d.db.ExecContext(ctx,
+		`INSERT INTO experiment_metric_schema (experiment_id, key, min_step, max_step) VALUES ($1, $2, $3, $4)
+		 ON CONFLICT (experiment_id, key) DO UPDATE SET min_step = EXCLUDED.min_step, max_step = EXCLUDED.max_step`,
+		experimentID, entry.Key, entry.MinStep, entry.MaxStep,
+	)
+	return err
+}
+
+// DeleteMetricSchemaEntry removes a key from an experiment's metric schema
+func (d *PostgresDAO) DeleteMetricSchemaEntry(ctx context.Context, experimentID int, key string) error {
+	_, err := d.db.ExecContext(ctx,
+		"DELETE FROM experiment_metric_schema WHERE experiment_id = $1 AND key = $2",
+		experimentID, key,
+	)
+	return err
+}
+
+// GetMetricSchema retrieves the metric schema of an experiment, ordered by key
+func (d *PostgresDAO) GetMetricSchema(ctx context.Context, experimentID int) ([]MetricSchemaEntryRow, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT key, min_step, max_step
+		FROM experiment_metric_schema
+		WHERE experiment_id = $1
+		ORDER BY key
+	`, experimentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanMetricSchemaEntries(rows)
+}
+
+// GetMetricSchemaForRun retrieves the metric schema of a run's experiment, ordered by key
+func (d *PostgresDAO) GetMetricSchemaForRun(ctx context.Context, runID int) ([]MetricSchemaEntryRow, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT s.key, s.min_step, s.max_step
+		FROM experiment_metric_schema s
+		JOIN runs r ON r.experiment_id = s.experiment_id
+		WHERE r.id = $1
+		ORDER BY s.key
+	`, runID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanMetricSchemaEntries(rows)
+}
+
+// RecordMetricSchemaWarning records that a run logged a metric outside its
+// experiment's schema, counting repeats of the same kind of problem with a key
+func (d *PostgresDAO) RecordMetricSchemaWarning(ctx context.Context, runID int, key, kind, detail string) error {
+	_, err := d.db.ExecContext(ctx,
+		`INSERT INTO metric_schema_warnings (run_id, key, kind, detail) VALUES ($1, $2, $3, $4)
+		 ON CONFLICT (run_id, key, kind) DO UPDATE SET
+			count = metric_schema_warnings.count + 1,
+			detail = EXCLUDED.detail,
+			last_seen_at = CURRENT_TIMESTAMP`,
+		runID, key, kind, detail,
+	)
+	return err
+}
+
+// GetMetricSchemaWarnings retrieves the metric schema warnings of an
+// experiment's runs, most recently seen first
+func (d *PostgresDAO) GetMetricSchemaWarnings(ctx context.Context, experimentID int) ([]MetricSchemaWarningRow, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT r.uuid, r.name, w.key, w.kind, w.detail, w.count, w.last_seen_at
+		FROM metric_schema_warnings w
+		JOIN runs r ON r.id = w.run_id
+		WHERE r.experiment_id = $1 AND r.deleted_at IS NULL
+		ORDER BY w.last_seen_at DESC, w.id DESC
+	`, experimentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var warnings []MetricSchemaWarningRow
+	for rows.Next() {
+		var w MetricSchemaWarningRow
+		if err := rows.Scan(&w.RunUUID, &w.RunName, &w.Key, &w.Kind, &w.Detail, &w.Count, &w.LastSeenAt); err != nil {
+			return nil, err
+		}
+		warnings = append(warnings, w)
+	}
+
+	return warnings, rows.Err()
+}
+
+// DeleteMetricSchemaWarnings dismisses the metric schema warnings of an experiment's runs
+func (d *PostgresDAO) DeleteMetricSchemaWarnings(ctx context.Context, experimentID int) error {
+	_, err := d.db.ExecContext(ctx,
+		"DELETE FROM metric_schema_warnings WHERE run_id IN (SELECT id FROM runs WHERE experiment_id = $1)",
+		experimentID,
+	)
+	return err
+}
+
+// GetRunMetricNamespaces lists the namespaces of a run's metrics, ordered by
+// namespace, with how many metrics and points each has
+func (d *PostgresDAO) GetRunMetricNamespaces(ctx context.Context, runID int) ([]MetricNamespaceRow, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT namespace, COUNT(*), SUM(point_count)
+		FROM metric_summaries
+		WHERE run_id = $1
+		GROUP BY namespace
+		ORDER BY namespace
+	`, runID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var namespaces []MetricNamespaceRow
+	for rows.Next() {
+		var ns MetricNamespaceRow
+		if err := rows.Scan(&ns.Namespace, &ns.Metrics, &ns.Points); err != nil {
+			return nil, err
+		}
+		namespaces = append(namespaces, ns)
+	}
+	return namespaces, rows.Err()
+}
+
+// UpsertMetricNamespaceSetting sets how an experiment treats the metrics of
+// a namespace, replacing what was set before
+func (d *PostgresDAO) UpsertMetricNamespaceSetting(ctx context.Context, setting MetricNamespaceSettingRow) error {
+	_, err := d.db.ExecContext(ctx,
+		`INSERT INTO metric_namespace_settings (experiment_id, namespace, retain_points_days, alert_above, alert_below) VALUES ($1, $2, $3, $4, $5)
+		 ON CONFLICT (experiment_id, namespace) DO UPDATE SET
+			retain_points_days = EXCLUDED.retain_points_days,
+			alert_above = EXCLUDED.alert_above,
+			alert_below = EXCLUDED.alert_below`,
+		setting.ExperimentID, setting.Namespace, setting.RetainPointsDays, setting.AlertAbove, setting.AlertBelow,
+	)
+	return err
+}
+
+// DeleteMetricNamespaceSetting removes what was set for a namespace of an
+// experiment's metrics, returning whether anything was
+func (d *PostgresDAO) DeleteMetricNamespaceSetting(ctx context.Context, experimentID int, namespace string) (bool, error) {
+	result, err := d.db.ExecContext(ctx,
+		"DELETE FROM metric_namespace_settings WHERE experiment_id = $1 AND namespace = $2",
+		experimentID, namespace,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// GetMetricNamespaceSettings retrieves what is set for the metric namespaces
+// of an experiment, or of every experiment if experimentID is 0, ordered by
+// experiment and namespace
+func (d *PostgresDAO) GetMetricNamespaceSettings(ctx context.Context, experimentID int) ([]MetricNamespaceSettingRow, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT experiment_id, namespace, retain_points_days, alert_above, alert_below
+		FROM metric_namespace_settings
+		WHERE experiment_id = $1 OR $1 = 0
+		ORDER BY experiment_id, namespace
+	`, experimentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanMetricNamespaceSettings(rows)
+}
+
+// GetMetricNamespaceSettingsForRun retrieves what is set for the metric
+// namespaces of a run's experiment, ordered by namespace
+func (d *PostgresDAO) GetMetricNamespaceSettingsForRun(ctx context.Context, runID int) ([]MetricNamespaceSettingRow, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT s.experiment_id, s.namespace, s.retain_points_days, s.alert_above, s.alert_below
+		FROM metric_namespace_settings s
+		JOIN runs r ON r.experiment_id = s.experiment_id
+		WHERE r.id = $1
+		ORDER BY s.namespace
+	`, runID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanMetricNamespaceSettings(rows)
+}
+
+// GetNamespaceMetricPoints counts the points of the metrics in a namespace of
+// an experiment's runs that ended before a time, listing the runs with any
+func (d *PostgresDAO) GetNamespaceMetricPoints(ctx context.Context, experimentID int, namespace string, endedBefore time.Time) ([]RunMetricPointsRow, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT r.id, r.uuid, COUNT(*)
+		FROM metrics m
+		JOIN runs r ON r.id = m.run_id
+		JOIN metric_summaries s ON s.run_id = m.run_id AND s.key = m.key
+		WHERE r.experiment_id = $1 AND r.deleted_at IS NULL AND r.finished_at < $2 AND s.namespace = $3
+		GROUP BY r.id, r.uuid
+		ORDER BY r.id
+	`, experimentID, endedBefore.UTC(), namespace)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var runs []RunMetricPointsRow
+	for rows.Next() {
+		var run RunMetricPointsRow
+		if err := rows.Scan(&run.RunID, &run.RunUUID, &run.Points); err != nil {
+			return nil, err
+		}
+		runs = append(runs, run)
+	}
+	return runs, rows.Err()
+}
+
+// DeleteNamespaceMetrics deletes the points of a run's metrics in a
+// namespace, keeping their summaries, and returns how many it deleted
+func (d *PostgresDAO) DeleteNamespaceMetrics(ctx context.Context, runID int, namespace string) (int64, error) {
+	result, err := d.db.ExecContext(ctx, `
+		DELETE FROM metrics
+		WHERE run_id = $1 AND key IN (SELECT key FROM metric_summaries WHERE run_id = $1 AND namespace = $2)
+	`, runID, namespace)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// RecordMetricAlert records that a run's metric crossed its namespace's alert
+// values, returning false if it already had
+func (d *PostgresDAO) RecordMetricAlert(ctx context.Context, runID int, key string, value float64, at time.Time) (bool, error) {
+	result, err := d.db.ExecContext(ctx,
+		`INSERT INTO metric_alerts (run_id, key, value, alerted_at) VALUES ($1, $2, $3, $4)
+		 ON CONFLICT (run_id, key) DO NOTHING`,
+		runID, key, value, at.UTC(),
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// SetExperimentQuota sets the limits on an experiment's usage, replacing
+// any it had
+func (d *PostgresDAO) SetExperimentQuota(ctx context.Context, experimentID int, quota ExperimentQuotaRow) error {
+	_, err := d.db.ExecContext(ctx, `
+		INSERT INTO experiment_quotas (experiment_id, max_runs, max_metric_points_per_day, max_artifact_bytes, updated_at)
+		VALUES ($1, $2, $3, $4, CURRENT_TIMESTAMP)
+		ON CONFLICT (experiment_id) DO UPDATE SET
+			max_runs = EXCLUDED.max_runs,
+			max_metric_points_per_day = EXCLUDED.max_metric_points_per_day,
+			max_artifact_bytes = EXCLUDED.max_artifact_bytes,
+			updated_at = EXCLUDED.updated_at
+	`, experimentID, quota.MaxRuns, quota.MaxMetricPointsPerDay, quota.MaxArtifactBytes)
+	return err
+}
+
+// DeleteExperimentQuota removes the limits set on an experiment, returning
+// whether it had any
+func (d *PostgresDAO) DeleteExperimentQuota(ctx context.Context, experimentID int) (bool, error) {
+	result, err := d.db.ExecContext(ctx, "DELETE FROM experiment_quotas WHERE experiment_id = $1", experimentID)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// GetExperimentQuota retrieves the limits set on an experiment, or nil if it
+// has none
+func (d *PostgresDAO) GetExperimentQuota(ctx context.Context, experimentID int) (*ExperimentQuotaRow, error) {
+	var quota ExperimentQuotaRow
+	err := d.db.QueryRowContext(ctx,
+		"SELECT max_runs, max_metric_points_per_day, max_artifact_bytes FROM experiment_quotas WHERE experiment_id = $1",
+		experimentID,
+	).Scan(&quota.MaxRuns, &quota.MaxMetricPointsPerDay, &quota.MaxArtifactBytes)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &quota, nil
+}
+
+// GetExperimentUsage measures an experiment's usage, counting the metric
+// points logged to it on day
+func (d *PostgresDAO) GetExperimentUsage(ctx context.Context, experimentID int, day string) (*ExperimentUsageRow, error) {
+	var usage ExperimentUsageRow
+	err := d.db.QueryRowContext(ctx, `
+		SELECT
+			(SELECT COUNT(*) FROM runs WHERE experiment_id = $1 AND deleted_at IS NULL),
+			COALESCE((SELECT metric_points FROM experiment_metric_usage WHERE experiment_id = $1 AND day = $2), 0),
+			COALESCE((SELECT SUM(a.size_bytes) FROM artifacts a JOIN runs r ON r.id = a.run_id WHERE r.experiment_id = $1), 0) +
+			COALESCE((SELECT SUM(v.size_bytes) FROM artifact_versions v JOIN runs r ON r.id = v.run_id WHERE r.experiment_id = $1), 0)
+	`, experimentID, day).Scan(&usage.Runs, &usage.MetricPoints, &usage.ArtifactBytes)
+	if err != nil {
+		return nil, err
+	}
+	return &usage, nil
+}
+
+// AddExperimentMetricPoints counts metric points logged to an experiment on day
+func (d *PostgresDAO) AddExperimentMetricPoints(ctx context.Context, experimentID int, day string, points int) error {
+	_, err := d.db.ExecContext(ctx, `
+		INSERT INTO experiment_metric_usage (experiment_id, day, metric_points) VALUES ($1, $2, $3)
+		ON CONFLICT (experiment_id, day) DO UPDATE SET metric_points = experiment_metric_usage.metric_points + EXCLUDED.metric_points
+	`, experimentID, day, points)
+	return err
+}
+
+// GetRunExperimentID retrieves the ID of the experiment a run belongs to
+func (d *PostgresDAO) GetRunExperimentID(ctx context.Context, runID int) (int, error) {
+	var experimentID int
+	err := d.db.QueryRowContext(ctx, "SELECT experiment_id FROM runs WHERE id = $1", runID).Scan(&experimentID)
+	return experimentID, err
+}
+
+// InsertRunDependency records that a run consumed something produced by an upstream run
+func (d *PostgresDAO) InsertRunDependency(ctx context.Context, runID, upstreamRunID int, kind, artifactPath string) error {
+	_, err := d.db.ExecContext(ctx,
+		"INSERT INTO run_dependencies (run_id, upstream_run_id, kind, artifact_path) VALUES ($1, $2, $3, $4)",
+		runID, upstreamRunID, kind, artifactPath,
+	)
+	return err
+}
+
+// DeleteRunDependency removes a run dependency
+func (d *PostgresDAO) DeleteRunDependency(ctx context.Context, id int) error {
+	_, err := d.db.ExecContext(ctx, "DELETE FROM run_dependencies WHERE id = $1", id)
+	return err
+}
+
+// GetRunDependencyEdges retrieves the dependencies a run is on either end of, oldest first
+func (d *PostgresDAO) GetRunDependencyEdges(ctx context.Context, runID int) ([]RunDependencyRow, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT d.id, d.run_id, r.uuid, r.name, d.upstream_run_id, u.uuid, u.name,
+			d.kind, d.artifact_path, d.created_at
+		FROM run_dependencies d
+		JOIN runs r ON r.id = d.run_id
+		JOIN runs u ON u.id = d.upstream_run_id
+		WHERE d.run_id = $1 OR d.upstream_run_id = $1
+		ORDER BY d.id
+	`, runID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var edges []RunDependencyRow
+	for rows.Next() {
+		var e RunDependencyRow
+		err := rows.Scan(&e.ID, &e.RunID, &e.RunUUID, &e.RunName, &e.UpstreamRunID, &e.UpstreamUUID, &e.UpstreamName,
+			&e.Kind, &e.ArtifactPath, &e.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		edges = append(edges, e)
+	}
+
+	return edges, rows.Err()
+}
+
+// UpsertConfusionMatrix saves a confusion matrix, replacing any logged by the run under the same key and step
+func (d *PostgresDAO) UpsertConfusionMatrix(ctx context.Context, m ConfusionMatrixRow) error {
+	_, err := d.db.ExecContext(ctx,
+		`
+		INSERT INTO confusion_matrices (run_id, key, step, labels, matrix, logged_at) VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (run_id, key, step) DO UPDATE SET labels = EXCLUDED.labels, matrix = EXCLUDED.matrix, logged_at = EXCLUDED.logged_at
+	`,
+		m.RunID, m.Key, m.Step, m.Labels, m.Matrix, time.Now().UTC(),
+	)
+	return err
+}
+
+// GetConfusionMatricesByRunID retrieves the confusion matrices of a run, ordered by key and step
+func (d *PostgresDAO) GetConfusionMatricesByRunID(ctx context.Context, runID int) ([]ConfusionMatrixRow, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT run_id, key, step, labels, matrix, logged_at
+		FROM confusion_matrices
+		WHERE run_id = $1
+		ORDER BY key, step
+	`, runID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var matrices []ConfusionMatrixRow
+	for rows.Next() {
+		var m ConfusionMatrixRow
+		if err := rows.Scan(&m.RunID, &m.Key, &m.Step, &m.Labels, &m.Matrix, &m.LoggedAt); err != nil {
+			return nil, err
+		}
+		matrices = append(matrices, m)
+	}
+
+	return matrices, rows.Err()
+}
+
+// UpsertRunCurve saves a curve, replacing any logged by the run under the same key and step
+func (d *PostgresDAO) UpsertRunCurve(ctx context.Context, c RunCurveRow) error {
+	_, err := d.db.ExecContext(ctx,
+		`
+		INSERT INTO run_curves (run_id, key, kind, step, points, auc, logged_at) VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (run_id, key, step) DO UPDATE SET kind = EXCLUDED.kind, points = EXCLUDED.points, auc = EXCLUDED.auc, logged_at = EXCLUDED.logged_at
+	`,
+		c.RunID, c.Key, c.Kind, c.Step, c.Points, c.AUC, time.Now().UTC(),
+	)
+	return err
+}
+
+// GetRunCurvesByRunID retrieves the curves of a run, ordered by key and step
+func (d *PostgresDAO) GetRunCurvesByRunID(ctx context.Context, runID int) ([]RunCurveRow, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT run_id, key, kind, step, points, auc, logged_at
+		FROM run_curves
+		WHERE run_id = $1
+		ORDER BY key, step
+	`, runID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var curves []RunCurveRow
+	for rows.Next() {
+		var c RunCurveRow
+		if err := rows.Scan(&c.RunID, &c.Key, &c.Kind, &c.Step, &c.Points, &c.AUC, &c.LoggedAt); err != nil {
+			return nil, err
+		}
+		curves = append(curves, c)
+	}
+
+	return curves, rows.Err()
+}
+
+// UpdateRunStatus sets the status of a run and the message it ended with, recording when it finished if the status is terminal
+func (d *PostgresDAO) UpdateRunStatus(ctx context.Context, runID int, status, message string) error {
+	var finishedAt *time.Time
+	if status != runStatusRunning {
+		now := time.Now().UTC()
+		finishedAt = &now
+	}
+	_, err := d.db.ExecContext(ctx,
+		"UPDATE runs SET status = $1, finished_at = $2, status_message = $3 WHERE id = $4",
+		status, finishedAt, sql.NullString{String: message, Valid: message != ""}, runID,
+	)
+	return err
+}
+
+// RecordRunActivity records that a run logged something at the given time
+func (d *PostgresDAO) RecordRunActivity(ctx context.Context, runID int, at time.Time) error {
+	_, err := d.db.ExecContext(ctx, "UPDATE runs SET last_activity_at = $1 WHERE id = $2", at, runID)
+	return err
+}
+
+// FailStaleRuns marks FAILED every running run that has logged nothing since
+// cutoff, or was created before it and never logged, returning their UUIDs
+func (d *PostgresDAO) FailStaleRuns(ctx context.Context, cutoff time.Time) ([]string, error) {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id, uuid
+		FROM runs
+		WHERE status = $1 AND COALESCE(last_activity_at, created_at) < $2 AND deleted_at IS NULL
+	`, runStatusRunning, cutoff)
+	if err != nil {
+		return nil, err
+	}
+	var ids []int
+	var uuids []string
+	for rows.Next() {
+		var id int
+		var uuid string
+		if err := rows.Scan(&id, &uuid); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+		uuids = append(uuids, uuid)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	for _, id := range ids {
+		if _, err := tx.ExecContext(ctx,
+			"UPDATE runs SET status = $1, finished_at = $2, status_message = $3 WHERE id = $4",
+			runStatusFailed, now, runStaleStatusMessage, id,
+		); err != nil {
+			return nil, err
+		}
+	}
+	return uuids, tx.Commit()
+}
+
+// UpsertTextSamples inserts or replaces the text samples a run logged under a key at a step
+func (d *PostgresDAO) UpsertTextSamples(ctx context.Context, s TextSamplesRow) error {
+	_, err := d.db.ExecContext(ctx,
+		`
+		INSERT INTO text_samples (run_id, key, step, samples, logged_at) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (run_id, key, step) DO UPDATE SET samples = EXCLUDED.samples, logged_at = EXCLUDED.logged_at
+	`,
+		s.RunID, s.Key, s.Step, s.Samples, time.Now().UTC(),
+	)
+	return err
+}
+
+// GetTextSampleStepsByRunID retrieves the keys and steps of a run's text samples, without the samples, ordered by key and step
+func (d *PostgresDAO) GetTextSampleStepsByRunID(ctx context.Context, runID int) ([]TextSamplesRow, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT run_id, key, step, logged_at
+		FROM text_samples
+		WHERE run_id = $1
+		ORDER BY key, step
+	`, runID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var steps []TextSamplesRow
+	for rows.Next() {
+		var s TextSamplesRow
+		if err := rows.Scan(&s.RunID, &s.Key, &s.Step, &s.LoggedAt); err != nil {
+			return nil, err
+		}
+		steps = append(steps, s)
+	}
+
+	return steps, rows.Err()
+}
+
+// GetTextSamples retrieves the text samples a run logged under a key at a step, or nil if there are none
+func (d *PostgresDAO) GetTextSamples(ctx context.Context, runID int, key string, step float64) (*TextSamplesRow, error) {
+	var s TextSamplesRow
+	err := d.db.QueryRowContext(ctx, `
+		SELECT run_id, key, step, samples, logged_at
+		FROM text_samples
+		WHERE run_id = $1 AND key = $2 AND step = $3
+	`, runID, key, step).Scan(&s.RunID, &s.Key, &s.Step, &s.Samples, &s.LoggedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// GetArtifactsToMirror retrieves artifacts pending replication to the mirror store, including failed ones with attempts remaining
+func (d *PostgresDAO) GetArtifactsToMirror(ctx context.Context, maxAttempts, limit int) ([]ArtifactMirrorRow, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT run_id, path, uri, updated_at, mirror_attempts
+		FROM artifacts
+		WHERE mirror_status = 'pending' OR (mirror_status = 'failed' AND mirror_attempts < $1)
+		ORDER BY mirror_attempts, id
+		LIMIT $2
+	`, maxAttempts, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var artifacts []ArtifactMirrorRow
+	for rows.Next() {
+		var m ArtifactMirrorRow
+		if err := rows.Scan(&m.RunID, &m.Path, &m.URI, &m.UpdatedAt, &m.Attempts); err != nil {
+			return nil, err
+		}
+		artifacts = append(artifacts, m)
+	}
+
+	return artifacts, rows.Err()
+}
+
+// SetArtifactMirrorStatus records the outcome of an attempt to mirror an artifact, unless it has since been overwritten
+func (d *PostgresDAO) SetArtifactMirrorStatus(ctx context.Context, m ArtifactMirrorRow, status, mirrorError string) error {
+	var mirroredAt sql.NullTime
+	if status == artifactMirrorMirrored {
+		mirroredAt = sql.NullTime{Time: time.Now().UTC(), Valid: true}
+	}
+	_, err := d.db.ExecContext(ctx, `
+		UPDATE artifacts
+		SET mirror_status = $1, mirror_attempts = $2, mirror_error = $3, mirrored_at = $4
+		WHERE run_id = $5 AND path = $6 AND updated_at IS NOT DISTINCT FROM $7
+	`, status, m.Attempts+1, sql.NullString{String: mirrorError, Valid: mirrorError != ""}, mirroredAt, m.RunID, m.Path, m.UpdatedAt)
+	return err
+}
+
+// GetArtifactMirrorStatusByURI retrieves the mirror status of the artifact recorded under a URI, or "" if there is none
+func (d *PostgresDAO) GetArtifactMirrorStatusByURI(ctx context.Context, uri string) (string, error) {
+	var status string
+	err := d.db.QueryRowContext(ctx, "SELECT mirror_status FROM artifacts WHERE uri = $1 LIMIT 1", uri).Scan(&status)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return status, err
+}
+
+// GetArtifactsToScan retrieves artifacts and prior versions awaiting a malware scan, least attempted first
+func (d *PostgresDAO) GetArtifactsToScan(ctx context.Context, limit int) ([]ArtifactScanRow, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT uri, scan_attempts FROM artifacts WHERE scan_status = 'pending'
+		UNION ALL
+		SELECT uri, scan_attempts FROM artifact_versions WHERE scan_status = 'pending'
+		ORDER BY scan_attempts
+		LIMIT $1
+	`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var artifacts []ArtifactScanRow
+	for rows.Next() {
+		var a ArtifactScanRow
+		if err := rows.Scan(&a.URI, &a.Attempts); err != nil {
+			return nil, err
+		}
+		artifacts = append(artifacts, a)
+	}
+
+	return artifacts, rows.Err()
+}
+
+// RecordArtifactScan records the outcome of an attempt to scan the contents stored under a URI, unless an admin has since decided it
+func (d *PostgresDAO) RecordArtifactScan(ctx context.Context, uri, status, result string) error {
+	for _, table := range []string{"artifacts", "artifact_versions"} {
+		_, err := d.db.ExecContext(ctx, `
+			UPDATE `+table+`
+			SET scan_status = $1, scan_result = $2, scan_attempts = scan_attempts + 1, scanned_at = $3
+			WHERE uri = $4 AND scan_status = 'pending'
+		`, status, sql.NullString{String: result, Valid: result != ""}, time.Now().UTC(), uri)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetArtifactScanStatus sets the scan status of the contents stored under a URI, e.g. to release them from quarantine or have them scanned again
+func (d *PostgresDAO) SetArtifactScanStatus(ctx context.Context, uri, status, result string) error {
+	var scannedAt sql.NullTime
+	if status != artifactScanPending {
+		scannedAt = sql.NullTime{Time: time.Now().UTC(), Valid: true}
+	}
+	for _, table := range []string{"artifacts", "artifact_versions"} {
+		_, err := d.db.ExecContext(ctx, `
+			UPDATE `+table+`
+			SET scan_status = $1, scan_result = $2, scan_attempts = 0, scanned_at = $3
+			WHERE uri = $4
+		`, status, sql.NullString{String: result, Valid: result != ""}, scannedAt, uri)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetArtifactScanStatusByURI retrieves the scan status and result of the contents stored under a URI, or "" if no artifact is
+func (d *PostgresDAO) GetArtifactScanStatusByURI(ctx context.Context, uri string) (string, string, error) {
+	var status, result string
+	err := d.db.QueryRowContext(ctx, `
+		SELECT scan_status, COALESCE(scan_result, '') FROM artifacts WHERE uri = $1
+		UNION ALL
+		SELECT scan_status, COALESCE(scan_result, '') FROM artifact_versions WHERE uri = $1
+		LIMIT 1
+	`, uri).Scan(&status, &result)
+	if err == sql.ErrNoRows {
+		return "", "", nil
+	}
+	return status, result, err
+}
+
+// SetRunTag tags a run, replacing the tag's value if it is already set
+func (d *PostgresDAO) SetRunTag(ctx context.Context, runID int, key, value string) error {
+	_, err := d.db.ExecContext(ctx,
+		`INSERT INTO tags (run_id, key, value) VALUES ($1, $2, $3)
+		 ON CONFLICT (run_id, key) DO UPDATE SET value = EXCLUDED.value`,
+		runID, key, value,
+	)
+	return err
+}
+
+// GetRunTags retrieves a run's tags ordered by key
+func (d *PostgresDAO) GetRunTags(ctx context.Context, runID int) ([]RunTagRow, error) {
+	rows, err := d.db.QueryContext(ctx, "SELECT key, value FROM tags WHERE run_id = $1 ORDER BY key", runID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tags []RunTagRow
+	for rows.Next() {
+		var t RunTagRow
+		if err := rows.Scan(&t.Key, &t.Value); err != nil {
+			return nil, err
+		}
+		tags = append(tags, t)
+	}
+
+	return tags, rows.Err()
+}
+
+// DeleteRunTag removes a tag from a run, reporting whether it was set
+func (d *PostgresDAO) DeleteRunTag(ctx context.Context, runID int, key string) (bool, error) {
+	result, err := d.db.ExecContext(ctx, "DELETE FROM tags WHERE run_id = $1 AND key = $2", runID, key)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// InsertAPIToken saves a new API token by the hash of its secret, scoped to
+// a project unless projectID is null, and issued to a user unless userID is
+// null
+func (d *PostgresDAO) InsertAPIToken(ctx context.Context, name, tokenHash string, projectID, userID sql.NullInt64) error {
+	_, err := d.db.ExecContext(ctx, "INSERT INTO api_tokens (name, token_hash, project_id, user_id) VALUES ($1, $2, $3, $4)", name, tokenHash, projectID, userID)
+	return err
+}
+
+// InsertKioskToken saves a new kiosk token, which shows an experiment's
+// wallboard
+func (d *PostgresDAO) InsertKioskToken(ctx context.Context, name, tokenHash string, experimentID int) error {
+	_, err := d.db.ExecContext(ctx, "INSERT INTO api_tokens (name, token_hash, kiosk_experiment_id) VALUES ($1, $2, $3)", name, tokenHash, experimentID)
+	return err
+}
+
+// GetAPITokenByHash retrieves the API token with a hash, or nil if there is none
+func (d *PostgresDAO) GetAPITokenByHash(ctx context.Context, tokenHash string) (*APITokenRow, error) {
+	var t APITokenRow
+	err := d.db.QueryRowContext(ctx,
+		"SELECT id, name, token_hash, created_at, revoked_at, project_id, user_id, kiosk_experiment_id FROM api_tokens WHERE token_hash = $1",
+		tokenHash,
+	).Scan(&t.ID, &t.Name, &t.TokenHash, &t.CreatedAt, &t.RevokedAt, &t.ProjectID, &t.UserID, &t.KioskExperimentID)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// GetAllAPITokens retrieves all API tokens, including revoked ones, ordered by name
+func (d *PostgresDAO) GetAllAPITokens(ctx context.Context) ([]APITokenRow, error) {
+	rows, err := d.db.QueryContext(ctx, "SELECT id, name, token_hash, created_at, revoked_at, project_id, user_id, kiosk_experiment_id FROM api_tokens ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []APITokenRow
+	for rows.Next() {
+		var t APITokenRow
+		if err := rows.Scan(&t.ID, &t.Name, &t.TokenHash, &t.CreatedAt, &t.RevokedAt, &t.ProjectID, &t.UserID, &t.KioskExperimentID); err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, t)
+	}
+	return tokens, rows.Err()
+}
+
+// RevokeAPIToken revokes the API token with a name, returning false if there
+// is no such unrevoked token
+func (d *PostgresDAO) RevokeAPIToken(ctx context.Context, name string) (bool, error) {
+	result, err := d.db.ExecContext(ctx,
+		"UPDATE api_tokens SET revoked_at = $1 WHERE name = $2 AND revoked_at IS NULL",
+		time.Now().UTC(), name,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// InsertUser saves a new user with a role
+func (d *PostgresDAO) InsertUser(ctx context.Context, name, role string) error {
+	_, err := d.db.ExecContext(ctx, "INSERT INTO users (name, role) VALUES ($1, $2)", name, role)
+	return err
+}
+
+// GetUserByID retrieves a user by ID, or nil if there is none
+func (d *PostgresDAO) GetUserByID(ctx context.Context, id int) (*UserRow, error) {
+	var u UserRow
+	err := d.db.QueryRowContext(ctx, "SELECT id, name, role, created_at FROM users WHERE id = $1", id).Scan(&u.ID, &u.Name, &u.Role, &u.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// GetUserByName retrieves a user by name, or nil if there is none
+func (d *PostgresDAO) GetUserByName(ctx context.Context, name string) (*UserRow, error) {
+	var u UserRow
+	err := d.db.QueryRowContext(ctx, "SELECT id, name, role, created_at FROM users WHERE name = $1", name).Scan(&u.ID, &u.Name, &u.Role, &u.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// GetAllUsers retrieves all users, ordered by name
+func (d *PostgresDAO) GetAllUsers(ctx context.Context) ([]UserRow, error) {
+	rows, err := d.db.QueryContext(ctx, "SELECT id, name, role, created_at FROM users ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []UserRow
+	for rows.Next() {
+		var u UserRow
+		if err := rows.Scan(&u.ID, &u.Name, &u.Role, &u.CreatedAt); err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+// SetUserRole changes a user's role
+func (d *PostgresDAO) SetUserRole(ctx context.Context, id int, role string) error {
+	_, err := d.db.ExecContext(ctx, "UPDATE users SET role = $1 WHERE id = $2", role, id)
+	return err
+}
+
+// DeleteUser removes a user, revoking the tokens issued to them
+func (d *PostgresDAO) DeleteUser(ctx context.Context, id int) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "UPDATE api_tokens SET revoked_at = $1 WHERE user_id = $2 AND revoked_at IS NULL", time.Now().UTC(), id); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM users WHERE id = $1", id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ReplaceRunEnvironment replaces a run's environment variable snapshot
+func (d *PostgresDAO) ReplaceRunEnvironment(ctx context.Context, runID int, variables []EnvironmentVariableRow) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM run_environment WHERE run_id = $1", runID); err != nil {
+		return err
+	}
+	for _, v := range variables {
+		_, err := tx.ExecContext(ctx,
+			"INSERT INTO run_environment (run_id, key, value, redacted) VALUES ($1, $2, $3, $4)",
+			runID, v.Key, v.Value, v.Redacted,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// GetRunEnvironment retrieves a run's environment variables ordered by key
+func (d *PostgresDAO) GetRunEnvironment(ctx context.Context, runID int) ([]EnvironmentVariableRow, error) {
+	rows, err := d.db.QueryContext(ctx, "SELECT key, value, redacted FROM run_environment WHERE run_id = $1 ORDER BY key", runID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var variables []EnvironmentVariableRow
+	for rows.Next() {
+		var v EnvironmentVariableRow
+		if err := rows.Scan(&v.Key, &v.Value, &v.Redacted); err != nil {
+			return nil, err
+		}
+		variables = append(variables, v)
+	}
+	return variables, rows.Err()
+}
+
+// UpsertBestCheckpointRule sets the rule an experiment's runs choose their best checkpoint by
+func (d *PostgresDAO) UpsertBestCheckpointRule(ctx context.Context, experimentID int, rule BestCheckpointRuleRow) error {
+	_, err := d.db.ExecContext(ctx,
+		`INSERT INTO best_checkpoint_rules (experiment_id, metric_key, mode, artifact_pattern)
+		 VALUES ($1, $2, $3, $4)
+		 ON CONFLICT (experiment_id) DO UPDATE
+		 SET metric_key = EXCLUDED.metric_key, mode = EXCLUDED.mode, artifact_pattern = EXCLUDED.artifact_pattern`,
+		experimentID, rule.MetricKey, rule.Mode, rule.ArtifactPattern,
+	)
+	return err
+}
+
+// DeleteBestCheckpointRule removes an experiment's best checkpoint rule
+func (d *PostgresDAO) DeleteBestCheckpointRule(ctx context.Context, experimentID int) error {
+	_, err := d.db.ExecContext(ctx, "DELETE FROM best_checkpoint_rules WHERE experiment_id = $1", experimentID)
+	return err
+}
+
+// GetBestCheckpointRule retrieves an experiment's best checkpoint rule, or nil if it has none
+func (d *PostgresDAO) GetBestCheckpointRule(ctx context.Context, experimentID int) (*BestCheckpointRuleRow, error) {
+	var rule BestCheckpointRuleRow
+	err := d.db.QueryRowContext(ctx,
+		"SELECT metric_key, mode, artifact_pattern FROM best_checkpoint_rules WHERE experiment_id = $1",
+		experimentID,
+	).Scan(&rule.MetricKey, &rule.Mode, &rule.ArtifactPattern)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &rule, nil
+}
+
+// SetRunBestCheckpoint designates a run's best checkpoint, replacing any other
+func (d *PostgresDAO) SetRunBestCheckpoint(ctx context.Context, c RunBestCheckpointRow) error {
+	_, err := d.db.ExecContext(ctx,
+		`INSERT INTO run_best_checkpoints (run_id, path, source, metric_key, metric_value, designated_at)
+		 VALUES ($1, $2, $3, $4, $5, $6)
+		 ON CONFLICT (run_id) DO UPDATE
+		 SET path = EXCLUDED.path, source = EXCLUDED.source, metric_key = EXCLUDED.metric_key,
+		     metric_value = EXCLUDED.metric_value, designated_at = EXCLUDED.designated_at`,
+		c.RunID, c.Path, c.Source, c.MetricKey, c.MetricValue, c.DesignatedAt,
+	)
+	return err
+}
+
+// ClearRunBestCheckpoint removes a run's best checkpoint designation,
+// reporting whether it had one
+func (d *PostgresDAO) ClearRunBestCheckpoint(ctx context.Context, runID int) (bool, error) {
+	result, err := d.db.ExecContext(ctx, "DELETE FROM run_best_checkpoints WHERE run_id = $1", runID)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// GetRunBestCheckpoint retrieves a run's best checkpoint, or nil if it has none
+func (d *PostgresDAO) GetRunBestCheckpoint(ctx context.Context, runID int) (*RunBestCheckpointRow, error) {
+	var c RunBestCheckpointRow
+	err := d.db.QueryRowContext(ctx,
+		"SELECT run_id, path, source, metric_key, metric_value, designated_at FROM run_best_checkpoints WHERE run_id = $1",
+		runID,
+	).Scan(&c.RunID, &c.Path, &c.Source, &c.MetricKey, &c.MetricValue, &c.DesignatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// GetRunBestCheckpointsByExperimentID retrieves the best checkpoints of every run in an experiment
+func (d *PostgresDAO) GetRunBestCheckpointsByExperimentID(ctx context.Context, experimentID int) ([]RunBestCheckpointRow, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT c.run_id, c.path, c.source, c.metric_key, c.metric_value, c.designated_at
+		FROM run_best_checkpoints c
+		JOIN runs r ON r.id = c.run_id
+		WHERE r.experiment_id = $1
+	`, experimentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var checkpoints []RunBestCheckpointRow
+	for rows.Next() {
+		var c RunBestCheckpointRow
+		if err := rows.Scan(&c.RunID, &c.Path, &c.Source, &c.MetricKey, &c.MetricValue, &c.DesignatedAt); err != nil {
+			return nil, err
+		}
+		checkpoints = append(checkpoints, c)
+	}
+	return checkpoints, rows.Err()
+}
+
+// GetAllArtifactURIs retrieves the URI of every artifact, including prior
+// versions
+func (d *PostgresDAO) GetAllArtifactURIs(ctx context.Context) ([]string, error) {
+	rows, err := d.db.QueryContext(ctx, "SELECT uri FROM artifacts UNION SELECT uri FROM artifact_versions")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var uris []string
+	for rows.Next() {
+		var uri string
+		if err := rows.Scan(&uri); err != nil {
+			return nil, err
+		}
+		uris = append(uris, uri)
+	}
+	return uris, rows.Err()
+}
+
+// RelocateArtifactURI rewrites the URI of every artifact and prior version
+// recorded under from to to, returning how many it rewrote
+func (d *PostgresDAO) RelocateArtifactURI(ctx context.Context, from, to string) (int, error) {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	relocated := 0
+	for _, table := range []string{"artifacts", "artifact_versions"} {
+		result, err := tx.ExecContext(ctx, `UPDATE `+table+` SET uri = $1 WHERE uri = $2`, to, from)
+		if err != nil {
+			return 0, err
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return 0, err
+		}
+		relocated += int(n)
+	}
+	return relocated, tx.Commit()
+}
+
+// InsertArtifactUpload records a chunked artifact upload in progress
+func (d *PostgresDAO) InsertArtifactUpload(ctx context.Context, u ArtifactUploadRow) error {
+	_, err := d.db.ExecContext(ctx, `
+		INSERT INTO artifact_uploads (uuid, run_id, path, size_bytes, sha256, chunk_size, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	`, u.UUID, u.RunID, u.Path, u.SizeBytes, u.SHA256, u.ChunkSize, u.CreatedAt, u.UpdatedAt)
+	return err
+}
+
+// GetArtifactUpload retrieves a chunked artifact upload by UUID
+func (d *PostgresDAO) GetArtifactUpload(ctx context.Context, uuid string) (*ArtifactUploadRow, error) {
+	var u ArtifactUploadRow
+	err := d.db.QueryRowContext(ctx,
+		"SELECT uuid, run_id, path, size_bytes, sha256, chunk_size, created_at, updated_at FROM artifact_uploads WHERE uuid = $1",
+		uuid,
+	).Scan(&u.UUID, &u.RunID, &u.Path, &u.SizeBytes, &u.SHA256, &u.ChunkSize, &u.CreatedAt, &u.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// FindArtifactUpload retrieves the most recent chunked upload of the same
+// contents to an artifact's path, or nil if there is none, so that an
+// interrupted upload can be resumed
+func (d *PostgresDAO) FindArtifactUpload(ctx context.Context, runID int, path, sha256 string, sizeBytes int64) (*ArtifactUploadRow, error) {
+	var u ArtifactUploadRow
+	err := d.db.QueryRowContext(ctx, `
+		SELECT uuid, run_id, path, size_bytes, sha256, chunk_size, created_at, updated_at
+		FROM artifact_uploads
+		WHERE run_id = $1 AND path = $2 AND sha256 = $3 AND size_bytes = $4
+		ORDER BY updated_at DESC
+		LIMIT 1
+	`, runID, path, sha256, sizeBytes).Scan(&u.UUID, &u.RunID, &u.Path, &u.SizeBytes, &u.SHA256, &u.ChunkSize, &u.CreatedAt, &u.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// TouchArtifactUpload records that a chunk of an upload was received
+func (d *PostgresDAO) TouchArtifactUpload(ctx context.Context, uuid string, at time.Time) error {
+	_, err := d.db.ExecContext(ctx, "UPDATE artifact_uploads SET updated_at = $1 WHERE uuid = $2", at, uuid)
+	return err
+}
+
+// DeleteArtifactUpload removes a chunked artifact upload
+func (d *PostgresDAO) DeleteArtifactUpload(ctx context.Context, uuid string) error {
+	_, err := d.db.ExecContext(ctx, "DELETE FROM artifact_uploads WHERE uuid = $1", uuid)
+	return err
+}
+
+// GetArtifactUploads retrieves every chunked artifact upload in progress,
+// oldest first
+func (d *PostgresDAO) GetArtifactUploads(ctx context.Context) ([]ArtifactUploadRow, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT uuid, run_id, path, size_bytes, sha256, chunk_size, created_at, updated_at
+		FROM artifact_uploads
+		ORDER BY created_at, id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var uploads []ArtifactUploadRow
+	for rows.Next() {
+		var u ArtifactUploadRow
+		if err := rows.Scan(&u.UUID, &u.RunID, &u.Path, &u.SizeBytes, &u.SHA256, &u.ChunkSize, &u.CreatedAt, &u.UpdatedAt); err != nil {
+			return nil, err
+		}
+		uploads = append(uploads, u)
+	}
+	return uploads, rows.Err()
+}
+
+// InsertHousekeepingReport records a housekeeping report, returning its ID
+func (d *PostgresDAO) InsertHousekeepingReport(ctx context.Context, r HousekeepingReportRow) (int, error) {
+	var id int
+	err := d.db.QueryRowContext(ctx, `
+		INSERT INTO housekeeping_reports (job, dry_run, uri, item_count, run_count, size_bytes, plan_id, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id
+	`, r.Job, r.DryRun, r.URI, r.ItemCount, r.RunCount, r.SizeBytes, r.PlanID, r.CreatedAt).Scan(&id)
+	return id, err
+}
+
+// GetHousekeepingReport retrieves a housekeeping report, or nil if there is none
+func (d *PostgresDAO) GetHousekeepingReport(ctx context.Context, id int) (*HousekeepingReportRow, error) {
+	var r HousekeepingReportRow
+	err := d.db.QueryRowContext(ctx, `
+		SELECT id, job, dry_run, uri, item_count, run_count, size_bytes, plan_id, created_at, executed_at
+		FROM housekeeping_reports WHERE id = $1
+	`, id).Scan(&r.ID, &r.Job, &r.DryRun, &r.URI, &r.ItemCount, &r.RunCount, &r.SizeBytes, &r.PlanID, &r.CreatedAt, &r.ExecutedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// GetHousekeepingReports retrieves the most recent housekeeping reports, newest first
+func (d *PostgresDAO) GetHousekeepingReports(ctx context.Context, limit int) ([]HousekeepingReportRow, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT id, job, dry_run, uri, item_count, run_count, size_bytes, plan_id, created_at, executed_at
+		FROM housekeeping_reports
+		ORDER BY created_at DESC, id DESC
+		LIMIT $1
+	`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var reports []HousekeepingReportRow
+	for rows.Next() {
+		var r HousekeepingReportRow
+		if err := rows.Scan(&r.ID, &r.Job, &r.DryRun, &r.URI, &r.ItemCount, &r.RunCount, &r.SizeBytes, &r.PlanID, &r.CreatedAt, &r.ExecutedAt); err != nil {
+			return nil, err
+		}
+		reports = append(reports, r)
+	}
+	return reports, rows.Err()
+}
+
+// MarkHousekeepingReportExecuted records that a dry run was carried out,
+// returning false if it already had been
+func (d *PostgresDAO) MarkHousekeepingReportExecuted(ctx context.Context, id int, executedAt time.Time) (bool, error) {
+	result, err := d.db.ExecContext(ctx,
+		"UPDATE housekeeping_reports SET executed_at = $1 WHERE id = $2 AND dry_run AND executed_at IS NULL",
+		executedAt, id,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// AcquireJobLease takes or renews the lease on a background job for holder
+// until now+ttl, returning false if another holder's lease has not expired
+func (d *PostgresDAO) AcquireJobLease(ctx context.Context, job, holder string, now time.Time, ttl time.Duration) (bool, error) {
+	result, err := d.db.ExecContext(ctx, `
+		INSERT INTO job_leases (job, holder, expires_at) VALUES ($1, $2, $3)
+		ON CONFLICT (job) DO UPDATE SET holder = EXCLUDED.holder, expires_at = EXCLUDED.expires_at
+		WHERE job_leases.holder = EXCLUDED.holder OR job_leases.expires_at < $4
+	`, job, holder, now.Add(ttl), now)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// GetCacheGeneration retrieves the generation of a cache, which is 0 until
+// the cache is first invalidated
+func (d *PostgresDAO) GetCacheGeneration(ctx context.Context, name string) (int64, error) {
+	var generation int64
+	err := d.db.QueryRowContext(ctx, "SELECT generation FROM cache_generations WHERE name = $1", name).Scan(&generation)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return generation, err
+}
+
+// BumpCacheGeneration invalidates a cache on every replica
+func (d *PostgresDAO) BumpCacheGeneration(ctx context.Context, name string) error {
+	_, err := d.db.ExecContext(ctx, `
+		INSERT INTO cache_generations (name, generation) VALUES ($1, 1)
+		ON CONFLICT (name) DO UPDATE SET generation = cache_generations.generation + 1
+	`, name)
+	return err
+}
+
+// InsertArtifactCacheInvalidation records that the artifact stored at uri
+// was written or deleted, for the other replicas to drop from their caches
+func (d *PostgresDAO) InsertArtifactCacheInvalidation(ctx context.Context, uri string, at time.Time) error {
+	_, err := d.db.ExecContext(ctx, "INSERT INTO artifact_cache_invalidations (uri, invalidated_at) VALUES ($1, $2)", uri, at)
+	return err
+}
+
+// GetArtifactCacheInvalidations retrieves the artifact cache invalidations
+// recorded at or after since, oldest first
+func (d *PostgresDAO) GetArtifactCacheInvalidations(ctx context.Context, since time.Time) ([]ArtifactCacheInvalidationRow, error) {
+	rows, err := d.db.QueryContext(ctx, "SELECT id, uri, invalidated_at FROM artifact_cache_invalidations WHERE invalidated_at >= $1 ORDER BY invalidated_at, id", since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var invalidations []ArtifactCacheInvalidationRow
+	for rows.Next() {
+		var inv ArtifactCacheInvalidationRow
+		if err := rows.Scan(&inv.ID, &inv.URI, &inv.InvalidatedAt); err != nil {
+			return nil, err
+		}
+		invalidations = append(invalidations, inv)
+	}
+	return invalidations, rows.Err()
+}
+
+// DeleteArtifactCacheInvalidations deletes the artifact cache invalidations
+// recorded before a time
+func (d *PostgresDAO) DeleteArtifactCacheInvalidations(ctx context.Context, before time.Time) error {
+	_, err := d.db.ExecContext(ctx, "DELETE FROM artifact_cache_invalidations WHERE invalidated_at < $1", before)
+	return err
+}
+
+// NotifyRunEvent relays a run event to the other replicas sharing the
+// database, which listen on runEventChannel
+func (d *PostgresDAO) NotifyRunEvent(ctx context.Context, payload string) error {
+	_, err := d.db.ExecContext(ctx, "SELECT pg_notify($1, $2)", runEventChannel, payload)
+	return err
+}
+
+// MarkRunDeleted hides a run, so that its data can be purged, recording the
+// email address of whoever deleted it, if given, to tell before it is
+// purged. Runs already deleted keep their deletion time.
+func (d *PostgresDAO) MarkRunDeleted(ctx context.Context, runID int, at time.Time, deletedBy string) error {
+	_, err := d.db.ExecContext(ctx, "UPDATE runs SET deleted_at = $1, deleted_by = NULLIF($2, '') WHERE id = $3 AND deleted_at IS NULL", at, deletedBy, runID)
+	return err
+}
+
+// MarkRunPurgeNoticeSent records that whoever deleted a run was told it is
+// about to be purged
+func (d *PostgresDAO) MarkRunPurgeNoticeSent(ctx context.Context, runID int, at time.Time) error {
+	_, err := d.db.ExecContext(ctx, "UPDATE runs SET purge_notice_sent_at = $1 WHERE id = $2 AND deleted_at IS NOT NULL", at, runID)
+	return err
+}
+
+// GetDeletedRuns retrieves the deleted runs whose data has not been purged,
+// in the order they were deleted
+func (d *PostgresDAO) GetDeletedRuns(ctx context.Context) ([]DeletedRunRow, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT r.id, r.uuid, r.name, r.parent_run_id, COALESCE(e.uuid, ''), COALESCE(e.name, ''), COALESCE(e.project_id, 0),
+			r.deleted_at, COALESCE(r.deleted_by, ''), r.purge_notice_sent_at
+		FROM runs r
+		LEFT JOIN experiments e ON e.id = r.experiment_id
+		WHERE r.deleted_at IS NOT NULL
+		ORDER BY r.deleted_at, r.id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var runs []DeletedRunRow
+	for rows.Next() {
+		var r DeletedRunRow
+		if err := rows.Scan(&r.ID, &r.UUID, &r.Name, &r.ParentRunID, &r.ExperimentUUID, &r.ExperimentName, &r.ProjectID,
+			&r.DeletedAt, &r.DeletedBy, &r.PurgeNoticeSentAt); err != nil {
+			return nil, err
+		}
+		runs = append(runs, r)
+	}
+	return runs, rows.Err()
+}
+
+// RestoreRun takes a run out of the trash, forgetting who deleted it and
+// any purge notice sent. It refuses runs that have not been deleted.
+func (d *PostgresDAO) RestoreRun(ctx context.Context, runID int) error {
+	result, err := d.db.ExecContext(ctx,
+		"UPDATE runs SET deleted_at = NULL, deleted_by = NULL, purge_notice_sent_at = NULL WHERE id = $1 AND deleted_at IS NOT NULL",
+		runID,
+	)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return fmt.Errorf("run %d does not exist or has not been deleted", runID)
+	}
+	return nil
+}
+
+// PurgeRun deletes a deleted run and every row belonging to it. It refuses
+// to purge runs that have not been deleted.
+func (d *PostgresDAO) PurgeRun(ctx context.Context, runID int) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, "DELETE FROM runs WHERE id = $1 AND deleted_at IS NOT NULL", runID)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return fmt.Errorf("run %d does not exist or has not been deleted", runID)
+	}
+	for _, table := range runDataTables {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM "+table+" WHERE run_id = $1", runID); err != nil {
+			return fmt.Errorf("purging %s: %w", table, err)
+		}
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM run_dependencies WHERE run_id = $1 OR upstream_run_id = $1", runID); err != nil {
+		return fmt.Errorf("purging run_dependencies: %w", err)
+	}
+	return tx.Commit()
+}
+
+// ArchiveRun archives a run, unless it is already archived
+func (d *PostgresDAO) ArchiveRun(ctx context.Context, runID int, at time.Time) error {
+	_, err := d.db.ExecContext(ctx, "UPDATE runs SET archived_at = $1 WHERE id = $2 AND archived_at IS NULL", at.UTC(), runID)
+	return err
+}
+
+// UnarchiveRun restores an archived run
+func (d *PostgresDAO) UnarchiveRun(ctx context.Context, runID int) error {
+	_, err := d.db.ExecContext(ctx, "UPDATE runs SET archived_at = NULL WHERE id = $1", runID)
+	return err
+}
+
+// GetRunsToArchive retrieves the runs created before a time that are not
+// archived, deleted, on hold or still running, oldest first
+func (d *PostgresDAO) GetRunsToArchive(ctx context.Context, createdBefore time.Time) ([]RetentionRunRow, error) {
+	return d.getRetentionRuns(ctx, `
+		SELECT id, uuid, name
+		FROM runs
+		WHERE created_at < $1 AND status != $2 AND archived_at IS NULL AND deleted_at IS NULL AND held_at IS NULL
+		ORDER BY created_at, id
+	`, createdBefore.UTC(), runStatusRunning)
+}
+
+// GetRunsArchivedBefore retrieves the runs archived before a time that are
+// not deleted, in the order they were archived
+func (d *PostgresDAO) GetRunsArchivedBefore(ctx context.Context, cutoff time.Time) ([]RetentionRunRow, error) {
+	return d.getRetentionRuns(ctx, `
+		SELECT id, uuid, name
+		FROM runs
+		WHERE archived_at < $1 AND deleted_at IS NULL
+		ORDER BY archived_at, id
+	`, cutoff.UTC())
+}
+
+func (d *PostgresDAO) getRetentionRuns(ctx context.Context, query string, args ...interface{}) ([]RetentionRunRow, error) {
+	rows, err := d.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var runs []RetentionRunRow
+	for rows.Next() {
+		var r RetentionRunRow
+		if err := rows.Scan(&r.ID, &r.UUID, &r.Name); err != nil {
+			return nil, err
+		}
+		runs = append(runs, r)
+	}
+	return runs, rows.Err()
+}
+
+// MergeRunMetrics moves a run's metric points to another run, returning how
+// many were moved. Where both runs have a point at the same step of a metric,
+// the one logged later is kept.
+func (d *PostgresDAO) MergeRunMetrics(ctx context.Context, sourceRunID, targetRunID int) (int, error) {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `
+		DELETE FROM metrics
+		WHERE run_id = $1 AND EXISTS (
+			SELECT 1 FROM metrics s
+			WHERE s.run_id = $2 AND s.key = metrics.key AND s.x_value = metrics.x_value AND s.logged_at > metrics.logged_at
+		)
+	`, targetRunID, sourceRunID); err != nil {
+		return 0, err
+	}
+	if _, err := tx.ExecContext(ctx, `
+		DELETE FROM metrics
+		WHERE run_id = $1 AND EXISTS (
+			SELECT 1 FROM metrics t
+			WHERE t.run_id = $2 AND t.key = metrics.key AND t.x_value = metrics.x_value
+		)
+	`, sourceRunID, targetRunID); err != nil {
+		return 0, err
+	}
+	result, err := tx.ExecContext(ctx, "UPDATE metrics SET run_id = $1 WHERE run_id = $2", targetRunID, sourceRunID)
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	// Moved and deleted points do not fire the trigger that maintains the
+	// summaries, so both runs' are recomputed
+	if _, err := tx.ExecContext(ctx, "DELETE FROM metric_summaries WHERE run_id IN ($1, $2)", sourceRunID, targetRunID); err != nil {
+		return 0, err
+	}
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf(metricSummariesInsert, "run_id = $1"), targetRunID); err != nil {
+		return 0, err
+	}
+	return int(n), tx.Commit()
+}
+
+// InsertAuditLogEntry records an administrative operation in the audit log
+func (d *PostgresDAO) InsertAuditLogEntry(ctx context.Context, e AuditLogRow) error {
+	_, err := d.db.ExecContext(ctx,
+		"INSERT INTO audit_log (action, subject, details, created_at) VALUES ($1, $2, $3, $4)",
+		e.Action, e.Subject, e.Details, e.CreatedAt,
+	)
+	return err
+}
+
+// GetAuditLog retrieves the most recent audit log entries, newest first
+func (d *PostgresDAO) GetAuditLog(ctx context.Context, limit int) ([]AuditLogRow, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT id, action, subject, details, created_at
+		FROM audit_log
+		ORDER BY created_at DESC, id DESC
+		LIMIT $1
+	`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []AuditLogRow
+	for rows.Next() {
+		var e AuditLogRow
+		if err := rows.Scan(&e.ID, &e.Action, &e.Subject, &e.Details, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// GetParameterKeys retrieves the parameter keys logged to an experiment's
+// runs, once per value type they were logged as
+func (d *PostgresDAO) GetParameterKeys(ctx context.Context, experimentID, projectID int) ([]SchemaKeyRow, error) {
+	return d.getSchemaKeys(ctx, `
+		SELECT p.key, p.value_type, COUNT(DISTINCT p.run_id)
+		FROM parameters p
+		JOIN runs r ON r.id = p.run_id
+		WHERE r.deleted_at IS NULL AND ($1 = 0 OR r.experiment_id = $1)
+		  AND ($2 = 0 OR r.experiment_id IN (SELECT id FROM experiments WHERE project_id = $2))
+		GROUP BY p.key, p.value_type
+		ORDER BY p.key, p.value_type
+	`, experimentID, projectID)
+}
+
+// GetMetricKeys retrieves the metric keys logged to an experiment's runs
+func (d *PostgresDAO) GetMetricKeys(ctx context.Context, experimentID, projectID int) ([]SchemaKeyRow, error) {
+	return d.getSchemaKeys(ctx, `
+		SELECT m.key, '', COUNT(DISTINCT m.run_id)
+		FROM metrics m
+		JOIN runs r ON r.id = m.run_id
+		WHERE r.deleted_at IS NULL AND ($1 = 0 OR r.experiment_id = $1)
+		  AND ($2 = 0 OR r.experiment_id IN (SELECT id FROM experiments WHERE project_id = $2)) AND m.key NOT LIKE 'system/%'
+		GROUP BY m.key
+		ORDER BY m.key
+	`, experimentID, projectID)
+}
+
+// GetTagKeys retrieves the tag keys set on an experiment's runs
+func (d *PostgresDAO) GetTagKeys(ctx context.Context, experimentID, projectID int) ([]SchemaKeyRow, error) {
+	return d.getSchemaKeys(ctx, `
+		SELECT t.key, '', COUNT(DISTINCT t.run_id)
+		FROM tags t
+		JOIN runs r ON r.id = t.run_id
+		WHERE r.deleted_at IS NULL AND ($1 = 0 OR r.experiment_id = $1)
+		  AND ($2 = 0 OR r.experiment_id IN (SELECT id FROM experiments WHERE project_id = $2))
+		GROUP BY t.key
+		ORDER BY t.key
+	`, experimentID, projectID)
+}
+
+func (d *PostgresDAO) getSchemaKeys(ctx context.Context, query string, experimentID, projectID int) ([]SchemaKeyRow, error) {
+	rows, err := d.db.QueryContext(ctx, query, experimentID, projectID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []SchemaKeyRow
+	for rows.Next() {
+		var k SchemaKeyRow
+		if err := rows.Scan(&k.Key, &k.ValueType, &k.RunCount); err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+// GetMetricsLoggedBetween retrieves the points of a metric series of a run
+// logged between from and to inclusive, ordered by when they were logged
+func (d *PostgresDAO) GetMetricsLoggedBetween(ctx context.Context, runID int, key string, from, to time.Time) ([]MetricRow, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT key, x_value, y_value, logged_at
+		FROM metrics
+		WHERE run_id = $1 AND key = $2 AND logged_at >= $3 AND logged_at <= $4
+		ORDER BY logged_at, x_value
+	`, runID, key, from.UTC(), to.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var metrics []MetricRow
+	for rows.Next() {
+		var m MetricRow
+		if err := rows.Scan(&m.Key, &m.XValue, &m.YValue, &m.LoggedAt); err != nil {
+			return nil, err
+		}
+		metrics = append(metrics, m)
+	}
+	return metrics, rows.Err()
+}
+
+// GetMetricsAfter retrieves up to limit points of a metric series of a run
+// with x greater than afterX, ordered by x
+func (d *PostgresDAO) GetMetricsAfter(ctx context.Context, runID int, key string, afterX float64, limit int) ([]MetricRow, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT key, x_value, y_value, logged_at
+		FROM metrics
+		WHERE run_id = $1 AND key = $2 AND x_value > $3
+		ORDER BY x_value
+		LIMIT $4
+	`, runID, key, afterX, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var metrics []MetricRow
+	for rows.Next() {
+		var m MetricRow
+		if err := rows.Scan(&m.Key, &m.XValue, &m.YValue, &m.LoggedAt); err != nil {
+			return nil, err
+		}
+		metrics = append(metrics, m)
+	}
+	return metrics, rows.Err()
+}
+
+// GetBestMetric retrieves the point of a metric series of a run with the
+// lowest y, or the highest if maximize is set, taking the earliest step among
+// ties. It returns sql.ErrNoRows if the series has no points.
+func (d *PostgresDAO) GetBestMetric(ctx context.Context, runID int, key string, maximize bool) (*MetricRow, error) {
+	direction := "ASC"
+	if maximize {
+		direction = "DESC"
+	}
+	var m MetricRow
+	err := d.db.QueryRowContext(ctx, `
+		SELECT key, x_value, y_value, logged_at
+		FROM metrics
+		WHERE run_id = $1 AND key = $2
+		ORDER BY y_value `+direction+`, x_value
+		LIMIT 1
+	`, runID, key).Scan(&m.Key, &m.XValue, &m.YValue, &m.LoggedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// GetMetricSummariesByRunIDs retrieves the metric summaries of runs
+func (d *PostgresDAO) GetMetricSummariesByRunIDs(ctx context.Context, runIDs []int) ([]MetricSummaryRow, error) {
+	if len(runIDs) == 0 {
+		return nil, nil
+	}
+	args := make([]interface{}, len(runIDs))
+	placeholders := make([]string, len(runIDs))
+	for i, id := range runIDs {
+		args[i] = id
+		placeholders[i] = fmt.Sprintf("$%d", i+1)
+	}
+	rows, err := d.db.QueryContext(ctx, fmt.Sprintf(`
+		SELECT run_id, key, namespace, name, COALESCE(last_step, 0), last_value, COALESCE(min_step, 0), min_value, COALESCE(max_step, 0), max_value, point_count
+		FROM metric_summaries
+		WHERE run_id IN (%s)
+		ORDER BY run_id, key
+	`, strings.Join(placeholders, ", ")), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var summaries []MetricSummaryRow
+	for rows.Next() {
+		var s MetricSummaryRow
+		if err := rows.Scan(&s.RunID, &s.Key, &s.Namespace, &s.Name, &s.LastStep, &s.LastValue, &s.MinStep, &s.MinValue, &s.MaxStep, &s.MaxValue, &s.PointCount); err != nil {
+			return nil, err
+		}
+		summaries = append(summaries, s)
+	}
+	return summaries, rows.Err()
+}
+
+// FindMetricSeries finds the metric series whose run name, run UUID or key
+// contains query, ignoring case, newest run first
+func (d *PostgresDAO) FindMetricSeries(ctx context.Context, query string, limit, projectID int) ([]MetricSeriesRow, error) {
+	pattern := "%" + strings.ToLower(query) + "%"
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT r.uuid, r.name, m.key
+		FROM metrics m
+		JOIN runs r ON r.id = m.run_id
+		WHERE r.deleted_at IS NULL AND (LOWER(r.name) LIKE $1 OR LOWER(r.uuid) LIKE $1 OR LOWER(m.key) LIKE $1)
+		  AND (m.key NOT LIKE 'system/%' OR $3 LIKE 'system/%')
+		  AND ($4 = 0 OR r.experiment_id IN (SELECT id FROM experiments WHERE project_id = $4))
+		GROUP BY r.id, r.uuid, r.name, r.created_at, m.key
+		ORDER BY r.created_at DESC, r.id DESC, m.key
+		LIMIT $2
+	`, pattern, limit, strings.ToLower(query), projectID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var series []MetricSeriesRow
+	for rows.Next() {
+		var s MetricSeriesRow
+		if err := rows.Scan(&s.RunUUID, &s.RunName, &s.Key); err != nil {
+			return nil, err
+		}
+		series = append(series, s)
+	}
+	return series, rows.Err()
+}
+
+// GetMetricSeries retrieves every point of a metric series of a run, ordered
+// by x value
+func (d *PostgresDAO) GetMetricSeries(ctx context.Context, runID int, key string) ([]MetricRow, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT key, x_value, y_value, logged_at
+		FROM metrics
+		WHERE run_id = $1 AND key = $2
+		ORDER BY x_value
+	`, runID, key)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var metrics []MetricRow
+	for rows.Next() {
+		var m MetricRow
+		if err := rows.Scan(&m.Key, &m.XValue, &m.YValue, &m.LoggedAt); err != nil {
+			return nil, err
+		}
+		metrics = append(metrics, m)
+	}
+	return metrics, rows.Err()
+}
+
+// GetRunHealth counts running runs, runs that failed since failedSince, and
+// running runs whose last activity was before quietBefore
+func (d *PostgresDAO) GetRunHealth(ctx context.Context, failedSince, quietBefore time.Time) (*RunHealthRow, error) {
+	var health RunHealthRow
+	err := d.db.QueryRowContext(ctx, `
+		SELECT
+			COUNT(CASE WHEN status = $1 THEN 1 END),
+			COUNT(CASE WHEN status = $2 AND finished_at >= $3 THEN 1 END),
+			COUNT(CASE WHEN status = $1 AND COALESCE(last_activity_at, created_at) < $4 THEN 1 END)
+		FROM runs
+		WHERE status IN ($1, $2) AND deleted_at IS NULL
+	`, runStatusRunning, runStatusFailed, failedSince.UTC(), quietBefore.UTC()).Scan(&health.Running, &health.Failed, &health.Quiet)
+	if err != nil {
+		return nil, err
+	}
+	return &health, nil
+}
+
+// GetFailedRuns retrieves the failed runs of an experiment with the messages
+// they ended with, most recently finished first
+func (d *PostgresDAO) GetFailedRuns(ctx context.Context, experimentID int) ([]FailedRunRow, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT uuid, name, COALESCE(status_message, ''), finished_at
+		FROM runs
+		WHERE experiment_id = $1 AND status = $2 AND deleted_at IS NULL
+		ORDER BY finished_at DESC, id DESC
+	`, experimentID, runStatusFailed)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var runs []FailedRunRow
+	for rows.Next() {
+		var run FailedRunRow
+		if err := rows.Scan(&run.UUID, &run.Name, &run.Message, &run.FinishedAt); err != nil {
+			return nil, err
+		}
+		runs = append(runs, run)
+	}
+	return runs, rows.Err()
 }
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
@@ -10,17 +11,46 @@ import (
 
 // SQLiteDAO implements the DAO interface for SQLite
 type SQLiteDAO struct {
-	db *sql.DB
+	db sqliteDB
 }
 
-// NewSQLiteDAO creates a new SQLite DAO
-func NewSQLiteDAO(db *sql.DB) *SQLiteDAO {
-	return &SQLiteDAO{db: db}
+// NewSQLiteDAO creates a new SQLite DAO reading from a pool of connections
+// and writing through writer, which should hold a single connection. They may
+// be the same pool.
+func NewSQLiteDAO(readers, writer *sql.DB) *SQLiteDAO {
+	return &SQLiteDAO{db: sqliteDB{DB: readers, writer: writer}}
+}
+
+// sqliteDB sends queries to a pool of reader connections and writes to a
+// writer pool. SQLite allows one writer at a time, and writers that find the
+// database locked give up after the busy timeout with "database is locked".
+// Keeping the writer pool to one connection queues writes in Go instead, one
+// after another, while in WAL mode reads carry on alongside them.
+type sqliteDB struct {
+	*sql.DB
+	writer *sql.DB
+}
+
+// ExecContext runs a write on the writer connection
+func (s sqliteDB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return s.writer.ExecContext(ctx, query, args...)
+}
+
+// PrepareContext prepares a write on the writer connection
+func (s sqliteDB) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	return s.writer.PrepareContext(ctx, query)
+}
+
+// BeginTx starts a transaction on the writer connection. Transactions only
+// read through the transaction, never the reader pool, so they cannot
+// deadlock waiting for the writer they hold.
+func (s sqliteDB) BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error) {
+	return s.writer.BeginTx(ctx, opts)
 }
 
 // InsertExperiment inserts a new experiment
-func (d *SQLiteDAO) InsertExperiment(uuid, name string) error {
-	_, err := d.db.Exec(
+func (d *SQLiteDAO) InsertExperiment(ctx context.Context, uuid, name string) error {
+	_, err := d.db.ExecContext(ctx,
 		"INSERT INTO experiments (uuid, name) VALUES (?, ?)",
 		uuid, name,
 	)
@@ -28,29 +58,43 @@ func (d *SQLiteDAO) InsertExperiment(uuid, name string) error {
 }
 
 // GetExperimentByUUID retrieves an experiment by its UUID
-func (d *SQLiteDAO) GetExperimentByUUID(uuid string) (*Experiment, error) {
-	var name, createdAt string
+func (d *SQLiteDAO) GetExperimentByUUID(ctx context.Context, uuid string) (*Experiment, error) {
+	var name, createdAt, readme string
+	var projectID int
 	var mostRecentRunAt sql.NullString
-	err := d.db.QueryRow(`
-		SELECT e.name, e.created_at,
-			(SELECT MAX(created_at) FROM runs WHERE experiment_id = e.id) as most_recent_run_at
+	err := d.db.QueryRowContext(ctx, `
+		SELECT e.name, e.created_at, e.readme, e.project_id,
+			(SELECT MAX(created_at) FROM runs WHERE experiment_id = e.id AND deleted_at IS NULL) as most_recent_run_at
 		FROM experiments e WHERE e.uuid = ?`,
 		uuid,
-	).Scan(&name, &createdAt, &mostRecentRunAt)
+	).Scan(&name, &createdAt, &readme, &projectID, &mostRecentRunAt)
 	if err != nil {
 		return nil, err
 	}
-	exp := &Experiment{UUID: uuid, Name: name, CreatedAt: createdAt}
+	exp := &Experiment{UUID: uuid, Name: name, CreatedAt: createdAt, Readme: readme, ProjectID: projectID}
 	if mostRecentRunAt.Valid {
 		exp.MostRecentRunAt = mostRecentRunAt.String
 	}
 	return exp, nil
 }
 
+// GetExperimentByID retrieves an experiment by its database ID
+func (d *SQLiteDAO) GetExperimentByID(ctx context.Context, id int) (*Experiment, error) {
+	var exp Experiment
+	err := d.db.QueryRowContext(ctx,
+		"SELECT uuid, name, created_at, readme, project_id FROM experiments WHERE id = ?",
+		id,
+	).Scan(&exp.UUID, &exp.Name, &exp.CreatedAt, &exp.Readme, &exp.ProjectID)
+	if err != nil {
+		return nil, err
+	}
+	return &exp, nil
+}
+
 // GetExperimentIDByUUID retrieves the database ID of an experiment by its UUID
-func (d *SQLiteDAO) GetExperimentIDByUUID(uuid string) (int, error) {
+func (d *SQLiteDAO) GetExperimentIDByUUID(ctx context.Context, uuid string) (int, error) {
 	var id int
-	err := d.db.QueryRow(
+	err := d.db.QueryRowContext(ctx,
 		"SELECT id FROM experiments WHERE uuid = ?",
 		uuid,
 	).Scan(&id)
@@ -58,13 +102,13 @@ func (d *SQLiteDAO) GetExperimentIDByUUID(uuid string) (int, error) {
 }
 
 // GetAllExperiments retrieves all experiments ordered by most_recent_run_at descending
-func (d *SQLiteDAO) GetAllExperiments() ([]Experiment, error) {
-	rows, err := d.db.Query(`
-		SELECT e.uuid, e.name, e.created_at,
-			(SELECT MAX(created_at) FROM runs WHERE experiment_id = e.id) as most_recent_run_at,
-			(SELECT COUNT(*) FROM runs WHERE experiment_id = e.id) as run_count
+func (d *SQLiteDAO) GetAllExperiments(ctx context.Context) ([]Experiment, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT e.uuid, e.name, e.created_at, e.project_id,
+			(SELECT MAX(created_at) FROM runs WHERE experiment_id = e.id AND deleted_at IS NULL) as most_recent_run_at,
+			(SELECT COUNT(*) FROM runs WHERE experiment_id = e.id AND deleted_at IS NULL) as run_count
 		FROM experiments e
-		ORDER BY COALESCE((SELECT MAX(created_at) FROM runs WHERE experiment_id = e.id), e.created_at) DESC
+		ORDER BY COALESCE((SELECT MAX(created_at) FROM runs WHERE experiment_id = e.id AND deleted_at IS NULL), e.created_at) DESC
 	`)
 	if err != nil {
 		return nil, err
@@ -75,11 +119,11 @@ func (d *SQLiteDAO) GetAllExperiments() ([]Experiment, error) {
 	for rows.Next() {
 		var uuid, name, createdAt string
 		var mostRecentRunAt sql.NullString
-		var runCount int
-		if err := rows.Scan(&uuid, &name, &createdAt, &mostRecentRunAt, &runCount); err != nil {
+		var projectID, runCount int
+		if err := rows.Scan(&uuid, &name, &createdAt, &projectID, &mostRecentRunAt, &runCount); err != nil {
 			return nil, err
 		}
-		exp := Experiment{UUID: uuid, Name: name, CreatedAt: createdAt, RunCount: runCount}
+		exp := Experiment{UUID: uuid, Name: name, CreatedAt: createdAt, RunCount: runCount, ProjectID: projectID}
 		if mostRecentRunAt.Valid {
 			exp.MostRecentRunAt = mostRecentRunAt.String
 		}
@@ -89,20 +133,106 @@ func (d *SQLiteDAO) GetAllExperiments() ([]Experiment, error) {
 	return experiments, rows.Err()
 }
 
+// InsertProject inserts a new project
+func (d *SQLiteDAO) InsertProject(ctx context.Context, uuid, name, artifactPrefix string) error {
+	_, err := d.db.ExecContext(ctx,
+		"INSERT INTO projects (uuid, name, artifact_prefix) VALUES (?, ?, ?)",
+		uuid, name, artifactPrefix,
+	)
+	return err
+}
+
+// GetProjectByID retrieves a project by its database ID
+func (d *SQLiteDAO) GetProjectByID(ctx context.Context, id int) (*ProjectRow, error) {
+	return d.getProject(ctx, "id = ?", id)
+}
+
+// GetProjectByUUID retrieves a project by its UUID
+func (d *SQLiteDAO) GetProjectByUUID(ctx context.Context, uuid string) (*ProjectRow, error) {
+	return d.getProject(ctx, "uuid = ?", uuid)
+}
+
+// GetProjectByName retrieves a project by its name
+func (d *SQLiteDAO) GetProjectByName(ctx context.Context, name string) (*ProjectRow, error) {
+	return d.getProject(ctx, "name = ?", name)
+}
+
+func (d *SQLiteDAO) getProject(ctx context.Context, where string, arg interface{}) (*ProjectRow, error) {
+	var p ProjectRow
+	err := d.db.QueryRowContext(ctx,
+		"SELECT id, uuid, name, artifact_prefix, created_at FROM projects WHERE "+where,
+		arg,
+	).Scan(&p.ID, &p.UUID, &p.Name, &p.ArtifactPrefix, &p.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// GetAllProjects retrieves all projects ordered by name
+func (d *SQLiteDAO) GetAllProjects(ctx context.Context) ([]ProjectRow, error) {
+	rows, err := d.db.QueryContext(ctx, "SELECT id, uuid, name, artifact_prefix, created_at FROM projects ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var projects []ProjectRow
+	for rows.Next() {
+		var p ProjectRow
+		if err := rows.Scan(&p.ID, &p.UUID, &p.Name, &p.ArtifactPrefix, &p.CreatedAt); err != nil {
+			return nil, err
+		}
+		projects = append(projects, p)
+	}
+	return projects, rows.Err()
+}
+
+// SetExperimentProject moves an experiment, and so its runs, to a project
+func (d *SQLiteDAO) SetExperimentProject(ctx context.Context, experimentID, projectID int) error {
+	_, err := d.db.ExecContext(ctx, "UPDATE experiments SET project_id = ? WHERE id = ?", projectID, experimentID)
+	return err
+}
+
+// GetRunProjectID retrieves the ID of the project of a run's experiment
+func (d *SQLiteDAO) GetRunProjectID(ctx context.Context, runID int) (int, error) {
+	var projectID int
+	err := d.db.QueryRowContext(ctx, `
+		SELECT e.project_id
+		FROM runs r
+		JOIN experiments e ON e.id = r.experiment_id
+		WHERE r.id = ?
+	`, runID).Scan(&projectID)
+	return projectID, err
+}
+
 // GetDefaultExperimentID returns the ID of the default experiment
-func (d *SQLiteDAO) GetDefaultExperimentID() (int, error) {
+func (d *SQLiteDAO) GetDefaultExperimentID(ctx context.Context) (int, error) {
 	var id int
-	err := d.db.QueryRow("SELECT id FROM experiments WHERE uuid = '00000000-0000-0000-0000-000000000000'").Scan(&id)
+	err := d.db.QueryRowContext(ctx, "SELECT id FROM experiments WHERE uuid = '00000000-0000-0000-0000-000000000000'").Scan(&id)
 	return id, err
 }
 
 // InsertRun inserts a new run
-func (d *SQLiteDAO) InsertRun(uuid, name string, experimentID int, parentRunID *int) error {
+func (d *SQLiteDAO) InsertRun(ctx context.Context, uuid, name string, experimentID int, parentRunID *int) error {
+	return d.InsertRunWithSourceAndSeeds(ctx, uuid, name, experimentID, parentRunID, RunSourceRow{}, RunSeedsRow{})
+}
+
+// InsertRunWithSourceAndSeeds inserts a new run along with where it came from
+// and the random seeds it was started with, in one transaction, so that a run
+// is never left behind without them
+func (d *SQLiteDAO) InsertRunWithSourceAndSeeds(ctx context.Context, uuid, name string, experimentID int, parentRunID *int, source RunSourceRow, seeds RunSeedsRow) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
 	var nestingLevel int
 	if parentRunID != nil {
 		// Get parent's nesting level and add 1
 		var parentLevel int
-		err := d.db.QueryRow("SELECT nesting_level FROM runs WHERE id = ?", *parentRunID).Scan(&parentLevel)
+		err := tx.QueryRowContext(ctx, "SELECT nesting_level FROM runs WHERE id = ?", *parentRunID).Scan(&parentLevel)
 		if err != nil {
 			return fmt.Errorf("failed to get parent run nesting level: %w", err)
 		}
@@ -112,26 +242,45 @@ func (d *SQLiteDAO) InsertRun(uuid, name string, experimentID int, parentRunID *
 		}
 	}
 
-	_, err := d.db.Exec(
+	res, err := tx.ExecContext(ctx,
 		"INSERT INTO runs (uuid, name, experiment_id, parent_run_id, nesting_level) VALUES (?, ?, ?, ?, ?)",
 		uuid, name, experimentID, parentRunID, nestingLevel,
 	)
-	return err
+	if err != nil {
+		return err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	runID := int(id)
+
+	if source != (RunSourceRow{}) {
+		if err := setSQLiteRunSource(ctx, tx.ExecContext, runID, source); err != nil {
+			return fmt.Errorf("failed to record the run's source: %w", err)
+		}
+	}
+	if !seeds.isEmpty() {
+		if err := setSQLiteRunSeeds(ctx, tx.ExecContext, runID, seeds); err != nil {
+			return fmt.Errorf("failed to record the run's seeds: %w", err)
+		}
+	}
+	return tx.Commit()
 }
 
 // GetRunByUUID retrieves a run by its UUID
-func (d *SQLiteDAO) GetRunByUUID(uuid string) (*Run, error) {
-	var name, notes string
+func (d *SQLiteDAO) GetRunByUUID(ctx context.Context, uuid string) (*Run, error) {
+	var name, notes, status, statusMessage, forkedFrom string
 	var parentRunID sql.NullInt64
 	var nestingLevel int
-	err := d.db.QueryRow(
-		"SELECT name, notes, parent_run_id, nesting_level FROM runs WHERE uuid = ?",
+	err := d.db.QueryRowContext(ctx,
+		"SELECT name, notes, parent_run_id, nesting_level, status, COALESCE(status_message, ''), COALESCE(forked_from, '') FROM runs WHERE uuid = ? AND deleted_at IS NULL",
 		uuid,
-	).Scan(&name, &notes, &parentRunID, &nestingLevel)
+	).Scan(&name, &notes, &parentRunID, &nestingLevel, &status, &statusMessage, &forkedFrom)
 	if err != nil {
 		return nil, err
 	}
-	run := &Run{UUID: uuid, Name: name, Notes: notes, NestingLevel: nestingLevel}
+	run := &Run{UUID: uuid, Name: name, Notes: notes, NestingLevel: nestingLevel, Status: status, StatusMessage: statusMessage, ForkedFrom: forkedFrom}
 	if parentRunID.Valid {
 		id := int(parentRunID.Int64)
 		run.ParentRunID = &id
@@ -140,18 +289,18 @@ func (d *SQLiteDAO) GetRunByUUID(uuid string) (*Run, error) {
 }
 
 // GetRunByID retrieves a run by its database ID
-func (d *SQLiteDAO) GetRunByID(id int) (*Run, error) {
-	var uuid, name, notes string
+func (d *SQLiteDAO) GetRunByID(ctx context.Context, id int) (*Run, error) {
+	var uuid, name, notes, status, statusMessage, forkedFrom string
 	var parentRunID sql.NullInt64
 	var nestingLevel int
-	err := d.db.QueryRow(
-		"SELECT uuid, name, notes, parent_run_id, nesting_level FROM runs WHERE id = ?",
+	err := d.db.QueryRowContext(ctx,
+		"SELECT uuid, name, notes, parent_run_id, nesting_level, status, COALESCE(status_message, ''), COALESCE(forked_from, '') FROM runs WHERE id = ?",
 		id,
-	).Scan(&uuid, &name, &notes, &parentRunID, &nestingLevel)
+	).Scan(&uuid, &name, &notes, &parentRunID, &nestingLevel, &status, &statusMessage, &forkedFrom)
 	if err != nil {
 		return nil, err
 	}
-	run := &Run{UUID: uuid, Name: name, Notes: notes, NestingLevel: nestingLevel}
+	run := &Run{UUID: uuid, Name: name, Notes: notes, NestingLevel: nestingLevel, Status: status, StatusMessage: statusMessage, ForkedFrom: forkedFrom}
 	if parentRunID.Valid {
 		pID := int(parentRunID.Int64)
 		run.ParentRunID = &pID
@@ -160,20 +309,21 @@ func (d *SQLiteDAO) GetRunByID(id int) (*Run, error) {
 }
 
 // GetRunIDByUUID retrieves the database ID of a run by its UUID
-func (d *SQLiteDAO) GetRunIDByUUID(uuid string) (int, error) {
+func (d *SQLiteDAO) GetRunIDByUUID(ctx context.Context, uuid string) (int, error) {
 	var id int
-	err := d.db.QueryRow(
-		"SELECT id FROM runs WHERE uuid = ?",
+	err := d.db.QueryRowContext(ctx,
+		"SELECT id FROM runs WHERE uuid = ? AND deleted_at IS NULL",
 		uuid,
 	).Scan(&id)
 	return id, err
 }
 
 // GetAllRuns retrieves all runs ordered by created_at descending
-func (d *SQLiteDAO) GetAllRuns() ([]Run, error) {
-	rows, err := d.db.Query(`
-		SELECT uuid, name, created_at
+func (d *SQLiteDAO) GetAllRuns(ctx context.Context) ([]Run, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT uuid, name, created_at, status
 		FROM runs
+		WHERE deleted_at IS NULL
 		ORDER BY created_at DESC
 	`)
 	if err != nil {
@@ -183,22 +333,22 @@ func (d *SQLiteDAO) GetAllRuns() ([]Run, error) {
 
 	var runs []Run
 	for rows.Next() {
-		var uuid, name, createdAt string
-		if err := rows.Scan(&uuid, &name, &createdAt); err != nil {
+		var uuid, name, createdAt, status string
+		if err := rows.Scan(&uuid, &name, &createdAt, &status); err != nil {
 			return nil, err
 		}
-		runs = append(runs, Run{UUID: uuid, Name: name, CreatedAt: createdAt})
+		runs = append(runs, Run{UUID: uuid, Name: name, CreatedAt: createdAt, Status: status})
 	}
 
 	return runs, rows.Err()
 }
 
 // GetRunsByExperimentID retrieves all runs for an experiment
-func (d *SQLiteDAO) GetRunsByExperimentID(experimentID int) ([]Run, error) {
-	rows, err := d.db.Query(`
-		SELECT uuid, name, created_at, parent_run_id, nesting_level
+func (d *SQLiteDAO) GetRunsByExperimentID(ctx context.Context, experimentID int) ([]Run, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT uuid, name, created_at, parent_run_id, nesting_level, status
 		FROM runs
-		WHERE experiment_id = ?
+		WHERE experiment_id = ? AND deleted_at IS NULL
 		ORDER BY created_at DESC
 	`, experimentID)
 	if err != nil {
@@ -208,13 +358,13 @@ func (d *SQLiteDAO) GetRunsByExperimentID(experimentID int) ([]Run, error) {
 
 	var runs []Run
 	for rows.Next() {
-		var uuid, name, createdAt string
+		var uuid, name, createdAt, status string
 		var parentRunID sql.NullInt64
 		var nestingLevel int
-		if err := rows.Scan(&uuid, &name, &createdAt, &parentRunID, &nestingLevel); err != nil {
+		if err := rows.Scan(&uuid, &name, &createdAt, &parentRunID, &nestingLevel, &status); err != nil {
 			return nil, err
 		}
-		run := Run{UUID: uuid, Name: name, CreatedAt: createdAt, NestingLevel: nestingLevel}
+		run := Run{UUID: uuid, Name: name, CreatedAt: createdAt, NestingLevel: nestingLevel, Status: status}
 		if parentRunID.Valid {
 			id := int(parentRunID.Int64)
 			run.ParentRunID = &id
@@ -226,11 +376,11 @@ func (d *SQLiteDAO) GetRunsByExperimentID(experimentID int) ([]Run, error) {
 }
 
 // GetRunsByExperimentIDAndLevel retrieves runs for an experiment at a specific nesting level
-func (d *SQLiteDAO) GetRunsByExperimentIDAndLevel(experimentID int, nestingLevel int) ([]Run, error) {
-	rows, err := d.db.Query(`
-		SELECT uuid, name, created_at, parent_run_id, nesting_level
+func (d *SQLiteDAO) GetRunsByExperimentIDAndLevel(ctx context.Context, experimentID int, nestingLevel int) ([]Run, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT uuid, name, created_at, parent_run_id, nesting_level, status
 		FROM runs
-		WHERE experiment_id = ? AND nesting_level = ?
+		WHERE experiment_id = ? AND nesting_level = ? AND deleted_at IS NULL
 		ORDER BY created_at DESC
 	`, experimentID, nestingLevel)
 	if err != nil {
@@ -240,13 +390,13 @@ func (d *SQLiteDAO) GetRunsByExperimentIDAndLevel(experimentID int, nestingLevel
 
 	var runs []Run
 	for rows.Next() {
-		var uuid, name, createdAt string
+		var uuid, name, createdAt, status string
 		var parentRunID sql.NullInt64
 		var level int
-		if err := rows.Scan(&uuid, &name, &createdAt, &parentRunID, &level); err != nil {
+		if err := rows.Scan(&uuid, &name, &createdAt, &parentRunID, &level, &status); err != nil {
 			return nil, err
 		}
-		run := Run{UUID: uuid, Name: name, CreatedAt: createdAt, NestingLevel: level}
+		run := Run{UUID: uuid, Name: name, CreatedAt: createdAt, NestingLevel: level, Status: status}
 		if parentRunID.Valid {
 			id := int(parentRunID.Int64)
 			run.ParentRunID = &id
@@ -258,11 +408,11 @@ func (d *SQLiteDAO) GetRunsByExperimentIDAndLevel(experimentID int, nestingLevel
 }
 
 // GetChildRuns retrieves all direct child runs of a parent run
-func (d *SQLiteDAO) GetChildRuns(parentRunID int) ([]Run, error) {
-	rows, err := d.db.Query(`
-		SELECT uuid, name, created_at, parent_run_id, nesting_level
+func (d *SQLiteDAO) GetChildRuns(ctx context.Context, parentRunID int) ([]Run, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT uuid, name, created_at, parent_run_id, nesting_level, status
 		FROM runs
-		WHERE parent_run_id = ?
+		WHERE parent_run_id = ? AND deleted_at IS NULL
 		ORDER BY created_at DESC
 	`, parentRunID)
 	if err != nil {
@@ -272,13 +422,13 @@ func (d *SQLiteDAO) GetChildRuns(parentRunID int) ([]Run, error) {
 
 	var runs []Run
 	for rows.Next() {
-		var uuid, name, createdAt string
+		var uuid, name, createdAt, status string
 		var pRunID sql.NullInt64
 		var nestingLevel int
-		if err := rows.Scan(&uuid, &name, &createdAt, &pRunID, &nestingLevel); err != nil {
+		if err := rows.Scan(&uuid, &name, &createdAt, &pRunID, &nestingLevel, &status); err != nil {
 			return nil, err
 		}
-		run := Run{UUID: uuid, Name: name, CreatedAt: createdAt, NestingLevel: nestingLevel}
+		run := Run{UUID: uuid, Name: name, CreatedAt: createdAt, NestingLevel: nestingLevel, Status: status}
 		if pRunID.Valid {
 			id := int(pRunID.Int64)
 			run.ParentRunID = &id
@@ -290,41 +440,83 @@ func (d *SQLiteDAO) GetChildRuns(parentRunID int) ([]Run, error) {
 }
 
 // GetChildRunCount returns the count of direct child runs
-func (d *SQLiteDAO) GetChildRunCount(parentRunID int) (int, error) {
+func (d *SQLiteDAO) GetChildRunCount(ctx context.Context, parentRunID int) (int, error) {
 	var count int
-	err := d.db.QueryRow("SELECT COUNT(*) FROM runs WHERE parent_run_id = ?", parentRunID).Scan(&count)
+	err := d.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM runs WHERE parent_run_id = ? AND deleted_at IS NULL", parentRunID).Scan(&count)
 	return count, err
 }
 
 // UpsertParameter inserts or updates a parameter
-func (d *SQLiteDAO) UpsertParameter(runID int, key, valueType string, valueString *string, valueBool *bool, valueFloat *float64, valueInt *int64) error {
-	var sql string
+func (d *SQLiteDAO) UpsertParameter(ctx context.Context, runID int, key, valueType string, valueString *string, valueBool *bool, valueFloat *float64, valueInt *int64) error {
+	return upsertSQLiteParameter(ctx, d.db.ExecContext, runID, key, valueType, valueString, valueBool, valueFloat, valueInt)
+}
+
+// UpsertParameters inserts or replaces several parameters of a run in one
+// transaction, so that either all of them are logged or none are
+func (d *SQLiteDAO) UpsertParameters(ctx context.Context, runID int, params []ParameterRow) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, p := range params {
+		valueString, valueBool, valueFloat, valueInt := parameterValuePointers(p)
+		if err := upsertSQLiteParameter(ctx, tx.ExecContext, runID, p.Key, p.ValueType, valueString, valueBool, valueFloat, valueInt); err != nil {
+			return fmt.Errorf("parameter %s: %w", p.Key, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// UpsertExperimentParameters inserts or replaces parameters of several runs
+// in one transaction, so that either all of them are written or none are
+func (d *SQLiteDAO) UpsertExperimentParameters(ctx context.Context, params []ExperimentParameterRow) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, p := range params {
+		valueString, valueBool, valueFloat, valueInt := parameterValuePointers(p.ParameterRow)
+		if err := upsertSQLiteParameter(ctx, tx.ExecContext, p.RunID, p.Key, p.ValueType, valueString, valueBool, valueFloat, valueInt); err != nil {
+			return fmt.Errorf("run %d parameter %s: %w", p.RunID, p.Key, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// upsertSQLiteParameter inserts or replaces a parameter with exec, which is the
+// database's or a transaction's Exec
+func upsertSQLiteParameter(ctx context.Context, exec func(ctx context.Context, query string, args ...interface{}) (sql.Result, error), runID int, key, valueType string, valueString *string, valueBool *bool, valueFloat *float64, valueInt *int64) error {
+	var query string
 	var args []interface{}
 
 	switch valueType {
 	case "string":
-		sql = "INSERT OR REPLACE INTO parameters (run_id, key, value_type, value_string) VALUES (?, ?, ?, ?)"
+		query = "INSERT OR REPLACE INTO parameters (run_id, key, value_type, value_string) VALUES (?, ?, ?, ?)"
 		args = []interface{}{runID, key, valueType, valueString}
 	case "bool":
-		sql = "INSERT OR REPLACE INTO parameters (run_id, key, value_type, value_bool) VALUES (?, ?, ?, ?)"
+		query = "INSERT OR REPLACE INTO parameters (run_id, key, value_type, value_bool) VALUES (?, ?, ?, ?)"
 		args = []interface{}{runID, key, valueType, valueBool}
 	case "float":
-		sql = "INSERT OR REPLACE INTO parameters (run_id, key, value_type, value_float) VALUES (?, ?, ?, ?)"
+		query = "INSERT OR REPLACE INTO parameters (run_id, key, value_type, value_float) VALUES (?, ?, ?, ?)"
 		args = []interface{}{runID, key, valueType, valueFloat}
 	case "int":
-		sql = "INSERT OR REPLACE INTO parameters (run_id, key, value_type, value_int) VALUES (?, ?, ?, ?)"
+		query = "INSERT OR REPLACE INTO parameters (run_id, key, value_type, value_int) VALUES (?, ?, ?, ?)"
 		args = []interface{}{runID, key, valueType, valueInt}
 	default:
 		return fmt.Errorf("unsupported value type: %s", valueType)
 	}
 
-	_, err := d.db.Exec(sql, args...)
+	_, err := exec(ctx, query, args...)
 	return err
 }
 
 // GetParametersByRunID retrieves all parameters for a run
-func (d *SQLiteDAO) GetParametersByRunID(runID int) ([]ParameterRow, error) {
-	rows, err := d.db.Query(`
+func (d *SQLiteDAO) GetParametersByRunID(ctx context.Context, runID int) ([]ParameterRow, error) {
+	rows, err := d.db.QueryContext(ctx, `
 		SELECT key, value_type, value_string, value_bool, value_float, value_int
 		FROM parameters
 		WHERE run_id = ?
@@ -348,7 +540,7 @@ func (d *SQLiteDAO) GetParametersByRunID(runID int) ([]ParameterRow, error) {
 }
 
 // InsertMetric inserts a new metric
-func (d *SQLiteDAO) InsertMetrics(runID int, key string, xValues []float64, yValues []float64, loggedAtEpochMillis int64) error {
+func (d *SQLiteDAO) InsertMetrics(ctx context.Context, runID int, key string, xValues []float64, yValues []float64, loggedAtEpochMillis int64) error {
 	if len(xValues) != len(yValues) {
 		return errors.New("xValues and yValues must have the same length")
 	}
@@ -363,17 +555,64 @@ func (d *SQLiteDAO) InsertMetrics(runID int, key string, xValues []float64, yVal
 		vals = append(vals, runID, key, xValues[i], yValues[i], time.UnixMilli(loggedAtEpochMillis).UTC())
 	}
 	stmtBuilder.WriteString(";")
-	stmt, err := d.db.Prepare(stmtBuilder.String())
+	stmt, err := d.db.PrepareContext(ctx, stmtBuilder.String())
 	if err != nil {
 		return err
 	}
-	_, err = stmt.Exec(vals...)
+	defer stmt.Close()
+	_, err = stmt.ExecContext(ctx, vals...)
 	return err
 }
 
+// InsertSystemMetrics inserts points of system metrics, each with its own key
+// and logging time, in one transaction
+func (d *SQLiteDAO) InsertSystemMetrics(ctx context.Context, runID int, points []MetricRow) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, "INSERT INTO metrics (run_id, key, x_value, y_value, logged_at) VALUES (?, ?, ?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for _, p := range points {
+		if _, err := stmt.ExecContext(ctx, runID, p.Key, p.XValue, p.YValue, p.LoggedAt.UTC()); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// InsertMetricBatch inserts points of the metrics of several runs, keyed by
+// run ID, in one transaction
+func (d *SQLiteDAO) InsertMetricBatch(ctx context.Context, points map[int][]MetricRow) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, "INSERT INTO metrics (run_id, key, x_value, y_value, logged_at) VALUES (?, ?, ?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for runID, runPoints := range points {
+		for _, p := range runPoints {
+			if _, err := stmt.ExecContext(ctx, runID, p.Key, p.XValue, p.YValue, p.LoggedAt.UTC()); err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
+
 // GetMetricsByRunID retrieves all metrics for a run
-func (d *SQLiteDAO) GetMetricsByRunID(runID int) ([]MetricRow, error) {
-	rows, err := d.db.Query(`
+func (d *SQLiteDAO) GetMetricsByRunID(ctx context.Context, runID int) ([]MetricRow, error) {
+	rows, err := d.db.QueryContext(ctx, `
 		SELECT key, x_value, y_value, logged_at
 		FROM metrics
 		WHERE run_id = ?
@@ -396,19 +635,137 @@ func (d *SQLiteDAO) GetMetricsByRunID(runID int) ([]MetricRow, error) {
 	return metrics, rows.Err()
 }
 
-// UpsertArtifact inserts or updates an artifact
-func (d *SQLiteDAO) UpsertArtifact(runID int, path, uri, artifactType string) error {
-	_, err := d.db.Exec(
-		"INSERT OR REPLACE INTO artifacts (run_id, path, uri, type) VALUES (?, ?, ?, ?)",
-		runID, path, uri, artifactType,
-	)
-	return err
+// GetMetricsDownsampled retrieves a metric series of a run, ordered by x
+// value, downsampled to at most maxPoints points, or 4 if maxPoints is less.
+// The series is split into buckets of consecutive points, of which the lowest
+// and highest are kept, so that spikes survive downsampling.
+func (d *SQLiteDAO) GetMetricsDownsampled(ctx context.Context, runID int, key string, maxPoints int) ([]MetricRow, error) {
+	buckets := metricDownsampleBuckets(maxPoints)
+	rows, err := d.db.QueryContext(ctx, `
+		WITH numbered AS (
+			SELECT id, x_value, y_value,
+				ROW_NUMBER() OVER (ORDER BY x_value) - 1 AS point_index,
+				COUNT(*) OVER () AS point_count
+			FROM metrics
+			WHERE run_id = ? AND key = ?
+		), ranked AS (
+			SELECT id, point_index, point_count,
+				ROW_NUMBER() OVER (PARTITION BY point_index * ? / point_count ORDER BY y_value, x_value) AS lowest,
+				ROW_NUMBER() OVER (PARTITION BY point_index * ? / point_count ORDER BY y_value DESC, x_value) AS highest
+			FROM numbered
+		)
+		SELECT m.key, m.x_value, m.y_value, m.logged_at
+		FROM ranked r
+		JOIN metrics m ON m.id = r.id
+		WHERE r.point_count <= ? OR r.lowest = 1 OR r.highest = 1 OR r.point_index = 0 OR r.point_index = r.point_count - 1
+		ORDER BY m.x_value
+	`, runID, key, buckets, buckets, maxPoints)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var metrics []MetricRow
+	for rows.Next() {
+		var m MetricRow
+		if err := rows.Scan(&m.Key, &m.XValue, &m.YValue, &m.LoggedAt); err != nil {
+			return nil, err
+		}
+		metrics = append(metrics, m)
+	}
+
+	return metrics, rows.Err()
+}
+
+// GetMetricGaps retrieves the consecutive points of a metric series of a run
+// that were logged more than threshold apart, ordered by x value
+func (d *SQLiteDAO) GetMetricGaps(ctx context.Context, runID int, key string, threshold time.Duration) ([]MetricGapRow, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		WITH ordered AS (
+			SELECT id, logged_at, LAG(id) OVER (ORDER BY x_value) AS previous_id
+			FROM metrics
+			WHERE run_id = ? AND key = ?
+		)
+		SELECT s.key, s.x_value, s.y_value, s.logged_at, e.key, e.x_value, e.y_value, e.logged_at
+		FROM ordered o
+		JOIN metrics s ON s.id = o.previous_id
+		JOIN metrics e ON e.id = o.id
+		WHERE (julianday(e.logged_at) - julianday(s.logged_at)) * 86400 > ?
+		ORDER BY e.x_value
+	`, runID, key, threshold.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var gaps []MetricGapRow
+	for rows.Next() {
+		var g MetricGapRow
+		if err := rows.Scan(&g.Start.Key, &g.Start.XValue, &g.Start.YValue, &g.Start.LoggedAt, &g.End.Key, &g.End.XValue, &g.End.YValue, &g.End.LoggedAt); err != nil {
+			return nil, err
+		}
+		gaps = append(gaps, g)
+	}
+
+	return gaps, rows.Err()
+}
+
+// UpsertArtifact inserts or updates an artifact. Updating it to contents
+// stored under another URI keeps the prior version.
+func (d *SQLiteDAO) UpsertArtifact(ctx context.Context, runID int, path, uri, artifactType, contentType string, sizeBytes int64, sha256 string) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var currentURI string
+	var version int
+	err = tx.QueryRowContext(ctx, "SELECT uri, version FROM artifacts WHERE run_id = ? AND path = ?", runID, path).Scan(&currentURI, &version)
+	if errors.Is(err, sql.ErrNoRows) {
+		_, err = tx.ExecContext(ctx,
+			"INSERT INTO artifacts (run_id, path, uri, type, content_type, size_bytes, sha256, updated_at) VALUES (?, ?, ?, ?, NULLIF(?, ''), ?, NULLIF(?, ''), ?)",
+			runID, path, uri, artifactType, contentType, sizeBytes, sha256, time.Now().UTC(),
+		)
+		if err != nil {
+			return err
+		}
+		return tx.Commit()
+	}
+	if err != nil {
+		return err
+	}
+
+	// Contents stored under a new URI make a new version, keeping the prior
+	// one. Contents stored over the current ones replace them.
+	if uri != currentURI {
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO artifact_versions (run_id, path, version, uri, type, content_type, size_bytes, sha256, updated_at, scan_status, scan_result, scan_attempts, scanned_at)
+			SELECT run_id, path, version, uri, type, content_type, size_bytes, sha256, updated_at, scan_status, scan_result, scan_attempts, scanned_at
+			FROM artifacts WHERE run_id = ? AND path = ?
+		`, runID, path)
+		if err != nil {
+			return err
+		}
+		version++
+	}
+	_, err = tx.ExecContext(ctx, `
+		UPDATE artifacts
+		SET uri = ?, type = ?, content_type = NULLIF(?, ''), size_bytes = ?, sha256 = NULLIF(?, ''), updated_at = ?, version = ?,
+		    mirror_status = 'pending', mirror_attempts = 0, mirror_error = NULL, mirrored_at = NULL,
+		    scan_status = 'pending', scan_result = NULL, scan_attempts = 0, scanned_at = NULL
+		WHERE run_id = ? AND path = ?
+	`, uri, artifactType, contentType, sizeBytes, sha256, time.Now().UTC(), version, runID, path)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
 // GetArtifactsByRunID retrieves all artifacts for a run
-func (d *SQLiteDAO) GetArtifactsByRunID(runID int) ([]ArtifactRow, error) {
-	rows, err := d.db.Query(`
-		SELECT path, uri, type
+func (d *SQLiteDAO) GetArtifactsByRunID(ctx context.Context, runID int) ([]ArtifactRow, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT path, uri, type, COALESCE(content_type, ''), size_bytes, updated_at, mirror_status, COALESCE(sha256, ''), version, scan_status, COALESCE(scan_result, '')
 		FROM artifacts
 		WHERE run_id = ?
 		ORDER BY path
@@ -421,7 +778,7 @@ func (d *SQLiteDAO) GetArtifactsByRunID(runID int) ([]ArtifactRow, error) {
 	var artifacts []ArtifactRow
 	for rows.Next() {
 		var a ArtifactRow
-		if err := rows.Scan(&a.Path, &a.URI, &a.Type); err != nil {
+		if err := rows.Scan(&a.Path, &a.URI, &a.Type, &a.ContentType, &a.SizeBytes, &a.UpdatedAt, &a.MirrorStatus, &a.SHA256, &a.Version, &a.ScanStatus, &a.ScanResult); err != nil {
 			return nil, err
 		}
 		artifacts = append(artifacts, a)
@@ -431,38 +788,2512 @@ func (d *SQLiteDAO) GetArtifactsByRunID(runID int) ([]ArtifactRow, error) {
 }
 
 // GetArtifactByRunIDAndPath retrieves a specific artifact by run ID and path
-func (d *SQLiteDAO) GetArtifactByRunIDAndPath(runID int, path string) (*ArtifactRow, error) {
+func (d *SQLiteDAO) GetArtifactByRunIDAndPath(ctx context.Context, runID int, path string) (*ArtifactRow, error) {
 	var a ArtifactRow
-	err := d.db.QueryRow(
-		"SELECT path, uri, type FROM artifacts WHERE run_id = ? AND path = ?",
+	err := d.db.QueryRowContext(ctx,
+		"SELECT path, uri, type, COALESCE(content_type, ''), size_bytes, updated_at, mirror_status, COALESCE(sha256, ''), version, scan_status, COALESCE(scan_result, '') FROM artifacts WHERE run_id = ? AND path = ?",
 		runID, path,
-	).Scan(&a.Path, &a.URI, &a.Type)
+	).Scan(&a.Path, &a.URI, &a.Type, &a.ContentType, &a.SizeBytes, &a.UpdatedAt, &a.MirrorStatus, &a.SHA256, &a.Version, &a.ScanStatus, &a.ScanResult)
 	if err != nil {
 		return nil, err
 	}
 	return &a, nil
 }
 
+// GetArtifactVersions retrieves the prior versions of an artifact, newest
+// first
+func (d *SQLiteDAO) GetArtifactVersions(ctx context.Context, runID int, path string) ([]ArtifactRow, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT path, uri, type, COALESCE(content_type, ''), size_bytes, updated_at, COALESCE(sha256, ''), version, scan_status, COALESCE(scan_result, '')
+		FROM artifact_versions
+		WHERE run_id = ? AND path = ?
+		ORDER BY version DESC
+	`, runID, path)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var versions []ArtifactRow
+	for rows.Next() {
+		var a ArtifactRow
+		if err := rows.Scan(&a.Path, &a.URI, &a.Type, &a.ContentType, &a.SizeBytes, &a.UpdatedAt, &a.SHA256, &a.Version, &a.ScanStatus, &a.ScanResult); err != nil {
+			return nil, err
+		}
+		versions = append(versions, a)
+	}
+	return versions, rows.Err()
+}
+
+// SetArtifactContentType changes the type and content type of an artifact
+func (d *SQLiteDAO) SetArtifactContentType(ctx context.Context, runID int, path, artifactType, contentType string) error {
+	_, err := d.db.ExecContext(ctx,
+		"UPDATE artifacts SET type = ?, content_type = ? WHERE run_id = ? AND path = ?",
+		artifactType, contentType, runID, path,
+	)
+	return err
+}
+
 // UpdateRunNotes updates the notes for a run
-func (d *SQLiteDAO) UpdateRunNotes(runID int, notes string) error {
-	_, err := d.db.Exec(
+func (d *SQLiteDAO) UpdateRunNotes(ctx context.Context, runID int, notes string) error {
+	_, err := d.db.ExecContext(ctx,
 		"UPDATE runs SET notes = ? WHERE id = ?",
 		notes, runID,
 	)
 	return err
 }
 
+// BackdateRun records that a run was created, and finished, at a time in the
+// past, for runs imported from elsewhere
+func (d *SQLiteDAO) BackdateRun(ctx context.Context, runID int, at time.Time) error {
+	at = at.UTC()
+	_, err := d.db.ExecContext(ctx,
+		"UPDATE runs SET created_at = ?, last_activity_at = ?, finished_at = ? WHERE id = ?",
+		at, at, at, runID,
+	)
+	return err
+}
+
 // GetExperimentForRunUUID retrieves the experiment associated with a run
-func (d *SQLiteDAO) GetExperimentForRunUUID(runUUID string) (*Experiment, error) {
+func (d *SQLiteDAO) GetExperimentForRunUUID(ctx context.Context, runUUID string) (*Experiment, error) {
 	var uuid, name, createdAt string
-	err := d.db.QueryRow(`
-		SELECT e.uuid, e.name, e.created_at
+	var projectID int
+	err := d.db.QueryRowContext(ctx, `
+		SELECT e.uuid, e.name, e.created_at, e.project_id
 		FROM experiments e
 		JOIN runs r ON r.experiment_id = e.id
 		WHERE r.uuid = ?
-	`, runUUID).Scan(&uuid, &name, &createdAt)
+	`, runUUID).Scan(&uuid, &name, &createdAt, &projectID)
+	if err != nil {
+		return nil, err
+	}
+	return &Experiment{UUID: uuid, Name: name, CreatedAt: createdAt, ProjectID: projectID}, nil
+}
+
+// InsertRunAnnotation attaches a text annotation to a run at the given step
+func (d *SQLiteDAO) InsertRunAnnotation(ctx context.Context, runID int, step float64, text string) error {
+	_, err := d.db.ExecContext(ctx,
+		"INSERT INTO run_annotations (run_id, step, text) VALUES (?, ?, ?)",
+		runID, step, text,
+	)
+	return err
+}
+
+// GetRunAnnotationsByRunID retrieves all annotations for a run ordered by step
+func (d *SQLiteDAO) GetRunAnnotationsByRunID(ctx context.Context, runID int) ([]RunAnnotationRow, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT step, text, created_at
+		FROM run_annotations
+		WHERE run_id = ?
+		ORDER BY step, id
+	`, runID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var annotations []RunAnnotationRow
+	for rows.Next() {
+		var a RunAnnotationRow
+		if err := rows.Scan(&a.Step, &a.Text, &a.CreatedAt); err != nil {
+			return nil, err
+		}
+		annotations = append(annotations, a)
+	}
+
+	return annotations, rows.Err()
+}
+
+// UpdateExperimentReadme replaces an experiment's README and records the new
+// text as a revision in its edit history
+func (d *SQLiteDAO) UpdateExperimentReadme(ctx context.Context, experimentID int, readme string) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, "UPDATE experiments SET readme = ? WHERE id = ?", readme, experimentID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO experiment_readme_revisions (experiment_id, readme) VALUES (?, ?)",
+		experimentID, readme,
+	)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetExperimentReadmeRevisions retrieves the README edit history of an experiment, newest first
+func (d *SQLiteDAO) GetExperimentReadmeRevisions(ctx context.Context, experimentID int) ([]ExperimentReadmeRevisionRow, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT readme, created_at
+		FROM experiment_readme_revisions
+		WHERE experiment_id = ?
+		ORDER BY id DESC
+	`, experimentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var revisions []ExperimentReadmeRevisionRow
+	for rows.Next() {
+		var rev ExperimentReadmeRevisionRow
+		if err := rows.Scan(&rev.Readme, &rev.CreatedAt); err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, rev)
+	}
+
+	return revisions, rows.Err()
+}
+
+// FindRunsByNameOrUUIDPrefix retrieves runs whose name matches the query
+// exactly or whose UUID starts with it, newest first
+func (d *SQLiteDAO) FindRunsByNameOrUUIDPrefix(ctx context.Context, query string) ([]Run, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT uuid, name, created_at, parent_run_id, nesting_level, status
+		FROM runs
+		WHERE (name = ? OR substr(uuid, 1, ?) = ?) AND deleted_at IS NULL
+		ORDER BY created_at DESC
+	`, query, len(query), strings.ToLower(query))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var runs []Run
+	for rows.Next() {
+		var uuid, name, createdAt, status string
+		var parentRunID sql.NullInt64
+		var nestingLevel int
+		if err := rows.Scan(&uuid, &name, &createdAt, &parentRunID, &nestingLevel, &status); err != nil {
+			return nil, err
+		}
+		run := Run{UUID: uuid, Name: name, CreatedAt: createdAt, NestingLevel: nestingLevel, Status: status}
+		if parentRunID.Valid {
+			id := int(parentRunID.Int64)
+			run.ParentRunID = &id
+		}
+		runs = append(runs, run)
+	}
+
+	return runs, rows.Err()
+}
+
+// GetLatestMetricsByRunID retrieves the point with the largest x value for each metric key of a run, leaving out system metrics
+func (d *SQLiteDAO) GetLatestMetricsByRunID(ctx context.Context, runID int) ([]MetricRow, error) {
+	return d.getLatestMetrics(ctx, runID, "key NOT LIKE 'system/%'")
+}
+
+// GetLatestSystemMetricsByRunID retrieves the point with the largest x value for each system metric key of a run
+func (d *SQLiteDAO) GetLatestSystemMetricsByRunID(ctx context.Context, runID int) ([]MetricRow, error) {
+	return d.getLatestMetrics(ctx, runID, "key LIKE 'system/%'")
+}
+
+// getLatestMetrics retrieves the latest point of each metric key of a run that matches keyFilter
+func (d *SQLiteDAO) getLatestMetrics(ctx context.Context, runID int, keyFilter string) ([]MetricRow, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT m.key, m.x_value, m.y_value, m.logged_at
+		FROM metrics m
+		JOIN (
+			SELECT key, MAX(x_value) AS x_value
+			FROM metrics
+			WHERE run_id = ? AND `+keyFilter+`
+			GROUP BY key
+		) latest ON m.key = latest.key AND m.x_value = latest.x_value
+		WHERE m.run_id = ?
+		ORDER BY m.key
+	`, runID, runID)
 	if err != nil {
 		return nil, err
 	}
-	return &Experiment{UUID: uuid, Name: name, CreatedAt: createdAt}, nil
+	defer rows.Close()
+
+	var metrics []MetricRow
+	for rows.Next() {
+		var m MetricRow
+		if err := rows.Scan(&m.Key, &m.XValue, &m.YValue, &m.LoggedAt); err != nil {
+			return nil, err
+		}
+		metrics = append(metrics, m)
+	}
+
+	return metrics, rows.Err()
+}
+
+// GetParametersByExperimentID retrieves the parameters of every run in an experiment
+func (d *SQLiteDAO) GetParametersByExperimentID(ctx context.Context, experimentID int) ([]ExperimentParameterRow, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT r.id, r.uuid, p.key, p.value_type, p.value_string, p.value_bool, p.value_float, p.value_int
+		FROM parameters p
+		JOIN runs r ON p.run_id = r.id
+		WHERE r.experiment_id = ?
+		ORDER BY p.key, r.id
+	`, experimentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var params []ExperimentParameterRow
+	for rows.Next() {
+		var p ExperimentParameterRow
+		if err := rows.Scan(&p.RunID, &p.RunUUID, &p.Key, &p.ValueType, &p.ValueString, &p.ValueBool, &p.ValueFloat, &p.ValueInt); err != nil {
+			return nil, err
+		}
+		params = append(params, p)
+	}
+
+	return params, rows.Err()
+}
+
+// InsertRunTemplate saves a named template and its parameters
+func (d *SQLiteDAO) InsertRunTemplate(ctx context.Context, name string, experimentID int, params []RunTemplateParameterRow) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, "INSERT INTO run_templates (name, experiment_id) VALUES (?, ?)", name, experimentID)
+	if err != nil {
+		return err
+	}
+	templateID, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	for _, param := range params {
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO run_template_parameters (template_id, key, value_type, value_string, value_bool, value_float, value_int, prompt)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		`, templateID, param.Key, param.ValueType, param.ValueString, param.ValueBool, param.ValueFloat, param.ValueInt, param.Prompt)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// GetRunTemplateByName retrieves a run template by its name
+func (d *SQLiteDAO) GetRunTemplateByName(ctx context.Context, name string) (*RunTemplateRow, error) {
+	var t RunTemplateRow
+	err := d.db.QueryRowContext(ctx,
+		"SELECT id, name, experiment_id, created_at FROM run_templates WHERE name = ?",
+		name,
+	).Scan(&t.ID, &t.Name, &t.ExperimentID, &t.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// GetAllRunTemplates retrieves all run templates ordered by name
+func (d *SQLiteDAO) GetAllRunTemplates(ctx context.Context) ([]RunTemplateRow, error) {
+	rows, err := d.db.QueryContext(ctx, "SELECT id, name, experiment_id, created_at FROM run_templates ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var templates []RunTemplateRow
+	for rows.Next() {
+		var t RunTemplateRow
+		if err := rows.Scan(&t.ID, &t.Name, &t.ExperimentID, &t.CreatedAt); err != nil {
+			return nil, err
+		}
+		templates = append(templates, t)
+	}
+
+	return templates, rows.Err()
+}
+
+// GetRunTemplateParameters retrieves the parameters of a run template ordered by key
+func (d *SQLiteDAO) GetRunTemplateParameters(ctx context.Context, templateID int) ([]RunTemplateParameterRow, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT key, value_type, value_string, value_bool, value_float, value_int, prompt
+		FROM run_template_parameters
+		WHERE template_id = ?
+		ORDER BY key
+	`, templateID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var params []RunTemplateParameterRow
+	for rows.Next() {
+		var param RunTemplateParameterRow
+		if err := rows.Scan(&param.Key, &param.ValueType, &param.ValueString, &param.ValueBool, &param.ValueFloat, &param.ValueInt, &param.Prompt); err != nil {
+			return nil, err
+		}
+		params = append(params, param)
+	}
+
+	return params, rows.Err()
+}
+
+// GetRuns retrieves a page of runs across all experiments matching a filter, sorted by one of the runSortColumns or a metric
+func (d *SQLiteDAO) GetRuns(ctx context.Context, offset, limit int, sortBy, sortDir string, filter RunFilter) ([]RunSummary, error) {
+	sortValue, orderBy, args, err := runsOrderBy(sortBy, sortDir, func(int) string { return "?" })
+	if err != nil {
+		return nil, err
+	}
+	where, filterArgs := runFilterWhere(filter, func(int) string { return "?" })
+	args = append(append(args, filterArgs...), limit, offset)
+	rows, err := d.db.QueryContext(ctx, fmt.Sprintf(`
+		SELECT r.id, r.uuid, r.name, r.created_at, e.uuid, e.name, r.status, r.archived_at IS NOT NULL, %s, %s AS sort_value
+		FROM runs r
+		JOIN experiments e ON r.experiment_id = e.id AND r.deleted_at IS NULL
+		%s
+		ORDER BY %s
+		LIMIT ? OFFSET ?
+	`, runReproducibleCondition, sortValue, where, orderBy), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var runs []RunSummary
+	for rows.Next() {
+		var run RunSummary
+		if err := rows.Scan(&run.ID, &run.UUID, &run.Name, &run.CreatedAt, &run.ExperimentUUID, &run.ExperimentName, &run.Status, &run.Archived, &run.Reproducible, &run.SortValue); err != nil {
+			return nil, err
+		}
+		runs = append(runs, run)
+	}
+
+	return runs, rows.Err()
+}
+
+// SetRunHold places a run on hold, recording the reason
+func (d *SQLiteDAO) SetRunHold(ctx context.Context, runID int, reason string) error {
+	_, err := d.db.ExecContext(ctx,
+		"UPDATE runs SET hold_reason = ?, held_at = ? WHERE id = ?",
+		reason, time.Now().UTC(), runID,
+	)
+	return err
+}
+
+// ClearRunHold releases the hold on a run
+func (d *SQLiteDAO) ClearRunHold(ctx context.Context, runID int) error {
+	_, err := d.db.ExecContext(ctx,
+		"UPDATE runs SET hold_reason = NULL, held_at = NULL WHERE id = ?",
+		runID,
+	)
+	return err
+}
+
+// GetRunHold retrieves the hold on a run, or nil if the run is not on hold
+func (d *SQLiteDAO) GetRunHold(ctx context.Context, runID int) (*RunHoldRow, error) {
+	var reason sql.NullString
+	var heldAt sql.NullTime
+	err := d.db.QueryRowContext(ctx,
+		"SELECT hold_reason, held_at FROM runs WHERE id = ?",
+		runID,
+	).Scan(&reason, &heldAt)
+	if err != nil {
+		return nil, err
+	}
+	if !heldAt.Valid {
+		return nil, nil
+	}
+	return &RunHoldRow{Reason: reason.String, HeldAt: heldAt.Time}, nil
+}
+
+// SetRunSource records where a run came from, storing empty fields as NULL
+func (d *SQLiteDAO) SetRunSource(ctx context.Context, runID int, source RunSourceRow) error {
+	return setSQLiteRunSource(ctx, d.db.ExecContext, runID, source)
+}
+
+// setSQLiteRunSource records where a run came from with exec, which is the
+// database's or a transaction's Exec
+func setSQLiteRunSource(ctx context.Context, exec func(ctx context.Context, query string, args ...interface{}) (sql.Result, error), runID int, source RunSourceRow) error {
+	nullable := func(s string) sql.NullString { return sql.NullString{String: s, Valid: s != ""} }
+	_, err := exec(ctx,
+		"UPDATE runs SET user_name = ?, git_commit = ?, git_branch = ?, git_dirty = ?, entrypoint = ? WHERE id = ?",
+		nullable(source.User), nullable(source.GitCommit), nullable(source.GitBranch), source.GitDirty, nullable(source.Entrypoint), runID,
+	)
+	return err
+}
+
+// GetRunSource retrieves where a run came from, or nil if nothing was recorded
+func (d *SQLiteDAO) GetRunSource(ctx context.Context, runID int) (*RunSourceRow, error) {
+	var user, commit, branch, entrypoint sql.NullString
+	var source RunSourceRow
+	err := d.db.QueryRowContext(ctx,
+		"SELECT user_name, git_commit, git_branch, git_dirty, entrypoint FROM runs WHERE id = ?",
+		runID,
+	).Scan(&user, &commit, &branch, &source.GitDirty, &entrypoint)
+	if err != nil {
+		return nil, err
+	}
+	source.User, source.GitCommit, source.GitBranch, source.Entrypoint = user.String, commit.String, branch.String, entrypoint.String
+	if source == (RunSourceRow{}) {
+		return nil, nil
+	}
+	return &source, nil
+}
+
+// SetRunForkedFrom records the UUID of the run a run was forked from
+func (d *SQLiteDAO) SetRunForkedFrom(ctx context.Context, runID int, sourceUUID string) error {
+	_, err := d.db.ExecContext(ctx, "UPDATE runs SET forked_from = ? WHERE id = ?", sourceUUID, runID)
+	return err
+}
+
+// SetRunSeeds replaces the random seeds a run was started with
+func (d *SQLiteDAO) SetRunSeeds(ctx context.Context, runID int, seeds RunSeedsRow) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := setSQLiteRunSeeds(ctx, tx.ExecContext, runID, seeds); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// setSQLiteRunSeeds replaces the random seeds a run was started with, with
+// exec, which is a transaction's Exec
+func setSQLiteRunSeeds(ctx context.Context, exec func(ctx context.Context, query string, args ...interface{}) (sql.Result, error), runID int, seeds RunSeedsRow) error {
+	seed := sql.NullString{String: seeds.Seed, Valid: seeds.Seed != ""}
+	if _, err := exec(ctx, "UPDATE runs SET seed = ? WHERE id = ?", seed, runID); err != nil {
+		return err
+	}
+	if _, err := exec(ctx, "DELETE FROM run_seeds WHERE run_id = ?", runID); err != nil {
+		return err
+	}
+	for _, l := range seeds.Libraries {
+		if _, err := exec(ctx, "INSERT INTO run_seeds (run_id, library, seed) VALUES (?, ?, ?)", runID, l.Library, l.Seed); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetRunSeeds retrieves the random seeds a run was started with, or nil if it
+// reported none
+func (d *SQLiteDAO) GetRunSeeds(ctx context.Context, runID int) (*RunSeedsRow, error) {
+	var seed sql.NullString
+	if err := d.db.QueryRowContext(ctx, "SELECT seed FROM runs WHERE id = ?", runID).Scan(&seed); err != nil {
+		return nil, err
+	}
+	seeds := RunSeedsRow{Seed: seed.String}
+
+	rows, err := d.db.QueryContext(ctx, "SELECT library, seed FROM run_seeds WHERE run_id = ? ORDER BY library", runID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var l LibrarySeedRow
+		if err := rows.Scan(&l.Library, &l.Seed); err != nil {
+			return nil, err
+		}
+		seeds.Libraries = append(seeds.Libraries, l)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if seeds.Seed == "" && len(seeds.Libraries) == 0 {
+		return nil, nil
+	}
+	return &seeds, nil
+}
+
+// InsertNotificationSubscription saves a notification subscription
+func (d *SQLiteDAO) InsertNotificationSubscription(ctx context.Context, sub NotificationSubscriptionRow) error {
+	_, err := d.db.ExecContext(ctx,
+		"INSERT INTO notification_subscriptions (experiment_id, channel, target, events, tag) VALUES (?, ?, ?, ?, ?)",
+		sub.ExperimentID, sub.Channel, sub.Target, sub.Events, sub.Tag,
+	)
+	return err
+}
+
+// GetNotificationSubscriptions retrieves all notification subscriptions, oldest first
+func (d *SQLiteDAO) GetNotificationSubscriptions(ctx context.Context) ([]NotificationSubscriptionRow, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT id, experiment_id, channel, target, events, tag, created_at
+		FROM notification_subscriptions
+		ORDER BY id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var subs []NotificationSubscriptionRow
+	for rows.Next() {
+		var sub NotificationSubscriptionRow
+		if err := rows.Scan(&sub.ID, &sub.ExperimentID, &sub.Channel, &sub.Target, &sub.Events, &sub.Tag, &sub.CreatedAt); err != nil {
+			return nil, err
+		}
+		subs = append(subs, sub)
+	}
+
+	return subs, rows.Err()
+}
+
+// DeleteNotificationSubscription removes a notification subscription
+func (d *SQLiteDAO) DeleteNotificationSubscription(ctx context.Context, id int) error {
+	_, err := d.db.ExecContext(ctx, "DELETE FROM notification_subscriptions WHERE id = ?", id)
+	return err
+}
+
+// InsertNotificationPreference saves a user's notification preference
+func (d *SQLiteDAO) InsertNotificationPreference(ctx context.Context, pref NotificationPreferenceRow) error {
+	_, err := d.db.ExecContext(ctx,
+		"INSERT INTO notification_preferences (user_name, event, experiment_id, channel, target) VALUES (?, ?, ?, ?, ?)",
+		pref.User, pref.Event, pref.ExperimentID, pref.Channel, pref.Target,
+	)
+	return err
+}
+
+// GetNotificationPreferences retrieves every user's notification preferences,
+// oldest first
+func (d *SQLiteDAO) GetNotificationPreferences(ctx context.Context) ([]NotificationPreferenceRow, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT id, user_name, event, experiment_id, channel, target, created_at
+		FROM notification_preferences
+		ORDER BY id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var prefs []NotificationPreferenceRow
+	for rows.Next() {
+		var pref NotificationPreferenceRow
+		if err := rows.Scan(&pref.ID, &pref.User, &pref.Event, &pref.ExperimentID, &pref.Channel, &pref.Target, &pref.CreatedAt); err != nil {
+			return nil, err
+		}
+		prefs = append(prefs, pref)
+	}
+
+	return prefs, rows.Err()
+}
+
+// DeleteNotificationPreference removes one of a user's notification
+// preferences, doing nothing if it is someone else's
+func (d *SQLiteDAO) DeleteNotificationPreference(ctx context.Context, id int, user string) error {
+	_, err := d.db.ExecContext(ctx, "DELETE FROM notification_preferences WHERE id = ? AND user_name = ?", id, user)
+	return err
+}
+
+// InsertWebNotification saves a notification delivered to a user on the web
+func (d *SQLiteDAO) InsertWebNotification(ctx context.Context, notification WebNotificationRow) error {
+	_, err := d.db.ExecContext(ctx,
+		"INSERT INTO web_notifications (user_name, run_uuid, message) VALUES (?, ?, ?)",
+		notification.User, notification.RunUUID, notification.Message,
+	)
+	return err
+}
+
+// GetWebNotifications retrieves the latest notifications delivered to a user
+// on the web, newest first
+func (d *SQLiteDAO) GetWebNotifications(ctx context.Context, user string, limit int) ([]WebNotificationRow, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT id, user_name, run_uuid, message, created_at
+		FROM web_notifications
+		WHERE user_name = ?
+		ORDER BY id DESC
+		LIMIT ?
+	`, user, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var notifications []WebNotificationRow
+	for rows.Next() {
+		var n WebNotificationRow
+		if err := rows.Scan(&n.ID, &n.User, &n.RunUUID, &n.Message, &n.CreatedAt); err != nil {
+			return nil, err
+		}
+		notifications = append(notifications, n)
+	}
+
+	return notifications, rows.Err()
+}
+
+// SearchRuns finds runs whose name, notes, annotations, or tags contain every term,
+// newest first. SQLite has no full-text index here (the migration and query
+// drivers support different FTS modules), so terms are matched with LIKE.
+func (d *SQLiteDAO) SearchRuns(ctx context.Context, terms []string, limit, projectID int) ([]RunSearchRow, error) {
+	var conditions []string
+	var args []interface{}
+	for _, term := range terms {
+		pattern := "%" + term + "%"
+		conditions = append(conditions, `(r.name LIKE ? OR r.notes LIKE ? OR EXISTS (
+			SELECT 1 FROM run_annotations a WHERE a.run_id = r.id AND a.text LIKE ?) OR EXISTS (
+			SELECT 1 FROM tags t WHERE t.run_id = r.id AND (t.key LIKE ? OR t.value LIKE ?)))`)
+		args = append(args, pattern, pattern, pattern, pattern, pattern)
+	}
+	args = append(args, projectID, projectID, limit)
+
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT r.uuid, r.name, r.created_at, e.uuid, e.name, COALESCE(r.notes, ''),
+			COALESCE((SELECT group_concat(a.text, ' ') FROM run_annotations a WHERE a.run_id = r.id), ''),
+			COALESCE((SELECT group_concat(t.key || ' ' || t.value, ' ') FROM tags t WHERE t.run_id = r.id), '')
+		FROM runs r
+		JOIN experiments e ON e.id = r.experiment_id
+		WHERE r.deleted_at IS NULL AND `+strings.Join(conditions, " AND ")+`
+		  AND (? = 0 OR e.project_id = ?)
+		ORDER BY r.created_at DESC, r.id DESC
+		LIMIT ?
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var results []RunSearchRow
+	for rows.Next() {
+		var r RunSearchRow
+		var notes, annotations, tags string
+		if err := rows.Scan(&r.UUID, &r.Name, &r.CreatedAt, &r.ExperimentUUID, &r.ExperimentName, &notes, &annotations, &tags); err != nil {
+			return nil, err
+		}
+		r.Snippet = searchSnippet(r.Name+" "+notes+" "+annotations+" "+tags, terms)
+		results = append(results, r)
+	}
+
+	return results, rows.Err()
+}
+
+// UpsertRunGPUSummary saves the GPU aggregates of a run, replacing any previous ones
+func (d *SQLiteDAO) UpsertRunGPUSummary(ctx context.Context, summary RunGPUSummaryRow) error {
+	_, err := d.db.ExecContext(ctx,
+		"INSERT OR REPLACE INTO run_gpu_summaries (run_id, utilization_mean, memory_peak_bytes, gpu_hours, updated_at) VALUES (?, ?, ?, ?, ?)",
+		summary.RunID, summary.UtilizationMean, summary.MemoryPeakBytes, summary.GPUHours, time.Now().UTC(),
+	)
+	return err
+}
+
+// GetRunGPUSummary retrieves the GPU aggregates of a run, or nil if it has none
+func (d *SQLiteDAO) GetRunGPUSummary(ctx context.Context, runID int) (*RunGPUSummaryRow, error) {
+	var s RunGPUSummaryRow
+	err := d.db.QueryRowContext(ctx, `
+		SELECT run_id, utilization_mean, memory_peak_bytes, gpu_hours
+		FROM run_gpu_summaries
+		WHERE run_id = ?
+	`, runID).Scan(&s.RunID, &s.UtilizationMean, &s.MemoryPeakBytes, &s.GPUHours)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// GetRunGPUSummariesByExperimentID retrieves the GPU aggregates of every run in an experiment
+func (d *SQLiteDAO) GetRunGPUSummariesByExperimentID(ctx context.Context, experimentID int) ([]RunGPUSummaryRow, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT s.run_id, s.utilization_mean, s.memory_peak_bytes, s.gpu_hours
+		FROM run_gpu_summaries s
+		JOIN runs r ON r.id = s.run_id
+		WHERE r.experiment_id = ?
+	`, experimentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var summaries []RunGPUSummaryRow
+	for rows.Next() {
+		var s RunGPUSummaryRow
+		if err := rows.Scan(&s.RunID, &s.UtilizationMean, &s.MemoryPeakBytes, &s.GPUHours); err != nil {
+			return nil, err
+		}
+		summaries = append(summaries, s)
+	}
+
+	return summaries, rows.Err()
+}
+
+// UpsertExperimentCostRate sets the price of a GPU-hour on a machine type for an experiment
+func (d *SQLiteDAO) UpsertExperimentCostRate(ctx context.Context, experimentID int, machineType string, usdPerGPUHour float64) error {
+	_, err := d.db.ExecContext(ctx,
+		"INSERT OR REPLACE INTO experiment_cost_rates (experiment_id, machine_type, usd_per_gpu_hour) VALUES (?, ?, ?)",
+		experimentID, machineType, usdPerGPUHour,
+	)
+	return err
+}
+
+// DeleteExperimentCostRate removes the rate of a machine type from an experiment's cost model
+func (d *SQLiteDAO) DeleteExperimentCostRate(ctx context.Context, experimentID int, machineType string) error {
+	_, err := d.db.ExecContext(ctx,
+		"DELETE FROM experiment_cost_rates WHERE experiment_id = ? AND machine_type = ?",
+		experimentID, machineType,
+	)
+	return err
+}
+
+// GetExperimentCostRates retrieves the cost model of an experiment, ordered by machine type
+func (d *SQLiteDAO) GetExperimentCostRates(ctx context.Context, experimentID int) ([]CostRateRow, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT machine_type, usd_per_gpu_hour
+		FROM experiment_cost_rates
+		WHERE experiment_id = ?
+		ORDER BY machine_type
+	`, experimentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rates []CostRateRow
+	for rows.Next() {
+		var rate CostRateRow
+		if err := rows.Scan(&rate.MachineType, &rate.USDPerGPUHour); err != nil {
+			return nil, err
+		}
+		rates = append(rates, rate)
+	}
+
+	return rates, rows.Err()
+}
+
+// UpsertMetricSchemaEntry declares a metric key, or pattern of keys, in an experiment's metric schema
+func (d *SQLiteDAO) UpsertMetricSchemaEntry(ctx context.Context, experimentID int, entry MetricSchemaEntryRow) error {
+	_, err := d.db.ExecContext(ctx,
+		`INSERT INTO experiment_metric_schema (experiment_id, key, min_step, max_step) VALUES (?, ?, ?, ?)
+		 ON CONFLICT (experiment_id, key) DO UPDATE SET min_step = EXCLUDED.min_step, max_step = EXCLUDED.max_step`,
+		experimentID, entry.Key, entry.MinStep, entry.MaxStep,
+	)
+	return err
+}
+
+// DeleteMetricSchemaEntry removes a key from an experiment's metric schema
+func (d *SQLiteDAO) DeleteMetricSchemaEntry(ctx context.Context, experimentID int, key string) error {
+	_, err := d.db.ExecContext(ctx,
+		"DELETE FROM experiment_metric_schema WHERE experiment_id = ? AND key = ?",
+		experimentID, key,
+	)
+	return err
+}
+
+// GetMetricSchema retrieves the metric schema of an experiment, ordered by key
+func (d *SQLiteDAO) GetMetricSchema(ctx context.Context, experimentID int) ([]MetricSchemaEntryRow, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT key, min_step, max_step
+		FROM experiment_metric_schema
+		WHERE experiment_id = ?
+		ORDER BY key
+	`, experimentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanMetricSchemaEntries(rows)
+}
+
+// GetMetricSchemaForRun retrieves the metric schema of a run's experiment, ordered by key
+func (d *SQLiteDAO) GetMetricSchemaForRun(ctx context.Context, runID int) ([]MetricSchemaEntryRow, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT s.key, s.min_step, s.max_step
+		FROM experiment_metric_schema s
+		JOIN runs r ON r.experiment_id = s.experiment_id
+		WHERE r.id = ?
+		ORDER BY s.key
+	`, runID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanMetricSchemaEntries(rows)
+}
+
+// RecordMetricSchemaWarning records that a run logged a metric outside its
+// experiment's schema, counting repeats of the same kind of problem with a key
+func (d *SQLiteDAO) RecordMetricSchemaWarning(ctx context.Context, runID int, key, kind, detail string) error {
+	_, err := d.db.ExecContext(ctx,
+		`INSERT INTO metric_schema_warnings (run_id, key, kind, detail) VALUES (?, ?, ?, ?)
+		 ON CONFLICT (run_id, key, kind) DO UPDATE SET
+			count = metric_schema_warnings.count + 1,
+			detail = EXCLUDED.detail,
+			last_seen_at = CURRENT_TIMESTAMP`,
+		runID, key, kind, detail,
+	)
+	return err
+}
+
+// GetMetricSchemaWarnings retrieves the metric schema warnings of an
+// experiment's runs, most recently seen first
+func (d *SQLiteDAO) GetMetricSchemaWarnings(ctx context.Context, experimentID int) ([]MetricSchemaWarningRow, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT r.uuid, r.name, w.key, w.kind, w.detail, w.count, w.last_seen_at
+		FROM metric_schema_warnings w
+		JOIN runs r ON r.id = w.run_id
+		WHERE r.experiment_id = ? AND r.deleted_at IS NULL
+		ORDER BY w.last_seen_at DESC, w.id DESC
+	`, experimentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var warnings []MetricSchemaWarningRow
+	for rows.Next() {
+		var w MetricSchemaWarningRow
+		if err := rows.Scan(&w.RunUUID, &w.RunName, &w.Key, &w.Kind, &w.Detail, &w.Count, &w.LastSeenAt); err != nil {
+			return nil, err
+		}
+		warnings = append(warnings, w)
+	}
+
+	return warnings, rows.Err()
+}
+
+// DeleteMetricSchemaWarnings dismisses the metric schema warnings of an experiment's runs
+func (d *SQLiteDAO) DeleteMetricSchemaWarnings(ctx context.Context, experimentID int) error {
+	_, err := d.db.ExecContext(ctx,
+		"DELETE FROM metric_schema_warnings WHERE run_id IN (SELECT id FROM runs WHERE experiment_id = ?)",
+		experimentID,
+	)
+	return err
+}
+
+// GetRunMetricNamespaces lists the namespaces of a run's metrics, ordered by
+// namespace, with how many metrics and points each has
+func (d *SQLiteDAO) GetRunMetricNamespaces(ctx context.Context, runID int) ([]MetricNamespaceRow, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT namespace, COUNT(*), SUM(point_count)
+		FROM metric_summaries
+		WHERE run_id = ?
+		GROUP BY namespace
+		ORDER BY namespace
+	`, runID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var namespaces []MetricNamespaceRow
+	for rows.Next() {
+		var ns MetricNamespaceRow
+		if err := rows.Scan(&ns.Namespace, &ns.Metrics, &ns.Points); err != nil {
+			return nil, err
+		}
+		namespaces = append(namespaces, ns)
+	}
+	return namespaces, rows.Err()
+}
+
+// UpsertMetricNamespaceSetting sets how an experiment treats the metrics of
+// a namespace, replacing what was set before
+func (d *SQLiteDAO) UpsertMetricNamespaceSetting(ctx context.Context, setting MetricNamespaceSettingRow) error {
+	_, err := d.db.ExecContext(ctx,
+		`INSERT INTO metric_namespace_settings (experiment_id, namespace, retain_points_days, alert_above, alert_below) VALUES (?, ?, ?, ?, ?)
+		 ON CONFLICT (experiment_id, namespace) DO UPDATE SET
+			retain_points_days = EXCLUDED.retain_points_days,
+			alert_above = EXCLUDED.alert_above,
+			alert_below = EXCLUDED.alert_below`,
+		setting.ExperimentID, setting.Namespace, setting.RetainPointsDays, setting.AlertAbove, setting.AlertBelow,
+	)
+	return err
+}
+
+// DeleteMetricNamespaceSetting removes what was set for a namespace of an
+// experiment's metrics, returning whether anything was
+func (d *SQLiteDAO) DeleteMetricNamespaceSetting(ctx context.Context, experimentID int, namespace string) (bool, error) {
+	result, err := d.db.ExecContext(ctx,
+		"DELETE FROM metric_namespace_settings WHERE experiment_id = ? AND namespace = ?",
+		experimentID, namespace,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// GetMetricNamespaceSettings retrieves what is set for the metric namespaces
+// of an experiment, or of every experiment if experimentID is 0, ordered by
+// experiment and namespace
+func (d *SQLiteDAO) GetMetricNamespaceSettings(ctx context.Context, experimentID int) ([]MetricNamespaceSettingRow, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT experiment_id, namespace, retain_points_days, alert_above, alert_below
+		FROM metric_namespace_settings
+		WHERE experiment_id = ? OR ? = 0
+		ORDER BY experiment_id, namespace
+	`, experimentID, experimentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanMetricNamespaceSettings(rows)
+}
+
+// GetMetricNamespaceSettingsForRun retrieves what is set for the metric
+// namespaces of a run's experiment, ordered by namespace
+func (d *SQLiteDAO) GetMetricNamespaceSettingsForRun(ctx context.Context, runID int) ([]MetricNamespaceSettingRow, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT s.experiment_id, s.namespace, s.retain_points_days, s.alert_above, s.alert_below
+		FROM metric_namespace_settings s
+		JOIN runs r ON r.experiment_id = s.experiment_id
+		WHERE r.id = ?
+		ORDER BY s.namespace
+	`, runID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanMetricNamespaceSettings(rows)
+}
+
+// GetNamespaceMetricPoints counts the points of the metrics in a namespace of
+// an experiment's runs that ended before a time, listing the runs with any
+func (d *SQLiteDAO) GetNamespaceMetricPoints(ctx context.Context, experimentID int, namespace string, endedBefore time.Time) ([]RunMetricPointsRow, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT r.id, r.uuid, COUNT(*)
+		FROM metrics m
+		JOIN runs r ON r.id = m.run_id
+		JOIN metric_summaries s ON s.run_id = m.run_id AND s.key = m.key
+		WHERE r.experiment_id = ? AND r.deleted_at IS NULL AND r.finished_at < ? AND s.namespace = ?
+		GROUP BY r.id, r.uuid
+		ORDER BY r.id
+	`, experimentID, endedBefore.UTC(), namespace)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var runs []RunMetricPointsRow
+	for rows.Next() {
+		var run RunMetricPointsRow
+		if err := rows.Scan(&run.RunID, &run.RunUUID, &run.Points); err != nil {
+			return nil, err
+		}
+		runs = append(runs, run)
+	}
+	return runs, rows.Err()
+}
+
+// DeleteNamespaceMetrics deletes the points of a run's metrics in a
+// namespace, keeping their summaries, and returns how many it deleted
+func (d *SQLiteDAO) DeleteNamespaceMetrics(ctx context.Context, runID int, namespace string) (int64, error) {
+	result, err := d.db.ExecContext(ctx, `
+		DELETE FROM metrics
+		WHERE run_id = ? AND key IN (SELECT key FROM metric_summaries WHERE run_id = ? AND namespace = ?)
+	`, runID, runID, namespace)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// RecordMetricAlert records that a run's metric crossed its namespace's alert
+// values, returning false if it already had
+func (d *SQLiteDAO) RecordMetricAlert(ctx context.Context, runID int, key string, value float64, at time.Time) (bool, error) {
+	result, err := d.db.ExecContext(ctx,
+		`INSERT INTO metric_alerts (run_id, key, value, alerted_at) VALUES (?, ?, ?, ?)
+		 ON CONFLICT (run_id, key) DO NOTHING`,
+		runID, key, value, at.UTC(),
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// SetExperimentQuota sets the limits on an experiment's usage, replacing
+// any it had
+func (d *SQLiteDAO) SetExperimentQuota(ctx context.Context, experimentID int, quota ExperimentQuotaRow) error {
+	_, err := d.db.ExecContext(ctx, `
+		INSERT INTO experiment_quotas (experiment_id, max_runs, max_metric_points_per_day, max_artifact_bytes, updated_at)
+		VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT (experiment_id) DO UPDATE SET
+			max_runs = EXCLUDED.max_runs,
+			max_metric_points_per_day = EXCLUDED.max_metric_points_per_day,
+			max_artifact_bytes = EXCLUDED.max_artifact_bytes,
+			updated_at = EXCLUDED.updated_at
+	`, experimentID, quota.MaxRuns, quota.MaxMetricPointsPerDay, quota.MaxArtifactBytes)
+	return err
+}
+
+// DeleteExperimentQuota removes the limits set on an experiment, returning
+// whether it had any
+func (d *SQLiteDAO) DeleteExperimentQuota(ctx context.Context, experimentID int) (bool, error) {
+	result, err := d.db.ExecContext(ctx, "DELETE FROM experiment_quotas WHERE experiment_id = ?", experimentID)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// GetExperimentQuota retrieves the limits set on an experiment, or nil if it
+// has none
+func (d *SQLiteDAO) GetExperimentQuota(ctx context.Context, experimentID int) (*ExperimentQuotaRow, error) {
+	var quota ExperimentQuotaRow
+	err := d.db.QueryRowContext(ctx,
+		"SELECT max_runs, max_metric_points_per_day, max_artifact_bytes FROM experiment_quotas WHERE experiment_id = ?",
+		experimentID,
+	).Scan(&quota.MaxRuns, &quota.MaxMetricPointsPerDay, &quota.MaxArtifactBytes)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &quota, nil
+}
+
+// GetExperimentUsage measures an experiment's usage, counting the metric
+// points logged to it on day
+func (d *SQLiteDAO) GetExperimentUsage(ctx context.Context, experimentID int, day string) (*ExperimentUsageRow, error) {
+	var usage ExperimentUsageRow
+	err := d.db.QueryRowContext(ctx, `
+		SELECT
+			(SELECT COUNT(*) FROM runs WHERE experiment_id = ? AND deleted_at IS NULL),
+			COALESCE((SELECT metric_points FROM experiment_metric_usage WHERE experiment_id = ? AND day = ?), 0),
+			COALESCE((SELECT SUM(a.size_bytes) FROM artifacts a JOIN runs r ON r.id = a.run_id WHERE r.experiment_id = ?), 0) +
+			COALESCE((SELECT SUM(v.size_bytes) FROM artifact_versions v JOIN runs r ON r.id = v.run_id WHERE r.experiment_id = ?), 0)
+	`, experimentID, experimentID, day, experimentID, experimentID).Scan(&usage.Runs, &usage.MetricPoints, &usage.ArtifactBytes)
+	if err != nil {
+		return nil, err
+	}
+	return &usage, nil
+}
+
+// AddExperimentMetricPoints counts metric points logged to an experiment on day
+func (d *SQLiteDAO) AddExperimentMetricPoints(ctx context.Context, experimentID int, day string, points int) error {
+	_, err := d.db.ExecContext(ctx, `
+		INSERT INTO experiment_metric_usage (experiment_id, day, metric_points) VALUES (?, ?, ?)
+		ON CONFLICT (experiment_id, day) DO UPDATE SET metric_points = experiment_metric_usage.metric_points + EXCLUDED.metric_points
+	`, experimentID, day, points)
+	return err
+}
+
+// GetRunExperimentID retrieves the ID of the experiment a run belongs to
+func (d *SQLiteDAO) GetRunExperimentID(ctx context.Context, runID int) (int, error) {
+	var experimentID int
+	err := d.db.QueryRowContext(ctx, "SELECT experiment_id FROM runs WHERE id = ?", runID).Scan(&experimentID)
+	return experimentID, err
+}
+
+// InsertRunDependency records that a run consumed something produced by an upstream run
+func (d *SQLiteDAO) InsertRunDependency(ctx context.Context, runID, upstreamRunID int, kind, artifactPath string) error {
+	_, err := d.db.ExecContext(ctx,
+		"INSERT INTO run_dependencies (run_id, upstream_run_id, kind, artifact_path) VALUES (?, ?, ?, ?)",
+		runID, upstreamRunID, kind, artifactPath,
+	)
+	return err
+}
+
+// DeleteRunDependency removes a run dependency
+func (d *SQLiteDAO) DeleteRunDependency(ctx context.Context, id int) error {
+	_, err := d.db.ExecContext(ctx, "DELETE FROM run_dependencies WHERE id = ?", id)
+	return err
+}
+
+// GetRunDependencyEdges retrieves the dependencies a run is on either end of, oldest first
+func (d *SQLiteDAO) GetRunDependencyEdges(ctx context.Context, runID int) ([]RunDependencyRow, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT d.id, d.run_id, r.uuid, r.name, d.upstream_run_id, u.uuid, u.name,
+			d.kind, d.artifact_path, d.created_at
+		FROM run_dependencies d
+		JOIN runs r ON r.id = d.run_id
+		JOIN runs u ON u.id = d.upstream_run_id
+		WHERE d.run_id = ? OR d.upstream_run_id = ?
+		ORDER BY d.id
+	`, runID, runID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var edges []RunDependencyRow
+	for rows.Next() {
+		var e RunDependencyRow
+		err := rows.Scan(&e.ID, &e.RunID, &e.RunUUID, &e.RunName, &e.UpstreamRunID, &e.UpstreamUUID, &e.UpstreamName,
+			&e.Kind, &e.ArtifactPath, &e.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		edges = append(edges, e)
+	}
+
+	return edges, rows.Err()
+}
+
+// UpsertConfusionMatrix saves a confusion matrix, replacing any logged by the run under the same key and step
+func (d *SQLiteDAO) UpsertConfusionMatrix(ctx context.Context, m ConfusionMatrixRow) error {
+	_, err := d.db.ExecContext(ctx,
+		"INSERT OR REPLACE INTO confusion_matrices (run_id, key, step, labels, matrix, logged_at) VALUES (?, ?, ?, ?, ?, ?)",
+		m.RunID, m.Key, m.Step, m.Labels, m.Matrix, time.Now().UTC(),
+	)
+	return err
+}
+
+// GetConfusionMatricesByRunID retrieves the confusion matrices of a run, ordered by key and step
+func (d *SQLiteDAO) GetConfusionMatricesByRunID(ctx context.Context, runID int) ([]ConfusionMatrixRow, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT run_id, key, step, labels, matrix, logged_at
+		FROM confusion_matrices
+		WHERE run_id = ?
+		ORDER BY key, step
+	`, runID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var matrices []ConfusionMatrixRow
+	for rows.Next() {
+		var m ConfusionMatrixRow
+		if err := rows.Scan(&m.RunID, &m.Key, &m.Step, &m.Labels, &m.Matrix, &m.LoggedAt); err != nil {
+			return nil, err
+		}
+		matrices = append(matrices, m)
+	}
+
+	return matrices, rows.Err()
+}
+
+// UpsertRunCurve saves a curve, replacing any logged by the run under the same key and step
+func (d *SQLiteDAO) UpsertRunCurve(ctx context.Context, c RunCurveRow) error {
+	_, err := d.db.ExecContext(ctx,
+		"INSERT OR REPLACE INTO run_curves (run_id, key, kind, step, points, auc, logged_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		c.RunID, c.Key, c.Kind, c.Step, c.Points, c.AUC, time.Now().UTC(),
+	)
+	return err
+}
+
+// GetRunCurvesByRunID retrieves the curves of a run, ordered by key and step
+func (d *SQLiteDAO) GetRunCurvesByRunID(ctx context.Context, runID int) ([]RunCurveRow, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT run_id, key, kind, step, points, auc, logged_at
+		FROM run_curves
+		WHERE run_id = ?
+		ORDER BY key, step
+	`, runID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var curves []RunCurveRow
+	for rows.Next() {
+		var c RunCurveRow
+		if err := rows.Scan(&c.RunID, &c.Key, &c.Kind, &c.Step, &c.Points, &c.AUC, &c.LoggedAt); err != nil {
+			return nil, err
+		}
+		curves = append(curves, c)
+	}
+
+	return curves, rows.Err()
+}
+
+// UpdateRunStatus sets the status of a run and the message it ended with, recording when it finished if the status is terminal
+func (d *SQLiteDAO) UpdateRunStatus(ctx context.Context, runID int, status, message string) error {
+	var finishedAt *time.Time
+	if status != runStatusRunning {
+		now := time.Now().UTC()
+		finishedAt = &now
+	}
+	_, err := d.db.ExecContext(ctx,
+		"UPDATE runs SET status = ?, finished_at = ?, status_message = ? WHERE id = ?",
+		status, finishedAt, sql.NullString{String: message, Valid: message != ""}, runID,
+	)
+	return err
+}
+
+// RecordRunActivity records that a run logged something at the given time
+func (d *SQLiteDAO) RecordRunActivity(ctx context.Context, runID int, at time.Time) error {
+	_, err := d.db.ExecContext(ctx, "UPDATE runs SET last_activity_at = ? WHERE id = ?", at, runID)
+	return err
+}
+
+// FailStaleRuns marks FAILED every running run that has logged nothing since
+// cutoff, or was created before it and never logged, returning their UUIDs
+func (d *SQLiteDAO) FailStaleRuns(ctx context.Context, cutoff time.Time) ([]string, error) {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id, uuid
+		FROM runs
+		WHERE status = ? AND COALESCE(last_activity_at, created_at) < ? AND deleted_at IS NULL
+	`, runStatusRunning, cutoff)
+	if err != nil {
+		return nil, err
+	}
+	var ids []int
+	var uuids []string
+	for rows.Next() {
+		var id int
+		var uuid string
+		if err := rows.Scan(&id, &uuid); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+		uuids = append(uuids, uuid)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	for _, id := range ids {
+		if _, err := tx.ExecContext(ctx,
+			"UPDATE runs SET status = ?, finished_at = ?, status_message = ? WHERE id = ?",
+			runStatusFailed, now, runStaleStatusMessage, id,
+		); err != nil {
+			return nil, err
+		}
+	}
+	return uuids, tx.Commit()
+}
+
+// UpsertTextSamples inserts or replaces the text samples a run logged under a key at a step
+func (d *SQLiteDAO) UpsertTextSamples(ctx context.Context, s TextSamplesRow) error {
+	_, err := d.db.ExecContext(ctx,
+		"INSERT OR REPLACE INTO text_samples (run_id, key, step, samples, logged_at) VALUES (?, ?, ?, ?, ?)",
+		s.RunID, s.Key, s.Step, s.Samples, time.Now().UTC(),
+	)
+	return err
+}
+
+// GetTextSampleStepsByRunID retrieves the keys and steps of a run's text samples, without the samples, ordered by key and step
+func (d *SQLiteDAO) GetTextSampleStepsByRunID(ctx context.Context, runID int) ([]TextSamplesRow, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT run_id, key, step, logged_at
+		FROM text_samples
+		WHERE run_id = ?
+		ORDER BY key, step
+	`, runID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var steps []TextSamplesRow
+	for rows.Next() {
+		var s TextSamplesRow
+		if err := rows.Scan(&s.RunID, &s.Key, &s.Step, &s.LoggedAt); err != nil {
+			return nil, err
+		}
+		steps = append(steps, s)
+	}
+
+	return steps, rows.Err()
+}
+
+// GetTextSamples retrieves the text samples a run logged under a key at a step, or nil if there are none
+func (d *SQLiteDAO) GetTextSamples(ctx context.Context, runID int, key string, step float64) (*TextSamplesRow, error) {
+	var s TextSamplesRow
+	err := d.db.QueryRowContext(ctx, `
+		SELECT run_id, key, step, samples, logged_at
+		FROM text_samples
+		WHERE run_id = ? AND key = ? AND step = ?
+	`, runID, key, step).Scan(&s.RunID, &s.Key, &s.Step, &s.Samples, &s.LoggedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// GetArtifactsToMirror retrieves artifacts pending replication to the mirror store, including failed ones with attempts remaining
+func (d *SQLiteDAO) GetArtifactsToMirror(ctx context.Context, maxAttempts, limit int) ([]ArtifactMirrorRow, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT run_id, path, uri, updated_at, mirror_attempts
+		FROM artifacts
+		WHERE mirror_status = 'pending' OR (mirror_status = 'failed' AND mirror_attempts < ?)
+		ORDER BY mirror_attempts, id
+		LIMIT ?
+	`, maxAttempts, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var artifacts []ArtifactMirrorRow
+	for rows.Next() {
+		var m ArtifactMirrorRow
+		if err := rows.Scan(&m.RunID, &m.Path, &m.URI, &m.UpdatedAt, &m.Attempts); err != nil {
+			return nil, err
+		}
+		artifacts = append(artifacts, m)
+	}
+
+	return artifacts, rows.Err()
+}
+
+// SetArtifactMirrorStatus records the outcome of an attempt to mirror an artifact, unless it has since been overwritten
+func (d *SQLiteDAO) SetArtifactMirrorStatus(ctx context.Context, m ArtifactMirrorRow, status, mirrorError string) error {
+	var mirroredAt sql.NullTime
+	if status == artifactMirrorMirrored {
+		mirroredAt = sql.NullTime{Time: time.Now().UTC(), Valid: true}
+	}
+	_, err := d.db.ExecContext(ctx, `
+		UPDATE artifacts
+		SET mirror_status = ?, mirror_attempts = ?, mirror_error = ?, mirrored_at = ?
+		WHERE run_id = ? AND path = ? AND updated_at IS ?
+	`, status, m.Attempts+1, sql.NullString{String: mirrorError, Valid: mirrorError != ""}, mirroredAt, m.RunID, m.Path, m.UpdatedAt)
+	return err
+}
+
+// GetArtifactMirrorStatusByURI retrieves the mirror status of the artifact recorded under a URI, or "" if there is none
+func (d *SQLiteDAO) GetArtifactMirrorStatusByURI(ctx context.Context, uri string) (string, error) {
+	var status string
+	err := d.db.QueryRowContext(ctx, "SELECT mirror_status FROM artifacts WHERE uri = ? LIMIT 1", uri).Scan(&status)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return status, err
+}
+
+// GetArtifactsToScan retrieves artifacts and prior versions awaiting a malware scan, least attempted first
+func (d *SQLiteDAO) GetArtifactsToScan(ctx context.Context, limit int) ([]ArtifactScanRow, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT uri, scan_attempts FROM artifacts WHERE scan_status = 'pending'
+		UNION ALL
+		SELECT uri, scan_attempts FROM artifact_versions WHERE scan_status = 'pending'
+		ORDER BY scan_attempts
+		LIMIT ?
+	`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var artifacts []ArtifactScanRow
+	for rows.Next() {
+		var a ArtifactScanRow
+		if err := rows.Scan(&a.URI, &a.Attempts); err != nil {
+			return nil, err
+		}
+		artifacts = append(artifacts, a)
+	}
+
+	return artifacts, rows.Err()
+}
+
+// RecordArtifactScan records the outcome of an attempt to scan the contents stored under a URI, unless an admin has since decided it
+func (d *SQLiteDAO) RecordArtifactScan(ctx context.Context, uri, status, result string) error {
+	for _, table := range []string{"artifacts", "artifact_versions"} {
+		_, err := d.db.ExecContext(ctx, `
+			UPDATE `+table+`
+			SET scan_status = ?, scan_result = ?, scan_attempts = scan_attempts + 1, scanned_at = ?
+			WHERE uri = ? AND scan_status = 'pending'
+		`, status, sql.NullString{String: result, Valid: result != ""}, time.Now().UTC(), uri)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetArtifactScanStatus sets the scan status of the contents stored under a URI, e.g. to release them from quarantine or have them scanned again
+func (d *SQLiteDAO) SetArtifactScanStatus(ctx context.Context, uri, status, result string) error {
+	var scannedAt sql.NullTime
+	if status != artifactScanPending {
+		scannedAt = sql.NullTime{Time: time.Now().UTC(), Valid: true}
+	}
+	for _, table := range []string{"artifacts", "artifact_versions"} {
+		_, err := d.db.ExecContext(ctx, `
+			UPDATE `+table+`
+			SET scan_status = ?, scan_result = ?, scan_attempts = 0, scanned_at = ?
+			WHERE uri = ?
+		`, status, sql.NullString{String: result, Valid: result != ""}, scannedAt, uri)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetArtifactScanStatusByURI retrieves the scan status and result of the contents stored under a URI, or "" if no artifact is
+func (d *SQLiteDAO) GetArtifactScanStatusByURI(ctx context.Context, uri string) (string, string, error) {
+	var status, result string
+	err := d.db.QueryRowContext(ctx, `
+		SELECT scan_status, COALESCE(scan_result, '') FROM artifacts WHERE uri = ?
+		UNION ALL
+		SELECT scan_status, COALESCE(scan_result, '') FROM artifact_versions WHERE uri = ?
+		LIMIT 1
+	`, uri, uri).Scan(&status, &result)
+	if err == sql.ErrNoRows {
+		return "", "", nil
+	}
+	return status, result, err
+}
+
+// SetRunTag tags a run, replacing the tag's value if it is already set
+func (d *SQLiteDAO) SetRunTag(ctx context.Context, runID int, key, value string) error {
+	_, err := d.db.ExecContext(ctx,
+		"INSERT OR REPLACE INTO tags (run_id, key, value) VALUES (?, ?, ?)",
+		runID, key, value,
+	)
+	return err
+}
+
+// GetRunTags retrieves a run's tags ordered by key
+func (d *SQLiteDAO) GetRunTags(ctx context.Context, runID int) ([]RunTagRow, error) {
+	rows, err := d.db.QueryContext(ctx, "SELECT key, value FROM tags WHERE run_id = ? ORDER BY key", runID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tags []RunTagRow
+	for rows.Next() {
+		var t RunTagRow
+		if err := rows.Scan(&t.Key, &t.Value); err != nil {
+			return nil, err
+		}
+		tags = append(tags, t)
+	}
+
+	return tags, rows.Err()
+}
+
+// DeleteRunTag removes a tag from a run, reporting whether it was set
+func (d *SQLiteDAO) DeleteRunTag(ctx context.Context, runID int, key string) (bool, error) {
+	result, err := d.db.ExecContext(ctx, "DELETE FROM tags WHERE run_id = ? AND key = ?", runID, key)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// InsertAPIToken saves a new API token by the hash of its secret, scoped to
+// a project unless projectID is null, and issued to a user unless userID is
+// null
+func (d *SQLiteDAO) InsertAPIToken(ctx context.Context, name, tokenHash string, projectID, userID sql.NullInt64) error {
+	_, err := d.db.ExecContext(ctx, "INSERT INTO api_tokens (name, token_hash, project_id, user_id) VALUES (?, ?, ?, ?)", name, tokenHash, projectID, userID)
+	return err
+}
+
+// InsertKioskToken saves a new kiosk token, which shows an experiment's
+// wallboard
+func (d *SQLiteDAO) InsertKioskToken(ctx context.Context, name, tokenHash string, experimentID int) error {
+	_, err := d.db.ExecContext(ctx, "INSERT INTO api_tokens (name, token_hash, kiosk_experiment_id) VALUES (?, ?, ?)", name, tokenHash, experimentID)
+	return err
+}
+
+// GetAPITokenByHash retrieves the API token with a hash, or nil if there is none
+func (d *SQLiteDAO) GetAPITokenByHash(ctx context.Context, tokenHash string) (*APITokenRow, error) {
+	var t APITokenRow
+	err := d.db.QueryRowContext(ctx,
+		"SELECT id, name, token_hash, created_at, revoked_at, project_id, user_id, kiosk_experiment_id FROM api_tokens WHERE token_hash = ?",
+		tokenHash,
+	).Scan(&t.ID, &t.Name, &t.TokenHash, &t.CreatedAt, &t.RevokedAt, &t.ProjectID, &t.UserID, &t.KioskExperimentID)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// GetAllAPITokens retrieves all API tokens, including revoked ones, ordered by name
+func (d *SQLiteDAO) GetAllAPITokens(ctx context.Context) ([]APITokenRow, error) {
+	rows, err := d.db.QueryContext(ctx, "SELECT id, name, token_hash, created_at, revoked_at, project_id, user_id, kiosk_experiment_id FROM api_tokens ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []APITokenRow
+	for rows.Next() {
+		var t APITokenRow
+		if err := rows.Scan(&t.ID, &t.Name, &t.TokenHash, &t.CreatedAt, &t.RevokedAt, &t.ProjectID, &t.UserID, &t.KioskExperimentID); err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, t)
+	}
+	return tokens, rows.Err()
+}
+
+// RevokeAPIToken revokes the API token with a name, returning false if there
+// is no such unrevoked token
+func (d *SQLiteDAO) RevokeAPIToken(ctx context.Context, name string) (bool, error) {
+	result, err := d.db.ExecContext(ctx,
+		"UPDATE api_tokens SET revoked_at = ? WHERE name = ? AND revoked_at IS NULL",
+		time.Now().UTC(), name,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// InsertUser saves a new user with a role
+func (d *SQLiteDAO) InsertUser(ctx context.Context, name, role string) error {
+	_, err := d.db.ExecContext(ctx, "INSERT INTO users (name, role) VALUES (?, ?)", name, role)
+	return err
+}
+
+// GetUserByID retrieves a user by ID, or nil if there is none
+func (d *SQLiteDAO) GetUserByID(ctx context.Context, id int) (*UserRow, error) {
+	var u UserRow
+	err := d.db.QueryRowContext(ctx, "SELECT id, name, role, created_at FROM users WHERE id = ?", id).Scan(&u.ID, &u.Name, &u.Role, &u.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// GetUserByName retrieves a user by name, or nil if there is none
+func (d *SQLiteDAO) GetUserByName(ctx context.Context, name string) (*UserRow, error) {
+	var u UserRow
+	err := d.db.QueryRowContext(ctx, "SELECT id, name, role, created_at FROM users WHERE name = ?", name).Scan(&u.ID, &u.Name, &u.Role, &u.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// GetAllUsers retrieves all users, ordered by name
+func (d *SQLiteDAO) GetAllUsers(ctx context.Context) ([]UserRow, error) {
+	rows, err := d.db.QueryContext(ctx, "SELECT id, name, role, created_at FROM users ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []UserRow
+	for rows.Next() {
+		var u UserRow
+		if err := rows.Scan(&u.ID, &u.Name, &u.Role, &u.CreatedAt); err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+// SetUserRole changes a user's role
+func (d *SQLiteDAO) SetUserRole(ctx context.Context, id int, role string) error {
+	_, err := d.db.ExecContext(ctx, "UPDATE users SET role = ? WHERE id = ?", role, id)
+	return err
+}
+
+// DeleteUser removes a user, revoking the tokens issued to them
+func (d *SQLiteDAO) DeleteUser(ctx context.Context, id int) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "UPDATE api_tokens SET revoked_at = ? WHERE user_id = ? AND revoked_at IS NULL", time.Now().UTC(), id); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM users WHERE id = ?", id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ReplaceRunEnvironment replaces a run's environment variable snapshot
+func (d *SQLiteDAO) ReplaceRunEnvironment(ctx context.Context, runID int, variables []EnvironmentVariableRow) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM run_environment WHERE run_id = ?", runID); err != nil {
+		return err
+	}
+	for _, v := range variables {
+		_, err := tx.ExecContext(ctx,
+			"INSERT INTO run_environment (run_id, key, value, redacted) VALUES (?, ?, ?, ?)",
+			runID, v.Key, v.Value, v.Redacted,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// GetRunEnvironment retrieves a run's environment variables ordered by key
+func (d *SQLiteDAO) GetRunEnvironment(ctx context.Context, runID int) ([]EnvironmentVariableRow, error) {
+	rows, err := d.db.QueryContext(ctx, "SELECT key, value, redacted FROM run_environment WHERE run_id = ? ORDER BY key", runID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var variables []EnvironmentVariableRow
+	for rows.Next() {
+		var v EnvironmentVariableRow
+		if err := rows.Scan(&v.Key, &v.Value, &v.Redacted); err != nil {
+			return nil, err
+		}
+		variables = append(variables, v)
+	}
+	return variables, rows.Err()
+}
+
+// UpsertBestCheckpointRule sets the rule an experiment's runs choose their best checkpoint by
+func (d *SQLiteDAO) UpsertBestCheckpointRule(ctx context.Context, experimentID int, rule BestCheckpointRuleRow) error {
+	_, err := d.db.ExecContext(ctx,
+		"INSERT OR REPLACE INTO best_checkpoint_rules (experiment_id, metric_key, mode, artifact_pattern) VALUES (?, ?, ?, ?)",
+		experimentID, rule.MetricKey, rule.Mode, rule.ArtifactPattern,
+	)
+	return err
+}
+
+// DeleteBestCheckpointRule removes an experiment's best checkpoint rule
+func (d *SQLiteDAO) DeleteBestCheckpointRule(ctx context.Context, experimentID int) error {
+	_, err := d.db.ExecContext(ctx, "DELETE FROM best_checkpoint_rules WHERE experiment_id = ?", experimentID)
+	return err
+}
+
+// GetBestCheckpointRule retrieves an experiment's best checkpoint rule, or nil if it has none
+func (d *SQLiteDAO) GetBestCheckpointRule(ctx context.Context, experimentID int) (*BestCheckpointRuleRow, error) {
+	var rule BestCheckpointRuleRow
+	err := d.db.QueryRowContext(ctx,
+		"SELECT metric_key, mode, artifact_pattern FROM best_checkpoint_rules WHERE experiment_id = ?",
+		experimentID,
+	).Scan(&rule.MetricKey, &rule.Mode, &rule.ArtifactPattern)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &rule, nil
+}
+
+// SetRunBestCheckpoint designates a run's best checkpoint, replacing any other
+func (d *SQLiteDAO) SetRunBestCheckpoint(ctx context.Context, c RunBestCheckpointRow) error {
+	_, err := d.db.ExecContext(ctx,
+		"INSERT OR REPLACE INTO run_best_checkpoints (run_id, path, source, metric_key, metric_value, designated_at) VALUES (?, ?, ?, ?, ?, ?)",
+		c.RunID, c.Path, c.Source, c.MetricKey, c.MetricValue, c.DesignatedAt,
+	)
+	return err
+}
+
+// ClearRunBestCheckpoint removes a run's best checkpoint designation,
+// reporting whether it had one
+func (d *SQLiteDAO) ClearRunBestCheckpoint(ctx context.Context, runID int) (bool, error) {
+	result, err := d.db.ExecContext(ctx, "DELETE FROM run_best_checkpoints WHERE run_id = ?", runID)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// GetRunBestCheckpoint retrieves a run's best checkpoint, or nil if it has none
+func (d *SQLiteDAO) GetRunBestCheckpoint(ctx context.Context, runID int) (*RunBestCheckpointRow, error) {
+	var c RunBestCheckpointRow
+	err := d.db.QueryRowContext(ctx,
+		"SELECT run_id, path, source, metric_key, metric_value, designated_at FROM run_best_checkpoints WHERE run_id = ?",
+		runID,
+	).Scan(&c.RunID, &c.Path, &c.Source, &c.MetricKey, &c.MetricValue, &c.DesignatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// GetRunBestCheckpointsByExperimentID retrieves the best checkpoints of every run in an experiment
+func (d *SQLiteDAO) GetRunBestCheckpointsByExperimentID(ctx context.Context, experimentID int) ([]RunBestCheckpointRow, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT c.run_id, c.path, c.source, c.metric_key, c.metric_value, c.designated_at
+		FROM run_best_checkpoints c
+		JOIN runs r ON r.id = c.run_id
+		WHERE r.experiment_id = ?
+	`, experimentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var checkpoints []RunBestCheckpointRow
+	for rows.Next() {
+		var c RunBestCheckpointRow
+		if err := rows.Scan(&c.RunID, &c.Path, &c.Source, &c.MetricKey, &c.MetricValue, &c.DesignatedAt); err != nil {
+			return nil, err
+		}
+		checkpoints = append(checkpoints, c)
+	}
+	return checkpoints, rows.Err()
+}
+
+// GetAllArtifactURIs retrieves the URI of every artifact, including prior
+// versions
+func (d *SQLiteDAO) GetAllArtifactURIs(ctx context.Context) ([]string, error) {
+	rows, err := d.db.QueryContext(ctx, "SELECT uri FROM artifacts UNION SELECT uri FROM artifact_versions")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var uris []string
+	for rows.Next() {
+		var uri string
+		if err := rows.Scan(&uri); err != nil {
+			return nil, err
+		}
+		uris = append(uris, uri)
+	}
+	return uris, rows.Err()
+}
+
+// RelocateArtifactURI rewrites the URI of every artifact and prior version
+// recorded under from to to, returning how many it rewrote
+func (d *SQLiteDAO) RelocateArtifactURI(ctx context.Context, from, to string) (int, error) {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	relocated := 0
+	for _, table := range []string{"artifacts", "artifact_versions"} {
+		result, err := tx.ExecContext(ctx, `UPDATE `+table+` SET uri = ? WHERE uri = ?`, to, from)
+		if err != nil {
+			return 0, err
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return 0, err
+		}
+		relocated += int(n)
+	}
+	return relocated, tx.Commit()
+}
+
+// InsertArtifactUpload records a chunked artifact upload in progress
+func (d *SQLiteDAO) InsertArtifactUpload(ctx context.Context, u ArtifactUploadRow) error {
+	_, err := d.db.ExecContext(ctx, `
+		INSERT INTO artifact_uploads (uuid, run_id, path, size_bytes, sha256, chunk_size, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, u.UUID, u.RunID, u.Path, u.SizeBytes, u.SHA256, u.ChunkSize, u.CreatedAt, u.UpdatedAt)
+	return err
+}
+
+// GetArtifactUpload retrieves a chunked artifact upload by UUID
+func (d *SQLiteDAO) GetArtifactUpload(ctx context.Context, uuid string) (*ArtifactUploadRow, error) {
+	var u ArtifactUploadRow
+	err := d.db.QueryRowContext(ctx,
+		"SELECT uuid, run_id, path, size_bytes, sha256, chunk_size, created_at, updated_at FROM artifact_uploads WHERE uuid = ?",
+		uuid,
+	).Scan(&u.UUID, &u.RunID, &u.Path, &u.SizeBytes, &u.SHA256, &u.ChunkSize, &u.CreatedAt, &u.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// FindArtifactUpload retrieves the most recent chunked upload of the same
+// contents to an artifact's path, or nil if there is none, so that an
+// interrupted upload can be resumed
+func (d *SQLiteDAO) FindArtifactUpload(ctx context.Context, runID int, path, sha256 string, sizeBytes int64) (*ArtifactUploadRow, error) {
+	var u ArtifactUploadRow
+	err := d.db.QueryRowContext(ctx, `
+		SELECT uuid, run_id, path, size_bytes, sha256, chunk_size, created_at, updated_at
+		FROM artifact_uploads
+		WHERE run_id = ? AND path = ? AND sha256 = ? AND size_bytes = ?
+		ORDER BY updated_at DESC
+		LIMIT 1
+	`, runID, path, sha256, sizeBytes).Scan(&u.UUID, &u.RunID, &u.Path, &u.SizeBytes, &u.SHA256, &u.ChunkSize, &u.CreatedAt, &u.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// TouchArtifactUpload records that a chunk of an upload was received
+func (d *SQLiteDAO) TouchArtifactUpload(ctx context.Context, uuid string, at time.Time) error {
+	_, err := d.db.ExecContext(ctx, "UPDATE artifact_uploads SET updated_at = ? WHERE uuid = ?", at, uuid)
+	return err
+}
+
+// DeleteArtifactUpload removes a chunked artifact upload
+func (d *SQLiteDAO) DeleteArtifactUpload(ctx context.Context, uuid string) error {
+	_, err := d.db.ExecContext(ctx, "DELETE FROM artifact_uploads WHERE uuid = ?", uuid)
+	return err
+}
+
+// GetArtifactUploads retrieves every chunked artifact upload in progress,
+// oldest first
+func (d *SQLiteDAO) GetArtifactUploads(ctx context.Context) ([]ArtifactUploadRow, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT uuid, run_id, path, size_bytes, sha256, chunk_size, created_at, updated_at
+		FROM artifact_uploads
+		ORDER BY created_at, id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var uploads []ArtifactUploadRow
+	for rows.Next() {
+		var u ArtifactUploadRow
+		if err := rows.Scan(&u.UUID, &u.RunID, &u.Path, &u.SizeBytes, &u.SHA256, &u.ChunkSize, &u.CreatedAt, &u.UpdatedAt); err != nil {
+			return nil, err
+		}
+		uploads = append(uploads, u)
+	}
+	return uploads, rows.Err()
+}
+
+// InsertHousekeepingReport records a housekeeping report, returning its ID
+func (d *SQLiteDAO) InsertHousekeepingReport(ctx context.Context, r HousekeepingReportRow) (int, error) {
+	result, err := d.db.ExecContext(ctx, `
+		INSERT INTO housekeeping_reports (job, dry_run, uri, item_count, run_count, size_bytes, plan_id, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, r.Job, r.DryRun, r.URI, r.ItemCount, r.RunCount, r.SizeBytes, r.PlanID, r.CreatedAt)
+	if err != nil {
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	return int(id), err
+}
+
+// GetHousekeepingReport retrieves a housekeeping report, or nil if there is none
+func (d *SQLiteDAO) GetHousekeepingReport(ctx context.Context, id int) (*HousekeepingReportRow, error) {
+	var r HousekeepingReportRow
+	err := d.db.QueryRowContext(ctx, `
+		SELECT id, job, dry_run, uri, item_count, run_count, size_bytes, plan_id, created_at, executed_at
+		FROM housekeeping_reports WHERE id = ?
+	`, id).Scan(&r.ID, &r.Job, &r.DryRun, &r.URI, &r.ItemCount, &r.RunCount, &r.SizeBytes, &r.PlanID, &r.CreatedAt, &r.ExecutedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// GetHousekeepingReports retrieves the most recent housekeeping reports, newest first
+func (d *SQLiteDAO) GetHousekeepingReports(ctx context.Context, limit int) ([]HousekeepingReportRow, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT id, job, dry_run, uri, item_count, run_count, size_bytes, plan_id, created_at, executed_at
+		FROM housekeeping_reports
+		ORDER BY created_at DESC, id DESC
+		LIMIT ?
+	`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var reports []HousekeepingReportRow
+	for rows.Next() {
+		var r HousekeepingReportRow
+		if err := rows.Scan(&r.ID, &r.Job, &r.DryRun, &r.URI, &r.ItemCount, &r.RunCount, &r.SizeBytes, &r.PlanID, &r.CreatedAt, &r.ExecutedAt); err != nil {
+			return nil, err
+		}
+		reports = append(reports, r)
+	}
+	return reports, rows.Err()
+}
+
+// MarkHousekeepingReportExecuted records that a dry run was carried out,
+// returning false if it already had been
+func (d *SQLiteDAO) MarkHousekeepingReportExecuted(ctx context.Context, id int, executedAt time.Time) (bool, error) {
+	result, err := d.db.ExecContext(ctx,
+		"UPDATE housekeeping_reports SET executed_at = ? WHERE id = ? AND dry_run AND executed_at IS NULL",
+		executedAt, id,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// AcquireJobLease takes or renews the lease on a background job for holder
+// until now+ttl, returning false if another holder's lease has not expired
+func (d *SQLiteDAO) AcquireJobLease(ctx context.Context, job, holder string, now time.Time, ttl time.Duration) (bool, error) {
+	result, err := d.db.ExecContext(ctx, `
+		INSERT INTO job_leases (job, holder, expires_at) VALUES (?, ?, ?)
+		ON CONFLICT (job) DO UPDATE SET holder = excluded.holder, expires_at = excluded.expires_at
+		WHERE job_leases.holder = excluded.holder OR job_leases.expires_at < ?
+	`, job, holder, now.Add(ttl), now)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// GetCacheGeneration retrieves the generation of a cache, which is 0 until
+// the cache is first invalidated
+func (d *SQLiteDAO) GetCacheGeneration(ctx context.Context, name string) (int64, error) {
+	var generation int64
+	err := d.db.QueryRowContext(ctx, "SELECT generation FROM cache_generations WHERE name = ?", name).Scan(&generation)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return generation, err
+}
+
+// BumpCacheGeneration invalidates a cache on every replica
+func (d *SQLiteDAO) BumpCacheGeneration(ctx context.Context, name string) error {
+	_, err := d.db.ExecContext(ctx, `
+		INSERT INTO cache_generations (name, generation) VALUES (?, 1)
+		ON CONFLICT (name) DO UPDATE SET generation = cache_generations.generation + 1
+	`, name)
+	return err
+}
+
+// InsertArtifactCacheInvalidation records that the artifact stored at uri
+// was written or deleted, for the other replicas to drop from their caches
+func (d *SQLiteDAO) InsertArtifactCacheInvalidation(ctx context.Context, uri string, at time.Time) error {
+	_, err := d.db.ExecContext(ctx, "INSERT INTO artifact_cache_invalidations (uri, invalidated_at) VALUES (?, ?)", uri, at)
+	return err
+}
+
+// GetArtifactCacheInvalidations retrieves the artifact cache invalidations
+// recorded at or after since, oldest first
+func (d *SQLiteDAO) GetArtifactCacheInvalidations(ctx context.Context, since time.Time) ([]ArtifactCacheInvalidationRow, error) {
+	rows, err := d.db.QueryContext(ctx, "SELECT id, uri, invalidated_at FROM artifact_cache_invalidations WHERE invalidated_at >= ? ORDER BY invalidated_at, id", since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var invalidations []ArtifactCacheInvalidationRow
+	for rows.Next() {
+		var inv ArtifactCacheInvalidationRow
+		if err := rows.Scan(&inv.ID, &inv.URI, &inv.InvalidatedAt); err != nil {
+			return nil, err
+		}
+		invalidations = append(invalidations, inv)
+	}
+	return invalidations, rows.Err()
+}
+
+// DeleteArtifactCacheInvalidations deletes the artifact cache invalidations
+// recorded before a time
+func (d *SQLiteDAO) DeleteArtifactCacheInvalidations(ctx context.Context, before time.Time) error {
+	_, err := d.db.ExecContext(ctx, "DELETE FROM artifact_cache_invalidations WHERE invalidated_at < ?", before)
+	return err
+}
+
+// NotifyRunEvent does nothing: a SQLite database is used by a single server,
+// which delivers its run events itself
+func (d *SQLiteDAO) NotifyRunEvent(ctx context.Context, payload string) error {
+	return nil
+}
+
+// MarkRunDeleted hides a run, so that its data can be purged, recording the
+// email address of whoever deleted it, if given, to tell before it is
+// purged. Runs already deleted keep their deletion time.
+func (d *SQLiteDAO) MarkRunDeleted(ctx context.Context, runID int, at time.Time, deletedBy string) error {
+	_, err := d.db.ExecContext(ctx, "UPDATE runs SET deleted_at = ?, deleted_by = NULLIF(?, '') WHERE id = ? AND deleted_at IS NULL", at, deletedBy, runID)
+	return err
+}
+
+// MarkRunPurgeNoticeSent records that whoever deleted a run was told it is
+// about to be purged
+func (d *SQLiteDAO) MarkRunPurgeNoticeSent(ctx context.Context, runID int, at time.Time) error {
+	_, err := d.db.ExecContext(ctx, "UPDATE runs SET purge_notice_sent_at = ? WHERE id = ? AND deleted_at IS NOT NULL", at, runID)
+	return err
+}
+
+// GetDeletedRuns retrieves the deleted runs whose data has not been purged,
+// in the order they were deleted
+func (d *SQLiteDAO) GetDeletedRuns(ctx context.Context) ([]DeletedRunRow, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT r.id, r.uuid, r.name, r.parent_run_id, COALESCE(e.uuid, ''), COALESCE(e.name, ''), COALESCE(e.project_id, 0),
+			r.deleted_at, COALESCE(r.deleted_by, ''), r.purge_notice_sent_at
+		FROM runs r
+		LEFT JOIN experiments e ON e.id = r.experiment_id
+		WHERE r.deleted_at IS NOT NULL
+		ORDER BY r.deleted_at, r.id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var runs []DeletedRunRow
+	for rows.Next() {
+		var r DeletedRunRow
+		if err := rows.Scan(&r.ID, &r.UUID, &r.Name, &r.ParentRunID, &r.ExperimentUUID, &r.ExperimentName, &r.ProjectID,
+			&r.DeletedAt, &r.DeletedBy, &r.PurgeNoticeSentAt); err != nil {
+			return nil, err
+		}
+		runs = append(runs, r)
+	}
+	return runs, rows.Err()
+}
+
+// RestoreRun takes a run out of the trash, forgetting who deleted it and
+// any purge notice sent. It refuses runs that have not been deleted.
+func (d *SQLiteDAO) RestoreRun(ctx context.Context, runID int) error {
+	result, err := d.db.ExecContext(ctx,
+		"UPDATE runs SET deleted_at = NULL, deleted_by = NULL, purge_notice_sent_at = NULL WHERE id = ? AND deleted_at IS NOT NULL",
+		runID,
+	)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return fmt.Errorf("run %d does not exist or has not been deleted", runID)
+	}
+	return nil
+}
+
+// PurgeRun deletes a deleted run and every row belonging to it. It refuses
+// to purge runs that have not been deleted.
+func (d *SQLiteDAO) PurgeRun(ctx context.Context, runID int) error {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, "DELETE FROM runs WHERE id = ? AND deleted_at IS NOT NULL", runID)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return fmt.Errorf("run %d does not exist or has not been deleted", runID)
+	}
+	for _, table := range runDataTables {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM "+table+" WHERE run_id = ?", runID); err != nil {
+			return fmt.Errorf("purging %s: %w", table, err)
+		}
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM run_dependencies WHERE run_id = ? OR upstream_run_id = ?", runID, runID); err != nil {
+		return fmt.Errorf("purging run_dependencies: %w", err)
+	}
+	return tx.Commit()
+}
+
+// ArchiveRun archives a run, unless it is already archived
+func (d *SQLiteDAO) ArchiveRun(ctx context.Context, runID int, at time.Time) error {
+	_, err := d.db.ExecContext(ctx, "UPDATE runs SET archived_at = ? WHERE id = ? AND archived_at IS NULL", at.UTC(), runID)
+	return err
+}
+
+// UnarchiveRun restores an archived run
+func (d *SQLiteDAO) UnarchiveRun(ctx context.Context, runID int) error {
+	_, err := d.db.ExecContext(ctx, "UPDATE runs SET archived_at = NULL WHERE id = ?", runID)
+	return err
+}
+
+// GetRunsToArchive retrieves the runs created before a time that are not
+// archived, deleted, on hold or still running, oldest first
+func (d *SQLiteDAO) GetRunsToArchive(ctx context.Context, createdBefore time.Time) ([]RetentionRunRow, error) {
+	return d.getRetentionRuns(ctx, `
+		SELECT id, uuid, name
+		FROM runs
+		WHERE created_at < ? AND status != ? AND archived_at IS NULL AND deleted_at IS NULL AND held_at IS NULL
+		ORDER BY created_at, id
+	`, createdBefore.UTC(), runStatusRunning)
+}
+
+// GetRunsArchivedBefore retrieves the runs archived before a time that are
+// not deleted, in the order they were archived
+func (d *SQLiteDAO) GetRunsArchivedBefore(ctx context.Context, cutoff time.Time) ([]RetentionRunRow, error) {
+	return d.getRetentionRuns(ctx, `
+		SELECT id, uuid, name
+		FROM runs
+		WHERE archived_at < ? AND deleted_at IS NULL
+		ORDER BY archived_at, id
+	`, cutoff.UTC())
+}
+
+func (d *SQLiteDAO) getRetentionRuns(ctx context.Context, query string, args ...interface{}) ([]RetentionRunRow, error) {
+	rows, err := d.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var runs []RetentionRunRow
+	for rows.Next() {
+		var r RetentionRunRow
+		if err := rows.Scan(&r.ID, &r.UUID, &r.Name); err != nil {
+			return nil, err
+		}
+		runs = append(runs, r)
+	}
+	return runs, rows.Err()
+}
+
+// MergeRunMetrics moves a run's metric points to another run, returning how
+// many were moved. Where both runs have a point at the same step of a metric,
+// the one logged later is kept.
+func (d *SQLiteDAO) MergeRunMetrics(ctx context.Context, sourceRunID, targetRunID int) (int, error) {
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `
+		DELETE FROM metrics
+		WHERE run_id = ? AND EXISTS (
+			SELECT 1 FROM metrics s
+			WHERE s.run_id = ? AND s.key = metrics.key AND s.x_value = metrics.x_value AND s.logged_at > metrics.logged_at
+		)
+	`, targetRunID, sourceRunID); err != nil {
+		return 0, err
+	}
+	if _, err := tx.ExecContext(ctx, `
+		DELETE FROM metrics
+		WHERE run_id = ? AND EXISTS (
+			SELECT 1 FROM metrics t
+			WHERE t.run_id = ? AND t.key = metrics.key AND t.x_value = metrics.x_value
+		)
+	`, sourceRunID, targetRunID); err != nil {
+		return 0, err
+	}
+	result, err := tx.ExecContext(ctx, "UPDATE metrics SET run_id = ? WHERE run_id = ?", targetRunID, sourceRunID)
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	// Moved and deleted points do not fire the trigger that maintains the
+	// summaries, so both runs' are recomputed
+	if _, err := tx.ExecContext(ctx, "DELETE FROM metric_summaries WHERE run_id IN (?, ?)", sourceRunID, targetRunID); err != nil {
+		return 0, err
+	}
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf(metricSummariesInsert, "run_id = ?"), targetRunID); err != nil {
+		return 0, err
+	}
+	return int(n), tx.Commit()
+}
+
+// InsertAuditLogEntry records an administrative operation in the audit log
+func (d *SQLiteDAO) InsertAuditLogEntry(ctx context.Context, e AuditLogRow) error {
+	_, err := d.db.ExecContext(ctx,
+		"INSERT INTO audit_log (action, subject, details, created_at) VALUES (?, ?, ?, ?)",
+		e.Action, e.Subject, e.Details, e.CreatedAt,
+	)
+	return err
+}
+
+// GetAuditLog retrieves the most recent audit log entries, newest first
+func (d *SQLiteDAO) GetAuditLog(ctx context.Context, limit int) ([]AuditLogRow, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT id, action, subject, details, created_at
+		FROM audit_log
+		ORDER BY created_at DESC, id DESC
+		LIMIT ?
+	`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []AuditLogRow
+	for rows.Next() {
+		var e AuditLogRow
+		if err := rows.Scan(&e.ID, &e.Action, &e.Subject, &e.Details, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// GetParameterKeys retrieves the parameter keys logged to an experiment's
+// runs, once per value type they were logged as
+func (d *SQLiteDAO) GetParameterKeys(ctx context.Context, experimentID, projectID int) ([]SchemaKeyRow, error) {
+	return d.getSchemaKeys(ctx, `
+		SELECT p.key, p.value_type, COUNT(DISTINCT p.run_id)
+		FROM parameters p
+		JOIN runs r ON r.id = p.run_id
+		WHERE r.deleted_at IS NULL AND (? = 0 OR r.experiment_id = ?)
+		  AND (? = 0 OR r.experiment_id IN (SELECT id FROM experiments WHERE project_id = ?))
+		GROUP BY p.key, p.value_type
+		ORDER BY p.key, p.value_type
+	`, experimentID, projectID)
+}
+
+// GetMetricKeys retrieves the metric keys logged to an experiment's runs
+func (d *SQLiteDAO) GetMetricKeys(ctx context.Context, experimentID, projectID int) ([]SchemaKeyRow, error) {
+	return d.getSchemaKeys(ctx, `
+		SELECT m.key, '', COUNT(DISTINCT m.run_id)
+		FROM metrics m
+		JOIN runs r ON r.id = m.run_id
+		WHERE r.deleted_at IS NULL AND (? = 0 OR r.experiment_id = ?)
+		  AND (? = 0 OR r.experiment_id IN (SELECT id FROM experiments WHERE project_id = ?)) AND m.key NOT LIKE 'system/%'
+		GROUP BY m.key
+		ORDER BY m.key
+	`, experimentID, projectID)
+}
+
+// GetTagKeys retrieves the tag keys set on an experiment's runs
+func (d *SQLiteDAO) GetTagKeys(ctx context.Context, experimentID, projectID int) ([]SchemaKeyRow, error) {
+	return d.getSchemaKeys(ctx, `
+		SELECT t.key, '', COUNT(DISTINCT t.run_id)
+		FROM tags t
+		JOIN runs r ON r.id = t.run_id
+		WHERE r.deleted_at IS NULL AND (? = 0 OR r.experiment_id = ?)
+		  AND (? = 0 OR r.experiment_id IN (SELECT id FROM experiments WHERE project_id = ?))
+		GROUP BY t.key
+		ORDER BY t.key
+	`, experimentID, projectID)
+}
+
+func (d *SQLiteDAO) getSchemaKeys(ctx context.Context, query string, experimentID, projectID int) ([]SchemaKeyRow, error) {
+	rows, err := d.db.QueryContext(ctx, query, experimentID, experimentID, projectID, projectID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []SchemaKeyRow
+	for rows.Next() {
+		var k SchemaKeyRow
+		if err := rows.Scan(&k.Key, &k.ValueType, &k.RunCount); err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+// GetMetricsLoggedBetween retrieves the points of a metric series of a run
+// logged between from and to inclusive, ordered by when they were logged
+func (d *SQLiteDAO) GetMetricsLoggedBetween(ctx context.Context, runID int, key string, from, to time.Time) ([]MetricRow, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT key, x_value, y_value, logged_at
+		FROM metrics
+		WHERE run_id = ? AND key = ? AND logged_at >= ? AND logged_at <= ?
+		ORDER BY logged_at, x_value
+	`, runID, key, from.UTC(), to.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var metrics []MetricRow
+	for rows.Next() {
+		var m MetricRow
+		if err := rows.Scan(&m.Key, &m.XValue, &m.YValue, &m.LoggedAt); err != nil {
+			return nil, err
+		}
+		metrics = append(metrics, m)
+	}
+	return metrics, rows.Err()
+}
+
+// GetMetricsAfter retrieves up to limit points of a metric series of a run
+// with x greater than afterX, ordered by x
+func (d *SQLiteDAO) GetMetricsAfter(ctx context.Context, runID int, key string, afterX float64, limit int) ([]MetricRow, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT key, x_value, y_value, logged_at
+		FROM metrics
+		WHERE run_id = ? AND key = ? AND x_value > ?
+		ORDER BY x_value
+		LIMIT ?
+	`, runID, key, afterX, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var metrics []MetricRow
+	for rows.Next() {
+		var m MetricRow
+		if err := rows.Scan(&m.Key, &m.XValue, &m.YValue, &m.LoggedAt); err != nil {
+			return nil, err
+		}
+		metrics = append(metrics, m)
+	}
+	return metrics, rows.Err()
+}
+
+// GetBestMetric retrieves the point of a metric series of a run with the
+// lowest y, or the highest if maximize is set, taking the earliest step among
+// ties. It returns sql.ErrNoRows if the series has no points.
+func (d *SQLiteDAO) GetBestMetric(ctx context.Context, runID int, key string, maximize bool) (*MetricRow, error) {
+	direction := "ASC"
+	if maximize {
+		direction = "DESC"
+	}
+	var m MetricRow
+	err := d.db.QueryRowContext(ctx, `
+		SELECT key, x_value, y_value, logged_at
+		FROM metrics
+		WHERE run_id = ? AND key = ?
+		ORDER BY y_value `+direction+`, x_value
+		LIMIT 1
+	`, runID, key).Scan(&m.Key, &m.XValue, &m.YValue, &m.LoggedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// GetMetricSummariesByRunIDs retrieves the metric summaries of runs
+func (d *SQLiteDAO) GetMetricSummariesByRunIDs(ctx context.Context, runIDs []int) ([]MetricSummaryRow, error) {
+	if len(runIDs) == 0 {
+		return nil, nil
+	}
+	args := make([]interface{}, len(runIDs))
+	for i, id := range runIDs {
+		args[i] = id
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(runIDs)), ", ")
+	rows, err := d.db.QueryContext(ctx, fmt.Sprintf(`
+		SELECT run_id, key, namespace, name, COALESCE(last_step, 0), last_value, COALESCE(min_step, 0), min_value, COALESCE(max_step, 0), max_value, point_count
+		FROM metric_summaries
+		WHERE run_id IN (%s)
+		ORDER BY run_id, key
+	`, placeholders), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var summaries []MetricSummaryRow
+	for rows.Next() {
+		var s MetricSummaryRow
+		if err := rows.Scan(&s.RunID, &s.Key, &s.Namespace, &s.Name, &s.LastStep, &s.LastValue, &s.MinStep, &s.MinValue, &s.MaxStep, &s.MaxValue, &s.PointCount); err != nil {
+			return nil, err
+		}
+		summaries = append(summaries, s)
+	}
+	return summaries, rows.Err()
+}
+
+// FindMetricSeries finds the metric series whose run name, run UUID or key
+// contains query, ignoring case, newest run first
+func (d *SQLiteDAO) FindMetricSeries(ctx context.Context, query string, limit, projectID int) ([]MetricSeriesRow, error) {
+	pattern := "%" + strings.ToLower(query) + "%"
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT r.uuid, r.name, m.key
+		FROM metrics m
+		JOIN runs r ON r.id = m.run_id
+		WHERE r.deleted_at IS NULL AND (LOWER(r.name) LIKE ? OR LOWER(r.uuid) LIKE ? OR LOWER(m.key) LIKE ?)
+		  AND (m.key NOT LIKE 'system/%' OR ? LIKE 'system/%')
+		  AND (? = 0 OR r.experiment_id IN (SELECT id FROM experiments WHERE project_id = ?))
+		GROUP BY r.id, r.uuid, r.name, r.created_at, m.key
+		ORDER BY r.created_at DESC, r.id DESC, m.key
+		LIMIT ?
+	`, pattern, pattern, pattern, strings.ToLower(query), projectID, projectID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var series []MetricSeriesRow
+	for rows.Next() {
+		var s MetricSeriesRow
+		if err := rows.Scan(&s.RunUUID, &s.RunName, &s.Key); err != nil {
+			return nil, err
+		}
+		series = append(series, s)
+	}
+	return series, rows.Err()
+}
+
+// GetMetricSeries retrieves every point of a metric series of a run, ordered
+// by x value
+func (d *SQLiteDAO) GetMetricSeries(ctx context.Context, runID int, key string) ([]MetricRow, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT key, x_value, y_value, logged_at
+		FROM metrics
+		WHERE run_id = ? AND key = ?
+		ORDER BY x_value
+	`, runID, key)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var metrics []MetricRow
+	for rows.Next() {
+		var m MetricRow
+		if err := rows.Scan(&m.Key, &m.XValue, &m.YValue, &m.LoggedAt); err != nil {
+			return nil, err
+		}
+		metrics = append(metrics, m)
+	}
+	return metrics, rows.Err()
+}
+
+// GetRunHealth counts running runs, runs that failed since failedSince, and
+// running runs whose last activity was before quietBefore
+func (d *SQLiteDAO) GetRunHealth(ctx context.Context, failedSince, quietBefore time.Time) (*RunHealthRow, error) {
+	var health RunHealthRow
+	err := d.db.QueryRowContext(ctx, `
+		SELECT
+			COUNT(CASE WHEN status = ? THEN 1 END),
+			COUNT(CASE WHEN status = ? AND finished_at >= ? THEN 1 END),
+			COUNT(CASE WHEN status = ? AND COALESCE(last_activity_at, created_at) < ? THEN 1 END)
+		FROM runs
+		WHERE status IN (?, ?) AND deleted_at IS NULL
+	`, runStatusRunning, runStatusFailed, failedSince.UTC(), runStatusRunning, quietBefore.UTC(), runStatusRunning, runStatusFailed).Scan(&health.Running, &health.Failed, &health.Quiet)
+	if err != nil {
+		return nil, err
+	}
+	return &health, nil
+}
+
+// GetFailedRuns retrieves the failed runs of an experiment with the messages
+// they ended with, most recently finished first
+func (d *SQLiteDAO) GetFailedRuns(ctx context.Context, experimentID int) ([]FailedRunRow, error) {
+	rows, err := d.db.QueryContext(ctx, `
+		SELECT uuid, name, COALESCE(status_message, ''), finished_at
+		FROM runs
+		WHERE experiment_id = ? AND status = ? AND deleted_at IS NULL
+		ORDER BY finished_at DESC, id DESC
+	`, experimentID, runStatusFailed)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var runs []FailedRunRow
+	for rows.Next() {
+		var run FailedRunRow
+		if err := rows.Scan(&run.UUID, &run.Name, &run.Message, &run.FinishedAt); err != nil {
+			return nil, err
+		}
+		runs = append(runs, run)
+	}
+	return runs, rows.Err()
 }
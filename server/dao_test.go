@@ -2,7 +2,13 @@ package main
 
 import (
 	"database/sql"
+	"errors"
+	"fmt"
 	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -16,8 +22,9 @@ import (
 
 // testDAOImplementation runs a comprehensive test suite for a DAO implementation
 func testDAOImplementation(t *testing.T, dao DAO) {
+	ctx := t.Context()
 	// Get default experiment ID for run creation
-	defaultExpID, err := dao.GetDefaultExperimentID()
+	defaultExpID, err := dao.GetDefaultExperimentID(ctx)
 	if err != nil {
 		t.Fatalf("GetDefaultExperimentID failed: %v", err)
 	}
@@ -25,12 +32,12 @@ func testDAOImplementation(t *testing.T, dao DAO) {
 	// Test InsertExperiment and GetExperimentByUUID
 	expUUID := "test-exp-uuid-123"
 	expName := "Test Experiment"
-	err = dao.InsertExperiment(expUUID, expName)
+	err = dao.InsertExperiment(ctx, expUUID, expName)
 	if err != nil {
 		t.Fatalf("InsertExperiment failed: %v", err)
 	}
 
-	exp, err := dao.GetExperimentByUUID(expUUID)
+	exp, err := dao.GetExperimentByUUID(ctx, expUUID)
 	if err != nil {
 		t.Fatalf("GetExperimentByUUID failed: %v", err)
 	}
@@ -39,7 +46,7 @@ func testDAOImplementation(t *testing.T, dao DAO) {
 	}
 
 	// Test GetExperimentIDByUUID
-	expID, err := dao.GetExperimentIDByUUID(expUUID)
+	expID, err := dao.GetExperimentIDByUUID(ctx, expUUID)
 	if err != nil {
 		t.Fatalf("GetExperimentIDByUUID failed: %v", err)
 	}
@@ -47,8 +54,32 @@ func testDAOImplementation(t *testing.T, dao DAO) {
 		t.Errorf("GetExperimentIDByUUID returned invalid ID: %d", expID)
 	}
 
+	// Test UpdateExperimentReadme and GetExperimentReadmeRevisions
+	err = dao.UpdateExperimentReadme(ctx, expID, "# Goals\nFirst draft")
+	if err != nil {
+		t.Fatalf("UpdateExperimentReadme failed: %v", err)
+	}
+	err = dao.UpdateExperimentReadme(ctx, expID, "# Goals\nSecond draft")
+	if err != nil {
+		t.Fatalf("UpdateExperimentReadme failed: %v", err)
+	}
+	exp, err = dao.GetExperimentByUUID(ctx, expUUID)
+	if err != nil {
+		t.Fatalf("GetExperimentByUUID failed: %v", err)
+	}
+	if exp.Readme != "# Goals\nSecond draft" {
+		t.Errorf("Experiment README not updated: got %q", exp.Readme)
+	}
+	revisions, err := dao.GetExperimentReadmeRevisions(ctx, expID)
+	if err != nil {
+		t.Fatalf("GetExperimentReadmeRevisions failed: %v", err)
+	}
+	if len(revisions) != 2 || revisions[0].Readme != "# Goals\nSecond draft" {
+		t.Errorf("Expected 2 README revisions, newest first, got %+v", revisions)
+	}
+
 	// Test GetAllExperiments includes our new experiment
-	experiments, err := dao.GetAllExperiments()
+	experiments, err := dao.GetAllExperiments(ctx)
 	if err != nil {
 		t.Fatalf("GetAllExperiments failed: %v", err)
 	}
@@ -58,12 +89,12 @@ func testDAOImplementation(t *testing.T, dao DAO) {
 
 	// Test run under non-default experiment and GetRunsByExperimentID
 	runUnderExpUUID := "run-under-exp-uuid"
-	err = dao.InsertRun(runUnderExpUUID, "Run Under Test Experiment", expID, nil)
+	err = dao.InsertRun(ctx, runUnderExpUUID, "Run Under Test Experiment", expID, nil)
 	if err != nil {
 		t.Fatalf("InsertRun under experiment failed: %v", err)
 	}
 
-	runsForExp, err := dao.GetRunsByExperimentID(expID)
+	runsForExp, err := dao.GetRunsByExperimentID(ctx, expID)
 	if err != nil {
 		t.Fatalf("GetRunsByExperimentID failed: %v", err)
 	}
@@ -76,23 +107,23 @@ func testDAOImplementation(t *testing.T, dao DAO) {
 
 	// Test experiment isolation: create second experiment with a run
 	exp2UUID := "test-exp-uuid-456"
-	err = dao.InsertExperiment(exp2UUID, "Second Experiment")
+	err = dao.InsertExperiment(ctx, exp2UUID, "Second Experiment")
 	if err != nil {
 		t.Fatalf("InsertExperiment for exp2 failed: %v", err)
 	}
-	exp2ID, err := dao.GetExperimentIDByUUID(exp2UUID)
+	exp2ID, err := dao.GetExperimentIDByUUID(ctx, exp2UUID)
 	if err != nil {
 		t.Fatalf("GetExperimentIDByUUID for exp2 failed: %v", err)
 	}
 
 	runUnderExp2UUID := "run-under-exp2-uuid"
-	err = dao.InsertRun(runUnderExp2UUID, "Run Under Second Experiment", exp2ID, nil)
+	err = dao.InsertRun(ctx, runUnderExp2UUID, "Run Under Second Experiment", exp2ID, nil)
 	if err != nil {
 		t.Fatalf("InsertRun under exp2 failed: %v", err)
 	}
 
 	// Verify exp1 still only has 1 run
-	runsForExp, err = dao.GetRunsByExperimentID(expID)
+	runsForExp, err = dao.GetRunsByExperimentID(ctx, expID)
 	if err != nil {
 		t.Fatalf("GetRunsByExperimentID for exp1 failed: %v", err)
 	}
@@ -101,7 +132,7 @@ func testDAOImplementation(t *testing.T, dao DAO) {
 	}
 
 	// Verify exp2 has exactly 1 run
-	runsForExp2, err := dao.GetRunsByExperimentID(exp2ID)
+	runsForExp2, err := dao.GetRunsByExperimentID(ctx, exp2ID)
 	if err != nil {
 		t.Fatalf("GetRunsByExperimentID for exp2 failed: %v", err)
 	}
@@ -115,12 +146,12 @@ func testDAOImplementation(t *testing.T, dao DAO) {
 	// Test InsertRun and GetRunByUUID
 	runUUID := "test-run-uuid-123"
 	runName := "Test Run"
-	err = dao.InsertRun(runUUID, runName, defaultExpID, nil)
+	err = dao.InsertRun(ctx, runUUID, runName, defaultExpID, nil)
 	if err != nil {
 		t.Fatalf("InsertRun failed: %v", err)
 	}
 
-	run, err := dao.GetRunByUUID(runUUID)
+	run, err := dao.GetRunByUUID(ctx, runUUID)
 	if err != nil {
 		t.Fatalf("GetRunByUUID failed: %v", err)
 	}
@@ -129,7 +160,7 @@ func testDAOImplementation(t *testing.T, dao DAO) {
 	}
 
 	// Test GetRunIDByUUID
-	runID, err := dao.GetRunIDByUUID(runUUID)
+	runID, err := dao.GetRunIDByUUID(ctx, runUUID)
 	if err != nil {
 		t.Fatalf("GetRunIDByUUID failed: %v", err)
 	}
@@ -137,8 +168,24 @@ func testDAOImplementation(t *testing.T, dao DAO) {
 		t.Errorf("GetRunIDByUUID returned invalid ID: %d", runID)
 	}
 
+	// Test FindRunsByNameOrUUIDPrefix
+	matches, err := dao.FindRunsByNameOrUUIDPrefix(ctx, runName)
+	if err != nil {
+		t.Fatalf("FindRunsByNameOrUUIDPrefix by name failed: %v", err)
+	}
+	if len(matches) != 1 || matches[0].UUID != runUUID {
+		t.Errorf("FindRunsByNameOrUUIDPrefix by name returned unexpected runs: %+v", matches)
+	}
+	matches, err = dao.FindRunsByNameOrUUIDPrefix(ctx, "run-under-exp")
+	if err != nil {
+		t.Fatalf("FindRunsByNameOrUUIDPrefix by prefix failed: %v", err)
+	}
+	if len(matches) != 2 {
+		t.Errorf("Expected 2 runs matching UUID prefix, got %+v", matches)
+	}
+
 	// Test GetAllRuns
-	runs, err := dao.GetAllRuns()
+	runs, err := dao.GetAllRuns(ctx)
 	if err != nil {
 		t.Fatalf("GetAllRuns failed: %v", err)
 	}
@@ -146,6 +193,39 @@ func testDAOImplementation(t *testing.T, dao DAO) {
 		t.Error("GetAllRuns returned no runs")
 	}
 
+	// Test GetRuns
+	latestRuns, err := dao.GetRuns(ctx, 0, 2, "created", "desc", RunFilter{})
+	if err != nil {
+		t.Fatalf("GetRuns failed: %v", err)
+	}
+	if len(latestRuns) != 2 {
+		t.Fatalf("Expected 2 latest runs, got %d", len(latestRuns))
+	}
+	for _, r := range latestRuns {
+		if r.UUID == "" || r.ExperimentUUID == "" || r.ExperimentName == "" {
+			t.Errorf("GetRuns returned incomplete summary: %+v", r)
+		}
+	}
+	secondPage, err := dao.GetRuns(ctx, 1, 1, "created", "desc", RunFilter{})
+	if err != nil {
+		t.Fatalf("GetRuns failed: %v", err)
+	}
+	if len(secondPage) != 1 || secondPage[0].UUID != latestRuns[1].UUID {
+		t.Errorf("Expected the second page to hold %s, got %+v", latestRuns[1].UUID, secondPage)
+	}
+	byName, err := dao.GetRuns(ctx, 0, 100, "name", "asc", RunFilter{})
+	if err != nil {
+		t.Fatalf("GetRuns by name failed: %v", err)
+	}
+	for i := 1; i < len(byName); i++ {
+		if byName[i-1].Name > byName[i].Name {
+			t.Errorf("GetRuns by name is out of order: %q before %q", byName[i-1].Name, byName[i].Name)
+		}
+	}
+	if _, err := dao.GetRuns(ctx, 0, 10, "uuid; DROP TABLE runs", "asc", RunFilter{}); err == nil {
+		t.Error("Expected GetRuns to reject an unknown sort")
+	}
+
 	// Test UpsertParameter with different types
 	testCases := []struct {
 		key         string
@@ -178,14 +258,14 @@ func testDAOImplementation(t *testing.T, dao DAO) {
 	}
 
 	for _, tc := range testCases {
-		err = dao.UpsertParameter(runID, tc.key, tc.valueType, tc.valueString, tc.valueBool, tc.valueFloat, tc.valueInt)
+		err = dao.UpsertParameter(ctx, runID, tc.key, tc.valueType, tc.valueString, tc.valueBool, tc.valueFloat, tc.valueInt)
 		if err != nil {
 			t.Fatalf("UpsertParameter failed for %s: %v", tc.key, err)
 		}
 	}
 
 	// Test GetParametersByRunID
-	params, err := dao.GetParametersByRunID(runID)
+	params, err := dao.GetParametersByRunID(ctx, runID)
 	if err != nil {
 		t.Fatalf("GetParametersByRunID failed: %v", err)
 	}
@@ -193,16 +273,77 @@ func testDAOImplementation(t *testing.T, dao DAO) {
 		t.Errorf("Expected %d parameters, got %d", len(testCases), len(params))
 	}
 
+	// Test GetParametersByExperimentID
+	runUnderExp2ID, err := dao.GetRunIDByUUID(ctx, runUnderExp2UUID)
+	if err != nil {
+		t.Fatalf("GetRunIDByUUID for exp2 run failed: %v", err)
+	}
+	err = dao.UpsertParameter(ctx, runUnderExp2ID, "learning_rate", "string", stringPtr("0.01"), nil, nil, nil)
+	if err != nil {
+		t.Fatalf("UpsertParameter under exp2 failed: %v", err)
+	}
+	expParams, err := dao.GetParametersByExperimentID(ctx, defaultExpID)
+	if err != nil {
+		t.Fatalf("GetParametersByExperimentID failed: %v", err)
+	}
+	if len(expParams) != len(testCases) {
+		t.Errorf("Expected %d experiment parameters, got %d", len(testCases), len(expParams))
+	}
+	for _, p := range expParams {
+		if p.RunID != runID || p.RunUUID != runUUID {
+			t.Errorf("GetParametersByExperimentID returned parameter from wrong run: %+v", p)
+		}
+	}
+	exp2Params, err := dao.GetParametersByExperimentID(ctx, exp2ID)
+	if err != nil {
+		t.Fatalf("GetParametersByExperimentID for exp2 failed: %v", err)
+	}
+	if len(exp2Params) != 1 || exp2Params[0].ValueType != "string" || exp2Params[0].ValueString.String != "0.01" {
+		t.Errorf("GetParametersByExperimentID for exp2 returned unexpected parameters: %+v", exp2Params)
+	}
+
+	// Test UpsertExperimentParameters, which writes the parameters of every
+	// run or of none
+	warmup := ParameterRow{Key: "warmup", ValueType: "int", ValueInt: sql.NullInt64{Int64: 10, Valid: true}}
+	err = dao.UpsertExperimentParameters(ctx, []ExperimentParameterRow{
+		{RunID: runUnderExp2ID, ParameterRow: warmup},
+		{RunID: runID, ParameterRow: ParameterRow{Key: "warmup", ValueType: "tuple"}},
+	})
+	if err == nil {
+		t.Error("Expected UpsertExperimentParameters to reject an unknown type")
+	}
+	hasWarmup := func() bool {
+		params, err := dao.GetParametersByRunID(ctx, runUnderExp2ID)
+		if err != nil {
+			t.Fatalf("GetParametersByRunID failed: %v", err)
+		}
+		for _, p := range params {
+			if p.Key == "warmup" {
+				return true
+			}
+		}
+		return false
+	}
+	if hasWarmup() {
+		t.Error("Expected the failed batch rolled back")
+	}
+	if err := dao.UpsertExperimentParameters(ctx, []ExperimentParameterRow{{RunID: runUnderExp2ID, ParameterRow: warmup}}); err != nil {
+		t.Fatalf("UpsertExperimentParameters failed: %v", err)
+	}
+	if !hasWarmup() {
+		t.Error("Expected warmup written")
+	}
+
 	// Test InsertMetric
 	now := time.Now()
-	err = dao.InsertMetrics(runID, "loss", []float64{0, 10, 20, 30},
+	err = dao.InsertMetrics(ctx, runID, "loss", []float64{0, 10, 20, 30},
 		[]float64{0.5, 0.37, 0.34, 0.21}, now.UnixMilli())
 	if err != nil {
 		t.Fatalf("InsertMetric failed: %v", err)
 	}
 
 	// Test GetMetricsByRunID
-	metrics, err := dao.GetMetricsByRunID(runID)
+	metrics, err := dao.GetMetricsByRunID(ctx, runID)
 	if err != nil {
 		t.Fatalf("GetMetricsByRunID failed: %v", err)
 	}
@@ -220,195 +361,2153 @@ func testDAOImplementation(t *testing.T, dao DAO) {
 			now.UnixMilli(), metrics[1].LoggedAt.UnixMilli())
 	}
 
-	// Test UpsertArtifact
-	err = dao.UpsertArtifact(runID, "model.pkl", "file:///path/to/model.pkl", "model")
+	// Test GetLatestMetricsByRunID
+	err = dao.InsertMetrics(ctx, runID, "accuracy", []float64{0, 10}, []float64{0.5, 0.75}, now.UnixMilli())
 	if err != nil {
-		t.Fatalf("UpsertArtifact failed: %v", err)
+		t.Fatalf("InsertMetrics failed: %v", err)
 	}
-
-	err = dao.UpsertArtifact(runID, "plot.png", "file:///path/to/plot.png", "image")
+	latest, err := dao.GetLatestMetricsByRunID(ctx, runID)
 	if err != nil {
-		t.Fatalf("UpsertArtifact failed: %v", err)
+		t.Fatalf("GetLatestMetricsByRunID failed: %v", err)
+	}
+	if len(latest) != 2 {
+		t.Fatalf("Expected latest values for 2 metric keys, got %d", len(latest))
+	}
+	if latest[0].Key != "accuracy" || latest[0].XValue != 10 || latest[0].YValue != 0.75 {
+		t.Errorf("Unexpected latest accuracy: %+v", latest[0])
+	}
+	if latest[1].Key != "loss" || latest[1].XValue != 30 || latest[1].YValue != 0.21 {
+		t.Errorf("Unexpected latest loss: %+v", latest[1])
 	}
 
-	// Test GetArtifactsByRunID
-	artifacts, err := dao.GetArtifactsByRunID(runID)
+	// Test GetMetricsDownsampled and GetMetricGaps, on another run so that
+	// the series above are left as they are
+	seriesRunID, err := dao.GetRunIDByUUID(ctx, runUnderExpUUID)
 	if err != nil {
-		t.Fatalf("GetArtifactsByRunID failed: %v", err)
+		t.Fatalf("GetRunIDByUUID failed: %v", err)
 	}
-	if len(artifacts) != 2 {
-		t.Errorf("Expected 2 artifacts, got %d", len(artifacts))
+	var xs, ys []float64
+	for i := 0; i < 100; i++ {
+		xs, ys = append(xs, float64(i)), append(ys, 1)
 	}
-
-	// Test GetArtifactByRunIDAndPath
-	artifact, err := dao.GetArtifactByRunIDAndPath(runID, "model.pkl")
+	ys[57] = 50
+	if err := dao.InsertMetrics(ctx, seriesRunID, "loss", xs, ys, now.UnixMilli()); err != nil {
+		t.Fatalf("InsertMetrics failed: %v", err)
+	}
+	downsampled, err := dao.GetMetricsDownsampled(ctx, seriesRunID, "loss", 10)
 	if err != nil {
-		t.Fatalf("GetArtifactByRunIDAndPath failed: %v", err)
+		t.Fatalf("GetMetricsDownsampled failed: %v", err)
 	}
-	if artifact.Path != "model.pkl" || artifact.URI != "file:///path/to/model.pkl" || artifact.Type != "model" {
-		t.Errorf("GetArtifactByRunIDAndPath returned incorrect data: got %+v", artifact)
+	if len(downsampled) > 10 || downsampled[0].XValue != 0 || downsampled[len(downsampled)-1].XValue != 99 {
+		t.Errorf("Expected at most 10 points from first to last, got %+v", downsampled)
+	}
+	spikeKept := false
+	for i, m := range downsampled {
+		spikeKept = spikeKept || m.YValue == 50
+		if i > 0 && m.XValue <= downsampled[i-1].XValue {
+			t.Errorf("Expected points ordered by x value, got %+v", downsampled)
+		}
+	}
+	if !spikeKept {
+		t.Errorf("Expected the spike to survive downsampling, got %+v", downsampled)
+	}
+	if all, err := dao.GetMetricsDownsampled(ctx, seriesRunID, "loss", 100); err != nil || len(all) != 100 {
+		t.Errorf("Expected a short enough series in full, got %d points (%v)", len(all), err)
 	}
 
-	// Test upsert behavior - update existing parameter
-	newFloatValue := 0.002
-	err = dao.UpsertParameter(runID, "learning_rate", "float", nil, nil, &newFloatValue, nil)
+	start := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	if err := dao.InsertMetrics(ctx, seriesRunID, "acc", []float64{0, 1}, []float64{0.1, 0.2}, start.UnixMilli()); err != nil {
+		t.Fatalf("InsertMetrics failed: %v", err)
+	}
+	if err := dao.InsertMetrics(ctx, seriesRunID, "acc", []float64{2, 3}, []float64{0.3, 0.4}, start.Add(20*time.Minute).UnixMilli()); err != nil {
+		t.Fatalf("InsertMetrics failed: %v", err)
+	}
+	gapRows, err := dao.GetMetricGaps(ctx, seriesRunID, "acc", 10*time.Minute)
 	if err != nil {
-		t.Fatalf("UpsertParameter update failed: %v", err)
+		t.Fatalf("GetMetricGaps failed: %v", err)
+	}
+	if len(gapRows) != 1 || gapRows[0].Start.XValue != 1 || gapRows[0].End.XValue != 2 || gapRows[0].End.LoggedAt.Sub(gapRows[0].Start.LoggedAt) != 20*time.Minute {
+		t.Errorf("Expected one 20 minute gap from 1 to 2, got %+v", gapRows)
+	}
+	if gapRows, err := dao.GetMetricGaps(ctx, seriesRunID, "acc", time.Hour); err != nil || len(gapRows) != 0 {
+		t.Errorf("Expected no gaps with a 1h threshold, got %+v (%v)", gapRows, err)
 	}
 
-	params, err = dao.GetParametersByRunID(runID)
+	// Test UpsertRunGPUSummary, GetRunGPUSummary, and GetRunGPUSummariesByExperimentID
+	gpuSummary, err := dao.GetRunGPUSummary(ctx, runID)
 	if err != nil {
-		t.Fatalf("GetParametersByRunID failed after update: %v", err)
+		t.Fatalf("GetRunGPUSummary failed: %v", err)
+	}
+	if gpuSummary != nil {
+		t.Errorf("Expected no GPU summary before upsert, got %+v", gpuSummary)
+	}
+	err = dao.UpsertRunGPUSummary(ctx, RunGPUSummaryRow{
+		RunID:           runID,
+		UtilizationMean: sql.NullFloat64{Float64: 40, Valid: true},
+		GPUHours:        0.5,
+	})
+	if err != nil {
+		t.Fatalf("UpsertRunGPUSummary failed: %v", err)
+	}
+	err = dao.UpsertRunGPUSummary(ctx, RunGPUSummaryRow{
+		RunID:           runID,
+		UtilizationMean: sql.NullFloat64{Float64: 60, Valid: true},
+		MemoryPeakBytes: sql.NullFloat64{Float64: 1 << 30, Valid: true},
+		GPUHours:        1.5,
+	})
+	if err != nil {
+		t.Fatalf("UpsertRunGPUSummary failed: %v", err)
+	}
+	gpuSummary, err = dao.GetRunGPUSummary(ctx, runID)
+	if err != nil {
+		t.Fatalf("GetRunGPUSummary failed: %v", err)
+	}
+	if gpuSummary == nil || gpuSummary.UtilizationMean.Float64 != 60 || gpuSummary.MemoryPeakBytes.Float64 != 1<<30 || gpuSummary.GPUHours != 1.5 {
+		t.Errorf("GPU summary not replaced: got %+v", gpuSummary)
+	}
+	gpuSummaries, err := dao.GetRunGPUSummariesByExperimentID(ctx, defaultExpID)
+	if err != nil {
+		t.Fatalf("GetRunGPUSummariesByExperimentID failed: %v", err)
+	}
+	if len(gpuSummaries) != 1 || gpuSummaries[0].RunID != runID {
+		t.Errorf("Expected only the run's GPU summary, got %+v", gpuSummaries)
 	}
 
-	// Find the learning_rate parameter and check it was updated
-	found := false
-	for _, p := range params {
-		if p.Key == "learning_rate" {
-			if !p.ValueFloat.Valid || p.ValueFloat.Float64 != newFloatValue {
-				t.Errorf("Parameter not updated correctly: expected %f, got %f", newFloatValue, p.ValueFloat.Float64)
-			}
-			found = true
-			break
+	// Test UpsertExperimentCostRate, GetExperimentCostRates, and DeleteExperimentCostRate
+	for _, rate := range []CostRateRow{{"a100", 2}, {"*", 1}, {"a100", 3.5}} {
+		if err := dao.UpsertExperimentCostRate(ctx, expID, rate.MachineType, rate.USDPerGPUHour); err != nil {
+			t.Fatalf("UpsertExperimentCostRate failed: %v", err)
 		}
 	}
-	if !found {
-		t.Error("learning_rate parameter not found after update")
+	costRates, err := dao.GetExperimentCostRates(ctx, expID)
+	if err != nil {
+		t.Fatalf("GetExperimentCostRates failed: %v", err)
 	}
-
-	// Test nested runs
-	// Create parent run (level 0)
-	parentUUID := "parent-run-uuid"
-	err = dao.InsertRun(parentUUID, "Parent Run", defaultExpID, nil)
+	if len(costRates) != 2 || costRates[0].MachineType != "*" || costRates[1].USDPerGPUHour != 3.5 {
+		t.Errorf("Unexpected cost rates: %+v", costRates)
+	}
+	if err := dao.DeleteExperimentCostRate(ctx, expID, "*"); err != nil {
+		t.Fatalf("DeleteExperimentCostRate failed: %v", err)
+	}
+	costRates, err = dao.GetExperimentCostRates(ctx, expID)
 	if err != nil {
-		t.Fatalf("InsertRun for parent failed: %v", err)
+		t.Fatalf("GetExperimentCostRates failed: %v", err)
+	}
+	if len(costRates) != 1 || costRates[0].MachineType != "a100" {
+		t.Errorf("Cost rate not deleted: %+v", costRates)
 	}
-	parentID, _ := dao.GetRunIDByUUID(parentUUID)
 
-	// Create child run (level 1)
-	childUUID := "child-run-uuid"
-	err = dao.InsertRun(childUUID, "Child Run", defaultExpID, &parentID)
+	// Test the metric schema and its warnings
+	for _, e := range []MetricSchemaEntryRow{
+		{Key: "val_accuracy", MaxStep: sql.NullFloat64{Float64: 100, Valid: true}},
+		{Key: "train/*"},
+		{Key: "val_accuracy", MinStep: sql.NullFloat64{Float64: 0, Valid: true}, MaxStep: sql.NullFloat64{Float64: 1000, Valid: true}},
+	} {
+		if err := dao.UpsertMetricSchemaEntry(ctx, expID, e); err != nil {
+			t.Fatalf("UpsertMetricSchemaEntry failed: %v", err)
+		}
+	}
+	schemaRunID, err := dao.GetRunIDByUUID(ctx, runUnderExpUUID)
 	if err != nil {
-		t.Fatalf("InsertRun for child failed: %v", err)
+		t.Fatalf("GetRunIDByUUID failed: %v", err)
 	}
-	childID, _ := dao.GetRunIDByUUID(childUUID)
-
-	// Verify child's nesting level
-	childRun, _ := dao.GetRunByUUID(childUUID)
-	if childRun.NestingLevel != 1 {
-		t.Errorf("Expected child nesting level 1, got %d", childRun.NestingLevel)
+	schema, err := dao.GetMetricSchemaForRun(ctx, schemaRunID)
+	if err != nil {
+		t.Fatalf("GetMetricSchemaForRun failed: %v", err)
 	}
-	if childRun.ParentRunID == nil || *childRun.ParentRunID != parentID {
-		t.Error("Child parent_run_id not set correctly")
+	if len(schema) != 2 || schema[0].Key != "train/*" || schema[0].MinStep.Valid || schema[1].MaxStep.Float64 != 1000 || !schema[1].MinStep.Valid {
+		t.Errorf("Unexpected metric schema: %+v", schema)
 	}
-
-	// Create grandchild run (level 2)
-	grandchildUUID := "grandchild-run-uuid"
-	err = dao.InsertRun(grandchildUUID, "Grandchild Run", defaultExpID, &childID)
+	for _, detail := range []string{"step 1200 outside 0 to 1000", "step 1500 outside 0 to 1000"} {
+		if err := dao.RecordMetricSchemaWarning(ctx, schemaRunID, "val_accuracy", metricSchemaStepOutOfRange, detail); err != nil {
+			t.Fatalf("RecordMetricSchemaWarning failed: %v", err)
+		}
+	}
+	if err := dao.RecordMetricSchemaWarning(ctx, schemaRunID, "val_acuracy", metricSchemaUnknownKey, ""); err != nil {
+		t.Fatalf("RecordMetricSchemaWarning failed: %v", err)
+	}
+	schemaWarnings, err := dao.GetMetricSchemaWarnings(ctx, expID)
 	if err != nil {
-		t.Fatalf("InsertRun for grandchild failed: %v", err)
+		t.Fatalf("GetMetricSchemaWarnings failed: %v", err)
 	}
-	grandchildID, _ := dao.GetRunIDByUUID(grandchildUUID)
-
-	grandchildRun, _ := dao.GetRunByUUID(grandchildUUID)
-	if grandchildRun.NestingLevel != 2 {
-		t.Errorf("Expected grandchild nesting level 2, got %d", grandchildRun.NestingLevel)
+	if len(schemaWarnings) != 2 {
+		t.Fatalf("Expected 2 metric schema warnings, got %+v", schemaWarnings)
 	}
-
-	// Test GetChildRuns
-	childRuns, err := dao.GetChildRuns(parentID)
+	for _, w := range schemaWarnings {
+		if w.RunUUID != runUnderExpUUID || w.LastSeenAt.IsZero() {
+			t.Errorf("Unexpected metric schema warning: %+v", w)
+		}
+		if w.Kind == metricSchemaStepOutOfRange && (w.Count != 2 || w.Detail != "step 1500 outside 0 to 1000") {
+			t.Errorf("Expected repeated warnings counted with the latest detail, got %+v", w)
+		}
+	}
+	if err := dao.DeleteMetricSchemaWarnings(ctx, expID); err != nil {
+		t.Fatalf("DeleteMetricSchemaWarnings failed: %v", err)
+	}
+	if err := dao.DeleteMetricSchemaEntry(ctx, expID, "train/*"); err != nil {
+		t.Fatalf("DeleteMetricSchemaEntry failed: %v", err)
+	}
+	schema, err = dao.GetMetricSchema(ctx, expID)
 	if err != nil {
-		t.Fatalf("GetChildRuns failed: %v", err)
+		t.Fatalf("GetMetricSchema failed: %v", err)
 	}
-	if len(childRuns) != 1 || childRuns[0].UUID != childUUID {
-		t.Errorf("GetChildRuns returned unexpected runs: %+v", childRuns)
+	if schemaWarnings, _ := dao.GetMetricSchemaWarnings(ctx, expID); len(schema) != 1 || len(schemaWarnings) != 0 {
+		t.Errorf("Expected one key and no warnings left, got %+v and %+v", schema, schemaWarnings)
 	}
 
-	// Test GetChildRunCount
-	childCount, err := dao.GetChildRunCount(parentID)
+	// Test InsertRunDependency, GetRunDependencyEdges, and DeleteRunDependency
+	upstreamRunID, err := dao.GetRunIDByUUID(ctx, runUnderExpUUID)
 	if err != nil {
-		t.Fatalf("GetChildRunCount failed: %v", err)
+		t.Fatalf("GetRunIDByUUID failed: %v", err)
 	}
-	if childCount != 1 {
-		t.Errorf("Expected 1 child, got %d", childCount)
+	if err := dao.InsertRunDependency(ctx, runID, upstreamRunID, "checkpoint", "model.pt"); err != nil {
+		t.Fatalf("InsertRunDependency failed: %v", err)
 	}
-
-	// Test GetRunsByExperimentIDAndLevel
-	level0Runs, err := dao.GetRunsByExperimentIDAndLevel(defaultExpID, 0)
+	if err := dao.InsertRunDependency(ctx, runID, upstreamRunID, "dataset", ""); err != nil {
+		t.Fatalf("InsertRunDependency failed: %v", err)
+	}
+	dependencyEdges, err := dao.GetRunDependencyEdges(ctx, upstreamRunID)
 	if err != nil {
-		t.Fatalf("GetRunsByExperimentIDAndLevel failed: %v", err)
+		t.Fatalf("GetRunDependencyEdges failed: %v", err)
 	}
-	foundParent := false
-	for _, r := range level0Runs {
-		if r.UUID == parentUUID {
-			foundParent = true
-			break
-		}
+	if len(dependencyEdges) != 2 {
+		t.Fatalf("Expected 2 dependency edges, got %+v", dependencyEdges)
 	}
-	if !foundParent {
-		t.Error("Parent run not found in level 0 runs")
+	if e := dependencyEdges[0]; e.RunUUID != runUUID || e.UpstreamUUID != runUnderExpUUID || e.Kind != "checkpoint" || e.ArtifactPath != "model.pt" {
+		t.Errorf("Unexpected dependency edge: %+v", e)
 	}
-
-	// Test GetRunByID
-	parentByID, err := dao.GetRunByID(parentID)
+	if err := dao.DeleteRunDependency(ctx, dependencyEdges[0].ID); err != nil {
+		t.Fatalf("DeleteRunDependency failed: %v", err)
+	}
+	dependencyEdges, err = dao.GetRunDependencyEdges(ctx, runID)
 	if err != nil {
-		t.Fatalf("GetRunByID failed: %v", err)
+		t.Fatalf("GetRunDependencyEdges failed: %v", err)
 	}
-	if parentByID.UUID != parentUUID || parentByID.Name != "Parent Run" {
-		t.Errorf("GetRunByID returned wrong data: %+v", parentByID)
+	if len(dependencyEdges) != 1 || dependencyEdges[0].Kind != "dataset" {
+		t.Errorf("Dependency edge not deleted: %+v", dependencyEdges)
 	}
 
-	// Test max nesting level (should fail for level 3)
-	greatGrandchildUUID := "great-grandchild-run-uuid"
-	err = dao.InsertRun(greatGrandchildUUID, "Great Grandchild Run", defaultExpID, &grandchildID)
-	if err == nil {
-		t.Error("Expected error when exceeding max nesting level, but got none")
+	// Test UpsertConfusionMatrix and GetConfusionMatricesByRunID
+	for _, m := range []ConfusionMatrixRow{
+		{RunID: runID, Key: "val/confusion", Step: 2, Labels: `["a","b"]`, Matrix: `[[1,2],[3,4]]`},
+		{RunID: runID, Key: "val/confusion", Step: 1, Labels: `["a","b"]`, Matrix: `[[1,0],[0,1]]`},
+		{RunID: runID, Key: "val/confusion", Step: 2, Labels: `["a","b"]`, Matrix: `[[5,0],[0,5]]`},
+	} {
+		if err := dao.UpsertConfusionMatrix(ctx, m); err != nil {
+			t.Fatalf("UpsertConfusionMatrix failed: %v", err)
+		}
+	}
+	confusionMatrices, err := dao.GetConfusionMatricesByRunID(ctx, runID)
+	if err != nil {
+		t.Fatalf("GetConfusionMatricesByRunID failed: %v", err)
+	}
+	if len(confusionMatrices) != 2 {
+		t.Fatalf("Expected 2 confusion matrices, got %+v", confusionMatrices)
+	}
+	if confusionMatrices[0].Step != 1 || confusionMatrices[1].Step != 2 || confusionMatrices[1].Matrix != `[[5,0],[0,5]]` {
+		t.Errorf("Unexpected confusion matrices: %+v", confusionMatrices)
 	}
-}
-
-func TestSQLiteDAO(t *testing.T) {
-	// Create a temporary database file with absolute path
-	dbFile := "test_sqlite.db"
-	defer os.Remove(dbFile)
 
-	// Get absolute path
-	absPath, err := os.Getwd()
+	// Test UpsertRunCurve and GetRunCurvesByRunID
+	for _, c := range []RunCurveRow{
+		{RunID: runID, Key: "val/roc", Kind: "roc", Step: 1, Points: `[{"x":0,"y":0},{"x":1,"y":1}]`, AUC: 0.5},
+		{RunID: runID, Key: "val/pr", Kind: "pr", Points: `[{"x":0,"y":1},{"x":1,"y":0.5}]`, AUC: 0.75},
+		{RunID: runID, Key: "val/roc", Kind: "roc", Step: 1, Points: `[{"x":0,"y":0},{"x":0,"y":1},{"x":1,"y":1}]`, AUC: 1},
+	} {
+		if err := dao.UpsertRunCurve(ctx, c); err != nil {
+			t.Fatalf("UpsertRunCurve failed: %v", err)
+		}
+	}
+	runCurves, err := dao.GetRunCurvesByRunID(ctx, runID)
 	if err != nil {
-		t.Fatalf("Failed to get working directory: %v", err)
+		t.Fatalf("GetRunCurvesByRunID failed: %v", err)
+	}
+	if len(runCurves) != 2 || runCurves[0].Key != "val/pr" || runCurves[1].AUC != 1 || runCurves[1].Kind != "roc" {
+		t.Errorf("Unexpected curves: %+v", runCurves)
 	}
-	absDBPath := absPath + "/" + dbFile
 
-	// Create the database file first by opening it
-	db, err := sql.Open("sqlite3", dbFile)
+	// Test UpsertTextSamples, GetTextSampleStepsByRunID, and GetTextSamples
+	for _, s := range []TextSamplesRow{
+		{RunID: runID, Key: "gen", Step: 100, Samples: `["a"]`},
+		{RunID: runID, Key: "gen", Step: 0, Samples: `["b"]`},
+		{RunID: runID, Key: "gen", Step: 100, Samples: `["c", "d"]`},
+	} {
+		if err := dao.UpsertTextSamples(ctx, s); err != nil {
+			t.Fatalf("UpsertTextSamples failed: %v", err)
+		}
+	}
+	sampleSteps, err := dao.GetTextSampleStepsByRunID(ctx, runID)
 	if err != nil {
-		t.Fatalf("Failed to open SQLite database: %v", err)
+		t.Fatalf("GetTextSampleStepsByRunID failed: %v", err)
 	}
-	// Ping to ensure the file is created
-	if err := db.Ping(); err != nil {
-		t.Fatalf("Failed to ping database: %v", err)
+	if len(sampleSteps) != 2 || sampleSteps[0].Step != 0 || sampleSteps[1].Step != 100 || sampleSteps[1].Samples != "" {
+		t.Errorf("Unexpected text sample steps: %+v", sampleSteps)
+	}
+	textSamples, err := dao.GetTextSamples(ctx, runID, "gen", 100)
+	if err != nil {
+		t.Fatalf("GetTextSamples failed: %v", err)
+	}
+	if textSamples == nil || textSamples.Samples != `["c", "d"]` {
+		t.Errorf("Unexpected text samples: %+v", textSamples)
+	}
+	if missing, err := dao.GetTextSamples(ctx, runID, "gen", 50); err != nil || missing != nil {
+		t.Errorf("Expected no text samples at step 50, got %+v (%v)", missing, err)
 	}
-	db.Close()
 
-	connString := "sqlite:///" + absDBPath
+	// Test SetRunTag, GetRunTags, DeleteRunTag, and filtering runs by tag
+	if err := dao.SetRunTag(ctx, runID, "team", "nlp"); err != nil {
+		t.Fatalf("SetRunTag failed: %v", err)
+	}
+	if err := dao.SetRunTag(ctx, runID, "team", "vision"); err != nil {
+		t.Fatalf("SetRunTag overwrite failed: %v", err)
+	}
+	if err := dao.SetRunTag(ctx, runID, "baseline", ""); err != nil {
+		t.Fatalf("SetRunTag failed: %v", err)
+	}
+	tags, err := dao.GetRunTags(ctx, runID)
+	if err != nil {
+		t.Fatalf("GetRunTags failed: %v", err)
+	}
+	if len(tags) != 2 || tags[0] != (RunTagRow{Key: "baseline"}) || tags[1] != (RunTagRow{Key: "team", Value: "vision"}) {
+		t.Errorf("Unexpected tags: %+v", tags)
+	}
+	runExperimentID, err := dao.GetRunExperimentID(ctx, runID)
+	if err != nil {
+		t.Fatalf("GetRunExperimentID failed: %v", err)
+	}
+	for _, tt := range []struct {
+		filter RunFilter
+		want   int
+	}{
+		{RunFilter{Tags: []TagFilter{{Key: "baseline"}}}, 1},
+		{RunFilter{Tags: []TagFilter{{Key: "baseline"}}, ExperimentID: runExperimentID}, 1},
+		{RunFilter{Tags: []TagFilter{{Key: "baseline"}}, ExperimentID: runExperimentID + 1000}, 0},
+		{RunFilter{Tags: []TagFilter{{Key: "team", Value: "vision", HasValue: true}, {Key: "baseline"}}}, 1},
+		{RunFilter{Tags: []TagFilter{{Key: "team", Value: "nlp", HasValue: true}}}, 0},
+	} {
+		tagged, err := dao.GetRuns(ctx, 0, 100, "created", "desc", tt.filter)
+		if err != nil {
+			t.Fatalf("GetRuns with tag filter failed: %v", err)
+		}
+		if len(tagged) != tt.want {
+			t.Errorf("GetRuns(%+v) returned %d runs, want %d", tt.filter, len(tagged), tt.want)
+		}
+	}
 
-	// Run migrations
-	m, err := migrate.New("file://migrations/sqlite3", connString)
+	// Test filtering runs by query
+	for query, want := range map[string]bool{
+		`params.learning_rate > 0.0001 AND metrics.loss < 0.25 AND name ~ "test"`: true,
+		`params.learning_rate > 0.01`: false,
+		`metrics.loss > 0.3`:          false,
+		`params.epochs >= 100 AND params.use_gpu = true AND params.model_name = bert-base`: true,
+		`params.model_name ~ BERT AND NOT params.use_gpu = false`:                          true,
+		`(tags.team = nlp OR tags.team ~ vis) AND experiment = Default`:                    true,
+		`NOT tags.team = vision`:                         false,
+		`name ~ "100%" OR params.learning_rate = "0.01"`: false,
+	} {
+		q, err := parseRunQuery(query)
+		if err != nil {
+			t.Fatalf("parseRunQuery(%q) failed: %v", query, err)
+		}
+		matched, err := dao.GetRuns(ctx, 0, 100, "created", "desc", RunFilter{Query: q})
+		if err != nil {
+			t.Fatalf("GetRuns with query %q failed: %v", query, err)
+		}
+		found := slices.ContainsFunc(matched, func(r RunSummary) bool { return r.UUID == runUUID })
+		if found != want {
+			t.Errorf("GetRuns with query %q matched the run: %v, want %v", query, found, want)
+		}
+	}
+	for _, tt := range []struct {
+		filter RunFilter
+		want   bool
+	}{
+		{RunFilter{CreatedAfter: time.Now().Add(-time.Hour)}, true},
+		{RunFilter{CreatedAfter: time.Now().Add(time.Hour)}, false},
+		{RunFilter{CreatedAfter: time.Now().AddDate(0, 0, -1), CreatedBefore: time.Now().AddDate(0, 0, 1)}, true},
+		{RunFilter{CreatedBefore: time.Now().Add(-time.Hour)}, false},
+	} {
+		matched, err := dao.GetRuns(ctx, 0, 100, "created", "desc", tt.filter)
+		if err != nil {
+			t.Fatalf("GetRuns with creation range failed: %v", err)
+		}
+		found := slices.ContainsFunc(matched, func(r RunSummary) bool { return r.UUID == runUUID })
+		if found != tt.want {
+			t.Errorf("GetRuns created from %v to %v matched the run: %v, want %v", tt.filter.CreatedAfter, tt.filter.CreatedBefore, found, tt.want)
+		}
+	}
+	// Metric sorts sort by the latest value, with runs that did not log the
+	// metric last either way
+	lossQuery, _ := parseRunQuery("metrics.loss < 0.25")
+	byLoss, err := dao.GetRuns(ctx, 0, 100, "metric:loss", "asc", RunFilter{Query: lossQuery, Tags: []TagFilter{{Key: "team"}}})
 	if err != nil {
-		t.Fatalf("Failed to create migrator: %v", err)
+		t.Fatalf("GetRuns by metric failed: %v", err)
 	}
-	if err := m.Up(); err != nil && err != migrate.ErrNoChange {
-		t.Fatalf("Failed to run migrations: %v", err)
+	if len(byLoss) != 1 || byLoss[0].UUID != runUUID || byLoss[0].SortValue == nil || *byLoss[0].SortValue >= 0.25 {
+		t.Errorf("Expected the run sorted by its latest loss, got %+v", byLoss)
+	}
+	for _, dir := range []string{"asc", "desc"} {
+		all, err := dao.GetRuns(ctx, 0, 100, "metric:loss", dir, RunFilter{})
+		if err != nil {
+			t.Fatalf("GetRuns by metric failed: %v", err)
+		}
+		for i := 1; i < len(all); i++ {
+			if all[i-1].SortValue == nil && all[i].SortValue != nil {
+				t.Errorf("GetRuns by metric %s listed a run without the metric before one with it", dir)
+			}
+		}
+	}
+	if deleted, err := dao.DeleteRunTag(ctx, runID, "baseline"); err != nil || !deleted {
+		t.Errorf("DeleteRunTag = %v, %v; want true", deleted, err)
+	}
+	if deleted, err := dao.DeleteRunTag(ctx, runID, "baseline"); err != nil || deleted {
+		t.Errorf("Second DeleteRunTag = %v, %v; want false", deleted, err)
 	}
 
-	// Reopen database connection
-	db, err = sql.Open("sqlite3", dbFile)
+	// Test ReplaceRunEnvironment and GetRunEnvironment
+	if err := dao.ReplaceRunEnvironment(ctx, runID, []EnvironmentVariableRow{{Key: "HOME", Value: "/root"}, {Key: "TOKEN", Value: redactedValue, Redacted: true}}); err != nil {
+		t.Fatalf("ReplaceRunEnvironment failed: %v", err)
+	}
+	if err := dao.ReplaceRunEnvironment(ctx, runID, []EnvironmentVariableRow{{Key: "USER", Value: "me"}, {Key: "API_KEY", Value: redactedValue, Redacted: true}}); err != nil {
+		t.Fatalf("ReplaceRunEnvironment failed: %v", err)
+	}
+	environment, err := dao.GetRunEnvironment(ctx, runID)
 	if err != nil {
-		t.Fatalf("Failed to reopen SQLite database: %v", err)
+		t.Fatalf("GetRunEnvironment failed: %v", err)
+	}
+	if len(environment) != 2 || environment[0] != (EnvironmentVariableRow{Key: "API_KEY", Value: redactedValue, Redacted: true}) || environment[1].Key != "USER" {
+		t.Errorf("Expected the second snapshot to replace the first, got %+v", environment)
 	}
-	defer db.Close()
 
-	dao := NewSQLiteDAO(db)
-	testDAOImplementation(t, dao)
+	// Test InsertAPIToken, GetAPITokenByHash, GetAllAPITokens, and RevokeAPIToken
+	if err := dao.InsertAPIToken(ctx, "ci", "hash-ci", sql.NullInt64{}, sql.NullInt64{}); err != nil {
+		t.Fatalf("InsertAPIToken failed: %v", err)
+	}
+	if err := dao.InsertAPIToken(ctx, "ci", "hash-other", sql.NullInt64{}, sql.NullInt64{}); err == nil {
+		t.Error("Expected InsertAPIToken to reject a reused name")
+	}
+	apiToken, err := dao.GetAPITokenByHash(ctx, "hash-ci")
+	if err != nil || apiToken == nil || apiToken.Name != "ci" || apiToken.RevokedAt.Valid {
+		t.Fatalf("GetAPITokenByHash = %+v, %v", apiToken, err)
+	}
+	if missing, err := dao.GetAPITokenByHash(ctx, "hash-missing"); err != nil || missing != nil {
+		t.Errorf("Expected no token for an unknown hash, got %+v (%v)", missing, err)
+	}
+	if revoked, err := dao.RevokeAPIToken(ctx, "ci"); err != nil || !revoked {
+		t.Errorf("RevokeAPIToken = %v, %v; want true", revoked, err)
+	}
+	if revoked, err := dao.RevokeAPIToken(ctx, "ci"); err != nil || revoked {
+		t.Errorf("Second RevokeAPIToken = %v, %v; want false", revoked, err)
+	}
+	apiTokens, err := dao.GetAllAPITokens(ctx)
+	if err != nil || len(apiTokens) != 1 || !apiTokens[0].RevokedAt.Valid || apiTokens[0].UserID.Valid {
+		t.Errorf("Expected the revoked token to be listed, got %+v (%v)", apiTokens, err)
+	}
+
+	// Test kiosk tokens, which show an experiment's wallboard
+	if err := dao.InsertKioskToken(ctx, "lab-tv", "hash-tv", defaultExpID); err != nil {
+		t.Fatalf("InsertKioskToken failed: %v", err)
+	}
+	if apiToken, err := dao.GetAPITokenByHash(ctx, "hash-tv"); err != nil || apiToken == nil || apiToken.KioskExperimentID.Int64 != int64(defaultExpID) || apiToken.ProjectID.Valid || apiToken.UserID.Valid {
+		t.Errorf("Expected a kiosk token for the default experiment, got %+v (%v)", apiToken, err)
+	}
+	if _, err := dao.RevokeAPIToken(ctx, "lab-tv"); err != nil {
+		t.Fatalf("RevokeAPIToken failed for a kiosk token: %v", err)
+	}
+
+	// Test users and the tokens issued to them
+	if err := dao.InsertUser(ctx, "alice", "editor"); err != nil {
+		t.Fatalf("InsertUser failed: %v", err)
+	}
+	if err := dao.InsertUser(ctx, "alice", "viewer"); err == nil {
+		t.Error("Expected InsertUser to reject a reused name")
+	}
+	user, err := dao.GetUserByName(ctx, "alice")
+	if err != nil || user == nil || user.Role != "editor" {
+		t.Fatalf("GetUserByName = %+v, %v", user, err)
+	}
+	if missing, err := dao.GetUserByName(ctx, "bob"); err != nil || missing != nil {
+		t.Errorf("Expected no user for an unknown name, got %+v (%v)", missing, err)
+	}
+	if err := dao.SetUserRole(ctx, user.ID, "admin"); err != nil {
+		t.Fatalf("SetUserRole failed: %v", err)
+	}
+	if user, err = dao.GetUserByID(ctx, user.ID); err != nil || user == nil || user.Role != "admin" {
+		t.Errorf("Expected the role to be changed, got %+v (%v)", user, err)
+	}
+	if err := dao.InsertAPIToken(ctx, "alice-laptop", "hash-alice", sql.NullInt64{}, sql.NullInt64{Int64: int64(user.ID), Valid: true}); err != nil {
+		t.Fatalf("InsertAPIToken failed for a user's token: %v", err)
+	}
+	if apiToken, err := dao.GetAPITokenByHash(ctx, "hash-alice"); err != nil || apiToken == nil || apiToken.UserID.Int64 != int64(user.ID) {
+		t.Errorf("Expected the token to be issued to alice, got %+v (%v)", apiToken, err)
+	}
+	if users, err := dao.GetAllUsers(ctx); err != nil || len(users) != 1 {
+		t.Errorf("Expected 1 user, got %+v (%v)", users, err)
+	}
+	if err := dao.DeleteUser(ctx, user.ID); err != nil {
+		t.Fatalf("DeleteUser failed: %v", err)
+	}
+	if deleted, err := dao.GetUserByID(ctx, user.ID); err != nil || deleted != nil {
+		t.Errorf("Expected the user to be deleted, got %+v (%v)", deleted, err)
+	}
+	if apiToken, err := dao.GetAPITokenByHash(ctx, "hash-alice"); err != nil || apiToken == nil || !apiToken.RevokedAt.Valid {
+		t.Errorf("Expected the deleted user's token to be revoked, got %+v (%v)", apiToken, err)
+	}
+
+	// Test best checkpoint rules and designations
+	if rule, err := dao.GetBestCheckpointRule(ctx, defaultExpID); err != nil || rule != nil {
+		t.Errorf("Expected no best checkpoint rule, got %+v (%v)", rule, err)
+	}
+	if err := dao.UpsertBestCheckpointRule(ctx, defaultExpID, BestCheckpointRuleRow{MetricKey: "loss", Mode: "min", ArtifactPattern: "ckpt/*"}); err != nil {
+		t.Fatalf("UpsertBestCheckpointRule failed: %v", err)
+	}
+	if err := dao.UpsertBestCheckpointRule(ctx, defaultExpID, BestCheckpointRuleRow{MetricKey: "acc", Mode: "max", ArtifactPattern: "ckpt/*"}); err != nil {
+		t.Fatalf("UpsertBestCheckpointRule failed: %v", err)
+	}
+	if rule, err := dao.GetBestCheckpointRule(ctx, defaultExpID); err != nil || rule == nil || *rule != (BestCheckpointRuleRow{MetricKey: "acc", Mode: "max", ArtifactPattern: "ckpt/*"}) {
+		t.Errorf("Expected the second rule to replace the first, got %+v (%v)", rule, err)
+	}
+	if err := dao.DeleteBestCheckpointRule(ctx, defaultExpID); err != nil {
+		t.Fatalf("DeleteBestCheckpointRule failed: %v", err)
+	}
+	if rule, err := dao.GetBestCheckpointRule(ctx, defaultExpID); err != nil || rule != nil {
+		t.Errorf("Expected the rule to be deleted, got %+v (%v)", rule, err)
+	}
+	designatedAt := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	best := RunBestCheckpointRow{RunID: runID, Path: "ckpt/1.pt", Source: "rule", DesignatedAt: designatedAt}
+	best.MetricKey = sql.NullString{String: "acc", Valid: true}
+	best.MetricValue = sql.NullFloat64{Float64: 0.5, Valid: true}
+	if err := dao.SetRunBestCheckpoint(ctx, best); err != nil {
+		t.Fatalf("SetRunBestCheckpoint failed: %v", err)
+	}
+	if err := dao.SetRunBestCheckpoint(ctx, RunBestCheckpointRow{RunID: runID, Path: "ckpt/2.pt", Source: "manual", DesignatedAt: designatedAt}); err != nil {
+		t.Fatalf("SetRunBestCheckpoint failed: %v", err)
+	}
+	got, err := dao.GetRunBestCheckpoint(ctx, runID)
+	if err != nil || got == nil || got.Path != "ckpt/2.pt" || got.Source != "manual" || got.MetricValue.Valid || !got.DesignatedAt.Equal(designatedAt) {
+		t.Errorf("Expected the manual designation to replace the rule's, got %+v (%v)", got, err)
+	}
+	bests, err := dao.GetRunBestCheckpointsByExperimentID(ctx, defaultExpID)
+	if err != nil || len(bests) != 1 || bests[0].RunID != runID {
+		t.Errorf("Expected the run's best checkpoint in its experiment, got %+v (%v)", bests, err)
+	}
+	if cleared, err := dao.ClearRunBestCheckpoint(ctx, runID); err != nil || !cleared {
+		t.Errorf("ClearRunBestCheckpoint = %v, %v; want true", cleared, err)
+	}
+	if cleared, err := dao.ClearRunBestCheckpoint(ctx, runID); err != nil || cleared {
+		t.Errorf("Second ClearRunBestCheckpoint = %v, %v; want false", cleared, err)
+	}
+	if got, err := dao.GetRunBestCheckpoint(ctx, runID); err != nil || got != nil {
+		t.Errorf("Expected no best checkpoint after clearing, got %+v (%v)", got, err)
+	}
+
+	// Test housekeeping reports
+	createdAt := time.Date(2024, 3, 2, 12, 0, 0, 0, time.UTC)
+	planID, err := dao.InsertHousekeepingReport(ctx, HousekeepingReportRow{Job: "artifact-gc", DryRun: true, URI: "file://_housekeeping/plan.json", ItemCount: 2, RunCount: 1, SizeBytes: 5 << 30, CreatedAt: createdAt})
+	if err != nil {
+		t.Fatalf("InsertHousekeepingReport failed: %v", err)
+	}
+	plan, err := dao.GetHousekeepingReport(ctx, planID)
+	if err != nil || plan == nil || !plan.DryRun || plan.SizeBytes != 5<<30 || plan.ExecutedAt.Valid || !plan.CreatedAt.Equal(createdAt) {
+		t.Errorf("Expected the dry run report, got %+v (%v)", plan, err)
+	}
+	if claimed, err := dao.MarkHousekeepingReportExecuted(ctx, planID, createdAt.Add(time.Hour)); err != nil || !claimed {
+		t.Errorf("MarkHousekeepingReportExecuted = %v, %v; want true", claimed, err)
+	}
+	if claimed, err := dao.MarkHousekeepingReportExecuted(ctx, planID, createdAt.Add(time.Hour)); err != nil || claimed {
+		t.Errorf("Second MarkHousekeepingReportExecuted = %v, %v; want false", claimed, err)
+	}
+	executed := HousekeepingReportRow{Job: "artifact-gc", URI: "file://_housekeeping/executed.json", ItemCount: 2, CreatedAt: createdAt.Add(time.Hour)}
+	executed.PlanID = sql.NullInt64{Int64: int64(planID), Valid: true}
+	if _, err := dao.InsertHousekeepingReport(ctx, executed); err != nil {
+		t.Fatalf("InsertHousekeepingReport failed: %v", err)
+	}
+	reports, err := dao.GetHousekeepingReports(ctx, 10)
+	if err != nil || len(reports) != 2 || reports[0].PlanID.Int64 != int64(planID) || !reports[1].ExecutedAt.Valid {
+		t.Errorf("Expected the execution then its executed dry run, got %+v (%v)", reports, err)
+	}
+	if missing, err := dao.GetHousekeepingReport(ctx, planID+100); err != nil || missing != nil {
+		t.Errorf("Expected no report, got %+v (%v)", missing, err)
+	}
+
+	// Test AcquireJobLease
+	leaseAt := time.Date(2024, 3, 3, 12, 0, 0, 0, time.UTC)
+	if held, err := dao.AcquireJobLease(ctx, "housekeeping", "replica-a", leaseAt, time.Minute); err != nil || !held {
+		t.Errorf("AcquireJobLease = %v, %v; want the free lease", held, err)
+	}
+	if held, err := dao.AcquireJobLease(ctx, "housekeeping", "replica-b", leaseAt.Add(30*time.Second), time.Minute); err != nil || held {
+		t.Errorf("AcquireJobLease = %v, %v; want another replica's lease refused", held, err)
+	}
+	if held, err := dao.AcquireJobLease(ctx, "housekeeping", "replica-a", leaseAt.Add(45*time.Second), time.Minute); err != nil || !held {
+		t.Errorf("AcquireJobLease = %v, %v; want the holder to renew its lease", held, err)
+	}
+	if held, err := dao.AcquireJobLease(ctx, "housekeeping", "replica-b", leaseAt.Add(90*time.Second), time.Minute); err != nil || held {
+		t.Errorf("AcquireJobLease = %v, %v; want the renewed lease refused", held, err)
+	}
+	if held, err := dao.AcquireJobLease(ctx, "housekeeping", "replica-b", leaseAt.Add(2*time.Minute), time.Minute); err != nil || !held {
+		t.Errorf("AcquireJobLease = %v, %v; want the expired lease taken over", held, err)
+	}
+
+	// Test GetCacheGeneration and BumpCacheGeneration
+	if generation, err := dao.GetCacheGeneration(ctx, "home"); err != nil || generation != 0 {
+		t.Errorf("GetCacheGeneration = %d, %v; want 0", generation, err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := dao.BumpCacheGeneration(ctx, "home"); err != nil {
+			t.Fatalf("BumpCacheGeneration failed: %v", err)
+		}
+	}
+	if generation, err := dao.GetCacheGeneration(ctx, "home"); err != nil || generation != 2 {
+		t.Errorf("GetCacheGeneration = %d, %v; want 2", generation, err)
+	}
+
+	// Test artifact cache invalidations, which replicas re-read by time
+	invalidatedAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, uri := range []string{"s3://bucket/a", "s3://bucket/b"} {
+		if err := dao.InsertArtifactCacheInvalidation(ctx, uri, invalidatedAt.Add(time.Duration(i)*time.Hour)); err != nil {
+			t.Fatalf("InsertArtifactCacheInvalidation failed: %v", err)
+		}
+	}
+	invalidations, err := dao.GetArtifactCacheInvalidations(ctx, time.Time{})
+	if err != nil || len(invalidations) != 2 || invalidations[0].URI != "s3://bucket/a" || !invalidations[1].InvalidatedAt.Equal(invalidatedAt.Add(time.Hour)) {
+		t.Fatalf("GetArtifactCacheInvalidations = %+v, %v; want a then b", invalidations, err)
+	}
+	if since, err := dao.GetArtifactCacheInvalidations(ctx, invalidatedAt.Add(time.Hour)); err != nil || len(since) != 1 || since[0].URI != "s3://bucket/b" {
+		t.Errorf("GetArtifactCacheInvalidations since b = %+v, %v; want b", since, err)
+	}
+	if err := dao.DeleteArtifactCacheInvalidations(ctx, invalidatedAt.Add(30*time.Minute)); err != nil {
+		t.Fatalf("DeleteArtifactCacheInvalidations failed: %v", err)
+	}
+	if remaining, err := dao.GetArtifactCacheInvalidations(ctx, time.Time{}); err != nil || len(remaining) != 1 || remaining[0].URI != "s3://bucket/b" {
+		t.Errorf("Expected only the later invalidation kept, got %+v, %v", remaining, err)
+	}
+
+	// Test InsertRunAnnotation and GetRunAnnotationsByRunID
+	err = dao.InsertRunAnnotation(ctx, runID, 20, "lr dropped to 1e-4")
+	if err != nil {
+		t.Fatalf("InsertRunAnnotation failed: %v", err)
+	}
+	err = dao.InsertRunAnnotation(ctx, runID, 10, "resumed from checkpoint")
+	if err != nil {
+		t.Fatalf("InsertRunAnnotation failed: %v", err)
+	}
+	annotations, err := dao.GetRunAnnotationsByRunID(ctx, runID)
+	if err != nil {
+		t.Fatalf("GetRunAnnotationsByRunID failed: %v", err)
+	}
+	if len(annotations) != 2 {
+		t.Fatalf("Expected 2 annotations, got %d", len(annotations))
+	}
+	if annotations[0].Step != 10 || annotations[0].Text != "resumed from checkpoint" {
+		t.Errorf("Annotations not ordered by step: got %+v", annotations)
+	}
+
+	// Test SearchRuns over names, notes, annotations, and tags
+	if err := dao.SetRunTag(ctx, runID, "dataset", "imagenet"); err != nil {
+		t.Fatalf("SetRunTag failed: %v", err)
+	}
+	err = dao.UpdateRunNotes(ctx, runID, "Tried label smoothing with a warmup schedule")
+	if err != nil {
+		t.Fatalf("UpdateRunNotes failed: %v", err)
+	}
+	if run, err := dao.GetRunByUUID(ctx, runUUID); err != nil || run.Notes != "Tried label smoothing with a warmup schedule" {
+		t.Errorf("Expected the notes saved, got %+v (err %v)", run, err)
+	}
+	searchCases := []struct {
+		terms []string
+		want  bool
+	}{
+		{[]string{"label", "smoothing"}, true},
+		{[]string{"smooth"}, true},
+		{[]string{"checkpoint"}, true},
+		{[]string{"label", "checkpoint"}, true},
+		{[]string{"label", "dropout"}, false},
+		{[]string{"imagenet"}, true},
+		{[]string{"dataset", "smoothing"}, true},
+	}
+	for _, tc := range searchCases {
+		results, err := dao.SearchRuns(ctx, tc.terms, 10, 0)
+		if err != nil {
+			t.Fatalf("SearchRuns(%v) failed: %v", tc.terms, err)
+		}
+		found := false
+		for _, r := range results {
+			if r.UUID == runUUID {
+				found = true
+				if r.ExperimentUUID == "" || r.Snippet == "" {
+					t.Errorf("SearchRuns(%v) returned incomplete result: %+v", tc.terms, r)
+				}
+			}
+		}
+		if found != tc.want {
+			t.Errorf("SearchRuns(%v) found run = %v, want %v", tc.terms, found, tc.want)
+		}
+	}
+	if _, err := dao.DeleteRunTag(ctx, runID, "dataset"); err != nil {
+		t.Fatalf("DeleteRunTag failed: %v", err)
+	}
+	if results, err := dao.SearchRuns(ctx, []string{"imagenet"}, 10, 0); err != nil || slices.ContainsFunc(results, func(r RunSearchRow) bool { return r.UUID == runUUID }) {
+		t.Errorf("Expected a deleted tag not to match, got %+v (%v)", results, err)
+	}
+
+	// Test SetRunHold, GetRunHold, and ClearRunHold
+	hold, err := dao.GetRunHold(ctx, runID)
+	if err != nil {
+		t.Fatalf("GetRunHold failed: %v", err)
+	}
+	if hold != nil {
+		t.Errorf("Expected no hold on a new run, got %+v", hold)
+	}
+	err = dao.SetRunHold(ctx, runID, "cited in paper")
+	if err != nil {
+		t.Fatalf("SetRunHold failed: %v", err)
+	}
+	hold, err = dao.GetRunHold(ctx, runID)
+	if err != nil {
+		t.Fatalf("GetRunHold failed after SetRunHold: %v", err)
+	}
+	if hold == nil || hold.Reason != "cited in paper" || hold.HeldAt.IsZero() {
+		t.Errorf("GetRunHold returned incorrect data: got %+v", hold)
+	}
+	err = dao.ClearRunHold(ctx, runID)
+	if err != nil {
+		t.Fatalf("ClearRunHold failed: %v", err)
+	}
+	hold, err = dao.GetRunHold(ctx, runID)
+	if err != nil {
+		t.Fatalf("GetRunHold failed after ClearRunHold: %v", err)
+	}
+	if hold != nil {
+		t.Errorf("Expected no hold after ClearRunHold, got %+v", hold)
+	}
+
+	// Test SetRunSource, GetRunSource, and filtering runs by user and commit
+	if source, err := dao.GetRunSource(ctx, runID); err != nil || source != nil {
+		t.Errorf("Expected no source for a new run, got %+v (%v)", source, err)
+	}
+	source := RunSourceRow{User: "ada", GitCommit: "0123456789abcdef0123456789abcdef01234567", GitDirty: sql.NullBool{Bool: false, Valid: true}, Entrypoint: "train.py"}
+	if err := dao.SetRunSource(ctx, runID, source); err != nil {
+		t.Fatalf("SetRunSource failed: %v", err)
+	}
+	if got, err := dao.GetRunSource(ctx, runID); err != nil || got == nil || *got != source {
+		t.Errorf("GetRunSource returned %+v (%v), want %+v", got, err, source)
+	}
+	for _, tt := range []struct {
+		filter RunFilter
+		want   bool
+	}{
+		{RunFilter{User: "ada"}, true},
+		{RunFilter{User: "grace"}, false},
+		{RunFilter{GitCommit: "0123"}, true},
+		{RunFilter{User: "ada", GitCommit: "0123456789abcdef0123456789abcdef01234567"}, true},
+		{RunFilter{GitCommit: "fedc"}, false},
+	} {
+		matched, err := dao.GetRuns(ctx, 0, 100, "created", "desc", tt.filter)
+		if err != nil {
+			t.Fatalf("GetRuns with source filter failed: %v", err)
+		}
+		found := slices.ContainsFunc(matched, func(r RunSummary) bool { return r.UUID == runUUID })
+		if found != tt.want {
+			t.Errorf("GetRuns(%+v) matched the run: %v, want %v", tt.filter, found, tt.want)
+		}
+	}
+
+	// Test SetRunSeeds, GetRunSeeds, and filtering reproducible runs. The run
+	// logged its environment and is from a clean commit, so it is
+	// reproducible once seeded.
+	reproducible := func() bool {
+		matched, err := dao.GetRuns(ctx, 0, 100, "created", "desc", RunFilter{Reproducible: true})
+		if err != nil {
+			t.Fatalf("GetRuns with reproducible filter failed: %v", err)
+		}
+		i := slices.IndexFunc(matched, func(r RunSummary) bool { return r.UUID == runUUID })
+		if i >= 0 && !matched[i].Reproducible {
+			t.Errorf("Expected a reproducible run listed as reproducible")
+		}
+		return i >= 0
+	}
+	if seeds, err := dao.GetRunSeeds(ctx, runID); err != nil || seeds != nil {
+		t.Errorf("Expected no seeds for a new run, got %+v (%v)", seeds, err)
+	}
+	if reproducible() {
+		t.Errorf("Expected a run without seeds not to be reproducible")
+	}
+	seeds := RunSeedsRow{Seed: "18446744073709551615", Libraries: []LibrarySeedRow{{Library: "numpy", Seed: "1"}, {Library: "torch", Seed: "2"}}}
+	if err := dao.SetRunSeeds(ctx, runID, seeds); err != nil {
+		t.Fatalf("SetRunSeeds failed: %v", err)
+	}
+	if got, err := dao.GetRunSeeds(ctx, runID); err != nil || got == nil || got.Seed != seeds.Seed || !slices.Equal(got.Libraries, seeds.Libraries) {
+		t.Errorf("GetRunSeeds returned %+v (%v), want %+v", got, err, seeds)
+	}
+	if !reproducible() {
+		t.Errorf("Expected a seeded run from a clean commit with its environment to be reproducible")
+	}
+	seeds = RunSeedsRow{Libraries: []LibrarySeedRow{{Library: "random", Seed: "3"}}}
+	if err := dao.SetRunSeeds(ctx, runID, seeds); err != nil {
+		t.Fatalf("SetRunSeeds failed: %v", err)
+	}
+	if got, err := dao.GetRunSeeds(ctx, runID); err != nil || got == nil || got.Seed != seeds.Seed || !slices.Equal(got.Libraries, seeds.Libraries) {
+		t.Errorf("Expected the seeds replaced with %+v, got %+v (%v)", seeds, got, err)
+	}
+	source.GitDirty = sql.NullBool{Bool: true, Valid: true}
+	if err := dao.SetRunSource(ctx, runID, source); err != nil {
+		t.Fatalf("SetRunSource failed: %v", err)
+	}
+	if reproducible() {
+		t.Errorf("Expected a run with uncommitted changes not to be reproducible")
+	}
+
+	// Test InsertRunWithSourceAndSeeds: the run is created with its source
+	// and seeds, or not at all
+	seededUUID := "seeded-run-uuid"
+	if err := dao.InsertRunWithSourceAndSeeds(ctx, seededUUID, "Seeded Run", defaultExpID, nil, source, seeds); err != nil {
+		t.Fatalf("InsertRunWithSourceAndSeeds failed: %v", err)
+	}
+	seededID, err := dao.GetRunIDByUUID(ctx, seededUUID)
+	if err != nil {
+		t.Fatalf("GetRunIDByUUID failed for the seeded run: %v", err)
+	}
+	if got, err := dao.GetRunSource(ctx, seededID); err != nil || got == nil || *got != source {
+		t.Errorf("GetRunSource returned %+v (%v), want %+v", got, err, source)
+	}
+	if got, err := dao.GetRunSeeds(ctx, seededID); err != nil || got == nil || !slices.Equal(got.Libraries, seeds.Libraries) {
+		t.Errorf("GetRunSeeds returned %+v (%v), want %+v", got, err, seeds)
+	}
+	duplicated := RunSeedsRow{Libraries: []LibrarySeedRow{{Library: "numpy", Seed: "1"}, {Library: "numpy", Seed: "2"}}}
+	if err := dao.InsertRunWithSourceAndSeeds(ctx, "half-seeded-run-uuid", "Half Seeded Run", defaultExpID, nil, source, duplicated); err == nil {
+		t.Errorf("Expected InsertRunWithSourceAndSeeds to fail with a library seeded twice")
+	}
+	if _, err := dao.GetRunIDByUUID(ctx, "half-seeded-run-uuid"); err == nil {
+		t.Errorf("Expected the run to be rolled back when its seeds fail to insert")
+	}
+
+	// Test SetRunForkedFrom
+	if run, err := dao.GetRunByUUID(ctx, runUUID); err != nil || run.ForkedFrom != "" {
+		t.Errorf("Expected a new run not to be forked from another, got %+v (err %v)", run, err)
+	}
+	if err := dao.SetRunForkedFrom(ctx, runID, "source-run-uuid"); err != nil {
+		t.Fatalf("SetRunForkedFrom failed: %v", err)
+	}
+	if run, err := dao.GetRunByID(ctx, runID); err != nil || run.ForkedFrom != "source-run-uuid" {
+		t.Errorf("Expected the run forked from source-run-uuid, got %+v (err %v)", run, err)
+	}
+
+	// Test UpdateRunStatus, RecordRunActivity, and FailStaleRuns
+	if run, err := dao.GetRunByUUID(ctx, runUUID); err != nil || run.Status != runStatusRunning {
+		t.Errorf("Expected a new run to be RUNNING, got %+v (err %v)", run, err)
+	}
+	now = time.Now().UTC()
+	if err := dao.RecordRunActivity(ctx, runID, now); err != nil {
+		t.Fatalf("RecordRunActivity failed: %v", err)
+	}
+	staleUUIDs, err := dao.FailStaleRuns(ctx, now.Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("FailStaleRuns failed: %v", err)
+	}
+	if len(staleUUIDs) != 0 {
+		t.Errorf("Expected no stale runs, got %v", staleUUIDs)
+	}
+	running, err := dao.GetRunHealth(ctx, now.Add(-time.Hour), now.Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("GetRunHealth failed: %v", err)
+	}
+	quiet, err := dao.GetRunHealth(ctx, now.Add(-time.Hour), now.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("GetRunHealth failed: %v", err)
+	}
+	if running.Running == 0 || quiet.Running != running.Running || quiet.Quiet <= running.Quiet {
+		t.Errorf("Expected the run to be counted running, and quiet only since its activity, got %+v and %+v", running, quiet)
+	}
+	staleUUIDs, err = dao.FailStaleRuns(ctx, now.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("FailStaleRuns failed: %v", err)
+	}
+	if !slices.Contains(staleUUIDs, runUUID) {
+		t.Errorf("Expected %s to be stale, got %v", runUUID, staleUUIDs)
+	}
+	if run, err := dao.GetRunByID(ctx, runID); err != nil || run.Status != runStatusFailed || run.StatusMessage != runStaleStatusMessage {
+		t.Errorf("Expected stale run to be FAILED with the stale run message, got %+v (err %v)", run, err)
+	}
+	failedRuns, err := dao.GetFailedRuns(ctx, runExperimentID)
+	if err != nil {
+		t.Fatalf("GetFailedRuns failed: %v", err)
+	}
+	if i := slices.IndexFunc(failedRuns, func(f FailedRunRow) bool { return f.UUID == runUUID }); i < 0 || failedRuns[i].Message != runStaleStatusMessage || !failedRuns[i].FinishedAt.Valid {
+		t.Errorf("Expected the stale run among the failed runs, got %+v", failedRuns)
+	}
+	if failed, err := dao.GetRunHealth(ctx, now.Add(-time.Hour), now.Add(time.Hour)); err != nil || failed.Running != quiet.Running-len(staleUUIDs) || failed.Failed != quiet.Failed+len(staleUUIDs) {
+		t.Errorf("Expected the failed run to be counted failed, got %+v (err %v)", failed, err)
+	}
+	if failed, err := dao.GetRunHealth(ctx, now.Add(time.Hour), now.Add(time.Hour)); err != nil || failed.Failed != quiet.Failed {
+		t.Errorf("Expected runs that failed before failedSince not to be counted, got %+v (err %v)", failed, err)
+	}
+	if err := dao.UpdateRunStatus(ctx, runID, runStatusFailed, "RuntimeError: CUDA out of memory"); err != nil {
+		t.Fatalf("UpdateRunStatus failed: %v", err)
+	}
+	if run, err := dao.GetRunByUUID(ctx, runUUID); err != nil || run.StatusMessage != "RuntimeError: CUDA out of memory" {
+		t.Errorf("Expected the run's message replaced, got %+v (err %v)", run, err)
+	}
+	if err := dao.UpdateRunStatus(ctx, runID, runStatusFinished, ""); err != nil {
+		t.Fatalf("UpdateRunStatus failed: %v", err)
+	}
+	if run, err := dao.GetRunByUUID(ctx, runUUID); err != nil || run.Status != runStatusFinished || run.StatusMessage != "" {
+		t.Errorf("Expected run to be FINISHED without a message, got %+v (err %v)", run, err)
+	}
+	if failedRuns, err := dao.GetFailedRuns(ctx, runExperimentID); err != nil || slices.ContainsFunc(failedRuns, func(f FailedRunRow) bool { return f.UUID == runUUID }) {
+		t.Errorf("Expected a finished run not among the failed runs, got %+v (err %v)", failedRuns, err)
+	}
+	if staleUUIDs, err := dao.FailStaleRuns(ctx, now.Add(time.Hour)); err != nil || len(staleUUIDs) != 0 {
+		t.Errorf("Expected finished runs not to be failed, got %v (err %v)", staleUUIDs, err)
+	}
+
+	// Test run templates
+	templateParams := []RunTemplateParameterRow{
+		{ParameterRow: ParameterRow{Key: "lr", ValueType: "float", ValueFloat: sql.NullFloat64{Float64: 0.001, Valid: true}}, Prompt: true},
+		{ParameterRow: ParameterRow{Key: "model", ValueType: "string", ValueString: sql.NullString{String: "bert", Valid: true}}},
+	}
+	err = dao.InsertRunTemplate(ctx, "baseline", defaultExpID, templateParams)
+	if err != nil {
+		t.Fatalf("InsertRunTemplate failed: %v", err)
+	}
+	if err = dao.InsertRunTemplate(ctx, "baseline", defaultExpID, nil); err == nil {
+		t.Error("InsertRunTemplate allowed a duplicate template name")
+	}
+	runTemplate, err := dao.GetRunTemplateByName(ctx, "baseline")
+	if err != nil {
+		t.Fatalf("GetRunTemplateByName failed: %v", err)
+	}
+	if runTemplate.Name != "baseline" || runTemplate.ExperimentID != defaultExpID {
+		t.Errorf("GetRunTemplateByName returned incorrect data: got %+v", runTemplate)
+	}
+	if _, err := dao.GetRunTemplateByName(ctx, "missing"); err == nil {
+		t.Error("GetRunTemplateByName found a template that does not exist")
+	}
+	savedParams, err := dao.GetRunTemplateParameters(ctx, runTemplate.ID)
+	if err != nil {
+		t.Fatalf("GetRunTemplateParameters failed: %v", err)
+	}
+	if len(savedParams) != 2 || savedParams[0].Key != "lr" || !savedParams[0].Prompt ||
+		savedParams[0].ValueFloat.Float64 != 0.001 || savedParams[1].Prompt || savedParams[1].ValueString.String != "bert" {
+		t.Errorf("GetRunTemplateParameters returned incorrect data: got %+v", savedParams)
+	}
+	allTemplates, err := dao.GetAllRunTemplates(ctx)
+	if err != nil {
+		t.Fatalf("GetAllRunTemplates failed: %v", err)
+	}
+	if len(allTemplates) != 1 {
+		t.Errorf("Expected 1 run template, got %d", len(allTemplates))
+	}
+
+	// Test notification subscriptions
+	err = dao.InsertNotificationSubscription(ctx, NotificationSubscriptionRow{
+		Channel: "webhook",
+		Target:  "https://example.com/global",
+	})
+	if err != nil {
+		t.Fatalf("InsertNotificationSubscription failed for global subscription: %v", err)
+	}
+	err = dao.InsertNotificationSubscription(ctx, NotificationSubscriptionRow{
+		ExperimentID: sql.NullInt64{Int64: int64(expID), Valid: true},
+		Channel:      "slack",
+		Target:       "https://hooks.slack.com/services/x",
+		Events:       "run_created",
+		Tag:          "production",
+	})
+	if err != nil {
+		t.Fatalf("InsertNotificationSubscription failed for scoped subscription: %v", err)
+	}
+	subs, err := dao.GetNotificationSubscriptions(ctx)
+	if err != nil {
+		t.Fatalf("GetNotificationSubscriptions failed: %v", err)
+	}
+	if len(subs) != 2 {
+		t.Fatalf("Expected 2 notification subscriptions, got %d", len(subs))
+	}
+	if subs[0].ExperimentID.Valid || subs[1].ExperimentID.Int64 != int64(expID) ||
+		subs[1].Events != "run_created" || subs[1].Tag != "production" {
+		t.Errorf("GetNotificationSubscriptions returned incorrect data: got %+v", subs)
+	}
+	err = dao.DeleteNotificationSubscription(ctx, subs[0].ID)
+	if err != nil {
+		t.Fatalf("DeleteNotificationSubscription failed: %v", err)
+	}
+	subs, err = dao.GetNotificationSubscriptions(ctx)
+	if err != nil {
+		t.Fatalf("GetNotificationSubscriptions failed after delete: %v", err)
+	}
+	if len(subs) != 1 {
+		t.Errorf("Expected 1 notification subscription after delete, got %d", len(subs))
+	}
+
+	// Test notification preferences
+	err = dao.InsertNotificationPreference(ctx, NotificationPreferenceRow{
+		User:    "alice",
+		Event:   "own_run_ended",
+		Channel: "web",
+	})
+	if err != nil {
+		t.Fatalf("InsertNotificationPreference failed: %v", err)
+	}
+	err = dao.InsertNotificationPreference(ctx, NotificationPreferenceRow{
+		User:         "alice",
+		Event:        "run_failed",
+		ExperimentID: sql.NullInt64{Int64: int64(expID), Valid: true},
+		Channel:      "slack",
+		Target:       "https://hooks.slack.com/services/x",
+	})
+	if err != nil {
+		t.Fatalf("InsertNotificationPreference failed for scoped preference: %v", err)
+	}
+	prefs, err := dao.GetNotificationPreferences(ctx)
+	if err != nil {
+		t.Fatalf("GetNotificationPreferences failed: %v", err)
+	}
+	if len(prefs) != 2 || prefs[0].User != "alice" || prefs[0].ExperimentID.Valid ||
+		prefs[1].ExperimentID.Int64 != int64(expID) || prefs[1].Target != "https://hooks.slack.com/services/x" {
+		t.Fatalf("GetNotificationPreferences returned incorrect data: got %+v", prefs)
+	}
+	if err := dao.DeleteNotificationPreference(ctx, prefs[0].ID, "bob"); err != nil {
+		t.Fatalf("DeleteNotificationPreference failed: %v", err)
+	}
+	if prefs, _ = dao.GetNotificationPreferences(ctx); len(prefs) != 2 {
+		t.Errorf("Expected another user's preference to be kept, got %d preferences", len(prefs))
+	}
+	if err := dao.DeleteNotificationPreference(ctx, prefs[0].ID, "alice"); err != nil {
+		t.Fatalf("DeleteNotificationPreference failed: %v", err)
+	}
+	if prefs, _ = dao.GetNotificationPreferences(ctx); len(prefs) != 1 {
+		t.Errorf("Expected 1 notification preference after delete, got %d", len(prefs))
+	}
+	for _, message := range []string{"first", "second"} {
+		if err := dao.InsertWebNotification(ctx, WebNotificationRow{User: "alice", RunUUID: runUUID, Message: message}); err != nil {
+			t.Fatalf("InsertWebNotification failed: %v", err)
+		}
+	}
+	webNotifications, err := dao.GetWebNotifications(ctx, "alice", 1)
+	if err != nil {
+		t.Fatalf("GetWebNotifications failed: %v", err)
+	}
+	if len(webNotifications) != 1 || webNotifications[0].Message != "second" || webNotifications[0].RunUUID != runUUID {
+		t.Errorf("Expected the latest web notification, got %+v", webNotifications)
+	}
+	if webNotifications, _ = dao.GetWebNotifications(ctx, "bob", 10); len(webNotifications) != 0 {
+		t.Errorf("Expected no web notifications for another user, got %+v", webNotifications)
+	}
+
+	// Test GetExperimentByID
+	experimentByID, err := dao.GetExperimentByID(ctx, expID)
+	if err != nil {
+		t.Fatalf("GetExperimentByID failed: %v", err)
+	}
+	if experimentByID.UUID != expUUID {
+		t.Errorf("GetExperimentByID returned incorrect data: got %+v", experimentByID)
+	}
+
+	// Test UpsertArtifact
+	err = dao.UpsertArtifact(ctx, runID, "model.pkl", "file:///path/to/model.pkl", "model", "", 2048, "ab12")
+	if err != nil {
+		t.Fatalf("UpsertArtifact failed: %v", err)
+	}
+
+	err = dao.UpsertArtifact(ctx, runID, "plot.png", "file:///path/to/plot.png", "image", "image/png", 512, "")
+	if err != nil {
+		t.Fatalf("UpsertArtifact failed: %v", err)
+	}
+
+	// Test GetArtifactsByRunID
+	artifacts, err := dao.GetArtifactsByRunID(ctx, runID)
+	if err != nil {
+		t.Fatalf("GetArtifactsByRunID failed: %v", err)
+	}
+	if len(artifacts) != 2 {
+		t.Errorf("Expected 2 artifacts, got %d", len(artifacts))
+	}
+
+	// Test GetArtifactByRunIDAndPath
+	artifact, err := dao.GetArtifactByRunIDAndPath(ctx, runID, "model.pkl")
+	if err != nil {
+		t.Fatalf("GetArtifactByRunIDAndPath failed: %v", err)
+	}
+	if artifact.Path != "model.pkl" || artifact.URI != "file:///path/to/model.pkl" || artifact.Type != "model" {
+		t.Errorf("GetArtifactByRunIDAndPath returned incorrect data: got %+v", artifact)
+	}
+	if artifact.SizeBytes != 2048 || !artifact.UpdatedAt.Valid || artifact.SHA256 != "ab12" {
+		t.Errorf("GetArtifactByRunIDAndPath returned incorrect size metadata: got %+v", artifact)
+	}
+	if plot, err := dao.GetArtifactByRunIDAndPath(ctx, runID, "plot.png"); err != nil || plot.SHA256 != "" || plot.ContentType != "image/png" {
+		t.Errorf("Expected plot.png to have an empty SHA256 and an image/png content type, got %+v (%v)", plot, err)
+	}
+	if artifact.ContentType != "" {
+		t.Errorf("Expected an artifact without a content type to have an empty one, got %q", artifact.ContentType)
+	}
+
+	// Test GetArtifactsToMirror, SetArtifactMirrorStatus, and GetArtifactMirrorStatusByURI
+	if artifact.MirrorStatus != artifactMirrorPending {
+		t.Errorf("Expected new artifact to be pending mirroring, got %q", artifact.MirrorStatus)
+	}
+	toMirror, err := dao.GetArtifactsToMirror(ctx, artifactMirrorMaxAttempts, 10)
+	if err != nil {
+		t.Fatalf("GetArtifactsToMirror failed: %v", err)
+	}
+	var modelMirror *ArtifactMirrorRow
+	for i, m := range toMirror {
+		if m.RunID == runID && m.Path == "model.pkl" {
+			modelMirror = &toMirror[i]
+		}
+	}
+	if modelMirror == nil || modelMirror.URI != "file:///path/to/model.pkl" || modelMirror.Attempts != 0 {
+		t.Fatalf("Expected model.pkl to await mirroring, got %+v", toMirror)
+	}
+	if err := dao.SetArtifactMirrorStatus(ctx, *modelMirror, artifactMirrorFailed, "mirror unavailable"); err != nil {
+		t.Fatalf("SetArtifactMirrorStatus failed: %v", err)
+	}
+	toMirror, err = dao.GetArtifactsToMirror(ctx, artifactMirrorMaxAttempts, 10)
+	if err != nil {
+		t.Fatalf("GetArtifactsToMirror failed: %v", err)
+	}
+	modelMirror = nil
+	for i, m := range toMirror {
+		if m.RunID == runID && m.Path == "model.pkl" {
+			modelMirror = &toMirror[i]
+		}
+	}
+	if modelMirror == nil || modelMirror.Attempts != 1 {
+		t.Fatalf("Expected failed model.pkl to be retried, got %+v", toMirror)
+	}
+	if err := dao.SetArtifactMirrorStatus(ctx, *modelMirror, artifactMirrorMirrored, ""); err != nil {
+		t.Fatalf("SetArtifactMirrorStatus failed: %v", err)
+	}
+	if status, err := dao.GetArtifactMirrorStatusByURI(ctx, "file:///path/to/model.pkl"); err != nil || status != artifactMirrorMirrored {
+		t.Errorf("Expected model.pkl to be mirrored, got %q (%v)", status, err)
+	}
+	if status, err := dao.GetArtifactMirrorStatusByURI(ctx, "file:///path/to/missing.pkl"); err != nil || status != "" {
+		t.Errorf("Expected no mirror status for an unknown URI, got %q (%v)", status, err)
+	}
+	// Overwriting an artifact resets it to pending, and outcomes recorded
+	// against the previous upload are ignored
+	time.Sleep(10 * time.Millisecond)
+	if err := dao.UpsertArtifact(ctx, runID, "model.pkl", "file:///path/to/model.pkl", "model", "", 4096, "cd34"); err != nil {
+		t.Fatalf("UpsertArtifact failed: %v", err)
+	}
+	if err := dao.SetArtifactMirrorStatus(ctx, *modelMirror, artifactMirrorMirrored, ""); err != nil {
+		t.Fatalf("SetArtifactMirrorStatus failed: %v", err)
+	}
+	if status, err := dao.GetArtifactMirrorStatusByURI(ctx, "file:///path/to/model.pkl"); err != nil || status != artifactMirrorPending {
+		t.Errorf("Expected overwritten model.pkl to be pending mirroring, got %q (%v)", status, err)
+	}
+
+	// Test upsert behavior - update existing parameter
+	newFloatValue := 0.002
+	err = dao.UpsertParameter(ctx, runID, "learning_rate", "float", nil, nil, &newFloatValue, nil)
+	if err != nil {
+		t.Fatalf("UpsertParameter update failed: %v", err)
+	}
+
+	params, err = dao.GetParametersByRunID(ctx, runID)
+	if err != nil {
+		t.Fatalf("GetParametersByRunID failed after update: %v", err)
+	}
+
+	// Find the learning_rate parameter and check it was updated
+	found := false
+	for _, p := range params {
+		if p.Key == "learning_rate" {
+			if !p.ValueFloat.Valid || p.ValueFloat.Float64 != newFloatValue {
+				t.Errorf("Parameter not updated correctly: expected %f, got %f", newFloatValue, p.ValueFloat.Float64)
+			}
+			found = true
+			break
+		}
+	}
+	if !found {
+		t.Error("learning_rate parameter not found after update")
+	}
+
+	// Test nested runs
+	// Create parent run (level 0)
+	parentUUID := "parent-run-uuid"
+	err = dao.InsertRun(ctx, parentUUID, "Parent Run", defaultExpID, nil)
+	if err != nil {
+		t.Fatalf("InsertRun for parent failed: %v", err)
+	}
+	parentID, _ := dao.GetRunIDByUUID(ctx, parentUUID)
+
+	// Create child run (level 1)
+	childUUID := "child-run-uuid"
+	err = dao.InsertRun(ctx, childUUID, "Child Run", defaultExpID, &parentID)
+	if err != nil {
+		t.Fatalf("InsertRun for child failed: %v", err)
+	}
+	childID, _ := dao.GetRunIDByUUID(ctx, childUUID)
+
+	// Verify child's nesting level
+	childRun, _ := dao.GetRunByUUID(ctx, childUUID)
+	if childRun.NestingLevel != 1 {
+		t.Errorf("Expected child nesting level 1, got %d", childRun.NestingLevel)
+	}
+	if childRun.ParentRunID == nil || *childRun.ParentRunID != parentID {
+		t.Error("Child parent_run_id not set correctly")
+	}
+
+	// Create grandchild run (level 2)
+	grandchildUUID := "grandchild-run-uuid"
+	err = dao.InsertRun(ctx, grandchildUUID, "Grandchild Run", defaultExpID, &childID)
+	if err != nil {
+		t.Fatalf("InsertRun for grandchild failed: %v", err)
+	}
+	grandchildID, _ := dao.GetRunIDByUUID(ctx, grandchildUUID)
+
+	grandchildRun, _ := dao.GetRunByUUID(ctx, grandchildUUID)
+	if grandchildRun.NestingLevel != 2 {
+		t.Errorf("Expected grandchild nesting level 2, got %d", grandchildRun.NestingLevel)
+	}
+
+	// Test GetChildRuns
+	childRuns, err := dao.GetChildRuns(ctx, parentID)
+	if err != nil {
+		t.Fatalf("GetChildRuns failed: %v", err)
+	}
+	if len(childRuns) != 1 || childRuns[0].UUID != childUUID {
+		t.Errorf("GetChildRuns returned unexpected runs: %+v", childRuns)
+	}
+
+	// Test GetChildRunCount
+	childCount, err := dao.GetChildRunCount(ctx, parentID)
+	if err != nil {
+		t.Fatalf("GetChildRunCount failed: %v", err)
+	}
+	if childCount != 1 {
+		t.Errorf("Expected 1 child, got %d", childCount)
+	}
+
+	// Test GetRunsByExperimentIDAndLevel
+	level0Runs, err := dao.GetRunsByExperimentIDAndLevel(ctx, defaultExpID, 0)
+	if err != nil {
+		t.Fatalf("GetRunsByExperimentIDAndLevel failed: %v", err)
+	}
+	foundParent := false
+	for _, r := range level0Runs {
+		if r.UUID == parentUUID {
+			foundParent = true
+			break
+		}
+	}
+	if !foundParent {
+		t.Error("Parent run not found in level 0 runs")
+	}
+
+	// Test GetRunByID
+	parentByID, err := dao.GetRunByID(ctx, parentID)
+	if err != nil {
+		t.Fatalf("GetRunByID failed: %v", err)
+	}
+	if parentByID.UUID != parentUUID || parentByID.Name != "Parent Run" {
+		t.Errorf("GetRunByID returned wrong data: %+v", parentByID)
+	}
+
+	// Test max nesting level (should fail for level 3)
+	greatGrandchildUUID := "great-grandchild-run-uuid"
+	err = dao.InsertRun(ctx, greatGrandchildUUID, "Great Grandchild Run", defaultExpID, &grandchildID)
+	if err == nil {
+		t.Error("Expected error when exceeding max nesting level, but got none")
+	}
+
+	// Test MarkRunDeleted, MarkRunPurgeNoticeSent, GetDeletedRuns, RestoreRun, and PurgeRun
+	doomedUUID := "doomed-run-uuid"
+	if err := dao.InsertRun(ctx, doomedUUID, "Doomed Run", defaultExpID, nil); err != nil {
+		t.Fatalf("InsertRun failed: %v", err)
+	}
+	doomedID, _ := dao.GetRunIDByUUID(ctx, doomedUUID)
+	if err := dao.UpsertParameter(ctx, doomedID, "seed", "int", nil, nil, nil, int64Ptr(7)); err != nil {
+		t.Fatalf("UpsertParameter failed: %v", err)
+	}
+	if err := dao.InsertMetrics(ctx, doomedID, "loss", []float64{1}, []float64{0.5}, 1700000000000); err != nil {
+		t.Fatalf("InsertMetrics failed: %v", err)
+	}
+	if err := dao.SetRunTag(ctx, doomedID, "baseline", ""); err != nil {
+		t.Fatalf("SetRunTag failed: %v", err)
+	}
+	if err := dao.InsertRunDependency(ctx, doomedID, parentID, "artifact", "model.pt"); err != nil {
+		t.Fatalf("InsertRunDependency failed: %v", err)
+	}
+
+	if err := dao.PurgeRun(ctx, doomedID); err == nil {
+		t.Error("Expected PurgeRun to refuse a run that is not deleted")
+	}
+	deletedAt := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	if err := dao.MarkRunDeleted(ctx, doomedID, deletedAt, "alice@example.com"); err != nil {
+		t.Fatalf("MarkRunDeleted failed: %v", err)
+	}
+	if err := dao.MarkRunDeleted(ctx, doomedID, deletedAt.Add(time.Hour), "bob@example.com"); err != nil {
+		t.Fatalf("MarkRunDeleted failed: %v", err)
+	}
+	if _, err := dao.GetRunIDByUUID(ctx, doomedUUID); err == nil {
+		t.Error("Expected a deleted run not to be found by UUID")
+	}
+	if runs, _ := dao.GetRunsByExperimentID(ctx, defaultExpID); slices.ContainsFunc(runs, func(r Run) bool { return r.UUID == doomedUUID }) {
+		t.Error("Expected a deleted run not to be listed")
+	}
+	deletedRuns, err := dao.GetDeletedRuns(ctx)
+	if err != nil {
+		t.Fatalf("GetDeletedRuns failed: %v", err)
+	}
+	if len(deletedRuns) != 1 || deletedRuns[0].ID != doomedID || deletedRuns[0].UUID != doomedUUID || deletedRuns[0].Name != "Doomed Run" || !deletedRuns[0].DeletedAt.Equal(deletedAt) {
+		t.Errorf("GetDeletedRuns returned unexpected runs: %+v", deletedRuns)
+	}
+	if deletedRuns[0].DeletedBy != "alice@example.com" || deletedRuns[0].PurgeNoticeSentAt.Valid {
+		t.Errorf("Expected the first deletion to be kept, without a notice sent, got %+v", deletedRuns[0])
+	}
+	if err := dao.MarkRunPurgeNoticeSent(ctx, doomedID, deletedAt.Add(time.Hour)); err != nil {
+		t.Fatalf("MarkRunPurgeNoticeSent failed: %v", err)
+	}
+	if deletedRuns, _ := dao.GetDeletedRuns(ctx); !deletedRuns[0].PurgeNoticeSentAt.Valid || !deletedRuns[0].PurgeNoticeSentAt.Time.Equal(deletedAt.Add(time.Hour)) {
+		t.Errorf("Expected the purge notice recorded, got %+v", deletedRuns[0])
+	}
+	if deletedRuns[0].ExperimentUUID == "" || deletedRuns[0].ProjectID == 0 || deletedRuns[0].ParentRunID.Valid {
+		t.Errorf("Expected the deleted run's experiment and project, without a parent, got %+v", deletedRuns[0])
+	}
+
+	// Test RestoreRun
+	if err := dao.RestoreRun(ctx, doomedID); err != nil {
+		t.Fatalf("RestoreRun failed: %v", err)
+	}
+	if id, err := dao.GetRunIDByUUID(ctx, doomedUUID); err != nil || id != doomedID {
+		t.Errorf("Expected a restored run to be found by UUID, got %d (err %v)", id, err)
+	}
+	if params, _ := dao.GetParametersByRunID(ctx, doomedID); len(params) != 1 {
+		t.Errorf("Expected a restored run to keep its parameters, got %+v", params)
+	}
+	if err := dao.RestoreRun(ctx, doomedID); err == nil {
+		t.Error("Expected RestoreRun to refuse a run that is not deleted")
+	}
+	if err := dao.MarkRunDeleted(ctx, doomedID, deletedAt, ""); err != nil {
+		t.Fatalf("MarkRunDeleted failed: %v", err)
+	}
+	if deletedRuns, _ := dao.GetDeletedRuns(ctx); len(deletedRuns) != 1 || deletedRuns[0].DeletedBy != "" || deletedRuns[0].PurgeNoticeSentAt.Valid {
+		t.Errorf("Expected the restored run's deletion forgotten, got %+v", deletedRuns)
+	}
+
+	if err := dao.PurgeRun(ctx, doomedID); err != nil {
+		t.Fatalf("PurgeRun failed: %v", err)
+	}
+	if params, _ := dao.GetParametersByRunID(ctx, doomedID); len(params) != 0 {
+		t.Errorf("Expected parameters to be purged, got %+v", params)
+	}
+	if metrics, _ := dao.GetMetricsByRunID(ctx, doomedID); len(metrics) != 0 {
+		t.Errorf("Expected metrics to be purged, got %+v", metrics)
+	}
+	if tags, _ := dao.GetRunTags(ctx, doomedID); len(tags) != 0 {
+		t.Errorf("Expected tags to be purged, got %+v", tags)
+	}
+	if edges, _ := dao.GetRunDependencyEdges(ctx, parentID); len(edges) != 0 {
+		t.Errorf("Expected dependencies to be purged, got %+v", edges)
+	}
+	if deletedRuns, _ := dao.GetDeletedRuns(ctx); len(deletedRuns) != 0 {
+		t.Errorf("Expected no runs left to purge, got %+v", deletedRuns)
+	}
+
+	// Test MergeRunMetrics: the later point wins at steps both runs logged
+	if err := dao.InsertRun(ctx, "crashed-run-uuid", "Crashed Run", defaultExpID, nil); err != nil {
+		t.Fatalf("InsertRun failed: %v", err)
+	}
+	if err := dao.InsertRun(ctx, "restarted-run-uuid", "Restarted Run", defaultExpID, nil); err != nil {
+		t.Fatalf("InsertRun failed: %v", err)
+	}
+	crashedID, _ := dao.GetRunIDByUUID(ctx, "crashed-run-uuid")
+	restartedID, _ := dao.GetRunIDByUUID(ctx, "restarted-run-uuid")
+	if err := dao.InsertMetrics(ctx, crashedID, "loss", []float64{0, 1, 2}, []float64{1, 0.9, 0.8}, 1700000000000); err != nil {
+		t.Fatalf("InsertMetrics failed: %v", err)
+	}
+	if err := dao.InsertMetrics(ctx, restartedID, "loss", []float64{2, 3}, []float64{0.7, 0.6}, 1700000060000); err != nil {
+		t.Fatalf("InsertMetrics failed: %v", err)
+	}
+	moved, err := dao.MergeRunMetrics(ctx, restartedID, crashedID)
+	if err != nil {
+		t.Fatalf("MergeRunMetrics failed: %v", err)
+	}
+	if moved != 2 {
+		t.Errorf("Expected 2 points to be moved, got %d", moved)
+	}
+	merged, _ := dao.GetMetricsByRunID(ctx, crashedID)
+	var mergedY []float64
+	for _, m := range merged {
+		mergedY = append(mergedY, m.YValue)
+	}
+	if !slices.Equal(mergedY, []float64{1, 0.9, 0.7, 0.6}) {
+		t.Errorf("Unexpected merged series %v", mergedY)
+	}
+	if left, _ := dao.GetMetricsByRunID(ctx, restartedID); len(left) != 0 {
+		t.Errorf("Expected no points left in the source run, got %+v", left)
+	}
+
+	// Test InsertAuditLogEntry and GetAuditLog
+	for i, action := range []string{"merge_runs", "other_action"} {
+		entry := AuditLogRow{Action: action, Subject: "crashed-run-uuid", Details: `{"n": 1}`, CreatedAt: time.Date(2025, 4, 1, i, 0, 0, 0, time.UTC)}
+		if err := dao.InsertAuditLogEntry(ctx, entry); err != nil {
+			t.Fatalf("InsertAuditLogEntry failed: %v", err)
+		}
+	}
+	entries, err := dao.GetAuditLog(ctx, 1)
+	if err != nil {
+		t.Fatalf("GetAuditLog failed: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Action != "other_action" || entries[0].Subject != "crashed-run-uuid" || entries[0].Details != `{"n": 1}` {
+		t.Errorf("GetAuditLog returned unexpected entries: %+v", entries)
+	}
+
+	// Test GetParameterKeys, GetMetricKeys and GetTagKeys within one experiment
+	if err := dao.InsertExperiment(ctx, "schema-exp-uuid", "Schema Experiment"); err != nil {
+		t.Fatalf("InsertExperiment failed: %v", err)
+	}
+	schemaExpID, _ := dao.GetExperimentIDByUUID(ctx, "schema-exp-uuid")
+	lr, batch := 0.1, "32"
+	for i, runUUID := range []string{"schema-run-1", "schema-run-2"} {
+		if err := dao.InsertRun(ctx, runUUID, "Schema Run", schemaExpID, nil); err != nil {
+			t.Fatalf("InsertRun failed: %v", err)
+		}
+		runID, _ := dao.GetRunIDByUUID(ctx, runUUID)
+		if err := dao.UpsertParameter(ctx, runID, "lr", "float", nil, nil, &lr, nil); err != nil {
+			t.Fatalf("UpsertParameter failed: %v", err)
+		}
+		if i == 0 {
+			batchSize := int64(32)
+			err = dao.UpsertParameter(ctx, runID, "batch_size", "int", nil, nil, nil, &batchSize)
+		} else {
+			err = dao.UpsertParameter(ctx, runID, "batch_size", "string", &batch, nil, nil, nil)
+		}
+		if err != nil {
+			t.Fatalf("UpsertParameter failed: %v", err)
+		}
+		if err := dao.InsertMetrics(ctx, runID, "loss", []float64{0, 1}, []float64{1, 0.5}, 1700000000000); err != nil {
+			t.Fatalf("InsertMetrics failed: %v", err)
+		}
+		if err := dao.SetRunTag(ctx, runID, "stage", "final"); err != nil {
+			t.Fatalf("SetRunTag failed: %v", err)
+		}
+	}
+	paramKeys, err := dao.GetParameterKeys(ctx, schemaExpID, 0)
+	if err != nil {
+		t.Fatalf("GetParameterKeys failed: %v", err)
+	}
+	wantParamKeys := []SchemaKeyRow{{"batch_size", "int", 1}, {"batch_size", "string", 1}, {"lr", "float", 2}}
+	if !slices.Equal(paramKeys, wantParamKeys) {
+		t.Errorf("GetParameterKeys returned %+v, want %+v", paramKeys, wantParamKeys)
+	}
+	metricKeys, err := dao.GetMetricKeys(ctx, schemaExpID, 0)
+	if err != nil {
+		t.Fatalf("GetMetricKeys failed: %v", err)
+	}
+	if !slices.Equal(metricKeys, []SchemaKeyRow{{Key: "loss", RunCount: 2}}) {
+		t.Errorf("GetMetricKeys returned %+v", metricKeys)
+	}
+	tagKeys, err := dao.GetTagKeys(ctx, schemaExpID, 0)
+	if err != nil {
+		t.Fatalf("GetTagKeys failed: %v", err)
+	}
+	if !slices.Equal(tagKeys, []SchemaKeyRow{{Key: "stage", RunCount: 2}}) {
+		t.Errorf("GetTagKeys returned %+v", tagKeys)
+	}
+	// Every experiment's keys are included without one
+	if allMetricKeys, _ := dao.GetMetricKeys(ctx, 0, 0); !slices.ContainsFunc(allMetricKeys, func(k SchemaKeyRow) bool { return k.Key == "loss" && k.RunCount > 2 }) {
+		t.Errorf("Expected loss across every experiment, got %+v", allMetricKeys)
+	}
+
+	// Test GetMetricsLoggedBetween: the crashed run's own points were logged
+	// a minute before the ones merged into it
+	between, err := dao.GetMetricsLoggedBetween(ctx, crashedID, "loss", time.UnixMilli(1700000030000), time.UnixMilli(1700000060000))
+	if err != nil {
+		t.Fatalf("GetMetricsLoggedBetween failed: %v", err)
+	}
+	if len(between) != 2 || between[0].XValue != 2 || between[1].XValue != 3 {
+		t.Errorf("GetMetricsLoggedBetween returned unexpected points: %+v", between)
+	}
+
+	// Test GetMetricSeries
+	wholeSeries, err := dao.GetMetricSeries(ctx, crashedID, "loss")
+	if err != nil {
+		t.Fatalf("GetMetricSeries failed: %v", err)
+	}
+	if len(wholeSeries) != 4 || wholeSeries[0].XValue != 0 || wholeSeries[3].YValue != 0.6 {
+		t.Errorf("GetMetricSeries returned unexpected points: %+v", wholeSeries)
+	}
+
+	// Test GetMetricsAfter
+	tail, err := dao.GetMetricsAfter(ctx, crashedID, "loss", 1, 1)
+	if err != nil {
+		t.Fatalf("GetMetricsAfter failed: %v", err)
+	}
+	if len(tail) != 1 || tail[0].XValue != 2 {
+		t.Errorf("GetMetricsAfter returned unexpected points: %+v", tail)
+	}
+	if tail, _ := dao.GetMetricsAfter(ctx, crashedID, "loss", 3, 10); len(tail) != 0 {
+		t.Errorf("Expected no points after the last, got %+v", tail)
+	}
+
+	// Test GetBestMetric
+	lowest, err := dao.GetBestMetric(ctx, crashedID, "loss", false)
+	if err != nil {
+		t.Fatalf("GetBestMetric failed: %v", err)
+	}
+	if lowest.XValue != 3 || lowest.YValue != 0.6 {
+		t.Errorf("Expected the lowest loss at step 3, got %+v", lowest)
+	}
+	if highest, err := dao.GetBestMetric(ctx, crashedID, "loss", true); err != nil || highest.XValue != 0 || highest.YValue != 1 {
+		t.Errorf("Expected the highest loss at step 0, got %+v %v", highest, err)
+	}
+	if _, err := dao.GetBestMetric(ctx, crashedID, "missing", false); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("Expected sql.ErrNoRows for a series without points, got %v", err)
+	}
+
+	// Test GetMetricSummariesByRunIDs: summaries are kept up to date on
+	// insert, and recomputed when runs are merged
+	if err := dao.InsertRun(ctx, "summarized-run-uuid", "Summarized Run", defaultExpID, nil); err != nil {
+		t.Fatalf("InsertRun failed: %v", err)
+	}
+	summarizedID, _ := dao.GetRunIDByUUID(ctx, "summarized-run-uuid")
+	if err := dao.InsertMetrics(ctx, summarizedID, "loss", []float64{0, 1, 2, 3}, []float64{0.5, 0.2, 0.2, 0.3}, 1700000000000); err != nil {
+		t.Fatalf("InsertMetrics failed: %v", err)
+	}
+	if err := dao.InsertMetricBatch(ctx, map[int][]MetricRow{summarizedID: {{Key: "loss", XValue: 4, YValue: 0.1, LoggedAt: time.UnixMilli(1700000060000)}}}); err != nil {
+		t.Fatalf("InsertMetricBatch failed: %v", err)
+	}
+	if err := dao.InsertMetrics(ctx, summarizedID, "loss", []float64{3.5}, []float64{0.9}, 1700000120000); err != nil {
+		t.Fatalf("InsertMetrics failed: %v", err)
+	}
+	summaries, err := dao.GetMetricSummariesByRunIDs(ctx, []int{summarizedID, crashedID, restartedID})
+	if err != nil {
+		t.Fatalf("GetMetricSummariesByRunIDs failed: %v", err)
+	}
+	wantSummaries := map[int]MetricSummaryRow{
+		summarizedID: {RunID: summarizedID, Key: "loss", Name: "loss", LastStep: 4, LastValue: 0.1, MinStep: 4, MinValue: 0.1, MaxStep: 3.5, MaxValue: 0.9, PointCount: 6},
+		crashedID:    {RunID: crashedID, Key: "loss", Name: "loss", LastStep: 3, LastValue: 0.6, MinStep: 3, MinValue: 0.6, MaxStep: 0, MaxValue: 1, PointCount: 4},
+	}
+	if len(summaries) != len(wantSummaries) {
+		t.Errorf("Expected summaries of the two runs with metrics, got %+v", summaries)
+	}
+	for _, s := range summaries {
+		if s != wantSummaries[s.RunID] {
+			t.Errorf("Expected summary %+v, got %+v", wantSummaries[s.RunID], s)
+		}
+	}
+	if summaries, err := dao.GetMetricSummariesByRunIDs(ctx, nil); err != nil || len(summaries) != 0 {
+		t.Errorf("Expected no summaries without runs, got %+v %v", summaries, err)
+	}
+
+	// Test metric namespaces: summaries split keys at their first slash, and
+	// pruning a namespace's points keeps its summaries
+	if err := dao.InsertRun(ctx, "namespaced-run-uuid", "Namespaced Run", defaultExpID, nil); err != nil {
+		t.Fatalf("InsertRun failed: %v", err)
+	}
+	namespacedID, _ := dao.GetRunIDByUUID(ctx, "namespaced-run-uuid")
+	for _, key := range []string{"train/loss", "train/layer1/grad", "val/loss", "lr"} {
+		if err := dao.InsertMetrics(ctx, namespacedID, key, []float64{0, 1}, []float64{1, 2}, 1700000000000); err != nil {
+			t.Fatalf("InsertMetrics failed: %v", err)
+		}
+	}
+	namespaces, err := dao.GetRunMetricNamespaces(ctx, namespacedID)
+	if err != nil {
+		t.Fatalf("GetRunMetricNamespaces failed: %v", err)
+	}
+	wantNamespaces := []MetricNamespaceRow{{Namespace: "", Metrics: 1, Points: 2}, {Namespace: "train", Metrics: 2, Points: 4}, {Namespace: "val", Metrics: 1, Points: 2}}
+	if !slices.Equal(namespaces, wantNamespaces) {
+		t.Errorf("Expected namespaces %+v, got %+v", wantNamespaces, namespaces)
+	}
+	summaries, _ = dao.GetMetricSummariesByRunIDs(ctx, []int{namespacedID})
+	if len(summaries) != 4 || summaries[1].Key != "train/layer1/grad" || summaries[1].Namespace != "train" || summaries[1].Name != "layer1/grad" {
+		t.Errorf("Expected summaries with keys split, got %+v", summaries)
+	}
+	if runs, err := dao.GetNamespaceMetricPoints(ctx, defaultExpID, "train", time.Now().Add(time.Hour)); err != nil || len(runs) != 0 {
+		t.Errorf("Expected no points of a running run to prune, got %+v (err %v)", runs, err)
+	}
+	if err := dao.UpdateRunStatus(ctx, namespacedID, runStatusFinished, ""); err != nil {
+		t.Fatalf("UpdateRunStatus failed: %v", err)
+	}
+	pruned, err := dao.GetNamespaceMetricPoints(ctx, defaultExpID, "train", time.Now().Add(time.Hour))
+	if err != nil || len(pruned) != 1 || pruned[0].RunUUID != "namespaced-run-uuid" || pruned[0].Points != 4 {
+		t.Errorf("Expected the finished run's 4 train points, got %+v (err %v)", pruned, err)
+	}
+	if runs, _ := dao.GetNamespaceMetricPoints(ctx, defaultExpID, "train", time.Now().Add(-time.Hour)); len(runs) != 0 {
+		t.Errorf("Expected no points of runs that ended since, got %+v", runs)
+	}
+	if n, err := dao.DeleteNamespaceMetrics(ctx, namespacedID, "train"); err != nil || n != 4 {
+		t.Errorf("Expected 4 points deleted, got %d (err %v)", n, err)
+	}
+	if series, _ := dao.GetMetricSeries(ctx, namespacedID, "train/loss"); len(series) != 0 {
+		t.Errorf("Expected the train points deleted, got %+v", series)
+	}
+	if series, _ := dao.GetMetricSeries(ctx, namespacedID, "val/loss"); len(series) != 2 {
+		t.Errorf("Expected the val points kept, got %+v", series)
+	}
+	if namespaces, _ := dao.GetRunMetricNamespaces(ctx, namespacedID); len(namespaces) != 3 {
+		t.Errorf("Expected the train summaries kept, got %+v", namespaces)
+	}
+
+	// Test metric namespace settings and alerts
+	trainSetting := MetricNamespaceSettingRow{ExperimentID: defaultExpID, Namespace: "train", RetainPointsDays: sql.NullInt64{Int64: 7, Valid: true}}
+	for _, above := range []float64{10, 100} {
+		trainSetting.AlertAbove = sql.NullFloat64{Float64: above, Valid: true}
+		if err := dao.UpsertMetricNamespaceSetting(ctx, trainSetting); err != nil {
+			t.Fatalf("UpsertMetricNamespaceSetting failed: %v", err)
+		}
+	}
+	if err := dao.UpsertMetricNamespaceSetting(ctx, MetricNamespaceSettingRow{ExperimentID: defaultExpID, Namespace: "", AlertBelow: sql.NullFloat64{Float64: 0, Valid: true}}); err != nil {
+		t.Fatalf("UpsertMetricNamespaceSetting failed: %v", err)
+	}
+	settings, err := dao.GetMetricNamespaceSettingsForRun(ctx, namespacedID)
+	if err != nil || len(settings) != 2 || settings[0].Namespace != "" || settings[1] != trainSetting {
+		t.Errorf("Expected the run's experiment's two settings, got %+v (err %v)", settings, err)
+	}
+	if all, _ := dao.GetMetricNamespaceSettings(ctx, 0); len(all) != 2 {
+		t.Errorf("Expected every experiment's settings, got %+v", all)
+	}
+	if deleted, err := dao.DeleteMetricNamespaceSetting(ctx, defaultExpID, ""); err != nil || !deleted {
+		t.Errorf("Expected the setting deleted, got %v (err %v)", deleted, err)
+	}
+	if deleted, _ := dao.DeleteMetricNamespaceSetting(ctx, defaultExpID, ""); deleted {
+		t.Errorf("Expected nothing left to delete")
+	}
+	if settings, _ := dao.GetMetricNamespaceSettings(ctx, defaultExpID); len(settings) != 1 || settings[0] != trainSetting {
+		t.Errorf("Expected the train setting left, got %+v", settings)
+	}
+	for i, want := range []bool{true, false} {
+		if first, err := dao.RecordMetricAlert(ctx, namespacedID, "train/loss", float64(200+i), time.Now()); err != nil || first != want {
+			t.Errorf("Expected RecordMetricAlert to report a first alert %v, got %v (err %v)", want, first, err)
+		}
+	}
+
+	// Test FindMetricSeries
+	seriesFound, err := dao.FindMetricSeries(ctx, "CRASHED", 10, 0)
+	if err != nil {
+		t.Fatalf("FindMetricSeries failed: %v", err)
+	}
+	if len(seriesFound) != 1 || seriesFound[0].RunUUID != "crashed-run-uuid" || seriesFound[0].RunName != "Crashed Run" || seriesFound[0].Key != "loss" {
+		t.Errorf("FindMetricSeries returned unexpected series: %+v", seriesFound)
+	}
+	if seriesFound, _ := dao.FindMetricSeries(ctx, "loss", 1, 0); len(seriesFound) != 1 {
+		t.Errorf("Expected FindMetricSeries to respect its limit, got %+v", seriesFound)
+	}
+
+	// Test SetExperimentQuota, GetExperimentQuota and DeleteExperimentQuota
+	if err := dao.InsertExperiment(ctx, "quota-exp-uuid", "Quota Experiment"); err != nil {
+		t.Fatalf("InsertExperiment failed: %v", err)
+	}
+	quotaExpID, _ := dao.GetExperimentIDByUUID(ctx, "quota-exp-uuid")
+	if quota, err := dao.GetExperimentQuota(ctx, quotaExpID); err != nil || quota != nil {
+		t.Errorf("Expected no quota before one is set, got %+v (err %v)", quota, err)
+	}
+	for _, maxRuns := range []int64{5, 10} {
+		err = dao.SetExperimentQuota(ctx, quotaExpID, ExperimentQuotaRow{
+			MaxRuns:          sql.NullInt64{Int64: maxRuns, Valid: true},
+			MaxArtifactBytes: sql.NullInt64{Int64: 0, Valid: true},
+		})
+		if err != nil {
+			t.Fatalf("SetExperimentQuota failed: %v", err)
+		}
+	}
+	quota, err := dao.GetExperimentQuota(ctx, quotaExpID)
+	if err != nil {
+		t.Fatalf("GetExperimentQuota failed: %v", err)
+	}
+	if quota == nil || quota.MaxRuns.Int64 != 10 || quota.MaxMetricPointsPerDay.Valid || !quota.MaxArtifactBytes.Valid {
+		t.Errorf("GetExperimentQuota returned %+v", quota)
+	}
+	if deleted, err := dao.DeleteExperimentQuota(ctx, quotaExpID); err != nil || !deleted {
+		t.Errorf("DeleteExperimentQuota failed: %v", err)
+	}
+	if deleted, _ := dao.DeleteExperimentQuota(ctx, quotaExpID); deleted {
+		t.Errorf("Expected nothing left to delete")
+	}
+
+	// Test GetRunExperimentID, AddExperimentMetricPoints and GetExperimentUsage
+	if err := dao.InsertRun(ctx, "quota-run-uuid", "Quota Run", quotaExpID, nil); err != nil {
+		t.Fatalf("InsertRun failed: %v", err)
+	}
+	quotaRunID, _ := dao.GetRunIDByUUID(ctx, "quota-run-uuid")
+	if id, err := dao.GetRunExperimentID(ctx, quotaRunID); err != nil || id != quotaExpID {
+		t.Errorf("GetRunExperimentID returned %d (err %v), expected %d", id, err, quotaExpID)
+	}
+	if err := dao.UpsertArtifact(ctx, quotaRunID, "model.pt", "file://artifacts/model.pt", "file", "application/octet-stream", 1000, ""); err != nil {
+		t.Fatalf("UpsertArtifact failed: %v", err)
+	}
+	for _, points := range []int{3, 4} {
+		if err := dao.AddExperimentMetricPoints(ctx, quotaExpID, "2026-01-02", points); err != nil {
+			t.Fatalf("AddExperimentMetricPoints failed: %v", err)
+		}
+	}
+	usage, err := dao.GetExperimentUsage(ctx, quotaExpID, "2026-01-02")
+	if err != nil {
+		t.Fatalf("GetExperimentUsage failed: %v", err)
+	}
+	if *usage != (ExperimentUsageRow{Runs: 1, MetricPoints: 7, ArtifactBytes: 1000}) {
+		t.Errorf("GetExperimentUsage returned %+v", usage)
+	}
+	if usage, _ := dao.GetExperimentUsage(ctx, quotaExpID, "2026-01-03"); usage.MetricPoints != 0 {
+		t.Errorf("Expected no metric points logged the next day, got %+v", usage)
+	}
+
+	// Test BackdateRun
+	if err := dao.BackdateRun(ctx, quotaRunID, time.Date(2019, 3, 1, 12, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatalf("BackdateRun failed: %v", err)
+	}
+	if runs, _ := dao.GetRunsByExperimentID(ctx, quotaExpID); len(runs) != 1 || !strings.HasPrefix(runs[0].CreatedAt, "2019-03-01") {
+		t.Errorf("Expected the run backdated, got %+v", runs)
+	}
+
+	// Test ArchiveRun, and retention's queries of runs to archive and delete
+	archiveCutoff := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	if toArchive, _ := dao.GetRunsToArchive(ctx, archiveCutoff); len(toArchive) != 0 {
+		t.Errorf("Expected running runs not to be archived, got %+v", toArchive)
+	}
+	if err := dao.UpdateRunStatus(ctx, quotaRunID, "FINISHED", ""); err != nil {
+		t.Fatalf("UpdateRunStatus failed: %v", err)
+	}
+	// Runs on hold are exempt from retention
+	if err := dao.SetRunHold(ctx, quotaRunID, "audit"); err != nil {
+		t.Fatalf("SetRunHold failed: %v", err)
+	}
+	if toArchive, _ := dao.GetRunsToArchive(ctx, archiveCutoff); len(toArchive) != 0 {
+		t.Errorf("Expected runs on hold not to be archived, got %+v", toArchive)
+	}
+	if err := dao.ClearRunHold(ctx, quotaRunID); err != nil {
+		t.Fatalf("ClearRunHold failed: %v", err)
+	}
+	toArchive, err := dao.GetRunsToArchive(ctx, archiveCutoff)
+	if err != nil {
+		t.Fatalf("GetRunsToArchive failed: %v", err)
+	}
+	if len(toArchive) != 1 || toArchive[0].ID != quotaRunID {
+		t.Errorf("Expected the backdated run to be archived, got %+v", toArchive)
+	}
+	archivedAt := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	if err := dao.ArchiveRun(ctx, quotaRunID, archivedAt); err != nil {
+		t.Fatalf("ArchiveRun failed: %v", err)
+	}
+	// Archiving again keeps the original time
+	if err := dao.ArchiveRun(ctx, quotaRunID, archivedAt.AddDate(1, 0, 0)); err != nil {
+		t.Fatalf("ArchiveRun failed: %v", err)
+	}
+	if toArchive, _ := dao.GetRunsToArchive(ctx, archiveCutoff); len(toArchive) != 0 {
+		t.Errorf("Expected archived runs not to be archived again, got %+v", toArchive)
+	}
+	toDelete, err := dao.GetRunsArchivedBefore(ctx, archivedAt.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("GetRunsArchivedBefore failed: %v", err)
+	}
+	if len(toDelete) != 1 || toDelete[0].ID != quotaRunID {
+		t.Errorf("Expected the archived run, got %+v", toDelete)
+	}
+	experimentRuns := func(filter RunFilter) []RunSummary {
+		runs, err := dao.GetRuns(ctx, 0, 100, "created", "desc", filter)
+		if err != nil {
+			t.Fatalf("GetRuns failed: %v", err)
+		}
+		var matched []RunSummary
+		for _, run := range runs {
+			if run.ExperimentUUID == "quota-exp-uuid" {
+				matched = append(matched, run)
+			}
+		}
+		return matched
+	}
+	if runs := experimentRuns(RunFilter{HideArchived: true}); len(runs) != 0 {
+		t.Errorf("Expected archived runs hidden, got %+v", runs)
+	}
+	if runs := experimentRuns(RunFilter{}); len(runs) != 1 || !runs[0].Archived {
+		t.Errorf("Expected the run listed as archived, got %+v", runs)
+	}
+	if err := dao.UnarchiveRun(ctx, quotaRunID); err != nil {
+		t.Fatalf("UnarchiveRun failed: %v", err)
+	}
+	if runs := experimentRuns(RunFilter{HideArchived: true}); len(runs) != 1 || runs[0].Archived {
+		t.Errorf("Expected the run restored, got %+v", runs)
+	}
+
+	// Test UpsertParameters, which logs all of a batch or none of it
+	if err := dao.UpsertParameters(ctx, quotaRunID, []ParameterRow{stringParam("optimizer", "adam"), floatParam("lr", 0.01)}); err != nil {
+		t.Fatalf("UpsertParameters failed: %v", err)
+	}
+	if err := dao.UpsertParameters(ctx, quotaRunID, []ParameterRow{floatParam("lr", 0.1), {Key: "shape", ValueType: "tuple"}}); err == nil {
+		t.Error("Expected UpsertParameters to reject an unknown type")
+	}
+	batchParams, err := dao.GetParametersByRunID(ctx, quotaRunID)
+	if err != nil {
+		t.Fatalf("GetParametersByRunID failed: %v", err)
+	}
+	logged := make(map[string]string)
+	for _, p := range batchParams {
+		logged[p.Key] = formatParameterValue(p)
+	}
+	if len(logged) != 2 || logged["optimizer"] != "adam" || logged["lr"] != "0.01" {
+		t.Errorf("Expected only the first batch logged, got %v", logged)
+	}
+
+	// Test artifact versions: uploads under new URIs keep the prior version,
+	// and uploads over the current contents replace them
+	if err := dao.UpsertArtifact(ctx, quotaRunID, "model.pt", "file://artifacts/~versions/2/model.pt", "file", "application/octet-stream", 2000, "bbb"); err != nil {
+		t.Fatalf("UpsertArtifact failed: %v", err)
+	}
+	if err := dao.UpsertArtifact(ctx, quotaRunID, "model.pt", "file://artifacts/~versions/2/model.pt", "file", "application/octet-stream", 3000, "ccc"); err != nil {
+		t.Fatalf("UpsertArtifact failed: %v", err)
+	}
+	if current, err := dao.GetArtifactByRunIDAndPath(ctx, quotaRunID, "model.pt"); err != nil || current.Version != 2 || current.SizeBytes != 3000 || current.MirrorStatus != "pending" {
+		t.Errorf("Expected the current artifact at version 2, got %+v %v", current, err)
+	}
+	versions, err := dao.GetArtifactVersions(ctx, quotaRunID, "model.pt")
+	if err != nil {
+		t.Fatalf("GetArtifactVersions failed: %v", err)
+	}
+	if len(versions) != 1 || versions[0].Version != 1 || versions[0].URI != "file://artifacts/model.pt" || versions[0].SizeBytes != 1000 || !versions[0].UpdatedAt.Valid {
+		t.Errorf("Expected the first upload kept as version 1, got %+v", versions)
+	}
+	if versions, _ := dao.GetArtifactVersions(ctx, quotaRunID, "other.pt"); len(versions) != 0 {
+		t.Errorf("Expected no versions of another path, got %+v", versions)
+	}
+	if uris, _ := dao.GetAllArtifactURIs(ctx); !slices.Contains(uris, "file://artifacts/model.pt") {
+		t.Errorf("Expected prior versions to stay referenced, got %v", uris)
+	}
+	if usage, _ := dao.GetExperimentUsage(ctx, quotaExpID, "2026-01-02"); usage.ArtifactBytes != 4000 {
+		t.Errorf("Expected prior versions counted against the quota, got %+v", usage)
+	}
+
+	// Test GetArtifactsToScan, RecordArtifactScan, SetArtifactScanStatus and
+	// GetArtifactScanStatusByURI, across current artifacts and prior versions
+	toScan, err := dao.GetArtifactsToScan(ctx, 100)
+	if err != nil {
+		t.Fatalf("GetArtifactsToScan failed: %v", err)
+	}
+	pending := make(map[string]bool)
+	for _, a := range toScan {
+		pending[a.URI] = true
+	}
+	if !pending["file://artifacts/model.pt"] || !pending["file://artifacts/~versions/2/model.pt"] {
+		t.Errorf("Expected the artifact and its prior version awaiting a scan, got %+v", toScan)
+	}
+	if err := dao.RecordArtifactScan(ctx, "file://artifacts/model.pt", artifactScanQuarantined, "Eicar-Test-Signature"); err != nil {
+		t.Fatalf("RecordArtifactScan failed: %v", err)
+	}
+	if err := dao.RecordArtifactScan(ctx, "file://artifacts/model.pt", artifactScanClean, ""); err != nil {
+		t.Fatalf("RecordArtifactScan failed: %v", err)
+	}
+	if status, result, err := dao.GetArtifactScanStatusByURI(ctx, "file://artifacts/model.pt"); err != nil || status != artifactScanQuarantined || result != "Eicar-Test-Signature" {
+		t.Errorf("Expected the prior version to stay quarantined, got %q %q %v", status, result, err)
+	}
+	if versions, _ := dao.GetArtifactVersions(ctx, quotaRunID, "model.pt"); len(versions) != 1 || versions[0].ScanStatus != artifactScanQuarantined {
+		t.Errorf("Expected the prior version's scan status, got %+v", versions)
+	}
+	if err := dao.SetArtifactScanStatus(ctx, "file://artifacts/model.pt", artifactScanClean, "false positive"); err != nil {
+		t.Fatalf("SetArtifactScanStatus failed: %v", err)
+	}
+	if status, result, _ := dao.GetArtifactScanStatusByURI(ctx, "file://artifacts/model.pt"); status != artifactScanClean || result != "false positive" {
+		t.Errorf("Expected the prior version released, got %q %q", status, result)
+	}
+	if err := dao.RecordArtifactScan(ctx, "file://artifacts/~versions/2/model.pt", artifactScanClean, ""); err != nil {
+		t.Fatalf("RecordArtifactScan failed: %v", err)
+	}
+	if current, _ := dao.GetArtifactByRunIDAndPath(ctx, quotaRunID, "model.pt"); current.ScanStatus != artifactScanClean {
+		t.Errorf("Expected the current artifact clean, got %+v", current)
+	}
+	if err := dao.UpsertArtifact(ctx, quotaRunID, "model.pt", "file://artifacts/~versions/2/model.pt", "file", "application/octet-stream", 3000, "ddd"); err != nil {
+		t.Fatalf("UpsertArtifact failed: %v", err)
+	}
+	if current, _ := dao.GetArtifactByRunIDAndPath(ctx, quotaRunID, "model.pt"); current.ScanStatus != artifactScanPending {
+		t.Errorf("Expected overwritten contents to await a scan, got %+v", current)
+	}
+	if status, _, err := dao.GetArtifactScanStatusByURI(ctx, "file://artifacts/missing.pt"); err != nil || status != "" {
+		t.Errorf("Expected no status for a missing artifact, got %q %v", status, err)
+	}
+
+	// Test RelocateArtifactURI, across current artifacts and prior versions
+	if n, err := dao.RelocateArtifactURI(ctx, "file://artifacts/model.pt", "file://moved/model.pt"); err != nil || n != 1 {
+		t.Fatalf("Expected the prior version relocated, got %d (err %v)", n, err)
+	}
+	if versions, _ := dao.GetArtifactVersions(ctx, quotaRunID, "model.pt"); len(versions) != 1 || versions[0].URI != "file://moved/model.pt" || versions[0].ScanStatus != artifactScanClean {
+		t.Errorf("Expected the prior version under its new URI, got %+v", versions)
+	}
+	if n, err := dao.RelocateArtifactURI(ctx, "file://artifacts/~versions/2/model.pt", "file://moved/~versions/2/model.pt"); err != nil || n != 1 {
+		t.Fatalf("Expected the current artifact relocated, got %d (err %v)", n, err)
+	}
+	if current, _ := dao.GetArtifactByRunIDAndPath(ctx, quotaRunID, "model.pt"); current.URI != "file://moved/~versions/2/model.pt" {
+		t.Errorf("Expected the current artifact under its new URI, got %+v", current)
+	}
+	if n, err := dao.RelocateArtifactURI(ctx, "file://artifacts/missing.pt", "file://moved/missing.pt"); err != nil || n != 0 {
+		t.Errorf("Expected nothing relocated from an unknown URI, got %d (err %v)", n, err)
+	}
+
+	// Test InsertSystemMetrics and GetLatestSystemMetricsByRunID, and that
+	// system metrics are kept out of queries of the run's own metrics
+	sampledAt := time.UnixMilli(1700000000000)
+	if err := dao.InsertSystemMetrics(ctx, quotaRunID, []MetricRow{
+		{Key: "system/gpu_utilization", XValue: 1700000000, YValue: 80, LoggedAt: sampledAt},
+		{Key: "system/gpu_utilization", XValue: 1700000005, YValue: 90, LoggedAt: sampledAt.Add(5 * time.Second)},
+		{Key: "system/cpu_percent", XValue: 1700000000, YValue: 40, LoggedAt: sampledAt},
+	}); err != nil {
+		t.Fatalf("InsertSystemMetrics failed: %v", err)
+	}
+	if err := dao.InsertMetrics(ctx, quotaRunID, "sysloss", []float64{1}, []float64{0.5}, sampledAt.UnixMilli()); err != nil {
+		t.Fatalf("InsertMetrics failed: %v", err)
+	}
+	system, err := dao.GetLatestSystemMetricsByRunID(ctx, quotaRunID)
+	if err != nil {
+		t.Fatalf("GetLatestSystemMetricsByRunID failed: %v", err)
+	}
+	latestSystem := make(map[string]float64)
+	for _, m := range system {
+		latestSystem[m.Key] = m.YValue
+	}
+	if len(system) != 2 || latestSystem["system/gpu_utilization"] != 90 || latestSystem["system/cpu_percent"] != 40 {
+		t.Errorf("Expected the latest of each system metric, got %+v", system)
+	}
+	if latest, _ := dao.GetLatestMetricsByRunID(ctx, quotaRunID); len(latest) != 1 || latest[0].Key != "sysloss" {
+		t.Errorf("Expected only the run's own metric, got %+v", latest)
+	}
+	if keys, _ := dao.GetMetricKeys(ctx, quotaExpID, 0); slices.ContainsFunc(keys, func(k SchemaKeyRow) bool { return isSystemMetricKey(k.Key) }) {
+		t.Errorf("Expected no system metric keys in the schema, got %+v", keys)
+	}
+	if series, _ := dao.FindMetricSeries(ctx, "gpu", 10, 0); len(series) != 0 {
+		t.Errorf("Expected system metrics left out of search, got %+v", series)
+	}
+	if series, _ := dao.FindMetricSeries(ctx, "system/gpu", 10, 0); len(series) != 1 || series[0].Key != "system/gpu_utilization" {
+		t.Errorf("Expected system metrics found when searched for by namespace, got %+v", series)
+	}
+	if points, _ := dao.GetMetricSeries(ctx, quotaRunID, "system/gpu_utilization"); len(points) != 2 || !points[1].LoggedAt.Equal(sampledAt.Add(5*time.Second)) {
+		t.Errorf("Expected the system metric's series, got %+v", points)
+	}
+
+	// Test InsertMetricBatch, which inserts all of a batch or none of it
+	if err := dao.InsertMetricBatch(ctx, map[int][]MetricRow{crashedID: {
+		{Key: "streamed", XValue: 0, YValue: 1, LoggedAt: sampledAt},
+		{Key: "streamed", XValue: 1, YValue: 0.5, LoggedAt: sampledAt.Add(time.Second)},
+		{Key: "streamed/lr", XValue: 0, YValue: 0.01, LoggedAt: sampledAt},
+	}}); err != nil {
+		t.Fatalf("InsertMetricBatch failed: %v", err)
+	}
+	if points, _ := dao.GetMetricSeries(ctx, crashedID, "streamed"); len(points) != 2 || points[1].YValue != 0.5 || !points[1].LoggedAt.Equal(sampledAt.Add(time.Second)) {
+		t.Errorf("Expected the batch's points, got %+v", points)
+	}
+	if err := dao.InsertMetricBatch(ctx, map[int][]MetricRow{crashedID: {
+		{Key: "streamed/lr", XValue: 1, YValue: 0.02, LoggedAt: sampledAt},
+		{Key: "streamed", XValue: 1, YValue: 0.4, LoggedAt: sampledAt},
+	}}); err == nil {
+		t.Error("Expected InsertMetricBatch to reject a point at a logged step")
+	}
+	if points, _ := dao.GetMetricSeries(ctx, crashedID, "streamed/lr"); len(points) != 1 {
+		t.Errorf("Expected none of a rejected batch inserted, got %+v", points)
+	}
+
+	// Test InsertProject, the project lookups, SetExperimentProject and
+	// GetRunProjectID, and that runs, search, schema keys and tokens are
+	// scoped to projects
+	projects, err := dao.GetAllProjects(ctx)
+	if err != nil {
+		t.Fatalf("GetAllProjects failed: %v", err)
+	}
+	if len(projects) != 1 || projects[0].ID != 1 || projects[0].UUID != defaultProjectUUID || projects[0].ArtifactPrefix != "" {
+		t.Errorf("Expected only the Default project, got %+v", projects)
+	}
+	if err := dao.InsertProject(ctx, "vision-uuid", "vision", "projects/vision-uuid"); err != nil {
+		t.Fatalf("InsertProject failed: %v", err)
+	}
+	if err := dao.InsertProject(ctx, "other-uuid", "vision", ""); err == nil {
+		t.Error("Expected InsertProject to reject a reused name")
+	}
+	vision, err := dao.GetProjectByName(ctx, "vision")
+	if err != nil || vision.UUID != "vision-uuid" || vision.ArtifactPrefix != "projects/vision-uuid" {
+		t.Fatalf("GetProjectByName returned %+v (err %v)", vision, err)
+	}
+	if p, err := dao.GetProjectByUUID(ctx, "vision-uuid"); err != nil || p.ID != vision.ID {
+		t.Errorf("GetProjectByUUID returned %+v (err %v)", p, err)
+	}
+	if p, err := dao.GetProjectByID(ctx, vision.ID); err != nil || p.Name != "vision" {
+		t.Errorf("GetProjectByID returned %+v (err %v)", p, err)
+	}
+	if _, err := dao.GetProjectByName(ctx, "missing"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("Expected sql.ErrNoRows for a missing project, got %v", err)
+	}
+	if id, err := dao.GetRunProjectID(ctx, quotaRunID); err != nil || id != 1 {
+		t.Errorf("Expected runs in the Default project, got %d (err %v)", id, err)
+	}
+	if err := dao.SetExperimentProject(ctx, quotaExpID, vision.ID); err != nil {
+		t.Fatalf("SetExperimentProject failed: %v", err)
+	}
+	if id, err := dao.GetRunProjectID(ctx, quotaRunID); err != nil || id != vision.ID {
+		t.Errorf("Expected the run moved with its experiment, got %d (err %v)", id, err)
+	}
+	if e, err := dao.GetExperimentByID(ctx, quotaExpID); err != nil || e.ProjectID != vision.ID {
+		t.Errorf("GetExperimentByID returned %+v (err %v)", e, err)
+	}
+	visionRuns, err := dao.GetRuns(ctx, 0, 100, "created", "desc", RunFilter{ProjectID: vision.ID})
+	if err != nil || len(visionRuns) != 1 || visionRuns[0].UUID != "quota-run-uuid" {
+		t.Errorf("Expected only the project's run, got %+v (err %v)", visionRuns, err)
+	}
+	if found, _ := dao.SearchRuns(ctx, []string{"quota"}, 10, vision.ID); len(found) != 1 || found[0].UUID != "quota-run-uuid" {
+		t.Errorf("Expected search to find the project's run, got %+v", found)
+	}
+	if found, _ := dao.SearchRuns(ctx, []string{"quota"}, 10, 1); len(found) != 0 {
+		t.Errorf("Expected search to leave out other projects' runs, got %+v", found)
+	}
+	if keys, _ := dao.GetMetricKeys(ctx, 0, 1); slices.ContainsFunc(keys, func(k SchemaKeyRow) bool { return k.Key == "sysloss" }) {
+		t.Errorf("Expected other projects' metric keys left out, got %+v", keys)
+	}
+	if keys, _ := dao.GetMetricKeys(ctx, 0, vision.ID); len(keys) != 1 || keys[0].Key != "sysloss" {
+		t.Errorf("Expected the project's metric keys, got %+v", keys)
+	}
+	if series, _ := dao.FindMetricSeries(ctx, "sysloss", 10, 1); len(series) != 0 {
+		t.Errorf("Expected other projects' series left out, got %+v", series)
+	}
+	if err := dao.InsertAPIToken(ctx, "vision-ci", "hash-vision", sql.NullInt64{Int64: int64(vision.ID), Valid: true}, sql.NullInt64{}); err != nil {
+		t.Fatalf("InsertAPIToken failed: %v", err)
+	}
+	if token, err := dao.GetAPITokenByHash(ctx, "hash-vision"); err != nil || token.ProjectID.Int64 != int64(vision.ID) {
+		t.Errorf("Expected the token scoped to its project, got %+v (err %v)", token, err)
+	}
+	if token, _ := dao.GetAPITokenByHash(ctx, "hash-ci"); token.ProjectID.Valid {
+		t.Errorf("Expected an unscoped token, got %+v", token)
+	}
+
+	// Test InsertArtifactUpload, the upload lookups, TouchArtifactUpload and
+	// DeleteArtifactUpload
+	uploadStarted := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	upload := ArtifactUploadRow{UUID: "upload-uuid", RunID: quotaRunID, Path: "model.pt", SizeBytes: 5 << 30, SHA256: "abcd", ChunkSize: 16 << 20, CreatedAt: uploadStarted, UpdatedAt: uploadStarted}
+	if err := dao.InsertArtifactUpload(ctx, upload); err != nil {
+		t.Fatalf("InsertArtifactUpload failed: %v", err)
+	}
+	if got, err := dao.GetArtifactUpload(ctx, "upload-uuid"); err != nil || got.SizeBytes != 5<<30 || got.ChunkSize != 16<<20 || !got.CreatedAt.Equal(uploadStarted) {
+		t.Errorf("GetArtifactUpload returned %+v (err %v)", got, err)
+	}
+	if _, err := dao.GetArtifactUpload(ctx, "missing"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("Expected sql.ErrNoRows for a missing upload, got %v", err)
+	}
+	if got, err := dao.FindArtifactUpload(ctx, quotaRunID, "model.pt", "abcd", 5<<30); err != nil || got == nil || got.UUID != "upload-uuid" {
+		t.Errorf("Expected the upload of the same file found, got %+v (err %v)", got, err)
+	}
+	if got, err := dao.FindArtifactUpload(ctx, quotaRunID, "model.pt", "ef01", 5<<30); err != nil || got != nil {
+		t.Errorf("Expected no upload of a different file, got %+v (err %v)", got, err)
+	}
+	if err := dao.TouchArtifactUpload(ctx, "upload-uuid", uploadStarted.Add(time.Hour)); err != nil {
+		t.Fatalf("TouchArtifactUpload failed: %v", err)
+	}
+	if uploads, err := dao.GetArtifactUploads(ctx); err != nil || len(uploads) != 1 || !uploads[0].UpdatedAt.Equal(uploadStarted.Add(time.Hour)) {
+		t.Errorf("Expected the upload touched, got %+v (err %v)", uploads, err)
+	}
+	if err := dao.DeleteArtifactUpload(ctx, "upload-uuid"); err != nil {
+		t.Fatalf("DeleteArtifactUpload failed: %v", err)
+	}
+	if uploads, _ := dao.GetArtifactUploads(ctx); len(uploads) != 0 {
+		t.Errorf("Expected the upload deleted, got %+v", uploads)
+	}
+}
+
+func TestSQLiteDAO(t *testing.T) {
+	// Create a temporary database file with absolute path
+	dbFile := "test_sqlite.db"
+	defer os.Remove(dbFile)
+	defer os.Remove(dbFile + "-wal")
+	defer os.Remove(dbFile + "-shm")
+
+	// Get absolute path
+	absPath, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Failed to get working directory: %v", err)
+	}
+	absDBPath := absPath + "/" + dbFile
+
+	// Create the database file first by opening it
+	db, err := sql.Open("sqlite3", dbFile)
+	if err != nil {
+		t.Fatalf("Failed to open SQLite database: %v", err)
+	}
+	// Ping to ensure the file is created
+	if err := db.Ping(); err != nil {
+		t.Fatalf("Failed to ping database: %v", err)
+	}
+	db.Close()
+
+	connString := "sqlite:///" + absDBPath
+
+	// Run migrations
+	m, err := migrate.New("file://migrations/sqlite3", connString)
+	if err != nil {
+		t.Fatalf("Failed to create migrator: %v", err)
+	}
+	if err := m.Up(); err != nil && err != migrate.ErrNoChange {
+		t.Fatalf("Failed to run migrations: %v", err)
+	}
+
+	// Reopen database connections, as initDB does
+	db, err = sql.Open("sqlite3", sqliteDataSource(dbFile))
+	if err != nil {
+		t.Fatalf("Failed to reopen SQLite database: %v", err)
+	}
+	defer db.Close()
+	writer, err := sql.Open("sqlite3", sqliteDataSource(dbFile))
+	if err != nil {
+		t.Fatalf("Failed to reopen SQLite database for writing: %v", err)
+	}
+	defer writer.Close()
+	writer.SetMaxOpenConns(1)
+
+	dao := NewSQLiteDAO(db, writer)
+	testDAOImplementation(t, dao)
+}
+
+func TestSQLiteDAOConcurrentWrites(t *testing.T) {
+	ctx := t.Context()
+	defer func(d DAO, conn *sql.DB) { dao, db = d, conn }(dao, db)
+	initDB("sqlite:///" + filepath.Join(t.TempDir(), "concurrent.db"))
+	defer db.Close()
+	defer dao.(*SQLiteDAO).db.writer.Close()
+
+	var mode string
+	if err := db.QueryRow("PRAGMA journal_mode").Scan(&mode); err != nil || mode != "wal" {
+		t.Errorf("Expected WAL mode, got %q (%v)", mode, err)
+	}
+
+	expID, err := dao.GetDefaultExperimentID(ctx)
+	if err != nil {
+		t.Fatalf("GetDefaultExperimentID failed: %v", err)
+	}
+	if err := dao.InsertRun(ctx, "concurrent-run", "concurrent", expID, nil); err != nil {
+		t.Fatalf("InsertRun failed: %v", err)
+	}
+	runID, err := dao.GetRunIDByUUID(ctx, "concurrent-run")
+	if err != nil {
+		t.Fatalf("GetRunIDByUUID failed: %v", err)
+	}
+
+	// Writers and readers at once, as when several runs log metrics while
+	// their pages are open
+	const writers, batches = 8, 25
+	var wg sync.WaitGroup
+	errs := make(chan error, 2*writers*batches)
+	for w := 0; w < writers; w++ {
+		wg.Add(2)
+		go func(w int) {
+			defer wg.Done()
+			key := fmt.Sprintf("loss_%d", w)
+			for i := 0; i < batches; i++ {
+				if err := dao.InsertMetrics(ctx, runID, key, []float64{float64(i)}, []float64{1 / float64(i+1)}, time.Now().UnixMilli()); err != nil {
+					errs <- err
+				}
+			}
+		}(w)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < batches; i++ {
+				if _, err := dao.GetLatestMetricsByRunID(ctx, runID); err != nil {
+					errs <- err
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("Concurrent access failed: %v", err)
+	}
+	metrics, err := dao.GetMetricsByRunID(ctx, runID)
+	if err != nil || len(metrics) != writers*batches {
+		t.Errorf("Expected %d metric points, got %d (%v)", writers*batches, len(metrics), err)
+	}
 }
 
 func TestPostgresDAO(t *testing.T) {
@@ -2,9 +2,11 @@ package main
 
 import (
 	"database/sql"
+	"fmt"
 	"log"
-	
+	"net/url"
 	"strings"
+	"time"
 
 	_ "github.com/lib/pq"
 	_ "github.com/mattn/go-sqlite3"
@@ -24,7 +26,7 @@ func initDB(connString string) {
 	// Parse connection string
 	if strings.HasPrefix(connString, "sqlite:///") {
 		driverName = "sqlite3"
-		dataSource = strings.TrimPrefix(connString, "sqlite:///")
+		dataSource = sqliteDataSource(strings.TrimPrefix(connString, "sqlite:///"))
 	} else if strings.HasPrefix(connString, "postgres://") || strings.HasPrefix(connString, "postgresql://") {
 		driverName = "postgres"
 		dataSource = connString
@@ -33,7 +35,7 @@ func initDB(connString string) {
 	}
 
 	// Open database connection
-	db, err = sql.Open(driverName, dataSource)
+	db, err = openInstrumentedDB(driverName, dataSource)
 	if err != nil {
 		log.Fatalf("Failed to open database: %v", err)
 	}
@@ -53,7 +55,14 @@ func initDB(connString string) {
 
 	// Create appropriate DAO
 	if driverName == "sqlite3" {
-		dao = NewSQLiteDAO(db)
+		writer, err := openInstrumentedDB(driverName, dataSource)
+		if err != nil {
+			log.Fatalf("Failed to open database for writing: %v", err)
+		}
+		// Writes queue for the one connection rather than contending for
+		// SQLite's lock
+		writer.SetMaxOpenConns(1)
+		dao = NewSQLiteDAO(db, writer)
 	} else if driverName == "postgres" {
 		dao = NewPostgresDAO(db)
 	} else {
@@ -62,3 +71,25 @@ func initDB(connString string) {
 
 	log.Printf("Database initialized with driver: %s", driverName)
 }
+
+// sqliteBusyTimeout is how long SQLite connections wait for a lock held by
+// another connection, such as the token command's, before failing with
+// "database is locked"
+const sqliteBusyTimeout = 5 * time.Second
+
+// sqliteDataSource adds the connection settings apparatus needs to a SQLite
+// database path: WAL mode, so reads go on while a write is in progress, a busy
+// timeout, and transactions that take the write lock when they begin, since a
+// transaction that upgrades its lock later fails rather than waiting
+func sqliteDataSource(path string) string {
+	params := url.Values{
+		"_journal_mode": {"WAL"},
+		"_busy_timeout": {fmt.Sprint(sqliteBusyTimeout.Milliseconds())},
+		"_txlock":       {"immediate"},
+	}
+	separator := "?"
+	if strings.Contains(path, "?") {
+		separator = "&"
+	}
+	return path + separator + params.Encode()
+}
@@ -0,0 +1,628 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// RunDependencyKind is a kind of dependency edge between runs, with the label
+// describing how the run used its upstream run
+type RunDependencyKind struct {
+	Kind  string
+	Label string
+}
+
+// runDependencyKinds are the kinds of dependency edges between runs
+var runDependencyKinds = []RunDependencyKind{
+	{"artifact", "consumed an artifact of"},
+	{"checkpoint", "initialized from a checkpoint of"},
+	{"dataset", "consumed a dataset produced by"},
+	{"evaluation", "evaluated a model trained by"},
+}
+
+// runDependencyGraphLimit caps the number of runs collected into a dependency
+// graph, so a run in a large lineage still renders
+const runDependencyGraphLimit = 50
+
+// Layout of the dependency graph, in pixels
+const (
+	dependencyGraphNodeWidth  = 180
+	dependencyGraphNodeHeight = 36
+	dependencyGraphColumnGap  = 100
+	dependencyGraphRowGap     = 24
+	dependencyGraphPadding    = 16
+)
+
+// runDependencyError is a problem with a dependency request that the client
+// can fix, as opposed to a server-side failure
+type runDependencyError struct {
+	status  int
+	message string
+}
+
+func (e *runDependencyError) Error() string {
+	return e.message
+}
+
+// RunDependency is an edge recording that a run consumed something produced
+// by an upstream run
+type RunDependency struct {
+	ID           int
+	RunUUID      string
+	RunName      string
+	UpstreamUUID string
+	UpstreamName string
+	Kind         string
+	KindLabel    string
+	ArtifactPath string
+}
+
+// DependencyGraphNode is a run positioned in a rendered dependency graph
+type DependencyGraphNode struct {
+	UUID  string
+	Name  string
+	Label string
+	Focus bool
+	X, Y  int
+}
+
+// DependencyGraphEdge is a dependency positioned in a rendered dependency
+// graph, drawn from the upstream run to the run that consumed it
+type DependencyGraphEdge struct {
+	Kind           string
+	ArtifactPath   string
+	X1, Y1, X2, Y2 int
+}
+
+// DependencyGraph is a run's lineage laid out left to right, upstream runs
+// first
+type DependencyGraph struct {
+	Nodes      []DependencyGraphNode
+	Edges      []DependencyGraphEdge
+	Width      int
+	Height     int
+	NodeWidth  int
+	NodeHeight int
+	Truncated  bool
+}
+
+// runDependencyKindLabel returns the label of a dependency kind, or "" if the
+// kind is unknown
+func runDependencyKindLabel(kind string) string {
+	for _, k := range runDependencyKinds {
+		if k.Kind == kind {
+			return k.Label
+		}
+	}
+	return ""
+}
+
+func runDependencyFromRow(row RunDependencyRow) RunDependency {
+	return RunDependency{
+		ID:           row.ID,
+		RunUUID:      row.RunUUID,
+		RunName:      row.RunName,
+		UpstreamUUID: row.UpstreamUUID,
+		UpstreamName: row.UpstreamName,
+		Kind:         row.Kind,
+		KindLabel:    runDependencyKindLabel(row.Kind),
+		ArtifactPath: row.ArtifactPath,
+	}
+}
+
+// runDependsOn reports whether runID transitively depends on upstreamRunID
+func runDependsOn(ctx context.Context, runID, upstreamRunID int) (bool, error) {
+	seen := map[int]bool{runID: true}
+	queue := []int{runID}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		edges, err := dao.GetRunDependencyEdges(ctx, current)
+		if err != nil {
+			return false, err
+		}
+		for _, e := range edges {
+			if e.RunID != current || seen[e.UpstreamRunID] {
+				continue
+			}
+			if e.UpstreamRunID == upstreamRunID {
+				return true, nil
+			}
+			seen[e.UpstreamRunID] = true
+			queue = append(queue, e.UpstreamRunID)
+		}
+	}
+	return false, nil
+}
+
+// addRunDependency records that a run consumed something produced by an
+// upstream run. If artifactPath is set it must be an artifact of the upstream
+// run. Edges that would make a run depend on itself are rejected, and adding
+// an existing edge again is a no-op.
+func addRunDependency(ctx context.Context, runUUID, upstreamUUID, kind, artifactPath string) error {
+	if runDependencyKindLabel(kind) == "" {
+		return &runDependencyError{http.StatusBadRequest, fmt.Sprintf("unknown dependency kind %q", kind)}
+	}
+	runID, err := dao.GetRunIDByUUID(ctx, runUUID)
+	if err != nil {
+		return &runDependencyError{http.StatusNotFound, "Run not found"}
+	}
+	upstreamRunID, err := dao.GetRunIDByUUID(ctx, upstreamUUID)
+	if err != nil {
+		return &runDependencyError{http.StatusNotFound, "Upstream run not found"}
+	}
+	if runID == upstreamRunID {
+		return &runDependencyError{http.StatusBadRequest, "a run cannot depend on itself"}
+	}
+
+	if artifactPath != "" {
+		_, err := dao.GetArtifactByRunIDAndPath(ctx, upstreamRunID, artifactPath)
+		if errors.Is(err, sql.ErrNoRows) {
+			return &runDependencyError{http.StatusBadRequest, fmt.Sprintf("upstream run has no artifact %q", artifactPath)}
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	edges, err := dao.GetRunDependencyEdges(ctx, runID)
+	if err != nil {
+		return err
+	}
+	for _, e := range edges {
+		if e.RunID == runID && e.UpstreamRunID == upstreamRunID && e.Kind == kind && e.ArtifactPath == artifactPath {
+			return nil
+		}
+	}
+
+	cycle, err := runDependsOn(ctx, upstreamRunID, runID)
+	if err != nil {
+		return err
+	}
+	if cycle {
+		return &runDependencyError{http.StatusConflict, "the upstream run already depends on this run"}
+	}
+
+	return dao.InsertRunDependency(ctx, runID, upstreamRunID, kind, artifactPath)
+}
+
+// removeRunDependency deletes a dependency of which runID is either end
+func removeRunDependency(ctx context.Context, runID, dependencyID int) error {
+	edges, err := dao.GetRunDependencyEdges(ctx, runID)
+	if err != nil {
+		return err
+	}
+	for _, e := range edges {
+		if e.ID == dependencyID {
+			return dao.DeleteRunDependency(ctx, dependencyID)
+		}
+	}
+	return &runDependencyError{http.StatusNotFound, "Dependency not found"}
+}
+
+// getRunDependencies returns the direct upstream and downstream dependencies
+// of a run
+func getRunDependencies(ctx context.Context, runID int) ([]RunDependency, []RunDependency, error) {
+	edges, err := dao.GetRunDependencyEdges(ctx, runID)
+	if err != nil {
+		return nil, nil, err
+	}
+	var upstream, downstream []RunDependency
+	for _, e := range edges {
+		if e.RunID == runID {
+			upstream = append(upstream, runDependencyFromRow(e))
+		} else {
+			downstream = append(downstream, runDependencyFromRow(e))
+		}
+	}
+	return upstream, downstream, nil
+}
+
+// collectRunLineage gathers the dependency edges among a run, everything it
+// transitively depends on, and everything that transitively depends on it.
+// Sibling consumers of an upstream run are not included. It reports whether
+// runDependencyGraphLimit cut the lineage short.
+func collectRunLineage(ctx context.Context, runID int) ([]RunDependencyRow, bool, error) {
+	seenEdges := make(map[int]bool)
+	var lineage []RunDependencyRow
+	nodes := map[int]bool{runID: true}
+	truncated := false
+
+	walk := func(upstream bool) error {
+		visited := map[int]bool{runID: true}
+		queue := []int{runID}
+		for len(queue) > 0 {
+			current := queue[0]
+			queue = queue[1:]
+			edges, err := dao.GetRunDependencyEdges(ctx, current)
+			if err != nil {
+				return err
+			}
+			for _, e := range edges {
+				next := e.UpstreamRunID
+				if !upstream {
+					next = e.RunID
+				}
+				if next == current {
+					continue
+				}
+				if !nodes[next] && len(nodes) >= runDependencyGraphLimit {
+					truncated = true
+					continue
+				}
+				nodes[next] = true
+				if !seenEdges[e.ID] {
+					seenEdges[e.ID] = true
+					lineage = append(lineage, e)
+				}
+				if !visited[next] {
+					visited[next] = true
+					queue = append(queue, next)
+				}
+			}
+		}
+		return nil
+	}
+
+	if err := walk(true); err != nil {
+		return nil, false, err
+	}
+	if err := walk(false); err != nil {
+		return nil, false, err
+	}
+	return lineage, truncated, nil
+}
+
+// layoutDependencyGraph places the runs of a lineage in columns by their
+// longest path from a run with no upstream dependencies, so every edge points
+// to the right. Runs in a column keep the order they were first seen in.
+func layoutDependencyGraph(focusRunID int, focusUUID, focusName string, edges []RunDependencyRow) DependencyGraph {
+	type node struct {
+		uuid, name string
+		column     int
+		order      int
+	}
+	nodes := map[int]*node{focusRunID: {uuid: focusUUID, name: focusName}}
+	addNode := func(id int, uuid, name string) {
+		if _, ok := nodes[id]; !ok {
+			nodes[id] = &node{uuid: uuid, name: name, order: len(nodes)}
+		}
+	}
+	for _, e := range edges {
+		addNode(e.UpstreamRunID, e.UpstreamUUID, e.UpstreamName)
+		addNode(e.RunID, e.RunUUID, e.RunName)
+	}
+
+	// Longest-path layering; lineages are acyclic so this settles within
+	// one pass per node
+	for range nodes {
+		changed := false
+		for _, e := range edges {
+			if column := nodes[e.UpstreamRunID].column + 1; column > nodes[e.RunID].column {
+				nodes[e.RunID].column = column
+				changed = true
+			}
+		}
+		if !changed {
+			break
+		}
+	}
+
+	columns := make(map[int][]int)
+	maxColumn, maxRows := 0, 0
+	for id, n := range nodes {
+		columns[n.column] = append(columns[n.column], id)
+		maxColumn = max(maxColumn, n.column)
+	}
+	positions := make(map[int][2]int)
+	graph := DependencyGraph{NodeWidth: dependencyGraphNodeWidth, NodeHeight: dependencyGraphNodeHeight}
+	for column := 0; column <= maxColumn; column++ {
+		ids := columns[column]
+		sort.Slice(ids, func(i, j int) bool { return nodes[ids[i]].order < nodes[ids[j]].order })
+		maxRows = max(maxRows, len(ids))
+		for row, id := range ids {
+			x := dependencyGraphPadding + column*(dependencyGraphNodeWidth+dependencyGraphColumnGap)
+			y := dependencyGraphPadding + row*(dependencyGraphNodeHeight+dependencyGraphRowGap)
+			positions[id] = [2]int{x, y}
+			n := nodes[id]
+			graph.Nodes = append(graph.Nodes, DependencyGraphNode{
+				UUID:  n.uuid,
+				Name:  n.name,
+				Label: truncateDependencyGraphLabel(n.name),
+				Focus: id == focusRunID,
+				X:     x,
+				Y:     y,
+			})
+		}
+	}
+
+	for _, e := range edges {
+		from, to := positions[e.UpstreamRunID], positions[e.RunID]
+		graph.Edges = append(graph.Edges, DependencyGraphEdge{
+			Kind:         e.Kind,
+			ArtifactPath: e.ArtifactPath,
+			X1:           from[0] + dependencyGraphNodeWidth,
+			Y1:           from[1] + dependencyGraphNodeHeight/2,
+			X2:           to[0],
+			Y2:           to[1] + dependencyGraphNodeHeight/2,
+		})
+	}
+
+	graph.Width = 2*dependencyGraphPadding + (maxColumn+1)*dependencyGraphNodeWidth + maxColumn*dependencyGraphColumnGap
+	graph.Height = 2*dependencyGraphPadding + maxRows*dependencyGraphNodeHeight + (maxRows-1)*dependencyGraphRowGap
+	return graph
+}
+
+// truncateDependencyGraphLabel shortens a run name to fit in a graph node
+func truncateDependencyGraphLabel(name string) string {
+	const maxRunes = 22
+	runes := []rune(name)
+	if len(runes) <= maxRunes {
+		return name
+	}
+	return string(runes[:maxRunes-1]) + "…"
+}
+
+// writeRunDependencyError reports err as JSON, using its status if the
+// client can fix it
+func writeRunDependencyError(w http.ResponseWriter, err error, action string) {
+	var depErr *runDependencyError
+	if errors.As(err, &depErr) {
+		w.WriteHeader(depErr.status)
+		json.NewEncoder(w).Encode(map[string]string{"error": depErr.message})
+		return
+	}
+	log.Printf("Failed to %s: %v", action, err)
+	w.WriteHeader(http.StatusInternalServerError)
+	json.NewEncoder(w).Encode(map[string]string{"error": "Failed to " + action})
+}
+
+func handleAPIRunDependencies(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		handleAPIGetRunDependencies(w, r)
+	case http.MethodPost:
+		handleAPIAddRunDependency(w, r)
+	case http.MethodDelete:
+		handleAPIDeleteRunDependency(w, r)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// RunDependencyDocument is a run dependency as the API returns it
+type RunDependencyDocument struct {
+	ID           int    `json:"id"`
+	RunUUID      string `json:"run_uuid"`
+	UpstreamUUID string `json:"upstream_run_uuid"`
+	Kind         string `json:"kind"`
+	ArtifactPath string `json:"artifact_path,omitempty"`
+}
+
+func handleAPIGetRunDependencies(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	runUUID := r.URL.Query().Get("run_uuid")
+	if runUUID == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Missing required field: run_uuid"})
+		return
+	}
+	runID, err := dao.GetRunIDByUUID(ctx, runUUID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Run not found"})
+		return
+	}
+
+	upstream, downstream, err := getRunDependencies(ctx, runID)
+	if err != nil {
+		writeRunDependencyError(w, err, "query dependencies")
+		return
+	}
+
+	toJSON := func(deps []RunDependency) []RunDependencyDocument {
+		out := []RunDependencyDocument{}
+		for _, d := range deps {
+			out = append(out, RunDependencyDocument{
+				ID:           d.ID,
+				RunUUID:      d.RunUUID,
+				UpstreamUUID: d.UpstreamUUID,
+				Kind:         d.Kind,
+				ArtifactPath: d.ArtifactPath,
+			})
+		}
+		return out
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"upstream":   toJSON(upstream),
+		"downstream": toJSON(downstream),
+	})
+}
+
+// AddRunDependencyRequest is the body of POST /api/runs/dependencies
+type AddRunDependencyRequest struct {
+	RunUUID      string `json:"run_uuid"`
+	UpstreamUUID string `json:"upstream_run_uuid"`
+	Kind         string `json:"kind"`
+	ArtifactPath string `json:"artifact_path"`
+}
+
+func handleAPIAddRunDependency(w http.ResponseWriter, r *http.Request) {
+	var req AddRunDependencyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid JSON"})
+		return
+	}
+
+	var missing []string
+	if req.RunUUID == "" {
+		missing = append(missing, "run_uuid")
+	}
+	if req.UpstreamUUID == "" {
+		missing = append(missing, "upstream_run_uuid")
+	}
+	if req.Kind == "" {
+		missing = append(missing, "kind")
+	}
+	if len(missing) > 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":          "Missing required fields",
+			"missing_fields": missing,
+		})
+		return
+	}
+
+	if err := addRunDependency(r.Context(), req.RunUUID, req.UpstreamUUID, req.Kind, req.ArtifactPath); err != nil {
+		writeRunDependencyError(w, err, "add dependency")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+
+func handleAPIDeleteRunDependency(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	runUUID := r.URL.Query().Get("run_uuid")
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if runUUID == "" || err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Missing or invalid fields: run_uuid, id"})
+		return
+	}
+	runID, err := dao.GetRunIDByUUID(ctx, runUUID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Run not found"})
+		return
+	}
+
+	if err := removeRunDependency(ctx, runID, id); err != nil {
+		writeRunDependencyError(w, err, "delete dependency")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+
+// runDependenciesTemplates are the templates of the dependencies tab of the run page
+var runDependenciesTemplates = registerTemplates("templates/run_dependencies.html")
+
+// handleRunDependencies renders the dependencies tab of a run page. Posting
+// to it adds a dependency (upstream, kind, artifact_path) or removes one
+// (delete) and renders the tab again.
+func handleRunDependencies(w http.ResponseWriter, r *http.Request, runUUID string) error {
+	ctx := r.Context()
+	run, err := dao.GetRunByUUID(ctx, runUUID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "Run not found")
+		return nil
+	}
+	runID, err := dao.GetRunIDByUUID(ctx, runUUID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "Run not found")
+		return nil
+	}
+
+	var formError string
+	if r.Method == http.MethodPost {
+		if id := r.FormValue("delete"); id != "" {
+			dependencyID, err := strconv.Atoi(id)
+			if err == nil {
+				err = removeRunDependency(ctx, runID, dependencyID)
+			}
+			var depErr *runDependencyError
+			if errors.As(err, &depErr) {
+				formError = depErr.message
+			} else if err != nil {
+				log.Printf("Failed to delete dependency %s of run %s: %v", id, runUUID, err)
+				formError = "Could not remove the dependency"
+			}
+		} else {
+			formError = addRunDependencyFromForm(r, runUUID)
+		}
+	}
+
+	upstream, downstream, err := getRunDependencies(ctx, runID)
+	if err != nil {
+		return fmt.Errorf("failed to query dependencies of run %s: %w", runUUID, err)
+	}
+	lineage, truncated, err := collectRunLineage(ctx, runID)
+	if err != nil {
+		return fmt.Errorf("failed to collect lineage of run %s: %w", runUUID, err)
+	}
+	graph := layoutDependencyGraph(runID, runUUID, run.Name, lineage)
+	graph.Truncated = truncated
+
+	data := struct {
+		UUID       string
+		Upstream   []RunDependency
+		Downstream []RunDependency
+		Graph      DependencyGraph
+		Kinds      []RunDependencyKind
+		FormError  string
+	}{
+		UUID:       runUUID,
+		Upstream:   upstream,
+		Downstream: downstream,
+		Graph:      graph,
+		Kinds:      runDependencyKinds,
+		FormError:  formError,
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	tmpl, err := runDependenciesTemplates.Get()
+	if err != nil {
+		return fmt.Errorf("failed to parse template: %w", err)
+	}
+	return executeTemplate(w, tmpl, "run_dependencies.html", data)
+}
+
+// addRunDependencyFromForm adds the dependency posted from the dependencies
+// tab. The upstream run may be given by name or UUID prefix, as in short
+// links. Returns a message for the form if the dependency was not added.
+func addRunDependencyFromForm(r *http.Request, runUUID string) string {
+	ctx := r.Context()
+	query := strings.TrimSpace(r.FormValue("upstream"))
+	if query == "" {
+		return "Enter the upstream run's name or UUID"
+	}
+	matches, err := dao.FindRunsByNameOrUUIDPrefix(ctx, query)
+	if err != nil {
+		log.Printf("Failed to resolve upstream run %q: %v", query, err)
+		return "Could not look up the upstream run"
+	}
+	switch len(matches) {
+	case 0:
+		return fmt.Sprintf("No run matches %q", query)
+	case 1:
+	default:
+		return fmt.Sprintf("%d runs match %q; use more of the UUID", len(matches), query)
+	}
+
+	err = addRunDependency(ctx, runUUID, matches[0].UUID, r.FormValue("kind"), strings.TrimSpace(r.FormValue("artifact_path")))
+	var depErr *runDependencyError
+	if errors.As(err, &depErr) {
+		return depErr.message
+	}
+	if err != nil {
+		log.Printf("Failed to add dependency to run %s: %v", runUUID, err)
+		return "Could not add the dependency"
+	}
+	return ""
+}
@@ -0,0 +1,69 @@
+package main
+
+import "testing"
+
+func TestLayoutDependencyGraph(t *testing.T) {
+	// pretrain -> finetune -> eval, plus data -> finetune
+	edges := []RunDependencyRow{
+		{ID: 1, RunID: 2, RunUUID: "finetune", RunName: "finetune", UpstreamRunID: 1, UpstreamUUID: "pretrain", UpstreamName: "pretrain", Kind: "checkpoint"},
+		{ID: 2, RunID: 3, RunUUID: "eval", RunName: "eval", UpstreamRunID: 2, UpstreamUUID: "finetune", UpstreamName: "finetune", Kind: "evaluation"},
+		{ID: 3, RunID: 2, RunUUID: "finetune", RunName: "finetune", UpstreamRunID: 4, UpstreamUUID: "data", UpstreamName: "data", Kind: "dataset"},
+	}
+
+	graph := layoutDependencyGraph(2, "finetune", "finetune", edges)
+
+	if len(graph.Nodes) != 4 || len(graph.Edges) != 3 {
+		t.Fatalf("Expected 4 nodes and 3 edges, got %+v", graph)
+	}
+	positions := make(map[string]DependencyGraphNode)
+	for _, n := range graph.Nodes {
+		positions[n.UUID] = n
+	}
+
+	column := func(uuid string) int {
+		return (positions[uuid].X - dependencyGraphPadding) / (dependencyGraphNodeWidth + dependencyGraphColumnGap)
+	}
+	wantColumns := map[string]int{"pretrain": 0, "data": 0, "finetune": 1, "eval": 2}
+	for uuid, want := range wantColumns {
+		if got := column(uuid); got != want {
+			t.Errorf("%s in column %d, want %d", uuid, got, want)
+		}
+	}
+	if positions["pretrain"].Y == positions["data"].Y {
+		t.Errorf("Runs in the same column overlap: %+v", graph.Nodes)
+	}
+	if !positions["finetune"].Focus || positions["eval"].Focus {
+		t.Errorf("Expected only the focus run to be marked: %+v", graph.Nodes)
+	}
+	for _, e := range graph.Edges {
+		if e.X2 <= e.X1 {
+			t.Errorf("Edge %+v does not point right", e)
+		}
+	}
+	if graph.Width != 2*dependencyGraphPadding+3*dependencyGraphNodeWidth+2*dependencyGraphColumnGap {
+		t.Errorf("Unexpected graph width %d", graph.Width)
+	}
+}
+
+func TestLayoutDependencyGraphWithoutEdges(t *testing.T) {
+	graph := layoutDependencyGraph(1, "solo", "solo", nil)
+	if len(graph.Nodes) != 1 || !graph.Nodes[0].Focus || len(graph.Edges) != 0 {
+		t.Errorf("Expected only the focus run, got %+v", graph)
+	}
+}
+
+func TestTruncateDependencyGraphLabel(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"short", "short"},
+		{"exactly-twenty-two-ch", "exactly-twenty-two-ch"},
+		{"a-very-long-run-name-that-overflows", "a-very-long-run-name-…"},
+	}
+	for _, tt := range tests {
+		if got := truncateDependencyGraphLabel(tt.name); got != tt.want {
+			t.Errorf("truncateDependencyGraphLabel(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+)
+
+// Limits on logged embeddings. The projector computes projections on
+// request, so embeddings are kept small.
+const (
+	embeddingsMinPoints     = 3
+	embeddingsMaxPoints     = 2000
+	embeddingsMaxDimensions = 2048
+)
+
+// embeddingsArtifactType is the artifact type of logged embeddings
+const embeddingsArtifactType = "embeddings"
+
+// Embeddings is a matrix of vectors with optional labels, one per vector
+type Embeddings struct {
+	Labels  []string    `json:"labels,omitempty"`
+	Vectors [][]float64 `json:"vectors"`
+}
+
+// ProjectedPoint is an embedding vector projected onto two dimensions
+type ProjectedPoint struct {
+	X     float64 `json:"x"`
+	Y     float64 `json:"y"`
+	Label string  `json:"label"`
+	Index int     `json:"index"`
+}
+
+// projectionMethods are the methods the projector offers
+var projectionMethods = []string{"pca", "umap"}
+
+// embeddingsArtifactPath is the artifact path of the embeddings logged under key
+func embeddingsArtifactPath(key string) string {
+	return "embeddings/" + key + ".json"
+}
+
+// embeddingsKey recovers the key of embeddings from their artifact path
+func embeddingsKey(artifactPath string) string {
+	return strings.TrimSuffix(strings.TrimPrefix(artifactPath, "embeddings/"), ".json")
+}
+
+// validateEmbeddings checks that embeddings form a finite matrix of supported
+// size with at most one label per vector
+func validateEmbeddings(e Embeddings) error {
+	if len(e.Vectors) < embeddingsMinPoints {
+		return fmt.Errorf("embeddings must have at least %d vectors", embeddingsMinPoints)
+	}
+	if len(e.Vectors) > embeddingsMaxPoints {
+		return fmt.Errorf("embeddings have %d vectors, at most %d are supported", len(e.Vectors), embeddingsMaxPoints)
+	}
+	dims := len(e.Vectors[0])
+	if dims == 0 {
+		return fmt.Errorf("vectors cannot be empty")
+	}
+	if dims > embeddingsMaxDimensions {
+		return fmt.Errorf("vectors have %d dimensions, at most %d are supported", dims, embeddingsMaxDimensions)
+	}
+	for i, v := range e.Vectors {
+		if len(v) != dims {
+			return fmt.Errorf("vector %d has %d dimensions, expected %d", i, len(v), dims)
+		}
+		for _, x := range v {
+			if math.IsNaN(x) || math.IsInf(x, 0) {
+				return fmt.Errorf("vector %d has a non-finite value", i)
+			}
+		}
+	}
+	if e.Labels != nil && len(e.Labels) != len(e.Vectors) {
+		return fmt.Errorf("embeddings have %d vectors but %d labels", len(e.Vectors), len(e.Labels))
+	}
+	return nil
+}
+
+// projectEmbeddings projects embeddings onto two dimensions with the given
+// method. For PCA it also returns the fraction of variance explained by each
+// of the two components.
+func projectEmbeddings(e Embeddings, method string) ([]ProjectedPoint, []float64, error) {
+	var coords [][]float64
+	var explained []float64
+	switch method {
+	case "pca":
+		coords, explained = principalComponents(e.Vectors, 2)
+	case "umap":
+		coords = umapProjection(e.Vectors)
+	default:
+		return nil, nil, fmt.Errorf("unknown projection method %q", method)
+	}
+
+	points := make([]ProjectedPoint, len(coords))
+	for i, c := range coords {
+		points[i] = ProjectedPoint{X: c[0], Index: i}
+		if len(c) > 1 {
+			points[i].Y = c[1]
+		}
+		if e.Labels != nil {
+			points[i].Label = e.Labels[i]
+		}
+	}
+	return points, explained, nil
+}
+
+// loadEmbeddings reads embeddings a run logged under key, returning nil if
+// there are none
+func loadEmbeddings(ctx context.Context, runID int, key string) (*Embeddings, error) {
+	artifact, err := dao.GetArtifactByRunIDAndPath(ctx, runID, embeddingsArtifactPath(key))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if artifact.Type != embeddingsArtifactType {
+		return nil, nil
+	}
+
+	file, err := openArtifact(ctx, artifact.URI)
+	if err != nil {
+		return nil, fmt.Errorf("reading embeddings %q: %w", key, err)
+	}
+	defer file.Close()
+	var e Embeddings
+	if err := json.NewDecoder(file).Decode(&e); err != nil {
+		return nil, fmt.Errorf("decoding embeddings %q: %w", key, err)
+	}
+	return &e, nil
+}
+
+// getRunEmbeddingKeys lists the keys of the embeddings a run has logged
+func getRunEmbeddingKeys(ctx context.Context, runID int) ([]string, error) {
+	artifacts, err := dao.GetArtifactsByRunID(ctx, runID)
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	for _, a := range artifacts {
+		if a.Type == embeddingsArtifactType {
+			keys = append(keys, embeddingsKey(a.Path))
+		}
+	}
+	return keys, nil
+}
+
+// projectorTemplates are the templates of the embedding projector page
+var projectorTemplates = registerPage("templates/run_projector.html")
+
+func handleRunProjector(w http.ResponseWriter, r *http.Request, runUUID string) {
+	ctx := r.Context()
+	run, err := dao.GetRunByUUID(ctx, runUUID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "Run not found")
+		return
+	}
+	runID, err := dao.GetRunIDByUUID(ctx, runUUID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "Run not found")
+		return
+	}
+
+	keys, err := getRunEmbeddingKeys(ctx, runID)
+	if err != nil {
+		log.Printf("Failed to query embeddings for run %s: %v", runUUID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	key := r.URL.Query().Get("key")
+	if key == "" && len(keys) > 0 {
+		key = keys[0]
+	}
+	method := r.URL.Query().Get("method")
+	if method == "" {
+		method = "pca"
+	}
+
+	data := struct {
+		Title   string
+		RunUUID string
+		RunName string
+		Keys    []string
+		Key     string
+		Method  string
+		Methods []string
+		Points  []ProjectedPoint
+		// Explained describes the variance explained by each principal
+		// component, for PCA projections
+		Explained []string
+	}{
+		Title:   "Projector · " + run.Name,
+		RunUUID: runUUID,
+		RunName: run.Name,
+		Keys:    keys,
+		Key:     key,
+		Method:  method,
+		Methods: projectionMethods,
+	}
+
+	if key != "" {
+		embeddings, err := loadEmbeddings(ctx, runID, key)
+		if err != nil {
+			log.Printf("Failed to load embeddings for run %s: %v", runUUID, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if embeddings == nil {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprintf(w, "Embeddings %q not found", key)
+			return
+		}
+		points, explained, err := projectEmbeddings(*embeddings, method)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, "%v", err)
+			return
+		}
+		data.Points = points
+		for i, fraction := range explained {
+			data.Explained = append(data.Explained, fmt.Sprintf("PC%d %.1f%%", i+1, fraction*100))
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	tmpl, err := projectorTemplates.Get()
+	if err != nil {
+		log.Printf("Failed to parse template: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if err := tmpl.ExecuteTemplate(w, "run_projector.html", data); err != nil {
+		log.Printf("Failed to execute template: %v", err)
+	}
+}
+
+// LogEmbeddingsRequest is the body of POST /api/embeddings
+type LogEmbeddingsRequest struct {
+	RunUUID string `json:"run_uuid"`
+	Key     string `json:"key"`
+	Embeddings
+}
+
+func handleAPILogEmbeddings(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req LogEmbeddingsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid JSON"})
+		return
+	}
+
+	var missing []string
+	if req.RunUUID == "" {
+		missing = append(missing, "run_uuid")
+	}
+	if req.Key == "" {
+		missing = append(missing, "key")
+	}
+	if req.Vectors == nil {
+		missing = append(missing, "vectors")
+	}
+	if len(missing) > 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":          "Missing required fields",
+			"missing_fields": missing,
+		})
+		return
+	}
+
+	artifactPath := embeddingsArtifactPath(req.Key)
+	if err := isValidArtifactPath(artifactPath); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": fmt.Sprintf("Invalid key: %v", err)})
+		return
+	}
+	if err := validateEmbeddings(req.Embeddings); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": fmt.Sprintf("Invalid embeddings: %v", err)})
+		return
+	}
+
+	runID, err := dao.GetRunIDByUUID(ctx, req.RunUUID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Run not found"})
+		return
+	}
+
+	// Embeddings of a run on hold may be added but not overwritten
+	version := 1
+	if existing, err := dao.GetArtifactByRunIDAndPath(ctx, runID, artifactPath); err == nil {
+		if err := ensureRunNotOnHold(ctx, runID); err != nil {
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(map[string]string{"error": fmt.Sprintf("Cannot overwrite embeddings: %v", err)})
+			return
+		}
+		version = existing.Version + 1
+	}
+
+	encoded, err := json.Marshal(req.Embeddings)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to encode embeddings"})
+		return
+	}
+	key, err := runArtifactKey(ctx, runID, req.RunUUID)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": fmt.Sprintf("Failed to store embeddings: %v", err)})
+		return
+	}
+	uri, size, digest, err := storeArtifact(key, artifactPath, version, bytes.NewReader(encoded))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": fmt.Sprintf("Failed to store embeddings: %v", err)})
+		return
+	}
+	if err := dao.UpsertArtifact(ctx, runID, artifactPath, uri, embeddingsArtifactType, "application/json", size, digest); err != nil {
+		log.Printf("Error saving embeddings artifact: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to insert artifact metadata"})
+		return
+	}
+	scanUploadedArtifact(ctx, uri)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"status": "ok",
+		"path":   artifactPath,
+	})
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestValidateEmbeddings(t *testing.T) {
+	valid := Embeddings{Vectors: [][]float64{{0, 1}, {1, 0}, {1, 1}}, Labels: []string{"a", "b", "a"}}
+	if err := validateEmbeddings(valid); err != nil {
+		t.Errorf("Expected valid embeddings, got %v", err)
+	}
+	if err := validateEmbeddings(Embeddings{Vectors: valid.Vectors}); err != nil {
+		t.Errorf("Expected labels to be optional, got %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		embeddings Embeddings
+	}{
+		{"too few vectors", Embeddings{Vectors: [][]float64{{0}, {1}}}},
+		{"empty vectors", Embeddings{Vectors: [][]float64{{}, {}, {}}}},
+		{"ragged", Embeddings{Vectors: [][]float64{{0, 1}, {1}, {1, 1}}}},
+		{"NaN", Embeddings{Vectors: [][]float64{{0}, {math.NaN()}, {1}}}},
+		{"infinite", Embeddings{Vectors: [][]float64{{0}, {math.Inf(-1)}, {1}}}},
+		{"labels length", Embeddings{Vectors: valid.Vectors, Labels: []string{"a"}}},
+		{"too many dimensions", Embeddings{Vectors: [][]float64{
+			make([]float64, embeddingsMaxDimensions+1),
+			make([]float64, embeddingsMaxDimensions+1),
+			make([]float64, embeddingsMaxDimensions+1),
+		}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateEmbeddings(tt.embeddings); err == nil {
+				t.Errorf("Expected an error")
+			}
+		})
+	}
+}
+
+func TestEmbeddingsKey(t *testing.T) {
+	for _, key := range []string{"val", "val/penultimate"} {
+		if got := embeddingsKey(embeddingsArtifactPath(key)); got != key {
+			t.Errorf("Expected key %q, got %q", key, got)
+		}
+	}
+}
+
+func TestProjectEmbeddings(t *testing.T) {
+	e := Embeddings{Vectors: [][]float64{{0, 0}, {1, 0}, {2, 0}, {3, 1}}, Labels: []string{"a", "a", "b", "b"}}
+	points, explained, err := projectEmbeddings(e, "pca")
+	if err != nil {
+		t.Fatalf("projectEmbeddings failed: %v", err)
+	}
+	if len(points) != 4 || points[2].Label != "b" || points[2].Index != 2 || len(explained) != 2 {
+		t.Errorf("Unexpected projection %+v, %v", points, explained)
+	}
+
+	if _, _, err := projectEmbeddings(e, "tsne"); err == nil {
+		t.Errorf("Expected an error for an unknown method")
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"unicode/utf8"
+)
+
+// Limits on a run's environment variable snapshot
+const (
+	environmentMaxVariables = 1000
+	environmentKeyMaxLength = 256
+	environmentValueMaxSize = 8 << 10
+)
+
+// redactedValue replaces the values of environment variables that look like
+// secrets. The original values are never stored.
+const redactedValue = "[REDACTED]"
+
+// secretKeyPattern matches the names of environment variables that
+// conventionally hold secrets, e.g. AWS_SECRET_ACCESS_KEY or WANDB_API_KEY.
+// PWD is the working directory, but DB_PWD is a password.
+var secretKeyPattern = regexp.MustCompile(`(?i)(secret|token|passw(or)?d|.+_pwd$|api_?key|private_?key|access_?key|credential|auth|session|cookie|signature)`)
+
+// secretValuePatterns match values in the formats of well known credentials,
+// whatever variable they are in
+var secretValuePatterns = []*regexp.Regexp{
+	regexp.MustCompile(`\b(AKIA|ASIA)[0-9A-Z]{16}\b`),                            // AWS access key ID
+	regexp.MustCompile(`\bgh[pousr]_[A-Za-z0-9]{36,}\b`),                         // GitHub token
+	regexp.MustCompile(`\bgithub_pat_[A-Za-z0-9_]{22,}\b`),                       // GitHub fine-grained token
+	regexp.MustCompile(`\bxox[abprs]-[A-Za-z0-9-]{10,}`),                         // Slack token
+	regexp.MustCompile(`\bsk-[A-Za-z0-9_-]{20,}`),                                // OpenAI-style API key
+	regexp.MustCompile(`\bhf_[A-Za-z0-9]{30,}\b`),                                // Hugging Face token
+	regexp.MustCompile(`\beyJ[A-Za-z0-9_-]+\.eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+`), // JWT
+	regexp.MustCompile(`-----BEGIN [A-Z ]*PRIVATE KEY-----`),
+	regexp.MustCompile(`\b` + apiTokenPrefix + `[0-9a-f]{64}\b`),
+}
+
+// urlCredentialsPattern matches the password in a URL such as a database
+// connection string, which is redacted leaving the rest of the URL readable
+var urlCredentialsPattern = regexp.MustCompile(`([a-zA-Z][a-zA-Z0-9+.-]*://[^:/@\s]*:)[^@\s]+@`)
+
+// environmentRedactKeys matches the names of further environment variables
+// to redact, configured with -environment-redact-keys, or is nil
+var environmentRedactKeys *regexp.Regexp
+
+// redactEnvironmentVariable returns the value of an environment variable to
+// store, and whether it was redacted
+func redactEnvironmentVariable(key, value string) (string, bool) {
+	if secretKeyPattern.MatchString(key) || (environmentRedactKeys != nil && environmentRedactKeys.MatchString(key)) {
+		return redactedValue, true
+	}
+	for _, pattern := range secretValuePatterns {
+		if pattern.MatchString(value) {
+			return redactedValue, true
+		}
+	}
+	if urlCredentialsPattern.MatchString(value) {
+		return urlCredentialsPattern.ReplaceAllString(value, "${1}"+redactedValue+"@"), true
+	}
+	return value, false
+}
+
+// buildEnvironmentSnapshot validates and redacts logged environment
+// variables, returning them ordered by key
+func buildEnvironmentSnapshot(variables map[string]string) ([]EnvironmentVariableRow, error) {
+	if len(variables) > environmentMaxVariables {
+		return nil, fmt.Errorf("%d variables were logged, at most %d are supported", len(variables), environmentMaxVariables)
+	}
+	rows := make([]EnvironmentVariableRow, 0, len(variables))
+	for key, value := range variables {
+		if key == "" || len(key) > environmentKeyMaxLength {
+			return nil, fmt.Errorf("variable names must be 1 to %d characters, got %q", environmentKeyMaxLength, key)
+		}
+		if len(value) > environmentValueMaxSize {
+			return nil, fmt.Errorf("variable %s is %s, at most %s are supported", key, formatBytes(int64(len(value))), formatBytes(environmentValueMaxSize))
+		}
+		if !utf8.ValidString(key) || !utf8.ValidString(value) {
+			return nil, fmt.Errorf("variable %q is not valid UTF-8", key)
+		}
+		value, redacted := redactEnvironmentVariable(key, value)
+		rows = append(rows, EnvironmentVariableRow{Key: key, Value: value, Redacted: redacted})
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i].Key < rows[j].Key })
+	return rows, nil
+}
+
+// LogEnvironmentRequest is the body of POST /api/runs/environment
+type LogEnvironmentRequest struct {
+	RunUUID   string            `json:"run_uuid"`
+	Variables map[string]string `json:"variables"`
+}
+
+// handleAPILogEnvironment replaces the environment variable snapshot of a run
+func handleAPILogEnvironment(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req LogEnvironmentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid JSON"})
+		return
+	}
+
+	var missing []string
+	if req.RunUUID == "" {
+		missing = append(missing, "run_uuid")
+	}
+	if req.Variables == nil {
+		missing = append(missing, "variables")
+	}
+	if len(missing) > 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":          "Missing required fields",
+			"missing_fields": missing,
+		})
+		return
+	}
+
+	snapshot, err := buildEnvironmentSnapshot(req.Variables)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": fmt.Sprintf("Invalid environment: %v", err)})
+		return
+	}
+
+	runID, err := dao.GetRunIDByUUID(ctx, req.RunUUID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Run not found"})
+		return
+	}
+
+	// The environment of a run on hold may be logged but not replaced
+	existing, err := dao.GetRunEnvironment(ctx, runID)
+	if err != nil {
+		log.Printf("Failed to query environment for run %s: %v", req.RunUUID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to save environment"})
+		return
+	}
+	if len(existing) > 0 {
+		if err := ensureRunNotOnHold(ctx, runID); err != nil {
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(map[string]string{"error": fmt.Sprintf("Cannot replace environment: %v", err)})
+			return
+		}
+	}
+
+	if err := dao.ReplaceRunEnvironment(ctx, runID, snapshot); err != nil {
+		log.Printf("Error saving environment: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to save environment"})
+		return
+	}
+
+	// Tell the client which variables were redacted, so that it can warn
+	// about secrets in the environment
+	redacted := []string{}
+	for _, v := range snapshot {
+		if v.Redacted {
+			redacted = append(redacted, v.Key)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": "ok", "redacted": redacted})
+}
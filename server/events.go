@@ -0,0 +1,219 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// runEventBuffer is how many events a subscriber can fall behind before it
+// is disconnected. Browsers reconnect, and reload what they missed.
+const runEventBuffer = 256
+
+// runEventKeepAlive is how often an idle event stream sends a comment, so
+// that proxies do not close it
+var runEventKeepAlive = 30 * time.Second
+
+// RunEvent is something logged to a run, pushed to the run's page as it
+// arrives. Type is the SSE event name.
+type RunEvent struct {
+	Type string
+	Data interface{}
+}
+
+// MetricPointsEvent is the data of a "metrics" event: points logged to a
+// metric series together, at LoggedAt epoch milliseconds
+type MetricPointsEvent struct {
+	Key      string    `json:"key"`
+	X        []float64 `json:"x"`
+	Y        []float64 `json:"y"`
+	LoggedAt int64     `json:"logged_at"`
+}
+
+// ArtifactEvent is the data of an "artifact" event: an artifact that was
+// logged or overwritten
+type ArtifactEvent struct {
+	Path        string `json:"path"`
+	URI         string `json:"uri"`
+	SizeBytes   int64  `json:"size_bytes"`
+	DownloadURL string `json:"download_url"`
+}
+
+// runEventHub fans out the events of each run to its subscribers
+type runEventHub struct {
+	mu          sync.Mutex
+	subscribers map[int]map[chan RunEvent]struct{}
+	// relay sends published events to other replicas, if there are any
+	relay func(runID int, event RunEvent)
+	// closed is set once the server starts shutting down
+	closed bool
+}
+
+// runEvents is the server's hub of run events
+var runEvents = newRunEventHub()
+
+func newRunEventHub() *runEventHub {
+	return &runEventHub{subscribers: make(map[int]map[chan RunEvent]struct{})}
+}
+
+// Subscribe returns a channel of the run's events, and a function to
+// unsubscribe. The channel is closed when the subscriber falls too far behind,
+// unsubscribes, or the hub is closed.
+func (h *runEventHub) Subscribe(runID int) (<-chan RunEvent, func()) {
+	ch := make(chan RunEvent, runEventBuffer)
+	h.mu.Lock()
+	if h.closed {
+		h.mu.Unlock()
+		close(ch)
+		return ch, func() {}
+	}
+	if h.subscribers[runID] == nil {
+		h.subscribers[runID] = make(map[chan RunEvent]struct{})
+	}
+	h.subscribers[runID][ch] = struct{}{}
+	h.mu.Unlock()
+
+	return ch, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		h.remove(runID, ch)
+	}
+}
+
+// Publish sends an event to the run's subscribers, on every replica,
+// without blocking
+func (h *runEventHub) Publish(runID int, event RunEvent) {
+	h.deliver(runID, event)
+	if h.relay != nil {
+		h.relay(runID, event)
+	}
+}
+
+// deliver sends an event to the run's subscribers on this replica. A
+// subscriber whose buffer is full is disconnected rather than sent a stream
+// with holes in it.
+func (h *runEventHub) deliver(runID int, event RunEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subscribers[runID] {
+		h.send(runID, ch, event)
+	}
+}
+
+// deliverAll sends an event to the subscribers of every run on this replica
+func (h *runEventHub) deliverAll(event RunEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for runID, subscribers := range h.subscribers {
+		for ch := range subscribers {
+			h.send(runID, ch, event)
+		}
+	}
+}
+
+// send sends an event to one subscriber, disconnecting it if it has fallen
+// behind. h.mu must be held.
+func (h *runEventHub) send(runID int, ch chan RunEvent, event RunEvent) {
+	select {
+	case ch <- event:
+	default:
+		h.remove(runID, ch)
+	}
+}
+
+// Close disconnects every subscriber and refuses new ones. Event streams
+// never end on their own, so they are ended when the server shuts down.
+func (h *runEventHub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for runID, subscribers := range h.subscribers {
+		for ch := range subscribers {
+			h.remove(runID, ch)
+		}
+	}
+}
+
+// remove closes a subscriber's channel if it is still subscribed. h.mu must
+// be held.
+func (h *runEventHub) remove(runID int, ch chan RunEvent) {
+	if _, ok := h.subscribers[runID][ch]; !ok {
+		return
+	}
+	delete(h.subscribers[runID], ch)
+	close(ch)
+	if len(h.subscribers[runID]) == 0 {
+		delete(h.subscribers, runID)
+	}
+}
+
+// publishArtifactEvent announces a logged artifact to the run's page
+func publishArtifactEvent(runID int, path, uri string, size int64) {
+	runEvents.Publish(runID, RunEvent{Type: "artifact", Data: ArtifactEvent{
+		Path:        path,
+		URI:         uri,
+		SizeBytes:   size,
+		DownloadURL: "/artifacts/blob?uri=" + url.QueryEscape(uri),
+	}})
+}
+
+// handleRunEvents streams a run's events as Server-Sent Events until the
+// client disconnects
+func handleRunEvents(w http.ResponseWriter, r *http.Request, runUUID string) {
+	ctx := r.Context()
+	runID, err := dao.GetRunIDByUUID(ctx, runUUID)
+	if err != nil {
+		http.Error(w, "Run not found", http.StatusNotFound)
+		return
+	}
+
+	events, unsubscribe := runEvents.Subscribe(runID)
+	defer unsubscribe()
+	serverStats.sseConnections.Add(1)
+	defer serverStats.sseConnections.Add(-1)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// Stop nginx from buffering the stream
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	rc := http.NewResponseController(w)
+	if err := rc.Flush(); err != nil {
+		log.Printf("Cannot stream events of run %s: %v", runUUID, err)
+		return
+	}
+
+	keepAlive := time.NewTicker(runEventKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		case event, ok := <-events:
+			if !ok {
+				// Fell behind, or the server is shutting down; the client
+				// reconnects and reloads
+				return
+			}
+			data, err := json.Marshal(event.Data)
+			if err != nil {
+				log.Printf("Failed to encode %s event of run %s: %v", event.Type, runUUID, err)
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data); err != nil {
+				return
+			}
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
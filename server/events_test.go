@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRunEventHub(t *testing.T) {
+	hub := newRunEventHub()
+	events, unsubscribe := hub.Subscribe(1)
+	other, unsubscribeOther := hub.Subscribe(2)
+	defer unsubscribeOther()
+
+	hub.Publish(1, RunEvent{Type: "metrics"})
+	if event := <-events; event.Type != "metrics" {
+		t.Errorf("Expected a metrics event, got %+v", event)
+	}
+	select {
+	case event := <-other:
+		t.Errorf("Expected no event for another run, got %+v", event)
+	default:
+	}
+
+	unsubscribe()
+	if _, ok := <-events; ok {
+		t.Errorf("Expected the channel to be closed after unsubscribing")
+	}
+	unsubscribe()
+	hub.Publish(1, RunEvent{Type: "metrics"})
+}
+
+func TestRunEventHubDisconnectsSlowSubscribers(t *testing.T) {
+	hub := newRunEventHub()
+	events, unsubscribe := hub.Subscribe(1)
+	defer unsubscribe()
+
+	for i := 0; i <= runEventBuffer; i++ {
+		hub.Publish(1, RunEvent{Type: "metrics"})
+	}
+	received := 0
+	for range events {
+		received++
+	}
+	if received != runEventBuffer {
+		t.Errorf("Expected %d buffered events before the channel closed, got %d", runEventBuffer, received)
+	}
+}
+
+// runEventsDAO resolves run UUIDs from a map
+type runEventsDAO struct {
+	DAO
+	runs map[string]int
+}
+
+func (d *runEventsDAO) GetRunIDByUUID(ctx context.Context, uuid string) (int, error) {
+	if id, ok := d.runs[uuid]; ok {
+		return id, nil
+	}
+	return 0, sql.ErrNoRows
+}
+
+func TestHandleRunEvents(t *testing.T) {
+	defer func(d DAO) { dao = d }(dao)
+	dao = &runEventsDAO{runs: map[string]int{"run-1": 7}}
+
+	server := httptest.NewServer(LoggerMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handleRunEvents(w, r, strings.TrimPrefix(r.URL.Path, "/"))
+	})))
+	defer server.Close()
+
+	if resp, err := http.Get(server.URL + "/missing"); err != nil || resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected 404 for a missing run, got %v (%v)", resp, err)
+	}
+
+	resp, err := http.Get(server.URL + "/run-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+
+	// The response headers are flushed once the handler has subscribed
+	runEvents.Publish(7, RunEvent{Type: "metrics", Data: MetricPointsEvent{Key: "loss", X: []float64{1}, Y: []float64{0.5}, LoggedAt: 1700000000000}})
+	reader := bufio.NewReader(resp.Body)
+	var lines []string
+	for len(lines) < 2 {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Failed to read event: %v", err)
+		}
+		lines = append(lines, strings.TrimSuffix(line, "\n"))
+	}
+	if lines[0] != "event: metrics" || lines[1] != `data: {"key":"loss","x":[1],"y":[0.5],"logged_at":1700000000000}` {
+		t.Errorf("Unexpected event %q", lines)
+	}
+}
+
+func TestRunEventHubRelay(t *testing.T) {
+	hub := newRunEventHub()
+	var relayed []RunEvent
+	hub.relay = func(runID int, event RunEvent) { relayed = append(relayed, event) }
+	events, unsubscribe := hub.Subscribe(1)
+	defer unsubscribe()
+
+	hub.Publish(1, RunEvent{Type: "metrics"})
+	if len(relayed) != 1 || (<-events).Type != "metrics" {
+		t.Errorf("Expected a published event to be delivered and relayed, got %+v", relayed)
+	}
+
+	// Events relayed from other replicas are delivered, not relayed again
+	hub.deliver(1, RunEvent{Type: "artifact"})
+	hub.deliverAll(RunEvent{Type: "resync"})
+	if len(relayed) != 1 || (<-events).Type != "artifact" || (<-events).Type != "resync" {
+		t.Errorf("Expected delivered events not to be relayed, got %+v", relayed)
+	}
+}
+
+func TestRunEventHubClose(t *testing.T) {
+	hub := newRunEventHub()
+	events, unsubscribe := hub.Subscribe(1)
+	defer unsubscribe()
+
+	hub.Close()
+	if _, ok := <-events; ok {
+		t.Errorf("Expected the channel to be closed when the hub closes")
+	}
+	late, unsubscribeLate := hub.Subscribe(1)
+	defer unsubscribeLate()
+	if _, ok := <-late; ok {
+		t.Errorf("Expected a closed hub to refuse new subscribers")
+	}
+}
@@ -0,0 +1,444 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Archiving an experiment writes everything logged to it into a single zip
+// bundle in the artifact store:
+//
+//	experiment.yaml          the experiment's config, as /api/experiments/config exports it
+//	runs.json                the runs, with their parameters, tags and artifact metadata
+//	metrics.parquet          every metric point, one row per point
+//	artifacts/{uuid}/{path}  the runs' artifact files
+//
+// The experiment can then be purged: its archived runs are deleted, as if
+// each were deleted from its page, leaving the bundle as the only copy.
+
+// experimentArchivePrefix is where experiment bundles are kept in the artifact
+// store, beneath the experiment's UUID. It cannot collide with a run UUID.
+const experimentArchivePrefix = "_archives/"
+
+// errExperimentRunning is returned when purging an experiment that still has
+// runs in progress
+var errExperimentRunning = errors.New("experiment has runs in progress")
+
+// metricsParquetColumns are the columns of metrics.parquet
+var metricsParquetColumns = []parquetColumn{
+	{Name: "run_uuid", Type: parquetByteArray, ConvertedType: parquetUTF8},
+	{Name: "key", Type: parquetByteArray, ConvertedType: parquetUTF8},
+	{Name: "x", Type: parquetDouble, ConvertedType: -1},
+	{Name: "y", Type: parquetDouble, ConvertedType: -1},
+	{Name: "logged_at", Type: parquetInt64, ConvertedType: parquetTimestampMillis},
+}
+
+// ArchivedRun is a run as runs.json records it
+type ArchivedRun struct {
+	UUID       string                 `json:"uuid"`
+	Name       string                 `json:"name"`
+	Notes      string                 `json:"notes,omitempty"`
+	Status     string                 `json:"status"`
+	CreatedAt  string                 `json:"created_at"`
+	ParentUUID string                 `json:"parent_uuid,omitempty"`
+	Parameters map[string]interface{} `json:"parameters"`
+	Tags       map[string]string      `json:"tags"`
+	Artifacts  []ArchivedArtifact     `json:"artifacts"`
+}
+
+// ArchivedArtifact is an artifact's metadata as runs.json records it. Its
+// file is at artifacts/{run uuid}/{path} in the bundle.
+type ArchivedArtifact struct {
+	Path      string `json:"path"`
+	Type      string `json:"type"`
+	SizeBytes int64  `json:"size_bytes"`
+	SHA256    string `json:"sha256,omitempty"`
+}
+
+// ExperimentArchive is a bundle in the artifact store
+type ExperimentArchive struct {
+	Key         string    `json:"key"`
+	URI         string    `json:"uri"`
+	SizeBytes   int64     `json:"size_bytes"`
+	CreatedAt   time.Time `json:"created_at"`
+	DownloadURL string    `json:"download_url"`
+}
+
+// archivedExperimentRun is a run being archived, with its ID
+type archivedExperimentRun struct {
+	Run
+	ID int
+}
+
+// getExperimentArchiveRuns loads the runs of an experiment, oldest first
+func getExperimentArchiveRuns(ctx context.Context, experimentID int) ([]archivedExperimentRun, error) {
+	runs, err := dao.GetRunsByExperimentID(ctx, experimentID)
+	if err != nil {
+		return nil, err
+	}
+	archived := make([]archivedExperimentRun, 0, len(runs))
+	for i := len(runs) - 1; i >= 0; i-- {
+		id, err := dao.GetRunIDByUUID(ctx, runs[i].UUID)
+		if err != nil {
+			return nil, err
+		}
+		// GetRunsByExperimentID leaves out notes
+		run, err := dao.GetRunByUUID(ctx, runs[i].UUID)
+		if err != nil {
+			return nil, err
+		}
+		runs[i].Notes = run.Notes
+		archived = append(archived, archivedExperimentRun{Run: runs[i], ID: id})
+	}
+	return archived, nil
+}
+
+// archiveExperiment writes a bundle of an experiment to the artifact store.
+// If purge is set, the archived runs are then deleted; nothing is archived if
+// any of them is on hold (errRunOnHold) or still running
+// (errExperimentRunning). Returns the bundle and the number of runs in it.
+func archiveExperiment(ctx context.Context, experimentUUID string, purge bool) (*ExperimentArchive, int, error) {
+	experimentID, err := dao.GetExperimentIDByUUID(ctx, experimentUUID)
+	if err != nil {
+		return nil, 0, err
+	}
+	runs, err := getExperimentArchiveRuns(ctx, experimentID)
+	if err != nil {
+		return nil, 0, err
+	}
+	if purge {
+		for _, run := range runs {
+			if run.Status == runStatusRunning {
+				return nil, 0, errExperimentRunning
+			}
+			if err := ensureRunNotOnHold(ctx, run.ID); err != nil {
+				return nil, 0, err
+			}
+		}
+	}
+
+	now := time.Now().UTC()
+	key := experimentArchivePrefix + experimentUUID + "/" + now.Format("20060102T150405.000000000Z") + ".zip"
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(writeExperimentArchive(ctx, pw, experimentUUID, runs))
+	}()
+	size, err := artifactStore.Put(key, pr)
+	// Unblock the writer if the store gave up part way through
+	pr.Close()
+	if err != nil {
+		return nil, 0, fmt.Errorf("writing %s: %w", key, err)
+	}
+	archive := newExperimentArchive(ArtifactInfo{Key: key, Size: size, ModifiedAt: now})
+
+	if purge {
+		// Only the archived runs are purged, not any logged in the meantime
+		ids := make([]int, len(runs))
+		for i, run := range runs {
+			if err := dao.MarkRunDeleted(ctx, run.ID, now, ""); err != nil {
+				return nil, 0, fmt.Errorf("deleting run %s: %w", run.UUID, err)
+			}
+			ids[i] = run.ID
+		}
+		if err := purgeRuns(ctx, ids); err != nil {
+			log.Printf("Failed to purge runs of archived experiment %s: %v", experimentUUID, err)
+		}
+	}
+	return archive, len(runs), nil
+}
+
+// writeExperimentArchive writes the bundle of an experiment's runs as a zip
+func writeExperimentArchive(ctx context.Context, w io.Writer, experimentUUID string, runs []archivedExperimentRun) error {
+	zw := zip.NewWriter(w)
+
+	config, err := exportExperimentConfig(ctx, experimentUUID)
+	if err != nil {
+		return fmt.Errorf("exporting config: %w", err)
+	}
+	entry, err := zw.Create("experiment.yaml")
+	if err != nil {
+		return err
+	}
+	configEncoder := yaml.NewEncoder(entry)
+	configEncoder.SetIndent(2)
+	if err := configEncoder.Encode(config); err != nil {
+		return err
+	}
+
+	uuids := make(map[int]string, len(runs))
+	for _, run := range runs {
+		uuids[run.ID] = run.UUID
+	}
+	archivedRuns := make([]ArchivedRun, 0, len(runs))
+	for _, run := range runs {
+		archived, err := getArchivedRun(ctx, run, uuids)
+		if err != nil {
+			return fmt.Errorf("archiving run %s: %w", run.UUID, err)
+		}
+		archivedRuns = append(archivedRuns, *archived)
+	}
+	entry, err = zw.Create("runs.json")
+	if err != nil {
+		return err
+	}
+	runsEncoder := json.NewEncoder(entry)
+	runsEncoder.SetIndent("", "  ")
+	if err := runsEncoder.Encode(archivedRuns); err != nil {
+		return err
+	}
+
+	// Parquet pages are already uncompressed, so the zip compresses them
+	entry, err = zw.Create("metrics.parquet")
+	if err != nil {
+		return err
+	}
+	metrics := newParquetWriter(entry, metricsParquetColumns)
+	for _, run := range runs {
+		rows, err := dao.GetMetricsByRunID(ctx, run.ID)
+		if err != nil {
+			return fmt.Errorf("loading metrics of run %s: %w", run.UUID, err)
+		}
+		for _, m := range rows {
+			if err := metrics.WriteRow(run.UUID, m.Key, m.XValue, m.YValue, m.LoggedAt.UnixMilli()); err != nil {
+				return err
+			}
+		}
+	}
+	if err := metrics.Close(); err != nil {
+		return err
+	}
+
+	for _, run := range runs {
+		artifacts, err := dao.GetArtifactsByRunID(ctx, run.ID)
+		if err != nil {
+			return fmt.Errorf("loading artifacts of run %s: %w", run.UUID, err)
+		}
+		if err := addArtifactsToArchive(ctx, zw, artifacts, "artifacts/"+run.UUID+"/"); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// getArchivedRun loads what runs.json records about a run. uuids maps the IDs
+// of the archived runs to their UUIDs.
+func getArchivedRun(ctx context.Context, run archivedExperimentRun, uuids map[int]string) (*ArchivedRun, error) {
+	archived := &ArchivedRun{
+		UUID:       run.UUID,
+		Name:       run.Name,
+		Notes:      run.Notes,
+		Status:     run.Status,
+		CreatedAt:  run.CreatedAt,
+		Parameters: map[string]interface{}{},
+		Tags:       map[string]string{},
+		Artifacts:  []ArchivedArtifact{},
+	}
+	if run.ParentRunID != nil {
+		archived.ParentUUID = uuids[*run.ParentRunID]
+	}
+
+	params, err := dao.GetParametersByRunID(ctx, run.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range params {
+		archived.Parameters[p.Key] = parameterJSONValue(p)
+	}
+	tags, err := dao.GetRunTags(ctx, run.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, tag := range tags {
+		archived.Tags[tag.Key] = tag.Value
+	}
+	artifacts, err := dao.GetArtifactsByRunID(ctx, run.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, artifact := range artifacts {
+		archived.Artifacts = append(archived.Artifacts, ArchivedArtifact{
+			Path:      artifact.Path,
+			Type:      artifact.Type,
+			SizeBytes: artifact.SizeBytes,
+			SHA256:    artifact.SHA256,
+		})
+	}
+	return archived, nil
+}
+
+func newExperimentArchive(info ArtifactInfo) *ExperimentArchive {
+	uri := artifactStore.URI(info.Key)
+	return &ExperimentArchive{
+		Key:         info.Key,
+		URI:         uri,
+		SizeBytes:   info.Size,
+		CreatedAt:   info.ModifiedAt,
+		DownloadURL: "/artifacts/blob?uri=" + url.QueryEscape(uri),
+	}
+}
+
+// getExperimentArchives lists the bundles of an experiment, newest first
+func getExperimentArchives(experimentUUID string) ([]ExperimentArchive, error) {
+	var archives []ExperimentArchive
+	err := artifactStore.Walk(experimentArchivePrefix+experimentUUID+"/", func(info ArtifactInfo) error {
+		archives = append(archives, *newExperimentArchive(info))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	// Keys are timestamped, so they sort by age
+	sort.Slice(archives, func(i, j int) bool { return archives[i].Key > archives[j].Key })
+	return archives, nil
+}
+
+// ExperimentArchiveRequest is the body of POST /api/experiments/archive
+type ExperimentArchiveRequest struct {
+	ExperimentUUID string `json:"experiment_uuid"`
+	Purge          bool   `json:"purge"`
+}
+
+// handleAPIExperimentArchive lists an experiment's bundles at
+// GET /api/experiments/archive?experiment_uuid=..., and archives it at POST
+func handleAPIExperimentArchive(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	w.Header().Set("Content-Type", "application/json")
+
+	var req ExperimentArchiveRequest
+	switch r.Method {
+	case http.MethodGet:
+		req.ExperimentUUID = r.URL.Query().Get("experiment_uuid")
+	case http.MethodPost:
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Invalid JSON"})
+			return
+		}
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if req.ExperimentUUID == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Missing required field: experiment_uuid"})
+		return
+	}
+	if _, err := dao.GetExperimentIDByUUID(ctx, req.ExperimentUUID); err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Experiment not found"})
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		archives, err := getExperimentArchives(req.ExperimentUUID)
+		if err != nil {
+			log.Printf("Failed to list archives of experiment %s: %v", req.ExperimentUUID, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to list archives"})
+			return
+		}
+		if archives == nil {
+			archives = []ExperimentArchive{}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"archives": archives})
+		return
+	}
+
+	archive, runCount, err := archiveExperiment(ctx, req.ExperimentUUID, req.Purge)
+	if err != nil {
+		switch {
+		case errors.Is(err, errRunOnHold):
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(map[string]string{"error": "A run of the experiment is on hold, so it cannot be purged"})
+		case errors.Is(err, errExperimentRunning):
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(map[string]string{"error": "A run of the experiment is still running, so it cannot be purged"})
+		default:
+			log.Printf("Failed to archive experiment %s: %v", req.ExperimentUUID, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to archive experiment"})
+		}
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"archive": archive,
+		"runs":    runCount,
+		"purged":  req.Purge,
+	})
+}
+
+// handleExperimentArchive archives an experiment from its page, purging it if
+// the form asks to
+func handleExperimentArchive(w http.ResponseWriter, r *http.Request, experimentUUID string) {
+	ctx := r.Context()
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if _, err := dao.GetExperimentIDByUUID(ctx, experimentUUID); err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "Experiment not found")
+		return
+	}
+
+	purge := r.FormValue("purge") == "on"
+	var archiveError string
+	_, _, err := archiveExperiment(ctx, experimentUUID, purge)
+	switch {
+	case errors.Is(err, errRunOnHold):
+		archiveError = "A run of this experiment is on hold, so it cannot be purged"
+	case errors.Is(err, errExperimentRunning):
+		archiveError = "A run of this experiment is still running, so it cannot be purged"
+	case err != nil:
+		log.Printf("Failed to archive experiment %s: %v", experimentUUID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	case purge:
+		// The runs table is now empty, so reload the page rather than
+		// swapping the fragment
+		w.Header().Set("HX-Refresh", "true")
+		return
+	}
+	renderExperimentArchives(w, experimentUUID, archiveError)
+}
+
+// experimentArchivesTemplates are the templates of the archives panel of the experiment page
+var experimentArchivesTemplates = registerTemplates("templates/experiment_archives.html")
+
+// renderExperimentArchives renders the archives section of an experiment page
+func renderExperimentArchives(w http.ResponseWriter, experimentUUID, archiveError string) {
+	archives, err := getExperimentArchives(experimentUUID)
+	if err != nil {
+		log.Printf("Failed to list archives of experiment %s: %v", experimentUUID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	data := struct {
+		ExperimentUUID string
+		Archives       []ExperimentArchive
+		ArchiveError   string
+	}{experimentUUID, archives, archiveError}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	tmpl, err := experimentArchivesTemplates.Get()
+	if err != nil {
+		log.Printf("Failed to parse template: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if err := executeTemplate(w, tmpl, "experiment_archives", data); err != nil {
+		log.Printf("Failed to render template: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
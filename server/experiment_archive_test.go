@@ -0,0 +1,306 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// experimentArchiveDAO knows an experiment with a parent and a child run
+type experimentArchiveDAO struct {
+	defaultProjectDAO
+	runs    []Run
+	held    map[int]bool
+	deleted map[int]bool
+	purged  []int
+}
+
+func newExperimentArchiveDAO() *experimentArchiveDAO {
+	parentID := 1
+	return &experimentArchiveDAO{
+		// Newest first, like GetRunsByExperimentID
+		runs: []Run{
+			{UUID: "child", Name: "fold-0", CreatedAt: "2024-01-02", ParentRunID: &parentID, NestingLevel: 1, Status: runStatusFinished},
+			{UUID: "parent", Name: "sweep", CreatedAt: "2024-01-01", Status: runStatusFinished},
+		},
+		held:    map[int]bool{},
+		deleted: map[int]bool{},
+	}
+}
+
+func (d *experimentArchiveDAO) runID(uuid string) (int, error) {
+	switch uuid {
+	case "parent":
+		return 1, nil
+	case "child":
+		return 2, nil
+	}
+	return 0, sql.ErrNoRows
+}
+
+func (d *experimentArchiveDAO) GetExperimentIDByUUID(ctx context.Context, uuid string) (int, error) {
+	if uuid != "exp-1" {
+		return 0, sql.ErrNoRows
+	}
+	return 7, nil
+}
+
+func (d *experimentArchiveDAO) GetExperimentByUUID(ctx context.Context, uuid string) (*Experiment, error) {
+	return &Experiment{UUID: uuid, Name: "ablations"}, nil
+}
+
+func (d *experimentArchiveDAO) GetNotificationSubscriptions(ctx context.Context) ([]NotificationSubscriptionRow, error) {
+	return nil, nil
+}
+
+func (d *experimentArchiveDAO) GetExperimentCostRates(ctx context.Context, experimentID int) ([]CostRateRow, error) {
+	return nil, nil
+}
+
+func (d *experimentArchiveDAO) GetMetricSchema(ctx context.Context, experimentID int) ([]MetricSchemaEntryRow, error) {
+	return nil, nil
+}
+
+func (d *experimentArchiveDAO) GetBestCheckpointRule(ctx context.Context, experimentID int) (*BestCheckpointRuleRow, error) {
+	return nil, nil
+}
+
+func (d *experimentArchiveDAO) GetRunsByExperimentID(ctx context.Context, experimentID int) ([]Run, error) {
+	var runs []Run
+	for _, run := range d.runs {
+		if id, _ := d.runID(run.UUID); !d.deleted[id] {
+			runs = append(runs, run)
+		}
+	}
+	return runs, nil
+}
+
+func (d *experimentArchiveDAO) GetRunIDByUUID(ctx context.Context, uuid string) (int, error) {
+	return d.runID(uuid)
+}
+
+func (d *experimentArchiveDAO) GetRunByUUID(ctx context.Context, uuid string) (*Run, error) {
+	for _, run := range d.runs {
+		if run.UUID == uuid {
+			run.Notes = "notes on " + uuid
+			return &run, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (d *experimentArchiveDAO) GetRunHold(ctx context.Context, runID int) (*RunHoldRow, error) {
+	if d.held[runID] {
+		return &RunHoldRow{Reason: "audit"}, nil
+	}
+	return nil, nil
+}
+
+func (d *experimentArchiveDAO) GetParametersByRunID(ctx context.Context, runID int) ([]ParameterRow, error) {
+	return []ParameterRow{{Key: "lr", ValueType: "float", ValueFloat: sql.NullFloat64{Float64: 0.1, Valid: true}}}, nil
+}
+
+func (d *experimentArchiveDAO) GetRunTags(ctx context.Context, runID int) ([]RunTagRow, error) {
+	return []RunTagRow{{Key: "stage", Value: "final"}}, nil
+}
+
+func (d *experimentArchiveDAO) GetArtifactsByRunID(ctx context.Context, runID int) ([]ArtifactRow, error) {
+	if runID != 1 {
+		return nil, nil
+	}
+	return []ArtifactRow{{Path: "model.pt", URI: "parent/model.pt", Type: "model", SizeBytes: 7}}, nil
+}
+
+func (d *experimentArchiveDAO) GetMetricsByRunID(ctx context.Context, runID int) ([]MetricRow, error) {
+	loggedAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	return []MetricRow{
+		{Key: "loss", XValue: 0, YValue: 1, LoggedAt: loggedAt},
+		{Key: "loss", XValue: 1, YValue: 0.5, LoggedAt: loggedAt.Add(time.Second)},
+	}, nil
+}
+
+func (d *experimentArchiveDAO) MarkRunDeleted(ctx context.Context, runID int, at time.Time, deletedBy string) error {
+	d.deleted[runID] = true
+	return nil
+}
+
+func (d *experimentArchiveDAO) GetDeletedRuns(ctx context.Context) ([]DeletedRunRow, error) {
+	var runs []DeletedRunRow
+	for _, run := range d.runs {
+		if id, _ := d.runID(run.UUID); d.deleted[id] && !slices.Contains(d.purged, id) {
+			runs = append(runs, DeletedRunRow{ID: id, UUID: run.UUID})
+		}
+	}
+	return runs, nil
+}
+
+func (d *experimentArchiveDAO) PurgeRun(ctx context.Context, runID int) error {
+	d.purged = append(d.purged, runID)
+	return nil
+}
+
+func postExperimentArchive(body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	handleAPIExperimentArchive(w, httptest.NewRequest(http.MethodPost, "/api/experiments/archive", strings.NewReader(body)))
+	return w
+}
+
+func TestHandleAPIExperimentArchive(t *testing.T) {
+	defer func(d DAO) { dao = d }(dao)
+	defer func(s ArtifactStore) { artifactStore = s }(artifactStore)
+	store, err := newFileArtifactStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	artifactStore = store
+	if _, err := store.Put("parent/model.pt", strings.NewReader("weights")); err != nil {
+		t.Fatal(err)
+	}
+	d := newExperimentArchiveDAO()
+	dao = d
+
+	w := postExperimentArchive(`{"experiment_uuid": "exp-1"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body)
+	}
+	var resp struct {
+		Archive ExperimentArchive `json:"archive"`
+		Runs    int               `json:"runs"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Runs != 2 || !strings.HasPrefix(resp.Archive.Key, "_archives/exp-1/") {
+		t.Errorf("Unexpected response %s", w.Body)
+	}
+	if len(d.deleted) != 0 {
+		t.Errorf("Expected nothing to be deleted without purge, got %v", d.deleted)
+	}
+
+	file, err := store.Get(resp.Archive.Key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bundle, _ := io.ReadAll(file)
+	file.Close()
+	archive, err := zip.NewReader(bytes.NewReader(bundle), int64(len(bundle)))
+	if err != nil {
+		t.Fatalf("Invalid zip: %v", err)
+	}
+	entries := map[string][]byte{}
+	for _, f := range archive.File {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries[f.Name], _ = io.ReadAll(r)
+		r.Close()
+	}
+
+	if !strings.Contains(string(entries["experiment.yaml"]), "name: ablations") {
+		t.Errorf("Unexpected experiment.yaml %q", entries["experiment.yaml"])
+	}
+	if string(entries["artifacts/parent/model.pt"]) != "weights" {
+		t.Errorf("Expected the run's artifact in the bundle, got entries %v", archive.File)
+	}
+	var runs []ArchivedRun
+	if err := json.Unmarshal(entries["runs.json"], &runs); err != nil {
+		t.Fatalf("Invalid runs.json: %v", err)
+	}
+	if len(runs) != 2 || runs[0].UUID != "parent" || runs[1].ParentUUID != "parent" {
+		t.Fatalf("Expected the runs oldest first with their parents, got %+v", runs)
+	}
+	if runs[0].Parameters["lr"] != 0.1 || runs[0].Tags["stage"] != "final" || runs[0].Notes != "notes on parent" {
+		t.Errorf("Unexpected parent run %+v", runs[0])
+	}
+	if len(runs[0].Artifacts) != 1 || runs[0].Artifacts[0].Path != "model.pt" {
+		t.Errorf("Unexpected artifacts %+v", runs[0].Artifacts)
+	}
+
+	metrics := entries["metrics.parquet"]
+	if !bytes.HasPrefix(metrics, parquetMagic) || !bytes.HasSuffix(metrics, parquetMagic) {
+		t.Fatalf("metrics.parquet is not framed as Parquet")
+	}
+	footerLen := int(binary.LittleEndian.Uint32(metrics[len(metrics)-8:]))
+	if footerLen <= 0 || footerLen > len(metrics)-12 {
+		t.Errorf("Unexpected Parquet footer length %d of %d bytes", footerLen, len(metrics))
+	}
+	// Both runs' values are in the file, uncompressed
+	if n := bytes.Count(metrics, []byte("loss")); n != 4 {
+		t.Errorf("Expected 4 metric points, found %d", n)
+	}
+
+	// A held run keeps the experiment from being purged, and nothing is archived
+	d.held[2] = true
+	if w := postExperimentArchive(`{"experiment_uuid": "exp-1", "purge": true}`); w.Code != http.StatusConflict {
+		t.Errorf("Expected 409 for a held run, got %d: %s", w.Code, w.Body)
+	}
+	archives, err := getExperimentArchives("exp-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(archives) != 1 {
+		t.Errorf("Expected only the first archive, got %v", archives)
+	}
+
+	d.held[2] = false
+	if w := postExperimentArchive(`{"experiment_uuid": "exp-1", "purge": true}`); w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body)
+	}
+	if len(d.purged) != 2 {
+		t.Errorf("Expected both runs to be purged, got %v", d.purged)
+	}
+	if keys, _ := store.List("parent/"); len(keys) != 0 {
+		t.Errorf("Expected the run's artifacts to be purged, got %v", keys)
+	}
+
+	w = httptest.NewRecorder()
+	handleAPIExperimentArchive(w, httptest.NewRequest(http.MethodGet, "/api/experiments/archive?experiment_uuid=exp-1", nil))
+	var list struct {
+		Archives []ExperimentArchive `json:"archives"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Archives) != 2 || list.Archives[0].Key <= list.Archives[1].Key {
+		t.Errorf("Expected both archives, newest first, got %+v", list.Archives)
+	}
+
+	if w := postExperimentArchive(`{"experiment_uuid": "missing"}`); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a missing experiment, got %d", w.Code)
+	}
+}
+
+func TestHandleAPIExperimentArchiveRefusesRunningRuns(t *testing.T) {
+	defer func(d DAO) { dao = d }(dao)
+	defer func(s ArtifactStore) { artifactStore = s }(artifactStore)
+	store, err := newFileArtifactStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	artifactStore = store
+	if _, err := store.Put("parent/model.pt", strings.NewReader("weights")); err != nil {
+		t.Fatal(err)
+	}
+	d := newExperimentArchiveDAO()
+	d.runs[0].Status = runStatusRunning
+	dao = d
+
+	if w := postExperimentArchive(`{"experiment_uuid": "exp-1", "purge": true}`); w.Code != http.StatusConflict {
+		t.Errorf("Expected 409 for a running run, got %d: %s", w.Code, w.Body)
+	}
+	// Archiving without purging is fine
+	if w := postExperimentArchive(`{"experiment_uuid": "exp-1"}`); w.Code != http.StatusOK {
+		t.Errorf("Expected 200, got %d: %s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,473 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+)
+
+const (
+	// experimentCompareCurveRuns caps how many of an experiment's runs,
+	// newest first, go into its aggregate learning curve
+	experimentCompareCurveRuns = 100
+	// experimentCompareCurvePoints is how many points of each run's series
+	// are loaded for the aggregate learning curve
+	experimentCompareCurvePoints = 500
+	// experimentCompareCurveBins is how many step intervals the aggregate
+	// learning curves are divided into
+	experimentCompareCurveBins = 100
+	// experimentCompareTopValues is how many of a non-numeric parameter's
+	// most common values are listed
+	experimentCompareTopValues = 5
+)
+
+// ComparedExperiment is one side of an experiment comparison
+type ComparedExperiment struct {
+	UUID         string
+	Name         string
+	RunCount     int
+	StatusCounts []StatusCount
+	// MetricRunCount is how many runs logged the compared metric
+	MetricRunCount int
+	// Best is the best value of the compared metric across all runs, or nil
+	// if no run logged it
+	Best *ExperimentBest
+	// MedianBest is the median of each run's best value of the compared
+	// metric, if any run logged it
+	MedianBest float64
+	// Curve is the compared metric's learning curve aggregated across runs
+	Curve []AggregatePoint
+	// CurveRunCount is how many runs went into Curve
+	CurveRunCount int
+}
+
+// StatusCount is how many of an experiment's runs have a status
+type StatusCount struct {
+	Status string
+	Count  int
+}
+
+// ExperimentBest is the best value of a metric across an experiment's runs
+type ExperimentBest struct {
+	RunUUID string
+	RunName string
+	Step    float64
+	Value   float64
+}
+
+// AggregatePoint is an interval of steps of a learning curve aggregated
+// across runs: the mean, lowest and highest of the runs' average values in
+// the interval
+type AggregatePoint struct {
+	Step float64 `json:"step"`
+	Mean float64 `json:"mean"`
+	Min  float64 `json:"min"`
+	Max  float64 `json:"max"`
+	Runs int     `json:"runs"`
+}
+
+// ParameterDistribution compares how the runs of two experiments set a
+// parameter
+type ParameterDistribution struct {
+	Key string
+	A   ParameterSummary
+	B   ParameterSummary
+}
+
+// ParameterSummary summarizes the values an experiment's runs set a
+// parameter to: the range and median of numeric values, otherwise the most
+// common values
+type ParameterSummary struct {
+	Runs    int
+	Numeric bool
+	Min     float64
+	Median  float64
+	Max     float64
+	Values  []ParameterValueCount
+	// OtherValues is how many distinct values are not listed in Values
+	OtherValues int
+}
+
+// ParameterValueCount is how many runs set a parameter to a value
+type ParameterValueCount struct {
+	Value string
+	Count int
+}
+
+// aggregateLearningCurve aggregates runs' series of a metric into one curve.
+// The steps the runs span are divided into bins intervals. In each, every
+// run's values are averaged, and the point gives the mean, lowest and
+// highest of those averages at the average step of the values, so runs that
+// log at different steps or stop early still line up.
+func aggregateLearningCurve(series [][]MetricRow, bins int) []AggregatePoint {
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for _, s := range series {
+		for _, m := range s {
+			lo = math.Min(lo, m.XValue)
+			hi = math.Max(hi, m.XValue)
+		}
+	}
+	if math.IsInf(lo, 1) {
+		return nil
+	}
+	if bins < 1 || hi == lo {
+		bins = 1
+	}
+	bin := func(step float64) int {
+		if hi == lo {
+			return 0
+		}
+		return min(int((step-lo)/(hi-lo)*float64(bins)), bins-1)
+	}
+
+	type interval struct {
+		stepSum   float64
+		stepCount int
+		averages  []float64
+	}
+	intervals := make([]interval, bins)
+	for _, s := range series {
+		sums := make([]float64, bins)
+		counts := make([]int, bins)
+		for _, m := range s {
+			if math.IsNaN(m.YValue) || math.IsInf(m.YValue, 0) {
+				continue
+			}
+			i := bin(m.XValue)
+			sums[i] += m.YValue
+			counts[i]++
+			intervals[i].stepSum += m.XValue
+			intervals[i].stepCount++
+		}
+		for i, n := range counts {
+			if n > 0 {
+				intervals[i].averages = append(intervals[i].averages, sums[i]/float64(n))
+			}
+		}
+	}
+
+	var curve []AggregatePoint
+	for _, in := range intervals {
+		if len(in.averages) == 0 {
+			continue
+		}
+		p := AggregatePoint{
+			Step: in.stepSum / float64(in.stepCount),
+			Min:  math.Inf(1),
+			Max:  math.Inf(-1),
+			Runs: len(in.averages),
+		}
+		for _, v := range in.averages {
+			p.Mean += v / float64(len(in.averages))
+			p.Min = math.Min(p.Min, v)
+			p.Max = math.Max(p.Max, v)
+		}
+		curve = append(curve, p)
+	}
+	return curve
+}
+
+// median is the median of values, which it sorts
+func median(values []float64) float64 {
+	sort.Float64s(values)
+	n := len(values)
+	if n%2 == 1 {
+		return values[n/2]
+	}
+	return (values[n/2-1] + values[n/2]) / 2
+}
+
+// summarizeParameters summarizes each parameter an experiment's runs set, by
+// key
+func summarizeParameters(params []ExperimentParameterRow) map[string]ParameterSummary {
+	byKey := make(map[string][]ParameterRow)
+	for _, p := range params {
+		byKey[p.Key] = append(byKey[p.Key], p.ParameterRow)
+	}
+
+	summaries := make(map[string]ParameterSummary, len(byKey))
+	for key, rows := range byKey {
+		summary := ParameterSummary{Runs: len(rows), Numeric: true}
+		var numbers []float64
+		for _, p := range rows {
+			switch p.ValueType {
+			case "float":
+				numbers = append(numbers, p.ValueFloat.Float64)
+			case "int":
+				numbers = append(numbers, float64(p.ValueInt.Int64))
+			default:
+				summary.Numeric = false
+			}
+		}
+		if summary.Numeric {
+			summary.Median = median(numbers)
+			summary.Min, summary.Max = numbers[0], numbers[len(numbers)-1]
+			summaries[key] = summary
+			continue
+		}
+
+		counts := make(map[string]int)
+		for _, p := range rows {
+			counts[formatParameterValue(p)]++
+		}
+		for value, count := range counts {
+			summary.Values = append(summary.Values, ParameterValueCount{Value: value, Count: count})
+		}
+		sort.Slice(summary.Values, func(i, j int) bool {
+			if summary.Values[i].Count != summary.Values[j].Count {
+				return summary.Values[i].Count > summary.Values[j].Count
+			}
+			return summary.Values[i].Value < summary.Values[j].Value
+		})
+		if len(summary.Values) > experimentCompareTopValues {
+			summary.OtherValues = len(summary.Values) - experimentCompareTopValues
+			summary.Values = summary.Values[:experimentCompareTopValues]
+		}
+		summaries[key] = summary
+	}
+	return summaries
+}
+
+// compareParameters pairs the parameter summaries of two experiments, in key
+// order
+func compareParameters(a, b map[string]ParameterSummary) []ParameterDistribution {
+	var keys []string
+	for key := range a {
+		keys = append(keys, key)
+	}
+	for key := range b {
+		if _, ok := a[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+
+	distributions := make([]ParameterDistribution, len(keys))
+	for i, key := range keys {
+		distributions[i] = ParameterDistribution{Key: key, A: a[key], B: b[key]}
+	}
+	return distributions
+}
+
+// countStatuses counts runs by status, in lifecycle order
+func countStatuses(runs []Run) []StatusCount {
+	order := []string{runStatusRunning, runStatusFinished, runStatusFailed, runStatusKilled}
+	counts := make(map[string]int)
+	for _, run := range runs {
+		if !slices.Contains(order, run.Status) {
+			order = append(order, run.Status)
+		}
+		counts[run.Status]++
+	}
+	var statuses []StatusCount
+	for _, status := range order {
+		if counts[status] > 0 {
+			statuses = append(statuses, StatusCount{Status: status, Count: counts[status]})
+		}
+	}
+	return statuses
+}
+
+// pickComparedMetric picks the metric two experiments are compared by when
+// none was asked for: the one experiment A's best checkpoints are chosen
+// by, else the first metric both logged named like a loss, else the first
+// metric both logged, else the first metric either logged
+func pickComparedMetric(rule *BestCheckpointRuleRow, keysA, keysB []string) string {
+	all := append(slices.Clone(keysA), keysB...)
+	slices.Sort(all)
+	all = slices.Compact(all)
+	if rule != nil && slices.Contains(all, rule.MetricKey) {
+		return rule.MetricKey
+	}
+	var common []string
+	for _, key := range keysA {
+		if slices.Contains(keysB, key) {
+			common = append(common, key)
+		}
+	}
+	slices.Sort(common)
+	for _, key := range common {
+		if strings.Contains(strings.ToLower(key), "loss") {
+			return key
+		}
+	}
+	if len(common) > 0 {
+		return common[0]
+	}
+	if len(all) > 0 {
+		return all[0]
+	}
+	return ""
+}
+
+// loadComparedExperiment loads one side of an experiment comparison, judging
+// runs by metric, lower is better unless maximize
+func loadComparedExperiment(ctx context.Context, experiment *Experiment, experimentID int, metric string, maximize bool) (*ComparedExperiment, error) {
+	runs, err := dao.GetRunsByExperimentID(ctx, experimentID)
+	if err != nil {
+		return nil, fmt.Errorf("querying runs: %w", err)
+	}
+	compared := &ComparedExperiment{
+		UUID:         experiment.UUID,
+		Name:         experiment.Name,
+		RunCount:     len(runs),
+		StatusCounts: countStatuses(runs),
+	}
+	if metric == "" {
+		return compared, nil
+	}
+
+	var bests []float64
+	var series [][]MetricRow
+	for _, run := range runs {
+		runID, err := dao.GetRunIDByUUID(ctx, run.UUID)
+		if err != nil {
+			return nil, fmt.Errorf("querying run %s: %w", run.UUID, err)
+		}
+		best, err := dao.GetBestMetric(ctx, runID, metric, maximize)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("querying best %s of run %s: %w", metric, run.UUID, err)
+		}
+		bests = append(bests, best.YValue)
+		if compared.Best == nil || (maximize && best.YValue > compared.Best.Value) || (!maximize && best.YValue < compared.Best.Value) {
+			compared.Best = &ExperimentBest{RunUUID: run.UUID, RunName: run.Name, Step: best.XValue, Value: best.YValue}
+		}
+		if len(series) < experimentCompareCurveRuns {
+			points, err := dao.GetMetricsDownsampled(ctx, runID, metric, experimentCompareCurvePoints)
+			if err != nil {
+				return nil, fmt.Errorf("querying %s of run %s: %w", metric, run.UUID, err)
+			}
+			series = append(series, points)
+		}
+	}
+	compared.MetricRunCount = len(bests)
+	if len(bests) > 0 {
+		compared.MedianBest = median(bests)
+	}
+	compared.Curve = aggregateLearningCurve(series, experimentCompareCurveBins)
+	compared.CurveRunCount = len(series)
+	return compared, nil
+}
+
+// experimentCompareTemplates are the templates of the experiment comparison
+// page
+var experimentCompareTemplates = registerPage("templates/experiment_compare.html")
+
+// handleCompareExperiments serves the experiment comparison page at
+// /experiments/compare?a={uuid}&b={uuid}&metric={key}&mode=min|max. It puts
+// two experiments side by side: their run counts, the best value of a
+// metric, how their runs set each parameter and the metric's learning curve
+// aggregated across runs. Without both experiments it offers a form to pick
+// them.
+func handleCompareExperiments(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	query := r.URL.Query()
+	uuidA, uuidB := query.Get("a"), query.Get("b")
+	metric, mode := query.Get("metric"), query.Get("mode")
+	if mode != "" && mode != bestCheckpointModeMin && mode != bestCheckpointModeMax {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "mode must be min or max")
+		return nil
+	}
+
+	experiments, err := dao.GetAllExperiments(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to query experiments: %w", err)
+	}
+	data := struct {
+		Title       string
+		Experiments []Experiment
+		AUUID       string
+		BUUID       string
+		Metric      string
+		Mode        string
+		MetricKeys  []string
+		A           *ComparedExperiment
+		B           *ComparedExperiment
+		Parameters  []ParameterDistribution
+	}{
+		Title:       "Compare experiments",
+		Experiments: experiments,
+		AUUID:       uuidA,
+		BUUID:       uuidB,
+	}
+
+	if uuidA != "" && uuidB != "" {
+		var ids [2]int
+		var exps [2]*Experiment
+		var keys [2][]string
+		var params [2]map[string]ParameterSummary
+		for i, uuid := range []string{uuidA, uuidB} {
+			exps[i], err = dao.GetExperimentByUUID(ctx, uuid)
+			if err != nil {
+				w.WriteHeader(http.StatusNotFound)
+				fmt.Fprintf(w, "Experiment %s not found", uuid)
+				return nil
+			}
+			ids[i], err = dao.GetExperimentIDByUUID(ctx, uuid)
+			if err != nil {
+				w.WriteHeader(http.StatusNotFound)
+				fmt.Fprintf(w, "Experiment %s not found", uuid)
+				return nil
+			}
+			schemaKeys, err := dao.GetMetricKeys(ctx, ids[i], 0)
+			if err != nil {
+				return fmt.Errorf("failed to query metric keys of experiment %s: %w", uuid, err)
+			}
+			for _, k := range schemaKeys {
+				keys[i] = append(keys[i], k.Key)
+			}
+			rows, err := dao.GetParametersByExperimentID(ctx, ids[i])
+			if err != nil {
+				return fmt.Errorf("failed to query parameters of experiment %s: %w", uuid, err)
+			}
+			params[i] = summarizeParameters(rows)
+		}
+
+		rule, err := dao.GetBestCheckpointRule(ctx, ids[0])
+		if err != nil {
+			return fmt.Errorf("failed to query best checkpoint rule of experiment %s: %w", uuidA, err)
+		}
+		if metric == "" {
+			metric = pickComparedMetric(rule, keys[0], keys[1])
+		}
+		if mode == "" {
+			mode = bestCheckpointModeMin
+			if rule != nil && rule.MetricKey == metric {
+				mode = rule.Mode
+			}
+		}
+
+		var compared [2]*ComparedExperiment
+		for i := range compared {
+			compared[i], err = loadComparedExperiment(ctx, exps[i], ids[i], metric, mode == bestCheckpointModeMax)
+			if err != nil {
+				return fmt.Errorf("failed to compare experiment %s: %w", exps[i].UUID, err)
+			}
+		}
+
+		metricKeys := append(keys[0], keys[1]...)
+		slices.Sort(metricKeys)
+		data.MetricKeys = slices.Compact(metricKeys)
+		data.Metric, data.Mode = metric, mode
+		data.A, data.B = compared[0], compared[1]
+		data.Parameters = compareParameters(params[0], params[1])
+		data.Title = fmt.Sprintf("%s vs. %s", exps[0].Name, exps[1].Name)
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	tmpl, err := experimentCompareTemplates.Get()
+	if err != nil {
+		return fmt.Errorf("failed to parse template: %w", err)
+	}
+	return executeTemplate(w, tmpl, "experiment_compare.html", data)
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// experimentCompareDAO holds two experiments, old (1) and new (2), whose runs
+// log loss. Runs are numbered by experiment: old-a is 10, new-a is 20.
+type experimentCompareDAO struct {
+	DAO
+	series map[int][]MetricRow
+}
+
+var experimentCompareIDs = map[string]int{"old": 1, "new": 2, "old-a": 10, "old-b": 11, "new-a": 20}
+
+func (d *experimentCompareDAO) GetAllExperiments(ctx context.Context) ([]Experiment, error) {
+	return []Experiment{{UUID: "old", Name: "Old architecture"}, {UUID: "new", Name: "New architecture"}}, nil
+}
+
+func (d *experimentCompareDAO) GetExperimentByUUID(ctx context.Context, uuid string) (*Experiment, error) {
+	switch uuid {
+	case "old":
+		return &Experiment{UUID: "old", Name: "Old architecture"}, nil
+	case "new":
+		return &Experiment{UUID: "new", Name: "New architecture"}, nil
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (d *experimentCompareDAO) GetExperimentIDByUUID(ctx context.Context, uuid string) (int, error) {
+	return experimentCompareIDs[uuid], nil
+}
+
+func (d *experimentCompareDAO) GetRunIDByUUID(ctx context.Context, uuid string) (int, error) {
+	return experimentCompareIDs[uuid], nil
+}
+
+func (d *experimentCompareDAO) GetRunsByExperimentID(ctx context.Context, experimentID int) ([]Run, error) {
+	if experimentID == 1 {
+		return []Run{{UUID: "old-a", Name: "old a", Status: runStatusFinished}, {UUID: "old-b", Name: "old b", Status: runStatusFailed}}, nil
+	}
+	return []Run{{UUID: "new-a", Name: "new a", Status: runStatusRunning}}, nil
+}
+
+func (d *experimentCompareDAO) GetMetricKeys(ctx context.Context, experimentID, projectID int) ([]SchemaKeyRow, error) {
+	return []SchemaKeyRow{{Key: "accuracy"}, {Key: "loss"}}, nil
+}
+
+func (d *experimentCompareDAO) GetParametersByExperimentID(ctx context.Context, experimentID int) ([]ExperimentParameterRow, error) {
+	if experimentID == 1 {
+		return []ExperimentParameterRow{
+			{RunID: 10, ParameterRow: ParameterRow{Key: "layers", ValueType: "int", ValueInt: sql.NullInt64{Int64: 4, Valid: true}}},
+		}, nil
+	}
+	return []ExperimentParameterRow{
+		{RunID: 20, ParameterRow: ParameterRow{Key: "attention", ValueType: "string", ValueString: sql.NullString{String: "flash", Valid: true}}},
+	}, nil
+}
+
+func (d *experimentCompareDAO) GetBestCheckpointRule(ctx context.Context, experimentID int) (*BestCheckpointRuleRow, error) {
+	return nil, nil
+}
+
+func (d *experimentCompareDAO) GetBestMetric(ctx context.Context, runID int, key string, maximize bool) (*MetricRow, error) {
+	series := d.series[runID]
+	if key != "loss" || len(series) == 0 {
+		return nil, sql.ErrNoRows
+	}
+	best := series[0]
+	for _, m := range series {
+		if (maximize && m.YValue > best.YValue) || (!maximize && m.YValue < best.YValue) {
+			best = m
+		}
+	}
+	return &best, nil
+}
+
+func (d *experimentCompareDAO) GetMetricsDownsampled(ctx context.Context, runID int, key string, maxPoints int) ([]MetricRow, error) {
+	return d.series[runID], nil
+}
+
+func TestAggregateLearningCurve(t *testing.T) {
+	if curve := aggregateLearningCurve(nil, 10); curve != nil {
+		t.Errorf("Expected no curve without series, got %+v", curve)
+	}
+
+	// The second run stops early and logs more often; each run counts once
+	// per interval regardless
+	curve := aggregateLearningCurve([][]MetricRow{
+		{{XValue: 0, YValue: 4}, {XValue: 10, YValue: 2}},
+		{{XValue: 0, YValue: 2}, {XValue: 1, YValue: 2}, {XValue: 2, YValue: 5}},
+	}, 2)
+	want := []AggregatePoint{
+		{Step: 0.75, Mean: 3.5, Min: 3, Max: 4, Runs: 2},
+		{Step: 10, Mean: 2, Min: 2, Max: 2, Runs: 1},
+	}
+	if !reflect.DeepEqual(curve, want) {
+		t.Errorf("Expected %+v, got %+v", want, curve)
+	}
+
+	curve = aggregateLearningCurve([][]MetricRow{{{XValue: 5, YValue: 1}}, {{XValue: 5, YValue: 3}}}, 10)
+	if len(curve) != 1 || curve[0].Step != 5 || curve[0].Mean != 2 {
+		t.Errorf("Expected series logged at a single step aggregated to one point, got %+v", curve)
+	}
+}
+
+func TestSummarizeParameters(t *testing.T) {
+	row := func(runID int, p ParameterRow) ExperimentParameterRow {
+		return ExperimentParameterRow{RunID: runID, ParameterRow: p}
+	}
+	lr := func(v float64) ParameterRow {
+		return ParameterRow{Key: "lr", ValueType: "float", ValueFloat: sql.NullFloat64{Float64: v, Valid: true}}
+	}
+	opt := func(v string) ParameterRow {
+		return ParameterRow{Key: "optimizer", ValueType: "string", ValueString: sql.NullString{String: v, Valid: true}}
+	}
+	summaries := summarizeParameters([]ExperimentParameterRow{
+		row(1, lr(0.1)), row(2, lr(0.001)), row(3, lr(0.01)),
+		row(1, opt("sgd")), row(2, opt("adam")), row(3, opt("adam")),
+		row(4, opt("a")), row(5, opt("b")), row(6, opt("c")), row(7, opt("d")), row(8, opt("e")),
+	})
+
+	if got := summaries["lr"]; !got.Numeric || got.Min != 0.001 || got.Median != 0.01 || got.Max != 0.1 || got.Runs != 3 {
+		t.Errorf("Expected lr summarized by its range and median, got %+v", got)
+	}
+	got := summaries["optimizer"]
+	if got.Numeric || got.Runs != 8 || len(got.Values) != experimentCompareTopValues || got.OtherValues != 2 {
+		t.Fatalf("Expected the optimizer's top values listed, got %+v", got)
+	}
+	if got.Values[0] != (ParameterValueCount{Value: "adam", Count: 2}) || got.Values[1].Value != "a" {
+		t.Errorf("Expected values by count, then alphabetically, got %+v", got.Values)
+	}
+}
+
+func TestPickComparedMetric(t *testing.T) {
+	keysA := []string{"accuracy", "train/loss", "val/loss"}
+	keysB := []string{"accuracy", "val/loss"}
+	if got := pickComparedMetric(&BestCheckpointRuleRow{MetricKey: "accuracy"}, keysA, keysB); got != "accuracy" {
+		t.Errorf("Expected the best checkpoint rule's metric, got %q", got)
+	}
+	if got := pickComparedMetric(&BestCheckpointRuleRow{MetricKey: "gone"}, keysA, keysB); got != "val/loss" {
+		t.Errorf("Expected the first common loss, got %q", got)
+	}
+	if got := pickComparedMetric(nil, []string{"b", "a"}, []string{"a", "b"}); got != "a" {
+		t.Errorf("Expected the first common metric, got %q", got)
+	}
+	if got := pickComparedMetric(nil, []string{"b"}, []string{"a"}); got != "a" {
+		t.Errorf("Expected the first metric of either, got %q", got)
+	}
+	if got := pickComparedMetric(nil, nil, nil); got != "" {
+		t.Errorf("Expected no metric, got %q", got)
+	}
+}
+
+func TestHandleCompareExperiments(t *testing.T) {
+	defer func(d DAO) { dao = d }(dao)
+	dao = &experimentCompareDAO{series: map[int][]MetricRow{
+		10: {{Key: "loss", XValue: 0, YValue: 2}, {Key: "loss", XValue: 100, YValue: 0.5}},
+		11: {{Key: "loss", XValue: 0, YValue: 3}},
+		20: {{Key: "loss", XValue: 0, YValue: 1.5}, {Key: "loss", XValue: 100, YValue: 0.25}},
+	}}
+
+	compare := func(query string) (int, string) {
+		w := httptest.NewRecorder()
+		errorHandler(handleCompareExperiments).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/experiments/compare"+query, nil))
+		return w.Code, w.Body.String()
+	}
+
+	code, body := compare("")
+	if code != http.StatusOK || !strings.Contains(body, "Pick two experiments") {
+		t.Errorf("Expected a form to pick experiments, got %d", code)
+	}
+
+	code, body = compare("?a=old&b=new")
+	if code != http.StatusOK {
+		t.Fatalf("Expected the comparison, got %d: %s", code, body)
+	}
+	for _, want := range []string{
+		"Best loss (min)",
+		`<a href="/runs/old-a">old a</a> at step 100`,
+		`<a href="/runs/new-a">new a</a> at step 100`,
+		"1 FAILED",
+		"1 RUNNING",
+		"layers",
+		"flash",
+		"experiment-compare-curve",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Expected the comparison to contain %q", want)
+		}
+	}
+
+	if code, _ := compare("?a=old&b=missing"); code != http.StatusNotFound {
+		t.Errorf("Expected an unknown experiment not found, got %d", code)
+	}
+	if code, _ := compare("?a=old&b=new&mode=best"); code != http.StatusBadRequest {
+		t.Errorf("Expected an unknown mode rejected, got %d", code)
+	}
+}
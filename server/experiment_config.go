@@ -0,0 +1,390 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// experimentConfigVersion is the version of the experiment config format
+const experimentConfigVersion = 1
+
+// errAmbiguousExperimentName is returned when a config cannot be applied
+// because several experiments share its name
+var errAmbiguousExperimentName = errors.New("more than one experiment has this name")
+
+// ExperimentConfig is the portable configuration of an experiment, exported
+// and imported as YAML so tracking setups can be managed as code. It holds
+// settings only, never runs or their data.
+type ExperimentConfig struct {
+	Version        int                        `yaml:"version"`
+	Name           string                     `yaml:"name"`
+	Readme         string                     `yaml:"readme,omitempty"`
+	Notifications  []NotificationConfigEntry  `yaml:"notifications,omitempty"`
+	CostRates      []CostRateConfigEntry      `yaml:"cost_rates,omitempty"`
+	MetricSchema   []MetricSchemaConfigEntry  `yaml:"metric_schema,omitempty"`
+	BestCheckpoint *BestCheckpointConfigEntry `yaml:"best_checkpoint,omitempty"`
+}
+
+// NotificationConfigEntry is a notification subscription scoped to the experiment
+type NotificationConfigEntry struct {
+	Channel string   `yaml:"channel"`
+	Target  string   `yaml:"target"`
+	Events  []string `yaml:"events,omitempty"`
+	Tag     string   `yaml:"tag,omitempty"`
+}
+
+// CostRateConfigEntry is a rate of the experiment's cost model
+type CostRateConfigEntry struct {
+	MachineType   string  `yaml:"machine_type"`
+	USDPerGPUHour float64 `yaml:"usd_per_gpu_hour"`
+}
+
+// MetricSchemaConfigEntry is a metric key, or pattern of keys, declared in
+// the experiment's metric schema, with the steps it may be logged at
+type MetricSchemaConfigEntry struct {
+	Key     string   `yaml:"key"`
+	MinStep *float64 `yaml:"min_step,omitempty"`
+	MaxStep *float64 `yaml:"max_step,omitempty"`
+}
+
+func (e MetricSchemaConfigEntry) entry() MetricSchemaEntryRow {
+	entry := MetricSchemaEntryRow{Key: e.Key}
+	if e.MinStep != nil {
+		entry.MinStep = sql.NullFloat64{Float64: *e.MinStep, Valid: true}
+	}
+	if e.MaxStep != nil {
+		entry.MaxStep = sql.NullFloat64{Float64: *e.MaxStep, Valid: true}
+	}
+	return entry
+}
+
+// BestCheckpointConfigEntry is the rule the experiment's runs choose their
+// best checkpoint by
+type BestCheckpointConfigEntry struct {
+	Metric    string `yaml:"metric"`
+	Mode      string `yaml:"mode"`
+	Artifacts string `yaml:"artifacts"`
+}
+
+func (e BestCheckpointConfigEntry) rule() BestCheckpointRuleRow {
+	return BestCheckpointRuleRow{MetricKey: e.Metric, Mode: e.Mode, ArtifactPattern: e.Artifacts}
+}
+
+// parseExperimentConfig decodes and validates a YAML experiment config.
+// Unknown fields are rejected so that typos are not silently ignored.
+func parseExperimentConfig(r io.Reader) (*ExperimentConfig, error) {
+	decoder := yaml.NewDecoder(r)
+	decoder.KnownFields(true)
+
+	var config ExperimentConfig
+	if err := decoder.Decode(&config); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("config is empty")
+		}
+		return nil, err
+	}
+
+	if config.Version != experimentConfigVersion {
+		return nil, fmt.Errorf("unsupported config version %d (expected %d)", config.Version, experimentConfigVersion)
+	}
+	if strings.TrimSpace(config.Name) == "" {
+		return nil, fmt.Errorf("name is required")
+	}
+	for i, n := range config.Notifications {
+		if err := validateNotificationSubscription(n.Channel, n.Target, n.Events); err != nil {
+			return nil, fmt.Errorf("notifications[%d]: %w", i, err)
+		}
+	}
+	machineTypes := make(map[string]bool)
+	for i, c := range config.CostRates {
+		if err := validateCostRate(c.MachineType, c.USDPerGPUHour); err != nil {
+			return nil, fmt.Errorf("cost_rates[%d]: %w", i, err)
+		}
+		if machineTypes[c.MachineType] {
+			return nil, fmt.Errorf("cost_rates[%d]: duplicate machine type %q", i, c.MachineType)
+		}
+		machineTypes[c.MachineType] = true
+	}
+	metricKeys := make(map[string]bool)
+	for i, m := range config.MetricSchema {
+		if err := validateMetricSchemaEntry(m.entry()); err != nil {
+			return nil, fmt.Errorf("metric_schema[%d]: %w", i, err)
+		}
+		if metricKeys[m.Key] {
+			return nil, fmt.Errorf("metric_schema[%d]: duplicate key %q", i, m.Key)
+		}
+		metricKeys[m.Key] = true
+	}
+	if config.BestCheckpoint != nil {
+		if err := validateBestCheckpointRule(config.BestCheckpoint.rule()); err != nil {
+			return nil, fmt.Errorf("best_checkpoint: %w", err)
+		}
+	}
+	return &config, nil
+}
+
+// exportExperimentConfig builds the config of an existing experiment
+func exportExperimentConfig(ctx context.Context, experimentUUID string) (*ExperimentConfig, error) {
+	experiment, err := dao.GetExperimentByUUID(ctx, experimentUUID)
+	if err != nil {
+		return nil, err
+	}
+	experimentID, err := dao.GetExperimentIDByUUID(ctx, experimentUUID)
+	if err != nil {
+		return nil, err
+	}
+	subs, err := getExperimentNotificationSubscriptions(ctx, experimentID)
+	if err != nil {
+		return nil, err
+	}
+	rates, err := getExperimentCostRates(ctx, experimentID)
+	if err != nil {
+		return nil, err
+	}
+	schema, err := dao.GetMetricSchema(ctx, experimentID)
+	if err != nil {
+		return nil, err
+	}
+	rule, err := dao.GetBestCheckpointRule(ctx, experimentID)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &ExperimentConfig{
+		Version: experimentConfigVersion,
+		Name:    experiment.Name,
+		Readme:  experiment.Readme,
+	}
+	for _, sub := range subs {
+		config.Notifications = append(config.Notifications, NotificationConfigEntry{
+			Channel: sub.Channel,
+			Target:  sub.Target,
+			Events:  sub.Events,
+			Tag:     sub.Tag,
+		})
+	}
+	for _, rate := range rates {
+		config.CostRates = append(config.CostRates, CostRateConfigEntry{
+			MachineType:   rate.MachineType,
+			USDPerGPUHour: rate.USDPerGPUHour,
+		})
+	}
+	for _, e := range schema {
+		m := MetricSchemaConfigEntry{Key: e.Key}
+		if e.MinStep.Valid {
+			m.MinStep = &e.MinStep.Float64
+		}
+		if e.MaxStep.Valid {
+			m.MaxStep = &e.MaxStep.Float64
+		}
+		config.MetricSchema = append(config.MetricSchema, m)
+	}
+	if rule != nil {
+		config.BestCheckpoint = &BestCheckpointConfigEntry{
+			Metric:    rule.MetricKey,
+			Mode:      rule.Mode,
+			Artifacts: rule.ArtifactPattern,
+		}
+	}
+	return config, nil
+}
+
+// applyExperimentConfig imports a config. The experiment with the config's
+// name is updated, or created if there is none, so applying the same config
+// repeatedly is idempotent. The experiment's notification subscriptions,
+// cost rates, metric schema, and best checkpoint rule are replaced by the
+// config's. Returns the experiment UUID and whether it was created.
+func applyExperimentConfig(ctx context.Context, config *ExperimentConfig) (string, bool, error) {
+	experiments, err := dao.GetAllExperiments(ctx)
+	if err != nil {
+		return "", false, err
+	}
+
+	var matches []Experiment
+	for _, e := range experiments {
+		if e.Name == config.Name {
+			matches = append(matches, e)
+		}
+	}
+	if len(matches) > 1 {
+		return "", false, fmt.Errorf("%w: %q", errAmbiguousExperimentName, config.Name)
+	}
+
+	created := len(matches) == 0
+	var experimentUUID string
+	if created {
+		experimentUUID = newUUID(ctx)
+		if err := dao.InsertExperiment(ctx, experimentUUID, config.Name); err != nil {
+			return "", false, err
+		}
+	} else {
+		experimentUUID = matches[0].UUID
+	}
+
+	experiment, err := dao.GetExperimentByUUID(ctx, experimentUUID)
+	if err != nil {
+		return "", false, err
+	}
+	experimentID, err := dao.GetExperimentIDByUUID(ctx, experimentUUID)
+	if err != nil {
+		return "", false, err
+	}
+
+	// Only record a README revision when the README actually changes
+	if experiment.Readme != config.Readme {
+		if err := dao.UpdateExperimentReadme(ctx, experimentID, config.Readme); err != nil {
+			return "", false, err
+		}
+	}
+
+	subs, err := getExperimentNotificationSubscriptions(ctx, experimentID)
+	if err != nil {
+		return "", false, err
+	}
+	for _, sub := range subs {
+		if err := dao.DeleteNotificationSubscription(ctx, sub.ID); err != nil {
+			return "", false, err
+		}
+	}
+	for _, n := range config.Notifications {
+		err := dao.InsertNotificationSubscription(ctx, NotificationSubscriptionRow{
+			ExperimentID: sql.NullInt64{Int64: int64(experimentID), Valid: true},
+			Channel:      n.Channel,
+			Target:       n.Target,
+			Events:       strings.Join(n.Events, ","),
+			Tag:          n.Tag,
+		})
+		if err != nil {
+			return "", false, err
+		}
+	}
+
+	rates, err := getExperimentCostRates(ctx, experimentID)
+	if err != nil {
+		return "", false, err
+	}
+	for _, rate := range rates {
+		if err := dao.DeleteExperimentCostRate(ctx, experimentID, rate.MachineType); err != nil {
+			return "", false, err
+		}
+	}
+	for _, c := range config.CostRates {
+		if err := dao.UpsertExperimentCostRate(ctx, experimentID, c.MachineType, c.USDPerGPUHour); err != nil {
+			return "", false, err
+		}
+	}
+
+	schema, err := dao.GetMetricSchema(ctx, experimentID)
+	if err != nil {
+		return "", false, err
+	}
+	for _, e := range schema {
+		if err := dao.DeleteMetricSchemaEntry(ctx, experimentID, e.Key); err != nil {
+			return "", false, err
+		}
+	}
+	for _, m := range config.MetricSchema {
+		if err := dao.UpsertMetricSchemaEntry(ctx, experimentID, m.entry()); err != nil {
+			return "", false, err
+		}
+	}
+
+	if config.BestCheckpoint != nil {
+		err = dao.UpsertBestCheckpointRule(ctx, experimentID, config.BestCheckpoint.rule())
+	} else {
+		err = dao.DeleteBestCheckpointRule(ctx, experimentID)
+	}
+	if err != nil {
+		return "", false, err
+	}
+
+	return experimentUUID, created, nil
+}
+
+func handleAPIExperimentConfig(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		handleAPIExportExperimentConfig(w, r)
+	case http.MethodPost:
+		handleAPIImportExperimentConfig(w, r)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func handleAPIExportExperimentConfig(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	experimentUUID := r.URL.Query().Get("experiment_uuid")
+	if experimentUUID == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Missing required field: experiment_uuid"})
+		return
+	}
+
+	if _, err := dao.GetExperimentIDByUUID(ctx, experimentUUID); err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Experiment not found"})
+		return
+	}
+
+	config, err := exportExperimentConfig(ctx, experimentUUID)
+	if err != nil {
+		log.Printf("Failed to export config for experiment %s: %v", experimentUUID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to export config"})
+		return
+	}
+
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(config); err != nil {
+		log.Printf("Failed to encode config for experiment %s: %v", experimentUUID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to export config"})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/yaml")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", experimentUUID+".yaml"))
+	w.Write(buf.Bytes())
+}
+
+func handleAPIImportExperimentConfig(w http.ResponseWriter, r *http.Request) {
+	config, err := parseExperimentConfig(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": fmt.Sprintf("Invalid config: %v", err)})
+		return
+	}
+
+	experimentUUID, created, err := applyExperimentConfig(r.Context(), config)
+	if errors.Is(err, errAmbiguousExperimentName) {
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]string{"error": fmt.Sprintf("Failed to import config: %v", err)})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to import config for experiment %q: %v", config.Name, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": fmt.Sprintf("Failed to import config: %v", err)})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":          "ok",
+		"experiment_uuid": experimentUUID,
+		"created":         created,
+	})
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestParseExperimentConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		wantErr string
+	}{
+		{
+			name: "valid config",
+			src: `version: 1
+name: lr-sweep
+readme: |
+  # Goals
+notifications:
+  - channel: slack
+    target: https://hooks.slack.com/services/T/B/X
+    events: [run_created]
+    tag: production
+cost_rates:
+  - machine_type: a100
+    usd_per_gpu_hour: 3.2
+metric_schema:
+  - key: val_accuracy
+    min_step: 0
+    max_step: 10000
+  - key: train/*
+best_checkpoint:
+  metric: val_loss
+  mode: min
+  artifacts: checkpoints/*.pt
+`,
+		},
+		{name: "empty", src: "", wantErr: "empty"},
+		{name: "missing name", src: "version: 1\n", wantErr: "name is required"},
+		{name: "wrong version", src: "version: 2\nname: x\n", wantErr: "unsupported config version"},
+		{name: "unknown field", src: "version: 1\nname: x\nretention: 30d\n", wantErr: "retention"},
+		{
+			name:    "invalid notification",
+			src:     "version: 1\nname: x\nnotifications:\n  - channel: pager\n    target: x\n",
+			wantErr: "notifications[0]",
+		},
+		{
+			name:    "negative cost rate",
+			src:     "version: 1\nname: x\ncost_rates:\n  - machine_type: a100\n    usd_per_gpu_hour: -1\n",
+			wantErr: "cost_rates[0]",
+		},
+		{
+			name:    "duplicate cost rate",
+			src:     "version: 1\nname: x\ncost_rates:\n  - machine_type: a100\n    usd_per_gpu_hour: 1\n  - machine_type: a100\n    usd_per_gpu_hour: 2\n",
+			wantErr: "duplicate machine type",
+		},
+		{
+			name:    "empty metric step range",
+			src:     "version: 1\nname: x\nmetric_schema:\n  - key: loss\n    min_step: 10\n    max_step: 1\n",
+			wantErr: "metric_schema[0]",
+		},
+		{
+			name:    "duplicate metric key",
+			src:     "version: 1\nname: x\nmetric_schema:\n  - key: loss\n  - key: loss\n",
+			wantErr: "duplicate key",
+		},
+		{
+			name:    "invalid best checkpoint mode",
+			src:     "version: 1\nname: x\nbest_checkpoint:\n  metric: loss\n  mode: lowest\n  artifacts: '*.pt'\n",
+			wantErr: "best_checkpoint",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := parseExperimentConfig(strings.NewReader(tt.src))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("parseExperimentConfig() error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseExperimentConfig() failed: %v", err)
+			}
+			if config.Name != "lr-sweep" || config.Readme != "# Goals\n" || len(config.Notifications) != 1 ||
+				config.Notifications[0].Tag != "production" || len(config.CostRates) != 1 ||
+				config.CostRates[0].USDPerGPUHour != 3.2 || len(config.MetricSchema) != 2 ||
+				*config.MetricSchema[0].MaxStep != 10000 || config.MetricSchema[1].MinStep != nil || config.BestCheckpoint == nil ||
+				config.BestCheckpoint.Artifacts != "checkpoints/*.pt" {
+				t.Errorf("parseExperimentConfig() = %+v", config)
+			}
+		})
+	}
+}
+
+func TestExperimentConfigRoundTrip(t *testing.T) {
+	config := &ExperimentConfig{
+		Version: experimentConfigVersion,
+		Name:    "lr-sweep",
+		Readme:  "Line one\nLine two\n",
+		Notifications: []NotificationConfigEntry{
+			{Channel: "webhook", Target: "https://example.com/hook", Events: []string{"run_created"}},
+		},
+	}
+
+	out, err := yaml.Marshal(config)
+	if err != nil {
+		t.Fatalf("yaml.Marshal failed: %v", err)
+	}
+	parsed, err := parseExperimentConfig(strings.NewReader(string(out)))
+	if err != nil {
+		t.Fatalf("parseExperimentConfig failed on exported config %q: %v", out, err)
+	}
+	if parsed.Name != config.Name || parsed.Readme != config.Readme ||
+		len(parsed.Notifications) != 1 || parsed.Notifications[0].Target != config.Notifications[0].Target {
+		t.Errorf("round trip changed config: got %+v, want %+v", parsed, config)
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// ReadmeRevision is one saved version of an experiment README
+type ReadmeRevision struct {
+	Readme    string
+	CreatedAt string
+}
+
+// UpdateExperimentReadmeRequest is the body of POST /api/experiments/readme
+type UpdateExperimentReadmeRequest struct {
+	ExperimentUUID string `json:"experiment_uuid"`
+	Readme         string `json:"readme"`
+}
+
+func handleAPIUpdateExperimentReadme(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req UpdateExperimentReadmeRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid JSON"})
+		return
+	}
+
+	if req.ExperimentUUID == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Missing required field: experiment_uuid"})
+		return
+	}
+
+	experimentID, err := dao.GetExperimentIDByUUID(ctx, req.ExperimentUUID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Experiment not found"})
+		return
+	}
+
+	err = dao.UpdateExperimentReadme(ctx, experimentID, req.Readme)
+	if err != nil {
+		log.Printf("Failed to update README for experiment %s: %v", req.ExperimentUUID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to update README"})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+
+// readmeTemplates are the templates of the README panel of the experiment page
+var readmeTemplates = registerTemplates("templates/experiment_readme.html")
+
+func handleUpdateExperimentReadme(w http.ResponseWriter, r *http.Request, experimentUUID string) {
+	ctx := r.Context()
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	readme := r.FormValue("readme")
+
+	experimentID, err := dao.GetExperimentIDByUUID(ctx, experimentUUID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "Experiment not found")
+		return
+	}
+
+	err = dao.UpdateExperimentReadme(ctx, experimentID, readme)
+	if err != nil {
+		log.Printf("Failed to update README for experiment %s: %v", experimentUUID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// Return the README fragment for htmx to swap in
+	data := struct {
+		ExperimentUUID string
+		Experiment     *Experiment
+	}{
+		ExperimentUUID: experimentUUID,
+		Experiment:     &Experiment{UUID: experimentUUID, Readme: readme},
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	tmpl, err := readmeTemplates.Get()
+	if err != nil {
+		log.Printf("Failed to parse template: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if err := executeTemplate(w, tmpl, "experiment_readme", data); err != nil {
+		log.Printf("Failed to render template: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// readmeHistoryTemplates are the templates of the README history page
+var readmeHistoryTemplates = registerPage("templates/experiment_readme_history.html")
+
+func handleViewExperimentReadmeHistory(w http.ResponseWriter, r *http.Request, experimentUUID string) {
+	ctx := r.Context()
+	experiment, err := dao.GetExperimentByUUID(ctx, experimentUUID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "Experiment not found")
+		return
+	}
+
+	experimentID, err := dao.GetExperimentIDByUUID(ctx, experimentUUID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "Experiment not found")
+		return
+	}
+
+	revisionRows, err := dao.GetExperimentReadmeRevisions(ctx, experimentID)
+	if err != nil {
+		log.Printf("Failed to get README revisions for experiment %s: %v", experimentUUID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	var revisions []ReadmeRevision
+	for _, rev := range revisionRows {
+		revisions = append(revisions, ReadmeRevision{
+			Readme:    rev.Readme,
+			CreatedAt: rev.CreatedAt.Format(displayTimeLayout),
+		})
+	}
+
+	data := struct {
+		Title      string
+		Experiment *Experiment
+		Revisions  []ReadmeRevision
+	}{
+		Title:      experiment.Name + " README history",
+		Experiment: experiment,
+		Revisions:  revisions,
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	tmpl, err := readmeHistoryTemplates.Get()
+	if err != nil {
+		log.Printf("Failed to parse template: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	err = tmpl.ExecuteTemplate(w, "experiment_readme_history.html", data)
+	if err != nil {
+		log.Printf("Failed to execute template: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// exportRunsLimit is the most runs GET /api/export exports at once
+const exportRunsLimit = 1000
+
+// exportRunColumns are the run metadata columns of an export, before the
+// parameter and metric columns. Those are named params.KEY and metrics.KEY,
+// as in run queries.
+var exportRunColumns = []string{"uuid", "name", "status", "experiment_uuid", "experiment_name"}
+
+// ExportedRun is a run flattened to one row of an export: its metadata,
+// parameters, and the final value of each metric
+type ExportedRun struct {
+	UUID           string
+	Name           string
+	Status         string
+	ExperimentUUID string
+	ExperimentName string
+	Parameters     []ParameterRow
+	Metrics        []MetricRow
+}
+
+// RunExport is a table of runs with a column for every parameter and metric
+// any of them logged
+type RunExport struct {
+	Columns []string
+	Runs    []ExportedRun
+}
+
+// exportURL links to the export of runs in a format, csv or json
+func exportURL(runUUIDs []string, format string) string {
+	query := url.Values{}
+	query.Set("format", format)
+	query.Set("runs", strings.Join(runUUIDs, ","))
+	return "/api/export?" + query.Encode()
+}
+
+// exportRuns loads runs and the columns of their export. Columns are ordered
+// by key within the parameters and the metrics.
+func exportRuns(ctx context.Context, runUUIDs []string) (*RunExport, error) {
+	export := &RunExport{}
+	paramKeys := make(map[string]bool)
+	metricKeys := make(map[string]bool)
+	for _, runUUID := range runUUIDs {
+		run, err := dao.GetRunByUUID(ctx, runUUID)
+		if err != nil {
+			return nil, err
+		}
+		runID, err := dao.GetRunIDByUUID(ctx, runUUID)
+		if err != nil {
+			return nil, err
+		}
+		exported := ExportedRun{UUID: run.UUID, Name: run.Name, Status: run.Status}
+		experiment, err := dao.GetExperimentForRunUUID(ctx, runUUID)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+		if experiment != nil {
+			exported.ExperimentUUID, exported.ExperimentName = experiment.UUID, experiment.Name
+		}
+		if exported.Parameters, err = dao.GetParametersByRunID(ctx, runID); err != nil {
+			return nil, err
+		}
+		// The latest point of each metric is its final value
+		if exported.Metrics, err = dao.GetLatestMetricsByRunID(ctx, runID); err != nil {
+			return nil, err
+		}
+		for _, p := range exported.Parameters {
+			paramKeys[p.Key] = true
+		}
+		for _, m := range exported.Metrics {
+			metricKeys[m.Key] = true
+		}
+		export.Runs = append(export.Runs, exported)
+	}
+
+	export.Columns = append(export.Columns, exportRunColumns...)
+	for _, group := range []struct {
+		prefix string
+		keys   map[string]bool
+	}{{"params.", paramKeys}, {"metrics.", metricKeys}} {
+		keys := make([]string, 0, len(group.keys))
+		for key := range group.keys {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			export.Columns = append(export.Columns, group.prefix+key)
+		}
+	}
+	return export, nil
+}
+
+// values maps each of a run's columns to its value: parameters with their
+// logged type, and metrics as floats
+func (run ExportedRun) values() map[string]interface{} {
+	values := map[string]interface{}{
+		"uuid":            run.UUID,
+		"name":            run.Name,
+		"status":          run.Status,
+		"experiment_uuid": run.ExperimentUUID,
+		"experiment_name": run.ExperimentName,
+	}
+	for _, p := range run.Parameters {
+		values["params."+p.Key] = parameterJSONValue(p)
+	}
+	for _, m := range run.Metrics {
+		values["metrics."+m.Key] = m.YValue
+	}
+	return values
+}
+
+// writeExportCSV writes an export as CSV, with a header row of its columns.
+// Parameters and metrics a run did not log are left empty.
+func writeExportCSV(w *csv.Writer, export *RunExport) error {
+	if err := w.Write(export.Columns); err != nil {
+		return err
+	}
+	record := make([]string, len(export.Columns))
+	for _, run := range export.Runs {
+		values := run.values()
+		for i, column := range export.Columns {
+			record[i] = ""
+			switch v := values[column].(type) {
+			case string:
+				record[i] = v
+			case float64:
+				record[i] = strconv.FormatFloat(v, 'g', -1, 64)
+			case nil:
+			default:
+				record[i] = fmt.Sprint(v)
+			}
+		}
+		if err := w.Write(record); err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
+
+// handleAPIExport exports runs as a table of their metadata, parameters and
+// final metric values, at GET /api/export?runs=UUID,UUID&format=csv|json, for
+// analysis in pandas or a spreadsheet. JSON exports are {"columns": [...],
+// "runs": [{column: value}]}, leaving out the parameters and metrics a run
+// did not log.
+func handleAPIExport(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	query := r.URL.Query()
+	format := query.Get("format")
+	if format == "" {
+		format = "csv"
+	}
+	if format != "csv" && format != "json" {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "format must be csv or json"})
+		return
+	}
+
+	var runUUIDs []string
+	seen := make(map[string]bool)
+	for _, list := range query["runs"] {
+		for _, runUUID := range strings.Split(list, ",") {
+			if runUUID = strings.TrimSpace(runUUID); runUUID != "" && !seen[runUUID] {
+				seen[runUUID] = true
+				runUUIDs = append(runUUIDs, runUUID)
+			}
+		}
+	}
+	if len(runUUIDs) == 0 || len(runUUIDs) > exportRunsLimit {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": fmt.Sprintf("runs must list from 1 to %d run UUIDs", exportRunsLimit)})
+		return
+	}
+	// The runs are in a list the project scope middleware doesn't look in
+	for _, runUUID := range runUUIDs {
+		runID, err := dao.GetRunIDByUUID(ctx, runUUID)
+		found := err == nil
+		if found {
+			found, err = requestCanAccessRun(r, runID)
+		}
+		if !found || err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Run not found: " + runUUID})
+			return
+		}
+	}
+
+	export, err := exportRuns(ctx, runUUIDs)
+	if err != nil {
+		log.Printf("Failed to export runs: %v", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to export runs"})
+		return
+	}
+
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=runs.%s", format))
+	if format == "json" {
+		rows := make([]map[string]interface{}, 0, len(export.Runs))
+		for _, run := range export.Runs {
+			rows = append(rows, run.values())
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"columns": export.Columns, "runs": rows})
+		return
+	}
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	if err := writeExportCSV(csv.NewWriter(w), export); err != nil {
+		log.Printf("Failed to send export: %v", err)
+	}
+}
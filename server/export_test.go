@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// exportDAO has two runs in the Default project, one with an experiment
+type exportDAO struct {
+	defaultProjectDAO
+}
+
+func (d exportDAO) GetRunIDByUUID(ctx context.Context, uuid string) (int, error) {
+	switch uuid {
+	case "run-1":
+		return 1, nil
+	case "run-2":
+		return 2, nil
+	}
+	return 0, sql.ErrNoRows
+}
+
+func (d exportDAO) GetRunByUUID(ctx context.Context, uuid string) (*Run, error) {
+	return &Run{UUID: uuid, Name: "name of " + uuid, Status: "finished"}, nil
+}
+
+func (d exportDAO) GetExperimentForRunUUID(ctx context.Context, runUUID string) (*Experiment, error) {
+	if runUUID != "run-1" {
+		return nil, sql.ErrNoRows
+	}
+	return &Experiment{UUID: "exp-1", Name: "sweep"}, nil
+}
+
+func (d exportDAO) GetParametersByRunID(ctx context.Context, runID int) ([]ParameterRow, error) {
+	if runID != 1 {
+		return []ParameterRow{{Key: "lr", ValueType: "float", ValueFloat: sql.NullFloat64{Float64: 0.01, Valid: true}}}, nil
+	}
+	return []ParameterRow{
+		{Key: "optimizer", ValueType: "string", ValueString: sql.NullString{String: "adam, beta", Valid: true}},
+		{Key: "lr", ValueType: "float", ValueFloat: sql.NullFloat64{Float64: 0.001, Valid: true}},
+		{Key: "epochs", ValueType: "int", ValueInt: sql.NullInt64{Int64: 10, Valid: true}},
+	}, nil
+}
+
+func (d exportDAO) GetLatestMetricsByRunID(ctx context.Context, runID int) ([]MetricRow, error) {
+	if runID != 1 {
+		return nil, nil
+	}
+	return []MetricRow{{Key: "loss", YValue: 0.25}}, nil
+}
+
+func TestHandleAPIExport(t *testing.T) {
+	defer func(d DAO) { dao = d }(dao)
+	dao = exportDAO{}
+
+	export := func(r *http.Request) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handleAPIExport(w, r)
+		return w
+	}
+
+	w := export(httptest.NewRequest(http.MethodGet, "/api/export?runs=run-1,run-2", nil))
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/csv") {
+		t.Fatalf("Expected a CSV export, got %d %s", w.Code, w.Body.String())
+	}
+	expected := "uuid,name,status,experiment_uuid,experiment_name,params.epochs,params.lr,params.optimizer,metrics.loss\n" +
+		"run-1,name of run-1,finished,exp-1,sweep,10,0.001,\"adam, beta\",0.25\n" +
+		"run-2,name of run-2,finished,,,,0.01,,\n"
+	if w.Body.String() != expected {
+		t.Errorf("Expected\n%s\ngot\n%s", expected, w.Body.String())
+	}
+
+	w = export(httptest.NewRequest(http.MethodGet, "/api/export?runs=run-2&runs=run-1&format=json", nil))
+	var body struct {
+		Columns []string
+		Runs    []map[string]interface{}
+	}
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("Expected a JSON export, got %d %v", w.Code, err)
+	}
+	if len(body.Runs) != 2 || body.Runs[0]["uuid"] != "run-2" || body.Runs[1]["params.epochs"] != 10.0 || body.Runs[1]["params.optimizer"] != "adam, beta" {
+		t.Errorf("Expected runs in the order listed with typed values, got %+v", body.Runs)
+	}
+	if _, ok := body.Runs[0]["metrics.loss"]; ok {
+		t.Errorf("Expected metrics a run did not log to be left out, got %+v", body.Runs[0])
+	}
+
+	for query, code := range map[string]int{
+		"runs=run-1&format=xlsx": http.StatusBadRequest,
+		"runs=":                  http.StatusBadRequest,
+		"runs=run-1,missing":     http.StatusNotFound,
+	} {
+		if w := export(httptest.NewRequest(http.MethodGet, "/api/export?"+query, nil)); w.Code != code {
+			t.Errorf("Expected %d for %s, got %d", code, query, w.Code)
+		}
+	}
+
+	// Runs are in the Default project, which a token scoped to another
+	// project can't export
+	r := httptest.NewRequest(http.MethodGet, "/api/export?runs=run-1", nil)
+	r = r.WithContext(context.WithValue(r.Context(), tokenProjectContextKey{}, 2))
+	if w := export(r); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a run outside the token's project, got %d", w.Code)
+	}
+}
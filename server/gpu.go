@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// System metric keys the GPU summary is computed from. Utilization is a
+// percentage averaged over the run's GPUs, memory is bytes in use, and the
+// count is the number of GPUs the run holds (1 if never logged).
+const (
+	gpuUtilizationMetricKey = "system/gpu_utilization"
+	gpuMemoryMetricKey      = "system/gpu_memory_bytes"
+	gpuCountMetricKey       = "system/gpu_count"
+)
+
+// RunGPUSummary aggregates the GPU system metrics of a run so compute cost can
+// be compared across runs and experiments
+type RunGPUSummary struct {
+	UtilizationMean *float64
+	MemoryPeakBytes *int64
+	GPUHours        float64
+}
+
+// isGPUMetricKey reports whether logging key changes a run's GPU summary
+func isGPUMetricKey(key string) bool {
+	return key == gpuUtilizationMetricKey || key == gpuMemoryMetricKey || key == gpuCountMetricKey
+}
+
+// computeRunGPUSummary aggregates the GPU metrics among a run's metrics. It
+// returns nil if the run logged no GPU utilization or memory.
+//
+// GPU-hours is the wall-clock time covered by the utilization samples (or the
+// memory samples, if utilization was never logged) times the GPU count.
+// Intervals between samples longer than metricGapThreshold are left out, so a
+// preempted or hung job is not billed for the time it was down.
+func computeRunGPUSummary(metrics []MetricRow) *RunGPUSummary {
+	var utilization, memory []MetricRow
+	gpuCount := 1.0
+	var gpuCountX float64
+	for _, m := range metrics {
+		switch m.Key {
+		case gpuUtilizationMetricKey:
+			utilization = append(utilization, m)
+		case gpuMemoryMetricKey:
+			memory = append(memory, m)
+		case gpuCountMetricKey:
+			if m.XValue >= gpuCountX && m.YValue > 0 {
+				gpuCount, gpuCountX = m.YValue, m.XValue
+			}
+		}
+	}
+	if len(utilization) == 0 && len(memory) == 0 {
+		return nil
+	}
+
+	summary := &RunGPUSummary{}
+	if len(utilization) > 0 {
+		var total float64
+		for _, m := range utilization {
+			total += m.YValue
+		}
+		mean := total / float64(len(utilization))
+		summary.UtilizationMean = &mean
+	}
+	if len(memory) > 0 {
+		peak := memory[0].YValue
+		for _, m := range memory[1:] {
+			peak = max(peak, m.YValue)
+		}
+		peakBytes := int64(peak)
+		summary.MemoryPeakBytes = &peakBytes
+	}
+
+	samples := utilization
+	if len(samples) == 0 {
+		samples = memory
+	}
+	times := make([]time.Time, len(samples))
+	for i, m := range samples {
+		times[i] = m.LoggedAt
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+	var active time.Duration
+	for i := 1; i < len(times); i++ {
+		if elapsed := times[i].Sub(times[i-1]); elapsed <= metricGapThreshold {
+			active += elapsed
+		}
+	}
+	summary.GPUHours = active.Hours() * gpuCount
+
+	return summary
+}
+
+// updateRunGPUSummary recomputes and stores the GPU summary of a run
+func updateRunGPUSummary(ctx context.Context, runID int) error {
+	metrics, err := dao.GetMetricsByRunID(ctx, runID)
+	if err != nil {
+		return err
+	}
+	summary := computeRunGPUSummary(metrics)
+	if summary == nil {
+		return nil
+	}
+
+	row := RunGPUSummaryRow{RunID: runID, GPUHours: summary.GPUHours}
+	if summary.UtilizationMean != nil {
+		row.UtilizationMean.Float64, row.UtilizationMean.Valid = *summary.UtilizationMean, true
+	}
+	if summary.MemoryPeakBytes != nil {
+		row.MemoryPeakBytes.Float64, row.MemoryPeakBytes.Valid = float64(*summary.MemoryPeakBytes), true
+	}
+	return dao.UpsertRunGPUSummary(ctx, row)
+}
+
+// runGPUSummaryFromRow converts a stored summary to its domain type
+func runGPUSummaryFromRow(row RunGPUSummaryRow) *RunGPUSummary {
+	summary := &RunGPUSummary{GPUHours: row.GPUHours}
+	if row.UtilizationMean.Valid {
+		mean := row.UtilizationMean.Float64
+		summary.UtilizationMean = &mean
+	}
+	if row.MemoryPeakBytes.Valid {
+		peak := int64(row.MemoryPeakBytes.Float64)
+		summary.MemoryPeakBytes = &peak
+	}
+	return summary
+}
+
+// getRunGPUSummary loads the GPU summary of a run, or nil if it has none
+func getRunGPUSummary(ctx context.Context, runID int) (*RunGPUSummary, error) {
+	row, err := dao.GetRunGPUSummary(ctx, runID)
+	if err != nil || row == nil {
+		return nil, err
+	}
+	return runGPUSummaryFromRow(*row), nil
+}
+
+// getExperimentGPUSummaries loads the GPU summaries of an experiment's runs,
+// keyed by run ID
+func getExperimentGPUSummaries(ctx context.Context, experimentID int) (map[int]*RunGPUSummary, error) {
+	rows, err := dao.GetRunGPUSummariesByExperimentID(ctx, experimentID)
+	if err != nil {
+		return nil, err
+	}
+	summaries := make(map[int]*RunGPUSummary, len(rows))
+	for _, row := range rows {
+		summaries[row.RunID] = runGPUSummaryFromRow(row)
+	}
+	return summaries, nil
+}
+
+// formatGPUSummary renders a GPU summary on one line for the runs table
+func formatGPUSummary(s *RunGPUSummary) string {
+	if s == nil {
+		return "-"
+	}
+	var parts []string
+	if s.UtilizationMean != nil {
+		parts = append(parts, fmt.Sprintf("%.0f%% util", *s.UtilizationMean))
+	}
+	if s.MemoryPeakBytes != nil {
+		parts = append(parts, formatBytes(*s.MemoryPeakBytes)+" peak")
+	}
+	parts = append(parts, fmt.Sprintf("%.2f GPU-h", s.GPUHours))
+	return strings.Join(parts, ", ")
+}
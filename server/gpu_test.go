@@ -0,0 +1,121 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestComputeRunGPUSummary(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(minutes int) time.Time { return start.Add(time.Duration(minutes) * time.Minute) }
+
+	tests := []struct {
+		name            string
+		metrics         []MetricRow
+		wantNil         bool
+		wantUtilization float64
+		wantMemory      int64
+		wantGPUHours    float64
+	}{
+		{
+			name:    "no GPU metrics",
+			metrics: []MetricRow{{Key: "loss", XValue: 1, YValue: 0.5, LoggedAt: at(0)}},
+			wantNil: true,
+		},
+		{
+			name: "utilization and memory on one GPU",
+			metrics: []MetricRow{
+				{Key: gpuUtilizationMetricKey, XValue: 0, YValue: 50, LoggedAt: at(0)},
+				{Key: gpuUtilizationMetricKey, XValue: 1, YValue: 70, LoggedAt: at(5)},
+				{Key: gpuUtilizationMetricKey, XValue: 2, YValue: 90, LoggedAt: at(10)},
+				{Key: gpuMemoryMetricKey, XValue: 0, YValue: 1 << 30, LoggedAt: at(0)},
+				{Key: gpuMemoryMetricKey, XValue: 1, YValue: 3 << 30, LoggedAt: at(5)},
+				{Key: gpuMemoryMetricKey, XValue: 2, YValue: 2 << 30, LoggedAt: at(10)},
+			},
+			wantUtilization: 70,
+			wantMemory:      3 << 30,
+			wantGPUHours:    10.0 / 60,
+		},
+		{
+			name: "latest GPU count multiplies hours",
+			metrics: []MetricRow{
+				{Key: gpuCountMetricKey, XValue: 0, YValue: 2, LoggedAt: at(0)},
+				{Key: gpuCountMetricKey, XValue: 5, YValue: 4, LoggedAt: at(0)},
+				{Key: gpuUtilizationMetricKey, XValue: 0, YValue: 100, LoggedAt: at(0)},
+				{Key: gpuUtilizationMetricKey, XValue: 1, YValue: 100, LoggedAt: at(6)},
+			},
+			wantUtilization: 100,
+			wantGPUHours:    4 * 6.0 / 60,
+		},
+		{
+			name: "gaps are not billed",
+			metrics: []MetricRow{
+				{Key: gpuUtilizationMetricKey, XValue: 0, YValue: 10, LoggedAt: at(0)},
+				{Key: gpuUtilizationMetricKey, XValue: 1, YValue: 10, LoggedAt: at(6)},
+				{Key: gpuUtilizationMetricKey, XValue: 2, YValue: 10, LoggedAt: at(126)},
+				{Key: gpuUtilizationMetricKey, XValue: 3, YValue: 10, LoggedAt: at(132)},
+			},
+			wantUtilization: 10,
+			wantGPUHours:    12.0 / 60,
+		},
+		{
+			name: "memory only uses memory samples for hours",
+			metrics: []MetricRow{
+				{Key: gpuMemoryMetricKey, XValue: 0, YValue: 100, LoggedAt: at(0)},
+				{Key: gpuMemoryMetricKey, XValue: 1, YValue: 200, LoggedAt: at(3)},
+			},
+			wantMemory:   200,
+			wantGPUHours: 3.0 / 60,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := computeRunGPUSummary(tt.metrics)
+			if tt.wantNil {
+				if got != nil {
+					t.Fatalf("expected no summary, got %+v", got)
+				}
+				return
+			}
+			if got == nil {
+				t.Fatal("expected a summary, got nil")
+			}
+			if tt.wantUtilization != 0 && (got.UtilizationMean == nil || *got.UtilizationMean != tt.wantUtilization) {
+				t.Errorf("UtilizationMean = %v, want %v", got.UtilizationMean, tt.wantUtilization)
+			}
+			if tt.wantUtilization == 0 && got.UtilizationMean != nil {
+				t.Errorf("UtilizationMean = %v, want nil", *got.UtilizationMean)
+			}
+			if tt.wantMemory != 0 && (got.MemoryPeakBytes == nil || *got.MemoryPeakBytes != tt.wantMemory) {
+				t.Errorf("MemoryPeakBytes = %v, want %v", got.MemoryPeakBytes, tt.wantMemory)
+			}
+			if tt.wantMemory == 0 && got.MemoryPeakBytes != nil {
+				t.Errorf("MemoryPeakBytes = %v, want nil", *got.MemoryPeakBytes)
+			}
+			if math.Abs(got.GPUHours-tt.wantGPUHours) > 1e-9 {
+				t.Errorf("GPUHours = %v, want %v", got.GPUHours, tt.wantGPUHours)
+			}
+		})
+	}
+}
+
+func TestFormatGPUSummary(t *testing.T) {
+	mean := 72.4
+	peak := int64(3 << 30)
+	tests := []struct {
+		summary *RunGPUSummary
+		want    string
+	}{
+		{nil, "-"},
+		{&RunGPUSummary{UtilizationMean: &mean, MemoryPeakBytes: &peak, GPUHours: 1.5}, "72% util, 3.0 GiB peak, 1.50 GPU-h"},
+		{&RunGPUSummary{GPUHours: 0.25}, "0.25 GPU-h"},
+	}
+
+	for _, tt := range tests {
+		if got := formatGPUSummary(tt.summary); got != tt.want {
+			t.Errorf("formatGPUSummary(%+v) = %q, want %q", tt.summary, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Grafana charts Apparatus metrics through the endpoints beneath
+// /api/v1/grafana/, without an exporter in between. They implement the
+// SimpleJSON data source protocol (GET /, POST /search, POST /query), and
+// GET /series returns a series as rows, for the Infinity data source. Grafana
+// plots points by when they were logged, so its time range selects the
+// points logged during it.
+//
+// A series is named by a target of the form "{run_uuid}:{key}". Metric keys
+// may contain colons, so the target is split at the first one.
+
+// grafanaSearchLimit is how many series a search lists
+const grafanaSearchLimit = 100
+
+// GrafanaTarget is a series offered to Grafana's query editor
+type GrafanaTarget struct {
+	Text  string `json:"text"`
+	Value string `json:"value"`
+}
+
+// GrafanaTimeSeries is a series as SimpleJSON returns it: each datapoint is
+// [value, epoch milliseconds]
+type GrafanaTimeSeries struct {
+	Target     string       `json:"target"`
+	Datapoints [][2]float64 `json:"datapoints"`
+}
+
+// GrafanaSeriesRow is a point as the Infinity endpoint returns it
+type GrafanaSeriesRow struct {
+	Time  time.Time `json:"time"`
+	X     float64   `json:"x"`
+	Value float64   `json:"value"`
+}
+
+// handleGrafana serves the Grafana data source endpoints beneath
+// /api/grafana/. They are read-only, so are not journaled although the
+// SimpleJSON protocol queries with POST.
+func handleGrafana(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	path := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/api/v1"), "/api")
+	switch strings.TrimPrefix(path, "/grafana/") {
+	case "":
+		// Grafana checks the data source is reachable with GET /
+		json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+	case "search":
+		handleGrafanaSearch(w, r)
+	case "query":
+		handleGrafanaQuery(w, r)
+	case "series":
+		handleGrafanaSeries(w, r)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Not found"})
+	}
+}
+
+// handleGrafanaSearch lists the series whose run name, run UUID or key
+// contains the search's target
+func handleGrafanaSearch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		Target string `json:"target"`
+	}
+	// Older Grafana versions send an empty body
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid JSON"})
+		return
+	}
+
+	series, err := dao.FindMetricSeries(r.Context(), req.Target, grafanaSearchLimit, tokenProjectID(r))
+	if err != nil {
+		log.Printf("Failed to search metric series: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to search metric series"})
+		return
+	}
+	targets := make([]GrafanaTarget, 0, len(series))
+	for _, s := range series {
+		targets = append(targets, GrafanaTarget{
+			Text:  grafanaSeriesName(s.RunName, s.Key),
+			Value: s.RunUUID + ":" + s.Key,
+		})
+	}
+	json.NewEncoder(w).Encode(targets)
+}
+
+// handleGrafanaQuery returns the points of each target logged during the
+// query's time range, downsampled to maxDataPoints
+func handleGrafanaQuery(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		Range struct {
+			From time.Time `json:"from"`
+			To   time.Time `json:"to"`
+		} `json:"range"`
+		Targets []struct {
+			Target string `json:"target"`
+			Hide   bool   `json:"hide"`
+		} `json:"targets"`
+		MaxDataPoints int `json:"maxDataPoints"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid JSON"})
+		return
+	}
+	maxPoints := min(max(req.MaxDataPoints, metricSeriesMinPoints), metricSeriesMaxPoints)
+	if req.MaxDataPoints == 0 {
+		maxPoints = metricSeriesDefaultPoints
+	}
+
+	results := []GrafanaTimeSeries{}
+	for _, t := range req.Targets {
+		if t.Hide || t.Target == "" {
+			continue
+		}
+		runUUID, key, ok := strings.Cut(t.Target, ":")
+		if !ok || runUUID == "" || key == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Targets must be of the form run_uuid:key, got " + t.Target})
+			return
+		}
+		runID, err := dao.GetRunIDByUUID(ctx, runUUID)
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Run not found: " + runUUID})
+			return
+		}
+		// Targets are in the body's targets list, where the project scope
+		// middleware doesn't look
+		if ok, err := requestCanAccessRun(r, runID); err != nil || !ok {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Run not found: " + runUUID})
+			return
+		}
+		run, err := dao.GetRunByUUID(ctx, runUUID)
+		if err != nil {
+			log.Printf("Failed to load run %s: %v", runUUID, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to query metric"})
+			return
+		}
+		rows, err := dao.GetMetricsLoggedBetween(ctx, runID, key, req.Range.From, req.Range.To)
+		if err != nil {
+			log.Printf("Failed to query metric %s of run %s: %v", key, runUUID, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to query metric"})
+			return
+		}
+		series := GrafanaTimeSeries{Target: grafanaSeriesName(run.Name, key), Datapoints: [][2]float64{}}
+		for _, row := range downsampleMetricRows(rows, maxPoints) {
+			series.Datapoints = append(series.Datapoints, [2]float64{row.YValue, float64(row.LoggedAt.UnixMilli())})
+		}
+		results = append(results, series)
+	}
+	json.NewEncoder(w).Encode(results)
+}
+
+// handleGrafanaSeries returns a series as rows, at
+// GET /api/grafana/series?run_uuid=U&key=K&from=T&to=T&max_points=N. from and
+// to are RFC 3339 times or epoch milliseconds, as Infinity's ${__from} and
+// ${__to} give them, and default to the whole series.
+func handleGrafanaSeries(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	query := r.URL.Query()
+	runUUID, key := query.Get("run_uuid"), query.Get("key")
+	if runUUID == "" || key == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Missing required parameters: run_uuid, key"})
+		return
+	}
+	from, err := parseGrafanaTime(query.Get("from"), time.Unix(0, 0))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "from must be an RFC 3339 time or epoch milliseconds"})
+		return
+	}
+	to, err := parseGrafanaTime(query.Get("to"), time.Now())
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "to must be an RFC 3339 time or epoch milliseconds"})
+		return
+	}
+	maxPoints := metricSeriesDefaultPoints
+	if s := query.Get("max_points"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < metricSeriesMinPoints || n > metricSeriesMaxPoints {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "max_points must be an integer from 4 to 100000"})
+			return
+		}
+		maxPoints = n
+	}
+
+	runID, err := dao.GetRunIDByUUID(ctx, runUUID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Run not found"})
+		return
+	}
+	rows, err := dao.GetMetricsLoggedBetween(ctx, runID, key, from, to)
+	if err != nil {
+		log.Printf("Failed to query metric %s of run %s: %v", key, runUUID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to query metric"})
+		return
+	}
+	points := []GrafanaSeriesRow{}
+	for _, row := range downsampleMetricRows(rows, maxPoints) {
+		points = append(points, GrafanaSeriesRow{Time: row.LoggedAt, X: row.XValue, Value: row.YValue})
+	}
+	json.NewEncoder(w).Encode(points)
+}
+
+// grafanaSeriesName is how a series is labelled in Grafana
+func grafanaSeriesName(runName, key string) string {
+	return runName + " / " + key
+}
+
+// parseGrafanaTime parses an RFC 3339 time or epoch milliseconds, returning
+// def if s is empty
+func parseGrafanaTime(s string, def time.Time) (time.Time, error) {
+	if s == "" {
+		return def, nil
+	}
+	if ms, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.UnixMilli(ms), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// downsampleMetricRows keeps at most maxPoints of a series, in order, the way
+// GetMetricsDownsampled does: the series is split into buckets of consecutive
+// points, of which the lowest and highest are kept along with the first and
+// last points
+func downsampleMetricRows(rows []MetricRow, maxPoints int) []MetricRow {
+	n := len(rows)
+	if n <= maxPoints {
+		return rows
+	}
+	buckets := metricDownsampleBuckets(maxPoints)
+	keep := make([]bool, n)
+	keep[0], keep[n-1] = true, true
+	for b := range buckets {
+		start, end := b*n/buckets, (b+1)*n/buckets
+		lowest, highest := start, start
+		for i := start; i < end; i++ {
+			if rows[i].YValue < rows[lowest].YValue {
+				lowest = i
+			}
+			if rows[i].YValue > rows[highest].YValue {
+				highest = i
+			}
+		}
+		keep[lowest], keep[highest] = true, true
+	}
+	kept := make([]MetricRow, 0, min(n, 2*buckets+2))
+	for i, row := range rows {
+		if keep[i] {
+			kept = append(kept, row)
+		}
+	}
+	return kept
+}
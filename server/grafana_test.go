@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// grafanaDAO knows one run with a loss series logged a second apart
+type grafanaDAO struct {
+	DAO
+	from, to time.Time
+}
+
+var grafanaEpoch = time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func (d *grafanaDAO) GetRunIDByUUID(ctx context.Context, uuid string) (int, error) {
+	if uuid != "run-1" {
+		return 0, sql.ErrNoRows
+	}
+	return 1, nil
+}
+
+func (d *grafanaDAO) GetRunByUUID(ctx context.Context, uuid string) (*Run, error) {
+	return &Run{UUID: uuid, Name: "baseline"}, nil
+}
+
+func (d *grafanaDAO) FindMetricSeries(ctx context.Context, query string, limit, projectID int) ([]MetricSeriesRow, error) {
+	return []MetricSeriesRow{{RunUUID: "run-1", RunName: "baseline", Key: "train:loss"}}, nil
+}
+
+func (d *grafanaDAO) GetMetricsLoggedBetween(ctx context.Context, runID int, key string, from, to time.Time) ([]MetricRow, error) {
+	d.from, d.to = from, to
+	var rows []MetricRow
+	for i := range 10 {
+		rows = append(rows, MetricRow{Key: key, XValue: float64(i), YValue: float64(10 - i), LoggedAt: grafanaEpoch.Add(time.Duration(i) * time.Second)})
+	}
+	return rows, nil
+}
+
+func TestHandleGrafana(t *testing.T) {
+	defer func(d DAO) { dao = d }(dao)
+	d := &grafanaDAO{}
+	dao = d
+
+	grafana := func(method, path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handleGrafana(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return w
+	}
+
+	if w := grafana(http.MethodGet, "/api/v1/grafana/", ""); w.Code != http.StatusOK {
+		t.Errorf("Expected the connection test to succeed, got %d", w.Code)
+	}
+
+	w := grafana(http.MethodPost, "/api/v1/grafana/search", `{"target": "loss"}`)
+	var targets []GrafanaTarget
+	json.Unmarshal(w.Body.Bytes(), &targets)
+	if len(targets) != 1 || targets[0].Value != "run-1:train:loss" || targets[0].Text != "baseline / train:loss" {
+		t.Errorf("Unexpected search results %s", w.Body)
+	}
+
+	w = grafana(http.MethodPost, "/api/v1/grafana/query", `{
+		"range": {"from": "2025-01-01T00:00:00Z", "to": "2025-01-01T01:00:00Z"},
+		"targets": [{"target": "run-1:train:loss", "refId": "A"}, {"target": "run-1:hidden", "hide": true}],
+		"maxDataPoints": 4
+	}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body)
+	}
+	var series []GrafanaTimeSeries
+	json.Unmarshal(w.Body.Bytes(), &series)
+	if len(series) != 1 || series[0].Target != "baseline / train:loss" {
+		t.Fatalf("Expected one series, got %s", w.Body)
+	}
+	if !d.to.Equal(grafanaEpoch.Add(time.Hour)) {
+		t.Errorf("Expected the query's time range to be used, got %v to %v", d.from, d.to)
+	}
+	// The first and last points are kept, with each bucket's extremes
+	points := series[0].Datapoints
+	if len(points) > 4 || points[0] != [2]float64{10, float64(grafanaEpoch.UnixMilli())} || points[len(points)-1][0] != 1 {
+		t.Errorf("Unexpected datapoints %v", points)
+	}
+
+	if w := grafana(http.MethodPost, "/api/v1/grafana/query", `{"targets": [{"target": "loss"}]}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a target without a run, got %d", w.Code)
+	}
+	if w := grafana(http.MethodPost, "/api/v1/grafana/query", `{"targets": [{"target": "missing:loss"}]}`); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a missing run, got %d", w.Code)
+	}
+
+	w = grafana(http.MethodGet, "/api/grafana/series?run_uuid=run-1&key=loss&from=1735689600000&to=2025-01-02T00:00:00Z", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body)
+	}
+	var rows []GrafanaSeriesRow
+	json.Unmarshal(w.Body.Bytes(), &rows)
+	if len(rows) != 10 || !rows[0].Time.Equal(grafanaEpoch) || rows[9].X != 9 {
+		t.Errorf("Unexpected rows %s", w.Body)
+	}
+	if !d.from.Equal(grafanaEpoch) {
+		t.Errorf("Expected from in epoch milliseconds to be parsed, got %v", d.from)
+	}
+	if w := grafana(http.MethodGet, "/api/grafana/series?run_uuid=run-1&key=loss&from=yesterday", ""); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid time, got %d", w.Code)
+	}
+}
+
+func TestDownsampleMetricRows(t *testing.T) {
+	var rows []MetricRow
+	for _, y := range []float64{5, 1, 9, 4, 4, 8, 2, 6} {
+		rows = append(rows, MetricRow{XValue: float64(len(rows)), YValue: y})
+	}
+	var xs []float64
+	for _, row := range downsampleMetricRows(rows, 6) {
+		xs = append(xs, row.XValue)
+	}
+	// Two buckets, [5 1 9 4] and [4 8 2 6], keep their extremes
+	if !slices.Equal(xs, []float64{0, 1, 2, 5, 6, 7}) {
+		t.Errorf("Unexpected points kept %v", xs)
+	}
+	if len(downsampleMetricRows(rows, 100)) != len(rows) {
+		t.Error("Expected a short series to be kept whole")
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"net/http"
+	"runtime/debug"
+	"strings"
+)
+
+// Page handlers return their errors rather than responding to them, so that a
+// failed query or template fails only that request instead of stopping the
+// server. errorHandler logs the error along with the request's ID, and
+// responds with the error page, or a JSON error envelope for API requests,
+// quoting the ID so that a report can be matched to the log. API handlers
+// respond to their own errors, and apiErrorMiddleware puts the server errors
+// they respond with into the same envelope.
+
+// requestIDHeader carries a request's ID: from a proxy that assigned one, and
+// back to the client
+const requestIDHeader = "X-Request-ID"
+
+// requestIDMaxLength is the longest request ID accepted from a proxy
+const requestIDMaxLength = 64
+
+type requestIDContextKey struct{}
+
+// newRequestID returns a random ID for a request
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// requestID returns the ID a proxy assigned to the request if it is short and
+// printable, and a new ID otherwise
+func requestID(r *http.Request) string {
+	id := r.Header.Get(requestIDHeader)
+	if id == "" || len(id) > requestIDMaxLength {
+		return newRequestID()
+	}
+	for _, c := range id {
+		if c <= ' ' || c > '~' {
+			return newRequestID()
+		}
+	}
+	return id
+}
+
+// requestIDFromContext returns the ID LoggerMiddleware assigned to the
+// request, or "" outside of it
+func requestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDContextKey{}).(string)
+	return id
+}
+
+// errorHandler is a handler that returns the errors it cannot handle itself.
+// They are logged and answered with a 500, as are panics.
+type errorHandler func(w http.ResponseWriter, r *http.Request) error
+
+func (h errorHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ew := &errorResponseWriter{ResponseWriter: w}
+	defer func() {
+		if p := recover(); p != nil {
+			if p == http.ErrAbortHandler {
+				panic(p)
+			}
+			respondWithError(ew, r, fmt.Errorf("panic: %v\n%s", p, debug.Stack()))
+		}
+	}()
+	if err := h(ew, r); err != nil {
+		respondWithError(ew, r, err)
+	}
+}
+
+// errorResponseWriter records whether a response has begun, after which an
+// error can no longer be reported to the client
+type errorResponseWriter struct {
+	http.ResponseWriter
+	wrote bool
+}
+
+func (w *errorResponseWriter) WriteHeader(code int) {
+	w.wrote = true
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *errorResponseWriter) Write(b []byte) (int, error) {
+	w.wrote = true
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w *errorResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// errorPageTemplates are the templates of the error page
+var errorPageTemplates = registerPage("templates/error.html")
+
+// respondWithError logs a handler's error and, unless the handler has begun
+// its response, responds with a 500 quoting the request's ID
+func respondWithError(w *errorResponseWriter, r *http.Request, err error) {
+	id := requestIDFromContext(r.Context())
+	if id == "" {
+		id = newRequestID()
+		w.Header().Set(requestIDHeader, id)
+	}
+	log.Printf("Request %s (%s %s) failed: %v", id, r.Method, r.URL.Path, err)
+	if w.wrote {
+		return
+	}
+
+	if strings.HasPrefix(r.URL.Path, "/api/") {
+		writeAPIErrorEnvelope(w, http.StatusInternalServerError, "Internal server error", id)
+		return
+	}
+
+	data := struct {
+		Title     string
+		RequestID string
+	}{
+		Title:     "Error",
+		RequestID: id,
+	}
+	var page bytes.Buffer
+	tmpl, err := errorPageTemplates.Get()
+	if err == nil {
+		err = tmpl.ExecuteTemplate(&page, "error.html", data)
+	}
+	if err != nil {
+		log.Printf("Failed to render error page for request %s: %v", id, err)
+		http.Error(w, "Internal server error (request ID "+id+")", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusInternalServerError)
+	w.Write(page.Bytes())
+}
+
+// writeAPIErrorEnvelope responds to an API request with an error and the
+// request's ID
+func writeAPIErrorEnvelope(w http.ResponseWriter, status int, message, id string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message, "request_id": id})
+}
+
+// apiErrorMiddleware answers the server errors of an API endpoint, whether
+// it responds with them itself or panics, with the JSON error envelope
+func apiErrorMiddleware(next http.Handler) http.Handler {
+	return errorHandler(func(w http.ResponseWriter, r *http.Request) error {
+		aw := &apiErrorWriter{ResponseWriter: w}
+		next.ServeHTTP(aw, r)
+		if aw.status == 0 {
+			return nil
+		}
+		// The handler's own message, from its {"error": ...} body or its
+		// plain text one
+		var body struct {
+			Error string `json:"error"`
+		}
+		message := strings.TrimSpace(aw.body.String())
+		if json.Unmarshal(aw.body.Bytes(), &body) == nil && body.Error != "" {
+			message = body.Error
+		}
+		if message == "" {
+			message = http.StatusText(aw.status)
+		}
+		writeAPIErrorEnvelope(w, aw.status, message, requestIDFromContext(r.Context()))
+		return nil
+	})
+}
+
+// apiErrorWriter holds back a server error response, status 500 or above,
+// for apiErrorMiddleware to put into the envelope; other responses are
+// written through
+type apiErrorWriter struct {
+	http.ResponseWriter
+	// status is the server error responded with, or 0
+	status int
+	body   bytes.Buffer
+}
+
+func (w *apiErrorWriter) WriteHeader(code int) {
+	if code >= http.StatusInternalServerError {
+		w.status = code
+		return
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *apiErrorWriter) Write(b []byte) (int, error) {
+	if w.status != 0 {
+		return w.body.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w *apiErrorWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// executeTemplate renders a template in full before writing it to w, so that
+// a template that fails partway leaves nothing written and the error page can
+// be served in its place
+func executeTemplate(w io.Writer, tmpl *template.Template, name string, data any) error {
+	var buf bytes.Buffer
+	if err := tmpl.ExecuteTemplate(&buf, name, data); err != nil {
+		return fmt.Errorf("failed to execute template %s: %w", name, err)
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
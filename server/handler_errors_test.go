@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// failingRunDAO finds a run but fails to look up its ID
+type failingRunDAO struct {
+	DAO
+}
+
+func (d *failingRunDAO) GetRunByUUID(ctx context.Context, uuid string) (*Run, error) {
+	return &Run{Name: "Run"}, nil
+}
+
+func (d *failingRunDAO) GetRunIDByUUID(ctx context.Context, uuid string) (int, error) {
+	return 0, errors.New("database is locked")
+}
+
+func TestErrorHandlerPage(t *testing.T) {
+	defer func(d DAO) { dao = d }(dao)
+	dao = &failingRunDAO{}
+	handler := LoggerMiddleware(errorHandler(handleViewRun))
+
+	// A failed tab is answered with the error page alone, not beneath the
+	// run page's tabs
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/runs/run-1/overview", nil))
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("Expected 500, got %d", w.Code)
+	}
+	id := w.Header().Get(requestIDHeader)
+	if id == "" {
+		t.Fatal("Expected the response to carry a request ID")
+	}
+	body := w.Body.String()
+	if !strings.Contains(body, "Something went wrong") || !strings.Contains(body, id) {
+		t.Errorf("Expected the error page quoting request ID %s, got %s", id, body)
+	}
+	if strings.Contains(body, `role="tab"`) || strings.Contains(body, "database is locked") {
+		t.Errorf("Expected neither the tabs nor the error's detail in the page, got %s", body)
+	}
+
+	// A proxy's request ID is kept
+	w = httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/runs/run-1", nil)
+	r.Header.Set(requestIDHeader, "proxy-42")
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusInternalServerError || w.Header().Get(requestIDHeader) != "proxy-42" || !strings.Contains(w.Body.String(), "proxy-42") {
+		t.Errorf("Expected the error page quoting the proxy's request ID, got %d %s", w.Code, w.Body)
+	}
+}
+
+func TestErrorHandlerAPI(t *testing.T) {
+	handler := LoggerMiddleware(errorHandler(func(w http.ResponseWriter, r *http.Request) error {
+		panic("nil map")
+	}))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/runs/run-1", nil))
+	if w.Code != http.StatusInternalServerError || w.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("Expected a JSON 500, got %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	var envelope map[string]string
+	if err := json.NewDecoder(w.Body).Decode(&envelope); err != nil {
+		t.Fatal(err)
+	}
+	if envelope["error"] != "Internal server error" || envelope["request_id"] != w.Header().Get(requestIDHeader) {
+		t.Errorf("Unexpected error envelope %v", envelope)
+	}
+}
+
+// failingSearchDAO fails every search
+type failingSearchDAO struct {
+	DAO
+}
+
+func (d *failingSearchDAO) SearchRuns(ctx context.Context, terms []string, limit, projectID int) ([]RunSearchRow, error) {
+	return nil, errors.New("database is locked")
+}
+
+func TestErrorHandlerSearch(t *testing.T) {
+	defer func(d DAO) { dao = d }(dao)
+	dao = &failingSearchDAO{}
+
+	// The search page answers a failed search with the error page
+	w := httptest.NewRecorder()
+	LoggerMiddleware(errorHandler(handleSearch)).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/search?q=resnet", nil))
+	if w.Code != http.StatusInternalServerError || !strings.Contains(w.Body.String(), w.Header().Get(requestIDHeader)) {
+		t.Errorf("Expected the error page quoting the request ID, got %d %s", w.Code, w.Body)
+	}
+
+	// The search API answers it with the error envelope, keeping its message
+	w = httptest.NewRecorder()
+	LoggerMiddleware(apiErrorMiddleware(http.HandlerFunc(handleAPISearch))).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/search?q=resnet", nil))
+	if w.Code != http.StatusInternalServerError || w.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("Expected a JSON 500, got %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	var envelope map[string]string
+	if err := json.NewDecoder(w.Body).Decode(&envelope); err != nil {
+		t.Fatal(err)
+	}
+	if envelope["error"] != "Failed to search runs" || envelope["request_id"] != w.Header().Get(requestIDHeader) {
+		t.Errorf("Unexpected error envelope %v", envelope)
+	}
+}
+
+func TestAPIErrorMiddleware(t *testing.T) {
+	serve := func(handler http.HandlerFunc) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		LoggerMiddleware(apiErrorMiddleware(handler)).ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/runs", nil))
+		return w
+	}
+
+	// Client errors are left as the handler wrote them
+	w := serve(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":"name is required"}`))
+	})
+	if w.Code != http.StatusBadRequest || w.Body.String() != `{"error":"name is required"}` {
+		t.Errorf("Expected a client error left alone, got %d %s", w.Code, w.Body)
+	}
+
+	// Server errors in plain text are put into the envelope, keeping their
+	// status and headers
+	w = serve(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "Server is busy", http.StatusServiceUnavailable)
+	})
+	var envelope map[string]string
+	if err := json.NewDecoder(w.Body).Decode(&envelope); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != "1" ||
+		envelope["error"] != "Server is busy" || envelope["request_id"] != w.Header().Get(requestIDHeader) {
+		t.Errorf("Unexpected response %d %v", w.Code, envelope)
+	}
+
+	// As are those without a body
+	w = serve(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	if !strings.Contains(w.Body.String(), `"error":"Internal Server Error"`) {
+		t.Errorf("Expected the status text as the error, got %s", w.Body)
+	}
+}
+
+func TestErrorHandlerAfterResponse(t *testing.T) {
+	// Once a response has begun, an error can only be logged
+	w := httptest.NewRecorder()
+	errorHandler(func(w http.ResponseWriter, r *http.Request) error {
+		w.Write([]byte("partial"))
+		return errors.New("connection reset")
+	}).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/runs/run-1", nil))
+	if w.Code != http.StatusOK || w.Body.String() != "partial" {
+		t.Errorf("Expected the response to be left as it was, got %d %s", w.Code, w.Body)
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	for header, kept := range map[string]bool{
+		"":                         false,
+		"abc-123":                  true,
+		"has space":                false,
+		strings.Repeat("a", 65):    false,
+		"4bf92f3577b34da6a3ce929d": true,
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set(requestIDHeader, header)
+		id := requestID(r)
+		if id == "" || (id == header) != kept {
+			t.Errorf("requestID with %q = %q", header, id)
+		}
+	}
+}
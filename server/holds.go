@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+)
+
+// errRunOnHold is returned by operations that would delete or overwrite data
+// belonging to a run on hold
+var errRunOnHold = errors.New("run is on hold")
+
+// RunHold is a run's hold in display form
+type RunHold struct {
+	Reason string
+	HeldAt string
+}
+
+// ensureRunNotOnHold returns errRunOnHold if the run is on hold. Retention,
+// GC, and deletion operations must check it before touching a run's data.
+func ensureRunNotOnHold(ctx context.Context, runID int) error {
+	hold, err := dao.GetRunHold(ctx, runID)
+	if err != nil {
+		return err
+	}
+	if hold != nil {
+		return errRunOnHold
+	}
+	return nil
+}
+
+// getRunHold loads the hold on a run in display form, or nil if there is none
+func getRunHold(ctx context.Context, runID int) (*RunHold, error) {
+	hold, err := dao.GetRunHold(ctx, runID)
+	if err != nil || hold == nil {
+		return nil, err
+	}
+	return &RunHold{Reason: hold.Reason, HeldAt: hold.HeldAt.Format(displayTimeLayout)}, nil
+}
+
+// RunHoldRequest is the body of POST /api/runs/hold
+type RunHoldRequest struct {
+	RunUUID string `json:"run_uuid"`
+	Reason  string `json:"reason"`
+}
+
+func handleAPIRunHold(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	var req RunHoldRequest
+
+	if r.Method == http.MethodDelete {
+		req.RunUUID = r.URL.Query().Get("run_uuid")
+	} else if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid JSON"})
+		return
+	}
+
+	var missing []string
+	if req.RunUUID == "" {
+		missing = append(missing, "run_uuid")
+	}
+	if r.Method == http.MethodPost && req.Reason == "" {
+		missing = append(missing, "reason")
+	}
+
+	if len(missing) > 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":          "Missing required fields",
+			"missing_fields": missing,
+		})
+		return
+	}
+
+	runID, err := dao.GetRunIDByUUID(ctx, req.RunUUID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Run not found"})
+		return
+	}
+
+	if r.Method == http.MethodDelete {
+		err = dao.ClearRunHold(ctx, runID)
+	} else {
+		err = dao.SetRunHold(ctx, runID, req.Reason)
+	}
+	if err != nil {
+		log.Printf("Failed to update hold on run %s: %v", req.RunUUID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to update hold"})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
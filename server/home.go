@@ -0,0 +1,710 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// homePageRunsPerPage is the number of runs listed on each page of the home
+// page's run list
+const homePageRunsPerPage = 20
+
+// Default sort of run lists: newest first
+const (
+	defaultRunSort    = "created"
+	defaultRunSortDir = "desc"
+)
+
+// runSortColumns maps the sort keys of run lists to the columns they order by
+var runSortColumns = map[string]string{
+	"created":    "r.created_at",
+	"name":       "r.name",
+	"status":     "r.status",
+	"experiment": "e.name",
+}
+
+// runSortMetricPrefix prefixes the sort key of a metric, which sorts runs by
+// the metric's latest value, e.g. metric:val/loss
+const runSortMetricPrefix = "metric:"
+
+// validRunSort reports whether a run list can be sorted by a key
+func validRunSort(sortBy string) bool {
+	if key, ok := strings.CutPrefix(sortBy, runSortMetricPrefix); ok {
+		return key != ""
+	}
+	_, ok := runSortColumns[sortBy]
+	return ok
+}
+
+// runsOrderBy builds the ORDER BY clause of a run list sort, and the value
+// runs are sorted by, selected as sort_value: the latest value of the metric
+// for metric sorts and NULL otherwise. placeholder(n) renders the nth of the
+// value's query arguments. Runs without the metric come last either way, and
+// ties are broken by creation order so that rows do not repeat or go missing
+// across pages.
+func runsOrderBy(sortBy, sortDir string, placeholder func(n int) string) (value, orderBy string, args []interface{}, err error) {
+	if sortDir != "asc" && sortDir != "desc" {
+		return "", "", nil, fmt.Errorf("unknown sort direction %q", sortDir)
+	}
+	if key, ok := strings.CutPrefix(sortBy, runSortMetricPrefix); ok && key != "" {
+		value = "(SELECT m.y_value FROM metrics m WHERE m.run_id = r.id AND m.key = " + placeholder(1) + " ORDER BY m.x_value DESC LIMIT 1)"
+		return value, fmt.Sprintf("sort_value %s NULLS LAST, r.id %s", sortDir, sortDir), []interface{}{key}, nil
+	}
+	column, ok := runSortColumns[sortBy]
+	if !ok {
+		return "", "", nil, fmt.Errorf("unknown run sort %q", sortBy)
+	}
+	return "NULL", fmt.Sprintf("%s %s, r.id %s", column, sortDir, sortDir), nil, nil
+}
+
+// Densities of run list rows
+const (
+	runDensityComfortable = "comfortable"
+	runDensityCompact     = "compact"
+)
+
+// RunListView is how a run list is sorted and laid out unless its URL says
+// otherwise
+type RunListView struct {
+	Sort    string
+	Dir     string
+	Density string
+}
+
+// serverRunListView is the server's default view of the home page's run
+// list, which users can override for themselves; see runListViewFor
+var serverRunListView = RunListView{Sort: defaultRunSort, Density: runDensityComfortable}
+
+// normalized replaces the invalid parts of a view with defaults. A missing
+// direction is the default direction of the sort.
+func (v RunListView) normalized() RunListView {
+	if !validRunSort(v.Sort) {
+		v.Sort, v.Dir = defaultRunSort, ""
+	}
+	if v.Dir != "asc" && v.Dir != "desc" {
+		v.Dir = defaultRunSortDirFor(v.Sort)
+	}
+	if v.Density != runDensityCompact {
+		v.Density = runDensityComfortable
+	}
+	return v
+}
+
+// runListViewCookie holds a user's own default view of the run list, as
+// url-encoded sort, dir and density
+const runListViewCookie = "apparatus_run_list"
+
+// runListViewFor is the default view of the run list for the user making a
+// request: the server's, overridden by any they saved
+func runListViewFor(r *http.Request) RunListView {
+	view := serverRunListView
+	cookie, err := r.Cookie(runListViewCookie)
+	if err != nil {
+		return view.normalized()
+	}
+	saved, err := url.ParseQuery(cookie.Value)
+	if err != nil {
+		return view.normalized()
+	}
+	if validRunSort(saved.Get("sort")) {
+		view.Sort, view.Dir = saved.Get("sort"), ""
+	}
+	if dir := saved.Get("dir"); dir != "" {
+		view.Dir = dir
+	}
+	if density := saved.Get("density"); density != "" {
+		view.Density = density
+	}
+	return view.normalized()
+}
+
+// handleRunListPreferences saves the view of the run list posted from the
+// home page as the user's default, or forgets it if reset is posted, then
+// sends the browser back to the home page
+func handleRunListPreferences(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if r.FormValue("reset") != "" {
+		http.SetCookie(w, &http.Cookie{Name: runListViewCookie, Path: "/", MaxAge: -1})
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	view := RunListView{
+		Sort:    formRunSort(r.Form),
+		Dir:     r.FormValue("dir"),
+		Density: r.FormValue("density"),
+	}.normalized()
+	http.SetCookie(w, &http.Cookie{
+		Name:     runListViewCookie,
+		Value:    url.Values{"sort": {view.Sort}, "dir": {view.Dir}, "density": {view.Density}}.Encode(),
+		Path:     "/",
+		MaxAge:   365 * 24 * 60 * 60,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// formRunSort reads a sort key from a URL or form, where the view form gives
+// metric sorts as sort=metric and the metric's key
+func formRunSort(values url.Values) string {
+	if values.Get("sort") == "metric" {
+		return runSortMetricPrefix + strings.TrimSpace(values.Get("metric"))
+	}
+	return values.Get("sort")
+}
+
+// runFilterWhere builds the WHERE clause of a run list filter over runs r,
+// with placeholder(n) rendering the nth query argument
+func runFilterWhere(filter RunFilter, placeholder func(n int) string) (string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return placeholder(len(args))
+	}
+	for _, t := range filter.Tags {
+		condition := "EXISTS (SELECT 1 FROM tags t WHERE t.run_id = r.id AND t.key = " + arg(t.Key)
+		if t.HasValue {
+			condition += " AND t.value = " + arg(t.Value)
+		}
+		conditions = append(conditions, condition+")")
+	}
+	if filter.Query != nil {
+		conditions = append(conditions, filter.Query.sql(arg))
+	}
+	// Creation times are compared in UTC, as they are stored
+	if !filter.CreatedAfter.IsZero() {
+		conditions = append(conditions, "r.created_at >= "+arg(filter.CreatedAfter.UTC()))
+	}
+	if !filter.CreatedBefore.IsZero() {
+		conditions = append(conditions, "r.created_at < "+arg(filter.CreatedBefore.UTC()))
+	}
+	if filter.HideArchived {
+		conditions = append(conditions, "r.archived_at IS NULL")
+	}
+	if filter.ProjectID != 0 {
+		conditions = append(conditions, "r.experiment_id IN (SELECT id FROM experiments WHERE project_id = "+arg(filter.ProjectID)+")")
+	}
+	if filter.ExperimentID != 0 {
+		conditions = append(conditions, "r.experiment_id = "+arg(filter.ExperimentID))
+	}
+	if filter.User != "" {
+		conditions = append(conditions, "r.user_name = "+arg(filter.User))
+	}
+	// Commits are hex, so the prefix needs no escaping from LIKE
+	if filter.GitCommit != "" {
+		conditions = append(conditions, "r.git_commit LIKE "+arg(filter.GitCommit+"%"))
+	}
+	if filter.Reproducible {
+		conditions = append(conditions, runReproducibleCondition)
+	}
+	if len(conditions) == 0 {
+		return "", nil
+	}
+	return "WHERE " + strings.Join(conditions, " AND "), args
+}
+
+// homePageCacheTTL bounds how stale the home page can get when the database is
+// written to by something other than this process
+var homePageCacheTTL = 30 * time.Second
+
+// RunSummary is a run as listed on the home page, with its experiment
+type RunSummary struct {
+	ID             int
+	UUID           string
+	Name           string
+	CreatedAt      string
+	ExperimentUUID string
+	ExperimentName string
+	Status         string
+	Archived       bool
+	// Reproducible reports whether the run can be reproduced; see
+	// runReproducibleCondition
+	Reproducible bool
+	// SortValue is the latest value of the metric runs are sorted by, for
+	// metric sorts of runs that logged it
+	SortValue *float64
+	// Loss and Accuracy summarize the run's headline loss and accuracy
+	// metrics, if it logged them; see withMetricSummaries
+	Loss     *HeadlineMetric
+	Accuracy *HeadlineMetric
+}
+
+// RunListPage is a page of the home page's run list. Its state is encoded in
+// the page URL, e.g. /?page=2&sort=name&dir=asc&tags=team%3Dvision, so pages
+// can be linked to.
+type RunListPage struct {
+	Runs []RunSummary
+	Page int
+	Sort string
+	Dir  string
+	// Tags is the tag filter box; see parseTagFilters
+	Tags string
+	// Query is the run query box, and QueryError why it does not parse; see
+	// parseRunQuery
+	Query      string
+	QueryError string
+	// Created is a preset range of creation times, e.g. 7d; see
+	// runCreatedRanges. CreatedFrom and CreatedTo are dates bounding a
+	// custom range, both inclusive.
+	Created     string
+	CreatedFrom string
+	CreatedTo   string
+	// Archived lists archived runs too, which are hidden by default
+	Archived bool
+	// User and Commit limit runs to those created by a user and from a git
+	// commit, which may be abbreviated
+	User   string
+	Commit string
+	// Reproducible limits runs to reproducible ones
+	Reproducible bool
+	// Density is how tightly rows are laid out: comfortable or compact
+	Density string
+	HasNext bool
+
+	// view is the default view, which URLs leave out
+	view   RunListView
+	query  *RunQuery
+	filter RunFilter
+}
+
+// RunCreatedRange is a preset range of creation times run lists can be
+// filtered by
+type RunCreatedRange struct {
+	Value string
+	Label string
+}
+
+// runCreatedRanges are the preset ranges, in the order offered
+var runCreatedRanges = []RunCreatedRange{
+	{"24h", "Last 24 hours"},
+	{"7d", "Last 7 days"},
+	{"30d", "Last 30 days"},
+}
+
+// parseCreatedWithin parses how recently runs were created, as a duration
+// such as 24h or a number of days such as 7d
+func parseCreatedWithin(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n <= 0 {
+			return 0, fmt.Errorf("invalid number of days %q", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	return d, nil
+}
+
+// parseCreatedTime parses a bound on run creation times: an RFC 3339 time, or
+// a date, which is midnight UTC
+func parseCreatedTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.DateOnly, s); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// parseRunListPage reads the run list state from a home page URL, falling back
+// to the first page of a default view for missing or invalid values
+func parseRunListPage(query url.Values, view RunListView) RunListPage {
+	p := RunListPage{Page: 1, Sort: view.Sort, Dir: view.Dir, Density: view.Density, view: view}
+	if page, err := strconv.Atoi(query.Get("page")); err == nil && page > 1 {
+		p.Page = page
+	}
+	if sortBy := formRunSort(query); validRunSort(sortBy) {
+		p.Sort = sortBy
+		p.Dir = defaultRunSortDirFor(p.Sort)
+	}
+	if dir := query.Get("dir"); dir == "asc" || dir == "desc" {
+		p.Dir = dir
+	}
+	if density := query.Get("density"); density == runDensityComfortable || density == runDensityCompact {
+		p.Density = density
+	}
+	p.Tags = strings.TrimSpace(query.Get("tags"))
+	p.Query = strings.TrimSpace(query.Get("q"))
+	if p.Query != "" {
+		q, err := parseRunQuery(p.Query)
+		if err != nil {
+			p.QueryError = err.Error()
+		}
+		p.query = q
+	}
+	now := time.Now()
+	for _, r := range runCreatedRanges {
+		if query.Get("created") == r.Value {
+			p.Created = r.Value
+			within, _ := parseCreatedWithin(r.Value)
+			p.filter.CreatedAfter = now.Add(-within)
+		}
+	}
+	if from, err := time.Parse(time.DateOnly, query.Get("created_from")); err == nil {
+		p.CreatedFrom = query.Get("created_from")
+		if from.After(p.filter.CreatedAfter) {
+			p.filter.CreatedAfter = from
+		}
+	}
+	if to, err := time.Parse(time.DateOnly, query.Get("created_to")); err == nil {
+		p.CreatedTo = query.Get("created_to")
+		p.filter.CreatedBefore = to.AddDate(0, 0, 1)
+	}
+	p.Archived = query.Get("archived") == "1"
+	p.filter.HideArchived = !p.Archived
+	p.User = strings.TrimSpace(query.Get("user"))
+	p.filter.User = p.User
+	if commit, ok := parseGitCommit(query.Get("commit")); ok {
+		p.Commit = commit
+		p.filter.GitCommit = commit
+	}
+	p.Reproducible = query.Get("reproducible") == "1"
+	p.filter.Reproducible = p.Reproducible
+	return p
+}
+
+// Filter is the filter the page's runs are selected by
+func (p RunListPage) Filter() RunFilter {
+	filter := p.filter
+	filter.Tags = parseTagFilters(p.Tags)
+	filter.Query = p.query
+	return filter
+}
+
+// defaultRunSortDirFor is the direction a run list is first sorted in by a
+// column: newest first for creation time, ascending otherwise
+func defaultRunSortDirFor(sortBy string) string {
+	if sortBy == "created" {
+		return "desc"
+	}
+	return "asc"
+}
+
+// isDefault reports whether the page is the unfiltered first page of the
+// server's default sort, which the home page cache holds
+func (p RunListPage) isDefault() bool {
+	server := serverRunListView.normalized()
+	return p.Page == 1 && p.Sort == server.Sort && p.Dir == server.Dir && p.Tags == "" && p.Query == "" && !p.IsCreatedFiltered() && !p.Archived && !p.IsSourceFiltered()
+}
+
+// defaults is the view URLs of the page leave out: the default view of the
+// user it is shown to, if the server's is the same
+func (p RunListPage) defaults() RunListView {
+	server := serverRunListView.normalized()
+	if p.view != (RunListView{}) && p.view != server {
+		// Left out values would be read differently by other users, so
+		// only leave out the values both defaults agree on
+		if p.view.Sort != server.Sort || p.view.Dir != server.Dir {
+			server.Sort, server.Dir = "", ""
+		}
+		if p.view.Density != server.Density {
+			server.Density = ""
+		}
+	}
+	return server
+}
+
+// url encodes a run list state, leaving out defaults
+func (p RunListPage) url(page int, sortBy, sortDir string) string {
+	defaults := p.defaults()
+	query := p.Filters()
+	if page > 1 {
+		query.Set("page", strconv.Itoa(page))
+	}
+	if sortBy != defaults.Sort || sortDir != defaults.Dir {
+		query.Set("sort", sortBy)
+		query.Set("dir", sortDir)
+	}
+	if p.Density != "" && p.Density != defaults.Density {
+		query.Set("density", p.Density)
+	}
+	if len(query) == 0 {
+		return "/"
+	}
+	return "/?" + query.Encode()
+}
+
+// Filters encodes the page's filters, for links and forms that keep them
+func (p RunListPage) Filters() url.Values {
+	query := url.Values{}
+	if p.Tags != "" {
+		query.Set("tags", p.Tags)
+	}
+	if p.Query != "" {
+		query.Set("q", p.Query)
+	}
+	if p.Created != "" {
+		query.Set("created", p.Created)
+	}
+	if p.CreatedFrom != "" {
+		query.Set("created_from", p.CreatedFrom)
+	}
+	if p.CreatedTo != "" {
+		query.Set("created_to", p.CreatedTo)
+	}
+	if p.Archived {
+		query.Set("archived", "1")
+	}
+	if p.User != "" {
+		query.Set("user", p.User)
+	}
+	if p.Commit != "" {
+		query.Set("commit", p.Commit)
+	}
+	if p.Reproducible {
+		query.Set("reproducible", "1")
+	}
+	return query
+}
+
+// ExportURL links to the export of the runs on the page in a format, csv or
+// json
+func (p RunListPage) ExportURL(format string) string {
+	uuids := make([]string, 0, len(p.Runs))
+	for _, run := range p.Runs {
+		uuids = append(uuids, run.UUID)
+	}
+	return exportURL(uuids, format)
+}
+
+// IsCreatedFiltered reports whether runs are filtered by when they were
+// created
+func (p RunListPage) IsCreatedFiltered() bool {
+	return p.Created != "" || p.CreatedFrom != "" || p.CreatedTo != ""
+}
+
+// IsSourceFiltered reports whether runs are filtered by where they came
+// from: who created them, the commit they were created from, or whether they
+// can be reproduced
+func (p RunListPage) IsSourceFiltered() bool {
+	return p.User != "" || p.Commit != "" || p.Reproducible
+}
+
+// SortMetric is the key of the metric runs are sorted by, for metric sorts
+func (p RunListPage) SortMetric() string {
+	if key, ok := strings.CutPrefix(p.Sort, runSortMetricPrefix); ok {
+		return key
+	}
+	return ""
+}
+
+// HasLoss reports whether any run on the page logged a loss
+func (p RunListPage) HasLoss() bool {
+	return slices.ContainsFunc(p.Runs, func(run RunSummary) bool { return run.Loss != nil })
+}
+
+// HasAccuracy reports whether any run on the page logged an accuracy
+func (p RunListPage) HasAccuracy() bool {
+	return slices.ContainsFunc(p.Runs, func(run RunSummary) bool { return run.Accuracy != nil })
+}
+
+// CreatedRanges are the preset ranges of creation times offered
+func (p RunListPage) CreatedRanges() []RunCreatedRange {
+	return runCreatedRanges
+}
+
+// PrevURL is the URL of the previous page
+func (p RunListPage) PrevURL() string {
+	return p.url(p.Page-1, p.Sort, p.Dir)
+}
+
+// NextURL is the URL of the next page
+func (p RunListPage) NextURL() string {
+	return p.url(p.Page+1, p.Sort, p.Dir)
+}
+
+// SortURL is the URL of the first page sorted by a column, reversing the
+// direction if the list is already sorted by it
+func (p RunListPage) SortURL(sortBy string) string {
+	dir := defaultRunSortDirFor(sortBy)
+	if sortBy == p.Sort {
+		dir = "asc"
+		if p.Dir == "asc" {
+			dir = "desc"
+		}
+	}
+	return p.url(1, sortBy, dir)
+}
+
+// SortIndicator marks the column the list is sorted by with its direction
+func (p RunListPage) SortIndicator(sortBy string) string {
+	if sortBy != p.Sort {
+		return ""
+	}
+	if p.Dir == "asc" {
+		return "▲"
+	}
+	return "▼"
+}
+
+// withRuns fills a page with its runs. One run past the page is fetched to
+// tell whether there is a next page.
+func withRuns(p RunListPage, runs []RunSummary) RunListPage {
+	if len(runs) > homePageRunsPerPage {
+		p.HasNext = true
+		runs = runs[:homePageRunsPerPage]
+	}
+	p.Runs = runs
+	return p
+}
+
+// homePageCache keeps the experiments and the first page of latest runs shown
+// on the home page in memory so that it renders without touching the database. Writes that
+// change what the home page shows invalidate it; see homePageCachingDAO.
+// Replicas of a multi-instance deployment also check the cache's generation
+// in the database, which every replica's writes bump.
+type homePageCache struct {
+	mu          sync.Mutex
+	generation  uint64
+	shared      int64
+	loaded      bool
+	loadedAt    time.Time
+	experiments []Experiment
+	latestRuns  []RunSummary
+}
+
+var homeCache = &homePageCache{}
+
+// homePageCacheName names the home page cache's generation in the database
+const homePageCacheName = "home"
+
+// get returns the cached home page contents, loading them from d if the
+// cache is empty, invalidated, or older than homePageCacheTTL
+func (c *homePageCache) get(ctx context.Context, d DAO) ([]Experiment, []RunSummary, error) {
+	var shared int64
+	if multiInstance {
+		var err error
+		if shared, err = d.GetCacheGeneration(ctx, homePageCacheName); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	c.mu.Lock()
+	if c.loaded && c.shared == shared && time.Since(c.loadedAt) < homePageCacheTTL {
+		experiments, latestRuns := c.experiments, c.latestRuns
+		c.mu.Unlock()
+		return experiments, latestRuns, nil
+	}
+	generation := c.generation
+	c.mu.Unlock()
+
+	experiments, err := d.GetAllExperiments(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	view := serverRunListView.normalized()
+	latestRuns, err := d.GetRuns(ctx, 0, homePageRunsPerPage+1, view.Sort, view.Dir, RunFilter{HideArchived: true})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Only store the result if nothing was written while it was loading;
+	// otherwise the next request loads again
+	c.mu.Lock()
+	if c.generation == generation {
+		c.loaded = true
+		c.shared = shared
+		c.loadedAt = time.Now()
+		c.experiments = experiments
+		c.latestRuns = latestRuns
+	}
+	c.mu.Unlock()
+
+	return experiments, latestRuns, nil
+}
+
+// invalidate drops the cached contents so the next request reloads them
+func (c *homePageCache) invalidate() {
+	c.mu.Lock()
+	c.generation++
+	c.loaded = false
+	c.experiments = nil
+	c.latestRuns = nil
+	c.mu.Unlock()
+}
+
+// homePageCachingDAO wraps a DAO and invalidates the home page cache on
+// writes that change the experiments list or the latest runs
+type homePageCachingDAO struct {
+	DAO
+	cache *homePageCache
+}
+
+// invalidate invalidates the home page cache of this server, and of the other
+// replicas if there are any
+func (d *homePageCachingDAO) invalidate(ctx context.Context) {
+	d.cache.invalidate()
+	if multiInstance {
+		if err := d.DAO.BumpCacheGeneration(ctx, homePageCacheName); err != nil {
+			log.Printf("Failed to invalidate the home page cache of other replicas: %v", err)
+		}
+	}
+}
+
+func (d *homePageCachingDAO) InsertExperiment(ctx context.Context, uuid, name string) error {
+	defer d.invalidate(ctx)
+	return d.DAO.InsertExperiment(ctx, uuid, name)
+}
+
+func (d *homePageCachingDAO) InsertRun(ctx context.Context, uuid, name string, experimentID int, parentRunID *int) error {
+	defer d.invalidate(ctx)
+	return d.DAO.InsertRun(ctx, uuid, name, experimentID, parentRunID)
+}
+
+func (d *homePageCachingDAO) InsertRunWithSourceAndSeeds(ctx context.Context, uuid, name string, experimentID int, parentRunID *int, source RunSourceRow, seeds RunSeedsRow) error {
+	defer d.invalidate(ctx)
+	return d.DAO.InsertRunWithSourceAndSeeds(ctx, uuid, name, experimentID, parentRunID, source, seeds)
+}
+
+func (d *homePageCachingDAO) UpdateRunStatus(ctx context.Context, runID int, status, message string) error {
+	defer d.invalidate(ctx)
+	return d.DAO.UpdateRunStatus(ctx, runID, status, message)
+}
+
+func (d *homePageCachingDAO) MarkRunDeleted(ctx context.Context, runID int, at time.Time, deletedBy string) error {
+	defer d.invalidate(ctx)
+	return d.DAO.MarkRunDeleted(ctx, runID, at, deletedBy)
+}
+
+func (d *homePageCachingDAO) RestoreRun(ctx context.Context, runID int) error {
+	defer d.invalidate(ctx)
+	return d.DAO.RestoreRun(ctx, runID)
+}
+
+func (d *homePageCachingDAO) ArchiveRun(ctx context.Context, runID int, at time.Time) error {
+	defer d.invalidate(ctx)
+	return d.DAO.ArchiveRun(ctx, runID, at)
+}
+
+func (d *homePageCachingDAO) UnarchiveRun(ctx context.Context, runID int) error {
+	defer d.invalidate(ctx)
+	return d.DAO.UnarchiveRun(ctx, runID)
+}
+
+func (d *homePageCachingDAO) FailStaleRuns(ctx context.Context, cutoff time.Time) ([]string, error) {
+	defer d.invalidate(ctx)
+	return d.DAO.FailStaleRuns(ctx, cutoff)
+}
+
+// initHomePageCache wraps the global DAO so writes invalidate the cache and
+// loads the cache up front so the first home page request is fast too
+func initHomePageCache(ctx context.Context) {
+	dao = &homePageCachingDAO{DAO: dao, cache: homeCache}
+	if _, _, err := homeCache.get(ctx, dao); err != nil {
+		log.Printf("Failed to warm home page cache: %v", err)
+	}
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// countingHomeDAO counts the queries the home page cache makes
+type countingHomeDAO struct {
+	DAO
+	experimentQueries int
+	runQueries        int
+}
+
+func (d *countingHomeDAO) GetAllExperiments(ctx context.Context) ([]Experiment, error) {
+	d.experimentQueries++
+	return []Experiment{{UUID: "exp", Name: "Experiment"}}, nil
+}
+
+func (d *countingHomeDAO) GetRuns(ctx context.Context, offset, limit int, sortBy, sortDir string, filter RunFilter) ([]RunSummary, error) {
+	d.runQueries++
+	return []RunSummary{{UUID: "run", Name: "Run"}}, nil
+}
+
+func (d *countingHomeDAO) InsertRun(ctx context.Context, uuid, name string, experimentID int, parentRunID *int) error {
+	return nil
+}
+
+func TestHomePageCache(t *testing.T) {
+	ctx := t.Context()
+	backing := &countingHomeDAO{}
+	cache := &homePageCache{}
+	cached := &homePageCachingDAO{DAO: backing, cache: cache}
+
+	for i := 0; i < 3; i++ {
+		experiments, runs, err := cache.get(ctx, cached)
+		if err != nil {
+			t.Fatalf("get failed: %v", err)
+		}
+		if len(experiments) != 1 || len(runs) != 1 {
+			t.Fatalf("get returned %v, %v", experiments, runs)
+		}
+	}
+	if backing.experimentQueries != 1 || backing.runQueries != 1 {
+		t.Errorf("Expected a single load, got %d experiment and %d run queries", backing.experimentQueries, backing.runQueries)
+	}
+
+	// Creating a run invalidates the cache
+	if err := cached.InsertRun(ctx, "new-run", "New Run", 1, nil); err != nil {
+		t.Fatalf("InsertRun failed: %v", err)
+	}
+	cache.get(ctx, cached)
+	if backing.runQueries != 2 {
+		t.Errorf("Expected a reload after InsertRun, got %d run queries", backing.runQueries)
+	}
+
+	// Entries older than the TTL are reloaded
+	cache.mu.Lock()
+	cache.loadedAt = time.Now().Add(-2 * homePageCacheTTL)
+	cache.mu.Unlock()
+	cache.get(ctx, cached)
+	if backing.runQueries != 3 {
+		t.Errorf("Expected a reload after the TTL expired, got %d run queries", backing.runQueries)
+	}
+}
+
+func TestParseRunListPage(t *testing.T) {
+	tests := []struct {
+		query string
+		want  RunListPage
+	}{
+		{"", RunListPage{Page: 1, Sort: "created", Dir: "desc"}},
+		{"page=3", RunListPage{Page: 3, Sort: "created", Dir: "desc"}},
+		{"sort=name", RunListPage{Page: 1, Sort: "name", Dir: "asc"}},
+		{"sort=name&dir=desc&page=2", RunListPage{Page: 2, Sort: "name", Dir: "desc"}},
+		{"sort=id;drop&dir=sideways&page=-1", RunListPage{Page: 1, Sort: "created", Dir: "desc"}},
+	}
+	for _, tt := range tests {
+		query, _ := url.ParseQuery(tt.query)
+		if got := parseRunListPage(query, serverRunListView.normalized()); got.Page != tt.want.Page || got.Sort != tt.want.Sort || got.Dir != tt.want.Dir {
+			t.Errorf("parseRunListPage(%q) = %+v, want %+v", tt.query, got, tt.want)
+		}
+	}
+
+	// A run query is kept across pages, and one that does not parse is
+	// reported rather than ignored
+	queried := parseRunListPage(url.Values{"q": {"metrics.loss < 0.2"}}, serverRunListView.normalized())
+	if queried.Filter().Query == nil || queried.isDefault() || queried.NextURL() != "/?page=2&q=metrics.loss+%3C+0.2" {
+		t.Errorf("Unexpected page for a run query: %+v", queried)
+	}
+	if invalid := parseRunListPage(url.Values{"q": {"loss < 0.2"}}, serverRunListView.normalized()); invalid.QueryError == "" || invalid.Filter().Query != nil {
+		t.Errorf("Expected an invalid run query to be reported, got %+v", invalid)
+	}
+
+	// Preset and custom creation ranges combine, the later start winning;
+	// custom ranges include their last day
+	created := parseRunListPage(url.Values{"created": {"7d"}, "created_from": {"2000-01-01"}, "created_to": {"2100-01-31"}}, serverRunListView.normalized())
+	filter := created.Filter()
+	if since := time.Since(filter.CreatedAfter); since < 7*24*time.Hour-time.Minute || since > 7*24*time.Hour+time.Minute {
+		t.Errorf("Expected runs created in the last 7 days, got %v", filter.CreatedAfter)
+	}
+	if want := time.Date(2100, 2, 1, 0, 0, 0, 0, time.UTC); !filter.CreatedBefore.Equal(want) {
+		t.Errorf("Expected runs created before %v, got %v", want, filter.CreatedBefore)
+	}
+	if created.isDefault() || created.NextURL() != "/?created=7d&created_from=2000-01-01&created_to=2100-01-31&page=2" {
+		t.Errorf("Unexpected next page URL %q", created.NextURL())
+	}
+	if ignored := parseRunListPage(url.Values{"created": {"1y"}, "created_from": {"yesterday"}}, serverRunListView.normalized()); ignored.IsCreatedFiltered() || !ignored.Filter().CreatedAfter.IsZero() {
+		t.Errorf("Expected unknown creation ranges to be ignored, got %+v", ignored)
+	}
+
+	// Commits may be abbreviated, and are ignored unless they are hex
+	bySource := parseRunListPage(url.Values{"user": {" ada "}, "commit": {"0123ABC"}}, serverRunListView.normalized())
+	if filter := bySource.Filter(); filter.User != "ada" || filter.GitCommit != "0123abc" || bySource.isDefault() || bySource.NextURL() != "/?commit=0123abc&page=2&user=ada" {
+		t.Errorf("Unexpected page for a user and commit: %+v with next page %q", bySource, bySource.NextURL())
+	}
+	if ignored := parseRunListPage(url.Values{"commit": {"main"}}, serverRunListView.normalized()); ignored.IsSourceFiltered() || ignored.Filter().GitCommit != "" {
+		t.Errorf("Expected a commit that is not hex to be ignored, got %+v", ignored)
+	}
+
+	// Archived runs are hidden unless asked for
+	if !parseRunListPage(url.Values{}, serverRunListView.normalized()).Filter().HideArchived {
+		t.Errorf("Expected archived runs hidden by default")
+	}
+	archived := parseRunListPage(url.Values{"archived": {"1"}}, serverRunListView.normalized())
+	if archived.Filter().HideArchived || archived.isDefault() || archived.NextURL() != "/?archived=1&page=2" {
+		t.Errorf("Expected archived runs shown, got %+v with next page %q", archived, archived.NextURL())
+	}
+}
+
+func TestParseCreatedWithin(t *testing.T) {
+	for s, want := range map[string]time.Duration{"24h": 24 * time.Hour, "7d": 7 * 24 * time.Hour, "90m": 90 * time.Minute, "0d": 0, "-1h": 0, "d": 0, "week": 0} {
+		got, err := parseCreatedWithin(s)
+		if (err != nil) != (want == 0) || got != want {
+			t.Errorf("parseCreatedWithin(%q) = %v, %v; want %v", s, got, err, want)
+		}
+	}
+}
+
+func TestRunListPageURLs(t *testing.T) {
+	first := RunListPage{Page: 1, Sort: "created", Dir: "desc"}
+	if got := first.NextURL(); got != "/?page=2" {
+		t.Errorf("NextURL() = %q", got)
+	}
+	if got := first.SortURL("created"); got != "/?dir=asc&sort=created" {
+		t.Errorf("SortURL(created) = %q", got)
+	}
+	if got := first.SortURL("name"); got != "/?dir=asc&sort=name" {
+		t.Errorf("SortURL(name) = %q", got)
+	}
+
+	byName := RunListPage{Page: 2, Sort: "name", Dir: "asc"}
+	if got := byName.PrevURL(); got != "/?dir=asc&sort=name" {
+		t.Errorf("PrevURL() = %q", got)
+	}
+	if got := byName.SortURL("name"); got != "/?dir=desc&sort=name" {
+		t.Errorf("SortURL(name) = %q", got)
+	}
+	if got := byName.SortIndicator("name") + byName.SortIndicator("created"); got != "▲" {
+		t.Errorf("SortIndicator = %q", got)
+	}
+}
+
+func TestWithRuns(t *testing.T) {
+	runs := make([]RunSummary, homePageRunsPerPage+1)
+	if p := withRuns(RunListPage{Page: 1}, runs); !p.HasNext || len(p.Runs) != homePageRunsPerPage {
+		t.Errorf("Expected a full page with a next page, got %d runs, HasNext %v", len(p.Runs), p.HasNext)
+	}
+	if p := withRuns(RunListPage{Page: 1}, runs[:3]); p.HasNext || len(p.Runs) != 3 {
+		t.Errorf("Expected a last page of 3 runs, got %d runs, HasNext %v", len(p.Runs), p.HasNext)
+	}
+}
+
+// sharedGenerationDAO keeps cache generations as if in a database shared by
+// replicas
+type sharedGenerationDAO struct {
+	countingHomeDAO
+	generations map[string]int64
+}
+
+func (d *sharedGenerationDAO) GetCacheGeneration(ctx context.Context, name string) (int64, error) {
+	return d.generations[name], nil
+}
+
+func (d *sharedGenerationDAO) BumpCacheGeneration(ctx context.Context, name string) error {
+	d.generations[name]++
+	return nil
+}
+
+func TestHomePageCacheAcrossReplicas(t *testing.T) {
+	ctx := t.Context()
+	defer func(m bool) { multiInstance = m }(multiInstance)
+	multiInstance = true
+
+	backing := &sharedGenerationDAO{generations: map[string]int64{}}
+	replicaCache, otherCache := &homePageCache{}, &homePageCache{}
+	replica := &homePageCachingDAO{DAO: backing, cache: replicaCache}
+	other := &homePageCachingDAO{DAO: backing, cache: otherCache}
+
+	replicaCache.get(ctx, replica)
+	replicaCache.get(ctx, replica)
+	if backing.runQueries != 1 {
+		t.Fatalf("Expected a single load, got %d run queries", backing.runQueries)
+	}
+
+	// A run created through another replica invalidates this replica's cache
+	if err := other.InsertRun(ctx, "new-run", "New Run", 1, nil); err != nil {
+		t.Fatalf("InsertRun failed: %v", err)
+	}
+	replicaCache.get(ctx, replica)
+	if backing.runQueries != 2 {
+		t.Errorf("Expected a reload after another replica's InsertRun, got %d run queries", backing.runQueries)
+	}
+	replicaCache.get(ctx, replica)
+	if backing.runQueries != 2 {
+		t.Errorf("Expected the reload to be cached, got %d run queries", backing.runQueries)
+	}
+}
+
+func TestMetricSort(t *testing.T) {
+	page := parseRunListPage(url.Values{"sort": {"metric"}, "metric": {"val/loss"}}, serverRunListView.normalized())
+	if page.Sort != "metric:val/loss" || page.Dir != "asc" || page.SortMetric() != "val/loss" {
+		t.Fatalf("Expected runs sorted by val/loss, got %+v", page)
+	}
+	if got := page.NextURL(); got != "/?dir=asc&page=2&sort=metric%3Aval%2Floss" {
+		t.Errorf("NextURL() = %q", got)
+	}
+	if again := parseRunListPage(url.Values{"sort": {"metric:val/loss"}}, serverRunListView.normalized()); again.Sort != page.Sort {
+		t.Errorf("Expected the page URL to sort by the metric, got %+v", again)
+	}
+	if empty := parseRunListPage(url.Values{"sort": {"metric"}}, serverRunListView.normalized()); empty.Sort != defaultRunSort {
+		t.Errorf("Expected a metric sort without a key ignored, got %+v", empty)
+	}
+
+	value, orderBy, args, err := runsOrderBy("metric:val/loss", "desc", func(n int) string { return "$1" })
+	if err != nil || !strings.Contains(value, "m.key = $1") || orderBy != "sort_value desc NULLS LAST, r.id desc" || len(args) != 1 || args[0] != "val/loss" {
+		t.Errorf("runsOrderBy(metric:val/loss) = %q, %q, %v, %v", value, orderBy, args, err)
+	}
+}
+
+func TestRunListView(t *testing.T) {
+	defer func(view RunListView) { serverRunListView = view }(serverRunListView)
+	serverRunListView = RunListView{Sort: "name", Density: "compact"}
+
+	request := httptest.NewRequest(http.MethodGet, "/", nil)
+	if view := runListViewFor(request); view != (RunListView{Sort: "name", Dir: "asc", Density: "compact"}) {
+		t.Errorf("Expected the server's view, got %+v", view)
+	}
+
+	// A user's saved view overrides the server's
+	w := httptest.NewRecorder()
+	form := url.Values{"sort": {"metric"}, "metric": {"accuracy"}, "dir": {"desc"}, "density": {"comfortable"}}
+	post := httptest.NewRequest(http.MethodPost, "/preferences/run-list", strings.NewReader(form.Encode()))
+	post.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	handleRunListPreferences(w, post)
+	if w.Code != http.StatusSeeOther || len(w.Result().Cookies()) != 1 {
+		t.Fatalf("Expected the view saved in a cookie, got %d %v", w.Code, w.Result().Cookies())
+	}
+	request.AddCookie(w.Result().Cookies()[0])
+	view := runListViewFor(request)
+	if view != (RunListView{Sort: "metric:accuracy", Dir: "desc", Density: "comfortable"}) {
+		t.Errorf("Expected the saved view, got %+v", view)
+	}
+
+	page := parseRunListPage(url.Values{}, view)
+	if page.Sort != "metric:accuracy" || page.Density != "comfortable" || page.isDefault() {
+		t.Errorf("Expected the page in the saved view, got %+v", page)
+	}
+	// Links leave out only what the user's and the server's defaults agree on
+	if got := page.NextURL(); got != "/?density=comfortable&dir=desc&page=2&sort=metric%3Aaccuracy" {
+		t.Errorf("NextURL() = %q", got)
+	}
+	if got := page.SortURL("name"); got != "/?density=comfortable&dir=asc&sort=name" {
+		t.Errorf("SortURL(name) = %q", got)
+	}
+	if page := parseRunListPage(url.Values{}, serverRunListView.normalized()); !page.isDefault() || page.NextURL() != "/?page=2" {
+		t.Errorf("Expected the server's view to be left out, got %q", page.NextURL())
+	}
+
+	w = httptest.NewRecorder()
+	handleRunListPreferences(w, httptest.NewRequest(http.MethodPost, "/preferences/run-list?reset=1", nil))
+	if cookies := w.Result().Cookies(); len(cookies) != 1 || cookies[0].MaxAge >= 0 {
+		t.Errorf("Expected the saved view forgotten, got %v", cookies)
+	}
+}
@@ -0,0 +1,565 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Housekeeping jobs delete data the server no longer needs. A job never
+// deletes anything it has not first listed in a dry run report, which is
+// kept in the artifact store for admins to review. Deleting means carrying
+// out a reviewed dry run: only the items it listed are deleted, and only
+// those still eligible when it is carried out.
+
+var (
+	// housekeepingInterval is how often every housekeeping job is dry run
+	// (0 disables). Dry runs never delete anything.
+	housekeepingInterval time.Duration
+	// housekeepingPlanMaxAge is how old a dry run can be and still be
+	// carried out
+	housekeepingPlanMaxAge = 24 * time.Hour
+)
+
+// housekeepingReportPrefix is where housekeeping reports are kept in the
+// artifact store. It cannot collide with a run UUID, and files beneath it are
+// only served to admins.
+const housekeepingReportPrefix = "_housekeeping/"
+
+// housekeepingReportListLimit is how many recent reports the admin API lists
+const housekeepingReportListLimit = 50
+
+// HousekeepingItem is a piece of data a housekeeping job deletes
+type HousekeepingItem struct {
+	Kind    string `json:"kind"`
+	Key     string `json:"key"`
+	RunUUID string `json:"run_uuid,omitempty"`
+	Bytes   int64  `json:"bytes"`
+	// Reason is why the item was skipped, for skipped items
+	Reason string `json:"reason,omitempty"`
+}
+
+// HousekeepingRun summarizes the items of a report belonging to one run
+type HousekeepingRun struct {
+	UUID  string `json:"uuid"`
+	Name  string `json:"name,omitempty"`
+	Items int    `json:"items"`
+	Bytes int64  `json:"bytes"`
+}
+
+// HousekeepingReport is what a housekeeping job would delete, for a dry run,
+// or did delete, for an execution. Items held back, e.g. because their run is
+// on hold, are listed as skipped.
+type HousekeepingReport struct {
+	Job         string             `json:"job"`
+	DryRun      bool               `json:"dry_run"`
+	GeneratedAt time.Time          `json:"generated_at"`
+	PlanID      int                `json:"plan_id,omitempty"`
+	Counts      map[string]int     `json:"counts"`
+	Bytes       int64              `json:"bytes"`
+	Runs        []HousekeepingRun  `json:"runs"`
+	Items       []HousekeepingItem `json:"items"`
+	Skipped     []HousekeepingItem `json:"skipped"`
+}
+
+// housekeepingJob is a background job that deletes data
+type housekeepingJob struct {
+	Name        string
+	Description string
+	// plan lists the items the job would delete now, and the items it would
+	// but may not, without deleting anything
+	plan func(ctx context.Context) (items, skipped []HousekeepingItem, err error)
+	// deleteItem deletes one planned item
+	deleteItem func(ctx context.Context, item HousekeepingItem) error
+}
+
+// housekeepingJobs are the housekeeping jobs, in the order they are run
+var housekeepingJobs = []*housekeepingJob{
+	{
+		Name:        "artifact-gc",
+		Description: "Deletes files in the artifact store that no artifact refers to, such as uploads whose metadata failed to save",
+		plan:        planArtifactGC,
+		deleteItem:  func(ctx context.Context, item HousekeepingItem) error { return artifactStore.Delete(item.Key) },
+	},
+	{
+		Name:        "archived-runs",
+		Description: "Deletes runs, with their artifacts, archived longer ago than -delete-archived-runs-after-days",
+		plan:        planArchivedRunDeletion,
+		deleteItem:  deleteArchivedRun,
+	},
+	{
+		Name:        "expired-uploads",
+		Description: "Deletes chunked artifact uploads that have received no chunks for longer than -artifact-upload-expiry, with the chunks staged for them",
+		plan:        planExpiredArtifactUploads,
+		deleteItem:  deleteExpiredArtifactUpload,
+	},
+	{
+		Name:        "metric-namespaces",
+		Description: "Deletes the metric points of runs that ended longer ago than their experiment keeps points of the metric's namespace for, keeping the metrics' summaries",
+		plan:        planMetricNamespacePruning,
+		deleteItem:  pruneNamespaceMetrics,
+	},
+	{
+		Name:        "trash-purge",
+		Description: "Purges deleted runs, with their artifacts, that have been in the trash for -purge-deleted-runs-after-days and whose deleter has been sent the purge notice, and deleted runs whose purge failed",
+		plan:        planTrashPurge,
+		deleteItem:  purgeDeletedRun,
+	},
+}
+
+// lookupHousekeepingJob finds a housekeeping job by name
+func lookupHousekeepingJob(name string) *housekeepingJob {
+	for _, job := range housekeepingJobs {
+		if job.Name == name {
+			return job
+		}
+	}
+	return nil
+}
+
+// errHousekeepingPlanNotFound is returned when carrying out a dry run that
+// does not exist
+var errHousekeepingPlanNotFound = errors.New("dry run report not found")
+
+// housekeepingPlanError is returned when a report cannot be carried out,
+// e.g. because it is stale
+type housekeepingPlanError struct {
+	message string
+}
+
+func (e *housekeepingPlanError) Error() string {
+	return e.message
+}
+
+// newHousekeepingReport summarizes a job's items into a report
+func newHousekeepingReport(job string, dryRun bool, items, skipped []HousekeepingItem, now time.Time) *HousekeepingReport {
+	report := &HousekeepingReport{
+		Job:         job,
+		DryRun:      dryRun,
+		GeneratedAt: now.UTC(),
+		Counts:      map[string]int{},
+		Runs:        []HousekeepingRun{},
+		Items:       items,
+		Skipped:     skipped,
+	}
+	if report.Items == nil {
+		report.Items = []HousekeepingItem{}
+	}
+	if report.Skipped == nil {
+		report.Skipped = []HousekeepingItem{}
+	}
+
+	runs := make(map[string]*HousekeepingRun)
+	for _, item := range items {
+		report.Counts[item.Kind]++
+		report.Bytes += item.Bytes
+		if item.RunUUID == "" {
+			continue
+		}
+		run, ok := runs[item.RunUUID]
+		if !ok {
+			run = &HousekeepingRun{UUID: item.RunUUID}
+			runs[item.RunUUID] = run
+		}
+		run.Items++
+		run.Bytes += item.Bytes
+	}
+	for _, run := range runs {
+		report.Runs = append(report.Runs, *run)
+	}
+	sort.Slice(report.Runs, func(i, j int) bool { return report.Runs[i].UUID < report.Runs[j].UUID })
+	return report
+}
+
+// saveHousekeepingReport stores a report in the artifact store and records
+// it, returning its ID
+func saveHousekeepingReport(ctx context.Context, report *HousekeepingReport) (int, error) {
+	for i := range report.Runs {
+		// Runs whose data is left over after they were deleted have no name
+		if run, err := dao.GetRunByUUID(ctx, report.Runs[i].UUID); err == nil {
+			report.Runs[i].Name = run.Name
+		}
+	}
+
+	content, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return 0, err
+	}
+	kind := "executed"
+	if report.DryRun {
+		kind = "dry-run"
+	}
+	key := fmt.Sprintf("%s%s/%s-%s.json", housekeepingReportPrefix, report.Job, report.GeneratedAt.Format("20060102T150405.000Z"), kind)
+	if _, err := artifactStore.Put(key, bytes.NewReader(content)); err != nil {
+		return 0, fmt.Errorf("storing report: %w", err)
+	}
+
+	row := HousekeepingReportRow{
+		Job:       report.Job,
+		DryRun:    report.DryRun,
+		URI:       artifactStore.URI(key),
+		ItemCount: len(report.Items),
+		RunCount:  len(report.Runs),
+		SizeBytes: report.Bytes,
+		CreatedAt: report.GeneratedAt,
+	}
+	if report.PlanID != 0 {
+		row.PlanID.Int64, row.PlanID.Valid = int64(report.PlanID), true
+	}
+	return dao.InsertHousekeepingReport(ctx, row)
+}
+
+// loadHousekeepingReport reads a stored report
+func loadHousekeepingReport(ctx context.Context, row *HousekeepingReportRow) (*HousekeepingReport, error) {
+	file, err := openArtifact(ctx, row.URI)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var report HousekeepingReport
+	if err := json.NewDecoder(file).Decode(&report); err != nil {
+		return nil, fmt.Errorf("reading report %d: %w", row.ID, err)
+	}
+	return &report, nil
+}
+
+// dryRunHousekeepingJob lists what a job would delete now, returning the ID
+// of the report
+func dryRunHousekeepingJob(ctx context.Context, job *housekeepingJob) (int, *HousekeepingReport, error) {
+	items, skipped, err := job.plan(ctx)
+	if err != nil {
+		return 0, nil, err
+	}
+	report := newHousekeepingReport(job.Name, true, items, skipped, time.Now())
+	id, err := saveHousekeepingReport(ctx, report)
+	return id, report, err
+}
+
+// executeHousekeepingPlan carries out a dry run, deleting the items it listed
+// that are still eligible, and returns the ID of the execution's report
+func executeHousekeepingPlan(ctx context.Context, planID int) (int, *HousekeepingReport, error) {
+	row, err := dao.GetHousekeepingReport(ctx, planID)
+	if err != nil {
+		return 0, nil, err
+	}
+	if row == nil {
+		return 0, nil, errHousekeepingPlanNotFound
+	}
+	if !row.DryRun {
+		return 0, nil, &housekeepingPlanError{fmt.Sprintf("report %d is not a dry run", planID)}
+	}
+	if row.ExecutedAt.Valid {
+		return 0, nil, &housekeepingPlanError{fmt.Sprintf("dry run %d was already carried out", planID)}
+	}
+	if age := time.Since(row.CreatedAt); age > housekeepingPlanMaxAge {
+		return 0, nil, &housekeepingPlanError{fmt.Sprintf("dry run %d is older than %s; dry run %s again", planID, housekeepingPlanMaxAge, row.Job)}
+	}
+	job := lookupHousekeepingJob(row.Job)
+	if job == nil {
+		return 0, nil, &housekeepingPlanError{fmt.Sprintf("unknown housekeeping job %q", row.Job)}
+	}
+	plan, err := loadHousekeepingReport(ctx, row)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	// Claim the dry run, so that it is carried out at most once
+	claimed, err := dao.MarkHousekeepingReportExecuted(ctx, planID, time.Now().UTC())
+	if err != nil {
+		return 0, nil, err
+	}
+	if !claimed {
+		return 0, nil, &housekeepingPlanError{fmt.Sprintf("dry run %d was already carried out", planID)}
+	}
+
+	// Items may have become ineligible since the dry run, e.g. because their
+	// run was put on hold
+	currentItems, currentSkipped, err := job.plan(ctx)
+	if err != nil {
+		return 0, nil, err
+	}
+	itemID := func(item HousekeepingItem) string { return item.Kind + "\x00" + item.Key }
+	eligible := make(map[string]bool, len(currentItems))
+	for _, item := range currentItems {
+		eligible[itemID(item)] = true
+	}
+	heldBack := make(map[string]string, len(currentSkipped))
+	for _, item := range currentSkipped {
+		heldBack[itemID(item)] = item.Reason
+	}
+
+	var deleted, skipped []HousekeepingItem
+	for _, item := range plan.Items {
+		if !eligible[itemID(item)] {
+			item.Reason = heldBack[itemID(item)]
+			if item.Reason == "" {
+				item.Reason = "no longer eligible"
+			}
+			skipped = append(skipped, item)
+			continue
+		}
+		if err := job.deleteItem(ctx, item); err != nil {
+			log.Printf("Housekeeping job %s failed to delete %s %s: %v", job.Name, item.Kind, item.Key, err)
+			item.Reason = fmt.Sprintf("delete failed: %v", err)
+			skipped = append(skipped, item)
+			continue
+		}
+		deleted = append(deleted, item)
+	}
+
+	report := newHousekeepingReport(job.Name, false, deleted, skipped, time.Now())
+	report.PlanID = planID
+	id, err := saveHousekeepingReport(ctx, report)
+	return id, report, err
+}
+
+// planArtifactGC lists the files in the artifact store that no artifact
+// refers to. Files of runs on hold are kept.
+func planArtifactGC(ctx context.Context) (items, skipped []HousekeepingItem, err error) {
+	uris, err := dao.GetAllArtifactURIs(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	referenced := make(map[string]bool, len(uris))
+	for _, uri := range uris {
+		if key, err := artifactKey(uri); err == nil {
+			referenced[key] = true
+		}
+	}
+
+	keys, err := artifactStore.List("")
+	if err != nil {
+		return nil, nil, err
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if referenced[key] || strings.HasPrefix(key, housekeepingReportPrefix) || strings.HasPrefix(key, experimentArchivePrefix) || strings.HasPrefix(key, artifactUploadPrefix) {
+			continue
+		}
+		size, err := artifactStore.Size(key)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		runUUID, _, _ := strings.Cut(key, "/")
+		item := HousekeepingItem{Kind: "artifact_file", Key: key, RunUUID: runUUID, Bytes: size}
+		if runID, err := dao.GetRunIDByUUID(ctx, runUUID); err == nil {
+			if err := ensureRunNotOnHold(ctx, runID); errors.Is(err, errRunOnHold) {
+				item.Reason = "run is on hold"
+				skipped = append(skipped, item)
+				continue
+			} else if err != nil {
+				return nil, nil, err
+			}
+		}
+		items = append(items, item)
+	}
+	return items, skipped, nil
+}
+
+// startHousekeeping dry runs every housekeeping job periodically, so that
+// fresh reports are waiting for review
+func startHousekeeping(ctx context.Context) {
+	if housekeepingInterval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(housekeepingInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if !holdJobLease(ctx, "housekeeping", 2*housekeepingInterval) {
+				continue
+			}
+			for _, job := range housekeepingJobs {
+				id, report, err := dryRunHousekeepingJob(ctx, job)
+				if err != nil {
+					log.Printf("Housekeeping dry run of %s failed: %v", job.Name, err)
+					continue
+				}
+				if len(report.Items) > 0 {
+					log.Printf("Housekeeping dry run %d of %s would delete %d items (%s) from %d runs", id, job.Name, len(report.Items), formatBytes(report.Bytes), len(report.Runs))
+				}
+			}
+		}
+	}()
+	log.Printf("Housekeeping jobs are dry run every %s", housekeepingInterval)
+}
+
+// HousekeepingRequest is the body of POST /api/admin/housekeeping
+type HousekeepingRequest struct {
+	Job    string `json:"job"`
+	DryRun bool   `json:"dry_run"`
+	PlanID int    `json:"plan_id"`
+}
+
+// handleAPIHousekeeping lists housekeeping jobs and reports, or returns one
+// report (GET), and dry runs a job or carries out a dry run (POST). All
+// housekeeping operations are admin operations.
+func handleAPIHousekeeping(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		if reportID := r.URL.Query().Get("report_id"); reportID != "" {
+			handleAPIGetHousekeepingReport(ctx, w, reportID)
+			return
+		}
+		handleAPIListHousekeeping(ctx, w)
+		return
+	}
+
+	var req HousekeepingRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid JSON"})
+		return
+	}
+	if req.Job == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Missing required field: job"})
+		return
+	}
+	job := lookupHousekeepingJob(req.Job)
+	if job == nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": fmt.Sprintf("Unknown housekeeping job %q", req.Job)})
+		return
+	}
+
+	var id int
+	var report *HousekeepingReport
+	var err error
+	switch {
+	case req.DryRun && req.PlanID != 0:
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Either dry run a job or carry out a dry run's plan_id, not both"})
+		return
+	case req.DryRun:
+		id, report, err = dryRunHousekeepingJob(ctx, job)
+	case req.PlanID != 0:
+		if row, err := dao.GetHousekeepingReport(ctx, req.PlanID); err == nil && row != nil && row.Job != job.Name {
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(map[string]string{"error": fmt.Sprintf("Report %d is a report of %s, not %s", req.PlanID, row.Job, job.Name)})
+			return
+		}
+		id, report, err = executeHousekeepingPlan(ctx, req.PlanID)
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Deleting requires the plan_id of a reviewed dry run; dry run the job first with dry_run: true"})
+		return
+	}
+
+	var planErr *housekeepingPlanError
+	switch {
+	case errors.Is(err, errHousekeepingPlanNotFound):
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Dry run report not found"})
+		return
+	case errors.As(err, &planErr):
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]string{"error": planErr.Error()})
+		return
+	case err != nil:
+		log.Printf("Housekeeping job %s failed: %v", job.Name, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": fmt.Sprintf("Housekeeping job %s failed", job.Name)})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": "ok", "report_id": id, "report": report})
+}
+
+func handleAPIListHousekeeping(ctx context.Context, w http.ResponseWriter) {
+	rows, err := dao.GetHousekeepingReports(ctx, housekeepingReportListLimit)
+	if err != nil {
+		log.Printf("Failed to query housekeeping reports: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to query housekeeping reports"})
+		return
+	}
+
+	type jobView struct {
+		Name        string `json:"name"`
+		Description string `json:"description"`
+	}
+	type reportView struct {
+		ID         int    `json:"id"`
+		Job        string `json:"job"`
+		DryRun     bool   `json:"dry_run"`
+		Items      int    `json:"items"`
+		Runs       int    `json:"runs"`
+		Bytes      int64  `json:"bytes"`
+		PlanID     int64  `json:"plan_id,omitempty"`
+		CreatedAt  string `json:"created_at"`
+		ExecutedAt string `json:"executed_at,omitempty"`
+	}
+	jobs := make([]jobView, len(housekeepingJobs))
+	for i, job := range housekeepingJobs {
+		jobs[i] = jobView{Name: job.Name, Description: job.Description}
+	}
+	reports := make([]reportView, len(rows))
+	for i, row := range rows {
+		reports[i] = reportView{
+			ID:        row.ID,
+			Job:       row.Job,
+			DryRun:    row.DryRun,
+			Items:     row.ItemCount,
+			Runs:      row.RunCount,
+			Bytes:     row.SizeBytes,
+			PlanID:    row.PlanID.Int64,
+			CreatedAt: row.CreatedAt.UTC().Format(time.RFC3339),
+		}
+		if row.ExecutedAt.Valid {
+			reports[i].ExecutedAt = row.ExecutedAt.Time.UTC().Format(time.RFC3339)
+		}
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"jobs": jobs, "reports": reports})
+}
+
+func handleAPIGetHousekeepingReport(ctx context.Context, w http.ResponseWriter, reportID string) {
+	id, err := strconv.Atoi(reportID)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid report_id"})
+		return
+	}
+	row, err := dao.GetHousekeepingReport(ctx, id)
+	if err != nil {
+		log.Printf("Failed to query housekeeping report %d: %v", id, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to query housekeeping report"})
+		return
+	}
+	if row == nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Report not found"})
+		return
+	}
+	file, err := openArtifact(ctx, row.URI)
+	if err != nil {
+		log.Printf("Failed to open housekeeping report %d: %v", id, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to read housekeeping report"})
+		return
+	}
+	defer file.Close()
+	io.Copy(w, file)
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// housekeepingDAO keeps artifacts, holds and housekeeping reports in memory
+type housekeepingDAO struct {
+	DAO
+	uris    []string
+	runs    map[string]int
+	held    map[int]bool
+	reports []HousekeepingReportRow
+}
+
+func (d *housekeepingDAO) GetAllArtifactURIs(ctx context.Context) ([]string, error) {
+	return d.uris, nil
+}
+
+func (d *housekeepingDAO) GetRunIDByUUID(ctx context.Context, uuid string) (int, error) {
+	if id, ok := d.runs[uuid]; ok {
+		return id, nil
+	}
+	return 0, sql.ErrNoRows
+}
+
+func (d *housekeepingDAO) GetRunByUUID(ctx context.Context, uuid string) (*Run, error) {
+	return nil, sql.ErrNoRows
+}
+
+func (d *housekeepingDAO) GetRunHold(ctx context.Context, runID int) (*RunHoldRow, error) {
+	if d.held[runID] {
+		return &RunHoldRow{Reason: "audit"}, nil
+	}
+	return nil, nil
+}
+
+func (d *housekeepingDAO) InsertHousekeepingReport(ctx context.Context, r HousekeepingReportRow) (int, error) {
+	r.ID = len(d.reports) + 1
+	d.reports = append(d.reports, r)
+	return r.ID, nil
+}
+
+func (d *housekeepingDAO) GetHousekeepingReport(ctx context.Context, id int) (*HousekeepingReportRow, error) {
+	if id < 1 || id > len(d.reports) {
+		return nil, nil
+	}
+	r := d.reports[id-1]
+	return &r, nil
+}
+
+func (d *housekeepingDAO) MarkHousekeepingReportExecuted(ctx context.Context, id int, executedAt time.Time) (bool, error) {
+	r := &d.reports[id-1]
+	if !r.DryRun || r.ExecutedAt.Valid {
+		return false, nil
+	}
+	r.ExecutedAt = sql.NullTime{Time: executedAt, Valid: true}
+	return true, nil
+}
+
+func TestNewHousekeepingReport(t *testing.T) {
+	items := []HousekeepingItem{
+		{Kind: "artifact_file", Key: "b/x", RunUUID: "b", Bytes: 10},
+		{Kind: "artifact_file", Key: "a/y", RunUUID: "a", Bytes: 5},
+		{Kind: "artifact_file", Key: "a/z", RunUUID: "a", Bytes: 7},
+	}
+	report := newHousekeepingReport("artifact-gc", true, items, nil, time.Now())
+	if report.Counts["artifact_file"] != 3 || report.Bytes != 22 {
+		t.Errorf("Expected 3 files of 22 bytes, got %v and %d bytes", report.Counts, report.Bytes)
+	}
+	if len(report.Runs) != 2 || report.Runs[0] != (HousekeepingRun{UUID: "a", Items: 2, Bytes: 12}) {
+		t.Errorf("Unexpected runs %+v", report.Runs)
+	}
+	if report.Skipped == nil {
+		t.Errorf("Expected skipped items to be an empty list")
+	}
+}
+
+func TestHousekeepingArtifactGC(t *testing.T) {
+	ctx := t.Context()
+	defer func(d DAO, s ArtifactStore) { dao, artifactStore = d, s }(dao, artifactStore)
+	store, err := newFileArtifactStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	artifactStore = store
+	for _, key := range []string{"run1/kept.txt", "run1/orphan.txt", "run2/orphan.txt"} {
+		if _, err := store.Put(key, strings.NewReader("content")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	d := &housekeepingDAO{
+		uris: []string{store.URI("run1/kept.txt")},
+		runs: map[string]int{"run1": 1, "run2": 2},
+		held: map[int]bool{},
+	}
+	dao = d
+	job := lookupHousekeepingJob("artifact-gc")
+
+	planID, plan, err := dryRunHousekeepingJob(ctx, job)
+	if err != nil {
+		t.Fatalf("Dry run failed: %v", err)
+	}
+	if len(plan.Items) != 2 || plan.Bytes != 14 || len(plan.Runs) != 2 {
+		t.Fatalf("Expected the dry run to list both orphans, got %+v", plan)
+	}
+	// The dry run deletes nothing, and its report is itself kept out of GC
+	if _, err := store.Get("run2/orphan.txt"); err != nil {
+		t.Errorf("Expected the dry run to delete nothing, got %v", err)
+	}
+	if _, again, _ := dryRunHousekeepingJob(ctx, job); len(again.Items) != 2 {
+		t.Errorf("Expected reports not to be collected, got %+v", again.Items)
+	}
+
+	// run2 is put on hold after the dry run was reviewed
+	d.held[2] = true
+	_, executed, err := executeHousekeepingPlan(ctx, planID)
+	if err != nil {
+		t.Fatalf("Executing the dry run failed: %v", err)
+	}
+	if len(executed.Items) != 1 || executed.Items[0].Key != "run1/orphan.txt" || executed.PlanID != planID {
+		t.Errorf("Expected only run1's orphan to be deleted, got %+v", executed.Items)
+	}
+	if len(executed.Skipped) != 1 || executed.Skipped[0].Reason != "run is on hold" {
+		t.Errorf("Expected run2's orphan to be skipped, got %+v", executed.Skipped)
+	}
+	if _, err := store.Get("run1/orphan.txt"); err == nil {
+		t.Errorf("Expected run1's orphan to be deleted")
+	}
+	for _, key := range []string{"run1/kept.txt", "run2/orphan.txt"} {
+		if _, err := store.Get(key); err != nil {
+			t.Errorf("Expected %s to be kept, got %v", key, err)
+		}
+	}
+
+	var planErr *housekeepingPlanError
+	if _, _, err := executeHousekeepingPlan(ctx, planID); !errors.As(err, &planErr) {
+		t.Errorf("Expected a dry run to be carried out at most once, got %v", err)
+	}
+	if _, _, err := executeHousekeepingPlan(ctx, planID+2); !errors.As(err, &planErr) {
+		t.Errorf("Expected an execution report not to be carried out, got %v", err)
+	}
+	if _, _, err := executeHousekeepingPlan(ctx, 99); !errors.Is(err, errHousekeepingPlanNotFound) {
+		t.Errorf("Expected a missing dry run not to be found, got %v", err)
+	}
+
+	stale, _, err := dryRunHousekeepingJob(ctx, job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.reports[stale-1].CreatedAt = time.Now().Add(-housekeepingPlanMaxAge - time.Minute)
+	if _, _, err := executeHousekeepingPlan(ctx, stale); !errors.As(err, &planErr) || !strings.Contains(err.Error(), "older than") {
+		t.Errorf("Expected a stale dry run to be refused, got %v", err)
+	}
+}
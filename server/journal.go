@@ -0,0 +1,420 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// journalMaxBodyBytes bounds the request bodies written to the journal.
+// Larger requests are still served but cannot be replayed.
+const journalMaxBodyBytes = 64 << 20
+
+// JournalEntry is an ingestion request as recorded in the journal
+type JournalEntry struct {
+	Time       time.Time `json:"time"`
+	APIVersion int       `json:"api_version"`
+	Method     string    `json:"method"`
+	// Path is the unversioned API path, e.g. "/api/metrics"
+	Path        string `json:"path"`
+	Query       string `json:"query,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+	Body        []byte `json:"body,omitempty"`
+	// GeneratedUUID is the UUID the server assigned to the run or experiment
+	// the request created, which replay assigns again so that later requests
+	// referring to it still apply
+	GeneratedUUID string `json:"generated_uuid,omitempty"`
+}
+
+// Journal is a local append-only log of the ingestion requests the server has
+// acknowledged. After restoring the database from a backup, replaying the
+// journal from the backup's time recovers what was logged since. Entries are
+// written before the response is sent, and synced to disk every
+// fsyncInterval, which bounds the data a crash can lose.
+type Journal struct {
+	dir           string
+	fsyncInterval time.Duration
+
+	mu      sync.Mutex
+	file    *os.File
+	segment string
+	dirty   bool
+}
+
+// journal is the ingestion journal, or nil if journaling is disabled
+var journal *Journal
+
+// journalFsyncInterval is how often the journal is synced to disk; zero
+// syncs every entry before it is acknowledged
+var journalFsyncInterval = time.Second
+
+// replayingJournal is set while the replay command runs, so that replayed
+// requests do not repeat side effects such as notifications
+var replayingJournal bool
+
+type journalContextKey struct{}
+
+// journalRequest tracks the UUID generated while serving a journaled request,
+// or the one to reuse while replaying it
+type journalRequest struct {
+	uuid     string
+	replayed bool
+}
+
+func initJournal(dir string) {
+	if dir == "" {
+		return
+	}
+	var err error
+	journal, err = openJournal(dir, journalFsyncInterval)
+	if err != nil {
+		log.Fatalf("Could not open ingestion journal: %v", err)
+	}
+
+	log.Printf("Ingestion journal initialized at: %s", dir)
+}
+
+// openJournal opens the journal in dir, starting a background sync if
+// fsyncInterval is positive
+func openJournal(dir string, fsyncInterval time.Duration) (*Journal, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	j := &Journal{dir: dir, fsyncInterval: fsyncInterval}
+	if fsyncInterval > 0 {
+		go func() {
+			for range time.Tick(fsyncInterval) {
+				if err := j.Sync(); err != nil {
+					log.Printf("Failed to sync ingestion journal: %v", err)
+				}
+			}
+		}()
+	}
+	return j, nil
+}
+
+// journalSegmentName is the file entries written at t are appended to. The
+// journal is split into daily segments so that old ones can be deleted once
+// they are covered by a database backup.
+func journalSegmentName(t time.Time) string {
+	return "journal-" + t.UTC().Format("20060102") + ".jsonl"
+}
+
+// Append writes an entry to the journal
+func (j *Journal) Append(entry JournalEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if segment := journalSegmentName(entry.Time); segment != j.segment {
+		if err := j.rotate(segment); err != nil {
+			return err
+		}
+	}
+	if _, err := j.file.Write(line); err != nil {
+		return err
+	}
+	if j.fsyncInterval <= 0 {
+		return j.file.Sync()
+	}
+	j.dirty = true
+	return nil
+}
+
+// rotate syncs and closes the current segment and opens another. j.mu must
+// be held.
+func (j *Journal) rotate(segment string) error {
+	if j.file != nil {
+		if err := j.file.Sync(); err != nil {
+			return err
+		}
+		j.file.Close()
+		j.file, j.dirty = nil, false
+	}
+	file, err := os.OpenFile(filepath.Join(j.dir, segment), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	j.file, j.segment = file, segment
+	return nil
+}
+
+// Sync flushes appended entries to disk
+func (j *Journal) Sync() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.file == nil || !j.dirty {
+		return nil
+	}
+	j.dirty = false
+	return j.file.Sync()
+}
+
+// Close syncs and closes the journal
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.file == nil {
+		return nil
+	}
+	err := j.file.Sync()
+	j.file.Close()
+	j.file = nil
+	return err
+}
+
+// newUUID generates the UUID of a run or experiment a request creates. While
+// the request is replayed from the journal, it is the UUID generated when the
+// request was first served.
+func newUUID(ctx context.Context) string {
+	jr, _ := ctx.Value(journalContextKey{}).(*journalRequest)
+	if jr != nil && jr.replayed && jr.uuid != "" {
+		return jr.uuid
+	}
+	id := uuid.New().String()
+	if jr != nil {
+		jr.uuid = id
+	}
+	return id
+}
+
+// isJournalReplay reports whether a request is being replayed from the journal
+func isJournalReplay(ctx context.Context) bool {
+	jr, _ := ctx.Value(journalContextKey{}).(*journalRequest)
+	return jr != nil && jr.replayed
+}
+
+// isJournaled reports whether a request is journaled: writes other than
+// multipart and chunked uploads, whose contents are kept in the artifact store
+func isJournaled(r *http.Request) bool {
+	if r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions {
+		return false
+	}
+	if isArtifactUploadRequest(r) {
+		return false
+	}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return !strings.HasPrefix(mediaType, "multipart/")
+}
+
+// bufferedResponseWriter holds a response back until the request has been
+// journaled
+type bufferedResponseWriter struct {
+	header     http.Header
+	statusCode int
+	body       bytes.Buffer
+}
+
+func (w *bufferedResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *bufferedResponseWriter) WriteHeader(statusCode int) {
+	if w.statusCode == 0 {
+		w.statusCode = statusCode
+	}
+}
+
+func (w *bufferedResponseWriter) Write(b []byte) (int, error) {
+	if w.statusCode == 0 {
+		w.statusCode = http.StatusOK
+	}
+	return w.body.Write(b)
+}
+
+// journalMiddleware appends successful ingestion requests to the journal
+// before acknowledging them. It must run inside apiVersionMiddleware.
+func journalMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if journal == nil || !isJournaled(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, journalMaxBodyBytes+1))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to read request body"})
+			return
+		}
+		if len(body) > journalMaxBodyBytes {
+			log.Printf("Not journaling %s %s: body exceeds %s", r.Method, r.URL.Path, formatBytes(journalMaxBodyBytes))
+			r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), r.Body))
+			next.ServeHTTP(w, r)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		jr := &journalRequest{}
+		buffered := &bufferedResponseWriter{header: w.Header()}
+		next.ServeHTTP(buffered, r.WithContext(context.WithValue(ctx, journalContextKey{}, jr)))
+		if buffered.statusCode == 0 {
+			buffered.statusCode = http.StatusOK
+		}
+
+		if buffered.statusCode < 300 {
+			err := journal.Append(JournalEntry{
+				Time:          time.Now().UTC(),
+				APIVersion:    apiVersionFromContext(ctx),
+				Method:        r.Method,
+				Path:          unversionedAPIPath(r.URL.Path),
+				Query:         r.URL.RawQuery,
+				ContentType:   r.Header.Get("Content-Type"),
+				Body:          body,
+				GeneratedUUID: jr.uuid,
+			})
+			if err != nil {
+				// The request has been applied, but acknowledging it would
+				// promise a durability the server cannot provide
+				log.Printf("Failed to journal %s %s: %v", r.Method, r.URL.Path, err)
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusServiceUnavailable)
+				json.NewEncoder(w).Encode(map[string]string{"error": "Failed to journal request"})
+				return
+			}
+		}
+
+		w.WriteHeader(buffered.statusCode)
+		w.Write(buffered.body.Bytes())
+	})
+}
+
+// journaledHandlers are the API handlers by unversioned path, for replay
+var journaledHandlers = map[string]http.HandlerFunc{}
+
+// readJournal reads the entries of the journal in dir recorded at or after
+// since, in the order they were written
+func readJournal(dir string, since time.Time) ([]JournalEntry, error) {
+	segments, err := filepath.Glob(filepath.Join(dir, "journal-*.jsonl"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(segments)
+
+	var entries []JournalEntry
+	for _, segment := range segments {
+		file, err := os.Open(segment)
+		if err != nil {
+			return nil, err
+		}
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(nil, 2*journalMaxBodyBytes)
+		for line := 1; scanner.Scan(); line++ {
+			var entry JournalEntry
+			if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+				// A crash can leave the last entry partially written
+				log.Printf("Skipping unreadable journal entry %s:%d: %v", filepath.Base(segment), line, err)
+				continue
+			}
+			if !entry.Time.Before(since) {
+				entries = append(entries, entry)
+			}
+		}
+		err = scanner.Err()
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", segment, err)
+		}
+	}
+	return entries, nil
+}
+
+// replayJournalEntry serves a journaled request again, returning the response
+func replayJournalEntry(entry JournalEntry) (*httptest.ResponseRecorder, error) {
+	handler, ok := journaledHandlers[entry.Path]
+	if !ok {
+		return nil, fmt.Errorf("no endpoint at %s", entry.Path)
+	}
+	target := entry.Path
+	if entry.Query != "" {
+		target += "?" + entry.Query
+	}
+	r := httptest.NewRequest(entry.Method, target, bytes.NewReader(entry.Body))
+	if entry.ContentType != "" {
+		r.Header.Set("Content-Type", entry.ContentType)
+	}
+	r = r.WithContext(context.WithValue(r.Context(), journalContextKey{}, &journalRequest{uuid: entry.GeneratedUUID, replayed: true}))
+
+	w := httptest.NewRecorder()
+	apiVersionMiddleware(entry.APIVersion, handler).ServeHTTP(w, r)
+	return w, nil
+}
+
+// runReplayCommand implements "apparatus-server replay", which reprocesses
+// the ingestion journal against a restored database
+func runReplayCommand(args []string) {
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+	configPath := defineConfigFlag(flags)
+	dbConnString := flags.String("db", "sqlite:///apparatus.db", "Database connection string (e.g., sqlite:///path/to/db.db)")
+	artifactStoreURI := flags.String("artifact-store-uri", "file://artifacts", "URI for location to store artifacts (e.g. file:///path/to/artifacts or s3://bucket/prefix)")
+	journalDir := flags.String("journal-dir", "", "Directory of the ingestion journal to replay")
+	sinceFlag := flags.String("since", "", "Replay only requests journaled at or after this RFC 3339 time, e.g. when the restored backup was taken")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s replay -journal-dir DIR [-since TIME] [flags]\n\nReprocesses journaled ingestion requests, e.g. after restoring the database from a backup.\n\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if _, err := loadServerConfig(flags, *configPath); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+
+	if *journalDir == "" {
+		flags.Usage()
+		os.Exit(2)
+	}
+	var since time.Time
+	if *sinceFlag != "" {
+		var err error
+		if since, err = time.Parse(time.RFC3339, *sinceFlag); err != nil {
+			log.Fatalf("Invalid -since time: %v", err)
+		}
+	}
+	entries, err := readJournal(*journalDir, since)
+	if err != nil {
+		log.Fatalf("Failed to read journal: %v", err)
+	}
+
+	replayingJournal = true
+	initDB(*dbConnString)
+	initArtifactStore(*artifactStoreURI)
+	registerRoutes()
+
+	// Requests that fail on replay are usually ones already in the restored
+	// database, such as runs created before the backup
+	var applied, failed int
+	for _, entry := range entries {
+		w, err := replayJournalEntry(entry)
+		if err == nil && w.Code >= 300 {
+			err = errors.New(strings.TrimSpace(w.Body.String()))
+		}
+		if err != nil {
+			log.Printf("Replaying %s %s from %s failed: %v", entry.Method, entry.Path, entry.Time.Format(time.RFC3339), err)
+			failed++
+			continue
+		}
+		applied++
+	}
+	log.Printf("Replayed %d journaled requests: %d applied, %d failed", len(entries), applied, failed)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJournalAppendAndRead(t *testing.T) {
+	dir := t.TempDir()
+	j, err := openJournal(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+
+	day1 := time.Date(2026, 3, 1, 23, 59, 0, 0, time.UTC)
+	day2 := day1.Add(2 * time.Minute)
+	for i, at := range []time.Time{day1, day1.Add(30 * time.Second), day2} {
+		if err := j.Append(JournalEntry{Time: at, Method: "POST", Path: "/api/metrics", Body: []byte{byte('a' + i)}}); err != nil {
+			t.Fatalf("Append failed: %v", err)
+		}
+	}
+
+	entries, err := readJournal(dir, time.Time{})
+	if err != nil {
+		t.Fatalf("readJournal failed: %v", err)
+	}
+	if len(entries) != 3 || string(entries[0].Body) != "a" || string(entries[2].Body) != "c" {
+		t.Fatalf("Expected 3 entries in order across segments, got %+v", entries)
+	}
+
+	entries, err = readJournal(dir, day1.Add(time.Second))
+	if err != nil {
+		t.Fatalf("readJournal failed: %v", err)
+	}
+	if len(entries) != 2 || string(entries[0].Body) != "b" {
+		t.Errorf("Expected the 2 entries since the cutoff, got %+v", entries)
+	}
+}
+
+func TestJournalMiddleware(t *testing.T) {
+	dir := t.TempDir()
+	var err error
+	journal, err = openJournal(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		journal.Close()
+		journal = nil
+	}()
+
+	// A handler that creates something named by a generated UUID
+	var created []string
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("fail") != "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		id := newUUID(r.Context())
+		created = append(created, id)
+		json.NewEncoder(w).Encode(map[string]string{"id": id})
+	}
+	journaledHandlers["/api/test-journal"] = handler
+	defer delete(journaledHandlers, "/api/test-journal")
+	served := apiVersionMiddleware(1, journalMiddleware(http.HandlerFunc(handler)))
+
+	requests := []*http.Request{
+		httptest.NewRequest(http.MethodPost, "/api/v1/test-journal", strings.NewReader(`{"name":"a"}`)),
+		httptest.NewRequest(http.MethodPost, "/api/v1/test-journal?fail=1", strings.NewReader(`{}`)),
+		httptest.NewRequest(http.MethodGet, "/api/v1/test-journal", nil),
+	}
+	for _, r := range requests {
+		r.Header.Set("Content-Type", "application/json")
+		served.ServeHTTP(httptest.NewRecorder(), r)
+	}
+	upload := httptest.NewRequest(http.MethodPost, "/api/v1/test-journal", strings.NewReader("--x--"))
+	upload.Header.Set("Content-Type", "multipart/form-data; boundary=x")
+	served.ServeHTTP(httptest.NewRecorder(), upload)
+
+	// Only the successful JSON write is journaled
+	entries, err := readJournal(dir, time.Time{})
+	if err != nil {
+		t.Fatalf("readJournal failed: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("Expected 1 journaled request, got %+v", entries)
+	}
+	entry := entries[0]
+	if entry.Path != "/api/test-journal" || entry.APIVersion != 1 || string(entry.Body) != `{"name":"a"}` || entry.GeneratedUUID != created[0] {
+		t.Errorf("Unexpected journal entry: %+v", entry)
+	}
+
+	// Replay assigns the UUID generated when the request was first served
+	w, err := replayJournalEntry(entry)
+	if err != nil {
+		t.Fatalf("replayJournalEntry failed: %v", err)
+	}
+	if w.Code != http.StatusOK || created[len(created)-1] != created[0] {
+		t.Errorf("Replay created %v with status %d, want the original UUID %s", created, w.Code, created[0])
+	}
+}
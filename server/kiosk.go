@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// A kiosk token shows one experiment's wallboard at /kiosk, for a TV in the
+// lab: the experiment's latest runs with their status, headline metrics and
+// charts, refreshing itself, with no navigation to the rest of the web UI.
+// Opening /kiosk?token=... once signs the kiosk in. Kiosk tokens are refused
+// everywhere else, so the kiosk exposes only the experiment on its screen.
+
+// kioskCookie holds the kiosk token a browser signed in with
+const kioskCookie = "apparatus_kiosk"
+
+// kioskRunsShown is how many of the experiment's latest runs the wallboard
+// shows
+const kioskRunsShown = 12
+
+// Size of the wallboard's run charts
+const (
+	kioskChartWidth  = 480
+	kioskChartHeight = 260
+)
+
+// kioskExperimentID is the experiment a kiosk token shows, returning ok false
+// if the token is not an unrevoked kiosk token
+func kioskExperimentID(ctx context.Context, token string) (id int, ok bool, err error) {
+	if token == "" {
+		return 0, false, nil
+	}
+	row, err := dao.GetAPITokenByHash(ctx, hashAPIToken(token))
+	if err != nil || row == nil || row.RevokedAt.Valid || !row.KioskExperimentID.Valid {
+		return 0, false, err
+	}
+	return int(row.KioskExperimentID.Int64), true, nil
+}
+
+// KioskBoard is the part of the wallboard that refreshes itself
+type KioskBoard struct {
+	Runs        []RunSummary
+	UpdatedAt   string
+	ChartWidth  int
+	ChartHeight int
+	HasLoss     bool
+	HasAccuracy bool
+}
+
+// loadKioskBoard loads the latest runs of a kiosk's experiment
+func loadKioskBoard(ctx context.Context, experimentID int) (KioskBoard, error) {
+	runs, err := dao.GetRuns(ctx, 0, kioskRunsShown, defaultRunSort, defaultRunSortDir, RunFilter{HideArchived: true, ExperimentID: experimentID})
+	if err != nil {
+		return KioskBoard{}, fmt.Errorf("failed to get runs: %w", err)
+	}
+	if runs, err = withMetricSummaries(ctx, runs); err != nil {
+		return KioskBoard{}, fmt.Errorf("failed to get metric summaries: %w", err)
+	}
+	board := KioskBoard{
+		Runs:        runs,
+		UpdatedAt:   time.Now().Format("15:04:05"),
+		ChartWidth:  kioskChartWidth,
+		ChartHeight: kioskChartHeight,
+	}
+	for _, run := range runs {
+		board.HasLoss = board.HasLoss || run.Loss != nil
+		board.HasAccuracy = board.HasAccuracy || run.Accuracy != nil
+	}
+	return board, nil
+}
+
+// kioskTemplates are the templates of the wallboard, which has its own
+// document rather than the layout, leaving out the layout's navigation
+var kioskTemplates = registerTemplates("templates/kiosk.html", "templates/kiosk_board.html")
+
+// handleKiosk serves the wallboard of the kiosk token the browser signed in
+// with at /kiosk, its refreshing part at /kiosk/board, and its run charts at
+// /kiosk/runs/{uuid}/chart.png. GET /kiosk?token=... signs the browser in.
+func handleKiosk(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return nil
+	}
+	if token := r.URL.Query().Get("token"); token != "" {
+		_, ok, err := kioskExperimentID(ctx, token)
+		if err != nil {
+			return fmt.Errorf("failed to check kiosk token: %w", err)
+		}
+		if !ok {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprintf(w, "Invalid kiosk token")
+			return nil
+		}
+		// Kiosks stay signed in until the token is revoked
+		http.SetCookie(w, &http.Cookie{
+			Name:     kioskCookie,
+			Value:    token,
+			Path:     "/kiosk",
+			MaxAge:   365 * 24 * 60 * 60,
+			HttpOnly: true,
+			Secure:   r.TLS != nil,
+			SameSite: http.SameSiteLaxMode,
+		})
+		// Keep the token out of the address bar
+		http.Redirect(w, r, "/kiosk", http.StatusSeeOther)
+		return nil
+	}
+
+	var token string
+	if cookie, err := r.Cookie(kioskCookie); err == nil {
+		token = cookie.Value
+	}
+	experimentID, ok, err := kioskExperimentID(ctx, token)
+	if err != nil {
+		return fmt.Errorf("failed to check kiosk token: %w", err)
+	}
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprintf(w, "Open /kiosk?token=... with a kiosk token created by the token command")
+		return nil
+	}
+
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/kiosk"), "/")
+	if rest, ok := strings.CutPrefix(path, "runs/"); ok {
+		runUUID, file, _ := strings.Cut(rest, "/")
+		if file != "chart.png" {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprintf(w, "Not found")
+			return nil
+		}
+		return handleKioskRunChart(w, r, experimentID, runUUID)
+	}
+	if path != "" && path != "board" {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "Not found")
+		return nil
+	}
+
+	board, err := loadKioskBoard(ctx, experimentID)
+	if err != nil {
+		return err
+	}
+	tmpl, err := kioskTemplates.Get()
+	if err != nil {
+		return fmt.Errorf("failed to parse template: %w", err)
+	}
+	if path == "board" {
+		return executeTemplate(w, tmpl, "kiosk_board.html", board)
+	}
+	experiment, err := dao.GetExperimentByID(ctx, experimentID)
+	if err != nil {
+		return fmt.Errorf("failed to get experiment %d: %w", experimentID, err)
+	}
+	data := struct {
+		Title string
+		Board KioskBoard
+	}{
+		Title: experiment.Name,
+		Board: board,
+	}
+	return executeTemplate(w, tmpl, "kiosk.html", data)
+}
+
+// handleKioskRunChart renders the chart of a run of the kiosk's experiment,
+// refusing runs of other experiments
+func handleKioskRunChart(w http.ResponseWriter, r *http.Request, experimentID int, runUUID string) error {
+	ctx := r.Context()
+	runID, err := dao.GetRunIDByUUID(ctx, runUUID)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(w, "Run not found", http.StatusNotFound)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get run %s: %w", runUUID, err)
+	}
+	runExperimentID, err := dao.GetRunExperimentID(ctx, runID)
+	if err != nil {
+		return fmt.Errorf("failed to get experiment of run %s: %w", runUUID, err)
+	}
+	if runExperimentID != experimentID {
+		http.Error(w, "Run not found", http.StatusNotFound)
+		return nil
+	}
+	return handleRunChart(w, r, runUUID, chartFormatPNG)
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// kioskDAO serves a kiosk token for experiment 1, which has one run, and a
+// run of experiment 2
+type kioskDAO struct {
+	apiTokenDAO
+	filter RunFilter
+}
+
+func (d *kioskDAO) GetRuns(ctx context.Context, offset, limit int, sortBy, sortDir string, filter RunFilter) ([]RunSummary, error) {
+	d.filter = filter
+	return []RunSummary{{ID: 1, UUID: "run-1", Name: "baseline", Status: "running"}}, nil
+}
+
+func (d *kioskDAO) GetMetricSummariesByRunIDs(ctx context.Context, runIDs []int) ([]MetricSummaryRow, error) {
+	return []MetricSummaryRow{{RunID: 1, Key: "train/loss", LastStep: 100, LastValue: 0.25, MinStep: 90, MinValue: 0.2, MaxValue: 2, PointCount: 100}}, nil
+}
+
+func (d *kioskDAO) GetExperimentByID(ctx context.Context, id int) (*Experiment, error) {
+	return &Experiment{UUID: "exp-1", Name: "Sweep"}, nil
+}
+
+func (d *kioskDAO) GetRunIDByUUID(ctx context.Context, uuid string) (int, error) {
+	switch uuid {
+	case "run-1":
+		return 1, nil
+	case "run-2":
+		return 2, nil
+	}
+	return 0, sql.ErrNoRows
+}
+
+func (d *kioskDAO) GetRunExperimentID(ctx context.Context, runID int) (int, error) {
+	return runID, nil
+}
+
+func TestHandleKiosk(t *testing.T) {
+	defer func(d DAO) { dao = d }(dao)
+	d := &kioskDAO{apiTokenDAO: apiTokenDAO{tokens: map[string]APITokenRow{
+		hashAPIToken("tv"):     {Name: "lab-tv", KioskExperimentID: sql.NullInt64{Int64: 1, Valid: true}},
+		hashAPIToken("old-tv"): {Name: "old-tv", KioskExperimentID: sql.NullInt64{Int64: 1, Valid: true}, RevokedAt: sql.NullTime{Time: time.Now(), Valid: true}},
+		hashAPIToken("laptop"): {Name: "laptop"},
+	}}}
+	dao = d
+
+	get := func(path string, cookie *http.Cookie) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		if cookie != nil {
+			r.AddCookie(cookie)
+		}
+		w := httptest.NewRecorder()
+		errorHandler(handleKiosk).ServeHTTP(w, r)
+		return w
+	}
+
+	for _, token := range []string{"old-tv", "laptop", "nope"} {
+		if w := get("/kiosk?token="+token, nil); w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected token %q to be refused, got %d", token, w.Code)
+		}
+	}
+	if w := get("/kiosk", nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without a kiosk token, got %d", w.Code)
+	}
+
+	w := get("/kiosk?token=tv", nil)
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/kiosk" {
+		t.Fatalf("Expected a redirect dropping the token, got %d to %q", w.Code, w.Header().Get("Location"))
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != kioskCookie || cookies[0].Path != "/kiosk" {
+		t.Fatalf("Expected the kiosk cookie, got %v", cookies)
+	}
+	cookie := cookies[0]
+
+	w = get("/kiosk", cookie)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body)
+	}
+	if d.filter.ExperimentID != 1 || !d.filter.HideArchived {
+		t.Errorf("Expected the experiment's unarchived runs, got filter %+v", d.filter)
+	}
+	body := w.Body.String()
+	for _, want := range []string{"<title>Sweep - Apparatus</title>", "baseline", `hx-get="/kiosk/board"`, `src="/kiosk/runs/run-1/chart.png?`, "0.25"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Expected the wallboard to contain %q", want)
+		}
+	}
+	for _, unwanted := range []string{`href="/"`, "/search", "/preferences/"} {
+		if strings.Contains(body, unwanted) {
+			t.Errorf("Expected the wallboard to leave out navigation, found %q", unwanted)
+		}
+	}
+
+	if w := get("/kiosk/board", cookie); w.Code != http.StatusOK || strings.Contains(w.Body.String(), "<html>") || !strings.Contains(w.Body.String(), "baseline") {
+		t.Errorf("Expected the board fragment, got %d: %s", w.Code, w.Body)
+	}
+	if w := get("/kiosk/runs/run-2/chart.png", cookie); w.Code != http.StatusNotFound {
+		t.Errorf("Expected another experiment's run to be hidden, got %d", w.Code)
+	}
+	if w := get("/kiosk/runs/missing/chart.png", cookie); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown run, got %d", w.Code)
+	}
+	if w := get("/kiosk/runs/run-1/metrics", cookie); w.Code != http.StatusNotFound {
+		t.Errorf("Expected only charts to be served, got %d", w.Code)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// LinkPreview is the Open Graph metadata that chat apps such as Slack and
+// Teams unfurl a pasted link to a page into
+type LinkPreview struct {
+	Title       string
+	Description string
+	URL         string
+	// ImageURL is a chart of the page's headline metric, if it has one
+	ImageURL    string
+	ImageWidth  int
+	ImageHeight int
+}
+
+// requestBaseURL is the scheme and host a request was made to, such as
+// https://apparatus.example.com, which link previews must give absolute
+// URLs under. X-Forwarded-Proto and X-Forwarded-Host are honored so that
+// servers behind a TLS-terminating proxy link to the proxy.
+func requestBaseURL(r *http.Request) string {
+	u := url.URL{Scheme: "http", Host: r.Host}
+	if r.TLS != nil {
+		u.Scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto == "http" || proto == "https" {
+		u.Scheme = proto
+	}
+	if host := r.Header.Get("X-Forwarded-Host"); host != "" {
+		u.Host = strings.TrimSpace(strings.Split(host, ",")[0])
+	}
+	return u.String()
+}
+
+// runLinkPreview describes a run for link previews: its status, experiment
+// and the latest value of its headline metric, which the preview image
+// charts
+func runLinkPreview(r *http.Request, runID int, run *Run, experiment *Experiment) (*LinkPreview, error) {
+	ctx := r.Context()
+	base := requestBaseURL(r)
+	preview := &LinkPreview{
+		Title: run.Name,
+		URL:   base + "/runs/" + url.PathEscape(run.UUID),
+	}
+	details := []string{run.Status}
+	if experiment != nil {
+		details = append(details, "Experiment: "+experiment.Name)
+	}
+
+	checkpoint, err := dao.GetRunBestCheckpoint(ctx, runID)
+	if err != nil {
+		return nil, err
+	}
+	latest, err := dao.GetLatestMetricsByRunID(ctx, runID)
+	if err != nil {
+		return nil, err
+	}
+	if key := pickChartKey(checkpoint, latest); key != "" {
+		for _, m := range latest {
+			if m.Key == key {
+				details = append(details, fmt.Sprintf("%s: %s at step %s", key,
+					strconv.FormatFloat(m.YValue, 'g', 6, 64), strconv.FormatFloat(m.XValue, 'f', -1, 64)))
+			}
+		}
+		preview.ImageURL = preview.URL + "/chart.png?" + url.Values{"key": {key}}.Encode()
+		preview.ImageWidth, preview.ImageHeight = chartDefaultWidth, chartDefaultHeight
+	}
+	preview.Description = strings.Join(details, " · ")
+	return preview, nil
+}
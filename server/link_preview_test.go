@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// previewDAO is chartDAO's run-1, finished in the vision experiment
+type previewDAO struct {
+	chartDAO
+}
+
+func (d *previewDAO) GetRunByUUID(ctx context.Context, uuid string) (*Run, error) {
+	run, err := d.chartDAO.GetRunByUUID(ctx, uuid)
+	if run != nil {
+		run.Status = "FINISHED"
+	}
+	return run, err
+}
+
+func (d *previewDAO) GetExperimentForRunUUID(ctx context.Context, uuid string) (*Experiment, error) {
+	return &Experiment{UUID: "exp-1", Name: "vision"}, nil
+}
+
+func (d *previewDAO) GetRunHold(ctx context.Context, runID int) (*RunHoldRow, error) {
+	return nil, nil
+}
+
+func (d *previewDAO) GetLatestMetricsByRunID(ctx context.Context, runID int) ([]MetricRow, error) {
+	return []MetricRow{{Key: "accuracy", XValue: 3, YValue: 0.9}, {Key: "train/loss", XValue: 3, YValue: 0.25}}, nil
+}
+
+func TestRequestBaseURL(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/runs/run-1", nil)
+	r.Host = "apparatus.internal:8080"
+	if got := requestBaseURL(r); got != "http://apparatus.internal:8080" {
+		t.Errorf("Expected the request's host, got %q", got)
+	}
+	r.TLS = &tls.ConnectionState{}
+	if got := requestBaseURL(r); got != "https://apparatus.internal:8080" {
+		t.Errorf("Expected https for a TLS request, got %q", got)
+	}
+	r.TLS = nil
+	r.Header.Set("X-Forwarded-Proto", "https")
+	r.Header.Set("X-Forwarded-Host", "apparatus.example.com, proxy.internal")
+	if got := requestBaseURL(r); got != "https://apparatus.example.com" {
+		t.Errorf("Expected the proxy's scheme and host, got %q", got)
+	}
+}
+
+func TestRunPageLinkPreview(t *testing.T) {
+	defer func(d DAO) { dao = d }(dao)
+	dao = &previewDAO{}
+
+	r := httptest.NewRequest(http.MethodGet, "/runs/run-1", nil)
+	r.Host = "apparatus.example.com"
+	w := httptest.NewRecorder()
+	if err := handleViewRun(w, r); err != nil {
+		t.Fatal(err)
+	}
+	body := w.Body.String()
+	for _, want := range []string{
+		`<meta property="og:title" content="baseline &lt;lr=0.1&gt;">`,
+		`<meta property="og:description" content="FINISHED · Experiment: vision · train/loss: 0.25 at step 3">`,
+		`<meta property="og:url" content="http://apparatus.example.com/runs/run-1">`,
+		`<meta property="og:image" content="http://apparatus.example.com/runs/run-1/chart.png?key=train%2Floss">`,
+		`<meta name="twitter:card" content="summary_large_image">`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Expected the page to contain %s, got:\n%s", want, body)
+		}
+	}
+}
@@ -1,51 +1,230 @@
 package main
 
 import (
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
-	"html/template"
+	"io"
 	"io/fs"
 	"log"
+	"mime"
+	"mime/multipart"
 	"net/http"
+	"net/url"
 	"os"
-	"path/filepath"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
-
-	"github.com/google/uuid"
 )
 
 func main() {
-	// Parse command line flags
-	dbConnString := flag.String("db", "sqlite:///apparatus.db", "Database connection string (e.g., sqlite:///path/to/db.db)")
-	artifactStoreURI := flag.String("artifact-store-uri", "file://artifacts", "URI for location to store artifacts (e.g. file:///path/to/artifacts")
+	ctx := context.Background()
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		runReplayCommand(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "token" {
+		runTokenCommand(ctx, os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "config" {
+		runConfigCommand(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "verify" {
+		runVerifyCommand(ctx, os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "export-run" {
+		runExportRunCommand(ctx, os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "relocate-artifacts" {
+		runRelocateArtifactsCommand(ctx, os.Args[2:])
+		return
+	}
+
+	// Parse command line flags, then fill in the rest from the config file
+	// and environment
+	opts := defineServerFlags(flag.CommandLine)
 	flag.Parse()
+	if _, err := loadServerConfig(flag.CommandLine, *opts.configPath); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	if err := validateServerSettings(flag.CommandLine); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
 
-	// Environment variable takes precedence over command line flag
-	finalDBConnString := *dbConnString
-	if envDB := os.Getenv("APPARATUS_DB_CONNECTION_STRING"); envDB != "" {
-		finalDBConnString = envDB
+	if *opts.environmentRedactKeys != "" {
+		environmentRedactKeys = regexp.MustCompile(*opts.environmentRedactKeys)
 	}
 
-	initDB(finalDBConnString)
-	initArtifactStore(*artifactStoreURI)
+	initDB(*opts.dbConnString)
+	initMultiInstance(*opts.dbConnString, *opts.artifactStoreURI)
+	initHomePageCache(ctx)
+	initArtifactStore(*opts.artifactStoreURI)
+	initArtifactMirror(*opts.artifactMirrorURI)
+	initArtifactCache()
+	initArtifactScanner(*opts.artifactScannerURI)
+	initArtifactServeLimits()
+	initIngestionBackpressure()
+	initJournal(*opts.journalDir)
+	startStaleRunDetector(ctx)
+	startArtifactMirror(ctx)
+	startArtifactScanner(ctx)
+	startHousekeeping(ctx)
+	startRetention(ctx)
+	startTrashPurge(ctx)
 
-	// Define routes
-	http.Handle("/", LoggerMiddleware(http.HandlerFunc(handleHome)))
+	registerRoutes()
+	loadTemplates()
+
+	// Start server
+	addr := *opts.listen
+	if addr == "" {
+		addr = fmt.Sprintf(":%d", *opts.port)
+	}
+	tlsConfig, err := serverTLSConfig(opts)
+	if err != nil {
+		log.Fatalf("Failed to configure TLS: %v", err)
+	}
+	log.Printf("Starting Apparatus server on %s", serverURL(addr, opts, tlsConfig != nil))
+	serve(addr, tlsConfig)
+}
+
+// serverFlags are the server settings that are not kept in package variables
+type serverFlags struct {
+	configPath            *string
+	dbConnString          *string
+	artifactStoreURI      *string
+	artifactMirrorURI     *string
+	artifactScannerURI    *string
+	journalDir            *string
+	environmentRedactKeys *string
+	port                  *int
+	listen                *string
+	tlsCert               *string
+	tlsKey                *string
+	acmeDomains           *string
+	acmeEmail             *string
+	acmeDirectory         *string
+	acmeCacheDir          *string
+}
+
+// defineServerFlags defines the server's flags on flags
+func defineServerFlags(flags *flag.FlagSet) serverFlags {
+	configPath := defineConfigFlag(flags)
+	dbConnString := flags.String("db", "sqlite:///apparatus.db", "Database connection string (e.g., sqlite:///path/to/db.db)")
+	flags.DurationVar(&dbQueryTimeout, "db-query-timeout", dbQueryTimeout, "How long a database query can take, including reading its results, before it is cancelled (0 is unlimited)")
+	artifactStoreURI := flags.String("artifact-store-uri", "file://artifacts", "URI for location to store artifacts (e.g. file:///path/to/artifacts or s3://bucket/prefix)")
+	artifactMirrorURI := flags.String("artifact-mirror-uri", "", "URI of a secondary store to replicate artifacts to for durability, in the same format as -artifact-store-uri (disabled if empty)")
+	flags.DurationVar(&artifactMirrorInterval, "artifact-mirror-interval", artifactMirrorInterval, "How often to copy new and overwritten artifacts to the artifact mirror")
+	artifactScannerURI := flags.String("artifact-scanner", "", "Malware scanner uploads must pass before they can be downloaded: clamd://host:port, clamd:///path/to/clamd.sock, or the http(s) URL of a webhook (disabled if empty)")
+	flags.DurationVar(&artifactScanInterval, "artifact-scan-interval", artifactScanInterval, "How often to scan artifacts that could not be scanned on upload")
+	flags.DurationVar(&artifactScanTimeout, "artifact-scan-timeout", artifactScanTimeout, "How long scanning an artifact can take")
+	flags.IntVar(&artifactServeConcurrency, "artifact-serve-concurrency", artifactServeConcurrency, "Maximum number of artifact downloads streamed at once (0 is unlimited)")
+	flags.IntVar(&artifactServeQueueLength, "artifact-serve-queue", artifactServeQueueLength, "Maximum number of artifact downloads waiting to be streamed before the server responds 503")
+	flags.DurationVar(&artifactServeQueueTimeout, "artifact-serve-queue-timeout", artifactServeQueueTimeout, "How long an artifact download waits to be streamed before the server responds 503")
+	flags.Int64Var(&artifactServeBandwidth, "artifact-serve-bandwidth", artifactServeBandwidth, "Total bandwidth for streaming artifact downloads, in bytes per second (0 is unlimited)")
+	flags.StringVar(&artifactCacheDir, "artifact-cache-dir", "", "Directory to cache artifacts viewed from an S3 artifact store or mirror in, so that viewing them again does not fetch them from object storage (disabled if empty)")
+	flags.Int64Var(&artifactCacheSize, "artifact-cache-size", artifactCacheSize, "Most bytes of artifacts to cache before evicting the least recently viewed")
+	flags.Int64Var(&artifactCacheMaxObjectSize, "artifact-cache-max-object-size", artifactCacheMaxObjectSize, "Size in bytes of the largest artifact to cache; larger artifacts are fetched from object storage each time they are viewed")
+	flags.IntVar(&ingestionMaxInFlight, "ingest-max-inflight", ingestionMaxInFlight, "Maximum number of ingestion writes served at once before the server responds 429 with a suggested backoff (0 is unlimited)")
+	flags.DurationVar(&ingestionLatencyTarget, "ingest-latency-target", ingestionLatencyTarget, "Average ingestion write latency above which the server asks clients to back off with the X-Apparatus-Backoff header (0 never asks)")
+	port := flags.Int("port", 8080, "Port to listen on, on every interface")
+	listen := flags.String("listen", "", "Address to listen on, as host:port, e.g. 127.0.0.1:8080 or :443 (overrides -port)")
+	tlsCert := flags.String("tls-cert", "", "TLS certificate file to serve HTTPS and HTTP/2 with, together with -tls-key (HTTP if empty)")
+	tlsKey := flags.String("tls-key", "", "TLS private key file for -tls-cert")
+	acmeDomains := flags.String("acme-domains", "", "Comma-separated domains to serve HTTPS and HTTP/2 for with a certificate obtained and renewed automatically from an ACME certificate authority, which must reach the server on port 443 of each domain (disabled if empty)")
+	acmeEmail := flags.String("acme-email", "", "Contact email for the ACME account, which the certificate authority may send expiry warnings to")
+	acmeDirectory := flags.String("acme-directory", letsEncryptDirectory, "Directory URL of the ACME certificate authority")
+	acmeCacheDir := flags.String("acme-cache-dir", "acme", "Directory to keep the ACME account key and certificate in across restarts")
+	journalDir := flags.String("journal-dir", "", "Directory of a local journal that ingestion requests are written to before they are acknowledged, for replay after a database restore (disabled if empty)")
+	flags.DurationVar(&journalFsyncInterval, "journal-fsync-interval", journalFsyncInterval, "How often the ingestion journal is synced to disk, bounding the requests a crash can lose (0 syncs every request)")
+	flags.DurationVar(&metricGapThreshold, "metric-gap-threshold", metricGapThreshold, "Minimum time without logged points to highlight as a gap in metric charts")
+	flags.DurationVar(&runHeartbeatTimeout, "run-heartbeat-timeout", runHeartbeatTimeout, "Mark running runs FAILED after this long without a logged param or metric (0 disables)")
+	flags.DurationVar(&housekeepingInterval, "housekeeping-interval", 0, "How often to dry run every housekeeping job, leaving reports for admins to review (0 disables)")
+	flags.DurationVar(&artifactUploadExpiry, "artifact-upload-expiry", artifactUploadExpiry, "How long a chunked artifact upload can go without receiving a chunk before the expired-uploads housekeeping job lists it for deletion")
+	flags.DurationVar(&housekeepingPlanMaxAge, "housekeeping-plan-max-age", housekeepingPlanMaxAge, "How old a housekeeping dry run can be and still be carried out")
+	flags.StringVar(&serverRunListView.Sort, "runs-sort", serverRunListView.Sort, "Default sort of the home page's run list: created, name, status, experiment, or metric:KEY for the latest value of a metric; users can save their own")
+	flags.StringVar(&serverRunListView.Dir, "runs-sort-dir", "", "Default direction of the home page's run list sort, asc or desc (defaults to newest first for created, ascending otherwise)")
+	flags.StringVar(&serverRunListView.Density, "runs-density", serverRunListView.Density, "Default row density of the home page's run list: comfortable or compact")
+	flags.IntVar(&retentionArchiveAfterDays, "archive-runs-after-days", 0, "Archive runs that have stopped running this many days after they were created (0 disables)")
+	flags.IntVar(&retentionDeleteArchivedAfterDays, "delete-archived-runs-after-days", 0, "List runs archived this many days ago, with their artifacts, in the archived-runs housekeeping job's dry runs for deletion (0 disables)")
+	flags.IntVar(&trashPurgeAfterDays, "purge-deleted-runs-after-days", trashPurgeAfterDays, "Keep deleted runs, with their artifacts, in the trash this many days before purging them (0 purges them as soon as they are deleted)")
+	flags.IntVar(&trashPurgeNoticeDays, "purge-notice-days", trashPurgeNoticeDays, "Email whoever deleted a run, if they left an address, this many days before it is purged; requires -smtp-addr")
+	flags.Int64Var(&defaultRunQuota, "quota-runs", 0, "Default maximum number of runs per experiment, which admins can override per experiment (0 is unlimited)")
+	flags.Int64Var(&defaultMetricPointQuota, "quota-metric-points-per-day", 0, "Default maximum number of metric points each experiment can log per UTC day (0 is unlimited)")
+	flags.Int64Var(&defaultArtifactBytesQuota, "quota-artifact-bytes", 0, "Default maximum total size of each experiment's artifacts, in bytes (0 is unlimited)")
+	flags.BoolVar(&legacyQueryParamWrites, "legacy-query-params", legacyQueryParamWrites, "Accept the deprecated URL query parameter form of POST /api/runs and POST /api/params alongside JSON bodies")
+	flags.BoolVar(&multiInstance, "multi-instance", false, "Run as one of several replicas behind a load balancer, sharing a Postgres database and artifact store; background jobs run on one replica at a time")
+	flags.StringVar(&instanceID, "instance-id", "", "Name of this replica with -multi-instance (defaults to the hostname and a random suffix)")
+	flags.BoolVar(&requireAuth, "require-auth", false, "Require an API token, created with the token command, on all /api endpoints, and enforce the roles of the users tokens were issued to")
+	flags.BoolVar(&requireLogin, "require-login", false, "Require signing in to the web UI with an API token, and enforce the roles of the users tokens were issued to")
+	environmentRedactKeys := flags.String("environment-redact-keys", "", "Regular expression matching the names of further environment variables to redact when runs log their environment, e.g. '^MYCO_'")
+	flags.StringVar(&adminToken, "admin-token", "", "Bearer token authorizing admin operations such as run holds (disabled if empty)")
+	flags.StringVar(&smtpAddr, "smtp-addr", "", "SMTP relay host:port for email notifications (disabled if empty)")
+	flags.StringVar(&smtpFrom, "smtp-from", smtpFrom, "Sender address for email notifications")
+	flags.BoolVar(&devTemplates, "dev", false, "Read templates from disk again when they change, for developing them without restarting the server")
+	flags.DurationVar(&shutdownTimeout, "shutdown-timeout", shutdownTimeout, "How long to wait for in-flight requests, such as metric and artifact uploads, to finish on SIGINT or SIGTERM before closing them")
+	return serverFlags{
+		configPath:            configPath,
+		dbConnString:          dbConnString,
+		artifactStoreURI:      artifactStoreURI,
+		artifactMirrorURI:     artifactMirrorURI,
+		artifactScannerURI:    artifactScannerURI,
+		journalDir:            journalDir,
+		environmentRedactKeys: environmentRedactKeys,
+		port:                  port,
+		listen:                listen,
+		tlsCert:               tlsCert,
+		tlsKey:                tlsKey,
+		acmeDomains:           acmeDomains,
+		acmeEmail:             acmeEmail,
+		acmeDirectory:         acmeDirectory,
+		acmeCacheDir:          acmeCacheDir,
+	}
+}
+
+// registerRoutes registers the server's handlers on the default mux
+func registerRoutes() {
+	handlePage("/", errorHandler(handleHome))
 	http.Handle("/health", LoggerMiddleware(http.HandlerFunc(handleHealth)))
-	http.Handle("/api/runs", LoggerMiddleware(http.HandlerFunc(handleAPICreateRun)))
-	http.Handle("/api/params", LoggerMiddleware(http.HandlerFunc(handleAPILogParam)))
-	http.Handle("/api/metrics", LoggerMiddleware(http.HandlerFunc(handleAPILogMetrics)))
-	http.Handle("/api/artifacts", LoggerMiddleware(http.HandlerFunc(handleAPILogArtifact)))
-	http.Handle("/api/runs/notes", LoggerMiddleware(http.HandlerFunc(handleAPIUpdateRunNotes)))
-	http.Handle("/api/experiments", LoggerMiddleware(http.HandlerFunc(handleAPICreateExperiment)))
-	http.Handle("/experiments/", LoggerMiddleware(http.HandlerFunc(handleViewExperiment)))
-	http.Handle("/runs/", LoggerMiddleware(http.HandlerFunc(handleViewRun)))
-	http.Handle("/artifacts", LoggerMiddleware(http.HandlerFunc(handleViewArtifact)))
-	http.Handle("/artifacts/blob", LoggerMiddleware(http.HandlerFunc(handleServeArtifactBlob)))
+	http.Handle("/metrics", LoggerMiddleware(authMiddleware(http.HandlerFunc(handleServerMetrics))))
+	handlePage("/status-strip", errorHandler(handleStatusStrip))
+	handlePage("/projects/switcher", errorHandler(handleProjectSwitcher))
+	handlePage("/projects/select", http.HandlerFunc(handleSelectProject))
+	handlePage("/preferences/run-list", http.HandlerFunc(handleRunListPreferences))
+	handlePage("/preferences/notifications", errorHandler(handleNotificationPreferences))
+	handlePage("/preferences/notifications/", errorHandler(handleNotificationPreferences))
+	handlePage("/admin/users", errorHandler(handleAdminUsers))
+	handlePage("/admin/users/", errorHandler(handleAdminUsers))
+	http.Handle("/login", LoggerMiddleware(errorHandler(handleLogin)))
+	http.Handle("/logout", LoggerMiddleware(http.HandlerFunc(handleLogout)))
+	http.Handle("/session", LoggerMiddleware(errorHandler(handleSessionLinks)))
+	// Kiosks sign in with their own tokens, which open nothing else
+	http.Handle("/kiosk", LoggerMiddleware(errorHandler(handleKiosk)))
+	http.Handle("/kiosk/", LoggerMiddleware(errorHandler(handleKiosk)))
+	registerAPIRoutes()
+	handlePage("/experiments/", errorHandler(handleViewExperiment))
+	handlePage("/runs/", errorHandler(handleViewRun))
+	handlePage("/r/", errorHandler(handleResolveShortLink))
+	handlePage("/search", errorHandler(handleSearch))
+	handlePage("/compare", errorHandler(handleCompareRuns))
+	handlePage("/experiments/compare", errorHandler(handleCompareExperiments))
+	handlePage("/trash", errorHandler(handleTrash))
+	handlePage("/trash/", errorHandler(handleTrash))
+	handlePage("/templates", errorHandler(handleViewRunTemplates))
+	handlePage("/templates/", errorHandler(handleViewRunTemplates))
+	handlePage("/artifacts", errorHandler(handleViewArtifact))
+	handlePage("/artifacts/blob", http.HandlerFunc(handleServeArtifactBlob))
 
 	// Serve static files from embedded or filesystem
 	staticFS, err := fs.Sub(templateFS, "static")
@@ -53,22 +232,19 @@ func main() {
 		log.Fatalf("Failed to get static subdirectory: %v", err)
 	}
 	http.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.FS(staticFS))))
-
-	// Start server
-	port := "8080"
-	log.Printf("Starting Apparatus server on http://localhost:%s", port)
-	if err := http.ListenAndServe(":"+port, nil); err != nil {
-		log.Fatalf("Server failed to start: %v", err)
-	}
 }
 
 type Run struct {
-	UUID         string
-	Name         string
-	Notes        string
-	CreatedAt    string
-	ParentRunID  *int
-	NestingLevel int
+	UUID          string
+	Name          string
+	Notes         string
+	CreatedAt     string
+	ParentRunID   *int
+	NestingLevel  int
+	Status        string
+	StatusMessage string
+	// ForkedFrom is the UUID of the run the run was forked from, if any
+	ForkedFrom string
 }
 
 // NestedRun represents a run with its children for hierarchical display
@@ -76,6 +252,9 @@ type NestedRun struct {
 	Run
 	ID         int
 	ChildCount int
+	GPU        *RunGPUSummary
+	Cost       *float64
+	Best       *BestCheckpoint
 	Children   []NestedRun
 }
 
@@ -85,12 +264,20 @@ type Experiment struct {
 	CreatedAt       string
 	MostRecentRunAt string
 	RunCount        int
+	Readme          string
+	ProjectID       int
 }
 
 func LoggerMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
 
+		// Tag the request with an ID, so that errors reported to the client
+		// can be found in the log
+		id := requestID(r)
+		w.Header().Set(requestIDHeader, id)
+		r = r.WithContext(context.WithValue(r.Context(), requestIDContextKey{}, id))
+
 		// Create a custom ResponseWriter to capture the status code
 		lrw := &loggingResponseWriter{ResponseWriter: w}
 
@@ -98,13 +285,16 @@ func LoggerMiddleware(next http.Handler) http.Handler {
 		next.ServeHTTP(lrw, r)
 
 		// Log the request and response details
+		elapsed := time.Since(start)
 		log.Printf(
-			"Method: %s, Path: %s, Status: %d, Latency: %v",
+			"Request: %s, Method: %s, Path: %s, Status: %d, Latency: %v",
+			id,
 			r.Method,
 			r.URL.Path,
 			lrw.statusCode,
-			time.Since(start),
+			elapsed,
 		)
+		serverStats.observeRequest(r.Pattern, r.Method, lrw.status(), elapsed)
 	})
 }
 
@@ -118,30 +308,79 @@ func (lrw *loggingResponseWriter) WriteHeader(code int) {
 	lrw.ResponseWriter.WriteHeader(code)
 }
 
-func handleHome(w http.ResponseWriter, r *http.Request) {
-	// Query all experiments
-	experiments, err := dao.GetAllExperiments()
+func (lrw *loggingResponseWriter) Write(b []byte) (int, error) {
+	if lrw.statusCode == 0 {
+		lrw.statusCode = http.StatusOK
+	}
+	return lrw.ResponseWriter.Write(b)
+}
+
+// status is the response's status code, which is 200 if the handler wrote
+// nothing
+func (lrw *loggingResponseWriter) status() int {
+	if lrw.statusCode == 0 {
+		return http.StatusOK
+	}
+	return lrw.statusCode
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, e.g. to
+// flush event streams
+func (lrw *loggingResponseWriter) Unwrap() http.ResponseWriter {
+	return lrw.ResponseWriter
+}
+
+// homeTemplates are the templates of the home page
+var homeTemplates = registerPage("templates/home.html")
+
+func handleHome(w http.ResponseWriter, r *http.Request) error {
+	// Experiments and the first page of latest runs are served from the home
+	// page cache
+	ctx := r.Context()
+	experiments, latestRuns, err := homeCache.get(ctx, dao)
 	if err != nil {
-		log.Fatalf("Failed to query experiments: %v", err)
+		return fmt.Errorf("failed to query experiments: %w", err)
+	}
+	// The cache holds every project's; those of the project picked with the
+	// header's switcher are queried
+	projectID := selectedProjectID(r)
+	experiments = experimentsInProject(experiments, projectID)
+	page := parseRunListPage(r.URL.Query(), runListViewFor(r))
+	if page.QueryError != "" {
+		latestRuns = nil
+	} else if !page.isDefault() || projectID != 0 {
+		filter := page.Filter()
+		filter.ProjectID = projectID
+		latestRuns, err = dao.GetRuns(ctx, (page.Page-1)*homePageRunsPerPage, homePageRunsPerPage+1, page.Sort, page.Dir, filter)
+		if err != nil {
+			return fmt.Errorf("failed to query runs: %w", err)
+		}
+	}
+
+	runs := withRuns(page, latestRuns)
+	// Metrics change without invalidating the home page cache, so their
+	// summaries are always queried
+	runs.Runs, err = withMetricSummaries(ctx, runs.Runs)
+	if err != nil {
+		return fmt.Errorf("failed to query metric summaries: %w", err)
 	}
 
 	data := struct {
 		Title       string
 		Experiments []Experiment
+		Runs        RunListPage
 	}{
 		Title:       "Home",
 		Experiments: experiments,
+		Runs:        runs,
 	}
 
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	tmpl, err := template.ParseFS(templateFS, "templates/header.html", "templates/home.html")
+	tmpl, err := homeTemplates.Get()
 	if err != nil {
-		log.Fatalf("Failed to parse template: %v", err)
-	}
-	err = tmpl.ExecuteTemplate(w, "home.html", data)
-	if err != nil {
-		log.Fatalf("Failed to execute template: %v", err)
+		return fmt.Errorf("failed to parse template: %w", err)
 	}
+	return executeTemplate(w, tmpl, "home.html", data)
 }
 
 func handleHealth(w http.ResponseWriter, r *http.Request) {
@@ -149,30 +388,87 @@ func handleHealth(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintf(w, `{"status":"ok"}`)
 }
 
+// CreateRunRequest is the body of POST /api/runs
+type CreateRunRequest struct {
+	Name           string `json:"name"`
+	ExperimentUUID string `json:"experiment_uuid"`
+	ParentRunUUID  string `json:"parent_run_uuid"`
+	RunSourceRequest
+	RunSeedsRequest
+}
+
 func handleAPICreateRun(w http.ResponseWriter, r *http.Request) {
-	name := r.URL.Query().Get("name")
-	experimentUUID := r.URL.Query().Get("experiment_uuid")
-	parentRunUUID := r.URL.Query().Get("parent_run_uuid")
-	runUUID := uuid.New().String()
+	ctx := r.Context()
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+
+	var req CreateRunRequest
+	legacy, ok := isLegacyQueryParamWrite(w, r)
+	if !ok {
+		return
+	}
+	if legacy {
+		req.Name = r.URL.Query().Get("name")
+		req.ExperimentUUID = r.URL.Query().Get("experiment_uuid")
+		req.ParentRunUUID = r.URL.Query().Get("parent_run_uuid")
+	} else {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Invalid JSON"})
+			return
+		}
+		if req.Name == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"error":          "Missing required fields",
+				"missing_fields": []string{"name"},
+			})
+			return
+		}
+	}
+	name, experimentUUID, parentRunUUID := req.Name, req.ExperimentUUID, req.ParentRunUUID
+	source, err := req.RunSourceRequest.row()
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+	seeds, err := req.RunSeedsRequest.row()
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+	runUUID := newUUID(ctx)
 
 	// Get experiment ID (use default if not specified)
 	var experimentID int
-	var err error
 	if experimentUUID == "" {
-		experimentID, err = dao.GetDefaultExperimentID()
+		experimentID, err = dao.GetDefaultExperimentID(ctx)
 	} else {
-		experimentID, err = dao.GetExperimentIDByUUID(experimentUUID)
+		experimentID, err = dao.GetExperimentIDByUUID(ctx, experimentUUID)
 	}
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid experiment"})
 		return
 	}
+	// The default experiment is in the Default project, which a token scoped
+	// to another project can't write to
+	if ok, err := requestCanAccessExperiment(r, experimentID); err != nil || !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "experiment_uuid is required with a token scoped to a project"})
+		return
+	}
 
 	// Get parent run ID if specified
 	var parentRunID *int
 	if parentRunUUID != "" {
-		id, err := dao.GetRunIDByUUID(parentRunUUID)
+		id, err := dao.GetRunIDByUUID(ctx, parentRunUUID)
 		if err != nil {
 			w.WriteHeader(http.StatusBadRequest)
 			json.NewEncoder(w).Encode(map[string]string{"error": "Invalid parent run"})
@@ -182,7 +478,7 @@ func handleAPICreateRun(w http.ResponseWriter, r *http.Request) {
 
 		// If no experiment was specified, inherit from parent
 		if experimentUUID == "" {
-			parentRun, err := dao.GetRunByUUID(parentRunUUID)
+			parentRun, err := dao.GetRunByUUID(ctx, parentRunUUID)
 			if err == nil && parentRun.ParentRunID != nil {
 				// Get experiment from parent (query by run ID)
 				// For now, keep the default experiment since we'd need to add a method
@@ -191,81 +487,148 @@ func handleAPICreateRun(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	err = dao.InsertRun(runUUID, name, experimentID, parentRunID)
+	if !quotaExempt(r) && writeQuotaError(w, checkExperimentQuota(ctx, experimentID, 1, 0, 0)) {
+		return
+	}
+
+	// The run is created with its source and seeds or not at all, so that a
+	// client retrying a failed request doesn't leave a duplicate run behind
+	err = dao.InsertRunWithSourceAndSeeds(ctx, runUUID, name, experimentID, parentRunID, source, seeds)
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
 		return
 	}
+	notifyRunEvent(ctx, notificationEventRunCreated, runUUID)
 
-	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{
 		"id":   runUUID,
 		"name": name,
 	})
 }
 
+// LogParamRequest is the body of POST /api/params. Type is the value's type,
+// inferred from the JSON value if empty.
+type LogParamRequest struct {
+	RunUUID string          `json:"run_uuid"`
+	Key     string          `json:"key"`
+	Value   json.RawMessage `json:"value"`
+	Type    string          `json:"type"`
+}
+
 func handleAPILogParam(w http.ResponseWriter, r *http.Request) {
-	runUUID := r.URL.Query().Get("run_uuid")
-	key := r.URL.Query().Get("key")
-	value := r.URL.Query().Get("value")
-	valueType := r.URL.Query().Get("type")
+	ctx := r.Context()
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+
+	// Value is a JSON string, bool, or number. Type is optional, and converts
+	// the value, e.g. to log 3 as a float.
+	var req LogParamRequest
+	legacy, ok := isLegacyQueryParamWrite(w, r)
+	if !ok {
+		return
+	}
+	var param ParameterRow
+	var err error
+	if legacy {
+		req.RunUUID = r.URL.Query().Get("run_uuid")
+		req.Key = r.URL.Query().Get("key")
+		param, err = parseParameterQueryValue(r.URL.Query().Get("value"), r.URL.Query().Get("type"))
+	} else {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Invalid JSON"})
+			return
+		}
+		var missing []string
+		if req.RunUUID == "" {
+			missing = append(missing, "run_uuid")
+		}
+		if req.Key == "" {
+			missing = append(missing, "key")
+		}
+		if req.Value == nil {
+			missing = append(missing, "value")
+		}
+		if len(missing) > 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"error":          "Missing required fields",
+				"missing_fields": missing,
+			})
+			return
+		}
+		param, err = parseParameterJSONValue(req.Value, req.Type)
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": fmt.Sprintf("Invalid parameter value: %v", err)})
+		return
+	}
 
 	// Get run_id from uuid
-	runID, err := dao.GetRunIDByUUID(runUUID)
+	runID, err := dao.GetRunIDByUUID(ctx, req.RunUUID)
 	if err != nil {
 		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Run not found"})
 		return
 	}
 
-	// Insert parameter based on type
 	var valueString *string
 	var valueBool *bool
 	var valueFloat *float64
 	var valueInt *int64
-
-	switch valueType {
-	case "string":
-		valueString = &value
-	case "bool":
-		boolVal := value == "true"
-		valueBool = &boolVal
-	case "float":
-		var f float64
-		fmt.Sscanf(value, "%f", &f)
-		valueFloat = &f
-	case "int":
-		var i int64
-		fmt.Sscanf(value, "%d", &i)
-		valueInt = &i
+	if param.ValueString.Valid {
+		valueString = &param.ValueString.String
+	}
+	if param.ValueBool.Valid {
+		valueBool = &param.ValueBool.Bool
+	}
+	if param.ValueFloat.Valid {
+		valueFloat = &param.ValueFloat.Float64
+	}
+	if param.ValueInt.Valid {
+		valueInt = &param.ValueInt.Int64
 	}
 
-	err = dao.UpsertParameter(runID, key, valueType, valueString, valueBool, valueFloat, valueInt)
+	err = dao.UpsertParameter(ctx, runID, req.Key, param.ValueType, valueString, valueBool, valueFloat, valueInt)
 	if err != nil {
+		log.Printf("Error saving parameter: %v", err)
 		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to save parameter"})
 		return
 	}
+	recordRunActivity(ctx, runID)
 
-	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
 }
 
+// MetricVal is a point of a metric, as POST /api/metrics takes it
+type MetricVal struct {
+	XValue float64 `json:"x_value"`
+	YValue float64 `json:"y_value"`
+}
+
+// LogMetricsRequest is the body of POST /api/metrics
+type LogMetricsRequest struct {
+	RunUUID             string       `json:"run_uuid"`
+	Key                 string       `json:"key"`
+	Values              *[]MetricVal `json:"values,omitempty"`
+	LoggedAtEpochMillis *int64       `json:"logged_at_epoch_millis,omitempty"`
+}
+
 func handleAPILogMetrics(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
 	if r.Method != http.MethodPost {
 		w.WriteHeader(http.StatusMethodNotAllowed)
 		return
 	}
 
-	type MetricVal struct {
-		XValue float64 `json:"x_value"`
-		YValue float64 `json:"y_value"`
-	}
-	var req struct {
-		RunUUID             string       `json:"run_uuid"`
-		Key                 string       `json:"key"`
-		Values              *[]MetricVal `json:"values,omitempty"`
-		LoggedAtEpochMillis *int64       `json:"logged_at_epoch_millis,omitempty"`
-	}
+	var req LogMetricsRequest
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		w.WriteHeader(http.StatusBadRequest)
@@ -298,7 +661,7 @@ func handleAPILogMetrics(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Get run_id from uuid
-	runID, err := dao.GetRunIDByUUID(req.RunUUID)
+	runID, err := dao.GetRunIDByUUID(ctx, req.RunUUID)
 	if err != nil {
 		w.WriteHeader(http.StatusNotFound)
 		json.NewEncoder(w).Encode(map[string]string{"error": "Run not found"})
@@ -306,6 +669,13 @@ func handleAPILogMetrics(w http.ResponseWriter, r *http.Request) {
 	}
 
 	nValues := len(*req.Values)
+	experimentID, err := dao.GetRunExperimentID(ctx, runID)
+	if err == nil && !quotaExempt(r) {
+		err = checkExperimentQuota(ctx, experimentID, 0, int64(nValues), 0)
+	}
+	if writeQuotaError(w, err) {
+		return
+	}
 	xValues := make([]float64, nValues, nValues)
 	yValues := make([]float64, nValues, nValues)
 	for i, metricVal := range *req.Values {
@@ -314,7 +684,7 @@ func handleAPILogMetrics(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Insert metric
-	err = dao.InsertMetrics(runID, req.Key, xValues, yValues, *req.LoggedAtEpochMillis)
+	err = dao.InsertMetrics(ctx, runID, req.Key, xValues, yValues, *req.LoggedAtEpochMillis)
 	if err != nil {
 		log.Printf("Error inserting metric: %v", err)
 		w.WriteHeader(http.StatusInternalServerError)
@@ -322,27 +692,143 @@ func handleAPILogMetrics(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	recordMetricPointUsage(ctx, experimentID, nValues)
+	recordRunActivity(ctx, runID)
+	runEvents.Publish(runID, RunEvent{Type: "metrics", Data: MetricPointsEvent{Key: req.Key, X: xValues, Y: yValues, LoggedAt: *req.LoggedAtEpochMillis}})
+
+	if isGPUMetricKey(req.Key) {
+		if err := updateRunGPUSummary(ctx, runID); err != nil {
+			log.Printf("Failed to update GPU summary for run %s: %v", req.RunUUID, err)
+		}
+	}
+
+	// Metrics outside the experiment's schema are still recorded, but warned about
+	response := map[string]interface{}{"status": "ok"}
+	warnings, err := recordMetricSchemaWarnings(ctx, runID, req.Key, xValues)
+	if err != nil {
+		log.Printf("Failed to check metric %q of run %s against the metric schema: %v", req.Key, req.RunUUID, err)
+	}
+	if isSystemMetricKey(req.Key) {
+		warnings = append(warnings, fmt.Sprintf("%s is reserved for system metrics; log them with POST /api/system-metrics", systemMetricPrefix))
+	}
+	if err := checkMetricAlerts(ctx, runID, req.RunUUID, req.Key, yValues); err != nil {
+		log.Printf("Failed to check metric %q of run %s against its namespace's alerts: %v", req.Key, req.RunUUID, err)
+	}
+	if len(warnings) > 0 {
+		response["warnings"] = warnings
+	}
+
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+	json.NewEncoder(w).Encode(response)
+}
+
+// handleAPIArtifacts describes (GET) or uploads (POST) an artifact of a run
+func handleAPIArtifacts(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		handleAPIGetArtifact(w, r)
+	case http.MethodPost:
+		handleAPILogArtifact(w, r)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// handleAPIGetArtifact returns an artifact's metadata, including the digest
+// clients verify downloads against and the URL to download it from
+func handleAPIGetArtifact(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	w.Header().Set("Content-Type", "application/json")
+	runUUID := r.URL.Query().Get("run_uuid")
+	artifactPath := r.URL.Query().Get("path")
+
+	var missing []string
+	if runUUID == "" {
+		missing = append(missing, "run_uuid")
+	}
+	if artifactPath == "" {
+		missing = append(missing, "path")
+	}
+	if len(missing) > 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":          "Missing required fields",
+			"missing_fields": missing,
+		})
+		return
+	}
+
+	runID, err := dao.GetRunIDByUUID(ctx, runUUID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Run not found"})
+		return
+	}
+	artifact, err := dao.GetArtifactByRunIDAndPath(ctx, runID, artifactPath)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Artifact not found"})
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"path":         artifact.Path,
+		"uri":          artifact.URI,
+		"type":         artifact.Type,
+		"content_type": artifact.ContentType,
+		"size_bytes":   artifact.SizeBytes,
+		"sha256":       artifact.SHA256,
+		"download_url": "/artifacts/blob?uri=" + url.QueryEscape(artifact.URI),
+		"scan_status":  artifact.ScanStatus,
+		"scan_result":  artifact.ScanResult,
+	})
 }
 
 func handleAPILogArtifact(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
 	if r.Method != http.MethodPost {
 		w.WriteHeader(http.StatusMethodNotAllowed)
 		return
 	}
 
-	// Parse multipart form (32MB max)
-	err := r.ParseMultipartForm(32 << 20)
+	reader, err := r.MultipartReader()
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to parse multipart form"})
 		return
 	}
 
-	// Get form values
-	runUUID := r.FormValue("run_uuid")
-	artifactPath := r.FormValue("path")
+	// Read form values up to the file, which is streamed to the artifact store
+	// rather than buffered, so clients must send run_uuid and path first
+	var runUUID, artifactPath string
+	var file *multipart.Part
+	for file == nil {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to parse multipart form"})
+			return
+		}
+		if part.FormName() == "file" {
+			file = part
+			continue
+		}
+		value, err := io.ReadAll(io.LimitReader(part, 4096))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to parse multipart form"})
+			return
+		}
+		switch part.FormName() {
+		case "run_uuid":
+			runUUID = string(value)
+		case "path":
+			artifactPath = string(value)
+		}
+	}
 
 	if runUUID == "" || artifactPath == "" {
 		w.WriteHeader(http.StatusBadRequest)
@@ -356,40 +842,62 @@ func handleAPILogArtifact(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Get run_id from uuid
-	runID, err := dao.GetRunIDByUUID(runUUID)
+	// Get run_id from uuid. The run is in the form, where the project scope
+	// middleware doesn't look.
+	runID, err := dao.GetRunIDByUUID(ctx, runUUID)
 	if err != nil {
 		w.WriteHeader(http.StatusNotFound)
 		json.NewEncoder(w).Encode(map[string]string{"error": "Run not found"})
 		return
 	}
+	if ok, err := requestCanAccessRun(r, runID); err != nil || !ok {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Run not found"})
+		return
+	}
 
-	// Get uploaded file
-	file, _, err := r.FormFile("file")
-	if err != nil {
+	// Uploading over an artifact makes a new version of it, keeping the
+	// prior one. Artifacts of a run on hold may be added but not overwritten.
+	version := 1
+	if existing, err := dao.GetArtifactByRunIDAndPath(ctx, runID, artifactPath); err == nil {
+		if err := ensureRunNotOnHold(ctx, runID); err != nil {
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(map[string]string{"error": fmt.Sprintf("Cannot overwrite artifact: %v", err)})
+			return
+		}
+		version = existing.Version + 1
+	}
+
+	if file == nil {
 		w.WriteHeader(http.StatusBadRequest)
 		json.NewEncoder(w).Encode(map[string]string{"error": "No file uploaded"})
 		return
 	}
-	defer file.Close()
 
-	// Store artifact
-	uri, err := storeArtifact(runUUID, artifactPath, file)
+	// Uploads are streamed, so the quota is checked against the request's
+	// length, which bounds the file's size
+	if !quotaExempt(r) {
+		size := max(r.ContentLength, 1)
+		if _, err := checkRunQuota(ctx, runID, 0, size); writeQuotaError(w, err) {
+			return
+		}
+	}
+
+	// Store artifact, sniffing its content type on the way
+	contentType, contents := sniffArtifact(artifactPath, file)
+	key, err := runArtifactKey(ctx, runID, runUUID)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		json.NewEncoder(w).Encode(map[string]string{"error": fmt.Sprintf("Failed to store artifact: %v", err)})
 		return
 	}
-
-	var artifactType string
-	if strings.HasSuffix(artifactPath, ".png") {
-		artifactType = "image"
-	} else {
-		artifactType = "unknown"
+	uri, size, digest, err := storeArtifact(key, artifactPath, version, contents)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": fmt.Sprintf("Failed to store artifact: %v", err)})
+		return
 	}
-
-	// Insert artifact metadata into database
-	err = dao.UpsertArtifact(runID, artifactPath, uri, artifactType)
+	scanStatus, err := recordArtifactUpload(ctx, runID, runUUID, artifactPath, uri, contentType, size, digest)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to insert artifact metadata"})
@@ -397,23 +905,51 @@ func handleAPILogArtifact(w http.ResponseWriter, r *http.Request) {
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{
-		"status": "ok",
-		"path":   artifactPath,
-		"uri":    uri,
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":       "ok",
+		"path":         artifactPath,
+		"uri":          uri,
+		"sha256":       digest,
+		"content_type": contentType,
+		"version":      version,
+		"scan_status":  scanStatus,
 	})
 }
 
+// recordArtifactUpload records an artifact stored by storeArtifact and acts
+// on its upload, returning the status of its malware scan
+func recordArtifactUpload(ctx context.Context, runID int, runUUID, artifactPath, uri, contentType string, size int64, digest string) (string, error) {
+	serverStats.artifactUploadBytes.Add(size)
+
+	// Insert artifact metadata into database
+	err := dao.UpsertArtifact(ctx, runID, artifactPath, uri, artifactTypeForContentType(contentType), contentType, size, digest)
+	if err != nil {
+		return "", err
+	}
+
+	scanStatus := scanUploadedArtifact(ctx, uri)
+	detectConfusionMatrixArtifact(ctx, runID, artifactPath, uri, size)
+	publishArtifactEvent(runID, artifactPath, uri, size)
+	if err := applyBestCheckpointRule(ctx, runID, runUUID, artifactPath); err != nil {
+		log.Printf("Failed to apply best checkpoint rule to %s of run %s: %v", artifactPath, runUUID, err)
+	}
+	return scanStatus, nil
+}
+
+// UpdateRunNotesRequest is the body of POST /api/runs/notes
+type UpdateRunNotesRequest struct {
+	RunUUID string `json:"run_uuid"`
+	Notes   string `json:"notes"`
+}
+
 func handleAPIUpdateRunNotes(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
 	if r.Method != http.MethodPost {
 		w.WriteHeader(http.StatusMethodNotAllowed)
 		return
 	}
 
-	var req struct {
-		RunUUID string `json:"run_uuid"`
-		Notes   string `json:"notes"`
-	}
+	var req UpdateRunNotesRequest
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		w.WriteHeader(http.StatusBadRequest)
@@ -427,14 +963,14 @@ func handleAPIUpdateRunNotes(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	runID, err := dao.GetRunIDByUUID(req.RunUUID)
+	runID, err := dao.GetRunIDByUUID(ctx, req.RunUUID)
 	if err != nil {
 		w.WriteHeader(http.StatusNotFound)
 		json.NewEncoder(w).Encode(map[string]string{"error": "Run not found"})
 		return
 	}
 
-	err = dao.UpdateRunNotes(runID, req.Notes)
+	err = dao.UpdateRunNotes(ctx, runID, req.Notes)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to update notes"})
@@ -445,23 +981,43 @@ func handleAPIUpdateRunNotes(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
 }
 
+// handleAPICreateExperiment creates an experiment in the project named by the
+// project query parameter, or else the request's token's project, or else the
+// Default project
 func handleAPICreateExperiment(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
 	name := r.URL.Query().Get("name")
-	experimentUUID := uuid.New().String()
+	experimentUUID := newUUID(ctx)
 
 	if name == "" {
 		w.WriteHeader(http.StatusBadRequest)
 		json.NewEncoder(w).Encode(map[string]string{"error": "Missing required field: name"})
 		return
 	}
+	projectID, err := apiProjectID(r)
+	if writeProjectError(w, err) {
+		return
+	}
 
-	err := dao.InsertExperiment(experimentUUID, name)
+	err = dao.InsertExperiment(ctx, experimentUUID, name)
 	if err != nil {
 		log.Printf("Failed to insert experiment: %v", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to create experiment"})
 		return
 	}
+	if projectID != 0 {
+		experimentID, err := dao.GetExperimentIDByUUID(ctx, experimentUUID)
+		if err == nil {
+			err = dao.SetExperimentProject(ctx, experimentID, projectID)
+		}
+		if err != nil {
+			log.Printf("Failed to add experiment %s to its project: %v", experimentUUID, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to create experiment"})
+			return
+		}
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{
@@ -470,22 +1026,60 @@ func handleAPICreateExperiment(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-func handleViewExperiment(w http.ResponseWriter, r *http.Request) {
+// experimentTemplates are the templates of the experiment page
+var experimentTemplates = registerPage("templates/experiment.html", "templates/experiment_readme.html", "templates/parameter_warnings.html", "templates/experiment_notifications.html", "templates/experiment_cost_model.html", "templates/experiment_data_quality.html", "templates/experiment_archives.html", "templates/experiment_failures.html")
+
+func handleViewExperiment(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
 	path := strings.TrimPrefix(r.URL.Path, "/experiments/")
-	experimentUUID := strings.TrimSuffix(path, "/")
+	parts := strings.SplitN(strings.TrimSuffix(path, "/"), "/", 2)
+	experimentUUID := parts[0]
+
+	// Route to sub-handlers
+	if len(parts) == 2 {
+		switch parts[1] {
+		case "readme":
+			handleUpdateExperimentReadme(w, r, experimentUUID)
+			return nil
+		case "readme/history":
+			handleViewExperimentReadmeHistory(w, r, experimentUUID)
+			return nil
+		case "params/normalize":
+			handleNormalizeExperimentParameter(w, r, experimentUUID)
+			return nil
+		case "archive":
+			handleExperimentArchive(w, r, experimentUUID)
+			return nil
+		case "import":
+			handleExperimentImport(w, r, experimentUUID)
+			return nil
+		}
+		if action, ok := strings.CutPrefix(parts[1], "notifications"); ok {
+			handleExperimentNotifications(w, r, experimentUUID, strings.TrimPrefix(action, "/"))
+			return nil
+		}
+		if action, ok := strings.CutPrefix(parts[1], "cost-rates"); ok {
+			handleExperimentCostRates(w, r, experimentUUID, strings.TrimPrefix(action, "/"))
+			return nil
+		}
+		if action, ok := strings.CutPrefix(parts[1], "metric-schema"); ok {
+			handleExperimentMetricSchema(w, r, experimentUUID, strings.TrimPrefix(action, "/"))
+			return nil
+		}
+	}
 
-	experiment, err := dao.GetExperimentByUUID(experimentUUID)
+	experiment, err := dao.GetExperimentByUUID(ctx, experimentUUID)
 	if err != nil {
 		w.WriteHeader(http.StatusNotFound)
 		fmt.Fprintf(w, "Experiment not found")
-		return
+		return nil
 	}
 
-	experimentID, err := dao.GetExperimentIDByUUID(experimentUUID)
+	experimentID, err := dao.GetExperimentIDByUUID(ctx, experimentUUID)
 	if err != nil {
 		w.WriteHeader(http.StatusNotFound)
 		fmt.Fprintf(w, "Experiment not found")
-		return
+		return nil
 	}
 
 	// Get URL query params for open state
@@ -493,47 +1087,87 @@ func handleViewExperiment(w http.ResponseWriter, r *http.Request) {
 	openL1 := r.URL.Query().Get("open_l1")
 
 	// Get level 0 runs
-	level0Runs, err := dao.GetRunsByExperimentIDAndLevel(experimentID, 0)
+	level0Runs, err := dao.GetRunsByExperimentIDAndLevel(ctx, experimentID, 0)
 	if err != nil {
-		log.Printf("Failed to get level 0 runs: %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		return
+		return fmt.Errorf("failed to get level 0 runs: %w", err)
+	}
+
+	gpuSummaries, err := getExperimentGPUSummaries(ctx, experimentID)
+	if err != nil {
+		return fmt.Errorf("failed to load GPU summaries for experiment %s: %w", experimentUUID, err)
+	}
+	var totalGPUHours float64
+	for _, s := range gpuSummaries {
+		totalGPUHours += s.GPUHours
+	}
+	runCosts, err := getExperimentRunCosts(ctx, experimentID, gpuSummaries)
+	if err != nil {
+		return fmt.Errorf("failed to estimate run costs for experiment %s: %w", experimentUUID, err)
+	}
+	var totalCost float64
+	for _, cost := range runCosts {
+		totalCost += cost
+	}
+	costRates, err := getExperimentCostRates(ctx, experimentID)
+	if err != nil {
+		return fmt.Errorf("failed to load cost rates for experiment %s: %w", experimentUUID, err)
+	}
+	bestCheckpoints, err := getExperimentBestCheckpoints(ctx, experimentID)
+	if err != nil {
+		return fmt.Errorf("failed to load best checkpoints for experiment %s: %w", experimentUUID, err)
+	}
+	bestCheckpointRule, err := dao.GetBestCheckpointRule(ctx, experimentID)
+	if err != nil {
+		return fmt.Errorf("failed to load best checkpoint rule for experiment %s: %w", experimentUUID, err)
+	}
+	var bestCheckpointRuleDescription string
+	if bestCheckpointRule != nil {
+		bestCheckpointRuleDescription = formatBestCheckpointRule(bestCheckpointRule)
 	}
 
 	// Build nested run structure
 	// TODO(a-1ebf): Fix N+1 queries - runID and childCount should come from DAO
 	var nestedRuns []NestedRun
 	for _, run := range level0Runs {
-		runID, _ := dao.GetRunIDByUUID(run.UUID)
-		childCount, _ := dao.GetChildRunCount(runID)
+		runID, _ := dao.GetRunIDByUUID(ctx, run.UUID)
+		childCount, _ := dao.GetChildRunCount(ctx, runID)
 
 		nestedRun := NestedRun{
 			Run:        run,
 			ID:         runID,
 			ChildCount: childCount,
+			GPU:        gpuSummaries[runID],
+			Cost:       runCostPointer(runCosts, runID),
+			Best:       bestCheckpoints[runID],
 		}
 
 		// If this run is open, load its children
 		if run.UUID == openL0 && childCount > 0 {
-			childRuns, _ := dao.GetChildRuns(runID)
+			childRuns, _ := dao.GetChildRuns(ctx, runID)
 			for _, childRun := range childRuns {
-				childRunID, _ := dao.GetRunIDByUUID(childRun.UUID)
-				grandchildCount, _ := dao.GetChildRunCount(childRunID)
+				childRunID, _ := dao.GetRunIDByUUID(ctx, childRun.UUID)
+				grandchildCount, _ := dao.GetChildRunCount(ctx, childRunID)
 
 				childNestedRun := NestedRun{
 					Run:        childRun,
 					ID:         childRunID,
 					ChildCount: grandchildCount,
+					GPU:        gpuSummaries[childRunID],
+					Cost:       runCostPointer(runCosts, childRunID),
+					Best:       bestCheckpoints[childRunID],
 				}
 
 				// If this child is open, load its grandchildren
 				if childRun.UUID == openL1 && grandchildCount > 0 {
-					grandchildRuns, _ := dao.GetChildRuns(childRunID)
+					grandchildRuns, _ := dao.GetChildRuns(ctx, childRunID)
 					for _, grandchildRun := range grandchildRuns {
-						grandchildRunID, _ := dao.GetRunIDByUUID(grandchildRun.UUID)
+						grandchildRunID, _ := dao.GetRunIDByUUID(ctx, grandchildRun.UUID)
 						childNestedRun.Children = append(childNestedRun.Children, NestedRun{
-							Run: grandchildRun,
-							ID:  grandchildRunID,
+							Run:  grandchildRun,
+							ID:   grandchildRunID,
+							GPU:  gpuSummaries[grandchildRunID],
+							Cost: runCostPointer(runCosts, grandchildRunID),
+							Best: bestCheckpoints[grandchildRunID],
 						})
 					}
 				}
@@ -545,75 +1179,88 @@ func handleViewExperiment(w http.ResponseWriter, r *http.Request) {
 		nestedRuns = append(nestedRuns, nestedRun)
 	}
 
-	data := struct {
-		Title          string
-		Experiment     *Experiment
-		NestedRuns     []NestedRun
-		OpenL0         string
-		OpenL1         string
-		ExperimentUUID string
-	}{
-		Title:          experiment.Name,
-		Experiment:     experiment,
-		NestedRuns:     nestedRuns,
-		OpenL0:         openL0,
-		OpenL1:         openL1,
-		ExperimentUUID: experimentUUID,
+	parameterWarnings, err := getParameterTypeWarnings(ctx, experimentID)
+	if err != nil {
+		return fmt.Errorf("failed to lint parameters for experiment %s: %w", experimentUUID, err)
 	}
 
-	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	tmpl, err := template.ParseFS(templateFS, "templates/header.html", "templates/experiment.html")
+	subscriptions, err := getExperimentNotificationSubscriptions(ctx, experimentID)
 	if err != nil {
-		log.Printf("Failed to parse template: %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		return
+		return fmt.Errorf("failed to load notification subscriptions for experiment %s: %w", experimentUUID, err)
 	}
-	err = tmpl.ExecuteTemplate(w, "experiment.html", data)
+
+	metricSchema, err := dao.GetMetricSchema(ctx, experimentID)
 	if err != nil {
-		log.Printf("Failed to execute template: %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		return
+		return fmt.Errorf("failed to load metric schema for experiment %s: %w", experimentUUID, err)
 	}
-}
-func handleUpdateRunNotes(w http.ResponseWriter, r *http.Request, runUUID string) {
-	if r.Method != http.MethodPost {
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		return
+	metricSchemaWarnings, err := getMetricSchemaWarnings(ctx, experimentID, metricSchema)
+	if err != nil {
+		return fmt.Errorf("failed to load metric schema warnings for experiment %s: %w", experimentUUID, err)
 	}
 
-	notes := r.FormValue("notes")
-
-	runID, err := dao.GetRunIDByUUID(runUUID)
+	archives, err := getExperimentArchives(experimentUUID)
 	if err != nil {
-		w.WriteHeader(http.StatusNotFound)
-		json.NewEncoder(w).Encode(map[string]string{"error": "Run not found"})
-		return
+		return fmt.Errorf("failed to list archives of experiment %s: %w", experimentUUID, err)
 	}
 
-	err = dao.UpdateRunNotes(runID, notes)
+	failures, err := getExperimentFailures(ctx, experimentID)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to update notes"})
-		return
+		return fmt.Errorf("failed to load failed runs of experiment %s: %w", experimentUUID, err)
 	}
 
-	// Return the notes form fragment for htmx to swap in
 	data := struct {
-		UUID  string
-		Notes string
+		Title              string
+		Experiment         *Experiment
+		NestedRuns         []NestedRun
+		OpenL0             string
+		OpenL1             string
+		ExperimentUUID     string
+		ParameterWarnings  []ParameterTypeWarning
+		MigrationError     string
+		Subscriptions      []NotificationSubscription
+		NotificationEvents []string
+		NotificationError  string
+		GPURunCount        int
+		TotalGPUHours      float64
+		CostedRunCount     int
+		TotalCost          float64
+		CostRates          []CostRate
+		CostRateError      string
+		MetricSchema       []MetricSchemaEntryRow
+		SchemaWarnings     []MetricSchemaWarning
+		SchemaError        string
+		BestCheckpointRule string
+		Archives           []ExperimentArchive
+		ArchiveError       string
+		Failures           []FailureGroup
 	}{
-		UUID:  runUUID,
-		Notes: notes,
+		Title:              experiment.Name,
+		Experiment:         experiment,
+		NestedRuns:         nestedRuns,
+		OpenL0:             openL0,
+		OpenL1:             openL1,
+		ExperimentUUID:     experimentUUID,
+		ParameterWarnings:  parameterWarnings,
+		Subscriptions:      subscriptions,
+		NotificationEvents: notificationEvents,
+		GPURunCount:        len(gpuSummaries),
+		TotalGPUHours:      totalGPUHours,
+		CostedRunCount:     len(runCosts),
+		TotalCost:          totalCost,
+		CostRates:          costRates,
+		MetricSchema:       metricSchema,
+		SchemaWarnings:     metricSchemaWarnings,
+		BestCheckpointRule: bestCheckpointRuleDescription,
+		Archives:           archives,
+		Failures:           failures,
 	}
 
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	tmpl, err := template.ParseFS(templateFS, "templates/run_notes_form.html")
+	tmpl, err := experimentTemplates.Get()
 	if err != nil {
-		log.Printf("Failed to parse template: %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		return
+		return fmt.Errorf("failed to parse template: %w", err)
 	}
-	tmpl.ExecuteTemplate(w, "notes_form", data)
+	return executeTemplate(w, tmpl, "experiment.html", data)
 }
 
 type Parameter struct {
@@ -630,16 +1277,31 @@ type MetricValue struct {
 
 type Metric struct {
 	Key    string
-	Values []MetricValue
+	Latest MetricValue
 }
 
 type Artifact struct {
-	Path string
-	URI  string
-	Type string
+	Path         string
+	URI          string
+	Type         string
+	ContentType  string
+	Size         int64
+	ModifiedAt   time.Time
+	MirrorStatus string
+	Version      int
+	ScanStatus   string
+	ScanResult   string
 }
 
-func handleViewRun(w http.ResponseWriter, r *http.Request) {
+func newArtifact(a ArtifactRow) Artifact {
+	return Artifact{Path: a.Path, URI: a.URI, Type: a.Type, ContentType: a.ContentType, Size: a.SizeBytes, ModifiedAt: a.UpdatedAt.Time, MirrorStatus: a.MirrorStatus, Version: a.Version, ScanStatus: a.ScanStatus, ScanResult: a.ScanResult}
+}
+
+// runPageTemplates are the templates of the run page
+var runPageTemplates = registerPage("templates/run.html", "templates/link_preview.html")
+
+func handleViewRun(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
 	path := strings.TrimPrefix(r.URL.Path, "/runs/")
 	parts := strings.SplitN(path, "/", 2)
 	runUUID := parts[0]
@@ -648,23 +1310,55 @@ func handleViewRun(w http.ResponseWriter, r *http.Request) {
 	if len(parts) == 2 {
 		switch parts[1] {
 		case "overview":
-			executeRunPageTabsTemplate(w, r, runUUID, "overview")
-			handleRunOverview(w, r, runUUID)
-			return
+			return executeRunPageTab(w, r, runUUID, "overview", handleRunOverview)
 		case "artifacts":
-			executeRunPageTabsTemplate(w, r, runUUID, "artifacts")
-			handleRunArtifacts(w, r, runUUID)
-			return
+			return executeRunPageTab(w, r, runUUID, "artifacts", handleRunArtifacts)
+		case "artifact-type":
+			return handleSetArtifactType(w, r, runUUID)
+		case "dependencies":
+			return executeRunPageTab(w, r, runUUID, "dependencies", handleRunDependencies)
+		case "system":
+			return executeRunPageTab(w, r, runUUID, "system", handleRunSystem)
+		case "confusion-matrix":
+			handleRunConfusionMatrix(w, r, runUUID)
+			return nil
+		case "projector":
+			handleRunProjector(w, r, runUUID)
+			return nil
+		case "text-samples":
+			handleRunTextSamples(w, r, runUUID)
+			return nil
+		case "metrics":
+			handleRunMetrics(w, r, runUUID)
+			return nil
+		case "chart.png":
+			return handleRunChart(w, r, runUUID, chartFormatPNG)
+		case "chart.svg":
+			return handleRunChart(w, r, runUUID, chartFormatSVG)
 		case "notes":
 			handleUpdateRunNotes(w, r, runUUID)
-			return
+			return nil
+		case "template":
+			handleSaveRunTemplate(w, r, runUUID)
+			return nil
+		case "events":
+			handleRunEvents(w, r, runUUID)
+			return nil
+		case "delete":
+			handleDeleteRun(w, r, runUUID)
+			return nil
+		case "fork":
+			handleForkRun(w, r, runUUID)
+			return nil
 		}
 	}
 
 	// Main run page
-	run, err := dao.GetRunByUUID(runUUID)
+	run, err := dao.GetRunByUUID(ctx, runUUID)
 	if err != nil {
-		log.Fatalf("Failed to query run: %v", err)
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "Run not found")
+		return nil
 	}
 	name := run.Name
 
@@ -673,60 +1367,106 @@ func handleViewRun(w http.ResponseWriter, r *http.Request) {
 	var grandparentRun *Run
 	if run.ParentRunID != nil {
 		var err error
-		parentRun, err = dao.GetRunByID(*run.ParentRunID)
+		parentRun, err = dao.GetRunByID(ctx, *run.ParentRunID)
 		if err != nil {
-			log.Printf("Failed to get parent run (id=%d) for run %s: %v", *run.ParentRunID, runUUID, err)
-			w.WriteHeader(http.StatusInternalServerError)
-			fmt.Fprintf(w, "Internal server error")
-			return
+			return fmt.Errorf("failed to get parent run (id=%d) for run %s: %w", *run.ParentRunID, runUUID, err)
 		}
 		if parentRun != nil && parentRun.ParentRunID != nil {
-			grandparentRun, err = dao.GetRunByID(*parentRun.ParentRunID)
+			grandparentRun, err = dao.GetRunByID(ctx, *parentRun.ParentRunID)
 			if err != nil {
-				log.Printf("Failed to get grandparent run (id=%d) for run %s: %v", *parentRun.ParentRunID, runUUID, err)
-				w.WriteHeader(http.StatusInternalServerError)
-				fmt.Fprintf(w, "Internal server error")
-				return
+				return fmt.Errorf("failed to get grandparent run (id=%d) for run %s: %w", *parentRun.ParentRunID, runUUID, err)
 			}
 		}
 	}
 
+	// The run forked from is left out if it has since been deleted
+	var forkedFrom *Run
+	if run.ForkedFrom != "" {
+		forkedFrom, _ = dao.GetRunByUUID(ctx, run.ForkedFrom)
+	}
+
 	// Get experiment for this run
-	experiment, err := dao.GetExperimentForRunUUID(runUUID)
+	experiment, err := dao.GetExperimentForRunUUID(ctx, runUUID)
 	if err != nil {
 		log.Printf("Failed to get experiment for run %s: %v", runUUID, err)
 		// Don't fail the request, just leave experiment nil
 		experiment = nil
 	}
 
+	runID, err := dao.GetRunIDByUUID(ctx, runUUID)
+	if err != nil {
+		return fmt.Errorf("failed to get run id for run %s: %w", runUUID, err)
+	}
+	hold, err := getRunHold(ctx, runID)
+	if err != nil {
+		return fmt.Errorf("failed to get hold for run %s: %w", runUUID, err)
+	}
+	preview, err := runLinkPreview(r, runID, run, experiment)
+	if err != nil {
+		return fmt.Errorf("failed to describe run %s for link previews: %w", runUUID, err)
+	}
+
 	data := struct {
 		Title          string
+		Preview        *LinkPreview
 		UUID           string
+		ShortUUID      string
 		Name           string
 		ParentRun      *Run
 		GrandparentRun *Run
+		ForkedFrom     *Run
 		Experiment     *Experiment
+		Hold           *RunHold
+		Status         string
+		StatusMessage  string
+		Signature      string
+		PurgeAfterDays int
+		PurgeNotice    bool
 	}{
 		Title:          name,
+		Preview:        preview,
 		UUID:           runUUID,
+		ShortUUID:      shortRunUUID(runUUID),
 		Name:           name,
 		ParentRun:      parentRun,
 		GrandparentRun: grandparentRun,
+		ForkedFrom:     forkedFrom,
 		Experiment:     experiment,
+		Hold:           hold,
+		Status:         run.Status,
+		StatusMessage:  run.StatusMessage,
+		Signature:      failureSignature(run.StatusMessage),
+		PurgeAfterDays: trashPurgeAfterDays,
+		PurgeNotice:    trashPurgeAfterDays > 0 && smtpAddr != "",
 	}
 
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	tmpl, err := template.ParseFS(templateFS, "templates/header.html", "templates/run.html")
+	tmpl, err := runPageTemplates.Get()
 	if err != nil {
-		log.Fatalf("Failed to parse template: %v", err)
+		return fmt.Errorf("failed to parse template: %w", err)
 	}
-	err = tmpl.ExecuteTemplate(w, "run.html", data)
-	if err != nil {
-		log.Fatalf("Failed to execute template: %v", err)
+	return executeTemplate(w, tmpl, "run.html", data)
+}
+
+// executeRunPageTab responds with a tab of the run page beneath the page's
+// tabs. The tab is rendered first, so that if it fails nothing has been
+// written and the error page can be served instead.
+func executeRunPageTab(w http.ResponseWriter, r *http.Request, runUUID string, pageName string, tab func(http.ResponseWriter, *http.Request, string) error) error {
+	buffered := &bufferedResponseWriter{header: w.Header()}
+	if err := tab(buffered, r, runUUID); err != nil {
+		return err
+	}
+	if err := executeRunPageTabsTemplate(w, r, runUUID, pageName); err != nil {
+		return err
 	}
+	_, err := w.Write(buffered.body.Bytes())
+	return err
 }
 
-func executeRunPageTabsTemplate(w http.ResponseWriter, r *http.Request, runUUID string, pageName string) {
+// runPageTabsTemplates are the templates of the tabs of the run page
+var runPageTabsTemplates = registerTemplates("templates/run_page_tabs.html")
+
+func executeRunPageTabsTemplate(w http.ResponseWriter, r *http.Request, runUUID string, pageName string) error {
 	maybeCurrentArtifactPath := r.URL.Query().Get("current_artifact_path")
 	var currentArtifactPath *string
 	if maybeCurrentArtifactPath == "" {
@@ -744,112 +1484,165 @@ func executeRunPageTabsTemplate(w http.ResponseWriter, r *http.Request, runUUID
 		UUID:                runUUID,
 		PageName:            pageName,
 	}
-	tmpl, err := template.ParseFS(templateFS, "templates/run_page_tabs.html")
-	if err != nil {
-		log.Fatalf("Failed to parse template: %v", err)
-	}
-	err = tmpl.Execute(w, data)
+	tmpl, err := runPageTabsTemplates.Get()
 	if err != nil {
-		log.Fatalf("Failed to execute template: %v", err)
+		return fmt.Errorf("failed to parse template: %w", err)
 	}
+	return executeTemplate(w, tmpl, "run_page_tabs.html", data)
 }
 
-func handleRunOverview(w http.ResponseWriter, r *http.Request, runUUID string) {
-	run, err := dao.GetRunByUUID(runUUID)
+// runOverviewTemplates are the templates of the run overview
+var runOverviewTemplates = registerTemplates("templates/run_overview.html", "templates/run_notes_form.html", "templates/curve_chart.html", "templates/run_text_samples.html", "templates/run_metrics.html", "templates/run_confusion_matrix.html")
+
+func handleRunOverview(w http.ResponseWriter, r *http.Request, runUUID string) error {
+	ctx := r.Context()
+	run, err := dao.GetRunByUUID(ctx, runUUID)
 	if err != nil {
-		log.Fatalf("Failed to query run: %v", err)
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "Run not found")
+		return nil
 	}
 	name := run.Name
 
-	runID, err := dao.GetRunIDByUUID(runUUID)
+	runID, err := dao.GetRunIDByUUID(ctx, runUUID)
 	if err != nil {
-		log.Fatalf("Failed to get run ID: %v", err)
+		return fmt.Errorf("failed to get run ID: %w", err)
 	}
 
 	// Query parameters for this run
-	paramRows, err := dao.GetParametersByRunID(runID)
+	paramRows, err := dao.GetParametersByRunID(ctx, runID)
 	if err != nil {
-		log.Fatalf("Failed to query parameters: %v", err)
+		return fmt.Errorf("failed to query parameters: %w", err)
 	}
 
 	var parameters []Parameter
 	for _, p := range paramRows {
-		var value string
-		switch p.ValueType {
-		case "string":
-			value = p.ValueString.String
-		case "bool":
-			if p.ValueBool.Bool {
-				value = "true"
-			} else {
-				value = "false"
-			}
-		case "float":
-			value = fmt.Sprintf("%g", p.ValueFloat.Float64)
-		case "int":
-			value = fmt.Sprintf("%d", p.ValueInt.Int64)
-		}
+		parameters = append(parameters, Parameter{Key: p.Key, Value: formatParameterValue(p), Type: p.ValueType})
+	}
 
-		parameters = append(parameters, Parameter{Key: p.Key, Value: value, Type: p.ValueType})
+	// Only each metric's latest point is embedded in the page; the charts
+	// fetch their series, downsampled, once they are scrolled into view
+	metrics, err := getRunMetricsView(r, runID, runUUID)
+	if err != nil {
+		return fmt.Errorf("failed to query metrics: %w", err)
 	}
 
-	// Query metrics for this run
-	metricRows, err := dao.GetMetricsByRunID(runID)
+	annotations, err := getRunAnnotations(ctx, runID)
 	if err != nil {
-		log.Fatalf("Failed to query metrics: %v", err)
+		return fmt.Errorf("failed to query annotations for run %s: %w", runUUID, err)
 	}
 
-	// Group metrics by key
-	metricsMap := make(map[string][]MetricValue)
-	for _, m := range metricRows {
-		metricsMap[m.Key] = append(metricsMap[m.Key], MetricValue{
-			XValue:   fmt.Sprintf("%g", m.XValue),
-			YValue:   fmt.Sprintf("%g", m.YValue),
-			LoggedAt: fmt.Sprintf("%d", m.LoggedAt.UnixMilli()),
-		})
+	confusionMatrices, err := getRunConfusionMatrices(ctx, runID, runUUID)
+	if err != nil {
+		return fmt.Errorf("failed to query confusion matrices for run %s: %w", runUUID, err)
 	}
 
-	// Convert to slice of Metric
-	var metrics []Metric
-	for key, values := range metricsMap {
-		metrics = append(metrics, Metric{
-			Key:    key,
-			Values: values,
-		})
+	curves, err := getRunCurves(ctx, runID)
+	if err != nil {
+		return fmt.Errorf("failed to query curves for run %s: %w", runUUID, err)
+	}
+
+	textSamples, err := getRunTextSamples(ctx, runID, runUUID)
+	if err != nil {
+		return fmt.Errorf("failed to query text samples for run %s: %w", runUUID, err)
+	}
+
+	embeddingKeys, err := getRunEmbeddingKeys(ctx, runID)
+	if err != nil {
+		return fmt.Errorf("failed to query embeddings for run %s: %w", runUUID, err)
+	}
+
+	tags, err := dao.GetRunTags(ctx, runID)
+	if err != nil {
+		return fmt.Errorf("failed to query tags for run %s: %w", runUUID, err)
+	}
+
+	environment, err := dao.GetRunEnvironment(ctx, runID)
+	if err != nil {
+		return fmt.Errorf("failed to query environment for run %s: %w", runUUID, err)
+	}
+
+	bestCheckpoint, err := getRunBestCheckpoint(ctx, runID)
+	if err != nil {
+		return fmt.Errorf("failed to query best checkpoint for run %s: %w", runUUID, err)
+	}
+
+	source, err := getRunSource(ctx, runID)
+	if err != nil {
+		return fmt.Errorf("failed to query source for run %s: %w", runUUID, err)
+	}
+
+	seeds, err := getRunSeeds(ctx, runID)
+	if err != nil {
+		return fmt.Errorf("failed to query seeds for run %s: %w", runUUID, err)
 	}
 
 	data := struct {
-		Title      string
-		UUID       string
-		Name       string
-		Notes      string
-		Parameters []Parameter
-		Metrics    []Metric
+		Title             string
+		UUID              string
+		Name              string
+		Notes             RunNotes
+		Parameters        []Parameter
+		Metrics           MetricsView
+		Annotations       []Annotation
+		ConfusionMatrices []ConfusionMatrixView
+		Curves            []RunCurve
+		TextSamples       []TextSamplesView
+		EmbeddingKeys     []string
+		Tags              []RunTagRow
+		Environment       []EnvironmentVariableRow
+		BestCheckpoint    *BestCheckpoint
+		Source            *RunSource
+		Seeds             *RunSeeds
+		Reproducible      bool
 	}{
-		Title:      name,
-		UUID:       runUUID,
-		Name:       name,
-		Notes:      run.Notes,
-		Parameters: parameters,
-		Metrics:    metrics,
+		Title:             name,
+		UUID:              runUUID,
+		Name:              name,
+		Notes:             RunNotes{UUID: runUUID, Notes: run.Notes},
+		Parameters:        parameters,
+		Metrics:           metrics,
+		Annotations:       annotations,
+		ConfusionMatrices: confusionMatrices,
+		Curves:            curves,
+		TextSamples:       textSamples,
+		EmbeddingKeys:     embeddingKeys,
+		Tags:              tags,
+		Environment:       environment,
+		BestCheckpoint:    bestCheckpoint,
+		Source:            source,
+		Seeds:             seeds,
+		Reproducible:      runReproducible(seeds, source, environment),
 	}
 
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	tmpl, err := template.ParseFS(templateFS, "templates/run_overview.html", "templates/run_notes_form.html")
+	tmpl, err := runOverviewTemplates.Get()
 	if err != nil {
-		log.Fatalf("Failed to parse template: %v", err)
-	}
-	err = tmpl.Execute(w, data)
-	if err != nil {
-		log.Fatalf("Failed to execute template: %v", err)
+		return fmt.Errorf("failed to parse template: %w", err)
 	}
+	return executeTemplate(w, tmpl, "run_overview.html", data)
 }
 
 type ArtifactsTreeNode struct {
 	Children     map[string]*ArtifactsTreeNode
+	Entries      []ArtifactsTreeEntry
 	ArtifactURI  *string
 	ArtifactPath *string
 	RunUUID      *string
+	// Path is the path of the artifact or directory the node stands for
+	Path string
+	// ScanBlock explains why the artifact cannot be downloaded, if it cannot
+	ScanBlock string
+	// Size and ModifiedAt are cumulative for directories: the total size and
+	// most recent modification of all artifacts beneath them.
+	Size       int64
+	ModifiedAt time.Time
+}
+
+// ArtifactsTreeEntry is a named child of a tree node, in display order
+type ArtifactsTreeEntry struct {
+	Name string
+	Node *ArtifactsTreeNode
 }
 
 func hashString(s string) string {
@@ -857,34 +1650,69 @@ func hashString(s string) string {
 	return hex.EncodeToString(h[:8]) // Use first 8 bytes for shorter ID
 }
 
-func handleRunArtifacts(w http.ResponseWriter, r *http.Request, runUUID string) {
-	runID, err := dao.GetRunIDByUUID(runUUID)
+// runArtifactsTemplates are the templates of the artifacts tab of the run page
+var runArtifactsTemplates = registerTemplates("templates/run_artifacts.html", "templates/artifact_display.html")
+
+func handleRunArtifacts(w http.ResponseWriter, r *http.Request, runUUID string) error {
+	ctx := r.Context()
+	runID, err := dao.GetRunIDByUUID(ctx, runUUID)
 	if err != nil {
-		log.Fatalf("Failed to query run: %v", err)
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "Run not found")
+		return nil
 	}
 
 	// Query artifacts for this run
-	artifactRows, err := dao.GetArtifactsByRunID(runID)
+	artifactRows, err := dao.GetArtifactsByRunID(ctx, runID)
 	if err != nil {
-		log.Fatalf("Failed to query artifacts: %v", err)
+		return fmt.Errorf("failed to query artifacts: %w", err)
 	}
 
 	var artifacts []Artifact
 	for _, a := range artifactRows {
-		artifacts = append(artifacts, Artifact{Path: a.Path, URI: a.URI, Type: a.Type})
+		artifacts = append(artifacts, newArtifact(a))
 	}
 
+	sortBy := r.URL.Query().Get("sort")
 	artifactsTree := assembleArtifactsTree(runUUID, artifacts)
+	sortArtifactsTree(&artifactsTree, sortBy)
 
 	// Pull out the current artifact for display if it's present in the request
 	currentArtifactPath := r.URL.Query().Get("current_artifact_path")
 	log.Println("current artifact:", currentArtifactPath)
 
 	var currentArtifact *Artifact = nil
+	var currentView ArtifactView
 	if currentArtifactPath != "" {
 		for _, artifact := range artifacts {
 			if artifact.Path == currentArtifactPath {
 				currentArtifact = &artifact
+				currentView = newArtifactView(ctx, artifact)
+			}
+		}
+	}
+
+	// The versions of the current artifact, newest first, if it has prior
+	// ones. A prior version may be viewed in its place.
+	var versions []Artifact
+	viewedVersion := 0
+	if currentArtifact != nil {
+		prior, err := dao.GetArtifactVersions(ctx, runID, currentArtifact.Path)
+		if err != nil {
+			return fmt.Errorf("failed to query artifact versions: %w", err)
+		}
+		if len(prior) > 0 {
+			versions = append(versions, *currentArtifact)
+		}
+		for _, a := range prior {
+			versions = append(versions, newArtifact(a))
+		}
+		if v, err := strconv.Atoi(r.URL.Query().Get("current_artifact_version")); err == nil && v != currentArtifact.Version {
+			for _, version := range versions {
+				if version.Version == v {
+					currentView = newArtifactView(ctx, version)
+					viewedVersion = v
+				}
 			}
 		}
 	}
@@ -893,112 +1721,243 @@ func handleRunArtifacts(w http.ResponseWriter, r *http.Request, runUUID string)
 		UUID            string
 		ArtifactsTree   ArtifactsTreeNode
 		CurrentArtifact *Artifact
+		CurrentView     ArtifactView
+		// Versions are the current artifact's versions, newest first, if it
+		// has prior ones
+		Versions []Artifact
+		// ViewedVersion is the prior version shown in place of the current
+		// artifact, or 0 for the current one
+		ViewedVersion int
+		Sort          string
+		// Mirroring is whether artifacts are replicated to a mirror store
+		Mirroring bool
 	}{
 		UUID:            runUUID,
 		ArtifactsTree:   artifactsTree,
 		CurrentArtifact: currentArtifact,
+		CurrentView:     currentView,
+		Versions:        versions,
+		ViewedVersion:   viewedVersion,
+		Sort:            sortBy,
+		Mirroring:       artifactMirror != nil,
 	}
 
-	tmpl := template.New("run_artifacts.html").Funcs(template.FuncMap{
-		"hash": hashString,
-	})
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	tmpl, err = tmpl.ParseFS(templateFS, "templates/run_artifacts.html")
-	if err != nil {
-		log.Fatalf("Failed to parse template: %v", err)
-	}
-	err = tmpl.Execute(w, data)
+	tmpl, err := runArtifactsTemplates.Get()
 	if err != nil {
-		log.Fatalf("Failed to execute template: %v", err)
+		return fmt.Errorf("failed to parse template: %w", err)
 	}
+	return executeTemplate(w, tmpl, "run_artifacts.html", data)
+}
+
+func newArtifactsTreeNode() *ArtifactsTreeNode {
+	return &ArtifactsTreeNode{Children: make(map[string]*ArtifactsTreeNode)}
 }
 
 func assembleArtifactsTree(runUUID string, artifacts []Artifact) ArtifactsTreeNode {
-	root := ArtifactsTreeNode{make(map[string]*ArtifactsTreeNode), nil, nil, nil}
+	root := newArtifactsTreeNode()
 	for _, artifact := range artifacts {
-		node := &root
+		node := root
+		node.addArtifactMetadata(artifact)
 		parts := strings.Split(artifact.Path, "/")
-		for _, part := range parts {
+		for i, part := range parts {
 			child, ok := node.Children[part]
 			if ok {
 				node = child
 			} else {
-				newNode := ArtifactsTreeNode{make(map[string]*ArtifactsTreeNode), nil, nil, nil}
-				node.Children[part] = &newNode
-				node = &newNode
+				newNode := newArtifactsTreeNode()
+				newNode.Path = strings.Join(parts[:i+1], "/")
+				node.Children[part] = newNode
+				node = newNode
 			}
+			node.addArtifactMetadata(artifact)
 		}
 		node.ArtifactURI = &artifact.URI
 		node.ArtifactPath = &artifact.Path
 		node.RunUUID = &runUUID
+		node.ScanBlock = artifactScanBlock(artifact.ScanStatus)
+	}
+	sortArtifactsTree(root, "name")
+	return *root
+}
+
+// addArtifactMetadata folds an artifact's size and modification time into a node
+func (node *ArtifactsTreeNode) addArtifactMetadata(artifact Artifact) {
+	node.Size += artifact.Size
+	if artifact.ModifiedAt.After(node.ModifiedAt) {
+		node.ModifiedAt = artifact.ModifiedAt
 	}
-	return root
 }
 
-func handleViewArtifact(w http.ResponseWriter, r *http.Request) {
+// sortArtifactsTree orders the entries of every node in the tree. sortBy may
+// be "size" or "modified" (largest or most recent first); anything else sorts
+// by name.
+func sortArtifactsTree(node *ArtifactsTreeNode, sortBy string) {
+	node.Entries = node.Entries[:0]
+	for name, child := range node.Children {
+		node.Entries = append(node.Entries, ArtifactsTreeEntry{Name: name, Node: child})
+		sortArtifactsTree(child, sortBy)
+	}
+	sort.Slice(node.Entries, func(i, j int) bool {
+		a, b := node.Entries[i], node.Entries[j]
+		switch {
+		case sortBy == "size" && a.Node.Size != b.Node.Size:
+			return a.Node.Size > b.Node.Size
+		case sortBy == "modified" && !a.Node.ModifiedAt.Equal(b.Node.ModifiedAt):
+			return a.Node.ModifiedAt.After(b.Node.ModifiedAt)
+		}
+		return a.Name < b.Name
+	})
+}
+
+// artifactTemplates are the templates of the artifact page
+var artifactTemplates = registerTemplates("templates/artifact_display.html")
+
+func handleViewArtifact(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
 	runUUID := r.URL.Query().Get("run_uuid")
 	artifactPath := r.URL.Query().Get("path")
 
 	if runUUID == "" {
 		w.WriteHeader(http.StatusBadRequest)
 		fmt.Fprintf(w, "Missing required parameter: run_uuid")
-		return
+		return nil
 	}
 
 	// Get run_id from uuid
-	runID, err := dao.GetRunIDByUUID(runUUID)
+	runID, err := dao.GetRunIDByUUID(ctx, runUUID)
 	if err != nil {
 		w.WriteHeader(http.StatusNotFound)
 		fmt.Fprintf(w, "Run not found")
-		return
+		return nil
 	}
 
 	// Query artifact URI and type from database
-	artifact, err := dao.GetArtifactByRunIDAndPath(runID, artifactPath)
+	artifact, err := dao.GetArtifactByRunIDAndPath(ctx, runID, artifactPath)
 	if err != nil {
 		w.WriteHeader(http.StatusNotFound)
 		fmt.Fprintf(w, "Artifact not found")
-		return
+		return nil
 	}
 
-	// Render template with artifact URI and type
-	data := struct {
-		ArtifactURI  string
-		ArtifactType string
-	}{
-		ArtifactURI:  artifact.URI,
-		ArtifactType: artifact.Type,
-	}
+	// Render the artifact with its inline viewer
+	view := newArtifactView(ctx, newArtifact(*artifact))
 
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	tmpl, err := template.ParseFS(templateFS, "templates/artifact_display.html")
+	tmpl, err := artifactTemplates.Get()
 	if err != nil {
-		log.Fatalf("Failed to parse template: %v", err)
-	}
-	err = tmpl.Execute(w, data)
-	if err != nil {
-		log.Fatalf("Failed to execute template: %v", err)
+		return fmt.Errorf("failed to parse template: %w", err)
 	}
+	return executeTemplate(w, tmpl, "artifact_display.html", view)
 }
 
 func handleServeArtifactBlob(w http.ResponseWriter, r *http.Request) {
-	artifactURI := r.URL.Query().Get("uri")
-	if strings.HasPrefix(artifactURI, "file://") {
-		requestedPath := strings.TrimPrefix(artifactURI, "file://")
-		if filepath.IsAbs(requestedPath) {
-			http.Error(w, "Forbidden absolute path", http.StatusForbidden)
+	ctx := r.Context()
+	uri := r.URL.Query().Get("uri")
+	key, err := artifactStore.Key(uri)
+	if errors.Is(err, errArtifactURIOutsideStore) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Bad request", http.StatusBadRequest)
+		return
+	}
+	// Housekeeping reports are only for admins, through the housekeeping API,
+	// and the chunks of uploads in progress are not artifacts yet
+	if strings.HasPrefix(key, housekeepingReportPrefix) || strings.HasPrefix(key, artifactUploadPrefix) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	// Artifacts are looked up by the URI they were stored under, however the
+	// request spelled it
+	status, result, err := dao.GetArtifactScanStatusByURI(ctx, artifactStore.URI(key))
+	if err != nil {
+		log.Printf("Failed to query scan status of artifact %s: %v", key, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	if status == artifactScanQuarantined {
+		http.Error(w, "Forbidden: artifact quarantined by the malware scanner: "+result, http.StatusForbidden)
+		return
+	}
+	if block := artifactScanBlock(status); block != "" {
+		http.Error(w, "Conflict: artifact "+block, http.StatusConflict)
+		return
+	}
+
+	// Serve the nearest copy of the artifact, falling back to the others if it
+	// cannot be read
+	var file io.ReadCloser
+	for _, store := range artifactCopies(ctx, uri) {
+		// Artifacts small enough to cache are served from the artifact cache,
+		// so that viewing them again does not fetch them from object storage
+		if cached, ok := store.(*cachingArtifactStore); ok {
+			var cachedFile *os.File
+			if cachedFile, err = cached.openCached(ctx, key); err == nil {
+				file = cachedFile
+				break
+			}
+			if !errors.Is(err, errArtifactTooLargeToCache) {
+				if !errors.Is(err, fs.ErrNotExist) {
+					log.Printf("Failed to open artifact %s: %v", key, err)
+				}
+				continue
+			}
+			store = cached.ArtifactStore
 		}
-		cleanPath := filepath.Clean(filepath.Join(artifactStorePath, requestedPath))
 
-		// Ensure the path is within the artifact store to prevent path traversal
-		if !strings.HasPrefix(cleanPath, artifactStorePath) {
-			http.Error(w, "Forbidden", http.StatusForbidden)
+		// Stores that can sign URLs serve artifacts directly to the client
+		if signer, ok := store.(artifactURLSigner); ok {
+			signedURL, err := signer.PresignGet(key, artifactPresignExpiry)
+			if err != nil {
+				log.Printf("Failed to presign artifact %s: %v", key, err)
+				http.Error(w, "Internal server error", http.StatusInternalServerError)
+				return
+			}
+			http.Redirect(w, r, signedURL, http.StatusFound)
 			return
 		}
 
-		http.ServeFile(w, r, cleanPath)
+		file, err = getArtifact(ctx, store, key)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			log.Printf("Failed to open artifact %s: %v", key, err)
+		}
+	}
+	if errors.Is(err, fs.ErrNotExist) {
+		http.Error(w, "Not found", http.StatusNotFound)
 		return
-	} else {
-		http.Error(w, "Bad request", http.StatusBadRequest)
 	}
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	defer file.Close()
+
+	release, ok := artifactServeLimiter.acquire(ctx)
+	if !ok {
+		log.Printf("Turned away download of artifact %s: artifact serving is saturated", key)
+		artifactServeLimiter.rejectSaturated(w)
+		return
+	}
+	defer release()
+	w = artifactServeLimiter.throttle(ctx, w)
+
+	// HTML artifacts are shown inline, so keep their scripts from running as
+	// this site when they are opened directly
+	w.Header().Set("Content-Security-Policy", "sandbox")
+
+	if f, ok := file.(*os.File); ok {
+		if info, err := f.Stat(); err == nil {
+			http.ServeContent(w, r, key, info.ModTime(), f)
+			return
+		}
+	}
+	if contentType := mime.TypeByExtension(path.Ext(key)); contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	io.Copy(w, file)
 }
@@ -17,7 +17,9 @@ func TestHandleServeArtifactBlob(t *testing.T) {
 	defer os.RemoveAll(tempDir)
 
 	// Set the global artifact store path
-	artifactStorePath = tempDir
+	defer func(d DAO) { dao = d }(dao)
+	dao = &scanDAO{}
+	artifactStore = &fileArtifactStore{root: tempDir}
 
 	// Create a test file in the artifact store
 	testContent := []byte("test artifact content")
@@ -67,6 +69,12 @@ func TestHandleServeArtifactBlob(t *testing.T) {
 			expectedStatus: http.StatusForbidden,
 			expectContent:  false,
 		},
+		{
+			name:           "housekeeping report",
+			path:           "file://_housekeeping/artifact-gc/report.json",
+			expectedStatus: http.StatusForbidden,
+			expectContent:  false,
+		},
 		{
 			name:           "missing file:// prefix",
 			path:           "run123/artifact.txt",
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"html"
+	"html/template"
+	"regexp"
+	"strings"
+)
+
+// renderMarkdown converts a small, commonly used subset of Markdown to HTML:
+// headings, paragraphs, ordered and unordered lists, blockquotes, fenced code
+// blocks, horizontal rules, and inline code, emphasis, and links. Raw HTML in
+// the source is always escaped, so the output is safe to embed in a page.
+func renderMarkdown(src string) template.HTML {
+	var out strings.Builder
+	lines := strings.Split(strings.ReplaceAll(src, "\r\n", "\n"), "\n")
+
+	var paragraph []string
+	flushParagraph := func() {
+		if len(paragraph) > 0 {
+			out.WriteString("<p>" + renderMarkdownInline(strings.Join(paragraph, " ")) + "</p>\n")
+			paragraph = nil
+		}
+	}
+
+	listTag := ""
+	closeList := func() {
+		if listTag != "" {
+			out.WriteString("</" + listTag + ">\n")
+			listTag = ""
+		}
+	}
+
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		trimmed := strings.TrimSpace(line)
+
+		switch {
+		case strings.HasPrefix(trimmed, "```"):
+			flushParagraph()
+			closeList()
+			var code []string
+			for i++; i < len(lines) && !strings.HasPrefix(strings.TrimSpace(lines[i]), "```"); i++ {
+				code = append(code, lines[i])
+			}
+			out.WriteString("<pre><code>" + html.EscapeString(strings.Join(code, "\n")) + "</code></pre>\n")
+
+		case trimmed == "":
+			flushParagraph()
+			closeList()
+
+		case markdownHeadingPattern.MatchString(trimmed):
+			flushParagraph()
+			closeList()
+			m := markdownHeadingPattern.FindStringSubmatch(trimmed)
+			level := string(rune('0' + len(m[1])))
+			out.WriteString("<h" + level + ">" + renderMarkdownInline(m[2]) + "</h" + level + ">\n")
+
+		case markdownRulePattern.MatchString(trimmed):
+			flushParagraph()
+			closeList()
+			out.WriteString("<hr>\n")
+
+		case strings.HasPrefix(trimmed, ">"):
+			flushParagraph()
+			closeList()
+			var quote []string
+			for ; i < len(lines) && strings.HasPrefix(strings.TrimSpace(lines[i]), ">"); i++ {
+				quote = append(quote, strings.TrimPrefix(strings.TrimPrefix(strings.TrimSpace(lines[i]), ">"), " "))
+			}
+			i--
+			out.WriteString("<blockquote>\n" + string(renderMarkdown(strings.Join(quote, "\n"))) + "</blockquote>\n")
+
+		case markdownBulletPattern.MatchString(trimmed), markdownOrderedPattern.MatchString(trimmed):
+			flushParagraph()
+			tag, item := "ul", ""
+			if m := markdownBulletPattern.FindStringSubmatch(trimmed); m != nil {
+				item = m[1]
+			} else {
+				tag, item = "ol", markdownOrderedPattern.FindStringSubmatch(trimmed)[1]
+			}
+			if listTag != tag {
+				closeList()
+				out.WriteString("<" + tag + ">\n")
+				listTag = tag
+			}
+			out.WriteString("<li>" + renderMarkdownInline(item) + "</li>\n")
+
+		default:
+			closeList()
+			paragraph = append(paragraph, trimmed)
+		}
+	}
+	flushParagraph()
+	closeList()
+
+	return template.HTML(out.String())
+}
+
+var (
+	markdownHeadingPattern = regexp.MustCompile(`^(#{1,6})\s+(.*)$`)
+	markdownRulePattern    = regexp.MustCompile(`^(-{3,}|\*{3,}|_{3,})$`)
+	markdownBulletPattern  = regexp.MustCompile(`^[-*+]\s+(.*)$`)
+	markdownOrderedPattern = regexp.MustCompile(`^\d+[.)]\s+(.*)$`)
+
+	markdownLinkPattern   = regexp.MustCompile(`\[([^\]]+)\]\(([^)\s]+)\)`)
+	markdownStrongPattern = regexp.MustCompile(`\*\*([^*]+)\*\*|__([^_]+)__`)
+	markdownEmPattern     = regexp.MustCompile(`\*([^*]+)\*|\b_([^_]+)_\b`)
+)
+
+// renderMarkdownInline escapes a span of text and applies inline formatting.
+// Code spans are split out first so their contents are left untouched.
+func renderMarkdownInline(text string) string {
+	var out strings.Builder
+	parts := strings.Split(text, "`")
+	for i, part := range parts {
+		// Odd segments are inside backticks, unless the final backtick is unmatched
+		if i%2 == 1 && i < len(parts)-1 {
+			out.WriteString("<code>" + html.EscapeString(part) + "</code>")
+			continue
+		}
+		if i%2 == 1 {
+			out.WriteString("`")
+		}
+		out.WriteString(renderMarkdownFormatting(html.EscapeString(part)))
+	}
+	return out.String()
+}
+
+func renderMarkdownFormatting(escaped string) string {
+	escaped = markdownLinkPattern.ReplaceAllStringFunc(escaped, func(m string) string {
+		sub := markdownLinkPattern.FindStringSubmatch(m)
+		if !isSafeMarkdownURL(html.UnescapeString(sub[2])) {
+			return sub[1]
+		}
+		return `<a href="` + sub[2] + `">` + sub[1] + `</a>`
+	})
+	escaped = markdownStrongPattern.ReplaceAllString(escaped, "<strong>$1$2</strong>")
+	escaped = markdownEmPattern.ReplaceAllString(escaped, "<em>$1$2</em>")
+	return escaped
+}
+
+// isSafeMarkdownURL allows relative links and a short list of URL schemes so
+// that links like javascript:... are rendered as plain text.
+func isSafeMarkdownURL(url string) bool {
+	lower := strings.ToLower(url)
+	for _, scheme := range []string{"http://", "https://", "mailto:"} {
+		if strings.HasPrefix(lower, scheme) {
+			return true
+		}
+	}
+	return !strings.Contains(lower, ":")
+}
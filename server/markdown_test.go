@@ -0,0 +1,73 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderMarkdown(t *testing.T) {
+	tests := []struct {
+		name     string
+		src      string
+		contains []string
+		excludes []string
+	}{
+		{
+			name:     "heading and paragraph",
+			src:      "# Goals\n\nFind the best\nlearning rate.",
+			contains: []string{"<h1>Goals</h1>", "<p>Find the best learning rate.</p>"},
+		},
+		{
+			name:     "unordered and ordered lists",
+			src:      "- one\n- two\n\n1. first\n2. second",
+			contains: []string{"<ul>\n<li>one</li>\n<li>two</li>\n</ul>", "<ol>\n<li>first</li>\n<li>second</li>\n</ol>"},
+		},
+		{
+			name:     "inline formatting",
+			src:      "**bold** and *em* and `x < y` in snake_case_name",
+			contains: []string{"<strong>bold</strong>", "<em>em</em>", "<code>x &lt; y</code>", "snake_case_name"},
+		},
+		{
+			name:     "fenced code block is escaped verbatim",
+			src:      "```\n<b>**not bold**</b>\n```",
+			contains: []string{"<pre><code>&lt;b&gt;**not bold**&lt;/b&gt;</code></pre>"},
+		},
+		{
+			name:     "links",
+			src:      "[paper](https://arxiv.org/abs/1234?a=1&b=2)",
+			contains: []string{`<a href="https://arxiv.org/abs/1234?a=1&amp;b=2">paper</a>`},
+		},
+		{
+			name:     "javascript links are not rendered",
+			src:      "[click](javascript:alert(1))",
+			excludes: []string{"<a ", "href"},
+		},
+		{
+			name:     "raw html is escaped",
+			src:      "<script>alert('x')</script>",
+			contains: []string{"&lt;script&gt;"},
+			excludes: []string{"<script>"},
+		},
+		{
+			name:     "blockquote and rule",
+			src:      "> quoted\n\n---",
+			contains: []string{"<blockquote>\n<p>quoted</p>\n</blockquote>", "<hr>"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := string(renderMarkdown(tt.src))
+			for _, want := range tt.contains {
+				if !strings.Contains(got, want) {
+					t.Errorf("renderMarkdown(%q) = %q, want it to contain %q", tt.src, got, want)
+				}
+			}
+			for _, unwanted := range tt.excludes {
+				if strings.Contains(got, unwanted) {
+					t.Errorf("renderMarkdown(%q) = %q, want it not to contain %q", tt.src, got, unwanted)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// The meta endpoint describes what has been logged, so that BI tools and
+// custom dashboards can build query UIs without hardcoding experiment,
+// parameter, metric or tag names.
+
+// MetaExperiment is an experiment as the meta endpoint lists it
+type MetaExperiment struct {
+	UUID     string `json:"uuid"`
+	Name     string `json:"name"`
+	RunCount int    `json:"run_count"`
+}
+
+// MetaKey is a metric or tag key and how many runs logged it
+type MetaKey struct {
+	Key      string `json:"key"`
+	RunCount int    `json:"run_count"`
+}
+
+// MetaParameterType is a type a parameter was logged as and how many runs
+// logged it that way
+type MetaParameterType struct {
+	Type     string `json:"type"`
+	RunCount int    `json:"run_count"`
+}
+
+// MetaParameter is a parameter key and the types it was logged as, most
+// common first
+type MetaParameter struct {
+	Key      string              `json:"key"`
+	RunCount int                 `json:"run_count"`
+	Types    []MetaParameterType `json:"types"`
+}
+
+// Meta is the schema the meta endpoint describes
+type Meta struct {
+	Experiments []MetaExperiment `json:"experiments"`
+	Parameters  []MetaParameter  `json:"parameters"`
+	Metrics     []MetaKey        `json:"metrics"`
+	Tags        []MetaKey        `json:"tags"`
+}
+
+// getMeta describes the experiments of a project, or of every project if
+// projectID is 0, and the keys logged to their runs. experimentID 0 describes
+// every experiment; otherwise only that experiment's keys are described.
+func getMeta(ctx context.Context, experimentID, projectID int) (*Meta, error) {
+	experiments, err := dao.GetAllExperiments(ctx)
+	if err != nil {
+		return nil, err
+	}
+	experiments = experimentsInProject(experiments, projectID)
+	paramKeys, err := dao.GetParameterKeys(ctx, experimentID, projectID)
+	if err != nil {
+		return nil, err
+	}
+	metricKeys, err := dao.GetMetricKeys(ctx, experimentID, projectID)
+	if err != nil {
+		return nil, err
+	}
+	tagKeys, err := dao.GetTagKeys(ctx, experimentID, projectID)
+	if err != nil {
+		return nil, err
+	}
+
+	meta := &Meta{
+		Experiments: make([]MetaExperiment, 0, len(experiments)),
+		Parameters:  []MetaParameter{},
+		Metrics:     metaKeys(metricKeys),
+		Tags:        metaKeys(tagKeys),
+	}
+	for _, e := range experiments {
+		meta.Experiments = append(meta.Experiments, MetaExperiment{UUID: e.UUID, Name: e.Name, RunCount: e.RunCount})
+	}
+	// Parameter keys come back once per type, ordered by key
+	for _, k := range paramKeys {
+		n := len(meta.Parameters)
+		if n == 0 || meta.Parameters[n-1].Key != k.Key {
+			meta.Parameters = append(meta.Parameters, MetaParameter{Key: k.Key})
+			n++
+		}
+		p := &meta.Parameters[n-1]
+		p.RunCount += k.RunCount
+		i := len(p.Types)
+		for i > 0 && p.Types[i-1].RunCount < k.RunCount {
+			i--
+		}
+		p.Types = append(p.Types[:i], append([]MetaParameterType{{Type: k.ValueType, RunCount: k.RunCount}}, p.Types[i:]...)...)
+	}
+	return meta, nil
+}
+
+func metaKeys(rows []SchemaKeyRow) []MetaKey {
+	keys := make([]MetaKey, 0, len(rows))
+	for _, k := range rows {
+		keys = append(keys, MetaKey{Key: k.Key, RunCount: k.RunCount})
+	}
+	return keys
+}
+
+// handleAPIMeta describes the experiments and the parameter, metric and tag
+// keys logged to them, at GET /api/v1/meta. experiment_uuid limits the keys
+// to one experiment's runs, and project to one project's experiments.
+func handleAPIMeta(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	projectID, err := apiProjectID(r)
+	if writeProjectError(w, err) {
+		return
+	}
+	experimentID := 0
+	if experimentUUID := r.URL.Query().Get("experiment_uuid"); experimentUUID != "" {
+		id, err := dao.GetExperimentIDByUUID(ctx, experimentUUID)
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Experiment not found"})
+			return
+		}
+		experimentID = id
+	}
+
+	meta, err := getMeta(ctx, experimentID, projectID)
+	if err != nil {
+		log.Printf("Failed to describe schema: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to describe schema"})
+		return
+	}
+	json.NewEncoder(w).Encode(meta)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// metaDAO knows two experiments' worth of keys
+type metaDAO struct {
+	DAO
+	experimentIDs []int
+}
+
+func (d *metaDAO) GetAllExperiments(ctx context.Context) ([]Experiment, error) {
+	return []Experiment{{UUID: "exp-1", Name: "ablations", RunCount: 3}, {UUID: "exp-2", Name: "sweeps", RunCount: 1}}, nil
+}
+
+func (d *metaDAO) GetExperimentIDByUUID(ctx context.Context, uuid string) (int, error) {
+	if uuid != "exp-1" {
+		return 0, sql.ErrNoRows
+	}
+	return 7, nil
+}
+
+func (d *metaDAO) GetParameterKeys(ctx context.Context, experimentID, projectID int) ([]SchemaKeyRow, error) {
+	d.experimentIDs = append(d.experimentIDs, experimentID)
+	return []SchemaKeyRow{
+		{Key: "batch_size", ValueType: "int", RunCount: 1},
+		{Key: "batch_size", ValueType: "string", RunCount: 2},
+		{Key: "lr", ValueType: "float", RunCount: 3},
+	}, nil
+}
+
+func (d *metaDAO) GetMetricKeys(ctx context.Context, experimentID, projectID int) ([]SchemaKeyRow, error) {
+	return []SchemaKeyRow{{Key: "loss", RunCount: 3}}, nil
+}
+
+func (d *metaDAO) GetTagKeys(ctx context.Context, experimentID, projectID int) ([]SchemaKeyRow, error) {
+	return nil, nil
+}
+
+func TestHandleAPIMeta(t *testing.T) {
+	defer func(d DAO) { dao = d }(dao)
+	d := &metaDAO{}
+	dao = d
+
+	w := httptest.NewRecorder()
+	handleAPIMeta(w, httptest.NewRequest(http.MethodGet, "/api/v1/meta", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body)
+	}
+	var meta Meta
+	if err := json.Unmarshal(w.Body.Bytes(), &meta); err != nil {
+		t.Fatal(err)
+	}
+	if len(meta.Experiments) != 2 || meta.Experiments[0].Name != "ablations" || meta.Experiments[0].RunCount != 3 {
+		t.Errorf("Unexpected experiments %+v", meta.Experiments)
+	}
+	if len(meta.Parameters) != 2 {
+		t.Fatalf("Expected one entry per parameter key, got %+v", meta.Parameters)
+	}
+	batchSize := meta.Parameters[0]
+	if batchSize.Key != "batch_size" || batchSize.RunCount != 3 || len(batchSize.Types) != 2 || batchSize.Types[0].Type != "string" {
+		t.Errorf("Expected batch_size's types most common first, got %+v", batchSize)
+	}
+	if len(meta.Metrics) != 1 || meta.Metrics[0].Key != "loss" {
+		t.Errorf("Unexpected metrics %+v", meta.Metrics)
+	}
+	// No tags is an empty list rather than null
+	if meta.Tags == nil {
+		t.Errorf("Expected an empty list of tags, got %s", w.Body)
+	}
+
+	w = httptest.NewRecorder()
+	handleAPIMeta(w, httptest.NewRequest(http.MethodGet, "/api/v1/meta?experiment_uuid=exp-1", nil))
+	if w.Code != http.StatusOK || d.experimentIDs[len(d.experimentIDs)-1] != 7 {
+		t.Errorf("Expected keys scoped to the experiment, got %d and %v", w.Code, d.experimentIDs)
+	}
+
+	w = httptest.NewRecorder()
+	handleAPIMeta(w, httptest.NewRequest(http.MethodGet, "/api/v1/meta?experiment_uuid=missing", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a missing experiment, got %d", w.Code)
+	}
+}
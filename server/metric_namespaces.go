@@ -0,0 +1,386 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Metric keys are /-separated, and the part of a key before its first slash
+// is the metric's namespace, e.g. train for train/loss and train/layer1/grad.
+// Metric summaries keep keys split into namespace and name, so that the API
+// can list a run's namespaces and then the metrics of one. An experiment may
+// set, per namespace, how many days after a run ends the points of its
+// metrics are kept, by the metric-namespaces housekeeping job, which keeps
+// their summaries, and the values above or below which they alert.
+
+// splitMetricKey splits a metric key at its first slash into its namespace
+// and its name within it, e.g. "train" and "layer1/grad" for
+// train/layer1/grad. Keys without a slash have no namespace. Migration 49
+// splits keys in the database the same way.
+func splitMetricKey(key string) (namespace, name string) {
+	if namespace, name, ok := strings.Cut(key, "/"); ok {
+		return namespace, name
+	}
+	return "", key
+}
+
+// MetricNamespaceSetting is what an experiment sets for a metric namespace,
+// as the API takes and returns it
+type MetricNamespaceSetting struct {
+	Namespace        string   `json:"namespace"`
+	RetainPointsDays *int     `json:"retain_points_days"`
+	AlertAbove       *float64 `json:"alert_above"`
+	AlertBelow       *float64 `json:"alert_below"`
+}
+
+// newMetricNamespaceSetting converts a setting to its API form
+func newMetricNamespaceSetting(row MetricNamespaceSettingRow) MetricNamespaceSetting {
+	setting := MetricNamespaceSetting{Namespace: row.Namespace}
+	if row.RetainPointsDays.Valid {
+		days := int(row.RetainPointsDays.Int64)
+		setting.RetainPointsDays = &days
+	}
+	if row.AlertAbove.Valid {
+		setting.AlertAbove = &row.AlertAbove.Float64
+	}
+	if row.AlertBelow.Valid {
+		setting.AlertBelow = &row.AlertBelow.Float64
+	}
+	return setting
+}
+
+// row checks a setting and converts it for an experiment's metric_namespace_settings.
+// A trailing slash is dropped from the namespace, so that "train/" names train.
+func (s MetricNamespaceSetting) row(experimentID int) (MetricNamespaceSettingRow, error) {
+	row := MetricNamespaceSettingRow{ExperimentID: experimentID, Namespace: strings.TrimSuffix(s.Namespace, "/")}
+	if strings.Contains(row.Namespace, "/") {
+		return row, fmt.Errorf("namespace %q is more than the part of a key before its first slash", s.Namespace)
+	}
+	if s.RetainPointsDays == nil && s.AlertAbove == nil && s.AlertBelow == nil {
+		return row, fmt.Errorf("set at least one of retain_points_days, alert_above and alert_below")
+	}
+	if s.RetainPointsDays != nil {
+		if *s.RetainPointsDays <= 0 {
+			return row, fmt.Errorf("retain_points_days must be positive, got %d", *s.RetainPointsDays)
+		}
+		row.RetainPointsDays = sql.NullInt64{Int64: int64(*s.RetainPointsDays), Valid: true}
+	}
+	if s.AlertAbove != nil {
+		row.AlertAbove = sql.NullFloat64{Float64: *s.AlertAbove, Valid: true}
+	}
+	if s.AlertBelow != nil {
+		row.AlertBelow = sql.NullFloat64{Float64: *s.AlertBelow, Valid: true}
+	}
+	if row.AlertAbove.Valid && row.AlertBelow.Valid && row.AlertBelow.Float64 >= row.AlertAbove.Float64 {
+		return row, fmt.Errorf("alert_below (%g) must be less than alert_above (%g)", row.AlertBelow.Float64, row.AlertAbove.Float64)
+	}
+	return row, nil
+}
+
+// findMetricNamespaceSetting returns what is set for a namespace, or nil
+func findMetricNamespaceSetting(settings []MetricNamespaceSettingRow, namespace string) *MetricNamespaceSettingRow {
+	for i := range settings {
+		if settings[i].Namespace == namespace {
+			return &settings[i]
+		}
+	}
+	return nil
+}
+
+// metricAlert finds the first of a batch of values of a metric that crosses
+// its namespace's alert values, describing it
+func metricAlert(setting MetricNamespaceSettingRow, key string, values []float64) (float64, string, bool) {
+	for _, v := range values {
+		if setting.AlertAbove.Valid && v > setting.AlertAbove.Float64 {
+			return v, fmt.Sprintf("%s reached %g, above %g", key, v, setting.AlertAbove.Float64), true
+		}
+		if setting.AlertBelow.Valid && v < setting.AlertBelow.Float64 {
+			return v, fmt.Sprintf("%s fell to %g, below %g", key, v, setting.AlertBelow.Float64), true
+		}
+	}
+	return 0, "", false
+}
+
+// checkMetricAlerts checks metric values a run just logged against the alert
+// values set for their namespace, and notifies subscribers of the
+// metric_alert event the first time each of the run's metrics crosses them
+func checkMetricAlerts(ctx context.Context, runID int, runUUID, key string, yValues []float64) error {
+	settings, err := dao.GetMetricNamespaceSettingsForRun(ctx, runID)
+	if err != nil {
+		return err
+	}
+	namespace, _ := splitMetricKey(key)
+	setting := findMetricNamespaceSetting(settings, namespace)
+	if setting == nil {
+		return nil
+	}
+	value, detail, ok := metricAlert(*setting, key, yValues)
+	if !ok {
+		return nil
+	}
+	first, err := dao.RecordMetricAlert(ctx, runID, key, value, time.Now())
+	if err != nil {
+		return err
+	}
+	if first {
+		notifyRunEventDetail(ctx, notificationEventMetricAlert, runUUID, detail)
+	}
+	return nil
+}
+
+// metricPointsItemKey is the key of the housekeeping item of a run's points
+// in a namespace, e.g. "{uuid}/train"
+func metricPointsItemKey(runUUID, namespace string) string {
+	return runUUID + "/" + namespace
+}
+
+// planMetricNamespacePruning lists, for each namespace an experiment keeps
+// points of for retain_points_days, the runs that ended longer ago than
+// that and still have points of its metrics. Runs on hold are kept.
+func planMetricNamespacePruning(ctx context.Context) (items, skipped []HousekeepingItem, err error) {
+	settings, err := dao.GetMetricNamespaceSettings(ctx, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	now := time.Now()
+	for _, setting := range settings {
+		if !setting.RetainPointsDays.Valid {
+			continue
+		}
+		runs, err := dao.GetNamespaceMetricPoints(ctx, setting.ExperimentID, setting.Namespace, now.AddDate(0, 0, -int(setting.RetainPointsDays.Int64)))
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, run := range runs {
+			item := HousekeepingItem{Kind: "metric_points", Key: metricPointsItemKey(run.RunUUID, setting.Namespace), RunUUID: run.RunUUID}
+			if err := ensureRunNotOnHold(ctx, run.RunID); errors.Is(err, errRunOnHold) {
+				item.Reason = "run is on hold"
+				skipped = append(skipped, item)
+				continue
+			} else if err != nil {
+				return nil, nil, err
+			}
+			items = append(items, item)
+		}
+	}
+	return items, skipped, nil
+}
+
+// pruneNamespaceMetrics deletes the points of a run's metrics in a
+// namespace, listed by planMetricNamespacePruning. A run since deleted is
+// done.
+func pruneNamespaceMetrics(ctx context.Context, item HousekeepingItem) error {
+	runID, err := dao.GetRunIDByUUID(ctx, item.RunUUID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	namespace := strings.TrimPrefix(item.Key, item.RunUUID+"/")
+	_, err = dao.DeleteNamespaceMetrics(ctx, runID, namespace)
+	return err
+}
+
+// RunMetricNamespace is a namespace of a run's metrics as the API lists it,
+// with what the run's experiment sets for it, if anything
+type RunMetricNamespace struct {
+	Namespace string                  `json:"namespace"`
+	Metrics   int                     `json:"metrics"`
+	Points    int64                   `json:"points"`
+	Setting   *MetricNamespaceSetting `json:"setting,omitempty"`
+}
+
+// handleAPIRunMetricNamespaces lists the namespaces of a run's metrics at
+// GET /api/runs/{uuid}/metrics/namespaces, each collapsed to how many
+// metrics and points it has. Metrics without a namespace are listed under "".
+func handleAPIRunMetricNamespaces(w http.ResponseWriter, r *http.Request, runUUID string) {
+	ctx := r.Context()
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	runID, err := dao.GetRunIDByUUID(ctx, runUUID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Run not found"})
+		return
+	}
+	rows, err := dao.GetRunMetricNamespaces(ctx, runID)
+	if err != nil {
+		log.Printf("Failed to list metric namespaces of run %s: %v", runUUID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to list metric namespaces"})
+		return
+	}
+	settings, err := dao.GetMetricNamespaceSettingsForRun(ctx, runID)
+	if err != nil {
+		log.Printf("Failed to load metric namespace settings of run %s: %v", runUUID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to list metric namespaces"})
+		return
+	}
+	namespaces := make([]RunMetricNamespace, len(rows))
+	for i, row := range rows {
+		namespaces[i] = RunMetricNamespace{Namespace: row.Namespace, Metrics: row.Metrics, Points: row.Points}
+		if setting := findMetricNamespaceSetting(settings, row.Namespace); setting != nil {
+			s := newMetricNamespaceSetting(*setting)
+			namespaces[i].Setting = &s
+		}
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"namespaces": namespaces})
+}
+
+// RunMetricSummary is a metric of a run as GET
+// /api/runs/{uuid}/metrics/summaries lists it
+type RunMetricSummary struct {
+	Key       string  `json:"key"`
+	Namespace string  `json:"namespace"`
+	Name      string  `json:"name"`
+	LastStep  float64 `json:"last_step"`
+	LastValue float64 `json:"last_value"`
+	MinValue  float64 `json:"min_value"`
+	MaxValue  float64 `json:"max_value"`
+	Points    int     `json:"points"`
+}
+
+// handleAPIRunMetricSummaries lists a run's metrics with their last, lowest
+// and highest values at GET /api/runs/{uuid}/metrics/summaries, expanding
+// one namespace with ?namespace=train, or the metrics without one with
+// ?namespace=
+func handleAPIRunMetricSummaries(w http.ResponseWriter, r *http.Request, runUUID string) {
+	ctx := r.Context()
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	runID, err := dao.GetRunIDByUUID(ctx, runUUID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Run not found"})
+		return
+	}
+	rows, err := dao.GetMetricSummariesByRunIDs(ctx, []int{runID})
+	if err != nil {
+		log.Printf("Failed to query metric summaries of run %s: %v", runUUID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to query metrics"})
+		return
+	}
+	query := r.URL.Query()
+	namespace := strings.TrimSuffix(query.Get("namespace"), "/")
+	metrics := []RunMetricSummary{}
+	for _, row := range rows {
+		if query.Has("namespace") && row.Namespace != namespace {
+			continue
+		}
+		metrics = append(metrics, RunMetricSummary{
+			Key:       row.Key,
+			Namespace: row.Namespace,
+			Name:      row.Name,
+			LastStep:  row.LastStep,
+			LastValue: row.LastValue,
+			MinValue:  row.MinValue,
+			MaxValue:  row.MaxValue,
+			Points:    row.PointCount,
+		})
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"metrics": metrics})
+}
+
+// MetricNamespaceSettingRequest is the body of POST
+// /api/experiments/metric-namespaces
+type MetricNamespaceSettingRequest struct {
+	ExperimentUUID string `json:"experiment_uuid"`
+	MetricNamespaceSetting
+}
+
+// handleAPIMetricNamespaceSettings lists what an experiment sets for its
+// metric namespaces (GET ?experiment_uuid=), sets it for one (POST with
+// {"experiment_uuid", "namespace", "retain_points_days", "alert_above",
+// "alert_below"}), replacing what was set before, or removes it (DELETE
+// ?experiment_uuid=&namespace=), at /api/experiments/metric-namespaces
+func handleAPIMetricNamespaceSettings(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	w.Header().Set("Content-Type", "application/json")
+
+	var req MetricNamespaceSettingRequest
+	switch r.Method {
+	case http.MethodGet, http.MethodDelete:
+		req.ExperimentUUID = r.URL.Query().Get("experiment_uuid")
+		req.Namespace = r.URL.Query().Get("namespace")
+	case http.MethodPost:
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Invalid JSON"})
+			return
+		}
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if req.ExperimentUUID == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Missing required field: experiment_uuid"})
+		return
+	}
+	experimentID, err := dao.GetExperimentIDByUUID(ctx, req.ExperimentUUID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Experiment not found"})
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		rows, err := dao.GetMetricNamespaceSettings(ctx, experimentID)
+		if err != nil {
+			log.Printf("Failed to load metric namespace settings of experiment %s: %v", req.ExperimentUUID, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to load metric namespace settings"})
+			return
+		}
+		settings := make([]MetricNamespaceSetting, len(rows))
+		for i, row := range rows {
+			settings[i] = newMetricNamespaceSetting(row)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"namespaces": settings})
+	case http.MethodDelete:
+		deleted, err := dao.DeleteMetricNamespaceSetting(ctx, experimentID, strings.TrimSuffix(req.Namespace, "/"))
+		if err != nil {
+			log.Printf("Error deleting metric namespace setting: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to delete metric namespace setting"})
+			return
+		}
+		if !deleted {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Nothing is set for that namespace"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+	case http.MethodPost:
+		row, err := req.MetricNamespaceSetting.row(experimentID)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": fmt.Sprintf("Invalid metric namespace setting: %v", err)})
+			return
+		}
+		if err := dao.UpsertMetricNamespaceSetting(ctx, row); err != nil {
+			log.Printf("Error saving metric namespace setting: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to save metric namespace setting"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"status": "ok", "setting": newMetricNamespaceSetting(row)})
+	}
+}
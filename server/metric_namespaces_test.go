@@ -0,0 +1,287 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// metricNamespacesDAO has run-1, logging train/ and val/ metrics and lr, and
+// run-2, on hold, in experiment 1, which keeps train points for 7 days and
+// alerts on train metrics above 10
+type metricNamespacesDAO struct {
+	DAO
+	settings []MetricNamespaceSettingRow
+	alerts   map[string]float64
+	pruned   []string
+}
+
+func newMetricNamespacesDAO() *metricNamespacesDAO {
+	return &metricNamespacesDAO{
+		settings: []MetricNamespaceSettingRow{{
+			ExperimentID:     1,
+			Namespace:        "train",
+			RetainPointsDays: sql.NullInt64{Int64: 7, Valid: true},
+			AlertAbove:       sql.NullFloat64{Float64: 10, Valid: true},
+		}},
+		alerts: map[string]float64{},
+	}
+}
+
+func (d *metricNamespacesDAO) GetRunIDByUUID(ctx context.Context, uuid string) (int, error) {
+	switch uuid {
+	case "run-1":
+		return 1, nil
+	case "run-2":
+		return 2, nil
+	}
+	return 0, sql.ErrNoRows
+}
+
+func (d *metricNamespacesDAO) GetRunHold(ctx context.Context, runID int) (*RunHoldRow, error) {
+	if runID == 2 {
+		return &RunHoldRow{Reason: "paper", HeldAt: time.Now()}, nil
+	}
+	return nil, nil
+}
+
+func (d *metricNamespacesDAO) GetRunMetricNamespaces(ctx context.Context, runID int) ([]MetricNamespaceRow, error) {
+	return []MetricNamespaceRow{{Namespace: "", Metrics: 1, Points: 10}, {Namespace: "train", Metrics: 2, Points: 200}, {Namespace: "val", Metrics: 1, Points: 5}}, nil
+}
+
+func (d *metricNamespacesDAO) GetMetricSummariesByRunIDs(ctx context.Context, runIDs []int) ([]MetricSummaryRow, error) {
+	return []MetricSummaryRow{
+		{RunID: 1, Key: "lr", Name: "lr", LastValue: 0.001, PointCount: 10},
+		{RunID: 1, Key: "train/layer1/grad", Namespace: "train", Name: "layer1/grad", LastValue: 3, PointCount: 100},
+		{RunID: 1, Key: "train/loss", Namespace: "train", Name: "loss", LastValue: 0.4, MinValue: 0.3, MaxValue: 2, PointCount: 100},
+		{RunID: 1, Key: "val/loss", Namespace: "val", Name: "loss", LastValue: 0.5, PointCount: 5},
+	}, nil
+}
+
+func (d *metricNamespacesDAO) GetMetricNamespaceSettings(ctx context.Context, experimentID int) ([]MetricNamespaceSettingRow, error) {
+	return d.settings, nil
+}
+
+func (d *metricNamespacesDAO) GetMetricNamespaceSettingsForRun(ctx context.Context, runID int) ([]MetricNamespaceSettingRow, error) {
+	return d.settings, nil
+}
+
+func (d *metricNamespacesDAO) GetNamespaceMetricPoints(ctx context.Context, experimentID int, namespace string, endedBefore time.Time) ([]RunMetricPointsRow, error) {
+	return []RunMetricPointsRow{{RunID: 1, RunUUID: "run-1", Points: 200}, {RunID: 2, RunUUID: "run-2", Points: 50}}, nil
+}
+
+func (d *metricNamespacesDAO) DeleteNamespaceMetrics(ctx context.Context, runID int, namespace string) (int64, error) {
+	d.pruned = append(d.pruned, namespace)
+	return 200, nil
+}
+
+func (d *metricNamespacesDAO) RecordMetricAlert(ctx context.Context, runID int, key string, value float64, at time.Time) (bool, error) {
+	if _, ok := d.alerts[key]; ok {
+		return false, nil
+	}
+	d.alerts[key] = value
+	return true, nil
+}
+
+func (d *metricNamespacesDAO) GetExperimentIDByUUID(ctx context.Context, uuid string) (int, error) {
+	if uuid == "exp-1" {
+		return 1, nil
+	}
+	return 0, sql.ErrNoRows
+}
+
+func (d *metricNamespacesDAO) UpsertMetricNamespaceSetting(ctx context.Context, setting MetricNamespaceSettingRow) error {
+	d.settings = append(d.settings, setting)
+	return nil
+}
+
+func (d *metricNamespacesDAO) DeleteMetricNamespaceSetting(ctx context.Context, experimentID int, namespace string) (bool, error) {
+	n := len(d.settings)
+	d.settings = slices.DeleteFunc(d.settings, func(s MetricNamespaceSettingRow) bool { return s.Namespace == namespace })
+	return len(d.settings) < n, nil
+}
+
+// The notification of an alert finds no run, and is not delivered
+func (d *metricNamespacesDAO) GetRunByUUID(ctx context.Context, uuid string) (*Run, error) {
+	return nil, errors.New("not found")
+}
+
+func TestSplitMetricKey(t *testing.T) {
+	for key, want := range map[string][2]string{
+		"train/loss":        {"train", "loss"},
+		"train/layer1/grad": {"train", "layer1/grad"},
+		"lr":                {"", "lr"},
+		"/loss":             {"", "loss"},
+		"train/":            {"train", ""},
+	} {
+		if namespace, name := splitMetricKey(key); namespace != want[0] || name != want[1] {
+			t.Errorf("Expected %q split into %q and %q, got %q and %q", key, want[0], want[1], namespace, name)
+		}
+	}
+}
+
+func TestMetricNamespaceSettingRow(t *testing.T) {
+	days, above, below := 7, 10.0, -1.0
+	row, err := MetricNamespaceSetting{Namespace: "train/", RetainPointsDays: &days, AlertAbove: &above, AlertBelow: &below}.row(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := MetricNamespaceSettingRow{
+		ExperimentID:     3,
+		Namespace:        "train",
+		RetainPointsDays: sql.NullInt64{Int64: 7, Valid: true},
+		AlertAbove:       sql.NullFloat64{Float64: 10, Valid: true},
+		AlertBelow:       sql.NullFloat64{Float64: -1, Valid: true},
+	}
+	if row != want {
+		t.Errorf("Expected %+v, got %+v", want, row)
+	}
+
+	zero := 0
+	for name, setting := range map[string]MetricNamespaceSetting{
+		"with nothing set":          {Namespace: "train"},
+		"of a nested namespace":     {Namespace: "train/layer1", AlertAbove: &above},
+		"keeping points for 0 days": {Namespace: "train", RetainPointsDays: &zero},
+		"alerting below above":      {Namespace: "train", AlertAbove: &below, AlertBelow: &above},
+	} {
+		if _, err := setting.row(3); err == nil {
+			t.Errorf("Expected a setting %s rejected", name)
+		}
+	}
+}
+
+func TestCheckMetricAlerts(t *testing.T) {
+	defer func(d DAO) { dao = d }(dao)
+	fake := newMetricNamespacesDAO()
+	dao = fake
+
+	for _, logged := range []struct {
+		key    string
+		values []float64
+	}{
+		{"val/loss", []float64{50}},
+		{"train/loss", []float64{2, 3}},
+		{"train/loss", []float64{9, 12, 15}},
+		{"train/loss", []float64{20}},
+		{"train/grad", []float64{11}},
+	} {
+		if err := checkMetricAlerts(t.Context(), 1, "run-1", logged.key, logged.values); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(fake.alerts) != 2 || fake.alerts["train/loss"] != 12 || fake.alerts["train/grad"] != 11 {
+		t.Errorf("Expected the train metrics alerted on once each, at the first value above 10, got %v", fake.alerts)
+	}
+
+	setting := MetricNamespaceSettingRow{AlertBelow: sql.NullFloat64{Float64: 0.5, Valid: true}}
+	if _, detail, ok := metricAlert(setting, "lr", []float64{1, 0.1}); !ok || detail != "lr fell to 0.1, below 0.5" {
+		t.Errorf("Expected an alert below 0.5, got %q", detail)
+	}
+}
+
+func TestPlanMetricNamespacePruning(t *testing.T) {
+	defer func(d DAO) { dao = d }(dao)
+	fake := newMetricNamespacesDAO()
+	fake.settings = append(fake.settings, MetricNamespaceSettingRow{ExperimentID: 1, Namespace: "val", AlertAbove: sql.NullFloat64{Float64: 1, Valid: true}})
+	dao = fake
+
+	items, skipped, err := planMetricNamespacePruning(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 || items[0].Key != "run-1/train" || items[0].RunUUID != "run-1" {
+		t.Errorf("Expected run-1's train points planned, got %+v", items)
+	}
+	if len(skipped) != 1 || skipped[0].RunUUID != "run-2" || skipped[0].Reason != "run is on hold" {
+		t.Errorf("Expected run-2 on hold skipped, got %+v", skipped)
+	}
+
+	if err := pruneNamespaceMetrics(t.Context(), items[0]); err != nil || len(fake.pruned) != 1 || fake.pruned[0] != "train" {
+		t.Errorf("Expected run-1's train points deleted, got %v (err %v)", fake.pruned, err)
+	}
+	if err := pruneNamespaceMetrics(t.Context(), HousekeepingItem{Key: "gone/train", RunUUID: "gone"}); err != nil {
+		t.Errorf("Expected a deleted run done, got %v", err)
+	}
+}
+
+func TestHandleAPIRunMetricNamespaces(t *testing.T) {
+	defer func(d DAO) { dao = d }(dao)
+	dao = newMetricNamespacesDAO()
+
+	get := func(path string) (int, map[string][]map[string]interface{}) {
+		w := httptest.NewRecorder()
+		handleAPIV1Runs(w, httptest.NewRequest(http.MethodGet, path, nil))
+		var resp map[string][]map[string]interface{}
+		json.NewDecoder(w.Body).Decode(&resp)
+		return w.Code, resp
+	}
+
+	code, resp := get("/api/v1/runs/run-1/metrics/namespaces")
+	if code != http.StatusOK || len(resp["namespaces"]) != 3 {
+		t.Fatalf("Expected the run's three namespaces, got %d %v", code, resp)
+	}
+	train := resp["namespaces"][1]
+	setting, _ := train["setting"].(map[string]interface{})
+	if train["namespace"] != "train" || train["metrics"] != 2.0 || train["points"] != 200.0 || setting["retain_points_days"] != 7.0 || setting["alert_below"] != nil {
+		t.Errorf("Expected the train namespace with its setting, got %v", train)
+	}
+	if _, ok := resp["namespaces"][0]["setting"]; ok {
+		t.Errorf("Expected no setting for metrics without a namespace, got %v", resp["namespaces"][0])
+	}
+
+	_, resp = get("/api/v1/runs/run-1/metrics/summaries?namespace=train/")
+	if metrics := resp["metrics"]; len(metrics) != 2 || metrics[1]["name"] != "loss" || metrics[1]["max_value"] != 2.0 {
+		t.Errorf("Expected the train metrics, got %v", metrics)
+	}
+	if _, resp = get("/api/v1/runs/run-1/metrics/summaries?namespace="); len(resp["metrics"]) != 1 || resp["metrics"][0]["key"] != "lr" {
+		t.Errorf("Expected the metrics without a namespace, got %v", resp["metrics"])
+	}
+	if _, resp = get("/api/v1/runs/run-1/metrics/summaries"); len(resp["metrics"]) != 4 {
+		t.Errorf("Expected every metric, got %v", resp["metrics"])
+	}
+	if code, _ := get("/api/v1/runs/missing/metrics/namespaces"); code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a missing run, got %d", code)
+	}
+}
+
+func TestHandleAPIMetricNamespaceSettings(t *testing.T) {
+	defer func(d DAO) { dao = d }(dao)
+	fake := newMetricNamespacesDAO()
+	fake.settings = nil
+	dao = fake
+
+	do := func(method, target, body string) (int, string) {
+		w := httptest.NewRecorder()
+		handleAPIMetricNamespaceSettings(w, httptest.NewRequest(method, target, strings.NewReader(body)))
+		return w.Code, w.Body.String()
+	}
+
+	if code, body := do(http.MethodPost, "/api/experiments/metric-namespaces", `{"experiment_uuid": "exp-1", "namespace": "debug/", "retain_points_days": 3}`); code != http.StatusOK {
+		t.Fatalf("Expected the setting saved, got %d %s", code, body)
+	}
+	if len(fake.settings) != 1 || fake.settings[0].Namespace != "debug" || fake.settings[0].RetainPointsDays.Int64 != 3 || fake.settings[0].AlertAbove.Valid {
+		t.Errorf("Expected debug points kept for 3 days, got %+v", fake.settings)
+	}
+	if code, body := do(http.MethodGet, "/api/experiments/metric-namespaces?experiment_uuid=exp-1", ""); code != http.StatusOK || !strings.Contains(body, `{"namespace":"debug","retain_points_days":3,"alert_above":null,"alert_below":null}`) {
+		t.Errorf("Expected the setting listed, got %d %s", code, body)
+	}
+	if code, body := do(http.MethodPost, "/api/experiments/metric-namespaces", `{"experiment_uuid": "exp-1", "namespace": "debug", "retain_points_days": -1}`); code != http.StatusBadRequest || !strings.Contains(body, "retain_points_days must be positive") {
+		t.Errorf("Expected 400 for a negative retention, got %d %s", code, body)
+	}
+	if code, _ := do(http.MethodDelete, "/api/experiments/metric-namespaces?experiment_uuid=exp-1&namespace=debug", ""); code != http.StatusOK || len(fake.settings) != 0 {
+		t.Errorf("Expected the setting deleted, got %d and %+v", code, fake.settings)
+	}
+	if code, _ := do(http.MethodDelete, "/api/experiments/metric-namespaces?experiment_uuid=exp-1&namespace=debug", ""); code != http.StatusNotFound {
+		t.Errorf("Expected 404 deleting a setting that is not there, got %d", code)
+	}
+	if code, _ := do(http.MethodGet, "/api/experiments/metric-namespaces?experiment_uuid=missing", ""); code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a missing experiment, got %d", code)
+	}
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Kinds of metric schema warnings
+const (
+	metricSchemaUnknownKey     = "unknown_key"
+	metricSchemaStepOutOfRange = "step_out_of_range"
+)
+
+// metricKeySuggestionDistance is the largest edit distance at which a declared
+// key is suggested in place of an unknown one
+const metricKeySuggestionDistance = 2
+
+// validateMetricSchemaEntry checks that an entry of a metric schema has a key,
+// which parses as a pattern, and that its step range is not empty
+func validateMetricSchemaEntry(entry MetricSchemaEntryRow) error {
+	if entry.Key == "" {
+		return fmt.Errorf("metric key is required")
+	}
+	if _, err := path.Match(entry.Key, ""); err != nil {
+		return fmt.Errorf("invalid key pattern %q", entry.Key)
+	}
+	if entry.MinStep.Valid && entry.MaxStep.Valid && entry.MinStep.Float64 > entry.MaxStep.Float64 {
+		return fmt.Errorf("min step must not exceed max step")
+	}
+	return nil
+}
+
+// isMetricKeyPattern reports whether a schema key matches keys other than itself
+func isMetricKeyPattern(key string) bool {
+	return strings.ContainsAny(key, `*?[\`)
+}
+
+// StepRange describes the steps an entry may be logged at
+func (e MetricSchemaEntryRow) StepRange() string {
+	switch {
+	case e.MinStep.Valid && e.MaxStep.Valid:
+		return fmt.Sprintf("%g to %g", e.MinStep.Float64, e.MaxStep.Float64)
+	case e.MinStep.Valid:
+		return fmt.Sprintf("≥ %g", e.MinStep.Float64)
+	case e.MaxStep.Valid:
+		return fmt.Sprintf("≤ %g", e.MaxStep.Float64)
+	}
+	return "any"
+}
+
+// matchMetricSchema returns the entry of a schema a metric key falls under,
+// preferring an exact key to a pattern, or nil if the key is not declared
+func matchMetricSchema(schema []MetricSchemaEntryRow, key string) *MetricSchemaEntryRow {
+	var match *MetricSchemaEntryRow
+	for i, e := range schema {
+		if e.Key == key {
+			return &schema[i]
+		}
+		if ok, _ := path.Match(e.Key, key); ok && match == nil {
+			match = &schema[i]
+		}
+	}
+	return match
+}
+
+// MetricSchemaViolation is a problem with metric points about to be recorded
+// as a warning
+type MetricSchemaViolation struct {
+	Kind   string
+	Detail string
+}
+
+// checkMetricSchema checks a batch of points of a metric against an
+// experiment's schema. An empty schema accepts everything.
+func checkMetricSchema(schema []MetricSchemaEntryRow, key string, xValues []float64) []MetricSchemaViolation {
+	if len(schema) == 0 {
+		return nil
+	}
+	entry := matchMetricSchema(schema, key)
+	if entry == nil {
+		return []MetricSchemaViolation{{Kind: metricSchemaUnknownKey}}
+	}
+
+	outside, lowest, highest := 0, math.Inf(1), math.Inf(-1)
+	for _, x := range xValues {
+		if (entry.MinStep.Valid && x < entry.MinStep.Float64) || (entry.MaxStep.Valid && x > entry.MaxStep.Float64) {
+			outside++
+			lowest, highest = math.Min(lowest, x), math.Max(highest, x)
+		}
+	}
+	if outside == 0 {
+		return nil
+	}
+	steps := fmt.Sprintf("step %g", lowest)
+	if outside > 1 {
+		steps = fmt.Sprintf("%d steps from %g to %g", outside, lowest, highest)
+	}
+	return []MetricSchemaViolation{{
+		Kind:   metricSchemaStepOutOfRange,
+		Detail: fmt.Sprintf("%s outside %s", steps, entry.StepRange()),
+	}}
+}
+
+// recordMetricSchemaWarnings checks metric points a run just logged against
+// its experiment's schema, records any warnings for the data quality panel,
+// and returns them as messages for the client
+func recordMetricSchemaWarnings(ctx context.Context, runID int, key string, xValues []float64) ([]string, error) {
+	schema, err := dao.GetMetricSchemaForRun(ctx, runID)
+	if err != nil {
+		return nil, err
+	}
+	var messages []string
+	for _, v := range checkMetricSchema(schema, key, xValues) {
+		if err := dao.RecordMetricSchemaWarning(ctx, runID, key, v.Kind, v.Detail); err != nil {
+			return nil, err
+		}
+		messages = append(messages, MetricSchemaWarning{
+			MetricSchemaWarningRow: MetricSchemaWarningRow{Key: key, Kind: v.Kind, Detail: v.Detail},
+			Suggestion:             suggestMetricKey(schema, key),
+		}.Message())
+	}
+	return messages, nil
+}
+
+// MetricSchemaWarning is a metric schema warning shown on the data quality
+// panel, with the declared key the metric was probably meant to be
+type MetricSchemaWarning struct {
+	MetricSchemaWarningRow
+	Suggestion string
+}
+
+// Problem describes the warning without its key
+func (w MetricSchemaWarning) Problem() string {
+	switch w.Kind {
+	case metricSchemaUnknownKey:
+		return "not in the metric schema"
+	case metricSchemaStepOutOfRange:
+		return w.Detail
+	}
+	return w.Kind
+}
+
+// Message describes the warning in full
+func (w MetricSchemaWarning) Message() string {
+	message := fmt.Sprintf("metric %q: %s", w.Key, w.Problem())
+	if w.Suggestion != "" {
+		message += fmt.Sprintf(" (did you mean %q?)", w.Suggestion)
+	}
+	return message
+}
+
+// suggestMetricKey returns the declared key closest to an unknown key, if one
+// is close enough to be a typo of it
+func suggestMetricKey(schema []MetricSchemaEntryRow, key string) string {
+	if matchMetricSchema(schema, key) != nil {
+		return ""
+	}
+	best, bestDistance := "", metricKeySuggestionDistance+1
+	for _, e := range schema {
+		if isMetricKeyPattern(e.Key) {
+			continue
+		}
+		if d := editDistance(e.Key, key); d < bestDistance && d < len(e.Key) {
+			best, bestDistance = e.Key, d
+		}
+	}
+	return best
+}
+
+// editDistance is the Levenshtein distance between two strings
+func editDistance(a, b string) int {
+	s, t := []rune(a), []rune(b)
+	prev := make([]int, len(t)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(s); i++ {
+		cur := make([]int, len(t)+1)
+		cur[0] = i
+		for j := 1; j <= len(t); j++ {
+			cost := 1
+			if s[i-1] == t[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(t)]
+}
+
+// getMetricSchemaWarnings lists the metric schema warnings of an
+// experiment's runs with suggestions for unknown keys
+func getMetricSchemaWarnings(ctx context.Context, experimentID int, schema []MetricSchemaEntryRow) ([]MetricSchemaWarning, error) {
+	rows, err := dao.GetMetricSchemaWarnings(ctx, experimentID)
+	if err != nil {
+		return nil, err
+	}
+	warnings := make([]MetricSchemaWarning, len(rows))
+	for i, row := range rows {
+		warnings[i] = MetricSchemaWarning{MetricSchemaWarningRow: row}
+		if row.Kind == metricSchemaUnknownKey {
+			warnings[i].Suggestion = suggestMetricKey(schema, row.Key)
+		}
+	}
+	return warnings, nil
+}
+
+// parseOptionalStep parses a step bound from a form, where blank means unbounded
+func parseOptionalStep(s string) (sql.NullFloat64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return sql.NullFloat64{}, nil
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return sql.NullFloat64{}, fmt.Errorf("steps must be numbers")
+	}
+	return sql.NullFloat64{Float64: f, Valid: true}, nil
+}
+
+// dataQualityTemplates are the templates of the data quality panel of the experiment page
+var dataQualityTemplates = registerTemplates("templates/experiment_data_quality.html")
+
+// handleExperimentMetricSchema adds (POST) or removes (POST delete/{key}) an
+// entry of an experiment's metric schema, or dismisses its warnings (POST
+// warnings/clear), and returns the data quality panel
+func handleExperimentMetricSchema(w http.ResponseWriter, r *http.Request, experimentUUID, action string) {
+	ctx := r.Context()
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	experimentID, err := dao.GetExperimentIDByUUID(ctx, experimentUUID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "Experiment not found")
+		return
+	}
+
+	var formError string
+	if key, ok := strings.CutPrefix(action, "delete/"); ok {
+		if err := dao.DeleteMetricSchemaEntry(ctx, experimentID, key); err != nil {
+			log.Printf("Failed to delete metric schema key %q: %v", key, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	} else if action == "warnings/clear" {
+		if err := dao.DeleteMetricSchemaWarnings(ctx, experimentID); err != nil {
+			log.Printf("Failed to clear metric schema warnings for experiment %s: %v", experimentUUID, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	} else if action == "" {
+		entry := MetricSchemaEntryRow{Key: strings.TrimSpace(r.FormValue("key"))}
+		minStep, minErr := parseOptionalStep(r.FormValue("min_step"))
+		maxStep, maxErr := parseOptionalStep(r.FormValue("max_step"))
+		entry.MinStep, entry.MaxStep = minStep, maxStep
+		if minErr != nil {
+			formError = minErr.Error()
+		} else if maxErr != nil {
+			formError = maxErr.Error()
+		} else if err := validateMetricSchemaEntry(entry); err != nil {
+			formError = err.Error()
+		} else if err := dao.UpsertMetricSchemaEntry(ctx, experimentID, entry); err != nil {
+			log.Printf("Failed to save metric schema key %q: %v", entry.Key, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	} else {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	schema, err := dao.GetMetricSchema(ctx, experimentID)
+	if err != nil {
+		log.Printf("Failed to load metric schema for experiment %s: %v", experimentUUID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	warnings, err := getMetricSchemaWarnings(ctx, experimentID, schema)
+	if err != nil {
+		log.Printf("Failed to load metric schema warnings for experiment %s: %v", experimentUUID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	data := struct {
+		ExperimentUUID string
+		MetricSchema   []MetricSchemaEntryRow
+		SchemaWarnings []MetricSchemaWarning
+		SchemaError    string
+	}{
+		ExperimentUUID: experimentUUID,
+		MetricSchema:   schema,
+		SchemaWarnings: warnings,
+		SchemaError:    formError,
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	tmpl, err := dataQualityTemplates.Get()
+	if err != nil {
+		log.Printf("Failed to parse template: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if err := executeTemplate(w, tmpl, "experiment_data_quality", data); err != nil {
+		log.Printf("Failed to render template: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+	"testing"
+)
+
+func step(f float64) sql.NullFloat64 {
+	return sql.NullFloat64{Float64: f, Valid: true}
+}
+
+func TestCheckMetricSchema(t *testing.T) {
+	schema := []MetricSchemaEntryRow{
+		{Key: "train/*", MinStep: step(0)},
+		{Key: "train/lr"},
+		{Key: "val_accuracy", MinStep: step(0), MaxStep: step(1000)},
+	}
+	for _, tt := range []struct {
+		key     string
+		xValues []float64
+		want    []MetricSchemaViolation
+	}{
+		{"val_accuracy", []float64{0, 500, 1000}, nil},
+		{"val_acuracy", []float64{1}, []MetricSchemaViolation{{Kind: metricSchemaUnknownKey}}},
+		{"val_accuracy", []float64{999, 1200}, []MetricSchemaViolation{{Kind: metricSchemaStepOutOfRange, Detail: "step 1200 outside 0 to 1000"}}},
+		{"val_accuracy", []float64{-1, 5, 2000}, []MetricSchemaViolation{{Kind: metricSchemaStepOutOfRange, Detail: "2 steps from -1 to 2000 outside 0 to 1000"}}},
+		// Keys match patterns, but an exact key takes precedence
+		{"train/loss", []float64{-5}, []MetricSchemaViolation{{Kind: metricSchemaStepOutOfRange, Detail: "step -5 outside ≥ 0"}}},
+		{"train/lr", []float64{-5}, nil},
+		{"train/sub/loss", []float64{1}, []MetricSchemaViolation{{Kind: metricSchemaUnknownKey}}},
+	} {
+		if got := checkMetricSchema(schema, tt.key, tt.xValues); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("checkMetricSchema(%q, %v) = %+v, want %+v", tt.key, tt.xValues, got, tt.want)
+		}
+	}
+
+	// Experiments without a schema are not checked
+	if got := checkMetricSchema(nil, "anything", []float64{1}); got != nil {
+		t.Errorf("Expected no violations without a schema, got %+v", got)
+	}
+}
+
+func TestValidateMetricSchemaEntry(t *testing.T) {
+	for _, tt := range []struct {
+		entry   MetricSchemaEntryRow
+		wantErr bool
+	}{
+		{MetricSchemaEntryRow{Key: "loss"}, false},
+		{MetricSchemaEntryRow{Key: "loss", MinStep: step(5), MaxStep: step(5)}, false},
+		{MetricSchemaEntryRow{Key: ""}, true},
+		{MetricSchemaEntryRow{Key: "train/[a-"}, true},
+		{MetricSchemaEntryRow{Key: "loss", MinStep: step(10), MaxStep: step(1)}, true},
+	} {
+		if err := validateMetricSchemaEntry(tt.entry); (err != nil) != tt.wantErr {
+			t.Errorf("validateMetricSchemaEntry(%+v) error = %v, wantErr %v", tt.entry, err, tt.wantErr)
+		}
+	}
+}
+
+func TestSuggestMetricKey(t *testing.T) {
+	schema := []MetricSchemaEntryRow{{Key: "val_accuracy"}, {Key: "val_loss"}, {Key: "lr"}, {Key: "train_*"}}
+	for key, want := range map[string]string{
+		"val_acuracy":  "val_accuracy",
+		"val_los":      "val_loss",
+		"val_accuracy": "",
+		"test_loss":    "",
+		"xy":           "",
+		"train_loss":   "",
+	} {
+		if got := suggestMetricKey(schema, key); got != want {
+			t.Errorf("suggestMetricKey(%q) = %q, want %q", key, got, want)
+		}
+	}
+}
+
+// metricSchemaDAO serves one run's metric schema and records its warnings
+type metricSchemaDAO struct {
+	DAO
+	schema   []MetricSchemaEntryRow
+	warnings []MetricSchemaWarningRow
+}
+
+func (d *metricSchemaDAO) GetMetricSchemaForRun(ctx context.Context, runID int) ([]MetricSchemaEntryRow, error) {
+	return d.schema, nil
+}
+
+func (d *metricSchemaDAO) RecordMetricSchemaWarning(ctx context.Context, runID int, key, kind, detail string) error {
+	d.warnings = append(d.warnings, MetricSchemaWarningRow{Key: key, Kind: kind, Detail: detail})
+	return nil
+}
+
+func TestRecordMetricSchemaWarnings(t *testing.T) {
+	ctx := t.Context()
+	defer func(d DAO) { dao = d }(dao)
+	fake := &metricSchemaDAO{schema: []MetricSchemaEntryRow{{Key: "val_accuracy"}}}
+	dao = fake
+
+	messages, err := recordMetricSchemaWarnings(ctx, 1, "val_acuracy", []float64{1, 2})
+	if err != nil {
+		t.Fatalf("recordMetricSchemaWarnings failed: %v", err)
+	}
+	want := []string{`metric "val_acuracy": not in the metric schema (did you mean "val_accuracy"?)`}
+	if !reflect.DeepEqual(messages, want) || len(fake.warnings) != 1 || fake.warnings[0].Kind != metricSchemaUnknownKey {
+		t.Errorf("Expected the unknown key recorded and reported, got %q and %+v", messages, fake.warnings)
+	}
+
+	if messages, _ := recordMetricSchemaWarnings(ctx, 1, "val_accuracy", []float64{1}); messages != nil || len(fake.warnings) != 1 {
+		t.Errorf("Expected declared metrics to pass, got %q", messages)
+	}
+}
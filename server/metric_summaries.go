@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"path"
+	"slices"
+	"strings"
+)
+
+// HeadlineMetric is the final and best value of a run's loss or accuracy, as
+// listed with the run
+type HeadlineMetric struct {
+	Key      string
+	Last     float64
+	Best     float64
+	BestStep float64
+}
+
+// isLossKey reports whether a metric key names a loss, e.g. val/loss
+func isLossKey(key string) bool {
+	return strings.Contains(strings.ToLower(path.Base(key)), "loss")
+}
+
+// isAccuracyKey reports whether a metric key names an accuracy, e.g.
+// val/accuracy or top1_acc, but not grad_accumulation
+func isAccuracyKey(key string) bool {
+	name := strings.ToLower(path.Base(key))
+	if strings.Contains(name, "accuracy") {
+		return true
+	}
+	words := strings.FieldsFunc(name, func(r rune) bool { return r == '_' || r == '-' || r == '.' })
+	return slices.Contains(words, "acc")
+}
+
+// pickHeadlineMetric picks the key of a run's headline metric among those
+// matching is: validation metrics before the others, then the shortest key,
+// then the first in order
+func pickHeadlineMetric(keys []string, is func(string) bool) string {
+	before := func(a, b string) bool {
+		validA := strings.Contains(strings.ToLower(a), "val")
+		validB := strings.Contains(strings.ToLower(b), "val")
+		if validA != validB {
+			return validA
+		}
+		if len(a) != len(b) {
+			return len(a) < len(b)
+		}
+		return a < b
+	}
+	var best string
+	for _, key := range keys {
+		if is(key) && (best == "" || before(key, best)) {
+			best = key
+		}
+	}
+	return best
+}
+
+// withMetricSummaries fills in the headline loss and accuracy of runs from
+// their metric summaries, returning a copy so that runs shared through the
+// home page cache are not written to. The best loss is the lowest and the
+// best accuracy the highest.
+func withMetricSummaries(ctx context.Context, runs []RunSummary) ([]RunSummary, error) {
+	if len(runs) == 0 {
+		return runs, nil
+	}
+	runIDs := make([]int, len(runs))
+	for i, run := range runs {
+		runIDs[i] = run.ID
+	}
+	rows, err := dao.GetMetricSummariesByRunIDs(ctx, runIDs)
+	if err != nil {
+		return nil, err
+	}
+	byRun := make(map[int]map[string]MetricSummaryRow)
+	for _, row := range rows {
+		if byRun[row.RunID] == nil {
+			byRun[row.RunID] = make(map[string]MetricSummaryRow)
+		}
+		byRun[row.RunID][row.Key] = row
+	}
+
+	runs = slices.Clone(runs)
+	for i := range runs {
+		summaries := byRun[runs[i].ID]
+		var keys []string
+		for key := range summaries {
+			keys = append(keys, key)
+		}
+		if key := pickHeadlineMetric(keys, isLossKey); key != "" {
+			s := summaries[key]
+			runs[i].Loss = &HeadlineMetric{Key: key, Last: s.LastValue, Best: s.MinValue, BestStep: s.MinStep}
+		}
+		if key := pickHeadlineMetric(keys, isAccuracyKey); key != "" {
+			s := summaries[key]
+			runs[i].Accuracy = &HeadlineMetric{Key: key, Last: s.LastValue, Best: s.MaxValue, BestStep: s.MaxStep}
+		}
+	}
+	return runs, nil
+}
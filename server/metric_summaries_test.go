@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+// metricSummariesDAO returns fixed metric summaries
+type metricSummariesDAO struct {
+	DAO
+	summaries []MetricSummaryRow
+}
+
+func (d *metricSummariesDAO) GetMetricSummariesByRunIDs(ctx context.Context, runIDs []int) ([]MetricSummaryRow, error) {
+	return d.summaries, nil
+}
+
+func TestPickHeadlineMetric(t *testing.T) {
+	for _, tc := range []struct {
+		keys []string
+		is   func(string) bool
+		want string
+	}{
+		{[]string{"train/loss", "val/loss", "val/loss_ema"}, isLossKey, "val/loss"},
+		{[]string{"train/loss", "loss"}, isLossKey, "loss"},
+		{[]string{"b_loss", "a_loss"}, isLossKey, "a_loss"},
+		{[]string{"lr", "loss/scale"}, isLossKey, ""},
+		{[]string{"train/acc", "val/accuracy", "grad_accumulation"}, isAccuracyKey, "val/accuracy"},
+		{[]string{"top1_acc", "grad_accumulation"}, isAccuracyKey, "top1_acc"},
+		{[]string{"grad_accumulation"}, isAccuracyKey, ""},
+	} {
+		if got := pickHeadlineMetric(tc.keys, tc.is); got != tc.want {
+			t.Errorf("pickHeadlineMetric(%v) = %q, want %q", tc.keys, got, tc.want)
+		}
+	}
+}
+
+func TestWithMetricSummaries(t *testing.T) {
+	ctx := t.Context()
+	defer func(d DAO) { dao = d }(dao)
+	dao = &metricSummariesDAO{summaries: []MetricSummaryRow{
+		{RunID: 1, Key: "val/loss", LastStep: 10, LastValue: 0.4, MinStep: 8, MinValue: 0.3, MaxStep: 0, MaxValue: 2},
+		{RunID: 1, Key: "val/accuracy", LastStep: 10, LastValue: 0.8, MinStep: 0, MinValue: 0.1, MaxStep: 9, MaxValue: 0.85},
+		{RunID: 2, Key: "lr", LastStep: 10, LastValue: 0.01},
+	}}
+
+	cached := []RunSummary{{ID: 1}, {ID: 2}}
+	runs, err := withMetricSummaries(ctx, cached)
+	if err != nil {
+		t.Fatalf("withMetricSummaries failed: %v", err)
+	}
+	if got := runs[0].Loss; got == nil || *got != (HeadlineMetric{Key: "val/loss", Last: 0.4, Best: 0.3, BestStep: 8}) {
+		t.Errorf("Expected the lowest loss as the best, got %+v", got)
+	}
+	if got := runs[0].Accuracy; got == nil || *got != (HeadlineMetric{Key: "val/accuracy", Last: 0.8, Best: 0.85, BestStep: 9}) {
+		t.Errorf("Expected the highest accuracy as the best, got %+v", got)
+	}
+	if runs[1].Loss != nil || runs[1].Accuracy != nil {
+		t.Errorf("Expected no headline metrics for a run without loss or accuracy, got %+v", runs[1])
+	}
+	if cached[0].Loss != nil {
+		t.Errorf("Expected the runs given left unchanged")
+	}
+	page := RunListPage{Runs: runs[1:]}
+	if page.HasLoss() || page.HasAccuracy() {
+		t.Errorf("Expected no loss or accuracy columns for runs without them")
+	}
+}
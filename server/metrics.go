@@ -0,0 +1,655 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// metricGapThreshold is the minimum interval between consecutive logged
+// points of a series that is reported as a gap on the run overview charts.
+var metricGapThreshold = 10 * time.Minute
+
+// Limits on the number of points of a metric series returned for charting
+const (
+	metricSeriesDefaultPoints = 1000
+	metricSeriesMinPoints     = 4
+	metricSeriesMaxPoints     = 100000
+)
+
+// MetricGap describes a stretch of a metric series during which no points
+// were logged for longer than metricGapThreshold.
+type MetricGap struct {
+	StartX float64 `json:"start"`
+	EndX   float64 `json:"end"`
+	// StartTime and EndTime are when the points around the gap were logged,
+	// in epoch milliseconds
+	StartTime int64  `json:"start_time"`
+	EndTime   int64  `json:"end_time"`
+	Duration  string `json:"duration"`
+}
+
+// newMetricGaps describes the stretches between consecutive points logged
+// further apart than the gap threshold. Such gaps usually mean the job was
+// preempted or hung while the run was still live.
+func newMetricGaps(rows []MetricGapRow) []MetricGap {
+	gaps := make([]MetricGap, len(rows))
+	for i, row := range rows {
+		gaps[i] = MetricGap{
+			StartX:    row.Start.XValue,
+			EndX:      row.End.XValue,
+			StartTime: row.Start.LoggedAt.UnixMilli(),
+			EndTime:   row.End.LoggedAt.UnixMilli(),
+			Duration:  row.End.LoggedAt.Sub(row.Start.LoggedAt).Round(time.Second).String(),
+		}
+	}
+	return gaps
+}
+
+// MetricSeries is a metric series of a run, downsampled for charting. Time
+// is when each point was logged, in epoch milliseconds, so that the series
+// can be charted against wall-clock time as well as x.
+type MetricSeries struct {
+	Key  string      `json:"key"`
+	X    []float64   `json:"x"`
+	Y    []float64   `json:"y"`
+	Time []int64     `json:"time"`
+	Gaps []MetricGap `json:"gaps"`
+}
+
+// handleAPIRunMetricSeries returns a metric series of a run for charting, at
+// /api/runs/{uuid}/metrics/{key}?max_points=N. Series with more than
+// max_points points are downsampled, keeping each stretch's extremes.
+// Requests accepting an Arrow stream are sent the series as x, y and
+// logged_at columns instead, whole unless max_points is given, for analysis
+// notebooks that would otherwise spend most of their time parsing JSON.
+func handleAPIRunMetricSeries(w http.ResponseWriter, r *http.Request, runUUID, key string) {
+	ctx := r.Context()
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	arrow := wantsArrowStream(r)
+	maxPoints := metricSeriesDefaultPoints
+	if arrow {
+		maxPoints = 0
+	}
+	if s := r.URL.Query().Get("max_points"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < metricSeriesMinPoints || n > metricSeriesMaxPoints {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "max_points must be an integer from 4 to 100000"})
+			return
+		}
+		maxPoints = n
+	}
+
+	runID, err := dao.GetRunIDByUUID(ctx, runUUID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Run not found"})
+		return
+	}
+
+	var rows []MetricRow
+	if maxPoints == 0 {
+		rows, err = dao.GetMetricSeries(ctx, runID, key)
+	} else {
+		rows, err = dao.GetMetricsDownsampled(ctx, runID, key, maxPoints)
+	}
+	if err != nil {
+		log.Printf("Failed to query metric %s of run %s: %v", key, runUUID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to query metric"})
+		return
+	}
+	if len(rows) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Metric not found"})
+		return
+	}
+	if arrow {
+		writeMetricSeriesArrow(w, runUUID, rows)
+		return
+	}
+	gapRows, err := dao.GetMetricGaps(ctx, runID, key, metricGapThreshold)
+	if err != nil {
+		log.Printf("Failed to query gaps of metric %s of run %s: %v", key, runUUID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to query metric"})
+		return
+	}
+
+	series := MetricSeries{
+		Key:  key,
+		X:    make([]float64, len(rows)),
+		Y:    make([]float64, len(rows)),
+		Time: make([]int64, len(rows)),
+		Gaps: newMetricGaps(gapRows),
+	}
+	for i, row := range rows {
+		series.X[i], series.Y[i], series.Time[i] = row.XValue, row.YValue, row.LoggedAt.UnixMilli()
+	}
+	json.NewEncoder(w).Encode(series)
+}
+
+// writeMetricSeriesArrow sends a metric series as an Arrow stream
+func writeMetricSeriesArrow(w http.ResponseWriter, runUUID string, rows []MetricRow) {
+	w.Header().Set("Content-Type", arrowStreamMediaType)
+	aw := newArrowStreamWriter(w, []arrowColumn{
+		{Name: "x", Type: arrowFloat64},
+		{Name: "y", Type: arrowFloat64},
+		{Name: "logged_at", Type: arrowTimestampMillis},
+	})
+	for _, row := range rows {
+		if err := aw.WriteRow(row.XValue, row.YValue, row.LoggedAt.UnixMilli()); err != nil {
+			log.Printf("Failed to send metric %s of run %s: %v", row.Key, runUUID, err)
+			return
+		}
+	}
+	if err := aw.Close(); err != nil {
+		log.Printf("Failed to send metric series of run %s: %v", runUUID, err)
+	}
+}
+
+// Limits on the points returned and the time spent waiting for them by a
+// metric tail request
+const (
+	metricTailDefaultPoints = 1000
+	metricTailMaxWait       = 60 * time.Second
+)
+
+// MetricTail is the points of a metric series after a step. NextAfterStep is
+// the after_step to request the points that follow with, and is null until
+// the series has points.
+type MetricTail struct {
+	Key           string    `json:"key"`
+	X             []float64 `json:"x"`
+	Y             []float64 `json:"y"`
+	Time          []int64   `json:"time"`
+	NextAfterStep *float64  `json:"next_after_step"`
+	// HasMore is set when there were more than limit points, and the rest
+	// can be requested straight away
+	HasMore bool `json:"has_more"`
+}
+
+// handleAPIRunMetricTail returns the points of a metric series after a step,
+// at /api/runs/{uuid}/metrics/{key}/tail?after_step=N&limit=N&wait=S, so that
+// clients can follow a series as it is logged without fetching it again.
+// With wait, a request with no points to return waits up to that many
+// seconds for some to be logged before responding.
+func handleAPIRunMetricTail(w http.ResponseWriter, r *http.Request, runUUID, key string) {
+	ctx := r.Context()
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	// Every point's x is after the lowest float, which unlike -Inf can be
+	// passed to either database
+	afterStep := -math.MaxFloat64
+	if s := query.Get("after_step"); s != "" {
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil || math.IsNaN(v) {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "after_step must be a number"})
+			return
+		}
+		afterStep = v
+	}
+	limit := metricTailDefaultPoints
+	if s := query.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > metricSeriesMaxPoints {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "limit must be an integer from 1 to 100000"})
+			return
+		}
+		limit = n
+	}
+	var wait time.Duration
+	if s := query.Get("wait"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 || time.Duration(n)*time.Second > metricTailMaxWait {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "wait must be an integer number of seconds from 0 to 60"})
+			return
+		}
+		wait = time.Duration(n) * time.Second
+	}
+
+	runID, err := dao.GetRunIDByUUID(ctx, runUUID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Run not found"})
+		return
+	}
+
+	// Subscribe before the first query, so that points logged in between
+	// are not missed
+	var events <-chan RunEvent
+	var timeout <-chan time.Time
+	if wait > 0 {
+		var unsubscribe func()
+		events, unsubscribe = runEvents.Subscribe(runID)
+		defer unsubscribe()
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	var rows []MetricRow
+	for {
+		rows, err = dao.GetMetricsAfter(ctx, runID, key, afterStep, limit+1)
+		if err != nil {
+			log.Printf("Failed to query metric %s of run %s: %v", key, runUUID, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to query metric"})
+			return
+		}
+		if len(rows) > 0 || !waitForMetricEvent(ctx, events, timeout) {
+			break
+		}
+	}
+
+	tail := MetricTail{Key: key, X: []float64{}, Y: []float64{}, Time: []int64{}}
+	if len(rows) > limit {
+		rows, tail.HasMore = rows[:limit], true
+	}
+	for _, row := range rows {
+		tail.X = append(tail.X, row.XValue)
+		tail.Y = append(tail.Y, row.YValue)
+		tail.Time = append(tail.Time, row.LoggedAt.UnixMilli())
+	}
+	if len(rows) > 0 {
+		tail.NextAfterStep = &rows[len(rows)-1].XValue
+	} else if afterStep != -math.MaxFloat64 {
+		tail.NextAfterStep = &afterStep
+	}
+	json.NewEncoder(w).Encode(tail)
+}
+
+// waitForMetricEvent waits for metrics to be logged to a run, returning
+// false if events is nil or closed, timeout fires, or ctx is done first.
+// Relayed events do not say which series they belong to, so any metrics
+// event ends the wait.
+func waitForMetricEvent(ctx context.Context, events <-chan RunEvent, timeout <-chan time.Time) bool {
+	if events == nil {
+		return false
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-timeout:
+			return false
+		case event, ok := <-events:
+			if !ok {
+				return false
+			}
+			if event.Type == "metrics" || event.Type == "resync" {
+				return true
+			}
+		}
+	}
+}
+
+// MetricBest is the best point of a metric series, for picking the step whose
+// checkpoint to deploy
+type MetricBest struct {
+	Key      string  `json:"key"`
+	Mode     string  `json:"mode"`
+	Step     float64 `json:"step"`
+	Value    float64 `json:"value"`
+	LoggedAt string  `json:"logged_at"`
+}
+
+// handleAPIRunMetricBest returns the best point of a metric series, the
+// lowest with mode=min or the highest with mode=max, at
+// /api/runs/{uuid}/metrics/{key}/best. Ties go to the earliest step.
+func handleAPIRunMetricBest(w http.ResponseWriter, r *http.Request, runUUID, key string) {
+	ctx := r.Context()
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	mode := r.URL.Query().Get("mode")
+	if mode != bestCheckpointModeMin && mode != bestCheckpointModeMax {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "mode must be min or max"})
+		return
+	}
+
+	runID, err := dao.GetRunIDByUUID(ctx, runUUID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Run not found"})
+		return
+	}
+	best, err := dao.GetBestMetric(ctx, runID, key, mode == bestCheckpointModeMax)
+	if errors.Is(err, sql.ErrNoRows) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Metric not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to query best %s of run %s: %v", key, runUUID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to query metric"})
+		return
+	}
+	json.NewEncoder(w).Encode(MetricBest{
+		Key:      key,
+		Mode:     mode,
+		Step:     best.XValue,
+		Value:    best.YValue,
+		LoggedAt: best.LoggedAt.UTC().Format(time.RFC3339Nano),
+	})
+}
+
+// metricsPerPage is how many metrics the run overview lists per page
+const metricsPerPage = 50
+
+// MetricNamespace is a namespace of a run's metric keys, such as "train/",
+// with the number of keys in it
+type MetricNamespace struct {
+	Name  string
+	Count int
+}
+
+// MetricGroup is the metrics of one namespace on a page of the overview.
+// Count is how many metrics the namespace has on all pages, and a collapsed
+// group lists none of them.
+type MetricGroup struct {
+	Namespace string
+	Metrics   []Metric
+	Collapsed bool
+	Count     int
+}
+
+// MetricsView is one page of a run's metrics, filtered by key prefix and
+// grouped by namespace. Collapsed namespaces take up one entry of a page
+// however many metrics they have, so that runs with hundreds of metrics can
+// be narrowed to the namespaces of interest.
+type MetricsView struct {
+	RunUUID    string
+	Prefix     string
+	Namespaces []MetricNamespace
+	Groups     []MetricGroup
+	// Collapsed is the namespaces whose metrics are hidden
+	Collapsed []string
+	// Matched is the number of metrics matching the prefix, on all pages
+	Matched    int
+	Total      int
+	Page       int
+	TotalPages int
+}
+
+// PrevPage is the number of the previous page, or 0 on the first page
+func (v MetricsView) PrevPage() int {
+	return v.Page - 1
+}
+
+// NextPage is the number of the next page, or 0 on the last page
+func (v MetricsView) NextPage() int {
+	if v.Page >= v.TotalPages {
+		return 0
+	}
+	return v.Page + 1
+}
+
+// url links to a page of the view with the given namespaces collapsed
+func (v MetricsView) url(page int, collapsed []string) string {
+	query := url.Values{"prefix": {v.Prefix}, "page": {strconv.Itoa(page)}}
+	for _, namespace := range collapsed {
+		query.Add("collapsed", namespace)
+	}
+	return "/runs/" + url.PathEscape(v.RunUUID) + "/metrics?" + query.Encode()
+}
+
+// PageURL links to a page of the view
+func (v MetricsView) PageURL(page int) string {
+	return v.url(page, v.Collapsed)
+}
+
+// ToggleURL links to the current page with a namespace collapsed or expanded
+func (v MetricsView) ToggleURL(namespace string) string {
+	if slices.Contains(v.Collapsed, namespace) {
+		return v.url(v.Page, slices.DeleteFunc(slices.Clone(v.Collapsed), func(n string) bool { return n == namespace }))
+	}
+	return v.url(v.Page, append(slices.Clone(v.Collapsed), namespace))
+}
+
+// CollapseAllURL links to the view with every namespace collapsed
+func (v MetricsView) CollapseAllURL() string {
+	var all []string
+	for _, namespace := range v.Namespaces {
+		all = append(all, namespace.Name)
+	}
+	return v.url(1, all)
+}
+
+// ExpandAllURL links to the view with every namespace expanded
+func (v MetricsView) ExpandAllURL() string {
+	return v.url(1, nil)
+}
+
+// metricNamespace is the part of a metric key up to and including its first
+// slash, e.g. "train/" for "train/loss", or "" for keys without one
+func metricNamespace(key string) string {
+	return metricKeyGroup(key, "")
+}
+
+// metricKeyGroup is the group a metric key starting with prefix is listed in
+// below it: the key up to its next slash after the prefix, e.g.
+// "train/layer1/" for "train/layer1/loss" below "train/", or the prefix
+// itself for keys with no slash after it
+func metricKeyGroup(key, prefix string) string {
+	if i := strings.Index(key[len(prefix):], "/"); i >= 0 {
+		return key[:len(prefix)+i+1]
+	}
+	return prefix
+}
+
+// MetricKeyGroup is a group of a run's metric keys sharing a prefix
+type MetricKeyGroup struct {
+	Prefix string   `json:"prefix"`
+	Count  int      `json:"count"`
+	Keys   []string `json:"keys"`
+}
+
+// groupMetricKeys groups the metric keys starting with prefix by the next
+// /-delimited part of the key after it, ordered by prefix. Keys with no
+// further part are grouped under the prefix itself, which comes first.
+func groupMetricKeys(keys []string, prefix string) []MetricKeyGroup {
+	byPrefix := make(map[string][]string)
+	for _, key := range keys {
+		if strings.HasPrefix(key, prefix) {
+			group := metricKeyGroup(key, prefix)
+			byPrefix[group] = append(byPrefix[group], key)
+		}
+	}
+	groups := make([]MetricKeyGroup, 0, len(byPrefix))
+	for group, keys := range byPrefix {
+		sort.Strings(keys)
+		groups = append(groups, MetricKeyGroup{Prefix: group, Count: len(keys), Keys: keys})
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Prefix < groups[j].Prefix })
+	return groups
+}
+
+// handleAPIRunMetricKeys lists a run's metric keys grouped by namespace at
+// GET /api/runs/{uuid}/metrics. With ?prefix=train/ only keys starting with
+// the prefix are listed, grouped by the next part of their key, e.g.
+// train/layer1/, so that runs with hundreds of metrics can be browsed one
+// level at a time.
+func handleAPIRunMetricKeys(w http.ResponseWriter, r *http.Request, runUUID string) {
+	ctx := r.Context()
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	runID, err := dao.GetRunIDByUUID(ctx, runUUID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Run not found"})
+		return
+	}
+	rows, err := dao.GetLatestMetricsByRunID(ctx, runID)
+	if err != nil {
+		log.Printf("Failed to query metrics for run %s: %v", runUUID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to query metrics"})
+		return
+	}
+	keys := make([]string, len(rows))
+	for i, row := range rows {
+		keys[i] = row.Key
+	}
+	prefix := r.URL.Query().Get("prefix")
+	groups := groupMetricKeys(keys, prefix)
+	total := 0
+	for _, g := range groups {
+		total += g.Count
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"prefix": prefix, "total": total, "groups": groups})
+}
+
+// newMetricsView lists the metrics whose keys start with prefix, on the
+// given page, with the metrics of collapsed namespaces hidden. Metrics
+// without a namespace come first, then each namespace in order. Pages out of
+// range are clamped.
+func newMetricsView(runUUID string, rows []MetricRow, prefix string, page int, collapsed []string) MetricsView {
+	view := MetricsView{RunUUID: runUUID, Prefix: prefix, Total: len(rows)}
+
+	var matched []MetricRow
+	counts, matchedCounts := make(map[string]int), make(map[string]int)
+	for _, row := range rows {
+		counts[metricNamespace(row.Key)]++
+		if strings.HasPrefix(row.Key, prefix) {
+			matched = append(matched, row)
+			matchedCounts[metricNamespace(row.Key)]++
+		}
+	}
+	for name, count := range counts {
+		if name != "" {
+			view.Namespaces = append(view.Namespaces, MetricNamespace{Name: name, Count: count})
+		}
+	}
+	sort.Slice(view.Namespaces, func(i, j int) bool { return view.Namespaces[i].Name < view.Namespaces[j].Name })
+	// Only namespaces the run has are kept collapsed, each once
+	for _, namespace := range view.Namespaces {
+		if slices.Contains(collapsed, namespace.Name) {
+			view.Collapsed = append(view.Collapsed, namespace.Name)
+		}
+	}
+	sort.SliceStable(matched, func(i, j int) bool {
+		ni, nj := metricNamespace(matched[i].Key), metricNamespace(matched[j].Key)
+		if ni != nj {
+			return ni < nj
+		}
+		return matched[i].Key < matched[j].Key
+	})
+
+	// Pages are made of entries: a metric, or a whole collapsed namespace
+	var entries []MetricGroup
+	for _, row := range matched {
+		namespace := metricNamespace(row.Key)
+		if !slices.Contains(view.Collapsed, namespace) {
+			entries = append(entries, MetricGroup{Namespace: namespace, Metrics: []Metric{{
+				Key: row.Key,
+				Latest: MetricValue{
+					XValue:   fmt.Sprintf("%g", row.XValue),
+					YValue:   fmt.Sprintf("%g", row.YValue),
+					LoggedAt: fmt.Sprintf("%d", row.LoggedAt.UnixMilli()),
+				},
+			}}})
+			continue
+		}
+		if last := len(entries) - 1; last < 0 || !entries[last].Collapsed || entries[last].Namespace != namespace {
+			entries = append(entries, MetricGroup{Namespace: namespace, Collapsed: true})
+		}
+	}
+
+	view.Matched = len(matched)
+	view.TotalPages = max((len(entries)+metricsPerPage-1)/metricsPerPage, 1)
+	view.Page = min(max(page, 1), view.TotalPages)
+	start := (view.Page - 1) * metricsPerPage
+	for _, entry := range entries[start:min(start+metricsPerPage, len(entries))] {
+		last := len(view.Groups) - 1
+		if entry.Collapsed || last < 0 || view.Groups[last].Collapsed || view.Groups[last].Namespace != entry.Namespace {
+			view.Groups = append(view.Groups, entry)
+			continue
+		}
+		view.Groups[last].Metrics = append(view.Groups[last].Metrics, entry.Metrics...)
+	}
+	for i := range view.Groups {
+		view.Groups[i].Count = matchedCounts[view.Groups[i].Namespace]
+	}
+	return view
+}
+
+// getRunMetricsView loads the page of a run's metrics requested by the
+// prefix, page and collapsed query parameters
+func getRunMetricsView(r *http.Request, runID int, runUUID string) (MetricsView, error) {
+	rows, err := dao.GetLatestMetricsByRunID(r.Context(), runID)
+	if err != nil {
+		return MetricsView{}, err
+	}
+	query := r.URL.Query()
+	page, _ := strconv.Atoi(query.Get("page"))
+	return newMetricsView(runUUID, rows, query.Get("prefix"), page, query["collapsed"]), nil
+}
+
+// runMetricsTemplates are the templates of the metrics list of the run overview
+var runMetricsTemplates = registerTemplates("templates/run_metrics.html")
+
+// handleRunMetrics renders the metrics table of the run overview, for
+// filtering it by key prefix and paging through it
+func handleRunMetrics(w http.ResponseWriter, r *http.Request, runUUID string) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	runID, err := dao.GetRunIDByUUID(r.Context(), runUUID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "Run not found")
+		return
+	}
+	view, err := getRunMetricsView(r, runID, runUUID)
+	if err != nil {
+		log.Printf("Failed to query metrics for run %s: %v", runUUID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	tmpl, err := runMetricsTemplates.Get()
+	if err != nil {
+		log.Printf("Failed to parse template: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if err := tmpl.ExecuteTemplate(w, "run_metrics", view); err != nil {
+		log.Printf("Failed to execute template: %v", err)
+	}
+}
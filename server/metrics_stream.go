@@ -0,0 +1,511 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Metric streams carry metric points over a WebSocket at /api/stream, for
+// clients logging thousands of points a second, where a request per point
+// costs more than the point. Clients send text messages of newline-delimited
+// JSON points:
+//
+//	{"seq": 1, "run_uuid": "...", "key": "loss", "x_value": 0, "y_value": 0.93, "logged_at_epoch_millis": 1700000000000}
+//
+// seq defaults to one more than the previous point's, and logged_at to when
+// the server received the point. The server buffers points and inserts them
+// in batched transactions, acknowledging each with
+//
+//	{"type": "ack", "seq": 42, "points": 40}
+//
+// once every point up to seq has been committed or rejected. Rejected points
+// are reported with {"type": "error", "seq": ..., "points": ..., "error": ...},
+// giving the first of them and how many, and are not retried. Points of
+// metrics outside the experiment's schema are committed, and warned about with
+// {"type": "warning", "run_uuid": ..., "key": ..., "warnings": [...]}.
+// While the server is falling behind, acks carry "backoff_ms", how long the
+// client should wait before sending more points; see backpressure.go.
+
+// metricStreamFlushPoints is how many buffered points trigger a flush
+const metricStreamFlushPoints = 5000
+
+// metricStreamMaxMessageBytes bounds the messages of a metric stream
+const metricStreamMaxMessageBytes = 4 << 20
+
+// metricStreamFlushInterval is how often buffered points are flushed and
+// acknowledged
+var metricStreamFlushInterval = 250 * time.Millisecond
+
+// StreamedMetricPoint is a point sent over a metric stream
+type StreamedMetricPoint struct {
+	Seq                 int64    `json:"seq"`
+	RunUUID             string   `json:"run_uuid"`
+	Key                 string   `json:"key"`
+	XValue              *float64 `json:"x_value"`
+	YValue              *float64 `json:"y_value"`
+	LoggedAtEpochMillis *int64   `json:"logged_at_epoch_millis"`
+}
+
+// MetricStreamReply is a message the server sends over a metric stream
+type MetricStreamReply struct {
+	Type     string   `json:"type"`
+	Seq      int64    `json:"seq,omitempty"`
+	Points   int      `json:"points,omitempty"`
+	RunUUID  string   `json:"run_uuid,omitempty"`
+	Key      string   `json:"key,omitempty"`
+	Error    string   `json:"error,omitempty"`
+	Warnings []string `json:"warnings,omitempty"`
+	// BackoffMillis asks the client to wait before sending more points
+	BackoffMillis int64 `json:"backoff_ms,omitempty"`
+}
+
+// metricStreamRegistry tracks open metric streams, so that shutting down
+// flushes their buffered points before the database closes
+type metricStreamRegistry struct {
+	mu      sync.Mutex
+	closed  bool
+	closing chan struct{}
+	streams sync.WaitGroup
+}
+
+// metricStreams are the server's open metric streams
+var metricStreams = newMetricStreamRegistry()
+
+func newMetricStreamRegistry() *metricStreamRegistry {
+	return &metricStreamRegistry{closing: make(chan struct{})}
+}
+
+// open registers a stream, returning false once the server is shutting down.
+// The stream calls done when it has finished.
+func (s *metricStreamRegistry) open() (done func(), ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil, false
+	}
+	s.streams.Add(1)
+	return s.streams.Done, true
+}
+
+// Close asks every stream to flush and close
+func (s *metricStreamRegistry) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.closed {
+		s.closed = true
+		close(s.closing)
+	}
+}
+
+// Wait waits for the streams to finish closing, or ctx to be done
+func (s *metricStreamRegistry) Wait(ctx context.Context) {
+	finished := make(chan struct{})
+	go func() {
+		s.streams.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+	case <-ctx.Done():
+	}
+}
+
+// bufferedMetricPoint is a point awaiting a flush
+type bufferedMetricPoint struct {
+	seq   int64
+	run   *streamedRun
+	point MetricRow
+}
+
+// streamedRun is a run points are streamed to
+type streamedRun struct {
+	ID           int
+	UUID         string
+	ExperimentID int
+}
+
+// metricStream is the state of one client's stream
+type metricStream struct {
+	ws *webSocketConn
+	r  *http.Request
+	// runs caches the runs points have been streamed to, by UUID
+	runs   map[string]*streamedRun
+	buffer []bufferedMetricPoint
+	// seq is the last point received, and acked the last acknowledged
+	seq, acked int64
+	// committed counts the points committed since the last ack
+	committed int
+}
+
+// handleAPIMetricStream accepts a WebSocket of streamed metric points at
+// /api/stream
+func handleAPIMetricStream(w http.ResponseWriter, r *http.Request) {
+	done, ok := metricStreams.open()
+	if !ok {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(map[string]string{"error": "The server is shutting down"})
+		return
+	}
+	defer done()
+	ws, err := upgradeWebSocket(w, r, metricStreamMaxMessageBytes)
+	if err != nil {
+		return
+	}
+	stream := &metricStream{ws: ws, r: r, runs: make(map[string]*streamedRun)}
+	stream.serve(r.Context())
+}
+
+// serve reads points until the client closes the stream, flushing them as
+// they accumulate and every metricStreamFlushInterval
+func (s *metricStream) serve(ctx context.Context) {
+	messages := make(chan []byte, 16)
+	var readErr error
+	go func() {
+		defer close(messages)
+		for {
+			message, err := s.ws.ReadMessage()
+			if err != nil {
+				readErr = err
+				return
+			}
+			messages <- message
+		}
+	}()
+
+	ticker := time.NewTicker(metricStreamFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case message, ok := <-messages:
+			if !ok {
+				s.finish(ctx, readErr)
+				return
+			}
+			s.receive(ctx, message)
+			if len(s.buffer) >= metricStreamFlushPoints && !s.flush(ctx) {
+				return
+			}
+		case <-ticker.C:
+			if !s.flush(ctx) {
+				return
+			}
+		case <-metricStreams.closing:
+			if s.flush(ctx) {
+				s.ws.Close(webSocketCloseGoingAway, "server shutting down")
+			}
+			return
+		}
+	}
+}
+
+// finish flushes the points received before the client closed the stream or
+// broke it, and closes it
+func (s *metricStream) finish(ctx context.Context, readErr error) {
+	if !s.flush(ctx) {
+		return
+	}
+	var closeErr *webSocketCloseError
+	switch {
+	case errors.Is(readErr, errWebSocketClosed):
+		s.ws.Close(webSocketCloseNormal, "")
+	case errors.As(readErr, &closeErr):
+		s.ws.Close(closeErr.Code, closeErr.Reason)
+	default:
+		s.ws.Close(webSocketCloseGoingAway, "")
+	}
+}
+
+// reply sends a message to the client. Failures surface when the read loop
+// finds the connection broken.
+func (s *metricStream) reply(reply MetricStreamReply) {
+	message, err := json.Marshal(reply)
+	if err == nil {
+		err = s.ws.WriteText(message)
+	}
+	if err != nil && !errors.Is(err, errWebSocketClosed) {
+		log.Printf("Failed to reply on metric stream: %v", err)
+	}
+}
+
+// receive buffers the points of a message, rejecting invalid ones
+func (s *metricStream) receive(ctx context.Context, message []byte) {
+	for _, line := range bytes.Split(message, []byte("\n")) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var p StreamedMetricPoint
+		err := json.Unmarshal(line, &p)
+		if p.Seq == 0 {
+			p.Seq = s.seq + 1
+		}
+		s.seq = p.Seq
+		if err != nil {
+			s.reject(p.Seq, 1, "Invalid JSON: "+err.Error())
+			continue
+		}
+		var missing []string
+		if p.RunUUID == "" {
+			missing = append(missing, "run_uuid")
+		}
+		if p.Key == "" {
+			missing = append(missing, "key")
+		}
+		if p.XValue == nil {
+			missing = append(missing, "x_value")
+		}
+		if p.YValue == nil {
+			missing = append(missing, "y_value")
+		}
+		if len(missing) > 0 {
+			s.reject(p.Seq, 1, fmt.Sprintf("Missing required fields: %v", missing))
+			continue
+		}
+		run, err := s.run(ctx, p.RunUUID)
+		if err != nil {
+			s.reject(p.Seq, 1, err.Error())
+			continue
+		}
+		loggedAt := time.Now()
+		if p.LoggedAtEpochMillis != nil {
+			loggedAt = time.UnixMilli(*p.LoggedAtEpochMillis)
+		}
+		s.buffer = append(s.buffer, bufferedMetricPoint{seq: p.Seq, run: run, point: MetricRow{Key: p.Key, XValue: *p.XValue, YValue: *p.YValue, LoggedAt: loggedAt.UTC()}})
+	}
+}
+
+// run looks up a run points are streamed to, which the request's token must
+// be able to access
+func (s *metricStream) run(ctx context.Context, runUUID string) (*streamedRun, error) {
+	if run, ok := s.runs[runUUID]; ok {
+		return run, nil
+	}
+	notFound := errors.New("Run not found: " + runUUID)
+	runID, err := dao.GetRunIDByUUID(ctx, runUUID)
+	if err != nil {
+		return nil, notFound
+	}
+	// Points name their runs in messages the project scope middleware
+	// doesn't look in
+	if ok, err := requestCanAccessRun(s.r, runID); err != nil || !ok {
+		return nil, notFound
+	}
+	experimentID, err := dao.GetRunExperimentID(ctx, runID)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to look up the experiment of run %s", runUUID)
+	}
+	run := &streamedRun{ID: runID, UUID: runUUID, ExperimentID: experimentID}
+	s.runs[runUUID] = run
+	return run, nil
+}
+
+// reject reports points that will not be committed
+func (s *metricStream) reject(seq int64, points int, message string) {
+	s.reply(MetricStreamReply{Type: "error", Seq: seq, Points: points, Error: message})
+}
+
+// streamedSeries is a series of buffered points logged to a run's metric at
+// the same time, which is inserted, journaled and published together as
+// POST /api/metrics would
+type streamedSeries struct {
+	run      *streamedRun
+	key      string
+	loggedAt time.Time
+	points   []bufferedMetricPoint
+}
+
+// series groups the buffer into series, in the order their points arrived
+func (s *metricStream) series() []*streamedSeries {
+	type seriesKey struct {
+		runID    int
+		key      string
+		loggedAt int64
+	}
+	index := make(map[seriesKey]*streamedSeries)
+	var series []*streamedSeries
+	for _, p := range s.buffer {
+		k := seriesKey{p.run.ID, p.point.Key, p.point.LoggedAt.UnixMilli()}
+		if index[k] == nil {
+			index[k] = &streamedSeries{run: p.run, key: p.point.Key, loggedAt: p.point.LoggedAt}
+			series = append(series, index[k])
+		}
+		index[k].points = append(index[k].points, p)
+	}
+	return series
+}
+
+// flush inserts the buffered points in one transaction and acknowledges
+// them. It reports false if the stream had to be closed.
+func (s *metricStream) flush(ctx context.Context) bool {
+	series := s.series()
+	s.buffer = s.buffer[:0]
+	if len(series) > 0 {
+		defer ingestion.track()()
+	}
+
+	// Points over their experiment's quota are rejected, unless an admin
+	// streams them
+	if !hasAdminRole(s.r) {
+		perExperiment := make(map[int]int64)
+		for _, ser := range series {
+			perExperiment[ser.run.ExperimentID] += int64(len(ser.points))
+		}
+		refused := make(map[int]error)
+		for experimentID, n := range perExperiment {
+			if err := checkExperimentQuota(ctx, experimentID, 0, n, 0); err != nil {
+				var exceeded *quotaExceededError
+				if !errors.As(err, &exceeded) {
+					log.Printf("Failed to check quota: %v", err)
+					err = errors.New("Failed to check quota")
+				}
+				refused[experimentID] = err
+			}
+		}
+		kept := series[:0]
+		for _, ser := range series {
+			if err := refused[ser.run.ExperimentID]; err != nil {
+				s.reject(ser.points[0].seq, len(ser.points), err.Error())
+				continue
+			}
+			kept = append(kept, ser)
+		}
+		series = kept
+	}
+
+	batch := make(map[int][]MetricRow)
+	for _, ser := range series {
+		for _, p := range ser.points {
+			batch[ser.run.ID] = append(batch[ser.run.ID], p.point)
+		}
+	}
+	if len(batch) > 0 {
+		if err := dao.InsertMetricBatch(ctx, batch); err != nil {
+			// Insert the series one at a time, so that one that can't be,
+			// e.g. for logging a step twice, rejects only its own points
+			kept := series[:0]
+			for _, ser := range series {
+				rows := make([]MetricRow, len(ser.points))
+				for i, p := range ser.points {
+					rows[i] = p.point
+				}
+				if err := dao.InsertMetricBatch(ctx, map[int][]MetricRow{ser.run.ID: rows}); err != nil {
+					log.Printf("Error inserting streamed metric %s of run %s: %v", ser.key, ser.run.UUID, err)
+					s.reject(ser.points[0].seq, len(ser.points), "Failed to insert metric")
+					continue
+				}
+				kept = append(kept, ser)
+			}
+			series = kept
+		}
+	}
+
+	if !s.journal(series) {
+		s.ws.Close(webSocketCloseInternalError, "failed to journal metrics")
+		return false
+	}
+	s.committed += s.published(ctx, series)
+	if s.seq > s.acked {
+		s.reply(MetricStreamReply{Type: "ack", Seq: s.seq, Points: s.committed, BackoffMillis: ingestion.backoff().Milliseconds()})
+		s.acked, s.committed = s.seq, 0
+	}
+	return true
+}
+
+// journal records committed series in the ingestion journal as the POST
+// /api/metrics requests that would have logged them, so that replay recovers
+// streamed points too
+func (s *metricStream) journal(series []*streamedSeries) bool {
+	if journal == nil {
+		return true
+	}
+	type metricValue struct {
+		XValue float64 `json:"x_value"`
+		YValue float64 `json:"y_value"`
+	}
+	for _, ser := range series {
+		values := make([]metricValue, len(ser.points))
+		for i, p := range ser.points {
+			values[i] = metricValue{p.point.XValue, p.point.YValue}
+		}
+		body, err := json.Marshal(map[string]interface{}{
+			"run_uuid":               ser.run.UUID,
+			"key":                    ser.key,
+			"values":                 values,
+			"logged_at_epoch_millis": ser.loggedAt.UnixMilli(),
+		})
+		if err == nil {
+			err = journal.Append(JournalEntry{
+				Time:        time.Now().UTC(),
+				APIVersion:  currentAPIVersion().Version,
+				Method:      http.MethodPost,
+				Path:        "/api/metrics",
+				ContentType: "application/json",
+				Body:        body,
+			})
+		}
+		if err != nil {
+			// The points have been committed, but acknowledging them would
+			// promise a durability the server cannot provide
+			log.Printf("Failed to journal streamed metric %s of run %s: %v", ser.key, ser.run.UUID, err)
+			return false
+		}
+	}
+	return true
+}
+
+// published does what logging committed series does besides inserting them:
+// recording usage and activity, pushing them to run pages, updating GPU
+// summaries and checking the metric schema. It returns the points committed.
+func (s *metricStream) published(ctx context.Context, series []*streamedSeries) int {
+	points := 0
+	perExperiment := make(map[int]int)
+	runs := make(map[*streamedRun]bool)
+	gpuRuns := make(map[*streamedRun]bool)
+	for _, ser := range series {
+		xs := make([]float64, len(ser.points))
+		ys := make([]float64, len(ser.points))
+		for i, p := range ser.points {
+			xs[i], ys[i] = p.point.XValue, p.point.YValue
+		}
+		points += len(xs)
+		perExperiment[ser.run.ExperimentID] += len(xs)
+		runs[ser.run] = true
+		if isGPUMetricKey(ser.key) {
+			gpuRuns[ser.run] = true
+		}
+		runEvents.Publish(ser.run.ID, RunEvent{Type: "metrics", Data: MetricPointsEvent{Key: ser.key, X: xs, Y: ys, LoggedAt: ser.loggedAt.UnixMilli()}})
+
+		warnings, err := recordMetricSchemaWarnings(ctx, ser.run.ID, ser.key, xs)
+		if err != nil {
+			log.Printf("Failed to check metric %q of run %s against the metric schema: %v", ser.key, ser.run.UUID, err)
+		}
+		if isSystemMetricKey(ser.key) {
+			warnings = append(warnings, fmt.Sprintf("%s is reserved for system metrics; log them with POST /api/system-metrics", systemMetricPrefix))
+		}
+		if len(warnings) > 0 {
+			s.reply(MetricStreamReply{Type: "warning", RunUUID: ser.run.UUID, Key: ser.key, Warnings: warnings})
+		}
+		if err := checkMetricAlerts(ctx, ser.run.ID, ser.run.UUID, ser.key, ys); err != nil {
+			log.Printf("Failed to check metric %q of run %s against its namespace's alerts: %v", ser.key, ser.run.UUID, err)
+		}
+	}
+	for experimentID, n := range perExperiment {
+		recordMetricPointUsage(ctx, experimentID, n)
+	}
+	for run := range runs {
+		recordRunActivity(ctx, run.ID)
+	}
+	for run := range gpuRuns {
+		if err := updateRunGPUSummary(ctx, run.ID); err != nil {
+			log.Printf("Failed to update GPU summary for run %s: %v", run.UUID, err)
+		}
+	}
+	return points
+}
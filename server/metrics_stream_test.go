@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// streamDAO has run-1 in experiment 1, and run-2 in experiment 2, which is
+// over its metric point quota. Inserting a batch with a point of "dup" fails.
+type streamDAO struct {
+	DAO
+	points map[int][]MetricRow
+}
+
+func (d *streamDAO) GetRunIDByUUID(ctx context.Context, uuid string) (int, error) {
+	switch uuid {
+	case "run-1":
+		return 1, nil
+	case "run-2":
+		return 2, nil
+	}
+	return 0, sql.ErrNoRows
+}
+
+func (d *streamDAO) GetRunExperimentID(ctx context.Context, runID int) (int, error) {
+	return runID, nil
+}
+
+func (d *streamDAO) GetExperimentByID(ctx context.Context, id int) (*Experiment, error) {
+	return &Experiment{UUID: "exp", Name: "vision"}, nil
+}
+
+func (d *streamDAO) GetExperimentQuota(ctx context.Context, experimentID int) (*ExperimentQuotaRow, error) {
+	if experimentID != 2 {
+		return nil, nil
+	}
+	return &ExperimentQuotaRow{MaxMetricPointsPerDay: sql.NullInt64{Int64: 1, Valid: true}}, nil
+}
+
+func (d *streamDAO) GetExperimentUsage(ctx context.Context, experimentID int, day string) (*ExperimentUsageRow, error) {
+	return &ExperimentUsageRow{}, nil
+}
+
+func (d *streamDAO) InsertMetricBatch(ctx context.Context, points map[int][]MetricRow) error {
+	for _, rows := range points {
+		for _, p := range rows {
+			if p.Key == "dup" {
+				return sql.ErrTxDone
+			}
+		}
+	}
+	for runID, rows := range points {
+		d.points[runID] = append(d.points[runID], rows...)
+	}
+	return nil
+}
+
+func (d *streamDAO) GetMetricSchemaForRun(ctx context.Context, runID int) ([]MetricSchemaEntryRow, error) {
+	return nil, nil
+}
+
+func (d *streamDAO) GetMetricNamespaceSettingsForRun(ctx context.Context, runID int) ([]MetricNamespaceSettingRow, error) {
+	return nil, nil
+}
+
+func (d *streamDAO) AddExperimentMetricPoints(ctx context.Context, experimentID int, day string, points int) error {
+	return nil
+}
+
+func (d *streamDAO) RecordRunActivity(ctx context.Context, runID int, at time.Time) error {
+	return nil
+}
+
+// dialWebSocket opens a WebSocket to a test server
+func dialWebSocket(t *testing.T, srv *httptest.Server) (net.Conn, *bufio.Reader) {
+	t.Helper()
+	conn, err := net.Dial("tcp", strings.TrimPrefix(srv.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	io.WriteString(conn, "GET /api/stream HTTP/1.1\r\nHost: test\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n"+
+		"Sec-WebSocket-Version: 13\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n\r\n")
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("Unexpected handshake response %d %v", resp.StatusCode, resp.Header)
+	}
+	return conn, r
+}
+
+// writeClientFrame sends a masked frame, as clients must
+func writeClientFrame(t *testing.T, conn net.Conn, fin bool, opcode byte, payload []byte) {
+	t.Helper()
+	first := opcode
+	if fin {
+		first |= 0x80
+	}
+	frame := []byte{first}
+	switch n := len(payload); {
+	case n < 126:
+		frame = append(frame, 0x80|byte(n))
+	default:
+		frame = append(frame, 0x80|126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(n))
+	}
+	var mask [4]byte
+	rand.Read(mask[:])
+	frame = append(frame, mask[:]...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	if _, err := conn.Write(frame); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// readServerFrame reads an unmasked frame
+func readServerFrame(t *testing.T, r *bufio.Reader) (byte, []byte) {
+	t.Helper()
+	var header [2]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		t.Fatalf("Failed to read frame: %v", err)
+	}
+	length := int(header[1] & 0x7F)
+	if length == 126 {
+		var n [2]byte
+		io.ReadFull(r, n[:])
+		length = int(binary.BigEndian.Uint16(n[:]))
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		t.Fatalf("Failed to read frame: %v", err)
+	}
+	return header[0] & 0x0F, payload
+}
+
+// readReplies reads replies until the server closes the stream, returning
+// them and the close status
+func readReplies(t *testing.T, r *bufio.Reader) ([]MetricStreamReply, int) {
+	t.Helper()
+	var replies []MetricStreamReply
+	for {
+		opcode, payload := readServerFrame(t, r)
+		if opcode == webSocketClose {
+			return replies, int(binary.BigEndian.Uint16(payload))
+		}
+		var reply MetricStreamReply
+		if err := json.Unmarshal(payload, &reply); err != nil {
+			t.Fatalf("Invalid reply %s: %v", payload, err)
+		}
+		replies = append(replies, reply)
+	}
+}
+
+func TestMetricStream(t *testing.T) {
+	defer func(d DAO, interval time.Duration, streams *metricStreamRegistry) {
+		dao, metricStreamFlushInterval, metricStreams = d, interval, streams
+	}(dao, metricStreamFlushInterval, metricStreams)
+	fake := &streamDAO{points: make(map[int][]MetricRow)}
+	dao = fake
+	metricStreamFlushInterval = time.Hour
+	metricStreams = newMetricStreamRegistry()
+	srv := httptest.NewServer(http.HandlerFunc(handleAPIMetricStream))
+	defer srv.Close()
+
+	conn, r := dialWebSocket(t, srv)
+	// One message split across frames, with a ping between them
+	writeClientFrame(t, conn, false, webSocketText, []byte(`{"run_uuid": "run-1", "key": "loss", "x_value": 0, "y_value": 1, "logged_at_epoch_millis": 1700000000000}
+{"run_uuid": "run-1", "key": "loss", "x_value": 1, "y_value": 0.5, "logged_at_epoch_millis": 1700000000000}
+not json
+`))
+	writeClientFrame(t, conn, true, webSocketPing, []byte("hi"))
+	writeClientFrame(t, conn, true, webSocketContinuation, []byte(`{"run_uuid": "missing", "key": "loss", "x_value": 0, "y_value": 1}
+{"seq": 10, "run_uuid": "run-2", "key": "loss", "x_value": 0, "y_value": 1}
+{"run_uuid": "run-2", "key": "loss", "x_value": 1, "y_value": 1}
+{"run_uuid": "run-1", "key": "dup", "x_value": 0}
+{"run_uuid": "run-1", "key": "dup", "x_value": 0, "y_value": 2}`))
+	if opcode, payload := readServerFrame(t, r); opcode != webSocketPong || string(payload) != "hi" {
+		t.Errorf("Expected a pong, got %d %q", opcode, payload)
+	}
+	writeClientFrame(t, conn, true, webSocketClose, binary.BigEndian.AppendUint16(nil, webSocketCloseNormal))
+
+	replies, status := readReplies(t, r)
+	if status != webSocketCloseNormal {
+		t.Errorf("Expected a normal close, got %d", status)
+	}
+	rejected := make(map[int64]MetricStreamReply)
+	var acks []MetricStreamReply
+	for _, reply := range replies {
+		switch reply.Type {
+		case "error":
+			rejected[reply.Seq] = reply
+		case "ack":
+			acks = append(acks, reply)
+		}
+	}
+	// Invalid JSON, a missing run, points over the quota, a point missing
+	// its value, and a series the database refused
+	if len(rejected) != 5 || !strings.Contains(rejected[3].Error, "Invalid JSON") || rejected[4].Error != "Run not found: missing" ||
+		rejected[10].Points != 2 || !strings.Contains(rejected[10].Error, "quota") || !strings.Contains(rejected[12].Error, "y_value") || rejected[13].Error != "Failed to insert metric" {
+		t.Errorf("Unexpected errors %+v", rejected)
+	}
+	if len(acks) != 1 || acks[0].Seq != 13 || acks[0].Points != 2 {
+		t.Errorf("Expected the final ack to cover every point, got %+v", acks)
+	}
+	if points := fake.points[1]; len(points) != 2 || points[1].YValue != 0.5 || !points[1].LoggedAt.Equal(time.UnixMilli(1700000000000)) {
+		t.Errorf("Expected run-1's points committed, got %+v", points)
+	}
+	if len(fake.points[2]) != 0 {
+		t.Errorf("Expected run-2's points refused, got %+v", fake.points[2])
+	}
+}
+
+func TestMetricStreamAcksPeriodically(t *testing.T) {
+	defer func(d DAO, interval time.Duration, streams *metricStreamRegistry) {
+		dao, metricStreamFlushInterval, metricStreams = d, interval, streams
+	}(dao, metricStreamFlushInterval, metricStreams)
+	fake := &streamDAO{points: make(map[int][]MetricRow)}
+	dao = fake
+	metricStreamFlushInterval = 10 * time.Millisecond
+	metricStreams = newMetricStreamRegistry()
+	srv := httptest.NewServer(http.HandlerFunc(handleAPIMetricStream))
+	defer srv.Close()
+
+	conn, r := dialWebSocket(t, srv)
+	writeClientFrame(t, conn, true, webSocketText, []byte(`{"seq": 7, "run_uuid": "run-1", "key": "acc", "x_value": 0, "y_value": 0.1}`))
+	opcode, payload := readServerFrame(t, r)
+	var ack MetricStreamReply
+	json.Unmarshal(payload, &ack)
+	if opcode != webSocketText || ack.Type != "ack" || ack.Seq != 7 || ack.Points != 1 || len(fake.points[1]) != 1 {
+		t.Errorf("Expected the point acknowledged while the stream is open, got %s", payload)
+	}
+
+	// Shutting down flushes what is buffered before closing the stream
+	writeClientFrame(t, conn, true, webSocketText, []byte(`{"run_uuid": "run-1", "key": "acc", "x_value": 1, "y_value": 0.2}`))
+	time.Sleep(20 * time.Millisecond)
+	metricStreams.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	metricStreams.Wait(ctx)
+	if _, status := readReplies(t, r); status != webSocketCloseGoingAway {
+		t.Errorf("Expected the stream closed for shutdown, got %d", status)
+	}
+	if len(fake.points[1]) != 2 {
+		t.Errorf("Expected the buffered point committed, got %+v", fake.points[1])
+	}
+	w := httptest.NewRecorder()
+	handleAPIMetricStream(w, httptest.NewRequest(http.MethodGet, "/api/stream", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected new streams refused while shutting down, got %d", w.Code)
+	}
+}
+
+func TestUpgradeWebSocketRejectsPlainRequests(t *testing.T) {
+	w := httptest.NewRecorder()
+	if _, err := upgradeWebSocket(w, httptest.NewRequest(http.MethodGet, "/api/stream", nil), 1024); err == nil || w.Code != http.StatusUpgradeRequired {
+		t.Errorf("Expected 426 for a request without an upgrade, got %d", w.Code)
+	}
+	r := httptest.NewRequest(http.MethodGet, "/api/stream", nil)
+	r.Header.Set("Connection", "keep-alive, Upgrade")
+	r.Header.Set("Upgrade", "websocket")
+	r.Header.Set("Sec-WebSocket-Version", "8")
+	w = httptest.NewRecorder()
+	if _, err := upgradeWebSocket(w, r, 1024); err == nil || w.Code != http.StatusBadRequest || w.Header().Get("Sec-WebSocket-Version") != "13" {
+		t.Errorf("Expected 400 for an old protocol version, got %d", w.Code)
+	}
+}
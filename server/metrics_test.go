@@ -0,0 +1,300 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNewMetricGaps(t *testing.T) {
+	start := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	rows := []MetricGapRow{{
+		Start: MetricRow{Key: "loss", XValue: 1, YValue: 0.9, LoggedAt: start.Add(1 * time.Minute)},
+		End:   MetricRow{Key: "loss", XValue: 2, YValue: 0.8, LoggedAt: start.Add(20*time.Minute + 400*time.Millisecond)},
+	}}
+
+	gaps := newMetricGaps(rows)
+	if len(gaps) != 1 {
+		t.Fatalf("expected 1 gap, got %d", len(gaps))
+	}
+	if gaps[0].StartX != 1 || gaps[0].EndX != 2 {
+		t.Errorf("unexpected gap bounds: %+v", gaps[0])
+	}
+	if gaps[0].Duration != "19m0s" {
+		t.Errorf("unexpected gap duration: %s", gaps[0].Duration)
+	}
+
+	if gaps := newMetricGaps(nil); len(gaps) != 0 || gaps == nil {
+		t.Errorf("expected an empty list of gaps for no rows, got %v", gaps)
+	}
+}
+
+func TestNewMetricsView(t *testing.T) {
+	rows := []MetricRow{{Key: "lr"}, {Key: "system/gpu"}, {Key: "val/loss", YValue: 0.5, XValue: 10}}
+	for i := 0; i < metricsPerPage+10; i++ {
+		rows = append(rows, MetricRow{Key: fmt.Sprintf("train/m%03d", i)})
+	}
+
+	view := newMetricsView("run-1", rows, "", 0, nil)
+	if view.Total != len(rows) || view.Matched != len(rows) || view.Page != 1 || view.TotalPages != 2 {
+		t.Errorf("unexpected counts: %+v", view)
+	}
+	if len(view.Namespaces) != 3 || view.Namespaces[0] != (MetricNamespace{Name: "system/", Count: 1}) || view.Namespaces[1] != (MetricNamespace{Name: "train/", Count: metricsPerPage + 10}) {
+		t.Errorf("unexpected namespaces: %+v", view.Namespaces)
+	}
+	// Metrics without a namespace come first, then each namespace in order
+	if len(view.Groups) != 3 || view.Groups[0].Namespace != "" || view.Groups[1].Namespace != "system/" || view.Groups[2].Namespace != "train/" {
+		t.Fatalf("unexpected groups on the first page: %+v", view.Groups)
+	}
+	if n := len(view.Groups[2].Metrics); n != metricsPerPage-2 {
+		t.Errorf("expected %d train/ metrics on the first page, got %d", metricsPerPage-2, n)
+	}
+
+	view = newMetricsView("run-1", rows, "", 5, nil)
+	if view.Page != 2 || view.NextPage() != 0 || view.PrevPage() != 1 {
+		t.Errorf("expected the last page for a page out of range, got %+v", view)
+	}
+	if len(view.Groups) != 2 || view.Groups[0].Namespace != "train/" || view.Groups[1].Namespace != "val/" {
+		t.Errorf("unexpected groups on the last page: %+v", view.Groups)
+	}
+	if latest := view.Groups[1].Metrics[0].Latest; latest.YValue != "0.5" || latest.XValue != "10" {
+		t.Errorf("unexpected latest value: %+v", latest)
+	}
+
+	view = newMetricsView("run-1", rows, "train/m00", 1, nil)
+	if view.Matched != 10 || view.TotalPages != 1 || view.NextPage() != 0 || len(view.Namespaces) != 3 {
+		t.Errorf("unexpected filtered view: %+v", view)
+	}
+
+	view = newMetricsView("run-1", rows, "missing", 1, nil)
+	if view.Matched != 0 || view.Page != 1 || len(view.Groups) != 0 {
+		t.Errorf("expected no metrics for an unmatched prefix, got %+v", view)
+	}
+
+	// A collapsed namespace takes one entry of a page, fitting every metric
+	// on the first page
+	view = newMetricsView("run-1", rows, "", 1, []string{"train/", "unknown/"})
+	if view.TotalPages != 1 || view.Matched != len(rows) || len(view.Collapsed) != 1 {
+		t.Fatalf("unexpected collapsed view: %+v", view)
+	}
+	if len(view.Groups) != 4 || !view.Groups[2].Collapsed || view.Groups[2].Count != metricsPerPage+10 || len(view.Groups[2].Metrics) != 0 || view.Groups[3].Namespace != "val/" {
+		t.Errorf("expected train/ collapsed between system/ and val/, got %+v", view.Groups)
+	}
+	if got := view.ToggleURL("train/"); got != "/runs/run-1/metrics?page=1&prefix=" {
+		t.Errorf("expected toggling train/ to expand it, got %s", got)
+	}
+	if got := view.ToggleURL("val/"); got != "/runs/run-1/metrics?collapsed=train%2F&collapsed=val%2F&page=1&prefix=" {
+		t.Errorf("expected toggling val/ to collapse it too, got %s", got)
+	}
+	if got := view.CollapseAllURL(); got != "/runs/run-1/metrics?collapsed=system%2F&collapsed=train%2F&collapsed=val%2F&page=1&prefix=" {
+		t.Errorf("unexpected collapse all link %s", got)
+	}
+}
+
+func TestGroupMetricKeys(t *testing.T) {
+	keys := []string{"val/loss", "lr", "train/loss", "train/layer1/grad", "train/layer1/weight", "train/layer2/grad"}
+	groups := groupMetricKeys(keys, "")
+	want := []MetricKeyGroup{
+		{Prefix: "", Count: 1, Keys: []string{"lr"}},
+		{Prefix: "train/", Count: 4, Keys: []string{"train/layer1/grad", "train/layer1/weight", "train/layer2/grad", "train/loss"}},
+		{Prefix: "val/", Count: 1, Keys: []string{"val/loss"}},
+	}
+	if !reflect.DeepEqual(groups, want) {
+		t.Errorf("groupMetricKeys(keys, \"\") = %+v, want %+v", groups, want)
+	}
+
+	groups = groupMetricKeys(keys, "train/")
+	want = []MetricKeyGroup{
+		{Prefix: "train/", Count: 1, Keys: []string{"train/loss"}},
+		{Prefix: "train/layer1/", Count: 2, Keys: []string{"train/layer1/grad", "train/layer1/weight"}},
+		{Prefix: "train/layer2/", Count: 1, Keys: []string{"train/layer2/grad"}},
+	}
+	if !reflect.DeepEqual(groups, want) {
+		t.Errorf("groupMetricKeys(keys, \"train/\") = %+v, want %+v", groups, want)
+	}
+	if groups := groupMetricKeys(keys, "test/"); len(groups) != 0 {
+		t.Errorf("Expected no groups for an unmatched prefix, got %+v", groups)
+	}
+}
+
+// metricKeysDAO serves the latest values of run-1's metrics
+type metricKeysDAO struct {
+	metricBestDAO
+}
+
+func (d *metricKeysDAO) GetLatestMetricsByRunID(ctx context.Context, runID int) ([]MetricRow, error) {
+	return []MetricRow{{Key: "train/loss"}, {Key: "train/acc"}, {Key: "val/loss"}, {Key: "lr"}}, nil
+}
+
+func TestHandleAPIRunMetricKeys(t *testing.T) {
+	defer func(d DAO) { dao = d }(dao)
+	dao = &metricKeysDAO{}
+
+	get := func(path string) (int, map[string]interface{}) {
+		w := httptest.NewRecorder()
+		handleAPIV1Runs(w, httptest.NewRequest(http.MethodGet, path, nil))
+		var resp map[string]interface{}
+		json.NewDecoder(w.Body).Decode(&resp)
+		return w.Code, resp
+	}
+	code, resp := get("/api/v1/runs/run-1/metrics")
+	if code != http.StatusOK || resp["total"] != 4.0 || len(resp["groups"].([]interface{})) != 3 {
+		t.Errorf("Expected 4 keys in 3 groups, got %d %v", code, resp)
+	}
+	code, resp = get("/api/v1/runs/run-1/metrics?prefix=train/")
+	if code != http.StatusOK || resp["total"] != 2.0 || resp["prefix"] != "train/" {
+		t.Errorf("Expected the 2 train/ keys, got %d %v", code, resp)
+	}
+	if code, _ := get("/api/v1/runs/missing/metrics"); code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown run, got %d", code)
+	}
+}
+
+// metricTailDAO keeps the points of one series, which are logged to as
+// tests run
+type metricTailDAO struct {
+	DAO
+	mu     sync.Mutex
+	points []MetricRow
+}
+
+func (d *metricTailDAO) GetRunIDByUUID(ctx context.Context, uuid string) (int, error) {
+	return 1, nil
+}
+
+func (d *metricTailDAO) GetMetricsAfter(ctx context.Context, runID int, key string, afterX float64, limit int) ([]MetricRow, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var rows []MetricRow
+	for _, p := range d.points {
+		if p.Key == key && p.XValue > afterX && len(rows) < limit {
+			rows = append(rows, p)
+		}
+	}
+	return rows, nil
+}
+
+func (d *metricTailDAO) log(x float64) {
+	d.mu.Lock()
+	d.points = append(d.points, MetricRow{Key: "train/loss", XValue: x, YValue: 1 / x, LoggedAt: time.UnixMilli(1700000000000)})
+	d.mu.Unlock()
+	runEvents.Publish(1, RunEvent{Type: "metrics", Data: MetricPointsEvent{Key: "train/loss"}})
+}
+
+func TestHandleAPIRunMetricTail(t *testing.T) {
+	defer func(d DAO) { dao = d }(dao)
+	fake := &metricTailDAO{}
+	dao = fake
+	for x := 1; x <= 3; x++ {
+		fake.log(float64(x))
+	}
+
+	tail := func(ctx context.Context, query string) (int, MetricTail) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/api/v1/runs/run-1/metrics/train/loss/tail"+query, nil).WithContext(ctx)
+		handleAPIV1Runs(w, r)
+		var tail MetricTail
+		json.NewDecoder(w.Body).Decode(&tail)
+		return w.Code, tail
+	}
+
+	code, got := tail(context.Background(), "?after_step=1&limit=1")
+	if code != http.StatusOK || got.Key != "train/loss" || len(got.X) != 1 || got.X[0] != 2 || !got.HasMore || *got.NextAfterStep != 2 {
+		t.Errorf("Expected the point after step 1 and more to come, got %d %+v", code, got)
+	}
+	if _, got := tail(context.Background(), ""); len(got.X) != 3 || got.HasMore || *got.NextAfterStep != 3 {
+		t.Errorf("Expected the whole series without after_step, got %+v", got)
+	}
+	// Without wait, a caught-up client is answered straight away
+	if _, got := tail(context.Background(), "?after_step=3"); len(got.X) != 0 || *got.NextAfterStep != 3 {
+		t.Errorf("Expected no points after the last, got %+v", got)
+	}
+	for _, query := range []string{"?after_step=x", "?limit=0", "?wait=61"} {
+		if code, _ := tail(context.Background(), query); code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for %s, got %d", query, code)
+		}
+	}
+
+	// With wait, the request is answered once the next point is logged
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		fake.log(4)
+	}()
+	start := time.Now()
+	if _, got := tail(context.Background(), "?after_step=3&wait=10"); len(got.X) != 1 || got.X[0] != 4 || time.Since(start) > 5*time.Second {
+		t.Errorf("Expected to wait for the next point, got %+v", got)
+	}
+
+	// A client that goes away stops waiting
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, got := tail(ctx, "?after_step=4&wait=10"); len(got.X) != 0 {
+		t.Errorf("Expected no points, got %+v", got)
+	}
+}
+
+// metricBestDAO finds the best point of run-1's train/loss series
+type metricBestDAO struct {
+	metricTailDAO
+}
+
+func (d *metricBestDAO) GetRunIDByUUID(ctx context.Context, uuid string) (int, error) {
+	if uuid != "run-1" {
+		return 0, sql.ErrNoRows
+	}
+	return 1, nil
+}
+
+func (d *metricBestDAO) GetBestMetric(ctx context.Context, runID int, key string, maximize bool) (*MetricRow, error) {
+	var best *MetricRow
+	for i, p := range d.points {
+		if p.Key == key && (best == nil || (maximize && p.YValue > best.YValue) || (!maximize && p.YValue < best.YValue)) {
+			best = &d.points[i]
+		}
+	}
+	if best == nil {
+		return nil, sql.ErrNoRows
+	}
+	return best, nil
+}
+
+func TestHandleAPIRunMetricBest(t *testing.T) {
+	defer func(d DAO) { dao = d }(dao)
+	fake := &metricBestDAO{}
+	dao = fake
+	for x := 1; x <= 3; x++ {
+		fake.log(float64(x))
+	}
+
+	best := func(path string) (int, MetricBest) {
+		w := httptest.NewRecorder()
+		handleAPIV1Runs(w, httptest.NewRequest(http.MethodGet, path, nil))
+		var best MetricBest
+		json.NewDecoder(w.Body).Decode(&best)
+		return w.Code, best
+	}
+	code, got := best("/api/v1/runs/run-1/metrics/train/loss/best?mode=min")
+	if code != http.StatusOK || got.Key != "train/loss" || got.Step != 3 || got.Value != 1.0/3 || got.LoggedAt != "2023-11-14T22:13:20Z" {
+		t.Errorf("Expected the lowest loss at step 3, got %d %+v", code, got)
+	}
+	if _, got := best("/api/v1/runs/run-1/metrics/train/loss/best?mode=max"); got.Step != 1 || got.Mode != "max" {
+		t.Errorf("Expected the highest loss at step 1, got %+v", got)
+	}
+	for path, expected := range map[string]int{
+		"/api/v1/runs/run-1/metrics/train/loss/best":             http.StatusBadRequest,
+		"/api/v1/runs/run-1/metrics/train/loss/best?mode=median": http.StatusBadRequest,
+		"/api/v1/runs/run-1/metrics/val/loss/best?mode=min":      http.StatusNotFound,
+		"/api/v1/runs/missing/metrics/train/loss/best?mode=min":  http.StatusNotFound,
+	} {
+		if code, _ := best(path); code != expected {
+			t.Errorf("Expected %d for %s, got %d", expected, path, code)
+		}
+	}
+}
@@ -0,0 +1 @@
+DROP INDEX IF EXISTS idx_runs_created_at;
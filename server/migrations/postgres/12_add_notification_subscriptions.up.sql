@@ -0,0 +1,13 @@
+-- Webhook, Slack, and email subscriptions. A NULL experiment_id subscribes to
+-- all experiments; events and tag restrict which notifications are delivered.
+CREATE TABLE IF NOT EXISTS notification_subscriptions (
+    id SERIAL PRIMARY KEY,
+    experiment_id INTEGER,
+    channel TEXT NOT NULL,
+    target TEXT NOT NULL,
+    events TEXT NOT NULL DEFAULT '',
+    tag TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_notification_subscriptions_experiment_id ON notification_subscriptions(experiment_id);
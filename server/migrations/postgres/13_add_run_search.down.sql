@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS idx_runs_search_vector;
+DROP TRIGGER IF EXISTS run_search_annotations ON run_annotations;
+DROP TRIGGER IF EXISTS run_search_runs ON runs;
+DROP FUNCTION IF EXISTS run_search_annotations_trigger();
+DROP FUNCTION IF EXISTS run_search_runs_trigger();
+DROP FUNCTION IF EXISTS run_search_vector(INTEGER, TEXT, TEXT);
+ALTER TABLE runs DROP COLUMN search_vector;
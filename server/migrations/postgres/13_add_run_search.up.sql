@@ -0,0 +1,33 @@
+-- Full-text index over run names, notes, and annotations, kept up to date by
+-- triggers on runs and run_annotations
+ALTER TABLE runs ADD COLUMN search_vector tsvector;
+
+CREATE OR REPLACE FUNCTION run_search_vector(run_id INTEGER, name TEXT, notes TEXT) RETURNS tsvector AS $$
+    SELECT to_tsvector('english',
+        COALESCE(name, '') || ' ' || COALESCE(notes, '') || ' ' ||
+        COALESCE((SELECT string_agg(a.text, ' ') FROM run_annotations a WHERE a.run_id = $1), ''))
+$$ LANGUAGE SQL STABLE;
+
+CREATE OR REPLACE FUNCTION run_search_runs_trigger() RETURNS trigger AS $$
+BEGIN
+    NEW.search_vector := run_search_vector(NEW.id, NEW.name, NEW.notes);
+    RETURN NEW;
+END
+$$ LANGUAGE plpgsql;
+
+CREATE OR REPLACE FUNCTION run_search_annotations_trigger() RETURNS trigger AS $$
+BEGIN
+    UPDATE runs SET search_vector = run_search_vector(id, name, notes) WHERE id = NEW.run_id;
+    RETURN NULL;
+END
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER run_search_runs BEFORE INSERT OR UPDATE OF name, notes ON runs
+    FOR EACH ROW EXECUTE FUNCTION run_search_runs_trigger();
+
+CREATE TRIGGER run_search_annotations AFTER INSERT ON run_annotations
+    FOR EACH ROW EXECUTE FUNCTION run_search_annotations_trigger();
+
+UPDATE runs SET search_vector = run_search_vector(id, name, notes);
+
+CREATE INDEX idx_runs_search_vector ON runs USING GIN (search_vector);
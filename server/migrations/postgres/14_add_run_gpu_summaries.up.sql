@@ -0,0 +1,8 @@
+-- Per-run GPU aggregates, recomputed whenever system/gpu_* metrics are logged
+CREATE TABLE IF NOT EXISTS run_gpu_summaries (
+    run_id INTEGER PRIMARY KEY,
+    utilization_mean DOUBLE PRECISION,
+    memory_peak_bytes DOUBLE PRECISION,
+    gpu_hours DOUBLE PRECISION NOT NULL DEFAULT 0,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
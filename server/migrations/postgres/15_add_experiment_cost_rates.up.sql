@@ -0,0 +1,11 @@
+-- Cost model of an experiment: the price of a GPU-hour for each machine type,
+-- matched against the machine_type parameter of its runs. The machine type "*"
+-- prices runs that match no other rate.
+CREATE TABLE IF NOT EXISTS experiment_cost_rates (
+    id SERIAL PRIMARY KEY,
+    experiment_id INTEGER NOT NULL,
+    machine_type TEXT NOT NULL,
+    usd_per_gpu_hour DOUBLE PRECISION NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(experiment_id, machine_type)
+);
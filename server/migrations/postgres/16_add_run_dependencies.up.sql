@@ -0,0 +1,14 @@
+-- Typed edges recording that a run consumed something produced by another run,
+-- e.g. fine-tuning from its checkpoint. Independent of parent/child nesting.
+CREATE TABLE IF NOT EXISTS run_dependencies (
+    id SERIAL PRIMARY KEY,
+    run_id INTEGER NOT NULL,
+    upstream_run_id INTEGER NOT NULL,
+    kind TEXT NOT NULL,
+    artifact_path TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(run_id, upstream_run_id, kind, artifact_path)
+);
+
+CREATE INDEX idx_run_dependencies_run_id ON run_dependencies(run_id);
+CREATE INDEX idx_run_dependencies_upstream_run_id ON run_dependencies(upstream_run_id);
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS confusion_matrices;
@@ -0,0 +1,13 @@
+-- ROC and precision-recall curves logged by runs, one per key and step. points
+-- is a JSON array of {threshold, x, y}; auc is computed when the curve is logged.
+CREATE TABLE IF NOT EXISTS run_curves (
+    id SERIAL PRIMARY KEY,
+    run_id INTEGER NOT NULL,
+    key TEXT NOT NULL,
+    kind TEXT NOT NULL,
+    step DOUBLE PRECISION NOT NULL DEFAULT 0,
+    points TEXT NOT NULL,
+    auc DOUBLE PRECISION NOT NULL,
+    logged_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(run_id, key, step)
+);
@@ -0,0 +1,11 @@
+-- Generated text samples logged by runs, one set per key and step. samples is
+-- a JSON array of strings.
+CREATE TABLE IF NOT EXISTS text_samples (
+    id SERIAL PRIMARY KEY,
+    run_id INTEGER NOT NULL,
+    key TEXT NOT NULL,
+    step DOUBLE PRECISION NOT NULL DEFAULT 0,
+    samples TEXT NOT NULL,
+    logged_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(run_id, key, step)
+);
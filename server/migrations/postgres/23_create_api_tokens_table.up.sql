@@ -0,0 +1,9 @@
+-- Tokens authorizing API requests, stored as SHA-256 hashes. Revoked tokens
+-- are kept, so that a name is never reused for a different token.
+CREATE TABLE IF NOT EXISTS api_tokens (
+    id SERIAL PRIMARY KEY,
+    name TEXT NOT NULL UNIQUE,
+    token_hash TEXT NOT NULL UNIQUE,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    revoked_at TIMESTAMP
+);
@@ -0,0 +1 @@
+ALTER TABLE artifacts DROP COLUMN sha256;
@@ -0,0 +1,10 @@
+-- Snapshot of the environment variables a run was started with. Values that
+-- look like secrets are redacted before they are stored.
+CREATE TABLE IF NOT EXISTS run_environment (
+    id SERIAL PRIMARY KEY,
+    run_id INTEGER NOT NULL,
+    key TEXT NOT NULL,
+    value TEXT NOT NULL,
+    redacted BOOLEAN NOT NULL DEFAULT FALSE,
+    UNIQUE(run_id, key)
+);
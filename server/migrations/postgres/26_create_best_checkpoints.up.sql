@@ -0,0 +1,21 @@
+-- How an experiment's runs choose their best checkpoint automatically: the
+-- artifact matching artifact_pattern uploaded when metric_key was at its
+-- lowest (mode min) or highest (mode max)
+CREATE TABLE IF NOT EXISTS best_checkpoint_rules (
+    id SERIAL PRIMARY KEY,
+    experiment_id INTEGER NOT NULL UNIQUE,
+    metric_key TEXT NOT NULL,
+    mode TEXT NOT NULL,
+    artifact_pattern TEXT NOT NULL
+);
+
+-- The artifact designated as each run's best checkpoint, by a rule or by hand
+CREATE TABLE IF NOT EXISTS run_best_checkpoints (
+    id SERIAL PRIMARY KEY,
+    run_id INTEGER NOT NULL UNIQUE,
+    path TEXT NOT NULL,
+    source TEXT NOT NULL,
+    metric_key TEXT,
+    metric_value DOUBLE PRECISION,
+    designated_at TIMESTAMP NOT NULL
+);
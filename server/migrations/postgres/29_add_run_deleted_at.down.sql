@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_runs_deleted_at;
+ALTER TABLE runs DROP COLUMN deleted_at;
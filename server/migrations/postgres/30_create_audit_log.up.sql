@@ -0,0 +1,12 @@
+-- Administrative operations that change logged data, such as merging runs,
+-- for later review. subject is what the operation acted on, e.g. a run UUID,
+-- and details is a JSON object describing it.
+CREATE TABLE IF NOT EXISTS audit_log (
+    id SERIAL PRIMARY KEY,
+    action TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    details TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_audit_log_created_at ON audit_log(created_at);
@@ -0,0 +1,4 @@
+-- The MIME type of each artifact's contents, sniffed on upload, which picks
+-- the viewer it is shown with. Artifacts uploaded earlier have none and are
+-- typed by their extension when viewed.
+ALTER TABLE artifacts ADD COLUMN content_type TEXT;
@@ -0,0 +1 @@
+DROP INDEX IF EXISTS idx_metrics_run_id_key_x_value;
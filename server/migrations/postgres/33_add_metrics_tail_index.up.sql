@@ -0,0 +1,2 @@
+-- Index for reading a series past a step, e.g. to tail it as it is logged
+CREATE INDEX IF NOT EXISTS idx_metrics_run_id_key_x_value ON metrics(run_id, key, x_value);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_runs_archived_at;
+ALTER TABLE runs DROP COLUMN archived_at;
@@ -0,0 +1,18 @@
+-- Uploading an artifact over another keeps the earlier contents. Artifacts
+-- record their current version, and prior versions are kept here, each with
+-- the URI its contents are stored under.
+ALTER TABLE artifacts ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+CREATE TABLE IF NOT EXISTS artifact_versions (
+    id SERIAL PRIMARY KEY,
+    run_id INTEGER NOT NULL,
+    path TEXT NOT NULL,
+    version INTEGER NOT NULL,
+    uri TEXT NOT NULL,
+    type TEXT NOT NULL,
+    content_type TEXT,
+    size_bytes BIGINT NOT NULL DEFAULT 0,
+    sha256 TEXT,
+    updated_at TIMESTAMP,
+    UNIQUE(run_id, path, version)
+);
+CREATE INDEX IF NOT EXISTS idx_artifact_versions_uri ON artifact_versions(uri);
@@ -0,0 +1,12 @@
+-- Track the outcome of scanning each artifact and prior version for malware.
+-- Uploading contents resets them to pending.
+ALTER TABLE artifacts ADD COLUMN scan_status TEXT NOT NULL DEFAULT 'pending';
+ALTER TABLE artifacts ADD COLUMN scan_result TEXT;
+ALTER TABLE artifacts ADD COLUMN scan_attempts INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE artifacts ADD COLUMN scanned_at TIMESTAMP;
+ALTER TABLE artifact_versions ADD COLUMN scan_status TEXT NOT NULL DEFAULT 'pending';
+ALTER TABLE artifact_versions ADD COLUMN scan_result TEXT;
+ALTER TABLE artifact_versions ADD COLUMN scan_attempts INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE artifact_versions ADD COLUMN scanned_at TIMESTAMP;
+CREATE INDEX IF NOT EXISTS idx_artifacts_scan_status ON artifacts(scan_status);
+CREATE INDEX IF NOT EXISTS idx_artifact_versions_scan_status ON artifact_versions(scan_status);
@@ -0,0 +1,21 @@
+-- Projects group experiments, and so runs, of separate teams or tenants.
+-- Each project's artifacts are stored under its own key prefix, and API
+-- tokens may be scoped to one project.
+CREATE TABLE IF NOT EXISTS projects (
+    id SERIAL PRIMARY KEY,
+    uuid TEXT UNIQUE NOT NULL,
+    name TEXT UNIQUE NOT NULL,
+    artifact_prefix TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- The Default project is the first, so it has ID 1, which experiments
+-- default to. Its artifacts keep their keys from before projects.
+INSERT INTO projects (uuid, name, created_at)
+VALUES ('00000000-0000-0000-0000-000000000000', 'Default', CURRENT_TIMESTAMP);
+
+ALTER TABLE experiments ADD COLUMN project_id INTEGER NOT NULL DEFAULT 1;
+CREATE INDEX idx_experiments_project_id ON experiments(project_id);
+
+-- Tokens without a project may access every project
+ALTER TABLE api_tokens ADD COLUMN project_id INTEGER;
@@ -0,0 +1,9 @@
+-- Where a run came from, as reported by the client that created it. user is
+-- reserved in Postgres, so the user is user_name.
+ALTER TABLE runs ADD COLUMN user_name TEXT;
+ALTER TABLE runs ADD COLUMN git_commit TEXT;
+ALTER TABLE runs ADD COLUMN git_branch TEXT;
+ALTER TABLE runs ADD COLUMN git_dirty BOOLEAN;
+ALTER TABLE runs ADD COLUMN entrypoint TEXT;
+CREATE INDEX idx_runs_user_name ON runs(user_name) WHERE user_name IS NOT NULL;
+CREATE INDEX idx_runs_git_commit ON runs(git_commit) WHERE git_commit IS NOT NULL;
@@ -0,0 +1,3 @@
+DROP TRIGGER IF EXISTS metric_summaries_insert ON metrics;
+DROP FUNCTION IF EXISTS metric_summaries_trigger();
+DROP TABLE IF EXISTS metric_summaries;
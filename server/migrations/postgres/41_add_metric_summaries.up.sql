@@ -0,0 +1,50 @@
+-- Summary of each metric series of a run: its last point, the lowest and
+-- highest values and the steps they were first reached at, and how many
+-- points it has. A trigger on metrics keeps it up to date on insert, so run
+-- lists can show final and best values without scanning metrics.
+CREATE TABLE IF NOT EXISTS metric_summaries (
+    run_id INTEGER NOT NULL,
+    key TEXT NOT NULL,
+    last_step DOUBLE PRECISION,
+    last_value DOUBLE PRECISION NOT NULL,
+    min_step DOUBLE PRECISION,
+    min_value DOUBLE PRECISION NOT NULL,
+    max_step DOUBLE PRECISION,
+    max_value DOUBLE PRECISION NOT NULL,
+    point_count INTEGER NOT NULL,
+    PRIMARY KEY (run_id, key)
+);
+
+INSERT INTO metric_summaries (run_id, key, last_step, last_value, min_step, min_value, max_step, max_value, point_count)
+SELECT DISTINCT run_id, key,
+    FIRST_VALUE(x_value) OVER (PARTITION BY run_id, key ORDER BY x_value DESC NULLS LAST, id DESC),
+    FIRST_VALUE(y_value) OVER (PARTITION BY run_id, key ORDER BY x_value DESC NULLS LAST, id DESC),
+    FIRST_VALUE(x_value) OVER (PARTITION BY run_id, key ORDER BY y_value, x_value),
+    FIRST_VALUE(y_value) OVER (PARTITION BY run_id, key ORDER BY y_value, x_value),
+    FIRST_VALUE(x_value) OVER (PARTITION BY run_id, key ORDER BY y_value DESC, x_value),
+    FIRST_VALUE(y_value) OVER (PARTITION BY run_id, key ORDER BY y_value DESC, x_value),
+    COUNT(*) OVER (PARTITION BY run_id, key)
+FROM metrics;
+
+CREATE OR REPLACE FUNCTION metric_summaries_trigger() RETURNS trigger AS $$
+BEGIN
+    INSERT INTO metric_summaries AS s (run_id, key, last_step, last_value, min_step, min_value, max_step, max_value, point_count)
+    VALUES (NEW.run_id, NEW.key, NEW.x_value, NEW.y_value, NEW.x_value, NEW.y_value, NEW.x_value, NEW.y_value, 1)
+    ON CONFLICT (run_id, key) DO UPDATE SET
+        last_step = CASE WHEN EXCLUDED.last_step >= s.last_step THEN EXCLUDED.last_step ELSE s.last_step END,
+        last_value = CASE WHEN EXCLUDED.last_step >= s.last_step THEN EXCLUDED.last_value ELSE s.last_value END,
+        min_step = CASE WHEN EXCLUDED.min_value < s.min_value
+            OR (EXCLUDED.min_value = s.min_value AND EXCLUDED.min_step < s.min_step)
+            THEN EXCLUDED.min_step ELSE s.min_step END,
+        min_value = LEAST(s.min_value, EXCLUDED.min_value),
+        max_step = CASE WHEN EXCLUDED.max_value > s.max_value
+            OR (EXCLUDED.max_value = s.max_value AND EXCLUDED.max_step < s.max_step)
+            THEN EXCLUDED.max_step ELSE s.max_step END,
+        max_value = GREATEST(s.max_value, EXCLUDED.max_value),
+        point_count = s.point_count + 1;
+    RETURN NULL;
+END
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER metric_summaries_insert AFTER INSERT ON metrics
+    FOR EACH ROW EXECUTE FUNCTION metric_summaries_trigger();
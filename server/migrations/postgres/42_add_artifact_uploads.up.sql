@@ -0,0 +1,15 @@
+-- Chunked uploads of artifacts in progress. Chunks are staged in the
+-- artifact store until the upload is completed, which assembles them into
+-- the artifact, or expires after going idle.
+CREATE TABLE IF NOT EXISTS artifact_uploads (
+    id SERIAL PRIMARY KEY,
+    uuid TEXT NOT NULL UNIQUE,
+    run_id INTEGER NOT NULL,
+    path TEXT NOT NULL,
+    size_bytes BIGINT NOT NULL,
+    sha256 TEXT NOT NULL,
+    chunk_size BIGINT NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_artifact_uploads_run_path ON artifact_uploads(run_id, path);
@@ -0,0 +1,5 @@
+-- Deleted runs can be kept in the trash for a while before they are purged.
+-- Whoever deleted a run may leave an email address to be told before it is
+-- purged, and purge_notice_sent_at records that they were.
+ALTER TABLE runs ADD COLUMN deleted_by TEXT;
+ALTER TABLE runs ADD COLUMN purge_notice_sent_at TIMESTAMP;
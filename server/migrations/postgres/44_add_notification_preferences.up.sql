@@ -0,0 +1,26 @@
+-- Each user's choice of which events reach them on which channel. user_name is
+-- the user runs are recorded as created by; a NULL experiment_id means any
+-- experiment.
+CREATE TABLE IF NOT EXISTS notification_preferences (
+    id SERIAL PRIMARY KEY,
+    user_name TEXT NOT NULL,
+    event TEXT NOT NULL,
+    experiment_id INTEGER,
+    channel TEXT NOT NULL,
+    target TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_notification_preferences_user_name ON notification_preferences(user_name);
+
+-- Notifications delivered to the web channel, listed on the user's
+-- notification preferences page
+CREATE TABLE IF NOT EXISTS web_notifications (
+    id SERIAL PRIMARY KEY,
+    user_name TEXT NOT NULL,
+    run_uuid TEXT NOT NULL,
+    message TEXT NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_web_notifications_user_name ON web_notifications(user_name, id);
@@ -0,0 +1,11 @@
+-- Users of the web UI and API, with the role deciding what they may do:
+-- viewer, editor, or admin
+CREATE TABLE IF NOT EXISTS users (
+    id SERIAL PRIMARY KEY,
+    name TEXT NOT NULL UNIQUE,
+    role TEXT NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Tokens issued to a user act with the user's role
+ALTER TABLE api_tokens ADD COLUMN user_id INTEGER;
@@ -0,0 +1,11 @@
+-- The random seeds a run was started with: its global seed, and the seeds of
+-- libraries seeded separately, e.g. numpy or torch. Seeds are decimal text,
+-- as libraries such as PyTorch use the whole unsigned 64-bit range.
+ALTER TABLE runs ADD COLUMN seed TEXT;
+CREATE TABLE IF NOT EXISTS run_seeds (
+    id SERIAL PRIMARY KEY,
+    run_id INTEGER NOT NULL,
+    library TEXT NOT NULL,
+    seed TEXT NOT NULL,
+    UNIQUE(run_id, library)
+);
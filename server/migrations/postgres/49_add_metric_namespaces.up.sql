@@ -0,0 +1,29 @@
+-- A metric's namespace is the part of its key before the first slash, e.g.
+-- train for train/loss, and its name the rest. Summaries keep keys split, so
+-- that a run's metrics can be listed a namespace at a time.
+ALTER TABLE metric_summaries ADD COLUMN namespace TEXT
+    GENERATED ALWAYS AS (CASE WHEN strpos(key, '/') > 0 THEN left(key, strpos(key, '/') - 1) ELSE '' END) STORED;
+ALTER TABLE metric_summaries ADD COLUMN name TEXT
+    GENERATED ALWAYS AS (substr(key, strpos(key, '/') + 1)) STORED;
+CREATE INDEX IF NOT EXISTS idx_metric_summaries_namespace ON metric_summaries(run_id, namespace);
+
+-- How an experiment treats the metrics of a namespace: how many days after a
+-- run ends their points are kept, and the values that alert on them
+CREATE TABLE IF NOT EXISTS metric_namespace_settings (
+    experiment_id INTEGER NOT NULL,
+    namespace TEXT NOT NULL,
+    retain_points_days INTEGER,
+    alert_above DOUBLE PRECISION,
+    alert_below DOUBLE PRECISION,
+    PRIMARY KEY (experiment_id, namespace)
+);
+
+-- Metrics of runs that crossed their namespace's alert values, each alerted
+-- on once per run
+CREATE TABLE IF NOT EXISTS metric_alerts (
+    run_id INTEGER NOT NULL,
+    key TEXT NOT NULL,
+    value DOUBLE PRECISION NOT NULL,
+    alerted_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (run_id, key)
+);
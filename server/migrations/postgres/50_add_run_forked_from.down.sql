@@ -0,0 +1 @@
+ALTER TABLE runs DROP COLUMN forked_from;
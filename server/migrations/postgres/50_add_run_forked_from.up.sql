@@ -0,0 +1,2 @@
+-- The UUID of the run a run was forked from, copying its parameters
+ALTER TABLE runs ADD COLUMN forked_from TEXT;
@@ -0,0 +1,9 @@
+-- Artifacts written or deleted by a replica of a multi-instance deployment,
+-- which the other replicas drop from their artifact caches. Entries are only
+-- kept for a while; a replica that has not caught up since flushes its cache.
+CREATE TABLE IF NOT EXISTS artifact_cache_invalidations (
+    id SERIAL PRIMARY KEY,
+    uri TEXT NOT NULL,
+    invalidated_at TIMESTAMP NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_artifact_cache_invalidations_invalidated_at ON artifact_cache_invalidations(invalidated_at);
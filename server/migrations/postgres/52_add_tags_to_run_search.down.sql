@@ -0,0 +1,11 @@
+DROP TRIGGER IF EXISTS run_search_tags ON tags;
+DROP FUNCTION IF EXISTS run_search_tags_trigger();
+
+CREATE OR REPLACE FUNCTION run_search_vector(run_id INTEGER, name TEXT, notes TEXT) RETURNS tsvector AS $$
+    SELECT to_tsvector('english',
+        COALESCE(name, '') || ' ' || COALESCE(notes, '') || ' ' ||
+        COALESCE((SELECT string_agg(a.text, ' ') FROM run_annotations a WHERE a.run_id = $1), ''))
+$$ LANGUAGE SQL STABLE;
+
+UPDATE runs SET search_vector = run_search_vector(id, name, notes)
+    WHERE id IN (SELECT run_id FROM tags);
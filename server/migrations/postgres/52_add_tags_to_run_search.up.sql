@@ -0,0 +1,24 @@
+-- Include run tags in the full-text index, refreshed by a trigger on tags
+CREATE OR REPLACE FUNCTION run_search_vector(run_id INTEGER, name TEXT, notes TEXT) RETURNS tsvector AS $$
+    SELECT to_tsvector('english',
+        COALESCE(name, '') || ' ' || COALESCE(notes, '') || ' ' ||
+        COALESCE((SELECT string_agg(a.text, ' ') FROM run_annotations a WHERE a.run_id = $1), '') || ' ' ||
+        COALESCE((SELECT string_agg(t.key || ' ' || t.value, ' ') FROM tags t WHERE t.run_id = $1), ''))
+$$ LANGUAGE SQL STABLE;
+
+CREATE OR REPLACE FUNCTION run_search_tags_trigger() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        UPDATE runs SET search_vector = run_search_vector(id, name, notes) WHERE id = OLD.run_id;
+    ELSE
+        UPDATE runs SET search_vector = run_search_vector(id, name, notes) WHERE id = NEW.run_id;
+    END IF;
+    RETURN NULL;
+END
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER run_search_tags AFTER INSERT OR UPDATE OR DELETE ON tags
+    FOR EACH ROW EXECUTE FUNCTION run_search_tags_trigger();
+
+UPDATE runs SET search_vector = run_search_vector(id, name, notes)
+    WHERE id IN (SELECT run_id FROM tags);
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_experiment_readme_revisions_experiment_id;
+DROP TABLE IF EXISTS experiment_readme_revisions;
+
+ALTER TABLE experiments DROP COLUMN readme;
@@ -0,0 +1,2 @@
+ALTER TABLE artifacts DROP COLUMN size_bytes;
+ALTER TABLE artifacts DROP COLUMN updated_at;
@@ -0,0 +1,21 @@
+-- Named parameter sets saved from a run, used to create new runs
+CREATE TABLE IF NOT EXISTS run_templates (
+    id SERIAL PRIMARY KEY,
+    name TEXT NOT NULL UNIQUE,
+    experiment_id INTEGER NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Parameters of a run template; prompt marks values to ask for when creating a run
+CREATE TABLE IF NOT EXISTS run_template_parameters (
+    id SERIAL PRIMARY KEY,
+    template_id INTEGER NOT NULL,
+    key TEXT NOT NULL,
+    value_type TEXT NOT NULL,
+    value_string TEXT,
+    value_bool BOOLEAN,
+    value_float DOUBLE PRECISION,
+    value_int INTEGER,
+    prompt BOOLEAN NOT NULL DEFAULT FALSE,
+    UNIQUE(template_id, key)
+);
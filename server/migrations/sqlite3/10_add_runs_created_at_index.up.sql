@@ -0,0 +1,2 @@
+-- Supports listing the most recently created runs on the home page
+CREATE INDEX IF NOT EXISTS idx_runs_created_at ON runs(created_at);
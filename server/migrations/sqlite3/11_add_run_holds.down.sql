@@ -0,0 +1,2 @@
+ALTER TABLE runs DROP COLUMN hold_reason;
+ALTER TABLE runs DROP COLUMN held_at;
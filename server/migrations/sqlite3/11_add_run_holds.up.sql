@@ -0,0 +1,3 @@
+-- Legal/retention hold: a held run is exempt from retention, GC, and deletion
+ALTER TABLE runs ADD COLUMN hold_reason TEXT;
+ALTER TABLE runs ADD COLUMN held_at TIMESTAMP;
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_notification_subscriptions_experiment_id;
+DROP TABLE IF EXISTS notification_subscriptions;
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_run_dependencies_upstream_run_id;
+DROP INDEX IF EXISTS idx_run_dependencies_run_id;
+DROP TABLE IF EXISTS run_dependencies;
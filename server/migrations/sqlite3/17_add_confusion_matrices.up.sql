@@ -0,0 +1,12 @@
+-- Confusion matrices logged by runs, one per key and step. labels is a JSON
+-- array of class names and matrix a JSON array of rows, indexed [true][predicted].
+CREATE TABLE IF NOT EXISTS confusion_matrices (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    run_id INTEGER NOT NULL,
+    key TEXT NOT NULL,
+    step REAL NOT NULL DEFAULT 0,
+    labels TEXT NOT NULL,
+    matrix TEXT NOT NULL,
+    logged_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(run_id, key, step)
+);
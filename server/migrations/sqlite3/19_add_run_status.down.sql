@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_runs_status;
+ALTER TABLE runs DROP COLUMN finished_at;
+ALTER TABLE runs DROP COLUMN last_activity_at;
+ALTER TABLE runs DROP COLUMN status;
@@ -0,0 +1,8 @@
+-- Run lifecycle. A run is RUNNING until it reports FINISHED, FAILED, or KILLED,
+-- or is marked FAILED after logging nothing for longer than the heartbeat
+-- timeout. Runs that predate status tracking are considered finished.
+ALTER TABLE runs ADD COLUMN status TEXT NOT NULL DEFAULT 'RUNNING';
+ALTER TABLE runs ADD COLUMN last_activity_at TIMESTAMP;
+ALTER TABLE runs ADD COLUMN finished_at TIMESTAMP;
+UPDATE runs SET status = 'FINISHED';
+CREATE INDEX idx_runs_status ON runs(status);
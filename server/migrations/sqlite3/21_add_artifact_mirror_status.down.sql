@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_artifacts_uri;
+ALTER TABLE artifacts DROP COLUMN mirrored_at;
+ALTER TABLE artifacts DROP COLUMN mirror_error;
+ALTER TABLE artifacts DROP COLUMN mirror_attempts;
+ALTER TABLE artifacts DROP COLUMN mirror_status;
@@ -0,0 +1,7 @@
+-- Track whether each artifact has been replicated to the mirror artifact
+-- store. Overwriting an artifact resets it to pending.
+ALTER TABLE artifacts ADD COLUMN mirror_status TEXT NOT NULL DEFAULT 'pending';
+ALTER TABLE artifacts ADD COLUMN mirror_attempts INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE artifacts ADD COLUMN mirror_error TEXT;
+ALTER TABLE artifacts ADD COLUMN mirrored_at TIMESTAMP;
+CREATE INDEX IF NOT EXISTS idx_artifacts_uri ON artifacts(uri);
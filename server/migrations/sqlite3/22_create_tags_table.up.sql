@@ -0,0 +1,9 @@
+-- Key/value tags on runs, at most one value per key
+CREATE TABLE IF NOT EXISTS tags (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    run_id INTEGER NOT NULL,
+    key TEXT NOT NULL,
+    value TEXT NOT NULL DEFAULT '',
+    UNIQUE(run_id, key)
+);
+CREATE INDEX IF NOT EXISTS idx_tags_key_value ON tags(key, value);
@@ -0,0 +1,3 @@
+-- SHA-256 of each artifact's contents, computed on upload so that clients can
+-- verify downloads. Artifacts uploaded before this have none.
+ALTER TABLE artifacts ADD COLUMN sha256 TEXT;
@@ -0,0 +1,10 @@
+-- Snapshot of the environment variables a run was started with. Values that
+-- look like secrets are redacted before they are stored.
+CREATE TABLE IF NOT EXISTS run_environment (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    run_id INTEGER NOT NULL,
+    key TEXT NOT NULL,
+    value TEXT NOT NULL,
+    redacted INTEGER NOT NULL DEFAULT 0,
+    UNIQUE(run_id, key)
+);
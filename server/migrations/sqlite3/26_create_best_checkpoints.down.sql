@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS run_best_checkpoints;
+DROP TABLE IF EXISTS best_checkpoint_rules;
@@ -0,0 +1,16 @@
+-- Reports of housekeeping jobs, which delete data only as planned by a
+-- reviewed dry run. The report itself is a JSON file in the artifact store.
+CREATE TABLE IF NOT EXISTS housekeeping_reports (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    job TEXT NOT NULL,
+    dry_run BOOLEAN NOT NULL,
+    uri TEXT NOT NULL,
+    item_count INTEGER NOT NULL,
+    run_count INTEGER NOT NULL,
+    size_bytes INTEGER NOT NULL,
+    plan_id INTEGER REFERENCES housekeeping_reports(id),
+    created_at TIMESTAMP NOT NULL,
+    executed_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_housekeeping_reports_job ON housekeeping_reports(job, created_at);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS cache_generations;
+DROP TABLE IF EXISTS job_leases;
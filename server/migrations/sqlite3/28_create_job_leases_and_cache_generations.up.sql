@@ -0,0 +1,13 @@
+-- Coordination between replicas of a multi-instance deployment: leases let
+-- one replica at a time run each background job, and cache generations tell
+-- replicas when their in-memory caches are stale
+CREATE TABLE IF NOT EXISTS job_leases (
+    job TEXT PRIMARY KEY,
+    holder TEXT NOT NULL,
+    expires_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS cache_generations (
+    name TEXT PRIMARY KEY,
+    generation INTEGER NOT NULL
+);
@@ -0,0 +1,5 @@
+-- Deleted runs are hidden as soon as they are deleted, and their rows and
+-- artifacts are then purged. A run whose purge did not finish stays deleted
+-- until it is purged.
+ALTER TABLE runs ADD COLUMN deleted_at TIMESTAMP;
+CREATE INDEX idx_runs_deleted_at ON runs(deleted_at) WHERE deleted_at IS NOT NULL;
@@ -0,0 +1 @@
+ALTER TABLE artifacts DROP COLUMN content_type;
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS metric_schema_warnings;
+DROP TABLE IF EXISTS experiment_metric_schema;
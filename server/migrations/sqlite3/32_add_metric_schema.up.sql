@@ -0,0 +1,26 @@
+-- Metric schema of an experiment: the metric keys its runs are expected to
+-- log, as exact keys or path.Match patterns, and the range of steps each may
+-- be logged at, unbounded where null. Experiments without one are not checked.
+CREATE TABLE IF NOT EXISTS experiment_metric_schema (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    experiment_id INTEGER NOT NULL,
+    key TEXT NOT NULL,
+    min_step REAL,
+    max_step REAL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(experiment_id, key)
+);
+
+-- Metrics logged outside their experiment's schema. Repeats of a problem with
+-- the same key in a run are counted rather than recorded again.
+CREATE TABLE IF NOT EXISTS metric_schema_warnings (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    run_id INTEGER NOT NULL,
+    key TEXT NOT NULL,
+    kind TEXT NOT NULL,
+    detail TEXT NOT NULL DEFAULT '',
+    count INTEGER NOT NULL DEFAULT 1,
+    first_seen_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    last_seen_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(run_id, key, kind)
+);
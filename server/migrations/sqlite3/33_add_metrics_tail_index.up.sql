@@ -0,0 +1,2 @@
+-- The UNIQUE(run_id, key, x_value) constraint on metrics already indexes
+-- reading a series past a step, so SQLite needs no further index
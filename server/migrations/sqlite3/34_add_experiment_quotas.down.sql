@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS experiment_metric_usage;
+DROP TABLE IF EXISTS experiment_quotas;
@@ -0,0 +1,19 @@
+-- Limits an admin has set on an experiment's usage, overriding the server's
+-- defaults. NULL keeps the default and 0 is unlimited.
+CREATE TABLE IF NOT EXISTS experiment_quotas (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    experiment_id INTEGER NOT NULL UNIQUE,
+    max_runs INTEGER,
+    max_metric_points_per_day INTEGER,
+    max_artifact_bytes INTEGER,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Metric points logged to each experiment per UTC day, for the daily quota
+CREATE TABLE IF NOT EXISTS experiment_metric_usage (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    experiment_id INTEGER NOT NULL,
+    day TEXT NOT NULL,
+    metric_points INTEGER NOT NULL DEFAULT 0,
+    UNIQUE(experiment_id, day)
+);
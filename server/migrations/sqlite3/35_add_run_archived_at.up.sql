@@ -0,0 +1,4 @@
+-- Archived runs are hidden from the home page's run list unless asked for.
+-- Retention can archive old runs, and delete runs archived long enough.
+ALTER TABLE runs ADD COLUMN archived_at TIMESTAMP;
+CREATE INDEX idx_runs_archived_at ON runs(archived_at) WHERE archived_at IS NOT NULL;
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS artifact_versions;
+ALTER TABLE artifacts DROP COLUMN version;
@@ -0,0 +1,10 @@
+DROP INDEX IF EXISTS idx_artifact_versions_scan_status;
+DROP INDEX IF EXISTS idx_artifacts_scan_status;
+ALTER TABLE artifact_versions DROP COLUMN scanned_at;
+ALTER TABLE artifact_versions DROP COLUMN scan_attempts;
+ALTER TABLE artifact_versions DROP COLUMN scan_result;
+ALTER TABLE artifact_versions DROP COLUMN scan_status;
+ALTER TABLE artifacts DROP COLUMN scanned_at;
+ALTER TABLE artifacts DROP COLUMN scan_attempts;
+ALTER TABLE artifacts DROP COLUMN scan_result;
+ALTER TABLE artifacts DROP COLUMN scan_status;
@@ -0,0 +1,4 @@
+ALTER TABLE api_tokens DROP COLUMN project_id;
+DROP INDEX IF EXISTS idx_experiments_project_id;
+ALTER TABLE experiments DROP COLUMN project_id;
+DROP TABLE IF EXISTS projects;
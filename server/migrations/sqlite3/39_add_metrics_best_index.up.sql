@@ -0,0 +1,2 @@
+-- Index for finding the best point of a series, e.g. the lowest loss
+CREATE INDEX IF NOT EXISTS idx_metrics_run_id_key_y_value ON metrics(run_id, key, y_value, x_value);
@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS idx_runs_git_commit;
+DROP INDEX IF EXISTS idx_runs_user_name;
+ALTER TABLE runs DROP COLUMN entrypoint;
+ALTER TABLE runs DROP COLUMN git_dirty;
+ALTER TABLE runs DROP COLUMN git_branch;
+ALTER TABLE runs DROP COLUMN git_commit;
+ALTER TABLE runs DROP COLUMN user_name;
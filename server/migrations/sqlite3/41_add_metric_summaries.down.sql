@@ -0,0 +1,2 @@
+DROP TRIGGER IF EXISTS metric_summaries_insert;
+DROP TABLE IF EXISTS metric_summaries;
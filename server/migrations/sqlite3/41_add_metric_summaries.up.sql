@@ -0,0 +1,45 @@
+-- Summary of each metric series of a run: its last point, the lowest and
+-- highest values and the steps they were first reached at, and how many
+-- points it has. A trigger on metrics keeps it up to date on insert, so run
+-- lists can show final and best values without scanning metrics.
+CREATE TABLE IF NOT EXISTS metric_summaries (
+    run_id INTEGER NOT NULL,
+    key TEXT NOT NULL,
+    last_step REAL,
+    last_value REAL NOT NULL,
+    min_step REAL,
+    min_value REAL NOT NULL,
+    max_step REAL,
+    max_value REAL NOT NULL,
+    point_count INTEGER NOT NULL,
+    PRIMARY KEY (run_id, key)
+);
+
+INSERT INTO metric_summaries (run_id, key, last_step, last_value, min_step, min_value, max_step, max_value, point_count)
+SELECT DISTINCT run_id, key,
+    FIRST_VALUE(x_value) OVER (PARTITION BY run_id, key ORDER BY x_value DESC, id DESC),
+    FIRST_VALUE(y_value) OVER (PARTITION BY run_id, key ORDER BY x_value DESC, id DESC),
+    FIRST_VALUE(x_value) OVER (PARTITION BY run_id, key ORDER BY y_value, x_value),
+    FIRST_VALUE(y_value) OVER (PARTITION BY run_id, key ORDER BY y_value, x_value),
+    FIRST_VALUE(x_value) OVER (PARTITION BY run_id, key ORDER BY y_value DESC, x_value),
+    FIRST_VALUE(y_value) OVER (PARTITION BY run_id, key ORDER BY y_value DESC, x_value),
+    COUNT(*) OVER (PARTITION BY run_id, key)
+FROM metrics;
+
+CREATE TRIGGER IF NOT EXISTS metric_summaries_insert AFTER INSERT ON metrics
+BEGIN
+    INSERT INTO metric_summaries (run_id, key, last_step, last_value, min_step, min_value, max_step, max_value, point_count)
+    VALUES (NEW.run_id, NEW.key, NEW.x_value, NEW.y_value, NEW.x_value, NEW.y_value, NEW.x_value, NEW.y_value, 1)
+    ON CONFLICT (run_id, key) DO UPDATE SET
+        last_step = CASE WHEN excluded.last_step >= metric_summaries.last_step THEN excluded.last_step ELSE metric_summaries.last_step END,
+        last_value = CASE WHEN excluded.last_step >= metric_summaries.last_step THEN excluded.last_value ELSE metric_summaries.last_value END,
+        min_step = CASE WHEN excluded.min_value < metric_summaries.min_value
+            OR (excluded.min_value = metric_summaries.min_value AND excluded.min_step < metric_summaries.min_step)
+            THEN excluded.min_step ELSE metric_summaries.min_step END,
+        min_value = CASE WHEN excluded.min_value < metric_summaries.min_value THEN excluded.min_value ELSE metric_summaries.min_value END,
+        max_step = CASE WHEN excluded.max_value > metric_summaries.max_value
+            OR (excluded.max_value = metric_summaries.max_value AND excluded.max_step < metric_summaries.max_step)
+            THEN excluded.max_step ELSE metric_summaries.max_step END,
+        max_value = CASE WHEN excluded.max_value > metric_summaries.max_value THEN excluded.max_value ELSE metric_summaries.max_value END,
+        point_count = metric_summaries.point_count + 1;
+END;
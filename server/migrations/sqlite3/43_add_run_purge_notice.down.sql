@@ -0,0 +1,2 @@
+ALTER TABLE runs DROP COLUMN purge_notice_sent_at;
+ALTER TABLE runs DROP COLUMN deleted_by;
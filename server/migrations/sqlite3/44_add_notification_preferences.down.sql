@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_web_notifications_user_name;
+DROP TABLE IF EXISTS web_notifications;
+DROP INDEX IF EXISTS idx_notification_preferences_user_name;
+DROP TABLE IF EXISTS notification_preferences;
@@ -0,0 +1,2 @@
+ALTER TABLE api_tokens DROP COLUMN user_id;
+DROP TABLE IF EXISTS users;
@@ -0,0 +1,2 @@
+-- Kiosk tokens show one experiment's wallboard and authorize nothing else
+ALTER TABLE api_tokens ADD COLUMN kiosk_experiment_id INTEGER;
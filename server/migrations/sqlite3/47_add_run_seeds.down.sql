@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS run_seeds;
+ALTER TABLE runs DROP COLUMN seed;
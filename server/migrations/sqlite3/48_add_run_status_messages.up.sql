@@ -0,0 +1,3 @@
+-- The message a run ended with, e.g. the exception that failed it, from
+-- which the experiment page groups failed runs by error signature
+ALTER TABLE runs ADD COLUMN status_message TEXT;
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS metric_alerts;
+DROP TABLE IF EXISTS metric_namespace_settings;
+DROP INDEX IF EXISTS idx_metric_summaries_namespace;
+ALTER TABLE metric_summaries DROP COLUMN name;
+ALTER TABLE metric_summaries DROP COLUMN namespace;
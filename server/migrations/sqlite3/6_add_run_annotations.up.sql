@@ -0,0 +1,10 @@
+-- Text annotations attached to a run at a given step, rendered as markers on charts
+CREATE TABLE IF NOT EXISTS run_annotations (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    run_id INTEGER NOT NULL,
+    step REAL NOT NULL,
+    text TEXT NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_run_annotations_run_id ON run_annotations(run_id);
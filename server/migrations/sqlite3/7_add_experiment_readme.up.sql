@@ -0,0 +1,12 @@
+-- Markdown README describing an experiment's methodology and goals
+ALTER TABLE experiments ADD COLUMN readme TEXT DEFAULT '';
+
+-- Every saved revision of an experiment README, for edit history
+CREATE TABLE IF NOT EXISTS experiment_readme_revisions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    experiment_id INTEGER NOT NULL,
+    readme TEXT NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_experiment_readme_revisions_experiment_id ON experiment_readme_revisions(experiment_id);
@@ -0,0 +1,3 @@
+-- Track blob size and last upload time so the artifact tree can show and sort by them
+ALTER TABLE artifacts ADD COLUMN size_bytes BIGINT DEFAULT 0;
+ALTER TABLE artifacts ADD COLUMN updated_at TIMESTAMP;
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS run_template_parameters;
+DROP TABLE IF EXISTS run_templates;
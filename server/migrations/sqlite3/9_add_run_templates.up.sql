@@ -0,0 +1,21 @@
+-- Named parameter sets saved from a run, used to create new runs
+CREATE TABLE IF NOT EXISTS run_templates (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL UNIQUE,
+    experiment_id INTEGER NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Parameters of a run template; prompt marks values to ask for when creating a run
+CREATE TABLE IF NOT EXISTS run_template_parameters (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    template_id INTEGER NOT NULL,
+    key TEXT NOT NULL,
+    value_type TEXT NOT NULL,
+    value_string TEXT,
+    value_bool INTEGER,
+    value_float REAL,
+    value_int INTEGER,
+    prompt INTEGER NOT NULL DEFAULT 0,
+    UNIQUE(template_id, key)
+);
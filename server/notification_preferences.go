@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Besides the subscriptions set up for an experiment or the whole server,
+// each user chooses which events reach them on which channel on their
+// notification preferences page. Users are who runs are recorded as created
+// by; the web UI has no accounts, so the page is for whichever user the
+// browser says it is.
+
+// notificationChannelWeb delivers notifications to the user's preferences
+// page. Only preferences can use it, since a subscription has no user.
+const notificationChannelWeb = "web"
+
+var notificationPreferenceChannels = []string{notificationChannelEmail, notificationChannelSlack, notificationChannelWeb}
+
+// Events users can choose to be notified of
+const (
+	// notificationPreferenceOwnRunEnded is a run the user created finishing,
+	// failing or being killed
+	notificationPreferenceOwnRunEnded = "own_run_ended"
+	// notificationPreferenceRunFailed is any run failing, in the preference's
+	// experiment if it has one
+	notificationPreferenceRunFailed = "run_failed"
+)
+
+var notificationPreferenceEvents = []string{notificationPreferenceOwnRunEnded, notificationPreferenceRunFailed}
+
+// notificationUserCookie remembers which user a browser's preferences page is
+// for
+const notificationUserCookie = "apparatus_user"
+
+// webNotificationsShown is how many of the latest web notifications the
+// preferences page lists
+const webNotificationsShown = 50
+
+// NotificationPreference is a preference in display form
+type NotificationPreference struct {
+	ID             int
+	Event          string
+	ExperimentUUID string
+	ExperimentName string
+	Channel        string
+	Target         string
+}
+
+// EventDescription describes the preference's event for the preferences page
+func (p NotificationPreference) EventDescription() string {
+	switch p.Event {
+	case notificationPreferenceOwnRunEnded:
+		return "My runs finish, fail or are killed"
+	case notificationPreferenceRunFailed:
+		if p.ExperimentName != "" {
+			return fmt.Sprintf("A run fails in %s", p.ExperimentName)
+		}
+		return "A run fails in any experiment"
+	}
+	return p.Event
+}
+
+// preferenceMatches reports whether a user's preference asks for an event
+func preferenceMatches(pref NotificationPreferenceRow, event NotificationEvent) bool {
+	if pref.ExperimentID.Valid && int(pref.ExperimentID.Int64) != event.ExperimentID {
+		return false
+	}
+	switch pref.Event {
+	case notificationPreferenceOwnRunEnded:
+		ended := event.Event == notificationEventRunFinished || event.Event == notificationEventRunFailed || event.Event == notificationEventRunKilled
+		return ended && event.RunUser != "" && event.RunUser == pref.User
+	case notificationPreferenceRunFailed:
+		return event.Event == notificationEventRunFailed
+	}
+	return false
+}
+
+// preferenceSubscription is the subscription a preference is delivered as.
+// Web notifications are delivered to the preference's user.
+func preferenceSubscription(pref NotificationPreferenceRow) NotificationSubscriptionRow {
+	sub := NotificationSubscriptionRow{Channel: pref.Channel, Target: pref.Target}
+	if pref.Channel == notificationChannelWeb {
+		sub.Target = pref.User
+	}
+	return sub
+}
+
+// validateNotificationPreference checks a preference before it is saved
+func validateNotificationPreference(event, channel, target string) error {
+	if !slices.Contains(notificationPreferenceEvents, event) {
+		return fmt.Errorf("event must be one of %s", strings.Join(notificationPreferenceEvents, ", "))
+	}
+	switch channel {
+	case notificationChannelEmail, notificationChannelSlack:
+		return validateNotificationSubscription(channel, target, nil)
+	case notificationChannelWeb:
+		return nil
+	}
+	return fmt.Errorf("channel must be one of %s", strings.Join(notificationPreferenceChannels, ", "))
+}
+
+// notificationUser is the user a request's preferences page is for: the one
+// named in the URL, or else the one the browser last chose
+func notificationUser(r *http.Request) string {
+	if user := strings.TrimSpace(r.URL.Query().Get("user")); user != "" {
+		return user
+	}
+	if cookie, err := r.Cookie(notificationUserCookie); err == nil {
+		if user, err := url.QueryUnescape(cookie.Value); err == nil {
+			return user
+		}
+	}
+	return ""
+}
+
+// setNotificationUser remembers which user the browser's preferences page is
+// for, or forgets it if user is empty
+func setNotificationUser(w http.ResponseWriter, user string) {
+	if user == "" {
+		http.SetCookie(w, &http.Cookie{Name: notificationUserCookie, Path: "/", MaxAge: -1})
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     notificationUserCookie,
+		Value:    url.QueryEscape(user),
+		Path:     "/",
+		MaxAge:   365 * 24 * 60 * 60,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// getNotificationPreferences loads a user's preferences in display form
+func getNotificationPreferences(ctx context.Context, user string) ([]NotificationPreference, error) {
+	rows, err := dao.GetNotificationPreferences(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var prefs []NotificationPreference
+	for _, row := range rows {
+		if row.User != user {
+			continue
+		}
+		pref := NotificationPreference{ID: row.ID, Event: row.Event, Channel: row.Channel, Target: row.Target}
+		if row.ExperimentID.Valid {
+			experiment, err := dao.GetExperimentByID(ctx, int(row.ExperimentID.Int64))
+			if err != nil {
+				return nil, fmt.Errorf("failed to get experiment %d: %w", row.ExperimentID.Int64, err)
+			}
+			pref.ExperimentUUID, pref.ExperimentName = experiment.UUID, experiment.Name
+		}
+		prefs = append(prefs, pref)
+	}
+	return prefs, nil
+}
+
+// notificationPreferencesTemplates are the templates of the notification
+// preferences page
+var notificationPreferencesTemplates = registerPage("templates/notification_preferences.html")
+
+// handleNotificationPreferences serves a user's notification preferences page
+// at /preferences/notifications. POST to user switches the browser to the
+// user posted, POST adds a preference, and POST to delete/{id} removes one.
+func handleNotificationPreferences(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	action := strings.Trim(strings.TrimPrefix(r.URL.Path, "/preferences/notifications"), "/")
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return nil
+	}
+
+	if r.Method == http.MethodPost && action == "user" {
+		setNotificationUser(w, strings.TrimSpace(r.FormValue("user")))
+		http.Redirect(w, r, "/preferences/notifications", http.StatusSeeOther)
+		return nil
+	}
+	if action != "" && !strings.HasPrefix(action, "delete/") {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "Not found")
+		return nil
+	}
+
+	user := notificationUser(r)
+	// Following a link to someone's page switches the browser to them
+	if r.URL.Query().Get("user") != "" {
+		setNotificationUser(w, user)
+	}
+	var formError string
+	if r.Method == http.MethodPost {
+		if user == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, "Choose a user first")
+			return nil
+		}
+		if idString, ok := strings.CutPrefix(action, "delete/"); ok {
+			id, err := strconv.Atoi(idString)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprintf(w, "Invalid preference id")
+				return nil
+			}
+			if err := dao.DeleteNotificationPreference(ctx, id, user); err != nil {
+				return fmt.Errorf("failed to delete notification preference %d: %w", id, err)
+			}
+			http.Redirect(w, r, "/preferences/notifications", http.StatusSeeOther)
+			return nil
+		}
+
+		pref := NotificationPreferenceRow{
+			User:    user,
+			Event:   r.FormValue("event"),
+			Channel: r.FormValue("channel"),
+			Target:  strings.TrimSpace(r.FormValue("target")),
+		}
+		if pref.Channel == notificationChannelWeb {
+			pref.Target = ""
+		}
+		err := validateNotificationPreference(pref.Event, pref.Channel, pref.Target)
+		if experimentUUID := r.FormValue("experiment_uuid"); err == nil && experimentUUID != "" {
+			var experimentID int
+			if experimentID, err = dao.GetExperimentIDByUUID(ctx, experimentUUID); err != nil {
+				err = fmt.Errorf("experiment not found")
+			}
+			pref.ExperimentID = sql.NullInt64{Int64: int64(experimentID), Valid: true}
+		}
+		if err != nil {
+			formError = err.Error()
+		} else {
+			if err := dao.InsertNotificationPreference(ctx, pref); err != nil {
+				return fmt.Errorf("failed to save notification preference: %w", err)
+			}
+			http.Redirect(w, r, "/preferences/notifications", http.StatusSeeOther)
+			return nil
+		}
+	}
+
+	data := struct {
+		Title             string
+		User              string
+		Preferences       []NotificationPreference
+		WebNotifications  []WebNotificationRow
+		Experiments       []Experiment
+		EmailEnabled      bool
+		NotificationError string
+	}{
+		Title:             "Notification preferences",
+		User:              user,
+		EmailEnabled:      smtpAddr != "",
+		NotificationError: formError,
+	}
+	if user != "" {
+		var err error
+		if data.Preferences, err = getNotificationPreferences(ctx, user); err != nil {
+			return fmt.Errorf("failed to get notification preferences of %s: %w", user, err)
+		}
+		if data.WebNotifications, err = dao.GetWebNotifications(ctx, user, webNotificationsShown); err != nil {
+			return fmt.Errorf("failed to get web notifications of %s: %w", user, err)
+		}
+		if data.Experiments, err = dao.GetAllExperiments(ctx); err != nil {
+			return fmt.Errorf("failed to get experiments: %w", err)
+		}
+	}
+
+	tmpl, err := notificationPreferencesTemplates.Get()
+	if err != nil {
+		return fmt.Errorf("failed to parse template: %w", err)
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if formError != "" {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+	return executeTemplate(w, tmpl, "notification_preferences.html", data)
+}
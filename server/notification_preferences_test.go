@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// preferencesDAO keeps notification preferences and web notifications in
+// memory
+type preferencesDAO struct {
+	DAO
+	prefs            []NotificationPreferenceRow
+	webNotifications []WebNotificationRow
+}
+
+func (d *preferencesDAO) InsertNotificationPreference(ctx context.Context, pref NotificationPreferenceRow) error {
+	pref.ID = len(d.prefs) + 1
+	d.prefs = append(d.prefs, pref)
+	return nil
+}
+
+func (d *preferencesDAO) GetNotificationPreferences(ctx context.Context) ([]NotificationPreferenceRow, error) {
+	return d.prefs, nil
+}
+
+func (d *preferencesDAO) DeleteNotificationPreference(ctx context.Context, id int, user string) error {
+	for i, pref := range d.prefs {
+		if pref.ID == id && pref.User == user {
+			d.prefs = append(d.prefs[:i], d.prefs[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (d *preferencesDAO) InsertWebNotification(ctx context.Context, notification WebNotificationRow) error {
+	d.webNotifications = append(d.webNotifications, notification)
+	return nil
+}
+
+func (d *preferencesDAO) GetWebNotifications(ctx context.Context, user string, limit int) ([]WebNotificationRow, error) {
+	var notifications []WebNotificationRow
+	for _, n := range d.webNotifications {
+		if n.User == user {
+			notifications = append(notifications, n)
+		}
+	}
+	return notifications, nil
+}
+
+func (d *preferencesDAO) GetExperimentIDByUUID(ctx context.Context, uuid string) (int, error) {
+	if uuid == "exp-1" {
+		return 1, nil
+	}
+	return 0, sql.ErrNoRows
+}
+
+func (d *preferencesDAO) GetExperimentByID(ctx context.Context, id int) (*Experiment, error) {
+	return &Experiment{UUID: "exp-1", Name: "Sweep"}, nil
+}
+
+func (d *preferencesDAO) GetAllExperiments(ctx context.Context) ([]Experiment, error) {
+	return []Experiment{{UUID: "exp-1", Name: "Sweep"}}, nil
+}
+
+func TestPreferenceMatches(t *testing.T) {
+	finished := NotificationEvent{Event: notificationEventRunFinished, ExperimentID: 2, RunUser: "alice"}
+	failed := NotificationEvent{Event: notificationEventRunFailed, ExperimentID: 2, RunUser: "bob"}
+	inExperiment := func(id int64) sql.NullInt64 { return sql.NullInt64{Int64: id, Valid: true} }
+
+	tests := []struct {
+		name  string
+		pref  NotificationPreferenceRow
+		event NotificationEvent
+		want  bool
+	}{
+		{"own run finished", NotificationPreferenceRow{User: "alice", Event: notificationPreferenceOwnRunEnded}, finished, true},
+		{"someone else's run finished", NotificationPreferenceRow{User: "bob", Event: notificationPreferenceOwnRunEnded}, finished, false},
+		{"own run created", NotificationPreferenceRow{User: "alice", Event: notificationPreferenceOwnRunEnded}, NotificationEvent{Event: notificationEventRunCreated, RunUser: "alice"}, false},
+		{"run without a user", NotificationPreferenceRow{Event: notificationPreferenceOwnRunEnded}, NotificationEvent{Event: notificationEventRunFinished}, false},
+		{"any run failed", NotificationPreferenceRow{User: "alice", Event: notificationPreferenceRunFailed}, failed, true},
+		{"run failed in the experiment", NotificationPreferenceRow{User: "alice", Event: notificationPreferenceRunFailed, ExperimentID: inExperiment(2)}, failed, true},
+		{"run failed in another experiment", NotificationPreferenceRow{User: "alice", Event: notificationPreferenceRunFailed, ExperimentID: inExperiment(3)}, failed, false},
+		{"run finished without failing", NotificationPreferenceRow{User: "alice", Event: notificationPreferenceRunFailed}, finished, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := preferenceMatches(tt.pref, tt.event); got != tt.want {
+				t.Errorf("preferenceMatches(%+v, %+v) = %v, want %v", tt.pref, tt.event, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandleNotificationPreferences(t *testing.T) {
+	defer func(d DAO) { dao = d }(dao)
+	defer func(addr string) { smtpAddr = addr }(smtpAddr)
+	smtpAddr = ""
+	d := &preferencesDAO{}
+	dao = d
+
+	post := func(path string, form url.Values, user string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if user != "" {
+			r.AddCookie(&http.Cookie{Name: notificationUserCookie, Value: url.QueryEscape(user)})
+		}
+		w := httptest.NewRecorder()
+		errorHandler(handleNotificationPreferences).ServeHTTP(w, r)
+		return w
+	}
+
+	w := post("/preferences/notifications/user", url.Values{"user": {"Alice Smith"}}, "")
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("Expected a redirect after choosing a user, got %d: %s", w.Code, w.Body)
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != notificationUserCookie {
+		t.Fatalf("Expected the user to be remembered, got %v", cookies)
+	}
+	r := httptest.NewRequest(http.MethodGet, "/preferences/notifications", nil)
+	r.AddCookie(cookies[0])
+	if got := notificationUser(r); got != "Alice Smith" {
+		t.Errorf("Expected the remembered user, got %q", got)
+	}
+
+	if w := post("/preferences/notifications", url.Values{"event": {"run_failed"}, "channel": {"web"}}, ""); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 without a user, got %d", w.Code)
+	}
+	if w := post("/preferences/notifications", url.Values{"event": {"run_failed"}, "channel": {"email"}, "target": {"alice@example.com"}}, "alice"); w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "-smtp-addr") {
+		t.Errorf("Expected email preferences rejected without SMTP, got %d: %s", w.Code, w.Body)
+	}
+	w = post("/preferences/notifications", url.Values{"event": {"run_failed"}, "experiment_uuid": {"exp-1"}, "channel": {"web"}, "target": {"ignored"}}, "alice")
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("Expected a redirect after adding a preference, got %d: %s", w.Code, w.Body)
+	}
+	if len(d.prefs) != 1 || d.prefs[0].User != "alice" || d.prefs[0].ExperimentID.Int64 != 1 || d.prefs[0].Target != "" {
+		t.Fatalf("Expected a web preference for alice in the experiment, got %+v", d.prefs)
+	}
+
+	d.webNotifications = append(d.webNotifications, WebNotificationRow{User: "alice", RunUUID: "run-1", Message: `Run "baseline" failed in experiment "Sweep"`})
+	r = httptest.NewRequest(http.MethodGet, "/preferences/notifications?user=alice", nil)
+	w = httptest.NewRecorder()
+	errorHandler(handleNotificationPreferences).ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body)
+	}
+	for _, want := range []string{"A run fails in Sweep", `href="/runs/run-1"`} {
+		if !strings.Contains(w.Body.String(), want) {
+			t.Errorf("Expected the page to contain %q", want)
+		}
+	}
+
+	if w := post("/preferences/notifications/delete/1", nil, "bob"); w.Code != http.StatusSeeOther || len(d.prefs) != 1 {
+		t.Errorf("Expected another user's preference to be kept, got %d with %d preferences", w.Code, len(d.prefs))
+	}
+	if w := post("/preferences/notifications/delete/1", nil, "alice"); w.Code != http.StatusSeeOther || len(d.prefs) != 0 {
+		t.Errorf("Expected the preference to be removed, got %d with %d preferences", w.Code, len(d.prefs))
+	}
+}
+
+func TestDeliverWebNotification(t *testing.T) {
+	ctx := t.Context()
+	defer func(d DAO) { dao = d }(dao)
+	d := &preferencesDAO{}
+	dao = d
+
+	sub := preferenceSubscription(NotificationPreferenceRow{User: "alice", Channel: notificationChannelWeb})
+	event := NotificationEvent{Event: notificationEventRunFinished, RunUUID: "run-1", RunName: "baseline", ExperimentName: "Sweep"}
+	if err := deliverNotification(ctx, sub, event); err != nil {
+		t.Fatalf("web delivery failed: %v", err)
+	}
+	if len(d.webNotifications) != 1 || d.webNotifications[0] != (WebNotificationRow{User: "alice", RunUUID: "run-1", Message: `Run "baseline" finished in experiment "Sweep"`}) {
+		t.Errorf("Expected a web notification for alice, got %+v", d.webNotifications)
+	}
+}
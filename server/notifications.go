@@ -0,0 +1,659 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/smtp"
+	"net/textproto"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Notification channels a subscription can deliver to
+const (
+	notificationChannelWebhook = "webhook"
+	notificationChannelSlack   = "slack"
+	notificationChannelEmail   = "email"
+)
+
+var notificationChannels = []string{notificationChannelWebhook, notificationChannelSlack, notificationChannelEmail}
+
+// Events that subscriptions can be routed on
+const (
+	notificationEventRunCreated  = "run_created"
+	notificationEventRunFinished = "run_finished"
+	notificationEventRunFailed   = "run_failed"
+	notificationEventRunKilled   = "run_killed"
+	notificationEventMetricAlert = "metric_alert"
+)
+
+var notificationEvents = []string{
+	notificationEventRunCreated,
+	notificationEventRunFinished,
+	notificationEventRunFailed,
+	notificationEventRunKilled,
+	notificationEventMetricAlert,
+}
+
+// SMTP relay used for email notifications; email subscriptions are rejected
+// when smtpAddr is empty
+var (
+	smtpAddr string
+	smtpFrom = "apparatus@localhost"
+)
+
+var notificationHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+// sendMail sends email through the SMTP relay, and is replaced in tests
+var sendMail = smtp.SendMail
+
+// NotificationEvent is something that happened to a run that subscribers may
+// be notified about
+type NotificationEvent struct {
+	Event          string
+	RunUUID        string
+	RunName        string
+	ExperimentID   int
+	ExperimentUUID string
+	ExperimentName string
+	// RunUser is who the run was recorded as created by, if anyone
+	RunUser string
+	Tags    []string
+	// Detail is what happened, for events that need more than their name,
+	// e.g. the value a metric alerted at
+	Detail string
+	// ChartPNG is a chart of the run's metrics attached to emails about the
+	// run ending, or nil
+	ChartPNG []byte
+}
+
+// NotificationSubscription is a subscription in display form
+type NotificationSubscription struct {
+	ID      int
+	Channel string
+	Target  string
+	Events  []string
+	Tag     string
+}
+
+// parseNotificationEvents splits a stored comma-separated event list
+func parseNotificationEvents(events string) []string {
+	var parsed []string
+	for _, e := range strings.Split(events, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			parsed = append(parsed, e)
+		}
+	}
+	return parsed
+}
+
+// subscriptionMatches applies a subscription's routing rules to an event: the
+// subscription must be global or scoped to the event's experiment, list the
+// event (or no events, meaning all), and name one of the run's tags if it has
+// a tag filter
+func subscriptionMatches(sub NotificationSubscriptionRow, event NotificationEvent) bool {
+	if sub.ExperimentID.Valid && int(sub.ExperimentID.Int64) != event.ExperimentID {
+		return false
+	}
+	if events := parseNotificationEvents(sub.Events); len(events) > 0 && !slices.Contains(events, event.Event) {
+		return false
+	}
+	if sub.Tag != "" && !slices.Contains(event.Tags, sub.Tag) {
+		return false
+	}
+	return true
+}
+
+// validateNotificationSubscription checks a subscription before it is saved
+func validateNotificationSubscription(channel, target string, events []string) error {
+	switch channel {
+	case notificationChannelWebhook, notificationChannelSlack:
+		u, err := url.Parse(target)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("target must be an http(s) URL")
+		}
+	case notificationChannelEmail:
+		if smtpAddr == "" {
+			return fmt.Errorf("email notifications require the server to be started with -smtp-addr")
+		}
+		if !strings.Contains(target, "@") || strings.ContainsAny(target, "\r\n") {
+			return fmt.Errorf("target must be an email address")
+		}
+	default:
+		return fmt.Errorf("channel must be one of %s", strings.Join(notificationChannels, ", "))
+	}
+
+	for _, e := range events {
+		if !slices.Contains(notificationEvents, e) {
+			return fmt.Errorf("unknown event %q (expected one of %s)", e, strings.Join(notificationEvents, ", "))
+		}
+	}
+	return nil
+}
+
+// notificationText is the human-readable summary of an event used for Slack
+// messages and email subjects
+func notificationText(event NotificationEvent) string {
+	switch event.Event {
+	case notificationEventRunCreated:
+		return fmt.Sprintf("Run %q created in experiment %q", event.RunName, event.ExperimentName)
+	case notificationEventRunFinished:
+		return fmt.Sprintf("Run %q finished in experiment %q", event.RunName, event.ExperimentName)
+	case notificationEventRunFailed:
+		return fmt.Sprintf("Run %q failed in experiment %q", event.RunName, event.ExperimentName)
+	case notificationEventRunKilled:
+		return fmt.Sprintf("Run %q was killed in experiment %q", event.RunName, event.ExperimentName)
+	case notificationEventMetricAlert:
+		return fmt.Sprintf("Run %q in experiment %q: %s", event.RunName, event.ExperimentName, event.Detail)
+	}
+	return fmt.Sprintf("%s: run %q in experiment %q", event.Event, event.RunName, event.ExperimentName)
+}
+
+// notifyRunEvent delivers an event about a run to every matching subscription.
+// Delivery happens in the background so that API calls are not slowed down by
+// slow or unreachable endpoints; failures are logged.
+func notifyRunEvent(ctx context.Context, eventName, runUUID string) {
+	notifyRunEventDetail(ctx, eventName, runUUID, "")
+}
+
+// notifyRunEventDetail delivers an event about a run with what happened, as
+// notifyRunEvent does
+func notifyRunEventDetail(ctx context.Context, eventName, runUUID, detail string) {
+	// Notifications were sent when the replayed requests were first served
+	if replayingJournal {
+		return
+	}
+	run, err := dao.GetRunByUUID(ctx, runUUID)
+	if err != nil {
+		log.Printf("Failed to load run %s for %s notification: %v", runUUID, eventName, err)
+		return
+	}
+	experiment, err := dao.GetExperimentForRunUUID(ctx, runUUID)
+	if err != nil {
+		log.Printf("Failed to load experiment of run %s for %s notification: %v", runUUID, eventName, err)
+		return
+	}
+	experimentID, err := dao.GetExperimentIDByUUID(ctx, experiment.UUID)
+	if err != nil {
+		log.Printf("Failed to load experiment of run %s for %s notification: %v", runUUID, eventName, err)
+		return
+	}
+
+	subs, err := dao.GetNotificationSubscriptions(ctx)
+	if err != nil {
+		log.Printf("Failed to load notification subscriptions: %v", err)
+		return
+	}
+
+	event := NotificationEvent{
+		Event:          eventName,
+		RunUUID:        runUUID,
+		RunName:        run.Name,
+		ExperimentID:   experimentID,
+		ExperimentUUID: experiment.UUID,
+		ExperimentName: experiment.Name,
+		Detail:         detail,
+	}
+	var matched []NotificationSubscriptionRow
+	for _, sub := range subs {
+		if subscriptionMatches(sub, event) {
+			matched = append(matched, sub)
+		}
+	}
+
+	prefs, err := dao.GetNotificationPreferences(ctx)
+	if err != nil {
+		log.Printf("Failed to load notification preferences: %v", err)
+		return
+	}
+	if slices.ContainsFunc(prefs, func(pref NotificationPreferenceRow) bool { return pref.Event == notificationPreferenceOwnRunEnded }) {
+		if runID, err := dao.GetRunIDByUUID(ctx, runUUID); err != nil {
+			log.Printf("Failed to load run %s for %s notification: %v", runUUID, eventName, err)
+		} else if source, err := dao.GetRunSource(ctx, runID); err != nil {
+			log.Printf("Failed to load source of run %s for %s notification: %v", runUUID, eventName, err)
+		} else if source != nil {
+			event.RunUser = source.User
+		}
+	}
+	for _, pref := range prefs {
+		if !preferenceMatches(pref, event) {
+			continue
+		}
+		// Users are told once of an event that also matched a subscription
+		// delivering to the same place
+		sub := preferenceSubscription(pref)
+		if !slices.ContainsFunc(matched, func(m NotificationSubscriptionRow) bool { return m.Channel == sub.Channel && m.Target == sub.Target }) {
+			matched = append(matched, sub)
+		}
+	}
+	if len(matched) == 0 {
+		return
+	}
+	// Notifications are delivered after the request that caused them returns
+	ctx = context.WithoutCancel(ctx)
+	go func() {
+		// Emails about a run ending show how its metrics went, rendered once
+		// for every recipient
+		ended := eventName == notificationEventRunFinished || eventName == notificationEventRunFailed || eventName == notificationEventRunKilled
+		if ended && slices.ContainsFunc(matched, func(sub NotificationSubscriptionRow) bool { return sub.Channel == notificationChannelEmail }) {
+			if runID, err := dao.GetRunIDByUUID(ctx, runUUID); err != nil {
+				log.Printf("Failed to load run %s for %s notification: %v", runUUID, eventName, err)
+			} else if event.ChartPNG, err = renderRunChartPNG(ctx, runID, run.Name); err != nil {
+				log.Printf("Failed to render chart of run %s for %s notification: %v", runUUID, eventName, err)
+			}
+		}
+		for _, sub := range matched {
+			go func(sub NotificationSubscriptionRow) {
+				if err := deliverNotification(ctx, sub, event); err != nil {
+					log.Printf("Failed to deliver %s notification to %s %s: %v", event.Event, sub.Channel, sub.Target, err)
+				}
+			}(sub)
+		}
+	}()
+}
+
+// deliverNotification sends an event to a single subscription, or a user's
+// preference delivered as one
+func deliverNotification(ctx context.Context, sub NotificationSubscriptionRow, event NotificationEvent) error {
+	switch sub.Channel {
+	case notificationChannelWebhook:
+		payload := map[string]interface{}{
+			"event": event.Event,
+			"run": map[string]string{
+				"uuid": event.RunUUID,
+				"name": event.RunName,
+			},
+			"experiment": map[string]string{
+				"uuid": event.ExperimentUUID,
+				"name": event.ExperimentName,
+			},
+		}
+		if event.Detail != "" {
+			payload["detail"] = event.Detail
+		}
+		return postNotificationJSON(sub.Target, payload)
+	case notificationChannelSlack:
+		return postNotificationJSON(sub.Target, map[string]string{"text": notificationText(event)})
+	case notificationChannelEmail:
+		msg, err := notificationEmail(sub.Target, event)
+		if err != nil {
+			return err
+		}
+		return sendMail(smtpAddr, nil, smtpFrom, []string{sub.Target}, msg)
+	case notificationChannelWeb:
+		return dao.InsertWebNotification(ctx, WebNotificationRow{User: sub.Target, RunUUID: event.RunUUID, Message: notificationText(event)})
+	}
+	return fmt.Errorf("unknown notification channel %q", sub.Channel)
+}
+
+// notificationEmail writes the message emailed to a subscriber about an
+// event, with the event's chart attached if it has one
+func notificationEmail(to string, event NotificationEvent) ([]byte, error) {
+	var msg bytes.Buffer
+	msg.WriteString("From: " + smtpFrom + "\r\n" +
+		"To: " + to + "\r\n" +
+		"Subject: [apparatus] " + notificationText(event) + "\r\n")
+	body := notificationText(event) + "\r\n" +
+		"Run: " + event.RunUUID + "\r\n"
+	if event.ChartPNG == nil {
+		msg.WriteString("\r\n" + body)
+		return msg.Bytes(), nil
+	}
+
+	mw := multipart.NewWriter(&msg)
+	msg.WriteString("MIME-Version: 1.0\r\n" +
+		"Content-Type: multipart/mixed; boundary=" + mw.Boundary() + "\r\n" +
+		"\r\n")
+	part, err := mw.CreatePart(textproto.MIMEHeader{"Content-Type": {"text/plain; charset=utf-8"}})
+	if err != nil {
+		return nil, err
+	}
+	io.WriteString(part, body)
+	part, err = mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"image/png"},
+		"Content-Disposition":       {`attachment; filename="chart.png"`},
+		"Content-Transfer-Encoding": {"base64"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	// Lines of base64 are kept to 76 characters, as MIME requires
+	encoded := base64.StdEncoding.EncodeToString(event.ChartPNG)
+	for len(encoded) > 76 {
+		io.WriteString(part, encoded[:76]+"\r\n")
+		encoded = encoded[76:]
+	}
+	io.WriteString(part, encoded+"\r\n")
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+	return msg.Bytes(), nil
+}
+
+func postNotificationJSON(target string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	resp, err := notificationHTTPClient.Post(target, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// getExperimentNotificationSubscriptions loads the subscriptions scoped to an
+// experiment in display form
+func getExperimentNotificationSubscriptions(ctx context.Context, experimentID int) ([]NotificationSubscription, error) {
+	rows, err := dao.GetNotificationSubscriptions(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var subs []NotificationSubscription
+	for _, row := range rows {
+		if !row.ExperimentID.Valid || int(row.ExperimentID.Int64) != experimentID {
+			continue
+		}
+		subs = append(subs, NotificationSubscription{
+			ID:      row.ID,
+			Channel: row.Channel,
+			Target:  row.Target,
+			Events:  parseNotificationEvents(row.Events),
+			Tag:     row.Tag,
+		})
+	}
+	return subs, nil
+}
+
+func handleAPINotificationSubscriptions(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		handleAPIListNotificationSubscriptions(w, r)
+	case http.MethodPost:
+		handleAPICreateNotificationSubscription(w, r)
+	case http.MethodDelete:
+		handleAPIDeleteNotificationSubscription(w, r)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// requestCanAccessSubscription reports whether a request's token may see and
+// delete a notification subscription. Global subscriptions notify of every
+// project's runs, so tokens scoped to a project may only access those of
+// their project's experiments.
+func requestCanAccessSubscription(r *http.Request, sub NotificationSubscriptionRow) (bool, error) {
+	if !sub.ExperimentID.Valid {
+		return tokenProjectID(r) == 0, nil
+	}
+	return requestCanAccessExperiment(r, int(sub.ExperimentID.Int64))
+}
+
+// NotificationSubscriptionDocument is a notification subscription as the API
+// returns it
+type NotificationSubscriptionDocument struct {
+	ID             int      `json:"id"`
+	ExperimentUUID string   `json:"experiment_uuid,omitempty"`
+	Channel        string   `json:"channel"`
+	Target         string   `json:"target"`
+	Events         []string `json:"events"`
+	Tag            string   `json:"tag,omitempty"`
+}
+
+func handleAPIListNotificationSubscriptions(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	rows, err := dao.GetNotificationSubscriptions(ctx)
+	if err != nil {
+		log.Printf("Failed to list notification subscriptions: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to list subscriptions"})
+		return
+	}
+
+	resp := []NotificationSubscriptionDocument{}
+	for _, row := range rows {
+		if ok, err := requestCanAccessSubscription(r, row); err != nil || !ok {
+			continue
+		}
+		sub := NotificationSubscriptionDocument{
+			ID:      row.ID,
+			Channel: row.Channel,
+			Target:  row.Target,
+			Events:  append([]string{}, parseNotificationEvents(row.Events)...),
+			Tag:     row.Tag,
+		}
+		if row.ExperimentID.Valid {
+			experiment, err := dao.GetExperimentByID(ctx, int(row.ExperimentID.Int64))
+			if err == nil {
+				sub.ExperimentUUID = experiment.UUID
+			}
+		}
+		resp = append(resp, sub)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"subscriptions": resp})
+}
+
+// CreateNotificationSubscriptionRequest is the body of POST
+// /api/notifications/subscriptions
+type CreateNotificationSubscriptionRequest struct {
+	ExperimentUUID string   `json:"experiment_uuid"`
+	Channel        string   `json:"channel"`
+	Target         string   `json:"target"`
+	Events         []string `json:"events"`
+	Tag            string   `json:"tag"`
+}
+
+func handleAPICreateNotificationSubscription(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var req CreateNotificationSubscriptionRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid JSON"})
+		return
+	}
+
+	var missing []string
+	if req.Channel == "" {
+		missing = append(missing, "channel")
+	}
+	if req.Target == "" {
+		missing = append(missing, "target")
+	}
+	if req.ExperimentUUID == "" && tokenProjectID(r) != 0 {
+		// Tokens scoped to a project can't subscribe to every project's runs
+		missing = append(missing, "experiment_uuid")
+	}
+
+	if len(missing) > 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":          "Missing required fields",
+			"missing_fields": missing,
+		})
+		return
+	}
+
+	if err := validateNotificationSubscription(req.Channel, req.Target, req.Events); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	// Subscriptions without an experiment are global
+	sub := NotificationSubscriptionRow{
+		Channel: req.Channel,
+		Target:  req.Target,
+		Events:  strings.Join(req.Events, ","),
+		Tag:     strings.TrimSpace(req.Tag),
+	}
+	if req.ExperimentUUID != "" {
+		experimentID, err := dao.GetExperimentIDByUUID(ctx, req.ExperimentUUID)
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Experiment not found"})
+			return
+		}
+		sub.ExperimentID = sql.NullInt64{Int64: int64(experimentID), Valid: true}
+	}
+
+	if err := dao.InsertNotificationSubscription(ctx, sub); err != nil {
+		log.Printf("Failed to create notification subscription: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to create subscription"})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+
+func handleAPIDeleteNotificationSubscription(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Missing or invalid id"})
+		return
+	}
+
+	if tokenProjectID(r) != 0 {
+		rows, err := dao.GetNotificationSubscriptions(ctx)
+		if err != nil {
+			log.Printf("Failed to list notification subscriptions: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to delete subscription"})
+			return
+		}
+		i := slices.IndexFunc(rows, func(row NotificationSubscriptionRow) bool { return row.ID == id })
+		if i < 0 {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Subscription not found"})
+			return
+		}
+		if ok, err := requestCanAccessSubscription(r, rows[i]); err != nil || !ok {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Subscription not found"})
+			return
+		}
+	}
+
+	if err := dao.DeleteNotificationSubscription(ctx, id); err != nil {
+		log.Printf("Failed to delete notification subscription %d: %v", id, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to delete subscription"})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+
+// notificationsTemplates are the templates of the notifications panel of the experiment page
+var notificationsTemplates = registerTemplates("templates/experiment_notifications.html")
+
+// handleExperimentNotifications serves the experiment page's notification
+// subscription panel: POST adds a subscription, POST to delete/{id} removes
+// one, and either re-renders the panel
+func handleExperimentNotifications(w http.ResponseWriter, r *http.Request, experimentUUID, action string) {
+	ctx := r.Context()
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	experimentID, err := dao.GetExperimentIDByUUID(ctx, experimentUUID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "Experiment not found")
+		return
+	}
+
+	var formError string
+	if idString, ok := strings.CutPrefix(action, "delete/"); ok {
+		id, err := strconv.Atoi(idString)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, "Invalid subscription id")
+			return
+		}
+		if err := dao.DeleteNotificationSubscription(ctx, id); err != nil {
+			log.Printf("Failed to delete notification subscription %d: %v", id, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	} else {
+		channel := r.FormValue("channel")
+		target := strings.TrimSpace(r.FormValue("target"))
+		var events []string
+		if event := r.FormValue("event"); event != "" {
+			events = []string{event}
+		}
+		if err := validateNotificationSubscription(channel, target, events); err != nil {
+			formError = err.Error()
+		} else {
+			err := dao.InsertNotificationSubscription(ctx, NotificationSubscriptionRow{
+				ExperimentID: sql.NullInt64{Int64: int64(experimentID), Valid: true},
+				Channel:      channel,
+				Target:       target,
+				Events:       strings.Join(events, ","),
+				Tag:          strings.TrimSpace(r.FormValue("tag")),
+			})
+			if err != nil {
+				log.Printf("Failed to create notification subscription: %v", err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+
+	subs, err := getExperimentNotificationSubscriptions(ctx, experimentID)
+	if err != nil {
+		log.Printf("Failed to load notification subscriptions for experiment %s: %v", experimentUUID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// Return the notifications fragment for htmx to swap in
+	data := struct {
+		ExperimentUUID     string
+		Subscriptions      []NotificationSubscription
+		NotificationEvents []string
+		NotificationError  string
+	}{
+		ExperimentUUID:     experimentUUID,
+		Subscriptions:      subs,
+		NotificationEvents: notificationEvents,
+		NotificationError:  formError,
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	tmpl, err := notificationsTemplates.Get()
+	if err != nil {
+		log.Printf("Failed to parse template: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if err := executeTemplate(w, tmpl, "experiment_notifications", data); err != nil {
+		log.Printf("Failed to render template: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
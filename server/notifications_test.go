@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/mail"
+	"strings"
+	"testing"
+)
+
+func TestSubscriptionMatches(t *testing.T) {
+	event := NotificationEvent{
+		Event:        notificationEventRunCreated,
+		ExperimentID: 2,
+		Tags:         []string{"production"},
+	}
+
+	tests := []struct {
+		name string
+		sub  NotificationSubscriptionRow
+		want bool
+	}{
+		{"global subscription", NotificationSubscriptionRow{}, true},
+		{"scoped to the event's experiment", NotificationSubscriptionRow{ExperimentID: sql.NullInt64{Int64: 2, Valid: true}}, true},
+		{"scoped to another experiment", NotificationSubscriptionRow{ExperimentID: sql.NullInt64{Int64: 3, Valid: true}}, false},
+		{"event listed", NotificationSubscriptionRow{Events: "run_created"}, true},
+		{"event not listed", NotificationSubscriptionRow{Events: "run_failed"}, false},
+		{"tag matches", NotificationSubscriptionRow{Tag: "production"}, true},
+		{"tag does not match", NotificationSubscriptionRow{Tag: "staging"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := subscriptionMatches(tt.sub, event); got != tt.want {
+				t.Errorf("subscriptionMatches(%+v) = %v, want %v", tt.sub, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateNotificationSubscription(t *testing.T) {
+	defer func(addr string) { smtpAddr = addr }(smtpAddr)
+	smtpAddr = ""
+
+	tests := []struct {
+		name    string
+		channel string
+		target  string
+		events  []string
+		wantErr bool
+	}{
+		{"webhook", "webhook", "https://example.com/hook", []string{"run_created"}, false},
+		{"slack", "slack", "https://hooks.slack.com/services/T/B/X", nil, false},
+		{"webhook without scheme", "webhook", "example.com/hook", nil, true},
+		{"unknown channel", "pager", "https://example.com", nil, true},
+		{"unknown event", "webhook", "https://example.com/hook", []string{"run_exploded"}, true},
+		{"email without SMTP", "email", "team@example.com", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateNotificationSubscription(tt.channel, tt.target, tt.events)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("validateNotificationSubscription(%q, %q, %v) = %v, wantErr %v", tt.channel, tt.target, tt.events, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDeliverNotification(t *testing.T) {
+	ctx := t.Context()
+	var received map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&received)
+	}))
+	defer server.Close()
+
+	event := NotificationEvent{
+		Event:          notificationEventRunCreated,
+		RunUUID:        "run-uuid",
+		RunName:        "baseline",
+		ExperimentUUID: "exp-uuid",
+		ExperimentName: "Sweep",
+	}
+
+	err := deliverNotification(ctx, NotificationSubscriptionRow{Channel: notificationChannelWebhook, Target: server.URL}, event)
+	if err != nil {
+		t.Fatalf("webhook delivery failed: %v", err)
+	}
+	if received["event"] != "run_created" || received["run"].(map[string]interface{})["uuid"] != "run-uuid" {
+		t.Errorf("webhook received unexpected payload: %v", received)
+	}
+
+	err = deliverNotification(ctx, NotificationSubscriptionRow{Channel: notificationChannelSlack, Target: server.URL}, event)
+	if err != nil {
+		t.Fatalf("slack delivery failed: %v", err)
+	}
+	if received["text"] != `Run "baseline" created in experiment "Sweep"` {
+		t.Errorf("slack received unexpected payload: %v", received)
+	}
+}
+
+func TestNotificationEmailAttachesChart(t *testing.T) {
+	event := NotificationEvent{
+		Event:          notificationEventRunFinished,
+		RunUUID:        "run-uuid",
+		RunName:        "baseline",
+		ExperimentName: "Sweep",
+	}
+
+	plain, err := notificationEmail("team@example.com", event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := mail.ReadMessage(bytes.NewReader(plain))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body, _ := io.ReadAll(msg.Body); !strings.Contains(string(body), "Run: run-uuid") || msg.Header.Get("Content-Type") != "" {
+		t.Errorf("Expected a plain message without a chart, got %s", plain)
+	}
+
+	event.ChartPNG = bytes.Repeat([]byte("png"), 100)
+	withChart, err := notificationEmail("team@example.com", event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err = mail.ReadMessage(bytes.NewReader(withChart))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Header.Get("Subject") != `[apparatus] Run "baseline" finished in experiment "Sweep"` {
+		t.Errorf("Unexpected subject %q", msg.Header.Get("Subject"))
+	}
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/mixed" {
+		t.Fatalf("Expected a multipart message, got %q", msg.Header.Get("Content-Type"))
+	}
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+	text, err := mr.NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body, _ := io.ReadAll(text); !strings.Contains(string(body), "Run: run-uuid") {
+		t.Errorf("Expected the summary first, got %q", body)
+	}
+	chart, err := mr.NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded, _ := io.ReadAll(chart)
+	decoded, err := base64.StdEncoding.DecodeString(strings.ReplaceAll(string(encoded), "\r\n", ""))
+	if err != nil || !bytes.Equal(decoded, event.ChartPNG) || chart.FileName() != "chart.png" {
+		t.Errorf("Expected the chart attached, got %q (%v)", encoded, err)
+	}
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The API describes itself as an OpenAPI 3 document at /api/openapi.json, for
+// generating typed clients, e.g. with openapi-generator or
+// openapi-typescript. Endpoints are described where they are registered, in
+// api_routes.go, by their operations: methods, query parameters, and values
+// of the Go types of their request and response bodies, from which the
+// document's schemas are generated the way encoding/json would encode them.
+// Endpoints registered without operations, such as the metric stream's
+// WebSocket, are left out.
+
+// APIOperation describes what a method of an API endpoint takes and returns
+type APIOperation struct {
+	Method string
+	// ID is the operation's operationId, which generated clients name their
+	// methods after, e.g. createRun
+	ID      string
+	Summary string
+	Query   []APIParam
+	// Request is a value of the type of the operation's body, or nil if it
+	// takes none
+	Request any
+	// RequestType is the media type of the body if it is not JSON, e.g.
+	// multipart/form-data
+	RequestType string
+	// Response is a value of the type of the operation's response body, or
+	// nil if it is not JSON
+	Response any
+	// ResponseType is the media type of the response if it is not JSON, e.g.
+	// image/png
+	ResponseType string
+}
+
+// APIParam is a query parameter of an API operation
+type APIParam struct {
+	Name        string
+	Description string
+	Required    bool
+}
+
+// apiFields describes a JSON object the way a handler builds it, as a
+// map[string]interface{}, by a value of each field's type
+type apiFields map[string]any
+
+// apiFile is a file in a multipart/form-data body
+type apiFile struct{}
+
+// APIStatus is the response of writes that return nothing else
+type APIStatus struct {
+	Status string `json:"status"`
+}
+
+// apiOperations are the operations of the described API endpoints by
+// unversioned path, e.g. "/api/runs" or "/api/runs/{uuid}/metrics"
+var apiOperations = map[string][]APIOperation{}
+
+// describeAPI describes the operations of an API endpoint. handleAPI does so
+// for the endpoints it registers; endpoints served under a prefix, such as
+// /api/runs/{uuid}, are described by their path with its parameters.
+func describeAPI(pattern string, ops ...APIOperation) {
+	apiOperations[pattern] = append(apiOperations[pattern], ops...)
+}
+
+// apiPathParamPattern matches the parameters of a described path, e.g. {uuid}
+var apiPathParamPattern = regexp.MustCompile(`\{(\w+)\}`)
+
+// openAPISchemas generates the schemas of Go types, collecting those of
+// named structs as components
+type openAPISchemas struct {
+	components map[string]any
+}
+
+// schemaOf generates the schema of a value's type. apiFields are objects with
+// the fields they list.
+func (s *openAPISchemas) schemaOf(v any) map[string]any {
+	switch v := v.(type) {
+	case apiFields:
+		properties := make(map[string]any, len(v))
+		for name, field := range v {
+			properties[name] = s.schemaOf(field)
+		}
+		return map[string]any{"type": "object", "properties": properties}
+	case apiFile:
+		return map[string]any{"type": "string", "format": "binary"}
+	}
+	return s.schema(reflect.TypeOf(v))
+}
+
+// schema generates the schema of a type as encoding/json encodes it
+func (s *openAPISchemas) schema(t reflect.Type) map[string]any {
+	if t == nil {
+		return map[string]any{}
+	}
+	switch t {
+	case reflect.TypeFor[time.Time]():
+		return map[string]any{"type": "string", "format": "date-time"}
+	case reflect.TypeFor[json.RawMessage]():
+		return map[string]any{}
+	case reflect.TypeFor[json.Number]():
+		return map[string]any{"type": "number"}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		schema := s.schema(t.Elem())
+		if _, ok := schema["$ref"]; ok {
+			return map[string]any{"allOf": []any{schema}, "nullable": true}
+		}
+		nullable := map[string]any{"nullable": true}
+		for k, v := range schema {
+			nullable[k] = v
+		}
+		return nullable
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return map[string]any{"type": "integer"}
+	case reflect.Int64, reflect.Uint64:
+		return map[string]any{"type": "integer", "format": "int64"}
+	case reflect.Float32:
+		return map[string]any{"type": "number", "format": "float"}
+	case reflect.Float64:
+		return map[string]any{"type": "number", "format": "double"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]any{"type": "string", "format": "byte"}
+		}
+		return map[string]any{"type": "array", "items": s.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": s.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return s.object(t)
+		}
+		ref := map[string]any{"$ref": "#/components/schemas/" + t.Name()}
+		if _, ok := s.components[t.Name()]; !ok {
+			// Registered before its fields are, so that a type that refers to
+			// itself does not recurse forever
+			s.components[t.Name()] = nil
+			s.components[t.Name()] = s.object(t)
+		}
+		return ref
+	}
+	return map[string]any{}
+}
+
+// object generates the schema of a struct, with the properties of embedded
+// structs inlined
+func (s *openAPISchemas) object(t reflect.Type) map[string]any {
+	properties := map[string]any{}
+	s.addProperties(properties, t)
+	return map[string]any{"type": "object", "properties": properties}
+}
+
+// addProperties adds the properties of a struct's JSON fields
+func (s *openAPISchemas) addProperties(properties map[string]any, t reflect.Type) {
+	for _, field := range reflect.VisibleFields(t) {
+		if len(field.Index) > 1 {
+			// Fields of embedded structs are added with them
+			continue
+		}
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, options, _ := strings.Cut(tag, ",")
+		fieldType := field.Type
+		if field.Anonymous && name == "" {
+			if fieldType.Kind() == reflect.Pointer {
+				fieldType = fieldType.Elem()
+			}
+			if fieldType.Kind() == reflect.Struct {
+				s.addProperties(properties, fieldType)
+				continue
+			}
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		if slices.Contains(strings.Split(options, ","), "string") {
+			properties[name] = map[string]any{"type": "string"}
+			continue
+		}
+		properties[name] = s.schema(fieldType)
+	}
+}
+
+// openAPIDocument generates the OpenAPI document of the described endpoints,
+// at the current API version's paths
+func openAPIDocument() map[string]any {
+	s := &openAPISchemas{components: map[string]any{}}
+	s.components["Error"] = map[string]any{
+		"type":       "object",
+		"properties": map[string]any{"error": map[string]any{"type": "string"}},
+	}
+	version := currentAPIVersion().Version
+
+	patterns := make([]string, 0, len(apiOperations))
+	for pattern := range apiOperations {
+		patterns = append(patterns, pattern)
+	}
+	sort.Strings(patterns)
+	paths := map[string]any{}
+	for _, pattern := range patterns {
+		var pathParams []any
+		for _, m := range apiPathParamPattern.FindAllStringSubmatch(pattern, -1) {
+			pathParams = append(pathParams, map[string]any{
+				"name":     m[1],
+				"in":       "path",
+				"required": true,
+				"schema":   map[string]any{"type": "string"},
+			})
+		}
+		item := map[string]any{}
+		for _, op := range apiOperations[pattern] {
+			item[strings.ToLower(op.Method)] = s.operation(op, pathParams)
+		}
+		paths[fmt.Sprintf("/api/v%d%s", version, strings.TrimPrefix(pattern, "/api"))] = item
+	}
+
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":   "Apparatus API",
+			"version": fmt.Sprintf("v%d", version),
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"schemas": s.components,
+			"responses": map[string]any{
+				"Error": map[string]any{
+					"description": "The request failed",
+					"content": map[string]any{
+						"application/json": map[string]any{"schema": map[string]any{"$ref": "#/components/schemas/Error"}},
+					},
+				},
+			},
+			"securitySchemes": map[string]any{
+				"token": map[string]any{"type": "http", "scheme": "bearer"},
+			},
+		},
+		// Servers that do not require authentication ignore tokens
+		"security": []any{map[string]any{"token": []any{}}, map[string]any{}},
+	}
+}
+
+// operation generates the OpenAPI operation object of an operation
+func (s *openAPISchemas) operation(op APIOperation, pathParams []any) map[string]any {
+	params := append([]any{}, pathParams...)
+	for _, p := range op.Query {
+		param := map[string]any{"name": p.Name, "in": "query", "schema": map[string]any{"type": "string"}}
+		if p.Description != "" {
+			param["description"] = p.Description
+		}
+		if p.Required {
+			param["required"] = true
+		}
+		params = append(params, param)
+	}
+
+	operation := map[string]any{"operationId": op.ID, "summary": op.Summary}
+	if len(params) > 0 {
+		operation["parameters"] = params
+	}
+	if op.Request != nil || op.RequestType != "" {
+		mediaType := cmp.Or(op.RequestType, "application/json")
+		schema := map[string]any{"type": "string", "format": "binary"}
+		if op.Request != nil {
+			schema = s.schemaOf(op.Request)
+		}
+		operation["requestBody"] = map[string]any{
+			"required": true,
+			"content":  map[string]any{mediaType: map[string]any{"schema": schema}},
+		}
+	}
+
+	ok := map[string]any{"description": "OK"}
+	switch {
+	case op.Response != nil:
+		ok["content"] = map[string]any{"application/json": map[string]any{"schema": s.schemaOf(op.Response)}}
+	case op.ResponseType != "":
+		ok["content"] = map[string]any{op.ResponseType: map[string]any{"schema": map[string]any{"type": "string", "format": "binary"}}}
+	}
+	operation["responses"] = map[string]any{
+		"200":     ok,
+		"default": map[string]any{"$ref": "#/components/responses/Error"},
+	}
+	return operation
+}
+
+// handleAPIOpenAPI serves the API's OpenAPI document at GET /api/openapi.json
+func handleAPIOpenAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(openAPIDocument())
+}
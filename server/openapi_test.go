@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+type openAPITestBase struct {
+	ID string `json:"id"`
+}
+
+type openAPITestRun struct {
+	openAPITestBase
+	Name     string            `json:"name"`
+	Parent   *openAPITestRun   `json:"parent,omitempty"`
+	Steps    *int              `json:"steps"`
+	Tags     map[string]string `json:"tags"`
+	Values   []float64         `json:"values"`
+	Created  time.Time         `json:"created"`
+	Count    int64             `json:"count,string"`
+	Internal string            `json:"-"`
+	Untagged bool
+	private  string
+}
+
+func TestOpenAPISchema(t *testing.T) {
+	s := &openAPISchemas{components: map[string]any{}}
+	if got := s.schemaOf(openAPITestRun{}); got["$ref"] != "#/components/schemas/openAPITestRun" {
+		t.Fatalf("Expected a named struct referred to, got %v", got)
+	}
+	properties := s.components["openAPITestRun"].(map[string]any)["properties"].(map[string]any)
+	want := map[string]any{
+		"id":       map[string]any{"type": "string"},
+		"name":     map[string]any{"type": "string"},
+		"parent":   map[string]any{"allOf": []any{map[string]any{"$ref": "#/components/schemas/openAPITestRun"}}, "nullable": true},
+		"steps":    map[string]any{"type": "integer", "nullable": true},
+		"tags":     map[string]any{"type": "object", "additionalProperties": map[string]any{"type": "string"}},
+		"values":   map[string]any{"type": "array", "items": map[string]any{"type": "number", "format": "double"}},
+		"created":  map[string]any{"type": "string", "format": "date-time"},
+		"count":    map[string]any{"type": "string"},
+		"Untagged": map[string]any{"type": "boolean"},
+	}
+	if !reflect.DeepEqual(properties, want) {
+		t.Errorf("Expected properties\n%v\ngot\n%v", want, properties)
+	}
+	if _, ok := s.components["openAPITestBase"]; ok {
+		t.Errorf("Expected the embedded struct's fields inlined, not a component of their own")
+	}
+
+	fields := s.schemaOf(apiFields{"status": "", "runs": []openAPITestRun{}, "file": apiFile{}})
+	wantFields := map[string]any{"type": "object", "properties": map[string]any{
+		"status": map[string]any{"type": "string"},
+		"runs":   map[string]any{"type": "array", "items": map[string]any{"$ref": "#/components/schemas/openAPITestRun"}},
+		"file":   map[string]any{"type": "string", "format": "binary"},
+	}}
+	if !reflect.DeepEqual(fields, wantFields) {
+		t.Errorf("Expected %v, got %v", wantFields, fields)
+	}
+}
+
+func TestHandleAPIOpenAPI(t *testing.T) {
+	defer func(ops map[string][]APIOperation) { apiOperations = ops }(apiOperations)
+	apiOperations = map[string][]APIOperation{}
+	describeAPI("/api/runs/{uuid}/metrics/{key}",
+		APIOperation{Method: http.MethodGet, ID: "getRunMetricSeries", Query: []APIParam{{Name: "max_points"}}, Response: MetricSeries{}})
+	describeAPI("/api/runs/notes",
+		APIOperation{Method: http.MethodPost, ID: "updateRunNotes", Request: UpdateRunNotesRequest{}, Response: APIStatus{}})
+
+	w := httptest.NewRecorder()
+	handleAPIOpenAPI(w, httptest.NewRequest(http.MethodGet, "/api/openapi.json", nil))
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("Expected a JSON document, got %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	var doc struct {
+		OpenAPI string `json:"openapi"`
+		Paths   map[string]map[string]struct {
+			OperationID string `json:"operationId"`
+			Parameters  []struct {
+				Name     string `json:"name"`
+				In       string `json:"in"`
+				Required bool   `json:"required"`
+			} `json:"parameters"`
+			RequestBody struct {
+				Content map[string]struct {
+					Schema map[string]any `json:"schema"`
+				} `json:"content"`
+			} `json:"requestBody"`
+		} `json:"paths"`
+		Components struct {
+			Schemas map[string]any `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.OpenAPI != "3.0.3" || len(doc.Paths) != 2 {
+		t.Fatalf("Expected an OpenAPI 3 document of 2 paths, got %+v", doc)
+	}
+
+	series := doc.Paths["/api/v1/runs/{uuid}/metrics/{key}"]["get"]
+	if series.OperationID != "getRunMetricSeries" || len(series.Parameters) != 3 {
+		t.Fatalf("Expected the series operation at its versioned path with 3 parameters, got %+v", doc.Paths)
+	}
+	if p := series.Parameters[1]; p.Name != "key" || p.In != "path" || !p.Required {
+		t.Errorf("Expected the key path parameter, got %+v", p)
+	}
+	if p := series.Parameters[2]; p.Name != "max_points" || p.In != "query" || p.Required {
+		t.Errorf("Expected the optional max_points query parameter, got %+v", p)
+	}
+	notes := doc.Paths["/api/v1/runs/notes"]["post"]
+	if ref := notes.RequestBody.Content["application/json"].Schema["$ref"]; ref != "#/components/schemas/UpdateRunNotesRequest" {
+		t.Errorf("Expected the notes request body's schema referred to, got %v", ref)
+	}
+	for _, name := range []string{"Error", "MetricSeries", "MetricGap", "UpdateRunNotesRequest", "APIStatus"} {
+		if _, ok := doc.Components.Schemas[name]; !ok {
+			t.Errorf("Expected a %s schema, got %v", name, doc.Components.Schemas)
+		}
+	}
+
+	w = httptest.NewRecorder()
+	handleAPIOpenAPI(w, httptest.NewRequest(http.MethodPost, "/api/openapi.json", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected 405 for POST, got %d", w.Code)
+	}
+}
+
+// TestAPIRoutesOpenAPI checks the description of the API's endpoints: that
+// each operation has its own ID, and that every schema referred to exists
+func TestAPIRoutesOpenAPI(t *testing.T) {
+	defer func(mux *http.ServeMux) { http.DefaultServeMux = mux }(http.DefaultServeMux)
+	defer func(ops map[string][]APIOperation) { apiOperations = ops }(apiOperations)
+	defer func(handlers map[string]http.HandlerFunc) { journaledHandlers = handlers }(journaledHandlers)
+	http.DefaultServeMux = http.NewServeMux()
+	apiOperations = map[string][]APIOperation{}
+	journaledHandlers = map[string]http.HandlerFunc{}
+	registerAPIRoutes()
+
+	data, err := json.Marshal(openAPIDocument())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc struct {
+		Paths      map[string]map[string]struct{ OperationID string } `json:"paths"`
+		Components struct {
+			Schemas map[string]any `json:"schemas"`
+		} `json:"components"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		t.Fatal(err)
+	}
+	ids := map[string]string{}
+	for path, item := range doc.Paths {
+		for method, op := range item {
+			if op.OperationID == "" {
+				t.Errorf("Expected an operation ID for %s %s", method, path)
+			}
+			if other, ok := ids[op.OperationID]; ok {
+				t.Errorf("Expected operation IDs unique, got %s for %s %s and %s", op.OperationID, method, path, other)
+			}
+			ids[op.OperationID] = method + " " + path
+		}
+	}
+	if ids["createRun"] != "post /api/v1/runs" || ids["getRun"] != "get /api/v1/runs/{uuid}" {
+		t.Errorf("Expected createRun and getRun described, got %v", ids)
+	}
+	for _, m := range regexp.MustCompile(`"#/components/schemas/(\w+)"`).FindAllStringSubmatch(string(data), -1) {
+		if _, ok := doc.Components.Schemas[m[1]]; !ok {
+			t.Errorf("Expected the %s schema referred to to exist", m[1])
+		}
+	}
+	if strings.Contains(string(data), "/api/v1/stream") {
+		t.Errorf("Expected the metric stream's WebSocket left out")
+	}
+}
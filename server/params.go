@@ -0,0 +1,518 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// parameterTypes lists the parameter value types from most to least specific.
+// A normalization target is the first type every logged value converts to.
+var parameterTypes = []string{"bool", "int", "float", "string"}
+
+// ParameterTypeCount is the number of runs that logged a parameter as a type
+type ParameterTypeCount struct {
+	Type  string
+	Count int
+}
+
+// ParameterTypeWarning flags a parameter key that was logged with more than
+// one value type across the runs of an experiment, e.g. lr as both "0.001"
+// and 0.001. SuggestedType is the type all existing values can be migrated
+// to; it is empty if no such type exists.
+type ParameterTypeWarning struct {
+	Key           string
+	TypeCounts    []ParameterTypeCount
+	SuggestedType string
+}
+
+// formatParameterValue renders a parameter value as a string regardless of
+// its logged type
+func formatParameterValue(p ParameterRow) string {
+	switch p.ValueType {
+	case "string":
+		return p.ValueString.String
+	case "bool":
+		if p.ValueBool.Bool {
+			return "true"
+		}
+		return "false"
+	case "float":
+		return fmt.Sprintf("%g", p.ValueFloat.Float64)
+	case "int":
+		return fmt.Sprintf("%d", p.ValueInt.Int64)
+	}
+	return ""
+}
+
+// parameterJSONValue is a parameter value as it was logged, for encoding as
+// JSON
+func parameterJSONValue(p ParameterRow) interface{} {
+	switch p.ValueType {
+	case "string":
+		return p.ValueString.String
+	case "bool":
+		return p.ValueBool.Bool
+	case "float":
+		return p.ValueFloat.Float64
+	case "int":
+		return p.ValueInt.Int64
+	}
+	return nil
+}
+
+// parseParameterJSONValue builds a parameter from a logged JSON value: a
+// string, bool, or number. Numbers written without a fraction or exponent are
+// ints. If valueType is set, the value is converted to it.
+func parseParameterJSONValue(raw json.RawMessage, valueType string) (ParameterRow, error) {
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return ParameterRow{}, err
+	}
+
+	var p ParameterRow
+	switch v := value.(type) {
+	case string:
+		p.ValueType = "string"
+		p.ValueString.String, p.ValueString.Valid = v, true
+	case bool:
+		p.ValueType = "bool"
+		p.ValueBool.Bool, p.ValueBool.Valid = v, true
+	case json.Number:
+		if i, err := v.Int64(); err == nil && !strings.ContainsAny(v.String(), ".eE") {
+			p.ValueType = "int"
+			p.ValueInt.Int64, p.ValueInt.Valid = i, true
+		} else {
+			f, err := v.Float64()
+			if err != nil {
+				return p, fmt.Errorf("%s is out of range", v)
+			}
+			p.ValueType = "float"
+			p.ValueFloat.Float64, p.ValueFloat.Valid = f, true
+		}
+	default:
+		return p, fmt.Errorf("value must be a string, bool, or number, got %s", raw)
+	}
+
+	if valueType == "" || valueType == p.ValueType {
+		return p, nil
+	}
+	return convertParameterValue(p, valueType)
+}
+
+// parseParameterQueryValue builds a parameter from the deprecated query
+// parameter form of logging one, where the value is a string to convert to
+// valueType
+func parseParameterQueryValue(value, valueType string) (ParameterRow, error) {
+	p := ParameterRow{ValueType: "string"}
+	p.ValueString.String, p.ValueString.Valid = value, true
+	return convertParameterValue(p, valueType)
+}
+
+// convertParameterValue converts a parameter to targetType. Conversions are
+// strict: a string converts to a number only if it parses as one, a float
+// never silently truncates to an int, and only "true"/"false" become bools.
+func convertParameterValue(p ParameterRow, targetType string) (ParameterRow, error) {
+	converted := ParameterRow{Key: p.Key, ValueType: targetType}
+	value := formatParameterValue(p)
+
+	switch targetType {
+	case "string":
+		converted.ValueString.String, converted.ValueString.Valid = value, true
+	case "bool":
+		if p.ValueType != "bool" && p.ValueType != "string" {
+			return converted, fmt.Errorf("cannot convert %s value %s to bool", p.ValueType, value)
+		}
+		switch strings.ToLower(strings.TrimSpace(value)) {
+		case "true":
+			converted.ValueBool.Bool = true
+		case "false":
+			converted.ValueBool.Bool = false
+		default:
+			return converted, fmt.Errorf("cannot convert %q to bool", value)
+		}
+		converted.ValueBool.Valid = true
+	case "int":
+		if p.ValueType != "int" && p.ValueType != "string" {
+			return converted, fmt.Errorf("cannot convert %s value %s to int", p.ValueType, value)
+		}
+		i, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+		if err != nil {
+			return converted, fmt.Errorf("cannot convert %q to int", value)
+		}
+		converted.ValueInt.Int64, converted.ValueInt.Valid = i, true
+	case "float":
+		if p.ValueType == "bool" {
+			return converted, fmt.Errorf("cannot convert bool value %s to float", value)
+		}
+		f, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			return converted, fmt.Errorf("cannot convert %q to float", value)
+		}
+		converted.ValueFloat.Float64, converted.ValueFloat.Valid = f, true
+	default:
+		return converted, fmt.Errorf("unknown parameter type %q", targetType)
+	}
+
+	return converted, nil
+}
+
+// suggestParameterType returns the most specific type that every value can be
+// converted to, or "" if there is none
+func suggestParameterType(params []ParameterRow) string {
+	for _, candidate := range parameterTypes {
+		ok := true
+		for _, p := range params {
+			if _, err := convertParameterValue(p, candidate); err != nil {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			return candidate
+		}
+	}
+	return ""
+}
+
+// lintParameterTypes returns a warning for every parameter key that was logged
+// with inconsistent types, sorted by key
+func lintParameterTypes(params []ExperimentParameterRow) []ParameterTypeWarning {
+	byKey := make(map[string][]ParameterRow)
+	for _, p := range params {
+		byKey[p.Key] = append(byKey[p.Key], p.ParameterRow)
+	}
+
+	var warnings []ParameterTypeWarning
+	for key, rows := range byKey {
+		counts := make(map[string]int)
+		for _, p := range rows {
+			counts[p.ValueType]++
+		}
+		if len(counts) < 2 {
+			continue
+		}
+
+		warning := ParameterTypeWarning{Key: key, SuggestedType: suggestParameterType(rows)}
+		for _, t := range parameterTypes {
+			if counts[t] > 0 {
+				warning.TypeCounts = append(warning.TypeCounts, ParameterTypeCount{Type: t, Count: counts[t]})
+			}
+		}
+		warnings = append(warnings, warning)
+	}
+
+	sort.Slice(warnings, func(i, j int) bool {
+		return warnings[i].Key < warnings[j].Key
+	})
+	return warnings
+}
+
+// getParameterTypeWarnings lints the parameters of an experiment
+func getParameterTypeWarnings(ctx context.Context, experimentID int) ([]ParameterTypeWarning, error) {
+	params, err := dao.GetParametersByExperimentID(ctx, experimentID)
+	if err != nil {
+		return nil, err
+	}
+	return lintParameterTypes(params), nil
+}
+
+// normalizeExperimentParameter rewrites every value of key in the experiment
+// to targetType. All values are converted before any are written, and are
+// written in one transaction, so either every run is migrated or none is.
+// Runs on hold are never rewritten.
+func normalizeExperimentParameter(ctx context.Context, experimentID int, key, targetType string) error {
+	params, err := dao.GetParametersByExperimentID(ctx, experimentID)
+	if err != nil {
+		return err
+	}
+
+	var updates []ExperimentParameterRow
+	for _, p := range params {
+		if p.Key != key || p.ValueType == targetType {
+			continue
+		}
+		if err := ensureRunNotOnHold(ctx, p.RunID); err != nil {
+			return fmt.Errorf("run %s: %w", p.RunUUID, err)
+		}
+		converted, err := convertParameterValue(p.ParameterRow, targetType)
+		if err != nil {
+			return fmt.Errorf("run %s: %w", p.RunUUID, err)
+		}
+		updates = append(updates, ExperimentParameterRow{RunID: p.RunID, RunUUID: p.RunUUID, ParameterRow: converted})
+	}
+	if len(updates) == 0 {
+		return nil
+	}
+	return dao.UpsertExperimentParameters(ctx, updates)
+}
+
+// parameterValuePointers unpacks a row into the arguments UpsertParameter takes
+func parameterValuePointers(p ParameterRow) (*string, *bool, *float64, *int64) {
+	var valueString *string
+	var valueBool *bool
+	var valueFloat *float64
+	var valueInt *int64
+	if p.ValueString.Valid {
+		valueString = &p.ValueString.String
+	}
+	if p.ValueBool.Valid {
+		valueBool = &p.ValueBool.Bool
+	}
+	if p.ValueFloat.Valid {
+		valueFloat = &p.ValueFloat.Float64
+	}
+	if p.ValueInt.Valid {
+		valueInt = &p.ValueInt.Int64
+	}
+	return valueString, valueBool, valueFloat, valueInt
+}
+
+// maxBatchParameters is the most parameters POST /api/params/batch logs at once
+const maxBatchParameters = 1000
+
+// parseBatchParameters reads the parameters of a batch: either a JSON object
+// of keys to values, or an array of {"key", "value", "type"} objects where
+// type is optional and converts the value as it does for POST /api/params.
+// Parameters of an object are returned sorted by key.
+func parseBatchParameters(raw json.RawMessage) ([]ParameterRow, error) {
+	var params []ParameterRow
+	switch trimmed := bytes.TrimSpace(raw); {
+	case bytes.HasPrefix(trimmed, []byte("{")):
+		var values map[string]json.RawMessage
+		if err := json.Unmarshal(trimmed, &values); err != nil {
+			return nil, err
+		}
+		keys := make([]string, 0, len(values))
+		for key := range values {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			p, err := parseParameterJSONValue(values[key], "")
+			if err != nil {
+				return nil, fmt.Errorf("parameter %q: %v", key, err)
+			}
+			p.Key = key
+			params = append(params, p)
+		}
+	case bytes.HasPrefix(trimmed, []byte("[")):
+		var entries []struct {
+			Key   string          `json:"key"`
+			Value json.RawMessage `json:"value"`
+			Type  string          `json:"type"`
+		}
+		if err := json.Unmarshal(trimmed, &entries); err != nil {
+			return nil, err
+		}
+		seen := make(map[string]bool, len(entries))
+		for i, e := range entries {
+			if e.Key == "" || e.Value == nil {
+				return nil, fmt.Errorf("parameter %d is missing its key or value", i+1)
+			}
+			if seen[e.Key] {
+				return nil, fmt.Errorf("parameter %q is logged more than once", e.Key)
+			}
+			seen[e.Key] = true
+			p, err := parseParameterJSONValue(e.Value, e.Type)
+			if err != nil {
+				return nil, fmt.Errorf("parameter %q: %v", e.Key, err)
+			}
+			p.Key = e.Key
+			params = append(params, p)
+		}
+	default:
+		return nil, fmt.Errorf("params must be an object of keys to values or an array of parameters")
+	}
+	if len(params) == 0 {
+		return nil, fmt.Errorf("params is empty")
+	}
+	if len(params) > maxBatchParameters {
+		return nil, fmt.Errorf("at most %d parameters can be logged at once, got %d", maxBatchParameters, len(params))
+	}
+	for _, p := range params {
+		if p.Key == "" {
+			return nil, fmt.Errorf("parameter keys must not be empty")
+		}
+	}
+	return params, nil
+}
+
+// LogParamsBatchRequest is the body of POST /api/params/batch
+type LogParamsBatchRequest struct {
+	RunUUID string          `json:"run_uuid"`
+	Params  json.RawMessage `json:"params"`
+}
+
+// handleAPILogParamsBatch logs several parameters of a run at once, at POST
+// /api/params/batch, e.g. {"run_uuid": "...", "params": {"lr": 0.001}}. The
+// parameters are logged in one transaction, so if any is invalid none are.
+func handleAPILogParamsBatch(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+
+	var req LogParamsBatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid JSON"})
+		return
+	}
+	var missing []string
+	if req.RunUUID == "" {
+		missing = append(missing, "run_uuid")
+	}
+	if req.Params == nil {
+		missing = append(missing, "params")
+	}
+	if len(missing) > 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":          "Missing required fields",
+			"missing_fields": missing,
+		})
+		return
+	}
+	params, err := parseBatchParameters(req.Params)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": fmt.Sprintf("Invalid parameters: %v", err)})
+		return
+	}
+
+	runID, err := dao.GetRunIDByUUID(ctx, req.RunUUID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Run not found"})
+		return
+	}
+
+	if err := dao.UpsertParameters(ctx, runID, params); err != nil {
+		log.Printf("Error saving parameters of run %s: %v", req.RunUUID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to save parameters"})
+		return
+	}
+	recordRunActivity(ctx, runID)
+
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": "ok", "count": len(params)})
+}
+
+// ParameterTypeCountDocument is how many runs logged a parameter with a type
+type ParameterTypeCountDocument struct {
+	Type  string `json:"type"`
+	Count int    `json:"count"`
+}
+
+// ParameterWarningDocument is a parameter type warning as the API returns it
+type ParameterWarningDocument struct {
+	Key           string                       `json:"key"`
+	Types         []ParameterTypeCountDocument `json:"types"`
+	SuggestedType string                       `json:"suggested_type,omitempty"`
+}
+
+func handleAPIGetParameterWarnings(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	experimentUUID := r.URL.Query().Get("experiment_uuid")
+	if experimentUUID == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Missing required field: experiment_uuid"})
+		return
+	}
+
+	experimentID, err := dao.GetExperimentIDByUUID(ctx, experimentUUID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Experiment not found"})
+		return
+	}
+
+	warnings, err := getParameterTypeWarnings(ctx, experimentID)
+	if err != nil {
+		log.Printf("Failed to lint parameters for experiment %s: %v", experimentUUID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to lint parameters"})
+		return
+	}
+
+	resp := []ParameterWarningDocument{}
+	for _, pw := range warnings {
+		entry := ParameterWarningDocument{Key: pw.Key, SuggestedType: pw.SuggestedType}
+		for _, c := range pw.TypeCounts {
+			entry.Types = append(entry.Types, ParameterTypeCountDocument{Type: c.Type, Count: c.Count})
+		}
+		resp = append(resp, entry)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"warnings": resp})
+}
+
+// parameterWarningsTemplates are the templates of the parameter warnings panel of the experiment page
+var parameterWarningsTemplates = registerTemplates("templates/parameter_warnings.html")
+
+func handleNormalizeExperimentParameter(w http.ResponseWriter, r *http.Request, experimentUUID string) {
+	ctx := r.Context()
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	key := r.FormValue("key")
+	targetType := r.FormValue("type")
+
+	experimentID, err := dao.GetExperimentIDByUUID(ctx, experimentUUID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "Experiment not found")
+		return
+	}
+
+	var migrationError string
+	if err := normalizeExperimentParameter(ctx, experimentID, key, targetType); err != nil {
+		log.Printf("Failed to normalize parameter %s for experiment %s: %v", key, experimentUUID, err)
+		migrationError = fmt.Sprintf("Could not convert %s to %s: %v", key, targetType, err)
+	}
+
+	warnings, err := getParameterTypeWarnings(ctx, experimentID)
+	if err != nil {
+		log.Printf("Failed to lint parameters for experiment %s: %v", experimentUUID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// Return the warnings fragment for htmx to swap in
+	data := struct {
+		ExperimentUUID    string
+		ParameterWarnings []ParameterTypeWarning
+		MigrationError    string
+	}{
+		ExperimentUUID:    experimentUUID,
+		ParameterWarnings: warnings,
+		MigrationError:    migrationError,
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	tmpl, err := parameterWarningsTemplates.Get()
+	if err != nil {
+		log.Printf("Failed to parse template: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if err := executeTemplate(w, tmpl, "parameter_warnings", data); err != nil {
+		log.Printf("Failed to render template: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
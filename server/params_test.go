@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func stringParam(key, v string) ParameterRow {
+	return ParameterRow{Key: key, ValueType: "string", ValueString: sql.NullString{String: v, Valid: true}}
+}
+
+func floatParam(key string, v float64) ParameterRow {
+	return ParameterRow{Key: key, ValueType: "float", ValueFloat: sql.NullFloat64{Float64: v, Valid: true}}
+}
+
+func intParam(key string, v int64) ParameterRow {
+	return ParameterRow{Key: key, ValueType: "int", ValueInt: sql.NullInt64{Int64: v, Valid: true}}
+}
+
+func boolParam(key string, v bool) ParameterRow {
+	return ParameterRow{Key: key, ValueType: "bool", ValueBool: sql.NullBool{Bool: v, Valid: true}}
+}
+
+func TestConvertParameterValue(t *testing.T) {
+	tests := []struct {
+		name       string
+		param      ParameterRow
+		targetType string
+		want       string
+		wantErr    bool
+	}{
+		{"numeric string to float", stringParam("lr", "0.001"), "float", "0.001", false},
+		{"int to float", intParam("epochs", 10), "float", "10", false},
+		{"numeric string to int", stringParam("epochs", " 10 "), "int", "10", false},
+		{"float does not truncate to int", floatParam("epochs", 10), "int", "", true},
+		{"string to bool", stringParam("use_gpu", "True"), "bool", "true", false},
+		{"int does not become bool", intParam("use_gpu", 1), "bool", "", true},
+		{"non-numeric string to float", stringParam("lr", "auto"), "float", "", true},
+		{"float to string", floatParam("lr", 0.5), "string", "0.5", false},
+		{"bool to string", boolParam("use_gpu", false), "string", "false", false},
+		{"unknown type", stringParam("lr", "1"), "complex", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := convertParameterValue(tt.param, tt.targetType)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("convertParameterValue(%+v, %q) succeeded, want error", tt.param, tt.targetType)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("convertParameterValue(%+v, %q) failed: %v", tt.param, tt.targetType, err)
+			}
+			if got.ValueType != tt.targetType || formatParameterValue(got) != tt.want {
+				t.Errorf("convertParameterValue(%+v, %q) = %s %q, want %s %q",
+					tt.param, tt.targetType, got.ValueType, formatParameterValue(got), tt.targetType, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseParameterJSONValue(t *testing.T) {
+	tests := []struct {
+		name      string
+		raw       string
+		valueType string
+		wantType  string
+		want      string
+		wantErr   bool
+	}{
+		{"string with delimiters", `"a=1&b=ü"`, "", "string", "a=1&b=ü", false},
+		{"bool", `true`, "", "bool", "true", false},
+		{"integer", `10`, "", "int", "10", false},
+		{"fraction is float", `10.0`, "", "float", "10", false},
+		{"exponent is float", `1e-3`, "", "float", "0.001", false},
+		{"int converted to float", `3`, "float", "float", "3", false},
+		{"numeric string converted to int", `"3"`, "int", "int", "3", false},
+		{"float does not truncate to int", `3.5`, "int", "", "", true},
+		{"null", `null`, "", "", "", true},
+		{"object", `{"lr": 1}`, "", "", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseParameterJSONValue(json.RawMessage(tt.raw), tt.valueType)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("parseParameterJSONValue(%s, %q) = %+v, want error", tt.raw, tt.valueType, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseParameterJSONValue(%s, %q) failed: %v", tt.raw, tt.valueType, err)
+			}
+			if got.ValueType != tt.wantType || formatParameterValue(got) != tt.want {
+				t.Errorf("parseParameterJSONValue(%s, %q) = %s %q, want %s %q",
+					tt.raw, tt.valueType, got.ValueType, formatParameterValue(got), tt.wantType, tt.want)
+			}
+		})
+	}
+}
+
+func TestLintParameterTypes(t *testing.T) {
+	params := []ExperimentParameterRow{
+		{RunID: 1, RunUUID: "a", ParameterRow: floatParam("lr", 0.001)},
+		{RunID: 2, RunUUID: "b", ParameterRow: stringParam("lr", "0.01")},
+		{RunID: 3, RunUUID: "c", ParameterRow: floatParam("lr", 0.1)},
+		{RunID: 1, RunUUID: "a", ParameterRow: intParam("epochs", 10)},
+		{RunID: 2, RunUUID: "b", ParameterRow: intParam("epochs", 20)},
+		{RunID: 1, RunUUID: "a", ParameterRow: stringParam("optimizer", "adam")},
+		{RunID: 2, RunUUID: "b", ParameterRow: boolParam("optimizer", true)},
+		{RunID: 1, RunUUID: "a", ParameterRow: stringParam("batch_size", "32")},
+		{RunID: 2, RunUUID: "b", ParameterRow: intParam("batch_size", 64)},
+	}
+
+	warnings := lintParameterTypes(params)
+
+	want := []struct {
+		key           string
+		types         []ParameterTypeCount
+		suggestedType string
+	}{
+		{"batch_size", []ParameterTypeCount{{"int", 1}, {"string", 1}}, "int"},
+		{"lr", []ParameterTypeCount{{"float", 2}, {"string", 1}}, "float"},
+		{"optimizer", []ParameterTypeCount{{"bool", 1}, {"string", 1}}, "string"},
+	}
+
+	if len(warnings) != len(want) {
+		t.Fatalf("lintParameterTypes returned %d warnings, want %d: %+v", len(warnings), len(want), warnings)
+	}
+	for i, w := range want {
+		got := warnings[i]
+		if got.Key != w.key || got.SuggestedType != w.suggestedType {
+			t.Errorf("warning %d = %s -> %s, want %s -> %s", i, got.Key, got.SuggestedType, w.key, w.suggestedType)
+		}
+		if len(got.TypeCounts) != len(w.types) {
+			t.Errorf("warning %s type counts = %+v, want %+v", got.Key, got.TypeCounts, w.types)
+			continue
+		}
+		for j := range w.types {
+			if got.TypeCounts[j] != w.types[j] {
+				t.Errorf("warning %s type counts = %+v, want %+v", got.Key, got.TypeCounts, w.types)
+				break
+			}
+		}
+	}
+}
+
+func TestParseBatchParameters(t *testing.T) {
+	params, err := parseBatchParameters(json.RawMessage(`{"optimizer": "adam", "lr": 0.001, "layers": 4}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, p := range params {
+		got = append(got, p.Key+":"+p.ValueType+"="+formatParameterValue(p))
+	}
+	if strings.Join(got, " ") != "layers:int=4 lr:float=0.001 optimizer:string=adam" {
+		t.Errorf("Expected the object's parameters sorted by key, got %v", got)
+	}
+
+	params, err = parseBatchParameters(json.RawMessage(` [{"key": "epochs", "value": 3, "type": "float"}, {"key": "seed", "value": "7", "type": "int"}]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(params) != 2 || params[0].ValueType != "float" || params[1].ValueType != "int" || params[1].ValueInt.Int64 != 7 {
+		t.Errorf("Expected the array's parameters converted to their types, got %+v", params)
+	}
+
+	for _, invalid := range []string{
+		`{}`,
+		`"lr"`,
+		`{"lr": null}`,
+		`{"": 1}`,
+		`[{"key": "lr"}]`,
+		`[{"key": "lr", "value": 1}, {"key": "lr", "value": 2}]`,
+		`[{"key": "lr", "value": 0.5, "type": "int"}]`,
+	} {
+		if _, err := parseBatchParameters(json.RawMessage(invalid)); err == nil {
+			t.Errorf("Expected an error parsing %s", invalid)
+		}
+	}
+}
+
+// batchParamsDAO records the parameters logged to one run
+type batchParamsDAO struct {
+	DAO
+	params []ParameterRow
+}
+
+func (d *batchParamsDAO) GetRunIDByUUID(ctx context.Context, uuid string) (int, error) {
+	if uuid != "run-1" {
+		return 0, sql.ErrNoRows
+	}
+	return 1, nil
+}
+
+func (d *batchParamsDAO) UpsertParameters(ctx context.Context, runID int, params []ParameterRow) error {
+	d.params = append(d.params, params...)
+	return nil
+}
+
+func (d *batchParamsDAO) RecordRunActivity(ctx context.Context, runID int, at time.Time) error {
+	return nil
+}
+
+func TestHandleAPILogParamsBatch(t *testing.T) {
+	defer func(d DAO) { dao = d }(dao)
+	fake := &batchParamsDAO{}
+	dao = fake
+
+	post := func(body string) int {
+		w := httptest.NewRecorder()
+		handleAPILogParamsBatch(w, httptest.NewRequest(http.MethodPost, "/api/params/batch", strings.NewReader(body)))
+		return w.Code
+	}
+	if code := post(`{"run_uuid": "run-1", "params": {"lr": 0.001, "layers": 4}}`); code != http.StatusOK || len(fake.params) != 2 {
+		t.Fatalf("Expected 2 parameters logged, got %d %+v", code, fake.params)
+	}
+	// One invalid parameter fails the whole batch
+	if code := post(`{"run_uuid": "run-1", "params": [{"key": "a", "value": 1}, {"key": "b", "value": [1]}]}`); code != http.StatusBadRequest || len(fake.params) != 2 {
+		t.Errorf("Expected the batch refused, got %d %+v", code, fake.params)
+	}
+	if code := post(`{"run_uuid": "missing", "params": {"lr": 1}}`); code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a missing run, got %d", code)
+	}
+	if code := post(`{"params": {"lr": 1}}`); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 without a run, got %d", code)
+	}
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// A minimal Parquet writer, enough to export tables of required columns
+// without a dependency. Every row group holds one uncompressed, PLAIN-encoded
+// data page per column. See https://github.com/apache/parquet-format for the
+// layout and parquet.thrift for the footer's fields.
+
+// Parquet physical types
+const (
+	parquetInt64     = 2
+	parquetDouble    = 5
+	parquetByteArray = 6
+)
+
+// Parquet converted types
+const (
+	parquetUTF8            = 0
+	parquetTimestampMillis = 9
+)
+
+// parquetRowGroupRows is how many rows are buffered before they are written
+// out as a row group
+const parquetRowGroupRows = 64 * 1024
+
+var parquetMagic = []byte("PAR1")
+
+// parquetColumn describes a required column of a Parquet file.
+// ConvertedType is ignored if it is negative.
+type parquetColumn struct {
+	Name          string
+	Type          int32
+	ConvertedType int32
+}
+
+// parquetColumnChunk is where a column chunk was written, for the footer
+type parquetColumnChunk struct {
+	offset int64
+	size   int64
+	values int64
+}
+
+// parquetRowGroup is a row group already written, for the footer
+type parquetRowGroup struct {
+	rows    int64
+	columns []parquetColumnChunk
+}
+
+// parquetWriter writes rows to a Parquet file. Values are appended column by
+// column for each row; Close must be called to write the footer.
+type parquetWriter struct {
+	w         io.Writer
+	offset    int64
+	columns   []parquetColumn
+	pages     []bytes.Buffer
+	rows      int
+	rowGroups []parquetRowGroup
+	err       error
+}
+
+// newParquetWriter starts a Parquet file with the given columns
+func newParquetWriter(w io.Writer, columns []parquetColumn) *parquetWriter {
+	pw := &parquetWriter{w: w, columns: columns, pages: make([]bytes.Buffer, len(columns))}
+	pw.write(parquetMagic)
+	return pw
+}
+
+func (pw *parquetWriter) write(p []byte) {
+	if pw.err != nil {
+		return
+	}
+	n, err := pw.w.Write(p)
+	pw.offset += int64(n)
+	pw.err = err
+}
+
+// WriteRow appends a row. Values must be int64, float64 or string, matching
+// the physical types of the columns.
+func (pw *parquetWriter) WriteRow(values ...any) error {
+	if len(values) != len(pw.columns) {
+		return fmt.Errorf("parquet: got %d values for %d columns", len(values), len(pw.columns))
+	}
+	for i, v := range values {
+		page := &pw.pages[i]
+		switch pw.columns[i].Type {
+		case parquetInt64:
+			n, ok := v.(int64)
+			if !ok {
+				return fmt.Errorf("parquet: column %s needs an int64, got %T", pw.columns[i].Name, v)
+			}
+			page.Write(binary.LittleEndian.AppendUint64(nil, uint64(n)))
+		case parquetDouble:
+			f, ok := v.(float64)
+			if !ok {
+				return fmt.Errorf("parquet: column %s needs a float64, got %T", pw.columns[i].Name, v)
+			}
+			page.Write(binary.LittleEndian.AppendUint64(nil, math.Float64bits(f)))
+		case parquetByteArray:
+			s, ok := v.(string)
+			if !ok {
+				return fmt.Errorf("parquet: column %s needs a string, got %T", pw.columns[i].Name, v)
+			}
+			page.Write(binary.LittleEndian.AppendUint32(nil, uint32(len(s))))
+			page.WriteString(s)
+		default:
+			return fmt.Errorf("parquet: unsupported type %d of column %s", pw.columns[i].Type, pw.columns[i].Name)
+		}
+	}
+	pw.rows++
+	if pw.rows >= parquetRowGroupRows {
+		return pw.flush()
+	}
+	return pw.err
+}
+
+// flush writes the buffered rows out as a row group
+func (pw *parquetWriter) flush() error {
+	if pw.rows == 0 {
+		return pw.err
+	}
+	group := parquetRowGroup{rows: int64(pw.rows)}
+	for i := range pw.pages {
+		page := &pw.pages[i]
+		var header thriftCompactWriter
+		header.i32(1, 0) // type: DATA_PAGE
+		header.i32(2, int32(page.Len()))
+		header.i32(3, int32(page.Len()))
+		header.beginStruct(5) // data_page_header
+		header.i32(1, int32(pw.rows))
+		header.i32(2, 0) // encoding: PLAIN
+		header.i32(3, 3) // definition_level_encoding: RLE
+		header.i32(4, 3) // repetition_level_encoding: RLE
+		header.endStruct()
+		header.endStruct()
+
+		chunk := parquetColumnChunk{
+			offset: pw.offset,
+			size:   int64(header.buf.Len() + page.Len()),
+			values: int64(pw.rows),
+		}
+		pw.write(header.buf.Bytes())
+		pw.write(page.Bytes())
+		page.Reset()
+		group.columns = append(group.columns, chunk)
+	}
+	pw.rowGroups = append(pw.rowGroups, group)
+	pw.rows = 0
+	return pw.err
+}
+
+// Close writes any buffered rows and the footer. It does not close the
+// underlying writer.
+func (pw *parquetWriter) Close() error {
+	if err := pw.flush(); err != nil {
+		return err
+	}
+
+	var totalRows int64
+	for _, group := range pw.rowGroups {
+		totalRows += group.rows
+	}
+
+	var meta thriftCompactWriter
+	meta.i32(1, 1) // version
+	meta.beginList(2, thriftStruct, len(pw.columns)+1)
+	meta.beginListStruct()
+	meta.binary(4, "schema")
+	meta.i32(5, int32(len(pw.columns)))
+	meta.endStruct()
+	for _, column := range pw.columns {
+		meta.beginListStruct()
+		meta.i32(1, column.Type)
+		meta.i32(3, 0) // repetition_type: REQUIRED
+		meta.binary(4, column.Name)
+		if column.ConvertedType >= 0 {
+			meta.i32(6, column.ConvertedType)
+		}
+		meta.endStruct()
+	}
+	meta.i64(3, totalRows)
+	meta.beginList(4, thriftStruct, len(pw.rowGroups))
+	for _, group := range pw.rowGroups {
+		var groupSize int64
+		meta.beginListStruct()
+		meta.beginList(1, thriftStruct, len(group.columns))
+		for i, chunk := range group.columns {
+			groupSize += chunk.size
+			meta.beginListStruct()
+			meta.i64(2, chunk.offset)
+			meta.beginStruct(3) // meta_data
+			meta.i32(1, pw.columns[i].Type)
+			meta.beginList(2, thriftI32, 1)
+			meta.listVarint(0) // encodings: PLAIN
+			meta.beginList(3, thriftBinary, 1)
+			meta.listBinary(pw.columns[i].Name)
+			meta.i32(4, 0) // codec: UNCOMPRESSED
+			meta.i64(5, chunk.values)
+			meta.i64(6, chunk.size)
+			meta.i64(7, chunk.size)
+			meta.i64(9, chunk.offset)
+			meta.endStruct()
+			meta.endStruct()
+		}
+		meta.i64(2, groupSize)
+		meta.i64(3, group.rows)
+		meta.endStruct()
+	}
+	meta.binary(6, "apparatus")
+	meta.endStruct()
+
+	pw.write(meta.buf.Bytes())
+	pw.write(binary.LittleEndian.AppendUint32(nil, uint32(meta.buf.Len())))
+	pw.write(parquetMagic)
+	return pw.err
+}
+
+// Thrift compact protocol types
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thriftCompactWriter encodes structs with the Thrift compact protocol, which
+// Parquet uses for its page headers and footer. Fields must be written in
+// increasing order of ID within each struct.
+type thriftCompactWriter struct {
+	buf bytes.Buffer
+	// lastField is the last field ID written in each enclosing struct
+	lastField []int16
+}
+
+func (t *thriftCompactWriter) varint(v uint64) {
+	t.buf.Write(binary.AppendUvarint(nil, v))
+}
+
+func (t *thriftCompactWriter) zigzag(v int64) {
+	t.varint(uint64((v << 1) ^ (v >> 63)))
+}
+
+func (t *thriftCompactWriter) fieldHeader(id int16, typ byte) {
+	var last int16
+	if n := len(t.lastField); n > 0 {
+		last = t.lastField[n-1]
+		t.lastField[n-1] = id
+	} else {
+		t.lastField = append(t.lastField, id)
+	}
+	if delta := id - last; delta > 0 && delta <= 15 {
+		t.buf.WriteByte(byte(delta)<<4 | typ)
+		return
+	}
+	t.buf.WriteByte(typ)
+	t.zigzag(int64(id))
+}
+
+func (t *thriftCompactWriter) i32(id int16, v int32) {
+	t.fieldHeader(id, thriftI32)
+	t.zigzag(int64(v))
+}
+
+func (t *thriftCompactWriter) i64(id int16, v int64) {
+	t.fieldHeader(id, thriftI64)
+	t.zigzag(v)
+}
+
+func (t *thriftCompactWriter) binary(id int16, s string) {
+	t.fieldHeader(id, thriftBinary)
+	t.listBinary(s)
+}
+
+// beginStruct starts a struct-valued field
+func (t *thriftCompactWriter) beginStruct(id int16) {
+	t.fieldHeader(id, thriftStruct)
+	t.lastField = append(t.lastField, 0)
+}
+
+// beginListStruct starts a struct that is an element of a list
+func (t *thriftCompactWriter) beginListStruct() {
+	t.lastField = append(t.lastField, 0)
+}
+
+// endStruct ends the innermost struct, or the top-level one
+func (t *thriftCompactWriter) endStruct() {
+	t.buf.WriteByte(0)
+	if n := len(t.lastField); n > 0 {
+		t.lastField = t.lastField[:n-1]
+	}
+}
+
+// beginList starts a list-valued field of n elements, which must follow
+func (t *thriftCompactWriter) beginList(id int16, elemType byte, n int) {
+	t.fieldHeader(id, thriftList)
+	if n < 15 {
+		t.buf.WriteByte(byte(n)<<4 | elemType)
+		return
+	}
+	t.buf.WriteByte(0xf0 | elemType)
+	t.varint(uint64(n))
+}
+
+// listVarint writes an i32 or i64 element of a list
+func (t *thriftCompactWriter) listVarint(v int64) {
+	t.zigzag(v)
+}
+
+// listBinary writes a string element of a list
+func (t *thriftCompactWriter) listBinary(s string) {
+	t.varint(uint64(len(s)))
+	t.buf.WriteString(s)
+}
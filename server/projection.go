@@ -0,0 +1,412 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+)
+
+// Parameters of the UMAP projection. umapA and umapB shape the low
+// dimensional similarity curve for a minimum distance of 0.1 and a spread of
+// 1, the reference implementation's defaults.
+const (
+	umapNeighbors     = 15
+	umapEpochs        = 200
+	umapNegativeRate  = 5
+	umapA             = 1.577
+	umapB             = 0.8951
+	umapPCADimensions = 50
+	umapSeed          = 42
+)
+
+// pcaPowerIterations is the number of block power iterations used to find
+// principal components
+const pcaPowerIterations = 10
+
+// centerRows subtracts the column means from a copy of vectors
+func centerRows(vectors [][]float64) [][]float64 {
+	n, d := len(vectors), len(vectors[0])
+	means := make([]float64, d)
+	for _, v := range vectors {
+		for j, x := range v {
+			means[j] += x / float64(n)
+		}
+	}
+	centered := make([][]float64, n)
+	for i, v := range vectors {
+		centered[i] = make([]float64, d)
+		for j, x := range v {
+			centered[i][j] = x - means[j]
+		}
+	}
+	return centered
+}
+
+// orthonormalizeColumns makes the columns of q (d rows, k columns)
+// orthonormal in place with modified Gram-Schmidt. A column that becomes
+// degenerate is replaced by a random direction.
+func orthonormalizeColumns(q [][]float64, rng *rand.Rand) {
+	d, k := len(q), len(q[0])
+	for c := 0; c < k; c++ {
+		for attempt := 0; ; attempt++ {
+			for p := 0; p < c; p++ {
+				var dot float64
+				for r := 0; r < d; r++ {
+					dot += q[r][c] * q[r][p]
+				}
+				for r := 0; r < d; r++ {
+					q[r][c] -= dot * q[r][p]
+				}
+			}
+			var norm float64
+			for r := 0; r < d; r++ {
+				norm += q[r][c] * q[r][c]
+			}
+			norm = math.Sqrt(norm)
+			if norm > 1e-12 || attempt > 2 {
+				for r := 0; r < d; r++ {
+					if norm > 0 {
+						q[r][c] /= norm
+					}
+				}
+				break
+			}
+			for r := 0; r < d; r++ {
+				q[r][c] = rng.NormFloat64()
+			}
+		}
+	}
+}
+
+// symmetricEigen diagonalizes a small symmetric matrix with the Jacobi method,
+// returning its eigenvalues and the eigenvectors as columns, in descending
+// order of eigenvalue
+func symmetricEigen(m [][]float64) ([]float64, [][]float64) {
+	k := len(m)
+	a := make([][]float64, k)
+	v := make([][]float64, k)
+	for i := range a {
+		a[i] = append([]float64(nil), m[i]...)
+		v[i] = make([]float64, k)
+		v[i][i] = 1
+	}
+
+	for sweep := 0; sweep < 100; sweep++ {
+		var off float64
+		for i := 0; i < k; i++ {
+			for j := i + 1; j < k; j++ {
+				off += a[i][j] * a[i][j]
+			}
+		}
+		if off < 1e-20 {
+			break
+		}
+		for p := 0; p < k; p++ {
+			for q := p + 1; q < k; q++ {
+				if math.Abs(a[p][q]) < 1e-300 {
+					continue
+				}
+				theta := (a[q][q] - a[p][p]) / (2 * a[p][q])
+				t := math.Copysign(1, theta) / (math.Abs(theta) + math.Sqrt(theta*theta+1))
+				c := 1 / math.Sqrt(t*t+1)
+				s := t * c
+				for r := 0; r < k; r++ {
+					arp, arq := a[r][p], a[r][q]
+					a[r][p], a[r][q] = c*arp-s*arq, s*arp+c*arq
+				}
+				for r := 0; r < k; r++ {
+					apr, aqr := a[p][r], a[q][r]
+					a[p][r], a[q][r] = c*apr-s*aqr, s*apr+c*aqr
+				}
+				for r := 0; r < k; r++ {
+					vrp, vrq := v[r][p], v[r][q]
+					v[r][p], v[r][q] = c*vrp-s*vrq, s*vrp+c*vrq
+				}
+			}
+		}
+	}
+
+	order := make([]int, k)
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool { return a[order[i]][order[i]] > a[order[j]][order[j]] })
+	values := make([]float64, k)
+	vectors := make([][]float64, k)
+	for r := range vectors {
+		vectors[r] = make([]float64, k)
+	}
+	for c, o := range order {
+		values[c] = a[o][o]
+		for r := 0; r < k; r++ {
+			vectors[r][c] = v[r][o]
+		}
+	}
+	return values, vectors
+}
+
+// principalComponents projects vectors onto their top k principal components
+// using block power iteration. It returns the projected rows and the fraction
+// of the total variance each component explains.
+func principalComponents(vectors [][]float64, k int) ([][]float64, []float64) {
+	x := centerRows(vectors)
+	n, d := len(x), len(x[0])
+	k = min(k, d, n)
+	rng := rand.New(rand.NewSource(umapSeed))
+
+	var totalVariance float64
+	for _, row := range x {
+		for _, v := range row {
+			totalVariance += v * v
+		}
+	}
+
+	// q holds a d×k basis that converges to the top principal directions
+	q := make([][]float64, d)
+	for r := range q {
+		q[r] = make([]float64, k)
+		for c := range q[r] {
+			q[r][c] = rng.NormFloat64()
+		}
+	}
+	orthonormalizeColumns(q, rng)
+
+	project := func(q [][]float64) [][]float64 {
+		z := make([][]float64, n)
+		for i, row := range x {
+			z[i] = make([]float64, k)
+			for r, v := range row {
+				if v == 0 {
+					continue
+				}
+				for c := 0; c < k; c++ {
+					z[i][c] += v * q[r][c]
+				}
+			}
+		}
+		return z
+	}
+
+	for iter := 0; iter < pcaPowerIterations; iter++ {
+		z := project(q)
+		next := make([][]float64, d)
+		for r := range next {
+			next[r] = make([]float64, k)
+		}
+		for i, row := range x {
+			for r, v := range row {
+				if v == 0 {
+					continue
+				}
+				for c := 0; c < k; c++ {
+					next[r][c] += v * z[i][c]
+				}
+			}
+		}
+		orthonormalizeColumns(next, rng)
+		q = next
+	}
+
+	// Rotate the converged basis onto the principal directions, ordered by
+	// the variance they explain
+	z := project(q)
+	gram := make([][]float64, k)
+	for a := range gram {
+		gram[a] = make([]float64, k)
+		for b := range gram[a] {
+			for i := range z {
+				gram[a][b] += z[i][a] * z[i][b]
+			}
+		}
+	}
+	values, rotation := symmetricEigen(gram)
+
+	projected := make([][]float64, n)
+	for i := range z {
+		projected[i] = make([]float64, k)
+		for c := 0; c < k; c++ {
+			for b := 0; b < k; b++ {
+				projected[i][c] += z[i][b] * rotation[b][c]
+			}
+		}
+	}
+	explained := make([]float64, k)
+	for c, v := range values {
+		if totalVariance > 0 {
+			explained[c] = math.Max(v, 0) / totalVariance
+		}
+	}
+	return projected, explained
+}
+
+// umapGraph builds the symmetric fuzzy nearest-neighbor graph UMAP optimizes,
+// as edges i-j with membership weights
+func umapGraph(x [][]float64, neighbors int) (heads, tails []int, weights []float64) {
+	n := len(x)
+	type neighbor struct {
+		index    int
+		distance float64
+	}
+
+	memberships := make([]map[int]float64, n)
+	target := math.Log2(float64(neighbors))
+	for i := 0; i < n; i++ {
+		candidates := make([]neighbor, 0, n-1)
+		for j := 0; j < n; j++ {
+			if i == j {
+				continue
+			}
+			var dist float64
+			for c := range x[i] {
+				diff := x[i][c] - x[j][c]
+				dist += diff * diff
+			}
+			candidates = append(candidates, neighbor{j, math.Sqrt(dist)})
+		}
+		sort.Slice(candidates, func(a, b int) bool { return candidates[a].distance < candidates[b].distance })
+		nearest := candidates[:neighbors]
+
+		// rho is the distance to the nearest distinct point, and sigma is
+		// chosen so the memberships of the neighbors sum to log2(neighbors)
+		var rho float64
+		for _, nb := range nearest {
+			if nb.distance > 0 {
+				rho = nb.distance
+				break
+			}
+		}
+		lo, hi, sigma := 0.0, math.Inf(1), 1.0
+		for iter := 0; iter < 64; iter++ {
+			var sum float64
+			for _, nb := range nearest {
+				sum += math.Exp(-math.Max(nb.distance-rho, 0) / sigma)
+			}
+			if math.Abs(sum-target) < 1e-5 {
+				break
+			}
+			if sum > target {
+				hi = sigma
+				sigma = (lo + hi) / 2
+			} else {
+				lo = sigma
+				if math.IsInf(hi, 1) {
+					sigma *= 2
+				} else {
+					sigma = (lo + hi) / 2
+				}
+			}
+		}
+
+		memberships[i] = make(map[int]float64, neighbors)
+		for _, nb := range nearest {
+			memberships[i][nb.index] = math.Exp(-math.Max(nb.distance-rho, 0) / sigma)
+		}
+	}
+
+	// Combine the directed memberships with a fuzzy union
+	for i := 0; i < n; i++ {
+		for j, wij := range memberships[i] {
+			if j < i {
+				if _, ok := memberships[j][i]; ok {
+					continue
+				}
+			}
+			wji := memberships[j][i]
+			heads = append(heads, i)
+			tails = append(tails, j)
+			weights = append(weights, wij+wji-wij*wji)
+		}
+	}
+	return heads, tails, weights
+}
+
+// umapProjection embeds vectors in two dimensions with UMAP, starting from
+// their PCA projection. High-dimensional vectors are first reduced to
+// umapPCADimensions with PCA to keep the neighbor search fast.
+func umapProjection(vectors [][]float64) [][]float64 {
+	n := len(vectors)
+	x := vectors
+	if len(vectors[0]) > umapPCADimensions {
+		x, _ = principalComponents(vectors, umapPCADimensions)
+	}
+	neighbors := min(umapNeighbors, n-1)
+	heads, tails, weights := umapGraph(x, neighbors)
+
+	// Initialize from PCA, scaled to a box of side 20 like the reference
+	// implementation's spectral initialization
+	y, _ := principalComponents(vectors, 2)
+	for c := 0; c < 2; c++ {
+		var extent float64
+		for i := range y {
+			if len(y[i]) < 2 {
+				y[i] = append(y[i], 0)
+			}
+			extent = math.Max(extent, math.Abs(y[i][c]))
+		}
+		for i := range y {
+			if extent > 0 {
+				y[i][c] *= 10 / extent
+			}
+		}
+	}
+
+	rng := rand.New(rand.NewSource(umapSeed))
+	for i := range y {
+		// Break ties between coincident points
+		y[i][0] += rng.NormFloat64() * 1e-4
+		y[i][1] += rng.NormFloat64() * 1e-4
+	}
+
+	var maxWeight float64
+	for _, w := range weights {
+		maxWeight = math.Max(maxWeight, w)
+	}
+	epochsPerSample := make([]float64, len(weights))
+	nextSample := make([]float64, len(weights))
+	for e, w := range weights {
+		epochsPerSample[e] = math.Inf(1)
+		if w > 0 {
+			epochsPerSample[e] = maxWeight / w
+		}
+		nextSample[e] = epochsPerSample[e]
+	}
+
+	clip := func(v float64) float64 { return math.Max(-4, math.Min(4, v)) }
+	for epoch := 1; epoch <= umapEpochs; epoch++ {
+		alpha := 1 - float64(epoch-1)/float64(umapEpochs)
+		for e := range weights {
+			if nextSample[e] > float64(epoch) {
+				continue
+			}
+			nextSample[e] += epochsPerSample[e]
+
+			i, j := heads[e], tails[e]
+			dx, dy := y[i][0]-y[j][0], y[i][1]-y[j][1]
+			dist2 := dx*dx + dy*dy
+			if dist2 > 0 {
+				coeff := -2 * umapA * umapB * math.Pow(dist2, umapB-1) / (1 + umapA*math.Pow(dist2, umapB))
+				gx, gy := clip(coeff*dx)*alpha, clip(coeff*dy)*alpha
+				y[i][0] += gx
+				y[i][1] += gy
+				y[j][0] -= gx
+				y[j][1] -= gy
+			}
+
+			for s := 0; s < umapNegativeRate; s++ {
+				k := rng.Intn(n)
+				if k == i {
+					continue
+				}
+				dx, dy := y[i][0]-y[k][0], y[i][1]-y[k][1]
+				dist2 := dx*dx + dy*dy
+				coeff := 2 * umapB / ((0.001 + dist2) * (1 + umapA*math.Pow(dist2, umapB)))
+				if dist2 == 0 {
+					continue
+				}
+				y[i][0] += clip(coeff*dx) * alpha
+				y[i][1] += clip(coeff*dy) * alpha
+			}
+		}
+	}
+	return y
+}
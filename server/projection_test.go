@@ -0,0 +1,94 @@
+package main
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// gaussianClusters draws size points around each center
+func gaussianClusters(centers [][]float64, size int, spread float64) ([][]float64, []int) {
+	rng := rand.New(rand.NewSource(1))
+	var vectors [][]float64
+	var clusters []int
+	for c, center := range centers {
+		for i := 0; i < size; i++ {
+			v := make([]float64, len(center))
+			for j, x := range center {
+				v[j] = x + rng.NormFloat64()*spread
+			}
+			vectors = append(vectors, v)
+			clusters = append(clusters, c)
+		}
+	}
+	return vectors, clusters
+}
+
+func TestSymmetricEigen(t *testing.T) {
+	values, vectors := symmetricEigen([][]float64{{2, 1}, {1, 2}})
+	if math.Abs(values[0]-3) > 1e-9 || math.Abs(values[1]-1) > 1e-9 {
+		t.Fatalf("Expected eigenvalues [3 1], got %v", values)
+	}
+	// The top eigenvector is along (1, 1)
+	if math.Abs(math.Abs(vectors[0][0])-math.Sqrt2/2) > 1e-9 || vectors[0][0]*vectors[1][0] < 0 {
+		t.Errorf("Unexpected top eigenvector (%v, %v)", vectors[0][0], vectors[1][0])
+	}
+}
+
+func TestPrincipalComponents(t *testing.T) {
+	// Points along the direction (1, 2, 2) with a little noise off it
+	rng := rand.New(rand.NewSource(1))
+	var vectors [][]float64
+	for i := 0; i < 50; i++ {
+		s := float64(i - 25)
+		vectors = append(vectors, []float64{s + rng.NormFloat64()*0.01, 2 * s, 2*s + rng.NormFloat64()*0.01})
+	}
+
+	projected, explained := principalComponents(vectors, 2)
+	if len(projected) != 50 || len(projected[0]) != 2 {
+		t.Fatalf("Expected 50 2D points, got %d of %d", len(projected), len(projected[0]))
+	}
+	if explained[0] < 0.999 || explained[0] < explained[1] {
+		t.Errorf("Expected the first component to explain nearly all variance, got %v", explained)
+	}
+	// The first coordinate is the distance along the line, 3 per unit of s
+	if d := math.Abs(projected[49][0] - projected[0][0]); math.Abs(d-3*49) > 0.1 {
+		t.Errorf("Expected the first coordinate to span %v, got %v", 3*49, d)
+	}
+}
+
+func TestPrincipalComponentsOfFewerDimensions(t *testing.T) {
+	projected, explained := principalComponents([][]float64{{1}, {2}, {4}}, 2)
+	if len(projected[0]) != 1 || len(explained) != 1 || math.Abs(explained[0]-1) > 1e-9 {
+		t.Errorf("Expected a single component explaining all variance, got %v %v", projected, explained)
+	}
+}
+
+func TestUMAPProjectionSeparatesClusters(t *testing.T) {
+	centers := [][]float64{make([]float64, 8), make([]float64, 8), make([]float64, 8)}
+	centers[1][0] = 10
+	centers[2][1] = 10
+	vectors, clusters := gaussianClusters(centers, 30, 0.5)
+
+	y := umapProjection(vectors)
+	if len(y) != len(vectors) {
+		t.Fatalf("Expected %d points, got %d", len(vectors), len(y))
+	}
+
+	// Every point's nearest neighbor in the projection is in its own cluster
+	for i := range y {
+		nearest, best := -1, math.Inf(1)
+		for j := range y {
+			if i == j {
+				continue
+			}
+			d := math.Hypot(y[i][0]-y[j][0], y[i][1]-y[j][1])
+			if d < best {
+				nearest, best = j, d
+			}
+		}
+		if clusters[nearest] != clusters[i] {
+			t.Fatalf("Point %d in cluster %d is nearest to point %d in cluster %d", i, clusters[i], nearest, clusters[nearest])
+		}
+	}
+}
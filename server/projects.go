@@ -0,0 +1,509 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// Projects group experiments, and through them runs, of separate teams or
+// tenants. An API token may be scoped to a project, so that it can only see
+// and write that project's experiments and runs, and each project's
+// artifacts are stored under its own key prefix. The web UI shows one project
+// at a time, picked with the switcher in the header.
+
+// defaultProjectUUID is the UUID of the Default project, which experiments
+// belong to unless moved to another
+const defaultProjectUUID = "00000000-0000-0000-0000-000000000000"
+
+// selectedProjectCookie keeps the UUID of the project the web UI shows
+const selectedProjectCookie = "apparatus_project"
+
+// projectScopedRunFields and projectScopedExperimentFields are the request
+// fields, in the query string or at the top level of a JSON body, that name
+// runs and experiments
+var (
+	projectScopedRunFields        = []string{"run_uuid", "parent_run_uuid", "source_run_uuid", "target_run_uuid", "upstream_run_uuid"}
+	projectScopedExperimentFields = []string{"experiment_uuid"}
+)
+
+// errProjectNotFound is returned for a project that does not exist, or that
+// the request's token may not see
+var errProjectNotFound = errors.New("project not found")
+
+type tokenProjectContextKey struct{}
+
+// tokenProjectID is the ID of the project a request's API token is scoped to,
+// or 0 if it may access every project
+func tokenProjectID(r *http.Request) int {
+	id, _ := r.Context().Value(tokenProjectContextKey{}).(int)
+	return id
+}
+
+// findProject looks a project up by its UUID or name
+func findProject(ctx context.Context, ref string) (*ProjectRow, error) {
+	p, err := dao.GetProjectByUUID(ctx, ref)
+	if errors.Is(err, sql.ErrNoRows) {
+		p, err = dao.GetProjectByName(ctx, ref)
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, errProjectNotFound
+	}
+	return p, err
+}
+
+// apiProjectID is the ID of the project an API request is limited to: its
+// token's project, or else the one named by its project query parameter, or
+// 0 for every project
+func apiProjectID(r *http.Request) (int, error) {
+	scoped := tokenProjectID(r)
+	ref := r.URL.Query().Get("project")
+	if ref == "" {
+		return scoped, nil
+	}
+	p, err := findProject(r.Context(), ref)
+	if err != nil {
+		return 0, err
+	}
+	if scoped != 0 && p.ID != scoped {
+		return 0, errProjectNotFound
+	}
+	return p.ID, nil
+}
+
+// writeProjectError responds to a request naming a project that could not be
+// found, returning false if err is nil
+func writeProjectError(w http.ResponseWriter, err error) bool {
+	if err == nil {
+		return false
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if errors.Is(err, errProjectNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Project not found"})
+		return true
+	}
+	log.Printf("Failed to look up project: %v", err)
+	w.WriteHeader(http.StatusInternalServerError)
+	json.NewEncoder(w).Encode(map[string]string{"error": "Failed to look up project"})
+	return true
+}
+
+// projectScopeMiddleware keeps a request authorized by a token scoped to a
+// project within that project. Runs and experiments of other projects the
+// request names, in its path, query string or JSON body, are refused as if
+// they did not exist. Handlers that take runs or experiments elsewhere, e.g.
+// from multipart forms, check them with requestCanAccessRun and
+// requestCanAccessExperiment.
+func projectScopeMiddleware(projectID int, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r = r.WithContext(context.WithValue(r.Context(), tokenProjectContextKey{}, projectID))
+
+		var body []byte
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if r.Body != nil && mediaType == "application/json" {
+			var err error
+			body, err = io.ReadAll(io.LimitReader(r.Body, journalMaxBodyBytes+1))
+			if err != nil {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]string{"error": "Failed to read request body"})
+				return
+			}
+			if len(body) > journalMaxBodyBytes {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusRequestEntityTooLarge)
+				json.NewEncoder(w).Encode(map[string]string{"error": "Request body is too large"})
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+		}
+
+		runUUIDs, experimentUUIDs := projectScopedUUIDs(r, body)
+		message, err := checkProjectScope(r.Context(), projectID, runUUIDs, experimentUUIDs)
+		if err != nil {
+			log.Printf("Failed to check the project of %s %s: %v", r.Method, r.URL.Path, err)
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to check project"})
+			return
+		}
+		if message != "" {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{"error": message})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// projectScopedUUIDs collects the run and experiment UUIDs a request names:
+// the run of a per-run path such as /api/runs/{uuid}/notes, and the
+// projectScoped fields of its query string and JSON body
+func projectScopedUUIDs(r *http.Request, body []byte) (runUUIDs, experimentUUIDs []string) {
+	if rest, ok := strings.CutPrefix(unversionedAPIPath(r.URL.Path), "/api/runs/"); ok {
+		if runUUID, _, _ := strings.Cut(rest, "/"); runUUID != "" {
+			runUUIDs = append(runUUIDs, runUUID)
+		}
+	}
+
+	var fields map[string]json.RawMessage
+	if len(body) > 0 {
+		// Bodies that are not JSON objects are left to the handler to refuse
+		json.Unmarshal(body, &fields)
+	}
+	collect := func(names []string) []string {
+		var uuids []string
+		for _, name := range names {
+			if v := r.URL.Query().Get(name); v != "" {
+				uuids = append(uuids, v)
+			}
+			var v string
+			if raw, ok := fields[name]; ok && json.Unmarshal(raw, &v) == nil && v != "" {
+				uuids = append(uuids, v)
+			}
+		}
+		return uuids
+	}
+	return append(runUUIDs, collect(projectScopedRunFields)...), collect(projectScopedExperimentFields)
+}
+
+// checkProjectScope checks that runs and experiments belong to a project,
+// returning a message for the client if one does not. Those that do not exist
+// are left to the handler.
+func checkProjectScope(ctx context.Context, projectID int, runUUIDs, experimentUUIDs []string) (string, error) {
+	for _, runUUID := range runUUIDs {
+		runID, err := dao.GetRunIDByUUID(ctx, runUUID)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		runProjectID, err := dao.GetRunProjectID(ctx, runID)
+		if err != nil {
+			return "", err
+		}
+		if runProjectID != projectID {
+			return "Run not found", nil
+		}
+	}
+	for _, experimentUUID := range experimentUUIDs {
+		experiment, err := dao.GetExperimentByUUID(ctx, experimentUUID)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		if experiment.ProjectID != projectID {
+			return "Experiment not found", nil
+		}
+	}
+	return "", nil
+}
+
+// requestCanAccessRun reports whether a request's token may access a run
+func requestCanAccessRun(r *http.Request, runID int) (bool, error) {
+	scoped := tokenProjectID(r)
+	if scoped == 0 {
+		return true, nil
+	}
+	projectID, err := dao.GetRunProjectID(r.Context(), runID)
+	if err != nil {
+		return false, err
+	}
+	return projectID == scoped, nil
+}
+
+// requestCanAccessExperiment reports whether a request's token may access an
+// experiment
+func requestCanAccessExperiment(r *http.Request, experimentID int) (bool, error) {
+	scoped := tokenProjectID(r)
+	if scoped == 0 {
+		return true, nil
+	}
+	experiment, err := dao.GetExperimentByID(r.Context(), experimentID)
+	if err != nil {
+		return false, err
+	}
+	return experiment.ProjectID == scoped, nil
+}
+
+// runArtifactKey is the key under which a run's artifacts are stored: its
+// UUID, beneath its project's artifact prefix if the project has one
+func runArtifactKey(ctx context.Context, runID int, runUUID string) (string, error) {
+	projectID, err := dao.GetRunProjectID(ctx, runID)
+	if err != nil {
+		return "", err
+	}
+	project, err := dao.GetProjectByID(ctx, projectID)
+	if err != nil {
+		return "", err
+	}
+	if project.ArtifactPrefix == "" {
+		return runUUID, nil
+	}
+	return project.ArtifactPrefix + "/" + runUUID, nil
+}
+
+// ProjectDocument is a project as returned by the API
+type ProjectDocument struct {
+	UUID           string `json:"uuid"`
+	Name           string `json:"name"`
+	ArtifactPrefix string `json:"artifact_prefix"`
+	CreatedAt      string `json:"created_at"`
+}
+
+func newProjectDocument(p ProjectRow) ProjectDocument {
+	return ProjectDocument{UUID: p.UUID, Name: p.Name, ArtifactPrefix: p.ArtifactPrefix, CreatedAt: p.CreatedAt.UTC().Format("2006-01-02T15:04:05Z")}
+}
+
+// CreateProjectRequest is the body of POST /api/projects
+type CreateProjectRequest struct {
+	Name           string  `json:"name"`
+	ArtifactPrefix *string `json:"artifact_prefix"`
+}
+
+// handleAPIProjects lists the projects the request's token may see (GET), or
+// creates a project (POST, admin only) from {"name", "artifact_prefix"}. The
+// artifact prefix defaults to projects/{uuid}.
+func handleAPIProjects(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	w.Header().Set("Content-Type", "application/json")
+	switch r.Method {
+	case http.MethodGet:
+		projects, err := dao.GetAllProjects(ctx)
+		if err != nil {
+			log.Printf("Failed to list projects: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to list projects"})
+			return
+		}
+		scoped := tokenProjectID(r)
+		resp := []ProjectDocument{}
+		for _, p := range projects {
+			if scoped == 0 || p.ID == scoped {
+				resp = append(resp, newProjectDocument(p))
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"projects": resp})
+	case http.MethodPost:
+		if !requireAdmin(w, r) {
+			return
+		}
+		var req CreateProjectRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Invalid JSON"})
+			return
+		}
+		name := strings.TrimSpace(req.Name)
+		if name == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"error":          "Missing required fields",
+				"missing_fields": []string{"name"},
+			})
+			return
+		}
+		projectUUID := newUUID(ctx)
+		prefix := "projects/" + projectUUID
+		if req.ArtifactPrefix != nil {
+			prefix = strings.Trim(*req.ArtifactPrefix, "/")
+		}
+		if prefix != "" {
+			if err := isValidArtifactPath(prefix); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]string{"error": fmt.Sprintf("Invalid artifact prefix: %v", err)})
+				return
+			}
+		}
+		if _, err := findProject(ctx, name); !errors.Is(err, errProjectNotFound) {
+			if writeProjectError(w, err) {
+				return
+			}
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(map[string]string{"error": "A project with this name already exists"})
+			return
+		}
+		if err := dao.InsertProject(ctx, projectUUID, name, prefix); err != nil {
+			log.Printf("Failed to create project %q: %v", name, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to create project"})
+			return
+		}
+		recordAudit(ctx, "create_project", name, map[string]interface{}{"uuid": projectUUID, "artifact_prefix": prefix})
+		project, err := dao.GetProjectByUUID(ctx, projectUUID)
+		if err != nil {
+			writeProjectError(w, err)
+			return
+		}
+		json.NewEncoder(w).Encode(newProjectDocument(*project))
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// MoveExperimentRequest is the body of POST /api/admin/projects/experiments
+type MoveExperimentRequest struct {
+	ExperimentUUID string `json:"experiment_uuid"`
+	Project        string `json:"project"`
+}
+
+// handleAPIMoveExperiment moves an experiment, with its runs, to another
+// project at POST /api/admin/projects/experiments, e.g. {"experiment_uuid":
+// "...", "project": "vision"}. Artifacts already logged keep their keys.
+func handleAPIMoveExperiment(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if !requireAdmin(w, r) {
+		return
+	}
+	var req MoveExperimentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid JSON"})
+		return
+	}
+	var missing []string
+	if req.ExperimentUUID == "" {
+		missing = append(missing, "experiment_uuid")
+	}
+	if req.Project == "" {
+		missing = append(missing, "project")
+	}
+	if len(missing) > 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":          "Missing required fields",
+			"missing_fields": missing,
+		})
+		return
+	}
+
+	experimentID, err := dao.GetExperimentIDByUUID(ctx, req.ExperimentUUID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Experiment not found"})
+		return
+	}
+	project, err := findProject(ctx, req.Project)
+	if writeProjectError(w, err) {
+		return
+	}
+	if err := dao.SetExperimentProject(ctx, experimentID, project.ID); err != nil {
+		log.Printf("Failed to move experiment %s to project %s: %v", req.ExperimentUUID, project.Name, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to move experiment"})
+		return
+	}
+	homeCache.invalidate()
+	recordAudit(ctx, "move_experiment", req.ExperimentUUID, map[string]interface{}{"project": project.Name})
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+
+// selectedProjectID is the ID of the project the web UI shows, picked with
+// the header's switcher, or 0 for every project
+func selectedProjectID(r *http.Request) int {
+	cookie, err := r.Cookie(selectedProjectCookie)
+	if err != nil || cookie.Value == "" {
+		return 0
+	}
+	project, err := dao.GetProjectByUUID(r.Context(), cookie.Value)
+	if err != nil {
+		return 0
+	}
+	return project.ID
+}
+
+// ProjectSwitcher is the project picker shown in every page's header
+type ProjectSwitcher struct {
+	Projects []ProjectRow
+	Selected string
+}
+
+// projectSwitcherTemplates are the templates of the header's project switcher
+var projectSwitcherTemplates = registerTemplates("templates/project_switcher.html")
+
+// handleProjectSwitcher renders the header's project switcher, which the
+// header loads with htmx. There is nothing to switch between until a second
+// project is created.
+func handleProjectSwitcher(w http.ResponseWriter, r *http.Request) error {
+	projects, err := dao.GetAllProjects(r.Context())
+	if err != nil {
+		return fmt.Errorf("failed to list projects: %w", err)
+	}
+	if len(projects) < 2 {
+		return nil
+	}
+	switcher := ProjectSwitcher{Projects: projects}
+	if cookie, err := r.Cookie(selectedProjectCookie); err == nil {
+		switcher.Selected = cookie.Value
+	}
+	tmpl, err := projectSwitcherTemplates.Get()
+	if err != nil {
+		return fmt.Errorf("failed to parse template: %w", err)
+	}
+	return executeTemplate(w, tmpl, "project_switcher.html", switcher)
+}
+
+// handleSelectProject saves the project posted from the header's switcher as
+// the one the web UI shows, or shows every project if none is posted, then
+// sends the browser back to the home page
+func handleSelectProject(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	projectUUID := r.FormValue("project")
+	if projectUUID == "" {
+		http.SetCookie(w, &http.Cookie{Name: selectedProjectCookie, Path: "/", MaxAge: -1})
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+	if _, err := dao.GetProjectByUUID(r.Context(), projectUUID); err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "Project not found")
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     selectedProjectCookie,
+		Value:    projectUUID,
+		Path:     "/",
+		MaxAge:   365 * 24 * 60 * 60,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// experimentsInProject keeps the experiments of a project, or all of them if
+// projectID is 0
+func experimentsInProject(experiments []Experiment, projectID int) []Experiment {
+	if projectID == 0 {
+		return experiments
+	}
+	var kept []Experiment
+	for _, e := range experiments {
+		if e.ProjectID == projectID {
+			kept = append(kept, e)
+		}
+	}
+	return kept
+}
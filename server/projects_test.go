@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// defaultProjectDAO puts every run in the Default project, which has no
+// artifact prefix, for fakes of handlers that store or purge artifacts
+type defaultProjectDAO struct {
+	DAO
+}
+
+func (d defaultProjectDAO) GetRunProjectID(ctx context.Context, runID int) (int, error) {
+	return 1, nil
+}
+
+func (d defaultProjectDAO) GetProjectByID(ctx context.Context, id int) (*ProjectRow, error) {
+	return &ProjectRow{ID: 1, UUID: defaultProjectUUID, Name: "Default"}, nil
+}
+
+// projectScopeDAO has runs and experiments in two projects, and a token
+// scoped to project 2
+type projectScopeDAO struct {
+	apiTokenDAO
+	runProjects map[string]int
+	experiments map[string]Experiment
+}
+
+func newProjectScopeDAO() *projectScopeDAO {
+	return &projectScopeDAO{
+		apiTokenDAO: apiTokenDAO{tokens: map[string]APITokenRow{
+			hashAPIToken("vision"): {Name: "vision-ci", ProjectID: sql.NullInt64{Int64: 2, Valid: true}},
+		}},
+		runProjects: map[string]int{"default-run": 1, "vision-run": 2},
+		experiments: map[string]Experiment{
+			"default-exp": {UUID: "default-exp", ProjectID: 1},
+			"vision-exp":  {UUID: "vision-exp", ProjectID: 2},
+		},
+	}
+}
+
+func (d *projectScopeDAO) GetRunIDByUUID(ctx context.Context, uuid string) (int, error) {
+	if _, ok := d.runProjects[uuid]; !ok {
+		return 0, sql.ErrNoRows
+	}
+	return len(uuid), nil
+}
+
+func (d *projectScopeDAO) GetRunProjectID(ctx context.Context, runID int) (int, error) {
+	for uuid, projectID := range d.runProjects {
+		if len(uuid) == runID {
+			return projectID, nil
+		}
+	}
+	return 0, sql.ErrNoRows
+}
+
+func (d *projectScopeDAO) GetExperimentByUUID(ctx context.Context, uuid string) (*Experiment, error) {
+	if e, ok := d.experiments[uuid]; ok {
+		return &e, nil
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (d *projectScopeDAO) GetAllProjects(ctx context.Context) ([]ProjectRow, error) {
+	return []ProjectRow{
+		{ID: 1, UUID: defaultProjectUUID, Name: "Default"},
+		{ID: 2, UUID: "vision-uuid", Name: "vision", ArtifactPrefix: "projects/vision"},
+	}, nil
+}
+
+func (d *projectScopeDAO) GetProjectByID(ctx context.Context, id int) (*ProjectRow, error) {
+	projects, _ := d.GetAllProjects(ctx)
+	return &projects[id-1], nil
+}
+
+func TestProjectScope(t *testing.T) {
+	defer func(d DAO, required bool) { dao, requireAuth = d, required }(dao, requireAuth)
+	dao = newProjectScopeDAO()
+	requireAuth = true
+	var body string
+	handler := authMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+		body = string(b)
+		fmt.Fprint(w, tokenProjectID(r))
+	}))
+
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		body       string
+		wantStatus int
+	}{
+		{"own run in body", http.MethodPost, "/api/metrics", `{"run_uuid": "vision-run"}`, http.StatusOK},
+		{"other run in body", http.MethodPost, "/api/metrics", `{"run_uuid": "default-run"}`, http.StatusNotFound},
+		{"other parent run", http.MethodPost, "/api/runs", `{"name": "r", "parent_run_uuid": "default-run"}`, http.StatusNotFound},
+		{"other run in query", http.MethodGet, "/api/tags?run_uuid=default-run", "", http.StatusNotFound},
+		{"other run in path", http.MethodGet, "/api/v1/runs/default-run/notes", "", http.StatusNotFound},
+		{"own run in path", http.MethodGet, "/api/runs/vision-run", "", http.StatusOK},
+		{"missing run", http.MethodPost, "/api/metrics", `{"run_uuid": "missing"}`, http.StatusOK},
+		{"own experiment", http.MethodPost, "/api/runs", `{"name": "r", "experiment_uuid": "vision-exp"}`, http.StatusOK},
+		{"other experiment", http.MethodGet, "/api/meta?experiment_uuid=default-exp", "", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body = ""
+			r := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			r.Header.Set("Authorization", "Bearer vision")
+			if tt.body != "" {
+				r.Header.Set("Content-Type", "application/json")
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tt.wantStatus, w.Code, w.Body.String())
+			}
+			if w.Code == http.StatusOK && (w.Body.String() != "2" || body != tt.body) {
+				t.Errorf("Expected the handler to see the token's project and the whole body, got %s and %q", w.Body.String(), body)
+			}
+		})
+	}
+}
+
+func TestRunArtifactKey(t *testing.T) {
+	ctx := t.Context()
+	defer func(d DAO) { dao = d }(dao)
+	dao = newProjectScopeDAO()
+
+	if key, err := runArtifactKey(ctx, len("default-run"), "default-run"); err != nil || key != "default-run" {
+		t.Errorf("Expected the bare run UUID without a prefix, got %q (err %v)", key, err)
+	}
+	if key, err := runArtifactKey(ctx, len("vision-run"), "vision-run"); err != nil || key != "projects/vision/vision-run" {
+		t.Errorf("Expected the run UUID beneath the project's prefix, got %q (err %v)", key, err)
+	}
+}
+
+func TestHandleAPIProjects(t *testing.T) {
+	defer func(d DAO, required bool) { dao, requireAuth = d, required }(dao, requireAuth)
+	dao = newProjectScopeDAO()
+	requireAuth = true
+	handler := authMiddleware(http.HandlerFunc(handleAPIProjects))
+
+	list := func(authorization string) []ProjectDocument {
+		r := httptest.NewRequest(http.MethodGet, "/api/projects", nil)
+		r.Header.Set("Authorization", authorization)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		var resp struct {
+			Projects []ProjectDocument `json:"projects"`
+		}
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatalf("Failed to decode %d response: %v", w.Code, err)
+		}
+		return resp.Projects
+	}
+	if projects := list("Bearer vision"); len(projects) != 1 || projects[0].Name != "vision" {
+		t.Errorf("Expected a scoped token to see only its project, got %+v", projects)
+	}
+
+	requireAuth = false
+	if projects := list(""); len(projects) != 2 {
+		t.Errorf("Expected every project, got %+v", projects)
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// handleAPIRunMetricsPrometheus exposes the latest value of every metric of a
+// run in the Prometheus text exposition format, so a running training job can
+// be scraped directly.
+func handleAPIRunMetricsPrometheus(w http.ResponseWriter, r *http.Request, runUUID string) {
+	ctx := r.Context()
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	run, err := dao.GetRunByUUID(ctx, runUUID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Run not found"})
+		return
+	}
+
+	runID, err := dao.GetRunIDByUUID(ctx, runUUID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Run not found"})
+		return
+	}
+
+	latest, err := dao.GetLatestMetricsByRunID(ctx, runID)
+	if err != nil {
+		log.Printf("Failed to query latest metrics for run %s: %v", runUUID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to query metrics"})
+		return
+	}
+	system, err := dao.GetLatestSystemMetricsByRunID(ctx, runID)
+	if err != nil {
+		log.Printf("Failed to query latest system metrics for run %s: %v", runUUID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to query metrics"})
+		return
+	}
+	latest = append(latest, system...)
+
+	labels := map[string]string{
+		"run_uuid": runUUID,
+		"run_name": run.Name,
+	}
+	experiment, err := dao.GetExperimentForRunUUID(ctx, runUUID)
+	if err == nil {
+		labels["experiment"] = experiment.Name
+	}
+
+	gpu, err := getRunGPUSummary(ctx, runID)
+	if err != nil {
+		log.Printf("Failed to query GPU summary for run %s: %v", runUUID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to query metrics"})
+		return
+	}
+	var cost *float64
+	if gpu != nil && experiment != nil {
+		experimentID, err := dao.GetExperimentIDByUUID(ctx, experiment.UUID)
+		if err == nil {
+			c, ok, err := getRunCost(ctx, runID, experimentID, gpu)
+			if err != nil {
+				log.Printf("Failed to estimate cost of run %s: %v", runUUID, err)
+				w.WriteHeader(http.StatusInternalServerError)
+				json.NewEncoder(w).Encode(map[string]string{"error": "Failed to query metrics"})
+				return
+			}
+			if ok {
+				cost = &c
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	writePrometheusRunMetrics(w, labels, latest)
+	if gpu != nil {
+		writePrometheusRunGPUSummary(w, labels, gpu, cost)
+	}
+}
+
+// writePrometheusRunMetrics writes one gauge sample per metric key for the
+// latest y value and the x value (step) it was logged at. The metric key is a
+// label rather than part of the metric name, so arbitrary keys like
+// "train/loss" need no mangling.
+func writePrometheusRunMetrics(w io.Writer, runLabels map[string]string, latest []MetricRow) {
+	fmt.Fprintln(w, "# HELP apparatus_metric_value Latest logged value of a run metric.")
+	fmt.Fprintln(w, "# TYPE apparatus_metric_value gauge")
+	for _, m := range latest {
+		fmt.Fprintf(w, "apparatus_metric_value%s %s\n", formatPrometheusLabels(runLabels, "key", m.Key), formatPrometheusValue(m.YValue))
+	}
+	fmt.Fprintln(w, "# HELP apparatus_metric_step Step (x value) of the latest logged value of a run metric.")
+	fmt.Fprintln(w, "# TYPE apparatus_metric_step gauge")
+	for _, m := range latest {
+		fmt.Fprintf(w, "apparatus_metric_step%s %s\n", formatPrometheusLabels(runLabels, "key", m.Key), formatPrometheusValue(m.XValue))
+	}
+}
+
+// writePrometheusRunGPUSummary writes the run's GPU aggregates and estimated
+// cost as gauges. Aggregates the run never logged the metrics for, and the
+// cost if no rate applies, are omitted.
+func writePrometheusRunGPUSummary(w io.Writer, runLabels map[string]string, gpu *RunGPUSummary, cost *float64) {
+	labels := formatPrometheusLabels(runLabels)
+	if gpu.UtilizationMean != nil {
+		fmt.Fprintln(w, "# HELP apparatus_run_gpu_utilization_mean_percent Mean GPU utilization of a run.")
+		fmt.Fprintln(w, "# TYPE apparatus_run_gpu_utilization_mean_percent gauge")
+		fmt.Fprintf(w, "apparatus_run_gpu_utilization_mean_percent%s %s\n", labels, formatPrometheusValue(*gpu.UtilizationMean))
+	}
+	if gpu.MemoryPeakBytes != nil {
+		fmt.Fprintln(w, "# HELP apparatus_run_gpu_memory_peak_bytes Peak GPU memory in use by a run.")
+		fmt.Fprintln(w, "# TYPE apparatus_run_gpu_memory_peak_bytes gauge")
+		fmt.Fprintf(w, "apparatus_run_gpu_memory_peak_bytes%s %s\n", labels, formatPrometheusValue(float64(*gpu.MemoryPeakBytes)))
+	}
+	fmt.Fprintln(w, "# HELP apparatus_run_gpu_hours GPU-hours used by a run.")
+	fmt.Fprintln(w, "# TYPE apparatus_run_gpu_hours gauge")
+	fmt.Fprintf(w, "apparatus_run_gpu_hours%s %s\n", labels, formatPrometheusValue(gpu.GPUHours))
+	if cost != nil {
+		fmt.Fprintln(w, "# HELP apparatus_run_estimated_cost_usd Estimated cost of a run's GPU-hours under its experiment's cost model.")
+		fmt.Fprintln(w, "# TYPE apparatus_run_estimated_cost_usd gauge")
+		fmt.Fprintf(w, "apparatus_run_estimated_cost_usd%s %s\n", labels, formatPrometheusValue(*cost))
+	}
+}
+
+// formatPrometheusLabels renders a label set in a stable order, with any
+// extra name/value pairs appended after the base labels.
+func formatPrometheusLabels(base map[string]string, extra ...string) string {
+	var names []string
+	for _, name := range []string{"run_uuid", "run_name", "experiment"} {
+		if _, ok := base[name]; ok {
+			names = append(names, name)
+		}
+	}
+
+	var parts []string
+	for _, name := range names {
+		parts = append(parts, name+`="`+escapePrometheusLabelValue(base[name])+`"`)
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		parts = append(parts, extra[i]+`="`+escapePrometheusLabelValue(extra[i+1])+`"`)
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+var prometheusLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapePrometheusLabelValue(value string) string {
+	return prometheusLabelEscaper.Replace(value)
+}
+
+func formatPrometheusValue(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
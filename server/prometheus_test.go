@@ -0,0 +1,58 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWritePrometheusRunMetrics(t *testing.T) {
+	labels := map[string]string{
+		"run_uuid":   "abc-123",
+		"run_name":   `my "best" run`,
+		"experiment": "Default",
+	}
+	latest := []MetricRow{
+		{Key: "train/loss", XValue: 100, YValue: 0.25},
+		{Key: "accuracy", XValue: 50, YValue: 1e-7},
+	}
+
+	var b strings.Builder
+	writePrometheusRunMetrics(&b, labels, latest)
+	out := b.String()
+
+	expected := []string{
+		"# TYPE apparatus_metric_value gauge",
+		`apparatus_metric_value{run_uuid="abc-123",run_name="my \"best\" run",experiment="Default",key="train/loss"} 0.25`,
+		`apparatus_metric_value{run_uuid="abc-123",run_name="my \"best\" run",experiment="Default",key="accuracy"} 1e-07`,
+		`apparatus_metric_step{run_uuid="abc-123",run_name="my \"best\" run",experiment="Default",key="train/loss"} 100`,
+	}
+	for _, line := range expected {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("expected output to contain %q, got:\n%s", line, out)
+		}
+	}
+}
+
+func TestWritePrometheusRunGPUSummary(t *testing.T) {
+	labels := map[string]string{"run_uuid": "abc-123"}
+	mean := 55.5
+
+	var b strings.Builder
+	cost := 6.5
+	writePrometheusRunGPUSummary(&b, labels, &RunGPUSummary{UtilizationMean: &mean, GPUHours: 2}, &cost)
+	out := b.String()
+
+	expected := []string{
+		`apparatus_run_gpu_utilization_mean_percent{run_uuid="abc-123"} 55.5`,
+		`apparatus_run_gpu_hours{run_uuid="abc-123"} 2`,
+		`apparatus_run_estimated_cost_usd{run_uuid="abc-123"} 6.5`,
+	}
+	for _, line := range expected {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("expected output to contain %q, got:\n%s", line, out)
+		}
+	}
+	if strings.Contains(out, "apparatus_run_gpu_memory_peak_bytes") {
+		t.Errorf("expected no memory gauge without memory samples, got:\n%s", out)
+	}
+}
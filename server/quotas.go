@@ -0,0 +1,285 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Default limits on each experiment's usage, which admins can override per
+// experiment. 0 is unlimited.
+var (
+	defaultRunQuota           int64
+	defaultMetricPointQuota   int64
+	defaultArtifactBytesQuota int64
+)
+
+// Kinds of quota, as named in the API
+const (
+	quotaRuns               = "runs"
+	quotaMetricPointsPerDay = "metric_points_per_day"
+	quotaArtifactBytes      = "artifact_bytes"
+)
+
+// ExperimentQuota is the limits on an experiment's usage. 0 is unlimited.
+type ExperimentQuota struct {
+	Runs               int64 `json:"runs"`
+	MetricPointsPerDay int64 `json:"metric_points_per_day"`
+	ArtifactBytes      int64 `json:"artifact_bytes"`
+}
+
+// quotaExceededError is returned by writes that would take an experiment
+// over its quota
+type quotaExceededError struct {
+	Experiment string
+	Quota      string
+	Limit      int64
+	Usage      int64
+}
+
+func (e *quotaExceededError) Error() string {
+	switch e.Quota {
+	case quotaRuns:
+		return fmt.Sprintf("experiment %q has reached its quota of %d runs; delete runs or ask an admin to raise the quota", e.Experiment, e.Limit)
+	case quotaMetricPointsPerDay:
+		return fmt.Sprintf("experiment %q has logged %d of its %d metric points for today (UTC); log fewer points or ask an admin to raise the quota", e.Experiment, e.Usage, e.Limit)
+	default:
+		return fmt.Sprintf("experiment %q is using %s of its %s artifact storage quota; delete artifacts or ask an admin to raise the quota", e.Experiment, formatBytes(e.Usage), formatBytes(e.Limit))
+	}
+}
+
+// getExperimentQuota returns the limits in effect for an experiment: the
+// defaults, overridden by any an admin set
+func getExperimentQuota(ctx context.Context, experimentID int) (ExperimentQuota, *ExperimentQuotaRow, error) {
+	quota := ExperimentQuota{
+		Runs:               defaultRunQuota,
+		MetricPointsPerDay: defaultMetricPointQuota,
+		ArtifactBytes:      defaultArtifactBytesQuota,
+	}
+	row, err := dao.GetExperimentQuota(ctx, experimentID)
+	if err != nil || row == nil {
+		return quota, nil, err
+	}
+	if row.MaxRuns.Valid {
+		quota.Runs = row.MaxRuns.Int64
+	}
+	if row.MaxMetricPointsPerDay.Valid {
+		quota.MetricPointsPerDay = row.MaxMetricPointsPerDay.Int64
+	}
+	if row.MaxArtifactBytes.Valid {
+		quota.ArtifactBytes = row.MaxArtifactBytes.Int64
+	}
+	return quota, row, nil
+}
+
+// quotaDay is the UTC day that daily quotas count usage on
+func quotaDay(t time.Time) string {
+	return t.UTC().Format("2006-01-02")
+}
+
+// checkExperimentQuota returns a *quotaExceededError if adding runs, metric
+// points and artifact bytes to an experiment would take it over its quota
+func checkExperimentQuota(ctx context.Context, experimentID int, runs, metricPoints, artifactBytes int64) error {
+	quota, _, err := getExperimentQuota(ctx, experimentID)
+	if err != nil {
+		return err
+	}
+	if quota == (ExperimentQuota{}) {
+		return nil
+	}
+	usage, err := dao.GetExperimentUsage(ctx, experimentID, quotaDay(time.Now()))
+	if err != nil {
+		return err
+	}
+
+	exceeded := func(kind string, limit, used, adding int64) *quotaExceededError {
+		if adding == 0 || limit == 0 || used+adding <= limit {
+			return nil
+		}
+		return &quotaExceededError{Quota: kind, Limit: limit, Usage: used}
+	}
+	e := exceeded(quotaRuns, quota.Runs, usage.Runs, runs)
+	if e == nil {
+		e = exceeded(quotaMetricPointsPerDay, quota.MetricPointsPerDay, usage.MetricPoints, metricPoints)
+	}
+	if e == nil {
+		e = exceeded(quotaArtifactBytes, quota.ArtifactBytes, usage.ArtifactBytes, artifactBytes)
+	}
+	if e == nil {
+		return nil
+	}
+	experiment, err := dao.GetExperimentByID(ctx, experimentID)
+	if err != nil {
+		return err
+	}
+	e.Experiment = experiment.Name
+	return e
+}
+
+// checkRunQuota is checkExperimentQuota for writes to a run, returning the
+// ID of the run's experiment
+func checkRunQuota(ctx context.Context, runID int, metricPoints, artifactBytes int64) (int, error) {
+	experimentID, err := dao.GetRunExperimentID(ctx, runID)
+	if err != nil {
+		return 0, err
+	}
+	return experimentID, checkExperimentQuota(ctx, experimentID, 0, metricPoints, artifactBytes)
+}
+
+// quotaExempt reports whether a write is exempt from quotas: admins may go
+// over them, and journal replays restore writes that were already allowed
+func quotaExempt(r *http.Request) bool {
+	return isJournalReplay(r.Context()) || hasAdminRole(r)
+}
+
+// writeQuotaError responds to a write refused by checkExperimentQuota, or
+// that failed to be checked. It reports whether err was one.
+func writeQuotaError(w http.ResponseWriter, err error) bool {
+	if err == nil {
+		return false
+	}
+	var exceeded *quotaExceededError
+	if !errors.As(err, &exceeded) {
+		log.Printf("Failed to check quota: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to check quota"})
+		return true
+	}
+
+	// The daily quota frees up at midnight UTC; the others only when data is
+	// deleted or an admin raises them
+	status := http.StatusForbidden
+	if exceeded.Quota == quotaMetricPointsPerDay {
+		status = http.StatusTooManyRequests
+		now := time.Now().UTC()
+		midnight := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+		w.Header().Set("Retry-After", strconv.Itoa(int(midnight.Sub(now).Seconds())+1))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": "Quota exceeded: " + exceeded.Error(),
+		"quota": exceeded.Quota,
+		"limit": exceeded.Limit,
+		"usage": exceeded.Usage,
+	})
+	return true
+}
+
+// recordMetricPointUsage counts metric points logged to an experiment
+// against its daily quota
+func recordMetricPointUsage(ctx context.Context, experimentID, points int) {
+	if err := dao.AddExperimentMetricPoints(ctx, experimentID, quotaDay(time.Now()), points); err != nil {
+		log.Printf("Failed to record metric points logged to experiment %d: %v", experimentID, err)
+	}
+}
+
+// QuotaRequest is the body of PUT /api/admin/quotas
+type QuotaRequest struct {
+	ExperimentUUID     string `json:"experiment_uuid"`
+	Runs               *int64 `json:"runs"`
+	MetricPointsPerDay *int64 `json:"metric_points_per_day"`
+	ArtifactBytes      *int64 `json:"artifact_bytes"`
+}
+
+// handleAPIQuotas shows (GET), overrides (PUT) or restores the defaults of
+// (DELETE) an experiment's quota, at /api/admin/quotas?experiment_uuid=...
+// PUT takes the limits to override as JSON, where null keeps the server's
+// default and 0 is unlimited.
+func handleAPIQuotas(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodGet && r.Method != http.MethodPut && r.Method != http.MethodDelete {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	experimentUUID := r.URL.Query().Get("experiment_uuid")
+	var req QuotaRequest
+	if r.Method == http.MethodPut {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Invalid JSON"})
+			return
+		}
+		experimentUUID = req.ExperimentUUID
+		for _, limit := range []*int64{req.Runs, req.MetricPointsPerDay, req.ArtifactBytes} {
+			if limit != nil && *limit < 0 {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]string{"error": "Quotas must be 0 (unlimited) or more"})
+				return
+			}
+		}
+	}
+	if experimentUUID == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Missing required field: experiment_uuid"})
+		return
+	}
+	experimentID, err := dao.GetExperimentIDByUUID(ctx, experimentUUID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Experiment not found"})
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		nullable := func(limit *int64) sql.NullInt64 {
+			if limit == nil {
+				return sql.NullInt64{}
+			}
+			return sql.NullInt64{Int64: *limit, Valid: true}
+		}
+		err = dao.SetExperimentQuota(ctx, experimentID, ExperimentQuotaRow{
+			MaxRuns:               nullable(req.Runs),
+			MaxMetricPointsPerDay: nullable(req.MetricPointsPerDay),
+			MaxArtifactBytes:      nullable(req.ArtifactBytes),
+		})
+		if err == nil {
+			err = recordAudit(ctx, "set_quota", experimentUUID, req)
+		}
+	case http.MethodDelete:
+		_, err = dao.DeleteExperimentQuota(ctx, experimentID)
+		if err == nil {
+			err = recordAudit(ctx, "clear_quota", experimentUUID, nil)
+		}
+	}
+	if err != nil {
+		log.Printf("Failed to update quota of experiment %s: %v", experimentUUID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to update quota"})
+		return
+	}
+
+	quota, row, err := getExperimentQuota(ctx, experimentID)
+	var usage *ExperimentUsageRow
+	if err == nil {
+		usage, err = dao.GetExperimentUsage(ctx, experimentID, quotaDay(time.Now()))
+	}
+	if err != nil {
+		log.Printf("Failed to load quota of experiment %s: %v", experimentUUID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to load quota"})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"experiment_uuid": experimentUUID,
+		"quota":           quota,
+		"overridden":      row != nil,
+		"usage": ExperimentQuota{
+			Runs:               usage.Runs,
+			MetricPointsPerDay: usage.MetricPoints,
+			ArtifactBytes:      usage.ArtifactBytes,
+		},
+	})
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// quotaDAO has one experiment, whose usage and quota tests set
+type quotaDAO struct {
+	DAO
+	quota   *ExperimentQuotaRow
+	usage   ExperimentUsageRow
+	runs    int
+	audited []string
+}
+
+func (d *quotaDAO) GetDefaultExperimentID(ctx context.Context) (int, error) { return 1, nil }
+
+func (d *quotaDAO) GetExperimentIDByUUID(ctx context.Context, uuid string) (int, error) {
+	if uuid != "exp-1" {
+		return 0, sql.ErrNoRows
+	}
+	return 1, nil
+}
+
+func (d *quotaDAO) GetExperimentByID(ctx context.Context, id int) (*Experiment, error) {
+	return &Experiment{UUID: "exp-1", Name: "vision"}, nil
+}
+
+func (d *quotaDAO) GetExperimentQuota(ctx context.Context, experimentID int) (*ExperimentQuotaRow, error) {
+	return d.quota, nil
+}
+
+func (d *quotaDAO) SetExperimentQuota(ctx context.Context, experimentID int, quota ExperimentQuotaRow) error {
+	d.quota = &quota
+	return nil
+}
+
+func (d *quotaDAO) DeleteExperimentQuota(ctx context.Context, experimentID int) (bool, error) {
+	deleted := d.quota != nil
+	d.quota = nil
+	return deleted, nil
+}
+
+func (d *quotaDAO) GetExperimentUsage(ctx context.Context, experimentID int, day string) (*ExperimentUsageRow, error) {
+	usage := d.usage
+	return &usage, nil
+}
+
+func (d *quotaDAO) InsertRun(ctx context.Context, uuid, name string, experimentID int, parentRunID *int) error {
+	d.runs++
+	return nil
+}
+
+func (d *quotaDAO) InsertRunWithSourceAndSeeds(ctx context.Context, uuid, name string, experimentID int, parentRunID *int, source RunSourceRow, seeds RunSeedsRow) error {
+	return d.InsertRun(ctx, uuid, name, experimentID, parentRunID)
+}
+
+func (d *quotaDAO) GetRunByUUID(ctx context.Context, uuid string) (*Run, error) {
+	return nil, errors.New("not found")
+}
+
+func (d *quotaDAO) InsertAuditLogEntry(ctx context.Context, e AuditLogRow) error {
+	d.audited = append(d.audited, e.Action)
+	return nil
+}
+
+func TestCheckExperimentQuota(t *testing.T) {
+	ctx := t.Context()
+	defer func(d DAO, points int64) { dao, defaultMetricPointQuota = d, points }(dao, defaultMetricPointQuota)
+	fake := &quotaDAO{usage: ExperimentUsageRow{Runs: 2, MetricPoints: 90, ArtifactBytes: 1 << 20}}
+	dao = fake
+
+	defaultMetricPointQuota = 100
+	if err := checkExperimentQuota(ctx, 1, 1, 10, 1<<30); err != nil {
+		t.Errorf("Expected writes within the default quota allowed, got %v", err)
+	}
+	var exceeded *quotaExceededError
+	if err := checkExperimentQuota(ctx, 1, 0, 11, 0); !errors.As(err, &exceeded) || exceeded.Quota != quotaMetricPointsPerDay || exceeded.Usage != 90 || exceeded.Experiment != "vision" {
+		t.Errorf("Expected the daily metric point quota exceeded, got %v", err)
+	}
+
+	// An experiment's own quota overrides the defaults, with 0 unlimited
+	fake.quota = &ExperimentQuotaRow{
+		MaxRuns:               sql.NullInt64{Int64: 2, Valid: true},
+		MaxMetricPointsPerDay: sql.NullInt64{Int64: 0, Valid: true},
+	}
+	if err := checkExperimentQuota(ctx, 1, 0, 1000, 0); err != nil {
+		t.Errorf("Expected the override to lift the metric point quota, got %v", err)
+	}
+	if err := checkExperimentQuota(ctx, 1, 1, 0, 0); !errors.As(err, &exceeded) || exceeded.Quota != quotaRuns || !strings.Contains(err.Error(), "quota of 2 runs") {
+		t.Errorf("Expected the run quota exceeded, got %v", err)
+	}
+}
+
+func TestWriteQuotaError(t *testing.T) {
+	w := httptest.NewRecorder()
+	if !writeQuotaError(w, &quotaExceededError{Experiment: "vision", Quota: quotaMetricPointsPerDay, Limit: 100, Usage: 100}) {
+		t.Fatal("Expected the error written")
+	}
+	var body struct {
+		Error string `json:"error"`
+		Quota string `json:"quota"`
+		Limit int64  `json:"limit"`
+	}
+	json.NewDecoder(w.Body).Decode(&body)
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" || body.Quota != quotaMetricPointsPerDay || body.Limit != 100 || !strings.Contains(body.Error, "vision") {
+		t.Errorf("Expected 429 until the quota resets, got %d %+v", w.Code, body)
+	}
+
+	w = httptest.NewRecorder()
+	writeQuotaError(w, &quotaExceededError{Quota: quotaArtifactBytes, Limit: 1 << 30, Usage: 1 << 30})
+	if w.Code != http.StatusForbidden || w.Header().Get("Retry-After") != "" {
+		t.Errorf("Expected 403 for artifact storage, got %d", w.Code)
+	}
+	if writeQuotaError(httptest.NewRecorder(), nil) {
+		t.Errorf("Expected nothing written without an error")
+	}
+}
+
+func TestHandleAPICreateRunQuota(t *testing.T) {
+	defer func(d DAO, token string) { dao, adminToken = d, token }(dao, adminToken)
+	fake := &quotaDAO{
+		quota: &ExperimentQuotaRow{MaxRuns: sql.NullInt64{Int64: 2, Valid: true}},
+		usage: ExperimentUsageRow{Runs: 2},
+	}
+	dao = fake
+	adminToken = "admin"
+
+	create := func(token string) int {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/api/runs", strings.NewReader(`{"name": "run"}`))
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		handleAPICreateRun(w, r)
+		return w.Code
+	}
+	if code := create(""); code != http.StatusForbidden || fake.runs != 0 {
+		t.Errorf("Expected the run refused, got %d", code)
+	}
+	// Admins may go over quotas
+	if code := create("admin"); code != http.StatusOK || fake.runs != 1 {
+		t.Errorf("Expected the admin's run created, got %d", code)
+	}
+}
+
+func TestHandleAPIQuotas(t *testing.T) {
+	defer func(d DAO, token string) { dao, adminToken = d, token }(dao, adminToken)
+	fake := &quotaDAO{usage: ExperimentUsageRow{Runs: 3}}
+	dao = fake
+	adminToken = "admin"
+
+	request := func(method, target, body string) (int, map[string]interface{}) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		r.Header.Set("Authorization", "Bearer admin")
+		handleAPIQuotas(w, r)
+		var resp map[string]interface{}
+		json.NewDecoder(w.Body).Decode(&resp)
+		return w.Code, resp
+	}
+
+	code, resp := request(http.MethodPut, "/api/admin/quotas", `{"experiment_uuid": "exp-1", "runs": 10, "artifact_bytes": 0}`)
+	if code != http.StatusOK || fake.quota == nil || fake.quota.MaxRuns.Int64 != 10 || fake.quota.MaxMetricPointsPerDay.Valid {
+		t.Fatalf("Expected the quota set, got %d %v", code, resp)
+	}
+	if quota := resp["quota"].(map[string]interface{}); quota["runs"] != 10.0 || resp["overridden"] != true {
+		t.Errorf("Expected the effective quota returned, got %v", resp)
+	}
+	if usage := resp["usage"].(map[string]interface{}); usage["runs"] != 3.0 {
+		t.Errorf("Expected the usage returned, got %v", resp)
+	}
+
+	if code, resp := request(http.MethodDelete, "/api/admin/quotas?experiment_uuid=exp-1", ""); code != http.StatusOK || fake.quota != nil || resp["overridden"] != false {
+		t.Errorf("Expected the quota cleared, got %d %v", code, resp)
+	}
+	if strings.Join(fake.audited, ",") != "set_quota,clear_quota" {
+		t.Errorf("Expected the changes audited, got %v", fake.audited)
+	}
+
+	for _, tt := range []struct {
+		method, target, body string
+		code                 int
+	}{
+		{http.MethodPut, "/api/admin/quotas", `{"experiment_uuid": "exp-1", "runs": -1}`, http.StatusBadRequest},
+		{http.MethodGet, "/api/admin/quotas", "", http.StatusBadRequest},
+		{http.MethodGet, "/api/admin/quotas?experiment_uuid=missing", "", http.StatusNotFound},
+		{http.MethodPost, "/api/admin/quotas", "", http.StatusMethodNotAllowed},
+	} {
+		if code, _ := request(tt.method, tt.target, tt.body); code != tt.code {
+			t.Errorf("Expected %d for %s %s, got %d", tt.code, tt.method, tt.target, code)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	handleAPIQuotas(w, httptest.NewRequest(http.MethodGet, "/api/admin/quotas?experiment_uuid=exp-1", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected the admin token required, got %d", w.Code)
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// multiInstance runs the server as one of several replicas behind a load
+// balancer. Replicas must share:
+//
+//   - a Postgres database; a SQLite database belongs to a single server
+//   - the artifact store, i.e. S3 or a file store on a shared filesystem
+//
+// Background jobs then run on one replica at a time, the one holding the
+// job's lease; in-memory caches are invalidated across replicas through the
+// database; and run events are relayed between replicas with Postgres
+// notifications, so that run pages update whichever replica they stream
+// from. Each replica keeps its own ingestion journal (-journal-dir) and
+// applies the artifact download limits to its own downloads.
+var multiInstance bool
+
+// instanceID identifies this replica, e.g. as the holder of job leases
+var instanceID string
+
+// runEventChannel is the Postgres notification channel run events are
+// relayed between replicas on
+const runEventChannel = "apparatus_run_events"
+
+// runEventNotifyMaxSize is the largest run event relayed as it is. Postgres
+// notifications carry at most 8000 bytes, so larger events are relayed as a
+// resync, telling run pages to reload.
+const runEventNotifyMaxSize = 7900
+
+// relayedRunEvent is a run event relayed to the other replicas
+type relayedRunEvent struct {
+	Origin string          `json:"origin"`
+	RunID  int             `json:"run_id"`
+	Type   string          `json:"type"`
+	Data   json.RawMessage `json:"data,omitempty"`
+}
+
+// initMultiInstance checks that the deployment can run as several replicas,
+// and starts relaying run events between them
+func initMultiInstance(connString, artifactStoreURI string) {
+	if !multiInstance {
+		return
+	}
+	if !strings.HasPrefix(connString, "postgres://") && !strings.HasPrefix(connString, "postgresql://") {
+		log.Fatalf("-multi-instance requires a Postgres database shared by all replicas")
+	}
+	if strings.HasPrefix(artifactStoreURI, "file://") {
+		log.Printf("Warning: with -multi-instance, the artifact store %s must be on a filesystem shared by all replicas", artifactStoreURI)
+	}
+	if instanceID == "" {
+		instanceID = newInstanceID()
+	}
+
+	listener := pq.NewListener(connString, time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("Run event relay: %v", err)
+		}
+	})
+	if err := listener.Listen(runEventChannel); err != nil {
+		log.Fatalf("Failed to listen for run events from other replicas: %v", err)
+	}
+	runEvents.relay = relayRunEvent
+	go receiveRelayedRunEvents(listener)
+	log.Printf("Running as replica %s", instanceID)
+}
+
+// newInstanceID names this replica after its host
+func newInstanceID() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "apparatus"
+	}
+	b := make([]byte, 4)
+	rand.Read(b)
+	return host + "-" + hex.EncodeToString(b)
+}
+
+// holdJobLease reports whether this replica should run the next iteration of
+// a background job, taking or renewing the job's lease for ttl. A single
+// server always runs its jobs.
+func holdJobLease(ctx context.Context, job string, ttl time.Duration) bool {
+	if !multiInstance {
+		return true
+	}
+	held, err := dao.AcquireJobLease(ctx, job, instanceID, time.Now().UTC(), ttl)
+	if err != nil {
+		log.Printf("Failed to acquire the lease on %s: %v", job, err)
+		return false
+	}
+	return held
+}
+
+// relayRunEvent sends a run event to the other replicas. Events are relayed
+// even if the request that published them has gone away.
+func relayRunEvent(runID int, event RunEvent) {
+	relayed := relayedRunEvent{Origin: instanceID, RunID: runID, Type: event.Type}
+	data, err := json.Marshal(event.Data)
+	if err != nil {
+		log.Printf("Failed to encode %s event of run %d: %v", event.Type, runID, err)
+		return
+	}
+	relayed.Data = data
+	payload, _ := json.Marshal(relayed)
+	if len(payload) > runEventNotifyMaxSize {
+		relayed.Type, relayed.Data = "resync", nil
+		payload, _ = json.Marshal(relayed)
+	}
+	if err := dao.NotifyRunEvent(context.Background(), string(payload)); err != nil {
+		log.Printf("Failed to relay %s event of run %d: %v", event.Type, runID, err)
+	}
+}
+
+// receiveRelayedRunEvents delivers the run events of other replicas to this
+// replica's subscribers
+func receiveRelayedRunEvents(listener *pq.Listener) {
+	for notification := range listener.Notify {
+		// The connection was lost, and with it any events sent meanwhile
+		if notification == nil {
+			runEvents.deliverAll(RunEvent{Type: "resync"})
+			continue
+		}
+		var relayed relayedRunEvent
+		if err := json.Unmarshal([]byte(notification.Extra), &relayed); err != nil {
+			log.Printf("Failed to decode relayed run event: %v", err)
+			continue
+		}
+		if relayed.Origin == instanceID {
+			continue
+		}
+		event := RunEvent{Type: relayed.Type}
+		if relayed.Data != nil {
+			event.Data = relayed.Data
+		}
+		runEvents.deliver(relayed.RunID, event)
+	}
+}
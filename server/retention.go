@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Archived runs are hidden from the home page's run list and from run search
+// unless asked for, but are otherwise kept as they are. Retention archives
+// runs once they are old enough, and the archived-runs housekeeping job
+// deletes runs once they have been archived long enough. Like every
+// housekeeping job, it only deletes runs listed in a reviewed dry run.
+
+var (
+	// retentionArchiveAfterDays is how many days after they are created runs
+	// are archived (0 disables)
+	retentionArchiveAfterDays int
+	// retentionDeleteArchivedAfterDays is how many days after they are
+	// archived runs are listed for deletion (0 disables)
+	retentionDeleteArchivedAfterDays int
+)
+
+// retentionInterval is how often retention archives runs
+const retentionInterval = time.Hour
+
+// archiveRun archives a run and its child runs
+func archiveRun(ctx context.Context, runID int, at time.Time) error {
+	ids, err := getRunSubtree(ctx, runID)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if err := dao.ArchiveRun(ctx, id, at); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unarchiveRun restores a run and its child runs
+func unarchiveRun(ctx context.Context, runID int) error {
+	ids, err := getRunSubtree(ctx, runID)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if err := dao.UnarchiveRun(ctx, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// archiveOldRuns archives the runs older than retentionArchiveAfterDays that
+// have stopped running and are not on hold, returning how many it archived
+func archiveOldRuns(ctx context.Context, now time.Time) (int, error) {
+	if retentionArchiveAfterDays <= 0 {
+		return 0, nil
+	}
+	runs, err := dao.GetRunsToArchive(ctx, now.AddDate(0, 0, -retentionArchiveAfterDays))
+	if err != nil {
+		return 0, err
+	}
+	for i, run := range runs {
+		if err := dao.ArchiveRun(ctx, run.ID, now.UTC()); err != nil {
+			return i, fmt.Errorf("archiving run %s: %w", run.UUID, err)
+		}
+	}
+	return len(runs), nil
+}
+
+// startRetention archives old runs in the background
+func startRetention(ctx context.Context) {
+	if retentionArchiveAfterDays <= 0 {
+		return
+	}
+	go func() {
+		for {
+			if holdJobLease(ctx, "retention", 2*retentionInterval) {
+				if n, err := archiveOldRuns(ctx, time.Now()); err != nil {
+					log.Printf("Retention failed to archive runs: %v", err)
+				} else if n > 0 {
+					log.Printf("Retention archived %d runs created more than %d days ago", n, retentionArchiveAfterDays)
+				}
+			}
+			time.Sleep(retentionInterval)
+		}
+	}()
+	log.Printf("Runs are archived %d days after they are created", retentionArchiveAfterDays)
+}
+
+// planArchivedRunDeletion lists the runs archived more than
+// retentionDeleteArchivedAfterDays ago. Deleting a run deletes its child
+// runs, so runs with children that are not yet due are kept, as are runs on
+// hold.
+func planArchivedRunDeletion(ctx context.Context) (items, skipped []HousekeepingItem, err error) {
+	if retentionDeleteArchivedAfterDays <= 0 {
+		return nil, nil, nil
+	}
+	runs, err := dao.GetRunsArchivedBefore(ctx, time.Now().AddDate(0, 0, -retentionDeleteArchivedAfterDays))
+	if err != nil {
+		return nil, nil, err
+	}
+	due := make(map[int]bool, len(runs))
+	for _, run := range runs {
+		due[run.ID] = true
+	}
+
+	for _, run := range runs {
+		ids, err := getRunSubtree(ctx, run.ID)
+		if err != nil {
+			return nil, nil, err
+		}
+		item := HousekeepingItem{Kind: "run", Key: run.UUID, RunUUID: run.UUID}
+		for _, id := range ids {
+			if !due[id] {
+				item.Reason = "has child runs that are not due for deletion"
+				break
+			}
+			if err := ensureRunNotOnHold(ctx, id); errors.Is(err, errRunOnHold) {
+				item.Reason = "run or one of its child runs is on hold"
+				break
+			} else if err != nil {
+				return nil, nil, err
+			}
+			artifacts, err := dao.GetArtifactsByRunID(ctx, id)
+			if err != nil {
+				return nil, nil, err
+			}
+			for _, a := range artifacts {
+				item.Bytes += a.SizeBytes
+			}
+		}
+		if item.Reason != "" {
+			skipped = append(skipped, item)
+			continue
+		}
+		items = append(items, item)
+	}
+	return items, skipped, nil
+}
+
+// deleteArchivedRun deletes a run listed by planArchivedRunDeletion, with its
+// artifacts. A run already deleted with its parent is done.
+func deleteArchivedRun(ctx context.Context, item HousekeepingItem) error {
+	runID, err := dao.GetRunIDByUUID(ctx, item.Key)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return deleteRun(ctx, runID, "")
+}
+
+// handleAPIArchiveRun archives (POST) or restores (DELETE) a run and its
+// child runs, at /api/runs/{uuid}/archive
+func handleAPIArchiveRun(w http.ResponseWriter, r *http.Request, runUUID string) {
+	ctx := r.Context()
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	runID, err := dao.GetRunIDByUUID(ctx, runUUID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Run not found"})
+		return
+	}
+
+	archived := r.Method == http.MethodPost
+	if archived {
+		err = archiveRun(ctx, runID, time.Now().UTC())
+	} else {
+		err = unarchiveRun(ctx, runID)
+	}
+	if err != nil {
+		log.Printf("Failed to update archived state of run %s: %v", runUUID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to update run"})
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": "ok", "archived": archived})
+}
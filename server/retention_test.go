@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// retentionRun is a run kept by retentionDAO
+type retentionRun struct {
+	uuid       string
+	parent     int
+	archivedAt time.Time
+	held       bool
+}
+
+// retentionDAO keeps runs in memory, keyed by ID
+type retentionDAO struct {
+	DAO
+	runs map[int]*retentionRun
+	// toArchive is what GetRunsToArchive returns
+	toArchive []RetentionRunRow
+}
+
+func (d *retentionDAO) GetRunIDByUUID(ctx context.Context, uuid string) (int, error) {
+	for id, run := range d.runs {
+		if run.uuid == uuid {
+			return id, nil
+		}
+	}
+	return 0, sql.ErrNoRows
+}
+
+func (d *retentionDAO) GetChildRuns(ctx context.Context, parentRunID int) ([]Run, error) {
+	var children []Run
+	for _, run := range d.runs {
+		if run.parent == parentRunID {
+			children = append(children, Run{UUID: run.uuid})
+		}
+	}
+	return children, nil
+}
+
+func (d *retentionDAO) GetRunHold(ctx context.Context, runID int) (*RunHoldRow, error) {
+	if d.runs[runID].held {
+		return &RunHoldRow{Reason: "audit"}, nil
+	}
+	return nil, nil
+}
+
+func (d *retentionDAO) GetArtifactsByRunID(ctx context.Context, runID int) ([]ArtifactRow, error) {
+	return []ArtifactRow{{Path: "model.pt", SizeBytes: 100}}, nil
+}
+
+func (d *retentionDAO) ArchiveRun(ctx context.Context, runID int, at time.Time) error {
+	if d.runs[runID].archivedAt.IsZero() {
+		d.runs[runID].archivedAt = at
+	}
+	return nil
+}
+
+func (d *retentionDAO) UnarchiveRun(ctx context.Context, runID int) error {
+	d.runs[runID].archivedAt = time.Time{}
+	return nil
+}
+
+func (d *retentionDAO) GetRunsToArchive(ctx context.Context, createdBefore time.Time) ([]RetentionRunRow, error) {
+	return d.toArchive, nil
+}
+
+func (d *retentionDAO) GetRunsArchivedBefore(ctx context.Context, cutoff time.Time) ([]RetentionRunRow, error) {
+	var runs []RetentionRunRow
+	for id, run := range d.runs {
+		if !run.archivedAt.IsZero() && run.archivedAt.Before(cutoff) {
+			runs = append(runs, RetentionRunRow{ID: id, UUID: run.uuid})
+		}
+	}
+	return runs, nil
+}
+
+func TestArchiveOldRuns(t *testing.T) {
+	ctx := t.Context()
+	defer func(d DAO, days int) { dao, retentionArchiveAfterDays = d, days }(dao, retentionArchiveAfterDays)
+	fake := &retentionDAO{
+		runs:      map[int]*retentionRun{1: {uuid: "old"}, 2: {uuid: "new"}},
+		toArchive: []RetentionRunRow{{ID: 1, UUID: "old"}},
+	}
+	dao = fake
+	now := time.Now()
+
+	retentionArchiveAfterDays = 0
+	if n, err := archiveOldRuns(ctx, now); n != 0 || err != nil {
+		t.Errorf("Expected nothing archived when disabled, got %d %v", n, err)
+	}
+	retentionArchiveAfterDays = 30
+	if n, err := archiveOldRuns(ctx, now); n != 1 || err != nil {
+		t.Fatalf("Expected one run archived, got %d %v", n, err)
+	}
+	if !fake.runs[1].archivedAt.Equal(now) || !fake.runs[2].archivedAt.IsZero() {
+		t.Errorf("Expected only the old run archived, got %+v %+v", fake.runs[1], fake.runs[2])
+	}
+}
+
+func TestPlanArchivedRunDeletion(t *testing.T) {
+	ctx := t.Context()
+	defer func(d DAO, days int) { dao, retentionDeleteArchivedAfterDays = d, days }(dao, retentionDeleteArchivedAfterDays)
+	longAgo := time.Now().AddDate(0, 0, -100)
+	dao = &retentionDAO{runs: map[int]*retentionRun{
+		1: {uuid: "sweep", archivedAt: longAgo},
+		2: {uuid: "sweep-child", parent: 1, archivedAt: longAgo},
+		3: {uuid: "held", archivedAt: longAgo, held: true},
+		4: {uuid: "recent-parent", archivedAt: longAgo},
+		5: {uuid: "recent-child", parent: 4, archivedAt: time.Now()},
+		6: {uuid: "not-archived"},
+	}}
+
+	retentionDeleteArchivedAfterDays = 0
+	if items, skipped, _ := planArchivedRunDeletion(ctx); len(items) != 0 || len(skipped) != 0 {
+		t.Errorf("Expected nothing planned when disabled, got %+v %+v", items, skipped)
+	}
+
+	retentionDeleteArchivedAfterDays = 90
+	items, skipped, err := planArchivedRunDeletion(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	planned := make(map[string]int64)
+	for _, item := range items {
+		planned[item.Key] = item.Bytes
+	}
+	if len(planned) != 2 || planned["sweep"] != 200 || planned["sweep-child"] != 100 {
+		t.Errorf("Expected the sweep and its child planned with their artifacts, got %+v", items)
+	}
+	reasons := make(map[string]string)
+	for _, item := range skipped {
+		reasons[item.Key] = item.Reason
+	}
+	if reasons["held"] == "" || reasons["recent-parent"] == "" || len(reasons) != 2 {
+		t.Errorf("Expected held runs and runs with recently archived children skipped, got %+v", skipped)
+	}
+}
+
+func TestHandleAPIArchiveRun(t *testing.T) {
+	defer func(d DAO) { dao = d }(dao)
+	fake := &retentionDAO{runs: map[int]*retentionRun{
+		1: {uuid: "parent"},
+		2: {uuid: "child", parent: 1},
+	}}
+	dao = fake
+
+	request := func(method, target string) (int, map[string]interface{}) {
+		w := httptest.NewRecorder()
+		handleAPIV1Runs(w, httptest.NewRequest(method, target, nil))
+		var resp map[string]interface{}
+		json.NewDecoder(w.Body).Decode(&resp)
+		return w.Code, resp
+	}
+
+	if code, resp := request(http.MethodPost, "/api/runs/parent/archive"); code != http.StatusOK || resp["archived"] != true {
+		t.Fatalf("Expected the run archived, got %d %v", code, resp)
+	}
+	if fake.runs[1].archivedAt.IsZero() || fake.runs[2].archivedAt.IsZero() {
+		t.Errorf("Expected the run and its child archived, got %+v %+v", fake.runs[1], fake.runs[2])
+	}
+	if code, resp := request(http.MethodDelete, "/api/v1/runs/parent/archive"); code != http.StatusOK || resp["archived"] != false {
+		t.Fatalf("Expected the run restored, got %d %v", code, resp)
+	}
+	if !fake.runs[1].archivedAt.IsZero() || !fake.runs[2].archivedAt.IsZero() {
+		t.Errorf("Expected the run and its child restored, got %+v %+v", fake.runs[1], fake.runs[2])
+	}
+
+	if code, _ := request(http.MethodPost, "/api/runs/missing/archive"); code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a missing run, got %d", code)
+	}
+	if code, _ := request(http.MethodGet, "/api/runs/parent/archive"); code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected 405 for GET, got %d", code)
+	}
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Users have roles deciding what they may do: viewers browse runs, editors
+// also create runs and log to them, and admins also delete runs and manage
+// users and tokens. API tokens issued to a user act with the user's role,
+// and are enforced with -require-auth; tokens issued to no user act as
+// editors, and the admin token as an admin. The web UI is enforced with
+// -require-login, signing in with a token, though the admin pages always
+// need an admin to sign in.
+
+// role is what a user may do. Greater roles may do everything lesser ones
+// may.
+type role int
+
+const (
+	roleViewer role = iota + 1
+	roleEditor
+	roleAdmin
+)
+
+var roles = []role{roleViewer, roleEditor, roleAdmin}
+
+func (r role) String() string {
+	switch r {
+	case roleViewer:
+		return "viewer"
+	case roleEditor:
+		return "editor"
+	case roleAdmin:
+		return "admin"
+	}
+	return "none"
+}
+
+// parseRole parses a role's name
+func parseRole(name string) (role, bool) {
+	for _, r := range roles {
+		if r.String() == name {
+			return r, true
+		}
+	}
+	return 0, false
+}
+
+// routeRoles are the roles the route groups need, by path prefix, with the
+// first matching prefix deciding. Reads are GET and HEAD requests; writes
+// are the rest. Versioned API paths are matched by their unversioned path.
+var routeRoles = []struct {
+	prefix      string
+	read, write role
+}{
+	{"/api/admin/", roleAdmin, roleAdmin},
+	{"/admin/", roleAdmin, roleAdmin},
+	// Grafana queries by POST
+	{"/api/grafana/", roleViewer, roleViewer},
+	// Restoring runs from the trash needs an admin, like deleting them
+	{"/api/trash", roleViewer, roleAdmin},
+	{"/trash", roleViewer, roleAdmin},
+	{"/api/", roleViewer, roleEditor},
+	// Viewers keep their own preferences and choice of project
+	{"/preferences/", roleViewer, roleViewer},
+	{"/projects/select", roleViewer, roleViewer},
+	{"/", roleViewer, roleEditor},
+}
+
+// requiredRole is the role a request needs. Deleting a run needs an admin
+// wherever it is routed.
+func requiredRole(r *http.Request) role {
+	path := unversionedAPIPath(r.URL.Path)
+	if isRunDeletion(r.Method, path) {
+		return roleAdmin
+	}
+	// Downloading a selection of a run's artifacts posts the selection
+	if strings.HasPrefix(path, "/api/runs/") && strings.HasSuffix(path, "/artifacts/archive") {
+		return roleViewer
+	}
+	for _, group := range routeRoles {
+		if strings.HasPrefix(path, group.prefix) {
+			if r.Method == http.MethodGet || r.Method == http.MethodHead {
+				return group.read
+			}
+			return group.write
+		}
+	}
+	return roleAdmin
+}
+
+// isRunDeletion reports whether a request deletes a run: DELETE
+// /api/runs/{uuid}, or POST /runs/{uuid}/delete from the run page
+func isRunDeletion(method, path string) bool {
+	if rest, ok := strings.CutPrefix(path, "/api/runs/"); ok {
+		return method == http.MethodDelete && rest != "" && !strings.Contains(rest, "/")
+	}
+	if rest, ok := strings.CutPrefix(path, "/runs/"); ok {
+		uuid, action, _ := strings.Cut(rest, "/")
+		return uuid != "" && action == "delete"
+	}
+	return false
+}
+
+// principal is who a request acts as, once authorized
+type principal struct {
+	// User is the name of the user the token was issued to, or empty
+	User string
+	Role role
+	// ProjectID is the project the token is scoped to, or 0 for every project
+	ProjectID int
+}
+
+type principalContextKey struct{}
+
+// withPrincipal records who a request acts as
+func withPrincipal(r *http.Request, p principal) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), principalContextKey{}, p))
+}
+
+// requestPrincipal is who a request was authorized as, if it was
+func requestPrincipal(r *http.Request) (principal, bool) {
+	p, ok := r.Context().Value(principalContextKey{}).(principal)
+	return p, ok
+}
+
+// requireLogin makes the web UI require signing in, enforcing users' roles
+var requireLogin bool
+
+// sessionCookie holds the token a browser signed in with
+const sessionCookie = "apparatus_session"
+
+// authorizeSessionToken checks a token for signing in to the web UI, which
+// shows every project, so tokens scoped to a project are refused
+func authorizeSessionToken(ctx context.Context, token string) (principal, string, error) {
+	p, message, err := authorizeToken(ctx, token)
+	if err != nil || message != "" {
+		return p, message, err
+	}
+	if p.ProjectID != 0 {
+		return principal{}, "Tokens scoped to a project can only be used with the API", nil
+	}
+	return p, "", nil
+}
+
+// sessionPrincipal is who the browser making a request signed in as,
+// returning ok false if it has not or its token is no longer valid
+func sessionPrincipal(r *http.Request) (p principal, ok bool, err error) {
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil || cookie.Value == "" {
+		return principal{}, false, nil
+	}
+	p, message, err := authorizeSessionToken(r.Context(), cookie.Value)
+	if err != nil || message != "" {
+		return principal{}, false, err
+	}
+	return p, true, nil
+}
+
+// loginMiddleware keeps the web UI to signed-in users whose role allows the
+// request, sending those not signed in to sign in. Without -require-login
+// only the admin pages are kept to admins.
+func loginMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		need := requiredRole(r)
+		if !requireLogin && !strings.HasPrefix(r.URL.Path, "/admin/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		p, ok, err := sessionPrincipal(r)
+		if err != nil {
+			log.Printf("Failed to check session: %v", err)
+			http.Error(w, "Failed to check session", http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			// Fragments htmx loads into a page are left empty
+			if r.Method != http.MethodGet || r.Header.Get("HX-Request") != "" {
+				w.WriteHeader(http.StatusUnauthorized)
+				fmt.Fprintf(w, "Sign in first")
+				return
+			}
+			http.Redirect(w, r, "/login?"+url.Values{"next": {r.URL.RequestURI()}}.Encode(), http.StatusSeeOther)
+			return
+		}
+		if p.Role < need {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprintf(w, "This requires the %s role; you are signed in as a %s", need, p.Role)
+			return
+		}
+		next.ServeHTTP(w, withPrincipal(r, p))
+	})
+}
+
+// handlePage registers a page of the web UI, which loginMiddleware guards
+func handlePage(pattern string, handler http.Handler) {
+	http.Handle(pattern, LoggerMiddleware(loginMiddleware(handler)))
+}
+
+// loginTemplates are the templates of the sign-in page and the session links
+// in the header
+var (
+	loginTemplates        = registerPage("templates/login.html")
+	sessionLinksTemplates = registerTemplates("templates/session_links.html")
+)
+
+// safeRedirectTarget is where to go after signing in: a path on this server,
+// or the home page
+func safeRedirectTarget(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/"
+	}
+	return next
+}
+
+// handleLogin serves the sign-in page, where POSTing a token signs the
+// browser in as whoever it was issued to
+func handleLogin(w http.ResponseWriter, r *http.Request) error {
+	data := struct {
+		Title string
+		Next  string
+		Error string
+	}{
+		Title: "Sign in",
+		Next:  safeRedirectTarget(r.FormValue("next")),
+	}
+	if r.Method == http.MethodPost {
+		token := strings.TrimSpace(r.FormValue("token"))
+		_, message, err := authorizeSessionToken(r.Context(), token)
+		if err != nil {
+			return fmt.Errorf("failed to check token: %w", err)
+		}
+		if message == "" {
+			http.SetCookie(w, &http.Cookie{
+				Name:     sessionCookie,
+				Value:    token,
+				Path:     "/",
+				MaxAge:   30 * 24 * 60 * 60,
+				HttpOnly: true,
+				Secure:   r.TLS != nil,
+				SameSite: http.SameSiteLaxMode,
+			})
+			http.Redirect(w, r, data.Next, http.StatusSeeOther)
+			return nil
+		}
+		data.Error = message
+		w.WriteHeader(http.StatusUnauthorized)
+	}
+	tmpl, err := loginTemplates.Get()
+	if err != nil {
+		return fmt.Errorf("failed to parse template: %w", err)
+	}
+	return executeTemplate(w, tmpl, "login.html", data)
+}
+
+// handleLogout signs the browser out
+func handleLogout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: sessionCookie, Path: "/", MaxAge: -1})
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// handleSessionLinks renders who the browser is signed in as, with links to
+// sign in or out, which the header loads with htmx
+func handleSessionLinks(w http.ResponseWriter, r *http.Request) error {
+	p, signedIn, err := sessionPrincipal(r)
+	if err != nil {
+		return fmt.Errorf("failed to check session: %w", err)
+	}
+	data := struct {
+		SignedIn bool
+		User     string
+		Role     string
+		IsAdmin  bool
+	}{
+		SignedIn: signedIn,
+		User:     p.User,
+		Role:     p.Role.String(),
+		IsAdmin:  p.Role == roleAdmin,
+	}
+	tmpl, err := sessionLinksTemplates.Get()
+	if err != nil {
+		return fmt.Errorf("failed to parse template: %w", err)
+	}
+	return executeTemplate(w, tmpl, "session_links.html", data)
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// usersDAO keeps users and the tokens issued to them in memory
+type usersDAO struct {
+	DAO
+	users  []UserRow
+	tokens map[string]APITokenRow
+}
+
+func newUsersDAO(ctx context.Context) *usersDAO {
+	d := &usersDAO{tokens: map[string]APITokenRow{}}
+	for _, u := range []struct{ name, role string }{{"vera", "viewer"}, {"ed", "editor"}, {"ada", "admin"}} {
+		d.InsertUser(ctx, u.name, u.role)
+		user, _ := d.GetUserByName(ctx, u.name)
+		d.tokens[hashAPIToken(u.name+"-token")] = APITokenRow{Name: u.name + "-laptop", UserID: sql.NullInt64{Int64: int64(user.ID), Valid: true}}
+	}
+	return d
+}
+
+func (d *usersDAO) InsertUser(ctx context.Context, name, role string) error {
+	d.users = append(d.users, UserRow{ID: len(d.users) + 1, Name: name, Role: role})
+	return nil
+}
+
+func (d *usersDAO) GetUserByID(ctx context.Context, id int) (*UserRow, error) {
+	for _, u := range d.users {
+		if u.ID == id {
+			return &u, nil
+		}
+	}
+	return nil, nil
+}
+
+func (d *usersDAO) GetUserByName(ctx context.Context, name string) (*UserRow, error) {
+	for _, u := range d.users {
+		if u.Name == name {
+			return &u, nil
+		}
+	}
+	return nil, nil
+}
+
+func (d *usersDAO) GetAllUsers(ctx context.Context) ([]UserRow, error) {
+	return d.users, nil
+}
+
+func (d *usersDAO) SetUserRole(ctx context.Context, id int, role string) error {
+	for i := range d.users {
+		if d.users[i].ID == id {
+			d.users[i].Role = role
+		}
+	}
+	return nil
+}
+
+func (d *usersDAO) DeleteUser(ctx context.Context, id int) error {
+	for i, u := range d.users {
+		if u.ID == id {
+			d.users = append(d.users[:i], d.users[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func (d *usersDAO) GetAPITokenByHash(ctx context.Context, tokenHash string) (*APITokenRow, error) {
+	if t, ok := d.tokens[tokenHash]; ok {
+		return &t, nil
+	}
+	return nil, nil
+}
+
+func (d *usersDAO) GetAllAPITokens(ctx context.Context) ([]APITokenRow, error) {
+	var tokens []APITokenRow
+	for _, t := range d.tokens {
+		tokens = append(tokens, t)
+	}
+	return tokens, nil
+}
+
+func (d *usersDAO) InsertAPIToken(ctx context.Context, name, tokenHash string, projectID, userID sql.NullInt64) error {
+	d.tokens[tokenHash] = APITokenRow{Name: name, TokenHash: tokenHash, ProjectID: projectID, UserID: userID}
+	return nil
+}
+
+func (d *usersDAO) InsertAuditLogEntry(ctx context.Context, entry AuditLogRow) error {
+	return nil
+}
+
+func TestRequiredRole(t *testing.T) {
+	tests := []struct {
+		method, path string
+		want         role
+	}{
+		{http.MethodGet, "/", roleViewer},
+		{http.MethodGet, "/runs/abc", roleViewer},
+		{http.MethodPost, "/runs/abc/notes", roleEditor},
+		{http.MethodPost, "/runs/abc/delete", roleAdmin},
+		{http.MethodPost, "/preferences/run-list", roleViewer},
+		{http.MethodGet, "/api/runs/abc", roleViewer},
+		{http.MethodPost, "/api/runs", roleEditor},
+		{http.MethodPost, "/api/v1/metrics", roleEditor},
+		{http.MethodDelete, "/api/runs/abc", roleAdmin},
+		{http.MethodDelete, "/api/v1/runs/abc", roleAdmin},
+		{http.MethodDelete, "/api/runs/abc/tags", roleEditor},
+		{http.MethodPost, "/api/runs/abc/artifacts/archive", roleViewer},
+		{http.MethodPost, "/api/grafana/query", roleViewer},
+		{http.MethodGet, "/api/admin/audit-log", roleAdmin},
+		{http.MethodGet, "/admin/users", roleAdmin},
+		{http.MethodGet, "/trash", roleViewer},
+		{http.MethodPost, "/trash/abc/restore", roleAdmin},
+		{http.MethodGet, "/api/v1/trash", roleViewer},
+		{http.MethodPost, "/api/trash/restore", roleAdmin},
+	}
+	for _, tt := range tests {
+		if got := requiredRole(httptest.NewRequest(tt.method, tt.path, nil)); got != tt.want {
+			t.Errorf("requiredRole(%s %s) = %s, want %s", tt.method, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestAuthMiddlewareRoles(t *testing.T) {
+	ctx := t.Context()
+	defer func(d DAO, required bool, admin string) { dao, requireAuth, adminToken = d, required, admin }(dao, requireAuth, adminToken)
+	dao = newUsersDAO(ctx)
+	requireAuth, adminToken = true, ""
+	var admin bool
+	handler := authMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		admin = hasAdminRole(r)
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		name, method, path, token string
+		wantStatus                int
+	}{
+		{"viewer reads", http.MethodGet, "/api/runs/abc", "vera-token", http.StatusOK},
+		{"viewer writes", http.MethodPost, "/api/metrics", "vera-token", http.StatusForbidden},
+		{"editor writes", http.MethodPost, "/api/metrics", "ed-token", http.StatusOK},
+		{"editor deletes a run", http.MethodDelete, "/api/runs/abc", "ed-token", http.StatusForbidden},
+		{"admin deletes a run", http.MethodDelete, "/api/runs/abc", "ada-token", http.StatusOK},
+		{"editor administers", http.MethodGet, "/api/admin/audit-log", "ed-token", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.path, nil)
+			r.Header.Set("Authorization", "Bearer "+tt.token)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tt.wantStatus {
+				t.Errorf("Expected status %d, got %d: %s", tt.wantStatus, w.Code, w.Body.String())
+			}
+		})
+	}
+
+	// Admin users are admins to requireAdmin, without the admin token
+	r := httptest.NewRequest(http.MethodGet, "/api/admin/audit-log", nil)
+	r.Header.Set("Authorization", "Bearer ada-token")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+	if !admin {
+		t.Error("Expected an admin user's token to carry the admin role")
+	}
+
+	// The web UI calls the API with the browser's session
+	r = httptest.NewRequest(http.MethodPost, "/api/artifacts", nil)
+	r.AddCookie(&http.Cookie{Name: sessionCookie, Value: "ed-token"})
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected a signed-in editor's upload to be allowed, got %d", w.Code)
+	}
+}
+
+func TestLoginMiddleware(t *testing.T) {
+	ctx := t.Context()
+	defer func(d DAO, required bool) { dao, requireLogin = d, required }(dao, requireLogin)
+	dao = newUsersDAO(ctx)
+	handler := loginMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	serve := func(method, path, token string, header http.Header) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, nil)
+		for k, v := range header {
+			r.Header[k] = v
+		}
+		if token != "" {
+			r.AddCookie(&http.Cookie{Name: sessionCookie, Value: token})
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	requireLogin = false
+	if w := serve(http.MethodPost, "/runs/abc/delete", "", nil); w.Code != http.StatusOK {
+		t.Errorf("Expected the web UI open without -require-login, got %d", w.Code)
+	}
+	if w := serve(http.MethodGet, "/admin/users", "ed-token", nil); w.Code != http.StatusForbidden {
+		t.Errorf("Expected the admin pages kept to admins without -require-login, got %d", w.Code)
+	}
+
+	requireLogin = true
+	w := serve(http.MethodGet, "/runs/abc?tab=metrics", "", nil)
+	wantLocation := "/login?" + url.Values{"next": {"/runs/abc?tab=metrics"}}.Encode()
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != wantLocation {
+		t.Errorf("Expected a redirect to sign in, got %d to %q", w.Code, w.Header().Get("Location"))
+	}
+	if w := serve(http.MethodGet, "/status-strip", "", http.Header{"Hx-Request": {"true"}}); w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected htmx fragments refused rather than redirected, got %d", w.Code)
+	}
+	if w := serve(http.MethodGet, "/runs/abc", "nope", nil); w.Code != http.StatusSeeOther {
+		t.Errorf("Expected an invalid session to sign in again, got %d", w.Code)
+	}
+	if w := serve(http.MethodGet, "/runs/abc", "vera-token", nil); w.Code != http.StatusOK {
+		t.Errorf("Expected a viewer to browse runs, got %d", w.Code)
+	}
+	if w := serve(http.MethodPost, "/runs/abc/notes", "vera-token", nil); w.Code != http.StatusForbidden {
+		t.Errorf("Expected a viewer refused editing notes, got %d", w.Code)
+	}
+	if w := serve(http.MethodPost, "/runs/abc/delete", "ed-token", nil); w.Code != http.StatusForbidden {
+		t.Errorf("Expected an editor refused deleting runs, got %d", w.Code)
+	}
+	if w := serve(http.MethodPost, "/runs/abc/delete", "ada-token", nil); w.Code != http.StatusOK {
+		t.Errorf("Expected an admin to delete runs, got %d", w.Code)
+	}
+}
+
+func TestHandleLogin(t *testing.T) {
+	ctx := t.Context()
+	defer func(d DAO) { dao = d }(dao)
+	dao = newUsersDAO(ctx)
+
+	login := func(token, next string) *httptest.ResponseRecorder {
+		form := url.Values{"token": {token}, "next": {next}}
+		r := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		errorHandler(handleLogin).ServeHTTP(w, r)
+		return w
+	}
+
+	if w := login("nope", "/"); w.Code != http.StatusUnauthorized || !strings.Contains(w.Body.String(), "Invalid API token") {
+		t.Errorf("Expected an invalid token refused, got %d", w.Code)
+	}
+	w := login("vera-token", "/runs/abc")
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/runs/abc" {
+		t.Fatalf("Expected a redirect back, got %d to %q", w.Code, w.Header().Get("Location"))
+	}
+	if cookies := w.Result().Cookies(); len(cookies) != 1 || cookies[0].Name != sessionCookie || !cookies[0].HttpOnly {
+		t.Errorf("Expected an HttpOnly session cookie, got %v", cookies)
+	}
+	if w := login("vera-token", "//evil.example.com"); w.Header().Get("Location") != "/" {
+		t.Errorf("Expected redirects off the server refused, got %q", w.Header().Get("Location"))
+	}
+
+	// The web UI shows every project, so a token scoped to one cannot sign in
+	dao.InsertAPIToken(ctx, "ci", hashAPIToken("scoped-token"), sql.NullInt64{Int64: 2, Valid: true}, sql.NullInt64{})
+	w = login("scoped-token", "/runs/abc")
+	if w.Code != http.StatusUnauthorized || !strings.Contains(w.Body.String(), "scoped to a project") {
+		t.Errorf("Expected a project-scoped token refused, got %d", w.Code)
+	}
+	if cookies := w.Result().Cookies(); len(cookies) != 0 {
+		t.Errorf("Expected no session cookie for a project-scoped token, got %v", cookies)
+	}
+	r := httptest.NewRequest(http.MethodGet, "/runs/abc", nil)
+	r.AddCookie(&http.Cookie{Name: sessionCookie, Value: "scoped-token"})
+	if _, ok, err := sessionPrincipal(r); ok || err != nil {
+		t.Errorf("Expected a project-scoped session cookie not signed in, got %v, %v", ok, err)
+	}
+}
+
+func TestHandleAdminUsers(t *testing.T) {
+	ctx := t.Context()
+	defer func(d DAO) { dao = d }(dao)
+	d := newUsersDAO(ctx)
+	dao = d
+
+	post := func(path string, form url.Values) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r = withPrincipal(r, principal{User: "ada", Role: roleAdmin})
+		w := httptest.NewRecorder()
+		errorHandler(handleAdminUsers).ServeHTTP(w, r)
+		return w
+	}
+
+	if w := post("/admin/users", url.Values{"name": {"bob"}, "role": {"owner"}}); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected an unknown role refused, got %d", w.Code)
+	}
+	if w := post("/admin/users", url.Values{"name": {"bob"}, "role": {"viewer"}}); w.Code != http.StatusSeeOther {
+		t.Fatalf("Expected a redirect after adding a user, got %d: %s", w.Code, w.Body)
+	}
+	bob, _ := d.GetUserByName(ctx, "bob")
+	if bob == nil || bob.Role != "viewer" {
+		t.Fatalf("Expected bob to be added as a viewer, got %+v", bob)
+	}
+	if w := post("/admin/users/4/role", url.Values{"role": {"editor"}}); w.Code != http.StatusSeeOther {
+		t.Errorf("Expected a redirect after changing a role, got %d", w.Code)
+	}
+	if bob, _ = d.GetUserByName(ctx, "bob"); bob.Role != "editor" {
+		t.Errorf("Expected bob to be an editor, got %s", bob.Role)
+	}
+
+	w := post("/admin/users/4/tokens", url.Values{"token": {"bob-laptop"}})
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), apiTokenPrefix) {
+		t.Fatalf("Expected the issued token to be shown, got %d", w.Code)
+	}
+	var issued *APITokenRow
+	for _, t := range d.tokens {
+		if t.Name == "bob-laptop" {
+			issued = &t
+		}
+	}
+	if issued == nil || issued.UserID.Int64 != 4 {
+		t.Errorf("Expected a token issued to bob, got %+v", issued)
+	}
+
+	if w := post("/admin/users/3/delete", nil); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected admins refused deleting themselves, got %d", w.Code)
+	}
+	if w := post("/admin/users/4/delete", nil); w.Code != http.StatusSeeOther {
+		t.Errorf("Expected a redirect after deleting a user, got %d", w.Code)
+	}
+	if bob, _ = d.GetUserByName(ctx, "bob"); bob != nil {
+		t.Errorf("Expected bob to be deleted")
+	}
+}
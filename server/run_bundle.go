@@ -0,0 +1,592 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// A run bundle is a tar.gz of everything logged to a run, for moving it to
+// another server:
+//
+//	run.json          the run's metadata, parameters, tags and artifact metadata
+//	metrics.jsonl     every metric point, one JSON object per line
+//	artifacts/{path}  the current contents of each artifact
+//
+// run.json comes first, so that a bundle is imported as it is read. Importing
+// gives the run a new UUID, and stores its artifacts beneath it.
+
+// runBundleFormatVersion is the format_version of the bundles this server
+// writes, and the newest it imports
+const runBundleFormatVersion = 1
+
+// maxRunBundleManifestBytes bounds run.json
+const maxRunBundleManifestBytes = 16 << 20
+
+// RunBundle is run.json of a run bundle
+type RunBundle struct {
+	FormatVersion int               `json:"format_version"`
+	Experiment    BundledExperiment `json:"experiment"`
+	Run           BundledRun        `json:"run"`
+}
+
+// BundledExperiment is the experiment a bundled run was logged to
+type BundledExperiment struct {
+	UUID string `json:"uuid"`
+	Name string `json:"name"`
+}
+
+// BundledRun is a run as run.json records it
+type BundledRun struct {
+	UUID          string             `json:"uuid"`
+	Name          string             `json:"name"`
+	Notes         string             `json:"notes,omitempty"`
+	Status        string             `json:"status"`
+	StatusMessage string             `json:"status_message,omitempty"`
+	CreatedAt     string             `json:"created_at,omitempty"`
+	Parameters    []BundledParameter `json:"parameters"`
+	Tags          map[string]string  `json:"tags"`
+	Artifacts     []BundledArtifact  `json:"artifacts"`
+}
+
+// BundledParameter is a parameter with its type, which its JSON value alone
+// loses for whole-number floats
+type BundledParameter struct {
+	Key   string          `json:"key"`
+	Type  string          `json:"type"`
+	Value json.RawMessage `json:"value"`
+}
+
+// BundledArtifact is an artifact's metadata as run.json records it. Its file
+// is at artifacts/{path} in the bundle.
+type BundledArtifact struct {
+	ArchivedArtifact
+	ContentType string `json:"content_type,omitempty"`
+}
+
+// BundledMetricPoint is a line of metrics.jsonl
+type BundledMetricPoint struct {
+	Key string  `json:"key"`
+	X   float64 `json:"x"`
+	Y   float64 `json:"y"`
+	// LoggedAt is in epoch milliseconds
+	LoggedAt int64 `json:"logged_at"`
+}
+
+// RunBundleImport is the outcome of importing a run bundle
+type RunBundleImport struct {
+	RunUUID        string `json:"run_uuid"`
+	SourceRunUUID  string `json:"source_run_uuid"`
+	ExperimentUUID string `json:"experiment_uuid"`
+	MetricPoints   int    `json:"metric_points"`
+	Artifacts      int    `json:"artifacts"`
+}
+
+// runBundleError is a problem with a bundle that the client can fix
+type runBundleError struct {
+	message string
+}
+
+func (e *runBundleError) Error() string {
+	return e.message
+}
+
+// getRunBundle loads run.json of a run, and the artifacts whose contents the
+// bundle holds
+func getRunBundle(ctx context.Context, runID int, runUUID string) (*RunBundle, []ArtifactRow, error) {
+	run, err := dao.GetRunByUUID(ctx, runUUID)
+	if err != nil {
+		return nil, nil, err
+	}
+	bundle := &RunBundle{
+		FormatVersion: runBundleFormatVersion,
+		Run: BundledRun{
+			UUID:          run.UUID,
+			Name:          run.Name,
+			Notes:         run.Notes,
+			Status:        run.Status,
+			StatusMessage: run.StatusMessage,
+			Parameters:    []BundledParameter{},
+			Tags:          map[string]string{},
+			Artifacts:     []BundledArtifact{},
+		},
+	}
+
+	experiment, err := dao.GetExperimentForRunUUID(ctx, runUUID)
+	if err != nil {
+		return nil, nil, err
+	}
+	bundle.Experiment = BundledExperiment{UUID: experiment.UUID, Name: experiment.Name}
+	// GetRunByUUID leaves out when the run was created
+	experimentID, err := dao.GetRunExperimentID(ctx, runID)
+	if err != nil {
+		return nil, nil, err
+	}
+	runs, err := dao.GetRunsByExperimentID(ctx, experimentID)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, r := range runs {
+		if r.UUID == runUUID {
+			bundle.Run.CreatedAt = r.CreatedAt
+		}
+	}
+
+	params, err := dao.GetParametersByRunID(ctx, runID)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, p := range params {
+		value, err := json.Marshal(parameterJSONValue(p))
+		if err != nil {
+			return nil, nil, fmt.Errorf("parameter %s: %w", p.Key, err)
+		}
+		bundle.Run.Parameters = append(bundle.Run.Parameters, BundledParameter{Key: p.Key, Type: p.ValueType, Value: value})
+	}
+	tags, err := dao.GetRunTags(ctx, runID)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, tag := range tags {
+		bundle.Run.Tags[tag.Key] = tag.Value
+	}
+
+	all, err := dao.GetArtifactsByRunID(ctx, runID)
+	if err != nil {
+		return nil, nil, err
+	}
+	var artifacts []ArtifactRow
+	for _, a := range all {
+		// Artifacts the malware scanner holds back are left out, as from
+		// any other download
+		if !artifactDownloadable(a) {
+			continue
+		}
+		artifacts = append(artifacts, a)
+		bundle.Run.Artifacts = append(bundle.Run.Artifacts, BundledArtifact{
+			ArchivedArtifact: ArchivedArtifact{Path: a.Path, Type: a.Type, SizeBytes: a.SizeBytes, SHA256: a.SHA256},
+			ContentType:      a.ContentType,
+		})
+	}
+	return bundle, artifacts, nil
+}
+
+// writeRunBundle writes the bundle of a run as a tar.gz
+func writeRunBundle(ctx context.Context, w io.Writer, runUUID string) error {
+	runID, err := dao.GetRunIDByUUID(ctx, runUUID)
+	if err != nil {
+		return err
+	}
+	bundle, artifacts, err := getRunBundle(ctx, runID, runUUID)
+	if err != nil {
+		return err
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	now := time.Now()
+	manifest, err := json.MarshalIndent(bundle, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeRunBundleEntry(tw, "run.json", now, int64(len(manifest)), bytes.NewReader(manifest)); err != nil {
+		return err
+	}
+
+	// A tar entry's size comes before its contents, so metric points are
+	// spooled to a temporary file rather than held in memory
+	metrics, err := os.CreateTemp("", "apparatus-metrics-*.jsonl")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(metrics.Name())
+	defer metrics.Close()
+	rows, err := dao.GetMetricsByRunID(ctx, runID)
+	if err != nil {
+		return fmt.Errorf("loading metrics: %w", err)
+	}
+	encoder := json.NewEncoder(metrics)
+	for _, m := range rows {
+		if err := encoder.Encode(BundledMetricPoint{Key: m.Key, X: m.XValue, Y: m.YValue, LoggedAt: m.LoggedAt.UnixMilli()}); err != nil {
+			return fmt.Errorf("writing metric %s: %w", m.Key, err)
+		}
+	}
+	size, err := metrics.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := metrics.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := writeRunBundleEntry(tw, "metrics.jsonl", now, size, metrics); err != nil {
+		return err
+	}
+
+	for _, a := range artifacts {
+		key, err := artifactKey(a.URI)
+		if err != nil {
+			return err
+		}
+		size, err := artifactStore.Size(key)
+		if err != nil {
+			return fmt.Errorf("artifact %s: %w", a.Path, err)
+		}
+		file, err := artifactStore.Get(key)
+		if err != nil {
+			return fmt.Errorf("opening %s: %w", a.Path, err)
+		}
+		err = writeRunBundleEntry(tw, "artifacts/"+a.Path, a.UpdatedAt.Time, size, file)
+		file.Close()
+		if err != nil {
+			return fmt.Errorf("bundling %s: %w", a.Path, err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// writeRunBundleEntry adds a file to a bundle
+func writeRunBundleEntry(tw *tar.Writer, name string, modTime time.Time, size int64, r io.Reader) error {
+	err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: size, ModTime: modTime, Typeflag: tar.TypeReg})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, r)
+	return err
+}
+
+// readRunBundleManifest reads run.json, the first entry of a bundle, and
+// checks it before anything is imported
+func readRunBundleManifest(tr *tar.Reader) (*RunBundle, []ParameterRow, error) {
+	header, err := tr.Next()
+	if err != nil || header.Name != "run.json" {
+		return nil, nil, &runBundleError{"bundle must be a tar.gz beginning with run.json"}
+	}
+	var bundle RunBundle
+	if err := json.NewDecoder(io.LimitReader(tr, maxRunBundleManifestBytes)).Decode(&bundle); err != nil {
+		return nil, nil, &runBundleError{fmt.Sprintf("invalid run.json: %v", err)}
+	}
+	if bundle.FormatVersion < 1 || bundle.FormatVersion > runBundleFormatVersion {
+		return nil, nil, &runBundleError{fmt.Sprintf("unsupported bundle format_version %d", bundle.FormatVersion)}
+	}
+	if bundle.Run.Name == "" {
+		return nil, nil, &runBundleError{"run.json has no run name"}
+	}
+	if bundle.Run.Status != runStatusRunning && !slices.Contains(runTerminalStatuses, bundle.Run.Status) {
+		return nil, nil, &runBundleError{fmt.Sprintf("run.json has unknown status %q", bundle.Run.Status)}
+	}
+	params := make([]ParameterRow, 0, len(bundle.Run.Parameters))
+	for _, bp := range bundle.Run.Parameters {
+		p, err := parseParameterJSONValue(bp.Value, bp.Type)
+		if err != nil {
+			return nil, nil, &runBundleError{fmt.Sprintf("parameter %s: %v", bp.Key, err)}
+		}
+		p.Key = bp.Key
+		params = append(params, p)
+	}
+	for _, a := range bundle.Run.Artifacts {
+		if err := isValidArtifactPath(a.Path); err != nil {
+			return nil, nil, &runBundleError{fmt.Sprintf("artifact %s: %v", a.Path, err)}
+		}
+	}
+	return &bundle, params, nil
+}
+
+// importRunBundle creates a run in an experiment from a bundle, with a new
+// UUID. The run is tagged with the UUID it had where it was exported. If the
+// bundle turns out to be broken part way through, the run is deleted.
+func importRunBundle(ctx context.Context, r io.Reader, experimentID int) (*RunBundleImport, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, &runBundleError{"bundle must be a tar.gz beginning with run.json"}
+	}
+	tr := tar.NewReader(gz)
+	bundle, params, err := readRunBundleManifest(tr)
+	if err != nil {
+		return nil, err
+	}
+
+	runUUID := newUUID(ctx)
+	if err := dao.InsertRun(ctx, runUUID, bundle.Run.Name, experimentID, nil); err != nil {
+		return nil, err
+	}
+	runID, err := dao.GetRunIDByUUID(ctx, runUUID)
+	if err != nil {
+		return nil, err
+	}
+	imported := &RunBundleImport{RunUUID: runUUID, SourceRunUUID: bundle.Run.UUID}
+	if err := importRunBundleContents(ctx, tr, runID, runUUID, bundle, params, imported); err != nil {
+		if deleteErr := dao.MarkRunDeleted(ctx, runID, time.Now(), ""); deleteErr != nil {
+			log.Printf("Failed to delete run %s after its import failed: %v", runUUID, deleteErr)
+		}
+		return nil, err
+	}
+	return imported, nil
+}
+
+// importRunBundleContents logs what a bundle holds to the run imported from
+// it, counting the metric points and artifacts in imported
+func importRunBundleContents(ctx context.Context, tr *tar.Reader, runID int, runUUID string, bundle *RunBundle, params []ParameterRow, imported *RunBundleImport) error {
+	if err := dao.UpsertParameters(ctx, runID, params); err != nil {
+		return err
+	}
+	if bundle.Run.Notes != "" {
+		if err := dao.UpdateRunNotes(ctx, runID, bundle.Run.Notes); err != nil {
+			return err
+		}
+	}
+	for key, value := range bundle.Run.Tags {
+		if err := dao.SetRunTag(ctx, runID, key, value); err != nil {
+			return err
+		}
+	}
+	if bundle.Run.UUID != "" {
+		if err := dao.SetRunTag(ctx, runID, "imported_from", bundle.Run.UUID); err != nil {
+			return err
+		}
+	}
+
+	artifacts := make(map[string]BundledArtifact, len(bundle.Run.Artifacts))
+	for _, a := range bundle.Run.Artifacts {
+		artifacts[a.Path] = a
+	}
+	runKey, err := runArtifactKey(ctx, runID, runUUID)
+	if err != nil {
+		return err
+	}
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return &runBundleError{fmt.Sprintf("reading bundle: %v", err)}
+		}
+		if header.Name == "metrics.jsonl" {
+			points, err := importRunBundleMetrics(ctx, tr, runID)
+			if err != nil {
+				return err
+			}
+			imported.MetricPoints += points
+			continue
+		}
+		artifactPath, ok := strings.CutPrefix(header.Name, "artifacts/")
+		if !ok || header.Typeflag != tar.TypeReg {
+			// Entries a newer server adds are skipped
+			continue
+		}
+		a, ok := artifacts[artifactPath]
+		if !ok {
+			return &runBundleError{fmt.Sprintf("%s is not listed in run.json", header.Name)}
+		}
+		delete(artifacts, artifactPath)
+		uri, size, digest, err := storeArtifact(runKey, artifactPath, 1, tr)
+		if err != nil {
+			return fmt.Errorf("storing %s: %w", artifactPath, err)
+		}
+		if a.SHA256 != "" && a.SHA256 != digest {
+			return &runBundleError{fmt.Sprintf("%s does not match its SHA-256 digest in run.json", header.Name)}
+		}
+		if err := dao.UpsertArtifact(ctx, runID, artifactPath, uri, a.Type, a.ContentType, size, digest); err != nil {
+			return err
+		}
+		// Contents from another server are scanned like any upload
+		scanUploadedArtifact(ctx, uri)
+		serverStats.artifactUploadBytes.Add(size)
+		imported.Artifacts++
+	}
+	if len(artifacts) > 0 {
+		missing := slices.Sorted(maps.Keys(artifacts))
+		return &runBundleError{fmt.Sprintf("bundle is missing artifacts/%s", missing[0])}
+	}
+
+	if err := dao.UpdateRunStatus(ctx, runID, bundle.Run.Status, bundle.Run.StatusMessage); err != nil {
+		return err
+	}
+	if bundle.Run.CreatedAt != "" {
+		createdAt, err := parseImportDate(bundle.Run.CreatedAt)
+		if err != nil {
+			log.Printf("Not backdating run %s imported from %s: %v", runUUID, bundle.Run.UUID, err)
+			return nil
+		}
+		return dao.BackdateRun(ctx, runID, createdAt)
+	}
+	return nil
+}
+
+// importRunBundleMetrics logs the points of metrics.jsonl to a run, batching
+// consecutive points of a key logged at the same time, and returns how many
+// there were
+func importRunBundleMetrics(ctx context.Context, r io.Reader, runID int) (int, error) {
+	decoder := json.NewDecoder(r)
+	var batch BundledMetricPoint
+	var xs, ys []float64
+	flush := func() error {
+		if len(xs) == 0 {
+			return nil
+		}
+		err := dao.InsertMetrics(ctx, runID, batch.Key, xs, ys, batch.LoggedAt)
+		xs, ys = xs[:0], ys[:0]
+		return err
+	}
+	points := 0
+	for line := 1; ; line++ {
+		var p BundledMetricPoint
+		if err := decoder.Decode(&p); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return 0, &runBundleError{fmt.Sprintf("metrics.jsonl line %d: %v", line, err)}
+		}
+		if p.Key == "" {
+			return 0, &runBundleError{fmt.Sprintf("metrics.jsonl line %d has no key", line)}
+		}
+		if len(xs) > 0 && (p.Key != batch.Key || p.LoggedAt != batch.LoggedAt) {
+			if err := flush(); err != nil {
+				return 0, err
+			}
+		}
+		batch = p
+		xs, ys = append(xs, p.X), append(ys, p.Y)
+		points++
+	}
+	return points, flush()
+}
+
+// handleAPIImportRunBundle imports a run bundle, at POST /api/runs/import with
+// the tar.gz as the request body, into the experiment given as
+// experiment_uuid, or the default experiment
+func handleAPIImportRunBundle(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	experimentUUID := r.URL.Query().Get("experiment_uuid")
+	if experimentUUID == "" && tokenProjectID(r) != 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "experiment_uuid is required with a token scoped to a project"})
+		return
+	}
+	var experiment *Experiment
+	var err error
+	if experimentUUID == "" {
+		var defaultID int
+		if defaultID, err = dao.GetDefaultExperimentID(ctx); err == nil {
+			experiment, err = dao.GetExperimentByID(ctx, defaultID)
+		}
+	} else {
+		experiment, err = dao.GetExperimentByUUID(ctx, experimentUUID)
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Experiment not found"})
+		return
+	}
+	experimentID, err := dao.GetExperimentIDByUUID(ctx, experiment.UUID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Experiment not found"})
+		return
+	}
+
+	if !quotaExempt(r) {
+		size := max(r.ContentLength, 1)
+		if err := checkExperimentQuota(ctx, experimentID, 1, 0, size); writeQuotaError(w, err) {
+			return
+		}
+	}
+
+	imported, err := importRunBundle(ctx, r.Body, experimentID)
+	var bundleErr *runBundleError
+	if errors.As(err, &bundleErr) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": bundleErr.message})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to import run bundle: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to import run"})
+		return
+	}
+	recordMetricPointUsage(ctx, experimentID, imported.MetricPoints)
+	imported.ExperimentUUID = experiment.UUID
+	json.NewEncoder(w).Encode(imported)
+}
+
+// runExportRunCommand implements `apparatus-server export-run`, which writes
+// the bundle of a run for POST /api/runs/import on another server
+func runExportRunCommand(ctx context.Context, args []string) {
+	flags := flag.NewFlagSet("export-run", flag.ExitOnError)
+	configPath := defineConfigFlag(flags)
+	dbConnString := flags.String("db", "sqlite:///apparatus.db", "Database connection string (e.g., sqlite:///path/to/db.db)")
+	artifactStoreURI := flags.String("artifact-store-uri", "file://artifacts", "URI for location to store artifacts (e.g. file:///path/to/artifacts or s3://bucket/prefix)")
+	output := flags.String("o", "", "File to write the bundle to, or - for standard output (default {uuid}.tar.gz)")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s export-run [flags] <run uuid>\n\nWrites a run's metadata, parameters, metrics and artifacts to a tar.gz bundle. Import it on another server with\n\n  curl -H 'Authorization: Bearer $TOKEN' --data-binary @{uuid}.tar.gz -H 'Content-Type: application/gzip' https://server/api/runs/import\n\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	if _, err := loadServerConfig(flags, *configPath); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	runUUID := flags.Arg(0)
+
+	initDB(*dbConnString)
+	initArtifactStore(*artifactStoreURI)
+
+	if *output == "" {
+		*output = runUUID + ".tar.gz"
+	}
+	if *output == "-" {
+		if err := writeRunBundle(ctx, os.Stdout, runUUID); err != nil {
+			exitExportRun(runUUID, err)
+		}
+		return
+	}
+	file, err := os.Create(*output)
+	if err != nil {
+		log.Fatalf("Failed to create %s: %v", *output, err)
+	}
+	err = writeRunBundle(ctx, file, runUUID)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		// Leave no partial bundle behind to be imported by mistake
+		os.Remove(*output)
+		exitExportRun(runUUID, err)
+	}
+	fmt.Fprintf(os.Stderr, "Wrote %s\n", *output)
+}
+
+// exitExportRun reports why a run could not be exported and exits
+func exitExportRun(runUUID string, err error) {
+	if errors.Is(err, sql.ErrNoRows) {
+		log.Fatalf("Run %s not found", runUUID)
+	}
+	log.Fatalf("Failed to export run %s: %v", runUUID, err)
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// bundledRunState is what bundleDAO holds about a run
+type bundledRunState struct {
+	Run
+	experimentID int
+	createdAt    time.Time
+	params       []ParameterRow
+	tags         []RunTagRow
+	metrics      []MetricRow
+	artifacts    []ArtifactRow
+	deleted      bool
+}
+
+// bundleDAO holds runs in memory, starting with run-1, so that runs can be
+// exported and imported again
+type bundleDAO struct {
+	defaultProjectDAO
+	runs []*bundledRunState
+}
+
+func newBundleDAO() *bundleDAO {
+	return &bundleDAO{runs: []*bundledRunState{{
+		Run:          Run{UUID: "run-1", Name: "resnet", Notes: "lr sweep", Status: runStatusFailed, StatusMessage: "RuntimeError: CUDA out of memory", CreatedAt: "2024-03-01T12:00:00Z"},
+		experimentID: 1,
+		params: []ParameterRow{
+			{Key: "lr", ValueType: "float", ValueFloat: sql.NullFloat64{Float64: 1, Valid: true}},
+			{Key: "epochs", ValueType: "int", ValueInt: sql.NullInt64{Int64: 10, Valid: true}},
+			{Key: "optimizer", ValueType: "string", ValueString: sql.NullString{String: "adam", Valid: true}},
+		},
+		tags: []RunTagRow{{Key: "team", Value: "vision"}},
+		metrics: []MetricRow{
+			{Key: "loss", XValue: 0, YValue: 1.5, LoggedAt: time.UnixMilli(1709294400000)},
+			{Key: "loss", XValue: 1, YValue: 0.5, LoggedAt: time.UnixMilli(1709294400000)},
+			{Key: "loss", XValue: 2, YValue: 0.25, LoggedAt: time.UnixMilli(1709294460000)},
+		},
+	}}}
+}
+
+func (d *bundleDAO) run(id int) *bundledRunState {
+	return d.runs[id-1]
+}
+
+func (d *bundleDAO) GetRunIDByUUID(ctx context.Context, uuid string) (int, error) {
+	for i, run := range d.runs {
+		if run.UUID == uuid && !run.deleted {
+			return i + 1, nil
+		}
+	}
+	return 0, sql.ErrNoRows
+}
+
+func (d *bundleDAO) GetRunByUUID(ctx context.Context, uuid string) (*Run, error) {
+	id, err := d.GetRunIDByUUID(ctx, uuid)
+	if err != nil {
+		return nil, err
+	}
+	run := d.run(id).Run
+	run.CreatedAt = ""
+	return &run, nil
+}
+
+func (d *bundleDAO) InsertRun(ctx context.Context, uuid, name string, experimentID int, parentRunID *int) error {
+	d.runs = append(d.runs, &bundledRunState{Run: Run{UUID: uuid, Name: name, Status: runStatusRunning}, experimentID: experimentID})
+	return nil
+}
+
+func (d *bundleDAO) GetRunExperimentID(ctx context.Context, runID int) (int, error) {
+	return d.run(runID).experimentID, nil
+}
+
+func (d *bundleDAO) GetRunsByExperimentID(ctx context.Context, experimentID int) ([]Run, error) {
+	var runs []Run
+	for _, run := range d.runs {
+		if run.experimentID == experimentID {
+			runs = append(runs, run.Run)
+		}
+	}
+	return runs, nil
+}
+
+func (d *bundleDAO) GetExperimentForRunUUID(ctx context.Context, runUUID string) (*Experiment, error) {
+	id, err := d.GetRunIDByUUID(ctx, runUUID)
+	if err != nil {
+		return nil, err
+	}
+	return d.GetExperimentByID(ctx, d.run(id).experimentID)
+}
+
+func (d *bundleDAO) GetExperimentByID(ctx context.Context, id int) (*Experiment, error) {
+	return &Experiment{UUID: []string{"", "exp-1", "exp-2"}[id], Name: "experiment"}, nil
+}
+
+func (d *bundleDAO) GetExperimentByUUID(ctx context.Context, uuid string) (*Experiment, error) {
+	id, err := d.GetExperimentIDByUUID(ctx, uuid)
+	if err != nil {
+		return nil, err
+	}
+	return d.GetExperimentByID(ctx, id)
+}
+
+func (d *bundleDAO) GetExperimentIDByUUID(ctx context.Context, uuid string) (int, error) {
+	switch uuid {
+	case "exp-1":
+		return 1, nil
+	case "exp-2":
+		return 2, nil
+	}
+	return 0, sql.ErrNoRows
+}
+
+func (d *bundleDAO) GetDefaultExperimentID(ctx context.Context) (int, error) {
+	return 1, nil
+}
+
+func (d *bundleDAO) GetExperimentQuota(ctx context.Context, experimentID int) (*ExperimentQuotaRow, error) {
+	return nil, nil
+}
+
+func (d *bundleDAO) AddExperimentMetricPoints(ctx context.Context, experimentID int, day string, points int) error {
+	return nil
+}
+
+func (d *bundleDAO) GetParametersByRunID(ctx context.Context, runID int) ([]ParameterRow, error) {
+	return d.run(runID).params, nil
+}
+
+func (d *bundleDAO) UpsertParameters(ctx context.Context, runID int, params []ParameterRow) error {
+	d.run(runID).params = append(d.run(runID).params, params...)
+	return nil
+}
+
+func (d *bundleDAO) UpdateRunNotes(ctx context.Context, runID int, notes string) error {
+	d.run(runID).Notes = notes
+	return nil
+}
+
+func (d *bundleDAO) GetRunTags(ctx context.Context, runID int) ([]RunTagRow, error) {
+	return d.run(runID).tags, nil
+}
+
+func (d *bundleDAO) SetRunTag(ctx context.Context, runID int, key, value string) error {
+	d.run(runID).tags = append(d.run(runID).tags, RunTagRow{Key: key, Value: value})
+	return nil
+}
+
+func (d *bundleDAO) GetMetricsByRunID(ctx context.Context, runID int) ([]MetricRow, error) {
+	return d.run(runID).metrics, nil
+}
+
+func (d *bundleDAO) InsertMetrics(ctx context.Context, runID int, key string, xValues []float64, yValues []float64, loggedAt int64) error {
+	for i := range xValues {
+		d.run(runID).metrics = append(d.run(runID).metrics, MetricRow{Key: key, XValue: xValues[i], YValue: yValues[i], LoggedAt: time.UnixMilli(loggedAt)})
+	}
+	return nil
+}
+
+func (d *bundleDAO) GetArtifactsByRunID(ctx context.Context, runID int) ([]ArtifactRow, error) {
+	return d.run(runID).artifacts, nil
+}
+
+func (d *bundleDAO) UpsertArtifact(ctx context.Context, runID int, path, uri, artifactType, contentType string, sizeBytes int64, sha256 string) error {
+	d.run(runID).artifacts = append(d.run(runID).artifacts, ArtifactRow{Path: path, URI: uri, Type: artifactType, ContentType: contentType, SizeBytes: sizeBytes, SHA256: sha256, Version: 1})
+	return nil
+}
+
+func (d *bundleDAO) UpdateRunStatus(ctx context.Context, runID int, status, message string) error {
+	d.run(runID).Status = status
+	d.run(runID).StatusMessage = message
+	return nil
+}
+
+func (d *bundleDAO) BackdateRun(ctx context.Context, runID int, at time.Time) error {
+	d.run(runID).createdAt = at
+	return nil
+}
+
+func (d *bundleDAO) MarkRunDeleted(ctx context.Context, runID int, at time.Time, deletedBy string) error {
+	d.run(runID).deleted = true
+	return nil
+}
+
+func TestRunBundleRoundTrip(t *testing.T) {
+	ctx := t.Context()
+	defer func(d DAO) { dao = d }(dao)
+	defer func(s ArtifactStore) { artifactStore = s }(artifactStore)
+	fake := newBundleDAO()
+	dao = fake
+	store, err := newFileArtifactStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	artifactStore = store
+	uri, size, digest, err := storeArtifact("run-1", "plots/loss.txt", 1, strings.NewReader("loss went down"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake.run(1).artifacts = []ArtifactRow{{Path: "plots/loss.txt", URI: uri, Type: "text", ContentType: "text/plain", SizeBytes: size, SHA256: digest, Version: 1}}
+
+	var bundle bytes.Buffer
+	if err := writeRunBundle(ctx, &bundle, "run-1"); err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	handleAPIImportRunBundle(w, httptest.NewRequest(http.MethodPost, "/api/runs/import?experiment_uuid=exp-2", bytes.NewReader(bundle.Bytes())))
+	var imported RunBundleImport
+	if err := json.NewDecoder(w.Body).Decode(&imported); err != nil || w.Code != http.StatusOK {
+		t.Fatalf("Expected the bundle imported, got %d %v", w.Code, err)
+	}
+	if imported.SourceRunUUID != "run-1" || imported.RunUUID == "run-1" || imported.ExperimentUUID != "exp-2" || imported.MetricPoints != 3 || imported.Artifacts != 1 {
+		t.Errorf("Expected a new run in exp-2 with 3 points and an artifact, got %+v", imported)
+	}
+
+	run := fake.run(2)
+	if run.UUID != imported.RunUUID || run.Name != "resnet" || run.Notes != "lr sweep" || run.Status != runStatusFailed || run.StatusMessage != "RuntimeError: CUDA out of memory" || run.experimentID != 2 {
+		t.Errorf("Expected the run's metadata imported, got %+v", run)
+	}
+	if !run.createdAt.Equal(time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected the run backdated to when it was created, got %v", run.createdAt)
+	}
+	if len(run.params) != 3 || run.params[0].ValueType != "float" || run.params[0].ValueFloat.Float64 != 1 || run.params[1].ValueInt.Int64 != 10 {
+		t.Errorf("Expected parameters imported with their types, got %+v", run.params)
+	}
+	if len(run.tags) != 2 || run.tags[1] != (RunTagRow{Key: "imported_from", Value: "run-1"}) {
+		t.Errorf("Expected the run's tags and where it was imported from, got %+v", run.tags)
+	}
+	if len(run.metrics) != 3 || run.metrics[2].YValue != 0.25 || !run.metrics[2].LoggedAt.Equal(time.UnixMilli(1709294460000)) {
+		t.Errorf("Expected every metric point imported, got %+v", run.metrics)
+	}
+	if len(run.artifacts) != 1 || run.artifacts[0].SHA256 != digest || run.artifacts[0].ContentType != "text/plain" {
+		t.Fatalf("Expected the artifact imported, got %+v", run.artifacts)
+	}
+	contents, err := openArtifact(ctx, run.artifacts[0].URI)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer contents.Close()
+	if b, _ := io.ReadAll(contents); string(b) != "loss went down" || !strings.Contains(run.artifacts[0].URI, imported.RunUUID+"/plots/loss.txt") {
+		t.Errorf("Expected the artifact stored beneath the new run, got %q at %s", b, run.artifacts[0].URI)
+	}
+}
+
+func TestImportRunBundleErrors(t *testing.T) {
+	defer func(d DAO) { dao = d }(dao)
+	defer func(s ArtifactStore) { artifactStore = s }(artifactStore)
+	fake := newBundleDAO()
+	dao = fake
+	store, err := newFileArtifactStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	artifactStore = store
+
+	// bundle writes a tar.gz of entries in order
+	bundle := func(entries ...[2]string) []byte {
+		var b bytes.Buffer
+		gz := gzip.NewWriter(&b)
+		tw := tar.NewWriter(gz)
+		for _, e := range entries {
+			tw.WriteHeader(&tar.Header{Name: e[0], Mode: 0o644, Size: int64(len(e[1])), Typeflag: tar.TypeReg})
+			tw.Write([]byte(e[1]))
+		}
+		tw.Close()
+		gz.Close()
+		return b.Bytes()
+	}
+	manifest := `{"format_version": 1, "run": {"uuid": "run-9", "name": "n", "status": "FINISHED",
+		"artifacts": [{"path": "a.txt", "type": "text", "size_bytes": 1, "sha256": "0000"}]}}`
+	post := func(body []byte) (int, string) {
+		w := httptest.NewRecorder()
+		handleAPIImportRunBundle(w, httptest.NewRequest(http.MethodPost, "/api/runs/import", bytes.NewReader(body)))
+		return w.Code, w.Body.String()
+	}
+
+	for name, body := range map[string][]byte{
+		"not gzip":         []byte("run.json"),
+		"no run.json":      bundle([2]string{"metrics.jsonl", ""}),
+		"newer format":     bundle([2]string{"run.json", `{"format_version": 2, "run": {"name": "n", "status": "FINISHED"}}`}),
+		"unknown status":   bundle([2]string{"run.json", `{"format_version": 1, "run": {"name": "n", "status": "DONE"}}`}),
+		"bad parameter":    bundle([2]string{"run.json", `{"format_version": 1, "run": {"name": "n", "status": "FINISHED", "parameters": [{"key": "k", "type": "int", "value": "x"}]}}`}),
+		"wrong digest":     bundle([2]string{"run.json", manifest}, [2]string{"artifacts/a.txt", "a"}),
+		"missing artifact": bundle([2]string{"run.json", manifest}),
+		"unlisted":         bundle([2]string{"run.json", manifest}, [2]string{"artifacts/b.txt", "b"}),
+		"bad metric":       bundle([2]string{"run.json", manifest}, [2]string{"metrics.jsonl", `{"x": 1}`}),
+	} {
+		if code, resp := post(body); code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d %s", name, code, resp)
+		}
+	}
+	for _, run := range fake.runs[1:] {
+		if !run.deleted {
+			t.Errorf("Expected runs of broken bundles deleted, got %+v", run)
+		}
+	}
+}
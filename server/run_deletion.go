@@ -0,0 +1,382 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Deleting a run happens in two steps. The run and its child runs are first
+// marked deleted, which hides them everywhere and stops anything more being
+// logged to them. Their artifacts and rows are then purged. A run whose purge
+// fails, e.g. because the artifact store is unreachable, stays deleted and is
+// purged by the trash-purge housekeeping job.
+//
+// Deleted runs are kept in the trash for -purge-deleted-runs-after-days, and
+// can be restored from it until then (see trash.go). After that they are
+// eligible for the trash-purge housekeeping job, which like every other
+// housekeeping job only purges the runs listed in a reviewed dry run. Setting
+// it to 0 purges runs as soon as they are deleted instead. Whoever deletes a
+// run may leave an email address, which is sent a notice -purge-notice-days
+// before the run is purged, every trashPurgeInterval; the run is not eligible
+// until the notice has been sent.
+
+var (
+	// trashPurgeAfterDays is how many days deleted runs are kept before they
+	// are purged (0 purges them as soon as they are deleted)
+	trashPurgeAfterDays = 30
+	// trashPurgeNoticeDays is how many days before a deleted run is purged
+	// whoever deleted it is told
+	trashPurgeNoticeDays = 3
+)
+
+// trashPurgeNow is the time deleted runs are purged as of, and is replaced
+// in tests
+var trashPurgeNow = time.Now
+
+// trashPurgeInterval is how often notices of upcoming purges are sent
+const trashPurgeInterval = time.Hour
+
+// runPurgeAt is when a deleted run is due to be purged
+func runPurgeAt(run DeletedRunRow) time.Time {
+	return run.DeletedAt.AddDate(0, 0, trashPurgeAfterDays)
+}
+
+// runPurgeDue reports whether a deleted run can be purged: it has been in
+// the trash long enough, and whoever deleted it was told it would be
+func runPurgeDue(run DeletedRunRow, now time.Time) bool {
+	if trashPurgeAfterDays <= 0 {
+		return true
+	}
+	if now.Before(runPurgeAt(run)) {
+		return false
+	}
+	return run.DeletedBy == "" || run.PurgeNoticeSentAt.Valid
+}
+
+// getRunSubtree returns the IDs of a run and of its descendants
+func getRunSubtree(ctx context.Context, runID int) ([]int, error) {
+	ids := []int{runID}
+	for i := 0; i < len(ids); i++ {
+		children, err := dao.GetChildRuns(ctx, ids[i])
+		if err != nil {
+			return nil, err
+		}
+		for _, child := range children {
+			childID, err := dao.GetRunIDByUUID(ctx, child.UUID)
+			if err != nil {
+				return nil, err
+			}
+			ids = append(ids, childID)
+		}
+	}
+	return ids, nil
+}
+
+// deleteRun deletes a run and its child runs, recording the email address of
+// whoever deleted them, if given, to tell before they are purged. It returns
+// errRunOnHold, without deleting anything, if any of them is on hold. The
+// runs are deleted once they are marked. Without a grace period they are
+// purged straight away, and failing to purge them is only logged.
+func deleteRun(ctx context.Context, runID int, deletedBy string) error {
+	ids, err := getRunSubtree(ctx, runID)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if err := ensureRunNotOnHold(ctx, id); err != nil {
+			return err
+		}
+	}
+
+	now := time.Now().UTC()
+	for _, id := range ids {
+		if err := dao.MarkRunDeleted(ctx, id, now, deletedBy); err != nil {
+			return err
+		}
+	}
+	if trashPurgeAfterDays <= 0 {
+		// The purge outlives the request, and is retried in the background
+		// if it fails
+		if err := purgeRuns(context.WithoutCancel(ctx), ids); err != nil {
+			log.Printf("Failed to purge deleted run %d: %v", runID, err)
+		}
+	}
+	return nil
+}
+
+// purgeRuns purges the deleted runs among ids now, whether or not they are
+// due, returning their errors. Runs that fail to purge stay deleted.
+func purgeRuns(ctx context.Context, ids []int) error {
+	runs, err := dao.GetDeletedRuns(ctx)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, run := range runs {
+		if !slices.Contains(ids, run.ID) {
+			continue
+		}
+		if err := purgeRun(ctx, run); err != nil {
+			errs = append(errs, fmt.Errorf("run %s: %w", run.UUID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// planTrashPurge lists the deleted runs due to be purged, for the
+// trash-purge housekeeping job. Runs still in their grace period, or whose
+// deleter has not yet been sent the purge notice, are skipped.
+func planTrashPurge(ctx context.Context) (items, skipped []HousekeepingItem, err error) {
+	runs, err := dao.GetDeletedRuns(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	sort.Slice(runs, func(i, j int) bool { return runs[i].UUID < runs[j].UUID })
+	now := trashPurgeNow()
+	for _, run := range runs {
+		item := HousekeepingItem{Kind: "deleted_run", Key: run.UUID, RunUUID: run.UUID}
+		artifacts, err := dao.GetArtifactsByRunID(ctx, run.ID)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, a := range artifacts {
+			item.Bytes += a.SizeBytes
+		}
+		if !runPurgeDue(run, now) {
+			item.Reason = fmt.Sprintf("in the trash until %s", runPurgeAt(run).UTC().Format("2006-01-02 15:04 MST"))
+			if !now.Before(runPurgeAt(run)) {
+				item.Reason = "purge notice not sent yet"
+			}
+			skipped = append(skipped, item)
+			continue
+		}
+		items = append(items, item)
+	}
+	return items, skipped, nil
+}
+
+// purgeDeletedRun purges a deleted run listed by planTrashPurge. A run
+// already purged, or restored since, is done.
+func purgeDeletedRun(ctx context.Context, item HousekeepingItem) error {
+	runs, err := dao.GetDeletedRuns(ctx)
+	if err != nil {
+		return err
+	}
+	for _, run := range runs {
+		if run.UUID == item.Key {
+			return purgeRun(ctx, run)
+		}
+	}
+	return nil
+}
+
+// sendPurgeNotices emails whoever deleted runs that are to be purged within
+// trashPurgeNoticeDays, once, listing each person's runs in one email. It
+// returns how many runs' notices were sent.
+func sendPurgeNotices(ctx context.Context, now time.Time) (int, error) {
+	runs, err := dao.GetDeletedRuns(ctx)
+	if err != nil {
+		return 0, err
+	}
+	byRecipient := make(map[string][]DeletedRunRow)
+	for _, run := range runs {
+		if run.DeletedBy == "" || run.PurgeNoticeSentAt.Valid || now.Before(runPurgeAt(run).AddDate(0, 0, -trashPurgeNoticeDays)) {
+			continue
+		}
+		byRecipient[run.DeletedBy] = append(byRecipient[run.DeletedBy], run)
+	}
+	recipients := make([]string, 0, len(byRecipient))
+	for to := range byRecipient {
+		recipients = append(recipients, to)
+	}
+	sort.Strings(recipients)
+
+	sent := 0
+	var firstErr error
+	for _, to := range recipients {
+		runs := byRecipient[to]
+		if err := sendMail(smtpAddr, nil, smtpFrom, []string{to}, purgeNoticeEmail(to, runs)); err != nil {
+			log.Printf("Failed to send purge notice to %s: %v", to, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		for _, run := range runs {
+			if err := dao.MarkRunPurgeNoticeSent(ctx, run.ID, now.UTC()); err != nil {
+				return sent, err
+			}
+			sent++
+		}
+	}
+	return sent, firstErr
+}
+
+// purgeNoticeEmail writes the message telling whoever deleted runs when they
+// will be purged
+func purgeNoticeEmail(to string, runs []DeletedRunRow) []byte {
+	subject := fmt.Sprintf("Deleted run %q will be purged", runs[0].Name)
+	if len(runs) > 1 {
+		subject = fmt.Sprintf("%d deleted runs will be purged", len(runs))
+	}
+	var body strings.Builder
+	body.WriteString("These runs you deleted will be purged, with their artifacts, and can no longer be restored:\r\n\r\n")
+	for _, run := range runs {
+		fmt.Fprintf(&body, "%s (%s), deleted %s, purged after %s\r\n", run.Name, run.UUID,
+			run.DeletedAt.UTC().Format("2006-01-02"), runPurgeAt(run).UTC().Format("2006-01-02 15:04 MST"))
+	}
+	return []byte("From: " + smtpFrom + "\r\n" +
+		"To: " + to + "\r\n" +
+		"Subject: [apparatus] " + subject + "\r\n" +
+		"\r\n" + body.String())
+}
+
+// startTrashPurge tells whoever deleted runs, in the background, that they
+// are about to be purged, which makes the runs eligible for the trash-purge
+// housekeeping job once they have been in the trash for trashPurgeAfterDays
+func startTrashPurge(ctx context.Context) {
+	if trashPurgeAfterDays <= 0 {
+		return
+	}
+	if smtpAddr != "" {
+		go func() {
+			for {
+				if holdJobLease(ctx, "purge-notices", 2*trashPurgeInterval) {
+					if n, err := sendPurgeNotices(ctx, trashPurgeNow()); err != nil {
+						log.Printf("Failed to send purge notices: %v", err)
+					} else if n > 0 {
+						log.Printf("Sent purge notices for %d deleted runs", n)
+					}
+				}
+				time.Sleep(trashPurgeInterval)
+			}
+		}()
+	}
+	log.Printf("Deleted runs can be purged by the trash-purge housekeeping job %d days after they are deleted", trashPurgeAfterDays)
+}
+
+// validateDeletedBy checks the email address a run's deleter left to be told
+// before it is purged
+func validateDeletedBy(deletedBy string) error {
+	if deletedBy == "" {
+		return nil
+	}
+	if err := validateNotificationSubscription(notificationChannelEmail, deletedBy, nil); err != nil {
+		return fmt.Errorf("notify: %v", err)
+	}
+	return nil
+}
+
+// purgeRun deletes a deleted run's artifacts from the artifact store and its
+// mirror, then its rows. Artifacts go first, so that a failed purge leaves
+// the rows that say which run the files belonged to.
+func purgeRun(ctx context.Context, run DeletedRunRow) error {
+	// Artifacts are beneath the run's project's prefix, or the bare run UUID
+	// if they were logged before the project had one
+	prefixes := []string{run.UUID + "/"}
+	key, err := runArtifactKey(ctx, run.ID, run.UUID)
+	if err != nil {
+		return fmt.Errorf("finding artifacts: %w", err)
+	}
+	if key != run.UUID {
+		prefixes = append(prefixes, key+"/")
+	}
+	for _, store := range []ArtifactStore{artifactStore, artifactMirror} {
+		if store == nil {
+			continue
+		}
+		for _, prefix := range prefixes {
+			if err := deleteArtifactPrefix(store, prefix); err != nil {
+				return fmt.Errorf("deleting artifacts: %w", err)
+			}
+		}
+	}
+	return dao.PurgeRun(ctx, run.ID)
+}
+
+// handleAPIDeleteRun deletes a run, its child runs, and everything logged to
+// them, at DELETE /api/runs/{uuid}. The notify query parameter is an email
+// address to tell before the runs are purged.
+func handleAPIDeleteRun(w http.ResponseWriter, r *http.Request, runUUID string) {
+	ctx := r.Context()
+	w.Header().Set("Content-Type", "application/json")
+
+	runID, err := dao.GetRunIDByUUID(ctx, runUUID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Run not found"})
+		return
+	}
+	deletedBy := r.URL.Query().Get("notify")
+	if err := validateDeletedBy(deletedBy); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		return
+	}
+
+	if err := deleteRun(ctx, runID, deletedBy); err != nil {
+		if errors.Is(err, errRunOnHold) {
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Run or one of its child runs is on hold"})
+			return
+		}
+		log.Printf("Failed to delete run %s: %v", runUUID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to delete run"})
+		return
+	}
+
+	response := map[string]interface{}{"status": "ok"}
+	if trashPurgeAfterDays > 0 {
+		response["purge_after"] = time.Now().UTC().AddDate(0, 0, trashPurgeAfterDays)
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// handleDeleteRun deletes a run from its page, then sends the browser to the
+// run's experiment
+func handleDeleteRun(w http.ResponseWriter, r *http.Request, runUUID string) {
+	ctx := r.Context()
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	runID, err := dao.GetRunIDByUUID(ctx, runUUID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "Run not found")
+		return
+	}
+	redirect := "/"
+	if experiment, err := dao.GetExperimentForRunUUID(ctx, runUUID); err == nil {
+		redirect = "/experiments/" + experiment.UUID
+	}
+
+	// Respond with a short status message for htmx to swap in
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	deletedBy := strings.TrimSpace(r.FormValue("notify"))
+	if err := validateDeletedBy(deletedBy); err != nil {
+		fmt.Fprintf(w, "Invalid email address to notify: %s", template.HTMLEscapeString(err.Error()))
+		return
+	}
+	if err := deleteRun(ctx, runID, deletedBy); err != nil {
+		if errors.Is(err, errRunOnHold) {
+			fmt.Fprintf(w, "This run or one of its child runs is on hold, and cannot be deleted")
+			return
+		}
+		log.Printf("Failed to delete run %s: %v", runUUID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("HX-Redirect", redirect)
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// deletionDAO keeps a tree of runs in memory
+type deletionDAO struct {
+	defaultProjectDAO
+	runs     map[string]int
+	children map[int][]string
+	held     map[int]bool
+	deleted  map[int]bool
+	purged   []int
+	// deletions records when and by whom runs were deleted, and when their
+	// purge notices were sent
+	deletions map[int]DeletedRunRow
+}
+
+func (d *deletionDAO) GetRunIDByUUID(ctx context.Context, uuid string) (int, error) {
+	if id, ok := d.runs[uuid]; ok && !d.deleted[id] {
+		return id, nil
+	}
+	return 0, sql.ErrNoRows
+}
+
+func (d *deletionDAO) GetChildRuns(ctx context.Context, parentRunID int) ([]Run, error) {
+	var runs []Run
+	for _, uuid := range d.children[parentRunID] {
+		runs = append(runs, Run{UUID: uuid})
+	}
+	return runs, nil
+}
+
+func (d *deletionDAO) GetRunHold(ctx context.Context, runID int) (*RunHoldRow, error) {
+	if d.held[runID] {
+		return &RunHoldRow{Reason: "audit"}, nil
+	}
+	return nil, nil
+}
+
+func (d *deletionDAO) GetExperimentForRunUUID(ctx context.Context, runUUID string) (*Experiment, error) {
+	return &Experiment{UUID: "exp-1"}, nil
+}
+
+func (d *deletionDAO) MarkRunDeleted(ctx context.Context, runID int, at time.Time, deletedBy string) error {
+	d.deleted[runID] = true
+	if d.deletions != nil {
+		d.deletions[runID] = DeletedRunRow{DeletedAt: at, DeletedBy: deletedBy}
+	}
+	return nil
+}
+
+func (d *deletionDAO) MarkRunPurgeNoticeSent(ctx context.Context, runID int, at time.Time) error {
+	run := d.deletions[runID]
+	run.PurgeNoticeSentAt = sql.NullTime{Time: at, Valid: true}
+	d.deletions[runID] = run
+	return nil
+}
+
+func (d *deletionDAO) GetDeletedRuns(ctx context.Context) ([]DeletedRunRow, error) {
+	var runs []DeletedRunRow
+	for uuid, id := range d.runs {
+		if d.deleted[id] {
+			run := d.deletions[id]
+			run.ID, run.UUID, run.Name = id, uuid, uuid
+			runs = append(runs, run)
+		}
+	}
+	return runs, nil
+}
+
+func (d *deletionDAO) GetArtifactsByRunID(ctx context.Context, runID int) ([]ArtifactRow, error) {
+	return nil, nil
+}
+
+func (d *deletionDAO) PurgeRun(ctx context.Context, runID int) error {
+	for uuid, id := range d.runs {
+		if id == runID {
+			delete(d.runs, uuid)
+		}
+	}
+	d.purged = append(d.purged, runID)
+	return nil
+}
+
+func newDeletionDAO() *deletionDAO {
+	return &deletionDAO{
+		runs:     map[string]int{"parent": 1, "child": 2, "other": 3},
+		children: map[int][]string{1: {"child"}},
+		held:     map[int]bool{},
+		deleted:  map[int]bool{},
+	}
+}
+
+func TestHandleAPIDeleteRun(t *testing.T) {
+	defer func(d DAO) { dao = d }(dao)
+	defer func(s ArtifactStore) { artifactStore = s }(artifactStore)
+	defer func(days int) { trashPurgeAfterDays = days }(trashPurgeAfterDays)
+	// Runs are purged as soon as they are deleted
+	trashPurgeAfterDays = 0
+	root := t.TempDir()
+	store, err := newFileArtifactStore(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	artifactStore = store
+	for _, key := range []string{"parent/model.pt", "child/logs/out.txt", "other/model.pt"} {
+		if _, err := store.Put(key, strings.NewReader("data")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	d := newDeletionDAO()
+	dao = d
+	deleteRunRequest := func(uuid string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handleAPIV1Runs(w, httptest.NewRequest(http.MethodDelete, "/api/runs/"+uuid, nil))
+		return w
+	}
+
+	// A held child run keeps its parent from being deleted
+	d.held[2] = true
+	if w := deleteRunRequest("parent"); w.Code != http.StatusConflict {
+		t.Errorf("Expected 409 for a run with a held child, got %d: %s", w.Code, w.Body)
+	}
+	if len(d.deleted) != 0 {
+		t.Errorf("Expected nothing to be deleted, got %v", d.deleted)
+	}
+
+	// Only the runs being deleted are purged, not others whose purge failed
+	d.held[2] = false
+	d.deleted[3] = true
+	if w := deleteRunRequest("parent"); w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body)
+	}
+	if len(d.purged) != 2 || d.runs["other"] != 3 || len(d.runs) != 1 {
+		t.Errorf("Expected the run and its child to be purged, got %v (remaining %v)", d.purged, d.runs)
+	}
+	for _, dir := range []string{"parent", "child"} {
+		if _, err := os.Stat(filepath.Join(root, dir)); !os.IsNotExist(err) {
+			t.Errorf("Expected the artifact directory of %s to be removed, got %v", dir, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(root, "other", "model.pt")); err != nil {
+		t.Errorf("Expected artifacts of other runs to be kept, got %v", err)
+	}
+
+	if w := deleteRunRequest("parent"); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a deleted run, got %d", w.Code)
+	}
+}
+
+func TestHandleDeleteRunRedirects(t *testing.T) {
+	defer func(d DAO) { dao = d }(dao)
+	defer func(s ArtifactStore) { artifactStore = s }(artifactStore)
+	store, err := newFileArtifactStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	artifactStore = store
+	dao = newDeletionDAO()
+
+	w := httptest.NewRecorder()
+	handleDeleteRun(w, httptest.NewRequest(http.MethodPost, "/runs/other/delete", nil), "other")
+	if got := w.Header().Get("HX-Redirect"); got != "/experiments/exp-1" {
+		t.Errorf("Expected a redirect to the run's experiment, got %q (%d: %s)", got, w.Code, w.Body)
+	}
+}
+
+func TestFileArtifactStoreRemoveDirectory(t *testing.T) {
+	store, err := newFileArtifactStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, prefix := range []string{"", "/", "../", "a/../"} {
+		if err := store.RemoveDirectory(prefix); err == nil {
+			t.Errorf("Expected RemoveDirectory(%q) to be refused", prefix)
+		}
+	}
+}
+
+func TestTrashPurge(t *testing.T) {
+	ctx := t.Context()
+	defer func(d DAO) { dao = d }(dao)
+	defer func(s ArtifactStore) { artifactStore = s }(artifactStore)
+	defer func(days int) { trashPurgeAfterDays = days }(trashPurgeAfterDays)
+	defer func(addr string) { smtpAddr = addr }(smtpAddr)
+	defer func(f func(string, smtp.Auth, string, []string, []byte) error) { sendMail = f }(sendMail)
+	store, err := newFileArtifactStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	artifactStore = store
+	trashPurgeAfterDays = 30
+	smtpAddr = "localhost:25"
+
+	var sent []string
+	sendMail = func(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+		sent = append(sent, to[0]+": "+string(msg))
+		return nil
+	}
+
+	d := newDeletionDAO()
+	d.deletions = map[int]DeletedRunRow{}
+	dao = d
+
+	w := httptest.NewRecorder()
+	handleAPIV1Runs(w, httptest.NewRequest(http.MethodDelete, "/api/runs/parent?notify=not-an-address", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid address to notify, got %d: %s", w.Code, w.Body)
+	}
+
+	w = httptest.NewRecorder()
+	handleAPIV1Runs(w, httptest.NewRequest(http.MethodDelete, "/api/runs/parent?notify=alice@example.com", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body)
+	}
+	if err := deleteRun(ctx, 3, ""); err != nil {
+		t.Fatal(err)
+	}
+	if len(d.purged) != 0 || !d.deleted[1] || !d.deleted[2] || !d.deleted[3] {
+		t.Fatalf("Expected deleted runs kept in the trash, got deleted %v, purged %v", d.deleted, d.purged)
+	}
+	if items, skipped, err := planTrashPurge(ctx); err != nil || len(items) != 0 || len(skipped) != 3 || !strings.HasPrefix(skipped[0].Reason, "in the trash until ") {
+		t.Errorf("Expected runs in their grace period skipped, got %+v, %+v, %v", items, skipped, err)
+	}
+
+	deletedAt := d.deletions[1].DeletedAt
+	if n, err := sendPurgeNotices(ctx, deletedAt.AddDate(0, 0, 20)); err != nil || n != 0 {
+		t.Errorf("Expected no notices well before the purge, got %d, %v", n, err)
+	}
+
+	// Runs are not purged until whoever deleted them has been told
+	later := deletedAt.AddDate(0, 0, 31)
+	for id := range d.deletions {
+		run := d.deletions[id]
+		run.DeletedAt = later.AddDate(0, 0, -31)
+		d.deletions[id] = run
+	}
+	defer func(now func() time.Time) { trashPurgeNow = now }(trashPurgeNow)
+	trashPurgeNow = func() time.Time { return later }
+	// purge carries out the trash-purge housekeeping job's plan
+	purge := func() []HousekeepingItem {
+		items, skipped, err := planTrashPurge(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, item := range items {
+			if err := purgeDeletedRun(ctx, item); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return skipped
+	}
+	skipped := purge()
+	if len(d.purged) != 1 || d.purged[0] != 3 {
+		t.Fatalf("Expected only the run deleted without an address purged, got %v", d.purged)
+	}
+	if len(skipped) != 2 || skipped[0].Key != "child" || skipped[0].Reason != "purge notice not sent yet" {
+		t.Errorf("Expected the notified runs skipped until told, got %+v", skipped)
+	}
+
+	if n, err := sendPurgeNotices(ctx, later); err != nil || n != 2 {
+		t.Fatalf("Expected notices for the parent and child run, got %d, %v", n, err)
+	}
+	if len(sent) != 1 || !strings.HasPrefix(sent[0], "alice@example.com: ") || !strings.Contains(sent[0], "2 deleted runs will be purged") {
+		t.Errorf("Expected one email to alice listing both runs, got %q", sent)
+	}
+	if n, err := sendPurgeNotices(ctx, later); err != nil || n != 0 || len(sent) != 1 {
+		t.Errorf("Expected notices to be sent once, got %d, %v", n, err)
+	}
+
+	if skipped := purge(); len(skipped) != 0 {
+		t.Errorf("Expected nothing skipped once notified, got %+v", skipped)
+	}
+	if len(d.purged) != 3 {
+		t.Errorf("Expected every run purged once notified, got %v", d.purged)
+	}
+}
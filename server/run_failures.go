@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A run may end with a message, e.g. the exception that failed it. The
+// experiment page groups its failed runs by error signature, the message
+// reduced to what stays the same between occurrences of one error, so that
+// "27 runs failed with CUDA out of memory" shows at a glance.
+
+// failureExamplesShown is how many of a signature's runs the experiment page
+// links to
+const failureExamplesShown = 5
+
+// failureSignatureMaxLength is the longest signature shown, in runes
+const failureSignatureMaxLength = 160
+
+// noFailureMessageSignature is the signature of runs that failed without a
+// message
+const noFailureMessageSignature = "No error message"
+
+// knownFailureSignatures name errors whose messages vary in ways a signature
+// could not normalize away, matched case-insensitively in order
+var knownFailureSignatures = []struct {
+	pattern   *regexp.Regexp
+	signature string
+}{
+	{regexp.MustCompile(`(?i)cuda (error: )?out of memory|OutOfMemoryError: CUDA`), "CUDA out of memory"},
+	{regexp.MustCompile(`(?i)nccl (error|timeout)|NCCL WARN|ProcessGroupNCCL`), "NCCL error"},
+	{regexp.MustCompile(`(?i)\bMemoryError\b|out of memory|oom-kill`), "Out of memory"},
+	{regexp.MustCompile(`(?i)loss is nan|nan loss|\bnan\b.*\bloss\b|\bloss\b.*\bnan\b`), "Loss became NaN"},
+}
+
+// Parts of an error message that differ between occurrences of one error,
+// replaced in order with placeholders
+var failureMessageVariables = []struct {
+	pattern     *regexp.Regexp
+	placeholder string
+}{
+	{regexp.MustCompile(`"[^"]*"|'[^']*'`), "<str>"},
+	{regexp.MustCompile(`(?:\.{1,2}|~)?(?:/[\w.~-]+)+/?`), "<path>"},
+	{regexp.MustCompile(`\b0x[0-9a-fA-F]+\b`), "<hex>"},
+	{regexp.MustCompile(`\b[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}\b`), "<uuid>"},
+	{regexp.MustCompile(`-?\b\d+(?:\.\d+)?(?:[eE][-+]?\d+)?\b`), "<n>"},
+}
+
+// failureSignature reduces the message a run failed with to its error
+// signature: a known error's name, or else the last line of the message,
+// which names the exception in a traceback, with quoted strings, paths and
+// numbers replaced by placeholders
+func failureSignature(message string) string {
+	message = strings.TrimSpace(message)
+	if message == "" {
+		return noFailureMessageSignature
+	}
+	for _, known := range knownFailureSignatures {
+		if known.pattern.MatchString(message) {
+			return known.signature
+		}
+	}
+	lines := strings.Split(message, "\n")
+	line := strings.TrimSpace(lines[len(lines)-1])
+	for _, v := range failureMessageVariables {
+		line = v.pattern.ReplaceAllString(line, v.placeholder)
+	}
+	line = strings.Join(strings.Fields(line), " ")
+	if runes := []rune(line); len(runes) > failureSignatureMaxLength {
+		line = string(runes[:failureSignatureMaxLength-1]) + "…"
+	}
+	return line
+}
+
+// FailureGroup is the failed runs of an experiment sharing an error
+// signature, with the most recently finished of them as examples
+type FailureGroup struct {
+	Signature string
+	Count     int
+	LatestAt  time.Time
+	Examples  []FailedRunRow
+}
+
+// groupRunFailures groups failed runs, most recently finished first, by
+// error signature, the most common first
+func groupRunFailures(runs []FailedRunRow) []FailureGroup {
+	var groups []FailureGroup
+	index := map[string]int{}
+	for _, run := range runs {
+		signature := failureSignature(run.Message)
+		i, ok := index[signature]
+		if !ok {
+			i = len(groups)
+			index[signature] = i
+			groups = append(groups, FailureGroup{Signature: signature})
+		}
+		g := &groups[i]
+		g.Count++
+		if run.FinishedAt.Valid && run.FinishedAt.Time.After(g.LatestAt) {
+			g.LatestAt = run.FinishedAt.Time
+		}
+		if len(g.Examples) < failureExamplesShown {
+			g.Examples = append(g.Examples, run)
+		}
+	}
+	sort.SliceStable(groups, func(i, j int) bool { return groups[i].Count > groups[j].Count })
+	return groups
+}
+
+// getExperimentFailures loads the failed runs of an experiment grouped by
+// error signature
+func getExperimentFailures(ctx context.Context, experimentID int) ([]FailureGroup, error) {
+	runs, err := dao.GetFailedRuns(ctx, experimentID)
+	if err != nil {
+		return nil, err
+	}
+	return groupRunFailures(runs), nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+)
+
+func TestFailureSignature(t *testing.T) {
+	traceback := "Traceback (most recent call last):\n  File \"/home/ada/train.py\", line 12, in <module>\n    main()\nValueError: expected 3 channels, got 4 in /data/run-17/batch_0042.npz"
+	for message, want := range map[string]string{
+		"":        noFailureMessageSignature,
+		"  \n  ":  noFailureMessageSignature,
+		traceback: "ValueError: expected <n> channels, got <n> in <path>",
+		"torch.OutOfMemoryError: CUDA out of memory. Tried to allocate 2.00 GiB (GPU 0; 79.15 GiB total capacity)": "CUDA out of memory",
+		"RuntimeError: CUDA error: out of memory": "CUDA out of memory",
+		"MemoryError":                          "Out of memory",
+		"ValueError: Loss is NaN at step 1200": "Loss became NaN",
+		"KeyError: 'val_loss'":                 "KeyError: <str>",
+		"Segfault at 0x7f3a2c0 in worker 3":    "Segfault at <hex> in worker <n>",
+		"RuntimeError: run 0b8e2f4e-6a3c-4d7e-9f1a-2b3c4d5e6f70 failed": "RuntimeError: run <uuid> failed",
+		runStaleStatusMessage: runStaleStatusMessage,
+	} {
+		if got := failureSignature(message); got != want {
+			t.Errorf("failureSignature(%q) = %q, want %q", message, got, want)
+		}
+	}
+	if a, b := failureSignature("ValueError: lr=0.1 diverged after 120 steps"), failureSignature("ValueError: lr=3e-4 diverged after 9 steps"); a != b {
+		t.Errorf("Expected errors differing only in numbers to share a signature, got %q and %q", a, b)
+	}
+}
+
+func TestGroupRunFailures(t *testing.T) {
+	at := func(hour int) sql.NullTime {
+		return sql.NullTime{Time: time.Date(2024, 3, 1, hour, 0, 0, 0, time.UTC), Valid: true}
+	}
+	var runs []FailedRunRow
+	for i := 0; i < failureExamplesShown+2; i++ {
+		runs = append(runs, FailedRunRow{UUID: "oom", Message: "CUDA out of memory. Tried to allocate 1 GiB", FinishedAt: at(20 - i)})
+	}
+	runs = append(runs,
+		FailedRunRow{UUID: "key-1", Message: "KeyError: 'a'", FinishedAt: at(23)},
+		FailedRunRow{UUID: "quiet"},
+		FailedRunRow{UUID: "key-2", Message: "KeyError: 'b'", FinishedAt: at(1)},
+	)
+
+	groups := groupRunFailures(runs)
+	if len(groups) != 3 {
+		t.Fatalf("Expected 3 signatures, got %+v", groups)
+	}
+	if g := groups[0]; g.Signature != "CUDA out of memory" || g.Count != failureExamplesShown+2 || len(g.Examples) != failureExamplesShown || !g.LatestAt.Equal(at(20).Time) {
+		t.Errorf("Expected the out of memory errors first, with %d examples, got %+v", failureExamplesShown, g)
+	}
+	if g := groups[1]; g.Signature != "KeyError: <str>" || g.Count != 2 || g.Examples[0].UUID != "key-1" || !g.LatestAt.Equal(at(23).Time) {
+		t.Errorf("Expected the key errors second, got %+v", g)
+	}
+	if g := groups[2]; g.Signature != noFailureMessageSignature || g.Count != 1 || !g.LatestAt.IsZero() {
+		t.Errorf("Expected the run without a message last, got %+v", g)
+	}
+	if groups := groupRunFailures(nil); len(groups) != 0 {
+		t.Errorf("Expected no groups without failed runs, got %+v", groups)
+	}
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// A fork of a run is a new run in the same experiment, under the same parent,
+// with the run's parameters except those overridden, so that a run can be
+// relaunched with one hyperparameter tweaked. Its tags and notes are copied
+// if asked for. The fork records the UUID of the run it was forked from,
+// which the run page links to.
+
+// runForkError is a problem with a fork request that the client can fix, as
+// opposed to a server-side failure
+type runForkError struct {
+	status  int
+	message string
+}
+
+func (e *runForkError) Error() string {
+	return e.message
+}
+
+// runFork is what a fork of a run changes from it
+type runFork struct {
+	// Name is the fork's name, or empty for the run's name with " (fork)"
+	Name string
+	// Overrides replace the run's parameters of the same keys, converted to
+	// their types, or are added if the run has none
+	Overrides []ParameterRow
+	Tags      bool
+	Notes     bool
+	// CheckQuota is whether to refuse a fork that would take the experiment
+	// over its run quota
+	CheckQuota bool
+}
+
+// forkParameters applies overrides to a run's parameters, converting each to
+// the type of the parameter it replaces
+func forkParameters(params, overrides []ParameterRow) ([]ParameterRow, error) {
+	index := make(map[string]int, len(params))
+	forked := make([]ParameterRow, len(params))
+	for i, p := range params {
+		index[p.Key] = i
+		forked[i] = p
+	}
+	for _, override := range overrides {
+		i, ok := index[override.Key]
+		if !ok {
+			index[override.Key] = len(forked)
+			forked = append(forked, override)
+			continue
+		}
+		converted, err := convertParameterValue(override, params[i].ValueType)
+		if err != nil {
+			return nil, fmt.Errorf("parameter %q: %w", override.Key, err)
+		}
+		forked[i] = converted
+	}
+	return forked, nil
+}
+
+// forkRun creates a fork of a run, returning the fork's UUID
+func forkRun(ctx context.Context, sourceUUID string, fork runFork) (string, error) {
+	source, err := dao.GetRunByUUID(ctx, sourceUUID)
+	if err != nil {
+		return "", &runForkError{http.StatusNotFound, "Run not found"}
+	}
+	sourceID, err := dao.GetRunIDByUUID(ctx, sourceUUID)
+	if err != nil {
+		return "", &runForkError{http.StatusNotFound, "Run not found"}
+	}
+	experiment, err := dao.GetExperimentForRunUUID(ctx, sourceUUID)
+	if err != nil {
+		return "", err
+	}
+	experimentID, err := dao.GetExperimentIDByUUID(ctx, experiment.UUID)
+	if err != nil {
+		return "", err
+	}
+
+	sourceParams, err := dao.GetParametersByRunID(ctx, sourceID)
+	if err != nil {
+		return "", err
+	}
+	params, err := forkParameters(sourceParams, fork.Overrides)
+	if err != nil {
+		return "", &runForkError{http.StatusBadRequest, err.Error()}
+	}
+
+	if fork.CheckQuota {
+		if err := checkExperimentQuota(ctx, experimentID, 1, 0, 0); err != nil {
+			return "", err
+		}
+	}
+	name := fork.Name
+	if name == "" {
+		name = source.Name + " (fork)"
+	}
+	runUUID := newUUID(ctx)
+	if err := dao.InsertRun(ctx, runUUID, name, experimentID, source.ParentRunID); err != nil {
+		return "", err
+	}
+	runID, err := dao.GetRunIDByUUID(ctx, runUUID)
+	if err != nil {
+		return "", err
+	}
+	if err := dao.SetRunForkedFrom(ctx, runID, sourceUUID); err != nil {
+		return "", err
+	}
+	if len(params) > 0 {
+		if err := dao.UpsertParameters(ctx, runID, params); err != nil {
+			return "", err
+		}
+	}
+
+	if fork.Tags {
+		tags, err := dao.GetRunTags(ctx, sourceID)
+		if err != nil {
+			return "", err
+		}
+		for _, tag := range tags {
+			if err := dao.SetRunTag(ctx, runID, tag.Key, tag.Value); err != nil {
+				return "", err
+			}
+		}
+	}
+	if fork.Notes && source.Notes != "" {
+		if err := dao.UpdateRunNotes(ctx, runID, source.Notes); err != nil {
+			return "", err
+		}
+	}
+	notifyRunEvent(ctx, notificationEventRunCreated, runUUID)
+	return runUUID, nil
+}
+
+// ForkRunRequest is the body of POST /api/runs/{uuid}/fork. Parameters are
+// JSON strings, bools or numbers, converted to the types of the run's
+// parameters they replace.
+type ForkRunRequest struct {
+	Name       string                     `json:"name"`
+	Parameters map[string]json.RawMessage `json:"parameters"`
+	Tags       bool                       `json:"tags"`
+	Notes      bool                       `json:"notes"`
+}
+
+// handleAPIForkRun forks a run at POST /api/runs/{uuid}/fork
+func handleAPIForkRun(w http.ResponseWriter, r *http.Request, runUUID string) {
+	ctx := r.Context()
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+
+	var req ForkRunRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid JSON"})
+		return
+	}
+	fork := runFork{
+		Name:       strings.TrimSpace(req.Name),
+		Tags:       req.Tags,
+		Notes:      req.Notes,
+		CheckQuota: !quotaExempt(r),
+	}
+	keys := make([]string, 0, len(req.Parameters))
+	for key := range req.Parameters {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		p, err := parseParameterJSONValue(req.Parameters[key], "")
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": fmt.Sprintf("Invalid value for parameter %q: %v", key, err)})
+			return
+		}
+		p.Key = key
+		fork.Overrides = append(fork.Overrides, p)
+	}
+
+	runID, err := dao.GetRunIDByUUID(ctx, runUUID)
+	if err == nil {
+		var ok bool
+		ok, err = requestCanAccessRun(r, runID)
+		if err == nil && !ok {
+			err = sql.ErrNoRows
+		}
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Run not found"})
+		return
+	}
+
+	forkUUID, err := forkRun(ctx, runUUID, fork)
+	var forkErr *runForkError
+	var quotaErr *quotaExceededError
+	switch {
+	case errors.As(err, &forkErr):
+		w.WriteHeader(forkErr.status)
+		json.NewEncoder(w).Encode(map[string]string{"error": forkErr.message})
+		return
+	case errors.As(err, &quotaErr):
+		writeQuotaError(w, err)
+		return
+	case err != nil:
+		log.Printf("Failed to fork run %s: %v", runUUID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to fork run"})
+		return
+	}
+
+	run, err := dao.GetRunByUUID(ctx, forkUUID)
+	if err != nil {
+		log.Printf("Failed to load run %s forked from %s: %v", forkUUID, runUUID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to fork run"})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{
+		"id":          forkUUID,
+		"name":        run.Name,
+		"forked_from": runUUID,
+	})
+}
+
+// handleForkRun forks a run from the fork form of its overview, which posts
+// each of the run's parameters as param.<key>, then shows the fork
+func handleForkRun(w http.ResponseWriter, r *http.Request, runUUID string) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "Invalid form")
+		return
+	}
+
+	fork := runFork{
+		Name:  strings.TrimSpace(r.PostFormValue("name")),
+		Tags:  r.PostFormValue("tags") != "",
+		Notes: r.PostFormValue("notes") != "",
+	}
+	for field, values := range r.PostForm {
+		if key, ok := strings.CutPrefix(field, "param."); ok && len(values) > 0 {
+			p := ParameterRow{Key: key, ValueType: "string", ValueString: sql.NullString{String: values[0], Valid: true}}
+			fork.Overrides = append(fork.Overrides, p)
+		}
+	}
+	sort.Slice(fork.Overrides, func(i, j int) bool { return fork.Overrides[i].Key < fork.Overrides[j].Key })
+
+	forkUUID, err := forkRun(r.Context(), runUUID, fork)
+	if err != nil {
+		var forkErr *runForkError
+		if errors.As(err, &forkErr) {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.WriteHeader(forkErr.status)
+			fmt.Fprintf(w, "%s", forkErr.message)
+			return
+		}
+		log.Printf("Failed to fork run %s: %v", runUUID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/runs/"+forkUUID, http.StatusSeeOther)
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestForkParameters(t *testing.T) {
+	params := []ParameterRow{floatParam("lr", 0.001), intParam("epochs", 10), stringParam("optimizer", "adam")}
+
+	override := intParam("lr", 1)
+	forked, err := forkParameters(params, []ParameterRow{override, stringParam("epochs", "20"), floatParam("momentum", 0.9)})
+	if err != nil {
+		t.Fatalf("forkParameters failed: %v", err)
+	}
+	want := []struct{ key, value, valueType string }{
+		{"lr", "1", "float"},
+		{"epochs", "20", "int"},
+		{"optimizer", "adam", "string"},
+		{"momentum", "0.9", "float"},
+	}
+	if len(forked) != len(want) {
+		t.Fatalf("Expected %d parameters, got %+v", len(want), forked)
+	}
+	for i, w := range want {
+		if p := forked[i]; p.Key != w.key || formatParameterValue(p) != w.value || p.ValueType != w.valueType {
+			t.Errorf("Expected %s = %s (%s), got %s = %s (%s)", w.key, w.value, w.valueType, p.Key, formatParameterValue(p), p.ValueType)
+		}
+	}
+	if params[0].ValueFloat.Float64 != 0.001 {
+		t.Errorf("Expected the run's parameters left alone, got %+v", params[0])
+	}
+
+	if _, err := forkParameters(params, []ParameterRow{stringParam("epochs", "many")}); err == nil {
+		t.Error("Expected an override that does not convert to the parameter's type refused")
+	}
+}
+
+// runForkDAO keeps one run to fork, and the runs forked from it
+type runForkDAO struct {
+	DAO
+	runs       map[string]*Run
+	ids        map[string]int
+	params     map[int][]ParameterRow
+	tags       map[int][]RunTagRow
+	forkedFrom map[int]string
+}
+
+func newRunForkDAO() *runForkDAO {
+	parentID := 7
+	return &runForkDAO{
+		runs: map[string]*Run{
+			"run-1": {UUID: "run-1", Name: "baseline", Notes: "LR too high", ParentRunID: &parentID},
+		},
+		ids:        map[string]int{"run-1": 1},
+		params:     map[int][]ParameterRow{1: {floatParam("lr", 0.001), intParam("epochs", 10)}},
+		tags:       map[int][]RunTagRow{1: {{Key: "team", Value: "vision"}}},
+		forkedFrom: map[int]string{},
+	}
+}
+
+func (d *runForkDAO) GetRunByUUID(ctx context.Context, uuid string) (*Run, error) {
+	run, ok := d.runs[uuid]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return run, nil
+}
+
+func (d *runForkDAO) GetRunIDByUUID(ctx context.Context, uuid string) (int, error) {
+	id, ok := d.ids[uuid]
+	if !ok {
+		return 0, sql.ErrNoRows
+	}
+	return id, nil
+}
+
+func (d *runForkDAO) GetExperimentForRunUUID(ctx context.Context, runUUID string) (*Experiment, error) {
+	return &Experiment{UUID: "exp-1"}, nil
+}
+
+func (d *runForkDAO) GetExperimentIDByUUID(ctx context.Context, uuid string) (int, error) {
+	return 3, nil
+}
+
+func (d *runForkDAO) GetExperimentQuota(ctx context.Context, experimentID int) (*ExperimentQuotaRow, error) {
+	return nil, nil
+}
+
+func (d *runForkDAO) GetNotificationSubscriptions(ctx context.Context) ([]NotificationSubscriptionRow, error) {
+	return nil, nil
+}
+
+func (d *runForkDAO) GetNotificationPreferences(ctx context.Context) ([]NotificationPreferenceRow, error) {
+	return nil, nil
+}
+
+func (d *runForkDAO) GetParametersByRunID(ctx context.Context, runID int) ([]ParameterRow, error) {
+	return d.params[runID], nil
+}
+
+func (d *runForkDAO) InsertRun(ctx context.Context, uuid, name string, experimentID int, parentRunID *int) error {
+	d.runs[uuid] = &Run{UUID: uuid, Name: name, ParentRunID: parentRunID}
+	d.ids[uuid] = len(d.ids) + 1
+	return nil
+}
+
+func (d *runForkDAO) SetRunForkedFrom(ctx context.Context, runID int, sourceUUID string) error {
+	d.forkedFrom[runID] = sourceUUID
+	return nil
+}
+
+func (d *runForkDAO) UpsertParameters(ctx context.Context, runID int, params []ParameterRow) error {
+	d.params[runID] = params
+	return nil
+}
+
+func (d *runForkDAO) GetRunTags(ctx context.Context, runID int) ([]RunTagRow, error) {
+	return d.tags[runID], nil
+}
+
+func (d *runForkDAO) SetRunTag(ctx context.Context, runID int, key, value string) error {
+	d.tags[runID] = append(d.tags[runID], RunTagRow{Key: key, Value: value})
+	return nil
+}
+
+func (d *runForkDAO) UpdateRunNotes(ctx context.Context, runID int, notes string) error {
+	for uuid, id := range d.ids {
+		if id == runID {
+			d.runs[uuid].Notes = notes
+		}
+	}
+	return nil
+}
+
+func TestHandleAPIForkRun(t *testing.T) {
+	defer func(d DAO) { dao = d }(dao)
+	fake := newRunForkDAO()
+	dao = fake
+
+	post := func(path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handleAPIV1Runs(w, httptest.NewRequest(http.MethodPost, path, strings.NewReader(body)))
+		return w
+	}
+
+	w := post("/api/v1/runs/run-1/fork", `{"parameters": {"lr": 0.01, "warmup": 100}, "tags": true, "notes": true}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected the run forked, got %d %s", w.Code, w.Body)
+	}
+	var resp map[string]string
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp["name"] != "baseline (fork)" || resp["forked_from"] != "run-1" {
+		t.Errorf("Expected the fork named after the run, got %v", resp)
+	}
+	fork := fake.runs[resp["id"]]
+	if fork == nil || fork.ParentRunID == nil || *fork.ParentRunID != 7 {
+		t.Fatalf("Expected the fork under the run's parent, got %+v", fork)
+	}
+	forkID := fake.ids[fork.UUID]
+	if fake.forkedFrom[forkID] != "run-1" {
+		t.Errorf("Expected the fork to record the run it was forked from, got %v", fake.forkedFrom)
+	}
+	params := map[string]string{}
+	for _, p := range fake.params[forkID] {
+		params[p.Key] = formatParameterValue(p) + " " + p.ValueType
+	}
+	if params["lr"] != "0.01 float" || params["epochs"] != "10 int" || params["warmup"] != "100 int" {
+		t.Errorf("Expected the run's parameters with the overrides, got %v", params)
+	}
+	if len(fake.tags[forkID]) != 1 || fork.Notes != "LR too high" {
+		t.Errorf("Expected the tags and notes copied, got %v %q", fake.tags[forkID], fork.Notes)
+	}
+
+	// Tags and notes are only copied if asked for
+	w = post("/api/runs/run-1/fork", `{"name": "lower lr"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected the run forked, got %d %s", w.Code, w.Body)
+	}
+	json.NewDecoder(w.Body).Decode(&resp)
+	fork = fake.runs[resp["id"]]
+	if fork.Name != "lower lr" || fork.Notes != "" || len(fake.tags[fake.ids[fork.UUID]]) != 0 {
+		t.Errorf("Expected a named fork without tags or notes, got %+v %v", fork, fake.tags)
+	}
+
+	if w := post("/api/runs/run-1/fork", `{"parameters": {"epochs": "many"}}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an override that does not convert, got %d", w.Code)
+	}
+	if w := post("/api/runs/run-1/fork", `{"parameters": {"epochs": [1]}}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an override that is not a parameter value, got %d", w.Code)
+	}
+	if w := post("/api/runs/missing/fork", `{}`); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown run, got %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	handleAPIV1Runs(w, httptest.NewRequest(http.MethodGet, "/api/runs/run-1/fork", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected 405 for GET, got %d", w.Code)
+	}
+}
+
+func TestHandleForkRun(t *testing.T) {
+	defer func(d DAO) { dao = d }(dao)
+	fake := newRunForkDAO()
+	dao = fake
+
+	// The form posts every parameter, changed or not
+	form := url.Values{"name": {""}, "param.lr": {"0.01"}, "param.epochs": {"10"}, "notes": {"1"}}
+	r := httptest.NewRequest(http.MethodPost, "/runs/run-1/fork", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	handleForkRun(w, r, "run-1")
+
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("Expected a redirect to the fork, got %d %s", w.Code, w.Body)
+	}
+	fork := fake.runs[strings.TrimPrefix(w.Header().Get("Location"), "/runs/")]
+	if fork == nil || fork.Notes != "LR too high" || len(fake.tags[fake.ids[fork.UUID]]) != 0 {
+		t.Fatalf("Expected a fork with the notes but not the tags, got %+v", fork)
+	}
+	for _, p := range fake.params[fake.ids[fork.UUID]] {
+		if p.Key == "lr" && (p.ValueType != "float" || p.ValueFloat.Float64 != 0.01) {
+			t.Errorf("Expected lr converted to a float, got %+v", p)
+		}
+		if p.Key == "epochs" && (p.ValueType != "int" || p.ValueInt.Int64 != 10) {
+			t.Errorf("Expected epochs converted to an int, got %+v", p)
+		}
+	}
+
+	form.Set("param.epochs", "ten")
+	r = httptest.NewRequest(http.MethodPost, "/runs/run-1/fork", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w = httptest.NewRecorder()
+	handleForkRun(w, r, "run-1")
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "epochs") {
+		t.Errorf("Expected 400 naming the parameter that does not convert, got %d %s", w.Code, w.Body)
+	}
+}
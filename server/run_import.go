@@ -0,0 +1,696 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Importing a CSV creates a finished run in an experiment for each row of a
+// spreadsheet of past results, so that they live alongside runs logged since.
+// Each column is mapped to the run's name, a parameter, a final metric or the
+// date of the run, or ignored. Runs can also be imported from JSON lines, one
+// run document per line, for scripts that generate runs such as sweep
+// planners.
+
+// maxImportCSVBytes is the largest CSV that can be imported
+const maxImportCSVBytes = 10 << 20
+
+// What a CSV column can be imported as
+const (
+	importColumnIgnore = "ignore"
+	importColumnName   = "name"
+	importColumnParam  = "param"
+	importColumnMetric = "metric"
+	importColumnDate   = "date"
+)
+
+// importDateLayouts are the date formats accepted in a date column
+var importDateLayouts = []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02T15:04:05", "2006-01-02", "01/02/2006", "1/2/2006"}
+
+// importMetricKeywords are words in a column's header that suggest it holds a
+// metric rather than a parameter
+var importMetricKeywords = []string{"acc", "loss", "error", "f1", "auc", "score", "precision", "recall", "bleu", "rouge", "perplexity", "mae", "mse", "rmse"}
+
+// ImportColumn maps a CSV column to what it is imported as. Key is the
+// parameter or metric key, the column's header by default.
+type ImportColumn struct {
+	Column string `json:"column"`
+	As     string `json:"as"`
+	Key    string `json:"key,omitempty"`
+}
+
+// ImportedRun is a run to be created from a row of a CSV
+type ImportedRun struct {
+	Name            string                 `json:"name"`
+	Date            *time.Time             `json:"date,omitempty"`
+	Parameters      []ParameterRow         `json:"-"`
+	ParameterValues map[string]interface{} `json:"parameters"`
+	Metrics         map[string]float64     `json:"metrics"`
+}
+
+// importCSVError is a problem with a CSV or its column mapping that the
+// client can fix
+type importCSVError struct {
+	message string
+}
+
+func (e *importCSVError) Error() string {
+	return e.message
+}
+
+// readImportCSV reads a CSV with a header row, returning the header and the
+// rows beneath it
+func readImportCSV(r io.Reader) ([]string, [][]string, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxImportCSVBytes+1))
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(data) > maxImportCSVBytes {
+		return nil, nil, &importCSVError{fmt.Sprintf("CSV is larger than %s", formatBytes(maxImportCSVBytes))}
+	}
+	// Spreadsheets often save CSVs with a byte order mark
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+
+	records, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+	if err != nil {
+		return nil, nil, &importCSVError{fmt.Sprintf("Invalid CSV: %v", err)}
+	}
+	if len(records) < 2 {
+		return nil, nil, &importCSVError{"CSV must have a header row and at least one row of results"}
+	}
+
+	header := records[0]
+	seen := make(map[string]bool)
+	for i, column := range header {
+		header[i] = strings.TrimSpace(column)
+		if header[i] == "" {
+			return nil, nil, &importCSVError{fmt.Sprintf("Column %d has no header", i+1)}
+		}
+		if seen[header[i]] {
+			return nil, nil, &importCSVError{fmt.Sprintf("Column %q appears more than once", header[i])}
+		}
+		seen[header[i]] = true
+	}
+	return header, records[1:], nil
+}
+
+// guessImportColumns suggests what to import each column as from its header
+// and values: a name or date column by its header, metrics by headers that
+// name common metrics, and everything else as parameters
+func guessImportColumns(header []string, rows [][]string) []ImportColumn {
+	columns := make([]ImportColumn, len(header))
+	hasName := false
+	for i, column := range header {
+		columns[i] = ImportColumn{Column: column, As: importColumnParam, Key: column}
+		lower := strings.ToLower(column)
+		switch {
+		case !hasName && (lower == "name" || lower == "run" || lower == "run name" || lower == "run_name" || lower == "experiment"):
+			columns[i].As, columns[i].Key = importColumnName, ""
+			hasName = true
+		case (lower == "date" || strings.Contains(lower, "created") || strings.Contains(lower, "started")) && importColumnParses(rows, i, parseImportDate):
+			columns[i].As, columns[i].Key = importColumnDate, ""
+		case importColumnParses(rows, i, parseImportMetric):
+			for _, keyword := range importMetricKeywords {
+				if strings.Contains(lower, keyword) {
+					columns[i].As = importColumnMetric
+					break
+				}
+			}
+		}
+	}
+	return columns
+}
+
+// importColumnParses reports whether every non-empty value in a column parses
+func importColumnParses[T any](rows [][]string, column int, parse func(string) (T, error)) bool {
+	for _, row := range rows {
+		if value := strings.TrimSpace(row[column]); value != "" {
+			if _, err := parse(value); err != nil {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func parseImportDate(value string) (time.Time, error) {
+	for _, layout := range importDateLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognized date %q; use a format such as 2006-01-02", value)
+}
+
+func parseImportMetric(value string) (float64, error) {
+	// Spreadsheets may format fractions as percentages
+	if percent, ok := strings.CutSuffix(value, "%"); ok {
+		f, err := strconv.ParseFloat(strings.TrimSpace(percent), 64)
+		return f / 100, err
+	}
+	return strconv.ParseFloat(value, 64)
+}
+
+// planCSVImport builds the runs a CSV's rows import as, checking every row
+// before any run is created. Parameters take the most specific type all of
+// their column's values have, and empty cells are left out.
+func planCSVImport(header []string, rows [][]string, columns []ImportColumn) ([]ImportedRun, error) {
+	index := make(map[string]int)
+	for i, column := range header {
+		index[column] = i
+	}
+
+	nameColumn, dateColumn := -1, -1
+	keys := make(map[string]string)
+	var params, metrics []ImportColumn
+	for _, c := range columns {
+		i, ok := index[c.Column]
+		if !ok {
+			return nil, &importCSVError{fmt.Sprintf("CSV has no column %q", c.Column)}
+		}
+		if c.Key == "" {
+			c.Key = c.Column
+		}
+		switch c.As {
+		case importColumnIgnore:
+		case importColumnName, importColumnDate:
+			target := &nameColumn
+			if c.As == importColumnDate {
+				target = &dateColumn
+			}
+			if *target >= 0 {
+				return nil, &importCSVError{fmt.Sprintf("Only one column can be imported as the run's %s", c.As)}
+			}
+			*target = i
+		case importColumnParam, importColumnMetric:
+			if other, ok := keys[c.As+" "+c.Key]; ok {
+				return nil, &importCSVError{fmt.Sprintf("Columns %q and %q are both imported as %s %q", other, c.Column, c.As, c.Key)}
+			}
+			keys[c.As+" "+c.Key] = c.Column
+			if c.As == importColumnParam {
+				params = append(params, c)
+			} else {
+				metrics = append(metrics, c)
+			}
+		default:
+			return nil, &importCSVError{fmt.Sprintf("Column %q cannot be imported as %q; use name, param, metric, date or ignore", c.Column, c.As)}
+		}
+	}
+
+	// Find each parameter's type from every value it has
+	paramTypes := make([]string, len(params))
+	for j, c := range params {
+		var values []ParameterRow
+		for _, row := range rows {
+			if value := strings.TrimSpace(row[index[c.Column]]); value != "" {
+				p, _ := parseParameterQueryValue(value, "string")
+				values = append(values, p)
+			}
+		}
+		paramTypes[j] = suggestParameterType(values)
+	}
+
+	runs := make([]ImportedRun, len(rows))
+	for n, row := range rows {
+		// Rows are numbered as spreadsheets show them, below the header
+		line := n + 2
+		run := &runs[n]
+		run.Name = fmt.Sprintf("Imported run %d", n+1)
+		if nameColumn >= 0 {
+			if name := strings.TrimSpace(row[nameColumn]); name != "" {
+				run.Name = name
+			}
+		}
+		if dateColumn >= 0 {
+			if value := strings.TrimSpace(row[dateColumn]); value != "" {
+				date, err := parseImportDate(value)
+				if err != nil {
+					return nil, &importCSVError{fmt.Sprintf("Row %d: %v", line, err)}
+				}
+				run.Date = &date
+			}
+		}
+
+		run.ParameterValues = make(map[string]interface{})
+		for j, c := range params {
+			value := strings.TrimSpace(row[index[c.Column]])
+			if value == "" {
+				continue
+			}
+			p, err := parseParameterQueryValue(value, paramTypes[j])
+			if err != nil {
+				return nil, &importCSVError{fmt.Sprintf("Row %d: column %q: %v", line, c.Column, err)}
+			}
+			p.Key = c.Key
+			run.Parameters = append(run.Parameters, p)
+			run.ParameterValues[p.Key] = parameterJSONValue(p)
+		}
+
+		run.Metrics = make(map[string]float64)
+		for _, c := range metrics {
+			value := strings.TrimSpace(row[index[c.Column]])
+			if value == "" {
+				continue
+			}
+			y, err := parseImportMetric(value)
+			if err != nil {
+				return nil, &importCSVError{fmt.Sprintf("Row %d: column %q: %q is not a number", line, c.Column, value)}
+			}
+			run.Metrics[c.Key] = y
+		}
+	}
+	return runs, nil
+}
+
+// importRuns creates finished runs in an experiment from planned ones,
+// returning their UUIDs. Each final metric is logged at step 0, and runs
+// are tagged with where they were imported from.
+func importRuns(ctx context.Context, experimentID int, runs []ImportedRun, source string) ([]string, error) {
+	var uuids []string
+	for _, run := range runs {
+		runUUID := newUUID(ctx)
+		if err := dao.InsertRun(ctx, runUUID, run.Name, experimentID, nil); err != nil {
+			return uuids, err
+		}
+		uuids = append(uuids, runUUID)
+		runID, err := dao.GetRunIDByUUID(ctx, runUUID)
+		if err != nil {
+			return uuids, err
+		}
+
+		for _, p := range run.Parameters {
+			valueString, valueBool, valueFloat, valueInt := parameterValuePointers(p)
+			if err := dao.UpsertParameter(ctx, runID, p.Key, p.ValueType, valueString, valueBool, valueFloat, valueInt); err != nil {
+				return uuids, err
+			}
+		}
+		loggedAt := time.Now()
+		if run.Date != nil {
+			loggedAt = *run.Date
+		}
+		for key, y := range run.Metrics {
+			if err := dao.InsertMetrics(ctx, runID, key, []float64{0}, []float64{y}, loggedAt.UnixMilli()); err != nil {
+				return uuids, err
+			}
+		}
+		if err := dao.SetRunTag(ctx, runID, "imported_from", source); err != nil {
+			return uuids, err
+		}
+		if err := dao.UpdateRunStatus(ctx, runID, runStatusFinished, ""); err != nil {
+			return uuids, err
+		}
+		if run.Date != nil {
+			if err := dao.BackdateRun(ctx, runID, *run.Date); err != nil {
+				return uuids, err
+			}
+		}
+	}
+	return uuids, nil
+}
+
+// importMetricPoints counts the metric points imported runs log, for quotas
+func importMetricPoints(runs []ImportedRun) int {
+	points := 0
+	for _, run := range runs {
+		points += len(run.Metrics)
+	}
+	return points
+}
+
+// handleAPIImportRunsCSV imports runs into an experiment from a CSV, at
+// POST /api/experiments/import as multipart/form-data with fields:
+//
+//	experiment_uuid  the experiment (the default experiment if empty)
+//	columns          a JSON list of {"column", "as", "key"} mapping columns; columns
+//	                 left out are ignored, and without it the mapping is guessed
+//	dry_run          "true" to return the runs that would be created without creating them
+//	file             the CSV, with a header row
+func handleAPIImportRunsCSV(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseMultipartForm(maxImportCSVBytes); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to parse multipart form"})
+		return
+	}
+	file, fileHeader, err := r.FormFile("file")
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "No file uploaded"})
+		return
+	}
+	defer file.Close()
+
+	var experimentID int
+	if experimentUUID := r.FormValue("experiment_uuid"); experimentUUID == "" {
+		experimentID, err = dao.GetDefaultExperimentID(ctx)
+	} else {
+		experimentID, err = dao.GetExperimentIDByUUID(ctx, experimentUUID)
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Experiment not found"})
+		return
+	}
+	// The experiment is in the form, where the project scope middleware
+	// doesn't look
+	if ok, err := requestCanAccessExperiment(r, experimentID); err != nil || !ok {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Experiment not found"})
+		return
+	}
+
+	header, rows, err := readImportCSV(file)
+	var columns []ImportColumn
+	if err == nil {
+		if mapping := r.FormValue("columns"); mapping != "" {
+			if json.Unmarshal([]byte(mapping), &columns) != nil {
+				err = &importCSVError{"columns must be a JSON list of {\"column\", \"as\", \"key\"}"}
+			}
+		} else {
+			columns = guessImportColumns(header, rows)
+		}
+	}
+	var runs []ImportedRun
+	if err == nil {
+		runs, err = planCSVImport(header, rows, columns)
+	}
+	if err == nil && r.FormValue("dry_run") != "true" && !quotaExempt(r) {
+		if err = checkExperimentQuota(ctx, experimentID, int64(len(runs)), int64(importMetricPoints(runs)), 0); writeQuotaError(w, err) {
+			return
+		}
+	}
+	var importErr *importCSVError
+	if errors.As(err, &importErr) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": importErr.message})
+		return
+	}
+
+	resp := map[string]interface{}{"columns": columns, "runs": runs}
+	if r.FormValue("dry_run") == "true" {
+		resp["dry_run"] = true
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	uuids, err := importRuns(ctx, experimentID, runs, fileHeader.Filename)
+	if err != nil {
+		log.Printf("Failed to import runs from %s after creating %d: %v", fileHeader.Filename, len(uuids), err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"error": "Failed to import runs", "created_run_uuids": uuids})
+		return
+	}
+	recordMetricPointUsage(ctx, experimentID, importMetricPoints(runs))
+	resp["run_uuids"] = uuids
+	json.NewEncoder(w).Encode(resp)
+}
+
+// importRunDocument is a run to import from a line of JSON lines. Parameters
+// are an object of keys to values or a list of {"key", "value", "type"} as
+// POST /api/params/batch takes them, and metrics are final values.
+type importRunDocument struct {
+	Name       string             `json:"name"`
+	Date       string             `json:"date"`
+	Parameters json.RawMessage    `json:"parameters"`
+	Metrics    map[string]float64 `json:"metrics"`
+}
+
+// planJSONLImport builds the runs JSON lines import as, one run document per
+// line, checking every line before any run is created. Blank lines are
+// skipped.
+func planJSONLImport(r io.Reader) ([]ImportedRun, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxImportCSVBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxImportCSVBytes {
+		return nil, &importCSVError{fmt.Sprintf("JSON lines are larger than %s", formatBytes(maxImportCSVBytes))}
+	}
+
+	var runs []ImportedRun
+	for i, text := range bytes.Split(data, []byte("\n")) {
+		line := i + 1
+		text = bytes.TrimSpace(text)
+		if len(text) == 0 {
+			continue
+		}
+		var doc importRunDocument
+		decoder := json.NewDecoder(bytes.NewReader(text))
+		// Catch misspelled fields rather than importing runs without them
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&doc); err != nil {
+			return nil, &importCSVError{fmt.Sprintf("Line %d: invalid run document: %v", line, err)}
+		}
+		if decoder.More() {
+			return nil, &importCSVError{fmt.Sprintf("Line %d: put each run document on its own line", line)}
+		}
+
+		run := ImportedRun{
+			Name:            strings.TrimSpace(doc.Name),
+			ParameterValues: make(map[string]interface{}),
+			Metrics:         doc.Metrics,
+		}
+		if run.Name == "" {
+			run.Name = fmt.Sprintf("Imported run %d", len(runs)+1)
+		}
+		if doc.Date != "" {
+			date, err := parseImportDate(doc.Date)
+			if err != nil {
+				return nil, &importCSVError{fmt.Sprintf("Line %d: %v", line, err)}
+			}
+			run.Date = &date
+		}
+		if len(doc.Parameters) > 0 && string(doc.Parameters) != "null" {
+			params, err := parseBatchParameters(doc.Parameters)
+			if err != nil {
+				return nil, &importCSVError{fmt.Sprintf("Line %d: %v", line, err)}
+			}
+			run.Parameters = params
+			for _, p := range params {
+				run.ParameterValues[p.Key] = parameterJSONValue(p)
+			}
+		}
+		if run.Metrics == nil {
+			run.Metrics = make(map[string]float64)
+		}
+		runs = append(runs, run)
+	}
+	if len(runs) == 0 {
+		return nil, &importCSVError{"JSON lines must have at least one run document"}
+	}
+	return runs, nil
+}
+
+// handleAPIImportRunsJSONL imports runs into an experiment from JSON lines, at
+// POST /api/experiments/import/jsonl with a run document per line of the
+// body, e.g. {"name": "lr=0.1", "parameters": {"lr": 0.1}, "metrics":
+// {"accuracy": 0.8}, "date": "2024-01-02"}, and query parameters:
+//
+//	experiment_uuid  the experiment (the default experiment if empty)
+//	source           what the runs' imported_from tag records (default "jsonl")
+//	dry_run          "true" to return the runs that would be created without creating them
+func handleAPIImportRunsJSONL(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	experimentUUID := query.Get("experiment_uuid")
+	if experimentUUID == "" && tokenProjectID(r) != 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "experiment_uuid is required with a token scoped to a project"})
+		return
+	}
+	var experimentID int
+	var err error
+	if experimentUUID == "" {
+		experimentID, err = dao.GetDefaultExperimentID(ctx)
+	} else {
+		experimentID, err = dao.GetExperimentIDByUUID(ctx, experimentUUID)
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Experiment not found"})
+		return
+	}
+
+	dryRun := query.Get("dry_run") == "true"
+	runs, err := planJSONLImport(r.Body)
+	if err == nil && !dryRun && !quotaExempt(r) {
+		if err = checkExperimentQuota(ctx, experimentID, int64(len(runs)), int64(importMetricPoints(runs)), 0); writeQuotaError(w, err) {
+			return
+		}
+	}
+	var importErr *importCSVError
+	if errors.As(err, &importErr) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": importErr.message})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to read JSON lines to import: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to read request body"})
+		return
+	}
+
+	resp := map[string]interface{}{"runs": runs}
+	if dryRun {
+		resp["dry_run"] = true
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+	source := query.Get("source")
+	if source == "" {
+		source = "jsonl"
+	}
+	uuids, err := importRuns(ctx, experimentID, runs, source)
+	if err != nil {
+		log.Printf("Failed to import runs from %s after creating %d: %v", source, len(uuids), err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{"error": "Failed to import runs", "created_run_uuids": uuids})
+		return
+	}
+	recordMetricPointUsage(ctx, experimentID, importMetricPoints(runs))
+	resp["run_uuids"] = uuids
+	json.NewEncoder(w).Encode(resp)
+}
+
+// experimentImportTemplates are the templates of the CSV import page
+var experimentImportTemplates = registerPage("templates/experiment_import.html")
+
+// importPreviewRows is how many rows the import page previews
+const importPreviewRows = 5
+
+// handleExperimentImport guides importing runs from a CSV into an experiment
+// in two steps: uploading the CSV, then choosing what to import each column
+// as, with a preview of the first runs. The CSV is carried between the steps
+// in the form, so nothing is stored until the runs are created.
+func handleExperimentImport(w http.ResponseWriter, r *http.Request, experimentUUID string) {
+	ctx := r.Context()
+	experiment, err := dao.GetExperimentByUUID(ctx, experimentUUID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "Experiment not found")
+		return
+	}
+	experimentID, err := dao.GetExperimentIDByUUID(ctx, experimentUUID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "Experiment not found")
+		return
+	}
+
+	data := struct {
+		Title          string
+		Experiment     *Experiment
+		ExperimentUUID string
+		FileName       string
+		CSV            string
+		Columns        []ImportColumn
+		Preview        []ImportedRun
+		RowCount       int
+		Error          string
+	}{
+		Title:          "Import runs into " + experiment.Name,
+		Experiment:     experiment,
+		ExperimentUUID: experimentUUID,
+	}
+
+	if r.Method == http.MethodPost {
+		var header []string
+		var rows [][]string
+		if file, fileHeader, err := r.FormFile("file"); err == nil {
+			// Uploading the CSV: guess the columns
+			defer file.Close()
+			var contents strings.Builder
+			header, rows, err = readImportCSV(io.TeeReader(file, &contents))
+			data.FileName, data.CSV = fileHeader.Filename, contents.String()
+			if err == nil {
+				data.Columns = guessImportColumns(header, rows)
+			}
+			data.Error = importErrorMessage(err)
+		} else {
+			// Choosing the columns: import, or preview again
+			data.FileName, data.CSV = r.PostFormValue("file_name"), r.PostFormValue("csv")
+			header, rows, err = readImportCSV(strings.NewReader(data.CSV))
+			if err == nil {
+				for i, column := range header {
+					field := strconv.Itoa(i)
+					data.Columns = append(data.Columns, ImportColumn{
+						Column: column,
+						As:     r.PostFormValue("as." + field),
+						Key:    strings.TrimSpace(r.PostFormValue("key." + field)),
+					})
+				}
+			}
+			data.Error = importErrorMessage(err)
+		}
+
+		if data.Error == "" {
+			runs, err := planCSVImport(header, rows, data.Columns)
+			if err == nil && r.PostFormValue("import") != "" {
+				if !quotaExempt(r) {
+					err = checkExperimentQuota(ctx, experimentID, int64(len(runs)), int64(importMetricPoints(runs)), 0)
+				}
+				if err == nil {
+					if _, err = importRuns(ctx, experimentID, runs, data.FileName); err == nil {
+						recordMetricPointUsage(ctx, experimentID, importMetricPoints(runs))
+						http.Redirect(w, r, "/experiments/"+experimentUUID, http.StatusSeeOther)
+						return
+					}
+				}
+			}
+			data.RowCount = len(runs)
+			data.Preview = runs[:min(len(runs), importPreviewRows)]
+			if data.Error = importErrorMessage(err); data.Error == "" && err != nil {
+				log.Printf("Failed to import runs into experiment %s: %v", experimentUUID, err)
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	tmpl, err := experimentImportTemplates.Get()
+	if err != nil {
+		log.Printf("Failed to parse template: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if err := tmpl.ExecuteTemplate(w, "experiment_import.html", data); err != nil {
+		log.Printf("Failed to execute template: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// importErrorMessage returns the message of an error the user can fix by
+// changing the CSV or its mapping, or of a quota it exceeds
+func importErrorMessage(err error) string {
+	var importErr *importCSVError
+	var quotaErr *quotaExceededError
+	switch {
+	case errors.As(err, &importErr):
+		return importErr.message
+	case errors.As(err, &quotaErr):
+		return "Quota exceeded: " + quotaErr.Error()
+	}
+	return ""
+}
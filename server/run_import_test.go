@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const importTestCSV = "\xef\xbb\xbfName,lr,layers,optimizer,Val Accuracy,date\n" +
+	"baseline,0.1,4,sgd,81.5%,2019-03-01\n" +
+	"wider,0.01,8,adam,0.84,2019-03-02\n" +
+	"no date,1,,adam,,\n"
+
+func TestReadImportCSV(t *testing.T) {
+	header, rows, err := readImportCSV(strings.NewReader(importTestCSV))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header[0] != "Name" || len(header) != 6 || len(rows) != 3 {
+		t.Errorf("Expected the byte order mark stripped and 3 rows, got %q %d", header, len(rows))
+	}
+
+	for _, invalid := range []string{"name,lr\n", "name,name\na,b\n", "name,\na,b\n", "name,lr\na\n"} {
+		if _, _, err := readImportCSV(strings.NewReader(invalid)); err == nil {
+			t.Errorf("Expected an error reading %q", invalid)
+		}
+	}
+}
+
+func TestGuessImportColumns(t *testing.T) {
+	header, rows, _ := readImportCSV(strings.NewReader(importTestCSV))
+	got := guessImportColumns(header, rows)
+	want := []string{importColumnName, importColumnParam, importColumnParam, importColumnParam, importColumnMetric, importColumnDate}
+	for i, c := range got {
+		if c.As != want[i] {
+			t.Errorf("Expected %s guessed as %s, got %s", c.Column, want[i], c.As)
+		}
+	}
+}
+
+func TestPlanCSVImport(t *testing.T) {
+	header, rows, _ := readImportCSV(strings.NewReader(importTestCSV))
+	columns := guessImportColumns(header, rows)
+	columns[4].Key = "val/accuracy"
+	runs, err := planCSVImport(header, rows, columns)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if runs[0].Name != "baseline" || runs[0].Date == nil || !runs[0].Date.Equal(time.Date(2019, 3, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected the name and date imported, got %+v", runs[0])
+	}
+	if runs[0].Metrics["val/accuracy"] != 0.815 || runs[1].Metrics["val/accuracy"] != 0.84 {
+		t.Errorf("Expected percentages imported as fractions, got %v %v", runs[0].Metrics, runs[1].Metrics)
+	}
+	// Parameters are typed by every value in their column
+	types := make(map[string]string)
+	for _, p := range runs[2].Parameters {
+		types[p.Key] = p.ValueType
+	}
+	if types["lr"] != "float" || types["optimizer"] != "string" || types["layers"] != "" {
+		t.Errorf("Expected lr a float, optimizer a string and the empty layers skipped, got %v", types)
+	}
+	if runs[2].Date != nil || len(runs[2].Metrics) != 0 {
+		t.Errorf("Expected empty cells skipped, got %+v", runs[2])
+	}
+
+	for _, tt := range []struct {
+		columns []ImportColumn
+		want    string
+	}{
+		{[]ImportColumn{{Column: "missing", As: importColumnParam}}, "no column"},
+		{[]ImportColumn{{Column: "lr", As: "tag"}}, "cannot be imported"},
+		{[]ImportColumn{{Column: "Name", As: importColumnName}, {Column: "optimizer", As: importColumnName}}, "Only one column"},
+		{[]ImportColumn{{Column: "lr", As: importColumnMetric, Key: "x"}, {Column: "layers", As: importColumnMetric, Key: "x"}}, "both imported"},
+		{[]ImportColumn{{Column: "optimizer", As: importColumnMetric}}, "Row 2"},
+		{[]ImportColumn{{Column: "optimizer", As: importColumnDate}}, "unrecognized date"},
+	} {
+		if _, err := planCSVImport(header, rows, tt.columns); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("Expected an error containing %q for %+v, got %v", tt.want, tt.columns, err)
+		}
+	}
+}
+
+// importDAO records the runs imported into it
+type importDAO struct {
+	DAO
+	runs      []string
+	params    int
+	metrics   map[string]int64
+	tags      map[string]string
+	finished  int
+	backdated []time.Time
+}
+
+func (d *importDAO) GetDefaultExperimentID(ctx context.Context) (int, error) {
+	return 1, nil
+}
+
+func (d *importDAO) GetExperimentQuota(ctx context.Context, experimentID int) (*ExperimentQuotaRow, error) {
+	return nil, nil
+}
+
+func (d *importDAO) AddExperimentMetricPoints(ctx context.Context, experimentID int, day string, points int) error {
+	return nil
+}
+
+func (d *importDAO) GetRunIDByUUID(ctx context.Context, uuid string) (int, error) {
+	return len(d.runs), nil
+}
+
+func (d *importDAO) UpdateRunStatus(ctx context.Context, runID int, status, message string) error {
+	d.finished++
+	return nil
+}
+
+func (d *importDAO) BackdateRun(ctx context.Context, runID int, at time.Time) error {
+	d.backdated = append(d.backdated, at)
+	return nil
+}
+
+func (d *importDAO) SetRunTag(ctx context.Context, runID int, key, value string) error {
+	d.tags[key] = value
+	return nil
+}
+
+func (d *importDAO) InsertRun(ctx context.Context, uuid, name string, experimentID int, parentRunID *int) error {
+	d.runs = append(d.runs, name)
+	return nil
+}
+
+func (d *importDAO) UpsertParameter(ctx context.Context, runID int, key, valueType string, valueString *string, valueBool *bool, valueFloat *float64, valueInt *int64) error {
+	d.params++
+	return nil
+}
+
+func (d *importDAO) InsertMetrics(ctx context.Context, runID int, key string, xValues []float64, yValues []float64, loggedAt int64) error {
+	d.metrics[key] = loggedAt
+	return nil
+}
+
+func TestHandleAPIImportRunsCSV(t *testing.T) {
+	defer func(d DAO) { dao = d }(dao)
+	fake := &importDAO{metrics: make(map[string]int64), tags: make(map[string]string)}
+	dao = fake
+
+	post := func(fields map[string]string) (int, map[string]interface{}) {
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		for name, value := range fields {
+			mw.WriteField(name, value)
+		}
+		file, _ := mw.CreateFormFile("file", "results.csv")
+		file.Write([]byte(importTestCSV))
+		mw.Close()
+
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/api/experiments/import", &body)
+		r.Header.Set("Content-Type", mw.FormDataContentType())
+		handleAPIImportRunsCSV(w, r)
+		var resp map[string]interface{}
+		json.NewDecoder(w.Body).Decode(&resp)
+		return w.Code, resp
+	}
+
+	code, resp := post(map[string]string{"dry_run": "true"})
+	if code != http.StatusOK || len(resp["runs"].([]interface{})) != 3 || len(fake.runs) != 0 {
+		t.Fatalf("Expected a dry run to plan 3 runs and create none, got %d %v", code, resp)
+	}
+
+	code, resp = post(map[string]string{"columns": `[{"column": "Name", "as": "name"}, {"column": "Val Accuracy", "as": "metric", "key": "accuracy"}, {"column": "date", "as": "date"}]`})
+	if code != http.StatusOK || len(resp["run_uuids"].([]interface{})) != 3 {
+		t.Fatalf("Expected 3 runs imported, got %d %v", code, resp)
+	}
+	if strings.Join(fake.runs, ",") != "baseline,wider,no date" || fake.params != 0 || fake.finished != 3 || len(fake.backdated) != 2 {
+		t.Errorf("Expected finished runs without parameters, two backdated, got %+v", fake)
+	}
+	if fake.metrics["accuracy"] != time.Date(2019, 3, 2, 0, 0, 0, 0, time.UTC).UnixMilli() || fake.tags["imported_from"] != "results.csv" {
+		t.Errorf("Expected final metrics logged at the run's date and runs tagged with the file, got %v %v", fake.metrics, fake.tags)
+	}
+
+	if code, _ := post(map[string]string{"columns": `[{"column": "lr", "as": "date"}]`}); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid mapping, got %d", code)
+	}
+}
+
+const importTestJSONL = `{"name": "baseline", "parameters": {"lr": 0.1, "optimizer": "sgd"}, "metrics": {"accuracy": 0.815}, "date": "2019-03-01"}
+
+{"parameters": [{"key": "lr", "value": "0.01", "type": "float"}]}
+`
+
+func TestPlanJSONLImport(t *testing.T) {
+	runs, err := planJSONLImport(strings.NewReader(importTestJSONL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 2 {
+		t.Fatalf("Expected the blank line skipped and 2 runs, got %+v", runs)
+	}
+	if runs[0].Name != "baseline" || runs[0].Date == nil || !runs[0].Date.Equal(time.Date(2019, 3, 1, 0, 0, 0, 0, time.UTC)) || runs[0].Metrics["accuracy"] != 0.815 {
+		t.Errorf("Expected the name, date and metrics imported, got %+v", runs[0])
+	}
+	if len(runs[0].Parameters) != 2 || runs[0].ParameterValues["optimizer"] != "sgd" {
+		t.Errorf("Expected the parameters imported, got %+v", runs[0].Parameters)
+	}
+	if runs[1].Name != "Imported run 2" || runs[1].Parameters[0].ValueType != "float" || runs[1].Date != nil {
+		t.Errorf("Expected a default name and converted parameter, got %+v", runs[1])
+	}
+
+	for invalid, want := range map[string]string{
+		"":                                    "at least one",
+		"{\"name\": \"a\"}\n{\"params\": {}}": "Line 2",
+		`{"name": "a"} {"name": "b"}`:         "own line",
+		`{"date": "yesterday"}`:               "unrecognized date",
+		`{"parameters": {"lr": null}}`:        "parameter \"lr\"",
+		`{"metrics": {"loss": "low"}}`:        "Line 1",
+	} {
+		_, err := planJSONLImport(strings.NewReader(invalid))
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected an error containing %q for %q, got %v", want, invalid, err)
+		}
+	}
+}
+
+func TestHandleAPIImportRunsJSONL(t *testing.T) {
+	defer func(d DAO) { dao = d }(dao)
+	fake := &importDAO{metrics: make(map[string]int64), tags: make(map[string]string)}
+	dao = fake
+
+	post := func(query, body string) (int, map[string]interface{}) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/api/experiments/import/jsonl?"+query, strings.NewReader(body))
+		handleAPIImportRunsJSONL(w, r)
+		var resp map[string]interface{}
+		json.NewDecoder(w.Body).Decode(&resp)
+		return w.Code, resp
+	}
+
+	code, resp := post("dry_run=true", importTestJSONL)
+	if code != http.StatusOK || len(resp["runs"].([]interface{})) != 2 || len(fake.runs) != 0 {
+		t.Fatalf("Expected a dry run to plan 2 runs and create none, got %d %v", code, resp)
+	}
+
+	code, resp = post("source=sweep.py", importTestJSONL)
+	if code != http.StatusOK || len(resp["run_uuids"].([]interface{})) != 2 {
+		t.Fatalf("Expected 2 runs imported, got %d %v", code, resp)
+	}
+	if strings.Join(fake.runs, ",") != "baseline,Imported run 2" || fake.params != 3 || fake.finished != 2 || len(fake.backdated) != 1 {
+		t.Errorf("Expected finished runs with their parameters, one backdated, got %+v", fake)
+	}
+	if fake.tags["imported_from"] != "sweep.py" {
+		t.Errorf("Expected runs tagged with the source, got %v", fake.tags)
+	}
+
+	if code, _ := post("", `{"nmae": "typo"}`); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an unknown field, got %d", code)
+	}
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// Merging runs folds a run into another, for when a crashed and restarted job
+// logged one logical run as two. The source run's parameters, metric points
+// and artifacts move to the target run, and the source run is then deleted
+// along with anything else logged to it. Every merge is recorded in the audit
+// log.
+
+// Ways to resolve parameters or artifacts both runs have with different values
+const (
+	// runMergeFail refuses to merge if there are any conflicts
+	runMergeFail = ""
+	// runMergePreferTarget keeps the target run's values
+	runMergePreferTarget = "target"
+	// runMergePreferSource replaces the target run's values with the source's
+	runMergePreferSource = "source"
+)
+
+// RunMergeConflict is a parameter or artifact both runs have with different
+// values
+type RunMergeConflict struct {
+	Kind   string `json:"kind"`
+	Key    string `json:"key"`
+	Target string `json:"target"`
+	Source string `json:"source"`
+}
+
+// RunMerge is what merging one run into another did
+type RunMerge struct {
+	SourceRunUUID string             `json:"source_run_uuid"`
+	TargetRunUUID string             `json:"target_run_uuid"`
+	Prefer        string             `json:"prefer,omitempty"`
+	Parameters    int                `json:"parameters"`
+	MetricPoints  int                `json:"metric_points"`
+	Artifacts     int                `json:"artifacts"`
+	Conflicts     []RunMergeConflict `json:"conflicts"`
+}
+
+// runMergeConflictError is returned when runs conflict and no way to resolve
+// the conflicts was given
+type runMergeConflictError struct {
+	conflicts []RunMergeConflict
+}
+
+func (e *runMergeConflictError) Error() string {
+	return fmt.Sprintf("runs have %d conflicting parameters or artifacts", len(e.conflicts))
+}
+
+// runMergeError is returned when two runs cannot be merged, e.g. because they
+// are the same run
+type runMergeError struct {
+	message string
+}
+
+func (e *runMergeError) Error() string {
+	return e.message
+}
+
+// mergeRuns merges the source run into the target run, resolving conflicts as
+// prefer says. Neither run may be on hold (errRunOnHold), and the source run
+// may not have child runs, which deleting it would delete too.
+func mergeRuns(ctx context.Context, sourceRunID, targetRunID int, sourceRunUUID, targetRunUUID, prefer string) (*RunMerge, error) {
+	switch {
+	case prefer != runMergeFail && prefer != runMergePreferTarget && prefer != runMergePreferSource:
+		return nil, &runMergeError{fmt.Sprintf("prefer must be %q or %q", runMergePreferTarget, runMergePreferSource)}
+	case sourceRunID == targetRunID:
+		return nil, &runMergeError{"cannot merge a run into itself"}
+	}
+	childCount, err := dao.GetChildRunCount(ctx, sourceRunID)
+	if err != nil {
+		return nil, err
+	}
+	if childCount > 0 {
+		return nil, &runMergeError{"the source run has child runs; merge or delete them first"}
+	}
+	for _, runID := range []int{sourceRunID, targetRunID} {
+		if err := ensureRunNotOnHold(ctx, runID); err != nil {
+			return nil, err
+		}
+	}
+
+	merge := &RunMerge{
+		SourceRunUUID: sourceRunUUID,
+		TargetRunUUID: targetRunUUID,
+		Prefer:        prefer,
+		Conflicts:     []RunMergeConflict{},
+	}
+
+	// Work out everything that moves before moving anything, so that a merge
+	// refused for its conflicts changes nothing
+	targetParams, err := dao.GetParametersByRunID(ctx, targetRunID)
+	if err != nil {
+		return nil, err
+	}
+	sourceParams, err := dao.GetParametersByRunID(ctx, sourceRunID)
+	if err != nil {
+		return nil, err
+	}
+	targetParamsByKey := make(map[string]ParameterRow, len(targetParams))
+	for _, p := range targetParams {
+		targetParamsByKey[p.Key] = p
+	}
+	var params []ParameterRow
+	for _, p := range sourceParams {
+		existing, ok := targetParamsByKey[p.Key]
+		if !ok {
+			params = append(params, p)
+			continue
+		}
+		if existing.ValueType == p.ValueType && formatParameterValue(existing) == formatParameterValue(p) {
+			continue
+		}
+		merge.Conflicts = append(merge.Conflicts, RunMergeConflict{
+			Kind:   "parameter",
+			Key:    p.Key,
+			Target: formatParameterValue(existing),
+			Source: formatParameterValue(p),
+		})
+		if prefer == runMergePreferSource {
+			params = append(params, p)
+		}
+	}
+
+	targetArtifacts, err := dao.GetArtifactsByRunID(ctx, targetRunID)
+	if err != nil {
+		return nil, err
+	}
+	sourceArtifacts, err := dao.GetArtifactsByRunID(ctx, sourceRunID)
+	if err != nil {
+		return nil, err
+	}
+	targetArtifactsByPath := make(map[string]ArtifactRow, len(targetArtifacts))
+	for _, a := range targetArtifacts {
+		targetArtifactsByPath[a.Path] = a
+	}
+	var artifacts []ArtifactRow
+	for _, a := range sourceArtifacts {
+		existing, ok := targetArtifactsByPath[a.Path]
+		if !ok {
+			artifacts = append(artifacts, a)
+			continue
+		}
+		if existing.SHA256 != "" && existing.SHA256 == a.SHA256 {
+			continue
+		}
+		merge.Conflicts = append(merge.Conflicts, RunMergeConflict{
+			Kind:   "artifact",
+			Key:    a.Path,
+			Target: formatArtifactVersion(existing),
+			Source: formatArtifactVersion(a),
+		})
+		if prefer == runMergePreferSource {
+			artifacts = append(artifacts, a)
+		}
+	}
+
+	if len(merge.Conflicts) > 0 && prefer == runMergeFail {
+		return nil, &runMergeConflictError{merge.Conflicts}
+	}
+
+	// Artifacts are copied beneath the target run, since the source run's
+	// files are deleted with it
+	for _, a := range artifacts {
+		if err := moveArtifact(ctx, a, targetRunID, targetRunUUID); err != nil {
+			return nil, fmt.Errorf("moving artifact %s: %w", a.Path, err)
+		}
+		merge.Artifacts++
+	}
+	for _, p := range params {
+		valueString, valueBool, valueFloat, valueInt := parameterValuePointers(p)
+		if err := dao.UpsertParameter(ctx, targetRunID, p.Key, p.ValueType, valueString, valueBool, valueFloat, valueInt); err != nil {
+			return nil, fmt.Errorf("moving parameter %s: %w", p.Key, err)
+		}
+		merge.Parameters++
+	}
+	merge.MetricPoints, err = dao.MergeRunMetrics(ctx, sourceRunID, targetRunID)
+	if err != nil {
+		return nil, fmt.Errorf("moving metrics: %w", err)
+	}
+
+	// The audit log entry is written before the source run is deleted, so
+	// that a merge is recorded even if the deletion fails
+	if err := recordAudit(ctx, "merge_runs", targetRunUUID, merge); err != nil {
+		return nil, fmt.Errorf("recording merge in audit log: %w", err)
+	}
+	if err := deleteRun(ctx, sourceRunID, ""); err != nil {
+		return nil, fmt.Errorf("deleting source run: %w", err)
+	}
+	return merge, nil
+}
+
+// moveArtifact copies an artifact's contents beneath another run and records
+// it there
+func moveArtifact(ctx context.Context, a ArtifactRow, targetRunID int, targetRunUUID string) error {
+	key, err := artifactKey(a.URI)
+	if err != nil {
+		return err
+	}
+	src, err := artifactStore.Get(key)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	version, err := nextArtifactVersion(ctx, targetRunID, a.Path)
+	if err != nil {
+		return err
+	}
+	runKey, err := runArtifactKey(ctx, targetRunID, targetRunUUID)
+	if err != nil {
+		return err
+	}
+	uri, size, digest, err := storeArtifact(runKey, a.Path, version, src)
+	if err != nil {
+		return err
+	}
+	if err := dao.UpsertArtifact(ctx, targetRunID, a.Path, uri, a.Type, a.ContentType, size, digest); err != nil {
+		return err
+	}
+	// The contents are the same, so they keep their scan status
+	if a.ScanStatus == "" || a.ScanStatus == artifactScanPending {
+		return nil
+	}
+	return dao.SetArtifactScanStatus(ctx, uri, a.ScanStatus, a.ScanResult)
+}
+
+// formatArtifactVersion describes an artifact's contents for a conflict
+func formatArtifactVersion(a ArtifactRow) string {
+	if a.SHA256 == "" {
+		return formatBytes(a.SizeBytes)
+	}
+	return fmt.Sprintf("%s, sha256 %.12s", formatBytes(a.SizeBytes), a.SHA256)
+}
+
+// MergeRunsRequest is the body of POST /api/admin/runs/merge
+type MergeRunsRequest struct {
+	SourceRunUUID string `json:"source_run_uuid"`
+	TargetRunUUID string `json:"target_run_uuid"`
+	Prefer        string `json:"prefer"`
+}
+
+// handleAPIMergeRuns merges one run into another, at POST /api/admin/runs/merge
+func handleAPIMergeRuns(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if !requireAdmin(w, r) {
+		return
+	}
+
+	var req MergeRunsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid JSON"})
+		return
+	}
+	if req.SourceRunUUID == "" || req.TargetRunUUID == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Missing required fields: source_run_uuid, target_run_uuid"})
+		return
+	}
+
+	sourceRunID, err := dao.GetRunIDByUUID(ctx, req.SourceRunUUID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Source run not found"})
+		return
+	}
+	targetRunID, err := dao.GetRunIDByUUID(ctx, req.TargetRunUUID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Target run not found"})
+		return
+	}
+
+	merge, err := mergeRuns(ctx, sourceRunID, targetRunID, req.SourceRunUUID, req.TargetRunUUID, req.Prefer)
+	var conflictErr *runMergeConflictError
+	var mergeErr *runMergeError
+	switch {
+	case errors.As(err, &conflictErr):
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":     "The runs have conflicting parameters or artifacts; choose which to keep with prefer: \"target\" or \"source\"",
+			"conflicts": conflictErr.conflicts,
+		})
+	case errors.As(err, &mergeErr):
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": mergeErr.Error()})
+	case errors.Is(err, errRunOnHold):
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]string{"error": "One of the runs is on hold"})
+	case err != nil:
+		log.Printf("Failed to merge run %s into %s: %v", req.SourceRunUUID, req.TargetRunUUID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to merge runs"})
+	default:
+		json.NewEncoder(w).Encode(merge)
+	}
+}
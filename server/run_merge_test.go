@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// mergeDAO keeps two runs' parameters and artifacts in memory
+type mergeDAO struct {
+	defaultProjectDAO
+	params    map[int][]ParameterRow
+	artifacts map[int][]ArtifactRow
+	deleted   map[int]bool
+	purged    []int
+	audit     []AuditLogRow
+}
+
+func newMergeDAO() *mergeDAO {
+	return &mergeDAO{
+		params: map[int][]ParameterRow{
+			1: {
+				{Key: "lr", ValueType: "float", ValueFloat: sql.NullFloat64{Float64: 0.1, Valid: true}},
+				{Key: "seed", ValueType: "int", ValueInt: sql.NullInt64{Int64: 1, Valid: true}},
+			},
+			2: {
+				{Key: "lr", ValueType: "float", ValueFloat: sql.NullFloat64{Float64: 0.1, Valid: true}},
+				{Key: "seed", ValueType: "int", ValueInt: sql.NullInt64{Int64: 2, Valid: true}},
+				{Key: "resumed", ValueType: "bool", ValueBool: sql.NullBool{Bool: true, Valid: true}},
+			},
+		},
+		artifacts: map[int][]ArtifactRow{
+			1: {{Path: "model.pt", URI: "file://original/model.pt", Type: "unknown", SHA256: "aaa", Version: 1}},
+			2: {
+				{Path: "model.pt", URI: "file://restarted/model.pt", Type: "unknown", SHA256: "bbb", Version: 1},
+				{Path: "logs/out.txt", URI: "file://restarted/logs/out.txt", Type: "unknown", Version: 1},
+			},
+		},
+		deleted: map[int]bool{},
+	}
+}
+
+func (d *mergeDAO) GetRunIDByUUID(ctx context.Context, uuid string) (int, error) {
+	id := map[string]int{"original": 1, "restarted": 2}[uuid]
+	if id == 0 || d.deleted[id] {
+		return 0, sql.ErrNoRows
+	}
+	return id, nil
+}
+
+func (d *mergeDAO) GetChildRunCount(ctx context.Context, parentRunID int) (int, error) { return 0, nil }
+
+func (d *mergeDAO) GetChildRuns(ctx context.Context, parentRunID int) ([]Run, error) { return nil, nil }
+
+func (d *mergeDAO) GetRunHold(ctx context.Context, runID int) (*RunHoldRow, error) { return nil, nil }
+
+func (d *mergeDAO) GetParametersByRunID(ctx context.Context, runID int) ([]ParameterRow, error) {
+	return d.params[runID], nil
+}
+
+func (d *mergeDAO) UpsertParameter(ctx context.Context, runID int, key, valueType string, valueString *string, valueBool *bool, valueFloat *float64, valueInt *int64) error {
+	p := ParameterRow{Key: key, ValueType: valueType}
+	if valueInt != nil {
+		p.ValueInt = sql.NullInt64{Int64: *valueInt, Valid: true}
+	}
+	if valueBool != nil {
+		p.ValueBool = sql.NullBool{Bool: *valueBool, Valid: true}
+	}
+	for i, existing := range d.params[runID] {
+		if existing.Key == key {
+			d.params[runID][i] = p
+			return nil
+		}
+	}
+	d.params[runID] = append(d.params[runID], p)
+	return nil
+}
+
+func (d *mergeDAO) GetArtifactsByRunID(ctx context.Context, runID int) ([]ArtifactRow, error) {
+	return d.artifacts[runID], nil
+}
+
+func (d *mergeDAO) GetArtifactByRunIDAndPath(ctx context.Context, runID int, path string) (*ArtifactRow, error) {
+	for _, a := range d.artifacts[runID] {
+		if a.Path == path {
+			return &a, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+
+func (d *mergeDAO) UpsertArtifact(ctx context.Context, runID int, path, uri, artifactType, contentType string, sizeBytes int64, sha256 string) error {
+	a := ArtifactRow{Path: path, URI: uri, Type: artifactType, ContentType: contentType, SizeBytes: sizeBytes, SHA256: sha256}
+	for i, existing := range d.artifacts[runID] {
+		if existing.Path == path {
+			d.artifacts[runID][i] = a
+			return nil
+		}
+	}
+	d.artifacts[runID] = append(d.artifacts[runID], a)
+	return nil
+}
+
+func (d *mergeDAO) MergeRunMetrics(ctx context.Context, sourceRunID, targetRunID int) (int, error) {
+	return 3, nil
+}
+
+func (d *mergeDAO) InsertAuditLogEntry(ctx context.Context, e AuditLogRow) error {
+	d.audit = append(d.audit, e)
+	return nil
+}
+
+func (d *mergeDAO) MarkRunDeleted(ctx context.Context, runID int, at time.Time, deletedBy string) error {
+	d.deleted[runID] = true
+	return nil
+}
+
+func (d *mergeDAO) GetDeletedRuns(ctx context.Context) ([]DeletedRunRow, error) {
+	if d.deleted[2] && len(d.purged) == 0 {
+		return []DeletedRunRow{{ID: 2, UUID: "restarted"}}, nil
+	}
+	return nil, nil
+}
+
+func (d *mergeDAO) PurgeRun(ctx context.Context, runID int) error {
+	d.purged = append(d.purged, runID)
+	return nil
+}
+
+func TestHandleAPIMergeRuns(t *testing.T) {
+	defer func(d DAO, token string) { dao, adminToken = d, token }(dao, adminToken)
+	defer func(s ArtifactStore) { artifactStore = s }(artifactStore)
+	defer func(days int) { trashPurgeAfterDays = days }(trashPurgeAfterDays)
+	adminToken = "admin"
+	// The merged run is purged straight away rather than kept in the trash
+	trashPurgeAfterDays = 0
+	store, err := newFileArtifactStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	artifactStore = store
+	for key, content := range map[string]string{
+		"original/model.pt":      "first weights",
+		"restarted/model.pt":     "resumed weights",
+		"restarted/logs/out.txt": "log",
+	} {
+		if _, err := store.Put(key, strings.NewReader(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	d := newMergeDAO()
+	dao = d
+
+	merge := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/api/admin/runs/merge", strings.NewReader(body))
+		r.Header.Set("Authorization", "Bearer admin")
+		handleAPIMergeRuns(w, r)
+		return w
+	}
+
+	// Conflicts refuse the merge unless told how to resolve them
+	w := merge(`{"source_run_uuid": "restarted", "target_run_uuid": "original"}`)
+	if w.Code != http.StatusConflict {
+		t.Fatalf("Expected 409 for conflicting runs, got %d: %s", w.Code, w.Body)
+	}
+	var refused struct {
+		Conflicts []RunMergeConflict `json:"conflicts"`
+	}
+	json.Unmarshal(w.Body.Bytes(), &refused)
+	if len(refused.Conflicts) != 2 || refused.Conflicts[0].Key != "seed" || refused.Conflicts[1].Key != "model.pt" {
+		t.Errorf("Expected the seed and model.pt to conflict, got %+v", refused.Conflicts)
+	}
+	if len(d.params[1]) != 2 || len(d.deleted) != 0 || len(d.audit) != 0 {
+		t.Error("Expected a refused merge to change nothing")
+	}
+
+	if w := merge(`{"source_run_uuid": "original", "target_run_uuid": "original", "prefer": "source"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for merging a run into itself, got %d", w.Code)
+	}
+
+	w = merge(`{"source_run_uuid": "restarted", "target_run_uuid": "original", "prefer": "source"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body)
+	}
+	var result RunMerge
+	json.Unmarshal(w.Body.Bytes(), &result)
+	if result.Parameters != 2 || result.Artifacts != 2 || result.MetricPoints != 3 {
+		t.Errorf("Unexpected merge %+v", result)
+	}
+	if seed := d.params[1][1]; seed.Key != "seed" || seed.ValueInt.Int64 != 2 {
+		t.Errorf("Expected the source's seed to be preferred, got %+v", seed)
+	}
+
+	// The artifacts now live beneath the target run
+	for _, a := range d.artifacts[1] {
+		key, _ := artifactStore.Key(a.URI)
+		if !strings.HasPrefix(key, "original/") {
+			t.Errorf("Expected %s to be stored beneath the target run, got %s", a.Path, a.URI)
+		}
+	}
+	// The source's model.pt is a new version of the target's
+	if uri := d.artifacts[1][0].URI; uri != store.URI("original/~versions/2/model.pt") {
+		t.Errorf("Expected the source's model.pt stored as a second version, got %s", uri)
+	}
+	file, err := store.Get("original/~versions/2/model.pt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, _ := io.ReadAll(file)
+	file.Close()
+	if string(content) != "resumed weights" {
+		t.Errorf("Expected the source's model.pt to be preferred, got %q", content)
+	}
+
+	if !d.deleted[2] || len(d.purged) != 1 {
+		t.Errorf("Expected the source run to be deleted and purged")
+	}
+	if keys, _ := store.List("restarted/"); len(keys) != 0 {
+		t.Errorf("Expected the source run's files to be purged, got %v", keys)
+	}
+	if len(d.audit) != 1 || d.audit[0].Action != "merge_runs" || d.audit[0].Subject != "original" {
+		t.Fatalf("Expected the merge in the audit log, got %+v", d.audit)
+	}
+	var recorded RunMerge
+	if err := json.Unmarshal([]byte(d.audit[0].Details), &recorded); err != nil || recorded.SourceRunUUID != "restarted" || len(recorded.Conflicts) != 2 {
+		t.Errorf("Unexpected audit details %s", d.audit[0].Details)
+	}
+
+	if w := merge(`{"source_run_uuid": "restarted", "target_run_uuid": "original"}`); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a merged run, got %d", w.Code)
+	}
+}
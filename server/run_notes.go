@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"time"
+)
+
+// RunNotes is the notes panel of a run's overview
+type RunNotes struct {
+	UUID  string
+	Notes string
+	// SavedAt is when the notes were last saved from the panel, and is empty
+	// when the panel is first shown
+	SavedAt string
+}
+
+// HTML renders the notes as Markdown
+func (n RunNotes) HTML() template.HTML {
+	return renderMarkdown(n.Notes)
+}
+
+// PutRunNotesRequest is the body of PUT /api/runs/{uuid}/notes
+type PutRunNotesRequest struct {
+	Notes *string `json:"notes"`
+}
+
+// handleAPIPutRunNotes replaces a run's notes
+func handleAPIPutRunNotes(w http.ResponseWriter, r *http.Request, runUUID string) {
+	ctx := r.Context()
+	if r.Method != http.MethodPut {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+
+	var req PutRunNotesRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid JSON"})
+		return
+	}
+	if req.Notes == nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Missing required field: notes"})
+		return
+	}
+
+	runID, err := dao.GetRunIDByUUID(ctx, runUUID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Run not found"})
+		return
+	}
+
+	if err := dao.UpdateRunNotes(ctx, runID, *req.Notes); err != nil {
+		log.Printf("Failed to update notes for run %s: %v", runUUID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to update notes"})
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok", "notes": *req.Notes})
+}
+
+// runNotesTemplates are the templates of the notes panel of the run overview
+var runNotesTemplates = registerTemplates("templates/run_notes_form.html")
+
+// handleUpdateRunNotes saves a run's notes from the notes panel, which posts
+// them as they are typed, and returns the rendered notes for htmx to swap in
+// beside the editor
+func handleUpdateRunNotes(w http.ResponseWriter, r *http.Request, runUUID string) {
+	ctx := r.Context()
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	notes := r.FormValue("notes")
+
+	runID, err := dao.GetRunIDByUUID(ctx, runUUID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "Run not found")
+		return
+	}
+
+	if err := dao.UpdateRunNotes(ctx, runID, notes); err != nil {
+		log.Printf("Failed to update notes for run %s: %v", runUUID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "Failed to save notes")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	tmpl, err := runNotesTemplates.Get()
+	if err != nil {
+		log.Printf("Failed to parse template: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	err = executeTemplate(w, tmpl, "notes_view", RunNotes{
+		UUID:    runUUID,
+		Notes:   notes,
+		SavedAt: time.Now().Format("15:04:05"),
+	})
+	if err != nil {
+		log.Printf("Failed to render template: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// runNotesDAO keeps the notes of one run
+type runNotesDAO struct {
+	DAO
+	notes string
+}
+
+func (d *runNotesDAO) GetRunIDByUUID(ctx context.Context, uuid string) (int, error) {
+	if uuid != "run-1" {
+		return 0, sql.ErrNoRows
+	}
+	return 1, nil
+}
+
+func (d *runNotesDAO) UpdateRunNotes(ctx context.Context, runID int, notes string) error {
+	d.notes = notes
+	return nil
+}
+
+func TestHandleAPIPutRunNotes(t *testing.T) {
+	defer func(d DAO) { dao = d }(dao)
+	fake := &runNotesDAO{notes: "old"}
+	dao = fake
+
+	put := func(path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handleAPIV1Runs(w, httptest.NewRequest(http.MethodPut, path, strings.NewReader(body)))
+		return w
+	}
+
+	if w := put("/api/v1/runs/run-1/notes", `{"notes": "# Findings\n\nLR too high"}`); w.Code != http.StatusOK || fake.notes != "# Findings\n\nLR too high" {
+		t.Errorf("Expected the notes replaced, got %d %q", w.Code, fake.notes)
+	}
+	// Notes are cleared with an empty string, but not by leaving them out
+	if w := put("/api/runs/run-1/notes", `{"notes": ""}`); w.Code != http.StatusOK || fake.notes != "" {
+		t.Errorf("Expected the notes cleared, got %d %q", w.Code, fake.notes)
+	}
+	if w := put("/api/runs/run-1/notes", `{}`); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 without notes, got %d", w.Code)
+	}
+	if w := put("/api/runs/missing/notes", `{"notes": "x"}`); w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown run, got %d", w.Code)
+	}
+	w := httptest.NewRecorder()
+	handleAPIV1Runs(w, httptest.NewRequest(http.MethodGet, "/api/runs/run-1/notes", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected 405 for GET, got %d", w.Code)
+	}
+}
+
+func TestHandleUpdateRunNotes(t *testing.T) {
+	defer func(d DAO) { dao = d }(dao)
+	fake := &runNotesDAO{}
+	dao = fake
+
+	form := url.Values{"notes": {"**Diverged** at step 10 <script>"}}
+	r := httptest.NewRequest(http.MethodPost, "/runs/run-1/notes", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	handleUpdateRunNotes(w, r, "run-1")
+
+	// The panel autosaves, so only the rendered notes and the save status
+	// are swapped in, never the editor being typed in
+	body := w.Body.String()
+	if w.Code != http.StatusOK || fake.notes != form.Get("notes") {
+		t.Fatalf("Expected the notes saved, got %d %q", w.Code, fake.notes)
+	}
+	if !strings.Contains(body, "<strong>Diverged</strong>") || strings.Contains(body, "<script>") {
+		t.Errorf("Expected escaped rendered Markdown, got %s", body)
+	}
+	if strings.Contains(body, "<textarea") || !strings.Contains(body, `hx-swap-oob="true">Saved at`) {
+		t.Errorf("Expected the rendered notes and save status only, got %s", body)
+	}
+}
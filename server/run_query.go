@@ -0,0 +1,454 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Run queries select runs by their fields, parameters, latest metric values
+// and tags, e.g.
+//
+//	params.lr > 0.001 AND metrics.loss < 0.2 AND name ~ "resnet"
+//
+// A comparison is a field, an operator and a value. The fields are name,
+// status and experiment (its name), and params.KEY, metrics.KEY and tags.KEY.
+// The operators are =, !=, <, <=, > and >=, and ~, which matches text
+// containing the value, ignoring case. Values are numbers, true or false, or
+// text, in double quotes if it is not a single word. Comparisons combine with
+// AND, OR, NOT and parentheses; AND binds tighter than OR.
+//
+// A metric is compared by its latest value, the one with the highest x. A
+// comparison of a parameter, metric or tag only matches runs that logged it,
+// so `NOT params.lr > 0.1` also matches runs without a learning rate.
+
+// RunQuery is a parsed run query. Op is AND, OR or NOT, combining Operands,
+// or the operator of a comparison of Field, e.g. "params", and Key, e.g.
+// "lr", with Value: a string, float64 or bool.
+type RunQuery struct {
+	Op       string
+	Operands []RunQuery
+	Field    string
+	Key      string
+	Value    interface{}
+}
+
+// runQueryOperators are the comparison operators, with their SQL
+var runQueryOperators = map[string]string{
+	"=":  "=",
+	"!=": "<>",
+	"<":  "<",
+	"<=": "<=",
+	">":  ">",
+	">=": ">=",
+	"~":  "LIKE",
+}
+
+// runQueryColumns are the fields of the run itself, with their columns in
+// run lists
+var runQueryColumns = map[string]string{
+	"name":       "r.name",
+	"status":     "r.status",
+	"experiment": "e.name",
+}
+
+// runQueryToken is a token of a run query: an operator or parenthesis, a
+// quoted string, or a word, which may be a keyword, field, number or
+// unquoted text
+type runQueryToken struct {
+	text   string
+	quoted bool
+	pos    int
+}
+
+// runQueryError is a syntax error in a run query
+type runQueryError struct {
+	pos     int
+	message string
+}
+
+func (e *runQueryError) Error() string {
+	return fmt.Sprintf("%s at position %d", e.message, e.pos+1)
+}
+
+// isRunQueryWordChar reports whether c can be part of a word. Parameter and
+// metric keys are often paths, e.g. metrics.train/loss.
+func isRunQueryWordChar(c rune) bool {
+	return unicode.IsLetter(c) || unicode.IsDigit(c) || strings.ContainsRune("_.-/:+", c)
+}
+
+func tokenizeRunQuery(s string) ([]runQueryToken, error) {
+	var tokens []runQueryToken
+	runes := []rune(s)
+	for i := 0; i < len(runes); {
+		c := runes[i]
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '(' || c == ')' || c == '=' || c == '~':
+			tokens = append(tokens, runQueryToken{text: string(c), pos: i})
+			i++
+		case c == '!' || c == '<' || c == '>':
+			if i+1 < len(runes) && runes[i+1] == '=' {
+				tokens = append(tokens, runQueryToken{text: string(runes[i : i+2]), pos: i})
+				i += 2
+			} else if c == '!' {
+				return nil, &runQueryError{i, `expected "!="`}
+			} else {
+				tokens = append(tokens, runQueryToken{text: string(c), pos: i})
+				i++
+			}
+		case c == '"':
+			var text strings.Builder
+			start := i
+			for i++; i < len(runes) && runes[i] != '"'; i++ {
+				if runes[i] == '\\' && i+1 < len(runes) {
+					i++
+				}
+				text.WriteRune(runes[i])
+			}
+			if i == len(runes) {
+				return nil, &runQueryError{start, "unterminated string"}
+			}
+			tokens = append(tokens, runQueryToken{text: text.String(), quoted: true, pos: start})
+			i++
+		case isRunQueryWordChar(c):
+			start := i
+			for i < len(runes) && isRunQueryWordChar(runes[i]) {
+				i++
+			}
+			tokens = append(tokens, runQueryToken{text: string(runes[start:i]), pos: start})
+		default:
+			return nil, &runQueryError{i, fmt.Sprintf("unexpected %q", c)}
+		}
+	}
+	return tokens, nil
+}
+
+// runQueryParser parses run queries by recursive descent
+type runQueryParser struct {
+	tokens []runQueryToken
+	pos    int
+	end    int
+}
+
+// parseRunQuery parses a run query. The empty query is an error; callers
+// treat it as no query.
+func parseRunQuery(s string) (*RunQuery, error) {
+	tokens, err := tokenizeRunQuery(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &runQueryParser{tokens: tokens, end: len([]rune(s))}
+	q, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t, ok := p.peek(); ok {
+		return nil, &runQueryError{t.pos, fmt.Sprintf("unexpected %q", t.text)}
+	}
+	return &q, nil
+}
+
+func (p *runQueryParser) peek() (runQueryToken, bool) {
+	if p.pos == len(p.tokens) {
+		return runQueryToken{pos: p.end}, false
+	}
+	return p.tokens[p.pos], true
+}
+
+// keyword consumes the next token if it is the keyword kw, in any case
+func (p *runQueryParser) keyword(kw string) bool {
+	if t, ok := p.peek(); ok && !t.quoted && strings.EqualFold(t.text, kw) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *runQueryParser) parseOr() (RunQuery, error) {
+	return p.parseJunction("OR", p.parseAnd)
+}
+
+func (p *runQueryParser) parseAnd() (RunQuery, error) {
+	return p.parseJunction("AND", p.parseNot)
+}
+
+// parseJunction parses operands joined by op
+func (p *runQueryParser) parseJunction(op string, operand func() (RunQuery, error)) (RunQuery, error) {
+	q, err := operand()
+	if err != nil {
+		return q, err
+	}
+	if t, _ := p.peek(); !strings.EqualFold(t.text, op) || t.quoted {
+		return q, nil
+	}
+	operands := []RunQuery{q}
+	for p.keyword(op) {
+		q, err := operand()
+		if err != nil {
+			return q, err
+		}
+		operands = append(operands, q)
+	}
+	return RunQuery{Op: op, Operands: operands}, nil
+}
+
+func (p *runQueryParser) parseNot() (RunQuery, error) {
+	if p.keyword("NOT") {
+		q, err := p.parseNot()
+		if err != nil {
+			return q, err
+		}
+		return RunQuery{Op: "NOT", Operands: []RunQuery{q}}, nil
+	}
+	if t, ok := p.peek(); ok && t.text == "(" && !t.quoted {
+		p.pos++
+		q, err := p.parseOr()
+		if err != nil {
+			return q, err
+		}
+		if t, ok := p.peek(); !ok || t.text != ")" || t.quoted {
+			return q, &runQueryError{t.pos, `expected ")"`}
+		}
+		p.pos++
+		return q, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *runQueryParser) parseComparison() (RunQuery, error) {
+	var q RunQuery
+	t, ok := p.peek()
+	if !ok {
+		return q, &runQueryError{t.pos, "expected a comparison"}
+	}
+	if t.quoted {
+		return q, &runQueryError{t.pos, "expected a field, e.g. name or params.lr"}
+	}
+	if _, ok := runQueryColumns[t.text]; ok {
+		q.Field = t.text
+	} else if field, key, ok := strings.Cut(t.text, "."); ok && key != "" && (field == "params" || field == "metrics" || field == "tags") {
+		q.Field, q.Key = field, key
+	} else {
+		return q, &runQueryError{t.pos, fmt.Sprintf("unknown field %q; fields are name, status, experiment, params.KEY, metrics.KEY and tags.KEY", t.text)}
+	}
+	p.pos++
+
+	op, ok := p.peek()
+	if _, isOp := runQueryOperators[op.text]; !ok || !isOp || op.quoted {
+		return q, &runQueryError{op.pos, fmt.Sprintf("expected an operator after %s", t.text)}
+	}
+	q.Op = op.text
+	p.pos++
+
+	v, ok := p.peek()
+	if !ok || (!v.quoted && !isRunQueryWordChar([]rune(v.text)[0])) {
+		return q, &runQueryError{v.pos, fmt.Sprintf("expected a value after %s", op.text)}
+	}
+	p.pos++
+
+	// Text fields compare with text, metrics with numbers, and parameters
+	// with whatever the value looks like
+	number, numberErr := strconv.ParseFloat(v.text, 64)
+	isNumber := !v.quoted && numberErr == nil
+	ordered := q.Op != "=" && q.Op != "!=" && q.Op != "~"
+	switch {
+	case q.Field == "metrics":
+		if !isNumber || q.Op == "~" {
+			return q, &runQueryError{v.pos, "metrics compare with numbers"}
+		}
+		q.Value = number
+	case q.Field == "params" && isNumber && q.Op != "~":
+		q.Value = number
+	case q.Field == "params" && !v.quoted && (v.text == "true" || v.text == "false"):
+		if ordered || q.Op == "~" {
+			return q, &runQueryError{op.pos, "true and false compare with = and !="}
+		}
+		q.Value = v.text == "true"
+	default:
+		if ordered {
+			return q, &runQueryError{op.pos, fmt.Sprintf("text compares with =, != and ~, not %s", q.Op)}
+		}
+		q.Value = v.text
+	}
+	return q, nil
+}
+
+// likeContains is a LIKE pattern matching text that contains s, ignoring case
+func likeContains(s string) string {
+	escaped := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(strings.ToLower(s))
+	return "%" + escaped + "%"
+}
+
+// sql compiles the query to a condition over runs r joined to experiments e,
+// with arg adding a query argument and returning its placeholder
+func (q RunQuery) sql(arg func(interface{}) string) string {
+	switch q.Op {
+	case "AND", "OR":
+		conditions := make([]string, len(q.Operands))
+		for i, operand := range q.Operands {
+			conditions[i] = operand.sql(arg)
+		}
+		return "(" + strings.Join(conditions, " "+q.Op+" ") + ")"
+	case "NOT":
+		return "NOT " + q.Operands[0].sql(arg)
+	}
+
+	compare := func(column string) string {
+		if q.Op == "~" {
+			return "LOWER(" + column + `) LIKE ` + arg(likeContains(q.Value.(string))) + ` ESCAPE '\'`
+		}
+		return column + " " + runQueryOperators[q.Op] + " " + arg(q.Value)
+	}
+	switch q.Field {
+	case "params":
+		column := "p.value_string"
+		switch q.Value.(type) {
+		case float64:
+			column = "COALESCE(p.value_float, p.value_int)"
+		case bool:
+			column = "p.value_bool"
+		}
+		return "EXISTS (SELECT 1 FROM parameters p WHERE p.run_id = r.id AND p.key = " + arg(q.Key) + " AND " + compare(column) + ")"
+	case "metrics":
+		return "EXISTS (SELECT 1 FROM metrics m WHERE m.run_id = r.id AND m.key = " + arg(q.Key) +
+			" AND m.x_value = (SELECT MAX(latest.x_value) FROM metrics latest WHERE latest.run_id = r.id AND latest.key = m.key)" +
+			" AND " + compare("m.y_value") + ")"
+	case "tags":
+		return "EXISTS (SELECT 1 FROM tags t WHERE t.run_id = r.id AND t.key = " + arg(q.Key) + " AND " + compare("t.value") + ")"
+	}
+	return "(" + compare(runQueryColumns[q.Field]) + ")"
+}
+
+// runQuerySearchLimit is how many runs a query returns by default
+const runQuerySearchLimit = 50
+
+// runQuerySearchMaxLimit is the most runs a query returns at once
+const runQuerySearchMaxLimit = 1000
+
+// RunSearchResultDocument is a run matching a GET /api/runs/search query
+type RunSearchResultDocument struct {
+	UUID           string `json:"uuid"`
+	Name           string `json:"name"`
+	Status         string `json:"status"`
+	CreatedAt      string `json:"created_at"`
+	ExperimentUUID string `json:"experiment_uuid"`
+	ExperimentName string `json:"experiment_name"`
+	Archived       bool   `json:"archived"`
+	Reproducible   bool   `json:"reproducible"`
+}
+
+// handleAPIRunQuerySearch lists the runs matching a run query, newest first,
+// at GET /api/runs/search?q=QUERY&limit=N&offset=N. Runs may also be limited
+// to those created_within a duration, e.g. 24h or 7d, or created_after or
+// created_before a time or date; created_before is exclusive. Archived runs
+// are left out unless include_archived=true, and project limits the runs to
+// one project's. user and commit limit them to those created by a user and
+// from a git commit, which may be abbreviated, and reproducible=true to
+// reproducible runs.
+func handleAPIRunQuerySearch(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	query := r.URL.Query()
+	projectID, err := apiProjectID(r)
+	if writeProjectError(w, err) {
+		return
+	}
+	filter := RunFilter{HideArchived: query.Get("include_archived") != "true", ProjectID: projectID, User: strings.TrimSpace(query.Get("user")), Reproducible: query.Get("reproducible") == "true"}
+	if s := query.Get("commit"); s != "" {
+		commit, ok := parseGitCommit(s)
+		if !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "commit must be a hex commit hash"})
+			return
+		}
+		filter.GitCommit = commit
+	}
+	if s := strings.TrimSpace(query.Get("q")); s != "" {
+		q, err := parseRunQuery(s)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Invalid query: " + err.Error()})
+			return
+		}
+		filter.Query = q
+	}
+	if s := query.Get("created_within"); s != "" {
+		within, err := parseCreatedWithin(s)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "created_within must be a duration such as 24h or a number of days such as 7d"})
+			return
+		}
+		filter.CreatedAfter = time.Now().Add(-within)
+	}
+	for name, bound := range map[string]*time.Time{"created_after": &filter.CreatedAfter, "created_before": &filter.CreatedBefore} {
+		s := query.Get(name)
+		if s == "" {
+			continue
+		}
+		t, err := parseCreatedTime(s)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": name + " must be an RFC 3339 time or a date"})
+			return
+		}
+		if bound.IsZero() || t.After(*bound) {
+			*bound = t
+		}
+	}
+	limit, offset := runQuerySearchLimit, 0
+	if s := query.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > runQuerySearchMaxLimit {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": fmt.Sprintf("limit must be an integer from 1 to %d", runQuerySearchMaxLimit)})
+			return
+		}
+		limit = n
+	}
+	if s := query.Get("offset"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "offset must be a non-negative integer"})
+			return
+		}
+		offset = n
+	}
+
+	runs, err := dao.GetRuns(r.Context(), offset, limit+1, defaultRunSort, defaultRunSortDir, filter)
+	if err != nil {
+		log.Printf("Failed to search runs for %q: %v", query.Get("q"), err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to search runs"})
+		return
+	}
+
+	resp := []RunSearchResultDocument{}
+	for i, run := range runs {
+		if i == limit {
+			break
+		}
+		resp = append(resp, RunSearchResultDocument{
+			UUID:           run.UUID,
+			Name:           run.Name,
+			Status:         run.Status,
+			CreatedAt:      run.CreatedAt,
+			ExperimentUUID: run.ExperimentUUID,
+			ExperimentName: run.ExperimentName,
+			Archived:       run.Archived,
+			Reproducible:   run.Reproducible,
+		})
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"runs": resp, "has_more": len(runs) > limit})
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRunQuery(t *testing.T) {
+	for _, tt := range []struct {
+		query string
+		want  RunQuery
+	}{
+		{`params.lr > 0.001`, RunQuery{Op: ">", Field: "params", Key: "lr", Value: 0.001}},
+		{`name ~ "res net"`, RunQuery{Op: "~", Field: "name", Value: "res net"}},
+		{`metrics.train/loss <= 1e-3`, RunQuery{Op: "<=", Field: "metrics", Key: "train/loss", Value: 0.001}},
+		{`params.use_gpu != true`, RunQuery{Op: "!=", Field: "params", Key: "use_gpu", Value: true}},
+		{`params.lr = "0.1"`, RunQuery{Op: "=", Field: "params", Key: "lr", Value: "0.1"}},
+		{`tags.seed = 42`, RunQuery{Op: "=", Field: "tags", Key: "seed", Value: "42"}},
+		{
+			`status = failed or name ~ a AND NOT (tags.team = vision)`,
+			RunQuery{Op: "OR", Operands: []RunQuery{
+				{Op: "=", Field: "status", Value: "failed"},
+				{Op: "AND", Operands: []RunQuery{
+					{Op: "~", Field: "name", Value: "a"},
+					{Op: "NOT", Operands: []RunQuery{{Op: "=", Field: "tags", Key: "team", Value: "vision"}}},
+				}},
+			}},
+		},
+	} {
+		got, err := parseRunQuery(tt.query)
+		if err != nil {
+			t.Errorf("parseRunQuery(%q) failed: %v", tt.query, err)
+			continue
+		}
+		if !reflect.DeepEqual(*got, tt.want) {
+			t.Errorf("parseRunQuery(%q) = %+v, want %+v", tt.query, *got, tt.want)
+		}
+	}
+}
+
+func TestParseRunQueryErrors(t *testing.T) {
+	for query, want := range map[string]string{
+		``:                          "expected a comparison at position 1",
+		`lr > 1`:                    `unknown field "lr"`,
+		`params. > 1`:               `unknown field "params."`,
+		`name resnet`:               "expected an operator after name at position 6",
+		`name =`:                    "expected a value after = at position 7",
+		`name = "resnet`:            "unterminated string at position 8",
+		`name < b`:                  "text compares with =, != and ~, not <",
+		`metrics.loss < low`:        "metrics compare with numbers",
+		`params.fp16 > true`:        "true and false compare with = and !=",
+		`(name = a`:                 `expected ")" at position 10`,
+		`name = a b`:                `unexpected "b" at position 10`,
+		`name ! a`:                  `expected "!=" at position 6`,
+		`name = a AND`:              "expected a comparison at position 13",
+		`params.lr > 1 ; drop runs`: `unexpected ';'`,
+	} {
+		_, err := parseRunQuery(query)
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("parseRunQuery(%q) = %v, want an error containing %q", query, err, want)
+		}
+	}
+}
+
+func TestRunQuerySQL(t *testing.T) {
+	q, err := parseRunQuery(`params.lr > 0.001 AND NOT name ~ "50%"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var args []interface{}
+	sql := q.sql(func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	})
+	want := `(EXISTS (SELECT 1 FROM parameters p WHERE p.run_id = r.id AND p.key = $1 AND COALESCE(p.value_float, p.value_int) > $2) AND NOT (LOWER(r.name) LIKE $3 ESCAPE '\'))`
+	if sql != want {
+		t.Errorf("Unexpected SQL\n got %s\nwant %s", sql, want)
+	}
+	if !reflect.DeepEqual(args, []interface{}{"lr", 0.001, `%50\%%`}) {
+		t.Errorf("Unexpected arguments %v", args)
+	}
+}
+
+// runQueryDAO records the filter runs are listed by
+type runQueryDAO struct {
+	DAO
+	filter RunFilter
+}
+
+func (d *runQueryDAO) GetRuns(ctx context.Context, offset, limit int, sortBy, sortDir string, filter RunFilter) ([]RunSummary, error) {
+	d.filter = filter
+	return []RunSummary{{UUID: "run-1", Name: "resnet"}, {UUID: "run-2", Name: "resnet-2"}}, nil
+}
+
+func TestHandleAPIRunQuerySearch(t *testing.T) {
+	defer func(d DAO) { dao = d }(dao)
+	fake := &runQueryDAO{}
+	dao = fake
+
+	w := httptest.NewRecorder()
+	handleAPIRunQuerySearch(w, httptest.NewRequest(http.MethodGet, `/api/v1/runs/search?limit=1&q=name+~+resnet`, nil))
+	var resp struct {
+		Runs []struct {
+			UUID string `json:"uuid"`
+		} `json:"runs"`
+		HasMore bool `json:"has_more"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Runs) != 1 || resp.Runs[0].UUID != "run-1" || !resp.HasMore {
+		t.Errorf("Expected the first run and more to come, got %+v", resp)
+	}
+	if fake.filter.Query == nil || fake.filter.Query.Field != "name" {
+		t.Errorf("Expected runs to be filtered by the query, got %+v", fake.filter)
+	}
+
+	// Creation ranges narrow the listing, the latest start winning
+	w = httptest.NewRecorder()
+	handleAPIRunQuerySearch(w, httptest.NewRequest(http.MethodGet, `/api/v1/runs/search?created_within=24h&created_after=2000-01-01&created_before=2100-01-01T00:00:00Z`, nil))
+	if w.Code != http.StatusOK || fake.filter.Query != nil {
+		t.Fatalf("Expected runs listed without a query, got %d %s", w.Code, w.Body)
+	}
+	if since := time.Since(fake.filter.CreatedAfter); since < 23*time.Hour || since > 25*time.Hour {
+		t.Errorf("Expected runs created in the last 24 hours, got %v", fake.filter.CreatedAfter)
+	}
+	if !fake.filter.CreatedBefore.Equal(time.Date(2100, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Unexpected end of the creation range %v", fake.filter.CreatedBefore)
+	}
+	for _, bad := range []string{"created_within=soon", "created_after=last+tuesday"} {
+		w = httptest.NewRecorder()
+		handleAPIRunQuerySearch(w, httptest.NewRequest(http.MethodGet, "/api/v1/runs/search?"+bad, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for %s, got %d", bad, w.Code)
+		}
+	}
+
+	w = httptest.NewRecorder()
+	handleAPIRunQuerySearch(w, httptest.NewRequest(http.MethodGet, `/api/v1/runs/search?q=loss+<+1`, nil))
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), `unknown field \"loss\"`) {
+		t.Errorf("Expected a 400 explaining the query error, got %d %s", w.Code, w.Body)
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A run records the random seeds it was started with: a global seed, and the
+// seeds of libraries seeded separately, e.g. numpy or torch. A run that was
+// seeded, created from a clean git commit, and logged its environment is
+// reproducible, which the web UI shows with a badge and run lists can be
+// filtered by.
+
+// Limits on the library seeds a run records
+const (
+	runSeedsMaxLibraries    = 64
+	runSeedLibraryMaxLength = 64
+)
+
+// runReproducibleCondition is the SQL condition over runs r that a run is
+// reproducible: it recorded a seed, was created from a git commit whose
+// working tree had no uncommitted changes, and logged its environment. It
+// must agree with runReproducible.
+const runReproducibleCondition = `((r.seed IS NOT NULL OR EXISTS (SELECT 1 FROM run_seeds s WHERE s.run_id = r.id))` +
+	` AND r.git_commit IS NOT NULL AND r.git_dirty IS NOT TRUE` +
+	` AND EXISTS (SELECT 1 FROM run_environment v WHERE v.run_id = r.id))`
+
+// runReproducible reports whether a run is reproducible, as
+// runReproducibleCondition decides for run lists
+func runReproducible(seeds *RunSeeds, source *RunSource, environment []EnvironmentVariableRow) bool {
+	return seeds != nil && source != nil && source.GitCommit != "" && !source.Dirty() && len(environment) > 0
+}
+
+// parseSeed normalizes a seed, which must be a non-negative integer of at most
+// 64 bits
+func parseSeed(n json.Number) (string, error) {
+	seed, err := strconv.ParseUint(strings.TrimSpace(n.String()), 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("seeds must be non-negative integers of at most 64 bits, got %s", n)
+	}
+	return strconv.FormatUint(seed, 10), nil
+}
+
+// RunSeedsRequest is the random seeds a run was started with, as given when
+// creating it or logging them. Seed is the global seed, and Seeds the seeds
+// of libraries by name. Both are optional.
+type RunSeedsRequest struct {
+	Seed  json.Number            `json:"seed"`
+	Seeds map[string]json.Number `json:"seeds"`
+}
+
+// row validates a run's seeds for storing
+func (req RunSeedsRequest) row() (RunSeedsRow, error) {
+	var seeds RunSeedsRow
+	if req.Seed != "" {
+		seed, err := parseSeed(req.Seed)
+		if err != nil {
+			return RunSeedsRow{}, err
+		}
+		seeds.Seed = seed
+	}
+	if len(req.Seeds) > runSeedsMaxLibraries {
+		return RunSeedsRow{}, fmt.Errorf("%d library seeds were given, at most %d are supported", len(req.Seeds), runSeedsMaxLibraries)
+	}
+	for library, n := range req.Seeds {
+		library = strings.TrimSpace(library)
+		if library == "" || len(library) > runSeedLibraryMaxLength {
+			return RunSeedsRow{}, fmt.Errorf("library names must be 1 to %d characters, got %q", runSeedLibraryMaxLength, library)
+		}
+		seed, err := parseSeed(n)
+		if err != nil {
+			return RunSeedsRow{}, fmt.Errorf("library %s: %v", library, err)
+		}
+		seeds.Libraries = append(seeds.Libraries, LibrarySeedRow{Library: library, Seed: seed})
+	}
+	sort.Slice(seeds.Libraries, func(i, j int) bool { return seeds.Libraries[i].Library < seeds.Libraries[j].Library })
+	return seeds, nil
+}
+
+// isEmpty reports whether no seeds were given
+func (seeds RunSeedsRow) isEmpty() bool {
+	return seeds.Seed == "" && len(seeds.Libraries) == 0
+}
+
+// RunSeeds is the random seeds a run was started with in display form.
+// Seeds are strings, as 64-bit seeds do not fit in a JavaScript number.
+type RunSeeds struct {
+	Global    string            `json:"global,omitempty"`
+	Libraries map[string]string `json:"libraries,omitempty"`
+}
+
+// getRunSeeds loads the random seeds a run was started with in display form,
+// or nil if it reported none
+func getRunSeeds(ctx context.Context, runID int) (*RunSeeds, error) {
+	row, err := dao.GetRunSeeds(ctx, runID)
+	if err != nil || row == nil {
+		return nil, err
+	}
+	seeds := &RunSeeds{Global: row.Seed}
+	if len(row.Libraries) > 0 {
+		seeds.Libraries = make(map[string]string, len(row.Libraries))
+		for _, l := range row.Libraries {
+			seeds.Libraries[l.Library] = l.Seed
+		}
+	}
+	return seeds, nil
+}
+
+// LogSeedsRequest is the body of POST /api/runs/seeds
+type LogSeedsRequest struct {
+	RunUUID string `json:"run_uuid"`
+	RunSeedsRequest
+}
+
+// handleAPILogSeeds replaces the random seeds a run was started with, at
+// POST /api/runs/seeds with {"run_uuid", "seed", "seeds"}, where seed is the
+// global seed and seeds an object of library names to their seeds
+func handleAPILogSeeds(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+
+	var req LogSeedsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid JSON"})
+		return
+	}
+	var missing []string
+	if req.RunUUID == "" {
+		missing = append(missing, "run_uuid")
+	}
+	if req.Seed == "" && req.Seeds == nil {
+		missing = append(missing, "seed")
+	}
+	if len(missing) > 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":          "Missing required fields",
+			"missing_fields": missing,
+		})
+		return
+	}
+
+	seeds, err := req.RunSeedsRequest.row()
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": fmt.Sprintf("Invalid seeds: %v", err)})
+		return
+	}
+
+	runID, err := dao.GetRunIDByUUID(ctx, req.RunUUID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Run not found"})
+		return
+	}
+
+	// The seeds of a run on hold may be logged but not replaced
+	existing, err := dao.GetRunSeeds(ctx, runID)
+	if err != nil {
+		log.Printf("Failed to query seeds for run %s: %v", req.RunUUID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to save seeds"})
+		return
+	}
+	if existing != nil {
+		if err := ensureRunNotOnHold(ctx, runID); err != nil {
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(map[string]string{"error": fmt.Sprintf("Cannot replace seeds: %v", err)})
+			return
+		}
+	}
+
+	if err := dao.SetRunSeeds(ctx, runID, seeds); err != nil {
+		log.Printf("Error saving seeds: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to save seeds"})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// runSeedsDAO creates runs in quotaDAO's experiment and records their seeds,
+// with the run "held" on hold
+type runSeedsDAO struct {
+	quotaDAO
+	seeds map[int]RunSeedsRow
+}
+
+func (d *runSeedsDAO) GetRunIDByUUID(ctx context.Context, uuid string) (int, error) {
+	if uuid == "held" {
+		return 8, nil
+	}
+	return 7, nil
+}
+
+func (d *runSeedsDAO) InsertRunWithSourceAndSeeds(ctx context.Context, uuid, name string, experimentID int, parentRunID *int, source RunSourceRow, seeds RunSeedsRow) error {
+	if !seeds.isEmpty() {
+		d.seeds[7] = seeds
+	}
+	return d.quotaDAO.InsertRunWithSourceAndSeeds(ctx, uuid, name, experimentID, parentRunID, source, seeds)
+}
+
+func (d *runSeedsDAO) SetRunSeeds(ctx context.Context, runID int, seeds RunSeedsRow) error {
+	d.seeds[runID] = seeds
+	return nil
+}
+
+func (d *runSeedsDAO) GetRunSeeds(ctx context.Context, runID int) (*RunSeedsRow, error) {
+	if seeds, ok := d.seeds[runID]; ok {
+		return &seeds, nil
+	}
+	return nil, nil
+}
+
+func (d *runSeedsDAO) GetRunHold(ctx context.Context, runID int) (*RunHoldRow, error) {
+	if runID == 8 {
+		return &RunHoldRow{Reason: "paper", HeldAt: time.Now()}, nil
+	}
+	return nil, nil
+}
+
+func TestRunSeedsRequestRow(t *testing.T) {
+	seeds, err := RunSeedsRequest{Seed: "18446744073709551615", Seeds: map[string]json.Number{" torch ": "0", "numpy": "042"}}.row()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []LibrarySeedRow{{Library: "numpy", Seed: "42"}, {Library: "torch", Seed: "0"}}
+	if seeds.Seed != "18446744073709551615" || !slices.Equal(seeds.Libraries, want) {
+		t.Errorf("Expected the seeds normalized and ordered by library, got %+v", seeds)
+	}
+	if seeds, err := (RunSeedsRequest{}).row(); err != nil || !seeds.isEmpty() {
+		t.Errorf("Expected no seeds, got %+v (%v)", seeds, err)
+	}
+
+	for _, req := range []RunSeedsRequest{
+		{Seed: "-1"},
+		{Seed: "1.5"},
+		{Seed: "18446744073709551616"},
+		{Seeds: map[string]json.Number{"": "1"}},
+		{Seeds: map[string]json.Number{"numpy": "1e3"}},
+	} {
+		if _, err := req.row(); err == nil {
+			t.Errorf("Expected %+v rejected", req)
+		}
+	}
+}
+
+func TestHandleAPICreateRunSeeds(t *testing.T) {
+	defer func(d DAO) { dao = d }(dao)
+	fake := &runSeedsDAO{seeds: map[int]RunSeedsRow{}}
+	dao = fake
+
+	create := func(body string) int {
+		w := httptest.NewRecorder()
+		handleAPICreateRun(w, httptest.NewRequest(http.MethodPost, "/api/runs", strings.NewReader(body)))
+		return w.Code
+	}
+
+	if code := create(`{"name": "run"}`); code != http.StatusOK || len(fake.seeds) != 0 {
+		t.Errorf("Expected a run without seeds created without recording any, got %d and %+v", code, fake.seeds)
+	}
+	if code := create(`{"name": "run", "seed": 42, "seeds": {"torch": "7"}}`); code != http.StatusOK {
+		t.Fatalf("Expected the run created, got %d", code)
+	}
+	if got := fake.seeds[7]; got.Seed != "42" || !slices.Equal(got.Libraries, []LibrarySeedRow{{Library: "torch", Seed: "7"}}) {
+		t.Errorf("Expected the seeds recorded, got %+v", got)
+	}
+
+	fake.runs = 0
+	if code := create(`{"name": "run", "seed": -3}`); code != http.StatusBadRequest || fake.runs != 0 {
+		t.Errorf("Expected a negative seed rejected before creating the run, got %d", code)
+	}
+}
+
+func TestHandleAPILogSeeds(t *testing.T) {
+	defer func(d DAO) { dao = d }(dao)
+	fake := &runSeedsDAO{seeds: map[int]RunSeedsRow{}}
+	dao = fake
+
+	post := func(body string) int {
+		w := httptest.NewRecorder()
+		handleAPILogSeeds(w, httptest.NewRequest(http.MethodPost, "/api/runs/seeds", strings.NewReader(body)))
+		return w.Code
+	}
+
+	if code := post(`{"run_uuid": "run", "seeds": {"numpy": 1}}`); code != http.StatusOK || fake.seeds[7].Libraries[0].Seed != "1" {
+		t.Fatalf("Expected the seeds logged, got %d and %+v", code, fake.seeds)
+	}
+	if code := post(`{"run_uuid": "run"}`); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 without seeds, got %d", code)
+	}
+	if code := post(`{"run_uuid": "run", "seed": "abc"}`); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid seed, got %d", code)
+	}
+
+	// A held run's seeds may be logged once but not replaced
+	if code := post(`{"run_uuid": "held", "seed": 1}`); code != http.StatusOK {
+		t.Errorf("Expected a held run's first seeds logged, got %d", code)
+	}
+	if code := post(`{"run_uuid": "held", "seed": 2}`); code != http.StatusConflict || fake.seeds[8].Seed != "1" {
+		t.Errorf("Expected a held run's seeds kept, got %d and %+v", code, fake.seeds[8])
+	}
+}
+
+func TestRunReproducible(t *testing.T) {
+	dirty, clean := true, false
+	seeds := &RunSeeds{Global: "0"}
+	source := &RunSource{GitCommit: "0123abc", GitDirty: &clean}
+	environment := []EnvironmentVariableRow{{Key: "HOME", Value: "/root"}}
+	if !runReproducible(seeds, source, environment) {
+		t.Errorf("Expected a seeded run from a clean commit with its environment reproducible")
+	}
+	for name, reproducible := range map[string]bool{
+		"unseeded":               runReproducible(nil, source, environment),
+		"without a commit":       runReproducible(seeds, &RunSource{User: "ada"}, environment),
+		"with a dirty tree":      runReproducible(seeds, &RunSource{GitCommit: "0123abc", GitDirty: &dirty}, environment),
+		"without an environment": runReproducible(seeds, source, nil),
+	} {
+		if reproducible {
+			t.Errorf("Expected a run %s not to be reproducible", name)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// runSourceMaxLength is the longest a run's user, branch or entrypoint can be
+const runSourceMaxLength = 1024
+
+// gitCommitPattern matches a full or abbreviated git commit hash, SHA-1 or
+// SHA-256, in lowercase
+var gitCommitPattern = regexp.MustCompile(`^[0-9a-f]{4,64}$`)
+
+// parseGitCommit normalizes a git commit hash, or an abbreviation of one, to
+// lowercase, reporting whether it is one
+func parseGitCommit(s string) (string, bool) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	return s, gitCommitPattern.MatchString(s)
+}
+
+// RunSourceRequest is where a run came from, as given when creating it. All
+// fields are optional.
+type RunSourceRequest struct {
+	User       string `json:"user"`
+	GitCommit  string `json:"git_commit"`
+	GitBranch  string `json:"git_branch"`
+	GitDirty   *bool  `json:"git_dirty"`
+	Entrypoint string `json:"entrypoint"`
+}
+
+// row validates a run's source for storing
+func (req RunSourceRequest) row() (RunSourceRow, error) {
+	source := RunSourceRow{
+		User:       strings.TrimSpace(req.User),
+		GitBranch:  strings.TrimSpace(req.GitBranch),
+		Entrypoint: strings.TrimSpace(req.Entrypoint),
+	}
+	for _, field := range []struct{ name, value string }{
+		{"user", source.User}, {"git_branch", source.GitBranch}, {"entrypoint", source.Entrypoint},
+	} {
+		if len(field.value) > runSourceMaxLength || !utf8.ValidString(field.value) {
+			return RunSourceRow{}, fmt.Errorf("%s must be valid UTF-8 of at most %d bytes", field.name, runSourceMaxLength)
+		}
+	}
+	if req.GitCommit != "" {
+		commit, ok := parseGitCommit(req.GitCommit)
+		if !ok {
+			return RunSourceRow{}, fmt.Errorf("git_commit must be a hex commit hash")
+		}
+		source.GitCommit = commit
+	}
+	if req.GitDirty != nil {
+		source.GitDirty = sql.NullBool{Bool: *req.GitDirty, Valid: true}
+	}
+	return source, nil
+}
+
+// RunSource is where a run came from in display form
+type RunSource struct {
+	User       string `json:"user,omitempty"`
+	GitCommit  string `json:"git_commit,omitempty"`
+	GitBranch  string `json:"git_branch,omitempty"`
+	GitDirty   *bool  `json:"git_dirty,omitempty"`
+	Entrypoint string `json:"entrypoint,omitempty"`
+}
+
+// ShortGitCommit abbreviates the run's commit the way git log --oneline does
+func (s RunSource) ShortGitCommit() string {
+	if len(s.GitCommit) > 7 {
+		return s.GitCommit[:7]
+	}
+	return s.GitCommit
+}
+
+// Dirty reports whether the run's working tree had uncommitted changes
+func (s RunSource) Dirty() bool {
+	return s.GitDirty != nil && *s.GitDirty
+}
+
+// getRunSource loads where a run came from in display form, or nil if that
+// was not recorded
+func getRunSource(ctx context.Context, runID int) (*RunSource, error) {
+	row, err := dao.GetRunSource(ctx, runID)
+	if err != nil || row == nil {
+		return nil, err
+	}
+	source := &RunSource{User: row.User, GitCommit: row.GitCommit, GitBranch: row.GitBranch, Entrypoint: row.Entrypoint}
+	if row.GitDirty.Valid {
+		source.GitDirty = &row.GitDirty.Bool
+	}
+	return source, nil
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// runSourceDAO creates runs in quotaDAO's experiment and records their sources
+type runSourceDAO struct {
+	quotaDAO
+	sources map[int]RunSourceRow
+}
+
+func (d *runSourceDAO) GetRunIDByUUID(ctx context.Context, uuid string) (int, error) {
+	return 7, nil
+}
+
+func (d *runSourceDAO) InsertRunWithSourceAndSeeds(ctx context.Context, uuid, name string, experimentID int, parentRunID *int, source RunSourceRow, seeds RunSeedsRow) error {
+	if source != (RunSourceRow{}) {
+		d.sources[7] = source
+	}
+	return d.quotaDAO.InsertRunWithSourceAndSeeds(ctx, uuid, name, experimentID, parentRunID, source, seeds)
+}
+
+func TestParseGitCommit(t *testing.T) {
+	for s, want := range map[string]bool{
+		"0123abc":    true,
+		" 0123ABC\n": true,
+		"0123456789abcdef0123456789abcdef01234567": true,
+		"abc":      false,
+		"main":     false,
+		"0123abc%": false,
+		"":         false,
+	} {
+		commit, ok := parseGitCommit(s)
+		if ok != want {
+			t.Errorf("parseGitCommit(%q) = %v, want %v", s, ok, want)
+		}
+		if ok && commit != strings.ToLower(strings.TrimSpace(s)) {
+			t.Errorf("Expected %q normalized to lowercase, got %q", s, commit)
+		}
+	}
+}
+
+func TestHandleAPICreateRunSource(t *testing.T) {
+	defer func(d DAO) { dao = d }(dao)
+	fake := &runSourceDAO{sources: map[int]RunSourceRow{}}
+	dao = fake
+
+	create := func(body string) int {
+		w := httptest.NewRecorder()
+		handleAPICreateRun(w, httptest.NewRequest(http.MethodPost, "/api/runs", strings.NewReader(body)))
+		return w.Code
+	}
+
+	if code := create(`{"name": "run"}`); code != http.StatusOK || len(fake.sources) != 0 {
+		t.Errorf("Expected a run without a source created without recording one, got %d and %+v", code, fake.sources)
+	}
+	if code := create(`{"name": "run", "user": "ada", "git_commit": "0123ABC", "git_branch": "main", "git_dirty": true, "entrypoint": "train.py --lr 0.1"}`); code != http.StatusOK {
+		t.Fatalf("Expected the run created, got %d", code)
+	}
+	want := RunSourceRow{User: "ada", GitCommit: "0123abc", GitBranch: "main", GitDirty: sql.NullBool{Bool: true, Valid: true}, Entrypoint: "train.py --lr 0.1"}
+	if got := fake.sources[7]; got != want {
+		t.Errorf("Expected source %+v recorded, got %+v", want, got)
+	}
+
+	fake.runs = 0
+	for _, body := range []string{
+		`{"name": "run", "git_commit": "not a commit"}`,
+		`{"name": "run", "user": "` + strings.Repeat("a", runSourceMaxLength+1) + `"}`,
+	} {
+		if code := create(body); code != http.StatusBadRequest || fake.runs != 0 {
+			t.Errorf("Expected %.60s rejected before creating the run, got %d", body, code)
+		}
+	}
+}
+
+func TestRunSourceDisplay(t *testing.T) {
+	dirty := true
+	source := RunSource{GitCommit: "0123456789abcdef0123456789abcdef01234567", GitDirty: &dirty}
+	if got := source.ShortGitCommit(); got != "0123456" {
+		t.Errorf("Expected the commit abbreviated to 0123456, got %q", got)
+	}
+	if !source.Dirty() {
+		t.Errorf("Expected the run's tree dirty")
+	}
+	if (RunSource{GitCommit: "0123a"}).ShortGitCommit() != "0123a" || (RunSource{}).Dirty() {
+		t.Errorf("Expected short commits kept whole and an unreported tree clean")
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Run statuses. A run is RUNNING from creation until it reports one of the
+// terminal statuses or is marked FAILED by the stale run detector.
+const (
+	runStatusRunning  = "RUNNING"
+	runStatusFinished = "FINISHED"
+	runStatusFailed   = "FAILED"
+	runStatusKilled   = "KILLED"
+)
+
+var runTerminalStatuses = []string{runStatusFinished, runStatusFailed, runStatusKilled}
+
+// runStaleStatusMessage is the message of runs the stale run detector fails
+const runStaleStatusMessage = "Stopped logging without reporting a status"
+
+// runStatusMessageMaxLength is the longest message a run may end with. Longer
+// messages keep their end, where a traceback names the exception.
+const runStatusMessageMaxLength = 16 << 10
+
+// runHeartbeatTimeout is how long a running run may go without logging a
+// param or metric before it is marked FAILED; zero disables the detector
+var runHeartbeatTimeout = 30 * time.Minute
+
+// validateRunStatusTransition checks that a run in status from may report the
+// terminal status to. A FAILED run may still report another terminal status,
+// since the stale run detector fails runs that merely went quiet.
+func validateRunStatusTransition(from, to string) error {
+	if from == to || from == runStatusRunning || from == runStatusFailed {
+		return nil
+	}
+	return fmt.Errorf("run is already %s", from)
+}
+
+// runStatusNotificationEvent is the notification event for a run reaching a
+// terminal status
+func runStatusNotificationEvent(status string) string {
+	switch status {
+	case runStatusFinished:
+		return notificationEventRunFinished
+	case runStatusKilled:
+		return notificationEventRunKilled
+	}
+	return notificationEventRunFailed
+}
+
+// recordRunActivity notes that a run logged something, keeping it from being
+// marked stale. Failures are logged rather than failing the request.
+func recordRunActivity(ctx context.Context, runID int) {
+	if err := dao.RecordRunActivity(ctx, runID, time.Now().UTC()); err != nil {
+		log.Printf("Failed to record activity for run %d: %v", runID, err)
+	}
+}
+
+// failStaleRuns marks FAILED the running runs that have logged nothing within
+// the heartbeat timeout, and notifies subscribers
+func failStaleRuns(ctx context.Context, now time.Time) {
+	uuids, err := dao.FailStaleRuns(ctx, now.Add(-runHeartbeatTimeout).UTC())
+	if err != nil {
+		log.Printf("Failed to mark stale runs as failed: %v", err)
+		return
+	}
+	for _, uuid := range uuids {
+		log.Printf("Marked run %s FAILED after no activity for %s", uuid, runHeartbeatTimeout)
+		notifyRunEvent(ctx, notificationEventRunFailed, uuid)
+	}
+}
+
+// startStaleRunDetector checks for stale runs in the background, several
+// times per heartbeat timeout
+func startStaleRunDetector(ctx context.Context) {
+	if runHeartbeatTimeout <= 0 {
+		return
+	}
+	interval := min(max(runHeartbeatTimeout/4, time.Second), time.Minute)
+	go func() {
+		for {
+			if holdJobLease(ctx, "stale-run-detector", 2*interval) {
+				failStaleRuns(ctx, time.Now())
+			}
+			time.Sleep(interval)
+		}
+	}()
+}
+
+// FinishRunRequest is the body of POST /api/runs/finish
+type FinishRunRequest struct {
+	RunUUID string `json:"run_uuid"`
+	Status  string `json:"status"`
+	Message string `json:"message"`
+}
+
+func handleAPIFinishRun(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req FinishRunRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid JSON"})
+		return
+	}
+	if req.RunUUID == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":          "Missing required fields",
+			"missing_fields": []string{"run_uuid"},
+		})
+		return
+	}
+	if req.Status == "" {
+		req.Status = runStatusFinished
+	}
+	req.Status = strings.ToUpper(req.Status)
+	if !slices.Contains(runTerminalStatuses, req.Status) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": fmt.Sprintf("Unknown status %q (expected one of %s)", req.Status, strings.Join(runTerminalStatuses, ", "))})
+		return
+	}
+
+	run, err := dao.GetRunByUUID(ctx, req.RunUUID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Run not found"})
+		return
+	}
+	runID, err := dao.GetRunIDByUUID(ctx, req.RunUUID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Run not found"})
+		return
+	}
+
+	if err := validateRunStatusTransition(run.Status, req.Status); err != nil {
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]string{"error": fmt.Sprintf("Cannot mark run %s: %v", req.Status, err)})
+		return
+	}
+
+	message := strings.TrimSpace(req.Message)
+	if len(message) > runStatusMessageMaxLength {
+		message = strings.ToValidUTF8(message[len(message)-runStatusMessageMaxLength:], "")
+	}
+
+	if run.Status != req.Status || run.StatusMessage != message {
+		if err := dao.UpdateRunStatus(ctx, runID, req.Status, message); err != nil {
+			log.Printf("Failed to update status of run %s: %v", req.RunUUID, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"error": "Failed to update run status"})
+			return
+		}
+	}
+	if run.Status != req.Status {
+		notifyRunEvent(ctx, runStatusNotificationEvent(req.Status), req.RunUUID)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"status":     "ok",
+		"run_status": req.Status,
+	})
+}
@@ -0,0 +1,36 @@
+package main
+
+import "testing"
+
+func TestValidateRunStatusTransition(t *testing.T) {
+	tests := []struct {
+		from, to string
+		wantErr  bool
+	}{
+		{runStatusRunning, runStatusFinished, false},
+		{runStatusRunning, runStatusKilled, false},
+		{runStatusFinished, runStatusFinished, false},
+		{runStatusFailed, runStatusFinished, false},
+		{runStatusFinished, runStatusFailed, true},
+		{runStatusKilled, runStatusFinished, true},
+	}
+	for _, tt := range tests {
+		err := validateRunStatusTransition(tt.from, tt.to)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("validateRunStatusTransition(%s, %s) error = %v, wantErr %v", tt.from, tt.to, err, tt.wantErr)
+		}
+	}
+}
+
+func TestRunStatusNotificationEvent(t *testing.T) {
+	tests := map[string]string{
+		runStatusFinished: notificationEventRunFinished,
+		runStatusFailed:   notificationEventRunFailed,
+		runStatusKilled:   notificationEventRunKilled,
+	}
+	for status, want := range tests {
+		if got := runStatusNotificationEvent(status); got != want {
+			t.Errorf("runStatusNotificationEvent(%s) = %s, want %s", status, got, want)
+		}
+	}
+}
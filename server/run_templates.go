@@ -0,0 +1,555 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// RunTemplate is a named parameter set saved from a run. New runs created from
+// it get the same parameters, except for prompted ones which can be overridden.
+type RunTemplate struct {
+	Name           string
+	ExperimentUUID string
+	ExperimentName string
+	CreatedAt      string
+	Parameters     []RunTemplateParameter
+}
+
+// RunTemplateParameter is a parameter of a run template in display form
+type RunTemplateParameter struct {
+	Key    string
+	Value  string
+	Type   string
+	Prompt bool
+}
+
+// runTemplateError is a problem with a template request that the client can
+// fix, as opposed to a server-side failure
+type runTemplateError struct {
+	status  int
+	message string
+}
+
+func (e *runTemplateError) Error() string {
+	return e.message
+}
+
+// buildRunTemplateParameters copies a run's parameters into template rows,
+// marking the keys in promptKeys to be prompted for
+func buildRunTemplateParameters(params []ParameterRow, promptKeys []string) ([]RunTemplateParameterRow, error) {
+	prompt := make(map[string]bool)
+	for _, key := range promptKeys {
+		prompt[key] = true
+	}
+
+	var rows []RunTemplateParameterRow
+	for _, p := range params {
+		rows = append(rows, RunTemplateParameterRow{ParameterRow: p, Prompt: prompt[p.Key]})
+		delete(prompt, p.Key)
+	}
+
+	for key := range prompt {
+		return nil, fmt.Errorf("run has no parameter %q to prompt for", key)
+	}
+	return rows, nil
+}
+
+// resolveTemplateParameters applies overrides to a template's parameters.
+// Overrides are given as strings and converted to the type the template
+// recorded; only prompted parameters may be overridden.
+func resolveTemplateParameters(params []RunTemplateParameterRow, overrides map[string]string) ([]ParameterRow, error) {
+	remaining := make(map[string]string)
+	for key, value := range overrides {
+		remaining[key] = value
+	}
+
+	var resolved []ParameterRow
+	for _, p := range params {
+		value, ok := remaining[p.Key]
+		if !ok {
+			resolved = append(resolved, p.ParameterRow)
+			continue
+		}
+		delete(remaining, p.Key)
+
+		if !p.Prompt {
+			return nil, fmt.Errorf("parameter %q is not overridable in this template", p.Key)
+		}
+		override := ParameterRow{Key: p.Key, ValueType: "string", ValueString: sql.NullString{String: value, Valid: true}}
+		converted, err := convertParameterValue(override, p.ValueType)
+		if err != nil {
+			return nil, fmt.Errorf("parameter %q: %w", p.Key, err)
+		}
+		resolved = append(resolved, converted)
+	}
+
+	for key := range remaining {
+		return nil, fmt.Errorf("template has no parameter %q", key)
+	}
+	return resolved, nil
+}
+
+// saveRunTemplate saves the parameters of a run as a new named template
+func saveRunTemplate(ctx context.Context, name, runUUID string, promptKeys []string) error {
+	if strings.Contains(name, "/") {
+		return &runTemplateError{http.StatusBadRequest, "Template name must not contain '/'"}
+	}
+
+	runID, err := dao.GetRunIDByUUID(ctx, runUUID)
+	if err != nil {
+		return &runTemplateError{http.StatusNotFound, "Run not found"}
+	}
+
+	if _, err := dao.GetRunTemplateByName(ctx, name); err == nil {
+		return &runTemplateError{http.StatusConflict, fmt.Sprintf("A template named %q already exists", name)}
+	}
+
+	paramRows, err := dao.GetParametersByRunID(ctx, runID)
+	if err != nil {
+		return err
+	}
+	templateParams, err := buildRunTemplateParameters(paramRows, promptKeys)
+	if err != nil {
+		return &runTemplateError{http.StatusBadRequest, err.Error()}
+	}
+
+	experiment, err := dao.GetExperimentForRunUUID(ctx, runUUID)
+	if err != nil {
+		return err
+	}
+	experimentID, err := dao.GetExperimentIDByUUID(ctx, experiment.UUID)
+	if err != nil {
+		return err
+	}
+
+	return dao.InsertRunTemplate(ctx, name, experimentID, templateParams)
+}
+
+// createRunFromTemplate creates a run in the template's experiment with the
+// template's parameters and the given overrides, returning the new run's UUID
+func createRunFromTemplate(ctx context.Context, templateName, runName string, overrides map[string]string) (string, error) {
+	t, err := dao.GetRunTemplateByName(ctx, templateName)
+	if err != nil {
+		return "", &runTemplateError{http.StatusNotFound, "Template not found"}
+	}
+
+	templateParams, err := dao.GetRunTemplateParameters(ctx, t.ID)
+	if err != nil {
+		return "", err
+	}
+	params, err := resolveTemplateParameters(templateParams, overrides)
+	if err != nil {
+		return "", &runTemplateError{http.StatusBadRequest, err.Error()}
+	}
+
+	if runName == "" {
+		runName = t.Name
+	}
+	runUUID := newUUID(ctx)
+	if err := dao.InsertRun(ctx, runUUID, runName, t.ExperimentID, nil); err != nil {
+		return "", err
+	}
+	runID, err := dao.GetRunIDByUUID(ctx, runUUID)
+	if err != nil {
+		return "", err
+	}
+
+	for _, p := range params {
+		valueString, valueBool, valueFloat, valueInt := parameterValuePointers(p)
+		err := dao.UpsertParameter(ctx, runID, p.Key, p.ValueType, valueString, valueBool, valueFloat, valueInt)
+		if err != nil {
+			return "", err
+		}
+	}
+	notifyRunEvent(ctx, notificationEventRunCreated, runUUID)
+	return runUUID, nil
+}
+
+// getRunTemplate loads a run template with its parameters in display form
+func getRunTemplate(ctx context.Context, t RunTemplateRow) (*RunTemplate, error) {
+	paramRows, err := dao.GetRunTemplateParameters(ctx, t.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	runTemplate := &RunTemplate{
+		Name:      t.Name,
+		CreatedAt: t.CreatedAt.Format(displayTimeLayout),
+	}
+	if experiment, err := dao.GetExperimentByID(ctx, t.ExperimentID); err == nil {
+		runTemplate.ExperimentUUID = experiment.UUID
+		runTemplate.ExperimentName = experiment.Name
+	}
+	for _, p := range paramRows {
+		runTemplate.Parameters = append(runTemplate.Parameters, RunTemplateParameter{
+			Key:    p.Key,
+			Value:  formatParameterValue(p.ParameterRow),
+			Type:   p.ValueType,
+			Prompt: p.Prompt,
+		})
+	}
+	return runTemplate, nil
+}
+
+// writeRunTemplateError writes a JSON error response for err
+func writeRunTemplateError(w http.ResponseWriter, err error) {
+	var templateErr *runTemplateError
+	if errors.As(err, &templateErr) {
+		w.WriteHeader(templateErr.status)
+		json.NewEncoder(w).Encode(map[string]string{"error": templateErr.message})
+		return
+	}
+	log.Printf("Run template request failed: %v", err)
+	w.WriteHeader(http.StatusInternalServerError)
+	json.NewEncoder(w).Encode(map[string]string{"error": "Internal server error"})
+}
+
+func handleAPIRunTemplates(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		handleAPIListRunTemplates(w, r)
+	case http.MethodPost:
+		handleAPISaveRunTemplate(w, r)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// RunTemplateParameterDocument is a parameter of a run template as the API
+// returns it
+type RunTemplateParameterDocument struct {
+	Key    string `json:"key"`
+	Value  string `json:"value"`
+	Type   string `json:"type"`
+	Prompt bool   `json:"prompt"`
+}
+
+// RunTemplateDocument is a run template as the API returns it
+type RunTemplateDocument struct {
+	Name           string                         `json:"name"`
+	ExperimentUUID string                         `json:"experiment_uuid"`
+	CreatedAt      string                         `json:"created_at"`
+	Parameters     []RunTemplateParameterDocument `json:"parameters"`
+}
+
+func handleAPIListRunTemplates(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	rows, err := dao.GetAllRunTemplates(ctx)
+	if err != nil {
+		writeRunTemplateError(w, err)
+		return
+	}
+
+	resp := []RunTemplateDocument{}
+	for _, row := range rows {
+		if ok, err := requestCanAccessExperiment(r, row.ExperimentID); err != nil || !ok {
+			if err != nil {
+				writeRunTemplateError(w, err)
+				return
+			}
+			continue
+		}
+		t, err := getRunTemplate(ctx, row)
+		if err != nil {
+			writeRunTemplateError(w, err)
+			return
+		}
+		entry := RunTemplateDocument{Name: t.Name, ExperimentUUID: t.ExperimentUUID, CreatedAt: t.CreatedAt, Parameters: []RunTemplateParameterDocument{}}
+		for _, p := range t.Parameters {
+			entry.Parameters = append(entry.Parameters, RunTemplateParameterDocument{Key: p.Key, Value: p.Value, Type: p.Type, Prompt: p.Prompt})
+		}
+		resp = append(resp, entry)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"templates": resp})
+}
+
+// SaveRunTemplateRequest is the body of POST /api/templates
+type SaveRunTemplateRequest struct {
+	Name       string   `json:"name"`
+	RunUUID    string   `json:"run_uuid"`
+	PromptKeys []string `json:"prompt_keys"`
+}
+
+func handleAPISaveRunTemplate(w http.ResponseWriter, r *http.Request) {
+	var req SaveRunTemplateRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid JSON"})
+		return
+	}
+
+	var missing []string
+	if req.Name == "" {
+		missing = append(missing, "name")
+	}
+	if req.RunUUID == "" {
+		missing = append(missing, "run_uuid")
+	}
+
+	if len(missing) > 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":          "Missing required fields",
+			"missing_fields": missing,
+		})
+		return
+	}
+
+	if err := saveRunTemplate(r.Context(), req.Name, req.RunUUID, req.PromptKeys); err != nil {
+		writeRunTemplateError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+
+// CreateRunFromTemplateRequest is the body of POST /api/templates/runs
+type CreateRunFromTemplateRequest struct {
+	Template  string                 `json:"template"`
+	Name      string                 `json:"name"`
+	Overrides map[string]interface{} `json:"overrides"`
+}
+
+func handleAPICreateRunFromTemplate(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req CreateRunFromTemplateRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid JSON"})
+		return
+	}
+
+	if req.Template == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Missing required field: template"})
+		return
+	}
+
+	// Overrides may be sent as JSON strings, numbers, or booleans
+	overrides := make(map[string]string)
+	for key, value := range req.Overrides {
+		switch v := value.(type) {
+		case string:
+			overrides[key] = v
+		case float64:
+			overrides[key] = strconv.FormatFloat(v, 'g', -1, 64)
+		case bool:
+			overrides[key] = strconv.FormatBool(v)
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": fmt.Sprintf("Unsupported override value for %q", key)})
+			return
+		}
+	}
+
+	// A token scoped to a project may only use its project's templates
+	if t, err := dao.GetRunTemplateByName(ctx, req.Template); err == nil {
+		ok, err := requestCanAccessExperiment(r, t.ExperimentID)
+		if err == nil && !ok {
+			err = &runTemplateError{http.StatusNotFound, "Template not found"}
+		}
+		if err != nil {
+			writeRunTemplateError(w, err)
+			return
+		}
+	}
+
+	runUUID, err := createRunFromTemplate(ctx, req.Template, req.Name, overrides)
+	if err != nil {
+		writeRunTemplateError(w, err)
+		return
+	}
+
+	run, err := dao.GetRunByUUID(ctx, runUUID)
+	if err != nil {
+		writeRunTemplateError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"id":   runUUID,
+		"name": run.Name,
+	})
+}
+
+func handleSaveRunTemplate(w http.ResponseWriter, r *http.Request, runUUID string) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := strings.TrimSpace(r.FormValue("name"))
+	var promptKeys []string
+	for _, key := range strings.Split(r.FormValue("prompt_keys"), ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			promptKeys = append(promptKeys, key)
+		}
+	}
+
+	// Respond with a short status message for htmx to swap in
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if name == "" {
+		fmt.Fprintf(w, "Template name is required")
+		return
+	}
+	if err := saveRunTemplate(r.Context(), name, runUUID, promptKeys); err != nil {
+		var templateErr *runTemplateError
+		if !errors.As(err, &templateErr) {
+			log.Printf("Failed to save run %s as template %s: %v", runUUID, name, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprintf(w, "%s", template.HTMLEscapeString(templateErr.message))
+		return
+	}
+	fmt.Fprintf(w, `Saved as template <a href="/templates/%s">%s</a>`,
+		template.HTMLEscapeString(url.PathEscape(name)), template.HTMLEscapeString(name))
+}
+
+// runTemplateTemplates are the templates of a run template's page
+var runTemplateTemplates = registerPage("templates/run_template.html")
+
+func handleViewRunTemplates(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/templates"), "/")
+	if path == "" {
+		return handleListRunTemplates(w, r)
+	}
+
+	parts := strings.SplitN(path, "/", 2)
+	templateName := parts[0]
+	if len(parts) == 2 {
+		switch parts[1] {
+		case "runs":
+			handleCreateRunFromTemplate(w, r, templateName)
+			return nil
+		}
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "Not found")
+		return nil
+	}
+
+	row, err := dao.GetRunTemplateByName(ctx, templateName)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "Template not found")
+		return nil
+	}
+	runTemplate, err := getRunTemplate(ctx, *row)
+	if err != nil {
+		return fmt.Errorf("failed to load template %s: %w", templateName, err)
+	}
+
+	data := struct {
+		Title    string
+		Template *RunTemplate
+	}{
+		Title:    "Template " + runTemplate.Name,
+		Template: runTemplate,
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	tmpl, err := runTemplateTemplates.Get()
+	if err != nil {
+		return fmt.Errorf("failed to parse template: %w", err)
+	}
+	return executeTemplate(w, tmpl, "run_template.html", data)
+}
+
+// runTemplatesTemplates are the templates of the run templates page
+var runTemplatesTemplates = registerPage("templates/run_templates.html")
+
+func handleListRunTemplates(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	rows, err := dao.GetAllRunTemplates(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list run templates: %w", err)
+	}
+
+	var templates []*RunTemplate
+	for _, row := range rows {
+		if ok, err := requestCanAccessExperiment(r, row.ExperimentID); err != nil || !ok {
+			if err != nil {
+				writeRunTemplateError(w, err)
+				return nil
+			}
+			continue
+		}
+		t, err := getRunTemplate(ctx, row)
+		if err != nil {
+			return fmt.Errorf("failed to load template %s: %w", row.Name, err)
+		}
+		templates = append(templates, t)
+	}
+
+	data := struct {
+		Title     string
+		Templates []*RunTemplate
+	}{
+		Title:     "Run templates",
+		Templates: templates,
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	tmpl, err := runTemplatesTemplates.Get()
+	if err != nil {
+		return fmt.Errorf("failed to parse template: %w", err)
+	}
+	return executeTemplate(w, tmpl, "run_templates.html", data)
+}
+
+func handleCreateRunFromTemplate(w http.ResponseWriter, r *http.Request, templateName string) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "Invalid form")
+		return
+	}
+
+	// Prompted parameters are submitted as param.<key>
+	overrides := make(map[string]string)
+	for field, values := range r.PostForm {
+		if key, ok := strings.CutPrefix(field, "param."); ok && len(values) > 0 {
+			overrides[key] = values[0]
+		}
+	}
+
+	runUUID, err := createRunFromTemplate(r.Context(), templateName, strings.TrimSpace(r.PostFormValue("name")), overrides)
+	if err != nil {
+		var templateErr *runTemplateError
+		if errors.As(err, &templateErr) {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.WriteHeader(templateErr.status)
+			fmt.Fprintf(w, "%s", templateErr.message)
+			return
+		}
+		log.Printf("Failed to create run from template %s: %v", templateName, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/runs/"+runUUID, http.StatusSeeOther)
+}
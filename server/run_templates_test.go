@@ -0,0 +1,87 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestBuildRunTemplateParameters(t *testing.T) {
+	params := []ParameterRow{floatParam("lr", 0.001), intParam("epochs", 10)}
+
+	rows, err := buildRunTemplateParameters(params, []string{"lr"})
+	if err != nil {
+		t.Fatalf("buildRunTemplateParameters failed: %v", err)
+	}
+	if len(rows) != 2 || !rows[0].Prompt || rows[1].Prompt {
+		t.Errorf("buildRunTemplateParameters returned unexpected rows: %+v", rows)
+	}
+
+	if _, err := buildRunTemplateParameters(params, []string{"momentum"}); err == nil {
+		t.Error("buildRunTemplateParameters accepted a prompt key the run does not have")
+	}
+}
+
+func TestResolveTemplateParameters(t *testing.T) {
+	params := []RunTemplateParameterRow{
+		{ParameterRow: floatParam("lr", 0.001), Prompt: true},
+		{ParameterRow: intParam("epochs", 10), Prompt: true},
+		{ParameterRow: stringParam("optimizer", "adam")},
+	}
+
+	tests := []struct {
+		name      string
+		overrides map[string]string
+		want      map[string]string
+		wantErr   bool
+	}{
+		{
+			name: "no overrides keeps template values",
+			want: map[string]string{"lr": "0.001", "epochs": "10", "optimizer": "adam"},
+		},
+		{
+			name:      "overrides are converted to the template type",
+			overrides: map[string]string{"lr": "0.01", "epochs": "20"},
+			want:      map[string]string{"lr": "0.01", "epochs": "20", "optimizer": "adam"},
+		},
+		{
+			name:      "override that does not parse",
+			overrides: map[string]string{"epochs": "many"},
+			wantErr:   true,
+		},
+		{
+			name:      "override of a parameter that is not prompted",
+			overrides: map[string]string{"optimizer": "sgd"},
+			wantErr:   true,
+		},
+		{
+			name:      "override of an unknown parameter",
+			overrides: map[string]string{"momentum": "0.9"},
+			wantErr:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resolved, err := resolveTemplateParameters(params, tt.overrides)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("resolveTemplateParameters(%v) succeeded, want error", tt.overrides)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("resolveTemplateParameters(%v) failed: %v", tt.overrides, err)
+			}
+			if len(resolved) != len(params) {
+				t.Fatalf("resolveTemplateParameters returned %d parameters, want %d", len(resolved), len(params))
+			}
+			for i, p := range resolved {
+				if p.ValueType != params[i].ValueType {
+					t.Errorf("parameter %s has type %s, want %s", p.Key, p.ValueType, params[i].ValueType)
+				}
+				if got := formatParameterValue(p); got != tt.want[p.Key] {
+					t.Errorf("parameter %s = %q, want %q", p.Key, got, tt.want[p.Key])
+				}
+			}
+		})
+	}
+}
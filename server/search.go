@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+	"unicode"
+)
+
+// searchResultsLimit is the maximum number of runs a search returns
+const searchResultsLimit = 50
+
+// searchMatchStart and searchMatchEnd delimit matched words in search
+// snippets. They are private use characters so they never occur in user text
+// and survive HTML escaping.
+const (
+	searchMatchStart = "\ue000"
+	searchMatchEnd   = "\ue001"
+)
+
+// searchTerms splits a free-text query into lowercase words. Everything other
+// than letters and digits separates words, so no query syntax reaches the
+// database's full-text engine.
+func searchTerms(query string) []string {
+	return strings.FieldsFunc(strings.ToLower(query), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// searchSnippetWords is the number of words around the first match kept in
+// a snippet built by searchSnippet
+const searchSnippetWords = 16
+
+// searchSnippet excerpts text around the first word matching a term and
+// delimits every matching word. A word matches if one of its letter and digit
+// runs starts with a term, mirroring prefix matching in full-text search.
+func searchSnippet(text string, terms []string) string {
+	words := strings.Fields(text)
+	matches := make([]bool, len(words))
+	first := -1
+	for i, word := range words {
+		for _, part := range searchTerms(word) {
+			for _, term := range terms {
+				if strings.HasPrefix(part, term) {
+					matches[i] = true
+				}
+			}
+		}
+		if matches[i] && first < 0 {
+			first = i
+		}
+	}
+	if first < 0 {
+		first = 0
+	}
+
+	start := max(first-searchSnippetWords/4, 0)
+	end := min(start+searchSnippetWords, len(words))
+	var b strings.Builder
+	if start > 0 {
+		b.WriteString("… ")
+	}
+	for i := start; i < end; i++ {
+		if i > start {
+			b.WriteString(" ")
+		}
+		if matches[i] {
+			b.WriteString(searchMatchStart + words[i] + searchMatchEnd)
+		} else {
+			b.WriteString(words[i])
+		}
+	}
+	if end < len(words) {
+		b.WriteString(" …")
+	}
+	return b.String()
+}
+
+// highlightSnippet renders a search snippet as HTML with matched words in
+// <mark> elements
+func highlightSnippet(snippet string) template.HTML {
+	escaped := template.HTMLEscapeString(snippet)
+	escaped = strings.ReplaceAll(escaped, searchMatchStart, "<mark>")
+	escaped = strings.ReplaceAll(escaped, searchMatchEnd, "</mark>")
+	return template.HTML(escaped)
+}
+
+// plainSnippet strips the match delimiters from a search snippet
+func plainSnippet(snippet string) string {
+	return strings.NewReplacer(searchMatchStart, "", searchMatchEnd, "").Replace(snippet)
+}
+
+// searchRuns runs a full-text search over run names, notes, annotations, and tags
+// of a project's runs, or every run if projectID is 0. A query without any
+// words matches nothing.
+func searchRuns(ctx context.Context, query string, projectID int) ([]RunSearchRow, error) {
+	terms := searchTerms(query)
+	if len(terms) == 0 {
+		return nil, nil
+	}
+	return dao.SearchRuns(ctx, terms, searchResultsLimit, projectID)
+}
+
+// SearchResultDocument is a run matching a GET /api/search query
+type SearchResultDocument struct {
+	UUID           string `json:"uuid"`
+	Name           string `json:"name"`
+	CreatedAt      string `json:"created_at"`
+	ExperimentUUID string `json:"experiment_uuid"`
+	ExperimentName string `json:"experiment_name"`
+	Snippet        string `json:"snippet"`
+}
+
+func handleAPISearch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	projectID, err := apiProjectID(r)
+	if writeProjectError(w, err) {
+		return
+	}
+	query := r.URL.Query().Get("q")
+	results, err := searchRuns(r.Context(), query, projectID)
+	if err != nil {
+		log.Printf("Failed to search runs for %q: %v", query, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to search runs"})
+		return
+	}
+
+	if wantsArrowStream(r) {
+		writeSearchResultsArrow(w, query, results)
+		return
+	}
+
+	resp := []SearchResultDocument{}
+	for _, row := range results {
+		resp = append(resp, SearchResultDocument{
+			UUID:           row.UUID,
+			Name:           row.Name,
+			CreatedAt:      row.CreatedAt,
+			ExperimentUUID: row.ExperimentUUID,
+			ExperimentName: row.ExperimentName,
+			Snippet:        plainSnippet(row.Snippet),
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"results": resp})
+}
+
+// writeSearchResultsArrow sends run search results as an Arrow stream
+func writeSearchResultsArrow(w http.ResponseWriter, query string, results []RunSearchRow) {
+	w.Header().Set("Content-Type", arrowStreamMediaType)
+	aw := newArrowStreamWriter(w, []arrowColumn{
+		{Name: "uuid", Type: arrowUTF8},
+		{Name: "name", Type: arrowUTF8},
+		{Name: "created_at", Type: arrowUTF8},
+		{Name: "experiment_uuid", Type: arrowUTF8},
+		{Name: "experiment_name", Type: arrowUTF8},
+		{Name: "snippet", Type: arrowUTF8},
+	})
+	for _, row := range results {
+		if err := aw.WriteRow(row.UUID, row.Name, row.CreatedAt, row.ExperimentUUID, row.ExperimentName, plainSnippet(row.Snippet)); err != nil {
+			log.Printf("Failed to send search results for %q: %v", query, err)
+			return
+		}
+	}
+	if err := aw.Close(); err != nil {
+		log.Printf("Failed to send search results for %q: %v", query, err)
+	}
+}
+
+// searchTemplates are the templates of the search page
+var searchTemplates = registerPage("templates/search.html")
+
+func handleSearch(w http.ResponseWriter, r *http.Request) error {
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	results, err := searchRuns(r.Context(), query, selectedProjectID(r))
+	if err != nil {
+		return fmt.Errorf("failed to search runs for %q: %w", query, err)
+	}
+
+	type searchResult struct {
+		RunSearchRow
+		Highlighted template.HTML
+	}
+	var rendered []searchResult
+	for _, row := range results {
+		rendered = append(rendered, searchResult{RunSearchRow: row, Highlighted: highlightSnippet(row.Snippet)})
+	}
+
+	title := "Search"
+	if query != "" {
+		title = "Search: " + query
+	}
+	data := struct {
+		Title   string
+		Query   string
+		Results []searchResult
+	}{
+		Title:   title,
+		Query:   query,
+		Results: rendered,
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	tmpl, err := searchTemplates.Get()
+	if err != nil {
+		return fmt.Errorf("failed to parse template: %w", err)
+	}
+	return executeTemplate(w, tmpl, "search.html", data)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSearchTerms(t *testing.T) {
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"label smoothing", []string{"label", "smoothing"}},
+		{"  Label   SMOOTHING ", []string{"label", "smoothing"}},
+		{`"lr" AND (warmup OR -decay*)`, []string{"lr", "and", "warmup", "or", "decay"}},
+		{"resnet-50", []string{"resnet", "50"}},
+		{"größe", []string{"größe"}},
+		{"*:&|!", []string{}},
+		{"", []string{}},
+	}
+
+	for _, tt := range tests {
+		got := searchTerms(tt.query)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("searchTerms(%q) = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestHighlightSnippet(t *testing.T) {
+	tests := []struct {
+		snippet string
+		want    string
+	}{
+		{"tried " + searchMatchStart + "label" + searchMatchEnd + " smoothing", "tried <mark>label</mark> smoothing"},
+		{"<script>" + searchMatchStart + "x" + searchMatchEnd, "&lt;script&gt;<mark>x</mark>"},
+		{"no matches", "no matches"},
+	}
+
+	for _, tt := range tests {
+		if got := string(highlightSnippet(tt.snippet)); got != tt.want {
+			t.Errorf("highlightSnippet(%q) = %q, want %q", tt.snippet, got, tt.want)
+		}
+	}
+}
+
+func TestSearchSnippet(t *testing.T) {
+	m := func(s string) string { return searchMatchStart + s + searchMatchEnd }
+	tests := []struct {
+		name  string
+		text  string
+		terms []string
+		want  string
+	}{
+		{
+			name:  "prefix match",
+			text:  "Tried label smoothing with warmup",
+			terms: []string{"smooth"},
+			want:  "Tried label " + m("smoothing") + " with warmup",
+		},
+		{
+			name:  "several terms",
+			text:  "label smoothing, then Label noise",
+			terms: []string{"label", "noise"},
+			want:  m("label") + " smoothing, then " + m("Label") + " " + m("noise"),
+		},
+		{
+			name:  "match inside punctuation",
+			text:  "see (resnet-50) baseline",
+			terms: []string{"50"},
+			want:  "see " + m("(resnet-50)") + " baseline",
+		},
+		{
+			name:  "long text is cut around the first match",
+			text:  "a b c d e f g h i j k l m n o p q r s t u v w x y z",
+			terms: []string{"k"},
+			want:  "… g h i j " + m("k") + " l m n o p q r s t u v …",
+		},
+		{
+			name:  "no match keeps the start",
+			text:  "a b",
+			terms: []string{"z"},
+			want:  "a b",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := searchSnippet(tt.text, tt.terms); got != tt.want {
+				t.Errorf("searchSnippet() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
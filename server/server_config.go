@@ -0,0 +1,444 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"gopkg.in/yaml.v3"
+)
+
+// serverSetting is a server setting that can be given in the config file, as
+// a flag, or as an environment variable
+type serverSetting struct {
+	// Key is the setting's section and name in the config file
+	Key string
+	// Flag is the name of the setting's flag
+	Flag string
+	// LegacyEnv is an environment variable read before the APPARATUS_* one
+	// derived from the flag name existed
+	LegacyEnv string
+}
+
+// Env is the environment variable that overrides the setting
+func (s serverSetting) Env() string {
+	return "APPARATUS_" + strings.ToUpper(strings.ReplaceAll(s.Flag, "-", "_"))
+}
+
+// serverSettings are the settings the config file and APPARATUS_* environment
+// variables can set. Environment variables take precedence over flags, which
+// take precedence over the config file.
+var serverSettings = []serverSetting{
+	{Key: "database.url", Flag: "db", LegacyEnv: "APPARATUS_DB_CONNECTION_STRING"},
+	{Key: "database.query_timeout", Flag: "db-query-timeout"},
+	{Key: "artifact_store.uri", Flag: "artifact-store-uri"},
+	{Key: "artifact_store.mirror_uri", Flag: "artifact-mirror-uri"},
+	{Key: "artifact_store.mirror_interval", Flag: "artifact-mirror-interval"},
+	{Key: "artifact_store.scanner", Flag: "artifact-scanner"},
+	{Key: "artifact_store.scan_interval", Flag: "artifact-scan-interval"},
+	{Key: "artifact_store.scan_timeout", Flag: "artifact-scan-timeout"},
+	{Key: "artifact_store.serve_concurrency", Flag: "artifact-serve-concurrency"},
+	{Key: "artifact_store.serve_queue", Flag: "artifact-serve-queue"},
+	{Key: "artifact_store.serve_queue_timeout", Flag: "artifact-serve-queue-timeout"},
+	{Key: "artifact_store.serve_bandwidth", Flag: "artifact-serve-bandwidth"},
+	{Key: "artifact_store.upload_expiry", Flag: "artifact-upload-expiry"},
+	{Key: "artifact_store.cache_dir", Flag: "artifact-cache-dir"},
+	{Key: "artifact_store.cache_size", Flag: "artifact-cache-size"},
+	{Key: "artifact_store.cache_max_object_size", Flag: "artifact-cache-max-object-size"},
+	{Key: "ingestion.max_inflight", Flag: "ingest-max-inflight"},
+	{Key: "ingestion.latency_target", Flag: "ingest-latency-target"},
+	{Key: "server.port", Flag: "port"},
+	{Key: "server.listen", Flag: "listen"},
+	{Key: "server.tls_cert", Flag: "tls-cert"},
+	{Key: "server.tls_key", Flag: "tls-key"},
+	{Key: "server.acme_domains", Flag: "acme-domains"},
+	{Key: "server.acme_email", Flag: "acme-email"},
+	{Key: "server.acme_directory", Flag: "acme-directory"},
+	{Key: "server.acme_cache_dir", Flag: "acme-cache-dir"},
+	{Key: "server.shutdown_timeout", Flag: "shutdown-timeout"},
+	{Key: "server.multi_instance", Flag: "multi-instance"},
+	{Key: "server.instance_id", Flag: "instance-id"},
+	{Key: "server.legacy_query_params", Flag: "legacy-query-params"},
+	{Key: "server.dev", Flag: "dev"},
+	{Key: "auth.require_auth", Flag: "require-auth"},
+	{Key: "auth.admin_token", Flag: "admin-token"},
+	{Key: "journal.dir", Flag: "journal-dir"},
+	{Key: "journal.fsync_interval", Flag: "journal-fsync-interval"},
+	{Key: "runs.heartbeat_timeout", Flag: "run-heartbeat-timeout"},
+	{Key: "runs.metric_gap_threshold", Flag: "metric-gap-threshold"},
+	{Key: "runs.environment_redact_keys", Flag: "environment-redact-keys"},
+	{Key: "home.runs_sort", Flag: "runs-sort"},
+	{Key: "home.runs_sort_dir", Flag: "runs-sort-dir"},
+	{Key: "home.runs_density", Flag: "runs-density"},
+	{Key: "retention.housekeeping_interval", Flag: "housekeeping-interval"},
+	{Key: "retention.housekeeping_plan_max_age", Flag: "housekeeping-plan-max-age"},
+	{Key: "retention.archive_after_days", Flag: "archive-runs-after-days"},
+	{Key: "retention.delete_archived_after_days", Flag: "delete-archived-runs-after-days"},
+	{Key: "retention.purge_deleted_after_days", Flag: "purge-deleted-runs-after-days"},
+	{Key: "retention.purge_notice_days", Flag: "purge-notice-days"},
+	{Key: "quotas.runs", Flag: "quota-runs"},
+	{Key: "quotas.metric_points_per_day", Flag: "quota-metric-points-per-day"},
+	{Key: "quotas.artifact_bytes", Flag: "quota-artifact-bytes"},
+	{Key: "notifications.smtp_addr", Flag: "smtp-addr"},
+	{Key: "notifications.smtp_from", Flag: "smtp-from"},
+}
+
+// configFileEnv names the config file when -config is not given, or
+// overrides it when it is
+const configFileEnv = "APPARATUS_CONFIG"
+
+// defineConfigFlag defines the -config flag on flags
+func defineConfigFlag(flags *flag.FlagSet) *string {
+	return flags.String("config", "", "YAML or TOML file of settings, overridden by flags and APPARATUS_* environment variables (see the config validate command)")
+}
+
+// loadServerConfig fills in the settings defined on flags, which must have
+// been parsed, from the config file at configPath and from the environment.
+// Settings whose flags are not defined on flags are ignored, so commands
+// that take a few of the server's flags can share its config file. It
+// returns where each setting defined on flags came from.
+func loadServerConfig(flags *flag.FlagSet, configPath string) (map[string]string, error) {
+	sources := map[string]string{}
+	explicit := map[string]bool{}
+	flags.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+	for _, s := range serverSettings {
+		if flags.Lookup(s.Flag) == nil {
+			continue
+		}
+		sources[s.Flag] = "default"
+		if explicit[s.Flag] {
+			sources[s.Flag] = "flag -" + s.Flag
+		}
+	}
+
+	if env := os.Getenv(configFileEnv); env != "" {
+		configPath = env
+	}
+	if configPath != "" {
+		values, err := readServerConfigFile(configPath)
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range serverSettings {
+			value, ok := values[s.Key]
+			if !ok || explicit[s.Flag] || flags.Lookup(s.Flag) == nil {
+				continue
+			}
+			if err := flags.Set(s.Flag, value); err != nil {
+				return nil, fmt.Errorf("%s: invalid %s: %v", configPath, s.Key, err)
+			}
+			sources[s.Flag] = "file " + configPath
+		}
+	}
+
+	for _, s := range serverSettings {
+		if flags.Lookup(s.Flag) == nil {
+			continue
+		}
+		for _, env := range []string{s.LegacyEnv, s.Env()} {
+			if env == "" {
+				continue
+			}
+			if value := os.Getenv(env); value != "" {
+				if err := flags.Set(s.Flag, value); err != nil {
+					return nil, fmt.Errorf("invalid %s: %v", env, err)
+				}
+				sources[s.Flag] = "env " + env
+			}
+		}
+	}
+	return sources, nil
+}
+
+// readServerConfigFile reads a YAML or TOML config file into a map from
+// "section.name" keys to values in the form their flags accept
+func readServerConfigFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+	var values map[string]string
+	if strings.EqualFold(filepath.Ext(path), ".toml") {
+		values, err = parseTOMLConfig(string(data))
+	} else {
+		values, err = parseYAMLConfig(data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	known := map[string]bool{}
+	for _, s := range serverSettings {
+		known[s.Key] = true
+	}
+	for key := range values {
+		if !known[key] {
+			return nil, fmt.Errorf("%s: unknown setting %q", path, key)
+		}
+	}
+	return values, nil
+}
+
+// parseYAMLConfig reads a config file of sections mapping setting names to
+// scalar values
+func parseYAMLConfig(data []byte) (map[string]string, error) {
+	var sections map[string]map[string]interface{}
+	if err := yaml.Unmarshal(data, &sections); err != nil {
+		return nil, err
+	}
+	values := map[string]string{}
+	for section, settings := range sections {
+		for name, value := range settings {
+			switch value.(type) {
+			case string, bool, int, float64:
+				values[section+"."+name] = fmt.Sprint(value)
+			case nil:
+			default:
+				return nil, fmt.Errorf("%s.%s must be a string, number or boolean", section, name)
+			}
+		}
+	}
+	return values, nil
+}
+
+// parseTOMLConfig reads the subset of TOML a config file needs: [section]
+// tables of keys set to strings, integers, floats or booleans
+func parseTOMLConfig(data string) (map[string]string, error) {
+	values := map[string]string{}
+	section := ""
+	for i, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(stripTOMLComment(line))
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") || strings.HasPrefix(line, "[[") {
+				return nil, fmt.Errorf("line %d: invalid table header %q", i+1, line)
+			}
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			if !tomlBareKey.MatchString(section) {
+				return nil, fmt.Errorf("line %d: unsupported table name %q", i+1, section)
+			}
+			continue
+		}
+		key, raw, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !ok || !tomlBareKey.MatchString(key) {
+			return nil, fmt.Errorf("line %d: expected key = value, got %q", i+1, line)
+		}
+		if section == "" {
+			return nil, fmt.Errorf("line %d: %s must be in a [section]", i+1, key)
+		}
+		value, err := parseTOMLValue(strings.TrimSpace(raw))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", i+1, err)
+		}
+		values[section+"."+key] = value
+	}
+	return values, nil
+}
+
+// tomlBareKey matches the TOML keys and table names config files use
+var tomlBareKey = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// stripTOMLComment removes a # comment that is not inside a string
+func stripTOMLComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote == 0 && (c == '"' || c == '\''):
+			quote = c
+		case quote == 0 && c == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// parseTOMLValue converts a TOML string, integer, float or boolean to the
+// form its flag accepts
+func parseTOMLValue(raw string) (string, error) {
+	switch {
+	case len(raw) >= 2 && raw[0] == '"' && raw[len(raw)-1] == '"':
+		return strconv.Unquote(raw)
+	case len(raw) >= 2 && raw[0] == '\'' && raw[len(raw)-1] == '\'':
+		return raw[1 : len(raw)-1], nil
+	case raw == "true" || raw == "false":
+		return raw, nil
+	}
+	number := strings.ReplaceAll(raw, "_", "")
+	if _, err := strconv.ParseInt(number, 0, 64); err == nil {
+		return number, nil
+	}
+	if _, err := strconv.ParseFloat(number, 64); err == nil {
+		return number, nil
+	}
+	return "", fmt.Errorf("unsupported value %q (expected a string, number or boolean)", raw)
+}
+
+// validateServerSettings checks the settings defined on flags for mistakes
+// that would otherwise only surface once the server is running
+func validateServerSettings(flags *flag.FlagSet) error {
+	value := func(name string) string {
+		if f := flags.Lookup(name); f != nil {
+			return f.Value.String()
+		}
+		return ""
+	}
+
+	var errs []error
+	db := value("db")
+	isPostgres := strings.HasPrefix(db, "postgres://") || strings.HasPrefix(db, "postgresql://")
+	if db != "" && !strings.HasPrefix(db, "sqlite:///") && !isPostgres {
+		errs = append(errs, fmt.Errorf("database.url must start with sqlite:/// or postgres://"))
+	}
+	for name, key := range map[string]string{"artifact-store-uri": "artifact_store.uri", "artifact-mirror-uri": "artifact_store.mirror_uri"} {
+		uri := value(name)
+		if uri != "" && !strings.HasPrefix(uri, "file://") && !strings.HasPrefix(uri, "s3://") {
+			errs = append(errs, fmt.Errorf("%s must start with file:// or s3://", key))
+		}
+	}
+	if scanner := value("artifact-scanner"); scanner != "" {
+		if _, err := newArtifactScanner(scanner); err != nil {
+			errs = append(errs, fmt.Errorf("invalid artifact_store.scanner: %v", err))
+		}
+	}
+	if value("artifact-cache-dir") != "" {
+		for name, key := range map[string]string{"artifact-cache-size": "artifact_store.cache_size", "artifact-cache-max-object-size": "artifact_store.cache_max_object_size"} {
+			if n, err := strconv.ParseInt(value(name), 10, 64); err == nil && n <= 0 {
+				errs = append(errs, fmt.Errorf("%s must be more than 0", key))
+			}
+		}
+	}
+	if flags.Lookup("port") != nil {
+		if port, err := strconv.Atoi(value("port")); err != nil || port < 1 || port > 65535 {
+			errs = append(errs, fmt.Errorf("server.port must be between 1 and 65535"))
+		}
+	}
+	if listen := value("listen"); listen != "" {
+		if _, port, err := net.SplitHostPort(listen); err != nil {
+			errs = append(errs, fmt.Errorf("server.listen must be host:port: %v", err))
+		} else if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			errs = append(errs, fmt.Errorf("server.listen's port must be between 1 and 65535"))
+		}
+	}
+	if cert, key := value("tls-cert"), value("tls-key"); cert != "" || key != "" {
+		if cert == "" || key == "" {
+			errs = append(errs, fmt.Errorf("server.tls_cert and server.tls_key must be set together"))
+		} else if _, err := tls.LoadX509KeyPair(cert, key); err != nil {
+			errs = append(errs, fmt.Errorf("invalid TLS certificate: %v", err))
+		}
+	}
+	if domains := value("acme-domains"); domains != "" {
+		if value("tls-cert") != "" {
+			errs = append(errs, fmt.Errorf("server.acme_domains and server.tls_cert cannot both be set"))
+		}
+		for _, domain := range parseACMEDomains(domains) {
+			if strings.ContainsAny(domain, ":/ ") || !strings.Contains(domain, ".") {
+				errs = append(errs, fmt.Errorf("server.acme_domains: %q is not a domain name", domain))
+			}
+		}
+		if u, err := url.Parse(value("acme-directory")); err != nil || u.Scheme != "https" && u.Scheme != "http" {
+			errs = append(errs, fmt.Errorf("server.acme_directory must be an http(s) URL"))
+		}
+		if value("acme-cache-dir") == "" {
+			errs = append(errs, fmt.Errorf("server.acme_cache_dir is required with server.acme_domains"))
+		}
+		if value("multi-instance") == "true" {
+			errs = append(errs, fmt.Errorf("server.acme_domains cannot be used with server.multi_instance; terminate TLS at the load balancer"))
+		}
+	}
+	if pattern := value("environment-redact-keys"); pattern != "" {
+		if _, err := regexp.Compile(pattern); err != nil {
+			errs = append(errs, fmt.Errorf("invalid runs.environment_redact_keys: %v", err))
+		}
+	}
+	if sortBy := value("runs-sort"); sortBy != "" && !validRunSort(sortBy) {
+		errs = append(errs, fmt.Errorf("home.runs_sort must be created, name, status, experiment or metric:KEY"))
+	}
+	if dir := value("runs-sort-dir"); dir != "" && dir != "asc" && dir != "desc" {
+		errs = append(errs, fmt.Errorf("home.runs_sort_dir must be asc or desc"))
+	}
+	if density := value("runs-density"); density != "" && density != runDensityComfortable && density != runDensityCompact {
+		errs = append(errs, fmt.Errorf("home.runs_density must be comfortable or compact"))
+	}
+	for _, name := range []string{"quota-runs", "quota-metric-points-per-day", "quota-artifact-bytes"} {
+		if strings.HasPrefix(value(name), "-") {
+			errs = append(errs, fmt.Errorf("quotas.%s must be 0 (unlimited) or more", strings.ReplaceAll(strings.TrimPrefix(name, "quota-"), "-", "_")))
+		}
+	}
+	for name, key := range map[string]string{"ingest-max-inflight": "ingestion.max_inflight", "ingest-latency-target": "ingestion.latency_target"} {
+		if strings.HasPrefix(value(name), "-") {
+			errs = append(errs, fmt.Errorf("%s must be 0 (disabled) or more", key))
+		}
+	}
+	for name, key := range map[string]string{"archive-runs-after-days": "retention.archive_after_days", "delete-archived-runs-after-days": "retention.delete_archived_after_days"} {
+		if strings.HasPrefix(value(name), "-") {
+			errs = append(errs, fmt.Errorf("%s must be 0 (disabled) or more", key))
+		}
+	}
+	if value("multi-instance") == "true" && !isPostgres {
+		errs = append(errs, fmt.Errorf("server.multi_instance requires a Postgres database shared by all replicas"))
+	}
+	return errors.Join(errs...)
+}
+
+// redactSetting hides the admin token and database password when settings
+// are printed
+func redactSetting(flagName, value string) string {
+	switch flagName {
+	case "admin-token":
+		if value != "" {
+			return "<redacted>"
+		}
+	case "db", "artifact-scanner":
+		if u, err := url.Parse(value); err == nil && u.User != nil {
+			return u.Redacted()
+		}
+	}
+	return value
+}
+
+// runConfigCommand implements "apparatus-server config validate", which loads
+// the server's settings from its config file, flags and environment as the
+// server would, checks them, and prints each with where it came from
+func runConfigCommand(args []string) {
+	if len(args) == 0 || args[0] != "validate" {
+		fmt.Fprintf(os.Stderr, "Usage: %s config validate [-config FILE] [flags]\n\nChecks the server's settings without starting it.\n", os.Args[0])
+		os.Exit(2)
+	}
+
+	flags := flag.NewFlagSet("config validate", flag.ExitOnError)
+	opts := defineServerFlags(flags)
+	flags.Parse(args[1:])
+
+	sources, err := loadServerConfig(flags, *opts.configPath)
+	if err == nil {
+		err = validateServerSettings(flags)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid configuration:\n%v\n", err)
+		os.Exit(1)
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "SETTING\tVALUE\tSOURCE")
+	for _, s := range serverSettings {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", s.Key, redactSetting(s.Flag, flags.Lookup(s.Flag).Value.String()), sources[s.Flag])
+	}
+	tw.Flush()
+	fmt.Println("Configuration is valid")
+}
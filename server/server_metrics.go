@@ -0,0 +1,490 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// httpDurationBuckets are the upper bounds, in seconds, of the request
+// latency histogram buckets
+var httpDurationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// dbDurationBuckets are the upper bounds, in seconds, of the database query
+// duration histogram buckets
+var dbDurationBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5}
+
+// histogram counts observations into cumulative buckets
+type histogram struct {
+	buckets []float64
+	counts  []uint64
+	sum     float64
+	count   uint64
+}
+
+func newHistogram(buckets []float64) *histogram {
+	return &histogram{buckets: buckets, counts: make([]uint64, len(buckets))}
+}
+
+func (h *histogram) observe(v float64) {
+	for i, bound := range h.buckets {
+		if v <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+// write writes the histogram's samples, with labels in front of the le label
+func (h *histogram) write(w io.Writer, name string, labels ...string) {
+	for i, bound := range h.buckets {
+		fmt.Fprintf(w, "%s_bucket%s %d\n", name, formatPrometheusLabels(nil, append(labels, "le", formatPrometheusValue(bound))...), h.counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket%s %d\n", name, formatPrometheusLabels(nil, append(labels, "le", "+Inf")...), h.count)
+	fmt.Fprintf(w, "%s_sum%s %s\n", name, formatPrometheusLabels(nil, labels...), formatPrometheusValue(h.sum))
+	fmt.Fprintf(w, "%s_count%s %d\n", name, formatPrometheusLabels(nil, labels...), h.count)
+}
+
+// httpRouteKey identifies the requests of a route. Route is the pattern the
+// request matched, so that run UUIDs and other path parameters do not each
+// become a series.
+type httpRouteKey struct {
+	Route  string
+	Method string
+}
+
+// httpRouteStats are the request counts by status code and the latencies of
+// a route
+type httpRouteStats struct {
+	codes    map[int]uint64
+	duration *histogram
+}
+
+// serverMetrics are the server's own operational metrics, exposed at /metrics
+// for Prometheus to scrape
+type serverMetrics struct {
+	mu        sync.Mutex
+	routes    map[httpRouteKey]*httpRouteStats
+	dbQueries map[string]*histogram
+
+	artifactUploadBytes atomic.Int64
+	sseConnections      atomic.Int64
+
+	artifactCacheHits         atomic.Int64
+	artifactCacheMisses       atomic.Int64
+	artifactCacheEvictions    atomic.Int64
+	artifactCacheFetchedBytes atomic.Int64
+}
+
+func newServerMetrics() *serverMetrics {
+	return &serverMetrics{
+		routes:    make(map[httpRouteKey]*httpRouteStats),
+		dbQueries: make(map[string]*histogram),
+	}
+}
+
+var serverStats = newServerMetrics()
+
+// observeRequest records a handled request
+func (m *serverMetrics) observeRequest(route, method string, code int, elapsed time.Duration) {
+	if route == "" {
+		route = "unmatched"
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := httpRouteKey{Route: route, Method: method}
+	stats, ok := m.routes[key]
+	if !ok {
+		stats = &httpRouteStats{codes: make(map[int]uint64), duration: newHistogram(httpDurationBuckets)}
+		m.routes[key] = stats
+	}
+	stats.codes[code]++
+	stats.duration.observe(elapsed.Seconds())
+}
+
+// observeDBQuery records the duration of a database query or statement
+func (m *serverMetrics) observeDBQuery(operation string, elapsed time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	h, ok := m.dbQueries[operation]
+	if !ok {
+		h = newHistogram(dbDurationBuckets)
+		m.dbQueries[operation] = h
+	}
+	h.observe(elapsed.Seconds())
+}
+
+// write writes all metrics in the Prometheus text exposition format, in a
+// stable order
+func (m *serverMetrics) write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	keys := make([]httpRouteKey, 0, len(m.routes))
+	for key := range m.routes {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Route != keys[j].Route {
+			return keys[i].Route < keys[j].Route
+		}
+		return keys[i].Method < keys[j].Method
+	})
+
+	fmt.Fprintln(w, "# HELP apparatus_http_requests_total HTTP requests handled, by route, method and status code.")
+	fmt.Fprintln(w, "# TYPE apparatus_http_requests_total counter")
+	for _, key := range keys {
+		stats := m.routes[key]
+		codes := make([]int, 0, len(stats.codes))
+		for code := range stats.codes {
+			codes = append(codes, code)
+		}
+		sort.Ints(codes)
+		for _, code := range codes {
+			fmt.Fprintf(w, "apparatus_http_requests_total%s %d\n", formatPrometheusLabels(nil, "route", key.Route, "method", key.Method, "code", strconv.Itoa(code)), stats.codes[code])
+		}
+	}
+	fmt.Fprintln(w, "# HELP apparatus_http_request_duration_seconds Time to handle HTTP requests, by route and method. Event streams count for as long as they stay open.")
+	fmt.Fprintln(w, "# TYPE apparatus_http_request_duration_seconds histogram")
+	for _, key := range keys {
+		m.routes[key].duration.write(w, "apparatus_http_request_duration_seconds", "route", key.Route, "method", key.Method)
+	}
+
+	operations := make([]string, 0, len(m.dbQueries))
+	for operation := range m.dbQueries {
+		operations = append(operations, operation)
+	}
+	sort.Strings(operations)
+	fmt.Fprintln(w, "# HELP apparatus_db_query_duration_seconds Time for the database to execute a statement, or return the first rows of a query, by SQL command.")
+	fmt.Fprintln(w, "# TYPE apparatus_db_query_duration_seconds histogram")
+	for _, operation := range operations {
+		m.dbQueries[operation].write(w, "apparatus_db_query_duration_seconds", "operation", operation)
+	}
+
+	fmt.Fprintln(w, "# HELP apparatus_artifact_upload_bytes_total Bytes of artifacts uploaded.")
+	fmt.Fprintln(w, "# TYPE apparatus_artifact_upload_bytes_total counter")
+	fmt.Fprintf(w, "apparatus_artifact_upload_bytes_total %d\n", m.artifactUploadBytes.Load())
+	fmt.Fprintln(w, "# HELP apparatus_artifact_cache_requests_total Artifacts viewed through the artifact cache, by whether they were cached.")
+	fmt.Fprintln(w, "# TYPE apparatus_artifact_cache_requests_total counter")
+	fmt.Fprintf(w, "apparatus_artifact_cache_requests_total{result=\"hit\"} %d\n", m.artifactCacheHits.Load())
+	fmt.Fprintf(w, "apparatus_artifact_cache_requests_total{result=\"miss\"} %d\n", m.artifactCacheMisses.Load())
+	fmt.Fprintln(w, "# HELP apparatus_artifact_cache_fetched_bytes_total Bytes of artifacts fetched from object storage into the artifact cache.")
+	fmt.Fprintln(w, "# TYPE apparatus_artifact_cache_fetched_bytes_total counter")
+	fmt.Fprintf(w, "apparatus_artifact_cache_fetched_bytes_total %d\n", m.artifactCacheFetchedBytes.Load())
+	fmt.Fprintln(w, "# HELP apparatus_artifact_cache_evictions_total Artifacts evicted from the artifact cache to make room for others.")
+	fmt.Fprintln(w, "# TYPE apparatus_artifact_cache_evictions_total counter")
+	fmt.Fprintf(w, "apparatus_artifact_cache_evictions_total %d\n", m.artifactCacheEvictions.Load())
+	var cacheEntries int
+	var cacheBytes, cacheLimit int64
+	if artifactCache != nil {
+		cacheEntries, cacheBytes = artifactCache.usage()
+		cacheLimit = artifactCache.maxSize
+	}
+	fmt.Fprintln(w, "# HELP apparatus_artifact_cache_entries Artifacts in the artifact cache.")
+	fmt.Fprintln(w, "# TYPE apparatus_artifact_cache_entries gauge")
+	fmt.Fprintf(w, "apparatus_artifact_cache_entries %d\n", cacheEntries)
+	fmt.Fprintln(w, "# HELP apparatus_artifact_cache_bytes Bytes of artifacts in the artifact cache.")
+	fmt.Fprintln(w, "# TYPE apparatus_artifact_cache_bytes gauge")
+	fmt.Fprintf(w, "apparatus_artifact_cache_bytes %d\n", cacheBytes)
+	fmt.Fprintln(w, "# HELP apparatus_artifact_cache_limit_bytes Most bytes the artifact cache holds, 0 if it is disabled.")
+	fmt.Fprintln(w, "# TYPE apparatus_artifact_cache_limit_bytes gauge")
+	fmt.Fprintf(w, "apparatus_artifact_cache_limit_bytes %d\n", cacheLimit)
+	fmt.Fprintln(w, "# HELP apparatus_sse_connections Open run event streams.")
+	fmt.Fprintln(w, "# TYPE apparatus_sse_connections gauge")
+	fmt.Fprintf(w, "apparatus_sse_connections %d\n", m.sseConnections.Load())
+	fmt.Fprintln(w, "# HELP apparatus_ingestion_backoff_seconds How long the server is asking clients to wait between writes, 0 while it keeps up.")
+	fmt.Fprintln(w, "# TYPE apparatus_ingestion_backoff_seconds gauge")
+	fmt.Fprintf(w, "apparatus_ingestion_backoff_seconds %s\n", formatPrometheusValue(ingestion.backoff().Seconds()))
+}
+
+// handleServerMetrics exposes the server's own metrics to Prometheus
+func handleServerMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	serverStats.write(w)
+}
+
+// sqlOperation is the command of a SQL statement, e.g. "select", for
+// labelling its duration without a series per distinct statement
+func sqlOperation(query string) string {
+	fields := strings.Fields(query)
+	if len(fields) == 0 {
+		return "other"
+	}
+	switch operation := strings.ToLower(fields[0]); operation {
+	case "select", "insert", "update", "delete", "with", "copy":
+		return operation
+	}
+	return "other"
+}
+
+// dbQueryTimeout bounds how long a database statement can take, including
+// reading a query's rows, before it is cancelled (0 is unlimited). Statements
+// are also cancelled when the request they serve is.
+var dbQueryTimeout = 30 * time.Second
+
+// withQueryTimeout bounds a statement's context by dbQueryTimeout
+func withQueryTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if dbQueryTimeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, dbQueryTimeout)
+}
+
+// openInstrumentedDB opens a database whose queries are timed into the
+// server metrics and bounded by dbQueryTimeout. Connections are wrapped
+// rather than the DAOs, so every query is covered without touching the DAOs.
+func openInstrumentedDB(driverName, dataSource string) (*sql.DB, error) {
+	plain, err := sql.Open(driverName, dataSource)
+	if err != nil {
+		return nil, err
+	}
+	d := plain.Driver()
+	plain.Close()
+
+	var connector driver.Connector = dsnConnector{dsn: dataSource, driver: d}
+	if dc, ok := d.(driver.DriverContext); ok {
+		if connector, err = dc.OpenConnector(dataSource); err != nil {
+			return nil, err
+		}
+	}
+	return sql.OpenDB(instrumentedConnector{connector}), nil
+}
+
+// dsnConnector connects with a driver that has no connector of its own
+type dsnConnector struct {
+	dsn    string
+	driver driver.Driver
+}
+
+func (c dsnConnector) Connect(context.Context) (driver.Conn, error) {
+	return c.driver.Open(c.dsn)
+}
+
+func (c dsnConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+// instrumentedConnector makes connections that time their queries
+type instrumentedConnector struct {
+	driver.Connector
+}
+
+func (c instrumentedConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return instrumentedConn{conn}, nil
+}
+
+// instrumentedConn times the queries and statements run on a connection,
+// directly or prepared, inside transactions too. The optional interfaces of
+// the driver's connection are passed through, so that it behaves the same.
+type instrumentedConn struct {
+	driver.Conn
+}
+
+func (c instrumentedConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	execer, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	start := time.Now()
+	result, err := execer.ExecContext(ctx, query, args)
+	if err != driver.ErrSkip {
+		serverStats.observeDBQuery(sqlOperation(query), time.Since(start))
+	}
+	return result, err
+}
+
+func (c instrumentedConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	queryer, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	ctx, cancel := withQueryTimeout(ctx)
+	start := time.Now()
+	rows, err := queryer.QueryContext(ctx, query, args)
+	if err != driver.ErrSkip {
+		serverStats.observeDBQuery(sqlOperation(query), time.Since(start))
+	}
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	return timedRows{Rows: rows, cancel: cancel}, nil
+}
+
+func (c instrumentedConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	var stmt driver.Stmt
+	var err error
+	if preparer, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		stmt, err = preparer.PrepareContext(ctx, query)
+	} else {
+		stmt, err = c.Conn.Prepare(query)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Executions of a COPY only buffer rows, so they are not queries to time
+	operation := sqlOperation(query)
+	if operation == "copy" {
+		return stmt, nil
+	}
+	return instrumentedStmt{Stmt: stmt, operation: operation}, nil
+}
+
+func (c instrumentedConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if beginner, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return beginner.BeginTx(ctx, opts)
+	}
+	return c.Conn.Begin()
+}
+
+func (c instrumentedConn) Ping(ctx context.Context) error {
+	if pinger, ok := c.Conn.(driver.Pinger); ok {
+		return pinger.Ping(ctx)
+	}
+	return nil
+}
+
+func (c instrumentedConn) CheckNamedValue(nv *driver.NamedValue) error {
+	if checker, ok := c.Conn.(driver.NamedValueChecker); ok {
+		return checker.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+func (c instrumentedConn) ResetSession(ctx context.Context) error {
+	if resetter, ok := c.Conn.(driver.SessionResetter); ok {
+		return resetter.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c instrumentedConn) IsValid() bool {
+	if validator, ok := c.Conn.(driver.Validator); ok {
+		return validator.IsValid()
+	}
+	return true
+}
+
+// instrumentedStmt times the executions of a prepared statement
+type instrumentedStmt struct {
+	driver.Stmt
+	operation string
+}
+
+func (s instrumentedStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	ctx, cancel := withQueryTimeout(ctx)
+	defer cancel()
+	start := time.Now()
+	defer func() { serverStats.observeDBQuery(s.operation, time.Since(start)) }()
+	if execer, ok := s.Stmt.(driver.StmtExecContext); ok {
+		return execer.ExecContext(ctx, args)
+	}
+	return s.Stmt.Exec(namedValuesToValues(args))
+}
+
+func (s instrumentedStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	start := time.Now()
+	defer func() { serverStats.observeDBQuery(s.operation, time.Since(start)) }()
+	queryer, ok := s.Stmt.(driver.StmtQueryContext)
+	if !ok {
+		return s.Stmt.Query(namedValuesToValues(args))
+	}
+	ctx, cancel := withQueryTimeout(ctx)
+	rows, err := queryer.QueryContext(ctx, args)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	return timedRows{Rows: rows, cancel: cancel}, nil
+}
+
+// timedRows are the rows of a query bounded by dbQueryTimeout, whose timer is
+// stopped once they are closed. The optional interfaces of the driver's rows
+// are passed through, with database/sql's defaults where they are missing.
+type timedRows struct {
+	driver.Rows
+	cancel context.CancelFunc
+}
+
+func (r timedRows) Close() error {
+	defer r.cancel()
+	return r.Rows.Close()
+}
+
+func (r timedRows) HasNextResultSet() bool {
+	if next, ok := r.Rows.(driver.RowsNextResultSet); ok {
+		return next.HasNextResultSet()
+	}
+	return false
+}
+
+func (r timedRows) NextResultSet() error {
+	if next, ok := r.Rows.(driver.RowsNextResultSet); ok {
+		return next.NextResultSet()
+	}
+	return io.EOF
+}
+
+func (r timedRows) ColumnTypeScanType(index int) reflect.Type {
+	if typed, ok := r.Rows.(driver.RowsColumnTypeScanType); ok {
+		return typed.ColumnTypeScanType(index)
+	}
+	return reflect.TypeFor[any]()
+}
+
+func (r timedRows) ColumnTypeDatabaseTypeName(index int) string {
+	if typed, ok := r.Rows.(driver.RowsColumnTypeDatabaseTypeName); ok {
+		return typed.ColumnTypeDatabaseTypeName(index)
+	}
+	return ""
+}
+
+func (r timedRows) ColumnTypeLength(index int) (int64, bool) {
+	if typed, ok := r.Rows.(driver.RowsColumnTypeLength); ok {
+		return typed.ColumnTypeLength(index)
+	}
+	return 0, false
+}
+
+func (r timedRows) ColumnTypeNullable(index int) (nullable, ok bool) {
+	if typed, ok := r.Rows.(driver.RowsColumnTypeNullable); ok {
+		return typed.ColumnTypeNullable(index)
+	}
+	return false, false
+}
+
+func (r timedRows) ColumnTypePrecisionScale(index int) (precision, scale int64, ok bool) {
+	if typed, ok := r.Rows.(driver.RowsColumnTypePrecisionScale); ok {
+		return typed.ColumnTypePrecisionScale(index)
+	}
+	return 0, 0, false
+}
+
+func namedValuesToValues(args []driver.NamedValue) []driver.Value {
+	values := make([]driver.Value, len(args))
+	for i, arg := range args {
+		values[i] = arg.Value
+	}
+	return values
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServerMetricsWrite(t *testing.T) {
+	m := newServerMetrics()
+	m.observeRequest("/runs/", http.MethodGet, http.StatusOK, 20*time.Millisecond)
+	m.observeRequest("/runs/", http.MethodGet, http.StatusOK, 3*time.Second)
+	m.observeRequest("/runs/", http.MethodGet, http.StatusNotFound, time.Millisecond)
+	m.observeDBQuery("select", 2*time.Millisecond)
+	m.artifactUploadBytes.Add(1024)
+	m.sseConnections.Add(2)
+	m.artifactCacheHits.Add(3)
+	m.artifactCacheMisses.Add(1)
+
+	var b strings.Builder
+	m.write(&b)
+	out := b.String()
+	for _, line := range []string{
+		"# TYPE apparatus_http_requests_total counter",
+		`apparatus_http_requests_total{route="/runs/",method="GET",code="200"} 2`,
+		`apparatus_http_requests_total{route="/runs/",method="GET",code="404"} 1`,
+		"# TYPE apparatus_http_request_duration_seconds histogram",
+		`apparatus_http_request_duration_seconds_bucket{route="/runs/",method="GET",le="0.005"} 1`,
+		`apparatus_http_request_duration_seconds_bucket{route="/runs/",method="GET",le="0.025"} 2`,
+		`apparatus_http_request_duration_seconds_bucket{route="/runs/",method="GET",le="+Inf"} 3`,
+		`apparatus_http_request_duration_seconds_sum{route="/runs/",method="GET"} 3.021`,
+		`apparatus_http_request_duration_seconds_count{route="/runs/",method="GET"} 3`,
+		`apparatus_db_query_duration_seconds_bucket{operation="select",le="0.0025"} 1`,
+		"apparatus_artifact_upload_bytes_total 1024",
+		"apparatus_sse_connections 2",
+		`apparatus_artifact_cache_requests_total{result="hit"} 3`,
+		`apparatus_artifact_cache_requests_total{result="miss"} 1`,
+		"apparatus_artifact_cache_limit_bytes 0",
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("Expected output to contain %q, got:\n%s", line, out)
+		}
+	}
+}
+
+func TestLoggerMiddlewareObservesRoutes(t *testing.T) {
+	defer func(m *serverMetrics) { serverStats = m }(serverStats)
+	serverStats = newServerMetrics()
+
+	mux := http.NewServeMux()
+	mux.Handle("/runs/", LoggerMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+	for _, path := range []string{"/runs/a", "/runs/b"} {
+		mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	// Requests are counted by the pattern they matched, not their path
+	var b strings.Builder
+	serverStats.write(&b)
+	if line := `apparatus_http_requests_total{route="/runs/",method="GET",code="200"} 2`; !strings.Contains(b.String(), line) {
+		t.Errorf("Expected %q, got:\n%s", line, b.String())
+	}
+}
+
+func TestInstrumentedDB(t *testing.T) {
+	defer func(m *serverMetrics) { serverStats = m }(serverStats)
+	serverStats = newServerMetrics()
+
+	db, err := openInstrumentedDB("sqlite3", filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err := db.Exec("CREATE TABLE t (x INTEGER)"); err != nil {
+		t.Fatal(err)
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.Exec("INSERT INTO t (x) VALUES (?)", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	stmt, err := db.Prepare("UPDATE t SET x = ?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stmt.Close()
+	if _, err := stmt.Exec(1); err != nil {
+		t.Fatal(err)
+	}
+	var x int
+	if err := db.QueryRow("  select x FROM t WHERE x = ?", 1).Scan(&x); err != nil || x != 1 {
+		t.Fatalf("Expected to read back 1, got %d (err %v)", x, err)
+	}
+
+	for operation, want := range map[string]uint64{"other": 1, "insert": 1, "update": 1, "select": 1} {
+		if h := serverStats.dbQueries[operation]; h == nil || h.count != want {
+			t.Errorf("Expected %d %s statements timed, got %+v", want, operation, h)
+		}
+	}
+}
+
+func TestDBQueryTimeout(t *testing.T) {
+	defer func(timeout time.Duration) { dbQueryTimeout = timeout }(dbQueryTimeout)
+	db, err := openInstrumentedDB("sqlite3", filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	ctx := t.Context()
+
+	// Counting to a billion takes far longer than the timeout
+	dbQueryTimeout = 50 * time.Millisecond
+	var n int
+	err = db.QueryRowContext(ctx, "WITH RECURSIVE c(x) AS (SELECT 1 UNION ALL SELECT x + 1 FROM c WHERE x < 1000000000) SELECT count(*) FROM c").Scan(&n)
+	if err == nil {
+		t.Fatalf("Expected the query to be cancelled, got %d", n)
+	}
+
+	// Rows can be read until the timeout, then stop the timer when closed
+	dbQueryTimeout = time.Minute
+	rows, err := db.QueryContext(ctx, "SELECT 1 UNION ALL SELECT 2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if types, err := rows.ColumnTypes(); err != nil || len(types) != 1 {
+		t.Errorf("Expected the column types to be passed through, got %v (err %v)", types, err)
+	}
+	var sum int
+	for rows.Next() {
+		if err := rows.Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		sum += n
+	}
+	if err := rows.Close(); err != nil || sum != 3 {
+		t.Errorf("Expected to read 1 and 2, got a sum of %d (err %v)", sum, err)
+	}
+}
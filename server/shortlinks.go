@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// shortLinkPrefixLength is the number of UUID characters shown in a run's
+// short link. Shorter prefixes still resolve as long as they are unambiguous.
+const shortLinkPrefixLength = 8
+
+// shortRunUUID returns the UUID prefix used in a run's short link
+func shortRunUUID(uuid string) string {
+	if len(uuid) <= shortLinkPrefixLength {
+		return uuid
+	}
+	return uuid[:shortLinkPrefixLength]
+}
+
+// disambiguationTemplates are the templates of the page listing the runs a short link could mean
+var disambiguationTemplates = registerPage("templates/run_disambiguation.html")
+
+// handleResolveShortLink resolves /r/{name-or-uuid-prefix} to a run page. A
+// unique match redirects to the run; several matches render a disambiguation
+// page listing the candidates.
+func handleResolveShortLink(w http.ResponseWriter, r *http.Request) error {
+	query := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/r/"), "/")
+	if query == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "Missing run name or UUID prefix")
+		return nil
+	}
+
+	runs, err := dao.FindRunsByNameOrUUIDPrefix(r.Context(), query)
+	if err != nil {
+		return fmt.Errorf("failed to resolve short link %q: %w", query, err)
+	}
+
+	switch len(runs) {
+	case 0:
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "No run matches %q", query)
+		return nil
+	case 1:
+		http.Redirect(w, r, "/runs/"+runs[0].UUID, http.StatusFound)
+		return nil
+	}
+
+	data := struct {
+		Title string
+		Query string
+		Runs  []Run
+	}{
+		Title: query,
+		Query: query,
+		Runs:  runs,
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	tmpl, err := disambiguationTemplates.Get()
+	if err != nil {
+		return fmt.Errorf("failed to parse template: %w", err)
+	}
+	return executeTemplate(w, tmpl, "run_disambiguation.html", data)
+}
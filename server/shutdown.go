@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// shutdownTimeout is how long the server waits for in-flight requests, such
+// as metric and artifact uploads, to finish when asked to stop
+var shutdownTimeout = 30 * time.Second
+
+// serve serves the default mux on addr, over HTTPS if tlsConfig is not nil,
+// until the process receives SIGINT or SIGTERM, then shuts down gracefully.
+// A second signal stops the server without waiting.
+func serve(addr string, tlsConfig *tls.Config) {
+	srv := &http.Server{TLSConfig: tlsConfig}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Fatalf("Server failed to start: %v", err)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	if err := runServer(ctx, srv, ln); err != nil {
+		log.Fatalf("Server failed: %v", err)
+	}
+	closeServer()
+	log.Printf("Server stopped")
+}
+
+// serverTLSConfig configures HTTPS, over HTTP/2 or HTTP/1.1, with the
+// certificate in -tls-cert or one obtained for -acme-domains, or returns nil
+// to serve plain HTTP
+func serverTLSConfig(opts serverFlags) (*tls.Config, error) {
+	if *opts.tlsCert != "" {
+		cert, err := tls.LoadX509KeyPair(*opts.tlsCert, *opts.tlsKey)
+		if err != nil {
+			return nil, err
+		}
+		return &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			NextProtos:   []string{"h2", "http/1.1"},
+			MinVersion:   tls.VersionTLS12,
+		}, nil
+	}
+	if domains := parseACMEDomains(*opts.acmeDomains); len(domains) > 0 {
+		m, err := newACMEManager(*opts.acmeDirectory, *opts.acmeEmail, domains, *opts.acmeCacheDir)
+		if err != nil {
+			return nil, err
+		}
+		return acmeTLSConfig(m), nil
+	}
+	return nil, nil
+}
+
+// serverURL is where the server can be reached when listening on addr, for
+// the startup log
+func serverURL(addr string, opts serverFlags, https bool) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	if domains := parseACMEDomains(*opts.acmeDomains); len(domains) > 0 {
+		host = domains[0]
+	} else if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "localhost"
+	}
+	u := url.URL{Scheme: "http", Host: net.JoinHostPort(host, port)}
+	if https {
+		u.Scheme = "https"
+	}
+	if u.Scheme == "http" && port == "80" || u.Scheme == "https" && port == "443" {
+		u.Host = strings.TrimSuffix(u.Host, ":"+port)
+	}
+	return u.String()
+}
+
+// runServer serves on ln, over TLS if srv has a TLSConfig, until ctx is done,
+// then stops accepting connections and waits up to shutdownTimeout for
+// in-flight requests to finish before closing the ones that remain
+func runServer(ctx context.Context, srv *http.Server, ln net.Listener) error {
+	// Run event and metric streams never finish on their own
+	srv.RegisterOnShutdown(runEvents.Close)
+	srv.RegisterOnShutdown(metricStreams.Close)
+
+	served := make(chan error, 1)
+	go func() {
+		if srv.TLSConfig != nil {
+			served <- srv.ServeTLS(ln, "", "")
+		} else {
+			served <- srv.Serve(ln)
+		}
+	}()
+	select {
+	case err := <-served:
+		return err
+	case <-ctx.Done():
+	}
+
+	log.Printf("Shutting down, waiting up to %s for in-flight requests", shutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("In-flight requests did not finish in time, closing them: %v", err)
+		srv.Close()
+	}
+	// Shutdown doesn't track metric streams, which have taken over their
+	// connections; they flush their buffered points before closing
+	metricStreams.Wait(shutdownCtx)
+	if err := <-served; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// closeServer flushes buffered writes and releases the server's resources
+// once it has stopped serving requests
+func closeServer() {
+	if journal != nil {
+		if err := journal.Close(); err != nil {
+			log.Printf("Failed to close ingestion journal: %v", err)
+		}
+	}
+	if db != nil {
+		if err := db.Close(); err != nil {
+			log.Printf("Failed to close database: %v", err)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRunServerDrainsInFlightRequests(t *testing.T) {
+	defer func(h *runEventHub) { runEvents = h }(runEvents)
+	runEvents = newRunEventHub()
+	events, unsubscribe := runEvents.Subscribe(1)
+	defer unsubscribe()
+
+	started, release := make(chan struct{}), make(chan struct{})
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		w.Write([]byte("uploaded"))
+	})}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- runServer(ctx, srv, ln)
+	}()
+
+	responses := make(chan int, 1)
+	go func() {
+		resp, err := http.Post("http://"+ln.Addr().String()+"/api/artifacts", "text/plain", nil)
+		if err != nil {
+			responses <- 0
+			return
+		}
+		resp.Body.Close()
+		responses <- resp.StatusCode
+	}()
+	<-started
+	cancel()
+
+	// Event streams are ended, and new connections refused, while the upload
+	// is still being served
+	if _, ok := <-events; ok {
+		t.Error("Expected run event streams to be ended on shutdown")
+	}
+	for i := 0; ; i++ {
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			break
+		}
+		conn.Close()
+		if i == 100 {
+			t.Fatal("Expected new connections to be refused on shutdown")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	select {
+	case err := <-stopped:
+		t.Fatalf("Expected the server to wait for the in-flight request, stopped with %v", err)
+	default:
+	}
+
+	close(release)
+	if code := <-responses; code != http.StatusOK {
+		t.Errorf("Expected the in-flight request to finish, got %d", code)
+	}
+	if err := <-stopped; err != nil {
+		t.Errorf("Expected a clean shutdown, got %v", err)
+	}
+}
+
+func TestRunServerClosesRequestsAfterShutdownTimeout(t *testing.T) {
+	defer func(h *runEventHub, d time.Duration) { runEvents, shutdownTimeout = h, d }(runEvents, shutdownTimeout)
+	runEvents = newRunEventHub()
+	shutdownTimeout = 50 * time.Millisecond
+
+	started, release := make(chan struct{}), make(chan struct{})
+	defer close(release)
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	})}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- runServer(ctx, srv, ln)
+	}()
+	go http.Get("http://" + ln.Addr().String() + "/")
+	<-started
+	cancel()
+
+	select {
+	case err := <-stopped:
+		if err != nil {
+			t.Errorf("Expected the server to stop cleanly, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the server to stop after the shutdown timeout")
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// runQuietAfter is how long a running run may go without logging a param or
+// metric before the status strip raises an alert about it, well before the
+// stale run detector would mark it FAILED
+var runQuietAfter = 10 * time.Minute
+
+// runHealthCacheTTL is how long the status strip's counts are reused, so
+// that rendering it on every page load stays cheap
+var runHealthCacheTTL = 30 * time.Second
+
+// runHealthCache keeps the latest run health counts in memory
+type runHealthCache struct {
+	mu       sync.Mutex
+	loadedAt time.Time
+	health   *RunHealthRow
+}
+
+var healthCache = &runHealthCache{}
+
+// get returns the cached run health counts, loading them from d if they are
+// older than runHealthCacheTTL
+func (c *runHealthCache) get(ctx context.Context, d DAO, now time.Time) (*RunHealthRow, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.health != nil && now.Sub(c.loadedAt) < runHealthCacheTTL {
+		return c.health, nil
+	}
+	health, err := d.GetRunHealth(ctx, now.Add(-24*time.Hour), now.Add(-runQuietAfter))
+	if err != nil {
+		return nil, err
+	}
+	c.health = health
+	c.loadedAt = now
+	return health, nil
+}
+
+// StatusStrip is the run health summary shown in every page's header
+type StatusStrip struct {
+	*RunHealthRow
+	QuietAfter time.Duration
+}
+
+// RunningURL lists the running runs, including those behind alerts
+func (s StatusStrip) RunningURL() string {
+	return "/?" + url.Values{"q": {"status = " + runStatusRunning}}.Encode()
+}
+
+// FailedURL lists the failed runs
+func (s StatusStrip) FailedURL() string {
+	return "/?" + url.Values{"q": {"status = " + runStatusFailed}}.Encode()
+}
+
+// statusStripTemplates are the templates of the status strip
+var statusStripTemplates = registerTemplates("templates/status_strip.html")
+
+// handleStatusStrip renders the status strip, which the header loads and
+// refreshes with htmx
+func handleStatusStrip(w http.ResponseWriter, r *http.Request) error {
+	health, err := healthCache.get(r.Context(), dao, time.Now())
+	if err != nil {
+		return fmt.Errorf("failed to count runs: %w", err)
+	}
+	tmpl, err := statusStripTemplates.Get()
+	if err != nil {
+		return fmt.Errorf("failed to parse template: %w", err)
+	}
+	return executeTemplate(w, tmpl, "status_strip.html", StatusStrip{RunHealthRow: health, QuietAfter: runQuietAfter})
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// runHealthDAO counts the run health queries made
+type runHealthDAO struct {
+	DAO
+	health  RunHealthRow
+	queries int
+}
+
+func (d *runHealthDAO) GetRunHealth(ctx context.Context, failedSince, quietBefore time.Time) (*RunHealthRow, error) {
+	d.queries++
+	health := d.health
+	return &health, nil
+}
+
+func TestRunHealthCache(t *testing.T) {
+	ctx := t.Context()
+	backing := &runHealthDAO{health: RunHealthRow{Running: 3}}
+	cache := &runHealthCache{}
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		if health, err := cache.get(ctx, backing, now); err != nil || health.Running != 3 {
+			t.Fatalf("get = %+v, %v", health, err)
+		}
+	}
+	if backing.queries != 1 {
+		t.Errorf("Expected a single query, got %d", backing.queries)
+	}
+	cache.get(ctx, backing, now.Add(runHealthCacheTTL))
+	if backing.queries != 2 {
+		t.Errorf("Expected a reload after the TTL expired, got %d queries", backing.queries)
+	}
+}
+
+func TestHandleStatusStrip(t *testing.T) {
+	defer func(d DAO, c *runHealthCache) { dao, healthCache = d, c }(dao, healthCache)
+	dao = &runHealthDAO{health: RunHealthRow{Running: 4, Failed: 2, Quiet: 1}}
+	healthCache = &runHealthCache{}
+
+	w := httptest.NewRecorder()
+	errorHandler(handleStatusStrip).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/status-strip", nil))
+	body := w.Body.String()
+	for _, want := range []string{
+		`href="/?q=status&#43;%3D&#43;RUNNING">4 running`,
+		`status-badge-failed`,
+		`2 failed in 24h`,
+		`1 alert</a>`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Expected %q in the status strip, got %s", want, body)
+		}
+	}
+
+	// Alerts are only shown when there are any
+	dao = &runHealthDAO{health: RunHealthRow{Running: 1}}
+	healthCache = &runHealthCache{}
+	w = httptest.NewRecorder()
+	errorHandler(handleStatusStrip).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/status-strip", nil))
+	if body := w.Body.String(); strings.Contains(body, "alert") || strings.Contains(body, "status-badge-failed") {
+		t.Errorf("Expected neither alerts nor failures, got %s", body)
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// System metrics are the GPU utilization, memory, CPU and so on that the
+// client SDK samples while a run trains. They are kept under the reserved
+// system/ namespace, apart from the metrics a run logs itself: they are left
+// out of the metrics table, metric search and schema keys, and shown on the
+// run page's System tab instead. They are logged in batches of timestamped
+// samples at POST /api/system-metrics, since a collector samples many keys
+// every few seconds.
+
+// systemMetricPrefix is the reserved namespace of system metric keys
+const systemMetricPrefix = "system/"
+
+// maxSystemMetricPoints is the most points POST /api/system-metrics logs at once
+const maxSystemMetricPoints = 50000
+
+// isSystemMetricKey reports whether key is in the reserved system/ namespace
+func isSystemMetricKey(key string) bool {
+	return strings.HasPrefix(key, systemMetricPrefix)
+}
+
+// SystemMetricSample is the values of several system metrics sampled at one
+// time, in epoch milliseconds. Keys may leave out the system/ prefix.
+type SystemMetricSample struct {
+	T      int64              `json:"t"`
+	Values map[string]float64 `json:"values"`
+}
+
+// systemMetricPoints converts samples to metric points, with x the sample
+// time in epoch seconds, so the series of every key share an axis
+func systemMetricPoints(samples []SystemMetricSample) ([]MetricRow, error) {
+	var points []MetricRow
+	for i, s := range samples {
+		if s.T <= 0 {
+			return nil, fmt.Errorf("sample %d is missing its time", i+1)
+		}
+		keys := make([]string, 0, len(s.Values))
+		for key := range s.Values {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			name := strings.TrimPrefix(strings.TrimSpace(key), systemMetricPrefix)
+			if name == "" {
+				return nil, fmt.Errorf("sample %d has an empty key", i+1)
+			}
+			points = append(points, MetricRow{
+				Key:      systemMetricPrefix + name,
+				XValue:   float64(s.T) / 1000,
+				YValue:   s.Values[key],
+				LoggedAt: time.UnixMilli(s.T),
+			})
+		}
+	}
+	if len(points) == 0 {
+		return nil, fmt.Errorf("samples is empty")
+	}
+	if len(points) > maxSystemMetricPoints {
+		return nil, fmt.Errorf("at most %d points can be logged at once, got %d", maxSystemMetricPoints, len(points))
+	}
+	return points, nil
+}
+
+// LogSystemMetricsRequest is the body of POST /api/system-metrics
+type LogSystemMetricsRequest struct {
+	RunUUID string               `json:"run_uuid"`
+	Samples []SystemMetricSample `json:"samples"`
+}
+
+// handleAPILogSystemMetrics logs a batch of system metric samples of a run at
+// POST /api/system-metrics, e.g. {"run_uuid": "...", "samples": [{"t":
+// 1700000000000, "values": {"gpu_utilization": 87.5}}]}. Unlike POST
+// /api/metrics, no run events are published, so open run pages don't reload
+// their metrics every few seconds.
+func handleAPILogSystemMetrics(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+
+	var req LogSystemMetricsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid JSON"})
+		return
+	}
+	var missing []string
+	if req.RunUUID == "" {
+		missing = append(missing, "run_uuid")
+	}
+	if req.Samples == nil {
+		missing = append(missing, "samples")
+	}
+	if len(missing) > 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":          "Missing required fields",
+			"missing_fields": missing,
+		})
+		return
+	}
+	points, err := systemMetricPoints(req.Samples)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": fmt.Sprintf("Invalid samples: %v", err)})
+		return
+	}
+
+	runID, err := dao.GetRunIDByUUID(ctx, req.RunUUID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Run not found"})
+		return
+	}
+	experimentID, err := dao.GetRunExperimentID(ctx, runID)
+	if err == nil && !quotaExempt(r) {
+		err = checkExperimentQuota(ctx, experimentID, 0, int64(len(points)), 0)
+	}
+	if writeQuotaError(w, err) {
+		return
+	}
+
+	if err := dao.InsertSystemMetrics(ctx, runID, points); err != nil {
+		log.Printf("Error inserting system metrics of run %s: %v", req.RunUUID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to insert system metrics"})
+		return
+	}
+	recordMetricPointUsage(ctx, experimentID, len(points))
+	recordRunActivity(ctx, runID)
+
+	for _, p := range points {
+		if isGPUMetricKey(p.Key) {
+			if err := updateRunGPUSummary(ctx, runID); err != nil {
+				log.Printf("Failed to update GPU summary for run %s: %v", req.RunUUID, err)
+			}
+			break
+		}
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": "ok", "count": len(points)})
+}
+
+// SystemMetricChart is a chart of the System tab, one line per key
+type SystemMetricChart struct {
+	Title string
+	Unit  string
+	Keys  []string
+}
+
+// SystemMetricsView is the System tab of the run page
+type SystemMetricsView struct {
+	UUID   string
+	Charts []SystemMetricChart
+}
+
+// systemMetricCharts groups a run's system metric keys into GPU, CPU and
+// memory charts, and one chart for each other key
+func systemMetricCharts(latest []MetricRow) []SystemMetricChart {
+	gpu := SystemMetricChart{Title: "GPU utilization", Unit: "%"}
+	gpuMemory := SystemMetricChart{Title: "GPU memory", Unit: "bytes"}
+	cpu := SystemMetricChart{Title: "CPU", Unit: "%"}
+	memory := SystemMetricChart{Title: "Memory", Unit: "bytes"}
+	var other []SystemMetricChart
+	for _, m := range latest {
+		name := strings.TrimPrefix(m.Key, systemMetricPrefix)
+		switch {
+		case m.Key == gpuCountMetricKey:
+			other = append(other, SystemMetricChart{Title: "GPU count", Keys: []string{m.Key}})
+		case strings.HasPrefix(name, "gpu") && strings.Contains(name, "memory"):
+			gpuMemory.Keys = append(gpuMemory.Keys, m.Key)
+		case strings.HasPrefix(name, "gpu"):
+			gpu.Keys = append(gpu.Keys, m.Key)
+		case strings.HasPrefix(name, "cpu"):
+			cpu.Keys = append(cpu.Keys, m.Key)
+		case strings.HasPrefix(name, "memory"):
+			memory.Keys = append(memory.Keys, m.Key)
+		default:
+			other = append(other, SystemMetricChart{Title: name, Keys: []string{m.Key}})
+		}
+	}
+	var charts []SystemMetricChart
+	for _, c := range []SystemMetricChart{gpu, gpuMemory, cpu, memory} {
+		if len(c.Keys) > 0 {
+			charts = append(charts, c)
+		}
+	}
+	return append(charts, other...)
+}
+
+// runSystemTemplates are the templates of the System tab of the run page
+var runSystemTemplates = registerTemplates("templates/run_system.html")
+
+// handleRunSystem renders the System tab of the run page, charting the
+// run's system metrics over time
+func handleRunSystem(w http.ResponseWriter, r *http.Request, runUUID string) error {
+	ctx := r.Context()
+	runID, err := dao.GetRunIDByUUID(ctx, runUUID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "Run not found")
+		return nil
+	}
+	latest, err := dao.GetLatestSystemMetricsByRunID(ctx, runID)
+	if err != nil {
+		return fmt.Errorf("failed to query system metrics of run %s: %w", runUUID, err)
+	}
+
+	tmpl, err := runSystemTemplates.Get()
+	if err != nil {
+		return fmt.Errorf("failed to parse template: %w", err)
+	}
+	return executeTemplate(w, tmpl, "run_system.html", SystemMetricsView{UUID: runUUID, Charts: systemMetricCharts(latest)})
+}
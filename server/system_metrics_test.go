@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// systemMetricsDAO records the system metrics logged to one run
+type systemMetricsDAO struct {
+	DAO
+	points []MetricRow
+}
+
+func (d *systemMetricsDAO) GetRunIDByUUID(ctx context.Context, uuid string) (int, error) {
+	if uuid != "run-1" {
+		return 0, sql.ErrNoRows
+	}
+	return 1, nil
+}
+
+func (d *systemMetricsDAO) GetRunExperimentID(ctx context.Context, runID int) (int, error) {
+	return 1, nil
+}
+
+func (d *systemMetricsDAO) GetExperimentQuota(ctx context.Context, experimentID int) (*ExperimentQuotaRow, error) {
+	return nil, nil
+}
+
+func (d *systemMetricsDAO) InsertSystemMetrics(ctx context.Context, runID int, points []MetricRow) error {
+	d.points = append(d.points, points...)
+	return nil
+}
+
+func (d *systemMetricsDAO) AddExperimentMetricPoints(ctx context.Context, experimentID int, day string, points int) error {
+	return nil
+}
+
+func (d *systemMetricsDAO) RecordRunActivity(ctx context.Context, runID int, at time.Time) error {
+	return nil
+}
+
+func (d *systemMetricsDAO) GetMetricsByRunID(ctx context.Context, runID int) ([]MetricRow, error) {
+	return d.points, nil
+}
+
+func (d *systemMetricsDAO) UpsertRunGPUSummary(ctx context.Context, row RunGPUSummaryRow) error {
+	return nil
+}
+
+func (d *systemMetricsDAO) GetLatestSystemMetricsByRunID(ctx context.Context, runID int) ([]MetricRow, error) {
+	latest := make(map[string]MetricRow)
+	var keys []string
+	for _, p := range d.points {
+		if _, ok := latest[p.Key]; !ok {
+			keys = append(keys, p.Key)
+		}
+		latest[p.Key] = p
+	}
+	var rows []MetricRow
+	for _, key := range keys {
+		rows = append(rows, latest[key])
+	}
+	return rows, nil
+}
+
+func TestHandleAPILogSystemMetrics(t *testing.T) {
+	defer func(d DAO) { dao = d }(dao)
+	fake := &systemMetricsDAO{}
+	dao = fake
+
+	post := func(body string) int {
+		w := httptest.NewRecorder()
+		handleAPILogSystemMetrics(w, httptest.NewRequest(http.MethodPost, "/api/system-metrics", strings.NewReader(body)))
+		return w.Code
+	}
+	code := post(`{"run_uuid": "run-1", "samples": [
+		{"t": 1700000000000, "values": {"gpu_utilization": 80, "system/cpu_percent": 40}},
+		{"t": 1700000005000, "values": {"gpu_utilization": 90}}]}`)
+	if code != http.StatusOK || len(fake.points) != 3 {
+		t.Fatalf("Expected 3 points logged, got %d %+v", code, fake.points)
+	}
+	if p := fake.points[1]; p.Key != "system/cpu_percent" || p.XValue != 1700000000 || !p.LoggedAt.Equal(time.UnixMilli(1700000000000)) {
+		t.Errorf("Expected keys prefixed and x in epoch seconds, got %+v", p)
+	}
+	if p := fake.points[2]; p.Key != "system/gpu_utilization" || p.YValue != 90 {
+		t.Errorf("Expected the second sample's utilization, got %+v", p)
+	}
+
+	for _, invalid := range []string{
+		`{"run_uuid": "run-1", "samples": []}`,
+		`{"run_uuid": "run-1", "samples": [{"values": {"gpu_utilization": 1}}]}`,
+		`{"run_uuid": "run-1", "samples": [{"t": 1, "values": {"system/": 1}}]}`,
+		`{"samples": [{"t": 1, "values": {"gpu_utilization": 1}}]}`,
+	} {
+		if code := post(invalid); code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for %s, got %d", invalid, code)
+		}
+	}
+	if len(fake.points) != 3 {
+		t.Errorf("Expected no points logged from invalid batches, got %+v", fake.points)
+	}
+	if code := post(`{"run_uuid": "missing", "samples": [{"t": 1, "values": {"cpu_percent": 1}}]}`); code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a missing run, got %d", code)
+	}
+}
+
+func TestHandleRunSystem(t *testing.T) {
+	defer func(d DAO) { dao = d }(dao)
+	dao = &systemMetricsDAO{points: []MetricRow{
+		{Key: "system/gpu_utilization"},
+		{Key: "system/gpu_memory_bytes"},
+		{Key: "system/cpu_percent"},
+		{Key: "system/memory_bytes"},
+		{Key: "system/disk_read_bytes"},
+	}}
+
+	charts := systemMetricCharts(dao.(*systemMetricsDAO).points)
+	var titles []string
+	for _, c := range charts {
+		titles = append(titles, c.Title)
+	}
+	if got := strings.Join(titles, ","); got != "GPU utilization,GPU memory,CPU,Memory,disk_read_bytes" {
+		t.Errorf("Expected GPU, CPU and memory charts before the rest, got %s", got)
+	}
+
+	w := httptest.NewRecorder()
+	if err := handleRunSystem(w, httptest.NewRequest(http.MethodGet, "/runs/run-1/system", nil), "run-1"); err != nil {
+		t.Fatal(err)
+	}
+	if body := w.Body.String(); !strings.Contains(body, `data-keys="system/gpu_utilization"`) || strings.Contains(body, "system-empty") {
+		t.Errorf("Expected a chart per group, got %s", body)
+	}
+
+	dao = &systemMetricsDAO{}
+	w = httptest.NewRecorder()
+	if err := handleRunSystem(w, httptest.NewRequest(http.MethodGet, "/runs/run-1/system", nil), "run-1"); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(w.Body.String(), "system-empty") {
+		t.Errorf("Expected a note for a run without system metrics, got %s", w.Body.String())
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Limits on tag keys and values
+const (
+	tagKeyMaxLength   = 128
+	tagValueMaxLength = 256
+)
+
+// validTagKeyPattern matches tag keys, which may not contain whitespace, '='
+// or quotes so that they can be written in the tag filter box
+var validTagKeyPattern = regexp.MustCompile(`^[a-zA-Z0-9_.\-/:]+$`)
+
+// validateTag checks a tag's key and value
+func validateTag(key, value string) error {
+	if key == "" {
+		return fmt.Errorf("tag key cannot be empty")
+	}
+	if len(key) > tagKeyMaxLength {
+		return fmt.Errorf("tag key is longer than %d characters", tagKeyMaxLength)
+	}
+	if !validTagKeyPattern.MatchString(key) {
+		return fmt.Errorf("tag key contains invalid characters (allowed: alphanumerics, hyphens, underscores, dots, slashes, colons)")
+	}
+	if len(value) > tagValueMaxLength {
+		return fmt.Errorf("tag value is longer than %d characters", tagValueMaxLength)
+	}
+	if !utf8.ValidString(value) || strings.ContainsAny(value, "\"\n\r") {
+		return fmt.Errorf("tag value must be a single line of UTF-8 without double quotes")
+	}
+	return nil
+}
+
+// FilterTerm is the term of the tag filter box that matches runs with this
+// tag, e.g. baseline, team=vision, or team="computer vision"
+func (t RunTagRow) FilterTerm() string {
+	if t.Value == "" {
+		return t.Key
+	}
+	if strings.ContainsFunc(t.Value, unicode.IsSpace) {
+		return t.Key + `="` + t.Value + `"`
+	}
+	return t.Key + "=" + t.Value
+}
+
+// parseTagFilters parses the tag filter box: whitespace-separated terms that
+// runs must all match, each either a tag key, matching any value, or
+// key=value. Values containing whitespace are written in double quotes.
+func parseTagFilters(s string) []TagFilter {
+	var terms []string
+	var term strings.Builder
+	inQuotes := false
+	for _, c := range s {
+		switch {
+		case c == '"':
+			inQuotes = !inQuotes
+		case unicode.IsSpace(c) && !inQuotes:
+			if term.Len() > 0 {
+				terms = append(terms, term.String())
+				term.Reset()
+			}
+		default:
+			term.WriteRune(c)
+		}
+	}
+	if term.Len() > 0 {
+		terms = append(terms, term.String())
+	}
+
+	var filters []TagFilter
+	for _, term := range terms {
+		key, value, hasValue := strings.Cut(term, "=")
+		if key == "" {
+			continue
+		}
+		filters = append(filters, TagFilter{Key: key, Value: value, HasValue: hasValue})
+	}
+	return filters
+}
+
+// handleAPITags lists (GET), sets (POST), or removes (DELETE) the tags of a run
+func handleAPITags(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		handleAPIListTags(w, r)
+	case http.MethodPost:
+		handleAPISetTag(w, r)
+	case http.MethodDelete:
+		handleAPIDeleteTag(w, r)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func handleAPIListTags(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	w.Header().Set("Content-Type", "application/json")
+	runUUID := r.URL.Query().Get("run_uuid")
+	if runUUID == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Missing required field: run_uuid"})
+		return
+	}
+	runID, err := dao.GetRunIDByUUID(ctx, runUUID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Run not found"})
+		return
+	}
+
+	tags, err := dao.GetRunTags(ctx, runID)
+	if err != nil {
+		log.Printf("Failed to query tags for run %s: %v", runUUID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to query tags"})
+		return
+	}
+	result := make(map[string]string, len(tags))
+	for _, t := range tags {
+		result[t.Key] = t.Value
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"tags": result})
+}
+
+// SetTagRequest is the body of POST /api/tags
+type SetTagRequest struct {
+	RunUUID string `json:"run_uuid"`
+	Key     string `json:"key"`
+	Value   string `json:"value"`
+}
+
+func handleAPISetTag(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var req SetTagRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid JSON"})
+		return
+	}
+
+	var missing []string
+	if req.RunUUID == "" {
+		missing = append(missing, "run_uuid")
+	}
+	if req.Key == "" {
+		missing = append(missing, "key")
+	}
+	if len(missing) > 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":          "Missing required fields",
+			"missing_fields": missing,
+		})
+		return
+	}
+
+	if err := validateTag(req.Key, req.Value); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": fmt.Sprintf("Invalid tag: %v", err)})
+		return
+	}
+
+	runID, err := dao.GetRunIDByUUID(ctx, req.RunUUID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Run not found"})
+		return
+	}
+
+	if err := dao.SetRunTag(ctx, runID, req.Key, req.Value); err != nil {
+		log.Printf("Error saving tag: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to save tag"})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+
+func handleAPIDeleteTag(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	runUUID := r.URL.Query().Get("run_uuid")
+	key := r.URL.Query().Get("key")
+
+	var missing []string
+	if runUUID == "" {
+		missing = append(missing, "run_uuid")
+	}
+	if key == "" {
+		missing = append(missing, "key")
+	}
+	if len(missing) > 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":          "Missing required fields",
+			"missing_fields": missing,
+		})
+		return
+	}
+
+	runID, err := dao.GetRunIDByUUID(ctx, runUUID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Run not found"})
+		return
+	}
+
+	deleted, err := dao.DeleteRunTag(ctx, runID, key)
+	if err != nil {
+		log.Printf("Error deleting tag: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to delete tag"})
+		return
+	}
+	if !deleted {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Tag not found"})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
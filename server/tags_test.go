@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestValidateTag(t *testing.T) {
+	tests := []struct {
+		key, value string
+		wantErr    bool
+	}{
+		{"team", "vision", false},
+		{"baseline", "", false},
+		{"data/split:v2", "computer vision", false},
+		{"", "x", true},
+		{"bad key", "x", true},
+		{"key=value", "", true},
+		{"team", "two\nlines", true},
+		{"team", `"quoted"`, true},
+	}
+	for _, tt := range tests {
+		err := validateTag(tt.key, tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("validateTag(%q, %q) error = %v, wantErr %v", tt.key, tt.value, err, tt.wantErr)
+		}
+	}
+}
+
+func TestParseTagFilters(t *testing.T) {
+	tests := []struct {
+		input string
+		want  []TagFilter
+	}{
+		{"", nil},
+		{"baseline", []TagFilter{{Key: "baseline"}}},
+		{"  team=vision   baseline ", []TagFilter{{Key: "team", Value: "vision", HasValue: true}, {Key: "baseline"}}},
+		{`team="computer vision"`, []TagFilter{{Key: "team", Value: "computer vision", HasValue: true}}},
+		{"note=", []TagFilter{{Key: "note", HasValue: true}}},
+		{"=orphan", nil},
+	}
+	for _, tt := range tests {
+		if got := parseTagFilters(tt.input); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseTagFilters(%q) = %+v, want %+v", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestTagFilterTermRoundTrip(t *testing.T) {
+	for _, tag := range []RunTagRow{{Key: "baseline"}, {Key: "team", Value: "vision"}, {Key: "team", Value: "computer vision"}} {
+		filters := parseTagFilters(tag.FilterTerm())
+		if len(filters) != 1 || filters[0].Key != tag.Key || filters[0].Value != tag.Value || filters[0].HasValue != (tag.Value != "") {
+			t.Errorf("FilterTerm() of %+v = %q, which parses to %+v", tag, tag.FilterTerm(), filters)
+		}
+	}
+}
+
+func TestRunFilterWhere(t *testing.T) {
+	where, args := runFilterWhere(RunFilter{}, func(int) string { return "?" })
+	if where != "" || args != nil {
+		t.Errorf("Expected no WHERE clause for an empty filter, got %q %v", where, args)
+	}
+
+	filter := RunFilter{Tags: []TagFilter{{Key: "baseline"}, {Key: "team", Value: "vision", HasValue: true}}}
+	where, args = runFilterWhere(filter, func(n int) string { return fmt.Sprintf("$%d", n) })
+	want := "WHERE EXISTS (SELECT 1 FROM tags t WHERE t.run_id = r.id AND t.key = $1) AND " +
+		"EXISTS (SELECT 1 FROM tags t WHERE t.run_id = r.id AND t.key = $2 AND t.value = $3)"
+	if where != want {
+		t.Errorf("runFilterWhere() = %q, want %q", where, want)
+	}
+	if !reflect.DeepEqual(args, []interface{}{"baseline", "team", "vision"}) {
+		t.Errorf("runFilterWhere() args = %v", args)
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"io/fs"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// devTemplates makes template sets read their files again from disk when
+// they change, for editing templates without restarting the server
+var devTemplates bool
+
+// displayTimeLayout is how pages show times
+const displayTimeLayout = "2006-01-02 15:04:05"
+
+// templateFuncs are the functions every template can call
+var templateFuncs = template.FuncMap{
+	"markdown":      renderMarkdown,
+	"formatTime":    formatTime,
+	"formatNumber":  formatNumber,
+	"gpuSummary":    formatGPUSummary,
+	"usd":           formatUSD,
+	"pathEscape":    url.PathEscape,
+	"formatBytes":   formatBytes,
+	"hash":          hashString,
+	"embeddingsKey": embeddingsKey,
+	"formatAUC":     func(auc float64) string { return fmt.Sprintf("%.4f", auc) },
+	"percent":       func(fraction float64) string { return fmt.Sprintf("%.1f%%", fraction*100) },
+}
+
+// formatTime formats a time for display, or returns "" for the zero time
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(displayTimeLayout)
+}
+
+// formatNumber formats a number for display: whole numbers with thousands
+// separators, and other floats to four significant digits
+func formatNumber(v any) string {
+	var n int64
+	switch v := v.(type) {
+	case int:
+		n = int64(v)
+	case int64:
+		n = v
+	case float64:
+		if v != float64(int64(v)) || v >= 1e15 || v <= -1e15 {
+			return strconv.FormatFloat(v, 'g', 4, 64)
+		}
+		n = int64(v)
+	default:
+		return fmt.Sprint(v)
+	}
+
+	digits := strconv.FormatInt(n, 10)
+	sign := ""
+	if n < 0 {
+		sign, digits = "-", digits[1:]
+	}
+	var b strings.Builder
+	for i, d := range digits {
+		if i > 0 && (len(digits)-i)%3 == 0 {
+			b.WriteByte(',')
+		}
+		b.WriteRune(d)
+	}
+	return sign + b.String()
+}
+
+// templateSet is template files parsed together, such as a page and the
+// fragments it includes. A set is parsed once, when the server starts or on
+// first use, and shared by every request.
+type templateSet struct {
+	files []string
+
+	mu   sync.Mutex
+	tmpl *template.Template
+	// modTimes are when the files were modified as of parsing them, for
+	// -dev to tell when they change
+	modTimes []time.Time
+}
+
+// templateSets are the registered template sets
+var templateSets []*templateSet
+
+// registerTemplates registers a set of template files, named by their path
+// in templateFS
+func registerTemplates(files ...string) *templateSet {
+	s := &templateSet{files: files}
+	templateSets = append(templateSets, s)
+	return s
+}
+
+// layoutTemplate is the layout every page renders: the document, its <head>
+// and the header above the page. Pages fill its blocks, "content" and
+// optionally "head", and begin with {{template "layout.html" .}}.
+const layoutTemplate = "templates/layout.html"
+
+// registerPage registers a page with the layout and the fragments it includes
+func registerPage(page string, fragments ...string) *templateSet {
+	return registerTemplates(append([]string{layoutTemplate, page}, fragments...)...)
+}
+
+// loadTemplates parses every registered template set, so that mistakes in
+// templates stop the server from starting rather than failing requests
+func loadTemplates() {
+	for _, s := range templateSets {
+		if _, err := s.Get(); err != nil {
+			log.Fatalf("Failed to parse templates: %v", err)
+		}
+	}
+}
+
+// Get returns the set's parsed templates. With -dev, they are parsed again if
+// any of their files has changed.
+func (s *templateSet) Get() (*template.Template, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.tmpl != nil && !(devTemplates && s.changed()) {
+		return s.tmpl, nil
+	}
+
+	var fsys fs.FS = templateFS
+	if devTemplates {
+		// Read the source tree even when templates are embedded
+		fsys = os.DirFS(".")
+	}
+	tmpl, err := template.New(path.Base(s.files[0])).Funcs(templateFuncs).ParseFS(fsys, s.files...)
+	if err != nil {
+		return nil, err
+	}
+	s.tmpl = tmpl
+	if devTemplates {
+		s.modTimes = templateModTimes(fsys, s.files)
+	}
+	return tmpl, nil
+}
+
+// changed reports whether any of the set's files were modified since they
+// were parsed. s.mu must be held.
+func (s *templateSet) changed() bool {
+	modTimes := templateModTimes(os.DirFS("."), s.files)
+	for i, t := range modTimes {
+		if i >= len(s.modTimes) || !t.Equal(s.modTimes[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+// templateModTimes returns when each file was modified, or the zero time for
+// files that cannot be read
+func templateModTimes(fsys fs.FS, files []string) []time.Time {
+	modTimes := make([]time.Time, len(files))
+	for i, name := range files {
+		if info, err := fs.Stat(fsys, name); err == nil {
+			modTimes[i] = info.ModTime()
+		}
+	}
+	return modTimes
+}
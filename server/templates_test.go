@@ -0,0 +1,120 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTemplateSetsParse(t *testing.T) {
+	for _, s := range templateSets {
+		if _, err := s.Get(); err != nil {
+			t.Errorf("Failed to parse %v: %v", s.files, err)
+		}
+	}
+}
+
+func TestTemplateSetParsesOnce(t *testing.T) {
+	s := &templateSet{files: []string{"templates/status_strip.html"}}
+	first, err := s.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second, _ := s.Get(); second != first {
+		t.Errorf("Expected the parsed templates to be reused")
+	}
+}
+
+func TestTemplateSetDevReload(t *testing.T) {
+	defer func(dev bool) { devTemplates = dev }(devTemplates)
+	devTemplates = true
+	t.Chdir(t.TempDir())
+	if err := os.Mkdir("templates", 0o755); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join("templates", "page.html")
+	write := func(contents string, modTime time.Time) {
+		if err := os.WriteFile(file, []byte(contents), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(file, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	render := func(s *templateSet) string {
+		tmpl, err := s.Get()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var b strings.Builder
+		if err := tmpl.ExecuteTemplate(&b, "page.html", nil); err != nil {
+			t.Fatal(err)
+		}
+		return b.String()
+	}
+
+	s := &templateSet{files: []string{"templates/page.html"}}
+	now := time.Now()
+	write("before", now.Add(-time.Minute))
+	if got := render(s); got != "before" {
+		t.Errorf("Expected the template rendered, got %q", got)
+	}
+	write("after {{percent 0.5}}", now)
+	if got := render(s); got != "after 50.0%" {
+		t.Errorf("Expected the edited template rendered, got %q", got)
+	}
+}
+
+func TestRegisterPage(t *testing.T) {
+	tmpl, err := compareTemplates.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b strings.Builder
+	if err := tmpl.ExecuteTemplate(&b, "compare.html", struct {
+		Title         string
+		Runs          []ComparedRun
+		Curves        []CurveComparison
+		ExportCSVURL  string
+		ExportJSONURL string
+	}{Title: "Compare runs"}); err != nil {
+		t.Fatal(err)
+	}
+	page := b.String()
+	head, body, ok := strings.Cut(page, "</head>")
+	if !ok || !strings.HasPrefix(page, "<!DOCTYPE html>") || !strings.Contains(head, "<title>Compare runs - Apparatus</title>") {
+		t.Fatalf("Expected the page rendered in the layout, got %s", page)
+	}
+	if !strings.Contains(head, "chart.js") {
+		t.Errorf("Expected the page's head block in <head>, got %s", head)
+	}
+	if !strings.Contains(body, "<h2>Compare runs</h2>") || !strings.Contains(body, `class="search-form"`) {
+		t.Errorf("Expected the header and the page's content in <body>, got %s", body)
+	}
+}
+
+func TestFormatNumber(t *testing.T) {
+	for _, c := range []struct {
+		v        any
+		expected string
+	}{
+		{0, "0"},
+		{999, "999"},
+		{1234567, "1,234,567"},
+		{int64(-1000), "-1,000"},
+		{2.0, "2"},
+		{1e6, "1,000,000"},
+		{0.012345, "0.01235"},
+		{-3.14159, "-3.142"},
+		{1e20, "1e+20"},
+	} {
+		if got := formatNumber(c.v); got != c.expected {
+			t.Errorf("formatNumber(%v) = %q, expected %q", c.v, got, c.expected)
+		}
+	}
+	if got := formatTime(time.Time{}); got != "" {
+		t.Errorf("Expected the zero time formatted as empty, got %q", got)
+	}
+}
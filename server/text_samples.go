@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"unicode/utf8"
+)
+
+// Limits on the text samples logged under a key at one step
+const (
+	textSamplesMaxCount     = 100
+	textSampleMaxBytes      = 64 << 10
+	textSamplesMaxTotalSize = 1 << 20
+)
+
+// TextSamplesView is the text samples a run logged under a key at one step,
+// with the steps that can be stepped through
+type TextSamplesView struct {
+	RunUUID   string
+	Key       string
+	Step      float64
+	Steps     []float64
+	StepIndex int
+	Samples   []string
+}
+
+// LastStepIndex is the index of the key's last step, the end of the step slider
+func (v TextSamplesView) LastStepIndex() int {
+	return len(v.Steps) - 1
+}
+
+// validateTextSamples checks that text samples are valid UTF-8 within the size
+// limits
+func validateTextSamples(samples []string) error {
+	if len(samples) == 0 {
+		return fmt.Errorf("at least one sample is required")
+	}
+	if len(samples) > textSamplesMaxCount {
+		return fmt.Errorf("%d samples were logged, at most %d are supported per step", len(samples), textSamplesMaxCount)
+	}
+	total := 0
+	for i, s := range samples {
+		if len(s) > textSampleMaxBytes {
+			return fmt.Errorf("sample %d is %s, at most %s are supported", i, formatBytes(int64(len(s))), formatBytes(textSampleMaxBytes))
+		}
+		if !utf8.ValidString(s) {
+			return fmt.Errorf("sample %d is not valid UTF-8", i)
+		}
+		total += len(s)
+	}
+	if total > textSamplesMaxTotalSize {
+		return fmt.Errorf("samples total %s, at most %s are supported per step", formatBytes(int64(total)), formatBytes(textSamplesMaxTotalSize))
+	}
+	return nil
+}
+
+// textSampleSteps groups the steps of a run's text samples by key, in key
+// order. rows must be ordered by key and step.
+func textSampleSteps(rows []TextSamplesRow) (keys []string, steps map[string][]float64) {
+	steps = make(map[string][]float64)
+	for _, row := range rows {
+		if _, ok := steps[row.Key]; !ok {
+			keys = append(keys, row.Key)
+		}
+		steps[row.Key] = append(steps[row.Key], row.Step)
+	}
+	return keys, steps
+}
+
+// buildTextSamplesView decodes the samples logged at one of a key's steps
+func buildTextSamplesView(runUUID string, row TextSamplesRow, steps []float64) (TextSamplesView, error) {
+	view := TextSamplesView{RunUUID: runUUID, Key: row.Key, Step: row.Step, Steps: steps}
+	for i, step := range steps {
+		if step == row.Step {
+			view.StepIndex = i
+		}
+	}
+	if err := json.Unmarshal([]byte(row.Samples), &view.Samples); err != nil {
+		return view, fmt.Errorf("decoding text samples %q: %w", row.Key, err)
+	}
+	return view, nil
+}
+
+// getRunTextSamples loads the latest text samples a run logged under each key
+func getRunTextSamples(ctx context.Context, runID int, runUUID string) ([]TextSamplesView, error) {
+	rows, err := dao.GetTextSampleStepsByRunID(ctx, runID)
+	if err != nil {
+		return nil, err
+	}
+
+	keys, steps := textSampleSteps(rows)
+	var views []TextSamplesView
+	for _, key := range keys {
+		view, err := getRunTextSamplesAt(ctx, runID, runUUID, key, steps[key][len(steps[key])-1], steps[key])
+		if err != nil {
+			return nil, err
+		}
+		if view != nil {
+			views = append(views, *view)
+		}
+	}
+	return views, nil
+}
+
+// getRunTextSamplesAt loads the text samples a run logged under key at step,
+// or nil if there are none. steps are the key's steps, or nil to look them up.
+func getRunTextSamplesAt(ctx context.Context, runID int, runUUID, key string, step float64, steps []float64) (*TextSamplesView, error) {
+	if steps == nil {
+		rows, err := dao.GetTextSampleStepsByRunID(ctx, runID)
+		if err != nil {
+			return nil, err
+		}
+		_, byKey := textSampleSteps(rows)
+		steps = byKey[key]
+	}
+
+	row, err := dao.GetTextSamples(ctx, runID, key, step)
+	if err != nil || row == nil {
+		return nil, err
+	}
+	view, err := buildTextSamplesView(runUUID, *row, steps)
+	if err != nil {
+		return nil, err
+	}
+	return &view, nil
+}
+
+// textSamplesTemplates are the templates of the text samples tab of the run page
+var textSamplesTemplates = registerTemplates("templates/run_text_samples.html")
+
+// handleRunTextSamples renders the text samples fragment for one key and
+// step, for stepping through samples on the run page
+func handleRunTextSamples(w http.ResponseWriter, r *http.Request, runUUID string) {
+	ctx := r.Context()
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	runID, err := dao.GetRunIDByUUID(ctx, runUUID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "Run not found")
+		return
+	}
+	step, err := strconv.ParseFloat(r.URL.Query().Get("step"), 64)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "Invalid step")
+		return
+	}
+
+	view, err := getRunTextSamplesAt(ctx, runID, runUUID, r.URL.Query().Get("key"), step, nil)
+	if err != nil {
+		log.Printf("Failed to load text samples for run %s: %v", runUUID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if view == nil {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "Text samples not found")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	tmpl, err := textSamplesTemplates.Get()
+	if err != nil {
+		log.Printf("Failed to parse template: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if err := tmpl.ExecuteTemplate(w, "text_samples", view); err != nil {
+		log.Printf("Failed to execute template: %v", err)
+	}
+}
+
+// LogTextSamplesRequest is the body of POST /api/text_samples
+type LogTextSamplesRequest struct {
+	RunUUID string   `json:"run_uuid"`
+	Key     string   `json:"key"`
+	Samples []string `json:"samples"`
+	Step    *float64 `json:"step,omitempty"`
+}
+
+func handleAPILogTextSamples(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req LogTextSamplesRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid JSON"})
+		return
+	}
+
+	var missing []string
+	if req.RunUUID == "" {
+		missing = append(missing, "run_uuid")
+	}
+	if req.Key == "" {
+		missing = append(missing, "key")
+	}
+	if req.Samples == nil {
+		missing = append(missing, "samples")
+	}
+	if len(missing) > 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":          "Missing required fields",
+			"missing_fields": missing,
+		})
+		return
+	}
+
+	if err := validateTextSamples(req.Samples); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": fmt.Sprintf("Invalid text samples: %v", err)})
+		return
+	}
+
+	runID, err := dao.GetRunIDByUUID(ctx, req.RunUUID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Run not found"})
+		return
+	}
+
+	encoded, err := json.Marshal(req.Samples)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to encode text samples"})
+		return
+	}
+	row := TextSamplesRow{RunID: runID, Key: req.Key, Samples: string(encoded)}
+	if req.Step != nil {
+		row.Step = *req.Step
+	}
+	if err := dao.UpsertTextSamples(ctx, row); err != nil {
+		log.Printf("Error saving text samples: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to save text samples"})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateTextSamples(t *testing.T) {
+	if err := validateTextSamples([]string{"The quick brown fox", "", "naïve café"}); err != nil {
+		t.Errorf("Expected valid samples, got %v", err)
+	}
+
+	tooMany := make([]string, textSamplesMaxCount+1)
+	tooLarge := make([]string, textSamplesMaxCount)
+	for i := range tooLarge {
+		tooLarge[i] = strings.Repeat("x", textSampleMaxBytes)
+	}
+	tests := []struct {
+		name    string
+		samples []string
+	}{
+		{"empty", []string{}},
+		{"too many", tooMany},
+		{"sample too long", []string{strings.Repeat("x", textSampleMaxBytes+1)}},
+		{"total too large", tooLarge},
+		{"invalid UTF-8", []string{"ok", "\xff\xfe"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateTextSamples(tt.samples); err == nil {
+				t.Errorf("Expected an error")
+			}
+		})
+	}
+}
+
+func TestTextSampleSteps(t *testing.T) {
+	keys, steps := textSampleSteps([]TextSamplesRow{
+		{Key: "a", Step: 0},
+		{Key: "a", Step: 100},
+		{Key: "b", Step: 50},
+	})
+	if len(keys) != 2 || keys[0] != "a" || keys[1] != "b" {
+		t.Errorf("Unexpected keys %v", keys)
+	}
+	if len(steps["a"]) != 2 || steps["a"][1] != 100 || len(steps["b"]) != 1 {
+		t.Errorf("Unexpected steps %v", steps)
+	}
+}
+
+func TestBuildTextSamplesView(t *testing.T) {
+	row := TextSamplesRow{Key: "gen", Step: 200, Samples: `["hello", "world"]`}
+	view, err := buildTextSamplesView("run-uuid", row, []float64{0, 100, 200, 300})
+	if err != nil {
+		t.Fatalf("buildTextSamplesView failed: %v", err)
+	}
+	if view.StepIndex != 2 || view.LastStepIndex() != 3 {
+		t.Errorf("Expected step index 2 of 3, got %d of %d", view.StepIndex, view.LastStepIndex())
+	}
+	if len(view.Samples) != 2 || view.Samples[1] != "world" {
+		t.Errorf("Unexpected samples %v", view.Samples)
+	}
+
+	if _, err := buildTextSamplesView("run-uuid", TextSamplesRow{Samples: "not json"}, nil); err == nil {
+		t.Errorf("Expected an error for undecodable samples")
+	}
+}
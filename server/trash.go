@@ -0,0 +1,300 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The trash lists deleted runs until they are purged, at /trash, where an
+// admin may restore them. A run and the child runs deleted with it are one
+// entry, and are restored together. Runs can no longer be restored once they
+// are due to be purged, so with -purge-deleted-runs-after-days=0 the trash
+// only holds runs whose purge failed, until it is retried.
+
+// errRunNotInTrash is returned for a run that is not in the trash, or that
+// the request's project may not see
+var errRunNotInTrash = errors.New("run not found in the trash")
+
+// trashRestoreError is a run in the trash that cannot be restored, with the
+// reason for the client
+type trashRestoreError struct {
+	message string
+}
+
+func (e *trashRestoreError) Error() string {
+	return e.message
+}
+
+// TrashEntry is a deleted run with the child runs deleted with it
+type TrashEntry struct {
+	DeletedRunRow
+	// ChildRuns are the run's descendants deleted with it
+	ChildRuns []DeletedRunRow
+	PurgeAt   time.Time
+	// ParentDeleted is whether the run's parent is in the trash too, having
+	// been deleted separately, and must be restored first
+	ParentDeleted bool
+	PurgeDue      bool
+}
+
+// Restorable reports whether the entry can be restored
+func (e TrashEntry) Restorable() bool {
+	return !e.ParentDeleted && !e.PurgeDue
+}
+
+// deletedWith reports whether a deleted run was deleted along with its
+// deleted parent, rather than separately
+func deletedWith(run, parent DeletedRunRow) bool {
+	return run.DeletedAt.Equal(parent.DeletedAt)
+}
+
+// trashEntries groups deleted runs into the trash's entries, the most
+// recently deleted first, keeping those of a project, or of every project if
+// projectID is 0
+func trashEntries(runs []DeletedRunRow, projectID int, now time.Time) []TrashEntry {
+	byID := make(map[int]DeletedRunRow, len(runs))
+	for _, run := range runs {
+		byID[run.ID] = run
+	}
+	children := make(map[int][]DeletedRunRow)
+	var entries []TrashEntry
+	for _, run := range runs {
+		parent, parentDeleted := byID[int(run.ParentRunID.Int64)]
+		if run.ParentRunID.Valid && parentDeleted && deletedWith(run, parent) {
+			children[parent.ID] = append(children[parent.ID], run)
+			continue
+		}
+		if projectID != 0 && run.ProjectID != projectID {
+			continue
+		}
+		entries = append(entries, TrashEntry{
+			DeletedRunRow: run,
+			PurgeAt:       runPurgeAt(run),
+			ParentDeleted: run.ParentRunID.Valid && parentDeleted,
+			PurgeDue:      runPurgeDue(run, now),
+		})
+	}
+	for i := range entries {
+		for next := children[entries[i].ID]; len(next) > 0; {
+			child := next[0]
+			next = append(next[1:], children[child.ID]...)
+			entries[i].ChildRuns = append(entries[i].ChildRuns, child)
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].DeletedAt.After(entries[j].DeletedAt) })
+	return entries
+}
+
+// getTrash loads the trash's entries of a project, or of every project if
+// projectID is 0
+func getTrash(ctx context.Context, projectID int) ([]TrashEntry, error) {
+	runs, err := dao.GetDeletedRuns(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return trashEntries(runs, projectID, trashPurgeNow()), nil
+}
+
+// restoreRun takes a run and the child runs deleted with it out of the trash,
+// returning how many runs were restored. A project-scoped request passes
+// its projectID, and may only restore that project's runs.
+func restoreRun(ctx context.Context, runUUID string, projectID int) (int, error) {
+	entries, err := getTrash(ctx, projectID)
+	if err != nil {
+		return 0, err
+	}
+	i := slices.IndexFunc(entries, func(e TrashEntry) bool { return e.UUID == runUUID })
+	if i < 0 {
+		return 0, errRunNotInTrash
+	}
+	entry := entries[i]
+	if entry.ParentDeleted {
+		return 0, &trashRestoreError{"Its parent run is in the trash too; restore the parent first"}
+	}
+	if entry.PurgeDue {
+		return 0, &trashRestoreError{"It is due to be purged, and can no longer be restored"}
+	}
+
+	// The run goes first, so that its child runs never show without it
+	for _, run := range append([]DeletedRunRow{entry.DeletedRunRow}, entry.ChildRuns...) {
+		if err := dao.RestoreRun(ctx, run.ID); err != nil {
+			return 0, fmt.Errorf("restoring run %s: %w", run.UUID, err)
+		}
+	}
+	restored := 1 + len(entry.ChildRuns)
+	if err := recordAudit(ctx, "restore_run", runUUID, map[string]interface{}{"runs": restored}); err != nil {
+		log.Printf("Failed to record restoring run %s in the audit log: %v", runUUID, err)
+	}
+	return restored, nil
+}
+
+// trashTemplates are the templates of the trash page
+var trashTemplates = registerPage("templates/trash.html")
+
+// handleTrash serves the trash page at /trash, of the project the web UI
+// shows. POST to /trash/{uuid}/restore restores a run, then shows it.
+func handleTrash(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/trash"), "/")
+	projectID := selectedProjectID(r)
+
+	var formError string
+	switch {
+	case path == "" && (r.Method == http.MethodGet || r.Method == http.MethodHead):
+	case r.Method == http.MethodPost && strings.HasSuffix(path, "/restore"):
+		runUUID := strings.TrimSuffix(path, "/restore")
+		_, err := restoreRun(ctx, runUUID, projectID)
+		var restoreErr *trashRestoreError
+		switch {
+		case err == nil:
+			http.Redirect(w, r, "/runs/"+runUUID, http.StatusSeeOther)
+			return nil
+		case errors.Is(err, errRunNotInTrash):
+			formError = "That run is no longer in the trash"
+		case errors.As(err, &restoreErr):
+			formError = restoreErr.message
+		default:
+			return err
+		}
+	case r.Method == http.MethodGet || r.Method == http.MethodPost:
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "Not found")
+		return nil
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return nil
+	}
+
+	entries, err := getTrash(ctx, projectID)
+	if err != nil {
+		return fmt.Errorf("failed to list deleted runs: %w", err)
+	}
+	data := struct {
+		Title          string
+		Entries        []TrashEntry
+		PurgeAfterDays int
+		FormError      string
+	}{
+		Title:          "Trash",
+		Entries:        entries,
+		PurgeAfterDays: trashPurgeAfterDays,
+		FormError:      formError,
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	tmpl, err := trashTemplates.Get()
+	if err != nil {
+		return fmt.Errorf("failed to parse template: %w", err)
+	}
+	return executeTemplate(w, tmpl, "trash.html", data)
+}
+
+// TrashedRun is a run in the trash as GET /api/trash lists it
+type TrashedRun struct {
+	UUID           string    `json:"uuid"`
+	Name           string    `json:"name"`
+	ExperimentUUID string    `json:"experiment_uuid,omitempty"`
+	DeletedAt      time.Time `json:"deleted_at"`
+	PurgeAt        time.Time `json:"purge_at"`
+	ChildRunUUIDs  []string  `json:"child_run_uuids"`
+	Restorable     bool      `json:"restorable"`
+}
+
+// handleAPITrash lists the runs in the trash at GET /api/trash, optionally of
+// the project named by the project query parameter
+func handleAPITrash(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+
+	projectID, err := apiProjectID(r)
+	if writeProjectError(w, err) {
+		return
+	}
+	entries, err := getTrash(ctx, projectID)
+	if err != nil {
+		log.Printf("Failed to list deleted runs: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to list deleted runs"})
+		return
+	}
+	runs := make([]TrashedRun, len(entries))
+	for i, e := range entries {
+		runs[i] = TrashedRun{
+			UUID:           e.UUID,
+			Name:           e.Name,
+			ExperimentUUID: e.ExperimentUUID,
+			DeletedAt:      e.DeletedAt.UTC(),
+			PurgeAt:        e.PurgeAt.UTC(),
+			ChildRunUUIDs:  []string{},
+			Restorable:     e.Restorable(),
+		}
+		for _, child := range e.ChildRuns {
+			runs[i].ChildRunUUIDs = append(runs[i].ChildRunUUIDs, child.UUID)
+		}
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"purge_after_days": trashPurgeAfterDays,
+		"runs":             runs,
+	})
+}
+
+// RestoreRunRequest is the body of POST /api/trash/restore
+type RestoreRunRequest struct {
+	RunUUID string `json:"run_uuid"`
+}
+
+// handleAPIRestoreRun takes a run and the child runs deleted with it out of
+// the trash, at POST /api/trash/restore with {"run_uuid"}
+func handleAPIRestoreRun(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+
+	var req RestoreRunRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid JSON"})
+		return
+	}
+	if req.RunUUID == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":          "Missing required fields",
+			"missing_fields": []string{"run_uuid"},
+		})
+		return
+	}
+
+	restored, err := restoreRun(ctx, req.RunUUID, tokenProjectID(r))
+	var restoreErr *trashRestoreError
+	switch {
+	case errors.Is(err, errRunNotInTrash):
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Run not found in the trash"})
+		return
+	case errors.As(err, &restoreErr):
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Cannot restore run: " + restoreErr.message})
+		return
+	case err != nil:
+		log.Printf("Failed to restore run %s: %v", req.RunUUID, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to restore run"})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": "ok", "restored": restored})
+}
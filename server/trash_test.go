@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// trashDAO keeps deletionDAO's tree of runs, parent with child and other, in
+// project 1, restoring them from the trash
+type trashDAO struct {
+	*deletionDAO
+	audit []AuditLogRow
+}
+
+func (d *trashDAO) GetDeletedRuns(ctx context.Context) ([]DeletedRunRow, error) {
+	runs, err := d.deletionDAO.GetDeletedRuns(ctx)
+	for i := range runs {
+		runs[i].ProjectID = 1
+		for parentID, children := range d.children {
+			if slices.Contains(children, runs[i].UUID) {
+				runs[i].ParentRunID = sql.NullInt64{Int64: int64(parentID), Valid: true}
+			}
+		}
+	}
+	return runs, err
+}
+
+func (d *trashDAO) RestoreRun(ctx context.Context, runID int) error {
+	delete(d.deleted, runID)
+	delete(d.deletions, runID)
+	return nil
+}
+
+func (d *trashDAO) InsertAuditLogEntry(ctx context.Context, e AuditLogRow) error {
+	d.audit = append(d.audit, e)
+	return nil
+}
+
+func TestTrashEntries(t *testing.T) {
+	defer func(days int) { trashPurgeAfterDays = days }(trashPurgeAfterDays)
+	trashPurgeAfterDays = 30
+	deletedAt := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	parent := func(id int) sql.NullInt64 { return sql.NullInt64{Int64: int64(id), Valid: true} }
+	runs := []DeletedRunRow{
+		{ID: 1, UUID: "sweep", ProjectID: 1, DeletedAt: deletedAt},
+		{ID: 2, UUID: "trial", ParentRunID: parent(1), ProjectID: 1, DeletedAt: deletedAt},
+		{ID: 3, UUID: "epoch", ParentRunID: parent(2), ProjectID: 1, DeletedAt: deletedAt},
+		// Deleted before its parent was
+		{ID: 4, UUID: "early", ParentRunID: parent(1), ProjectID: 1, DeletedAt: deletedAt.Add(-time.Hour)},
+		{ID: 5, UUID: "later", ProjectID: 2, DeletedAt: deletedAt.Add(time.Hour)},
+	}
+
+	entries := trashEntries(runs, 0, deletedAt.AddDate(0, 0, 1))
+	if len(entries) != 3 || entries[0].UUID != "later" || entries[1].UUID != "sweep" || entries[2].UUID != "early" {
+		t.Fatalf("Expected 3 entries, the most recently deleted first, got %+v", entries)
+	}
+	sweep := entries[1]
+	if len(sweep.ChildRuns) != 2 || sweep.ChildRuns[0].UUID != "trial" || sweep.ChildRuns[1].UUID != "epoch" || !sweep.Restorable() {
+		t.Errorf("Expected the sweep restorable with its descendants deleted with it, got %+v", sweep)
+	}
+	if !sweep.PurgeAt.Equal(deletedAt.AddDate(0, 0, 30)) {
+		t.Errorf("Expected the sweep purged 30 days after it was deleted, got %v", sweep.PurgeAt)
+	}
+	if early := entries[2]; !early.ParentDeleted || early.Restorable() {
+		t.Errorf("Expected a run deleted before its parent not restorable while the parent is deleted, got %+v", early)
+	}
+
+	if entries := trashEntries(runs, 2, deletedAt); len(entries) != 1 || entries[0].UUID != "later" {
+		t.Errorf("Expected only project 2's run, got %+v", entries)
+	}
+	if entries := trashEntries(runs, 0, deletedAt.AddDate(0, 0, 31)); entries[1].Restorable() || !entries[1].PurgeDue {
+		t.Errorf("Expected a run due to be purged not restorable, got %+v", entries[1])
+	}
+}
+
+func TestHandleAPIRestoreRun(t *testing.T) {
+	defer func(d DAO) { dao = d }(dao)
+	defer func(s ArtifactStore) { artifactStore = s }(artifactStore)
+	defer func(days int) { trashPurgeAfterDays = days }(trashPurgeAfterDays)
+	store, err := newFileArtifactStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	artifactStore = store
+	trashPurgeAfterDays = 30
+
+	deletion := newDeletionDAO()
+	deletion.deletions = map[int]DeletedRunRow{}
+	d := &trashDAO{deletionDAO: deletion}
+	dao = d
+	if err := deleteRun(t.Context(), 1, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	list := func() map[string]interface{} {
+		w := httptest.NewRecorder()
+		handleAPITrash(w, httptest.NewRequest(http.MethodGet, "/api/trash", nil))
+		var resp map[string]interface{}
+		json.NewDecoder(w.Body).Decode(&resp)
+		return resp
+	}
+	restore := func(body string) int {
+		w := httptest.NewRecorder()
+		handleAPIRestoreRun(w, httptest.NewRequest(http.MethodPost, "/api/trash/restore", strings.NewReader(body)))
+		return w.Code
+	}
+
+	resp := list()
+	runs, _ := resp["runs"].([]interface{})
+	if resp["purge_after_days"] != 30.0 || len(runs) != 1 {
+		t.Fatalf("Expected the parent run in the trash, got %v", resp)
+	}
+	if run := runs[0].(map[string]interface{}); run["uuid"] != "parent" || run["restorable"] != true || len(run["child_run_uuids"].([]interface{})) != 1 {
+		t.Errorf("Expected the parent run restorable with its child, got %v", run)
+	}
+
+	if code := restore(`{"run_uuid": "child"}`); code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a child run deleted with its parent, got %d", code)
+	}
+	if code := restore(`{}`); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 without a run, got %d", code)
+	}
+	if code := restore(`{"run_uuid": "parent"}`); code != http.StatusOK {
+		t.Fatalf("Expected the parent run restored, got %d", code)
+	}
+	if len(d.deleted) != 0 || len(d.purged) != 0 {
+		t.Errorf("Expected the parent and child restored, got deleted %v, purged %v", d.deleted, d.purged)
+	}
+	if len(d.audit) != 1 || d.audit[0].Action != "restore_run" || d.audit[0].Subject != "parent" {
+		t.Errorf("Expected the restore in the audit log, got %+v", d.audit)
+	}
+	if code := restore(`{"run_uuid": "parent"}`); code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a run no longer in the trash, got %d", code)
+	}
+
+	// Without a grace period, runs whose purge failed cannot be restored
+	trashPurgeAfterDays = 0
+	deletion.deleted[3] = true
+	if code := restore(`{"run_uuid": "other"}`); code != http.StatusConflict || !deletion.deleted[3] {
+		t.Errorf("Expected 409 for a run due to be purged, got %d", code)
+	}
+}
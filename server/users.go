@@ -0,0 +1,176 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// UserSummary is a user in display form, with the tokens issued to them
+type UserSummary struct {
+	UserRow
+	Tokens []APITokenRow
+}
+
+// adminUsersTemplates are the templates of the user management page
+var adminUsersTemplates = registerPage("templates/admin_users.html")
+
+// handleAdminUsers serves the user management page at /admin/users. POST
+// adds a user, and POST to {id}/role, {id}/delete, and {id}/tokens changes a
+// user's role, deletes them, and issues them a token, which is shown once.
+// POST to tokens/revoke revokes a token by name.
+func handleAdminUsers(w http.ResponseWriter, r *http.Request) error {
+	ctx := r.Context()
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return nil
+	}
+	action := strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin/users"), "/")
+	if r.Method == http.MethodGet && action != "" {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "Not found")
+		return nil
+	}
+
+	var formError, newToken string
+	if r.Method == http.MethodPost {
+		var err error
+		newToken, formError, err = adminUsersAction(r, action)
+		if err != nil {
+			return err
+		}
+		if formError == "" && newToken == "" {
+			http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+			return nil
+		}
+	}
+
+	users, err := dao.GetAllUsers(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list users: %w", err)
+	}
+	tokens, err := dao.GetAllAPITokens(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list tokens: %w", err)
+	}
+	summaries := make([]UserSummary, len(users))
+	for i, u := range users {
+		summaries[i].UserRow = u
+		for _, t := range tokens {
+			if t.UserID.Valid && int(t.UserID.Int64) == u.ID && !t.RevokedAt.Valid {
+				summaries[i].Tokens = append(summaries[i].Tokens, t)
+			}
+		}
+	}
+
+	data := struct {
+		Title     string
+		Users     []UserSummary
+		Roles     []role
+		NewToken  string
+		FormError string
+	}{
+		Title:     "Users",
+		Users:     summaries,
+		Roles:     roles,
+		NewToken:  newToken,
+		FormError: formError,
+	}
+	tmpl, err := adminUsersTemplates.Get()
+	if err != nil {
+		return fmt.Errorf("failed to parse template: %w", err)
+	}
+	if formError != "" {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+	return executeTemplate(w, tmpl, "admin_users.html", data)
+}
+
+// adminUsersAction carries out a change posted to the user management page,
+// returning the token it issued, if any, or a message for the form if the
+// change was refused
+func adminUsersAction(r *http.Request, action string) (newToken, formError string, err error) {
+	ctx := r.Context()
+	signedIn, _ := requestPrincipal(r)
+	if action == "" {
+		name := strings.TrimSpace(r.FormValue("name"))
+		userRole, ok := parseRole(r.FormValue("role"))
+		if name == "" || !ok {
+			return "", "A user needs a name and a role", nil
+		}
+		if existing, err := dao.GetUserByName(ctx, name); err != nil {
+			return "", "", fmt.Errorf("failed to find user %s: %w", name, err)
+		} else if existing != nil {
+			return "", fmt.Sprintf("There is already a user named %s", name), nil
+		}
+		if err := dao.InsertUser(ctx, name, userRole.String()); err != nil {
+			return "", "", fmt.Errorf("failed to add user %s: %w", name, err)
+		}
+		recordAudit(ctx, "add_user", name, map[string]interface{}{"role": userRole.String(), "by": signedIn.User})
+		return "", "", nil
+	}
+	if action == "tokens/revoke" {
+		name := r.FormValue("token")
+		if _, err := dao.RevokeAPIToken(ctx, name); err != nil {
+			return "", "", fmt.Errorf("failed to revoke token %s: %w", name, err)
+		}
+		recordAudit(ctx, "revoke_token", name, map[string]interface{}{"by": signedIn.User})
+		return "", "", nil
+	}
+
+	idString, verb, _ := strings.Cut(action, "/")
+	id, err := strconv.Atoi(idString)
+	if err != nil {
+		return "", "Invalid user id", nil
+	}
+	user, err := dao.GetUserByID(ctx, id)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to find user %d: %w", id, err)
+	}
+	if user == nil {
+		return "", "No such user", nil
+	}
+	// Admins cannot lock themselves out
+	if user.Name == signedIn.User && verb != "tokens" {
+		return "", "You cannot change your own role or delete yourself", nil
+	}
+
+	switch verb {
+	case "role":
+		userRole, ok := parseRole(r.FormValue("role"))
+		if !ok {
+			return "", "Unknown role", nil
+		}
+		if err := dao.SetUserRole(ctx, id, userRole.String()); err != nil {
+			return "", "", fmt.Errorf("failed to change the role of %s: %w", user.Name, err)
+		}
+		recordAudit(ctx, "set_user_role", user.Name, map[string]interface{}{"role": userRole.String(), "by": signedIn.User})
+	case "delete":
+		if err := dao.DeleteUser(ctx, id); err != nil {
+			return "", "", fmt.Errorf("failed to delete user %s: %w", user.Name, err)
+		}
+		recordAudit(ctx, "delete_user", user.Name, map[string]interface{}{"by": signedIn.User})
+	case "tokens":
+		name := strings.TrimSpace(r.FormValue("token"))
+		if name == "" {
+			return "", "A token needs a name, e.g. the machine it is for", nil
+		}
+		token, err := newAPIToken()
+		if err != nil {
+			return "", "", fmt.Errorf("failed to generate token: %w", err)
+		}
+		userID := sql.NullInt64{Int64: int64(id), Valid: true}
+		if err := dao.InsertAPIToken(ctx, name, hashAPIToken(token), sql.NullInt64{}, userID); err != nil {
+			log.Printf("Failed to save token %q: %v", name, err)
+			return "", fmt.Sprintf("Could not save a token named %s; token names cannot be reused", name), nil
+		}
+		recordAudit(ctx, "issue_token", name, map[string]interface{}{"user": user.Name, "by": signedIn.User})
+		return token, "", nil
+	default:
+		return "", "Unknown action", nil
+	}
+	return "", "", nil
+}
@@ -0,0 +1,378 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+// The verify command cross-checks the database against the artifact store
+// and the GPU summaries against the metric points they summarize, e.g. after
+// restoring either from a backup or a storage incident. It changes nothing;
+// it lists what is wrong along with the repair each finding calls for.
+
+// Kinds of verify findings
+const (
+	verifyMissingBlob       = "missing_blob"
+	verifySizeMismatch      = "size_mismatch"
+	verifyChecksumMismatch  = "checksum_mismatch"
+	verifyOutsideStore      = "outside_store"
+	verifyOrphanBlob        = "orphan_blob"
+	verifyGPUSummaryMissing = "gpu_summary_missing"
+	verifyGPUSummaryStale   = "gpu_summary_stale"
+	verifyGPUSummaryOrphan  = "gpu_summary_orphan"
+)
+
+// Repairs verify plans
+const (
+	verifyRepairCopyFromMirror   = "copy_from_mirror"
+	verifyRepairReupload         = "reupload_or_delete"
+	verifyRepairArtifactGC       = "artifact_gc"
+	verifyRepairRecomputeSummary = "recompute_gpu_summary"
+	verifyRepairDeleteSummary    = "delete_gpu_summary"
+	verifyRepairNone             = "none"
+)
+
+// verifySummaryTolerance is the relative difference below which a stored GPU
+// summary value matches the one recomputed from its run's metric points
+const verifySummaryTolerance = 1e-6
+
+// VerifyFinding is a discrepancy verify found, with the repair it calls for
+type VerifyFinding struct {
+	Kind    string `json:"kind"`
+	RunUUID string `json:"run_uuid,omitempty"`
+	Path    string `json:"path,omitempty"`
+	Version int    `json:"version,omitempty"`
+	Key     string `json:"key,omitempty"`
+	Detail  string `json:"detail"`
+	Repair  string `json:"repair"`
+}
+
+// VerifyReport is what verify checked and found
+type VerifyReport struct {
+	CheckedAt time.Time       `json:"checked_at"`
+	Runs      int             `json:"runs"`
+	Artifacts int             `json:"artifacts"`
+	Bytes     int64           `json:"bytes"`
+	Checksums bool            `json:"checksums"`
+	Findings  []VerifyFinding `json:"findings"`
+	// Repairs counts the findings by the repair they call for
+	Repairs map[string]int `json:"repairs"`
+}
+
+// verifyOptions are the checks verify carries out
+type verifyOptions struct {
+	// Checksums reads every artifact to compare its digest, rather than only
+	// its size
+	Checksums bool
+}
+
+// verify cross-checks every run's artifacts and GPU summary, and the
+// artifact store's files against the artifacts referring to them
+func verify(ctx context.Context, opts verifyOptions) (*VerifyReport, error) {
+	report := &VerifyReport{CheckedAt: time.Now().UTC(), Checksums: opts.Checksums, Findings: []VerifyFinding{}, Repairs: map[string]int{}}
+	runs, err := dao.GetAllRuns(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("listing runs: %w", err)
+	}
+	for _, run := range runs {
+		runID, err := dao.GetRunIDByUUID(ctx, run.UUID)
+		if err != nil {
+			return nil, fmt.Errorf("looking up run %s: %w", run.UUID, err)
+		}
+		if err := verifyRunArtifacts(ctx, report, runID, run.UUID, opts); err != nil {
+			return nil, fmt.Errorf("verifying artifacts of run %s: %w", run.UUID, err)
+		}
+		if err := verifyRunGPUSummary(ctx, report, runID, run.UUID); err != nil {
+			return nil, fmt.Errorf("verifying GPU summary of run %s: %w", run.UUID, err)
+		}
+		report.Runs++
+	}
+
+	// Files no artifact refers to are what the artifact-gc housekeeping job
+	// deletes, so its plan lists them
+	orphans, held, err := planArtifactGC(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("listing unreferenced files: %w", err)
+	}
+	for _, item := range append(orphans, held...) {
+		finding := VerifyFinding{Kind: verifyOrphanBlob, Key: item.Key, Detail: fmt.Sprintf("%s not referred to by any artifact", formatBytes(item.Bytes)), Repair: verifyRepairArtifactGC}
+		if item.Reason != "" {
+			finding.Detail += "; " + item.Reason
+		}
+		report.add(finding)
+	}
+	return report, nil
+}
+
+func (report *VerifyReport) add(finding VerifyFinding) {
+	report.Findings = append(report.Findings, finding)
+	report.Repairs[finding.Repair]++
+}
+
+// verifyRunArtifacts checks that the contents of every version of a run's
+// artifacts are in the store, with the size and digest recorded on upload
+func verifyRunArtifacts(ctx context.Context, report *VerifyReport, runID int, runUUID string, opts verifyOptions) error {
+	artifacts, err := dao.GetArtifactsByRunID(ctx, runID)
+	if err != nil {
+		return err
+	}
+	for _, current := range artifacts {
+		versions, err := dao.GetArtifactVersions(ctx, runID, current.Path)
+		if err != nil {
+			return err
+		}
+		for _, a := range append([]ArtifactRow{current}, versions...) {
+			report.Artifacts++
+			report.Bytes += a.SizeBytes
+			finding, err := verifyArtifact(a, opts)
+			if err != nil {
+				return err
+			}
+			if finding != nil {
+				finding.RunUUID = runUUID
+				report.add(*finding)
+			}
+		}
+	}
+	return nil
+}
+
+// verifyArtifact checks one version of an artifact, returning what is wrong
+// with it, if anything
+func verifyArtifact(a ArtifactRow, opts verifyOptions) (*VerifyFinding, error) {
+	finding := &VerifyFinding{Path: a.Path, Version: a.Version}
+	key, err := artifactKey(a.URI)
+	if errors.Is(err, errArtifactURIOutsideStore) {
+		finding.Kind, finding.Key, finding.Repair = verifyOutsideStore, a.URI, verifyRepairNone
+		finding.Detail = "recorded outside the artifact store, so it cannot be checked"
+		return finding, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	finding.Key = key
+
+	size, digest, err := verifyContents(artifactStore, key, opts.Checksums)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		finding.Kind, finding.Detail = verifyMissingBlob, "not in the artifact store"
+	case err != nil:
+		return nil, err
+	case size != a.SizeBytes:
+		finding.Kind, finding.Detail = verifySizeMismatch, fmt.Sprintf("%d bytes in the store, %d recorded", size, a.SizeBytes)
+	case digest != "" && a.SHA256 != "" && digest != a.SHA256:
+		finding.Kind, finding.Detail = verifyChecksumMismatch, fmt.Sprintf("SHA-256 %s in the store, %s recorded", digest, a.SHA256)
+	default:
+		return nil, nil
+	}
+	finding.Repair = verifyRepairReupload
+	if verifyMirrorCopy(a, key, opts) {
+		finding.Repair = verifyRepairCopyFromMirror
+		finding.Detail += "; the mirror holds an intact copy"
+	}
+	return finding, nil
+}
+
+// verifyContents returns the size of the contents a store holds under key,
+// and their hex SHA-256 digest if checksums is set
+func verifyContents(store ArtifactStore, key string, checksums bool) (int64, string, error) {
+	if !checksums {
+		size, err := store.Size(key)
+		return size, "", err
+	}
+	file, err := store.Get(key)
+	if err != nil {
+		return 0, "", err
+	}
+	defer file.Close()
+	h := sha256.New()
+	size, err := io.Copy(h, file)
+	if err != nil {
+		return 0, "", err
+	}
+	return size, hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// verifyMirrorCopy reports whether the artifact mirror holds a copy of an
+// artifact with the recorded size and digest
+func verifyMirrorCopy(a ArtifactRow, key string, opts verifyOptions) bool {
+	if artifactMirror == nil {
+		return false
+	}
+	size, digest, err := verifyContents(artifactMirror, key, opts.Checksums)
+	if err != nil {
+		return false
+	}
+	return size == a.SizeBytes && (digest == "" || a.SHA256 == "" || digest == a.SHA256)
+}
+
+// verifyRunGPUSummary checks a run's stored GPU summary against the one its
+// metric points give
+func verifyRunGPUSummary(ctx context.Context, report *VerifyReport, runID int, runUUID string) error {
+	stored, err := dao.GetRunGPUSummary(ctx, runID)
+	if err != nil {
+		return err
+	}
+	metrics, err := dao.GetMetricsByRunID(ctx, runID)
+	if err != nil {
+		return err
+	}
+	computed := computeRunGPUSummary(metrics)
+
+	finding := VerifyFinding{RunUUID: runUUID, Repair: verifyRepairRecomputeSummary}
+	switch {
+	case stored == nil && computed == nil:
+		return nil
+	case stored == nil:
+		finding.Kind, finding.Detail = verifyGPUSummaryMissing, "GPU metrics were logged but there is no GPU summary"
+	case computed == nil:
+		finding.Kind, finding.Detail, finding.Repair = verifyGPUSummaryOrphan, "there is a GPU summary but no GPU metrics", verifyRepairDeleteSummary
+	default:
+		detail := gpuSummaryDifference(runGPUSummaryFromRow(*stored), computed)
+		if detail == "" {
+			return nil
+		}
+		finding.Kind, finding.Detail = verifyGPUSummaryStale, detail
+	}
+	report.add(finding)
+	return nil
+}
+
+// gpuSummaryDifference describes how a stored GPU summary differs from the
+// recomputed one, or returns "" if they match
+func gpuSummaryDifference(stored, computed *RunGPUSummary) string {
+	differs := func(a, b float64) bool {
+		return math.Abs(a-b) > verifySummaryTolerance*math.Max(math.Abs(a), math.Abs(b))
+	}
+	optional := func(p *float64) string {
+		if p == nil {
+			return "none"
+		}
+		return fmt.Sprintf("%g", *p)
+	}
+	if differs(stored.GPUHours, computed.GPUHours) {
+		return fmt.Sprintf("GPU hours %g stored, %g from metric points", stored.GPUHours, computed.GPUHours)
+	}
+	if (stored.UtilizationMean == nil) != (computed.UtilizationMean == nil) ||
+		(stored.UtilizationMean != nil && differs(*stored.UtilizationMean, *computed.UtilizationMean)) {
+		return fmt.Sprintf("mean utilization %s stored, %s from metric points", optional(stored.UtilizationMean), optional(computed.UtilizationMean))
+	}
+	if (stored.MemoryPeakBytes == nil) != (computed.MemoryPeakBytes == nil) ||
+		(stored.MemoryPeakBytes != nil && *stored.MemoryPeakBytes != *computed.MemoryPeakBytes) {
+		peak := func(p *int64) string {
+			if p == nil {
+				return "none"
+			}
+			return formatBytes(*p)
+		}
+		return fmt.Sprintf("peak memory %s stored, %s from metric points", peak(stored.MemoryPeakBytes), peak(computed.MemoryPeakBytes))
+	}
+	return ""
+}
+
+// verifyRepairDescriptions explain the repairs in verify's report
+var verifyRepairDescriptions = map[string]string{
+	verifyRepairCopyFromMirror:   "copy the contents from the artifact mirror to the same key in the artifact store",
+	verifyRepairReupload:         "upload the artifact again, or delete the run if its contents are lost",
+	verifyRepairArtifactGC:       "dry run and carry out the artifact-gc housekeeping job (POST /api/admin/housekeeping)",
+	verifyRepairRecomputeSummary: "recompute the summary from the run's metric points, as the server does whenever a GPU metric is logged to the run",
+	verifyRepairDeleteSummary:    "delete the run's row from run_gpu_summaries",
+	verifyRepairNone:             "nothing to repair",
+}
+
+// writeVerifyReport prints a verify report as a table of findings followed by
+// the repair plan
+func writeVerifyReport(w io.Writer, report *VerifyReport) {
+	checked := "sizes"
+	if report.Checksums {
+		checked = "sizes and checksums"
+	}
+	fmt.Fprintf(w, "Checked %d runs and %d artifacts (%s, %s)\n", report.Runs, report.Artifacts, formatBytes(report.Bytes), checked)
+	if len(report.Findings) == 0 {
+		fmt.Fprintln(w, "No problems found")
+		return
+	}
+
+	problems := "problems"
+	if len(report.Findings) == 1 {
+		problems = "problem"
+	}
+	fmt.Fprintf(w, "\n%d %s found:\n", len(report.Findings), problems)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "KIND\tRUN\tKEY\tDETAIL\tREPAIR")
+	for _, f := range report.Findings {
+		run := f.RunUUID
+		if run == "" {
+			run = "-"
+		}
+		key := f.Key
+		if key == "" {
+			key = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", f.Kind, run, key, f.Detail, f.Repair)
+	}
+	tw.Flush()
+
+	repairs := make([]string, 0, len(report.Repairs))
+	for repair := range report.Repairs {
+		repairs = append(repairs, repair)
+	}
+	sort.Strings(repairs)
+	fmt.Fprintln(w, "\nRepair plan:")
+	for _, repair := range repairs {
+		fmt.Fprintf(w, "  %s (%d): %s\n", repair, report.Repairs[repair], verifyRepairDescriptions[repair])
+	}
+}
+
+// runVerifyCommand implements "apparatus-server verify", which cross-checks
+// the database against the artifact store and exits with status 1 if it
+// finds any problems
+func runVerifyCommand(ctx context.Context, args []string) {
+	flags := flag.NewFlagSet("verify", flag.ExitOnError)
+	configPath := defineConfigFlag(flags)
+	dbConnString := flags.String("db", "sqlite:///apparatus.db", "Database connection string (e.g., sqlite:///path/to/db.db)")
+	artifactStoreURI := flags.String("artifact-store-uri", "file://artifacts", "URI for location to store artifacts (e.g. file:///path/to/artifacts or s3://bucket/prefix)")
+	artifactMirrorURI := flags.String("artifact-mirror-uri", "", "URI of the artifact mirror, to check for intact copies of damaged artifacts")
+	checksums := flags.Bool("checksums", true, "Read every artifact to compare its SHA-256 digest; with -checksums=false only sizes are compared")
+	jsonOutput := flags.Bool("json", false, "Print the report as JSON")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s verify [flags]\n\nCross-checks artifacts against the artifact store and GPU summaries against metric points, and plans repairs. Nothing is changed.\n\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if _, err := loadServerConfig(flags, *configPath); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+
+	initDB(*dbConnString)
+	initArtifactStore(*artifactStoreURI)
+	initArtifactMirror(*artifactMirrorURI)
+
+	report, err := verify(ctx, verifyOptions{Checksums: *checksums})
+	if err != nil {
+		log.Fatalf("Verify failed: %v", err)
+	}
+	if *jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+	} else {
+		writeVerifyReport(os.Stdout, report)
+	}
+	if len(report.Findings) > 0 {
+		os.Exit(1)
+	}
+}
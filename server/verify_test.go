@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+)
+
+// verifyDAO has two runs: run-1 with artifacts and GPU metrics, and run-2
+// with a GPU summary but no GPU metrics
+type verifyDAO struct {
+	DAO
+	artifacts []ArtifactRow
+	versions  []ArtifactRow
+	summary   *RunGPUSummaryRow
+	metrics   []MetricRow
+}
+
+func (d *verifyDAO) GetAllRuns(ctx context.Context) ([]Run, error) {
+	return []Run{{UUID: "run-1"}, {UUID: "run-2"}}, nil
+}
+
+func (d *verifyDAO) GetRunIDByUUID(ctx context.Context, uuid string) (int, error) {
+	switch uuid {
+	case "run-1":
+		return 1, nil
+	case "run-2":
+		return 2, nil
+	}
+	return 0, sql.ErrNoRows
+}
+
+func (d *verifyDAO) GetArtifactsByRunID(ctx context.Context, runID int) ([]ArtifactRow, error) {
+	if runID != 1 {
+		return nil, nil
+	}
+	return d.artifacts, nil
+}
+
+func (d *verifyDAO) GetArtifactVersions(ctx context.Context, runID int, path string) ([]ArtifactRow, error) {
+	var versions []ArtifactRow
+	for _, v := range d.versions {
+		if v.Path == path {
+			versions = append(versions, v)
+		}
+	}
+	return versions, nil
+}
+
+func (d *verifyDAO) GetAllArtifactURIs(ctx context.Context) ([]string, error) {
+	var uris []string
+	for _, a := range append(d.artifacts, d.versions...) {
+		uris = append(uris, a.URI)
+	}
+	return uris, nil
+}
+
+func (d *verifyDAO) GetRunHold(ctx context.Context, runID int) (*RunHoldRow, error) {
+	return nil, nil
+}
+
+func (d *verifyDAO) GetRunGPUSummary(ctx context.Context, runID int) (*RunGPUSummaryRow, error) {
+	if runID == 1 {
+		return d.summary, nil
+	}
+	return &RunGPUSummaryRow{RunID: 2, GPUHours: 1}, nil
+}
+
+func (d *verifyDAO) GetMetricsByRunID(ctx context.Context, runID int) ([]MetricRow, error) {
+	if runID != 1 {
+		return nil, nil
+	}
+	return d.metrics, nil
+}
+
+func TestVerify(t *testing.T) {
+	ctx := t.Context()
+	defer func(d DAO, s, m ArtifactStore) { dao, artifactStore, artifactMirror = d, s, m }(dao, artifactStore, artifactMirror)
+	store, err := newFileArtifactStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	mirror, err := newFileArtifactStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	artifactStore, artifactMirror = store, nil
+
+	d := &verifyDAO{}
+	for _, a := range []struct {
+		path     string
+		version  int
+		contents string
+	}{
+		{"intact.txt", 1, "intact"},
+		{"lost.txt", 1, "lost"},
+		{"resized.txt", 1, "resized"},
+		{"corrupt.txt", 1, "corrupt"},
+		{"intact.txt", 2, "intact v2"},
+	} {
+		uri, size, digest, err := storeArtifact("run-1", a.path, a.version, strings.NewReader(a.contents))
+		if err != nil {
+			t.Fatal(err)
+		}
+		mirror.Put(artifactVersionKey("run-1", a.path, a.version), strings.NewReader(a.contents))
+		row := ArtifactRow{Path: a.path, URI: uri, SizeBytes: size, SHA256: digest, Version: a.version}
+		if a.version == 1 && a.path == "intact.txt" {
+			d.versions = append(d.versions, row)
+		} else {
+			d.artifacts = append(d.artifacts, row)
+		}
+	}
+	store.Delete("run-1/lost.txt")
+	store.Put("run-1/resized.txt", strings.NewReader("resized and longer"))
+	store.Put("run-1/corrupt.txt", strings.NewReader("CORRUPT"))
+	store.Put("run-1/stray.bin", bytes.NewReader(make([]byte, 10)))
+
+	// Two minutes at 50% utilization, with the summary stored before the
+	// second minute was logged
+	start := time.Unix(1700000000, 0)
+	for i, y := range []float64{50, 50, 50} {
+		d.metrics = append(d.metrics, MetricRow{Key: gpuUtilizationMetricKey, YValue: y, LoggedAt: start.Add(time.Duration(i) * time.Minute)})
+	}
+	stale := *computeRunGPUSummary(d.metrics[:2])
+	d.summary = &RunGPUSummaryRow{RunID: 1, GPUHours: stale.GPUHours, UtilizationMean: sql.NullFloat64{Float64: 50, Valid: true}}
+	dao = d
+
+	findings := func(report *VerifyReport) map[string]string {
+		found := make(map[string]string)
+		for _, f := range report.Findings {
+			found[f.Kind+" "+f.Path+f.Key] = f.Repair
+		}
+		return found
+	}
+
+	report, err := verify(ctx, verifyOptions{Checksums: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"missing_blob lost.txtrun-1/lost.txt":            verifyRepairReupload,
+		"size_mismatch resized.txtrun-1/resized.txt":     verifyRepairReupload,
+		"checksum_mismatch corrupt.txtrun-1/corrupt.txt": verifyRepairReupload,
+		"orphan_blob run-1/stray.bin":                    verifyRepairArtifactGC,
+		"gpu_summary_stale ":                             verifyRepairRecomputeSummary,
+		"gpu_summary_orphan ":                            verifyRepairDeleteSummary,
+	}
+	got := findings(report)
+	if len(got) != len(want) {
+		t.Errorf("Expected %d findings, got %+v", len(want), report.Findings)
+	}
+	for kind, repair := range want {
+		if got[kind] != repair {
+			t.Errorf("Expected %s to be repaired with %s, got %q", kind, repair, got[kind])
+		}
+	}
+	if report.Runs != 2 || report.Artifacts != 5 || report.Repairs[verifyRepairReupload] != 3 {
+		t.Errorf("Expected 2 runs, 5 artifact versions and 3 reuploads, got %+v", report)
+	}
+
+	// Without checksums, the corrupt artifact's size still matches
+	report, err = verify(ctx, verifyOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := findings(report)["checksum_mismatch corrupt.txtrun-1/corrupt.txt"]; ok || len(report.Findings) != len(want)-1 {
+		t.Errorf("Expected only sizes compared, got %+v", report.Findings)
+	}
+
+	// Intact copies in the mirror are restored from it
+	artifactMirror = mirror
+	report, err = verify(ctx, verifyOptions{Checksums: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Repairs[verifyRepairCopyFromMirror] != 3 || report.Repairs[verifyRepairReupload] != 0 {
+		t.Errorf("Expected every damaged artifact copied from the mirror, got %+v", report.Repairs)
+	}
+
+	var out bytes.Buffer
+	writeVerifyReport(&out, report)
+	if s := out.String(); !strings.Contains(s, "6 problems found") || !strings.Contains(s, "copy_from_mirror (3)") {
+		t.Errorf("Expected the findings and repair plan, got:\n%s", s)
+	}
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A minimal server side of the WebSocket protocol (RFC 6455), enough for
+// clients streaming messages in and reading acknowledgements back. There are
+// no extensions or subprotocols.
+
+// webSocketGUID is appended to the client's key to accept a handshake
+const webSocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// WebSocket frame opcodes
+const (
+	webSocketContinuation = 0x0
+	webSocketText         = 0x1
+	webSocketBinary       = 0x2
+	webSocketClose        = 0x8
+	webSocketPing         = 0x9
+	webSocketPong         = 0xA
+)
+
+// WebSocket close status codes
+const (
+	webSocketCloseNormal        = 1000
+	webSocketCloseGoingAway     = 1001
+	webSocketCloseProtocol      = 1002
+	webSocketCloseTooBig        = 1009
+	webSocketCloseInternalError = 1011
+)
+
+// webSocketWriteTimeout bounds how long writing a frame may block on a client
+// that has stopped reading
+const webSocketWriteTimeout = 10 * time.Second
+
+// errWebSocketClosed is returned by ReadMessage once the client closes the
+// connection, and by writes once it is closed
+var errWebSocketClosed = errors.New("websocket closed")
+
+// webSocketCloseError is a protocol violation, closed with Code
+type webSocketCloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *webSocketCloseError) Error() string {
+	return fmt.Sprintf("websocket %d: %s", e.Code, e.Reason)
+}
+
+// webSocketConn is the server end of an upgraded WebSocket connection. Reads
+// must come from one goroutine; writes may come from any.
+type webSocketConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+	// maxMessage is the largest message ReadMessage accepts, in bytes
+	maxMessage int64
+
+	mu     sync.Mutex
+	closed bool
+}
+
+// isWebSocketUpgrade reports whether a request asks to upgrade to a WebSocket
+func isWebSocketUpgrade(r *http.Request) bool {
+	return headerHasToken(r.Header, "Connection", "upgrade") && headerHasToken(r.Header, "Upgrade", "websocket")
+}
+
+// headerHasToken reports whether a comma-separated header lists token
+func headerHasToken(h http.Header, name, token string) bool {
+	for _, value := range h.Values(name) {
+		for _, t := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// upgradeWebSocket completes a client's WebSocket handshake and takes over
+// its connection. If the request is not a valid handshake, it responds with
+// an error status and returns an error.
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request, maxMessage int64) (*webSocketConn, error) {
+	if r.Method != http.MethodGet || !isWebSocketUpgrade(r) {
+		w.Header().Set("Upgrade", "websocket")
+		http.Error(w, "Expected a WebSocket upgrade", http.StatusUpgradeRequired)
+		return nil, errors.New("not a websocket handshake")
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if r.Header.Get("Sec-WebSocket-Version") != "13" || key == "" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "Unsupported WebSocket version", http.StatusBadRequest)
+		return nil, errors.New("unsupported websocket version")
+	}
+
+	conn, rw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		http.Error(w, "WebSocket upgrade not supported", http.StatusInternalServerError)
+		return nil, err
+	}
+	// The server's deadlines for reading the request no longer apply
+	conn.SetDeadline(time.Time{})
+	digest := sha1.Sum([]byte(key + webSocketGUID))
+	handshake := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(digest[:]) + "\r\n\r\n"
+	if _, err := rw.WriteString(handshake); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &webSocketConn{conn: conn, r: rw.Reader, maxMessage: maxMessage}, nil
+}
+
+// ReadMessage reads the next text or binary message, answering pings along
+// the way. It returns errWebSocketClosed once the client starts closing the
+// connection, which the caller completes with Close, and a
+// *webSocketCloseError if the client breaks the protocol.
+func (c *webSocketConn) ReadMessage() ([]byte, error) {
+	var message []byte
+	started := false
+	for {
+		fin, opcode, payload, err := c.readFrame()
+		if err != nil {
+			return nil, err
+		}
+		switch opcode {
+		case webSocketPing:
+			if err := c.writeFrame(webSocketPong, payload); err != nil {
+				return nil, err
+			}
+			continue
+		case webSocketPong:
+			continue
+		case webSocketClose:
+			return nil, errWebSocketClosed
+		case webSocketText, webSocketBinary:
+			if started {
+				return nil, &webSocketCloseError{webSocketCloseProtocol, "expected a continuation frame"}
+			}
+			started = true
+		case webSocketContinuation:
+			if !started {
+				return nil, &webSocketCloseError{webSocketCloseProtocol, "unexpected continuation frame"}
+			}
+		default:
+			return nil, &webSocketCloseError{webSocketCloseProtocol, fmt.Sprintf("unknown opcode %d", opcode)}
+		}
+		if int64(len(message)+len(payload)) > c.maxMessage {
+			return nil, &webSocketCloseError{webSocketCloseTooBig, fmt.Sprintf("messages are limited to %s", formatBytes(c.maxMessage))}
+		}
+		message = append(message, payload...)
+		if fin {
+			return message, nil
+		}
+	}
+}
+
+// readFrame reads one frame, unmasking its payload
+func (c *webSocketConn) readFrame() (fin bool, opcode byte, payload []byte, err error) {
+	var header [2]byte
+	if _, err := io.ReadFull(c.r, header[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin = header[0]&0x80 != 0
+	opcode = header[0] & 0x0F
+	if header[0]&0x70 != 0 {
+		return false, 0, nil, &webSocketCloseError{webSocketCloseProtocol, "reserved bits set"}
+	}
+	if header[1]&0x80 == 0 {
+		return false, 0, nil, &webSocketCloseError{webSocketCloseProtocol, "client frames must be masked"}
+	}
+	length := int64(header[1] & 0x7F)
+	switch length {
+	case 126:
+		var n [2]byte
+		if _, err := io.ReadFull(c.r, n[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = int64(binary.BigEndian.Uint16(n[:]))
+	case 127:
+		var n [8]byte
+		if _, err := io.ReadFull(c.r, n[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = int64(binary.BigEndian.Uint64(n[:]))
+	}
+	// Control frames are small; data frames are checked against the message
+	// size before they are read
+	if opcode >= webSocketClose && (length > 125 || !fin) {
+		return false, 0, nil, &webSocketCloseError{webSocketCloseProtocol, "invalid control frame"}
+	}
+	if length < 0 || length > c.maxMessage {
+		return false, 0, nil, &webSocketCloseError{webSocketCloseTooBig, fmt.Sprintf("messages are limited to %s", formatBytes(c.maxMessage))}
+	}
+	var mask [4]byte
+	if _, err := io.ReadFull(c.r, mask[:]); err != nil {
+		return false, 0, nil, err
+	}
+	payload = make([]byte, length)
+	if _, err := io.ReadFull(c.r, payload); err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, opcode, payload, nil
+}
+
+// WriteText sends a text message
+func (c *webSocketConn) WriteText(message []byte) error {
+	return c.writeFrame(webSocketText, message)
+}
+
+// writeFrame sends an unmasked, unfragmented frame
+func (c *webSocketConn) writeFrame(opcode byte, payload []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return errWebSocketClosed
+	}
+	frame := []byte{0x80 | opcode}
+	switch n := len(payload); {
+	case n < 126:
+		frame = append(frame, byte(n))
+	case n <= 0xFFFF:
+		frame = append(frame, 126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(n))
+	default:
+		frame = append(frame, 127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(n))
+	}
+	frame = append(frame, payload...)
+	c.conn.SetWriteDeadline(time.Now().Add(webSocketWriteTimeout))
+	_, err := c.conn.Write(frame)
+	return err
+}
+
+// Close sends a close frame with a status code and reason, if the connection
+// is still open, and closes it
+func (c *webSocketConn) Close(code int, reason string) error {
+	payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+	if len(reason) > 123 {
+		reason = reason[:123]
+	}
+	c.writeFrame(webSocketClose, append(payload, reason...))
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	return c.conn.Close()
+}
@@ -16,11 +16,13 @@ export const test = base.extend({
        * @returns {Promise<string>} Run UUID
        */
       async createRun(name, parentRunUuid = null) {
-        let url = `/api/runs?name=${encodeURIComponent(name)}`;
+        const body = { name };
         if (parentRunUuid) {
-          url += `&parent_run_uuid=${encodeURIComponent(parentRunUuid)}`;
+          body.parent_run_uuid = parentRunUuid;
         }
-        const response = await apiContext.get(url);
+        const response = await apiContext.post('/api/runs', {
+          data: body
+        });
         if (!response.ok()) {
           throw new Error(`Failed to create run: ${response.status()} ${await response.text()}`);
         }
@@ -36,13 +38,15 @@ export const test = base.extend({
        * @param {string} type - Parameter type: 'string', 'int', 'float', 'bool'
        */
       async logParam(runUuid, key, value, type = 'string') {
-        const params = new URLSearchParams({
+        const body = {
           run_uuid: runUuid,
           key,
-          value: String(value),
+          value,
           type
+        };
+        const response = await apiContext.post('/api/params', {
+          data: body
         });
-        const response = await apiContext.get(`/api/params?${params}`);
         if (!response.ok()) {
           throw new Error(`Failed to log param: ${response.status()} ${await response.text()}`);
         }
@@ -117,6 +117,31 @@ def test_artifact_upload(running_server):
         Path(test_file_path).unlink()
 
 
+def test_artifact_download(running_server, tmp_path):
+    id = apparatus.create_run("run with downloads")
+    source = tmp_path / "weights.bin"
+    source.write_bytes(os.urandom(100_000))
+    apparatus.log_artifact(id, "model/weights.bin", str(source))
+
+    dest = tmp_path / "downloaded.bin"
+    apparatus.download_artifact(id, "model/weights.bin", str(dest))
+    assert dest.read_bytes() == source.read_bytes()
+
+    # A partial download resumes where it stopped
+    resumed = tmp_path / "resumed.bin"
+    Path(str(resumed) + ".part").write_bytes(source.read_bytes()[:40_000])
+    apparatus.download_artifact(id, "model/weights.bin", str(resumed))
+    assert resumed.read_bytes() == source.read_bytes()
+
+    # A corrupt partial download fails verification and is discarded
+    corrupt = tmp_path / "corrupt.bin"
+    Path(str(corrupt) + ".part").write_bytes(b"x" * 40_000)
+    with pytest.raises(RuntimeError, match="corrupt"):
+        apparatus.download_artifact(id, "model/weights.bin", str(corrupt))
+    assert not Path(str(corrupt) + ".part").exists()
+    assert not corrupt.exists()
+
+
 def test_nested_runs(running_server):
     """Test creating and viewing nested runs (parent -> child -> grandchild)."""
     # Create parent run (level 0)